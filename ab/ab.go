@@ -0,0 +1,351 @@
+// Package ab builds the dual-slot GPT layouts of embedded systems updated over the air, with the
+// partitions of the system mirrored in slots A and B and a data partition shared by both, and
+// switches the slot to boot:
+//
+//	spec := &ab.Spec{
+//		ESPSize:    64 * 1024 * 1024,
+//		Partitions: []ab.Partition{{Name: "rootfs", Role: gpt.RoleRoot, Size: 1024 * 1024 * 1024}},
+//	}
+//	table, err := spec.Partition(d)
+//	...
+//	err = ab.SetActive(table, ab.SlotB, 3)
+//	err = d.Partition(table)
+//
+// Which slot boots is recorded in the attributes of its partitions, in the bits of ChromeOS and
+// Android boot loaders: a priority, a number of tries left and whether it booted successfully.
+// Partitions with a role of the Discoverable Partitions Specification get the type of their role,
+// and those of the root and /usr roles in the slot that does not boot are marked
+// gpt.AttributeNoAuto, so that systemd mounts those of the slot that does.
+package ab
+
+import (
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+// Slot is one of the two slots
+type Slot string
+
+// slots of the layout
+const (
+	SlotA Slot = "a"
+	SlotB Slot = "b"
+)
+
+// Other returns the other slot
+func (s Slot) Other() Slot {
+	if s == SlotB {
+		return SlotA
+	}
+	return SlotB
+}
+
+const (
+	// alignment is that of the partitions, as by most partitioning tools
+	alignment = 1024 * 1024
+	// bits of the attributes of the slots, as read by ChromeOS and Android boot loaders
+	priorityShift  = 48
+	triesShift     = 52
+	successfulBit  = 1 << 56
+	slotBits       = 0xff<<priorityShift | successfulBit
+	maxPriority    = 15
+	maxTries       = 15
+	defaultData    = "data"
+	defaultESPName = "esp"
+)
+
+// Partition is a partition of the system, with a copy in each slot
+type Partition struct {
+	// Name is the name of the partition, suffixed with the slot, e.g. "rootfs_a" and "rootfs_b"
+	Name string
+	// Role is the role of the partition in the Discoverable Partitions Specification, if any,
+	// whose type it gets
+	Role gpt.Role
+	// Type is the type of the partition without role, gpt.LinuxFilesystem if not set
+	Type gpt.Type
+	// Size is the size in bytes of each copy
+	Size uint64
+	// Attributes are the attributes of both copies, besides those of the slots
+	Attributes uint64
+}
+
+// Spec describes an A/B layout: an optional EFI system partition, the partitions of the system
+// in both slots, each copy after the other, and the data partition
+type Spec struct {
+	// Architecture is that of the types of the partitions with a role, gpt.NativeArchitecture if
+	// not set
+	Architecture gpt.Architecture
+	// ESPSize is the size in bytes of the EFI system partition, none if 0
+	ESPSize uint64
+	// Partitions are the partitions of each slot
+	Partitions []Partition
+	// DataSize is the size in bytes of the data partition, the rest of the disk if 0
+	DataSize uint64
+	// DataName and DataType are the name and type of the data partition, "data" and
+	// gpt.LinuxFilesystem if not set
+	DataName string
+	DataType gpt.Type
+	// Active is the slot to boot, SlotA if not set. Its partitions are marked bootable and
+	// successful, while those of the other slot, to be updated, are not bootable.
+	Active Slot
+}
+
+// Table returns the GPT partition table of the layout on a disk of size bytes with sectors of
+// sectorSize bytes, with each partition aligned to 1MiB
+func (s *Spec) Table(size int64, sectorSize int) (*gpt.Table, error) {
+	if sectorSize <= 0 {
+		sectorSize = 512
+	}
+	arch := s.Architecture
+	if arch == "" {
+		arch = gpt.NativeArchitecture()
+	}
+	active := s.Active
+	if active == "" {
+		active = SlotA
+	}
+	if active != SlotA && active != SlotB {
+		return nil, fmt.Errorf("invalid active slot %q", active)
+	}
+	if len(s.Partitions) == 0 {
+		return nil, fmt.Errorf("layout has no partitions in its slots")
+	}
+
+	sector := uint64(sectorSize)
+	align := uint64(alignment) / sector
+	// the last sector before the backup partition entry array and header
+	last := uint64(size)/sector - 2 - 128*128/sector
+	next := align
+	var partitions []*gpt.Partition
+	add := func(p *gpt.Partition, bytes uint64) error {
+		if bytes == 0 || bytes%sector != 0 {
+			return fmt.Errorf("size %d of partition %s is not a positive multiple of %d bytes", bytes, p.Name, sector)
+		}
+		p.Start, p.End = next, next+bytes/sector-1
+		if p.End > last {
+			return fmt.Errorf("partition %s ends at sector %d, beyond the last sector %d", p.Name, p.End, last)
+		}
+		next = (p.End + align) / align * align
+		partitions = append(partitions, p)
+		return nil
+	}
+
+	if s.ESPSize > 0 {
+		if err := add(&gpt.Partition{Type: gpt.EFISystemPartition, Name: defaultESPName}, s.ESPSize); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range s.Partitions {
+		if p.Name == "" {
+			return nil, fmt.Errorf("partition without name")
+		}
+		t := p.Type
+		if p.Role != "" {
+			var err error
+			if t, err = gpt.DiscoverableType(p.Role, arch); err != nil {
+				return nil, fmt.Errorf("partition %s: %w", p.Name, err)
+			}
+		}
+		if t == "" {
+			t = gpt.LinuxFilesystem
+		}
+		for _, slot := range []Slot{SlotA, SlotB} {
+			partition := &gpt.Partition{Type: t, Name: p.Name + "_" + string(slot), Attributes: p.Attributes &^ slotBits}
+			if err := add(partition, p.Size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data := &gpt.Partition{Type: s.DataType, Name: s.DataName}
+	if data.Type == "" {
+		data.Type = gpt.LinuxFilesystem
+	}
+	if data.Name == "" {
+		data.Name = defaultData
+	}
+	dataSize := s.DataSize
+	if dataSize == 0 {
+		if next > last {
+			return nil, fmt.Errorf("no space left for partition %s", data.Name)
+		}
+		dataSize = (last - next + 1) * sector
+	}
+	if err := add(data, dataSize); err != nil {
+		return nil, err
+	}
+
+	table := &gpt.Table{
+		LogicalSectorSize:  sectorSize,
+		PhysicalSectorSize: sectorSize,
+		ProtectiveMBR:      true,
+		Partitions:         partitions,
+	}
+	setState(table, active, State{Priority: 1, Successful: true})
+	setState(table, active.Other(), State{})
+	return table, nil
+}
+
+// Partition writes the partition table of the layout to the disk, and returns it
+func (s *Spec) Partition(d *disk.Disk) (*gpt.Table, error) {
+	table, err := s.Table(d.Size, int(d.LogicalBlocksize))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Partition(table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// State is the state of a slot recorded in the attributes of its partitions
+type State struct {
+	// Priority orders the slots to boot, from 0 for a slot not to boot to 15
+	Priority int
+	// Tries is the number of times left that the slot is tried, until it boots successfully
+	Tries int
+	// Successful is whether the slot booted successfully
+	Successful bool
+}
+
+// Bootable returns whether a boot loader would boot the slot
+func (st State) Bootable() bool {
+	return st.Priority > 0 && (st.Successful || st.Tries > 0)
+}
+
+// attributes returns the bits of the state in the attributes of a partition
+func (st State) attributes() uint64 {
+	a := uint64(min(max(st.Priority, 0), maxPriority))<<priorityShift | uint64(min(max(st.Tries, 0), maxTries))<<triesShift
+	if st.Successful {
+		a |= successfulBit
+	}
+	return a
+}
+
+// stateOf returns the state recorded in the attributes of a partition
+func stateOf(attributes uint64) State {
+	return State{
+		Priority:   int(attributes >> priorityShift & 0xf),
+		Tries:      int(attributes >> triesShift & 0xf),
+		Successful: attributes&successfulBit != 0,
+	}
+}
+
+// SlotOf returns the name without suffix of a partition of a slot, and its slot, or false if the
+// name has no slot suffix
+func SlotOf(p *gpt.Partition) (string, Slot, bool) {
+	n := len(p.Name)
+	if n < 3 || p.Name[n-2] != '_' {
+		return "", "", false
+	}
+	slot := Slot(p.Name[n-1:])
+	if slot != SlotA && slot != SlotB {
+		return "", "", false
+	}
+	return p.Name[:n-2], slot, true
+}
+
+// Find returns the partition of the slot with the name without suffix, and its number from 1, or
+// false if there is none
+func Find(t *gpt.Table, name string, slot Slot) (int, *gpt.Partition, bool) {
+	for i, p := range t.Partitions {
+		if n, s, ok := SlotOf(p); ok && n == name && s == slot {
+			return i + 1, p, true
+		}
+	}
+	return 0, nil, false
+}
+
+// GetState returns the state of the slot, from its first partition, or an error if it has none
+func GetState(t *gpt.Table, slot Slot) (State, error) {
+	for _, p := range t.Partitions {
+		if _, s, ok := SlotOf(p); ok && s == slot {
+			return stateOf(p.Attributes), nil
+		}
+	}
+	return State{}, fmt.Errorf("no partition of slot %s", slot)
+}
+
+// Active returns the slot that a boot loader would boot, the bootable one of highest priority,
+// SlotA if both have the same, or an error if neither is bootable
+func Active(t *gpt.Table) (Slot, error) {
+	a, err := GetState(t, SlotA)
+	if err != nil {
+		return "", err
+	}
+	b, err := GetState(t, SlotB)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case b.Bootable() && (!a.Bootable() || b.Priority > a.Priority):
+		return SlotB, nil
+	case a.Bootable():
+		return SlotA, nil
+	}
+	return "", fmt.Errorf("neither slot is bootable")
+}
+
+// SetActive makes the slot, e.g. once updated, the one to boot, with a priority above that of the
+// other slot and the number of tries to boot it successfully, or marked successful if tries is 0.
+// The other slot stays bootable, as a fallback if the slot fails to boot. Write the table to the
+// disk for the change to take effect.
+func SetActive(t *gpt.Table, slot Slot, tries int) error {
+	if slot != SlotA && slot != SlotB {
+		return fmt.Errorf("invalid slot %q", slot)
+	}
+	if tries < 0 || tries > maxTries {
+		return fmt.Errorf("invalid number of tries %d, must be from 0 to %d", tries, maxTries)
+	}
+	other, err := GetState(t, slot.Other())
+	if err != nil {
+		return err
+	}
+	if _, err := GetState(t, slot); err != nil {
+		return err
+	}
+	// the other slot goes below, to make room under the highest priority
+	priority := min(other.Priority+1, maxPriority)
+	if other.Priority >= maxPriority {
+		other.Priority = maxPriority - 1
+		setState(t, slot.Other(), other)
+	}
+	setState(t, slot, State{Priority: priority, Tries: tries, Successful: tries == 0})
+	return nil
+}
+
+// MarkSuccessful records that the slot booted successfully, so that it is no longer tried a
+// limited number of times. Write the table to the disk for the change to take effect.
+func MarkSuccessful(t *gpt.Table, slot Slot) error {
+	st, err := GetState(t, slot)
+	if err != nil {
+		return err
+	}
+	st.Successful, st.Tries = true, 0
+	setState(t, slot, st)
+	return nil
+}
+
+// setState sets the state of the partitions of the slot, and marks those with the role of an
+// architecture gpt.AttributeNoAuto unless their slot is the one to boot
+func setState(t *gpt.Table, slot Slot, st State) {
+	for _, p := range t.Partitions {
+		if _, s, ok := SlotOf(p); ok && s == slot {
+			p.Attributes = p.Attributes&^slotBits | st.attributes()
+		}
+	}
+	active, err := Active(t)
+	for _, p := range t.Partitions {
+		_, s, ok := SlotOf(p)
+		// only the roles of each architecture are mounted by systemd from their type alone
+		if _, arch, _ := p.Role(); !ok || arch == "" {
+			continue
+		}
+		if err == nil && s == active {
+			p.Attributes &^= gpt.AttributeNoAuto
+		} else {
+			p.Attributes |= gpt.AttributeNoAuto
+		}
+	}
+}
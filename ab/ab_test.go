@@ -0,0 +1,158 @@
+package ab_test
+
+import (
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/ab"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+const (
+	size = 64 * 1024 * 1024
+	mib  = 1024 * 1024
+)
+
+var spec = &ab.Spec{
+	Architecture: gpt.ArchARM64,
+	ESPSize:      8 * mib,
+	Partitions: []ab.Partition{
+		{Name: "rootfs", Role: gpt.RoleRoot, Size: 16 * mib, Attributes: gpt.AttributeReadOnly},
+		{Name: "app", Size: 4 * mib},
+	},
+}
+
+// partition returns a disk partitioned with the spec, and its table read back
+func partition(t *testing.T) (*disk.Disk, *gpt.Table) {
+	t.Helper()
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	if _, err := spec.Partition(d); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	table, err := gpt.Read(b, 512, 512)
+	if err != nil {
+		t.Fatalf("error reading table: %v", err)
+	}
+	return d, table
+}
+
+func TestLayout(t *testing.T) {
+	_, table := partition(t)
+	expected := []struct {
+		name  string
+		typ   gpt.Type
+		start uint64
+		end   uint64
+	}{
+		{"esp", gpt.EFISystemPartition, 2048, 18431},
+		{"rootfs_a", gpt.LinuxRootArm64, 18432, 51199},
+		{"rootfs_b", gpt.LinuxRootArm64, 51200, 83967},
+		{"app_a", gpt.LinuxFilesystem, 83968, 92159},
+		{"app_b", gpt.LinuxFilesystem, 92160, 100351},
+		{"data", gpt.LinuxFilesystem, 100352, size/512 - 34},
+	}
+	if len(table.Partitions) != len(expected) {
+		t.Fatalf("%d partitions instead of %d", len(table.Partitions), len(expected))
+	}
+	for i, e := range expected {
+		p := table.Partitions[i]
+		if p.Name != e.name || p.Type != e.typ || p.Start != e.start || p.End != e.end {
+			t.Errorf("partition %d is %s of type %s from %d to %d instead of %s of type %s from %d to %d", i+1, p.Name, p.Type, p.Start, p.End, e.name, e.typ, e.start, e.end)
+		}
+	}
+	for _, tt := range []struct {
+		name  string
+		slot  ab.Slot
+		state ab.State
+	}{
+		{"rootfs", ab.SlotA, ab.State{Priority: 1, Successful: true}},
+		{"rootfs", ab.SlotB, ab.State{}},
+	} {
+		state, err := ab.GetState(table, tt.slot)
+		if err != nil || state != tt.state {
+			t.Errorf("slot %s in state %+v, %v instead of %+v", tt.slot, state, err, tt.state)
+		}
+	}
+	if active, err := ab.Active(table); err != nil || active != ab.SlotA {
+		t.Errorf("active slot %s, %v instead of %s", active, err, ab.SlotA)
+	}
+	// systemd mounts the root of the active slot, read-only
+	n, p, ok := table.Discoverable(gpt.RoleRoot, gpt.ArchARM64)
+	if !ok || n != 2 || p.Attributes&gpt.AttributeReadOnly == 0 {
+		t.Errorf("discovered root partition %d, %v", n, ok)
+	}
+	if n, _, ok := ab.Find(table, "app", ab.SlotB); !ok || n != 5 {
+		t.Errorf("found app_b as partition %d, %v", n, ok)
+	}
+}
+
+func TestSetActive(t *testing.T) {
+	d, table := partition(t)
+	if err := ab.SetActive(table, ab.SlotB, 3); err != nil {
+		t.Fatalf("error activating slot: %v", err)
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error writing table: %v", err)
+	}
+	table, err := gpt.Read(d.Backend, 512, 512)
+	if err != nil {
+		t.Fatalf("error reading table: %v", err)
+	}
+	if active, err := ab.Active(table); err != nil || active != ab.SlotB {
+		t.Errorf("active slot %s, %v instead of %s", active, err, ab.SlotB)
+	}
+	if state, _ := ab.GetState(table, ab.SlotB); state != (ab.State{Priority: 2, Tries: 3}) {
+		t.Errorf("slot b in state %+v", state)
+	}
+	if n, _, ok := table.Discoverable(gpt.RoleRoot, gpt.ArchARM64); !ok || n != 3 {
+		t.Errorf("discovered root partition %d, %v instead of 3", n, ok)
+	}
+
+	// slot b falls back to slot a once out of tries, until marked successful
+	for _, p := range table.Partitions {
+		if _, slot, ok := ab.SlotOf(p); ok && slot == ab.SlotB {
+			p.Attributes &^= 0xf << 52
+		}
+	}
+	if active, err := ab.Active(table); err != nil || active != ab.SlotA {
+		t.Errorf("active slot %s, %v instead of %s", active, err, ab.SlotA)
+	}
+	if err := ab.MarkSuccessful(table, ab.SlotB); err != nil {
+		t.Fatalf("error marking slot successful: %v", err)
+	}
+	if active, err := ab.Active(table); err != nil || active != ab.SlotB {
+		t.Errorf("active slot %s, %v instead of %s", active, err, ab.SlotB)
+	}
+	if err := ab.SetActive(table, ab.SlotB, 16); err == nil {
+		t.Errorf("activated slot with 16 tries")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ab.Spec
+	}{
+		{"no partitions", ab.Spec{}},
+		{"too large", ab.Spec{Partitions: []ab.Partition{{Name: "rootfs", Size: 32 * mib}}}},
+		{"unaligned", ab.Spec{Partitions: []ab.Partition{{Name: "rootfs", Size: mib + 1}}}},
+		{"role", ab.Spec{Architecture: "vax", Partitions: []ab.Partition{{Name: "rootfs", Role: gpt.RoleRoot, Size: mib}}}},
+		{"slot", ab.Spec{Active: "c", Partitions: []ab.Partition{{Name: "rootfs", Size: mib}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.spec.Table(size, 512); err == nil {
+				t.Errorf("created table")
+			}
+		})
+	}
+}
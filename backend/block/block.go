@@ -0,0 +1,331 @@
+// Package block provides a backend for block devices, which queries the operating system for their
+// size and sector sizes, and supports discarding ranges and re-reading the partition table.
+//
+// Block devices report a size of 0 through Stat, and their sector sizes are only known to the kernel,
+// so they are found with ioctls when the device is opened: BLKGETSIZE64, BLKSSZGET and BLKPBSZGET on
+// Linux, and their DKIOC equivalents on Darwin.
+//
+// On Windows, physical drives and volumes are opened by their paths, \\.\PhysicalDrive1 or \\.\E:,
+// and their sizes found with IOCTL_DISK_GET_LENGTH_INFO and IOCTL_STORAGE_QUERY_PROPERTY. Devices
+// there are only read and written in whole sectors, so reads and writes of parts of sectors are
+// done by reading, and writing back, the whole sectors around them. Windows refuses writes to the
+// sectors of mounted volumes, so Lock locks and dismounts them.
+package block
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// Device is an open block device
+type Device struct {
+	f                  *os.File
+	readOnly           bool
+	size               int64
+	logicalSectorSize  int64
+	physicalSectorSize int64
+	// volumes are the volumes of the device locked by Lock, until the device is closed
+	volumes []*os.File
+	// mu serializes the writes, where those of parts of sectors read and write back whole sectors
+	mu sync.Mutex
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Device)(nil)
+
+// IsDevice reports whether f is a block device: a device file, or on Windows a physical drive or
+// volume, such as \\.\PhysicalDrive1 or \\.\E:
+func IsDevice(f *os.File) bool {
+	if isDevicePath(f.Name()) {
+		return true
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeDevice != 0
+}
+
+// New creates a Device from an open block device
+func New(f *os.File, readOnly bool) (*Device, error) {
+	if !isDevicePath(f.Name()) {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("could not stat %s: %w", f.Name(), err)
+		}
+		if info.Mode()&os.ModeDevice == 0 {
+			return nil, fmt.Errorf("%s is not a block device", f.Name())
+		}
+	}
+	size, err := DeviceSize(f)
+	if err != nil {
+		return nil, err
+	}
+	logical, physical, err := SectorSizes(f)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{
+		f:                  f,
+		readOnly:           readOnly,
+		size:               size,
+		logicalSectorSize:  logical,
+		physicalSectorSize: physical,
+	}, nil
+}
+
+// OpenFromPath opens the block device at the path, e.g. /dev/sdb, or \\.\PhysicalDrive1 on
+// Windows. Unless readOnly, it is opened for exclusive access, and locked with Lock.
+func OpenFromPath(pathName string, readOnly bool) (*Device, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass device name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR | os.O_EXCL
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open device %s: %w", pathName, err)
+	}
+	d, err := New(f, readOnly)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !readOnly {
+		if err := d.Lock(); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Size is the size of the device in bytes
+func (d *Device) Size() int64 {
+	return d.size
+}
+
+// LogicalSectorSize is the smallest unit the device can address
+func (d *Device) LogicalSectorSize() int64 {
+	return d.logicalSectorSize
+}
+
+// PhysicalSectorSize is the unit the device writes internally, to which writes should be aligned
+func (d *Device) PhysicalSectorSize() int64 {
+	return d.physicalSectorSize
+}
+
+// Discard tells the device that a range is no longer used, e.g. to trim an SSD or to deallocate
+// it in a thin provisioned volume. The range must be aligned to the logical sector size, and
+// reads of it afterwards may return anything.
+func (d *Device) Discard(offset, length int64) error {
+	if d.readOnly {
+		return backend.ErrIncorrectOpenMode
+	}
+	if offset < 0 || length < 0 || offset+length > d.size {
+		return fmt.Errorf("range of %d bytes at %d is outside of the device of size %d", length, offset, d.size)
+	}
+	if offset%d.logicalSectorSize != 0 || length%d.logicalSectorSize != 0 {
+		return fmt.Errorf("range of %d bytes at %d is not aligned to sectors of %d bytes", length, offset, d.logicalSectorSize)
+	}
+	if length == 0 {
+		return nil
+	}
+	if err := discard(d.f, offset, length); err != nil {
+		return fmt.Errorf("unable to discard %d bytes at %d: %w", length, offset, err)
+	}
+	return nil
+}
+
+// RereadPartitionTable makes the kernel re-read the partition table, so that it uses the partitions
+// written to the device
+func (d *Device) RereadPartitionTable() error {
+	return RereadPartitionTable(d.f)
+}
+
+// Lock takes exclusive access of the device for writing. On Windows, it locks and dismounts the
+// volumes of the device, failing if files of them are open, and keeps them locked until the device
+// is closed. Elsewhere, OpenFromPath and diskfs.Open already open the device for exclusive access,
+// with O_EXCL, and it does nothing.
+func (d *Device) Lock() error {
+	if d.readOnly {
+		return backend.ErrIncorrectOpenMode
+	}
+	if d.volumes != nil {
+		return nil
+	}
+	volumes, err := lockVolumes(d.f)
+	if err != nil {
+		return err
+	}
+	d.volumes = volumes
+	return nil
+}
+
+// Stat describes the device, with its size. It is a device, with os.ModeDevice, even on Windows,
+// whose physical drives have no status of their own.
+func (d *Device) Stat() (fs.FileInfo, error) {
+	info, err := d.f.Stat()
+	if err != nil && !isDevicePath(d.f.Name()) {
+		return nil, err
+	}
+	if err != nil {
+		info = nil
+	}
+	return &deviceInfo{info: info, name: d.f.Name(), size: d.size}, nil
+}
+
+func (d *Device) Read(b []byte) (int, error) {
+	if !sectorAligned {
+		return d.f.Read(b)
+	}
+	offset, err := d.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.ReadAt(b, offset)
+	if _, seekErr := d.f.Seek(offset+int64(n), io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+	return n, err
+}
+
+func (d *Device) ReadAt(p []byte, off int64) (int, error) {
+	start, end := d.sectors(off, len(p))
+	if start == off && end == off+int64(len(p)) {
+		return d.f.ReadAt(p, off)
+	}
+	buf := make([]byte, end-start)
+	n, err := d.f.ReadAt(buf, start)
+	n = int(min(max(int64(n)-(off-start), 0), int64(len(p))))
+	copy(p, buf[off-start:][:n])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// WriteAt writes to the device, reading and writing back the whole sectors of the parts of sectors
+// written where the device is only written in whole sectors
+func (d *Device) WriteAt(p []byte, off int64) (int, error) {
+	if d.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if !sectorAligned {
+		return d.f.WriteAt(p, off)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start, end := d.sectors(off, len(p))
+	if start == off && end == off+int64(len(p)) {
+		return d.f.WriteAt(p, off)
+	}
+	buf := make([]byte, end-start)
+	if _, err := d.f.ReadAt(buf, start); err != nil {
+		return 0, err
+	}
+	copy(buf[off-start:], p)
+	if _, err := d.f.WriteAt(buf, start); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sectors returns the range of whole sectors around the range of n bytes at off where the device
+// is only read and written in whole sectors, or the range itself elsewhere
+func (d *Device) sectors(off int64, n int) (start, end int64) {
+	end = off + int64(n)
+	if !sectorAligned || d.logicalSectorSize == 0 {
+		return off, end
+	}
+	start = off - off%d.logicalSectorSize
+	if r := end % d.logicalSectorSize; r != 0 {
+		end += d.logicalSectorSize - r
+	}
+	return start, end
+}
+
+func (d *Device) Seek(offset int64, whence int) (int64, error) {
+	return d.f.Seek(offset, whence)
+}
+
+// Close closes the device, and unlocks the volumes locked by Lock
+func (d *Device) Close() error {
+	var errs []error
+	for _, v := range d.volumes {
+		errs = append(errs, v.Close())
+	}
+	d.volumes = nil
+	return errors.Join(append(errs, d.f.Close())...)
+}
+
+// Sync commits the writes to the device to stable storage
+func (d *Device) Sync() error {
+	if d.readOnly {
+		return nil
+	}
+	return d.f.Sync()
+}
+
+// Sys returns the device file, for ioctl calls
+func (d *Device) Sys() (*os.File, error) {
+	return d.f, nil
+}
+
+// Writable returns the device, unless opened read-only
+func (d *Device) Writable() (backend.WritableFile, error) {
+	if d.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return d, nil
+}
+
+// deviceInfo reports the size of the device, which Stat of the device file does not, from the
+// status of the device file if it has one
+type deviceInfo struct {
+	info fs.FileInfo
+	name string
+	size int64
+}
+
+func (i *deviceInfo) Name() string {
+	if i.info != nil {
+		return i.info.Name()
+	}
+	return i.name
+}
+
+func (i *deviceInfo) Size() int64 {
+	return i.size
+}
+
+func (i *deviceInfo) Mode() fs.FileMode {
+	if i.info != nil {
+		return i.info.Mode() | os.ModeDevice
+	}
+	return os.ModeDevice | 0o600
+}
+
+func (i *deviceInfo) ModTime() time.Time {
+	if i.info != nil {
+		return i.info.ModTime()
+	}
+	return time.Time{}
+}
+
+func (i *deviceInfo) IsDir() bool {
+	return false
+}
+
+func (i *deviceInfo) Sys() any {
+	if i.info != nil {
+		return i.info.Sys()
+	}
+	return nil
+}
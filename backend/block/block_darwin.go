@@ -0,0 +1,73 @@
+package block
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// these constants should be part of "golang.org/x/sys/unix", but aren't, yet
+const (
+	dkiocGetBlockSize         = 0x40046418
+	dkiocGetPhysicalBlockSize = 0x4004644D
+	dkiocGetBlockCount        = 0x40086419
+	dkiocUnmap                = 0x8010641F
+)
+
+// DeviceSize gets the size of an open block device in bytes
+func DeviceSize(f *os.File) (int64, error) {
+	fd := int(f.Fd())
+	blockSize, err := unix.IoctlGetInt(fd, dkiocGetBlockSize)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get device logical sector size: %v", err)
+	}
+	blockCount, err := unix.IoctlGetInt(fd, dkiocGetBlockCount)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get device block count: %v", err)
+	}
+	return int64(blockSize) * int64(blockCount), nil
+}
+
+// SectorSizes gets the logical and physical sector sizes of an open block device
+func SectorSizes(f *os.File) (logicalSectorSize, physicalSectorSize int64, err error) {
+	fd := int(f.Fd())
+	logical, err := unix.IoctlGetInt(fd, dkiocGetBlockSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get device logical sector size: %v", err)
+	}
+	physical, err := unix.IoctlGetInt(fd, dkiocGetPhysicalBlockSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get device physical sector size: %v", err)
+	}
+	return int64(logical), int64(physical), nil
+}
+
+// RereadPartitionTable does nothing on Darwin, where the partitions of a disk are probed again by
+// the system when the written device is closed
+func RereadPartitionTable(_ *os.File) error {
+	return nil
+}
+
+// dkExtent and dkUnmap are dk_extent_t and dk_unmap_t of DKIOCUNMAP
+type dkExtent struct {
+	offset uint64
+	length uint64
+}
+
+type dkUnmap struct {
+	extents      *dkExtent
+	extentsCount uint32
+	options      uint32
+}
+
+// discard issues DKIOCUNMAP with a single extent
+func discard(f *os.File, offset, length int64) error {
+	extent := dkExtent{offset: uint64(offset), length: uint64(length)}
+	unmap := dkUnmap{extents: &extent, extentsCount: 1}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), dkiocUnmap, uintptr(unsafe.Pointer(&unmap))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package block
+
+import "testing"
+
+func TestIsDevicePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		device bool
+	}{
+		{`\\.\PhysicalDrive0`, true},
+		{`\\.\physicaldrive12`, true},
+		{`\\?\PhysicalDrive1`, true},
+		{`\\.\E:`, true},
+		{`\\.\E:\`, false},
+		{`\\.\PhysicalDrive`, false},
+		{`E:`, false},
+		{`C:\disk.img`, false},
+	}
+	for _, tt := range tests {
+		if device := isDevicePath(tt.path); device != tt.device {
+			t.Errorf("isDevicePath(%s) = %v instead of %v", tt.path, device, tt.device)
+		}
+		if volume := isVolumePath(tt.path); volume != (tt.device && tt.path[len(tt.path)-1] == ':') {
+			t.Errorf("isVolumePath(%s) = %v", tt.path, volume)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package block
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DeviceSize gets the size of an open block device in bytes
+func DeviceSize(f *os.File) (int64, error) {
+	size, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKGETSIZE64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get block device size: %v", err)
+	}
+	return int64(size), nil
+}
+
+// SectorSizes gets the logical and physical sector sizes of an open block device
+func SectorSizes(f *os.File) (logicalSectorSize, physicalSectorSize int64, err error) {
+	fd := int(f.Fd())
+	logical, err := unix.IoctlGetInt(fd, unix.BLKSSZGET)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get device logical sector size: %v", err)
+	}
+	physical, err := unix.IoctlGetInt(fd, unix.BLKPBSZGET)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get device physical sector size: %v", err)
+	}
+	return int64(logical), int64(physical), nil
+}
+
+// RereadPartitionTable makes the kernel re-read the partition table of an open block device,
+// with the BLKRRPART ioctl
+func RereadPartitionTable(f *os.File) error {
+	if _, err := unix.IoctlGetInt(int(f.Fd()), unix.BLKRRPART); err != nil {
+		return fmt.Errorf("unable to re-read the partition table. Kernel still uses old partition table: %v", err)
+	}
+	return nil
+}
+
+// discard issues BLKDISCARD, which takes the start and length of the range
+func discard(f *os.File, offset, length int64) error {
+	r := [2]uint64{uint64(offset), uint64(length)}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKDISCARD, uintptr(unsafe.Pointer(&r[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package block_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/block"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// loopDevice attaches the file to a loop device with partition scanning, skipping the test if that
+// is not possible, e.g. when not running as root
+func loopDevice(t *testing.T, pathName string) string {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("loop devices need root")
+	}
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", pathName).Output()
+	if err != nil {
+		t.Skipf("could not attach loop device: %v", err)
+	}
+	device := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		_ = exec.Command("losetup", "--detach", device).Run()
+	})
+	return device
+}
+
+func TestNotBlockDevice(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, make([]byte, 1024*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := block.OpenFromPath(p, true); err == nil {
+		t.Errorf("no error opening regular file as block device")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "disk.img")
+	size := int64(20 * 1024 * 1024)
+	if err := os.WriteFile(p, bytes.Repeat([]byte{0xaa}, int(size)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	device := loopDevice(t, p)
+
+	d, err := block.OpenFromPath(device, false)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", device, err)
+	}
+	defer d.Close()
+	if d.Size() != size {
+		t.Errorf("size %d instead of %d", d.Size(), size)
+	}
+	info, err := d.Stat()
+	if err != nil {
+		t.Fatalf("error getting stat: %v", err)
+	}
+	if info.Size() != size {
+		t.Errorf("stat size %d instead of %d", info.Size(), size)
+	}
+	if d.LogicalSectorSize() != 512 {
+		t.Errorf("logical sector size %d instead of 512", d.LogicalSectorSize())
+	}
+
+	if err := d.Discard(1024*1024, 1000); err == nil {
+		t.Errorf("no error discarding unaligned range")
+	}
+	if err := d.Discard(1024*1024, 1024*1024); err != nil {
+		t.Fatalf("error discarding: %v", err)
+	}
+	b := make([]byte, 1024*1024)
+	if _, err := d.ReadAt(b, 1024*1024); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	// loop devices punch a hole in the file for discarded ranges
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("discarded range does not read as zeroes")
+	}
+
+	// partitioning through a disk re-reads the partition table
+	disk, err := diskfs.OpenBackend(d, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 4096, Type: mbr.Linux},
+		},
+	}
+	if err := disk.Partition(table); err != nil {
+		t.Fatalf("error partitioning: %v", err)
+	}
+
+	// diskfs.Open uses the backend for block devices
+	opened, err := diskfs.Open(device, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("error opening %s: %v", device, err)
+	}
+	defer opened.Close()
+	if _, ok := opened.Backend.(*block.Device); !ok {
+		t.Errorf("backend %T instead of *block.Device", opened.Backend)
+	}
+	if opened.Size != size {
+		t.Errorf("disk size %d instead of %d", opened.Size, size)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin && !windows
+
+package block
+
+import (
+	"errors"
+	"os"
+)
+
+// DeviceSize gets the size of an open block device in bytes
+func DeviceSize(_ *os.File) (int64, error) {
+	return 0, errors.New("block devices not supported on this platform")
+}
+
+// SectorSizes gets the logical and physical sector sizes of an open block device
+func SectorSizes(_ *os.File) (logicalSectorSize, physicalSectorSize int64, err error) {
+	return 0, 0, errors.New("block devices not supported on this platform")
+}
+
+// RereadPartitionTable makes the kernel re-read the partition table of an open block device
+func RereadPartitionTable(_ *os.File) error {
+	return errors.New("block devices not supported on this platform")
+}
+
+func discard(_ *os.File, _, _ int64) error {
+	return errors.ErrUnsupported
+}
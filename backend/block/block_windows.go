@@ -0,0 +1,218 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// these constants should be part of "golang.org/x/sys/windows", but aren't, yet
+const (
+	ioctlDiskGetLengthInfo          = 0x0007405C
+	ioctlDiskGetDriveGeometryEx     = 0x000700A0
+	ioctlDiskUpdateProperties       = 0x00070140
+	ioctlStorageQueryProperty       = 0x002D1400
+	ioctlStorageGetDeviceNumber     = 0x002D1080
+	ioctlVolumeGetVolumeDiskExtents = 0x00560000
+	fsctlLockVolume                 = 0x00090018
+	fsctlDismountVolume             = 0x00090020
+	storageAccessAlignmentProperty  = 6
+	propertyStandardQuery           = 0
+)
+
+// maxVolumeExtents is the number of extents of a volume read, which is more than a volume has on
+// a single drive, and diskExtentSize the size of each
+const (
+	maxVolumeExtents = 16
+	diskExtentSize   = 24
+)
+
+// Windows does reads and writes of raw devices in whole sectors only
+const sectorAligned = true
+
+// devicePath matches the paths of physical drives, \\.\PhysicalDrive1, and volumes, \\.\E:
+var devicePath = regexp.MustCompile(`(?i)^\\\\[.?]\\(PhysicalDrive[0-9]+|[a-z]:)$`)
+
+// isDevicePath reports whether p is the path of a physical drive or volume, which Windows has no
+// device files for
+func isDevicePath(p string) bool {
+	return devicePath.MatchString(p)
+}
+
+// isVolumePath reports whether p is the path of a volume rather than that of a physical drive
+func isVolumePath(p string) bool {
+	return isDevicePath(p) && strings.HasSuffix(p, ":")
+}
+
+// ioctl issues DeviceIoControl on the handle, with the input and output buffers
+func ioctl(handle windows.Handle, code uint32, in, out []byte) (uint32, error) {
+	var inPtr, outPtr *byte
+	if len(in) > 0 {
+		inPtr = &in[0]
+	}
+	if len(out) > 0 {
+		outPtr = &out[0]
+	}
+	var returned uint32
+	err := windows.DeviceIoControl(handle, code, inPtr, uint32(len(in)), outPtr, uint32(len(out)), &returned, nil)
+	return returned, err
+}
+
+// DeviceSize gets the size of an open physical drive or volume in bytes, with
+// IOCTL_DISK_GET_LENGTH_INFO
+func DeviceSize(f *os.File) (int64, error) {
+	var length int64
+	if _, err := ioctl(windows.Handle(f.Fd()), ioctlDiskGetLengthInfo, nil, unsafe.Slice((*byte)(unsafe.Pointer(&length)), 8)); err != nil {
+		return 0, fmt.Errorf("unable to get device size: %v", err)
+	}
+	return length, nil
+}
+
+// SectorSizes gets the logical and physical sector sizes of an open physical drive or volume, with
+// IOCTL_STORAGE_QUERY_PROPERTY, or with IOCTL_DISK_GET_DRIVE_GEOMETRY_EX for the logical sector
+// size of devices that do not report their alignment, whose physical sector size is taken to be
+// the same
+func SectorSizes(f *os.File) (logicalSectorSize, physicalSectorSize int64, err error) {
+	handle := windows.Handle(f.Fd())
+	// STORAGE_PROPERTY_QUERY, and the BytesPerLogicalSector and BytesPerPhysicalSector of
+	// STORAGE_ACCESS_ALIGNMENT_DESCRIPTOR
+	query := make([]byte, 12)
+	binary.LittleEndian.PutUint32(query[0:4], storageAccessAlignmentProperty)
+	binary.LittleEndian.PutUint32(query[4:8], propertyStandardQuery)
+	alignment := make([]byte, 28)
+	if n, err := ioctl(handle, ioctlStorageQueryProperty, query, alignment); err == nil && n >= 20 {
+		logical := binary.LittleEndian.Uint32(alignment[12:16])
+		physical := binary.LittleEndian.Uint32(alignment[16:20])
+		if logical != 0 && physical != 0 {
+			return int64(logical), int64(physical), nil
+		}
+	}
+	// DISK_GEOMETRY_EX, whose BytesPerSector ends its DISK_GEOMETRY
+	geometry := make([]byte, 40)
+	if _, err := ioctl(handle, ioctlDiskGetDriveGeometryEx, nil, geometry); err != nil {
+		return 0, 0, fmt.Errorf("unable to get device logical sector size: %v", err)
+	}
+	logical := int64(binary.LittleEndian.Uint32(geometry[20:24]))
+	return logical, logical, nil
+}
+
+// RereadPartitionTable makes Windows re-read the partition table of an open physical drive, with
+// IOCTL_DISK_UPDATE_PROPERTIES
+func RereadPartitionTable(f *os.File) error {
+	if isVolumePath(f.Name()) {
+		return nil
+	}
+	if _, err := ioctl(windows.Handle(f.Fd()), ioctlDiskUpdateProperties, nil, nil); err != nil {
+		return fmt.Errorf("unable to re-read the partition table. Windows still uses old partition table: %v", err)
+	}
+	return nil
+}
+
+func discard(_ *os.File, _, _ int64) error {
+	return errors.ErrUnsupported
+}
+
+// lockVolumes locks and dismounts the volume f, or the volumes on the physical drive f, returning
+// those it opened to lock, which stay locked until they are closed. Windows refuses writes to the
+// sectors of a mounted volume, even through the physical drive.
+func lockVolumes(f *os.File) ([]*os.File, error) {
+	if isVolumePath(f.Name()) {
+		if err := lockVolume(windows.Handle(f.Fd())); err != nil {
+			return nil, fmt.Errorf("unable to lock volume %s: %w", f.Name(), err)
+		}
+		return nil, nil
+	}
+	// STORAGE_DEVICE_NUMBER, whose DeviceNumber is that of the drive in the extents of volumes
+	number := make([]byte, 12)
+	if _, err := ioctl(windows.Handle(f.Fd()), ioctlStorageGetDeviceNumber, nil, number); err != nil {
+		return nil, fmt.Errorf("unable to get device number of %s: %v", f.Name(), err)
+	}
+	drive := binary.LittleEndian.Uint32(number[4:8])
+
+	var volumes []*os.File
+	closeAll := func() {
+		for _, v := range volumes {
+			v.Close()
+		}
+	}
+	name := make([]uint16, windows.MAX_PATH)
+	find, err := windows.FindFirstVolume(&name[0], uint32(len(name)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list volumes: %v", err)
+	}
+	defer windows.FindVolumeClose(find)
+	for {
+		v, err := volumeOnDrive(windows.UTF16ToString(name), drive)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if v != nil {
+			volumes = append(volumes, v)
+		}
+		if err := windows.FindNextVolume(find, &name[0], uint32(len(name))); err != nil {
+			if errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+				return volumes, nil
+			}
+			closeAll()
+			return nil, fmt.Errorf("unable to list volumes: %v", err)
+		}
+	}
+}
+
+// volumeOnDrive opens the volume named \\?\Volume{GUID}\, and locks and dismounts it if it has an
+// extent on the drive, returning it open, or returns nil if it is on other drives
+func volumeOnDrive(name string, drive uint32) (*os.File, error) {
+	// volumes are opened without the trailing backslash, which would open their root directory
+	p := strings.TrimSuffix(name, `\`)
+	p16, err := windows.UTF16PtrFromString(p)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(p16, windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		// volumes that cannot be opened, such as those of card readers without media, hold no
+		// sectors of the drive
+		return nil, nil
+	}
+	// VOLUME_DISK_EXTENTS, whose DISK_EXTENTs start with their DiskNumber
+	extents := make([]byte, 8+maxVolumeExtents*diskExtentSize)
+	if _, err := ioctl(handle, ioctlVolumeGetVolumeDiskExtents, nil, extents); err != nil {
+		// optical drives and other volumes that are not on disks have no extents
+		windows.CloseHandle(handle)
+		return nil, nil
+	}
+	count := min(int(binary.LittleEndian.Uint32(extents[0:4])), maxVolumeExtents)
+	for i := 0; i < count; i++ {
+		extent := extents[8+i*diskExtentSize:]
+		if binary.LittleEndian.Uint32(extent[0:4]) != drive {
+			continue
+		}
+		if err := lockVolume(handle); err != nil {
+			windows.CloseHandle(handle)
+			return nil, fmt.Errorf("unable to lock volume %s: %w", p, err)
+		}
+		return os.NewFile(uintptr(handle), p), nil
+	}
+	windows.CloseHandle(handle)
+	return nil, nil
+}
+
+// lockVolume locks the volume for exclusive access, failing if files of it are open, and dismounts
+// it, so that its filesystem does not keep its own view of the sectors written
+func lockVolume(handle windows.Handle) error {
+	if _, err := ioctl(handle, fsctlLockVolume, nil, nil); err != nil {
+		return fmt.Errorf("volume in use: %w", err)
+	}
+	if _, err := ioctl(handle, fsctlDismountVolume, nil, nil); err != nil {
+		return fmt.Errorf("unable to dismount: %w", err)
+	}
+	return nil
+}
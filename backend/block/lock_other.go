@@ -0,0 +1,20 @@
+//go:build !windows
+
+package block
+
+import "os"
+
+// devices are read and written at any offset
+const sectorAligned = false
+
+// isDevicePath reports whether p is the path of a device without a device file, which needs one
+// everywhere but on Windows
+func isDevicePath(_ string) bool {
+	return false
+}
+
+// lockVolumes does nothing, as the device is opened with O_EXCL for exclusive access, which the
+// kernel refuses while its partitions are mounted
+func lockVolumes(_ *os.File) ([]*os.File, error) {
+	return nil, nil
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCacheBlockSize is the size of the blocks cached by WithCache if none is given
+const DefaultCacheBlockSize int64 = 64 * 1024
+
+// cachedStorage is a Storage that keeps recently read blocks in memory
+type cachedStorage struct {
+	Storage
+	blockSize int64
+	maxBlocks int
+	// mu guards the cache and offset, but not reads of the underlying storage
+	mu     sync.Mutex
+	lru    *list.List
+	blocks map[int64]*list.Element
+	// generation changes with every write, so that blocks read while a write happens are not cached
+	generation uint64
+	offset     int64
+	// hits and misses count the blocks read from the cache and from the storage, see Stats
+	hits, misses atomic.Int64
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// WithCache wraps the storage with a cache of up to size bytes of recently read blocks of blockSize
+// bytes, DefaultCacheBlockSize if 0, which speeds up metadata heavy operations, such as walking
+// directories, on slow storage. Writes through Writable go to the storage directly, and drop the
+// blocks they overlap from the cache. The storage must not be changed other than through the cache.
+func WithCache(b Storage, size, blockSize int64) (Storage, error) {
+	if blockSize == 0 {
+		blockSize = DefaultCacheBlockSize
+	}
+	if blockSize < 0 {
+		return nil, fmt.Errorf("invalid cache block size %d", blockSize)
+	}
+	if size < blockSize {
+		return nil, fmt.Errorf("cache size %d is smaller than a block of %d bytes", size, blockSize)
+	}
+	return &cachedStorage{
+		Storage:   b,
+		blockSize: blockSize,
+		maxBlocks: int(size / blockSize),
+		lru:       list.New(),
+		blocks:    map[int64]*list.Element{},
+	}, nil
+}
+
+// ReadAt reads from the cache, reading the blocks that are not cached from the storage
+func (c *cachedStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		block, err := c.block(pos / c.blockSize)
+		if err != nil {
+			return read, err
+		}
+		inBlock := pos % c.blockSize
+		if inBlock >= int64(len(block)) {
+			return read, io.EOF
+		}
+		read += copy(p[read:], block[inBlock:])
+	}
+	return read, nil
+}
+
+// block returns the block with the given index, which is short at the end of the storage
+func (c *cachedStorage) block(index int64) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.blocks[index]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return e.Value.(*cachedBlock).data, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	b := make([]byte, c.blockSize)
+	n, err := c.Storage.ReadAt(b, index*c.blockSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	b = b[:n]
+	if n == 0 {
+		return b, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return b, nil
+	}
+	if _, ok := c.blocks[index]; !ok {
+		c.blocks[index] = c.lru.PushFront(&cachedBlock{index: index, data: b})
+		for c.lru.Len() > c.maxBlocks {
+			e := c.lru.Back()
+			c.lru.Remove(e)
+			delete(c.blocks, e.Value.(*cachedBlock).index)
+		}
+	}
+	return b, nil
+}
+
+// invalidate drops the cached blocks overlapping a write
+func (c *cachedStorage) invalidate(off, length int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if length <= 0 {
+		return
+	}
+	for i := off / c.blockSize; i <= (off+length-1)/c.blockSize; i++ {
+		if e, ok := c.blocks[i]; ok {
+			c.lru.Remove(e)
+			delete(c.blocks, i)
+		}
+	}
+}
+
+// Read reads at the current offset through the cache
+func (c *cachedStorage) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	offset := c.offset
+	c.mu.Unlock()
+	n, err := c.ReadAt(b, offset)
+	c.mu.Lock()
+	c.offset = offset + int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (c *cachedStorage) Seek(offset int64, whence int) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		info, err := c.Storage.Stat()
+		if err != nil {
+			return c.offset, err
+		}
+		offset += info.Size()
+	default:
+		return c.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return c.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the storage
+func (c *cachedStorage) Sync() error {
+	return Sync(c.Storage)
+}
+
+// Writable returns a file that reads through the cache, and writes to the storage
+func (c *cachedStorage) Writable() (WritableFile, error) {
+	w, err := c.Storage.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &cachedWritable{cachedStorage: c, w: w}, nil
+}
+
+type cachedWritable struct {
+	*cachedStorage
+	w WritableFile
+}
+
+func (c *cachedWritable) WriteAt(p []byte, off int64) (int, error) {
+	defer c.invalidate(off, int64(len(p)))
+	return c.w.WriteAt(p, off)
+}
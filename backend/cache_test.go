@@ -0,0 +1,137 @@
+package backend_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+// countingStorage counts the reads of the storage
+type countingStorage struct {
+	backend.Storage
+	reads atomic.Int64
+}
+
+func (c *countingStorage) ReadAt(p []byte, off int64) (int, error) {
+	c.reads.Add(1)
+	return c.Storage.ReadAt(p, off)
+}
+
+func TestWithCache(t *testing.T) {
+	content := make([]byte, 10*4096+100)
+	_, _ = rand.Read(content)
+	s := &countingStorage{Storage: mem.New(append([]byte(nil), content...), false)}
+	c, err := backend.WithCache(s, 4*4096, 4096)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+
+	b := make([]byte, 3*4096)
+	for i := 0; i < 3; i++ {
+		if _, err := c.ReadAt(b, 1000); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(b, content[1000:1000+len(b)]) {
+			t.Fatalf("contents do not match")
+		}
+	}
+	// four blocks, read once each
+	if s.reads.Load() != 4 {
+		t.Errorf("%d reads of storage instead of 4", s.reads.Load())
+	}
+
+	// reading more blocks than the cache holds evicts the least recently used ones
+	all, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(all, content) {
+		t.Errorf("contents do not match")
+	}
+	reads := s.reads.Load()
+	if _, err := c.ReadAt(b[:10], 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if s.reads.Load() != reads+1 {
+		t.Errorf("evicted block was not read again")
+	}
+
+	n, err := c.ReadAt(b, int64(len(content))-50)
+	if n != 50 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at the end instead of 50 bytes with EOF", n, err)
+	}
+
+	// writes drop the cached blocks
+	w, err := c.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	if _, err := w.WriteAt([]byte("overwritten"), 4090); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	copy(content[4090:], "overwritten")
+	if _, err := c.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content[:len(b)]) {
+		t.Errorf("stale cached contents after write")
+	}
+}
+
+func TestWithCacheConcurrent(t *testing.T) {
+	content := make([]byte, 64*1024)
+	s := mem.New(content, false)
+	c, err := backend.WithCache(s, 16*1024, 1024)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	w, err := c.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 3000)
+			for j := 0; j < 200; j++ {
+				off := int64((i*7919 + j*104729) % (len(content) - len(b)))
+				if i%2 == 0 {
+					_, _ = w.WriteAt(b[:100], off)
+				} else if _, err := c.ReadAt(b, off); err != nil {
+					t.Errorf("error reading: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b := make([]byte, len(content))
+	if _, err := c.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, s.Bytes()) {
+		t.Errorf("cached contents do not match storage")
+	}
+}
+
+func TestWithCacheInvalid(t *testing.T) {
+	s := mem.New(make([]byte, 100), true)
+	if _, err := backend.WithCache(s, 100, 4096); err == nil {
+		t.Errorf("no error with cache smaller than a block")
+	}
+	c, err := backend.WithCache(s, 1024*1024, 0)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	if _, err := c.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only storage")
+	}
+}
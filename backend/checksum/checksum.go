@@ -0,0 +1,447 @@
+// Package checksum provides a backend wrapper that keeps a CRC-32C checksum of every block of a
+// disk, and verifies the blocks on read, to catch silent corruption of images on unreliable
+// storage or transports.
+//
+// The checksums are kept in memory, computed from the contents of the disk when it is wrapped, or
+// in a sidecar file, so that they can be computed once when the image is known to be good and
+// verified whenever it is read later. The sidecar holds a header followed by the checksum of each
+// block. Writes through the wrapper update the checksums.
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks with a checksum each
+	DefaultBlockSize int64 = 4096
+	headerSize             = 512
+	sumSize                = 4
+	version                = 1
+	maxBlockSize           = 16 * 1024 * 1024
+)
+
+var magic = []byte("DFSCKSUM")
+
+// ErrChecksum is returned for reads of blocks whose contents do not match their checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Storage is a disk whose blocks are verified against their checksums on read
+type Storage struct {
+	storage   backend.Storage
+	rw        backend.WritableFile
+	sidecar   backend.Storage
+	sidecarRW backend.WritableFile
+	blockSize int64
+	size      int64
+	// mu guards sums and offset; reads may run concurrently
+	mu     sync.RWMutex
+	sums   []uint32
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Storage)(nil)
+
+func checkBlockSize(blockSize int64) error {
+	if blockSize < 512 || blockSize > maxBlockSize || blockSize&(blockSize-1) != 0 {
+		return fmt.Errorf("invalid block size %d, must be a power of 2 from 512 to %d", blockSize, maxBlockSize)
+	}
+	return nil
+}
+
+// New wraps the storage, computing the checksums of blocks of blockSize, DefaultBlockSize if 0, from
+// its current contents and keeping them in memory. It can be written if the storage is writable.
+func New(b backend.Storage, blockSize int64) (*Storage, error) {
+	s, err := newStorage(b, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.computeSums(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create wraps the storage like New, and writes the checksums to the sidecar, overwriting its
+// contents, so that the storage can be verified later with Open
+func Create(b, sidecar backend.Storage, blockSize int64) (*Storage, error) {
+	s, err := newStorage(b, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if s.sidecarRW, err = sidecar.Writable(); err != nil {
+		return nil, fmt.Errorf("sidecar is not writable: %w", err)
+	}
+	s.sidecar = sidecar
+	if err := s.computeSums(); err != nil {
+		return nil, err
+	}
+	h := make([]byte, headerSize)
+	copy(h, magic)
+	binary.LittleEndian.PutUint32(h[8:], version)
+	binary.LittleEndian.PutUint32(h[12:], uint32(s.blockSize))
+	binary.LittleEndian.PutUint64(h[16:], uint64(s.size))
+	sums := make([]byte, len(s.sums)*sumSize)
+	for i, sum := range s.sums {
+		binary.LittleEndian.PutUint32(sums[i*sumSize:], sum)
+	}
+	if err := s.writeSidecar(append(h, sums...), 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open wraps the storage with the checksums in a sidecar written by Create. The storage must have
+// the same size as when the sidecar was created. Unless readOnly, writes to the storage update the
+// sidecar.
+func Open(b, sidecar backend.Storage, readOnly bool) (*Storage, error) {
+	info, err := b.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat storage: %w", err)
+	}
+	h := make([]byte, headerSize)
+	if _, err := sidecar.ReadAt(h, 0); err != nil {
+		return nil, fmt.Errorf("could not read sidecar header: %w", err)
+	}
+	if !bytes.Equal(h[:8], magic) {
+		return nil, errors.New("invalid sidecar header, not a checksum sidecar")
+	}
+	if v := binary.LittleEndian.Uint32(h[8:]); v != version {
+		return nil, fmt.Errorf("unsupported checksum sidecar version %d", v)
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(h[12:]))
+	if err := checkBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	if size := int64(binary.LittleEndian.Uint64(h[16:])); size != info.Size() {
+		return nil, fmt.Errorf("storage is %d bytes, but the checksums are of %d bytes", info.Size(), size)
+	}
+	s := &Storage{
+		storage:   b,
+		sidecar:   sidecar,
+		blockSize: blockSize,
+		size:      info.Size(),
+	}
+	if !readOnly {
+		if s.rw, err = b.Writable(); err != nil {
+			return nil, fmt.Errorf("storage is not writable: %w", err)
+		}
+		if s.sidecarRW, err = sidecar.Writable(); err != nil {
+			return nil, fmt.Errorf("sidecar is not writable: %w", err)
+		}
+	}
+	sums := make([]byte, (s.size+blockSize-1)/blockSize*sumSize)
+	if n, err := sidecar.ReadAt(sums, headerSize); n != len(sums) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("could not read checksums from sidecar: %w", err)
+	}
+	s.sums = make([]uint32, len(sums)/sumSize)
+	for i := range s.sums {
+		s.sums[i] = binary.LittleEndian.Uint32(sums[i*sumSize:])
+	}
+	return s, nil
+}
+
+func newStorage(b backend.Storage, blockSize int64) (*Storage, error) {
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if err := checkBlockSize(blockSize); err != nil {
+		return nil, err
+	}
+	info, err := b.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat storage: %w", err)
+	}
+	s := &Storage{
+		storage:   b,
+		blockSize: blockSize,
+		size:      info.Size(),
+	}
+	// a storage that cannot be written is wrapped read-only
+	if rw, err := b.Writable(); err == nil {
+		s.rw = rw
+	}
+	return s, nil
+}
+
+// computeSums computes the checksums of all blocks from the contents of the storage
+func (s *Storage) computeSums() error {
+	s.sums = make([]uint32, (s.size+s.blockSize-1)/s.blockSize)
+	b := make([]byte, s.blockSize)
+	for i := range s.sums {
+		data, err := s.readBlock(b, int64(i))
+		if err != nil {
+			return err
+		}
+		s.sums[i] = crc32.Checksum(data, table)
+	}
+	return nil
+}
+
+// CreateFromPath wraps the image file, writing the checksums of its current contents to a new
+// sidecar file, and opens the image for writing
+func CreateFromPath(pathName, sidecarPath string, blockSize int64) (*Storage, error) {
+	b, err := file.OpenFromPath(pathName, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	f, err := os.OpenFile(sidecarPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("could not create sidecar %s: %w", sidecarPath, err)
+	}
+	s, err := Create(b, file.New(f, false), blockSize)
+	if err != nil {
+		b.Close()
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenFromPath wraps the image file with the checksums of its sidecar file
+func OpenFromPath(pathName, sidecarPath string, readOnly bool) (*Storage, error) {
+	b, err := file.OpenFromPath(pathName, readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	sidecar, err := file.OpenFromPath(sidecarPath, readOnly)
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("could not open sidecar %s: %w", sidecarPath, err)
+	}
+	s, err := Open(b, sidecar, readOnly)
+	if err != nil {
+		b.Close()
+		sidecar.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Size is the size of the disk
+func (s *Storage) Size() int64 {
+	return s.size
+}
+
+// BlockSize is the size of the blocks with a checksum each
+func (s *Storage) BlockSize() int64 {
+	return s.blockSize
+}
+
+// readBlock reads the block with the index into b, returning the part that is in the disk, which
+// is short for the last block
+func (s *Storage) readBlock(b []byte, index int64) ([]byte, error) {
+	start := index * s.blockSize
+	b = b[:min(s.blockSize, s.size-start)]
+	n, err := s.storage.ReadAt(b, start)
+	if n == len(b) {
+		return b, nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("could not read block %d at %d: %w", index, start, err)
+}
+
+// verifiedBlock reads the block with the index, and checks it against its checksum
+func (s *Storage) verifiedBlock(b []byte, index int64) ([]byte, error) {
+	data, err := s.readBlock(b, index)
+	if err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(data, table) != s.sums[index] {
+		return nil, fmt.Errorf("block %d at %d: %w", index, index*s.blockSize, ErrChecksum)
+	}
+	return data, nil
+}
+
+// Verify checks all blocks against their checksums, and returns the offsets of those that do not
+// match. The error is only for failures to read.
+func (s *Storage) Verify() ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var corrupt []int64
+	b := make([]byte, s.blockSize)
+	for i := range s.sums {
+		if _, err := s.verifiedBlock(b, int64(i)); err != nil {
+			if !errors.Is(err, ErrChecksum) {
+				return corrupt, err
+			}
+			corrupt = append(corrupt, int64(i)*s.blockSize)
+		}
+	}
+	return corrupt, nil
+}
+
+// ReadAt reads whole blocks from the storage, and returns an error wrapping ErrChecksum if any of
+// them does not match its checksum
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > s.size {
+		p = p[:s.size-off]
+		eof = io.EOF
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := make([]byte, s.blockSize)
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		data, err := s.verifiedBlock(b, pos/s.blockSize)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], data[pos%s.blockSize:])
+	}
+	return read, eof
+}
+
+// WriteAt writes to the storage, and updates the checksums of the blocks written. Blocks written
+// in part are verified first, so that corruption is not hidden by a new checksum.
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	if s.rw == nil {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the disk of size %d", len(p), off, s.size)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := make([]byte, s.blockSize)
+	written := 0
+	for written < len(p) {
+		pos := off + int64(written)
+		index := pos / s.blockSize
+		inBlock := pos % s.blockSize
+		chunk := p[written:min(len(p), written+int(s.blockSize-inBlock))]
+		var data []byte
+		if blockLen := min(s.blockSize, s.size-index*s.blockSize); int64(len(chunk)) == blockLen {
+			data = chunk
+		} else {
+			var err error
+			if data, err = s.verifiedBlock(b, index); err != nil {
+				return written, err
+			}
+			copy(data[inBlock:], chunk)
+		}
+		n, err := s.rw.WriteAt(chunk, pos)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := s.setSum(index, crc32.Checksum(data, table)); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// setSum updates the checksum of a block, in the sidecar if there is one
+func (s *Storage) setSum(index int64, sum uint32) error {
+	s.sums[index] = sum
+	if s.sidecarRW == nil {
+		return nil
+	}
+	return s.writeSidecar(binary.LittleEndian.AppendUint32(nil, sum), headerSize+index*sumSize)
+}
+
+func (s *Storage) writeSidecar(b []byte, offset int64) error {
+	n, err := s.sidecarRW.WriteAt(b, offset)
+	if err != nil {
+		return fmt.Errorf("could not write sidecar at %d: %w", offset, err)
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes to sidecar at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// Stat describes the storage
+func (s *Storage) Stat() (fs.FileInfo, error) {
+	return s.storage.Stat()
+}
+
+// Read reads from the disk at the current offset
+func (s *Storage) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+	n, err := s.ReadAt(b, offset)
+	s.mu.Lock()
+	s.offset = offset + int64(n)
+	s.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (s *Storage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return s.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the sidecar file, if any, and the storage
+func (s *Storage) Sync() error {
+	if s.sidecar == nil {
+		return backend.Sync(s.storage)
+	}
+	return errors.Join(backend.Sync(s.sidecar), backend.Sync(s.storage))
+}
+
+// Close closes the storage and the sidecar
+func (s *Storage) Close() error {
+	if s.sidecar == nil {
+		return s.storage.Close()
+	}
+	return errors.Join(s.sidecar.Close(), s.storage.Close())
+}
+
+// Sys is not suitable, as reads and writes through the file would bypass the checksums
+func (s *Storage) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the storage itself, unless it is read-only
+func (s *Storage) Writable() (backend.WritableFile, error) {
+	if s.rw == nil {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return s, nil
+}
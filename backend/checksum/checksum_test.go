@@ -0,0 +1,173 @@
+package checksum_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/checksum"
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+func TestChecksum(t *testing.T) {
+	content := make([]byte, 10*4096+100)
+	_, _ = rand.Read(content)
+	m := mem.New(append([]byte(nil), content...), false)
+	s, err := checksum.New(m, 4096)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	all, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(all, content) {
+		t.Errorf("contents do not match")
+	}
+
+	// writes through the storage update the checksums
+	data := bytes.Repeat([]byte("checked"), 2000)
+	if _, err := s.WriteAt(data, 4000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	copy(content[4000:], data)
+	if _, err := s.WriteAt([]byte("last"), int64(len(content))-4); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	copy(content[len(content)-4:], "last")
+	b := make([]byte, len(content))
+	if _, err := s.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading after write: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("contents do not match after write")
+	}
+
+	// changes that bypass the storage are corruption
+	m.Bytes()[5*4096+10] ^= 0xff
+	if _, err := s.ReadAt(b[:10], 5*4096); !errors.Is(err, checksum.ErrChecksum) {
+		t.Errorf("error %v instead of checksum mismatch", err)
+	}
+	if _, err := s.ReadAt(b[:10], 6*4096); err != nil {
+		t.Errorf("error reading other block: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("x"), 5*4096); !errors.Is(err, checksum.ErrChecksum) {
+		t.Errorf("error %v instead of checksum mismatch writing part of corrupt block", err)
+	}
+	corrupt, err := s.Verify()
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	if len(corrupt) != 1 || corrupt[0] != 5*4096 {
+		t.Errorf("corrupt blocks %v instead of [%d]", corrupt, 5*4096)
+	}
+	// overwriting the whole block repairs it
+	if _, err := s.WriteAt(content[5*4096:6*4096], 5*4096); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if corrupt, err := s.Verify(); err != nil || len(corrupt) != 0 {
+		t.Errorf("corrupt blocks %v with error %v after repair", corrupt, err)
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 1024*1024)
+	_, _ = rand.Read(content)
+	p := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(p, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sidecarPath := filepath.Join(dir, "disk.img.sums")
+	s, err := checksum.CreateFromPath(p, sidecarPath, 0)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("updated"), 100000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	copy(content[100000:], "updated")
+	if err := s.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+
+	s, err = checksum.OpenFromPath(p, sidecarPath, true)
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	b := make([]byte, len(content))
+	if _, err := s.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("contents do not match")
+	}
+	if _, err := s.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("no error writing read-only storage")
+	}
+	s.Close()
+
+	// corruption of the image outside of the storage
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("bit rot"), 500000); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	s, err = checksum.OpenFromPath(p, sidecarPath, true)
+	if err != nil {
+		t.Fatalf("error opening storage: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.ReadAt(b, 0); !errors.Is(err, checksum.ErrChecksum) {
+		t.Errorf("error %v instead of checksum mismatch", err)
+	}
+	corrupt, err := s.Verify()
+	if err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+	if block := int64(500000) / checksum.DefaultBlockSize * checksum.DefaultBlockSize; len(corrupt) != 1 || corrupt[0] != block {
+		t.Errorf("corrupt blocks %v instead of [%d]", corrupt, block)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(p, make([]byte, 64*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checksum.CreateFromPath(p, filepath.Join(dir, "bad"), 1000); err == nil {
+		t.Errorf("no error with block size that is not a power of 2")
+	}
+	sidecarPath := filepath.Join(dir, "disk.img.sums")
+	s, err := checksum.CreateFromPath(p, sidecarPath, 0)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	s.Close()
+	if err := os.Truncate(sidecarPath, 512+10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checksum.OpenFromPath(p, sidecarPath, true); err == nil {
+		t.Errorf("no error opening truncated sidecar")
+	}
+	if err := os.Truncate(p, 128*1024); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checksum.OpenFromPath(p, sidecarPath, true); err == nil {
+		t.Errorf("no error opening storage of a different size")
+	}
+	if _, err := checksum.OpenFromPath(p, p, true); err == nil {
+		t.Errorf("no error opening image as sidecar")
+	}
+	if _, err := checksum.New(mem.New(make([]byte, 100), true), 0); err != nil {
+		t.Errorf("error wrapping read-only storage: %v", err)
+	}
+}
@@ -0,0 +1,465 @@
+// Package crypt provides a backend wrapper that encrypts the disk with AES, sector by sector,
+// with a key provided by the caller.
+//
+// The layout is that of plain dm-crypt, with the aes-xts-plain64 cipher unless WithCipher: there
+// is no header, each sector is encrypted with its sector number as the IV, in 512 byte units
+// unless WithLargeSectorIV, and WithOffset and WithIVOffset match the offset and iv_offset of the
+// dm-crypt table. An image created with the wrapper can thereby be opened with
+//
+//	cryptsetup open --type plain --cipher aes-xts-plain64 --key-size 512 --key-file key disk.img name
+//
+// and the other way around. Without the key, the image is indistinguishable from random data.
+// NewPartition encrypts a single partition of a disk in the same way, leaving the partition table
+// readable, as embedded products with a static key do.
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+// DefaultSectorSize is the size of the units encrypted with their own tweak
+const DefaultSectorSize int64 = 512
+
+// DefaultCipher is the cipher of the encryption unless WithCipher, the default of cryptsetup
+const DefaultCipher = "aes-xts-plain64"
+
+// ivSectorSize is the unit of the sector numbers used as tweak, unless WithLargeSectorIV
+const ivSectorSize = 512
+
+// Storage is a disk encrypted with AES on the storage it wraps
+type Storage struct {
+	storage backend.Storage
+	rw      backend.WritableFile
+	data    cipher.Block
+	// tweak encrypts the tweaks of XTS, nil for CBC
+	tweak cipher.Block
+	// essiv encrypts the sector numbers into IVs, nil for plain and plain64 IVs
+	essiv cipher.Block
+	// plain truncates the sector numbers of the IVs to 32 bits
+	plain      bool
+	sectorSize int64
+	start      int64
+	size       int64
+	ivOffset   uint64
+	largeIV    bool
+	// writeMu serializes writes, which read and write back whole sectors
+	writeMu sync.Mutex
+	// mu guards offset
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Storage)(nil)
+
+type opts struct {
+	cipher     string
+	sectorSize int64
+	offset     int64
+	ivOffset   uint64
+	largeIV    bool
+}
+
+// Opt func that process New options
+type Opt func(o *opts) error
+
+// WithSectorSize sets the size of the units encrypted with their own tweak, 512, 1024, 2048 or
+// 4096 bytes, DefaultSectorSize if not set, as the sector_size of dm-crypt
+func WithSectorSize(size int64) Opt {
+	return func(o *opts) error {
+		if size < 512 || size > 4096 || size&(size-1) != 0 {
+			return fmt.Errorf("invalid sector size %d, must be a power of 2 from 512 to 4096", size)
+		}
+		o.sectorSize = size
+		return nil
+	}
+}
+
+// WithCipher sets the cipher of the encryption, in the format of dm-crypt, DefaultCipher if not
+// set: AES in the xts or cbc mode, with IVs of the sector numbers in 64 bits with plain64, in 32
+// bits with plain, or encrypted with the SHA256 hash of the key with essiv:sha256, e.g.
+// "aes-cbc-essiv:sha256", the default of older versions of cryptsetup
+func WithCipher(spec string) Opt {
+	return func(o *opts) error {
+		if _, _, err := parseCipher(spec); err != nil {
+			return err
+		}
+		o.cipher = spec
+		return nil
+	}
+}
+
+// parseCipher returns the mode and IV of the cipher
+func parseCipher(spec string) (mode, iv string, err error) {
+	parts := strings.SplitN(spec, "-", 3)
+	if len(parts) != 3 || parts[0] != "aes" || (parts[1] != "xts" && parts[1] != "cbc") {
+		return "", "", fmt.Errorf("unsupported cipher %s, must be aes-xts or aes-cbc", spec)
+	}
+	switch parts[2] {
+	case "plain", "plain64", "essiv:sha256":
+	default:
+		return "", "", fmt.Errorf("unsupported IV %s of cipher %s, must be plain, plain64 or essiv:sha256", parts[2], spec)
+	}
+	return parts[1], parts[2], nil
+}
+
+// WithOffset starts the encrypted disk at an offset in bytes in the storage, which must be a
+// multiple of 512, as the offset of dm-crypt in sectors
+func WithOffset(offset int64) Opt {
+	return func(o *opts) error {
+		if offset < 0 || offset%512 != 0 {
+			return fmt.Errorf("invalid offset %d, must be a multiple of 512", offset)
+		}
+		o.offset = offset
+		return nil
+	}
+}
+
+// WithIVOffset adds to the sector numbers used as tweak, as the iv_offset of dm-crypt
+func WithIVOffset(sectors uint64) Opt {
+	return func(o *opts) error {
+		o.ivOffset = sectors
+		return nil
+	}
+}
+
+// WithLargeSectorIV counts the sector numbers used as tweak in units of the sector size instead
+// of 512 bytes, as the iv_large_sectors option of dm-crypt
+func WithLargeSectorIV() Opt {
+	return func(o *opts) error {
+		o.largeIV = true
+		return nil
+	}
+}
+
+// New wraps the storage, encrypting and decrypting it with the key. For XTS, the key is 32 bytes
+// for AES-128-XTS or 64 bytes for AES-256-XTS, the first half for the data and the second half for
+// the tweak; for CBC, it is 16, 24 or 32 bytes. It can be written if the storage is writable. The
+// size is that of the storage after the offset, rounded down to a whole number of sectors.
+func New(b backend.Storage, key []byte, options ...Opt) (*Storage, error) {
+	o := &opts{cipher: DefaultCipher, sectorSize: DefaultSectorSize}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	mode, iv, err := parseCipher(o.cipher)
+	if err != nil {
+		return nil, err
+	}
+	var data, tweak, essiv cipher.Block
+	if mode == "xts" {
+		if len(key) != 32 && len(key) != 64 {
+			return nil, fmt.Errorf("invalid key of %d bytes, must be 32 bytes for AES-128-XTS or 64 bytes for AES-256-XTS", len(key))
+		}
+		if data, err = aes.NewCipher(key[:len(key)/2]); err != nil {
+			return nil, err
+		}
+		if tweak, err = aes.NewCipher(key[len(key)/2:]); err != nil {
+			return nil, err
+		}
+	} else if data, err = aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("invalid key of %d bytes for AES-CBC: %w", len(key), err)
+	}
+	if iv == "essiv:sha256" {
+		salt := sha256.Sum256(key)
+		if essiv, err = aes.NewCipher(salt[:]); err != nil {
+			return nil, err
+		}
+	}
+	info, err := b.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat storage: %w", err)
+	}
+	if info.Size() < o.offset {
+		return nil, fmt.Errorf("offset %d is beyond the end of the storage of size %d", o.offset, info.Size())
+	}
+	s := &Storage{
+		storage:    b,
+		data:       data,
+		tweak:      tweak,
+		essiv:      essiv,
+		plain:      iv == "plain",
+		sectorSize: o.sectorSize,
+		start:      o.offset,
+		size:       (info.Size() - o.offset) / o.sectorSize * o.sectorSize,
+		ivOffset:   o.ivOffset,
+		largeIV:    o.largeIV,
+	}
+	// a storage that cannot be written is wrapped read-only
+	if rw, err := b.Writable(); err == nil {
+		s.rw = rw
+	}
+	return s, nil
+}
+
+// NewPartition wraps the partition of the disk, numbered from 1 as in disk.GetFilesystem, so that
+// it is encrypted with the key as New does, e.g. to create a filesystem in it with
+// diskfs.OpenBackend. Closing it leaves the disk open.
+func NewPartition(d *disk.Disk, partition int, key []byte, options ...Opt) (*Storage, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot encrypt partition %d of %d partitions", partition, len(partitions))
+	}
+	p := partitions[partition-1]
+	return New(backend.Section(d.Backend, p.GetStart(), p.GetSize()), key, options...)
+}
+
+// CreateFromPath creates an image file of the size, to be encrypted with the key
+func CreateFromPath(pathName string, size int64, key []byte, options ...Opt) (*Storage, error) {
+	b, err := file.CreateFromPath(pathName, size)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(b, key, options...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenFromPath opens an image file encrypted with the key
+func OpenFromPath(pathName string, key []byte, readOnly bool, options ...Opt) (*Storage, error) {
+	b, err := file.OpenFromPath(pathName, readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	s, err := New(b, key, options...)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Size is the size of the decrypted disk
+func (s *Storage) Size() int64 {
+	return s.size
+}
+
+// SectorSize is the size of the units encrypted with their own tweak
+func (s *Storage) SectorSize() int64 {
+	return s.sectorSize
+}
+
+// crypt encrypts or decrypts whole sectors in place, the first of which has the sector number
+func (s *Storage) crypt(b []byte, sector int64, encrypt bool) {
+	var t, x [aes.BlockSize]byte
+	for ; len(b) > 0; b, sector = b[s.sectorSize:], sector+1 {
+		iv := uint64(sector)
+		if !s.largeIV {
+			iv *= uint64(s.sectorSize / ivSectorSize)
+		}
+		iv += s.ivOffset
+		clear(t[:])
+		if s.plain {
+			binary.LittleEndian.PutUint32(t[:], uint32(iv))
+		} else {
+			binary.LittleEndian.PutUint64(t[:], iv)
+		}
+		if s.essiv != nil {
+			s.essiv.Encrypt(t[:], t[:])
+		}
+		sectorData := b[:s.sectorSize]
+		if s.tweak == nil {
+			if encrypt {
+				cipher.NewCBCEncrypter(s.data, t[:]).CryptBlocks(sectorData, sectorData)
+			} else {
+				cipher.NewCBCDecrypter(s.data, t[:]).CryptBlocks(sectorData, sectorData)
+			}
+			continue
+		}
+		s.tweak.Encrypt(t[:], t[:])
+		for block := sectorData; len(block) > 0; block = block[aes.BlockSize:] {
+			for i := range x {
+				x[i] = block[i] ^ t[i]
+			}
+			if encrypt {
+				s.data.Encrypt(x[:], x[:])
+			} else {
+				s.data.Decrypt(x[:], x[:])
+			}
+			for i := range x {
+				block[i] = x[i] ^ t[i]
+			}
+			// the tweak of the next block is multiplied by x in GF(2^128)
+			carry := t[aes.BlockSize-1] >> 7
+			for i := aes.BlockSize - 1; i > 0; i-- {
+				t[i] = t[i]<<1 | t[i-1]>>7
+			}
+			t[0] <<= 1
+			if carry != 0 {
+				t[0] ^= 0x87
+			}
+		}
+	}
+}
+
+// readSectors reads and decrypts whole sectors starting with the sector number
+func (s *Storage) readSectors(b []byte, sector int64) error {
+	offset := s.start + sector*s.sectorSize
+	n, err := s.storage.ReadAt(b, offset)
+	if n != len(b) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("could not read %d bytes at %d: %w", len(b), offset, err)
+	}
+	s.crypt(b, sector, false)
+	return nil
+}
+
+// ReadAt reads and decrypts the sectors of the range
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > s.size {
+		p = p[:s.size-off]
+		eof = io.EOF
+	}
+	first := off / s.sectorSize
+	end := (off + int64(len(p)) + s.sectorSize - 1) / s.sectorSize
+	b := make([]byte, (end-first)*s.sectorSize)
+	if err := s.readSectors(b, first); err != nil {
+		return 0, err
+	}
+	return copy(p, b[off-first*s.sectorSize:]), eof
+}
+
+// WriteAt encrypts and writes the sectors of the range, reading those written in part first
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	if s.rw == nil {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the disk of size %d", len(p), off, s.size)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	first := off / s.sectorSize
+	end := (off + int64(len(p)) + s.sectorSize - 1) / s.sectorSize
+	b := make([]byte, (end-first)*s.sectorSize)
+	// the first and last sectors are only written in part if the range is not aligned
+	partialFirst := off%s.sectorSize != 0
+	if partialFirst {
+		if err := s.readSectors(b[:s.sectorSize], first); err != nil {
+			return 0, err
+		}
+	}
+	if (off+int64(len(p)))%s.sectorSize != 0 && (end-1 != first || !partialFirst) {
+		if err := s.readSectors(b[len(b)-int(s.sectorSize):], end-1); err != nil {
+			return 0, err
+		}
+	}
+	copy(b[off-first*s.sectorSize:], p)
+	s.crypt(b, first, true)
+	offset := s.start + first*s.sectorSize
+	n, err := s.rw.WriteAt(b, offset)
+	if err != nil {
+		return 0, fmt.Errorf("could not write %d bytes at %d: %w", len(b), offset, err)
+	}
+	if n != len(b) {
+		return 0, fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return len(p), nil
+}
+
+// Stat describes the storage, with the size of the decrypted disk
+func (s *Storage) Stat() (fs.FileInfo, error) {
+	info, err := s.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &cryptInfo{FileInfo: info, size: s.size}, nil
+}
+
+// Read reads from the disk at the current offset
+func (s *Storage) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+	n, err := s.ReadAt(b, offset)
+	s.mu.Lock()
+	s.offset = offset + int64(n)
+	s.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (s *Storage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return s.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Close closes the storage
+func (s *Storage) Close() error {
+	return s.storage.Close()
+}
+
+// Sync syncs the storage
+func (s *Storage) Sync() error {
+	return backend.Sync(s.storage)
+}
+
+// Sys is not suitable, as reads and writes through the file would bypass the encryption
+func (s *Storage) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the storage itself, unless it is read-only
+func (s *Storage) Writable() (backend.WritableFile, error) {
+	if s.rw == nil {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return s, nil
+}
+
+// cryptInfo reports the size of the decrypted disk
+type cryptInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *cryptInfo) Size() int64 {
+	return i.size
+}
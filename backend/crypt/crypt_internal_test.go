@@ -0,0 +1,104 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+// TestXTSVectors checks the start of sectors against the test vectors of IEEE 1619, whose data
+// units are shorter than a sector, but encrypted the same way
+func TestXTSVectors(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		sector     uint64
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			"vector 1",
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			0,
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e",
+		},
+		{
+			"vector 2",
+			"1111111111111111111111111111111122222222222222222222222222222222",
+			0x3333333333,
+			"4444444444444444444444444444444444444444444444444444444444444444",
+			"c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0",
+		},
+		{
+			"vector 4",
+			"2718281828459045235360287471352631415926535897932384626433832795",
+			0,
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			"27a7479befa1d476489f308cd4cfa6e2a96e4bbe3208ff25287dd3819616e89c",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+			plaintext, _ := hex.DecodeString(tt.plaintext)
+			ciphertext, _ := hex.DecodeString(tt.ciphertext)
+			s, err := New(mem.New(make([]byte, 512), false), key, WithIVOffset(tt.sector))
+			if err != nil {
+				t.Fatalf("error creating storage: %v", err)
+			}
+			b := make([]byte, 512)
+			copy(b, plaintext)
+			s.crypt(b, 0, true)
+			if !bytes.Equal(b[:len(ciphertext)], ciphertext) {
+				t.Errorf("ciphertext %x instead of %x", b[:len(ciphertext)], ciphertext)
+			}
+			s.crypt(b, 0, false)
+			if !bytes.Equal(b[:len(plaintext)], plaintext) {
+				t.Errorf("decrypted %x instead of %x", b[:len(plaintext)], plaintext)
+			}
+		})
+	}
+}
+
+// TestCBCVectors checks the start of a sector encrypted with AES-256-CBC against openssl, with
+// the IVs computed as dm-crypt does
+func TestCBCVectors(t *testing.T) {
+	tests := []struct {
+		cipher     string
+		sector     uint64
+		ciphertext string
+	}{
+		{"aes-cbc-plain64", 5, "c3717b69935f0067735f7c4273f2650b28467068a653e0b8c7356ebeae9789e8"},
+		{"aes-cbc-plain", 0x100000005, "c3717b69935f0067735f7c4273f2650b28467068a653e0b8c7356ebeae9789e8"},
+		{"aes-cbc-essiv:sha256", 5, "48c016b397feb53b0a508d772a0084ecde018ddb544876d2bccb55ea791ac980"},
+	}
+	key := make([]byte, 32)
+	plaintext := make([]byte, 512)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range plaintext {
+		plaintext[i] = byte(i % 251)
+	}
+	for _, tt := range tests {
+		t.Run(tt.cipher, func(t *testing.T) {
+			ciphertext, _ := hex.DecodeString(tt.ciphertext)
+			s, err := New(mem.New(make([]byte, 512), false), key, WithCipher(tt.cipher), WithIVOffset(tt.sector))
+			if err != nil {
+				t.Fatalf("error creating storage: %v", err)
+			}
+			b := bytes.Clone(plaintext)
+			s.crypt(b, 0, true)
+			if !bytes.Equal(b[:len(ciphertext)], ciphertext) {
+				t.Errorf("ciphertext %x instead of %x", b[:len(ciphertext)], ciphertext)
+			}
+			s.crypt(b, 0, false)
+			if !bytes.Equal(b, plaintext) {
+				t.Errorf("decrypted %x instead of %x", b[:32], plaintext[:32])
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package crypt_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/crypt"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestCrypt(t *testing.T) {
+	key := make([]byte, 64)
+	_, _ = rand.Read(key)
+	tests := []struct {
+		name    string
+		keySize int
+		opts    []crypt.Opt
+	}{
+		{"default", 64, nil},
+		{"4096 byte sectors", 64, []crypt.Opt{crypt.WithSectorSize(4096), crypt.WithLargeSectorIV()}},
+		{"offset", 64, []crypt.Opt{crypt.WithOffset(2048), crypt.WithIVOffset(100)}},
+		{"xts plain", 32, []crypt.Opt{crypt.WithCipher("aes-xts-plain")}},
+		{"cbc essiv", 32, []crypt.Opt{crypt.WithCipher("aes-cbc-essiv:sha256"), crypt.WithSectorSize(4096)}},
+		{"cbc plain64", 16, []crypt.Opt{crypt.WithCipher("aes-cbc-plain64")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := key[:tt.keySize]
+			m := mem.New(make([]byte, 64*1024+2048), false)
+			s, err := crypt.New(m, key, tt.opts...)
+			if err != nil {
+				t.Fatalf("error creating storage: %v", err)
+			}
+			expected := make([]byte, s.Size())
+			writes := []struct {
+				offset int64
+				length int
+			}{
+				{0, int(s.Size())},
+				{100, 10},
+				{4000, 9000},
+				{8192, 4096},
+				{s.Size() - 3, 3},
+			}
+			for _, w := range writes {
+				data := make([]byte, w.length)
+				_, _ = rand.Read(data)
+				if _, err := s.WriteAt(data, w.offset); err != nil {
+					t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+				}
+				copy(expected[w.offset:], data)
+			}
+			all, err := io.ReadAll(s)
+			if err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(all, expected) {
+				t.Errorf("contents do not match")
+			}
+			b := make([]byte, 5000)
+			if _, err := s.ReadAt(b, 3333); err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(b, expected[3333:3333+5000]) {
+				t.Errorf("contents of unaligned read do not match")
+			}
+			if bytes.Contains(m.Bytes(), expected[4000:4100]) {
+				t.Errorf("plaintext found in storage")
+			}
+
+			// another key reads garbage
+			other := append([]byte(nil), key...)
+			other[0] ^= 1
+			s, err = crypt.New(m, other, tt.opts...)
+			if err != nil {
+				t.Fatalf("error creating storage: %v", err)
+			}
+			if _, err := s.ReadAt(b, 3333); err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if bytes.Equal(b, expected[3333:3333+5000]) {
+				t.Errorf("contents read with wrong key")
+			}
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	m := mem.New(make([]byte, 4096), true)
+	if _, err := crypt.New(m, make([]byte, 16)); err == nil {
+		t.Errorf("no error with key of invalid size")
+	}
+	if _, err := crypt.New(m, make([]byte, 64), crypt.WithCipher("aes-cbc-plain64")); err == nil {
+		t.Errorf("no error with key of invalid size for CBC")
+	}
+	if _, err := crypt.New(m, make([]byte, 32), crypt.WithCipher("serpent-xts-plain64")); err == nil {
+		t.Errorf("no error with unsupported cipher")
+	}
+	if _, err := crypt.New(m, make([]byte, 32), crypt.WithSectorSize(1000)); err == nil {
+		t.Errorf("no error with invalid sector size")
+	}
+	if _, err := crypt.New(m, make([]byte, 32), crypt.WithOffset(8192)); err == nil {
+		t.Errorf("no error with offset beyond the end")
+	}
+	s, err := crypt.New(m, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	if _, err := s.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only storage")
+	}
+	if _, err := s.WriteAt(make([]byte, 10), 4090); err == nil {
+		t.Errorf("no error writing read-only storage")
+	}
+}
+
+func TestDisk(t *testing.T) {
+	key := make([]byte, 64)
+	_, _ = rand.Read(key)
+	p := filepath.Join(t.TempDir(), "encrypted.img")
+	s, err := crypt.CreateFromPath(p, 40*1024*1024, key)
+	if err != nil {
+		t.Fatalf("error creating image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("secret contents of an encrypted disk")
+	f, err := fs.OpenFile("/secret.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, content) || raw[510] == 0x55 && raw[511] == 0xaa {
+		t.Errorf("image is not encrypted")
+	}
+
+	s, err = crypt.OpenFromPath(p, key, true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(s)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/secret.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	image := make([]byte, 40*1024*1024)
+	d, err := diskfs.OpenBackend(mem.New(image, false), diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 2048, Type: mbr.Linux},
+			{Start: 4096, Size: 75000, Type: mbr.Linux},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	s, err := crypt.NewPartition(d, 2, key, crypt.WithCipher("aes-cbc-essiv:sha256"))
+	if err != nil {
+		t.Fatalf("error encrypting partition: %v", err)
+	}
+	if s.Size() != 75000*512 {
+		t.Errorf("size %d instead of the size of the partition", s.Size())
+	}
+	encrypted, err := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening encrypted partition: %v", err)
+	}
+	if _, err := encrypted.CreateFilesystem(disk.FormatSpec{FSType: filesystem.TypeFat32, VolumeLabel: "SECRET"}); err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if bytes.Contains(image[4096*512:], []byte("SECRET")) {
+		t.Errorf("partition is not encrypted")
+	}
+
+	// the partition table is still readable, the partition only with the key
+	d, err = diskfs.OpenBackend(mem.New(image, true))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	if _, err := d.GetFilesystem(2); err == nil {
+		t.Errorf("filesystem of the encrypted partition read without the key")
+	}
+	if s, err = crypt.NewPartition(d, 2, key, crypt.WithCipher("aes-cbc-essiv:sha256")); err != nil {
+		t.Fatalf("error opening encrypted partition: %v", err)
+	}
+	if encrypted, err = diskfs.OpenBackend(s); err != nil {
+		t.Fatalf("error opening encrypted partition: %v", err)
+	}
+	fs, err := encrypted.GetFilesystem(0)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if label := fs.Label(); label != "SECRET" {
+		t.Errorf("label %q instead of SECRET", label)
+	}
+}
@@ -0,0 +1,59 @@
+package dmg
+
+import (
+	"errors"
+	"fmt"
+)
+
+// decodeADC decompresses Apple Data Compression, an LZ77 variant of old images, into dst,
+// returning the number of bytes decompressed
+func decodeADC(dst, src []byte) (int, error) {
+	out := 0
+	for in := 0; in < len(src); {
+		b := src[in]
+		var length, distance int
+		switch {
+		case b&0x80 != 0:
+			// literal run
+			length = int(b&0x7f) + 1
+			if in+1+length > len(src) {
+				return out, errors.New("truncated ADC literal")
+			}
+			if out+length > len(dst) {
+				return out, errors.New("ADC data larger than chunk")
+			}
+			copy(dst[out:], src[in+1:in+1+length])
+			in += 1 + length
+			out += length
+			continue
+		case b&0x40 != 0:
+			// three byte match
+			if in+3 > len(src) {
+				return out, errors.New("truncated ADC match")
+			}
+			length = int(b&0x3f) + 4
+			distance = (int(src[in+1])<<8 | int(src[in+2])) + 1
+			in += 3
+		default:
+			// two byte match
+			if in+2 > len(src) {
+				return out, errors.New("truncated ADC match")
+			}
+			length = int(b&0x3f)>>2 + 3
+			distance = (int(b&0x3)<<8 | int(src[in+1])) + 1
+			in += 2
+		}
+		if distance > out {
+			return out, fmt.Errorf("invalid ADC match distance %d at %d", distance, out)
+		}
+		if out+length > len(dst) {
+			return out, errors.New("ADC data larger than chunk")
+		}
+		// matches may overlap the output being written
+		for i := 0; i < length; i++ {
+			dst[out+i] = dst[out-distance+i]
+		}
+		out += length
+	}
+	return out, nil
+}
@@ -0,0 +1,388 @@
+// Package dmg provides a read-only backend for Apple UDIF disk images, the .dmg files of macOS.
+//
+// A UDIF image is a data fork holding the disk in chunks, which may be stored, zeroes, or compressed with
+// ADC, zlib, bzip2, LZFSE or LZMA, followed by a property list that maps the chunks to the sectors of
+// the disk, and a koly trailer locating both. Image presents the expanded disk, whose partitions and
+// filesystems can then be opened as with any other backend.
+package dmg
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/ulikunitz/xz"
+)
+
+// chunk is a chunk of the data fork, mapped into the expanded image
+type chunk struct {
+	entryType uint32
+	// sector is the first sector of the expanded image covered by the chunk
+	sector  uint64
+	sectors uint64
+	// offset and length are the position of the data of the chunk in the image file
+	offset int64
+	length int64
+}
+
+// Image is a UDIF image presented as a read-only backend.Storage of the size of the expanded image
+type Image struct {
+	storage backend.Storage
+	trailer *trailer
+	chunks  []chunk
+	size    int64
+
+	mu sync.Mutex
+	// the last chunk decompressed, as reads are usually sequential
+	cached     int
+	cachedData []byte
+	offset     int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the UDIF image stored in the provided backend.Storage.
+// UDIF images can only be opened read-only.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	if !readOnly {
+		return nil, errors.New("UDIF images can only be opened read-only")
+	}
+	img := &Image{
+		storage: b,
+		cached:  -1,
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing UDIF image file, which can only be opened read-only
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	if !readOnly {
+		return nil, errors.New("UDIF images can only be opened read-only")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, true), true)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open UDIF image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init() error {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat image: %w", err)
+	}
+	fileSize := info.Size()
+	if fileSize < trailerSize {
+		return fmt.Errorf("image of %d bytes is too small to be a UDIF image", fileSize)
+	}
+	b := make([]byte, trailerSize)
+	if err := img.readFull(b, fileSize-trailerSize); err != nil {
+		return fmt.Errorf("error reading UDIF trailer: %w", err)
+	}
+	t, err := trailerFromBytes(b)
+	if err != nil {
+		return err
+	}
+	img.trailer = t
+	if t.xmlOffset+t.xmlLength > uint64(fileSize) {
+		return fmt.Errorf("property list at %d of %d bytes is beyond the end of the image", t.xmlOffset, t.xmlLength)
+	}
+	xml := make([]byte, t.xmlLength)
+	if err := img.readFull(xml, int64(t.xmlOffset)); err != nil {
+		return fmt.Errorf("error reading property list: %w", err)
+	}
+	tables, err := blkxTables(xml)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		for i, c := range table.chunks {
+			switch c.entryType {
+			case chunkComment, chunkTerminator:
+				continue
+			case chunkZeroFill, chunkIgnore, chunkRaw, chunkADC, chunkZlib, chunkBzip2, chunkLZFSE, chunkLZMA:
+			default:
+				return fmt.Errorf("unknown type %#x of chunk %d at sector %d", c.entryType, i, table.sectorNumber)
+			}
+			if c.sectorCount == 0 {
+				continue
+			}
+			entry := chunk{
+				entryType: c.entryType,
+				sector:    table.sectorNumber + c.sectorNumber,
+				sectors:   c.sectorCount,
+				offset:    int64(t.dataForkOffset + table.dataOffset + c.compressedOffset),
+				length:    int64(c.compressedLength),
+			}
+			if entry.entryType != chunkZeroFill && entry.entryType != chunkIgnore && entry.offset+entry.length > fileSize {
+				return fmt.Errorf("chunk %d at sector %d is beyond the end of the image", i, entry.sector)
+			}
+			img.chunks = append(img.chunks, entry)
+		}
+	}
+	sort.Slice(img.chunks, func(i, j int) bool {
+		return img.chunks[i].sector < img.chunks[j].sector
+	})
+	// sectors not covered by any chunk read as zero, but chunks may not overlap
+	var end uint64
+	for _, c := range img.chunks {
+		if c.sector < end {
+			return fmt.Errorf("chunk at sector %d overlaps the previous chunk", c.sector)
+		}
+		end = c.sector + c.sectors
+	}
+	img.size = int64(max(end, t.sectorCount)) * sectorSize
+	return nil
+}
+
+// blkxTables finds the block tables of the partitions in the property list
+func blkxTables(b []byte) ([]*blkxTable, error) {
+	p, err := parsePlist(b)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := p.(map[string]any)
+	if !ok {
+		return nil, errors.New("property list is not a dict")
+	}
+	resources, ok := root["resource-fork"].(map[string]any)
+	if !ok {
+		return nil, errors.New("property list has no resource-fork")
+	}
+	blkx, ok := resources["blkx"].([]any)
+	if !ok {
+		return nil, errors.New("property list has no blkx resources")
+	}
+	tables := make([]*blkxTable, 0, len(blkx))
+	for i, r := range blkx {
+		resource, ok := r.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("blkx resource %d is not a dict", i)
+		}
+		data, ok := resource["Data"].([]byte)
+		if !ok {
+			return nil, fmt.Errorf("blkx resource %d has no data", i)
+		}
+		t, err := blkxTableFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blkx resource %d: %w", i, err)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, failing on a truncated image
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Size is the size of the expanded image
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ReadAt reads from the expanded image, decompressing the chunks read
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		// the first chunk ending after the offset, which may start after it in gaps between chunks
+		i := sort.Search(len(img.chunks), func(i int) bool {
+			return int64(img.chunks[i].sector+img.chunks[i].sectors)*sectorSize > pos
+		})
+		if i == len(img.chunks) || int64(img.chunks[i].sector)*sectorSize > pos {
+			next := size
+			if i < len(img.chunks) {
+				next = int64(img.chunks[i].sector) * sectorSize
+			}
+			n := int(min(int64(len(p)-read), next-pos))
+			clear(p[read : read+n])
+			read += n
+			continue
+		}
+		c := img.chunks[i]
+		inChunk := pos - int64(c.sector)*sectorSize
+		n := int(min(int64(len(p)-read), int64(c.sectors)*sectorSize-inChunk))
+		b := p[read : read+n]
+		switch c.entryType {
+		case chunkRaw:
+			if err := img.readFull(b, c.offset+inChunk); err != nil {
+				return read, fmt.Errorf("error reading raw chunk at sector %d: %w", c.sector, err)
+			}
+		case chunkZeroFill, chunkIgnore:
+			clear(b)
+		default:
+			data, err := img.chunkData(i)
+			if err != nil {
+				return read, err
+			}
+			copy(b, data[inChunk:])
+		}
+		read += n
+	}
+	return read, eof
+}
+
+// chunkData returns the data of the compressed chunk i, keeping the last one decompressed.
+// It must be called with mu held.
+func (img *Image) chunkData(i int) ([]byte, error) {
+	if img.cached == i {
+		return img.cachedData, nil
+	}
+	c := img.chunks[i]
+	src := make([]byte, c.length)
+	if err := img.readFull(src, c.offset); err != nil {
+		return nil, fmt.Errorf("error reading chunk at sector %d: %w", c.sector, err)
+	}
+	size := int(c.sectors * sectorSize)
+	dst := img.cachedData
+	if cap(dst) < size {
+		dst = make([]byte, size)
+	}
+	dst = dst[:size]
+	// mark the buffer as not holding a chunk until it is decompressed
+	img.cached = -1
+	img.cachedData = dst
+
+	var (
+		n   int
+		err error
+	)
+	switch c.entryType {
+	case chunkADC:
+		n, err = decodeADC(dst, src)
+	case chunkLZFSE:
+		n, err = decodeLZFSE(dst, src)
+	case chunkZlib:
+		var r io.ReadCloser
+		if r, err = zlib.NewReader(bytes.NewReader(src)); err == nil {
+			n, err = io.ReadFull(r, dst)
+			r.Close()
+		}
+	case chunkBzip2:
+		n, err = io.ReadFull(bzip2.NewReader(bytes.NewReader(src)), dst)
+	case chunkLZMA:
+		var r *xz.Reader
+		if r, err = xz.NewReader(bytes.NewReader(src)); err == nil {
+			n, err = io.ReadFull(r, dst)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing chunk at sector %d: %w", c.sector, err)
+	}
+	if n != size {
+		return nil, fmt.Errorf("chunk at sector %d decompressed to %d bytes instead of %d", c.sector, n, size)
+	}
+	img.cached = i
+	return dst, nil
+}
+
+// Stat returns the file info of the image, but with the size of the expanded image
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the expanded image at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	offset := img.offset
+	img.mu.Unlock()
+	n, err := img.ReadAt(b, offset)
+	img.mu.Lock()
+	img.offset = offset + int64(n)
+	img.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the expanded image
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for UDIF images, as ioctls on the image file do not apply to the expanded image
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable is not possible for UDIF images, which are always read-only
+func (img *Image) Writable() (backend.WritableFile, error) {
+	return nil, backend.ErrIncorrectOpenMode
+}
+
+// imageInfo reports the size of the expanded image instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,466 @@
+package dmg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// lz builds the expected output of LZ77 streams
+type lz []byte
+
+func (b *lz) lit(s string) []byte {
+	*b = append(*b, s...)
+	return []byte(s)
+}
+
+func (b *lz) match(m, d int) {
+	for i := 0; i < m; i++ {
+		*b = append(*b, (*b)[len(*b)-d])
+	}
+}
+
+func TestPlist(t *testing.T) {
+	p, err := parsePlist([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>resource-fork</key>
+	<dict>
+		<key>blkx</key>
+		<array>
+			<dict>
+				<key>Attributes</key>
+				<string>0x0050</string>
+				<key>Data</key>
+				<data>
+				aGVsbG8s
+				IHdvcmxk
+				</data>
+				<key>ID</key>
+				<integer>-1</integer>
+				<key>Hidden</key>
+				<true/>
+			</dict>
+		</array>
+	</dict>
+</dict>
+</plist>`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	blkx := p.(map[string]any)["resource-fork"].(map[string]any)["blkx"].([]any)
+	if len(blkx) != 1 {
+		t.Fatalf("%d blkx resources instead of 1", len(blkx))
+	}
+	r := blkx[0].(map[string]any)
+	if data := r["Data"].([]byte); string(data) != "hello, world" {
+		t.Errorf("data %q instead of %q", data, "hello, world")
+	}
+	if id := r["ID"].(int64); id != -1 {
+		t.Errorf("ID %d instead of -1", id)
+	}
+	if attrs := r["Attributes"].(string); attrs != "0x0050" {
+		t.Errorf("attributes %q instead of 0x0050", attrs)
+	}
+	if hidden := r["Hidden"].(bool); !hidden {
+		t.Errorf("hidden is false")
+	}
+
+	for _, invalid := range []string{
+		`<dict></dict>`,
+		`<plist><dict><key>a</key></dict></plist>`,
+		`<plist><dict><string>a</string><string>b</string></dict></plist>`,
+		`<plist><integer>x</integer></plist>`,
+		`<plist><array>`,
+	} {
+		if _, err := parsePlist([]byte(invalid)); err == nil {
+			t.Errorf("no error parsing %s", invalid)
+		}
+	}
+}
+
+func TestADC(t *testing.T) {
+	var expected lz
+	src := append([]byte{0x83}, expected.lit("abcd")...)
+	// two byte match of 8 at distance 4
+	src = append(src, 0x14, 0x03)
+	expected.match(8, 4)
+	// three byte match of 20 at distance 12
+	src = append(src, 0x50, 0x00, 0x0b)
+	expected.match(20, 12)
+	src = append(src, 0x81)
+	src = append(src, expected.lit("xy")...)
+
+	dst := make([]byte, len(expected))
+	n, err := decodeADC(dst, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst[:n], expected) {
+		t.Errorf("decoded %q instead of %q", dst[:n], expected)
+	}
+	if _, err := decodeADC(dst, []byte{0x14, 0x03}); err == nil {
+		t.Errorf("no error for match before the start of the data")
+	}
+	if _, err := decodeADC(make([]byte, 2), src); err == nil {
+		t.Errorf("no error for data larger than the chunk")
+	}
+}
+
+// lzvnTest returns an LZVN payload using all kinds of opcodes, and its decompressed data
+func lzvnTest() (src, expected []byte) {
+	var e lz
+	// small literal
+	src = append([]byte{0xe4}, e.lit("abcd")...)
+	// small distance, L 0, M 8, D 4
+	src = append(src, 0x28, 0x04)
+	e.match(8, 4)
+	// small match at the previous distance
+	src = append(src, 0xf3)
+	e.match(3, 4)
+	// medium distance, L 1, M 10, D 15
+	src = append(src, 0xa9, 0x3f, 0x00)
+	src = append(src, e.lit("x")...)
+	e.match(10, 15)
+	// nop
+	src = append(src, 0x0e)
+	// large distance, L 0, M 4, D 16
+	src = append(src, 0x0f, 0x10, 0x00)
+	e.match(4, 16)
+	// large literal of 20
+	src = append(src, 0xe0, 0x04)
+	src = append(src, e.lit("0123456789abcdefghij")...)
+	// large match of 16 at the previous distance
+	src = append(src, 0xf0, 0x00)
+	e.match(16, 16)
+	// previous distance, L 2, M 3
+	src = append(src, 0x86)
+	src = append(src, e.lit("yz")...)
+	e.match(3, 16)
+	// end of stream
+	src = append(src, 0x06, 0, 0, 0, 0, 0, 0, 0)
+	return src, e
+}
+
+func TestLZVN(t *testing.T) {
+	src, expected := lzvnTest()
+	dst := make([]byte, len(expected))
+	n, err := decodeLZVN(dst, 0, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst[:n], expected) {
+		t.Errorf("decoded %q instead of %q", dst[:n], expected)
+	}
+	for _, invalid := range [][]byte{
+		{0x1e},
+		{0x70},
+		{0xd0},
+		{0xe4, 'a'},
+		{0xe1, 'a', 0xf1},
+		{0xe1, 'a', 0x28, 0x04},
+	} {
+		if _, err := decodeLZVN(dst, 0, invalid); !errors.Is(err, errLZFSE) {
+			t.Errorf("error %v instead of invalid LZFSE data for % x", err, invalid)
+		}
+	}
+}
+
+// fseEncoder encodes symbols in the reverse of the order they are decoded
+type fseEncoder struct {
+	nstates int
+	s0      []int
+	k       []int
+	delta0  []int
+	delta1  []int
+}
+
+func newFSEEncoder(nstates int, freq []uint16) *fseEncoder {
+	e := &fseEncoder{
+		nstates: nstates,
+		s0:      make([]int, len(freq)),
+		k:       make([]int, len(freq)),
+		delta0:  make([]int, len(freq)),
+		delta1:  make([]int, len(freq)),
+	}
+	offset := 0
+	for i, fr := range freq {
+		f := int(fr)
+		if f == 0 {
+			continue
+		}
+		k := 0
+		for f<<k < nstates {
+			k++
+		}
+		e.s0[i] = (f << k) - nstates
+		e.k[i] = k
+		e.delta0[i] = offset - f + (nstates >> k)
+		if k > 0 {
+			e.delta1[i] = offset - f + (nstates >> (k - 1))
+		}
+		offset += f
+	}
+	return e
+}
+
+func (e *fseEncoder) encode(state *int, symbol int, out *fseOutStream) {
+	s := *state
+	nbits, delta := e.k[symbol]-1, e.delta1[symbol]
+	if s >= e.s0[symbol] {
+		nbits, delta = e.k[symbol], e.delta0[symbol]
+	}
+	out.push(nbits, uint64(s)&(1<<nbits-1))
+	*state = delta + s>>nbits
+}
+
+// fseOutStream writes bits forwards, to be read backwards by fseInStream
+type fseOutStream struct {
+	b         []byte
+	accum     uint64
+	accumBits int
+}
+
+func (s *fseOutStream) push(n int, b uint64) {
+	s.accum |= b << s.accumBits
+	s.accumBits += n
+}
+
+func (s *fseOutStream) flush() {
+	for s.accumBits >= 8 {
+		s.b = append(s.b, byte(s.accum))
+		s.accum >>= 8
+		s.accumBits -= 8
+	}
+}
+
+// finish writes the remaining bits, returning the number of bits of the last byte not used, as -7..0
+func (s *fseOutStream) finish() int32 {
+	s.flush()
+	if s.accumBits == 0 {
+		return 0
+	}
+	s.b = append(s.b, byte(s.accum))
+	bits := int32(s.accumBits - 8)
+	s.accum, s.accumBits = 0, 0
+	return bits
+}
+
+// normalize scales the counts of symbols to frequencies that add up to nstates
+func normalize(counts []int, nstates int) []uint16 {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	freq := make([]uint16, len(counts))
+	sum, largest := 0, 0
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		freq[i] = uint16(max(1, c*nstates/total))
+		sum += int(freq[i])
+		if freq[i] > freq[largest] {
+			largest = i
+		}
+	}
+	freq[largest] = uint16(int(freq[largest]) + nstates - sum)
+	return freq
+}
+
+func valueSymbol(v int32, extraBits []uint8, baseValue []int32) int {
+	for i := len(baseValue) - 1; i >= 0; i-- {
+		if v >= baseValue[i] && v-baseValue[i] < 1<<extraBits[i] {
+			return i
+		}
+	}
+	panic("value out of range")
+}
+
+type lmd struct {
+	l, m, d int32
+}
+
+// lzfseEncodeBlock encodes the literals and matches as a compressed block, with a v1 or v2 header
+func lzfseEncodeBlock(literals []byte, matches []lmd, rawBytes int, v2 bool) []byte {
+	for len(literals)%4 != 0 {
+		literals = append(literals, 0)
+	}
+	h := lzfseBlock{
+		nRawBytes: uint32(rawBytes),
+		nLiterals: uint32(len(literals)),
+		nMatches:  uint32(len(matches)),
+	}
+	lCounts := make([]int, lzfseLSymbols)
+	mCounts := make([]int, lzfseMSymbols)
+	dCounts := make([]int, lzfseDSymbols)
+	literalCounts := make([]int, lzfseLiteralSymbols)
+	for _, c := range literals {
+		literalCounts[c]++
+	}
+	for _, x := range matches {
+		lCounts[valueSymbol(x.l, lzfseLExtraBits[:], lzfseLBaseValue[:])]++
+		mCounts[valueSymbol(x.m, lzfseMExtraBits[:], lzfseMBaseValue[:])]++
+		dCounts[valueSymbol(x.d, lzfseDExtraBits[:], lzfseDBaseValue[:])]++
+	}
+	lFreq := normalize(lCounts, lzfseLStates)
+	mFreq := normalize(mCounts, lzfseMStates)
+	dFreq := normalize(dCounts, lzfseDStates)
+	literalFreq := normalize(literalCounts, lzfseLiteralStates)
+	copy(h.freq[:], lFreq)
+	copy(h.freq[lzfseLSymbols:], mFreq)
+	copy(h.freq[lzfseLSymbols+lzfseMSymbols:], dFreq)
+	copy(h.freq[lzfseLSymbols+lzfseMSymbols+lzfseDSymbols:], literalFreq)
+
+	var literalOut fseOutStream
+	literalEncoder := newFSEEncoder(lzfseLiteralStates, literalFreq)
+	var states [4]int
+	for i := len(literals); i > 0; i -= 4 {
+		for j := 3; j >= 0; j-- {
+			literalEncoder.encode(&states[j], int(literals[i-4+j]), &literalOut)
+		}
+		literalOut.flush()
+	}
+	h.literalBits = literalOut.finish()
+	for i, s := range states {
+		h.literalState[i] = uint16(s)
+	}
+
+	var lmdOut fseOutStream
+	encoders := []*fseEncoder{
+		newFSEEncoder(lzfseLStates, lFreq), newFSEEncoder(lzfseMStates, mFreq), newFSEEncoder(lzfseDStates, dFreq),
+	}
+	extraBits := [][]uint8{lzfseLExtraBits[:], lzfseMExtraBits[:], lzfseDExtraBits[:]}
+	baseValue := [][]int32{lzfseLBaseValue[:], lzfseMBaseValue[:], lzfseDBaseValue[:]}
+	var lmdStates [3]int
+	for i := len(matches) - 1; i >= 0; i-- {
+		values := []int32{matches[i].l, matches[i].m, matches[i].d}
+		for j := 2; j >= 0; j-- {
+			symbol := valueSymbol(values[j], extraBits[j], baseValue[j])
+			lmdOut.push(int(extraBits[j][symbol]), uint64(values[j]-baseValue[j][symbol]))
+			encoders[j].encode(&lmdStates[j], symbol, &lmdOut)
+		}
+		lmdOut.flush()
+	}
+	h.lmdBits = lmdOut.finish()
+	h.lState, h.mState, h.dState = uint16(lmdStates[0]), uint16(lmdStates[1]), uint16(lmdStates[2])
+	h.nLiteralPayloadBytes = uint32(len(literalOut.b))
+	h.nLMDPayloadBytes = uint32(len(lmdOut.b))
+
+	var b []byte
+	if v2 {
+		var freqOut fseOutStream
+		for _, f := range h.freq {
+			switch {
+			case f == 0:
+				freqOut.push(2, 0)
+			case f == 1:
+				freqOut.push(2, 2)
+			case f == 2:
+				freqOut.push(3, 1)
+			case f == 3:
+				freqOut.push(3, 5)
+			case f < 8:
+				freqOut.push(5, uint64(f-4)<<3|3)
+			case f < 24:
+				freqOut.push(8, uint64(f-8)<<4|7)
+			default:
+				freqOut.push(14, uint64(f-24)<<4|15)
+			}
+			freqOut.flush()
+		}
+		freqOut.finish()
+		headerSize := lzfseV2HeaderSize + len(freqOut.b)
+		b = binary.LittleEndian.AppendUint32([]byte(lzfseCompressedV2), h.nRawBytes)
+		b = binary.LittleEndian.AppendUint64(b, uint64(h.nLiterals)|uint64(h.nLiteralPayloadBytes)<<20|
+			uint64(h.nMatches)<<40|uint64(h.literalBits+7)<<60)
+		b = binary.LittleEndian.AppendUint64(b, uint64(h.literalState[0])|uint64(h.literalState[1])<<10|
+			uint64(h.literalState[2])<<20|uint64(h.literalState[3])<<30|uint64(h.nLMDPayloadBytes)<<40|uint64(h.lmdBits+7)<<60)
+		b = binary.LittleEndian.AppendUint64(b, uint64(headerSize)|uint64(h.lState)<<32|
+			uint64(h.mState)<<42|uint64(h.dState)<<52)
+		b = append(b, freqOut.b...)
+	} else {
+		b = []byte(lzfseCompressedV1)
+		for _, v := range []uint32{
+			h.nRawBytes, h.nLiteralPayloadBytes + h.nLMDPayloadBytes, h.nLiterals, h.nMatches,
+			h.nLiteralPayloadBytes, h.nLMDPayloadBytes, uint32(h.literalBits),
+		} {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+		for _, s := range h.literalState {
+			b = binary.LittleEndian.AppendUint16(b, s)
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(h.lmdBits))
+		for _, v := range []uint16{h.lState, h.mState, h.dState} {
+			b = binary.LittleEndian.AppendUint16(b, v)
+		}
+		for _, f := range h.freq {
+			b = binary.LittleEndian.AppendUint16(b, f)
+		}
+	}
+	b = append(b, literalOut.b...)
+	return append(b, lmdOut.b...)
+}
+
+// lzfseTestBlock returns the literals and matches of a compressed block, appending its output to e
+func lzfseTestBlock(e *lz) ([]byte, []lmd) {
+	var literals []byte
+	var matches []lmd
+	var dist int32
+	add := func(s string, m, d int32) {
+		literals = append(literals, e.lit(s)...)
+		// a distance of 0 repeats the previous distance
+		if d != 0 {
+			dist = d
+		}
+		e.match(int(m), int(dist))
+		matches = append(matches, lmd{int32(len(s)), m, d})
+	}
+	add("The quick brown fox ", 40, 20)
+	add("jumps over the lazy dog", 5, 0)
+	add("", 300, 28)
+	add(string(bytes.Repeat([]byte("0123456789"), 7)), 1000, 450)
+	add("!", 0, 0)
+	return literals, matches
+}
+
+func TestLZFSE(t *testing.T) {
+	var expected lz
+	// uncompressed block
+	src := binary.LittleEndian.AppendUint32([]byte(lzfseUncompressed), 5)
+	src = append(src, expected.lit("start")...)
+	// compressed blocks, whose matches may refer to the earlier blocks
+	for _, v2 := range []bool{false, true} {
+		start := len(expected)
+		literals, matches := lzfseTestBlock(&expected)
+		src = append(src, lzfseEncodeBlock(literals, matches, len(expected)-start, v2)...)
+	}
+	// LZVN block
+	payload, lzvnExpected := lzvnTest()
+	src = binary.LittleEndian.AppendUint32(append(src, lzfseCompressedLZVN...), uint32(len(lzvnExpected)))
+	src = binary.LittleEndian.AppendUint32(src, uint32(len(payload)))
+	src = append(src, payload...)
+	expected = append(expected, lzvnExpected...)
+	src = append(src, lzfseEndOfStream...)
+
+	dst := make([]byte, len(expected))
+	n, err := decodeLZFSE(dst, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(dst[:n], expected) {
+		t.Errorf("decoded %q instead of %q", dst[:n], expected)
+	}
+
+	if _, err := decodeLZFSE(make([]byte, len(expected)-1), src); !errors.Is(err, errLZFSE) {
+		t.Errorf("error %v instead of invalid LZFSE data for data larger than the buffer", err)
+	}
+	if _, err := decodeLZFSE(dst, src[:len(src)-4]); !errors.Is(err, errLZFSE) {
+		t.Errorf("error %v instead of invalid LZFSE data for missing end of stream", err)
+	}
+	if _, err := decodeLZFSE(dst, []byte("bvxz")); !errors.Is(err, errLZFSE) {
+		t.Errorf("error %v instead of invalid LZFSE data for unknown block", err)
+	}
+}
@@ -0,0 +1,275 @@
+package dmg_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/dmg"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2Data is the bzip2 compression of bzip2Text, as the standard library cannot compress bzip2
+const bzip2Data = "425a68393141592653592341157a000117198040001000342044102000508326204d55343d4f53885c42c42f90b10bd42ce90b50b50b50bc42fc5dc914e142408d0455e8"
+
+var bzip2Text = bytes.Repeat([]byte("bzip2 data "), 94)[:1024]
+
+// testChunk is a chunk of a test image, with its data in the data fork and the sectors it expands to
+type testChunk struct {
+	entryType uint32
+	data      []byte
+	expanded  []byte
+}
+
+// sectors pads b to whole sectors
+func sectors(b []byte) []byte {
+	return append(b, make([]byte, (512-len(b)%512)%512)...)
+}
+
+func zlibChunk(t *testing.T, b []byte) testChunk {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return testChunk{0x80000005, buf.Bytes(), b}
+}
+
+func lzmaChunk(t *testing.T, b []byte) testChunk {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return testChunk{0x80000008, buf.Bytes(), b}
+}
+
+// lzfseChunk stores b in an LZFSE stream of an uncompressed block
+func lzfseChunk(b []byte) testChunk {
+	data := binary.LittleEndian.AppendUint32([]byte("bvx-"), uint32(len(b)))
+	data = append(append(data, b...), "bvx$"...)
+	return testChunk{0x80000007, data, b}
+}
+
+// blkx returns the block table of the chunks, starting at the sector, with the data at dataOffset
+func blkx(sector uint64, dataOffset uint64, chunks []testChunk) (table, data []byte) {
+	var sectorCount uint64
+	for _, c := range chunks {
+		sectorCount += uint64(len(c.expanded) / 512)
+	}
+	table = make([]byte, 204, 204+40*(len(chunks)+2))
+	copy(table, "mish")
+	binary.BigEndian.PutUint32(table[4:], 1)
+	binary.BigEndian.PutUint64(table[8:], sector)
+	binary.BigEndian.PutUint64(table[16:], sectorCount)
+	binary.BigEndian.PutUint64(table[24:], dataOffset)
+	binary.BigEndian.PutUint32(table[200:], uint32(len(chunks)+2))
+
+	entry := func(entryType uint32, sector, count, offset, length uint64) {
+		e := make([]byte, 40)
+		binary.BigEndian.PutUint32(e[0:], entryType)
+		binary.BigEndian.PutUint64(e[8:], sector)
+		binary.BigEndian.PutUint64(e[16:], count)
+		binary.BigEndian.PutUint64(e[24:], offset)
+		binary.BigEndian.PutUint64(e[32:], length)
+		table = append(table, e...)
+	}
+	entry(0x7ffffffe, 0, 0, 0, 0)
+	var s uint64
+	for _, c := range chunks {
+		count := uint64(len(c.expanded) / 512)
+		entry(c.entryType, s, count, uint64(len(data)), uint64(len(c.data)))
+		data = append(data, c.data...)
+		s += count
+	}
+	entry(0xffffffff, s, 0, uint64(len(data)), 0)
+	return table, data
+}
+
+// udif builds an image of partitions of chunks, each starting at the given sector
+func udif(sectorCount uint64, partitions map[uint64][]testChunk, order []uint64) []byte {
+	var fork []byte
+	var resources strings.Builder
+	for i, sector := range order {
+		table, data := blkx(sector, uint64(len(fork)), partitions[sector])
+		fork = append(fork, data...)
+		fmt.Fprintf(&resources, `<dict><key>ID</key><integer>%d</integer><key>Name</key><string>partition %d</string>
+<key>Data</key><data>%s</data></dict>
+`, i, i, base64.StdEncoding.EncodeToString(table))
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+<key>resource-fork</key>
+<dict>
+<key>blkx</key>
+<array>
+%s</array>
+</dict>
+</dict>
+</plist>
+`, resources.String())
+
+	b := append(fork, plist...)
+	koly := make([]byte, 512)
+	copy(koly, "koly")
+	binary.BigEndian.PutUint32(koly[4:], 4)
+	binary.BigEndian.PutUint32(koly[8:], 512)
+	binary.BigEndian.PutUint64(koly[32:], uint64(len(fork)))
+	binary.BigEndian.PutUint64(koly[216:], uint64(len(fork)))
+	binary.BigEndian.PutUint64(koly[224:], uint64(len(plist)))
+	binary.BigEndian.PutUint64(koly[492:], sectorCount)
+	return append(b, koly...)
+}
+
+func writeImage(t *testing.T, b []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.dmg")
+	if err := os.WriteFile(p, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRead(t *testing.T) {
+	bz, err := hex.DecodeString(bzip2Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := sectors([]byte("raw data of the first partition"))
+	compressible := bytes.Repeat([]byte("compressible "), 200)[:1536]
+	// ADC literal of 4 and match of 508
+	adc := append([]byte{0x83}, "adc!"...)
+	for left := 508; left > 0; left -= min(left, 67) {
+		adc = append(adc, 0x40|byte(min(left, 67)-4), 0, 3)
+	}
+	partitions := map[uint64][]testChunk{
+		0: {
+			{1, raw, raw},
+			{0, nil, make([]byte, 1024)},
+			zlibChunk(t, compressible),
+			{0x80000006, bz, bzip2Text},
+		},
+		// a gap of zeroes in sectors 8 and 9
+		10: {
+			{0x80000004, adc, bytes.Repeat([]byte("adc!"), 128)},
+			lzfseChunk(sectors([]byte("lzfse chunk"))),
+			lzmaChunk(t, bytes.Repeat([]byte("lzma"), 256)),
+			{2, nil, make([]byte, 512)},
+		},
+	}
+	var expected []byte
+	for _, c := range partitions[0] {
+		expected = append(expected, c.expanded...)
+	}
+	expected = append(expected, make([]byte, 1024)...)
+	for _, c := range partitions[10] {
+		expected = append(expected, c.expanded...)
+	}
+	// sectors past the chunks
+	expected = append(expected, make([]byte, 1024)...)
+	sectorCount := uint64(len(expected) / 512)
+
+	// the partitions may be in any order in the property list
+	p := writeImage(t, udif(sectorCount, partitions, []uint64{10, 0}))
+	if _, err := dmg.OpenFromPath(p, false); err == nil {
+		t.Errorf("no error opening UDIF image read-write")
+	}
+	img, err := dmg.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening UDIF image: %v", err)
+	}
+	defer img.Close()
+	if img.Size() != int64(len(expected)) {
+		t.Fatalf("size %d instead of %d", img.Size(), len(expected))
+	}
+	info, err := img.Stat()
+	if err != nil {
+		t.Fatalf("error in stat: %v", err)
+	}
+	if info.Size() != img.Size() {
+		t.Errorf("stat size %d instead of %d", info.Size(), img.Size())
+	}
+	if _, err := img.Writable(); err == nil {
+		t.Errorf("no error getting UDIF image writable")
+	}
+
+	data, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatalf("error reading UDIF image: %v", err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("contents of image do not match")
+	}
+	// reads across chunks, and back into an earlier compressed chunk
+	for _, r := range [][2]int64{{500, 2000}, {3000, 700}, {4 * 512, 100}, {6000, 1000}, {100, 50}} {
+		b := make([]byte, r[1])
+		if _, err := img.ReadAt(b, r[0]); err != nil {
+			t.Fatalf("error reading %d bytes at %d: %v", r[1], r[0], err)
+		}
+		if !bytes.Equal(b, expected[r[0]:r[0]+r[1]]) {
+			t.Errorf("contents of %d bytes at %d do not match", r[1], r[0])
+		}
+	}
+	b := make([]byte, 1024)
+	n, err := img.ReadAt(b, img.Size()-512)
+	if n != 512 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at the end instead of 512 bytes with EOF", n, err)
+	}
+}
+
+func TestInvalidImages(t *testing.T) {
+	valid := udif(2, map[uint64][]testChunk{0: {{1, make([]byte, 1024), make([]byte, 1024)}}}, []uint64{0})
+	overlap := udif(4, map[uint64][]testChunk{
+		0: {{0, nil, make([]byte, 1024)}},
+		1: {{0, nil, make([]byte, 1024)}},
+	}, []uint64{0, 1})
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"too small", valid[:100]},
+		{"no trailer", append(append([]byte{}, valid...), make([]byte, 512)...)},
+		{"truncated", valid[512:]},
+		{"overlapping chunks", overlap},
+		{"unknown chunk type", udif(1, map[uint64][]testChunk{0: {{0x80000009, []byte{1}, make([]byte, 512)}}}, []uint64{0})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := dmg.OpenFromPath(writeImage(t, tt.b), true); err == nil {
+				t.Errorf("no error opening invalid image")
+			}
+		})
+	}
+
+	// chunks are only decompressed when read
+	corrupt := udif(1, map[uint64][]testChunk{0: {{0x80000005, []byte("not zlib"), make([]byte, 512)}}}, []uint64{0})
+	img, err := dmg.OpenFromPath(writeImage(t, corrupt), true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	if _, err := img.ReadAt(make([]byte, 512), 0); err == nil {
+		t.Errorf("no error reading corrupt chunk")
+	}
+}
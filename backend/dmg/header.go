@@ -0,0 +1,111 @@
+package dmg
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// trailerSize is the size of the koly trailer at the end of the image
+	trailerSize   = 512
+	trailerMagic  = "koly"
+	blkxMagic     = "mish"
+	blkxTableSize = 204
+	blkxChunkSize = 40
+	sectorSize    = 512
+)
+
+// block chunk types
+const (
+	chunkZeroFill   uint32 = 0x00000000
+	chunkRaw        uint32 = 0x00000001
+	chunkIgnore     uint32 = 0x00000002
+	chunkADC        uint32 = 0x80000004
+	chunkZlib       uint32 = 0x80000005
+	chunkBzip2      uint32 = 0x80000006
+	chunkLZFSE      uint32 = 0x80000007
+	chunkLZMA       uint32 = 0x80000008
+	chunkComment    uint32 = 0x7ffffffe
+	chunkTerminator uint32 = 0xffffffff
+)
+
+// trailer is the koly block, the parts of it that are needed to find the data
+type trailer struct {
+	version        uint32
+	dataForkOffset uint64
+	dataForkLength uint64
+	xmlOffset      uint64
+	xmlLength      uint64
+	sectorCount    uint64
+}
+
+func trailerFromBytes(b []byte) (*trailer, error) {
+	if len(b) < trailerSize {
+		return nil, fmt.Errorf("trailer is %d bytes instead of %d", len(b), trailerSize)
+	}
+	if string(b[0:4]) != trailerMagic {
+		return nil, fmt.Errorf("invalid trailer signature %q, not a UDIF image", b[0:4])
+	}
+	t := &trailer{
+		version:        binary.BigEndian.Uint32(b[4:8]),
+		dataForkOffset: binary.BigEndian.Uint64(b[24:32]),
+		dataForkLength: binary.BigEndian.Uint64(b[32:40]),
+		xmlOffset:      binary.BigEndian.Uint64(b[216:224]),
+		xmlLength:      binary.BigEndian.Uint64(b[224:232]),
+		sectorCount:    binary.BigEndian.Uint64(b[492:500]),
+	}
+	if t.version != 4 {
+		return nil, fmt.Errorf("unsupported UDIF version %d", t.version)
+	}
+	if t.xmlLength == 0 {
+		return nil, fmt.Errorf("image has no property list")
+	}
+	return t, nil
+}
+
+// blkxChunk is a chunk of a block table, in sectors relative to the table and bytes relative to its data
+type blkxChunk struct {
+	entryType        uint32
+	sectorNumber     uint64
+	sectorCount      uint64
+	compressedOffset uint64
+	compressedLength uint64
+}
+
+// blkxTable is the mish block table of a partition
+type blkxTable struct {
+	sectorNumber uint64
+	sectorCount  uint64
+	dataOffset   uint64
+	chunks       []blkxChunk
+}
+
+func blkxTableFromBytes(b []byte) (*blkxTable, error) {
+	if len(b) < blkxTableSize {
+		return nil, fmt.Errorf("block table is %d bytes, smaller than %d", len(b), blkxTableSize)
+	}
+	if string(b[0:4]) != blkxMagic {
+		return nil, fmt.Errorf("invalid block table signature %q", b[0:4])
+	}
+	t := &blkxTable{
+		sectorNumber: binary.BigEndian.Uint64(b[8:16]),
+		sectorCount:  binary.BigEndian.Uint64(b[16:24]),
+		dataOffset:   binary.BigEndian.Uint64(b[24:32]),
+	}
+	count := binary.BigEndian.Uint32(b[200:204])
+	if uint64(len(b)) < blkxTableSize+uint64(count)*blkxChunkSize {
+		return nil, fmt.Errorf("block table with %d chunks is truncated at %d bytes", count, len(b))
+	}
+	t.chunks = make([]blkxChunk, count)
+	for i := range t.chunks {
+		c := b[blkxTableSize+i*blkxChunkSize:]
+		t.chunks[i] = blkxChunk{
+			entryType:        binary.BigEndian.Uint32(c[0:4]),
+			sectorNumber:     binary.BigEndian.Uint64(c[8:16]),
+			sectorCount:      binary.BigEndian.Uint64(c[16:24]),
+			compressedOffset: binary.BigEndian.Uint64(c[24:32]),
+			compressedLength: binary.BigEndian.Uint64(c[32:40]),
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,568 @@
+package dmg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// LZFSE is Apple's compression of recent images, a stream of blocks that are stored, compressed with
+// LZVN, or compressed with LZ77 matches whose literals and lengths are entropy coded with finite state entropy.
+
+const (
+	lzfseEndOfStream    = "bvx$"
+	lzfseUncompressed   = "bvx-"
+	lzfseCompressedV1   = "bvx1"
+	lzfseCompressedV2   = "bvx2"
+	lzfseCompressedLZVN = "bvxn"
+
+	lzfseLSymbols       = 20
+	lzfseMSymbols       = 20
+	lzfseDSymbols       = 64
+	lzfseLiteralSymbols = 256
+	lzfseLStates        = 64
+	lzfseMStates        = 64
+	lzfseDStates        = 256
+	lzfseLiteralStates  = 1024
+
+	lzfseMatchesPerBlock  = 10000
+	lzfseLiteralsPerBlock = 4 * lzfseMatchesPerBlock
+
+	lzfseV1HeaderSize = 770
+	lzfseV2HeaderSize = 32
+	lzfseFreqCount    = lzfseLSymbols + lzfseMSymbols + lzfseDSymbols + lzfseLiteralSymbols
+)
+
+var (
+	lzfseLExtraBits = [lzfseLSymbols]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 3, 5, 8}
+	lzfseLBaseValue = [lzfseLSymbols]int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 20, 28, 60}
+	lzfseMExtraBits = [lzfseMSymbols]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 5, 8, 11}
+	lzfseMBaseValue = [lzfseMSymbols]int32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 24, 56, 312}
+	lzfseDExtraBits = [lzfseDSymbols]uint8{
+		0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7, 7,
+		8, 8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 10, 11, 11, 11, 11, 12, 12, 12, 12, 13, 13, 13, 13, 14, 14, 14, 14, 15, 15, 15, 15,
+	}
+	lzfseDBaseValue = [lzfseDSymbols]int32{
+		0, 1, 2, 3, 4, 6, 8, 10, 12, 16, 20, 24, 28, 36, 44, 52, 60, 76, 92, 108, 124, 156, 188, 220,
+		252, 316, 380, 444, 508, 636, 764, 892, 1020, 1276, 1532, 1788, 2044, 2556, 3068, 3580,
+		4092, 5116, 6140, 7164, 8188, 10236, 12284, 14332, 16380, 20476, 24572, 28668, 32764,
+		40956, 49148, 57340, 65532, 81916, 98300, 114684, 131068, 163836, 196604, 229372,
+	}
+
+	// the number of bits and value of the frequency codes of v2 headers, by the low 5 bits
+	lzfseFreqNBits = [32]uint8{
+		2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14,
+		2, 3, 2, 5, 2, 3, 2, 8, 2, 3, 2, 5, 2, 3, 2, 14,
+	}
+	lzfseFreqValue = [32]uint16{
+		0, 2, 1, 4, 0, 3, 1, 0, 0, 2, 1, 5, 0, 3, 1, 0,
+		0, 2, 1, 6, 0, 3, 1, 0, 0, 2, 1, 7, 0, 3, 1, 0,
+	}
+)
+
+var errLZFSE = errors.New("invalid LZFSE data")
+
+// lzfseBlock is the header of a compressed block, with v2 headers expanded to v1
+type lzfseBlock struct {
+	nRawBytes            uint32
+	nLiterals            uint32
+	nMatches             uint32
+	nLiteralPayloadBytes uint32
+	nLMDPayloadBytes     uint32
+	literalBits          int32
+	literalState         [4]uint16
+	lmdBits              int32
+	lState               uint16
+	mState               uint16
+	dState               uint16
+	// freq is the frequencies of the L, M, D and literal symbols in turn
+	freq [lzfseFreqCount]uint16
+}
+
+func lzfseBlockV1(b []byte) (*lzfseBlock, int, error) {
+	if len(b) < lzfseV1HeaderSize {
+		return nil, 0, errLZFSE
+	}
+	h := &lzfseBlock{
+		nRawBytes:            binary.LittleEndian.Uint32(b[4:8]),
+		nLiterals:            binary.LittleEndian.Uint32(b[12:16]),
+		nMatches:             binary.LittleEndian.Uint32(b[16:20]),
+		nLiteralPayloadBytes: binary.LittleEndian.Uint32(b[20:24]),
+		nLMDPayloadBytes:     binary.LittleEndian.Uint32(b[24:28]),
+		literalBits:          int32(binary.LittleEndian.Uint32(b[28:32])),
+		lmdBits:              int32(binary.LittleEndian.Uint32(b[40:44])),
+		lState:               binary.LittleEndian.Uint16(b[44:46]),
+		mState:               binary.LittleEndian.Uint16(b[46:48]),
+		dState:               binary.LittleEndian.Uint16(b[48:50]),
+	}
+	for i := range h.literalState {
+		h.literalState[i] = binary.LittleEndian.Uint16(b[32+2*i:])
+	}
+	for i := range h.freq {
+		h.freq[i] = binary.LittleEndian.Uint16(b[50+2*i:])
+	}
+	return h, lzfseV1HeaderSize, nil
+}
+
+func lzfseBlockV2(b []byte) (*lzfseBlock, int, error) {
+	if len(b) < lzfseV2HeaderSize {
+		return nil, 0, errLZFSE
+	}
+	v0 := binary.LittleEndian.Uint64(b[8:16])
+	v1 := binary.LittleEndian.Uint64(b[16:24])
+	v2 := binary.LittleEndian.Uint64(b[24:32])
+	field := func(v uint64, offset, nbits uint) uint64 {
+		return (v >> offset) & (1<<nbits - 1)
+	}
+	h := &lzfseBlock{
+		nRawBytes:            binary.LittleEndian.Uint32(b[4:8]),
+		nLiterals:            uint32(field(v0, 0, 20)),
+		nLiteralPayloadBytes: uint32(field(v0, 20, 20)),
+		nMatches:             uint32(field(v0, 40, 20)),
+		literalBits:          int32(field(v0, 60, 3)) - 7,
+		nLMDPayloadBytes:     uint32(field(v1, 40, 20)),
+		lmdBits:              int32(field(v1, 60, 3)) - 7,
+		lState:               uint16(field(v2, 32, 10)),
+		mState:               uint16(field(v2, 42, 10)),
+		dState:               uint16(field(v2, 52, 10)),
+	}
+	for i := range h.literalState {
+		h.literalState[i] = uint16(field(v1, uint(10*i), 10))
+	}
+	headerSize := int(field(v2, 0, 32))
+	if headerSize < lzfseV2HeaderSize || headerSize > len(b) {
+		return nil, 0, errLZFSE
+	}
+	// the frequencies are variable length codes, least significant bits first
+	src := b[lzfseV2HeaderSize:headerSize]
+	if len(src) > 0 {
+		var accum uint32
+		var accumBits uint
+		for i := range h.freq {
+			for len(src) > 0 && accumBits+8 <= 32 {
+				accum |= uint32(src[0]) << accumBits
+				accumBits += 8
+				src = src[1:]
+			}
+			code := accum & 31
+			nbits := uint(lzfseFreqNBits[code])
+			if nbits > accumBits {
+				return nil, 0, errLZFSE
+			}
+			switch nbits {
+			case 8:
+				h.freq[i] = 8 + uint16(accum>>4&0xf)
+			case 14:
+				h.freq[i] = 24 + uint16(accum>>4&0x3ff)
+			default:
+				h.freq[i] = lzfseFreqValue[code]
+			}
+			accum >>= nbits
+			accumBits -= nbits
+		}
+		if accumBits >= 8 || len(src) != 0 {
+			return nil, 0, errLZFSE
+		}
+	}
+	return h, headerSize, nil
+}
+
+// fseDecoderEntry decodes a symbol in a state, and moves to the next state
+type fseDecoderEntry struct {
+	k      uint8
+	symbol uint8
+	delta  int16
+}
+
+// fseValueDecoderEntry decodes a value in a state, with the extra bits of the value following the state bits
+type fseValueDecoderEntry struct {
+	totalBits uint8
+	valueBits uint8
+	delta     int16
+	vbase     int32
+}
+
+// fseStates lists the states of the symbols with their number of bits and delta, calling f with each
+func fseStates(nstates int, freq []uint16, f func(symbol int, k int, delta int)) error {
+	sum := 0
+	for i, fr := range freq {
+		n := int(fr)
+		if n == 0 {
+			continue
+		}
+		sum += n
+		if sum > nstates {
+			return errLZFSE
+		}
+		// the shift so that nstates <= n<<k < 2*nstates
+		k := bits.Len(uint(nstates)) - bits.Len(uint(n))
+		j0 := ((2 * nstates) >> k) - n
+		for j := 0; j < n; j++ {
+			if j < j0 {
+				f(i, k, ((n+j)<<k)-nstates)
+			} else {
+				f(i, k-1, (j-j0)<<(k-1))
+			}
+		}
+	}
+	return nil
+}
+
+func fseDecoderTable(nstates int, freq []uint16) ([]fseDecoderEntry, error) {
+	t := make([]fseDecoderEntry, 0, nstates)
+	err := fseStates(nstates, freq, func(symbol int, k int, delta int) {
+		t = append(t, fseDecoderEntry{k: uint8(k), symbol: uint8(symbol), delta: int16(delta)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	// states beyond the sum of the frequencies are not used by valid streams
+	return t[:nstates], nil
+}
+
+func fseValueDecoderTable(nstates int, freq []uint16, extraBits []uint8, baseValue []int32) ([]fseValueDecoderEntry, error) {
+	t := make([]fseValueDecoderEntry, 0, nstates)
+	err := fseStates(nstates, freq, func(symbol int, k int, delta int) {
+		t = append(t, fseValueDecoderEntry{
+			totalBits: uint8(k) + extraBits[symbol],
+			valueBits: extraBits[symbol],
+			delta:     int16(delta),
+			vbase:     baseValue[symbol],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t[:nstates], nil
+}
+
+// fseInStream reads bits backwards from the end of a payload, the last bits written being read first
+type fseInStream struct {
+	src       []byte
+	pos       int
+	accum     uint64
+	accumBits int
+}
+
+// init starts reading before pos of src, of which n in -7..0 bits of the last byte are unused
+func (s *fseInStream) init(src []byte, pos int, n int32) error {
+	s.src = src
+	if n < -7 || n > 0 {
+		return errLZFSE
+	}
+	size := 8
+	if n == 0 {
+		size = 7
+	}
+	if pos < size {
+		return errLZFSE
+	}
+	pos -= size
+	var b [8]byte
+	copy(b[:], src[pos:pos+size])
+	s.accum = binary.LittleEndian.Uint64(b[:])
+	s.accumBits = int(n) + size*8
+	s.pos = pos
+	if s.accumBits < 56 || s.accumBits >= 64 || s.accum>>s.accumBits != 0 {
+		return errLZFSE
+	}
+	return nil
+}
+
+// flush refills the accumulator to at least 56 bits
+func (s *fseInStream) flush() error {
+	nbits := (63 - s.accumBits) &^ 7
+	n := nbits >> 3
+	if s.pos < n {
+		return errLZFSE
+	}
+	s.pos -= n
+	var b [8]byte
+	copy(b[:], s.src[s.pos:s.pos+n])
+	incoming := binary.LittleEndian.Uint64(b[:])
+	s.accum = s.accum<<nbits | incoming
+	s.accumBits += nbits
+	return nil
+}
+
+func (s *fseInStream) pull(n uint8) uint64 {
+	s.accumBits -= int(n)
+	result := s.accum >> s.accumBits
+	s.accum &= 1<<s.accumBits - 1
+	return result
+}
+
+func (s *fseInStream) decode(state *uint16, t []fseDecoderEntry) uint8 {
+	e := t[*state]
+	*state = uint16(int(e.delta) + int(s.pull(e.k)))
+	return e.symbol
+}
+
+func (s *fseInStream) decodeValue(state *uint16, t []fseValueDecoderEntry) int32 {
+	e := t[*state]
+	b := s.pull(e.totalBits)
+	*state = uint16(int(e.delta) + int(b>>e.valueBits))
+	return e.vbase + int32(b&(1<<e.valueBits-1))
+}
+
+// decodeLZFSE decompresses an LZFSE stream into dst, returning the number of bytes decompressed
+func decodeLZFSE(dst, src []byte) (int, error) {
+	out := 0
+	in := 0
+	for {
+		if len(src)-in < 4 {
+			return out, fmt.Errorf("LZFSE stream truncated at %d: %w", in, errLZFSE)
+		}
+		magic := string(src[in : in+4])
+		var (
+			n   int
+			err error
+		)
+		switch magic {
+		case lzfseEndOfStream:
+			return out, nil
+		case lzfseUncompressed:
+			if len(src)-in < 8 {
+				return out, errLZFSE
+			}
+			size := int(binary.LittleEndian.Uint32(src[in+4:]))
+			if len(src)-in-8 < size || len(dst)-out < size {
+				return out, errLZFSE
+			}
+			copy(dst[out:], src[in+8:in+8+size])
+			in += 8 + size
+			out += size
+			continue
+		case lzfseCompressedLZVN:
+			if len(src)-in < 12 {
+				return out, errLZFSE
+			}
+			rawSize := int(binary.LittleEndian.Uint32(src[in+4:]))
+			payloadSize := int(binary.LittleEndian.Uint32(src[in+8:]))
+			if len(src)-in-12 < payloadSize || len(dst)-out < rawSize {
+				return out, errLZFSE
+			}
+			if n, err = decodeLZVN(dst[:out+rawSize], out, src[in+12:in+12+payloadSize]); err != nil {
+				return out, err
+			}
+			if n != rawSize {
+				return out, fmt.Errorf("LZVN block decompressed to %d bytes instead of %d: %w", n, rawSize, errLZFSE)
+			}
+			in += 12 + payloadSize
+			out += n
+			continue
+		case lzfseCompressedV1, lzfseCompressedV2:
+			var (
+				h          *lzfseBlock
+				headerSize int
+			)
+			if magic == lzfseCompressedV1 {
+				h, headerSize, err = lzfseBlockV1(src[in:])
+			} else {
+				h, headerSize, err = lzfseBlockV2(src[in:])
+			}
+			if err != nil {
+				return out, err
+			}
+			if n, err = decodeLZFSEBlock(dst, out, src, in+headerSize, h); err != nil {
+				return out, err
+			}
+			in += headerSize + int(h.nLiteralPayloadBytes) + int(h.nLMDPayloadBytes)
+			out += n
+		default:
+			return out, fmt.Errorf("unknown LZFSE block magic %q: %w", magic, errLZFSE)
+		}
+	}
+}
+
+// decodeLZFSEBlock decodes a compressed block with its payload at src[in:], to dst at out.
+// Matches may refer to the output of earlier blocks.
+func decodeLZFSEBlock(dst []byte, out int, src []byte, in int, h *lzfseBlock) (int, error) {
+	if h.nLiterals > lzfseLiteralsPerBlock || h.nMatches > lzfseMatchesPerBlock ||
+		int(h.nRawBytes) > len(dst)-out ||
+		uint64(len(src)-in) < uint64(h.nLiteralPayloadBytes)+uint64(h.nLMDPayloadBytes) {
+		return 0, errLZFSE
+	}
+	for _, s := range h.literalState {
+		if s >= lzfseLiteralStates {
+			return 0, errLZFSE
+		}
+	}
+	if h.lState >= lzfseLStates || h.mState >= lzfseMStates || h.dState >= lzfseDStates {
+		return 0, errLZFSE
+	}
+	freq := h.freq[:]
+	lFreq, freq := freq[:lzfseLSymbols], freq[lzfseLSymbols:]
+	mFreq, freq := freq[:lzfseMSymbols], freq[lzfseMSymbols:]
+	dFreq, literalFreq := freq[:lzfseDSymbols], freq[lzfseDSymbols:]
+
+	literalDecoder, err := fseDecoderTable(lzfseLiteralStates, literalFreq)
+	if err != nil {
+		return 0, err
+	}
+	lDecoder, err := fseValueDecoderTable(lzfseLStates, lFreq, lzfseLExtraBits[:], lzfseLBaseValue[:])
+	if err != nil {
+		return 0, err
+	}
+	mDecoder, err := fseValueDecoderTable(lzfseMStates, mFreq, lzfseMExtraBits[:], lzfseMBaseValue[:])
+	if err != nil {
+		return 0, err
+	}
+	dDecoder, err := fseValueDecoderTable(lzfseDStates, dFreq, lzfseDExtraBits[:], lzfseDBaseValue[:])
+	if err != nil {
+		return 0, err
+	}
+
+	// the literals, decoded four at a time with interleaved states, the stream read backwards
+	// from its end; reads of the unused bits at the start may reach into the header
+	literals := make([]byte, (h.nLiterals+3)&^3)
+	var bs fseInStream
+	literalEnd := in + int(h.nLiteralPayloadBytes)
+	if err := bs.init(src, literalEnd, h.literalBits); err != nil {
+		return 0, err
+	}
+	states := h.literalState
+	for i := 0; i < len(literals); i += 4 {
+		if err := bs.flush(); err != nil {
+			return 0, err
+		}
+		for j := range states {
+			literals[i+j] = bs.decode(&states[j], literalDecoder)
+		}
+	}
+
+	// the matches, each of L literals then M bytes from distance D
+	if err := bs.init(src, literalEnd+int(h.nLMDPayloadBytes), h.lmdBits); err != nil {
+		return 0, err
+	}
+	start := out
+	end := out + int(h.nRawBytes)
+	lState, mState, dState := h.lState, h.mState, h.dState
+	var d int
+	lit := 0
+	for i := uint32(0); i < h.nMatches; i++ {
+		if err := bs.flush(); err != nil {
+			return 0, err
+		}
+		l := int(bs.decodeValue(&lState, lDecoder))
+		m := int(bs.decodeValue(&mState, mDecoder))
+		if newD := int(bs.decodeValue(&dState, dDecoder)); newD != 0 {
+			d = newD
+		}
+		if lit+l > len(literals) || out+l+m > end {
+			return 0, errLZFSE
+		}
+		copy(dst[out:], literals[lit:lit+l])
+		lit += l
+		out += l
+		if m > 0 && (d <= 0 || d > out) {
+			return 0, fmt.Errorf("invalid LZFSE match distance %d at %d: %w", d, out, errLZFSE)
+		}
+		for j := 0; j < m; j++ {
+			dst[out+j] = dst[out-d+j]
+		}
+		out += m
+	}
+	if out != end {
+		return 0, fmt.Errorf("LZFSE block decompressed to %d bytes instead of %d: %w", out-start, h.nRawBytes, errLZFSE)
+	}
+	return out - start, nil
+}
+
+// decodeLZVN decompresses an LZVN payload to dst from out, with matches possibly referring to dst before out,
+// returning the number of bytes decompressed
+func decodeLZVN(dst []byte, out int, src []byte) (int, error) {
+	start := out
+	d := 0
+	in := 0
+	// need returns whether n more bytes of src are available
+	need := func(n int) bool {
+		return len(src)-in >= n
+	}
+	for {
+		if !need(1) {
+			return out - start, fmt.Errorf("LZVN data truncated: %w", errLZFSE)
+		}
+		op := src[in]
+		var l, m int
+		switch {
+		case op == 0x06:
+			// end of stream
+			return out - start, nil
+		case op == 0x0e || op == 0x16:
+			// nop
+			in++
+			continue
+		case op >= 0xe0 && op <= 0xef:
+			// literal only
+			l = int(op & 0xf)
+			in++
+			if op == 0xe0 {
+				if !need(1) {
+					return out - start, errLZFSE
+				}
+				l = int(src[in]) + 16
+				in++
+			}
+		case op >= 0xf0:
+			// match only, at the previous distance
+			m = int(op & 0xf)
+			in++
+			if op == 0xf0 {
+				if !need(1) {
+					return out - start, errLZFSE
+				}
+				m = int(src[in]) + 16
+				in++
+			}
+		case op&0xe0 == 0xa0:
+			// medium distance
+			if !need(3) {
+				return out - start, errLZFSE
+			}
+			b1, b2 := int(src[in+1]), int(src[in+2])
+			l = int(op>>3) & 3
+			m = (int(op&7)<<2 | b1&3) + 3
+			d = b1>>2 | b2<<6
+			in += 3
+		case op < 0x40 && op&7 == 6, op >= 0x70 && op < 0x80, op >= 0xd0:
+			return out - start, fmt.Errorf("undefined LZVN opcode %#x: %w", op, errLZFSE)
+		default:
+			l = int(op >> 6)
+			m = int(op>>3&7) + 3
+			switch op & 7 {
+			case 6:
+				// previous distance
+				in++
+			case 7:
+				// large distance
+				if !need(3) {
+					return out - start, errLZFSE
+				}
+				d = int(src[in+1]) | int(src[in+2])<<8
+				in += 3
+			default:
+				// small distance
+				if !need(2) {
+					return out - start, errLZFSE
+				}
+				d = int(op&7)<<8 | int(src[in+1])
+				in += 2
+			}
+		}
+		if !need(l) || out+l+m > len(dst) {
+			return out - start, errLZFSE
+		}
+		copy(dst[out:], src[in:in+l])
+		in += l
+		out += l
+		if m > 0 && (d <= 0 || d > out) {
+			return out - start, fmt.Errorf("invalid LZVN match distance %d at %d: %w", d, out, errLZFSE)
+		}
+		for j := 0; j < m; j++ {
+			dst[out+j] = dst[out-d+j]
+		}
+		out += m
+	}
+}
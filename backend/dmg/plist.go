@@ -0,0 +1,130 @@
+package dmg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parsePlist parses an XML property list into map[string]any, []any, string, []byte, int64 and bool values
+func parsePlist(b []byte) (any, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid property list: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, fmt.Errorf("invalid property list, root element is %s", start.Name.Local)
+			}
+			v, err := plistNext(d)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				return nil, errors.New("empty property list")
+			}
+			return v, nil
+		}
+	}
+}
+
+// plistNext parses the next value, returning nil at the end of the enclosing element
+func plistNext(d *xml.Decoder) (any, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("invalid property list: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			return nil, nil
+		case xml.StartElement:
+			return plistValue(d, t)
+		}
+	}
+}
+
+func plistValue(d *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]any{}
+		for {
+			k, err := plistNext(d)
+			if err != nil {
+				return nil, err
+			}
+			if k == nil {
+				return dict, nil
+			}
+			key, ok := k.(plistKey)
+			if !ok {
+				return nil, fmt.Errorf("invalid property list, expected key in dict, found %T", k)
+			}
+			v, err := plistNext(d)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				return nil, fmt.Errorf("invalid property list, no value for key %s", key)
+			}
+			dict[string(key)] = v
+		}
+	case "array":
+		var array []any
+		for {
+			v, err := plistNext(d)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				return array, nil
+			}
+			array = append(array, v)
+		}
+	case "true", "false":
+		if err := d.Skip(); err != nil {
+			return nil, fmt.Errorf("invalid property list: %w", err)
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, fmt.Errorf("invalid property list: %w", err)
+	}
+	switch start.Name.Local {
+	case "key":
+		return plistKey(text), nil
+	case "string":
+		return text, nil
+	case "integer":
+		i, err := strconv.ParseInt(strings.TrimSpace(text), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid property list integer %q: %w", text, err)
+		}
+		return i, nil
+	case "data":
+		// data is base64 broken into lines
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid property list data: %w", err)
+		}
+		return data, nil
+	default:
+		// real and date values are not needed
+		return text, nil
+	}
+}
+
+// plistKey is a key of a dict, distinct from string values
+type plistKey string
@@ -0,0 +1,454 @@
+// Package ewf provides a read-only backend for Expert Witness Format (EWF) images, the .E01 forensic
+// images of EnCase, FTK Imager and ewfacquire.
+//
+// An EWF image is a set of segment files, image.E01, image.E02 and so on, each a chain of sections.
+// The media is stored in chunks, usually of 32KB, which are either zlib compressed or stored with an
+// Adler-32 checksum, and located through the table sections. Image presents the media, so that its
+// partitions and filesystems can be read without converting the image to raw first.
+package ewf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// chunk is the location of a chunk of the media in the segments
+type chunk struct {
+	segment    int
+	offset     int64
+	size       int64
+	compressed bool
+}
+
+// Image is an EWF image presented as a read-only backend.Storage of the size of the media
+type Image struct {
+	segments  []backend.Storage
+	volume    *volume
+	chunks    []chunk
+	chunkSize int64
+	size      int64
+
+	mu sync.Mutex
+	// the last chunk read, as reads are usually sequential
+	cached     int
+	cachedData []byte
+	offset     int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the EWF image stored in the provided segments, in any order.
+// EWF images can only be opened read-only.
+func New(segments []backend.Storage, readOnly bool) (*Image, error) {
+	if !readOnly {
+		return nil, errors.New("EWF images can only be opened read-only")
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("must pass at least one segment")
+	}
+	img := &Image{
+		cached: -1,
+	}
+	if err := img.init(segments); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// SegmentName returns the name of a segment of the image whose first segment is pathName, e.g. image.E01,
+// with the index of the segment from 0. Segments follow .E01 to .E99 with .EAA to .EZZ, then .FAA and on
+// to .ZZZ, in the case of the extension of pathName.
+func SegmentName(pathName string, index int) string {
+	ext := filepath.Ext(pathName)
+	base := strings.TrimSuffix(pathName, ext)
+	first := byte('E')
+	if len(ext) > 1 {
+		first = ext[1]
+	}
+	lower := first >= 'a' && first <= 'z'
+	if lower {
+		first -= 'a' - 'A'
+	}
+	var name string
+	if index < 99 {
+		name = fmt.Sprintf("%c%02d", first, index+1)
+	} else {
+		i := index - 99
+		name = string([]byte{first + byte(i/(26*26)), 'A' + byte(i/26%26), 'A' + byte(i%26)})
+	}
+	if lower {
+		name = strings.ToLower(name)
+	}
+	return base + "." + name
+}
+
+// OpenFromPath opens an existing EWF image from the path of its first segment, e.g. image.E01,
+// with the following segments named as by SegmentName. EWF images can only be opened read-only.
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	if !readOnly {
+		return nil, errors.New("EWF images can only be opened read-only")
+	}
+	var segments []backend.Storage
+	closeAll := func() {
+		for _, s := range segments {
+			s.Close()
+		}
+	}
+	for i := 0; ; i++ {
+		name := SegmentName(pathName, i)
+		if i == 0 {
+			name = pathName
+		}
+		f, err := os.OpenFile(name, os.O_RDONLY, 0o600)
+		if err != nil {
+			if i > 0 && errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			closeAll()
+			return nil, fmt.Errorf("could not open image %s: %w", name, err)
+		}
+		segments = append(segments, file.New(f, true))
+	}
+	img, err := New(segments, true)
+	if err != nil {
+		closeAll()
+		return nil, fmt.Errorf("could not open EWF image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init(segments []backend.Storage) error {
+	// segments are ordered by the number in their header
+	numbers := make(map[uint16]backend.Storage, len(segments))
+	for i, s := range segments {
+		b := make([]byte, fileHeaderSize)
+		if err := readFull(s, b, 0); err != nil {
+			return fmt.Errorf("error reading header of segment %d: %w", i, err)
+		}
+		h, err := fileHeaderFromBytes(b)
+		if err != nil {
+			return fmt.Errorf("invalid segment %d: %w", i, err)
+		}
+		if _, ok := numbers[h.segment]; ok {
+			return fmt.Errorf("duplicate segment number %d", h.segment)
+		}
+		numbers[h.segment] = s
+	}
+	img.segments = make([]backend.Storage, len(segments))
+	for i := range img.segments {
+		s, ok := numbers[uint16(i+1)]
+		if !ok {
+			return fmt.Errorf("missing segment %d", i+1)
+		}
+		img.segments[i] = s
+	}
+
+	done := false
+	for i, s := range img.segments {
+		last, err := img.readSections(i, s)
+		if err != nil {
+			return fmt.Errorf("error reading sections of segment %d: %w", i+1, err)
+		}
+		if last == sectionDone {
+			done = i == len(img.segments)-1
+			break
+		}
+	}
+	if !done {
+		return errors.New("image is incomplete, the last segment does not end the image")
+	}
+	if img.volume == nil {
+		return errors.New("image has no volume section")
+	}
+	if len(img.chunks) != int(img.volume.chunks) {
+		return fmt.Errorf("tables list %d chunks instead of the %d of the volume", len(img.chunks), img.volume.chunks)
+	}
+	img.chunkSize = int64(img.volume.sectorsPerChunk) * int64(img.volume.bytesPerSector)
+	img.size = int64(img.volume.sectors) * int64(img.volume.bytesPerSector)
+	if img.size > int64(len(img.chunks))*img.chunkSize {
+		return fmt.Errorf("%d chunks of %d bytes do not hold the media of %d bytes", len(img.chunks), img.chunkSize, img.size)
+	}
+	return nil
+}
+
+// readSections follows the chain of sections of a segment, returning the type of the last one
+func (img *Image) readSections(segment int, s backend.Storage) (string, error) {
+	info, err := s.Stat()
+	if err != nil {
+		return "", fmt.Errorf("could not stat segment: %w", err)
+	}
+	segmentSize := info.Size()
+	// the end of the last sectors section, where the data of the last chunk of a table ends
+	var sectorsEnd int64
+	b := make([]byte, sectionDescriptorSize)
+	offset := int64(fileHeaderSize)
+	for {
+		if err := readFull(s, b, offset); err != nil {
+			return "", fmt.Errorf("error reading section at %d: %w", offset, err)
+		}
+		d, err := sectionDescriptorFromBytes(b)
+		if err != nil {
+			return "", fmt.Errorf("invalid section at %d: %w", offset, err)
+		}
+		if d.sectionType == sectionNext || d.sectionType == sectionDone {
+			return d.sectionType, nil
+		}
+		dataSize := int64(d.size) - sectionDescriptorSize
+		if dataSize < 0 || offset+int64(d.size) > segmentSize {
+			return "", fmt.Errorf("%s section at %d of %d bytes is beyond the end of the segment", d.sectionType, offset, d.size)
+		}
+		switch d.sectionType {
+		case sectionVolume, sectionDisk, sectionData:
+			// the data section of later segments repeats the volume
+			if img.volume == nil {
+				v := make([]byte, dataSize)
+				if err := readFull(s, v, offset+sectionDescriptorSize); err != nil {
+					return "", fmt.Errorf("error reading volume section: %w", err)
+				}
+				if img.volume, err = volumeFromBytes(v); err != nil {
+					return "", err
+				}
+			}
+		case sectionSectors:
+			sectorsEnd = offset + int64(d.size)
+		case sectionTable:
+			if err := img.readTable(segment, s, offset+sectionDescriptorSize, dataSize, sectorsEnd); err != nil {
+				return "", err
+			}
+		}
+		// the table2 section repeats the table, and the others describe the acquisition
+		if int64(d.next) <= offset {
+			return "", fmt.Errorf("%s section at %d does not link forward to the next section", d.sectionType, offset)
+		}
+		offset = int64(d.next)
+	}
+}
+
+// readTable adds the chunks in the table to the image
+func (img *Image) readTable(segment int, s backend.Storage, offset, size, sectorsEnd int64) error {
+	if size < tableHeaderSize {
+		return fmt.Errorf("table section at %d is too small", offset)
+	}
+	b := make([]byte, size)
+	if err := readFull(s, b, offset); err != nil {
+		return fmt.Errorf("error reading table section: %w", err)
+	}
+	h, err := tableHeaderFromBytes(b)
+	if err != nil {
+		return err
+	}
+	entries := b[tableHeaderSize:]
+	if uint64(len(entries)) < uint64(h.entries)*4 {
+		return fmt.Errorf("table of %d entries is truncated at %d bytes", h.entries, len(entries))
+	}
+	// the data of the last chunk ends with the sectors section, or with the table in older versions
+	end := sectorsEnd
+	if end == 0 {
+		end = offset + size
+	}
+	first := len(img.chunks)
+	for i := uint32(0); i < h.entries; i++ {
+		e := binary.LittleEndian.Uint32(entries[4*i:])
+		img.chunks = append(img.chunks, chunk{
+			segment:    segment,
+			offset:     int64(h.baseOffset) + int64(e&^compressedFlag),
+			compressed: e&compressedFlag != 0,
+		})
+	}
+	for i := first; i < len(img.chunks); i++ {
+		next := end
+		if i+1 < len(img.chunks) {
+			next = img.chunks[i+1].offset
+		}
+		if next <= img.chunks[i].offset {
+			return fmt.Errorf("chunk %d at %d has no data", i, img.chunks[i].offset)
+		}
+		img.chunks[i].size = next - img.chunks[i].offset
+	}
+	return nil
+}
+
+// readFull reads len(b) bytes from the storage, failing on a truncated image
+func readFull(s backend.Storage, b []byte, offset int64) error {
+	n, err := s.ReadAt(b, offset)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Size is the size of the media
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ChunkSize is the size of the chunks in which the media is stored
+func (img *Image) ChunkSize() int64 {
+	return img.chunkSize
+}
+
+// ReadAt reads from the media, decompressing the chunks read
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		index := int(pos / img.chunkSize)
+		data, err := img.chunkData(index)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], data[pos%img.chunkSize:])
+	}
+	return read, eof
+}
+
+// chunkData returns the data of chunk i, keeping the last one read. It must be called with mu held.
+func (img *Image) chunkData(i int) ([]byte, error) {
+	if img.cached == i {
+		return img.cachedData, nil
+	}
+	c := img.chunks[i]
+	size := min(img.chunkSize, img.size-int64(i)*img.chunkSize)
+	if !c.compressed && c.size < size+4 {
+		return nil, fmt.Errorf("chunk %d of %d bytes is too small for %d bytes and a checksum", i, c.size, size)
+	}
+	src := make([]byte, c.size)
+	if !c.compressed {
+		src = src[:size+4]
+	}
+	if err := readFull(img.segments[c.segment], src, c.offset); err != nil {
+		return nil, fmt.Errorf("error reading chunk %d: %w", i, err)
+	}
+	dst := img.cachedData
+	if int64(cap(dst)) < size {
+		dst = make([]byte, img.chunkSize)
+	}
+	dst = dst[:size]
+	img.cached = -1
+	img.cachedData = dst
+	if c.compressed {
+		r, err := zlib.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing chunk %d: %w", i, err)
+		}
+		_, err = io.ReadFull(r, dst)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing chunk %d: %w", i, err)
+		}
+	} else {
+		// stored chunks are followed by their checksum
+		if sum := binary.LittleEndian.Uint32(src[size:]); sum != adler32.Checksum(src[:size]) {
+			return nil, fmt.Errorf("invalid checksum %#x of chunk %d", sum, i)
+		}
+		copy(dst, src)
+	}
+	img.cached = i
+	return dst, nil
+}
+
+// Stat returns the file info of the first segment, but with the size of the media
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.segments[0].Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the media at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	offset := img.offset
+	img.mu.Unlock()
+	n, err := img.ReadAt(b, offset)
+	img.mu.Lock()
+	img.offset = offset + int64(n)
+	img.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the media
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Close closes all the segments
+func (img *Image) Close() error {
+	var errs []error
+	for _, s := range img.segments {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sys is not suitable for EWF images, as ioctls on a segment file do not apply to the media
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable is not possible for EWF images, which are always read-only
+func (img *Image) Writable() (backend.WritableFile, error) {
+	return nil, backend.ErrIncorrectOpenMode
+}
+
+// imageInfo reports the size of the media instead of the segment file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
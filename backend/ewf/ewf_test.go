@@ -0,0 +1,300 @@
+package ewf_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/ewf"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// section appends a section with its descriptor, linked to the section following it
+func section(b []byte, sectionType string, data []byte) []byte {
+	d := make([]byte, 76)
+	copy(d, sectionType)
+	next := uint64(len(b) + 76 + len(data))
+	size := uint64(76 + len(data))
+	if sectionType == "done" {
+		// the done section links to itself
+		next = uint64(len(b))
+	}
+	binary.LittleEndian.PutUint64(d[16:], next)
+	binary.LittleEndian.PutUint64(d[24:], size)
+	binary.LittleEndian.PutUint32(d[72:], adler32.Checksum(d[:72]))
+	return append(append(b, d...), data...)
+}
+
+// writeEWF writes media as an EWF image in segments of at most chunksPerSegment chunks, compressing
+// every other chunk
+func writeEWF(t *testing.T, pathName string, media []byte, sectorsPerChunk uint32, chunksPerSegment int) {
+	t.Helper()
+	chunkSize := int(sectorsPerChunk) * 512
+	chunks := (len(media) + chunkSize - 1) / chunkSize
+
+	volume := make([]byte, 1052)
+	binary.LittleEndian.PutUint32(volume[4:], uint32(chunks))
+	binary.LittleEndian.PutUint32(volume[8:], sectorsPerChunk)
+	binary.LittleEndian.PutUint32(volume[12:], 512)
+	binary.LittleEndian.PutUint64(volume[16:], uint64(len(media)/512))
+	binary.LittleEndian.PutUint32(volume[1048:], adler32.Checksum(volume[:1048]))
+
+	segments := (chunks + chunksPerSegment - 1) / chunksPerSegment
+	for s := 0; s < segments; s++ {
+		b := []byte{'E', 'V', 'F', 0x09, 0x0d, 0x0a, 0xff, 0x00, 0x01}
+		b = binary.LittleEndian.AppendUint16(b, uint16(s+1))
+		b = append(b, 0, 0)
+		if s == 0 {
+			b = section(b, "header", []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01})
+			b = section(b, "volume", volume)
+		} else {
+			b = section(b, "data", volume)
+		}
+
+		// the sectors section holds the chunks, located by the table that follows with offsets from the base
+		sectorsStart := len(b)
+		var sectors []byte
+		var entries []byte
+		for c := s * chunksPerSegment; c < min(chunks, (s+1)*chunksPerSegment); c++ {
+			data := media[c*chunkSize : min(len(media), (c+1)*chunkSize)]
+			entry := uint32(76 + len(sectors))
+			if c%2 == 0 {
+				var buf bytes.Buffer
+				w := zlib.NewWriter(&buf)
+				if _, err := w.Write(data); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				sectors = append(sectors, buf.Bytes()...)
+				entry |= 0x80000000
+			} else {
+				sectors = append(sectors, data...)
+				sectors = binary.LittleEndian.AppendUint32(sectors, adler32.Checksum(data))
+			}
+			entries = binary.LittleEndian.AppendUint32(entries, entry)
+		}
+		b = section(b, "sectors", sectors)
+		table := make([]byte, 24)
+		binary.LittleEndian.PutUint32(table[0:], uint32(len(entries)/4))
+		binary.LittleEndian.PutUint64(table[8:], uint64(sectorsStart))
+		binary.LittleEndian.PutUint32(table[20:], adler32.Checksum(table[:20]))
+		table = append(table, entries...)
+		table = binary.LittleEndian.AppendUint32(table, adler32.Checksum(entries))
+		b = section(b, "table", table)
+		b = section(b, "table2", table)
+		if s == segments-1 {
+			b = section(b, "done", nil)
+		} else {
+			b = section(b, "next", nil)
+		}
+		if err := os.WriteFile(ewf.SegmentName(pathName, s), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSegmentName(t *testing.T) {
+	tests := []struct {
+		pathName string
+		index    int
+		expected string
+	}{
+		{"disk.E01", 0, "disk.E01"},
+		{"disk.E01", 1, "disk.E02"},
+		{"disk.E01", 98, "disk.E99"},
+		{"disk.E01", 99, "disk.EAA"},
+		{"disk.E01", 100, "disk.EAB"},
+		{"disk.E01", 99 + 26, "disk.EBA"},
+		{"disk.E01", 99 + 26*26, "disk.FAA"},
+		{"dir/disk.e01", 9, "dir/disk.e10"},
+	}
+	for _, tt := range tests {
+		if name := ewf.SegmentName(tt.pathName, tt.index); name != tt.expected {
+			t.Errorf("segment %d of %s is %s instead of %s", tt.index, tt.pathName, name, tt.expected)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	// a partial chunk at the end
+	media := make([]byte, 10*4096+1024)
+	_, _ = rand.Read(media[:3*4096])
+	copy(media[6*4096:], bytes.Repeat([]byte("compressible"), 500))
+	_, _ = rand.Read(media[len(media)-2000:])
+
+	p := filepath.Join(t.TempDir(), "disk.E01")
+	writeEWF(t, p, media, 8, 4)
+	if _, err := os.Stat(ewf.SegmentName(p, 2)); err != nil {
+		t.Fatalf("expected three segments: %v", err)
+	}
+	if _, err := ewf.OpenFromPath(p, false); err == nil {
+		t.Errorf("no error opening EWF image read-write")
+	}
+	img, err := ewf.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening EWF image: %v", err)
+	}
+	defer img.Close()
+	if img.Size() != int64(len(media)) {
+		t.Fatalf("size %d instead of %d", img.Size(), len(media))
+	}
+	if img.ChunkSize() != 4096 {
+		t.Errorf("chunk size %d instead of 4096", img.ChunkSize())
+	}
+	if _, err := img.Writable(); err == nil {
+		t.Errorf("no error getting EWF image writable")
+	}
+	data, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatalf("error reading EWF image: %v", err)
+	}
+	if !bytes.Equal(data, media) {
+		t.Errorf("contents of image do not match")
+	}
+	for _, r := range [][2]int64{{4000, 5000}, {100, 10}, {9 * 4096, 4096}, {2 * 4096, 4096}} {
+		b := make([]byte, r[1])
+		if _, err := img.ReadAt(b, r[0]); err != nil {
+			t.Fatalf("error reading %d bytes at %d: %v", r[1], r[0], err)
+		}
+		if !bytes.Equal(b, media[r[0]:r[0]+r[1]]) {
+			t.Errorf("contents of %d bytes at %d do not match", r[1], r[0])
+		}
+	}
+	b := make([]byte, 4096)
+	n, err := img.ReadAt(b, img.Size()-1024)
+	if n != 1024 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at the end instead of 1024 bytes with EOF", n, err)
+	}
+}
+
+func TestInvalidImages(t *testing.T) {
+	media := make([]byte, 8*4096)
+	_, _ = rand.Read(media)
+
+	t.Run("missing segment", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "disk.E01")
+		writeEWF(t, p, media, 8, 2)
+		if err := os.Remove(ewf.SegmentName(p, 3)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ewf.OpenFromPath(p, true); err == nil {
+			t.Errorf("no error opening image without its last segment")
+		}
+	})
+	t.Run("corrupt section", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "disk.E01")
+		writeEWF(t, p, media, 8, 8)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[13+20]++
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ewf.OpenFromPath(p, true); err == nil {
+			t.Errorf("no error opening image with an invalid section checksum")
+		}
+	})
+	t.Run("corrupt chunk", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "disk.E01")
+		writeEWF(t, p, media, 8, 8)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the last chunk is stored with its checksum before the tables and done sections
+		b[len(b)-76-2*(76+24+8*4+4)-100]++
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		img, err := ewf.OpenFromPath(p, true)
+		if err != nil {
+			t.Fatalf("error opening image: %v", err)
+		}
+		defer img.Close()
+		if _, err := img.ReadAt(make([]byte, 4096), 7*4096); err == nil {
+			t.Errorf("no error reading chunk with an invalid checksum")
+		}
+	})
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "disk.img")
+	size := int64(40 * 1024 * 1024)
+	b, err := file.CreateFromPath(raw, size)
+	if err != nil {
+		t.Fatalf("error creating raw image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from inside an EWF image")
+	f, err := fs.OpenFile("/evidence.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+	media, err := os.ReadFile(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(dir, "disk.E01")
+	writeEWF(t, p, media, 64, 400)
+	img, err := ewf.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening EWF image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(img)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/evidence.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
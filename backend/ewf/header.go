@@ -0,0 +1,117 @@
+package ewf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"strings"
+)
+
+const (
+	fileHeaderSize        = 13
+	sectionDescriptorSize = 76
+	tableHeaderSize       = 24
+	// volumeMinSize is the part of the volume section needed, which is the same in all EnCase versions
+	volumeMinSize = 24
+	// compressedFlag is the bit of table entries set for compressed chunks
+	compressedFlag = 0x80000000
+)
+
+var fileSignature = []byte{'E', 'V', 'F', 0x09, 0x0d, 0x0a, 0xff, 0x00}
+
+// section types
+const (
+	sectionVolume  = "volume"
+	sectionDisk    = "disk"
+	sectionData    = "data"
+	sectionSectors = "sectors"
+	sectionTable   = "table"
+	sectionNext    = "next"
+	sectionDone    = "done"
+)
+
+// fileHeader starts each segment file
+type fileHeader struct {
+	segment uint16
+}
+
+func fileHeaderFromBytes(b []byte) (*fileHeader, error) {
+	if len(b) < fileHeaderSize {
+		return nil, fmt.Errorf("file header is %d bytes instead of %d", len(b), fileHeaderSize)
+	}
+	if !bytes.Equal(b[0:8], fileSignature) {
+		return nil, fmt.Errorf("invalid signature % x, not an EWF image", b[0:8])
+	}
+	return &fileHeader{
+		segment: binary.LittleEndian.Uint16(b[9:11]),
+	}, nil
+}
+
+// sectionDescriptor starts each section, and links to the next one
+type sectionDescriptor struct {
+	sectionType string
+	next        uint64
+	size        uint64
+}
+
+func sectionDescriptorFromBytes(b []byte) (*sectionDescriptor, error) {
+	if len(b) < sectionDescriptorSize {
+		return nil, fmt.Errorf("section descriptor is %d bytes instead of %d", len(b), sectionDescriptorSize)
+	}
+	if sum := binary.LittleEndian.Uint32(b[72:76]); sum != adler32.Checksum(b[:72]) {
+		return nil, fmt.Errorf("invalid section descriptor checksum %#x", sum)
+	}
+	return &sectionDescriptor{
+		sectionType: strings.TrimRight(string(b[0:16]), "\x00"),
+		next:        binary.LittleEndian.Uint64(b[16:24]),
+		size:        binary.LittleEndian.Uint64(b[24:32]),
+	}, nil
+}
+
+// volume describes the media of the image
+type volume struct {
+	chunks          uint32
+	sectorsPerChunk uint32
+	bytesPerSector  uint32
+	sectors         uint64
+}
+
+func volumeFromBytes(b []byte) (*volume, error) {
+	if len(b) < volumeMinSize {
+		return nil, fmt.Errorf("volume section is %d bytes, smaller than %d", len(b), volumeMinSize)
+	}
+	v := &volume{
+		chunks:          binary.LittleEndian.Uint32(b[4:8]),
+		sectorsPerChunk: binary.LittleEndian.Uint32(b[8:12]),
+		bytesPerSector:  binary.LittleEndian.Uint32(b[12:16]),
+		sectors:         binary.LittleEndian.Uint64(b[16:24]),
+	}
+	if v.sectorsPerChunk == 0 || v.bytesPerSector == 0 {
+		return nil, fmt.Errorf("invalid chunks of %d sectors of %d bytes", v.sectorsPerChunk, v.bytesPerSector)
+	}
+	// each chunk read is held in memory
+	if uint64(v.sectorsPerChunk)*uint64(v.bytesPerSector) > 64*1024*1024 {
+		return nil, fmt.Errorf("chunks of %d sectors of %d bytes are too large", v.sectorsPerChunk, v.bytesPerSector)
+	}
+	return v, nil
+}
+
+// tableHeader starts the table of the chunks of a sectors section
+type tableHeader struct {
+	entries    uint32
+	baseOffset uint64
+}
+
+func tableHeaderFromBytes(b []byte) (*tableHeader, error) {
+	if len(b) < tableHeaderSize {
+		return nil, fmt.Errorf("table header is %d bytes instead of %d", len(b), tableHeaderSize)
+	}
+	if sum := binary.LittleEndian.Uint32(b[20:24]); sum != adler32.Checksum(b[:20]) {
+		return nil, fmt.Errorf("invalid table header checksum %#x", sum)
+	}
+	return &tableHeader{
+		entries:    binary.LittleEndian.Uint32(b[0:4]),
+		baseOffset: binary.LittleEndian.Uint64(b[8:16]),
+	}, nil
+}
@@ -0,0 +1,336 @@
+// Package fault provides a backend wrapper for tests, which injects faults into the storage it
+// wraps, to test that filesystem writers survive failed, lost and partial writes, and crashes.
+//
+// Writes are held in memory, and visible to reads, until Sync writes them to the storage, like
+// the write cache of a disk. Crash discards the writes since the last Sync, as a power loss
+// would, so that what a writer left on the storage at any point can be opened and checked:
+//
+//	s := fault.New(mem.New(img, false))
+//	d, _ := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+//	// ... write to the disk, calling s.Sync() where the writer would flush
+//	s.Crash()
+//	// ... open the disk again, and check it
+//
+// Faults are armed to trigger after a number of operations, so that a test can loop over all
+// the writes of an operation, failing each in turn.
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// ErrInjected is returned by the operations failed by an injected fault
+var ErrInjected = errors.New("injected fault")
+
+type writeFault int
+
+const (
+	noFault writeFault = iota
+	failWrites
+	dropWrites
+	tearWrites
+)
+
+// pendingWrite is a write that is not synced to the storage yet
+type pendingWrite struct {
+	offset int64
+	data   []byte
+}
+
+// Storage is a backend.Storage that injects faults into the storage it wraps
+type Storage struct {
+	storage backend.Storage
+	rw      backend.WritableFile
+	// mu guards all of the below, and serializes the operations on the storage
+	mu         sync.Mutex
+	pending    []pendingWrite
+	writeFault writeFault
+	writesLeft int
+	keep       int
+	failReads  bool
+	readsLeft  int
+	writes     int64
+	offset     int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Storage)(nil)
+
+// New wraps the storage, without any faults armed. It can be written if the storage is writable.
+func New(b backend.Storage) *Storage {
+	s := &Storage{storage: b}
+	// a storage that cannot be written is wrapped read-only
+	if rw, err := b.Writable(); err == nil {
+		s.rw = rw
+	}
+	return s
+}
+
+// FailWritesAfter lets n more writes succeed, and fails all writes after them with ErrInjected,
+// without writing anything
+func (s *Storage) FailWritesAfter(n int) {
+	s.armWrites(failWrites, n, 0)
+}
+
+// DropWritesAfter lets n more writes succeed, and silently drops all writes after them, which
+// report success but are never written, as a disk that loses its write cache would
+func (s *Storage) DropWritesAfter(n int) {
+	s.armWrites(dropWrites, n, 0)
+}
+
+// TearWriteAfter lets n more writes succeed, writes only the first keep bytes of the write after
+// them, and fails it and all writes after it with ErrInjected, as a write interrupted midway
+func (s *Storage) TearWriteAfter(n, keep int) {
+	s.armWrites(tearWrites, n, keep)
+}
+
+func (s *Storage) armWrites(f writeFault, n, keep int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeFault = f
+	s.writesLeft = n
+	s.keep = keep
+}
+
+// FailReadsAfter lets n more reads succeed, and fails all reads after them with ErrInjected
+func (s *Storage) FailReadsAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failReads = true
+	s.readsLeft = n
+}
+
+// Reset disarms all faults
+func (s *Storage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+func (s *Storage) reset() {
+	s.writeFault = noFault
+	s.failReads = false
+}
+
+// Writes is the number of writes so far, including failed and dropped writes, e.g. to find how
+// many writes an operation makes before failing each of them in turn
+func (s *Storage) Writes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+// Pending is the number of writes not synced to the storage yet
+func (s *Storage) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Sync writes the pending writes to the storage, in order, and syncs it
+func (s *Storage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.pending) > 0 {
+		w := s.pending[0]
+		if _, err := s.rw.WriteAt(w.data, w.offset); err != nil {
+			return fmt.Errorf("could not write %d bytes at %d: %w", len(w.data), w.offset, err)
+		}
+		s.pending = s.pending[1:]
+	}
+	s.pending = nil
+	return backend.Sync(s.storage)
+}
+
+// Crash discards the writes since the last Sync, as a power loss would, and disarms all faults,
+// so that the storage can be opened again as after a reboot
+func (s *Storage) Crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+	s.offset = 0
+	s.reset()
+}
+
+// size is the size of the storage with the pending writes, which may grow it
+func (s *Storage) size() (base, size int64, err error) {
+	info, err := s.storage.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	base, size = info.Size(), info.Size()
+	for _, w := range s.pending {
+		size = max(size, w.offset+int64(len(w.data)))
+	}
+	return base, size, nil
+}
+
+// ReadAt reads from the storage, with the pending writes applied
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readAt(p, off)
+}
+
+func (s *Storage) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if s.failReads {
+		if s.readsLeft == 0 {
+			return 0, ErrInjected
+		}
+		s.readsLeft--
+	}
+	base, size, err := s.size()
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	clear(p)
+	if off < base {
+		end := min(off+int64(len(p)), base)
+		n, err := s.storage.ReadAt(p[:end-off], off)
+		if err != nil && (!errors.Is(err, io.EOF) || int64(n) < end-off) {
+			return 0, err
+		}
+	}
+	end := off + int64(len(p))
+	for _, w := range s.pending {
+		wEnd := w.offset + int64(len(w.data))
+		if w.offset >= end || wEnd <= off {
+			continue
+		}
+		from := max(w.offset, off)
+		copy(p[from-off:], w.data[from-w.offset:])
+	}
+	return len(p), eof
+}
+
+// WriteAt holds the write in memory until Sync, unless it is failed or dropped by a fault
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	if s.rw == nil {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.writeFault != noFault {
+		if s.writesLeft > 0 {
+			s.writesLeft--
+		} else {
+			switch s.writeFault {
+			case dropWrites:
+				return len(p), nil
+			case tearWrites:
+				keep := min(s.keep, len(p))
+				s.pending = append(s.pending, pendingWrite{offset: off, data: append([]byte(nil), p[:keep]...)})
+				// the writes after the torn write fail
+				s.writeFault = failWrites
+				return keep, ErrInjected
+			default:
+				return 0, ErrInjected
+			}
+		}
+	}
+	s.pending = append(s.pending, pendingWrite{offset: off, data: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+// Read reads at the current offset
+func (s *Storage) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.readAt(b, s.offset)
+	s.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (s *Storage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		_, size, err := s.size()
+		if err != nil {
+			return s.offset, err
+		}
+		offset += size
+	default:
+		return s.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Stat describes the storage, with its size including the pending writes
+func (s *Storage) Stat() (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	_, size, err := s.size()
+	if err != nil {
+		return nil, err
+	}
+	return &faultInfo{FileInfo: info, size: size}, nil
+}
+
+// Close syncs the pending writes, unless discarded by Crash, and closes the storage
+func (s *Storage) Close() error {
+	if s.rw != nil {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	return s.storage.Close()
+}
+
+// Sys is not suitable, as reads and writes through the file would bypass the pending writes
+func (s *Storage) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the storage itself, unless it is read-only
+func (s *Storage) Writable() (backend.WritableFile, error) {
+	if s.rw == nil {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return s, nil
+}
+
+// faultInfo reports the size including the pending writes
+type faultInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *faultInfo) Size() int64 {
+	return i.size
+}
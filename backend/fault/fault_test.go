@@ -0,0 +1,155 @@
+package fault_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/fault"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestFault(t *testing.T) {
+	m := mem.New(make([]byte, 100), false)
+	s := fault.New(m)
+
+	if _, err := s.WriteAt([]byte("abcd"), 10); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("XY"), 98); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("grow"), 100); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	b := make([]byte, 8)
+	if _, err := s.ReadAt(b, 8); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, []byte("\x00\x00abcd\x00\x00")) {
+		t.Errorf("read %q before sync", b)
+	}
+	if n, err := s.ReadAt(b, 98); n != 6 || !errors.Is(err, io.EOF) || string(b[:n]) != "XYgrow" {
+		t.Errorf("read %q with error %v at the end", b[:n], err)
+	}
+	if bytes.Contains(m.Bytes(), []byte("abcd")) {
+		t.Errorf("write reached the storage before sync")
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("error syncing: %v", err)
+	}
+	if !bytes.Equal(m.Bytes()[10:14], []byte("abcd")) || string(m.Bytes()[98:]) != "XYgrow" {
+		t.Errorf("writes did not reach the storage on sync")
+	}
+
+	// a crash discards the writes since the sync
+	if _, err := s.WriteAt([]byte("lost"), 20); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if s.Pending() != 1 {
+		t.Errorf("%d pending writes instead of 1", s.Pending())
+	}
+	s.Crash()
+	if _, err := s.ReadAt(b[:4], 20); err != nil || bytes.Equal(b[:4], []byte("lost")) {
+		t.Errorf("write not discarded by crash: %q, %v", b[:4], err)
+	}
+
+	s.FailWritesAfter(1)
+	if _, err := s.WriteAt([]byte("ok"), 0); err != nil {
+		t.Errorf("error writing before the fault: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("no"), 2); !errors.Is(err, fault.ErrInjected) {
+		t.Errorf("error %v instead of %v", err, fault.ErrInjected)
+	}
+
+	s.DropWritesAfter(0)
+	if n, err := s.WriteAt([]byte("drop"), 30); n != 4 || err != nil {
+		t.Errorf("dropped write returned %d, %v", n, err)
+	}
+	if _, err := s.ReadAt(b[:4], 30); err != nil || bytes.Equal(b[:4], []byte("drop")) {
+		t.Errorf("dropped write was written: %q, %v", b[:4], err)
+	}
+
+	s.TearWriteAfter(0, 2)
+	if n, err := s.WriteAt([]byte("torn"), 40); n != 2 || !errors.Is(err, fault.ErrInjected) {
+		t.Errorf("torn write returned %d, %v", n, err)
+	}
+	if _, err := s.ReadAt(b[:4], 40); err != nil || !bytes.Equal(b[:4], []byte("to\x00\x00")) {
+		t.Errorf("torn write read as %q, %v", b[:4], err)
+	}
+	if _, err := s.WriteAt([]byte("next"), 50); !errors.Is(err, fault.ErrInjected) {
+		t.Errorf("no error writing after the torn write: %v", err)
+	}
+
+	s.Reset()
+	s.FailReadsAfter(0)
+	if _, err := s.ReadAt(b, 0); !errors.Is(err, fault.ErrInjected) {
+		t.Errorf("error %v instead of %v", err, fault.ErrInjected)
+	}
+	s.Reset()
+	if _, err := s.ReadAt(b, 0); err != nil {
+		t.Errorf("error reading after reset: %v", err)
+	}
+	if s.Writes() != 9 {
+		t.Errorf("%d writes instead of 9", s.Writes())
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := fault.New(mem.New(make([]byte, 100), true))
+	if _, err := s.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only storage")
+	}
+	if _, err := s.WriteAt([]byte("abcd"), 0); err == nil {
+		t.Errorf("no error writing read-only storage")
+	}
+}
+
+func TestCrash(t *testing.T) {
+	s := fault.New(mem.New(make([]byte, 20*1024*1024), false))
+	d, err := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 38000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("error syncing: %v", err)
+	}
+	f, err := fs.OpenFile("/file.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("not synced")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	s.Crash()
+
+	d, err = diskfs.OpenBackend(s)
+	if err != nil {
+		t.Fatalf("error opening disk after crash: %v", err)
+	}
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem after crash: %v", err)
+	}
+	if _, err := fs.OpenFile("/file.txt", os.O_RDONLY); err == nil {
+		t.Errorf("file written after the sync is there after crash")
+	}
+}
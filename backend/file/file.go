@@ -13,20 +13,45 @@ import (
 type rawBackend struct {
 	storage  fs.File
 	readOnly bool
+	// sparseBlockSize is the size of the blocks checked for zeroes when writing, 0 if not sparse
+	sparseBlockSize int64
+	// mapped is the memory-mapped file read from, nil if not mapped
+	mapped *mapping
 }
 
+type opts struct {
+	sparseBlockSize int64
+	mmap            bool
+}
+
+// Opt func that process New, OpenFromPath and CreateFromPath options
+type Opt func(o *opts)
+
 // Create a backend.Storage from provided fs.File
-func New(f fs.File, readOnly bool) backend.Storage {
+// If ReadAt and WriteAt of the file are not safe for concurrent use, as they are for *os.File, the
+// storage should be wrapped with backend.Synchronized
+func New(f fs.File, readOnly bool, options ...Opt) backend.Storage {
+	o := &opts{}
+	for _, opt := range options {
+		opt(o)
+	}
+	var mapped *mapping
+	if o.mmap {
+		// files that cannot be mapped are read as they would be without WithMmap
+		mapped, _ = newMapping(f)
+	}
 	return rawBackend{
-		storage:  f,
-		readOnly: readOnly,
+		storage:         f,
+		readOnly:        readOnly,
+		sparseBlockSize: o.sparseBlockSize,
+		mapped:          mapped,
 	}
 }
 
 // Create a backend.Storage from a path to a device
 // Should pass a path to a block device e.g. /dev/sda or a path to a file /tmp/foo.img
 // The provided device/file must exist at the time you call OpenFromPath()
-func OpenFromPath(pathName string, readOnly bool) (backend.Storage, error) {
+func OpenFromPath(pathName string, readOnly bool, options ...Opt) (backend.Storage, error) {
 	if pathName == "" {
 		return nil, errors.New("must pass device of file name")
 	}
@@ -46,16 +71,13 @@ func OpenFromPath(pathName string, readOnly bool) (backend.Storage, error) {
 		return nil, fmt.Errorf("could not open device %s with mode %v: %w", pathName, openMode, err)
 	}
 
-	return rawBackend{
-		storage:  f,
-		readOnly: readOnly,
-	}, nil
+	return New(f, readOnly, options...), nil
 }
 
 // Create a backend.Storage from a path to an image file.
 // Should pass a path to a file /tmp/foo.img
 // The provided file must not exist at the time you call CreateFromPath()
-func CreateFromPath(pathName string, size int64) (backend.Storage, error) {
+func CreateFromPath(pathName string, size int64, options ...Opt) (backend.Storage, error) {
 	if pathName == "" {
 		return nil, errors.New("must pass device name")
 	}
@@ -71,10 +93,7 @@ func CreateFromPath(pathName string, size int64) (backend.Storage, error) {
 		return nil, fmt.Errorf("could not expand device %s to size %d: %w", pathName, size, err)
 	}
 
-	return rawBackend{
-		storage:  f,
-		readOnly: false,
-	}, nil
+	return New(f, false, options...), nil
 }
 
 // backend.Storage interface guard
@@ -92,6 +111,9 @@ func (f rawBackend) Sys() (*os.File, error) {
 func (f rawBackend) Writable() (backend.WritableFile, error) {
 	if rwFile, ok := f.storage.(backend.WritableFile); ok {
 		if !f.readOnly {
+			if f.sparseBlockSize > 0 {
+				return &sparseFile{WritableFile: rwFile, blockSize: f.sparseBlockSize}, nil
+			}
 			return rwFile, nil
 		}
 
@@ -109,12 +131,34 @@ func (f rawBackend) Read(b []byte) (int, error) {
 }
 
 func (f rawBackend) Close() error {
+	if f.mapped != nil {
+		if err := f.mapped.close(); err != nil {
+			_ = f.storage.Close()
+			return fmt.Errorf("could not unmap file: %w", err)
+		}
+	}
 	return f.storage.Close()
 }
 
+// Sync commits the writes to the file to stable storage, if it can be synced, as an *os.File
+func (f rawBackend) Sync() error {
+	if s, ok := f.storage.(backend.Syncer); ok && !f.readOnly {
+		return s.Sync()
+	}
+	return nil
+}
+
 func (f rawBackend) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.mapped != nil {
+		n = f.mapped.readAt(p, off)
+		if n == len(p) {
+			return n, nil
+		}
+	}
 	if readerAt, ok := f.storage.(io.ReaderAt); ok {
-		return readerAt.ReadAt(p, off)
+		// beyond the mapping, e.g. if the file was extended since
+		m, err := readerAt.ReadAt(p[n:], off+int64(n))
+		return n + m, err
 	}
 	return -1, backend.ErrNotSuitable
 }
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// errMmapUnsupported is returned when the file cannot be memory-mapped
+var errMmapUnsupported = errors.New("memory-mapping is not supported")
+
+// WithMmap memory-maps the backing file for reading, where the OS supports it: ReadAt then copies
+// from the mapping instead of making a system call for every read, which speeds up read-heavy
+// workloads with many small reads, such as extracting a large ext4 or squashfs image. Writes still
+// go to the file, and are seen by reads through the mapping. Reads beyond the size of the file when
+// it was opened, e.g. after it was extended, read from the file. Where the file cannot be mapped,
+// such as on Windows or for an fs.File other than an *os.File, it is read as without WithMmap.
+//
+// The file must not be truncated by others while it is mapped, as reading pages beyond its end
+// would then crash the program.
+func WithMmap() Opt {
+	return func(o *opts) {
+		o.mmap = true
+	}
+}
+
+// mapping is the memory-mapped contents of a file, until it is closed
+type mapping struct {
+	// mu guards data against being unmapped while it is read
+	mu   sync.RWMutex
+	data []byte
+}
+
+// newMapping maps the file, which must have a size
+func newMapping(f fs.File) (*mapping, error) {
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		return nil, errMmapUnsupported
+	}
+	// the size of block devices is only known by seeking to their end
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+	data, err := mmap(f, int(size))
+	if err != nil {
+		return nil, err
+	}
+	return &mapping{data: data}, nil
+}
+
+// readAt copies what it can of p at off from the mapping, and returns the number of bytes copied,
+// which may be less than len(p) if the mapping ends before p, or has been closed
+func (m *mapping) readAt(p []byte, off int64) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off < 0 || off >= int64(len(m.data)) {
+		return 0
+	}
+	return copy(p, m.data[off:])
+}
+
+// close unmaps the file, after which nothing is read from the mapping
+func (m *mapping) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data == nil {
+		return nil
+	}
+	err := munmap(m.data)
+	m.data = nil
+	return err
+}
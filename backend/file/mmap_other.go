@@ -0,0 +1,12 @@
+//go:build !unix
+
+package file
+
+// mmap is only supported on Unix, elsewhere files are read with ReadAt
+func mmap(_ any, _ int) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package file_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+func TestMmap(t *testing.T) {
+	size := int64(1024 * 1024)
+	p := filepath.Join(t.TempDir(), "mmap.img")
+	expected := make([]byte, size)
+	_, _ = rand.Read(expected)
+	if err := os.WriteFile(p, expected, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := file.OpenFromPath(p, false, file.WithMmap())
+	if err != nil {
+		t.Fatalf("unexpected error opening image: %v", err)
+	}
+	defer b.Close()
+
+	read := func(off int64, length int) []byte {
+		t.Helper()
+		buf := make([]byte, length)
+		n, err := b.ReadAt(buf, off)
+		if err != nil {
+			t.Fatalf("error reading %d bytes at %d: %v", length, off, err)
+		}
+		return buf[:n]
+	}
+	if got := read(1000, 5000); !bytes.Equal(got, expected[1000:6000]) {
+		t.Errorf("read mismatched contents")
+	}
+
+	// writes are seen through the mapping, and beyond it once the file is extended
+	rw, err := b.Writable()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := make([]byte, 8192)
+	_, _ = rand.Read(data)
+	for _, off := range []int64{4096, size - 4096} {
+		if _, err := rw.WriteAt(data, off); err != nil {
+			t.Fatalf("error writing at %d: %v", off, err)
+		}
+		if end := off + int64(len(data)); end > int64(len(expected)) {
+			expected = append(expected, make([]byte, end-int64(len(expected)))...)
+		}
+		copy(expected[off:], data)
+	}
+	if got := read(0, len(expected)); !bytes.Equal(got, expected) {
+		t.Errorf("read mismatched contents after writes")
+	}
+}
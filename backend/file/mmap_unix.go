@@ -0,0 +1,25 @@
+//go:build unix
+
+package file
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// fder is implemented by files with a file descriptor, such as *os.File
+type fder interface {
+	Fd() uintptr
+}
+
+// mmap maps size bytes of the file for reading, shared so that writes to the file are seen
+func mmap(f any, size int) ([]byte, error) {
+	fd, ok := f.(fder)
+	if !ok {
+		return nil, errMmapUnsupported
+	}
+	return unix.Mmap(int(fd.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+}
+
+func munmap(b []byte) error {
+	return unix.Munmap(b)
+}
@@ -0,0 +1,144 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// DefaultSparseBlockSize is the size of the blocks checked for zeroes by WithSparse, that of most filesystems
+const DefaultSparseBlockSize = 4096
+
+// errPunchUnsupported is returned when holes cannot be punched in the file
+var errPunchUnsupported = errors.New("punching holes is not supported")
+
+// WithSparse keeps the backing file sparse: aligned blocks of blockSize that are entirely zero are not
+// written, and a hole is punched instead where the file already has data, so that e.g. a 32GB image
+// holding 2GB of data takes about 2GB on disk. A blockSize of 0 or less uses DefaultSparseBlockSize;
+// it should be a multiple of the block size of the filesystem holding the file, which can only
+// deallocate whole blocks.
+func WithSparse(blockSize int64) Opt {
+	return func(o *opts) {
+		if blockSize <= 0 {
+			blockSize = DefaultSparseBlockSize
+		}
+		o.sparseBlockSize = blockSize
+	}
+}
+
+// sparseFile is a writable file that does not store blocks of zeroes
+type sparseFile struct {
+	backend.WritableFile
+	blockSize int64
+}
+
+// truncater is implemented by files that can be extended, such as *os.File
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// WriteAt writes p at off, skipping or punching holes for aligned blocks of zeroes
+func (f *sparseFile) WriteAt(p []byte, off int64) (int, error) {
+	written := 0
+	// data is the start in p of data that has not been written yet
+	data := 0
+	pos := 0
+	for pos < len(p) {
+		chunk := int(min(int64(len(p)-pos), f.blockSize-(off+int64(pos))%f.blockSize))
+		if int64(chunk) != f.blockSize || !isZero(p[pos:pos+chunk]) {
+			pos += chunk
+			continue
+		}
+		// a run of whole blocks of zeroes
+		end := pos + chunk
+		for int64(len(p)-end) >= f.blockSize && isZero(p[end:end+int(f.blockSize)]) {
+			end += int(f.blockSize)
+		}
+		if data < pos {
+			n, err := f.WritableFile.WriteAt(p[data:pos], off+int64(data))
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		if err := f.zero(off+int64(pos), int64(end-pos)); err != nil {
+			return written, err
+		}
+		written += end - pos
+		data, pos = end, end
+	}
+	if data < len(p) {
+		n, err := f.WritableFile.WriteAt(p[data:], off+int64(data))
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// zero makes the region of the file read as zeroes, without allocating space for it
+func (f *sparseFile) zero(off, length int64) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat file: %w", err)
+	}
+	size := info.Size()
+	if off < size {
+		existing := min(length, size-off)
+		err := punchHole(f.WritableFile, off, existing)
+		if errors.Is(err, errPunchUnsupported) {
+			err = f.writeZeroes(off, existing)
+		}
+		if err != nil {
+			return fmt.Errorf("could not zero %d bytes at %d: %w", existing, off, err)
+		}
+	}
+	if off+length <= size {
+		return nil
+	}
+	// the region is past the end of the file, which only needs to be extended
+	if t, ok := f.WritableFile.(truncater); ok {
+		if err := t.Truncate(off + length); err != nil {
+			return fmt.Errorf("could not extend file to %d: %w", off+length, err)
+		}
+		return nil
+	}
+	_, err = f.WritableFile.WriteAt(make([]byte, f.blockSize), off+length-f.blockSize)
+	return err
+}
+
+// writeZeroes writes zeroes to the blocks of the region not already zero, when holes cannot be punched
+func (f *sparseFile) writeZeroes(off, length int64) error {
+	b := make([]byte, f.blockSize)
+	zeroes := make([]byte, f.blockSize)
+	for pos := int64(0); pos < length; pos += f.blockSize {
+		n, err := f.ReadAt(b, off+pos)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if isZero(b[:n]) {
+			continue
+		}
+		if _, err := f.WritableFile.WriteAt(zeroes[:n], off+pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for len(b) > 0 {
+		n := min(len(b), len(zeroBlock))
+		if !bytes.Equal(b[:n], zeroBlock[:n]) {
+			return false
+		}
+		b = b[n:]
+	}
+	return true
+}
+
+var zeroBlock = make([]byte, 64*1024)
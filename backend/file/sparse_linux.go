@@ -0,0 +1,20 @@
+package file
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// punchHole deallocates the region of the file, which then reads as zeroes, keeping the file size
+func punchHole(f any, off, length int64) error {
+	fd, ok := f.(fder)
+	if !ok {
+		return errPunchUnsupported
+	}
+	err := unix.Fallocate(int(fd.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, off, length)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return errPunchUnsupported
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+package file_test
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func allocatedSize(t *testing.T, p string) (int64, bool) {
+	t.Helper()
+	var stat unix.Stat_t
+	if err := unix.Stat(p, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat.Blocks * 512, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package file
+
+// punchHole is only supported on Linux, elsewhere zeroes are written over existing data
+func punchHole(_ any, _, _ int64) error {
+	return errPunchUnsupported
+}
@@ -0,0 +1,67 @@
+package file_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+func TestSparseWrite(t *testing.T) {
+	size := int64(8 * 1024 * 1024)
+	p := filepath.Join(t.TempDir(), "sparse.img")
+	b, err := file.CreateFromPath(p, size, file.WithSparse(0))
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	defer b.Close()
+	rw, err := b.Writable()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := make([]byte, size)
+	data := make([]byte, 3*file.DefaultSparseBlockSize+100)
+	_, _ = rand.Read(data)
+	for _, w := range []struct {
+		offset int64
+		b      []byte
+	}{
+		// zeroes over the whole image, which should not allocate anything
+		{0, make([]byte, size)},
+		// data with unaligned start and end
+		{1000, data},
+		// data surrounded by whole blocks of zeroes
+		{1024 * 1024, append(append(make([]byte, 5*file.DefaultSparseBlockSize), data...), make([]byte, 5*file.DefaultSparseBlockSize)...)},
+		// zeroes over previously written data, partially overlapping blocks
+		{1024*1024 + 5*file.DefaultSparseBlockSize + 10, make([]byte, 2*file.DefaultSparseBlockSize)},
+		// zeroes partially beyond the original size extend the file
+		{size - file.DefaultSparseBlockSize, make([]byte, 2*file.DefaultSparseBlockSize)},
+	} {
+		n, err := rw.WriteAt(w.b, w.offset)
+		if err != nil {
+			t.Fatalf("error writing %d bytes at %d: %v", len(w.b), w.offset, err)
+		}
+		if n != len(w.b) {
+			t.Fatalf("wrote %d bytes instead of %d", n, len(w.b))
+		}
+		if end := w.offset + int64(len(w.b)); end > int64(len(expected)) {
+			expected = append(expected, make([]byte, end-int64(len(expected)))...)
+		}
+		copy(expected[w.offset:], w.b)
+	}
+
+	contents, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, expected) {
+		t.Errorf("mismatched file contents")
+	}
+	if allocated, ok := allocatedSize(t, p); ok && allocated > 16*file.DefaultSparseBlockSize {
+		t.Errorf("%d bytes allocated for a sparse file with %d bytes of data", allocated, 2*len(data))
+	}
+}
@@ -0,0 +1,424 @@
+// Package httprange provides a read-only backend for disk images served over HTTP(S), which reads
+// them with Range requests instead of downloading them.
+//
+// Only the parts of the image that are read are fetched, in chunks that are kept in an in-memory
+// cache, so the partition table and filesystems inside a large image hosted on a web server or in
+// object storage can be inspected quickly. The server must support Range requests, as nearly all
+// static file servers and object stores do.
+package httprange
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+const (
+	// DefaultChunkSize is the size of the parts of the image fetched with each request
+	DefaultChunkSize int64 = 1024 * 1024
+	// DefaultParallelism is the number of requests made at once for a single read
+	DefaultParallelism = 4
+	// DefaultCacheSize is the size of the cache of fetched chunks
+	DefaultCacheSize int64 = 64 * 1024 * 1024
+)
+
+// Image is a disk image read over HTTP(S)
+type Image struct {
+	url         string
+	client      *http.Client
+	header      http.Header
+	size        int64
+	etag        string
+	modTime     time.Time
+	chunkSize   int64
+	parallelism int
+	cache       *chunkCache
+	// mu guards the offset for Read and Seek
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+type opts struct {
+	client      *http.Client
+	header      http.Header
+	chunkSize   int64
+	parallelism int
+	cacheSize   int64
+}
+
+// Opt func that process New options
+type Opt func(o *opts) error
+
+// WithClient sets the HTTP client used for the requests, http.DefaultClient if not set
+func WithClient(client *http.Client) Opt {
+	return func(o *opts) error {
+		if client == nil {
+			return errors.New("must pass HTTP client")
+		}
+		o.client = client
+		return nil
+	}
+}
+
+// WithHeader adds a header to all requests, e.g. for authorization
+func WithHeader(key, value string) Opt {
+	return func(o *opts) error {
+		o.header.Add(key, value)
+		return nil
+	}
+}
+
+// WithChunkSize sets the size of the parts of the image fetched with each request, DefaultChunkSize
+// if not set. Larger chunks mean fewer requests, but more data fetched that may not be needed.
+func WithChunkSize(size int64) Opt {
+	return func(o *opts) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid chunk size %d", size)
+		}
+		o.chunkSize = size
+		return nil
+	}
+}
+
+// WithParallelism sets the number of requests made at once when a read spans several chunks,
+// DefaultParallelism if not set
+func WithParallelism(n int) Opt {
+	return func(o *opts) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid parallelism %d", n)
+		}
+		o.parallelism = n
+		return nil
+	}
+}
+
+// WithCacheSize sets the size of the in-memory cache of fetched chunks, DefaultCacheSize if not set.
+// 0 disables the cache, so that every read requests the chunks it needs.
+func WithCacheSize(size int64) Opt {
+	return func(o *opts) error {
+		if size < 0 {
+			return fmt.Errorf("invalid cache size %d", size)
+		}
+		o.cacheSize = size
+		return nil
+	}
+}
+
+// New opens the disk image at the HTTP or HTTPS URL. It requests the first byte to find the size
+// of the image and to check that the server supports Range requests.
+func New(rawURL string, options ...Opt) (*Image, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	o := &opts{
+		client:      http.DefaultClient,
+		header:      http.Header{},
+		chunkSize:   DefaultChunkSize,
+		parallelism: DefaultParallelism,
+		cacheSize:   DefaultCacheSize,
+	}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	img := &Image{
+		url:         rawURL,
+		client:      o.client,
+		header:      o.header,
+		chunkSize:   o.chunkSize,
+		parallelism: o.parallelism,
+		cache:       newChunkCache(int(o.cacheSize / o.chunkSize)),
+	}
+	if err := img.probe(); err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", rawURL, err)
+	}
+	return img, nil
+}
+
+// probe finds the size and version of the image by requesting its first byte
+func (img *Image) probe() error {
+	resp, err := img.get(0, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return errors.New("server does not support range requests")
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	// Content-Range is bytes 0-0/size
+	contentRange := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("could not find size of image from Content-Range %q", contentRange)
+	}
+	img.size = size
+	// a strong ETag makes sure that all chunks are of the same version of the image
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		img.etag = etag
+	}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		img.modTime = modTime
+	}
+	return nil
+}
+
+// get requests the bytes from start to end inclusive
+func (img *Image) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, img.url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range img.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if img.etag != "" {
+		req.Header.Set("If-Match", img.etag)
+	}
+	resp, err := img.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// fetch fetches a chunk of the image, and adds it to the cache
+func (img *Image) fetch(index int64) ([]byte, error) {
+	start := index * img.chunkSize
+	end := min(start+img.chunkSize, img.size)
+	resp, err := img.get(start, end-1)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching bytes %d-%d: %w", start, end-1, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusPreconditionFailed:
+		return nil, errors.New("image has changed on the server since it was opened")
+	default:
+		return nil, fmt.Errorf("unexpected response %s fetching bytes %d-%d", resp.Status, start, end-1)
+	}
+	b := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, fmt.Errorf("error reading bytes %d-%d: %w", start, end-1, err)
+	}
+	img.cache.put(index, b)
+	return b, nil
+}
+
+// Size is the size of the image
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// ChunkSize is the size of the parts of the image fetched with each request
+func (img *Image) ChunkSize() int64 {
+	return img.chunkSize
+}
+
+// ReadAt reads from the image, fetching the chunks that are not cached with up to the configured
+// number of requests at once
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= img.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > img.size {
+		p = p[:img.size-off]
+		eof = io.EOF
+	}
+	if len(p) == 0 {
+		return 0, eof
+	}
+	first := off / img.chunkSize
+	chunks := make([][]byte, (off+int64(len(p))-1)/img.chunkSize-first+1)
+	var missing []int
+	for i := range chunks {
+		if b, ok := img.cache.get(first + int64(i)); ok {
+			chunks[i] = b
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	errs := make([]error, len(missing))
+	sem := make(chan struct{}, img.parallelism)
+	var wg sync.WaitGroup
+	for j, i := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			chunks[i], errs[j] = img.fetch(first + int64(i))
+			<-sem
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+
+	n := copy(p, chunks[0][off-first*img.chunkSize:])
+	for _, b := range chunks[1:] {
+		n += copy(p[n:], b)
+	}
+	return n, eof
+}
+
+// Stat describes the image, named after the last element of the URL path
+func (img *Image) Stat() (fs.FileInfo, error) {
+	u, err := url.Parse(img.url)
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{name: path.Base(u.Path), size: img.size, modTime: img.modTime}, nil
+}
+
+// Read reads from the image at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	offset := img.offset
+	img.mu.Unlock()
+	n, err := img.ReadAt(b, offset)
+	img.mu.Lock()
+	img.offset = offset + int64(n)
+	img.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the image
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.size
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Close drops the cached chunks
+func (img *Image) Close() error {
+	img.cache.clear()
+	return nil
+}
+
+// Sys is not suitable for images read over HTTP, which have no local file
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable is not possible for images read over HTTP, which are always read-only
+func (img *Image) Writable() (backend.WritableFile, error) {
+	return nil, backend.ErrIncorrectOpenMode
+}
+
+// imageInfo describes an image read over HTTP
+type imageInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i *imageInfo) Name() string       { return i.name }
+func (i *imageInfo) Size() int64        { return i.size }
+func (i *imageInfo) Mode() fs.FileMode  { return 0o444 }
+func (i *imageInfo) ModTime() time.Time { return i.modTime }
+func (i *imageInfo) IsDir() bool        { return false }
+func (i *imageInfo) Sys() any           { return nil }
+
+// chunkCache is a least recently used cache of chunks of the image
+type chunkCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[int64]*list.Element
+}
+
+type cacheEntry struct {
+	index int64
+	data  []byte
+}
+
+func newChunkCache(maxChunks int) *chunkCache {
+	return &chunkCache{
+		max:     maxChunks,
+		lru:     list.New(),
+		entries: map[int64]*list.Element{},
+	}
+}
+
+func (c *chunkCache) get(index int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[index]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).data, true
+}
+
+func (c *chunkCache) put(index int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max == 0 {
+		return
+	}
+	if e, ok := c.entries[index]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[index] = c.lru.PushFront(&cacheEntry{index: index, data: data})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).index)
+	}
+}
+
+func (c *chunkCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	clear(c.entries)
+}
@@ -0,0 +1,269 @@
+package httprange_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/httprange"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// server serves the contents with Range support, counting the requests and the most in flight at once
+type server struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	requests atomic.Int64
+	inFlight atomic.Int64
+	maxPar   atomic.Int64
+	delay    time.Duration
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		m := s.maxPar.Load()
+		if n <= m || s.maxPar.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	content, etag := s.content, s.etag
+	s.mu.Unlock()
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, "disk.img", time.Unix(1700000000, 0), bytes.NewReader(content))
+}
+
+func TestRead(t *testing.T) {
+	content := make([]byte, 5*1024*1024+1234)
+	_, _ = rand.Read(content)
+	s := &server{content: content, etag: `"v1"`, delay: 10 * time.Millisecond}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	img, err := httprange.New(ts.URL+"/images/disk.img", httprange.WithChunkSize(256*1024), httprange.WithParallelism(3))
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	if img.Size() != int64(len(content)) {
+		t.Fatalf("size %d instead of %d", img.Size(), len(content))
+	}
+	info, err := img.Stat()
+	if err != nil {
+		t.Fatalf("error getting stat: %v", err)
+	}
+	if info.Name() != "disk.img" || info.Size() != int64(len(content)) || !info.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected info %s %d %v", info.Name(), info.Size(), info.ModTime())
+	}
+	if _, err := img.Writable(); err == nil {
+		t.Errorf("no error getting image writable")
+	}
+
+	s.requests.Store(0)
+	data, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("contents of image do not match")
+	}
+	if s.maxPar.Load() > 3 {
+		t.Errorf("%d requests at once, more than 3", s.maxPar.Load())
+	}
+
+	// everything is cached now
+	requests := s.requests.Load()
+	b := make([]byte, 1024*1024)
+	if _, err := img.ReadAt(b, 100000); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content[100000:100000+len(b)]) {
+		t.Errorf("contents of cached read do not match")
+	}
+	if s.requests.Load() != requests {
+		t.Errorf("%d requests for cached read", s.requests.Load()-requests)
+	}
+
+	n, err := img.ReadAt(b, img.Size()-1000)
+	if n != 1000 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at the end instead of 1000 bytes with EOF", n, err)
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	content := make([]byte, 2*1024*1024)
+	s := &server{content: content, delay: 20 * time.Millisecond}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	img, err := httprange.New(ts.URL, httprange.WithChunkSize(64*1024), httprange.WithParallelism(8), httprange.WithCacheSize(0))
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	s.requests.Store(0)
+	if _, err := img.ReadAt(make([]byte, len(content)), 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if s.requests.Load() != 32 {
+		t.Errorf("%d requests instead of 32", s.requests.Load())
+	}
+	if s.maxPar.Load() < 2 || s.maxPar.Load() > 8 {
+		t.Errorf("%d requests at once instead of up to 8", s.maxPar.Load())
+	}
+	// without a cache, reading again fetches again
+	if _, err := img.ReadAt(make([]byte, 100), 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if s.requests.Load() != 33 {
+		t.Errorf("%d requests instead of 33", s.requests.Load())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	content := make([]byte, 1024*1024)
+	t.Run("no range support", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(content)
+		}))
+		defer ts.Close()
+		if _, err := httprange.New(ts.URL); err == nil {
+			t.Errorf("no error opening image on server without range support")
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		if _, err := httprange.New(ts.URL); err == nil {
+			t.Errorf("no error opening missing image")
+		}
+	})
+	t.Run("unsupported scheme", func(t *testing.T) {
+		if _, err := httprange.New("ftp://example.com/disk.img"); err == nil {
+			t.Errorf("no error opening ftp URL")
+		}
+	})
+	t.Run("changed image", func(t *testing.T) {
+		s := &server{content: content, etag: `"v1"`}
+		ts := httptest.NewServer(s)
+		defer ts.Close()
+		img, err := httprange.New(ts.URL, httprange.WithChunkSize(4096))
+		if err != nil {
+			t.Fatalf("error opening image: %v", err)
+		}
+		defer img.Close()
+		s.mu.Lock()
+		s.etag = `"v2"`
+		s.mu.Unlock()
+		if _, err := img.ReadAt(make([]byte, 10), 0); err == nil {
+			t.Errorf("no error reading image that changed")
+		}
+	})
+	t.Run("header", func(t *testing.T) {
+		s := &server{content: content}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			s.ServeHTTP(w, r)
+		}))
+		defer ts.Close()
+		if _, err := httprange.New(ts.URL); err == nil {
+			t.Errorf("no error opening image without authorization")
+		}
+		if _, err := httprange.New(ts.URL, httprange.WithHeader("Authorization", "Bearer token")); err != nil {
+			t.Errorf("error opening image with authorization: %v", err)
+		}
+	})
+}
+
+func TestDisk(t *testing.T) {
+	raw := filepath.Join(t.TempDir(), "disk.img")
+	b, err := file.CreateFromPath(raw, 40*1024*1024)
+	if err != nil {
+		t.Fatalf("error creating raw image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from a remote image")
+	f, err := fs.OpenFile("/remote.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+	media, err := os.ReadFile(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{content: media}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	img, err := httprange.New(ts.URL + "/disk.img")
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(img)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/remote.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+	// only the parts of the 40MB image that were read are fetched
+	if s.requests.Load() > 20 {
+		t.Errorf("%d requests, expected only a few chunks to be fetched", s.requests.Load())
+	}
+}
@@ -1,3 +1,14 @@
+// Package backend provides the interface to the storage of a disk, and wrappers for any storage.
+//
+// # Concurrency
+//
+// ReadAt of a Storage, and WriteAt of its WritableFile, must be safe for concurrent use, as
+// io.ReaderAt and io.WriterAt allow, so that filesystems may read in parallel, e.g. for
+// decompression, and a write may happen while another range is read. Concurrent writes to
+// overlapping ranges leave the range with either write. Read and Seek share an offset, and are not
+// meant to be used concurrently with each other. All backends of this module keep to this
+// contract; a Storage that does not, such as one over an fs.File that seeks for each read, can be
+// wrapped with Synchronized.
 package backend
 
 import (
@@ -24,6 +35,7 @@ type WritableFile interface {
 	io.WriterAt
 }
 
+// Storage is the storage of a disk, which must keep to the concurrency contract of the package
 type Storage interface {
 	File
 	// OS-specific file for ioctl calls via fd
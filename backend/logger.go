@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// IOStats are the counts of the I/O of a storage wrapped with WithLogger, see Stats
+type IOStats struct {
+	// Reads and BytesRead are the reads of the storage and the bytes they returned
+	Reads     int64
+	BytesRead int64
+	// Writes and BytesWritten are the writes through Writable and the bytes they wrote
+	Writes       int64
+	BytesWritten int64
+	// ReadTime and WriteTime are the time spent in reads and writes, which overlap when they are
+	// concurrent
+	ReadTime  time.Duration
+	WriteTime time.Duration
+	// CacheHits and CacheMisses are the blocks found and not found in the cache, where the
+	// storage wrapped is one of WithCache
+	CacheHits   int64
+	CacheMisses int64
+}
+
+// loggedStorage is a Storage that counts and logs its reads and writes
+type loggedStorage struct {
+	Storage
+	logger                                 *slog.Logger
+	reads, bytesRead, writes, bytesWritten atomic.Int64
+	readTime, writeTime                    atomic.Int64
+}
+
+// WithLogger wraps the storage to count its reads and writes, see Stats, and log each of them at
+// slog.LevelDebug, with its offset, size and duration, to find out where the time of slow
+// operations goes. The counts are logged at slog.LevelInfo when the storage is closed.
+func WithLogger(b Storage, logger *slog.Logger) Storage {
+	return &loggedStorage{Storage: b, logger: logger}
+}
+
+// Stats returns the counts of the I/O of a storage returned by WithLogger so far, and false for any
+// other storage
+func Stats(b Storage) (IOStats, bool) {
+	s, ok := b.(*loggedStorage)
+	if !ok {
+		return IOStats{}, false
+	}
+	stats := IOStats{
+		Reads:        s.reads.Load(),
+		BytesRead:    s.bytesRead.Load(),
+		Writes:       s.writes.Load(),
+		BytesWritten: s.bytesWritten.Load(),
+		ReadTime:     time.Duration(s.readTime.Load()),
+		WriteTime:    time.Duration(s.writeTime.Load()),
+	}
+	if c, ok := s.Storage.(*cachedStorage); ok {
+		stats.CacheHits, stats.CacheMisses = c.hits.Load(), c.misses.Load()
+	}
+	return stats, true
+}
+
+// record counts an operation and logs it at the debug level
+func (s *loggedStorage) record(op string, count, bytes, total *atomic.Int64, off int64, n int, start time.Time, err error) {
+	d := time.Since(start)
+	count.Add(1)
+	bytes.Add(int64(n))
+	total.Add(int64(d))
+	if !s.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	// sequential reads have no offset
+	var attrs []any
+	if off >= 0 {
+		attrs = append(attrs, "offset", off)
+	}
+	attrs = append(attrs, "size", n, "duration", d)
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	s.logger.Debug(op, attrs...)
+}
+
+func (s *loggedStorage) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := s.Storage.ReadAt(p, off)
+	s.record("read", &s.reads, &s.bytesRead, &s.readTime, off, n, start, err)
+	return n, err
+}
+
+func (s *loggedStorage) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := s.Storage.Read(p)
+	s.record("read", &s.reads, &s.bytesRead, &s.readTime, -1, n, start, err)
+	return n, err
+}
+
+// Sync syncs the storage
+func (s *loggedStorage) Sync() error {
+	return Sync(s.Storage)
+}
+
+// Close logs the counts of the I/O and closes the storage
+func (s *loggedStorage) Close() error {
+	stats, _ := Stats(s)
+	attrs := []any{
+		"reads", stats.Reads, "bytesRead", stats.BytesRead, "readTime", stats.ReadTime,
+		"writes", stats.Writes, "bytesWritten", stats.BytesWritten, "writeTime", stats.WriteTime,
+	}
+	if _, ok := s.Storage.(*cachedStorage); ok {
+		attrs = append(attrs, "cacheHits", stats.CacheHits, "cacheMisses", stats.CacheMisses)
+	}
+	s.logger.Info("closing storage", attrs...)
+	return s.Storage.Close()
+}
+
+// Writable returns a file whose writes are counted and logged as well
+func (s *loggedStorage) Writable() (WritableFile, error) {
+	w, err := s.Storage.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &loggedWritable{loggedStorage: s, w: w}, nil
+}
+
+type loggedWritable struct {
+	*loggedStorage
+	w WritableFile
+}
+
+func (l *loggedWritable) WriteAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := l.w.WriteAt(p, off)
+	l.record("write", &l.writes, &l.bytesWritten, &l.writeTime, off, n, start, err)
+	return n, err
+}
@@ -0,0 +1,56 @@
+package backend_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+func TestWithLogger(t *testing.T) {
+	c, err := backend.WithCache(mem.New(make([]byte, 8*4096), false), 4*4096, 4096)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	var logs bytes.Buffer
+	s := backend.WithLogger(c, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	b := make([]byte, 100)
+	for i := 0; i < 2; i++ {
+		if _, err := s.ReadAt(b, 4096); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+	}
+	w, err := s.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable file: %v", err)
+	}
+	if _, err := w.WriteAt(b, 8192); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	stats, ok := backend.Stats(s)
+	if !ok {
+		t.Fatal("no stats of the storage")
+	}
+	expected := backend.IOStats{Reads: 2, BytesRead: 200, Writes: 1, BytesWritten: 100, CacheHits: 1, CacheMisses: 1}
+	stats.ReadTime, stats.WriteTime = 0, 0
+	if stats != expected {
+		t.Errorf("stats %+v instead of %+v", stats, expected)
+	}
+	if !strings.Contains(logs.String(), "msg=read offset=4096 size=100") || !strings.Contains(logs.String(), "msg=write offset=8192 size=100") {
+		t.Errorf("reads and writes not logged in %q", logs.String())
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	if !strings.Contains(logs.String(), "reads=2 bytesRead=200") || !strings.Contains(logs.String(), "cacheHits=1 cacheMisses=1") {
+		t.Errorf("stats not logged when closing in %q", logs.String())
+	}
+	if _, ok := backend.Stats(c); ok {
+		t.Error("stats of a storage not wrapped with WithLogger")
+	}
+}
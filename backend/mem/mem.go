@@ -0,0 +1,232 @@
+// Package mem provides a backend held entirely in memory, for tests and for building small images,
+// such as seed or boot images, without temporary files.
+//
+// The storage grows when written beyond its end, up to an optional maximum size, so that filesystems
+// written out in one go, such as ISO9660 by Finalize, can be created without knowing their size.
+package mem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// ErrMaxSize is returned for writes beyond the maximum size of the storage
+var ErrMaxSize = errors.New("write beyond the maximum size of the storage")
+
+// Storage is a backend.Storage held in memory
+type Storage struct {
+	// mu guards data and offset; reads may run concurrently
+	mu       sync.RWMutex
+	data     []byte
+	offset   int64
+	maxSize  int64
+	readOnly bool
+	modTime  time.Time
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Storage)(nil)
+
+type opts struct {
+	maxSize int64
+}
+
+// Opt func that process New and Create options
+type Opt func(o *opts)
+
+// WithMaxSize limits the size to which the storage grows when written beyond its end.
+// The default, 0, means there is no limit.
+func WithMaxSize(size int64) Opt {
+	return func(o *opts) {
+		o.maxSize = size
+	}
+}
+
+// New creates a storage over the contents of b, which is used directly and modified by writes
+func New(b []byte, readOnly bool, options ...Opt) *Storage {
+	o := &opts{}
+	for _, opt := range options {
+		opt(o)
+	}
+	return &Storage{
+		data:     b,
+		maxSize:  o.maxSize,
+		readOnly: readOnly,
+		modTime:  time.Now(),
+	}
+}
+
+// Create creates a writable storage of the given size, filled with zeroes
+func Create(size int64, options ...Opt) (*Storage, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	s := New(nil, false, options...)
+	if s.maxSize > 0 && size > s.maxSize {
+		return nil, fmt.Errorf("size %d is larger than the maximum size %d", size, s.maxSize)
+	}
+	s.data = make([]byte, size)
+	return s, nil
+}
+
+// Bytes returns the current contents of the storage, which are only valid until the next write
+func (s *Storage) Bytes() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data
+}
+
+// Size is the current size of the storage
+func (s *Storage) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.data))
+}
+
+// ReadAt reads from the storage, returning io.EOF for reads that reach its end
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if off >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes to the storage, growing it to the end of the write if needed. Of a write beyond
+// the maximum size, the part that fits is written, and ErrMaxSize returned.
+func (s *Storage) WriteAt(p []byte, off int64) (int, error) {
+	if s.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeAt(p, off)
+}
+
+func (s *Storage) writeAt(p []byte, off int64) (int, error) {
+	var err error
+	end := off + int64(len(p))
+	if s.maxSize > 0 && end > s.maxSize {
+		if off >= s.maxSize {
+			return 0, ErrMaxSize
+		}
+		end = s.maxSize
+		p = p[:end-off]
+		err = ErrMaxSize
+	}
+	if size := int64(len(s.data)); end > size {
+		s.data = slices.Grow(s.data, int(end-size))[:end]
+		// the grown capacity is not necessarily zeroed
+		clear(s.data[size:end])
+	}
+	n := copy(s.data[off:], p)
+	s.modTime = time.Now()
+	return n, err
+}
+
+// Write writes to the storage at the current offset
+func (s *Storage) Write(p []byte) (int, error) {
+	if s.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.writeAt(p, s.offset)
+	s.offset += int64(n)
+	return n, err
+}
+
+// Read reads from the storage at the current offset
+func (s *Storage) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offset >= int64(len(s.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.data[s.offset:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read or Write
+func (s *Storage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += int64(len(s.data))
+	default:
+		return s.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Stat describes the storage, with its current size
+func (s *Storage) Stat() (fs.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &storageInfo{size: int64(len(s.data)), modTime: s.modTime, readOnly: s.readOnly}, nil
+}
+
+// Close does nothing, the contents remain accessible with Bytes
+func (s *Storage) Close() error {
+	return nil
+}
+
+// Sys is not suitable for storage in memory, which has no file
+func (s *Storage) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the storage itself, unless it is read-only
+func (s *Storage) Writable() (backend.WritableFile, error) {
+	if s.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return s, nil
+}
+
+// storageInfo describes storage in memory
+type storageInfo struct {
+	size     int64
+	modTime  time.Time
+	readOnly bool
+}
+
+func (i *storageInfo) Name() string { return "mem" }
+func (i *storageInfo) Size() int64  { return i.size }
+func (i *storageInfo) Mode() fs.FileMode {
+	if i.readOnly {
+		return 0o444
+	}
+	return 0o644
+}
+func (i *storageInfo) ModTime() time.Time { return i.modTime }
+func (i *storageInfo) IsDir() bool        { return false }
+func (i *storageInfo) Sys() any           { return nil }
@@ -0,0 +1,176 @@
+package mem_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestReadWrite(t *testing.T) {
+	s, err := mem.Create(10)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	if _, err := s.WriteAt([]byte("abc"), 2); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	// grows when written beyond the end, with zeroes in the gap
+	if _, err := s.WriteAt([]byte("xyz"), 15); err != nil {
+		t.Fatalf("error writing beyond end: %v", err)
+	}
+	expected := []byte("\x00\x00abc\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00xyz")
+	if !bytes.Equal(s.Bytes(), expected) {
+		t.Errorf("contents %q instead of %q", s.Bytes(), expected)
+	}
+	info, err := s.Stat()
+	if err != nil {
+		t.Fatalf("error getting stat: %v", err)
+	}
+	if info.Size() != 18 {
+		t.Errorf("size %d instead of 18", info.Size())
+	}
+	b := make([]byte, 5)
+	n, err := s.ReadAt(b, 15)
+	if n != 3 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at the end instead of 3 bytes with EOF", n, err)
+	}
+	if _, err := s.Seek(2, io.SeekStart); err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	if _, err := s.Write([]byte("ABCD")); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if _, err := s.Seek(-4, io.SeekCurrent); err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	copy(expected[2:], "ABCD")
+	if !bytes.Equal(got, expected[2:]) {
+		t.Errorf("read %q after seeking", got)
+	}
+	if _, err := s.Sys(); !errors.Is(err, backend.ErrNotSuitable) {
+		t.Errorf("error %v instead of ErrNotSuitable for Sys", err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	if _, err := mem.Create(100, mem.WithMaxSize(10)); err == nil {
+		t.Errorf("no error creating storage larger than maximum size")
+	}
+	s, err := mem.Create(0, mem.WithMaxSize(10))
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	n, err := s.WriteAt([]byte("0123456789abc"), 0)
+	if n != 10 || !errors.Is(err, mem.ErrMaxSize) {
+		t.Errorf("wrote %d bytes with error %v instead of 10 bytes with ErrMaxSize", n, err)
+	}
+	if n, err := s.WriteAt([]byte("x"), 20); n != 0 || !errors.Is(err, mem.ErrMaxSize) {
+		t.Errorf("wrote %d bytes with error %v beyond the maximum size", n, err)
+	}
+	if s.Size() != 10 {
+		t.Errorf("size %d instead of 10", s.Size())
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := mem.New([]byte("read only"), true)
+	if _, err := s.WriteAt([]byte("x"), 0); !errors.Is(err, backend.ErrIncorrectOpenMode) {
+		t.Errorf("error %v instead of ErrIncorrectOpenMode writing read-only storage", err)
+	}
+	if _, err := s.Writable(); !errors.Is(err, backend.ErrIncorrectOpenMode) {
+		t.Errorf("error %v instead of ErrIncorrectOpenMode for Writable", err)
+	}
+}
+
+func TestDisk(t *testing.T) {
+	s, err := mem.Create(40 * 1024 * 1024)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	d, err := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from memory")
+	f, err := fs.OpenFile("/mem.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// the same contents read through a new read-only storage
+	d, err = diskfs.OpenBackend(mem.New(s.Bytes(), true))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/mem.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
+
+func TestGrowingISO(t *testing.T) {
+	s, err := mem.Create(0)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	fs, err := iso9660.Create(s, 0, 0, 2048, t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/README.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("iso in memory")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing: %v", err)
+	}
+	if s.Size() == 0 || s.Size()%2048 != 0 {
+		t.Errorf("unexpected size %d of finalized ISO", s.Size())
+	}
+	if _, err := iso9660.Read(s, s.Size(), 0, 2048); err != nil {
+		t.Errorf("error reading finalized ISO: %v", err)
+	}
+}
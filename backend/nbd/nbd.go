@@ -0,0 +1,576 @@
+// Package nbd provides a backend for exports of Network Block Device (NBD) servers, such as
+// qemu-nbd and nbdkit, which makes every image format and storage those servers support available.
+//
+// The client uses fixed newstyle negotiation, optionally upgraded to TLS with NBD_OPT_STARTTLS,
+// selects the export with NBD_OPT_GO, or NBD_OPT_EXPORT_NAME for older servers, and sends one
+// command at a time with simple replies.
+//
+// The Server does the reverse, exporting any backend.Storage, such as a whole disk, a single
+// partition, or a copy-on-write overlay of either, to NBD clients like QEMU and nbd-client.
+package nbd
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// DefaultPort is the port NBD servers listen on by default
+const DefaultPort = "10809"
+
+// Client is an open export of an NBD server
+type Client struct {
+	conn     net.Conn
+	readOnly bool
+	export   string
+	size     int64
+	flags    uint16
+	maxBlock int64
+	// mu guards the connection, handle and err, so that one command is sent at a time
+	mu     sync.Mutex
+	handle uint64
+	// err is set when the connection can no longer be used
+	err error
+	// offsetMu guards the offset for Read and Seek
+	offsetMu sync.Mutex
+	offset   int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Client)(nil)
+
+type opts struct {
+	export    string
+	tlsConfig *tls.Config
+}
+
+// Opt func that process New options
+type Opt func(o *opts) error
+
+// WithExportName selects the export of the server, the default export if not set
+func WithExportName(name string) Opt {
+	return func(o *opts) error {
+		if len(name) > 4096 {
+			return fmt.Errorf("export name of %d bytes is too long", len(name))
+		}
+		o.export = name
+		return nil
+	}
+}
+
+// WithTLS upgrades the connection to TLS with the configuration before selecting the export. The
+// server must support NBD_OPT_STARTTLS.
+func WithTLS(config *tls.Config) Opt {
+	return func(o *opts) error {
+		if config == nil {
+			return errors.New("must pass TLS configuration")
+		}
+		o.tlsConfig = config
+		return nil
+	}
+}
+
+// Dial connects to the NBD server at the address, e.g. "tcp" and "localhost:10809", or "unix"
+// and the path of a socket, and opens the export. For TLS, the server name is taken from the
+// address if the configuration has none.
+func Dial(network, address string, readOnly bool, options ...Opt) (*Client, error) {
+	o := &opts{}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.tlsConfig != nil && o.tlsConfig.ServerName == "" && network != "unix" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		o.tlsConfig = o.tlsConfig.Clone()
+		o.tlsConfig.ServerName = host
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to NBD server %s: %w", address, err)
+	}
+	c, err := newClient(conn, readOnly, o)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open export of NBD server %s: %w", address, err)
+	}
+	return c, nil
+}
+
+// OpenURL opens the export of an NBD URL, as used by qemu and nbdkit:
+//
+//	nbd://host[:port][/export]
+//	nbds://host[:port][/export]
+//	nbd+unix:///[export]?socket=/path/to/socket
+//	nbds+unix:///[export]?socket=/path/to/socket
+//
+// The nbds schemes use TLS, with the configuration of WithTLS or the defaults. Options override the
+// export of the URL.
+func OpenURL(rawURL string, readOnly bool, options ...Opt) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	var network, address string
+	switch u.Scheme {
+	case "nbd", "nbds":
+		network, address = "tcp", u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), DefaultPort)
+		}
+	case "nbd+unix", "nbds+unix":
+		network, address = "unix", u.Query().Get("socket")
+		if address == "" {
+			return nil, fmt.Errorf("URL %s has no socket", rawURL)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	urlOptions := []Opt{WithExportName(strings.TrimPrefix(u.Path, "/"))}
+	if strings.HasPrefix(u.Scheme, "nbds") {
+		urlOptions = append(urlOptions, WithTLS(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	return Dial(network, address, readOnly, append(urlOptions, options...)...)
+}
+
+// New opens an export over a connection to an NBD server. The connection is closed with the Client.
+func New(conn net.Conn, readOnly bool, options ...Opt) (*Client, error) {
+	o := &opts{}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return newClient(conn, readOnly, o)
+}
+
+func newClient(conn net.Conn, readOnly bool, o *opts) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		readOnly: readOnly,
+		export:   o.export,
+		maxBlock: maxRequestSize,
+	}
+	if err := c.negotiate(o.tlsConfig); err != nil {
+		return nil, err
+	}
+	if !readOnly && c.flags&flagReadOnly != 0 {
+		return nil, errors.New("export is read-only")
+	}
+	return c, nil
+}
+
+// negotiate runs the fixed newstyle handshake, and selects the export
+func (c *Client) negotiate(tlsConfig *tls.Config) error {
+	var hello struct {
+		Magic    uint64
+		OptMagic uint64
+		Flags    uint16
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &hello); err != nil {
+		return fmt.Errorf("error reading handshake: %w", err)
+	}
+	if hello.Magic != nbdMagic {
+		return errors.New("not an NBD server")
+	}
+	if hello.OptMagic != optMagic {
+		return errors.New("server does not support newstyle negotiation")
+	}
+	if hello.Flags&flagFixedNewstyle == 0 {
+		return errors.New("server does not support fixed newstyle negotiation")
+	}
+	clientFlags := clientFixedNewstyle
+	noZeroes := hello.Flags&flagNoZeroes != 0
+	if noZeroes {
+		clientFlags |= clientNoZeroes
+	}
+	if err := binary.Write(c.conn, binary.BigEndian, clientFlags); err != nil {
+		return fmt.Errorf("error writing handshake: %w", err)
+	}
+
+	if tlsConfig != nil {
+		if err := c.startTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	err := c.optGo()
+	if !errors.Is(err, errUnsupported) {
+		return err
+	}
+	return c.optExportName(noZeroes)
+}
+
+// errUnsupported is returned by optGo when the server does not know NBD_OPT_GO
+var errUnsupported = errors.New("option not supported")
+
+// sendOption sends an option with its data
+func (c *Client) sendOption(option uint32, data []byte) error {
+	b := make([]byte, 16, 16+len(data))
+	binary.BigEndian.PutUint64(b[0:8], optMagic)
+	binary.BigEndian.PutUint32(b[8:12], option)
+	binary.BigEndian.PutUint32(b[12:16], uint32(len(data)))
+	if _, err := c.conn.Write(append(b, data...)); err != nil {
+		return fmt.Errorf("error sending option %d: %w", option, err)
+	}
+	return nil
+}
+
+// readReply reads the reply to an option, and its data
+func (c *Client) readReply(option uint32) (reply uint32, data []byte, err error) {
+	var header struct {
+		Magic  uint64
+		Option uint32
+		Reply  uint32
+		Length uint32
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &header); err != nil {
+		return 0, nil, fmt.Errorf("error reading reply to option %d: %w", option, err)
+	}
+	if header.Magic != replyMagic {
+		return 0, nil, fmt.Errorf("invalid reply magic %#x", header.Magic)
+	}
+	if header.Option != option {
+		return 0, nil, fmt.Errorf("reply for option %d instead of %d", header.Option, option)
+	}
+	// replies are small, anything large is a broken server
+	if header.Length > 64*1024 {
+		return 0, nil, fmt.Errorf("reply of %d bytes is too large", header.Length)
+	}
+	data = make([]byte, header.Length)
+	if _, err := io.ReadFull(c.conn, data); err != nil {
+		return 0, nil, fmt.Errorf("error reading reply to option %d: %w", option, err)
+	}
+	return header.Reply, data, nil
+}
+
+// startTLS upgrades the connection to TLS
+func (c *Client) startTLS(config *tls.Config) error {
+	if err := c.sendOption(optStartTLS, nil); err != nil {
+		return err
+	}
+	reply, data, err := c.readReply(optStartTLS)
+	if err != nil {
+		return err
+	}
+	if reply != repAck {
+		return fmt.Errorf("could not start TLS: %w", replyError(reply, string(data)))
+	}
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// optGo selects the export with NBD_OPT_GO, requesting its block sizes
+func (c *Client) optGo() error {
+	data := make([]byte, 0, 4+len(c.export)+4)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(c.export)))
+	data = append(data, c.export...)
+	data = binary.BigEndian.AppendUint16(data, 1)
+	data = binary.BigEndian.AppendUint16(data, infoBlockSize)
+	if err := c.sendOption(optGo, data); err != nil {
+		return err
+	}
+	haveExport := false
+	for {
+		reply, data, err := c.readReply(optGo)
+		if err != nil {
+			return err
+		}
+		switch {
+		case reply == repAck:
+			if !haveExport {
+				return errors.New("server did not describe export")
+			}
+			return nil
+		case reply == repInfo:
+			if len(data) < 2 {
+				return errors.New("invalid information reply")
+			}
+			switch binary.BigEndian.Uint16(data) {
+			case infoExport:
+				if len(data) != 12 {
+					return errors.New("invalid export information reply")
+				}
+				c.size = int64(binary.BigEndian.Uint64(data[2:10]))
+				c.flags = binary.BigEndian.Uint16(data[10:12])
+				haveExport = true
+			case infoBlockSize:
+				if len(data) != 14 {
+					return errors.New("invalid block size information reply")
+				}
+				if maxBlock := int64(binary.BigEndian.Uint32(data[10:14])); maxBlock > 0 && maxBlock < c.maxBlock {
+					c.maxBlock = maxBlock
+				}
+			}
+		case reply == repErrUnsup:
+			return errUnsupported
+		case reply&repError != 0:
+			return replyError(reply, string(data))
+		}
+	}
+}
+
+// optExportName selects the export with NBD_OPT_EXPORT_NAME, for servers without NBD_OPT_GO
+func (c *Client) optExportName(noZeroes bool) error {
+	if err := c.sendOption(optExportName, []byte(c.export)); err != nil {
+		return err
+	}
+	b := make([]byte, 10+exportNamePadding)
+	if noZeroes {
+		b = b[:10]
+	}
+	// the server closes the connection if it does not have the export
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		return fmt.Errorf("could not open export %q: %w", c.export, err)
+	}
+	c.size = int64(binary.BigEndian.Uint64(b[0:8]))
+	c.flags = binary.BigEndian.Uint16(b[8:10])
+	return nil
+}
+
+// command sends a command with the data to write, and reads the reply with the data read into out
+func (c *Client) command(cmd uint16, offset, length int64, data, out []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.handle++
+	b := make([]byte, 28, 28+len(data))
+	binary.BigEndian.PutUint32(b[0:4], requestMagic)
+	binary.BigEndian.PutUint16(b[6:8], cmd)
+	binary.BigEndian.PutUint64(b[8:16], c.handle)
+	binary.BigEndian.PutUint64(b[16:24], uint64(offset))
+	binary.BigEndian.PutUint32(b[24:28], uint32(length))
+	if _, err := c.conn.Write(append(b, data...)); err != nil {
+		c.err = fmt.Errorf("connection to NBD server failed: %w", err)
+		return c.err
+	}
+	if cmd == cmdDisc {
+		return nil
+	}
+
+	reply := b[:16]
+	if _, err := io.ReadFull(c.conn, reply); err != nil {
+		c.err = fmt.Errorf("connection to NBD server failed: %w", err)
+		return c.err
+	}
+	if magic := binary.BigEndian.Uint32(reply[0:4]); magic != simpleMagic {
+		c.err = fmt.Errorf("invalid reply magic %#x", magic)
+		return c.err
+	}
+	if handle := binary.BigEndian.Uint64(reply[8:16]); handle != c.handle {
+		c.err = fmt.Errorf("reply for request %d instead of %d", handle, c.handle)
+		return c.err
+	}
+	// an error reply has no data
+	if errno := binary.BigEndian.Uint32(reply[4:8]); errno != 0 {
+		return Error(errno)
+	}
+	if out != nil {
+		if _, err := io.ReadFull(c.conn, out); err != nil {
+			c.err = fmt.Errorf("connection to NBD server failed: %w", err)
+			return c.err
+		}
+	}
+	return nil
+}
+
+// Size is the size of the export
+func (c *Client) Size() int64 {
+	return c.size
+}
+
+// ExportName is the name of the export, empty for the default export
+func (c *Client) ExportName() string {
+	return c.export
+}
+
+// ReadAt reads from the export
+func (c *Client) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > c.size {
+		p = p[:c.size-off]
+		eof = io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		length := min(int64(len(p)-n), c.maxBlock)
+		if err := c.command(cmdRead, off+int64(n), length, nil, p[n:n+int(length)]); err != nil {
+			return n, fmt.Errorf("error reading %d bytes at %d: %w", length, off+int64(n), err)
+		}
+		n += int(length)
+	}
+	return n, eof
+}
+
+// WriteAt writes to the export, which cannot grow
+func (c *Client) WriteAt(p []byte, off int64) (int, error) {
+	if c.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > c.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the export of size %d", len(p), off, c.size)
+	}
+	n := 0
+	for n < len(p) {
+		length := min(int64(len(p)-n), c.maxBlock)
+		if err := c.command(cmdWrite, off+int64(n), length, p[n:n+int(length)], nil); err != nil {
+			return n, fmt.Errorf("error writing %d bytes at %d: %w", length, off+int64(n), err)
+		}
+		n += int(length)
+	}
+	return n, nil
+}
+
+// Flush makes the server write the data it has cached to its storage, if it supports it
+func (c *Client) Flush() error {
+	if c.readOnly || c.flags&flagSendFlush == 0 {
+		return nil
+	}
+	if err := c.command(cmdFlush, 0, 0, nil, nil); err != nil {
+		return fmt.Errorf("error flushing: %w", err)
+	}
+	return nil
+}
+
+// Sync flushes the server, as Flush
+func (c *Client) Sync() error {
+	return c.Flush()
+}
+
+// Trim tells the server that a range is no longer used, so that it can deallocate it. Reads of the
+// range afterwards may return anything. It returns errors.ErrUnsupported if the export does not
+// support it.
+func (c *Client) Trim(offset, length int64) error {
+	if c.readOnly {
+		return backend.ErrIncorrectOpenMode
+	}
+	if c.flags&flagSendTrim == 0 {
+		return errors.ErrUnsupported
+	}
+	if offset < 0 || length < 0 || offset+length > c.size {
+		return fmt.Errorf("range of %d bytes at %d is outside of the export of size %d", length, offset, c.size)
+	}
+	for length > 0 {
+		n := min(length, c.maxBlock)
+		if err := c.command(cmdTrim, offset, n, nil, nil); err != nil {
+			return fmt.Errorf("error trimming %d bytes at %d: %w", n, offset, err)
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
+
+// Stat describes the export, named after it
+func (c *Client) Stat() (fs.FileInfo, error) {
+	mode := fs.FileMode(0o644)
+	if c.readOnly {
+		mode = 0o444
+	}
+	name := c.export
+	if name == "" {
+		name = "nbd"
+	}
+	return &exportInfo{name: name, size: c.size, mode: mode}, nil
+}
+
+// Read reads from the export at the current offset
+func (c *Client) Read(b []byte) (int, error) {
+	c.offsetMu.Lock()
+	offset := c.offset
+	c.offsetMu.Unlock()
+	n, err := c.ReadAt(b, offset)
+	c.offsetMu.Lock()
+	c.offset = offset + int64(n)
+	c.offsetMu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the export
+func (c *Client) Seek(offset int64, whence int) (int64, error) {
+	c.offsetMu.Lock()
+	defer c.offsetMu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return c.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return c.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// Close flushes the export, and disconnects from the server
+func (c *Client) Close() error {
+	flushErr := c.Flush()
+	// the server closes the connection without a reply
+	_ = c.command(cmdDisc, 0, 0, nil, nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = errors.New("client is closed")
+	}
+	if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return flushErr
+}
+
+// Sys is not suitable for NBD exports, which have no local file
+func (c *Client) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the client, unless opened read-only
+func (c *Client) Writable() (backend.WritableFile, error) {
+	if c.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return c, nil
+}
+
+// exportInfo describes an NBD export
+type exportInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (i *exportInfo) Name() string       { return i.name }
+func (i *exportInfo) Size() int64        { return i.size }
+func (i *exportInfo) Mode() fs.FileMode  { return i.mode }
+func (i *exportInfo) ModTime() time.Time { return time.Time{} }
+func (i *exportInfo) IsDir() bool        { return false }
+func (i *exportInfo) Sys() any           { return nil }
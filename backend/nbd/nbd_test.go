@@ -0,0 +1,456 @@
+package nbd_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/nbd"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// fakeServer is a minimal NBD server with a single export held in memory
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+	export   string
+	readOnly bool
+	// noGo makes the server refuse NBD_OPT_GO, as old servers do
+	noGo      bool
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	data      []byte
+}
+
+func newFakeServer(t *testing.T, size int) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, listener: l, export: "disk", data: make([]byte, size)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	hello := binary.BigEndian.AppendUint64(nil, 0x4e42444d41474943)
+	hello = binary.BigEndian.AppendUint64(hello, 0x49484156454F5054)
+	hello = binary.BigEndian.AppendUint16(hello, 1|2)
+	if _, err := conn.Write(hello); err != nil {
+		return
+	}
+	var clientFlags uint32
+	if err := binary.Read(conn, binary.BigEndian, &clientFlags); err != nil {
+		return
+	}
+	reply := func(option, typ uint32, data []byte) error {
+		b := binary.BigEndian.AppendUint64(nil, 0x3e889045565a9)
+		b = binary.BigEndian.AppendUint32(b, option)
+		b = binary.BigEndian.AppendUint32(b, typ)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+		_, err := conn.Write(append(b, data...))
+		return err
+	}
+	flags := uint16(1 | 4 | 32)
+	s.mu.Lock()
+	if s.readOnly {
+		flags |= 2
+	}
+	s.mu.Unlock()
+	// without NBD_OPT_GO, clients cannot know the maximum, and assume 32MB
+	maxLength := uint32(32 * 1024 * 1024)
+	for negotiating := true; negotiating; {
+		var header struct {
+			Magic  uint64
+			Option uint32
+			Length uint32
+		}
+		if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+			return
+		}
+		data := make([]byte, header.Length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		switch {
+		case header.Option == 5 && s.tlsConfig != nil:
+			if err := reply(header.Option, 1, nil); err != nil {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+		case s.tlsConfig != nil && !isTLS(conn):
+			if err := reply(header.Option, 1<<31|5, []byte("TLS required")); err != nil {
+				return
+			}
+		case header.Option == 7 && !s.noGo:
+			name := string(data[4 : 4+binary.BigEndian.Uint32(data)])
+			if name != s.export {
+				if err := reply(header.Option, 1<<31|6, []byte("no such export")); err != nil {
+					return
+				}
+				continue
+			}
+			info := binary.BigEndian.AppendUint16(nil, 0)
+			info = binary.BigEndian.AppendUint64(info, uint64(len(s.data)))
+			info = binary.BigEndian.AppendUint16(info, flags)
+			blockSize := binary.BigEndian.AppendUint16(nil, 3)
+			blockSize = binary.BigEndian.AppendUint32(blockSize, 1)
+			blockSize = binary.BigEndian.AppendUint32(blockSize, 4096)
+			// a small maximum makes the client split requests
+			maxLength = 64 * 1024
+			blockSize = binary.BigEndian.AppendUint32(blockSize, maxLength)
+			if reply(header.Option, 3, info) != nil || reply(header.Option, 3, blockSize) != nil || reply(header.Option, 1, nil) != nil {
+				return
+			}
+			negotiating = false
+		case header.Option == 1:
+			if string(data) != s.export {
+				return
+			}
+			b := binary.BigEndian.AppendUint64(nil, uint64(len(s.data)))
+			b = binary.BigEndian.AppendUint16(b, flags)
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+			negotiating = false
+		default:
+			if err := reply(header.Option, 1<<31|1, nil); err != nil {
+				return
+			}
+		}
+	}
+
+	for {
+		var req struct {
+			Magic  uint32
+			Flags  uint16
+			Type   uint16
+			Handle uint64
+			Offset uint64
+			Length uint32
+		}
+		if err := binary.Read(conn, binary.BigEndian, &req); err != nil {
+			return
+		}
+		if req.Magic != 0x25609513 {
+			s.t.Errorf("invalid request magic %#x", req.Magic)
+			return
+		}
+		if req.Length > maxLength {
+			s.t.Errorf("request of %d bytes is larger than the maximum", req.Length)
+			return
+		}
+		var errno uint32
+		var out []byte
+		s.mu.Lock()
+		inRange := req.Offset+uint64(req.Length) <= uint64(len(s.data))
+		switch req.Type {
+		case 0:
+			if inRange {
+				out = s.data[req.Offset : req.Offset+uint64(req.Length)]
+			} else {
+				errno = 22
+			}
+		case 1:
+			b := make([]byte, req.Length)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			switch {
+			case s.readOnly:
+				errno = 1
+			case !inRange:
+				errno = 28
+			default:
+				copy(s.data[req.Offset:], b)
+			}
+		case 2:
+			s.mu.Unlock()
+			return
+		case 3:
+		case 4:
+			if inRange {
+				clear(s.data[req.Offset : req.Offset+uint64(req.Length)])
+			} else {
+				errno = 22
+			}
+		default:
+			errno = 22
+		}
+		b := binary.BigEndian.AppendUint32(nil, 0x67446698)
+		b = binary.BigEndian.AppendUint32(b, errno)
+		b = binary.BigEndian.AppendUint64(b, req.Handle)
+		_, err := conn.Write(append(b, out...))
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) setReadOnly(readOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOnly = readOnly
+}
+
+func isTLS(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// selfSigned creates a certificate for 127.0.0.1, and a pool to verify it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestClient(t *testing.T) {
+	tests := []struct {
+		name string
+		noGo bool
+	}{
+		{"go", false},
+		{"export name", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, 300*1024+100)
+			s.noGo = tt.noGo
+			_, _ = rand.Read(s.data)
+			expected := append([]byte(nil), s.data...)
+
+			c, err := nbd.OpenURL("nbd://"+s.addr()+"/disk", false)
+			if err != nil {
+				t.Fatalf("error opening export: %v", err)
+			}
+			defer c.Close()
+			if c.Size() != int64(len(expected)) {
+				t.Errorf("size %d instead of %d", c.Size(), len(expected))
+			}
+			b, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(b, expected) {
+				t.Errorf("contents do not match")
+			}
+
+			content := bytes.Repeat([]byte("written over nbd"), 10000)
+			if _, err := c.WriteAt(content, 1000); err != nil {
+				t.Fatalf("error writing: %v", err)
+			}
+			copy(expected[1000:], content)
+			if _, err := c.WriteAt([]byte("beyond"), c.Size()-2); err == nil {
+				t.Errorf("no error writing beyond the end")
+			}
+			b = make([]byte, len(expected))
+			if _, err := c.ReadAt(b, 0); err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(b, expected) {
+				t.Errorf("contents do not match after write")
+			}
+			n, err := c.ReadAt(b[:100], c.Size()-50)
+			if n != 50 || err != io.EOF {
+				t.Errorf("read %d bytes with error %v at the end instead of 50 bytes with EOF", n, err)
+			}
+
+			if err := c.Trim(4096, 8192); err != nil {
+				t.Fatalf("error trimming: %v", err)
+			}
+			if _, err := c.ReadAt(b[:8192], 4096); err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(b[:8192], make([]byte, 8192)) {
+				t.Errorf("trimmed range is not zeroes")
+			}
+			if err := c.Flush(); err != nil {
+				t.Errorf("error flushing: %v", err)
+			}
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	s := newFakeServer(t, 4096)
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	copy(s.data, "over TLS")
+
+	if _, err := nbd.Dial("tcp", s.addr(), true, nbd.WithExportName("disk")); err == nil {
+		t.Errorf("no error opening export without TLS from server that requires it")
+	}
+	c, err := nbd.Dial("tcp", s.addr(), true, nbd.WithExportName("disk"), nbd.WithTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer c.Close()
+	b := make([]byte, 8)
+	if _, err := c.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(b) != "over TLS" {
+		t.Errorf("read %q instead of %q", b, "over TLS")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newFakeServer(t, 4096)
+	if _, err := nbd.Dial("tcp", s.addr(), true, nbd.WithExportName("missing")); err == nil {
+		t.Errorf("no error opening missing export")
+	}
+
+	s.setReadOnly(true)
+	if _, err := nbd.Dial("tcp", s.addr(), false, nbd.WithExportName("disk")); err == nil {
+		t.Errorf("no error opening read-only export for writing")
+	}
+	c, err := nbd.Dial("tcp", s.addr(), true, nbd.WithExportName("disk"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only export")
+	}
+
+	// a server error is returned, without breaking the connection
+	s.setReadOnly(false)
+	w, err := nbd.Dial("tcp", s.addr(), false, nbd.WithExportName("disk"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer w.Close()
+	s.setReadOnly(true)
+	var nbdErr nbd.Error
+	if _, err := w.WriteAt([]byte("x"), 0); !errors.As(err, &nbdErr) || nbdErr != 1 {
+		t.Errorf("error %v instead of EPERM", err)
+	}
+	if _, err := w.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Errorf("error reading after server error: %v", err)
+	}
+
+	if _, err := nbd.OpenURL("http://"+s.addr(), true); err == nil {
+		t.Errorf("no error opening URL with invalid scheme")
+	}
+	if _, err := nbd.OpenURL("nbd+unix:///disk", true); err == nil {
+		t.Errorf("no error opening unix URL without socket")
+	}
+}
+
+func TestDisk(t *testing.T) {
+	s := newFakeServer(t, 40*1024*1024)
+	c, err := nbd.Dial("tcp", s.addr(), false, nbd.WithExportName("disk"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	d, err := diskfs.OpenBackend(c, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("stored on an nbd export")
+	f, err := fs.OpenFile("/nbd.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	c, err = nbd.Dial("tcp", s.addr(), true, nbd.WithExportName("disk"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	d, err = diskfs.OpenBackend(c)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/nbd.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
@@ -0,0 +1,128 @@
+package nbd
+
+import "fmt"
+
+// constants of the NBD protocol, see https://github.com/NetworkBlockDevice/nbd/blob/master/doc/proto.md
+
+const (
+	nbdMagic     uint64 = 0x4e42444d41474943 // NBDMAGIC
+	optMagic     uint64 = 0x49484156454F5054 // IHAVEOPT
+	replyMagic   uint64 = 0x3e889045565a9
+	requestMagic uint32 = 0x25609513
+	simpleMagic  uint32 = 0x67446698
+
+	// handshake flags sent by the server, and the matching flags of the client
+	flagFixedNewstyle uint16 = 1 << 0
+	flagNoZeroes      uint16 = 1 << 1
+
+	clientFixedNewstyle uint32 = 1 << 0
+	clientNoZeroes      uint32 = 1 << 1
+
+	optExportName      uint32 = 1
+	optAbort           uint32 = 2
+	optList            uint32 = 3
+	optStartTLS        uint32 = 5
+	optInfo            uint32 = 6
+	optGo              uint32 = 7
+	optStructuredReply uint32 = 8
+
+	repAck    uint32 = 1
+	repServer uint32 = 2
+	repInfo   uint32 = 3
+	repError  uint32 = 1 << 31
+
+	repErrUnsup       = repError | 1
+	repErrPolicy      = repError | 2
+	repErrInvalid     = repError | 3
+	repErrPlatform    = repError | 4
+	repErrTLSReqd     = repError | 5
+	repErrUnknown     = repError | 6
+	repErrShutdown    = repError | 7
+	repErrBlockSzReqd = repError | 8
+
+	infoExport      uint16 = 0
+	infoName        uint16 = 1
+	infoDescription uint16 = 2
+	infoBlockSize   uint16 = 3
+
+	// transmission flags of an export
+	flagHasFlags        uint16 = 1 << 0
+	flagReadOnly        uint16 = 1 << 1
+	flagSendFlush       uint16 = 1 << 2
+	flagSendFUA         uint16 = 1 << 3
+	flagRotational      uint16 = 1 << 4
+	flagSendTrim        uint16 = 1 << 5
+	flagSendWriteZeroes uint16 = 1 << 6
+	flagCanMultiConn    uint16 = 1 << 8
+
+	cmdRead        uint16 = 0
+	cmdWrite       uint16 = 1
+	cmdDisc        uint16 = 2
+	cmdFlush       uint16 = 3
+	cmdTrim        uint16 = 4
+	cmdWriteZeroes uint16 = 6
+
+	// flags of commands
+	cmdFlagFUA uint16 = 1 << 0
+
+	// errno values of replies
+	errPerm    Error = 1
+	errIO      Error = 5
+	errInvalid Error = 22
+	errNoSpace Error = 28
+
+	// without structured replies, servers may refuse requests larger than 32MB
+	maxRequestSize = 32 * 1024 * 1024
+	// length of the zero padding after the export data of NBD_OPT_EXPORT_NAME
+	exportNamePadding = 124
+)
+
+// Error is an error returned by the server for a command, with its errno value
+type Error uint32
+
+var errorNames = map[Error]string{
+	1:   "EPERM",
+	5:   "EIO",
+	12:  "ENOMEM",
+	22:  "EINVAL",
+	28:  "ENOSPC",
+	75:  "EOVERFLOW",
+	95:  "ENOTSUP",
+	108: "ESHUTDOWN",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return fmt.Sprintf("server error %s", name)
+	}
+	return fmt.Sprintf("server error %d", uint32(e))
+}
+
+// replyError describes the error reply of an option
+func replyError(reply uint32, message string) error {
+	var s string
+	switch reply {
+	case repErrUnsup:
+		s = "option not supported"
+	case repErrPolicy:
+		s = "forbidden by server policy"
+	case repErrInvalid:
+		s = "invalid option"
+	case repErrPlatform:
+		s = "not supported on the server platform"
+	case repErrTLSReqd:
+		s = "server requires TLS"
+	case repErrUnknown:
+		s = "export not found"
+	case repErrShutdown:
+		s = "server is shutting down"
+	case repErrBlockSzReqd:
+		s = "server requires block size negotiation"
+	default:
+		s = fmt.Sprintf("error reply %#x", reply)
+	}
+	if message != "" {
+		s += ": " + message
+	}
+	return fmt.Errorf("server refused: %s", s)
+}
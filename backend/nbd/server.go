@@ -0,0 +1,529 @@
+package nbd
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/backend/overlay"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// Export is a storage served by a Server, or a part of it such as a partition
+type Export struct {
+	// Name selects the export, the default export when empty
+	Name        string
+	Description string
+	Storage     backend.Storage
+	// Offset and Size select the part of the storage exported, all of it from Offset if Size is 0
+	Offset int64
+	Size   int64
+	// ReadOnly refuses writes to the export
+	ReadOnly bool
+	// CopyOnWrite keeps the writes to the export in memory, in an overlay over the storage, which
+	// is never written. The writes are seen by all clients, until the server is closed.
+	CopyOnWrite bool
+}
+
+// PartitionExport exports the partition of a disk, e.g. one of d.Table.GetPartitions() of a
+// disk.Disk d with the storage d.Backend
+func PartitionExport(name string, storage backend.Storage, p part.Partition, readOnly bool) Export {
+	return Export{Name: name, Storage: storage, Offset: p.GetStart(), Size: p.GetSize(), ReadOnly: readOnly}
+}
+
+// export is an Export being served
+type export struct {
+	Export
+	storage  backend.Storage
+	writable backend.WritableFile
+}
+
+type serverOpts struct {
+	tlsConfig *tls.Config
+}
+
+// ServerOpt func that process NewServer options
+type ServerOpt func(o *serverOpts) error
+
+// WithServerTLS requires clients to upgrade their connections to TLS, with NBD_OPT_STARTTLS,
+// before selecting an export
+func WithServerTLS(config *tls.Config) ServerOpt {
+	return func(o *serverOpts) error {
+		if config == nil {
+			return errors.New("must pass TLS configuration")
+		}
+		o.tlsConfig = config
+		return nil
+	}
+}
+
+// Server serves exports to NBD clients, such as qemu or nbd-client, with fixed newstyle
+// negotiation and simple replies. The commands of each connection are served one at a time.
+type Server struct {
+	exports   map[string]*export
+	names     []string
+	tlsConfig *tls.Config
+	// mu guards listeners, conns and closed
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve after Close
+var ErrServerClosed = errors.New("nbd: server closed")
+
+// NewServer creates a server of the exports, whose names must be unique
+func NewServer(exports []Export, options ...ServerOpt) (*Server, error) {
+	o := &serverOpts{}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	s := &Server{
+		exports:   map[string]*export{},
+		tlsConfig: o.tlsConfig,
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+	for _, e := range exports {
+		exp, err := newExport(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid export %q: %w", e.Name, err)
+		}
+		if _, ok := s.exports[e.Name]; ok {
+			return nil, fmt.Errorf("duplicate export %q", e.Name)
+		}
+		s.exports[e.Name] = exp
+		s.names = append(s.names, e.Name)
+	}
+	return s, nil
+}
+
+func newExport(e Export) (*export, error) {
+	if len(e.Name) > 4096 {
+		return nil, fmt.Errorf("export name of %d bytes is too long", len(e.Name))
+	}
+	if e.Storage == nil {
+		return nil, errors.New("no storage")
+	}
+	info, err := e.Storage.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat storage: %w", err)
+	}
+	if e.Size == 0 {
+		e.Size = info.Size() - e.Offset
+	}
+	if e.Offset < 0 || e.Size <= 0 || e.Offset+e.Size > info.Size() {
+		return nil, fmt.Errorf("range of %d bytes at %d is outside of the storage of size %d", e.Size, e.Offset, info.Size())
+	}
+	exp := &export{Export: e, storage: e.Storage}
+	if e.CopyOnWrite {
+		o, err := overlay.Create(e.Storage, mem.New(nil, false), 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not create overlay: %w", err)
+		}
+		exp.storage = o
+	}
+	if !e.ReadOnly {
+		if exp.writable, err = exp.storage.Writable(); err != nil {
+			return nil, fmt.Errorf("storage is not writable: %w", err)
+		}
+	}
+	return exp, nil
+}
+
+// ListenAndServe listens on the network address, e.g. "tcp" and ":10809", or "unix" and the path
+// of a socket, and serves the connections until the server is closed
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener and serves each in its own goroutine, until the
+// listener fails or the server is closed, which closes the listener. It returns ErrServerClosed
+// after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go func() {
+			_ = s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn serves a connection of a client until it disconnects, and closes it
+func (s *Server) ServeConn(conn net.Conn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	s.mu.Unlock()
+	c := &serverConn{s: s, conn: conn}
+	defer func() {
+		c.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	exp, err := c.negotiate()
+	if err != nil || exp == nil {
+		return err
+	}
+	return c.transmit(exp)
+}
+
+// Close stops the server, closing its listeners and connections, and waits for the connections
+// to end
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// serverConn is a connection of a client to a Server
+type serverConn struct {
+	s    *Server
+	conn net.Conn
+	// noZeroes omits the padding after the export data of NBD_OPT_EXPORT_NAME
+	noZeroes bool
+	tls      bool
+}
+
+// reply sends the reply to an option
+func (c *serverConn) reply(option, reply uint32, data []byte) error {
+	b := make([]byte, 20, 20+len(data))
+	binary.BigEndian.PutUint64(b[0:8], replyMagic)
+	binary.BigEndian.PutUint32(b[8:12], option)
+	binary.BigEndian.PutUint32(b[12:16], reply)
+	binary.BigEndian.PutUint32(b[16:20], uint32(len(data)))
+	_, err := c.conn.Write(append(b, data...))
+	return err
+}
+
+// negotiate runs the fixed newstyle handshake, and returns the export selected, or nil if the
+// client ended the negotiation
+func (c *serverConn) negotiate() (*export, error) {
+	hello := binary.BigEndian.AppendUint64(nil, nbdMagic)
+	hello = binary.BigEndian.AppendUint64(hello, optMagic)
+	hello = binary.BigEndian.AppendUint16(hello, flagFixedNewstyle|flagNoZeroes)
+	if _, err := c.conn.Write(hello); err != nil {
+		return nil, err
+	}
+	var clientFlags uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &clientFlags); err != nil {
+		return nil, fmt.Errorf("error reading handshake: %w", err)
+	}
+	if clientFlags&clientFixedNewstyle == 0 {
+		return nil, errors.New("client does not support fixed newstyle negotiation")
+	}
+	c.noZeroes = clientFlags&clientNoZeroes != 0
+
+	for {
+		var header struct {
+			Magic  uint64
+			Option uint32
+			Length uint32
+		}
+		if err := binary.Read(c.conn, binary.BigEndian, &header); err != nil {
+			return nil, fmt.Errorf("error reading option: %w", err)
+		}
+		if header.Magic != optMagic {
+			return nil, fmt.Errorf("invalid option magic %#x", header.Magic)
+		}
+		// options are small, anything large is a broken client
+		if header.Length > 64*1024 {
+			return nil, fmt.Errorf("option of %d bytes is too large", header.Length)
+		}
+		data := make([]byte, header.Length)
+		if _, err := io.ReadFull(c.conn, data); err != nil {
+			return nil, fmt.Errorf("error reading option: %w", err)
+		}
+		option := header.Option
+
+		if c.s.tlsConfig != nil && !c.tls && option != optStartTLS && option != optAbort {
+			if option == optExportName {
+				// there is no error reply to NBD_OPT_EXPORT_NAME
+				return nil, errors.New("client selected export without TLS")
+			}
+			if err := c.reply(option, repErrTLSReqd, []byte("TLS required")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var err error
+		switch option {
+		case optExportName:
+			exp, ok := c.s.exports[string(data)]
+			if !ok {
+				// the connection is closed for an unknown export
+				return nil, fmt.Errorf("unknown export %q", data)
+			}
+			b := binary.BigEndian.AppendUint64(nil, uint64(exp.Size))
+			b = binary.BigEndian.AppendUint16(b, exp.flags())
+			if !c.noZeroes {
+				b = append(b, make([]byte, exportNamePadding)...)
+			}
+			if _, err := c.conn.Write(b); err != nil {
+				return nil, err
+			}
+			return exp, nil
+		case optAbort:
+			_ = c.reply(option, repAck, nil)
+			return nil, nil
+		case optList:
+			err = c.list(data)
+		case optStartTLS:
+			err = c.startTLS(data)
+		case optInfo, optGo:
+			var exp *export
+			exp, err = c.info(option, data)
+			if err == nil && exp != nil && option == optGo {
+				return exp, nil
+			}
+		default:
+			err = c.reply(option, repErrUnsup, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// list replies with the names of the exports
+func (c *serverConn) list(data []byte) error {
+	if len(data) != 0 {
+		return c.reply(optList, repErrInvalid, []byte("list takes no data"))
+	}
+	for _, name := range c.s.names {
+		b := binary.BigEndian.AppendUint32(nil, uint32(len(name)))
+		b = append(b, name...)
+		b = append(b, c.s.exports[name].Description...)
+		if err := c.reply(optList, repServer, b); err != nil {
+			return err
+		}
+	}
+	return c.reply(optList, repAck, nil)
+}
+
+// startTLS upgrades the connection to TLS
+func (c *serverConn) startTLS(data []byte) error {
+	switch {
+	case c.s.tlsConfig == nil:
+		return c.reply(optStartTLS, repErrPolicy, []byte("TLS not configured"))
+	case c.tls:
+		return c.reply(optStartTLS, repErrInvalid, []byte("TLS already started"))
+	case len(data) != 0:
+		return c.reply(optStartTLS, repErrInvalid, []byte("starttls takes no data"))
+	}
+	if err := c.reply(optStartTLS, repAck, nil); err != nil {
+		return err
+	}
+	conn := tls.Server(c.conn, c.s.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	c.conn, c.tls = conn, true
+	return nil
+}
+
+// info replies to NBD_OPT_INFO and NBD_OPT_GO with the information of the export asked for, and
+// returns the export, or nil if there is none
+func (c *serverConn) info(option uint32, data []byte) (*export, error) {
+	if len(data) < 6 {
+		return nil, c.reply(option, repErrInvalid, []byte("invalid request"))
+	}
+	nameLength := binary.BigEndian.Uint32(data)
+	if uint64(len(data)) < 6+uint64(nameLength) {
+		return nil, c.reply(option, repErrInvalid, []byte("invalid request"))
+	}
+	name := string(data[4 : 4+nameLength])
+	data = data[4+nameLength:]
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) != 2*count {
+		return nil, c.reply(option, repErrInvalid, []byte("invalid request"))
+	}
+	exp, ok := c.s.exports[name]
+	if !ok {
+		return nil, c.reply(option, repErrUnknown, []byte("no such export"))
+	}
+	b := binary.BigEndian.AppendUint16(nil, infoExport)
+	b = binary.BigEndian.AppendUint64(b, uint64(exp.Size))
+	b = binary.BigEndian.AppendUint16(b, exp.flags())
+	if err := c.reply(option, repInfo, b); err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		var b []byte
+		switch typ := binary.BigEndian.Uint16(data[2*i:]); typ {
+		case infoName:
+			b = append(binary.BigEndian.AppendUint16(nil, typ), exp.Name...)
+		case infoDescription:
+			b = append(binary.BigEndian.AppendUint16(nil, typ), exp.Description...)
+		case infoBlockSize:
+			b = binary.BigEndian.AppendUint16(nil, typ)
+			b = binary.BigEndian.AppendUint32(b, 1)    // minimum
+			b = binary.BigEndian.AppendUint32(b, 4096) // preferred
+			b = binary.BigEndian.AppendUint32(b, maxRequestSize)
+		default:
+			continue
+		}
+		if err := c.reply(option, repInfo, b); err != nil {
+			return nil, err
+		}
+	}
+	return exp, c.reply(option, repAck, nil)
+}
+
+// flags are the transmission flags of the export
+func (e *export) flags() uint16 {
+	flags := flagHasFlags | flagSendFlush | flagSendFUA | flagSendTrim | flagSendWriteZeroes
+	if e.ReadOnly {
+		flags |= flagReadOnly
+	}
+	if !e.CopyOnWrite {
+		// the writes of every connection reach the same storage
+		flags |= flagCanMultiConn
+	}
+	return flags
+}
+
+// transmit serves the commands of the client for the export, until it disconnects
+func (c *serverConn) transmit(exp *export) error {
+	header := make([]byte, 28)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error reading command: %w", err)
+		}
+		if magic := binary.BigEndian.Uint32(header[0:4]); magic != requestMagic {
+			return fmt.Errorf("invalid request magic %#x", magic)
+		}
+		flags := binary.BigEndian.Uint16(header[4:6])
+		cmd := binary.BigEndian.Uint16(header[6:8])
+		handle := binary.BigEndian.Uint64(header[8:16])
+		offset := binary.BigEndian.Uint64(header[16:24])
+		length := binary.BigEndian.Uint32(header[24:28])
+		if length > maxRequestSize {
+			return fmt.Errorf("request of %d bytes is too large", length)
+		}
+		inRange := offset <= uint64(exp.Size) && uint64(length) <= uint64(exp.Size)-offset
+		at := exp.Offset + int64(offset)
+
+		var data []byte
+		errno := Error(0)
+		switch cmd {
+		case cmdDisc:
+			return nil
+		case cmdRead:
+			if !inRange {
+				errno = errInvalid
+				break
+			}
+			data = make([]byte, length)
+			if _, err := exp.storage.ReadAt(data, at); err != nil && !errors.Is(err, io.EOF) {
+				errno, data = errIO, nil
+			}
+		case cmdWrite:
+			b := make([]byte, length)
+			if _, err := io.ReadFull(c.conn, b); err != nil {
+				return fmt.Errorf("error reading data to write: %w", err)
+			}
+			errno = exp.write(b, at, inRange, flags)
+		case cmdWriteZeroes:
+			errno = exp.write(make([]byte, length), at, inRange, flags)
+		case cmdFlush:
+			if exp.writable != nil {
+				if err := backend.Sync(exp.storage); err != nil {
+					errno = errIO
+				}
+			}
+		case cmdTrim:
+			// the contents of a trimmed range are undefined, so they are kept
+			switch {
+			case exp.ReadOnly:
+				errno = errPerm
+			case !inRange:
+				errno = errInvalid
+			}
+		default:
+			errno = errInvalid
+		}
+
+		reply := make([]byte, 16, 16+len(data))
+		binary.BigEndian.PutUint32(reply[0:4], simpleMagic)
+		binary.BigEndian.PutUint32(reply[4:8], uint32(errno))
+		binary.BigEndian.PutUint64(reply[8:16], handle)
+		if _, err := c.conn.Write(append(reply, data...)); err != nil {
+			return fmt.Errorf("error writing reply: %w", err)
+		}
+	}
+}
+
+// write writes the data at the offset of the storage, and makes it durable for NBD_CMD_FLAG_FUA
+func (e *export) write(b []byte, at int64, inRange bool, flags uint16) Error {
+	switch {
+	case e.ReadOnly:
+		return errPerm
+	case !inRange:
+		return errNoSpace
+	}
+	if _, err := e.writable.WriteAt(b, at); err != nil {
+		return errIO
+	}
+	if flags&cmdFlagFUA != 0 {
+		if err := backend.Sync(e.storage); err != nil {
+			return errIO
+		}
+	}
+	return 0
+}
@@ -0,0 +1,249 @@
+package nbd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/backend/nbd"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// startServer serves the exports on a local port, and returns its address
+func startServer(t *testing.T, exports []nbd.Export, options ...nbd.ServerOpt) string {
+	t.Helper()
+	s, err := nbd.NewServer(exports, options...)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; !errors.Is(err, nbd.ErrServerClosed) {
+			t.Errorf("serve returned %v instead of %v", err, nbd.ErrServerClosed)
+		}
+	})
+	return l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	const size = 40 * 1024 * 1024
+	storage, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(storage, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	part := d.Table.GetPartitions()[0]
+	addr := startServer(t, []nbd.Export{
+		{Name: "disk", Storage: storage},
+		nbd.PartitionExport("part1", storage, part, false),
+		{Name: "cow", Storage: storage, CopyOnWrite: true},
+	})
+
+	// a filesystem created over the whole disk is found in the export of its partition
+	c, err := nbd.Dial("tcp", addr, false, nbd.WithExportName("disk"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	if c.Size() != size {
+		t.Errorf("size %d instead of %d", c.Size(), size)
+	}
+	remote, err := diskfs.OpenBackend(c, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	fs, err := remote.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("served by go-diskfs")
+	if err := writeFile(fs, "/served.txt", content); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	p, err := nbd.Dial("tcp", addr, true, nbd.WithExportName("part1"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer p.Close()
+	if p.Size() != part.GetSize() {
+		t.Errorf("size %d of partition export instead of %d", p.Size(), part.GetSize())
+	}
+	pd, err := diskfs.OpenBackend(p, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("error opening partition: %v", err)
+	}
+	pfs, err := pd.GetFilesystem(0)
+	if err != nil {
+		t.Fatalf("error reading filesystem of partition: %v", err)
+	}
+	if read, err := pfs.ReadFile("served.txt"); err != nil || !bytes.Equal(read, content) {
+		t.Errorf("read %q, %v instead of %q", read, err, content)
+	}
+
+	// writes to the copy-on-write export are seen by its clients, and leave the storage as is
+	cow, err := nbd.Dial("tcp", addr, false, nbd.WithExportName("cow"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	data := make([]byte, 100000)
+	_, _ = rand.Read(data)
+	original := make([]byte, len(data))
+	if _, err := storage.ReadAt(original, 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cow.WriteAt(data, 5000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if err := cow.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	cow, err = nbd.Dial("tcp", addr, true, nbd.WithExportName("cow"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer cow.Close()
+	read := make([]byte, len(data))
+	if _, err := cow.ReadAt(read, 5000); err != nil || !bytes.Equal(read, data) {
+		t.Errorf("contents written to copy-on-write export do not match, %v", err)
+	}
+	if _, err := storage.ReadAt(read, 5000); err != nil || !bytes.Equal(read, original) {
+		t.Errorf("storage changed by writes to copy-on-write export, %v", err)
+	}
+}
+
+func writeFile(fs filesystem.FileSystem, p string, content []byte) error {
+	f, err := fs.OpenFile(p, 0x42) // os.O_CREATE|os.O_RDWR
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func TestServerTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+	storage := mem.New([]byte("read over TLS"), true)
+	addr := startServer(t, []nbd.Export{{Storage: storage, ReadOnly: true}},
+		nbd.WithServerTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}))
+
+	if _, err := nbd.Dial("tcp", addr, true); err == nil {
+		t.Errorf("no error opening export without TLS from server that requires it")
+	}
+	c, err := nbd.OpenURL("nbds://"+addr, true, nbd.WithTLS(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer c.Close()
+	b, err := io.ReadAll(c)
+	if err != nil || string(b) != "read over TLS" {
+		t.Errorf("read %q, %v instead of %q", b, err, "read over TLS")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	storage, err := mem.Create(64 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nbd.NewServer([]nbd.Export{{Storage: storage}, {Storage: storage}}); err == nil {
+		t.Errorf("no error creating server with duplicate exports")
+	}
+	if _, err := nbd.NewServer([]nbd.Export{{Storage: storage, Offset: 60 * 1024, Size: 8192}}); err == nil {
+		t.Errorf("no error creating server with export beyond the storage")
+	}
+	addr := startServer(t, []nbd.Export{
+		{Name: "ro", Description: "read-only", Storage: storage, ReadOnly: true},
+		{Name: "rw", Storage: storage, Offset: 4096, Size: 8192},
+	})
+
+	if _, err := nbd.Dial("tcp", addr, true, nbd.WithExportName("missing")); err == nil {
+		t.Errorf("no error opening missing export")
+	}
+	if _, err := nbd.Dial("tcp", addr, false, nbd.WithExportName("ro")); err == nil {
+		t.Errorf("no error opening read-only export for writing")
+	}
+	c, err := nbd.Dial("tcp", addr, false, nbd.WithExportName("rw"))
+	if err != nil {
+		t.Fatalf("error opening export: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.WriteAt([]byte("beyond"), c.Size()-2); err == nil {
+		t.Errorf("no error writing beyond the end of the export")
+	}
+	if _, err := c.WriteAt([]byte("inside"), 0); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	b := make([]byte, 6)
+	if _, err := storage.ReadAt(b, 4096); err != nil || string(b) != "inside" {
+		t.Errorf("read %q, %v at the offset of the export instead of %q", b, err, "inside")
+	}
+
+	// NBD_OPT_LIST
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hello := make([]byte, 18)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		t.Fatal(err)
+	}
+	req := binary.BigEndian.AppendUint32(nil, 1|2)
+	req = binary.BigEndian.AppendUint64(req, 0x49484156454F5054)
+	req = binary.BigEndian.AppendUint32(req, 3)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		header := make([]byte, 20)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[16:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint32(header[12:]) != 2 {
+			break
+		}
+		names = append(names, string(data[4:]))
+	}
+	if len(names) != 2 || names[0] != "roread-only" || names[1] != "rw" {
+		t.Errorf("listed exports %q", names)
+	}
+}
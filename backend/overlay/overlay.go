@@ -0,0 +1,428 @@
+// Package overlay provides a copy-on-write backend over an immutable base disk, which records all
+// writes in a separate sidecar file instead of the base.
+//
+// Experiments on golden images are thereby non-destructive: discarding the sidecar restores the
+// base, and Changes lists the regions that differ from it. The sidecar holds a header followed by
+// one record per written block, the block index followed by the contents of the block, so it only
+// grows with the blocks written.
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks copied to the sidecar on first write
+	DefaultBlockSize int64 = 64 * 1024
+	headerSize             = 512
+	recordHeaderSize       = 8
+	version                = 1
+	maxBlockSize           = 16 * 1024 * 1024
+)
+
+var magic = []byte("DFSOVRLY")
+
+// Overlay is a disk that reads from a base disk, except for the blocks written, which are kept in
+// the sidecar
+type Overlay struct {
+	base      backend.Storage
+	sidecar   backend.Storage
+	sidecarRW backend.WritableFile
+	readOnly  bool
+	blockSize int64
+	size      int64
+	// mu guards blocks, next and offset; reads may run concurrently
+	mu sync.RWMutex
+	// blocks maps the index of each written block to the offset of its contents in the sidecar
+	blocks map[int64]int64
+	// next is the offset in the sidecar of the next record
+	next   int64
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Overlay)(nil)
+
+// Extent is a region of the disk
+type Extent struct {
+	Offset int64
+	Length int64
+}
+
+// Create starts an overlay over base, recording writes in the sidecar in blocks of blockSize,
+// DefaultBlockSize if 0. The sidecar must be writable, and its contents are overwritten.
+func Create(base, sidecar backend.Storage, blockSize int64) (*Overlay, error) {
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if blockSize < 512 || blockSize > maxBlockSize || blockSize&(blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d, must be a power of 2 from 512 to %d", blockSize, maxBlockSize)
+	}
+	info, err := base.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat base: %w", err)
+	}
+	rw, err := sidecar.Writable()
+	if err != nil {
+		return nil, fmt.Errorf("sidecar is not writable: %w", err)
+	}
+	h := make([]byte, headerSize)
+	copy(h, magic)
+	binary.LittleEndian.PutUint32(h[8:], version)
+	binary.LittleEndian.PutUint32(h[12:], uint32(blockSize))
+	binary.LittleEndian.PutUint64(h[16:], uint64(info.Size()))
+	if _, err := rw.WriteAt(h, 0); err != nil {
+		return nil, fmt.Errorf("could not write sidecar header: %w", err)
+	}
+	return &Overlay{
+		base:      base,
+		sidecar:   sidecar,
+		sidecarRW: rw,
+		blockSize: blockSize,
+		size:      info.Size(),
+		blocks:    map[int64]int64{},
+		next:      headerSize,
+	}, nil
+}
+
+// Open opens an existing overlay of base with its sidecar. The base must have the same size as when
+// the overlay was created. If readOnly, neither is written.
+func Open(base, sidecar backend.Storage, readOnly bool) (*Overlay, error) {
+	info, err := base.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat base: %w", err)
+	}
+	h := make([]byte, headerSize)
+	if _, err := sidecar.ReadAt(h, 0); err != nil {
+		return nil, fmt.Errorf("could not read sidecar header: %w", err)
+	}
+	if !bytes.Equal(h[:8], magic) {
+		return nil, errors.New("invalid sidecar header, not an overlay")
+	}
+	if v := binary.LittleEndian.Uint32(h[8:]); v != version {
+		return nil, fmt.Errorf("unsupported overlay version %d", v)
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(h[12:]))
+	if blockSize < 512 || blockSize > maxBlockSize || blockSize&(blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d", blockSize)
+	}
+	if size := int64(binary.LittleEndian.Uint64(h[16:])); size != info.Size() {
+		return nil, fmt.Errorf("base is %d bytes, but the overlay was created over %d bytes", info.Size(), size)
+	}
+	o := &Overlay{
+		base:      base,
+		sidecar:   sidecar,
+		readOnly:  readOnly,
+		blockSize: blockSize,
+		size:      info.Size(),
+		blocks:    map[int64]int64{},
+		next:      headerSize,
+	}
+	if !readOnly {
+		if o.sidecarRW, err = sidecar.Writable(); err != nil {
+			return nil, fmt.Errorf("sidecar is not writable: %w", err)
+		}
+	}
+	if err := o.readRecords(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// readRecords finds the blocks in the sidecar
+func (o *Overlay) readRecords() error {
+	info, err := o.sidecar.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat sidecar: %w", err)
+	}
+	blocks := (o.size + o.blockSize - 1) / o.blockSize
+	end := info.Size()
+	b := make([]byte, recordHeaderSize)
+	for o.next < end {
+		if o.next+recordHeaderSize+o.blockSize > end {
+			return fmt.Errorf("truncated record at %d in sidecar", o.next)
+		}
+		if _, err := o.sidecar.ReadAt(b, o.next); err != nil {
+			return fmt.Errorf("could not read record at %d in sidecar: %w", o.next, err)
+		}
+		index := int64(binary.LittleEndian.Uint64(b))
+		if index < 0 || index >= blocks {
+			return fmt.Errorf("record at %d in sidecar is for block %d beyond the disk", o.next, index)
+		}
+		if _, ok := o.blocks[index]; ok {
+			return fmt.Errorf("block %d is recorded more than once in sidecar", index)
+		}
+		o.blocks[index] = o.next + recordHeaderSize
+		o.next += recordHeaderSize + o.blockSize
+	}
+	return nil
+}
+
+// CreateFromPath starts an overlay over the base image file, which is only read, recording writes
+// in a new sidecar file
+func CreateFromPath(basePath, sidecarPath string, blockSize int64) (*Overlay, error) {
+	base, err := file.OpenFromPath(basePath, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not open base %s: %w", basePath, err)
+	}
+	f, err := os.OpenFile(sidecarPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		base.Close()
+		return nil, fmt.Errorf("could not create sidecar %s: %w", sidecarPath, err)
+	}
+	o, err := Create(base, file.New(f, false), blockSize)
+	if err != nil {
+		base.Close()
+		f.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// OpenFromPath opens an existing overlay from the base image file, which is only read, and its
+// sidecar file
+func OpenFromPath(basePath, sidecarPath string, readOnly bool) (*Overlay, error) {
+	base, err := file.OpenFromPath(basePath, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not open base %s: %w", basePath, err)
+	}
+	sidecar, err := file.OpenFromPath(sidecarPath, readOnly)
+	if err != nil {
+		base.Close()
+		return nil, fmt.Errorf("could not open sidecar %s: %w", sidecarPath, err)
+	}
+	o, err := Open(base, sidecar, readOnly)
+	if err != nil {
+		base.Close()
+		sidecar.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+// Size is the size of the disk, which is that of the base
+func (o *Overlay) Size() int64 {
+	return o.size
+}
+
+// BlockSize is the size of the blocks copied to the sidecar on first write
+func (o *Overlay) BlockSize() int64 {
+	return o.blockSize
+}
+
+// Changes lists the regions of the disk that have been written, merging adjacent blocks. Written
+// blocks are listed even if their contents are the same as in the base.
+func (o *Overlay) Changes() []Extent {
+	o.mu.RLock()
+	indexes := make([]int64, 0, len(o.blocks))
+	for i := range o.blocks {
+		indexes = append(indexes, i)
+	}
+	o.mu.RUnlock()
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	var extents []Extent
+	for _, i := range indexes {
+		offset := i * o.blockSize
+		length := min(o.blockSize, o.size-offset)
+		if n := len(extents); n > 0 && extents[n-1].Offset+extents[n-1].Length == offset {
+			extents[n-1].Length += length
+			continue
+		}
+		extents = append(extents, Extent{Offset: offset, Length: length})
+	}
+	return extents
+}
+
+// ReadAt reads from the sidecar for written blocks, and from the base for the others
+func (o *Overlay) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > o.size {
+		p = p[:o.size-off]
+		eof = io.EOF
+	}
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	read := 0
+	for read < len(p) {
+		pos := off + int64(read)
+		inBlock := pos % o.blockSize
+		chunk := p[read:min(len(p), read+int(o.blockSize-inBlock))]
+		if err := o.readBlock(chunk, pos); err != nil {
+			return read, err
+		}
+		read += len(chunk)
+	}
+	return read, eof
+}
+
+// readBlock reads part of a single block, which must not cross a block boundary
+func (o *Overlay) readBlock(b []byte, pos int64) error {
+	var (
+		n   int
+		err error
+	)
+	if offset, ok := o.blocks[pos/o.blockSize]; ok {
+		n, err = o.sidecar.ReadAt(b, offset+pos%o.blockSize)
+	} else {
+		n, err = o.base.ReadAt(b, pos)
+	}
+	if n == len(b) {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("could not read %d bytes at %d: %w", len(b), pos, err)
+}
+
+// WriteAt writes to the sidecar, copying each block written for the first time from the base
+func (o *Overlay) WriteAt(p []byte, off int64) (int, error) {
+	if o.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > o.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the disk of size %d", len(p), off, o.size)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	written := 0
+	for written < len(p) {
+		pos := off + int64(written)
+		inBlock := pos % o.blockSize
+		chunk := p[written:min(len(p), written+int(o.blockSize-inBlock))]
+		if err := o.writeBlock(chunk, pos); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// writeBlock writes part of a single block, which must not cross a block boundary
+func (o *Overlay) writeBlock(b []byte, pos int64) error {
+	index := pos / o.blockSize
+	inBlock := pos % o.blockSize
+	if offset, ok := o.blocks[index]; ok {
+		return o.writeSidecar(b, offset+inBlock)
+	}
+	// the first write of a block appends a record with the whole block
+	record := make([]byte, recordHeaderSize+o.blockSize)
+	binary.LittleEndian.PutUint64(record, uint64(index))
+	data := record[recordHeaderSize:]
+	if int64(len(b)) != o.blockSize {
+		start := index * o.blockSize
+		n := min(o.blockSize, o.size-start)
+		if err := o.readBlock(data[:n], start); err != nil {
+			return err
+		}
+	}
+	copy(data[inBlock:], b)
+	if err := o.writeSidecar(record, o.next); err != nil {
+		return err
+	}
+	o.blocks[index] = o.next + recordHeaderSize
+	o.next += int64(len(record))
+	return nil
+}
+
+func (o *Overlay) writeSidecar(b []byte, offset int64) error {
+	n, err := o.sidecarRW.WriteAt(b, offset)
+	if err != nil {
+		return fmt.Errorf("could not write sidecar at %d: %w", offset, err)
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes to sidecar at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// Stat describes the base, with the size of the disk
+func (o *Overlay) Stat() (fs.FileInfo, error) {
+	info, err := o.base.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &overlayInfo{FileInfo: info, size: o.size}, nil
+}
+
+// Read reads from the disk at the current offset
+func (o *Overlay) Read(b []byte) (int, error) {
+	o.mu.Lock()
+	offset := o.offset
+	o.mu.Unlock()
+	n, err := o.ReadAt(b, offset)
+	o.mu.Lock()
+	o.offset = offset + int64(n)
+	o.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (o *Overlay) Seek(offset int64, whence int) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return o.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return o.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the sidecar file, which holds all writes
+func (o *Overlay) Sync() error {
+	return backend.Sync(o.sidecar)
+}
+
+// Close closes the base and the sidecar
+func (o *Overlay) Close() error {
+	return errors.Join(o.sidecar.Close(), o.base.Close())
+}
+
+// Sys is not suitable for an overlay, as ioctls on the base would bypass the sidecar
+func (o *Overlay) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the overlay itself, unless it is read-only
+func (o *Overlay) Writable() (backend.WritableFile, error) {
+	if o.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return o, nil
+}
+
+// overlayInfo reports the size of the disk instead of the base
+type overlayInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *overlayInfo) Size() int64 {
+	return i.size
+}
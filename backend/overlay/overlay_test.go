@@ -0,0 +1,206 @@
+package overlay_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/overlay"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := make([]byte, 1024*1024+1000)
+	_, _ = rand.Read(base)
+	basePath := filepath.Join(dir, "base.img")
+	if err := os.WriteFile(basePath, base, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sidecarPath := filepath.Join(dir, "base.overlay")
+
+	o, err := overlay.CreateFromPath(basePath, sidecarPath, 4096)
+	if err != nil {
+		t.Fatalf("error creating overlay: %v", err)
+	}
+	expected := append([]byte(nil), base...)
+	writes := []struct {
+		offset int64
+		data   []byte
+	}{
+		{100, []byte("within the first block")},
+		{4090, bytes.Repeat([]byte("across blocks"), 1000)},
+		{int64(len(base)) - 10, []byte("last block")},
+		{110, []byte("again")},
+	}
+	for _, w := range writes {
+		if _, err := o.WriteAt(w.data, w.offset); err != nil {
+			t.Fatalf("error writing at %d: %v", w.offset, err)
+		}
+		copy(expected[w.offset:], w.data)
+	}
+	if _, err := o.WriteAt([]byte("beyond"), int64(len(base))-2); err == nil {
+		t.Errorf("no error writing beyond the end")
+	}
+	check := func(o *overlay.Overlay) {
+		t.Helper()
+		b := make([]byte, len(expected))
+		if _, err := o.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("contents of overlay do not match")
+		}
+		changes := o.Changes()
+		last := int64(len(base)) / 4096 * 4096
+		expectedChanges := []overlay.Extent{{Offset: 0, Length: 5 * 4096}, {Offset: last, Length: int64(len(base)) - last}}
+		if len(changes) != len(expectedChanges) || changes[0] != expectedChanges[0] || changes[1] != expectedChanges[1] {
+			t.Errorf("changes %v instead of %v", changes, expectedChanges)
+		}
+	}
+	check(o)
+	if err := o.Close(); err != nil {
+		t.Fatalf("error closing overlay: %v", err)
+	}
+
+	// the base is untouched
+	b, err := os.ReadFile(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, base) {
+		t.Errorf("base was modified")
+	}
+
+	o, err = overlay.OpenFromPath(basePath, sidecarPath, true)
+	if err != nil {
+		t.Fatalf("error opening overlay: %v", err)
+	}
+	defer o.Close()
+	check(o)
+	if _, err := o.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("no error writing read-only overlay")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.img")
+	if err := os.WriteFile(basePath, make([]byte, 64*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.CreateFromPath(basePath, filepath.Join(dir, "bad"), 1000); err == nil {
+		t.Errorf("no error with block size that is not a power of 2")
+	}
+	sidecarPath := filepath.Join(dir, "base.overlay")
+	o, err := overlay.CreateFromPath(basePath, sidecarPath, 0)
+	if err != nil {
+		t.Fatalf("error creating overlay: %v", err)
+	}
+	if _, err := o.WriteAt([]byte("x"), 0); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	o.Close()
+
+	// a sidecar with a partial record
+	if err := os.Truncate(sidecarPath, 512+8+100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.OpenFromPath(basePath, sidecarPath, true); err == nil {
+		t.Errorf("no error opening truncated sidecar")
+	}
+	// a base of a different size
+	if err := os.Truncate(sidecarPath, 512); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(basePath, 128*1024); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := overlay.OpenFromPath(basePath, sidecarPath, true); err == nil {
+		t.Errorf("no error opening overlay over base of a different size")
+	}
+	if _, err := overlay.OpenFromPath(basePath, basePath, true); err == nil {
+		t.Errorf("no error opening base as sidecar")
+	}
+}
+
+func TestDisk(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "golden.img")
+	b, err := file.CreateFromPath(basePath, 40*1024*1024)
+	if err != nil {
+		t.Fatalf("error creating base image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 78000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	if _, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32}); err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+	golden, err := os.ReadFile(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := overlay.CreateFromPath(basePath, filepath.Join(dir, "experiment.overlay"), 0)
+	if err != nil {
+		t.Fatalf("error creating overlay: %v", err)
+	}
+	d, err = diskfs.OpenBackend(o, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	content := []byte("written to the overlay only")
+	f, err := fs.OpenFile("/experiment.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	f, err = fs.OpenFile("/experiment.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+	if len(o.Changes()) == 0 {
+		t.Errorf("no changes recorded")
+	}
+	after, err := os.ReadFile(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, golden) {
+		t.Errorf("golden image was modified")
+	}
+}
@@ -0,0 +1,224 @@
+package qcow2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+const (
+	// backing file formats recorded in the backing file format header extension
+	formatQcow2 = "qcow2"
+	formatRaw   = "raw"
+
+	// maxBackingDepth limits the length of a backing file chain, which also catches loops
+	maxBackingDepth = 64
+)
+
+type openOpts struct {
+	backing  backend.Storage
+	data     backend.Storage
+	snapshot string
+}
+
+// OpenOpt func that process New options
+type OpenOpt func(o *openOpts) error
+
+// WithBackingStorage provides the backing file of the image, which is read for clusters
+// that are not allocated in the image. It may be a qcow2 Image or a raw image, and is only read.
+func WithBackingStorage(b backend.Storage) OpenOpt {
+	return func(o *openOpts) error {
+		o.backing = b
+		return nil
+	}
+}
+
+// WithDataFileStorage provides the external data file of the image, which holds its guest clusters.
+// It must be writable if the image is opened for writing.
+func WithDataFileStorage(b backend.Storage) OpenOpt {
+	return func(o *openOpts) error {
+		o.data = b
+		return nil
+	}
+}
+
+// setRelated checks and sets the backing file and external data file of the image
+func (img *Image) setRelated(opt *openOpts) error {
+	h := img.header
+	switch {
+	case h.backingFile != "" && opt.backing == nil:
+		return fmt.Errorf("image has backing file %q, which was not provided", h.backingFile)
+	case h.backingFile == "" && opt.backing != nil:
+		return errors.New("image has no backing file, but one was provided")
+	case opt.backing != nil:
+		info, err := opt.backing.Stat()
+		if err != nil {
+			return fmt.Errorf("could not stat backing file: %w", err)
+		}
+		img.backing = opt.backing
+		img.backingSize = info.Size()
+	}
+
+	external := h.incompatibleFeatures&incompatExternalData != 0
+	switch {
+	case external && opt.data == nil:
+		return fmt.Errorf("image has external data file %q, which was not provided", h.externalDataFile)
+	case !external && opt.data != nil:
+		return errors.New("image has no external data file, but one was provided")
+	case opt.data != nil:
+		img.data = opt.data
+		img.dataRW = nil
+		if !img.readOnly {
+			rw, err := opt.data.Writable()
+			if err != nil {
+				return fmt.Errorf("external data file is not writable: %w", err)
+			}
+			img.dataRW = rw
+		}
+	}
+	return nil
+}
+
+// hasDataFile whether the guest clusters are in an external data file
+func (img *Image) hasDataFile() bool {
+	return img.data != img.storage
+}
+
+// BackingFile is the name of the backing file as recorded in the image, or empty if it has none
+func (img *Image) BackingFile() string {
+	return img.header.backingFile
+}
+
+// DataFile is the name of the external data file as recorded in the image, or empty if it has none
+func (img *Image) DataFile() string {
+	return img.header.externalDataFile
+}
+
+// readBacking reads an unallocated part of the virtual disk from the backing file, if any.
+// Anything beyond the end of the backing file reads as zero.
+func (img *Image) readBacking(b []byte, virtual int64) error {
+	n := 0
+	if img.backing != nil && virtual < img.backingSize {
+		var err error
+		n, err = img.backing.ReadAt(b[:min(int64(len(b)), img.backingSize-virtual)], virtual)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error reading backing file: %w", err)
+		}
+	}
+	clear(b[n:])
+	return nil
+}
+
+// writeData writes guest data to the image or the external data file
+func (img *Image) writeData(b []byte, offset int64) error {
+	n, err := img.dataRW.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// openRelated opens the image in storage, opened from pathName, together with its backing file chain
+// and external data file, which are found relative to the directory of the image
+func openRelated(storage backend.Storage, pathName string, readOnly bool, depth int, extra ...OpenOpt) (*Image, error) {
+	h, err := readHeader(storage)
+	if err != nil {
+		return nil, err
+	}
+	opts := extra
+	var related []backend.Storage
+	closeRelated := func() {
+		for _, r := range related {
+			r.Close()
+		}
+	}
+	if h.backingFile != "" {
+		backing, err := openBacking(relativePath(pathName, h.backingFile), h.backingFileFormat, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		related = append(related, backing)
+		opts = append(opts, WithBackingStorage(backing))
+	}
+	if h.incompatibleFeatures&incompatExternalData != 0 {
+		if h.externalDataFile == "" {
+			closeRelated()
+			return nil, errors.New("image has an external data file without a name")
+		}
+		data, err := openRaw(relativePath(pathName, h.externalDataFile), readOnly)
+		if err != nil {
+			closeRelated()
+			return nil, err
+		}
+		related = append(related, data)
+		opts = append(opts, WithDataFileStorage(data))
+	}
+	img, err := New(storage, readOnly, opts...)
+	if err != nil {
+		closeRelated()
+		return nil, err
+	}
+	return img, nil
+}
+
+// openBacking opens a backing file read-only in the given format, detecting it if empty
+func openBacking(pathName, format string, depth int) (backend.Storage, error) {
+	if depth > maxBackingDepth {
+		return nil, fmt.Errorf("backing file chain is longer than %d images", maxBackingDepth)
+	}
+	storage, err := openRaw(pathName, true)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		magic := make([]byte, len(getMagic()))
+		if _, err := storage.ReadAt(magic, 0); err == nil && bytes.Equal(magic, getMagic()) {
+			format = formatQcow2
+		} else {
+			format = formatRaw
+		}
+	}
+	switch format {
+	case formatRaw:
+		return storage, nil
+	case formatQcow2:
+		img, err := openRelated(storage, pathName, true, depth)
+		if err != nil {
+			storage.Close()
+			return nil, fmt.Errorf("could not open backing file %s: %w", pathName, err)
+		}
+		return img, nil
+	default:
+		storage.Close()
+		return nil, fmt.Errorf("unsupported format %q of backing file %s", format, pathName)
+	}
+}
+
+func openRaw(pathName string, readOnly bool) (backend.Storage, error) {
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", pathName, err)
+	}
+	return file.New(f, readOnly), nil
+}
+
+// relativePath resolves name, as recorded in an image, relative to the directory of that image
+func relativePath(imagePath, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(imagePath), name)
+}
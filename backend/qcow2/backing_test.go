@@ -0,0 +1,178 @@
+package qcow2_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/qcow2"
+)
+
+func TestBackingFileChain(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(4 * 1024 * 1024)
+
+	// raw base, with a qcow2 image derived from it, with another qcow2 image derived from that
+	expected := make([]byte, size)
+	_, _ = rand.Read(expected[:size/2])
+	if err := os.WriteFile(filepath.Join(dir, "base.img"), expected[:size/2], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mid, err := qcow2.CreateFromPath(filepath.Join(dir, "mid.qcow2"), size, qcow2.WithClusterSize(4096), qcow2.WithBackingFile("base.img", "raw", nil))
+	if err != nil {
+		t.Fatalf("error creating image with raw backing file: %v", err)
+	}
+	midData := bytes.Repeat([]byte("mid"), 3000)
+	if _, err := mid.WriteAt(midData, 10000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	copy(expected[10000:], midData)
+	if err := mid.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// size of 0 takes the size of the backing file, and the format is detected
+	top, err := qcow2.CreateFromPath(filepath.Join(dir, "top.qcow2"), 0, qcow2.WithBackingFile("mid.qcow2", "", nil))
+	if err != nil {
+		t.Fatalf("error creating image with qcow2 backing file: %v", err)
+	}
+	if top.Size() != size {
+		t.Errorf("size %d instead of backing file size %d", top.Size(), size)
+	}
+	topData := bytes.Repeat([]byte("top"), 100)
+	for _, off := range []int64{10100, size/2 - 50, size - 300} {
+		if _, err := top.WriteAt(topData, off); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		copy(expected[off:], topData)
+	}
+	if err := top.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	top, err = qcow2.OpenFromPath(filepath.Join(dir, "top.qcow2"), true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer top.Close()
+	if top.BackingFile() != "mid.qcow2" {
+		t.Errorf("backing file %q instead of %q", top.BackingFile(), "mid.qcow2")
+	}
+	b := make([]byte, size)
+	if _, err := top.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("mismatched contents read through backing file chain")
+	}
+
+	// the intermediate image is unchanged by writes to the top one
+	mid, err = qcow2.OpenFromPath(filepath.Join(dir, "mid.qcow2"), true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer mid.Close()
+	if _, err := mid.ReadAt(b[:len(topData)], 10100); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b[:len(topData)], midData[100:100+len(topData)]) {
+		t.Errorf("backing file was modified by writes to derived image")
+	}
+}
+
+func TestBackingFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := qcow2.CreateFromPath(filepath.Join(dir, "a.qcow2"), 0, qcow2.WithBackingFile("missing.img", "", nil)); err == nil {
+		t.Errorf("expected error for missing backing file")
+	}
+	if _, err := qcow2.CreateFromPath(filepath.Join(dir, "a.qcow2"), 0, qcow2.WithBackingFile("missing.img", "vmdk", nil)); err == nil {
+		t.Errorf("expected error for unsupported backing file format")
+	}
+
+	// a loop of backing files, detected as qcow2 when opening
+	if err := os.WriteFile(filepath.Join(dir, "base.img"), make([]byte, 1024*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	img, err := qcow2.CreateFromPath(filepath.Join(dir, "loop.qcow2"), 0, qcow2.WithBackingFile("base.img", "", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img.Close()
+	if err := os.Remove(filepath.Join(dir, "base.img")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("loop.qcow2", filepath.Join(dir, "base.img")); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := qcow2.OpenFromPath(filepath.Join(dir, "loop.qcow2"), true); err == nil {
+		img.Close()
+		t.Errorf("expected error for loop of backing files")
+	}
+
+	// without a path, the backing file must be provided
+	f, err := os.Open(filepath.Join(dir, "loop.qcow2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := qcow2.New(file.New(f, true), true); err == nil {
+		t.Errorf("expected error for missing backing file storage")
+	}
+}
+
+func TestDataFile(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(2 * 1024 * 1024)
+	img, err := qcow2.CreateFromPath(filepath.Join(dir, "test.qcow2"), size, qcow2.WithClusterSize(4096), qcow2.WithDataFile("test.data", nil))
+	if err != nil {
+		t.Fatalf("error creating image with data file: %v", err)
+	}
+	data := make([]byte, 10000)
+	_, _ = rand.Read(data)
+	if _, err := img.WriteAt(data, 5000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// guest data is at its guest offset in the data file
+	raw, err := os.ReadFile(filepath.Join(dir, "test.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 5000+len(data) || !bytes.Equal(raw[5000:5000+len(data)], data) {
+		t.Errorf("data not written to data file at guest offset")
+	}
+
+	img, err = qcow2.OpenFromPath(filepath.Join(dir, "test.qcow2"), true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	if img.DataFile() != "test.data" {
+		t.Errorf("data file %q instead of %q", img.DataFile(), "test.data")
+	}
+	b := make([]byte, 20000)
+	if _, err := img.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	expected := make([]byte, 20000)
+	copy(expected[5000:], data)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("mismatched contents read from data file")
+	}
+
+	// the data file and the image are removed when the image cannot be created
+	if _, err := qcow2.CreateFromPath(filepath.Join(dir, "bad.qcow2"), -1, qcow2.WithDataFile("bad.data", nil)); err == nil {
+		t.Fatalf("expected error for invalid size")
+	}
+	for _, name := range []string{"bad.qcow2", "bad.data"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind by failed create: %v", name, err)
+		}
+	}
+}
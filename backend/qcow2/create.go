@@ -0,0 +1,226 @@
+package qcow2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+const (
+	defaultClusterBits   = 16
+	defaultRefcountOrder = 4
+)
+
+type createOpts struct {
+	clusterBits   uint32
+	backingFile   string
+	backingFormat string
+	backing       backend.Storage
+	dataFile      string
+	data          backend.Storage
+}
+
+// CreateOpt func that process Create options
+type CreateOpt func(o *createOpts) error
+
+// WithClusterSize sets the cluster size of the new image, which must be a power of 2
+// between 512 bytes and 2MB. Default is 64KB, as with qemu-img.
+func WithClusterSize(size int64) CreateOpt {
+	return func(o *createOpts) error {
+		for bits := uint32(minClusterBits); bits <= maxClusterBits; bits++ {
+			if int64(1)<<bits == size {
+				o.clusterBits = bits
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid cluster size %d, must be a power of 2 between %d and %d", size, 1<<minClusterBits, 1<<maxClusterBits)
+	}
+}
+
+// WithBackingFile creates an image that is derived from a backing file, so that only the clusters
+// that are changed are stored in the new image. The name is recorded in the image, and is resolved
+// relative to the directory of the image when it is opened; format is "qcow2", "raw", or empty to detect
+// it when opening. The backing file itself is b; with CreateFromPath, b may be nil to open it by name.
+func WithBackingFile(name, format string, b backend.Storage) CreateOpt {
+	return func(o *createOpts) error {
+		if name == "" {
+			return errors.New("must pass backing file name")
+		}
+		switch format {
+		case "", formatQcow2, formatRaw:
+		default:
+			return fmt.Errorf("unsupported backing file format %q", format)
+		}
+		o.backingFile, o.backingFormat, o.backing = name, format, b
+		return nil
+	}
+}
+
+// WithDataFile creates an image that stores its guest clusters in an external data file, which is
+// resolved like the name passed to WithBackingFile. The data file itself is b, which must be writable;
+// with CreateFromPath, b may be nil to create it by name, in which case it must not exist.
+func WithDataFile(name string, b backend.Storage) CreateOpt {
+	return func(o *createOpts) error {
+		if name == "" {
+			return errors.New("must pass data file name")
+		}
+		o.dataFile, o.data = name, b
+		return nil
+	}
+}
+
+// Create writes a new, empty qcow2 version 3 image with a virtual disk of the given size into
+// the provided backend.Storage, which must be writable, and should be empty. If the image has
+// a backing file, the size may be 0 to use the size of the backing file.
+func Create(b backend.Storage, size int64, opts ...CreateOpt) (*Image, error) {
+	opt := &createOpts{clusterBits: defaultClusterBits}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	if opt.backingFile != "" && opt.backing == nil {
+		return nil, fmt.Errorf("backing file %s was not provided", opt.backingFile)
+	}
+	if opt.dataFile != "" && opt.data == nil {
+		return nil, fmt.Errorf("data file %s was not provided", opt.dataFile)
+	}
+	if size == 0 && opt.backing != nil {
+		info, err := opt.backing.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("could not stat backing file: %w", err)
+		}
+		size = info.Size()
+	}
+	if size <= 0 {
+		return nil, errors.New("must pass valid virtual disk size to create")
+	}
+	rw, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+
+	clusterSize := int64(1) << opt.clusterBits
+	perL1 := clusterSize * (clusterSize / 8)
+	l1Size := (size + perL1 - 1) / perL1
+	l1Clusters := max((l1Size*8+clusterSize-1)/clusterSize, 1)
+
+	// cluster 0 is the header, cluster 1 the refcount table, followed by the L1 table.
+	// Refcount blocks are allocated as the refcounts of those clusters are set.
+	h := &header{
+		version:               3,
+		clusterBits:           opt.clusterBits,
+		size:                  uint64(size),
+		l1Size:                uint32(l1Size),
+		l1TableOffset:         uint64(2 * clusterSize),
+		refcountTableOffset:   uint64(clusterSize),
+		refcountTableClusters: 1,
+		refcountOrder:         defaultRefcountOrder,
+		backingFile:           opt.backingFile,
+		backingFileFormat:     opt.backingFormat,
+		externalDataFile:      opt.dataFile,
+	}
+	var open []OpenOpt
+	if opt.backing != nil {
+		open = append(open, WithBackingStorage(opt.backing))
+	}
+	if opt.data != nil {
+		h.incompatibleFeatures |= incompatExternalData
+		open = append(open, WithDataFileStorage(opt.data))
+	}
+	hb, err := h.toBytes(int(clusterSize))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.WriteAt(hb, 0); err != nil {
+		return nil, fmt.Errorf("error writing qcow2 header: %w", err)
+	}
+	zero := make([]byte, clusterSize)
+	for i := int64(1); i < 2+l1Clusters; i++ {
+		if _, err := rw.WriteAt(zero, i*clusterSize); err != nil {
+			return nil, fmt.Errorf("error writing qcow2 metadata: %w", err)
+		}
+	}
+
+	img, err := New(b, false, open...)
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < 2+l1Clusters; i++ {
+		if err := img.addRefcount(i*clusterSize, 1); err != nil {
+			return nil, fmt.Errorf("error setting refcounts of new image: %w", err)
+		}
+	}
+	return img, nil
+}
+
+// CreateFromPath creates a new, empty qcow2 version 3 image file with a virtual disk of the given size.
+// The provided file must not exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	opt := &createOpts{}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	// open the backing file and create the data file by name if they were not provided; if
+	// creating the image fails, they are closed and the files created are removed
+	var (
+		related []backend.Storage
+		created []string
+	)
+	closeRelated := func() {
+		for _, r := range related {
+			r.Close()
+		}
+		for _, p := range created {
+			os.Remove(p)
+		}
+	}
+	if opt.backingFile != "" && opt.backing == nil {
+		backing, err := openBacking(relativePath(pathName, opt.backingFile), opt.backingFormat, 1)
+		if err != nil {
+			return nil, err
+		}
+		related = append(related, backing)
+		opts = append(opts, func(o *createOpts) error {
+			o.backing = backing
+			return nil
+		})
+	}
+	if opt.dataFile != "" && opt.data == nil {
+		dataPath := relativePath(pathName, opt.dataFile)
+		f, err := os.OpenFile(dataPath, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+		if err != nil {
+			closeRelated()
+			return nil, fmt.Errorf("could not create data file %s: %w", dataPath, err)
+		}
+		created = append(created, dataPath)
+		data := file.New(f, false)
+		related = append(related, data)
+		opts = append(opts, func(o *createOpts) error {
+			o.data = data
+			return nil
+		})
+	}
+
+	f, err := os.OpenFile(pathName, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+	if err != nil {
+		closeRelated()
+		return nil, fmt.Errorf("could not create image %s: %w", pathName, err)
+	}
+	img, err := Create(file.New(f, false), size, opts...)
+	if err != nil {
+		f.Close()
+		created = append(created, pathName)
+		closeRelated()
+		return nil, fmt.Errorf("could not create qcow2 image %s: %w", pathName, err)
+	}
+	return img, nil
+}
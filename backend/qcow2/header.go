@@ -0,0 +1,249 @@
+package qcow2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	headerV2Size = 72
+	headerV3Size = 104
+
+	minClusterBits = 9
+	maxClusterBits = 21
+
+	// offset of the refcount table offset and clusters fields, rewritten when the table grows
+	refcountTableOffsetField = 48
+)
+
+// incompatible feature bits
+const (
+	incompatDirty         uint64 = 1 << 0
+	incompatCorrupt       uint64 = 1 << 1
+	incompatExternalData  uint64 = 1 << 2
+	incompatCompression   uint64 = 1 << 3
+	incompatSupportedMask        = incompatDirty | incompatCorrupt | incompatExternalData | incompatCompression
+)
+
+// header extension types
+const (
+	extensionEnd               uint32 = 0x00000000
+	extensionBackingFileFormat uint32 = 0xe2792aca
+	extensionExternalDataFile  uint32 = 0x44415441
+)
+
+// compression types, only meaningful when the compression type incompatible feature is set
+const (
+	compressionDeflate uint8 = 0
+	compressionZstd    uint8 = 1
+)
+
+func getMagic() []byte {
+	return []byte{'Q', 'F', 'I', 0xfb}
+}
+
+// header is the qcow2 image header, see
+// https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+type header struct {
+	version               uint32
+	backingFileOffset     uint64
+	backingFileSize       uint32
+	clusterBits           uint32
+	size                  uint64
+	cryptMethod           uint32
+	l1Size                uint32
+	l1TableOffset         uint64
+	refcountTableOffset   uint64
+	refcountTableClusters uint32
+	nbSnapshots           uint32
+	snapshotsOffset       uint64
+	incompatibleFeatures  uint64
+	compatibleFeatures    uint64
+	autoclearFeatures     uint64
+	refcountOrder         uint32
+	headerLength          uint32
+	compressionType       uint8
+	// from header extensions
+	backingFileFormat string
+	externalDataFile  string
+	// backingFile is read from backingFileOffset
+	backingFile string
+}
+
+// headerFromBytes parses the header from the first cluster of the image
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < headerV2Size {
+		return nil, fmt.Errorf("header was %d bytes instead of minimum %d", len(b), headerV2Size)
+	}
+	if !bytes.Equal(b[0:4], getMagic()) {
+		return nil, fmt.Errorf("invalid qcow2 magic %v", b[0:4])
+	}
+	h := &header{
+		version:               binary.BigEndian.Uint32(b[4:8]),
+		backingFileOffset:     binary.BigEndian.Uint64(b[8:16]),
+		backingFileSize:       binary.BigEndian.Uint32(b[16:20]),
+		clusterBits:           binary.BigEndian.Uint32(b[20:24]),
+		size:                  binary.BigEndian.Uint64(b[24:32]),
+		cryptMethod:           binary.BigEndian.Uint32(b[32:36]),
+		l1Size:                binary.BigEndian.Uint32(b[36:40]),
+		l1TableOffset:         binary.BigEndian.Uint64(b[40:48]),
+		refcountTableOffset:   binary.BigEndian.Uint64(b[48:56]),
+		refcountTableClusters: binary.BigEndian.Uint32(b[56:60]),
+		nbSnapshots:           binary.BigEndian.Uint32(b[60:64]),
+		snapshotsOffset:       binary.BigEndian.Uint64(b[64:72]),
+		refcountOrder:         4,
+		headerLength:          headerV2Size,
+	}
+	switch h.version {
+	case 2:
+	case 3:
+		if len(b) < headerV3Size {
+			return nil, fmt.Errorf("version 3 header was %d bytes instead of minimum %d", len(b), headerV3Size)
+		}
+		h.incompatibleFeatures = binary.BigEndian.Uint64(b[72:80])
+		h.compatibleFeatures = binary.BigEndian.Uint64(b[80:88])
+		h.autoclearFeatures = binary.BigEndian.Uint64(b[88:96])
+		h.refcountOrder = binary.BigEndian.Uint32(b[96:100])
+		h.headerLength = binary.BigEndian.Uint32(b[100:104])
+		if h.headerLength < headerV3Size || int(h.headerLength) > len(b) {
+			return nil, fmt.Errorf("invalid header length %d", h.headerLength)
+		}
+		if h.headerLength > headerV3Size {
+			h.compressionType = b[104]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported qcow2 version %d", h.version)
+	}
+
+	if h.clusterBits < minClusterBits || h.clusterBits > maxClusterBits {
+		return nil, fmt.Errorf("unsupported cluster bits %d", h.clusterBits)
+	}
+	if h.refcountOrder > 6 {
+		return nil, fmt.Errorf("invalid refcount order %d", h.refcountOrder)
+	}
+	if h.cryptMethod != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+	if unknown := h.incompatibleFeatures &^ incompatSupportedMask; unknown != 0 {
+		return nil, fmt.Errorf("unsupported incompatible features %#x", unknown)
+	}
+	if h.incompatibleFeatures&incompatCompression == 0 {
+		h.compressionType = compressionDeflate
+	}
+	if h.compressionType != compressionDeflate && h.compressionType != compressionZstd {
+		return nil, fmt.Errorf("unsupported compression type %d", h.compressionType)
+	}
+
+	// header extensions follow the header, and precede the backing file name, if any
+	extEnd := uint64(len(b))
+	if h.backingFileOffset != 0 && h.backingFileOffset < extEnd {
+		extEnd = h.backingFileOffset
+	}
+	if extEnd > uint64(h.headerLength) {
+		if err := h.readExtensions(b[h.headerLength:extEnd]); err != nil {
+			return nil, err
+		}
+	}
+	if h.backingFileOffset != 0 {
+		end := h.backingFileOffset + uint64(h.backingFileSize)
+		if end > uint64(len(b)) {
+			return nil, fmt.Errorf("backing file name at %d with size %d is outside of the header cluster", h.backingFileOffset, h.backingFileSize)
+		}
+		h.backingFile = string(b[h.backingFileOffset:end])
+	}
+	return h, nil
+}
+
+// readExtensions reads header extensions, each of which is padded to a multiple of 8 bytes
+func (h *header) readExtensions(b []byte) error {
+	for len(b) >= 8 {
+		extType := binary.BigEndian.Uint32(b[0:4])
+		extLength := binary.BigEndian.Uint32(b[4:8])
+		if extType == extensionEnd {
+			return nil
+		}
+		if 8+uint64(extLength) > uint64(len(b)) {
+			return fmt.Errorf("header extension %#x with length %d exceeds header cluster", extType, extLength)
+		}
+		data := b[8 : 8+extLength]
+		switch extType {
+		case extensionBackingFileFormat:
+			h.backingFileFormat = string(data)
+		case extensionExternalDataFile:
+			h.externalDataFile = string(data)
+		}
+		padded := (uint64(extLength) + 7) &^ 7
+		if 8+padded > uint64(len(b)) {
+			return nil
+		}
+		b = b[8+padded:]
+	}
+	return nil
+}
+
+// toBytes serializes the header, including any header extensions, the end-of-extensions
+// marker and the backing file name, into a buffer of the given size
+func (h *header) toBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	copy(b[0:4], getMagic())
+	binary.BigEndian.PutUint32(b[4:8], h.version)
+	binary.BigEndian.PutUint32(b[20:24], h.clusterBits)
+	binary.BigEndian.PutUint64(b[24:32], h.size)
+	binary.BigEndian.PutUint32(b[32:36], h.cryptMethod)
+	binary.BigEndian.PutUint32(b[36:40], h.l1Size)
+	binary.BigEndian.PutUint64(b[40:48], h.l1TableOffset)
+	binary.BigEndian.PutUint64(b[48:56], h.refcountTableOffset)
+	binary.BigEndian.PutUint32(b[56:60], h.refcountTableClusters)
+	binary.BigEndian.PutUint32(b[60:64], h.nbSnapshots)
+	binary.BigEndian.PutUint64(b[64:72], h.snapshotsOffset)
+
+	pos := headerV2Size
+	if h.version >= 3 {
+		h.headerLength = headerV3Size
+		binary.BigEndian.PutUint64(b[72:80], h.incompatibleFeatures)
+		binary.BigEndian.PutUint64(b[80:88], h.compatibleFeatures)
+		binary.BigEndian.PutUint64(b[88:96], h.autoclearFeatures)
+		binary.BigEndian.PutUint32(b[96:100], h.refcountOrder)
+		binary.BigEndian.PutUint32(b[100:104], h.headerLength)
+		pos = headerV3Size
+	}
+
+	writeExtension := func(extType uint32, data []byte) error {
+		padded := (len(data) + 7) &^ 7
+		if pos+8+padded > size {
+			return fmt.Errorf("header extensions do not fit in %d bytes", size)
+		}
+		binary.BigEndian.PutUint32(b[pos:pos+4], extType)
+		binary.BigEndian.PutUint32(b[pos+4:pos+8], uint32(len(data)))
+		copy(b[pos+8:], data)
+		pos += 8 + padded
+		return nil
+	}
+	if h.backingFileFormat != "" {
+		if err := writeExtension(extensionBackingFileFormat, []byte(h.backingFileFormat)); err != nil {
+			return nil, err
+		}
+	}
+	if h.externalDataFile != "" {
+		if err := writeExtension(extensionExternalDataFile, []byte(h.externalDataFile)); err != nil {
+			return nil, err
+		}
+	}
+	// end of extensions marker
+	if err := writeExtension(extensionEnd, nil); err != nil {
+		return nil, err
+	}
+
+	if h.backingFile != "" {
+		if pos+len(h.backingFile) > size {
+			return nil, fmt.Errorf("backing file name does not fit in %d bytes", size)
+		}
+		h.backingFileOffset = uint64(pos)
+		h.backingFileSize = uint32(len(h.backingFile))
+		copy(b[pos:], h.backingFile)
+	}
+	binary.BigEndian.PutUint64(b[8:16], h.backingFileOffset)
+	binary.BigEndian.PutUint32(b[16:20], h.backingFileSize)
+	return b, nil
+}
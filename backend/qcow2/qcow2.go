@@ -0,0 +1,789 @@
+// Package qcow2 provides a backend for disk images in the QEMU copy-on-write version 2 and 3 format.
+//
+// The backend presents the virtual disk contained in the image, so partition tables and filesystems
+// can be read and modified inside a qcow2 image directly, without first converting it to a raw image.
+// Clusters are allocated at the end of the image on first write, and the refcounts are kept up to date,
+// so the resulting image is consistent and can be used by qemu immediately.
+//
+// Images derived from a backing file, such as an overlay on a shared base image, read unallocated
+// clusters from the backing file, which may itself be a qcow2 image with a backing file. Images with an
+// external data file read and write guest data in the data file, and only keep metadata in the image.
+//
+// Encrypted images and images with extended L2 entries (subclusters) are not supported.
+package qcow2
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// l1/l2 entry flags
+	entryCopied     uint64 = 1 << 63
+	entryCompressed uint64 = 1 << 62
+	entryZero       uint64 = 1 << 0
+	// mask of the host cluster offset in standard l1 and l2 entries
+	offsetMask uint64 = 0x00fffffffffffe00
+
+	// maximum number of cached L2 tables and refcount blocks
+	maxCachedTables = 256
+
+	compressedSectorSize = 512
+)
+
+// Image is a qcow2 image presented as a backend.Storage of the size of its virtual disk
+type Image struct {
+	storage  backend.Storage
+	rw       backend.WritableFile
+	readOnly bool
+	header   *header
+
+	// data is where guest clusters are stored: the image itself, or the external data file
+	data   backend.Storage
+	dataRW backend.WritableFile
+	// backing is read for clusters that are not allocated in the image, if the image has a backing file
+	backing     backend.Storage
+	backingSize int64
+
+	clusterSize   int64
+	l2Entries     int64
+	refcountBits  uint64
+	l1Table       []uint64
+	refcountTable []uint64
+	snapshots     []Snapshot
+	// snapshot is the snapshot presented instead of the current virtual disk, if any
+	snapshot *Snapshot
+	// nextFree is the host offset of the next cluster to allocate, always at the end of the image
+	nextFree int64
+
+	// write-through caches of metadata clusters, keyed by host offset
+	l2Cache       map[int64][]uint64
+	refblockCache map[int64][]byte
+	// the most recently decompressed cluster
+	compressedOffset int64
+	compressedData   []byte
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the qcow2 image stored in the provided backend.Storage.
+// If readOnly is false, the storage must be writable.
+//
+// With WithSnapshot, the image presents the contents of an internal snapshot instead of the current ones.
+//
+// If the image has a backing file or an external data file, it must be passed with WithBackingStorage
+// or WithDataFileStorage, as the storage has no path to find it by; OpenFromPath opens them automatically.
+// The image takes ownership of them, and closes them when it is closed.
+func New(b backend.Storage, readOnly bool, opts ...OpenOpt) (*Image, error) {
+	opt := &openOpts{}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	img := &Image{
+		storage:       b,
+		readOnly:      readOnly,
+		data:          b,
+		l2Cache:       map[int64][]uint64{},
+		refblockCache: map[int64][]byte{},
+	}
+	if !readOnly {
+		rw, err := b.Writable()
+		if err != nil {
+			return nil, err
+		}
+		img.rw = rw
+		img.dataRW = rw
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	if opt.snapshot != "" {
+		if err := img.useSnapshot(opt.snapshot); err != nil {
+			return nil, err
+		}
+	}
+	if err := img.setRelated(opt); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing qcow2 image file
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	storage := file.New(f, readOnly)
+	img, err := openRelated(storage, pathName, readOnly, 0)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open qcow2 image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+// readHeader reads the header from the first cluster of the image
+func readHeader(storage io.ReaderAt) (*header, error) {
+	// the header always fits in the first cluster; read as much as a maximum cluster
+	// and then trim it once we know the actual cluster size
+	b := make([]byte, 1<<maxClusterBits)
+	n, err := storage.ReadAt(b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading qcow2 header: %w", err)
+	}
+	b = b[:n]
+	if len(b) >= 24 {
+		if clusterBits := binary.BigEndian.Uint32(b[20:24]); clusterBits >= minClusterBits && clusterBits <= maxClusterBits && int(1<<clusterBits) < len(b) {
+			b = b[:1<<clusterBits]
+		}
+	}
+	return headerFromBytes(b)
+}
+
+func (img *Image) init() error {
+	h, err := readHeader(img.storage)
+	if err != nil {
+		return err
+	}
+	if !img.readOnly && h.incompatibleFeatures&(incompatDirty|incompatCorrupt) != 0 {
+		return fmt.Errorf("qcow2 image is marked dirty or corrupt, and can only be opened read-only")
+	}
+	img.header = h
+	img.clusterSize = 1 << h.clusterBits
+	img.l2Entries = img.clusterSize / 8
+	img.refcountBits = 1 << h.refcountOrder
+
+	// make sure the L1 table is large enough to map the whole virtual disk, and read no more of
+	// it than that, whatever size the header claims
+	needed := img.l1SizeFor(h.size)
+	if uint64(h.l1Size) < needed {
+		return fmt.Errorf("L1 table has %d entries, but the virtual size %d requires %d", h.l1Size, h.size, needed)
+	}
+	if img.l1Table, err = img.readTable(int64(h.l1TableOffset), int64(needed)); err != nil {
+		return fmt.Errorf("error reading L1 table: %w", err)
+	}
+	refcountEntries := int64(h.refcountTableClusters) * img.clusterSize / 8
+	if img.refcountTable, err = img.readTable(int64(h.refcountTableOffset), refcountEntries); err != nil {
+		return fmt.Errorf("error reading refcount table: %w", err)
+	}
+	if img.snapshots, err = img.readSnapshots(); err != nil {
+		return fmt.Errorf("error reading snapshot table: %w", err)
+	}
+
+	info, err := img.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat qcow2 image: %w", err)
+	}
+	img.nextFree = img.alignCluster(info.Size())
+	return nil
+}
+
+// l1SizeFor number of L1 entries required to map a virtual disk of the given size
+func (img *Image) l1SizeFor(size uint64) uint64 {
+	perL1 := uint64(img.clusterSize) * uint64(img.l2Entries)
+	return (size + perL1 - 1) / perL1
+}
+
+func (img *Image) alignCluster(off int64) int64 {
+	return (off + img.clusterSize - 1) &^ (img.clusterSize - 1)
+}
+
+// readTable reads a table of big-endian uint64 entries
+func (img *Image) readTable(offset, entries int64) ([]uint64, error) {
+	b := make([]byte, entries*8)
+	if err := img.readFull(b, offset); err != nil {
+		return nil, err
+	}
+	table := make([]uint64, entries)
+	for i := range table {
+		table[i] = binary.BigEndian.Uint64(b[i*8 : i*8+8])
+	}
+	return table, nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, treating data past the end of the image as zero
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(b[n:])
+	return nil
+}
+
+func (img *Image) writeFull(b []byte, offset int64) error {
+	n, err := img.rw.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+func (img *Image) writeUint64(v uint64, offset int64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return img.writeFull(b, offset)
+}
+
+// Size is the size of the virtual disk
+func (img *Image) Size() int64 {
+	return int64(img.header.size)
+}
+
+// ClusterSize is the size of a cluster in the image
+func (img *Image) ClusterSize() int64 {
+	return img.clusterSize
+}
+
+// Version is the qcow2 version of the image, either 2 or 3
+func (img *Image) Version() int {
+	return int(img.header.version)
+}
+
+// l2Table returns the L2 table at the given host offset
+func (img *Image) l2Table(offset int64) ([]uint64, error) {
+	if t, ok := img.l2Cache[offset]; ok {
+		return t, nil
+	}
+	t, err := img.readTable(offset, img.l2Entries)
+	if err != nil {
+		return nil, fmt.Errorf("error reading L2 table at %d: %w", offset, err)
+	}
+	if len(img.l2Cache) >= maxCachedTables {
+		clear(img.l2Cache)
+	}
+	img.l2Cache[offset] = t
+	return t, nil
+}
+
+// lookup returns the L2 entry for the cluster containing the virtual offset.
+// A zero entry means the cluster is not allocated.
+func (img *Image) lookup(virtual int64) (uint64, error) {
+	cluster := virtual / img.clusterSize
+	l1Index := cluster / img.l2Entries
+	if l1Index >= int64(len(img.l1Table)) {
+		return 0, nil
+	}
+	l2Offset := int64(img.l1Table[l1Index] & offsetMask)
+	if l2Offset == 0 {
+		return 0, nil
+	}
+	l2, err := img.l2Table(l2Offset)
+	if err != nil {
+		return 0, err
+	}
+	return l2[cluster%img.l2Entries], nil
+}
+
+// compressedLocation host offset and maximum size of the compressed data described by an L2 entry
+func (img *Image) compressedLocation(entry uint64) (offset, size int64) {
+	x := 62 - (img.header.clusterBits - 8)
+	offset = int64(entry & (1<<x - 1))
+	sectors := int64((entry>>x)&(1<<(img.header.clusterBits-8)-1)) + 1
+	size = sectors*compressedSectorSize - offset%compressedSectorSize
+	return offset, size
+}
+
+// readCompressed returns the decompressed contents of a compressed cluster
+func (img *Image) readCompressed(entry uint64) ([]byte, error) {
+	offset, size := img.compressedLocation(entry)
+	if img.compressedData != nil && img.compressedOffset == offset {
+		return img.compressedData, nil
+	}
+	in := make([]byte, size)
+	if err := img.readFull(in, offset); err != nil {
+		return nil, fmt.Errorf("error reading compressed cluster at %d: %w", offset, err)
+	}
+	var r io.Reader
+	switch img.header.compressionType {
+	case compressionZstd:
+		dec, err := zstd.NewReader(bytes.NewReader(in))
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		dec := flate.NewReader(bytes.NewReader(in))
+		defer dec.Close()
+		r = dec
+	}
+	out := make([]byte, img.clusterSize)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, fmt.Errorf("error decompressing cluster at %d: %w", offset, err)
+	}
+	img.compressedOffset, img.compressedData = offset, out
+	return out, nil
+}
+
+// readCluster reads part of a single virtual cluster into b, which must not cross a cluster boundary
+func (img *Image) readCluster(b []byte, virtual int64) error {
+	entry, err := img.lookup(virtual)
+	if err != nil {
+		return err
+	}
+	inCluster := virtual % img.clusterSize
+	switch {
+	case entry&entryCompressed != 0:
+		if img.hasDataFile() {
+			return fmt.Errorf("compressed cluster at virtual offset %d in image with an external data file", virtual)
+		}
+		data, err := img.readCompressed(entry)
+		if err != nil {
+			return err
+		}
+		copy(b, data[inCluster:])
+	case entry&entryZero != 0 && img.header.version >= 3:
+		clear(b)
+	case entry&offsetMask == 0:
+		return img.readBacking(b, virtual)
+	default:
+		n, err := img.data.ReadAt(b, int64(entry&offsetMask)+inCluster)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		clear(b[n:])
+	}
+	return nil
+}
+
+// ReadAt reads from the virtual disk
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	return img.readAt(p, off)
+}
+
+func (img *Image) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	read := 0
+	for read < len(p) {
+		virtual := off + int64(read)
+		chunk := int(min(int64(len(p)-read), img.clusterSize-virtual%img.clusterSize))
+		if err := img.readCluster(p[read:read+chunk], virtual); err != nil {
+			return read, err
+		}
+		read += chunk
+	}
+	return read, eof
+}
+
+// WriteAt writes to the virtual disk, allocating clusters in the image as needed
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > img.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the virtual disk of size %d", len(p), off, img.Size())
+	}
+	written := 0
+	for written < len(p) {
+		virtual := off + int64(written)
+		chunk := int(min(int64(len(p)-written), img.clusterSize-virtual%img.clusterSize))
+		if err := img.writeCluster(p[written:written+chunk], virtual); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// writeCluster writes part of a single virtual cluster, which must not cross a cluster boundary
+func (img *Image) writeCluster(b []byte, virtual int64) error {
+	cluster := virtual / img.clusterSize
+	l1Index := cluster / img.l2Entries
+	l2Index := cluster % img.l2Entries
+	l2Offset, l2, err := img.writableL2(l1Index)
+	if err != nil {
+		return err
+	}
+	entry := l2[l2Index]
+	inCluster := virtual % img.clusterSize
+
+	// fast path: an allocated, unshared cluster is simply overwritten
+	if entry&entryCompressed == 0 && entry&entryCopied != 0 && entry&offsetMask != 0 && entry&entryZero == 0 {
+		return img.writeData(b, int64(entry&offsetMask)+inCluster)
+	}
+
+	// otherwise build the full new contents of the cluster, and write it to a newly allocated cluster
+	data := make([]byte, img.clusterSize)
+	if len(b) != len(data) {
+		if err := img.readCluster(data, cluster*img.clusterSize); err != nil {
+			return err
+		}
+	}
+	copy(data[inCluster:], b)
+
+	var newOffset int64
+	switch {
+	case img.hasDataFile():
+		// guest clusters in an external data file are at their guest offset, and are not refcounted
+		newOffset = cluster * img.clusterSize
+	case entry&entryCompressed == 0 && entry&offsetMask != 0 && entry&entryZero != 0 && entry&entryCopied != 0:
+		// preallocated zero cluster that we own, reuse it
+		newOffset = int64(entry & offsetMask)
+	default:
+		if newOffset, err = img.allocCluster(); err != nil {
+			return err
+		}
+		if err := img.releaseEntry(entry); err != nil {
+			return err
+		}
+	}
+	if err := img.writeData(data, newOffset); err != nil {
+		return fmt.Errorf("error writing data cluster at %d: %w", newOffset, err)
+	}
+	newEntry := uint64(newOffset) | entryCopied
+	l2[l2Index] = newEntry
+	return img.writeUint64(newEntry, l2Offset+l2Index*8)
+}
+
+// releaseEntry drops the reference that an L2 entry that is about to be replaced holds on its host clusters
+func (img *Image) releaseEntry(entry uint64) error {
+	switch {
+	case entry&entryCompressed != 0:
+		offset, size := img.compressedLocation(entry)
+		for c := offset &^ (img.clusterSize - 1); c < offset+size; c += img.clusterSize {
+			if err := img.addRefcount(c, -1); err != nil {
+				return err
+			}
+		}
+	case entry&offsetMask != 0:
+		return img.addRefcount(int64(entry&offsetMask), -1)
+	}
+	return nil
+}
+
+// writableL2 returns an L2 table for the given L1 index that may be modified in place,
+// allocating it or copying a shared one as needed
+func (img *Image) writableL2(l1Index int64) (int64, []uint64, error) {
+	if l1Index >= int64(len(img.l1Table)) {
+		return 0, nil, fmt.Errorf("L1 index %d out of range", l1Index)
+	}
+	l1Entry := img.l1Table[l1Index]
+	l2Offset := int64(l1Entry & offsetMask)
+	if l2Offset != 0 && l1Entry&entryCopied != 0 {
+		l2, err := img.l2Table(l2Offset)
+		return l2Offset, l2, err
+	}
+
+	l2 := make([]uint64, img.l2Entries)
+	if l2Offset != 0 {
+		// shared with a snapshot: copy it, the data clusters it references are already counted as shared
+		old, err := img.l2Table(l2Offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, e := range old {
+			l2[i] = e &^ entryCopied
+		}
+		if err := img.addRefcount(l2Offset, -1); err != nil {
+			return 0, nil, err
+		}
+	}
+	newOffset, err := img.allocCluster()
+	if err != nil {
+		return 0, nil, err
+	}
+	b := make([]byte, img.clusterSize)
+	for i, e := range l2 {
+		binary.BigEndian.PutUint64(b[i*8:], e)
+	}
+	if err := img.writeFull(b, newOffset); err != nil {
+		return 0, nil, fmt.Errorf("error writing L2 table at %d: %w", newOffset, err)
+	}
+	img.l2Cache[newOffset] = l2
+	newEntry := uint64(newOffset) | entryCopied
+	img.l1Table[l1Index] = newEntry
+	if err := img.writeUint64(newEntry, int64(img.header.l1TableOffset)+l1Index*8); err != nil {
+		return 0, nil, fmt.Errorf("error updating L1 table: %w", err)
+	}
+	return newOffset, l2, nil
+}
+
+// allocCluster allocates a new cluster at the end of the image, with refcount 1
+func (img *Image) allocCluster() (int64, error) {
+	offset := img.nextFree
+	img.nextFree += img.clusterSize
+	if err := img.addRefcount(offset, 1); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// refcountBlock returns the refcount block at the given host offset
+func (img *Image) refcountBlock(offset int64) ([]byte, error) {
+	if b, ok := img.refblockCache[offset]; ok {
+		return b, nil
+	}
+	b := make([]byte, img.clusterSize)
+	if err := img.readFull(b, offset); err != nil {
+		return nil, fmt.Errorf("error reading refcount block at %d: %w", offset, err)
+	}
+	if len(img.refblockCache) >= maxCachedTables {
+		clear(img.refblockCache)
+	}
+	img.refblockCache[offset] = b
+	return b, nil
+}
+
+// refcountLocation the index in the refcount table and in the refcount block for a host cluster
+func (img *Image) refcountLocation(hostOffset int64) (tableIndex, blockIndex int64) {
+	cluster := hostOffset / img.clusterSize
+	perBlock := img.clusterSize * 8 / int64(img.refcountBits)
+	return cluster / perBlock, cluster % perBlock
+}
+
+// getRefcountEntry reads entry i of a refcount block; sub-byte entries are stored least significant bits first
+func (img *Image) getRefcountEntry(block []byte, i int64) uint64 {
+	bits := img.refcountBits
+	if bits < 8 {
+		perByte := 8 / int64(bits)
+		shift := uint64(i%perByte) * bits
+		return uint64(block[i/perByte]>>shift) & (1<<bits - 1)
+	}
+	bytesPer := int64(bits / 8)
+	var v uint64
+	for _, c := range block[i*bytesPer : (i+1)*bytesPer] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// setRefcountEntry sets entry i of a refcount block, and returns the byte range that changed
+func (img *Image) setRefcountEntry(block []byte, i int64, v uint64) (start, end int64) {
+	bits := img.refcountBits
+	if bits < 8 {
+		perByte := 8 / int64(bits)
+		shift := uint64(i%perByte) * bits
+		mask := byte((1<<bits - 1) << shift)
+		block[i/perByte] = block[i/perByte]&^mask | byte(v<<shift)&mask
+		return i / perByte, i/perByte + 1
+	}
+	bytesPer := int64(bits / 8)
+	start, end = i*bytesPer, (i+1)*bytesPer
+	for j := end - 1; j >= start; j-- {
+		block[j] = byte(v)
+		v >>= 8
+	}
+	return start, end
+}
+
+// getRefcount returns the refcount of the host cluster at the offset
+func (img *Image) getRefcount(hostOffset int64) (uint64, error) {
+	tableIndex, blockIndex := img.refcountLocation(hostOffset)
+	if tableIndex >= int64(len(img.refcountTable)) || img.refcountTable[tableIndex]&offsetMask == 0 {
+		return 0, nil
+	}
+	block, err := img.refcountBlock(int64(img.refcountTable[tableIndex] & offsetMask))
+	if err != nil {
+		return 0, err
+	}
+	return img.getRefcountEntry(block, blockIndex), nil
+}
+
+// addRefcount changes the refcount of the host cluster at the offset, allocating refcount blocks
+// and growing the refcount table as needed
+func (img *Image) addRefcount(hostOffset int64, delta int) error {
+	tableIndex, blockIndex := img.refcountLocation(hostOffset)
+	if tableIndex >= int64(len(img.refcountTable)) {
+		if err := img.growRefcountTable(tableIndex + 1); err != nil {
+			return err
+		}
+	}
+	blockOffset := int64(img.refcountTable[tableIndex] & offsetMask)
+	if blockOffset == 0 {
+		// the new refcount block is allocated directly, as allocCluster would recurse into here
+		blockOffset = img.nextFree
+		img.nextFree += img.clusterSize
+		if err := img.writeFull(make([]byte, img.clusterSize), blockOffset); err != nil {
+			return fmt.Errorf("error writing refcount block at %d: %w", blockOffset, err)
+		}
+		img.refblockCache[blockOffset] = make([]byte, img.clusterSize)
+		img.refcountTable[tableIndex] = uint64(blockOffset)
+		if err := img.writeUint64(uint64(blockOffset), int64(img.header.refcountTableOffset)+tableIndex*8); err != nil {
+			return fmt.Errorf("error updating refcount table: %w", err)
+		}
+		if err := img.addRefcount(blockOffset, 1); err != nil {
+			return err
+		}
+	}
+	block, err := img.refcountBlock(blockOffset)
+	if err != nil {
+		return err
+	}
+	current := img.getRefcountEntry(block, blockIndex)
+	next := int64(current) + int64(delta)
+	if next < 0 || uint64(next) > 1<<img.refcountBits-1 {
+		return fmt.Errorf("refcount of cluster at %d out of range: %d%+d", hostOffset, current, delta)
+	}
+	start, end := img.setRefcountEntry(block, blockIndex, uint64(next))
+	return img.writeFull(block[start:end], blockOffset+start)
+}
+
+// growRefcountTable moves the refcount table to the end of the image, with room for at least the given entries
+func (img *Image) growRefcountTable(entries int64) error {
+	oldOffset := int64(img.header.refcountTableOffset)
+	oldClusters := int64(img.header.refcountTableClusters)
+	// leave room for the refcount blocks covering the new table itself
+	clusters := max((entries*8+img.clusterSize-1)/img.clusterSize, oldClusters) * 2
+	newOffset := img.nextFree
+	img.nextFree += clusters * img.clusterSize
+
+	table := make([]uint64, clusters*img.clusterSize/8)
+	copy(table, img.refcountTable)
+	b := make([]byte, len(table)*8)
+	for i, e := range table {
+		binary.BigEndian.PutUint64(b[i*8:], e)
+	}
+	if err := img.writeFull(b, newOffset); err != nil {
+		return fmt.Errorf("error writing refcount table at %d: %w", newOffset, err)
+	}
+	img.refcountTable = table
+	img.header.refcountTableOffset = uint64(newOffset)
+	img.header.refcountTableClusters = uint32(clusters)
+
+	hdr := make([]byte, 12)
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(newOffset))
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(clusters))
+	if err := img.writeFull(hdr, refcountTableOffsetField); err != nil {
+		return fmt.Errorf("error updating header: %w", err)
+	}
+
+	for i := int64(0); i < clusters; i++ {
+		if err := img.addRefcount(newOffset+i*img.clusterSize, 1); err != nil {
+			return err
+		}
+	}
+	for i := int64(0); i < oldClusters; i++ {
+		if err := img.addRefcount(oldOffset+i*img.clusterSize, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns the file info of the image, but with the size of the virtual disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the virtual disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.readAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the virtual disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the image file, and the external data file if any
+func (img *Image) Sync() error {
+	if img.hasDataFile() {
+		if err := backend.Sync(img.data); err != nil {
+			return err
+		}
+	}
+	return backend.Sync(img.storage)
+}
+
+// Close closes the underlying storage, as well as the backing file and external data file, if any
+func (img *Image) Close() error {
+	var errs []error
+	if img.backing != nil {
+		errs = append(errs, img.backing.Close())
+	}
+	if img.hasDataFile() {
+		errs = append(errs, img.data.Close())
+	}
+	errs = append(errs, img.storage.Close())
+	return errors.Join(errs...)
+}
+
+// Sys is not suitable for qcow2 images, as ioctls on the image file do not apply to the virtual disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the virtual disk instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,223 @@
+package qcow2
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// checkRefcounts verifies that the refcount of every host cluster matches the number of references
+// to it from the header, the L1/L2 tables and the refcount structures, like `qemu-img check`
+func checkRefcounts(t *testing.T, img *Image) {
+	t.Helper()
+	expected := map[int64]uint64{}
+	ref := func(offset int64) {
+		expected[offset&^(img.clusterSize-1)]++
+	}
+	ref(0)
+	for i := int64(0); i < int64(img.header.refcountTableClusters); i++ {
+		ref(int64(img.header.refcountTableOffset) + i*img.clusterSize)
+	}
+	for _, e := range img.refcountTable {
+		if e&offsetMask != 0 {
+			ref(int64(e & offsetMask))
+		}
+	}
+	// the current L1 table and those of the snapshots each hold a reference to the L2 tables and
+	// data clusters they map, even where they share them
+	refL1 := func(l1Offset int64, l1Table []uint64) {
+		l1Bytes := int64(len(l1Table)) * 8
+		for off := int64(0); off < l1Bytes; off += img.clusterSize {
+			ref(l1Offset + off)
+		}
+		for _, l1e := range l1Table {
+			l2Offset := int64(l1e & offsetMask)
+			if l2Offset == 0 {
+				continue
+			}
+			ref(l2Offset)
+			l2, err := img.l2Table(l2Offset)
+			if err != nil {
+				t.Fatalf("error reading L2 table: %v", err)
+			}
+			for _, e := range l2 {
+				switch {
+				case e&entryCompressed != 0:
+					offset, size := img.compressedLocation(e)
+					for c := offset &^ (img.clusterSize - 1); c < offset+size; c += img.clusterSize {
+						ref(c)
+					}
+				case e&offsetMask != 0:
+					ref(int64(e & offsetMask))
+				}
+			}
+		}
+	}
+	refL1(int64(img.header.l1TableOffset), img.l1Table)
+	snapshotsEnd := int64(img.header.snapshotsOffset)
+	for _, s := range img.snapshots {
+		l1, err := img.readTable(int64(s.l1TableOffset), int64(s.l1Size))
+		if err != nil {
+			t.Fatalf("error reading L1 table of snapshot: %v", err)
+		}
+		refL1(int64(s.l1TableOffset), l1)
+		snapshotsEnd += (snapshotHeaderSize + 16 + int64(len(s.ID)+len(s.Name)) + 7) &^ 7
+	}
+	for off := int64(img.header.snapshotsOffset); off < snapshotsEnd; off += img.clusterSize {
+		ref(off)
+	}
+	for c := int64(0); c < img.nextFree; c += img.clusterSize {
+		actual, err := img.getRefcount(c)
+		if err != nil {
+			t.Fatalf("error reading refcount: %v", err)
+		}
+		if actual != expected[c] {
+			t.Errorf("cluster at %d has refcount %d, expected %d", c, actual, expected[c])
+		}
+	}
+}
+
+func TestRefcountEntries(t *testing.T) {
+	for order := uint32(0); order <= 6; order++ {
+		img := &Image{clusterSize: 512, refcountBits: 1 << order}
+		block := make([]byte, 512)
+		entries := int64(512 * 8 / img.refcountBits)
+		maxValue := uint64(1)<<img.refcountBits - 1
+		for i := int64(0); i < entries; i++ {
+			img.setRefcountEntry(block, i, uint64(i)&maxValue)
+		}
+		for i := int64(0); i < entries; i++ {
+			if v := img.getRefcountEntry(block, i); v != uint64(i)&maxValue {
+				t.Errorf("order %d entry %d: got %d, expected %d", order, i, v, uint64(i)&maxValue)
+			}
+		}
+	}
+}
+
+func TestAllocationConsistency(t *testing.T) {
+	// small clusters force many refcount blocks and growing the refcount table
+	img, err := CreateFromPath(filepath.Join(t.TempDir(), "test.qcow2"), 64*1024*1024, WithClusterSize(512))
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	defer img.Close()
+	checkRefcounts(t, img)
+
+	data := make([]byte, 10000)
+	_, _ = rand.Read(data)
+	for _, off := range []int64{0, 511, 1024 * 1024, 32*1024*1024 + 7, 64*1024*1024 - int64(len(data))} {
+		if _, err := img.WriteAt(data, off); err != nil {
+			t.Fatalf("error writing at %d: %v", off, err)
+		}
+	}
+	// a single cluster refcount table with 512 byte clusters and 16 bit refcounts covers 8MB
+	large := make([]byte, 9*1024*1024)
+	_, _ = rand.Read(large)
+	if _, err := img.WriteAt(large, 40*1024*1024); err != nil {
+		t.Fatalf("error writing large block: %v", err)
+	}
+	b := make([]byte, len(large))
+	if _, err := img.ReadAt(b, 40*1024*1024); err != nil {
+		t.Fatalf("error reading large block: %v", err)
+	}
+	if !bytes.Equal(b, large) {
+		t.Errorf("mismatched large block")
+	}
+	if img.header.refcountTableClusters == 1 {
+		t.Errorf("expected refcount table to have grown")
+	}
+	checkRefcounts(t, img)
+}
+
+func TestReadCompressed(t *testing.T) {
+	img, err := CreateFromPath(filepath.Join(t.TempDir(), "test.qcow2"), 1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	defer img.Close()
+
+	// compress one cluster of data, and place it at the end of the image by hand
+	cluster := bytes.Repeat([]byte("compressed qcow2 cluster "), int(img.clusterSize)/25+1)[:img.clusterSize]
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(cluster)
+	_ = w.Close()
+	// compressed data does not need to be cluster aligned
+	dataOffset := img.nextFree + 100
+	img.nextFree += img.clusterSize
+	if err := img.writeFull(buf.Bytes(), dataOffset); err != nil {
+		t.Fatalf("error writing compressed data: %v", err)
+	}
+	if err := img.addRefcount(dataOffset, 1); err != nil {
+		t.Fatalf("error setting refcount: %v", err)
+	}
+	x := 62 - (img.header.clusterBits - 8)
+	sectors := (dataOffset%compressedSectorSize+int64(buf.Len())+compressedSectorSize-1)/compressedSectorSize - 1
+	entry := entryCompressed | uint64(sectors)<<x | uint64(dataOffset)
+
+	l2Offset, l2, err := img.writableL2(0)
+	if err != nil {
+		t.Fatalf("error allocating L2 table: %v", err)
+	}
+	l2[1] = entry
+	if err := img.writeUint64(entry, l2Offset+8); err != nil {
+		t.Fatalf("error writing L2 entry: %v", err)
+	}
+	checkRefcounts(t, img)
+
+	b := make([]byte, img.clusterSize)
+	if _, err := img.ReadAt(b, img.clusterSize); err != nil {
+		t.Fatalf("error reading compressed cluster: %v", err)
+	}
+	if !bytes.Equal(b, cluster) {
+		t.Errorf("mismatched decompressed cluster")
+	}
+
+	// rewriting part of a compressed cluster must decompress it into a regular cluster
+	if _, err := img.WriteAt([]byte("overwritten"), img.clusterSize+10); err != nil {
+		t.Fatalf("error overwriting compressed cluster: %v", err)
+	}
+	copy(cluster[10:], "overwritten")
+	if _, err := img.ReadAt(b, img.clusterSize); err != nil {
+		t.Fatalf("error reading cluster: %v", err)
+	}
+	if !bytes.Equal(b, cluster) {
+		t.Errorf("mismatched cluster after overwriting compressed cluster")
+	}
+	if l2[1]&entryCompressed != 0 {
+		t.Errorf("cluster is still compressed after write")
+	}
+	checkRefcounts(t, img)
+}
+
+func TestOversizedL1Table(t *testing.T) {
+	pathName := filepath.Join(t.TempDir(), "test.qcow2")
+	img, err := CreateFromPath(pathName, 1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// claim far more L1 entries than the virtual size needs
+	f, err := os.OpenFile(pathName, os.O_RDWR, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 36); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	img, err = OpenFromPath(pathName, true)
+	if err != nil {
+		t.Fatalf("unexpected error opening image: %v", err)
+	}
+	defer img.Close()
+	if needed := img.l1SizeFor(img.header.size); uint64(len(img.l1Table)) != needed {
+		t.Errorf("read %d L1 entries instead of the %d the virtual size needs", len(img.l1Table), needed)
+	}
+}
@@ -0,0 +1,197 @@
+package qcow2_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/qcow2"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+func TestCreate(t *testing.T) {
+	t.Run("invalid cluster size", func(t *testing.T) {
+		_, err := qcow2.CreateFromPath(filepath.Join(t.TempDir(), "test.qcow2"), 1024*1024, qcow2.WithClusterSize(1000))
+		if err == nil {
+			t.Errorf("expected error for invalid cluster size")
+		}
+	})
+	t.Run("existing file", func(t *testing.T) {
+		p := filepath.Join(t.TempDir(), "test.qcow2")
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := qcow2.CreateFromPath(p, 1024*1024); err == nil {
+			t.Errorf("expected error for existing file")
+		}
+	})
+	t.Run("valid", func(t *testing.T) {
+		img, err := qcow2.CreateFromPath(filepath.Join(t.TempDir(), "test.qcow2"), 10*1024*1024, qcow2.WithClusterSize(4096))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer img.Close()
+		if img.Size() != 10*1024*1024 {
+			t.Errorf("size %d instead of %d", img.Size(), 10*1024*1024)
+		}
+		if img.ClusterSize() != 4096 {
+			t.Errorf("cluster size %d instead of %d", img.ClusterSize(), 4096)
+		}
+		if img.Version() != 3 {
+			t.Errorf("version %d instead of 3", img.Version())
+		}
+		info, err := img.Stat()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.Size() != img.Size() {
+			t.Errorf("stat size %d instead of %d", info.Size(), img.Size())
+		}
+	})
+}
+
+func TestReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.qcow2")
+	size := int64(16 * 1024 * 1024)
+	img, err := qcow2.CreateFromPath(p, size, qcow2.WithClusterSize(4096))
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+
+	expected := make([]byte, size)
+	writes := []struct {
+		offset int64
+		length int
+	}{
+		{0, 512},
+		{4000, 200},             // crosses a cluster boundary
+		{8192, 4096},            // exactly one cluster
+		{1024 * 1024, 100000},   // many clusters
+		{1024*1024 + 50, 10},    // rewrite of an allocated cluster
+		{size - 1000, 1000},     // end of the disk
+		{8*1024*1024 + 1, 8191}, // unaligned start and end
+	}
+	for _, w := range writes {
+		b := make([]byte, w.length)
+		_, _ = rand.Read(b)
+		n, err := img.WriteAt(b, w.offset)
+		if err != nil {
+			t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+		}
+		if n != w.length {
+			t.Fatalf("wrote %d bytes instead of %d", n, w.length)
+		}
+		copy(expected[w.offset:], b)
+	}
+
+	if _, err := img.WriteAt([]byte{1}, size); err == nil {
+		t.Errorf("expected error writing beyond end of disk")
+	}
+
+	check := func(img *qcow2.Image) {
+		t.Helper()
+		b := make([]byte, size)
+		n, err := img.ReadAt(b, 0)
+		if err != nil {
+			t.Fatalf("error reading image: %v", err)
+		}
+		if n != len(b) {
+			t.Fatalf("read %d bytes instead of %d", n, len(b))
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched image contents")
+		}
+		if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+			t.Errorf("expected io.EOF reading past the end, got %v", err)
+		}
+	}
+	check(img)
+	if err := img.Close(); err != nil {
+		t.Fatalf("error closing image: %v", err)
+	}
+
+	img, err = qcow2.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	defer img.Close()
+	check(img)
+	if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+		t.Errorf("expected error writing to read-only image")
+	}
+
+	// the image should only have allocated what was written, plus metadata
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= size/4 {
+		t.Errorf("image file is %d bytes, expected it to be sparse", info.Size())
+	}
+}
+
+func TestDisk(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.qcow2")
+	img, err := qcow2.CreateFromPath(p, 20*1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(img, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 40000, Type: gpt.LinuxFilesystem, Name: "data"},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "QCOW"})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from inside a qcow2 image")
+	f, err := fs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	img, err = qcow2.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(img)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("file contents %q instead of %q", b, content)
+	}
+}
@@ -0,0 +1,176 @@
+package qcow2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+const (
+	snapshotHeaderSize = 40
+	// maxSnapshots is the limit of qemu on the number of snapshots of an image
+	maxSnapshots = 65536
+)
+
+// Snapshot is an internal snapshot of a qcow2 image, as created by `qemu-img snapshot -c` or by
+// savevm in the QEMU monitor
+type Snapshot struct {
+	// ID is the unique ID of the snapshot, usually a number assigned by qemu
+	ID string
+	// Name is the name of the snapshot
+	Name string
+	// Date is when the snapshot was taken
+	Date time.Time
+	// VMClock is the time the virtual machine had been running when the snapshot was taken
+	VMClock time.Duration
+	// VMStateSize is the size of the saved state of the virtual machine, 0 for disk only snapshots
+	VMStateSize uint64
+	// Size is the size of the virtual disk when the snapshot was taken
+	Size int64
+
+	l1TableOffset uint64
+	l1Size        uint32
+}
+
+// readSnapshots reads the snapshot table from the image, each entry of which is padded to 8 bytes
+func (img *Image) readSnapshots() ([]Snapshot, error) {
+	h := img.header
+	if h.nbSnapshots == 0 {
+		return nil, nil
+	}
+	if h.nbSnapshots > maxSnapshots {
+		return nil, fmt.Errorf("image has %d snapshots, more than the maximum %d", h.nbSnapshots, maxSnapshots)
+	}
+	snapshots := make([]Snapshot, 0, h.nbSnapshots)
+	offset := int64(h.snapshotsOffset)
+	b := make([]byte, snapshotHeaderSize)
+	for i := uint32(0); i < h.nbSnapshots; i++ {
+		if err := img.readFull(b, offset); err != nil {
+			return nil, fmt.Errorf("error reading snapshot %d: %w", i, err)
+		}
+		idSize := int64(binary.BigEndian.Uint16(b[12:14]))
+		nameSize := int64(binary.BigEndian.Uint16(b[14:16]))
+		extraSize := int64(binary.BigEndian.Uint32(b[36:40]))
+		if extraSize > 1024 {
+			return nil, fmt.Errorf("snapshot %d has %d bytes of extra data", i, extraSize)
+		}
+		s := Snapshot{
+			Date:          time.Unix(int64(binary.BigEndian.Uint32(b[16:20])), int64(binary.BigEndian.Uint32(b[20:24]))),
+			VMClock:       time.Duration(binary.BigEndian.Uint64(b[24:32])),
+			VMStateSize:   uint64(binary.BigEndian.Uint32(b[32:36])),
+			Size:          img.Size(),
+			l1TableOffset: binary.BigEndian.Uint64(b[0:8]),
+			l1Size:        binary.BigEndian.Uint32(b[8:12]),
+		}
+		rest := make([]byte, extraSize+idSize+nameSize)
+		if err := img.readFull(rest, offset+snapshotHeaderSize); err != nil {
+			return nil, fmt.Errorf("error reading snapshot %d: %w", i, err)
+		}
+		// the extra data of version 3 images holds the large VM state size and the disk size
+		extra := rest[:extraSize]
+		if len(extra) >= 8 {
+			s.VMStateSize = binary.BigEndian.Uint64(extra[0:8])
+		}
+		if len(extra) >= 16 {
+			s.Size = int64(binary.BigEndian.Uint64(extra[8:16]))
+		}
+		s.ID = string(rest[extraSize : extraSize+idSize])
+		s.Name = string(rest[extraSize+idSize:])
+		if s.l1TableOffset%uint64(img.clusterSize) != 0 {
+			return nil, fmt.Errorf("L1 table of snapshot %s is not aligned to a cluster", s.ID)
+		}
+		snapshots = append(snapshots, s)
+		offset += (snapshotHeaderSize + int64(len(rest)) + 7) &^ 7
+	}
+	return snapshots, nil
+}
+
+// Snapshots lists the internal snapshots of the image, in the order they are recorded
+func (img *Image) Snapshots() []Snapshot {
+	return append([]Snapshot(nil), img.snapshots...)
+}
+
+// Snapshot is the internal snapshot whose contents the image presents, if opened with WithSnapshot
+func (img *Image) Snapshot() *Snapshot {
+	return img.snapshot
+}
+
+// WithSnapshot opens the virtual disk as it was when the internal snapshot was taken, instead of its
+// current contents. The snapshot is found by its ID or name, and the image must be opened read-only.
+func WithSnapshot(idOrName string) OpenOpt {
+	return func(o *openOpts) error {
+		if idOrName == "" {
+			return errors.New("must pass snapshot ID or name")
+		}
+		o.snapshot = idOrName
+		return nil
+	}
+}
+
+// findSnapshot finds a snapshot by ID, or failing that by name, as qemu-img does
+func (img *Image) findSnapshot(idOrName string) (*Snapshot, error) {
+	for i := range img.snapshots {
+		if img.snapshots[i].ID == idOrName {
+			return &img.snapshots[i], nil
+		}
+	}
+	var found *Snapshot
+	for i := range img.snapshots {
+		if img.snapshots[i].Name == idOrName {
+			if found != nil {
+				return nil, fmt.Errorf("more than one snapshot is named %q, use the ID", idOrName)
+			}
+			found = &img.snapshots[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("image has no snapshot with ID or name %q", idOrName)
+	}
+	return found, nil
+}
+
+// useSnapshot presents the contents of the snapshot instead of the current virtual disk
+func (img *Image) useSnapshot(idOrName string) error {
+	if !img.readOnly {
+		return errors.New("snapshots can only be opened read-only")
+	}
+	s, err := img.findSnapshot(idOrName)
+	if err != nil {
+		return err
+	}
+	l1, err := img.readTable(int64(s.l1TableOffset), int64(min(uint64(s.l1Size), img.l1SizeFor(uint64(s.Size)))))
+	if err != nil {
+		return fmt.Errorf("error reading L1 table of snapshot %s: %w", s.ID, err)
+	}
+	// the header is kept, but with the size and L1 table of the snapshot
+	h := *img.header
+	h.size = uint64(s.Size)
+	h.l1TableOffset = s.l1TableOffset
+	h.l1Size = s.l1Size
+	img.header = &h
+	img.l1Table = l1
+	img.snapshot = s
+	return nil
+}
+
+// OpenSnapshotFromPath opens an existing qcow2 image file read-only, as it was when the internal
+// snapshot with the given ID or name was taken
+func OpenSnapshotFromPath(pathName, idOrName string) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := openRelated(file.New(f, true), pathName, true, 0, WithSnapshot(idOrName))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open snapshot %s of qcow2 image %s: %w", idOrName, pathName, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,210 @@
+package qcow2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createSnapshot takes an internal snapshot of the current virtual disk the way qemu does: the L1 table
+// is copied, every L2 table and data cluster it references gains a reference, and the copied flags are
+// cleared so that later writes copy them first
+func createSnapshot(t *testing.T, img *Image, id, name string) {
+	t.Helper()
+	// replacing an existing snapshot table would also have to release its clusters
+	if len(img.snapshots) > 0 {
+		t.Fatalf("createSnapshot only supports the first snapshot")
+	}
+	l1Bytes := int64(len(img.l1Table)) * 8
+	l1Offset := img.nextFree
+	for off := int64(0); off < l1Bytes; off += img.clusterSize {
+		if _, err := img.allocCluster(); err != nil {
+			t.Fatalf("error allocating snapshot L1 table: %v", err)
+		}
+	}
+	for i, l1e := range img.l1Table {
+		l2Offset := int64(l1e & offsetMask)
+		if l2Offset == 0 {
+			continue
+		}
+		if err := img.addRefcount(l2Offset, 1); err != nil {
+			t.Fatalf("error referencing L2 table: %v", err)
+		}
+		l2, err := img.l2Table(l2Offset)
+		if err != nil {
+			t.Fatalf("error reading L2 table: %v", err)
+		}
+		for j, e := range l2 {
+			switch {
+			case e&entryCompressed != 0:
+				offset, size := img.compressedLocation(e)
+				for c := offset &^ (img.clusterSize - 1); c < offset+size; c += img.clusterSize {
+					if err := img.addRefcount(c, 1); err != nil {
+						t.Fatalf("error referencing compressed cluster: %v", err)
+					}
+				}
+			case e&offsetMask != 0:
+				if err := img.addRefcount(int64(e&offsetMask), 1); err != nil {
+					t.Fatalf("error referencing data cluster: %v", err)
+				}
+			}
+			l2[j] = e &^ entryCopied
+			if err := img.writeUint64(l2[j], l2Offset+int64(j)*8); err != nil {
+				t.Fatalf("error writing L2 entry: %v", err)
+			}
+		}
+		img.l1Table[i] = l1e &^ entryCopied
+	}
+	l1 := make([]byte, l1Bytes)
+	for i, e := range img.l1Table {
+		binary.BigEndian.PutUint64(l1[i*8:], e)
+	}
+	if err := img.writeFull(l1, l1Offset); err != nil {
+		t.Fatalf("error writing snapshot L1 table: %v", err)
+	}
+	if err := img.writeFull(l1, int64(img.header.l1TableOffset)); err != nil {
+		t.Fatalf("error writing L1 table: %v", err)
+	}
+
+	// the snapshot table, with the extra data of version 3
+	var table []byte
+	snapshots := []Snapshot{{
+		ID:            id,
+		Name:          name,
+		Date:          time.Unix(1700000000, 0),
+		VMClock:       3 * time.Second,
+		Size:          img.Size(),
+		l1TableOffset: uint64(l1Offset),
+		l1Size:        uint32(len(img.l1Table)),
+	}}
+	for _, s := range snapshots {
+		e := make([]byte, snapshotHeaderSize+16)
+		binary.BigEndian.PutUint64(e[0:], s.l1TableOffset)
+		binary.BigEndian.PutUint32(e[8:], s.l1Size)
+		binary.BigEndian.PutUint16(e[12:], uint16(len(s.ID)))
+		binary.BigEndian.PutUint16(e[14:], uint16(len(s.Name)))
+		binary.BigEndian.PutUint32(e[16:], uint32(s.Date.Unix()))
+		binary.BigEndian.PutUint64(e[24:], uint64(s.VMClock))
+		binary.BigEndian.PutUint32(e[36:], 16)
+		binary.BigEndian.PutUint64(e[snapshotHeaderSize+8:], uint64(s.Size))
+		e = append(append(e, s.ID...), s.Name...)
+		for len(e)%8 != 0 {
+			e = append(e, 0)
+		}
+		table = append(table, e...)
+	}
+	tableOffset := img.nextFree
+	for off := 0; off < len(table); off += int(img.clusterSize) {
+		if _, err := img.allocCluster(); err != nil {
+			t.Fatalf("error allocating snapshot table: %v", err)
+		}
+	}
+	if err := img.writeFull(table, tableOffset); err != nil {
+		t.Fatalf("error writing snapshot table: %v", err)
+	}
+	h := make([]byte, 12)
+	binary.BigEndian.PutUint32(h[0:], uint32(len(snapshots)))
+	binary.BigEndian.PutUint64(h[4:], uint64(tableOffset))
+	if err := img.writeFull(h, 60); err != nil {
+		t.Fatalf("error writing header: %v", err)
+	}
+	img.header.nbSnapshots = uint32(len(snapshots))
+	img.header.snapshotsOffset = uint64(tableOffset)
+	img.snapshots = snapshots
+}
+
+func TestSnapshot(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.qcow2")
+	size := int64(4 * 1024 * 1024)
+	img, err := CreateFromPath(p, size)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	before := make([]byte, 3*img.clusterSize)
+	_, _ = rand.Read(before)
+	if _, err := img.WriteAt(before, 1000); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	createSnapshot(t, img, "1", "base")
+	checkRefcounts(t, img)
+
+	// overwrite part of the snapshotted data, and write data where the snapshot had none
+	after := bytes.Repeat([]byte("after the snapshot"), 1000)
+	if _, err := img.WriteAt(after, 2000); err != nil {
+		t.Fatalf("error writing after snapshot: %v", err)
+	}
+	checkRefcounts(t, img)
+	current := make([]byte, size)
+	copy(current[1000:], before)
+	copy(current[2000:], after)
+	if err := img.Close(); err != nil {
+		t.Fatalf("error closing image: %v", err)
+	}
+
+	img, err = OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	snapshots := img.Snapshots()
+	if len(snapshots) != 1 {
+		t.Fatalf("%d snapshots instead of 1", len(snapshots))
+	}
+	s := snapshots[0]
+	if s.ID != "1" || s.Name != "base" || s.Size != size || !s.Date.Equal(time.Unix(1700000000, 0)) || s.VMClock != 3*time.Second {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+	if img.Snapshot() != nil {
+		t.Errorf("image presents snapshot without WithSnapshot")
+	}
+	b := make([]byte, size)
+	if _, err := img.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	if !bytes.Equal(b, current) {
+		t.Errorf("current contents do not match")
+	}
+
+	expected := make([]byte, size)
+	copy(expected[1000:], before)
+	snap, err := OpenSnapshotFromPath(p, "base")
+	if err != nil {
+		t.Fatalf("error opening snapshot: %v", err)
+	}
+	defer snap.Close()
+	if snap.Snapshot() == nil || snap.Snapshot().ID != "1" {
+		t.Errorf("image does not present snapshot 1")
+	}
+	if _, err := snap.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading snapshot: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("snapshot contents do not match")
+	}
+
+	byID, err := New(img.storage, true, WithSnapshot("1"))
+	if err != nil {
+		t.Fatalf("error opening snapshot by ID: %v", err)
+	}
+	if _, err := byID.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading snapshot: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("snapshot contents by ID do not match")
+	}
+
+	if _, err := New(img.storage, true, WithSnapshot("missing")); err == nil {
+		t.Errorf("no error opening missing snapshot")
+	}
+	rw, err := openRaw(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	if _, err := New(rw, false, WithSnapshot("base")); err == nil {
+		t.Errorf("no error opening snapshot read-write")
+	}
+}
@@ -0,0 +1,186 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReadWriterAt is implemented by types that can be read and written at offsets, such as *os.File
+// or a mmap'ed buffer
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// readerAtStorage is a Storage of a fixed size over an io.ReaderAt, and an io.WriterAt if
+// writable
+type readerAtStorage struct {
+	r    io.ReaderAt
+	w    io.WriterAt
+	size int64
+	// mu guards offset
+	mu     sync.Mutex
+	offset int64
+}
+
+// FromReaderAt returns a read-only Storage of size bytes read from r, so that any type satisfying
+// io.ReaderAt can be used as a backend. Reads beyond size return io.EOF. Close closes r if it
+// implements io.Closer.
+func FromReaderAt(r io.ReaderAt, size int64) Storage {
+	return &readerAtStorage{r: r, size: size}
+}
+
+// FromReadWriterAt returns a Storage of size bytes read from and written to rw. Writes cannot go
+// beyond size. Close closes rw if it implements io.Closer.
+func FromReadWriterAt(rw ReadWriterAt, size int64) Storage {
+	return &readerAtStorage{r: rw, w: rw, size: size}
+}
+
+// Section returns the Storage of size bytes at offset in b, e.g. a partition of a disk, writable
+// if b is. Closing it leaves b open.
+func Section(b Storage, offset, size int64) Storage {
+	s := &section{storage: b, offset: offset}
+	if w, err := b.Writable(); err == nil {
+		s.w = w
+		return FromReadWriterAt(s, size)
+	}
+	return FromReaderAt(s, size)
+}
+
+// section is a range of a storage
+type section struct {
+	storage Storage
+	w       WritableFile
+	offset  int64
+}
+
+func (s *section) ReadAt(p []byte, off int64) (int, error) {
+	return s.storage.ReadAt(p, s.offset+off)
+}
+
+func (s *section) WriteAt(p []byte, off int64) (int, error) {
+	return s.w.WriteAt(p, s.offset+off)
+}
+
+func (s *section) Sync() error {
+	return Sync(s.storage)
+}
+
+// ReadAt reads from the reader, returning io.EOF for reads that reach the size
+func (s *readerAtStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > s.size {
+		p = p[:s.size-off]
+		eof = io.EOF
+	}
+	n, err := s.r.ReadAt(p, off)
+	if err == nil {
+		err = eof
+	}
+	return n, err
+}
+
+// WriteAt writes to the writer, within the size
+func (s *readerAtStorage) WriteAt(p []byte, off int64) (int, error) {
+	if s.w == nil {
+		return 0, ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > s.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the storage of size %d", len(p), off, s.size)
+	}
+	return s.w.WriteAt(p, off)
+}
+
+// Read reads at the current offset
+func (s *readerAtStorage) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+	n, err := s.ReadAt(b, offset)
+	s.mu.Lock()
+	s.offset = offset + int64(n)
+	s.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read
+func (s *readerAtStorage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return s.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return s.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+// Stat describes the storage, with its size
+func (s *readerAtStorage) Stat() (fs.FileInfo, error) {
+	return &readerAtInfo{size: s.size, readOnly: s.w == nil}, nil
+}
+
+// Close closes the reader if it is an io.Closer
+func (s *readerAtStorage) Close() error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Sync syncs the writer if it is a Syncer
+func (s *readerAtStorage) Sync() error {
+	if w, ok := s.w.(Syncer); ok {
+		return w.Sync()
+	}
+	return nil
+}
+
+// Sys is not suitable, as the reader is not necessarily a file
+func (s *readerAtStorage) Sys() (*os.File, error) {
+	return nil, ErrNotSuitable
+}
+
+// Writable returns the storage itself, unless it is read-only
+func (s *readerAtStorage) Writable() (WritableFile, error) {
+	if s.w == nil {
+		return nil, ErrIncorrectOpenMode
+	}
+	return s, nil
+}
+
+// readerAtInfo describes a storage over a reader
+type readerAtInfo struct {
+	size     int64
+	readOnly bool
+}
+
+func (i *readerAtInfo) Name() string { return "readerat" }
+func (i *readerAtInfo) Size() int64  { return i.size }
+func (i *readerAtInfo) Mode() fs.FileMode {
+	if i.readOnly {
+		return 0o444
+	}
+	return 0o644
+}
+func (i *readerAtInfo) ModTime() time.Time { return time.Time{} }
+func (i *readerAtInfo) IsDir() bool        { return false }
+func (i *readerAtInfo) Sys() any           { return nil }
@@ -0,0 +1,153 @@
+package backend_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// writerAt is a minimal io.ReaderAt and io.WriterAt over a buffer
+type writerAt struct {
+	b []byte
+}
+
+func (w *writerAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(w.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, w.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(w.b[off:], p), nil
+}
+
+func TestFromReaderAt(t *testing.T) {
+	s := backend.FromReaderAt(strings.NewReader("0123456789"), 8)
+	info, err := s.Stat()
+	if err != nil {
+		t.Fatalf("error stat: %v", err)
+	}
+	if info.Size() != 8 {
+		t.Errorf("size %d instead of 8", info.Size())
+	}
+	b := make([]byte, 4)
+	if n, err := s.ReadAt(b, 6); n != 2 || !errors.Is(err, io.EOF) {
+		t.Errorf("read %d bytes with error %v at the end instead of 2 and io.EOF", n, err)
+	}
+	if _, err := s.Seek(2, io.SeekStart); err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	all, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if string(all) != "234567" {
+		t.Errorf("read %q instead of %q", all, "234567")
+	}
+	if _, err := s.Writable(); !errors.Is(err, backend.ErrIncorrectOpenMode) {
+		t.Errorf("error %v getting writable of read-only storage instead of %v", err, backend.ErrIncorrectOpenMode)
+	}
+}
+
+func TestFromReadWriterAt(t *testing.T) {
+	buf := &writerAt{b: make([]byte, 10*1024*1024)}
+	s := backend.FromReadWriterAt(buf, int64(len(buf.b)))
+	w, err := s.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	if _, err := w.WriteAt([]byte("abc"), int64(len(buf.b))-2); err == nil {
+		t.Errorf("no error writing beyond the size")
+	}
+
+	d, err := diskfs.OpenBackend(s, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 18000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("contents of a file")
+	f, err := fs.OpenFile("/file.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// the same image can be opened as a file
+	p := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, buf.b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err = diskfs.Open(p, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/file.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
+
+func TestSection(t *testing.T) {
+	buf := &writerAt{b: []byte("0123456789")}
+	s := backend.Section(backend.FromReadWriterAt(buf, 10), 3, 4)
+	b := make([]byte, 10)
+	if n, err := s.ReadAt(b, 0); n != 4 || !errors.Is(err, io.EOF) || string(b[:n]) != "3456" {
+		t.Errorf("read %q with error %v instead of 3456 and io.EOF", b[:n], err)
+	}
+	w, err := s.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	if _, err := w.WriteAt([]byte("ab"), 1); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if _, err := w.WriteAt([]byte("cd"), 3); err == nil {
+		t.Errorf("no error writing beyond the section")
+	}
+	if string(buf.b) != "0123ab6789" {
+		t.Errorf("storage %q instead of 0123ab6789", buf.b)
+	}
+	if _, err := backend.Section(backend.FromReaderAt(buf, 10), 0, 5).Writable(); err == nil {
+		t.Errorf("no error getting writable of a section of read-only storage")
+	}
+}
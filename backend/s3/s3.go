@@ -0,0 +1,149 @@
+// Package s3 reads and writes disk images stored as objects in S3 compatible object storage,
+// including Google Cloud Storage through its XML API with HMAC keys, without going through a
+// local file.
+//
+// Images are read with ranged GET requests through the httprange backend, so only the parts that
+// are read are fetched. Finished images are written with a multipart upload by an io.Writer, so
+// that image streams, e.g. from Disk.WriteImage, can be uploaded as they are produced. Requests are
+// signed with AWS Signature Version 4.
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/httprange"
+)
+
+// GCSEndpoint is the endpoint of the XML API of Google Cloud Storage, used with HMAC keys and
+// region "auto"
+const GCSEndpoint = "https://storage.googleapis.com"
+
+// Config locates an object, and holds the credentials to access it
+type Config struct {
+	// Endpoint is the URL of the service, https://s3.<Region>.amazonaws.com if not set
+	Endpoint string
+	// Region is the region of the bucket, us-east-1 if not set
+	Region string
+	// Bucket is the name of the bucket
+	Bucket string
+	// Key is the key of the object
+	Key string
+	// AccessKeyID and SecretAccessKey are the credentials, or the HMAC key for GCS
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is the token of temporary credentials, if any
+	SessionToken string
+	// PathStyle addresses the bucket in the path of the URL instead of the host name, as needed by
+	// most S3 compatible services other than AWS
+	PathStyle bool
+	// Client is the HTTP client to use, http.DefaultClient if not set. Its transport is wrapped to
+	// sign the requests.
+	Client *http.Client
+}
+
+// object is a validated Config, with the URL of the object and a client that signs requests
+type object struct {
+	url    *url.URL
+	client *http.Client
+}
+
+func (c *Config) object() (*object, error) {
+	if c.Bucket == "" {
+		return nil, errors.New("must pass bucket")
+	}
+	if c.Key == "" {
+		return nil, errors.New("must pass object key")
+	}
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported endpoint scheme %q", u.Scheme)
+	}
+	key := strings.TrimPrefix(c.Key, "/")
+	base := strings.TrimSuffix(u.Path, "/")
+	if c.PathStyle {
+		u.Path = base + "/" + c.Bucket + "/" + key
+		u.RawPath = uriEncode(base, false) + "/" + uriEncode(c.Bucket, true) + "/" + uriEncode(key, false)
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = base + "/" + key
+		u.RawPath = uriEncode(base, false) + "/" + uriEncode(key, false)
+	}
+
+	client := http.DefaultClient
+	if c.Client != nil {
+		client = c.Client
+	}
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	signed := *client
+	signed.Transport = &signer{
+		accessKeyID:     c.AccessKeyID,
+		secretAccessKey: c.SecretAccessKey,
+		sessionToken:    c.SessionToken,
+		region:          region,
+		next:            next,
+		now:             time.Now,
+	}
+	return &object{url: u, client: &signed}, nil
+}
+
+// urlWithQuery is the URL of the object with the given query
+func (o *object) urlWithQuery(query url.Values) string {
+	u := *o.url
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Open opens the image in the object read-only. The options configure the chunks fetched and
+// cached, as for httprange.New.
+func Open(cfg *Config, opts ...httprange.Opt) (*httprange.Image, error) {
+	o, err := cfg.object()
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]httprange.Opt{httprange.WithClient(o.client)}, opts...)
+	img, err := httprange.New(o.url.String(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not open object %s in bucket %s: %w", cfg.Key, cfg.Bucket, err)
+	}
+	return img, nil
+}
+
+// responseError is the error returned by the service in the body of a response
+type responseError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// checkResponse returns an error for an unsuccessful response, with the error from its body if any
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e responseError
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("%s: %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return fmt.Errorf("unexpected response %s", resp.Status)
+}
@@ -0,0 +1,204 @@
+package s3_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/httprange"
+	"github.com/diskfs/go-diskfs/backend/s3"
+)
+
+// fakeS3 implements the object and multipart upload requests of S3 in memory, for path style URLs
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	next    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>unsigned request</Message></Error>")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.URL.Path
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet:
+		b, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(b))
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.next++
+		id := strconv.Itoa(f.next)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>b</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		parts[n] = b
+		sum := md5.Sum(b)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !sort.SliceIsSorted(complete.Parts, func(i, j int) bool { return complete.Parts[i].PartNumber < complete.Parts[j].PartNumber }) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var b []byte
+		for i, p := range complete.Parts {
+			if i < len(complete.Parts)-1 && len(parts[p.PartNumber]) < int(s3.MinPartSize) {
+				fmt.Fprint(w, "<Error><Code>EntityTooSmall</Code><Message>part too small</Message></Error>")
+				return
+			}
+			b = append(b, parts[p.PartNumber]...)
+		}
+		f.objects[key] = b
+		delete(f.uploads, q.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult><Key>disk.img</Key></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	return f, ts
+}
+
+func TestUploadAndOpen(t *testing.T) {
+	f, ts := newFakeS3(t)
+	cfg := &s3.Config{
+		Endpoint:        ts.URL,
+		Bucket:          "b",
+		Key:             "images/disk.img",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+	}
+	content := make([]byte, 2*s3.MinPartSize+12345)
+	_, _ = rand.Read(content)
+
+	w, err := s3.Create(cfg, s3.MinPartSize)
+	if err != nil {
+		t.Fatalf("error starting upload: %v", err)
+	}
+	// write in odd sizes, so that writes span parts
+	for off := 0; off < len(content); off += 1000003 {
+		if _, err := w.Write(content[off:min(len(content), off+1000003)]); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+	}
+	if _, ok := f.objects["/b/images/disk.img"]; ok {
+		t.Errorf("object exists before the upload is complete")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error completing upload: %v", err)
+	}
+	if !bytes.Equal(f.objects["/b/images/disk.img"], content) {
+		t.Fatalf("uploaded object does not match")
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Errorf("no error writing after close")
+	}
+
+	img, err := s3.Open(cfg, httprange.WithChunkSize(1024*1024))
+	if err != nil {
+		t.Fatalf("error opening object: %v", err)
+	}
+	defer img.Close()
+	if img.Size() != int64(len(content)) {
+		t.Fatalf("size %d instead of %d", img.Size(), len(content))
+	}
+	b := make([]byte, 3*1024*1024)
+	if _, err := img.ReadAt(b, 4*1024*1024+17); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content[4*1024*1024+17:][:len(b)]) {
+		t.Errorf("contents do not match")
+	}
+}
+
+func TestAbort(t *testing.T) {
+	f, ts := newFakeS3(t)
+	cfg := &s3.Config{Endpoint: ts.URL, Bucket: "b", Key: "disk.img", AccessKeyID: "AKID", SecretAccessKey: "secret", PathStyle: true}
+	w, err := s3.Create(cfg, 0)
+	if err != nil {
+		t.Fatalf("error starting upload: %v", err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("error aborting: %v", err)
+	}
+	if len(f.uploads) != 0 {
+		t.Errorf("upload remains after abort")
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("no error completing aborted upload")
+	}
+	if _, ok := f.objects["/b/disk.img"]; ok {
+		t.Errorf("object created by aborted upload")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, ts := newFakeS3(t)
+	if _, err := s3.Open(&s3.Config{Endpoint: ts.URL, Bucket: "b", Key: "missing.img", AccessKeyID: "AKID", PathStyle: true}); err == nil {
+		t.Errorf("no error opening missing object")
+	}
+	if _, err := s3.Create(&s3.Config{Endpoint: ts.URL, Bucket: "b", Key: "disk.img", AccessKeyID: "other", PathStyle: true}, 0); err == nil {
+		t.Errorf("no error starting upload with wrong credentials")
+	}
+	if _, err := s3.Create(&s3.Config{Endpoint: ts.URL, Bucket: "b", Key: "disk.img"}, 1024); err == nil {
+		t.Errorf("no error with part size smaller than minimum")
+	}
+	if _, err := s3.Open(&s3.Config{Bucket: "b"}); err == nil {
+		t.Errorf("no error without key")
+	}
+}
@@ -0,0 +1,196 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// DefaultPartSize is the size of the parts of a multipart upload
+	DefaultPartSize int64 = 16 * 1024 * 1024
+	// MinPartSize is the smallest part allowed by S3, other than the last one
+	MinPartSize int64 = 5 * 1024 * 1024
+	// maxParts is the most parts allowed in a multipart upload
+	maxParts = 10000
+)
+
+// Writer uploads an image to an object with a multipart upload, as it is written. The object is
+// only created when the Writer is closed; until then, Abort discards the upload.
+type Writer struct {
+	object   *object
+	uploadID string
+	partSize int64
+	buf      []byte
+	parts    []completedPart
+	// err is the first error, after which the upload is aborted and all calls fail
+	err error
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// Create starts a multipart upload to the object, in parts of partSize bytes, DefaultPartSize if 0.
+// As an upload has at most 10000 parts, the part size limits the size of the image.
+func Create(cfg *Config, partSize int64) (*Writer, error) {
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < MinPartSize {
+		return nil, fmt.Errorf("part size %d is smaller than the minimum %d", partSize, MinPartSize)
+	}
+	o, err := cfg.object()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, o.urlWithQuery(url.Values{"uploads": {""}}), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not start upload of object %s: %w", cfg.Key, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, fmt.Errorf("could not start upload of object %s: %w", cfg.Key, err)
+	}
+	var result initiateMultipartUploadResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return nil, fmt.Errorf("invalid response starting upload of object %s: %v", cfg.Key, err)
+	}
+	return &Writer{
+		object:   o,
+		uploadID: result.UploadID,
+		partSize: partSize,
+		buf:      make([]byte, 0, partSize),
+	}, nil
+}
+
+// Write buffers the data, uploading each part as it is filled
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	written := 0
+	for written < len(p) {
+		n := min(len(p)-written, int(w.partSize)-len(w.buf))
+		w.buf = append(w.buf, p[written:written+n]...)
+		written += n
+		if int64(len(w.buf)) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart uploads the buffered data as the next part
+func (w *Writer) uploadPart() error {
+	number := len(w.parts) + 1
+	if number > maxParts {
+		return w.fail(fmt.Errorf("image is larger than %d parts of %d bytes", maxParts, w.partSize))
+	}
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+	req, err := http.NewRequest(http.MethodPut, w.object.urlWithQuery(query), bytes.NewReader(w.buf))
+	if err != nil {
+		return w.fail(err)
+	}
+	resp, err := w.object.client.Do(req)
+	if err != nil {
+		return w.fail(fmt.Errorf("could not upload part %d: %w", number, err))
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return w.fail(fmt.Errorf("could not upload part %d: %w", number, err))
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return w.fail(fmt.Errorf("no ETag in response to upload of part %d", number))
+	}
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: etag})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close uploads the last part and completes the upload, which creates the object
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 || len(w.parts) == 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(completeMultipartUpload{Parts: w.parts})
+	if err != nil {
+		return w.fail(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, w.object.urlWithQuery(url.Values{"uploadId": {w.uploadID}}), bytes.NewReader(body))
+	if err != nil {
+		return w.fail(err)
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := w.object.client.Do(req)
+	if err != nil {
+		return w.fail(fmt.Errorf("could not complete upload: %w", err))
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return w.fail(fmt.Errorf("could not complete upload: %w", err))
+	}
+	// completing may fail after the response has started, with the error in the body
+	result, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e responseError
+	if xml.Unmarshal(result, &e) == nil && e.Code != "" {
+		return w.fail(fmt.Errorf("could not complete upload: %s: %s", e.Code, e.Message))
+	}
+	w.err = errors.New("upload is complete")
+	return nil
+}
+
+// Abort discards the upload and the parts uploaded so far
+func (w *Writer) Abort() error {
+	req, err := http.NewRequest(http.MethodDelete, w.object.urlWithQuery(url.Values{"uploadId": {w.uploadID}}), http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := w.object.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not abort upload: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("could not abort upload: %w", err)
+	}
+	if w.err == nil {
+		w.err = errors.New("upload is aborted")
+	}
+	return nil
+}
+
+// fail aborts the upload after an error, which is returned by all later calls
+func (w *Writer) fail(err error) error {
+	w.err = err
+	_ = w.Abort()
+	return err
+}
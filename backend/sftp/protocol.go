@@ -0,0 +1,189 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// constants of version 3 of the SFTP protocol, see
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02, and the extensions of
+// OpenSSH, see https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+
+const (
+	protocolVersion = 3
+
+	fxpInit          byte = 1
+	fxpVersion       byte = 2
+	fxpOpen          byte = 3
+	fxpClose         byte = 4
+	fxpRead          byte = 5
+	fxpWrite         byte = 6
+	fxpFstat         byte = 8
+	fxpStatus        byte = 101
+	fxpHandle        byte = 102
+	fxpData          byte = 103
+	fxpAttrs         byte = 105
+	fxpExtended      byte = 200
+	fxpExtendedReply byte = 201
+
+	flagRead  uint32 = 0x01
+	flagWrite uint32 = 0x02
+
+	attrSize        uint32 = 0x01
+	attrUIDGID      uint32 = 0x02
+	attrPermissions uint32 = 0x04
+	attrACModTime   uint32 = 0x08
+	attrExtended    uint32 = 0x80000000
+
+	statusOK               uint32 = 0
+	statusEOF              uint32 = 1
+	statusNoSuchFile       uint32 = 2
+	statusPermissionDenied uint32 = 3
+	statusFailure          uint32 = 4
+	statusBadMessage       uint32 = 5
+	statusNoConnection     uint32 = 6
+	statusConnectionLost   uint32 = 7
+	statusOpUnsupported    uint32 = 8
+
+	extensionFsync = "fsync@openssh.com"
+
+	// chunk is the size of reads and writes, which all servers must accept
+	chunk = 32 * 1024
+	// maxInFlight is the number of reads or writes sent before waiting for their replies
+	maxInFlight = 64
+	// maxPacket is the size of the largest packet accepted from the server
+	maxPacket = 256 * 1024
+)
+
+// StatusError is an error returned by the server for a request
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+var statusNames = map[uint32]string{
+	statusEOF:              "end of file",
+	statusNoSuchFile:       "no such file",
+	statusPermissionDenied: "permission denied",
+	statusFailure:          "failure",
+	statusBadMessage:       "bad message",
+	statusNoConnection:     "no connection",
+	statusConnectionLost:   "connection lost",
+	statusOpUnsupported:    "operation unsupported",
+}
+
+func (e *StatusError) Error() string {
+	name, ok := statusNames[e.Code]
+	if !ok {
+		name = fmt.Sprintf("status %d", e.Code)
+	}
+	if e.Message == "" {
+		return "server error " + name
+	}
+	return fmt.Sprintf("server error %s: %s", name, e.Message)
+}
+
+// Is matches fs.ErrNotExist, fs.ErrPermission and errors.ErrUnsupported to the status
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == statusNoSuchFile
+	case fs.ErrPermission:
+		return e.Code == statusPermissionDenied
+	case errors.ErrUnsupported:
+		return e.Code == statusOpUnsupported
+	}
+	return false
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads the fields of a packet, remembering if it was too short
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uint32() uint32 {
+	if len(d.b) < 4 {
+		d.err = errors.New("packet too short")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	if len(d.b) < 8 {
+		d.err = errors.New("packet too short")
+		return 0
+	}
+	v := binary.BigEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	if d.err != nil || uint32(len(d.b)) < n {
+		d.err = errors.New("packet too short")
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// attrs are the attributes of a file
+type attrs struct {
+	flags       uint32
+	size        uint64
+	permissions uint32
+	mtime       uint32
+}
+
+func (d *decoder) attrs() attrs {
+	var a attrs
+	a.flags = d.uint32()
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.permissions = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		d.uint32()
+		a.mtime = d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
+
+// statusError returns the error of a status reply, nil for statusOK
+func statusError(data []byte) error {
+	d := &decoder{b: data}
+	code := d.uint32()
+	message := d.string()
+	if d.err != nil {
+		return fmt.Errorf("invalid status reply: %w", d.err)
+	}
+	if code == statusOK {
+		return nil
+	}
+	return &StatusError{Code: code, Message: message}
+}
@@ -0,0 +1,629 @@
+// Package sftp provides a backend for image files on remote hosts, read and written with ranged
+// requests over SFTP, so that partitions and filesystems of images stored on build servers can be
+// worked on without copying them.
+//
+// The client speaks version 3 of the SFTP protocol, as OpenSSH and most other servers do, over the
+// connection to an sftp subsystem. Dial and OpenURL run the ssh command of OpenSSH, so that the
+// hosts, users, keys and agent of the ssh configuration apply. New takes a connection set up
+// otherwise, e.g. the stdin and stdout of a session of golang.org/x/crypto/ssh after
+// RequestSubsystem("sftp").
+//
+// Reads and writes are split into requests of 32KB, which all servers accept, with up to 64 of
+// them sent before waiting for the replies, so that the latency of the connection is paid once per
+// 2MB rather than for every request. Sync uses the fsync@openssh.com extension if the server has it.
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// File is an open file on an SFTP server
+type File struct {
+	conn     io.ReadWriteCloser
+	path     string
+	readOnly bool
+	handle   string
+	fsync    bool
+	// mu guards the connection, nextID, received, err and size, so that the requests of one
+	// operation are sent at a time
+	mu       sync.Mutex
+	nextID   uint32
+	received map[uint32]reply
+	// err is set when the connection can no longer be used
+	err  error
+	size int64
+	// offsetMu guards the offset for Read and Seek
+	offsetMu sync.Mutex
+	offset   int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*File)(nil)
+
+// reply is a packet from the server, without its id
+type reply struct {
+	typ  byte
+	data []byte
+}
+
+type opts struct {
+	command string
+	args    []string
+}
+
+// Opt func that process Dial and OpenURL options
+type Opt func(o *opts) error
+
+// WithSSHCommand runs the command instead of ssh, which is passed the arguments of ssh to run the
+// sftp subsystem on the host
+func WithSSHCommand(command string) Opt {
+	return func(o *opts) error {
+		if command == "" {
+			return errors.New("must pass SSH command")
+		}
+		o.command = command
+		return nil
+	}
+}
+
+// WithSSHArgs passes arguments to ssh before those that select the host and subsystem, e.g.
+// "-p", "2222", "-i", "/path/to/key" or "-o", "BatchMode=yes"
+func WithSSHArgs(args ...string) Opt {
+	return func(o *opts) error {
+		o.args = append(o.args, args...)
+		return nil
+	}
+}
+
+// Dial runs ssh to the host, "host" or "user@host", and opens the file at the path on it, which
+// must exist. Relative paths are relative to the home directory of the user.
+func Dial(host, pathName string, readOnly bool, options ...Opt) (*File, error) {
+	o := &opts{command: "ssh"}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if host == "" || strings.HasPrefix(host, "-") {
+		return nil, fmt.Errorf("invalid host %q", host)
+	}
+	conn, err := startCommand(o.command, append(o.args, "-s", host, "sftp")...)
+	if err != nil {
+		return nil, fmt.Errorf("could not run %s: %w", o.command, err)
+	}
+	f, err := New(conn, pathName, readOnly)
+	if err != nil {
+		closeErr := conn.Close()
+		return nil, fmt.Errorf("could not open %s on %s: %w", pathName, host, errors.Join(err, closeErr))
+	}
+	return f, nil
+}
+
+// OpenURL opens the file of an SFTP URL, sftp://[user@]host[:port]/path, whose path is absolute,
+// or relative to the home directory of the user if it starts with /~/
+func OpenURL(rawURL string, readOnly bool, options ...Opt) (*File, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	if u.Scheme != "sftp" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	var urlOptions []Opt
+	if u.Port() != "" {
+		urlOptions = append(urlOptions, WithSSHArgs("-p", u.Port()))
+	}
+	pathName := u.Path
+	if strings.HasPrefix(pathName, "/~/") {
+		pathName = pathName[len("/~/"):]
+	}
+	return Dial(host, pathName, readOnly, append(urlOptions, options...)...)
+}
+
+// New opens the file at the path over a connection to an sftp subsystem, which is closed with the
+// File. Relative paths are relative to the directory the server starts in, usually the home
+// directory of the user.
+func New(conn io.ReadWriteCloser, pathName string, readOnly bool) (*File, error) {
+	f := &File{
+		conn:     conn,
+		path:     pathName,
+		readOnly: readOnly,
+		received: map[uint32]reply{},
+	}
+	if err := f.init(); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// init negotiates the version and extensions of the protocol
+func (f *File) init() error {
+	b := []byte{0, 0, 0, 5, fxpInit}
+	b = binary.BigEndian.AppendUint32(b, protocolVersion)
+	if _, err := f.conn.Write(b); err != nil {
+		return fmt.Errorf("error sending init: %w", err)
+	}
+	typ, data, err := f.readPacket()
+	if err != nil {
+		return fmt.Errorf("error reading version: %w", err)
+	}
+	if typ != fxpVersion {
+		return fmt.Errorf("reply %d to init instead of version", typ)
+	}
+	d := &decoder{b: data}
+	if version := d.uint32(); version != protocolVersion {
+		return fmt.Errorf("unsupported SFTP version %d", version)
+	}
+	for len(d.b) > 0 && d.err == nil {
+		name, value := d.string(), d.string()
+		if name == extensionFsync && value == "1" {
+			f.fsync = true
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid version reply: %w", d.err)
+	}
+	return nil
+}
+
+// open opens the file and reads its size
+func (f *File) open() error {
+	flags := flagRead
+	if !f.readOnly {
+		flags |= flagWrite
+	}
+	payload := appendString(nil, f.path)
+	payload = binary.BigEndian.AppendUint32(payload, flags)
+	// no attributes
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	f.mu.Lock()
+	r, err := f.call(fxpOpen, payload)
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	switch r.typ {
+	case fxpHandle:
+		d := &decoder{b: r.data}
+		f.handle = d.string()
+		if d.err != nil {
+			return fmt.Errorf("invalid handle reply: %w", d.err)
+		}
+	case fxpStatus:
+		return statusError(r.data)
+	default:
+		return fmt.Errorf("reply %d to open instead of handle", r.typ)
+	}
+	if _, err := f.Stat(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readPacket reads the next packet from the server
+func (f *File) readPacket() (typ byte, data []byte, err error) {
+	var header [5]byte
+	if _, err := io.ReadFull(f.conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > maxPacket {
+		return 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	data = make([]byte, length-1)
+	if _, err := io.ReadFull(f.conn, data); err != nil {
+		return 0, nil, err
+	}
+	return header[4], data, nil
+}
+
+// send sends a request, returning its id. It must be called with mu held.
+func (f *File) send(typ byte, payload []byte) (uint32, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.nextID++
+	b := make([]byte, 9, 9+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(5+len(payload)))
+	b[4] = typ
+	binary.BigEndian.PutUint32(b[5:9], f.nextID)
+	if _, err := f.conn.Write(append(b, payload...)); err != nil {
+		f.err = fmt.Errorf("connection to SFTP server failed: %w", err)
+		return 0, f.err
+	}
+	return f.nextID, nil
+}
+
+// receive returns the reply to the request with the id; servers may reply out of order. It must
+// be called with mu held.
+func (f *File) receive(id uint32) (reply, error) {
+	for {
+		if r, ok := f.received[id]; ok {
+			delete(f.received, id)
+			return r, nil
+		}
+		if f.err != nil {
+			return reply{}, f.err
+		}
+		typ, data, err := f.readPacket()
+		if err != nil {
+			f.err = fmt.Errorf("connection to SFTP server failed: %w", err)
+			return reply{}, f.err
+		}
+		if len(data) < 4 {
+			f.err = fmt.Errorf("invalid reply of %d bytes", len(data))
+			return reply{}, f.err
+		}
+		f.received[binary.BigEndian.Uint32(data)] = reply{typ: typ, data: data[4:]}
+	}
+}
+
+// call sends a request and waits for its reply. It must be called with mu held.
+func (f *File) call(typ byte, payload []byte) (reply, error) {
+	id, err := f.send(typ, payload)
+	if err != nil {
+		return reply{}, err
+	}
+	return f.receive(id)
+}
+
+// status sends a request whose reply is a status. It must be called with mu held.
+func (f *File) status(typ byte, payload []byte) error {
+	r, err := f.call(typ, payload)
+	if err != nil {
+		return err
+	}
+	if r.typ != fxpStatus {
+		return fmt.Errorf("reply %d instead of status", r.typ)
+	}
+	return statusError(r.data)
+}
+
+// Path is the path of the file on the server
+func (f *File) Path() string {
+	return f.path
+}
+
+// Size is the size of the file, as known from opening, Stat and writes
+func (f *File) Size() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.size
+}
+
+// ReadAt reads from the file
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > f.size {
+		p = p[:f.size-off]
+		eof = io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		var ids []uint32
+		var starts []int
+		for pos := n; pos < len(p) && len(ids) < maxInFlight; pos += chunk {
+			payload := appendString(nil, f.handle)
+			payload = binary.BigEndian.AppendUint64(payload, uint64(off)+uint64(pos))
+			payload = binary.BigEndian.AppendUint32(payload, uint32(min(chunk, len(p)-pos)))
+			id, err := f.send(fxpRead, payload)
+			if err != nil {
+				return n, err
+			}
+			ids = append(ids, id)
+			starts = append(starts, pos)
+		}
+		// the data of a read may be shorter than requested, after which the replies to the reads
+		// that follow are discarded, and those ranges read again
+		short := false
+		var readErr error
+		for i, id := range ids {
+			r, err := f.receive(id)
+			if err != nil {
+				return n, err
+			}
+			if short || readErr != nil {
+				continue
+			}
+			switch r.typ {
+			case fxpData:
+				d := &decoder{b: r.data}
+				data := d.string()
+				length := min(chunk, len(p)-starts[i])
+				switch {
+				case d.err != nil:
+					readErr = fmt.Errorf("invalid data reply: %w", d.err)
+				case len(data) > length:
+					readErr = fmt.Errorf("%d bytes of data for read of %d bytes", len(data), length)
+				default:
+					n = starts[i] + copy(p[starts[i]:], data)
+					short = len(data) < length
+				}
+			case fxpStatus:
+				readErr = statusError(r.data)
+				if readErr == nil {
+					readErr = errors.New("status OK instead of data")
+				}
+			default:
+				readErr = fmt.Errorf("reply %d to read instead of data", r.typ)
+			}
+		}
+		var status *StatusError
+		if errors.As(readErr, &status) && status.Code == statusEOF {
+			// the file is shorter than when its size was read
+			return n, io.EOF
+		}
+		if readErr != nil {
+			return n, fmt.Errorf("error reading at %d: %w", off+int64(n), readErr)
+		}
+	}
+	return n, eof
+}
+
+// WriteAt writes to the file, growing it if written beyond its end
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		var ids []uint32
+		var ends []int
+		for pos := n; pos < len(p) && len(ids) < maxInFlight; pos += chunk {
+			end := min(pos+chunk, len(p))
+			payload := appendString(nil, f.handle)
+			payload = binary.BigEndian.AppendUint64(payload, uint64(off)+uint64(pos))
+			payload = appendString(payload, string(p[pos:end]))
+			id, err := f.send(fxpWrite, payload)
+			if err != nil {
+				return n, err
+			}
+			ids = append(ids, id)
+			ends = append(ends, end)
+		}
+		var writeErr error
+		for i, id := range ids {
+			r, err := f.receive(id)
+			if err != nil {
+				return n, err
+			}
+			if writeErr != nil {
+				continue
+			}
+			if r.typ != fxpStatus {
+				writeErr = fmt.Errorf("reply %d to write instead of status", r.typ)
+				continue
+			}
+			if writeErr = statusError(r.data); writeErr == nil {
+				n = ends[i]
+				f.size = max(f.size, off+int64(n))
+			}
+		}
+		if writeErr != nil {
+			return n, fmt.Errorf("error writing at %d: %w", off+int64(n), writeErr)
+		}
+	}
+	return n, nil
+}
+
+// Sync makes the server commit the writes to the file to stable storage, if it supports the
+// fsync@openssh.com extension
+func (f *File) Sync() error {
+	if f.readOnly || !f.fsync {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload := appendString(nil, extensionFsync)
+	payload = appendString(payload, f.handle)
+	if err := f.status(fxpExtended, payload); err != nil {
+		return fmt.Errorf("error syncing: %w", err)
+	}
+	return nil
+}
+
+// Stat describes the file, as the server reports it
+func (f *File) Stat() (fs.FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.call(fxpFstat, appendString(nil, f.handle))
+	if err != nil {
+		return nil, err
+	}
+	switch r.typ {
+	case fxpAttrs:
+	case fxpStatus:
+		if err := statusError(r.data); err != nil {
+			return nil, fmt.Errorf("could not stat %s: %w", f.path, err)
+		}
+		return nil, errors.New("status OK instead of attributes")
+	default:
+		return nil, fmt.Errorf("reply %d to stat instead of attributes", r.typ)
+	}
+	d := &decoder{b: r.data}
+	a := d.attrs()
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid attributes reply: %w", d.err)
+	}
+	if a.flags&attrSize == 0 {
+		return nil, fmt.Errorf("server did not report the size of %s", f.path)
+	}
+	f.size = int64(a.size)
+	info := &fileInfo{name: path.Base(f.path), size: f.size, mode: 0o644}
+	if a.flags&attrPermissions != 0 {
+		info.mode = fs.FileMode(a.permissions).Perm()
+	}
+	if a.flags&attrACModTime != 0 {
+		info.modTime = time.Unix(int64(a.mtime), 0)
+	}
+	return info, nil
+}
+
+// Read reads from the file at the current offset
+func (f *File) Read(b []byte) (int, error) {
+	f.offsetMu.Lock()
+	offset := f.offset
+	f.offsetMu.Unlock()
+	n, err := f.ReadAt(b, offset)
+	f.offsetMu.Lock()
+	f.offset = offset + int64(n)
+	f.offsetMu.Unlock()
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the file
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.offsetMu.Lock()
+	defer f.offsetMu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.Size()
+	default:
+		return f.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes the file on the server, and the connection
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	closeErr := f.status(fxpClose, appendString(nil, f.handle))
+	if f.err == nil {
+		f.err = errors.New("file is closed")
+	}
+	return errors.Join(closeErr, f.conn.Close())
+}
+
+// Sys is not suitable for remote files, which have no local file
+func (f *File) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the file, unless opened read-only
+func (f *File) Writable() (backend.WritableFile, error) {
+	if f.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return f, nil
+}
+
+// fileInfo describes a remote file
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return false }
+func (i *fileInfo) Sys() any           { return nil }
+
+// commandConn is the connection to the sftp subsystem over the stdin and stdout of ssh
+type commandConn struct {
+	io.Reader
+	stdin  io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *limitedBuffer
+}
+
+func startCommand(command string, args ...string) (*commandConn, error) {
+	cmd := exec.Command(command, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &limitedBuffer{max: 4096}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandConn{Reader: stdout, stdin: stdin, cmd: cmd, stderr: stderr}, nil
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close closes stdin, after which the server and ssh exit, and waits for ssh
+func (c *commandConn) Close() error {
+	_ = c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// limitedBuffer keeps the start of the output of ssh, for errors
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,341 @@
+package sftp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/sftp"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// serverEnv makes the test binary run as the SFTP server, for the ssh command run by Dial
+const serverEnv = "GO_DISKFS_SFTP_TEST_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(serverEnv) == "1" {
+		s := &server{maxRead: 16 * 1024}
+		s.serve(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// server is a minimal SFTP server of local files, which returns at most maxRead bytes per read
+type server struct {
+	maxRead int
+	fsyncs  atomic.Int32
+}
+
+func (s *server) serve(r io.Reader, w io.Writer) {
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:4])-1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		if header[4] == 1 {
+			reply := []byte{2, 0, 0, 0, 3}
+			reply = appendString(appendString(reply, "fsync@openssh.com"), "1")
+			writePacket(w, reply)
+			continue
+		}
+		id, data := data[:4], data[4:]
+		status := func(code uint32) {
+			reply := append([]byte{101}, id...)
+			reply = binary.BigEndian.AppendUint32(reply, code)
+			writePacket(w, appendString(appendString(reply, ""), ""))
+		}
+		str := func() string {
+			n := binary.BigEndian.Uint32(data)
+			v := string(data[4 : 4+n])
+			data = data[4+n:]
+			return v
+		}
+		switch header[4] {
+		case 3:
+			name := str()
+			flag := os.O_RDONLY
+			if binary.BigEndian.Uint32(data)&2 != 0 {
+				flag = os.O_RDWR
+			}
+			f, err := os.OpenFile(name, flag, 0)
+			if err != nil {
+				status(2)
+				continue
+			}
+			files[name] = f
+			writePacket(w, appendString(append([]byte{102}, id...), name))
+		case 4:
+			if f, ok := files[str()]; ok {
+				f.Close()
+			}
+			status(0)
+		case 5:
+			f := files[str()]
+			off := binary.BigEndian.Uint64(data)
+			length := min(int(binary.BigEndian.Uint32(data[8:])), s.maxRead)
+			b := make([]byte, length)
+			n, err := f.ReadAt(b, int64(off))
+			if n == 0 && errors.Is(err, io.EOF) {
+				status(1)
+				continue
+			}
+			writePacket(w, appendString(append([]byte{103}, id...), string(b[:n])))
+		case 6:
+			f := files[str()]
+			off := binary.BigEndian.Uint64(data)
+			data = data[8:]
+			if _, err := f.WriteAt([]byte(str()), int64(off)); err != nil {
+				status(4)
+				continue
+			}
+			status(0)
+		case 8:
+			info, err := files[str()].Stat()
+			if err != nil {
+				status(4)
+				continue
+			}
+			reply := append([]byte{105}, id...)
+			reply = binary.BigEndian.AppendUint32(reply, 0x01|0x04|0x08)
+			reply = binary.BigEndian.AppendUint64(reply, uint64(info.Size()))
+			reply = binary.BigEndian.AppendUint32(reply, uint32(info.Mode().Perm()))
+			reply = binary.BigEndian.AppendUint32(reply, uint32(info.ModTime().Unix()))
+			reply = binary.BigEndian.AppendUint32(reply, uint32(info.ModTime().Unix()))
+			writePacket(w, reply)
+		case 200:
+			if str() != "fsync@openssh.com" {
+				status(8)
+				continue
+			}
+			if err := files[str()].Sync(); err != nil {
+				status(4)
+				continue
+			}
+			s.fsyncs.Add(1)
+			status(0)
+		default:
+			status(8)
+		}
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func writePacket(w io.Writer, b []byte) {
+	_, _ = w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...))
+}
+
+// pipeConn is the client side of a connection to a server over pipes
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// connect starts a server, and returns a connection to it
+func connect(t *testing.T, s *server) io.ReadWriteCloser {
+	clientR, serverW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverR, clientW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serve(serverR, serverW)
+		serverW.Close()
+	}()
+	t.Cleanup(func() {
+		clientW.Close()
+		<-done
+		clientR.Close()
+		serverR.Close()
+	})
+	return &pipeConn{Reader: clientR, WriteCloser: clientW}
+}
+
+func TestFile(t *testing.T) {
+	content := make([]byte, 3*1024*1024+100)
+	_, _ = rand.Read(content)
+	p := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &server{maxRead: 10000}
+	f, err := sftp.New(connect(t, s), p, false)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error stat: %v", err)
+	}
+	if info.Size() != int64(len(content)) || info.Name() != "disk.img" || info.Mode() != 0o600 {
+		t.Errorf("stat returned %s of size %d with mode %v", info.Name(), info.Size(), info.Mode())
+	}
+
+	// reads of more than all requests in flight, with short reads from the server
+	b := make([]byte, 2*1024*1024+5000)
+	if _, err := f.ReadAt(b, 1234); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content[1234:1234+len(b)]) {
+		t.Errorf("contents do not match")
+	}
+	if n, err := f.ReadAt(b, int64(len(content))-10); n != 10 || !errors.Is(err, io.EOF) {
+		t.Errorf("read %d bytes with error %v at the end instead of 10 and io.EOF", n, err)
+	}
+
+	data := make([]byte, 2*1024*1024+3000)
+	_, _ = rand.Read(data)
+	off := int64(len(content)) - 1000
+	if n, err := f.WriteAt(data, off); n != len(data) || err != nil {
+		t.Fatalf("wrote %d bytes with error %v", n, err)
+	}
+	if f.Size() != off+int64(len(data)) {
+		t.Errorf("size %d after write beyond the end instead of %d", f.Size(), off+int64(len(data)))
+	}
+	end, err := f.Seek(-int64(len(data)), io.SeekEnd)
+	if err != nil || end != off {
+		t.Fatalf("seek to %d, %v instead of %d", end, err, off)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Errorf("contents read back do not match")
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatalf("error syncing: %v", err)
+	}
+	if s.fsyncs.Load() != 1 {
+		t.Errorf("%d fsyncs instead of 1", s.fsyncs.Load())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("error closing: %v", err)
+	}
+	local, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(local[off:], data) || !bytes.Equal(local[:off], content[:off]) {
+		t.Errorf("contents of the file do not match")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := &server{maxRead: 10000}
+	if _, err := sftp.New(connect(t, s), filepath.Join(t.TempDir(), "missing.img"), true); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error %v opening missing file instead of %v", err, fs.ErrNotExist)
+	}
+
+	p := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, make([]byte, 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := sftp.New(connect(t, s), p, true)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only file")
+	}
+	if _, err := f.WriteAt([]byte("abcd"), 0); err == nil {
+		t.Errorf("no error writing read-only file")
+	}
+
+	if _, err := sftp.OpenURL("http://host/disk.img", true); err == nil {
+		t.Errorf("no error opening URL with another scheme")
+	}
+	if _, err := sftp.Dial("-oProxyCommand=x", p, true); err == nil {
+		t.Errorf("no error dialing host that is an option")
+	}
+}
+
+func TestDial(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(p, make([]byte, 20*1024*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(serverEnv, "1")
+	f, err := sftp.OpenURL("sftp://user@buildserver:2222"+p, false, sftp.WithSSHCommand(os.Args[0]))
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	d, err := diskfs.OpenBackend(f, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 38000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("written over sftp")
+	file, err := fs.OpenFile("/remote.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := file.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	d, err = diskfs.Open(p, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	file, err = fs.OpenFile("/remote.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("file contents %q instead of %q", read, content)
+	}
+}
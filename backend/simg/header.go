@@ -0,0 +1,102 @@
+package simg
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	sparseMagic = 0xed26ff3a
+
+	majorVersion = 1
+	minorVersion = 0
+
+	fileHeaderSize  = 28
+	chunkHeaderSize = 12
+
+	chunkRaw      uint16 = 0xcac1
+	chunkFill     uint16 = 0xcac2
+	chunkDontCare uint16 = 0xcac3
+	chunkCRC32    uint16 = 0xcac4
+)
+
+// fileHeader is the header at the start of a sparse image
+type fileHeader struct {
+	majorVersion    uint16
+	minorVersion    uint16
+	fileHeaderSize  uint16
+	chunkHeaderSize uint16
+	blockSize       uint32
+	totalBlocks     uint32
+	totalChunks     uint32
+	checksum        uint32
+}
+
+func fileHeaderFromBytes(b []byte) (*fileHeader, error) {
+	if len(b) < fileHeaderSize {
+		return nil, fmt.Errorf("header was %d bytes instead of expected %d", len(b), fileHeaderSize)
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != sparseMagic {
+		return nil, fmt.Errorf("invalid Android sparse image magic %#x", magic)
+	}
+	h := &fileHeader{
+		majorVersion:    binary.LittleEndian.Uint16(b[4:6]),
+		minorVersion:    binary.LittleEndian.Uint16(b[6:8]),
+		fileHeaderSize:  binary.LittleEndian.Uint16(b[8:10]),
+		chunkHeaderSize: binary.LittleEndian.Uint16(b[10:12]),
+		blockSize:       binary.LittleEndian.Uint32(b[12:16]),
+		totalBlocks:     binary.LittleEndian.Uint32(b[16:20]),
+		totalChunks:     binary.LittleEndian.Uint32(b[20:24]),
+		checksum:        binary.LittleEndian.Uint32(b[24:28]),
+	}
+	if h.majorVersion != majorVersion {
+		return nil, fmt.Errorf("unsupported Android sparse image version %d.%d", h.majorVersion, h.minorVersion)
+	}
+	// later minor versions may have larger headers, which are skipped
+	if h.fileHeaderSize < fileHeaderSize || h.chunkHeaderSize < chunkHeaderSize {
+		return nil, fmt.Errorf("invalid header sizes %d and %d", h.fileHeaderSize, h.chunkHeaderSize)
+	}
+	if h.blockSize == 0 || h.blockSize%4 != 0 {
+		return nil, fmt.Errorf("invalid block size %d, must be a multiple of 4", h.blockSize)
+	}
+	return h, nil
+}
+
+func (h *fileHeader) toBytes() []byte {
+	b := make([]byte, fileHeaderSize)
+	binary.LittleEndian.PutUint32(b[0:4], sparseMagic)
+	binary.LittleEndian.PutUint16(b[4:6], h.majorVersion)
+	binary.LittleEndian.PutUint16(b[6:8], h.minorVersion)
+	binary.LittleEndian.PutUint16(b[8:10], h.fileHeaderSize)
+	binary.LittleEndian.PutUint16(b[10:12], h.chunkHeaderSize)
+	binary.LittleEndian.PutUint32(b[12:16], h.blockSize)
+	binary.LittleEndian.PutUint32(b[16:20], h.totalBlocks)
+	binary.LittleEndian.PutUint32(b[20:24], h.totalChunks)
+	binary.LittleEndian.PutUint32(b[24:28], h.checksum)
+	return b
+}
+
+// chunkHeader precedes the data of every chunk
+type chunkHeader struct {
+	chunkType uint16
+	// blocks is the number of blocks of the output image covered by the chunk
+	blocks uint32
+	// totalSize is the size of the chunk in the sparse image, including the header
+	totalSize uint32
+}
+
+func chunkHeaderFromBytes(b []byte) chunkHeader {
+	return chunkHeader{
+		chunkType: binary.LittleEndian.Uint16(b[0:2]),
+		blocks:    binary.LittleEndian.Uint32(b[4:8]),
+		totalSize: binary.LittleEndian.Uint32(b[8:12]),
+	}
+}
+
+func (c chunkHeader) toBytes() []byte {
+	b := make([]byte, chunkHeaderSize)
+	binary.LittleEndian.PutUint16(b[0:2], c.chunkType)
+	binary.LittleEndian.PutUint32(b[4:8], c.blocks)
+	binary.LittleEndian.PutUint32(b[8:12], c.totalSize)
+	return b
+}
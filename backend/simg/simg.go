@@ -0,0 +1,283 @@
+// Package simg provides a backend for Android sparse images, the format of the system.img style
+// artifacts flashed with fastboot.
+//
+// Sparse images are read through Image, which presents the expanded image, and are produced from any
+// raw image with Write. As the format is written sequentially, images cannot be modified in place.
+package simg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// chunk is a chunk of the sparse image, mapped into the expanded image
+type chunk struct {
+	chunkType uint16
+	// start is the first block of the expanded image covered by the chunk
+	start  uint64
+	blocks uint64
+	// offset is the position of the data of a raw chunk in the sparse image
+	offset int64
+	fill   [4]byte
+}
+
+// Image is an Android sparse image presented as a read-only backend.Storage of the size of the expanded image
+type Image struct {
+	storage backend.Storage
+	header  *fileHeader
+	chunks  []chunk
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the Android sparse image stored in the provided backend.Storage.
+// Sparse images can only be opened read-only, use Write to create them.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	if !readOnly {
+		return nil, errors.New("android sparse images can only be opened read-only")
+	}
+	img := &Image{
+		storage: b,
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing Android sparse image file, which can only be opened read-only
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	if !readOnly {
+		return nil, errors.New("android sparse images can only be opened read-only")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, true), true)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open Android sparse image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+// IsSparse reports whether the storage holds an Android sparse image
+func IsSparse(b io.ReaderAt) bool {
+	magic := make([]byte, 4)
+	if _, err := b.ReadAt(magic, 0); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(magic) == sparseMagic
+}
+
+func (img *Image) init() error {
+	b := make([]byte, fileHeaderSize)
+	if err := img.readFull(b, 0); err != nil {
+		return fmt.Errorf("error reading Android sparse image header: %w", err)
+	}
+	h, err := fileHeaderFromBytes(b)
+	if err != nil {
+		return err
+	}
+	img.header = h
+
+	offset := int64(h.fileHeaderSize)
+	var block uint64
+	ch := make([]byte, chunkHeaderSize)
+	for i := uint32(0); i < h.totalChunks; i++ {
+		if err := img.readFull(ch, offset); err != nil {
+			return fmt.Errorf("error reading header of chunk %d: %w", i, err)
+		}
+		c := chunkHeaderFromBytes(ch)
+		dataSize := int64(c.totalSize) - int64(h.chunkHeaderSize)
+		entry := chunk{
+			chunkType: c.chunkType,
+			start:     block,
+			blocks:    uint64(c.blocks),
+			offset:    offset + int64(h.chunkHeaderSize),
+		}
+		switch c.chunkType {
+		case chunkRaw:
+			if dataSize != int64(c.blocks)*int64(h.blockSize) {
+				return fmt.Errorf("raw chunk %d has %d bytes of data for %d blocks", i, dataSize, c.blocks)
+			}
+		case chunkFill:
+			if dataSize != 4 {
+				return fmt.Errorf("fill chunk %d has %d bytes of data instead of 4", i, dataSize)
+			}
+			if err := img.readFull(entry.fill[:], entry.offset); err != nil {
+				return fmt.Errorf("error reading fill value of chunk %d: %w", i, err)
+			}
+		case chunkDontCare:
+			if dataSize != 0 {
+				return fmt.Errorf("don't care chunk %d has %d bytes of data", i, dataSize)
+			}
+		case chunkCRC32:
+			// the checksum of the data so far, which is not verified
+			if dataSize != 4 || c.blocks != 0 {
+				return fmt.Errorf("invalid CRC32 chunk %d", i)
+			}
+		default:
+			return fmt.Errorf("unknown type %#x of chunk %d", c.chunkType, i)
+		}
+		if c.blocks > 0 {
+			img.chunks = append(img.chunks, entry)
+		}
+		block += uint64(c.blocks)
+		offset += int64(c.totalSize)
+	}
+	if block != uint64(h.totalBlocks) {
+		return fmt.Errorf("chunks cover %d blocks instead of the %d of the image", block, h.totalBlocks)
+	}
+	// the data of raw chunks is only read when needed, so check that it is all there
+	if info, err := img.storage.Stat(); err == nil && info != nil && info.Size() < offset {
+		return fmt.Errorf("image is truncated, it is %d bytes instead of %d", info.Size(), offset)
+	}
+	return nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, failing on a truncated image
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Size is the size of the expanded image
+func (img *Image) Size() int64 {
+	return int64(img.header.totalBlocks) * int64(img.header.blockSize)
+}
+
+// BlockSize is the size of the blocks in which the image is divided into chunks
+func (img *Image) BlockSize() int64 {
+	return int64(img.header.blockSize)
+}
+
+// ReadAt reads from the expanded image; regions in don't care chunks read as zero
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	blockSize := img.BlockSize()
+	// the first chunk containing the offset
+	i := sort.Search(len(img.chunks), func(i int) bool {
+		return int64(img.chunks[i].start+img.chunks[i].blocks)*blockSize > off
+	})
+	read := 0
+	for read < len(p) {
+		c := img.chunks[i]
+		inChunk := off + int64(read) - int64(c.start)*blockSize
+		n := int(min(int64(len(p)-read), int64(c.blocks)*blockSize-inChunk))
+		b := p[read : read+n]
+		switch c.chunkType {
+		case chunkRaw:
+			if err := img.readFull(b, c.offset+inChunk); err != nil {
+				return read, fmt.Errorf("error reading raw chunk: %w", err)
+			}
+		case chunkFill:
+			// fill chunks start on a block boundary, which is a multiple of the 4 byte value
+			for j := range b {
+				b[j] = c.fill[(inChunk+int64(j))%4]
+			}
+		default:
+			clear(b)
+		}
+		read += n
+		i++
+	}
+	return read, eof
+}
+
+// Stat returns the file info of the image, but with the size of the expanded image
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the expanded image at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.ReadAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the expanded image
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for sparse images, as ioctls on the image file do not apply to the expanded image
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable is not possible for sparse images, which are always read-only
+func (img *Image) Writable() (backend.WritableFile, error) {
+	return nil, backend.ErrIncorrectOpenMode
+}
+
+// imageInfo reports the size of the expanded image instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,113 @@
+package simg
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/testhelper"
+)
+
+// sparseImage builds a sparse image with a block size of 8 out of the chunk headers and their data
+func sparseImage(totalBlocks uint32, chunks ...[]byte) []byte {
+	h := &fileHeader{
+		majorVersion:    majorVersion,
+		fileHeaderSize:  fileHeaderSize,
+		chunkHeaderSize: chunkHeaderSize,
+		blockSize:       8,
+		totalBlocks:     totalBlocks,
+		totalChunks:     uint32(len(chunks)),
+	}
+	b := h.toBytes()
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func testChunk(chunkType uint16, blocks uint32, data []byte) []byte {
+	c := chunkHeader{chunkType: chunkType, blocks: blocks, totalSize: chunkHeaderSize + uint32(len(data))}
+	return append(c.toBytes(), data...)
+}
+
+func openBytes(t *testing.T, b []byte) (*Image, error) {
+	t.Helper()
+	f := &testhelper.FileImpl{
+		Reader: func(p []byte, off int64) (int, error) {
+			if off >= int64(len(b)) {
+				return 0, io.EOF
+			}
+			n := copy(p, b[off:])
+			if n < len(p) {
+				return n, io.EOF
+			}
+			return n, nil
+		},
+	}
+	return New(file.New(f, true), true)
+}
+
+func TestChunkTypes(t *testing.T) {
+	raw := []byte("0123456789abcdef")
+	b := sparseImage(6,
+		testChunk(chunkRaw, 2, raw),
+		testChunk(chunkCRC32, 0, []byte{1, 2, 3, 4}),
+		testChunk(chunkFill, 2, []byte{0xaa, 0xbb, 0xcc, 0xdd}),
+		testChunk(chunkDontCare, 2, nil),
+	)
+	img, err := openBytes(t, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := make([]byte, img.Size())
+	if _, err := img.ReadAt(data, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := append(append([]byte{}, raw...), bytes.Repeat([]byte{0xaa, 0xbb, 0xcc, 0xdd}, 4)...)
+	expected = append(expected, make([]byte, 16)...)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("contents % x instead of % x", data, expected)
+	}
+}
+
+func TestInvalidImages(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"too few blocks", sparseImage(3, testChunk(chunkDontCare, 2, nil))},
+		{"raw size mismatch", sparseImage(2, testChunk(chunkRaw, 2, make([]byte, 8)))},
+		{"fill size mismatch", sparseImage(2, testChunk(chunkFill, 2, make([]byte, 8)))},
+		{"unknown chunk", sparseImage(2, testChunk(0x1234, 2, nil))},
+		{"truncated", sparseImage(4, testChunk(chunkRaw, 2, make([]byte, 16)), testChunk(chunkDontCare, 2, nil))[:60]},
+		{"bad magic", make([]byte, 64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openBytes(t, tt.b); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestFillValue(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		fill [4]byte
+		ok   bool
+	}{
+		{make([]byte, 4096), [4]byte{}, true},
+		{bytes.Repeat([]byte{1, 2, 3, 4}, 1024), [4]byte{1, 2, 3, 4}, true},
+		{bytes.Repeat([]byte{1, 2, 3, 4}, 3), [4]byte{1, 2, 3, 4}, true},
+		{append(bytes.Repeat([]byte{1, 2, 3, 4}, 1023), 1, 2, 3, 5), [4]byte{1, 2, 3, 4}, false},
+		{append(make([]byte, 12), 1, 0, 0, 0), [4]byte{}, false},
+	}
+	for i, tt := range tests {
+		fill, ok := fillValue(tt.b)
+		if ok != tt.ok || (ok && fill != tt.fill) {
+			t.Errorf("%d: fill value %v %v instead of %v %v", i, fill, ok, tt.fill, tt.ok)
+		}
+	}
+}
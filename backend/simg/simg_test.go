@@ -0,0 +1,138 @@
+package simg_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/simg"
+)
+
+// testImage returns a raw image with random data, zeroes, and blocks repeating a value
+func testImage(size int64) []byte {
+	b := make([]byte, size)
+	_, _ = rand.Read(b[:3*4096+100])
+	_, _ = rand.Read(b[64*1024 : 200*1024])
+	for i := 300 * 1024; i < 400*1024; i += 4 {
+		copy(b[i:], []byte{0xde, 0xad, 0xbe, 0xef})
+	}
+	for i := 400 * 1024; i < 404*1024; i++ {
+		b[i] = 0xff
+	}
+	_, _ = rand.Read(b[size-512:])
+	return b
+}
+
+func TestWriteRead(t *testing.T) {
+	size := int64(1024 * 1024)
+	raw := testImage(size)
+	for _, tt := range []struct {
+		name string
+		opts []simg.WriteOpt
+	}{
+		{"default", nil},
+		{"large blocks", []simg.WriteOpt{simg.WithBlockSize(64 * 1024)}},
+		{"don't care", []simg.WriteOpt{simg.WithDontCare()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := simg.Write(&buf, bytes.NewReader(raw), size, tt.opts...); err != nil {
+				t.Fatalf("error writing sparse image: %v", err)
+			}
+			if int64(buf.Len()) >= size {
+				t.Errorf("sparse image is %d bytes, no smaller than the raw image", buf.Len())
+			}
+			if !simg.IsSparse(bytes.NewReader(buf.Bytes())) {
+				t.Errorf("written image not detected as sparse")
+			}
+			p := filepath.Join(t.TempDir(), "system.img")
+			if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			img, err := simg.OpenFromPath(p, true)
+			if err != nil {
+				t.Fatalf("error opening sparse image: %v", err)
+			}
+			defer img.Close()
+			if img.Size() != size {
+				t.Errorf("size %d instead of %d", img.Size(), size)
+			}
+			b, err := io.ReadAll(img)
+			if err != nil {
+				t.Fatalf("error reading sparse image: %v", err)
+			}
+			if !bytes.Equal(b, raw) {
+				t.Errorf("mismatched image contents")
+			}
+			// unaligned reads crossing chunks
+			for _, r := range [][2]int64{{1000, 5000}, {299*1024 + 3, 2 * 1024}, {403 * 1024, 8 * 1024}} {
+				b := make([]byte, r[1])
+				if _, err := img.ReadAt(b, r[0]); err != nil {
+					t.Fatalf("error reading %d bytes at %d: %v", r[1], r[0], err)
+				}
+				if !bytes.Equal(b, raw[r[0]:r[0]+r[1]]) {
+					t.Errorf("mismatched contents reading %d bytes at %d", r[1], r[0])
+				}
+			}
+			if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+				t.Errorf("expected io.EOF reading past the end, got %v", err)
+			}
+			if _, err := img.Writable(); err == nil {
+				t.Errorf("expected error getting writable sparse image")
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	raw := make([]byte, 8192)
+	for _, tt := range []struct {
+		name string
+		size int64
+		opts []simg.WriteOpt
+	}{
+		{"unaligned size", 8000, nil},
+		{"no size", 0, nil},
+		{"invalid block size", 8192, []simg.WriteOpt{simg.WithBlockSize(1001)}},
+		{"short source", 16384, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := simg.Write(io.Discard, bytes.NewReader(raw), tt.size, tt.opts...); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+}
+
+func TestOpenTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	raw := testImage(1024 * 1024)
+	if err := simg.Write(&buf, bytes.NewReader(raw), int64(len(raw))); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "system.img")
+	if err := os.WriteFile(p, buf.Bytes()[:buf.Len()-1], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := simg.OpenFromPath(p, true); err == nil {
+		t.Errorf("expected error opening truncated sparse image")
+	}
+}
+
+func TestOpenReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "system.img")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := simg.Write(f, bytes.NewReader(make([]byte, 8192)), 8192); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := simg.OpenFromPath(p, false); err == nil {
+		t.Errorf("expected error opening sparse image read-write")
+	}
+}
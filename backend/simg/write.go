@@ -0,0 +1,171 @@
+package simg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DefaultBlockSize is the block size of new sparse images, as with img2simg
+const DefaultBlockSize = 4096
+
+type writeOpts struct {
+	blockSize uint32
+	dontCare  bool
+}
+
+// WriteOpt func that process Write options
+type WriteOpt func(o *writeOpts) error
+
+// WithBlockSize sets the block size of the sparse image, which must be a multiple of 4 and divide the
+// size of the image. Default is DefaultBlockSize.
+func WithBlockSize(size uint32) WriteOpt {
+	return func(o *writeOpts) error {
+		if size == 0 || size%4 != 0 {
+			return fmt.Errorf("invalid block size %d, must be a positive multiple of 4", size)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// WithDontCare stores blocks of zeroes as don't care chunks instead of fill chunks. fastboot does not
+// write don't care chunks at all, so the flashed partition keeps its previous contents there; this is only
+// suitable where zeroes are unused space, such as the free blocks of a filesystem. Default is false.
+func WithDontCare() WriteOpt {
+	return func(o *writeOpts) error {
+		o.dontCare = true
+		return nil
+	}
+}
+
+// run is a run of blocks stored in one chunk
+type run struct {
+	chunkType uint16
+	start     uint64
+	blocks    uint64
+	fill      [4]byte
+}
+
+// Write writes the raw image of the given size read from src to w as an Android sparse image. Blocks
+// that repeat a single 4 byte value, zeroes in particular, are stored as fill chunks, and the other
+// blocks as raw chunks. src is read twice, as the header records the number of chunks.
+func Write(w io.Writer, src io.ReaderAt, size int64, opts ...WriteOpt) error {
+	opt := &writeOpts{
+		blockSize: DefaultBlockSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return err
+		}
+	}
+	blockSize := int64(opt.blockSize)
+	if size <= 0 || size%blockSize != 0 {
+		return fmt.Errorf("must pass valid image size, a positive multiple of the block size %d", blockSize)
+	}
+	if size/blockSize > math.MaxUint32 {
+		return fmt.Errorf("image size %d is too large for block size %d", size, blockSize)
+	}
+	// the size of a raw chunk must fit in its header
+	maxRawBlocks := uint64((math.MaxUint32 - chunkHeaderSize) / blockSize)
+
+	runs, err := classify(src, size, blockSize, opt.dontCare, maxRawBlocks)
+	if err != nil {
+		return err
+	}
+	h := &fileHeader{
+		majorVersion:    majorVersion,
+		minorVersion:    minorVersion,
+		fileHeaderSize:  fileHeaderSize,
+		chunkHeaderSize: chunkHeaderSize,
+		blockSize:       opt.blockSize,
+		totalBlocks:     uint32(size / blockSize),
+		totalChunks:     uint32(len(runs)),
+	}
+	if _, err := w.Write(h.toBytes()); err != nil {
+		return fmt.Errorf("error writing Android sparse image header: %w", err)
+	}
+	data := make([]byte, blockSize)
+	for _, r := range runs {
+		c := chunkHeader{chunkType: r.chunkType, blocks: uint32(r.blocks), totalSize: chunkHeaderSize}
+		switch r.chunkType {
+		case chunkRaw:
+			c.totalSize += uint32(r.blocks * uint64(blockSize))
+		case chunkFill:
+			c.totalSize += 4
+		}
+		if _, err := w.Write(c.toBytes()); err != nil {
+			return fmt.Errorf("error writing chunk header: %w", err)
+		}
+		switch r.chunkType {
+		case chunkFill:
+			if _, err := w.Write(r.fill[:]); err != nil {
+				return fmt.Errorf("error writing fill chunk: %w", err)
+			}
+		case chunkRaw:
+			for block := r.start; block < r.start+r.blocks; block++ {
+				if err := readBlock(src, data, int64(block)*blockSize); err != nil {
+					return err
+				}
+				if _, err := w.Write(data); err != nil {
+					return fmt.Errorf("error writing raw chunk: %w", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// classify reads src and groups its blocks into the runs to store as chunks
+func classify(src io.ReaderAt, size, blockSize int64, dontCare bool, maxRawBlocks uint64) ([]run, error) {
+	var runs []run
+	data := make([]byte, blockSize)
+	for block := uint64(0); block < uint64(size/blockSize); block++ {
+		if err := readBlock(src, data, int64(block)*blockSize); err != nil {
+			return nil, err
+		}
+		r := run{chunkType: chunkRaw, start: block, blocks: 1}
+		if fill, ok := fillValue(data); ok {
+			r.chunkType, r.fill = chunkFill, fill
+			if dontCare && fill == [4]byte{} {
+				r.chunkType, r.fill = chunkDontCare, [4]byte{}
+			}
+		}
+		if n := len(runs); n > 0 {
+			last := &runs[n-1]
+			if last.chunkType == r.chunkType && last.fill == r.fill && (r.chunkType != chunkRaw || last.blocks < maxRawBlocks) {
+				last.blocks++
+				continue
+			}
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+func readBlock(src io.ReaderAt, b []byte, offset int64) error {
+	n, err := src.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading block at %d: %w", offset, err)
+	}
+	if n != len(b) {
+		return fmt.Errorf("error reading block at %d: read %d bytes instead of %d", offset, n, len(b))
+	}
+	return nil
+}
+
+// fillValue returns the 4 byte value the block consists of, if any
+func fillValue(b []byte) ([4]byte, bool) {
+	var fill [4]byte
+	copy(fill[:], b)
+	// compare each part with the part before it, doubling the size of the part that is known to match
+	for n := 4; n < len(b); n *= 2 {
+		end := min(2*n, len(b))
+		if !bytes.Equal(b[n:end], b[:end-n]) {
+			return fill, false
+		}
+	}
+	return fill, true
+}
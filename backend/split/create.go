@@ -0,0 +1,100 @@
+package split
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// DefaultPartSize is the size of the parts of new images, which keeps them well below the 4GB file
+// size limit of FAT filesystems
+const DefaultPartSize = 2 * 1024 * 1024 * 1024
+
+// DefaultPartName names the parts of an image as pathName followed by the number of the part from 001,
+// e.g. disk.img.001, disk.img.002, as with 7-Zip and many other tools
+func DefaultPartName(pathName string, index int) string {
+	return fmt.Sprintf("%s.%03d", pathName, index+1)
+}
+
+type createOpts struct {
+	partSize int64
+	partName func(pathName string, index int) string
+}
+
+// CreateOpt func that process CreateFromPath options
+type CreateOpt func(o *createOpts) error
+
+// WithPartSize sets the size of the parts, all but the last of which have this size. It must be a
+// positive multiple of 512. Default is DefaultPartSize.
+func WithPartSize(size int64) CreateOpt {
+	return func(o *createOpts) error {
+		if size <= 0 || size%512 != 0 {
+			return fmt.Errorf("invalid part size %d, must be a positive multiple of 512", size)
+		}
+		o.partSize = size
+		return nil
+	}
+}
+
+// WithPartName sets how parts are named from the image path name and the index of the part from 0.
+// Default is DefaultPartName. For example, VMDK twoGbMaxExtentFlat extents of disk.vmdk are named
+// disk-f001.vmdk, disk-f002.vmdk and so on.
+func WithPartName(name func(pathName string, index int) string) CreateOpt {
+	return func(o *createOpts) error {
+		if name == nil {
+			return errors.New("must pass part name function")
+		}
+		o.partName = name
+		return nil
+	}
+}
+
+// CreateFromPath creates the part files of a new, empty disk of the given size, named from pathName.
+// None of the part files may exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	if size <= 0 {
+		return nil, errors.New("must pass valid disk size to create")
+	}
+	opt := &createOpts{
+		partSize: DefaultPartSize,
+		partName: DefaultPartName,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+
+	var parts []backend.Storage
+	cleanup := func() {
+		for i, p := range parts {
+			p.Close()
+			os.Remove(opt.partName(pathName, i))
+		}
+	}
+	for offset := int64(0); offset < size; offset += opt.partSize {
+		name := opt.partName(pathName, len(parts))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("could not create part %s: %w", name, err)
+		}
+		parts = append(parts, file.New(f, false))
+		if err := f.Truncate(min(opt.partSize, size-offset)); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("could not expand part %s: %w", name, err)
+		}
+	}
+	img, err := New(parts, false)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return img, nil
+}
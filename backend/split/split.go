@@ -0,0 +1,272 @@
+// Package split provides a backend presenting a set of sequential part files as a single disk,
+// e.g. to stage images on FAT formatted media, whose files cannot reach 4GB, or for images split in
+// flat parts as in the VMDK twoGbMaxExtentFlat layout.
+package split
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// part is one of the files of the disk
+type part struct {
+	storage backend.Storage
+	rw      backend.WritableFile
+	// offset is the position of the part in the disk
+	offset int64
+	size   int64
+}
+
+// Image is a set of part files presented as a backend.Storage of the size of all of them
+type Image struct {
+	parts    []part
+	size     int64
+	readOnly bool
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New presents the provided parts, in order, as a single disk. The size of each part is its current size.
+// If readOnly is false, all parts must be writable.
+func New(parts []backend.Storage, readOnly bool) (*Image, error) {
+	if len(parts) == 0 {
+		return nil, errors.New("must pass at least one part")
+	}
+	img := &Image{
+		readOnly: readOnly,
+	}
+	for i, b := range parts {
+		info, err := b.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("could not stat part %d: %w", i, err)
+		}
+		p := part{
+			storage: b,
+			offset:  img.size,
+			size:    info.Size(),
+		}
+		if !readOnly {
+			if p.rw, err = b.Writable(); err != nil {
+				return nil, fmt.Errorf("part %d is not writable: %w", i, err)
+			}
+		}
+		img.parts = append(img.parts, p)
+		img.size += p.size
+	}
+	return img, nil
+}
+
+// OpenFromPaths opens the existing part files, in order, as a single disk
+func OpenFromPaths(pathNames []string, readOnly bool) (*Image, error) {
+	if len(pathNames) == 0 {
+		return nil, errors.New("must pass part file names")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	parts := make([]backend.Storage, 0, len(pathNames))
+	closeAll := func() {
+		for _, p := range parts {
+			p.Close()
+		}
+	}
+	for _, pathName := range pathNames {
+		f, err := os.OpenFile(pathName, openMode, 0o600)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("could not open part %s: %w", pathName, err)
+		}
+		parts = append(parts, file.New(f, readOnly))
+	}
+	img, err := New(parts, readOnly)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens the existing part files named by DefaultPartName for pathName, from the first
+// one until a part does not exist
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	var pathNames []string
+	for i := 0; ; i++ {
+		name := DefaultPartName(pathName, i)
+		if _, err := os.Stat(name); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			return nil, fmt.Errorf("could not stat part %s: %w", name, err)
+		}
+		pathNames = append(pathNames, name)
+	}
+	if len(pathNames) == 0 {
+		return nil, fmt.Errorf("no parts found for image %s, expected %s", pathName, DefaultPartName(pathName, 0))
+	}
+	return OpenFromPaths(pathNames, readOnly)
+}
+
+// Size is the size of the disk, the sum of the sizes of the parts
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// Parts is the number of parts of the disk
+func (img *Image) Parts() int {
+	return len(img.parts)
+}
+
+// find returns the index of the part containing the offset
+func (img *Image) find(off int64) int {
+	return sort.Search(len(img.parts), func(i int) bool {
+		return img.parts[i].offset+img.parts[i].size > off
+	})
+}
+
+// ReadAt reads from the disk, across parts as needed
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= img.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > img.size {
+		p = p[:img.size-off]
+		eof = io.EOF
+	}
+	read := 0
+	for i := img.find(off); read < len(p); i++ {
+		pt := img.parts[i]
+		inPart := off + int64(read) - pt.offset
+		chunk := int(min(int64(len(p)-read), pt.size-inPart))
+		n, err := pt.storage.ReadAt(p[read:read+chunk], inPart)
+		read += n
+		if err != nil && (!errors.Is(err, io.EOF) || n < chunk) {
+			return read, fmt.Errorf("error reading part %d: %w", i, err)
+		}
+	}
+	return read, eof
+}
+
+// WriteAt writes to the disk, across parts as needed. The size of the disk is fixed by its parts.
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	if off < 0 || off+int64(len(p)) > img.size {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the disk of size %d", len(p), off, img.size)
+	}
+	written := 0
+	for i := img.find(off); written < len(p); i++ {
+		pt := img.parts[i]
+		inPart := off + int64(written) - pt.offset
+		chunk := int(min(int64(len(p)-written), pt.size-inPart))
+		n, err := pt.rw.WriteAt(p[written:written+chunk], inPart)
+		written += n
+		if err != nil {
+			return written, fmt.Errorf("error writing part %d: %w", i, err)
+		}
+	}
+	return written, nil
+}
+
+// Stat returns the file info of the first part, but with the size of the disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.parts[0].storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.size}, nil
+}
+
+// Read reads from the disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.ReadAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.size
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs all parts
+func (img *Image) Sync() error {
+	var errs []error
+	for i, p := range img.parts {
+		if err := backend.Sync(p.storage); err != nil {
+			errs = append(errs, fmt.Errorf("error syncing part %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all of the parts
+func (img *Image) Close() error {
+	var errs []error
+	for i, p := range img.parts {
+		if err := p.storage.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing part %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sys is not suitable for split images, as ioctls on a single part do not apply to the disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the disk instead of the first part
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,148 @@
+package split_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/split"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		opts     []split.CreateOpt
+		parts    []string
+		partSize int64
+		err      bool
+	}{
+		{"single part", 10 * 1024 * 1024, nil, []string{"disk.img.001"}, 10 * 1024 * 1024, false},
+		{"even parts", 4 * 1024 * 1024, []split.CreateOpt{split.WithPartSize(1024 * 1024)}, []string{"disk.img.001", "disk.img.002", "disk.img.003", "disk.img.004"}, 1024 * 1024, false},
+		{"short last part", 3*1024*1024 + 512, []split.CreateOpt{split.WithPartSize(2 * 1024 * 1024)}, []string{"disk.img.001", "disk.img.002"}, 2 * 1024 * 1024, false},
+		{"part names", 2 * 1024 * 1024, []split.CreateOpt{
+			split.WithPartSize(1024 * 1024),
+			split.WithPartName(func(pathName string, index int) string {
+				return fmt.Sprintf("%s-f%03d.vmdk", strings.TrimSuffix(pathName, ".img"), index+1)
+			}),
+		}, []string{"disk-f001.vmdk", "disk-f002.vmdk"}, 1024 * 1024, false},
+		{"invalid part size", 10 * 1024 * 1024, []split.CreateOpt{split.WithPartSize(1000)}, nil, 0, true},
+		{"no size", 0, nil, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			img, err := split.CreateFromPath(filepath.Join(dir, "disk.img"), tt.size, tt.opts...)
+			switch {
+			case tt.err && err == nil:
+				img.Close()
+				t.Fatalf("expected error, got none")
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case err != nil:
+				return
+			}
+			defer img.Close()
+			if img.Size() != tt.size {
+				t.Errorf("size %d instead of %d", img.Size(), tt.size)
+			}
+			if img.Parts() != len(tt.parts) {
+				t.Errorf("%d parts instead of %d", img.Parts(), len(tt.parts))
+			}
+			for i, name := range tt.parts {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatalf("missing part %s: %v", name, err)
+				}
+				expected := min(tt.partSize, tt.size-int64(i)*tt.partSize)
+				if info.Size() != expected {
+					t.Errorf("part %s is %d bytes instead of %d", name, info.Size(), expected)
+				}
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "disk.img")
+	size := int64(3*1024*1024 + 4096)
+	img, err := split.CreateFromPath(p, size, split.WithPartSize(1024*1024))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := make([]byte, size)
+	for _, w := range []struct {
+		offset int64
+		length int
+	}{
+		{0, 512},
+		{1024*1024 - 10, 20},        // crosses a part boundary
+		{100, 2*1024*1024 + 100},    // spans a whole part
+		{size - 4096, 4096},         // the short last part
+		{3*1024*1024 - 1, 4096 + 1}, // ends at the end of the disk
+	} {
+		b := make([]byte, w.length)
+		_, _ = rand.Read(b)
+		n, err := img.WriteAt(b, w.offset)
+		if err != nil {
+			t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+		}
+		if n != w.length {
+			t.Fatalf("wrote %d bytes instead of %d", n, w.length)
+		}
+		copy(expected[w.offset:], b)
+	}
+	if _, err := img.WriteAt([]byte{1}, size); err == nil {
+		t.Errorf("expected error writing beyond end of disk")
+	}
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parts hold the disk in order
+	var joined []byte
+	for i := 0; i < 4; i++ {
+		b, err := os.ReadFile(split.DefaultPartName(p, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined = append(joined, b...)
+	}
+	if !bytes.Equal(joined, expected) {
+		t.Errorf("mismatched contents of parts")
+	}
+
+	img, err = split.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	defer img.Close()
+	if img.Size() != size {
+		t.Errorf("size %d instead of %d", img.Size(), size)
+	}
+	b, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("mismatched image contents")
+	}
+	if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+		t.Errorf("expected io.EOF reading past the end, got %v", err)
+	}
+	if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+		t.Errorf("expected error writing to read-only image")
+	}
+}
+
+func TestOpenFromPathMissing(t *testing.T) {
+	if _, err := split.OpenFromPath(filepath.Join(t.TempDir(), "disk.img"), true); err == nil {
+		t.Errorf("expected error opening image without parts")
+	}
+}
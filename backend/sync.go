@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"errors"
+)
+
+// Syncer is implemented by storage that can make the writes so far durable, such as *os.File
+type Syncer interface {
+	Sync() error
+}
+
+// Sync makes the writes to the storage so far durable, committing them from any buffer or cache
+// to the underlying storage and device. It calls Sync of the storage, or else of its writable
+// file, if either is a Syncer, and does nothing otherwise, e.g. for read-only storage.
+func Sync(b Storage) error {
+	if s, ok := b.(Syncer); ok {
+		return s.Sync()
+	}
+	w, err := b.Writable()
+	if err != nil {
+		if errors.Is(err, ErrIncorrectOpenMode) || errors.Is(err, ErrNotSuitable) {
+			return nil
+		}
+		return err
+	}
+	if s, ok := w.(Syncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+// syncingStorage counts the syncs of the storage
+type syncingStorage struct {
+	*mem.Storage
+	syncs int
+}
+
+func (s *syncingStorage) Sync() error {
+	s.syncs++
+	return nil
+}
+
+func TestSync(t *testing.T) {
+	s := &syncingStorage{Storage: mem.New(make([]byte, 4096), false)}
+	c, err := backend.WithCache(s, 4096, 1024)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	wrapped := []backend.Storage{
+		s,
+		c,
+		backend.Synchronized(s),
+		backend.FromReadWriterAt(s, 4096),
+	}
+	for i, b := range wrapped {
+		if err := backend.Sync(b); err != nil {
+			t.Errorf("error syncing: %v", err)
+		}
+		if s.syncs != i+1 {
+			t.Errorf("storage %d not synced", i)
+		}
+	}
+	// storage that cannot be synced, or is read-only, has nothing to sync
+	if err := backend.Sync(mem.New(make([]byte, 4096), false)); err != nil {
+		t.Errorf("error syncing storage without Sync: %v", err)
+	}
+	if err := backend.Sync(backend.FromReaderAt(s, 4096)); err != nil {
+		t.Errorf("error syncing read-only storage: %v", err)
+	}
+	if s.syncs != len(wrapped) {
+		t.Errorf("read-only storage synced")
+	}
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// synchronizedStorage is a Storage whose operations are serialized with a mutex
+type synchronizedStorage struct {
+	storage Storage
+	mu      sync.Mutex
+}
+
+// Synchronized wraps the storage so that all of its operations, including the writes through
+// Writable, are serialized, to make a storage that is not safe for concurrent use keep to the
+// concurrency contract of the package. The storage must not be used other than through the wrapper.
+func Synchronized(b Storage) Storage {
+	if s, ok := b.(*synchronizedStorage); ok {
+		return s
+	}
+	return &synchronizedStorage{storage: b}
+}
+
+func (s *synchronizedStorage) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.ReadAt(p, off)
+}
+
+func (s *synchronizedStorage) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Read(p)
+}
+
+func (s *synchronizedStorage) Seek(offset int64, whence int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Seek(offset, whence)
+}
+
+func (s *synchronizedStorage) Stat() (fs.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Stat()
+}
+
+func (s *synchronizedStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.storage.Close()
+}
+
+// Sync syncs the storage
+func (s *synchronizedStorage) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Sync(s.storage)
+}
+
+// Sys returns the file of the storage; operations on it are not serialized
+func (s *synchronizedStorage) Sys() (*os.File, error) {
+	return s.storage.Sys()
+}
+
+// Writable returns a file whose writes are serialized with the operations of the storage
+func (s *synchronizedStorage) Writable() (WritableFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, err := s.storage.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &synchronizedWritable{synchronizedStorage: s, w: w}, nil
+}
+
+type synchronizedWritable struct {
+	*synchronizedStorage
+	w WritableFile
+}
+
+func (s *synchronizedWritable) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.WriteAt(p, off)
+}
@@ -0,0 +1,90 @@
+package backend_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
+)
+
+// seekingFile reads at an offset by seeking, which is not safe for concurrent use
+type seekingFile struct {
+	*bytes.Reader
+}
+
+func (f *seekingFile) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(f.Reader, p)
+}
+
+func (f *seekingFile) Stat() (fs.FileInfo, error) {
+	return mem.New(make([]byte, f.Size()), true).Stat()
+}
+
+func (f *seekingFile) Close() error {
+	return nil
+}
+
+func TestSynchronized(t *testing.T) {
+	content := make([]byte, 64*1024)
+	_, _ = rand.Read(content)
+	s := backend.Synchronized(file.New(&seekingFile{Reader: bytes.NewReader(content)}, true))
+	if backend.Synchronized(s) != s {
+		t.Errorf("synchronized storage wrapped again")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b := make([]byte, 1000)
+			for off := int64(i * 100); off+int64(len(b)) <= int64(len(content)); off += 3000 {
+				if _, err := s.ReadAt(b, off); err != nil {
+					t.Errorf("error reading at %d: %v", off, err)
+					return
+				}
+				if !bytes.Equal(b, content[off:off+int64(len(b))]) {
+					t.Errorf("contents at %d do not match", off)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := s.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only storage")
+	}
+}
+
+func TestSynchronizedWritable(t *testing.T) {
+	m := mem.New(make([]byte, 4096), false)
+	s := backend.Synchronized(m)
+	w, err := s.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := w.WriteAt(bytes.Repeat([]byte{byte(i)}, 256), int64(i*256)); err != nil {
+				t.Errorf("error writing: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 16; i++ {
+		if !bytes.Equal(m.Bytes()[i*256:(i+1)*256], bytes.Repeat([]byte{byte(i)}, 256)) {
+			t.Errorf("contents of write %d do not match", i)
+		}
+	}
+}
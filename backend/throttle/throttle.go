@@ -0,0 +1,198 @@
+// Package throttle provides a backend wrapper for tests, which slows down reads and writes with a
+// latency and a bandwidth cap, and returns short reads, to simulate slow or unreliable storage.
+//
+// The delays are passed to a sleep function, time.Sleep by default, which tests can replace to
+// record them instead of waiting, so that performance regressions, such as an operation that
+// suddenly needs many more reads, and retry behavior can be tested deterministically.
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// ErrShortRead is returned by ReadAt when it reads less than requested because of WithMaxRead,
+// since io.ReaderAt must return an error then
+var ErrShortRead = errors.New("short read")
+
+// Stats counts the operations on the storage, and the delay added to them
+type Stats struct {
+	Reads        int64
+	Writes       int64
+	BytesRead    int64
+	BytesWritten int64
+	Delay        time.Duration
+}
+
+// Storage is a backend.Storage that delays the operations on the storage it wraps
+type Storage struct {
+	backend.Storage
+	latency   time.Duration
+	bandwidth int64
+	maxRead   int
+	sleep     func(time.Duration)
+	// mu guards stats
+	mu    sync.Mutex
+	stats Stats
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Storage)(nil)
+
+type opts struct {
+	latency   time.Duration
+	bandwidth int64
+	maxRead   int
+	sleep     func(time.Duration)
+}
+
+// Opt func that process New options
+type Opt func(o *opts) error
+
+// WithLatency adds a delay to every read and write
+func WithLatency(d time.Duration) Opt {
+	return func(o *opts) error {
+		if d < 0 {
+			return fmt.Errorf("invalid latency %v", d)
+		}
+		o.latency = d
+		return nil
+	}
+}
+
+// WithBandwidth limits reads and writes to bytesPerSecond, by adding a delay for the time the
+// bytes would take to transfer
+func WithBandwidth(bytesPerSecond int64) Opt {
+	return func(o *opts) error {
+		if bytesPerSecond <= 0 {
+			return fmt.Errorf("invalid bandwidth %d", bytesPerSecond)
+		}
+		o.bandwidth = bytesPerSecond
+		return nil
+	}
+}
+
+// WithMaxRead limits reads to n bytes each. Read returns fewer bytes without an error, as
+// io.Reader allows, while ReadAt returns ErrShortRead.
+func WithMaxRead(n int) Opt {
+	return func(o *opts) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid maximum read %d", n)
+		}
+		o.maxRead = n
+		return nil
+	}
+}
+
+// WithSleep sets the function that waits for the delays, time.Sleep if not set. Tests can pass a
+// function that does nothing, and check Stats for the delays instead.
+func WithSleep(sleep func(time.Duration)) Opt {
+	return func(o *opts) error {
+		if sleep == nil {
+			return errors.New("must pass sleep function")
+		}
+		o.sleep = sleep
+		return nil
+	}
+}
+
+// New wraps the storage, delaying its operations as configured by the options
+func New(b backend.Storage, options ...Opt) (*Storage, error) {
+	o := &opts{sleep: time.Sleep}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return &Storage{
+		Storage:   b,
+		latency:   o.latency,
+		bandwidth: o.bandwidth,
+		maxRead:   o.maxRead,
+		sleep:     o.sleep,
+	}, nil
+}
+
+// Stats returns the counts of operations so far
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// delay waits for an operation of n bytes, and counts it
+func (s *Storage) delay(n int, write bool) {
+	d := s.latency
+	if s.bandwidth > 0 {
+		d += time.Duration(int64(n) * int64(time.Second) / s.bandwidth)
+	}
+	s.mu.Lock()
+	if write {
+		s.stats.Writes++
+		s.stats.BytesWritten += int64(n)
+	} else {
+		s.stats.Reads++
+		s.stats.BytesRead += int64(n)
+	}
+	s.stats.Delay += d
+	s.mu.Unlock()
+	if d > 0 {
+		s.sleep(d)
+	}
+}
+
+// Read reads up to the maximum read from the storage
+func (s *Storage) Read(p []byte) (int, error) {
+	if s.maxRead > 0 && len(p) > s.maxRead {
+		p = p[:s.maxRead]
+	}
+	n, err := s.Storage.Read(p)
+	s.delay(n, false)
+	return n, err
+}
+
+// ReadAt reads up to the maximum read from the storage, returning ErrShortRead if that is less
+// than requested
+func (s *Storage) ReadAt(p []byte, off int64) (int, error) {
+	short := false
+	if s.maxRead > 0 && len(p) > s.maxRead {
+		p = p[:s.maxRead]
+		short = true
+	}
+	n, err := s.Storage.ReadAt(p, off)
+	s.delay(n, false)
+	if err == nil && short {
+		err = ErrShortRead
+	}
+	return n, err
+}
+
+// Sync syncs the storage
+func (s *Storage) Sync() error {
+	return backend.Sync(s.Storage)
+}
+
+// Writable returns a file whose reads and writes are delayed
+func (s *Storage) Writable() (backend.WritableFile, error) {
+	w, err := s.Storage.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &writable{Storage: s, w: w}, nil
+}
+
+type writable struct {
+	*Storage
+	w io.WriterAt
+}
+
+func (w *writable) WriteAt(p []byte, off int64) (int, error) {
+	n, err := w.w.WriteAt(p, off)
+	w.delay(n, true)
+	return n, err
+}
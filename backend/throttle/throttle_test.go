@@ -0,0 +1,99 @@
+package throttle_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/backend/throttle"
+)
+
+func TestThrottle(t *testing.T) {
+	content := make([]byte, 1024*1024)
+	_, _ = rand.Read(content)
+	var slept []time.Duration
+	s, err := throttle.New(mem.New(append([]byte(nil), content...), false),
+		throttle.WithLatency(10*time.Millisecond),
+		throttle.WithBandwidth(1024*1024),
+		throttle.WithSleep(func(d time.Duration) { slept = append(slept, d) }),
+	)
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+
+	b := make([]byte, len(content))
+	if _, err := s.ReadAt(b, 0); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("contents do not match")
+	}
+	w, err := s.Writable()
+	if err != nil {
+		t.Fatalf("error getting writable: %v", err)
+	}
+	if _, err := w.WriteAt(make([]byte, 512*1024), 0); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+
+	expected := []time.Duration{10*time.Millisecond + time.Second, 10*time.Millisecond + time.Second/2}
+	if len(slept) != len(expected) || slept[0] != expected[0] || slept[1] != expected[1] {
+		t.Errorf("delays %v instead of %v", slept, expected)
+	}
+	stats := s.Stats()
+	expectedStats := throttle.Stats{Reads: 1, Writes: 1, BytesRead: 1024 * 1024, BytesWritten: 512 * 1024, Delay: expected[0] + expected[1]}
+	if stats != expectedStats {
+		t.Errorf("stats %+v instead of %+v", stats, expectedStats)
+	}
+}
+
+func TestMaxRead(t *testing.T) {
+	content := make([]byte, 10000)
+	_, _ = rand.Read(content)
+	s, err := throttle.New(mem.New(content, true), throttle.WithMaxRead(1000))
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+
+	b := make([]byte, 4096)
+	n, err := s.ReadAt(b, 0)
+	if n != 1000 || !errors.Is(err, throttle.ErrShortRead) {
+		t.Errorf("read %d bytes with error %v instead of 1000 bytes with short read", n, err)
+	}
+	if n, err := s.ReadAt(b[:100], int64(len(content))-100); n != 100 || err != nil {
+		t.Errorf("read %d bytes with error %v instead of 100 bytes", n, err)
+	}
+
+	// readers that retry short reads still get everything
+	all, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(all, content) {
+		t.Errorf("contents do not match")
+	}
+	if reads := s.Stats().Reads; reads < 10 {
+		t.Errorf("%d reads instead of at least 10", reads)
+	}
+	if _, err := s.Writable(); err == nil {
+		t.Errorf("no error getting writable of read-only storage")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	s := mem.New(nil, true)
+	for _, opt := range []throttle.Opt{
+		throttle.WithLatency(-time.Second),
+		throttle.WithBandwidth(0),
+		throttle.WithMaxRead(0),
+		throttle.WithSleep(nil),
+	} {
+		if _, err := throttle.New(s, opt); err == nil {
+			t.Errorf("no error with invalid option")
+		}
+	}
+}
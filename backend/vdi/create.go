@@ -0,0 +1,160 @@
+package vdi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// DefaultBlockSize is the block size of new images, as with VirtualBox
+	DefaultBlockSize = 1024 * 1024
+	// the block map and the data are aligned to 1MB, as with VirtualBox
+	dataAlign = 1024 * 1024
+)
+
+type createOpts struct {
+	imageType ImageType
+	blockSize uint32
+	comment   string
+}
+
+// CreateOpt func that process Create options
+type CreateOpt func(o *createOpts) error
+
+// WithImageType sets the type of the new image, Dynamic or Fixed. Default is Dynamic.
+func WithImageType(imageType ImageType) CreateOpt {
+	return func(o *createOpts) error {
+		switch imageType {
+		case Dynamic, Fixed:
+		default:
+			return fmt.Errorf("unsupported image type %d, must be Dynamic or Fixed", imageType)
+		}
+		o.imageType = imageType
+		return nil
+	}
+}
+
+// WithBlockSize sets the block size of the new image, which must be a power of 2 of at least 512.
+// Default is DefaultBlockSize, which is the only block size VirtualBox itself creates.
+func WithBlockSize(size uint32) CreateOpt {
+	return func(o *createOpts) error {
+		if size < sectorSize || size&(size-1) != 0 {
+			return fmt.Errorf("invalid block size %d, must be a power of 2 of at least %d", size, sectorSize)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// WithComment sets the comment recorded in the header of the new image, of at most 255 bytes
+func WithComment(comment string) CreateOpt {
+	return func(o *createOpts) error {
+		if len(comment) >= commentSize {
+			return fmt.Errorf("comment is %d bytes, more than the maximum of %d", len(comment), commentSize-1)
+		}
+		o.comment = comment
+		return nil
+	}
+}
+
+func alignUp(n, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
+
+// Create writes a new VDI image with a virtual disk of the given size into the provided backend.Storage,
+// which must be writable, and should be empty. The size must be a multiple of 512.
+func Create(b backend.Storage, size int64, opts ...CreateOpt) (*Image, error) {
+	if size <= 0 || size%sectorSize != 0 {
+		return nil, fmt.Errorf("must pass valid virtual disk size to create, a positive multiple of %d", sectorSize)
+	}
+	opt := &createOpts{
+		imageType: Dynamic,
+		blockSize: DefaultBlockSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	rw, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := alignUp(uint64(size), uint64(opt.blockSize)) / uint64(opt.blockSize)
+	if blocks >= uint64(blockZero) {
+		return nil, fmt.Errorf("virtual disk size %d is too large for block size %d", size, opt.blockSize)
+	}
+	offBlocks := alignUp(preHeaderSize+headerSize, dataAlign)
+	offData := alignUp(offBlocks+blocks*4, dataAlign)
+	if offData > 0xffffffff {
+		return nil, fmt.Errorf("virtual disk size %d is too large for block size %d", size, opt.blockSize)
+	}
+	h := &header{
+		imageType:  opt.imageType,
+		comment:    opt.comment,
+		offBlocks:  uint32(offBlocks),
+		offData:    uint32(offData),
+		diskSize:   uint64(size),
+		blockSize:  opt.blockSize,
+		blocks:     uint32(blocks),
+		uuidCreate: util.NewUUID(),
+		uuidModify: util.NewUUID(),
+		lchsGeometry: geometry{
+			cylinders:  uint32(min(uint64(size)/sectorSize/(16*63), 16383)),
+			heads:      16,
+			sectors:    63,
+			sectorSize: sectorSize,
+		},
+	}
+
+	blockMap := make([]byte, blocks*4)
+	for i := uint64(0); i < blocks; i++ {
+		entry := blockFree
+		if opt.imageType == Fixed {
+			entry = uint32(i)
+		}
+		binary.LittleEndian.PutUint32(blockMap[i*4:], entry)
+	}
+	end := offData
+	if opt.imageType == Fixed {
+		h.blocksAllocated = h.blocks
+		end += blocks * uint64(opt.blockSize)
+	}
+
+	// extend the file to the end of the data first, the blocks of fixed images are all zero
+	if _, err := rw.WriteAt(make([]byte, sectorSize), int64(end)-sectorSize); err != nil {
+		return nil, fmt.Errorf("error writing VDI image: %w", err)
+	}
+	if _, err := rw.WriteAt(h.toBytes(), 0); err != nil {
+		return nil, fmt.Errorf("error writing VDI header: %w", err)
+	}
+	if _, err := rw.WriteAt(blockMap, int64(offBlocks)); err != nil {
+		return nil, fmt.Errorf("error writing VDI block map: %w", err)
+	}
+	return New(b, false)
+}
+
+// CreateFromPath creates a new VDI image file with a virtual disk of the given size.
+// The provided file must not exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not create image %s: %w", pathName, err)
+	}
+	img, err := Create(file.New(f, false), size, opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not create VDI image %s: %w", pathName, err)
+	}
+	return img, nil
+}
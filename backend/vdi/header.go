@@ -0,0 +1,180 @@
+package vdi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	preHeaderSize = 0x48
+	headerSize    = 0x190
+	// the header follows the pre-header, and the block map is aligned after it
+	headerOffset = preHeaderSize
+
+	vdiSignature = 0xbeda107f
+	vdiVersion   = 0x00010001
+	vdiInfo      = "<<< Oracle VM VirtualBox Disk Image >>>\n"
+
+	commentSize = 256
+
+	// block map entries for blocks that are not allocated in the image
+	blockFree uint32 = 0xffffffff
+	blockZero uint32 = 0xfffffffe
+
+	sectorSize = 512
+)
+
+// ImageType is the type of VDI image
+type ImageType uint32
+
+const (
+	// Dynamic images only store the blocks that have been written
+	Dynamic ImageType = 1
+	// Fixed images store every block of the virtual disk
+	Fixed ImageType = 2
+)
+
+// geometry is a CHS geometry as recorded in the header
+type geometry struct {
+	cylinders  uint32
+	heads      uint32
+	sectors    uint32
+	sectorSize uint32
+}
+
+// header is the pre-header and version 1.1 header of a VDI image
+type header struct {
+	imageType        ImageType
+	flags            uint32
+	comment          string
+	offBlocks        uint32
+	offData          uint32
+	legacyGeometry   geometry
+	diskSize         uint64
+	blockSize        uint32
+	blockExtra       uint32
+	blocks           uint32
+	blocksAllocated  uint32
+	uuidCreate       uuid.UUID
+	uuidModify       uuid.UUID
+	uuidLinkage      uuid.UUID
+	uuidParentModify uuid.UUID
+	lchsGeometry     geometry
+}
+
+// uuidFromBytes reads a UUID as stored by VirtualBox, with the first three fields little-endian
+func uuidFromBytes(b []byte) uuid.UUID {
+	var u uuid.UUID
+	copy(u[:], b[:16])
+	u[0], u[1], u[2], u[3] = b[3], b[2], b[1], b[0]
+	u[4], u[5] = b[5], b[4]
+	u[6], u[7] = b[7], b[6]
+	return u
+}
+
+// uuidToBytes writes a UUID as stored by VirtualBox
+func uuidToBytes(b []byte, u uuid.UUID) {
+	copy(b[:16], u[:])
+	b[0], b[1], b[2], b[3] = u[3], u[2], u[1], u[0]
+	b[4], b[5] = u[5], u[4]
+	b[6], b[7] = u[7], u[6]
+}
+
+func geometryFromBytes(b []byte) geometry {
+	return geometry{
+		cylinders:  binary.LittleEndian.Uint32(b[0:4]),
+		heads:      binary.LittleEndian.Uint32(b[4:8]),
+		sectors:    binary.LittleEndian.Uint32(b[8:12]),
+		sectorSize: binary.LittleEndian.Uint32(b[12:16]),
+	}
+}
+
+func (g geometry) toBytes(b []byte) {
+	binary.LittleEndian.PutUint32(b[0:4], g.cylinders)
+	binary.LittleEndian.PutUint32(b[4:8], g.heads)
+	binary.LittleEndian.PutUint32(b[8:12], g.sectors)
+	binary.LittleEndian.PutUint32(b[12:16], g.sectorSize)
+}
+
+// headerFromBytes parses the pre-header and header, b starts at the beginning of the image
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < preHeaderSize+headerSize {
+		return nil, fmt.Errorf("header was %d bytes instead of expected %d", len(b), preHeaderSize+headerSize)
+	}
+	if sig := binary.LittleEndian.Uint32(b[0x40:0x44]); sig != vdiSignature {
+		return nil, fmt.Errorf("invalid VDI signature %#x", sig)
+	}
+	if version := binary.LittleEndian.Uint32(b[0x44:0x48]); version != vdiVersion {
+		return nil, fmt.Errorf("unsupported VDI version %#x", version)
+	}
+	h := b[headerOffset:]
+	if size := binary.LittleEndian.Uint32(h[0:4]); size != headerSize {
+		return nil, fmt.Errorf("unsupported VDI header size %d", size)
+	}
+	comment := h[0x0c : 0x0c+commentSize]
+	for i, c := range comment {
+		if c == 0 {
+			comment = comment[:i]
+			break
+		}
+	}
+	hdr := &header{
+		imageType:        ImageType(binary.LittleEndian.Uint32(h[0x04:0x08])),
+		flags:            binary.LittleEndian.Uint32(h[0x08:0x0c]),
+		comment:          string(comment),
+		offBlocks:        binary.LittleEndian.Uint32(h[0x10c:0x110]),
+		offData:          binary.LittleEndian.Uint32(h[0x110:0x114]),
+		legacyGeometry:   geometryFromBytes(h[0x114:0x124]),
+		diskSize:         binary.LittleEndian.Uint64(h[0x128:0x130]),
+		blockSize:        binary.LittleEndian.Uint32(h[0x130:0x134]),
+		blockExtra:       binary.LittleEndian.Uint32(h[0x134:0x138]),
+		blocks:           binary.LittleEndian.Uint32(h[0x138:0x13c]),
+		blocksAllocated:  binary.LittleEndian.Uint32(h[0x13c:0x140]),
+		uuidCreate:       uuidFromBytes(h[0x140:0x150]),
+		uuidModify:       uuidFromBytes(h[0x150:0x160]),
+		uuidLinkage:      uuidFromBytes(h[0x160:0x170]),
+		uuidParentModify: uuidFromBytes(h[0x170:0x180]),
+		lchsGeometry:     geometryFromBytes(h[0x180:0x190]),
+	}
+	switch hdr.imageType {
+	case Dynamic, Fixed:
+	default:
+		return nil, fmt.Errorf("unsupported VDI image type %d, only dynamic and fixed images are supported", hdr.imageType)
+	}
+	if hdr.blockSize == 0 || hdr.blockSize%sectorSize != 0 {
+		return nil, fmt.Errorf("invalid VDI block size %d", hdr.blockSize)
+	}
+	if expected := (hdr.diskSize + uint64(hdr.blockSize) - 1) / uint64(hdr.blockSize); uint64(hdr.blocks) < expected {
+		return nil, fmt.Errorf("VDI image has %d blocks, but its size %d requires %d", hdr.blocks, hdr.diskSize, expected)
+	}
+	return hdr, nil
+}
+
+// toBytes serializes the pre-header and header
+func (hdr *header) toBytes() []byte {
+	b := make([]byte, preHeaderSize+headerSize)
+	copy(b[0:0x40], vdiInfo)
+	binary.LittleEndian.PutUint32(b[0x40:0x44], vdiSignature)
+	binary.LittleEndian.PutUint32(b[0x44:0x48], vdiVersion)
+	h := b[headerOffset:]
+	binary.LittleEndian.PutUint32(h[0:4], headerSize)
+	binary.LittleEndian.PutUint32(h[0x04:0x08], uint32(hdr.imageType))
+	binary.LittleEndian.PutUint32(h[0x08:0x0c], hdr.flags)
+	copy(h[0x0c:0x0c+commentSize-1], hdr.comment)
+	binary.LittleEndian.PutUint32(h[0x10c:0x110], hdr.offBlocks)
+	binary.LittleEndian.PutUint32(h[0x110:0x114], hdr.offData)
+	hdr.legacyGeometry.toBytes(h[0x114:0x124])
+	binary.LittleEndian.PutUint64(h[0x128:0x130], hdr.diskSize)
+	binary.LittleEndian.PutUint32(h[0x130:0x134], hdr.blockSize)
+	binary.LittleEndian.PutUint32(h[0x134:0x138], hdr.blockExtra)
+	binary.LittleEndian.PutUint32(h[0x138:0x13c], hdr.blocks)
+	binary.LittleEndian.PutUint32(h[0x13c:0x140], hdr.blocksAllocated)
+	uuidToBytes(h[0x140:0x150], hdr.uuidCreate)
+	uuidToBytes(h[0x150:0x160], hdr.uuidModify)
+	uuidToBytes(h[0x160:0x170], hdr.uuidLinkage)
+	uuidToBytes(h[0x170:0x180], hdr.uuidParentModify)
+	hdr.lchsGeometry.toBytes(h[0x180:0x190])
+	return b
+}
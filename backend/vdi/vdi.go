@@ -0,0 +1,332 @@
+// Package vdi provides a backend for disk images in the VirtualBox disk image (VDI) format.
+//
+// Dynamic and fixed images can be read, written and created; undo and differencing images are not supported.
+package vdi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+// Image is a VDI image presented as a backend.Storage of the size of its virtual disk
+type Image struct {
+	storage  backend.Storage
+	rw       backend.WritableFile
+	readOnly bool
+	header   *header
+	blockMap []uint32
+	// modified whether the modification UUID has been updated since the image was opened
+	modified bool
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the VDI image stored in the provided backend.Storage.
+// If readOnly is false, the storage must be writable.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	img := &Image{
+		storage:  b,
+		readOnly: readOnly,
+	}
+	if !readOnly {
+		rw, err := b.Writable()
+		if err != nil {
+			return nil, err
+		}
+		img.rw = rw
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing VDI image file
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, readOnly), readOnly)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open VDI image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init() error {
+	b := make([]byte, preHeaderSize+headerSize)
+	if err := img.readFull(b, 0); err != nil {
+		return fmt.Errorf("error reading VDI header: %w", err)
+	}
+	h, err := headerFromBytes(b)
+	if err != nil {
+		return err
+	}
+	img.header = h
+
+	m := make([]byte, int(h.blocks)*4)
+	if err := img.readFull(m, int64(h.offBlocks)); err != nil {
+		return fmt.Errorf("error reading VDI block map: %w", err)
+	}
+	img.blockMap = make([]uint32, h.blocks)
+	for i := range img.blockMap {
+		entry := binary.LittleEndian.Uint32(m[i*4 : i*4+4])
+		if entry != blockFree && entry != blockZero && entry >= h.blocksAllocated {
+			return fmt.Errorf("block %d is mapped to %d, beyond the %d allocated blocks", i, entry, h.blocksAllocated)
+		}
+		img.blockMap[i] = entry
+	}
+	return nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, treating data past the end of the image as zero
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(b[n:])
+	return nil
+}
+
+func (img *Image) writeFull(b []byte, offset int64) error {
+	n, err := img.rw.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// Size is the size of the virtual disk
+func (img *Image) Size() int64 {
+	return int64(img.header.diskSize)
+}
+
+// BlockSize is the size of the blocks in which the image is allocated
+func (img *Image) BlockSize() int64 {
+	return int64(img.header.blockSize)
+}
+
+// Type is the type of the image, Dynamic or Fixed
+func (img *Image) Type() ImageType {
+	return img.header.imageType
+}
+
+// UUID is the creation UUID identifying the image
+func (img *Image) UUID() uuid.UUID {
+	return img.header.uuidCreate
+}
+
+// blockOffset returns the offset in the image file of the data of the allocated block
+func (img *Image) blockOffset(entry uint32) int64 {
+	h := img.header
+	return int64(h.offData) + int64(entry)*int64(h.blockSize+h.blockExtra) + int64(h.blockExtra)
+}
+
+// ReadAt reads from the virtual disk
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	return img.readAt(p, off)
+}
+
+func (img *Image) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	blockSize := img.BlockSize()
+	read := 0
+	for read < len(p) {
+		virtual := off + int64(read)
+		chunk := int(min(int64(len(p)-read), blockSize-virtual%blockSize))
+		b := p[read : read+chunk]
+		entry := img.blockMap[virtual/blockSize]
+		if entry == blockFree || entry == blockZero {
+			clear(b)
+		} else if err := img.readFull(b, img.blockOffset(entry)+virtual%blockSize); err != nil {
+			return read, err
+		}
+		read += chunk
+	}
+	return read, eof
+}
+
+// WriteAt writes to the virtual disk, allocating blocks as needed
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > img.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the virtual disk of size %d", len(p), off, img.Size())
+	}
+	if err := img.markModified(); err != nil {
+		return 0, err
+	}
+	blockSize := img.BlockSize()
+	written := 0
+	for written < len(p) {
+		virtual := off + int64(written)
+		chunk := int(min(int64(len(p)-written), blockSize-virtual%blockSize))
+		if err := img.writeBlock(p[written:written+chunk], virtual); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// writeBlock writes part of a single block, which must not cross a block boundary
+func (img *Image) writeBlock(b []byte, virtual int64) error {
+	blockSize := img.BlockSize()
+	index := virtual / blockSize
+	inBlock := virtual % blockSize
+	if entry := img.blockMap[index]; entry != blockFree && entry != blockZero {
+		return img.writeFull(b, img.blockOffset(entry)+inBlock)
+	}
+
+	// allocate a new block at the end of the data, and write the whole of it
+	h := img.header
+	entry := h.blocksAllocated
+	data := make([]byte, blockSize)
+	copy(data[inBlock:], b)
+	if err := img.writeFull(data, img.blockOffset(entry)); err != nil {
+		return fmt.Errorf("error writing block %d: %w", index, err)
+	}
+	e := make([]byte, 4)
+	binary.LittleEndian.PutUint32(e, entry)
+	if err := img.writeFull(e, int64(h.offBlocks)+index*4); err != nil {
+		return fmt.Errorf("error updating block map: %w", err)
+	}
+	img.blockMap[index] = entry
+	h.blocksAllocated++
+	if err := img.writeHeader(); err != nil {
+		return fmt.Errorf("error updating VDI header: %w", err)
+	}
+	return nil
+}
+
+// markModified sets a new modification UUID on the first write since the image was opened,
+// so that VirtualBox detects that the image changed
+func (img *Image) markModified() error {
+	if img.modified {
+		return nil
+	}
+	img.header.uuidModify = util.NewUUID()
+	if err := img.writeHeader(); err != nil {
+		return fmt.Errorf("error updating VDI header: %w", err)
+	}
+	img.modified = true
+	return nil
+}
+
+func (img *Image) writeHeader() error {
+	return img.writeFull(img.header.toBytes(), 0)
+}
+
+// Stat returns the file info of the image, but with the size of the virtual disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the virtual disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.readAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the virtual disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the image file
+func (img *Image) Sync() error {
+	return backend.Sync(img.storage)
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for VDI images, as ioctls on the image file do not apply to the virtual disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the virtual disk instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,64 @@
+package vdi
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHeader(t *testing.T) {
+	h := &header{
+		imageType:        Dynamic,
+		comment:          "test image",
+		offBlocks:        dataAlign,
+		offData:          2 * dataAlign,
+		diskSize:         64 * 1024 * 1024,
+		blockSize:        DefaultBlockSize,
+		blocks:           64,
+		blocksAllocated:  3,
+		uuidCreate:       uuid.New(),
+		uuidModify:       uuid.New(),
+		uuidLinkage:      uuid.Nil,
+		uuidParentModify: uuid.Nil,
+		lchsGeometry:     geometry{cylinders: 130, heads: 16, sectors: 63, sectorSize: sectorSize},
+	}
+	b := h.toBytes()
+	if string(b[:len(vdiInfo)]) != vdiInfo {
+		t.Errorf("invalid pre-header text %q", b[:len(vdiInfo)])
+	}
+	// signature and version as written by VirtualBox
+	if sig := []byte{0x7f, 0x10, 0xda, 0xbe, 0x01, 0x00, 0x01, 0x00}; string(b[0x40:0x48]) != string(sig) {
+		t.Errorf("invalid signature and version % x", b[0x40:0x48])
+	}
+	read, err := headerFromBytes(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *read != *h {
+		t.Errorf("mismatched header after round trip, got %+v, expected %+v", read, h)
+	}
+
+	bad := h.toBytes()
+	bad[0x40] = 0
+	if _, err := headerFromBytes(bad); err == nil {
+		t.Errorf("expected error for invalid signature")
+	}
+	short := *h
+	short.blocks = 10
+	if _, err := headerFromBytes(short.toBytes()); err == nil {
+		t.Errorf("expected error for too few blocks")
+	}
+}
+
+func TestUUIDBytes(t *testing.T) {
+	u := uuid.MustParse("00112233-4455-6677-8899-aabbccddeeff")
+	b := make([]byte, 16)
+	uuidToBytes(b, u)
+	expected := []byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	if string(b) != string(expected) {
+		t.Errorf("UUID bytes % x instead of % x", b, expected)
+	}
+	if read := uuidFromBytes(b); read != u {
+		t.Errorf("UUID %s instead of %s after round trip", read, u)
+	}
+}
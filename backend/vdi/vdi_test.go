@@ -0,0 +1,209 @@
+package vdi_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/vdi"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		opts []vdi.CreateOpt
+		err  bool
+	}{
+		{"default", 10 * 1024 * 1024, nil, false},
+		{"fixed", 10 * 1024 * 1024, []vdi.CreateOpt{vdi.WithImageType(vdi.Fixed)}, false},
+		{"small blocks", 10 * 1024 * 1024, []vdi.CreateOpt{vdi.WithBlockSize(4096)}, false},
+		{"partial last block", 10*1024*1024 + 512, nil, false},
+		{"comment", 10 * 1024 * 1024, []vdi.CreateOpt{vdi.WithComment("development VM")}, false},
+		{"unaligned size", 10*1024*1024 + 1, nil, true},
+		{"invalid type", 10 * 1024 * 1024, []vdi.CreateOpt{vdi.WithImageType(3)}, true},
+		{"invalid block size", 10 * 1024 * 1024, []vdi.CreateOpt{vdi.WithBlockSize(1000)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := vdi.CreateFromPath(filepath.Join(t.TempDir(), "test.vdi"), tt.size, tt.opts...)
+			switch {
+			case tt.err && err == nil:
+				img.Close()
+				t.Fatalf("expected error, got none")
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case err != nil:
+				return
+			}
+			defer img.Close()
+			if img.Size() != tt.size {
+				t.Errorf("size %d instead of %d", img.Size(), tt.size)
+			}
+			info, err := img.Stat()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Size() != tt.size {
+				t.Errorf("stat size %d instead of %d", info.Size(), tt.size)
+			}
+			b := make([]byte, 4096)
+			if _, err := img.ReadAt(b, 0); err != nil {
+				t.Fatalf("error reading new image: %v", err)
+			}
+			if !bytes.Equal(b, make([]byte, len(b))) {
+				t.Errorf("new image is not empty")
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	size := int64(8 * 1024 * 1024)
+	for _, tt := range []struct {
+		name      string
+		opts      []vdi.CreateOpt
+		imageType vdi.ImageType
+	}{
+		{"fixed", []vdi.CreateOpt{vdi.WithImageType(vdi.Fixed)}, vdi.Fixed},
+		{"dynamic", nil, vdi.Dynamic},
+		{"dynamic small blocks", []vdi.CreateOpt{vdi.WithBlockSize(64 * 1024)}, vdi.Dynamic},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "test.vdi")
+			img, err := vdi.CreateFromPath(p, size, tt.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error creating image: %v", err)
+			}
+			if img.Type() != tt.imageType {
+				t.Errorf("type %d instead of %d", img.Type(), tt.imageType)
+			}
+			id := img.UUID()
+			expected := make([]byte, size)
+			for _, w := range []struct {
+				offset int64
+				length int
+			}{
+				{0, 512},
+				{1000, 100},                    // partial sectors
+				{1024*1024 - 100, 300},         // crosses a block boundary
+				{3 * 1024 * 1024, 1000000},     // many blocks
+				{3*1024*1024 + 700, 50},        // rewrite
+				{size - 512, 512},              // end of the disk
+				{5*1024*1024 + 1, 64*1024 + 3}, // unaligned start and end
+			} {
+				b := make([]byte, w.length)
+				_, _ = rand.Read(b)
+				n, err := img.WriteAt(b, w.offset)
+				if err != nil {
+					t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+				}
+				if n != w.length {
+					t.Fatalf("wrote %d bytes instead of %d", n, w.length)
+				}
+				copy(expected[w.offset:], b)
+			}
+			if _, err := img.WriteAt([]byte{1}, size); err == nil {
+				t.Errorf("expected error writing beyond end of disk")
+			}
+
+			check := func(img *vdi.Image) {
+				t.Helper()
+				b := make([]byte, size)
+				if _, err := img.ReadAt(b, 0); err != nil {
+					t.Fatalf("error reading image: %v", err)
+				}
+				if !bytes.Equal(b, expected) {
+					t.Errorf("mismatched image contents")
+				}
+				if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+					t.Errorf("expected io.EOF reading past the end, got %v", err)
+				}
+			}
+			check(img)
+			if err := img.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			img, err = vdi.OpenFromPath(p, true)
+			if err != nil {
+				t.Fatalf("error reopening image: %v", err)
+			}
+			defer img.Close()
+			check(img)
+			if img.UUID() != id {
+				t.Errorf("UUID %s instead of %s after reopening", img.UUID(), id)
+			}
+			if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+				t.Errorf("expected error writing to read-only image")
+			}
+		})
+	}
+}
+
+func TestDisk(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.vdi")
+	img, err := vdi.CreateFromPath(p, 20*1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(img, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 38000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from inside a VDI image")
+	f, err := fs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	img, err = vdi.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(img)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("file contents %q instead of %q", b, content)
+	}
+}
@@ -0,0 +1,130 @@
+package vhd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+type createOpts struct {
+	diskType  DiskType
+	blockSize uint32
+}
+
+// CreateOpt func that process Create options
+type CreateOpt func(o *createOpts) error
+
+// WithDiskType sets the type of the new image, either Fixed or Dynamic. Default is Dynamic.
+func WithDiskType(t DiskType) CreateOpt {
+	return func(o *createOpts) error {
+		if t != Fixed && t != Dynamic {
+			return fmt.Errorf("cannot create VHD image of type %d, must be Fixed or Dynamic", t)
+		}
+		o.diskType = t
+		return nil
+	}
+}
+
+// WithBlockSize sets the block size of a new dynamic image, which must be a power of 2
+// of at least 512 bytes. Default is DefaultBlockSize.
+func WithBlockSize(size uint32) CreateOpt {
+	return func(o *createOpts) error {
+		if size < sectorSize || size&(size-1) != 0 {
+			return fmt.Errorf("invalid block size %d, must be a power of 2 of at least %d", size, sectorSize)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// Create writes a new, empty VHD image with a virtual disk of the given size into the provided
+// backend.Storage, which must be writable, and should be empty. The size must be a multiple of 512;
+// Azure additionally requires fixed images whose size is a multiple of 1MB.
+func Create(b backend.Storage, size int64, opts ...CreateOpt) (*Image, error) {
+	if size <= 0 || size%sectorSize != 0 {
+		return nil, fmt.Errorf("must pass valid virtual disk size to create, a positive multiple of %d", sectorSize)
+	}
+	opt := &createOpts{diskType: Dynamic, blockSize: DefaultBlockSize}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	rw, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &footer{
+		features:      footerFeatures,
+		version:       footerVersion,
+		dataOffset:    noDataOffset,
+		timestamp:     timestampFor(util.Now()),
+		creatorVer:    creatorVersion,
+		creatorHostOS: creatorHostOS,
+		originalSize:  uint64(size),
+		currentSize:   uint64(size),
+		geometry:      geometryFor(size),
+		diskType:      opt.diskType,
+		uniqueID:      util.NewUUID(),
+	}
+	copy(f.creatorApp[:], creatorApplication)
+
+	if opt.diskType == Fixed {
+		// the virtual disk is left as a hole before the footer
+		if _, err := rw.WriteAt(f.toBytes(), size); err != nil {
+			return nil, fmt.Errorf("error writing VHD footer: %w", err)
+		}
+		return New(b, false)
+	}
+
+	// footer copy, dynamic disk header, block allocation table, then the footer; blocks are added before the footer
+	f.dataOffset = footerSize
+	h := &dynamicHeader{
+		tableOffset:     footerSize + dynamicHeaderSize,
+		version:         dynamicHeaderVersion,
+		maxTableEntries: uint32((size + int64(opt.blockSize) - 1) / int64(opt.blockSize)),
+		blockSize:       opt.blockSize,
+	}
+	bat := make([]byte, h.batSize())
+	for i := range bat {
+		bat[i] = 0xff
+	}
+	fb := f.toBytes()
+	for _, w := range []struct {
+		b      []byte
+		offset int64
+	}{
+		{fb, 0},
+		{h.toBytes(), footerSize},
+		{bat, int64(h.tableOffset)},
+		{fb, int64(h.tableOffset) + h.batSize()},
+	} {
+		if _, err := rw.WriteAt(w.b, w.offset); err != nil {
+			return nil, fmt.Errorf("error writing VHD metadata: %w", err)
+		}
+	}
+	return New(b, false)
+}
+
+// CreateFromPath creates a new, empty VHD image file with a virtual disk of the given size.
+// The provided file must not exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not create image %s: %w", pathName, err)
+	}
+	img, err := Create(file.New(f, false), size, opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not create VHD image %s: %w", pathName, err)
+	}
+	return img, nil
+}
@@ -0,0 +1,198 @@
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	dynamicHeaderSize           = 1024
+	dynamicHeaderCookie         = "cxsparse"
+	dynamicHeaderVersion        = 0x00010000
+	dynamicHeaderChecksumOffset = 36
+
+	// unusedBlock is the BAT entry of a block that has not been allocated
+	unusedBlock uint32 = 0xffffffff
+
+	// DefaultBlockSize is the default block size of dynamic images, as used by Hyper-V
+	DefaultBlockSize = 2 * 1024 * 1024
+)
+
+// dynamicHeader is the dynamic disk header of dynamic and differencing images
+type dynamicHeader struct {
+	tableOffset     uint64
+	version         uint32
+	maxTableEntries uint32
+	blockSize       uint32
+}
+
+func dynamicHeaderFromBytes(b []byte) (*dynamicHeader, error) {
+	if len(b) != dynamicHeaderSize {
+		return nil, fmt.Errorf("dynamic disk header was %d bytes instead of expected %d", len(b), dynamicHeaderSize)
+	}
+	if !bytes.Equal(b[0:8], []byte(dynamicHeaderCookie)) {
+		return nil, fmt.Errorf("invalid VHD dynamic disk header cookie %q", b[0:8])
+	}
+	if expected, actual := checksum(b, dynamicHeaderChecksumOffset), binary.BigEndian.Uint32(b[36:40]); expected != actual {
+		return nil, fmt.Errorf("invalid VHD dynamic disk header checksum %#x, expected %#x", actual, expected)
+	}
+	h := &dynamicHeader{
+		tableOffset:     binary.BigEndian.Uint64(b[16:24]),
+		version:         binary.BigEndian.Uint32(b[24:28]),
+		maxTableEntries: binary.BigEndian.Uint32(b[28:32]),
+		blockSize:       binary.BigEndian.Uint32(b[32:36]),
+	}
+	if h.blockSize < sectorSize || h.blockSize&(h.blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid VHD block size %d", h.blockSize)
+	}
+	return h, nil
+}
+
+func (h *dynamicHeader) toBytes() []byte {
+	b := make([]byte, dynamicHeaderSize)
+	copy(b[0:8], dynamicHeaderCookie)
+	binary.BigEndian.PutUint64(b[8:16], noDataOffset)
+	binary.BigEndian.PutUint64(b[16:24], h.tableOffset)
+	binary.BigEndian.PutUint32(b[24:28], h.version)
+	binary.BigEndian.PutUint32(b[28:32], h.maxTableEntries)
+	binary.BigEndian.PutUint32(b[32:36], h.blockSize)
+	binary.BigEndian.PutUint32(b[36:40], checksum(b, dynamicHeaderChecksumOffset))
+	return b
+}
+
+// bitmapSize size of the sector bitmap preceding each block, padded to a whole sector
+func (h *dynamicHeader) bitmapSize() int64 {
+	bits := int64(h.blockSize) / sectorSize
+	return (bits/8 + sectorSize - 1) &^ (sectorSize - 1)
+}
+
+// batSize size of the block allocation table, padded to a whole sector
+func (h *dynamicHeader) batSize() int64 {
+	return (int64(h.maxTableEntries)*4 + sectorSize - 1) &^ (sectorSize - 1)
+}
+
+// bitmap returns the sector bitmap of an allocated block
+func (img *Image) bitmap(block uint32) ([]byte, error) {
+	if b, ok := img.bitmapCache[block]; ok {
+		return b, nil
+	}
+	b := make([]byte, img.dynamic.bitmapSize())
+	if err := img.readFull(b, int64(img.bat[block])*sectorSize); err != nil {
+		return nil, fmt.Errorf("error reading bitmap of block %d: %w", block, err)
+	}
+	if len(img.bitmapCache) >= maxCachedBitmaps {
+		clear(img.bitmapCache)
+	}
+	img.bitmapCache[block] = b
+	return b, nil
+}
+
+// sectorPresent whether a sector in a block is stored in the image; the most significant bit is the first sector
+func sectorPresent(bitmap []byte, sector int64) bool {
+	return bitmap[sector/8]&(0x80>>(sector%8)) != 0
+}
+
+// readBlock reads part of a single block of a dynamic image, which must not cross a block boundary
+func (img *Image) readBlock(b []byte, virtual int64) error {
+	blockSize := int64(img.dynamic.blockSize)
+	block := uint32(virtual / blockSize)
+	inBlock := virtual % blockSize
+	if img.bat[block] == unusedBlock {
+		clear(b)
+		return nil
+	}
+	bitmap, err := img.bitmap(block)
+	if err != nil {
+		return err
+	}
+	dataOffset := int64(img.bat[block])*sectorSize + img.dynamic.bitmapSize()
+	if err := img.readFull(b, dataOffset+inBlock); err != nil {
+		return fmt.Errorf("error reading block %d: %w", block, err)
+	}
+	// sectors that are not present read as zero
+	for pos := int64(0); pos < int64(len(b)); {
+		sector := (inBlock + pos) / sectorSize
+		end := min((sector+1)*sectorSize-inBlock, int64(len(b)))
+		if !sectorPresent(bitmap, sector) {
+			clear(b[pos:end])
+		}
+		pos = end
+	}
+	return nil
+}
+
+// writeBlock writes part of a single block of a dynamic image, which must not cross a block boundary,
+// allocating the block if needed
+func (img *Image) writeBlock(b []byte, virtual int64) error {
+	blockSize := int64(img.dynamic.blockSize)
+	block := uint32(virtual / blockSize)
+	inBlock := virtual % blockSize
+	if img.bat[block] == unusedBlock {
+		if err := img.allocBlock(block); err != nil {
+			return err
+		}
+	}
+	bitmap, err := img.bitmap(block)
+	if err != nil {
+		return err
+	}
+
+	// write whole sectors, so that sectors that become present do not have stale contents
+	first := inBlock / sectorSize
+	last := (inBlock + int64(len(b)) - 1) / sectorSize
+	data := make([]byte, (last-first+1)*sectorSize)
+	if inBlock%sectorSize != 0 {
+		if err := img.readBlock(data[:sectorSize], virtual-inBlock+first*sectorSize); err != nil {
+			return err
+		}
+	}
+	if (inBlock+int64(len(b)))%sectorSize != 0 {
+		if err := img.readBlock(data[len(data)-sectorSize:], virtual-inBlock+last*sectorSize); err != nil {
+			return err
+		}
+	}
+	copy(data[inBlock%sectorSize:], b)
+	dataOffset := int64(img.bat[block])*sectorSize + img.dynamic.bitmapSize()
+	if err := img.writeFull(data, dataOffset+first*sectorSize); err != nil {
+		return fmt.Errorf("error writing block %d: %w", block, err)
+	}
+
+	changed := false
+	for sector := first; sector <= last; sector++ {
+		if !sectorPresent(bitmap, sector) {
+			bitmap[sector/8] |= 0x80 >> (sector % 8)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	start, end := first/8, last/8+1
+	if err := img.writeFull(bitmap[start:end], int64(img.bat[block])*sectorSize+start); err != nil {
+		return fmt.Errorf("error writing bitmap of block %d: %w", block, err)
+	}
+	return nil
+}
+
+// allocBlock allocates a block at the end of the image, where the footer is, and moves the footer after it
+func (img *Image) allocBlock(block uint32) error {
+	offset := img.dataEnd
+	blockEnd := offset + img.dynamic.bitmapSize() + int64(img.dynamic.blockSize)
+	// the bitmap must start out empty, the data may be left as a hole
+	if err := img.writeFull(make([]byte, img.dynamic.bitmapSize()), offset); err != nil {
+		return fmt.Errorf("error writing bitmap of block %d: %w", block, err)
+	}
+	if err := img.writeFull(img.footer.toBytes(), blockEnd); err != nil {
+		return fmt.Errorf("error writing footer: %w", err)
+	}
+	img.dataEnd = blockEnd
+	entry := make([]byte, 4)
+	binary.BigEndian.PutUint32(entry, uint32(offset/sectorSize))
+	if err := img.writeFull(entry, int64(img.dynamic.tableOffset)+int64(block)*4); err != nil {
+		return fmt.Errorf("error updating block allocation table: %w", err)
+	}
+	img.bat[block] = uint32(offset / sectorSize)
+	img.bitmapCache[block] = make([]byte, img.dynamic.bitmapSize())
+	return nil
+}
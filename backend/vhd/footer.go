@@ -0,0 +1,172 @@
+package vhd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	sectorSize = 512
+	footerSize = 512
+
+	footerCookie         = "conectix"
+	footerFeatures       = 0x00000002 // the reserved bit, which must always be set
+	footerVersion        = 0x00010000
+	footerChecksumOffset = 64
+	// the data offset of a fixed disk, which has no dynamic disk header
+	noDataOffset uint64 = 0xffffffffffffffff
+
+	creatorApplication = "dfs "
+	creatorVersion     = 0x00010000
+	creatorHostOS      = 0x5769326b // "Wi2k"
+
+	// maximum total sectors that can be described by CHS geometry, 65535 cylinders with 16 heads and 255 sectors each
+	maxGeometrySectors = 65535 * 16 * 255
+)
+
+// DiskType is the type of VHD image
+type DiskType uint32
+
+const (
+	// Fixed images store the whole virtual disk uncompressed, followed by the footer
+	Fixed DiskType = 2
+	// Dynamic images only store the blocks of the virtual disk that have been written
+	Dynamic DiskType = 3
+	// Differencing images store the changes to a parent image, and are not supported
+	Differencing DiskType = 4
+)
+
+// vhdEpoch is the start of VHD timestamps
+var vhdEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// geometry is the CHS geometry of the disk, as recorded in the footer
+type geometry struct {
+	cylinders       uint16
+	heads           uint8
+	sectorsPerTrack uint8
+}
+
+// footer is the hard disk footer at the end of every VHD image, and at the start of dynamic images
+type footer struct {
+	features      uint32
+	version       uint32
+	dataOffset    uint64
+	timestamp     uint32
+	creatorApp    [4]byte
+	creatorVer    uint32
+	creatorHostOS uint32
+	originalSize  uint64
+	currentSize   uint64
+	geometry      geometry
+	diskType      DiskType
+	uniqueID      uuid.UUID
+	savedState    uint8
+}
+
+// checksum is the one's complement of the sum of all bytes, skipping the checksum field itself
+func checksum(b []byte, checksumOffset int) uint32 {
+	var sum uint32
+	for i, c := range b {
+		if i >= checksumOffset && i < checksumOffset+4 {
+			continue
+		}
+		sum += uint32(c)
+	}
+	return ^sum
+}
+
+func footerFromBytes(b []byte) (*footer, error) {
+	if len(b) != footerSize {
+		return nil, fmt.Errorf("footer was %d bytes instead of expected %d", len(b), footerSize)
+	}
+	if !bytes.Equal(b[0:8], []byte(footerCookie)) {
+		return nil, fmt.Errorf("invalid VHD footer cookie %q", b[0:8])
+	}
+	if expected, actual := checksum(b, footerChecksumOffset), binary.BigEndian.Uint32(b[64:68]); expected != actual {
+		return nil, fmt.Errorf("invalid VHD footer checksum %#x, expected %#x", actual, expected)
+	}
+	f := &footer{
+		features:      binary.BigEndian.Uint32(b[8:12]),
+		version:       binary.BigEndian.Uint32(b[12:16]),
+		dataOffset:    binary.BigEndian.Uint64(b[16:24]),
+		timestamp:     binary.BigEndian.Uint32(b[24:28]),
+		creatorVer:    binary.BigEndian.Uint32(b[32:36]),
+		creatorHostOS: binary.BigEndian.Uint32(b[36:40]),
+		originalSize:  binary.BigEndian.Uint64(b[40:48]),
+		currentSize:   binary.BigEndian.Uint64(b[48:56]),
+		geometry: geometry{
+			cylinders:       binary.BigEndian.Uint16(b[56:58]),
+			heads:           b[58],
+			sectorsPerTrack: b[59],
+		},
+		diskType:   DiskType(binary.BigEndian.Uint32(b[60:64])),
+		savedState: b[84],
+	}
+	copy(f.creatorApp[:], b[28:32])
+	copy(f.uniqueID[:], b[68:84])
+	if f.version>>16 != footerVersion>>16 {
+		return nil, fmt.Errorf("unsupported VHD version %#x", f.version)
+	}
+	return f, nil
+}
+
+func (f *footer) toBytes() []byte {
+	b := make([]byte, footerSize)
+	copy(b[0:8], footerCookie)
+	binary.BigEndian.PutUint32(b[8:12], f.features)
+	binary.BigEndian.PutUint32(b[12:16], f.version)
+	binary.BigEndian.PutUint64(b[16:24], f.dataOffset)
+	binary.BigEndian.PutUint32(b[24:28], f.timestamp)
+	copy(b[28:32], f.creatorApp[:])
+	binary.BigEndian.PutUint32(b[32:36], f.creatorVer)
+	binary.BigEndian.PutUint32(b[36:40], f.creatorHostOS)
+	binary.BigEndian.PutUint64(b[40:48], f.originalSize)
+	binary.BigEndian.PutUint64(b[48:56], f.currentSize)
+	binary.BigEndian.PutUint16(b[56:58], f.geometry.cylinders)
+	b[58] = f.geometry.heads
+	b[59] = f.geometry.sectorsPerTrack
+	binary.BigEndian.PutUint32(b[60:64], uint32(f.diskType))
+	copy(b[68:84], f.uniqueID[:])
+	b[84] = f.savedState
+	binary.BigEndian.PutUint32(b[64:68], checksum(b, footerChecksumOffset))
+	return b
+}
+
+// geometryFor calculates the CHS geometry for a disk size, using the algorithm from the VHD specification
+func geometryFor(size int64) geometry {
+	totalSectors := min(size/sectorSize, maxGeometrySectors)
+	var sectorsPerTrack, heads, cylinderTimesHeads int64
+	if totalSectors >= 65535*16*63 {
+		sectorsPerTrack = 255
+		heads = 16
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+	} else {
+		sectorsPerTrack = 17
+		cylinderTimesHeads = totalSectors / sectorsPerTrack
+		heads = max((cylinderTimesHeads+1023)/1024, 4)
+		if cylinderTimesHeads >= heads*1024 || heads > 16 {
+			sectorsPerTrack = 31
+			heads = 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+		if cylinderTimesHeads >= heads*1024 {
+			sectorsPerTrack = 63
+			heads = 16
+			cylinderTimesHeads = totalSectors / sectorsPerTrack
+		}
+	}
+	return geometry{
+		cylinders:       uint16(cylinderTimesHeads / heads),
+		heads:           uint8(heads),
+		sectorsPerTrack: uint8(sectorsPerTrack),
+	}
+}
+
+// timestampFor converts a time to a VHD timestamp, the seconds since the start of the year 2000 UTC
+func timestampFor(t time.Time) uint32 {
+	return uint32(t.Sub(vhdEpoch) / time.Second)
+}
@@ -0,0 +1,315 @@
+// Package vhd provides a backend for disk images in the Microsoft Virtual Hard Disk (VHD) format,
+// as used by Hyper-V, Virtual PC and Azure.
+//
+// Fixed images store the virtual disk as-is followed by a footer, and are what Azure requires.
+// Dynamic images only store the blocks that have been written, tracked by the block allocation table,
+// with a bitmap per block of which sectors are present. Differencing images are not supported.
+package vhd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// maximum number of cached block bitmaps
+const maxCachedBitmaps = 1024
+
+// Image is a VHD image presented as a backend.Storage of the size of its virtual disk
+type Image struct {
+	storage  backend.Storage
+	rw       backend.WritableFile
+	readOnly bool
+	footer   *footer
+
+	// only for dynamic images
+	dynamic     *dynamicHeader
+	bat         []uint32
+	bitmapCache map[uint32][]byte
+	// dataEnd is the end of the allocated blocks, where the footer is written
+	dataEnd int64
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the VHD image stored in the provided backend.Storage.
+// If readOnly is false, the storage must be writable.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	img := &Image{
+		storage:     b,
+		readOnly:    readOnly,
+		bitmapCache: map[uint32][]byte{},
+	}
+	if !readOnly {
+		rw, err := b.Writable()
+		if err != nil {
+			return nil, err
+		}
+		img.rw = rw
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing VHD image file
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, readOnly), readOnly)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open VHD image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init() error {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat VHD image: %w", err)
+	}
+	fileSize := info.Size()
+	if fileSize < footerSize {
+		return fmt.Errorf("image of %d bytes is too small for a VHD footer", fileSize)
+	}
+	b := make([]byte, footerSize)
+	if err := img.readFull(b, fileSize-footerSize); err != nil {
+		return fmt.Errorf("error reading VHD footer: %w", err)
+	}
+	f, err := footerFromBytes(b)
+	if err != nil {
+		// the footer at the end may be damaged, dynamic images have a copy at the start
+		if err := img.readFull(b, 0); err != nil {
+			return fmt.Errorf("error reading VHD footer copy: %w", err)
+		}
+		var copyErr error
+		if f, copyErr = footerFromBytes(b); copyErr != nil || f.diskType != Dynamic {
+			return err
+		}
+	}
+	img.footer = f
+	img.dataEnd = fileSize - footerSize
+
+	switch f.diskType {
+	case Fixed:
+		if int64(f.currentSize) > img.dataEnd {
+			return fmt.Errorf("fixed VHD image of %d bytes is too small for virtual size %d", fileSize, f.currentSize)
+		}
+		return nil
+	case Dynamic:
+	case Differencing:
+		return errors.New("differencing VHD images are not supported")
+	default:
+		return fmt.Errorf("unknown VHD disk type %d", f.diskType)
+	}
+
+	b = make([]byte, dynamicHeaderSize)
+	if err := img.readFull(b, int64(f.dataOffset)); err != nil {
+		return fmt.Errorf("error reading VHD dynamic disk header: %w", err)
+	}
+	if img.dynamic, err = dynamicHeaderFromBytes(b); err != nil {
+		return err
+	}
+	blocks := (int64(f.currentSize) + int64(img.dynamic.blockSize) - 1) / int64(img.dynamic.blockSize)
+	if int64(img.dynamic.maxTableEntries) < blocks {
+		return fmt.Errorf("block allocation table has %d entries, but the virtual size %d requires %d", img.dynamic.maxTableEntries, f.currentSize, blocks)
+	}
+	b = make([]byte, int64(img.dynamic.maxTableEntries)*4)
+	if err := img.readFull(b, int64(img.dynamic.tableOffset)); err != nil {
+		return fmt.Errorf("error reading VHD block allocation table: %w", err)
+	}
+	img.bat = make([]uint32, img.dynamic.maxTableEntries)
+	for i := range img.bat {
+		img.bat[i] = binary.BigEndian.Uint32(b[i*4 : i*4+4])
+	}
+	return nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, treating data past the end of the image as zero
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(b[n:])
+	return nil
+}
+
+func (img *Image) writeFull(b []byte, offset int64) error {
+	n, err := img.rw.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// Size is the size of the virtual disk
+func (img *Image) Size() int64 {
+	return int64(img.footer.currentSize)
+}
+
+// Type is the type of the image, Fixed or Dynamic
+func (img *Image) Type() DiskType {
+	return img.footer.diskType
+}
+
+// ReadAt reads from the virtual disk
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	return img.readAt(p, off)
+}
+
+func (img *Image) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	if img.dynamic == nil {
+		if err := img.readFull(p, off); err != nil {
+			return 0, err
+		}
+		return len(p), eof
+	}
+	blockSize := int64(img.dynamic.blockSize)
+	read := 0
+	for read < len(p) {
+		virtual := off + int64(read)
+		chunk := int(min(int64(len(p)-read), blockSize-virtual%blockSize))
+		if err := img.readBlock(p[read:read+chunk], virtual); err != nil {
+			return read, err
+		}
+		read += chunk
+	}
+	return read, eof
+}
+
+// WriteAt writes to the virtual disk, allocating blocks in dynamic images as needed
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > img.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the virtual disk of size %d", len(p), off, img.Size())
+	}
+	if img.dynamic == nil {
+		return img.rw.WriteAt(p, off)
+	}
+	blockSize := int64(img.dynamic.blockSize)
+	written := 0
+	for written < len(p) {
+		virtual := off + int64(written)
+		chunk := int(min(int64(len(p)-written), blockSize-virtual%blockSize))
+		if err := img.writeBlock(p[written:written+chunk], virtual); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// Stat returns the file info of the image, but with the size of the virtual disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the virtual disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.readAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the virtual disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the image file
+func (img *Image) Sync() error {
+	return backend.Sync(img.storage)
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for VHD images, as ioctls on the image file do not apply to the virtual disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the virtual disk instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,70 @@
+package vhd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeometryFor(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected geometry
+	}{
+		{10 * 1024 * 1024, geometry{cylinders: 301, heads: 4, sectorsPerTrack: 17}},
+		{1024 * 1024 * 1024, geometry{cylinders: 2080, heads: 16, sectorsPerTrack: 63}},
+		{40 * 1024 * 1024 * 1024, geometry{cylinders: 20560, heads: 16, sectorsPerTrack: 255}},
+		// beyond what CHS can describe
+		{4 * 1024 * 1024 * 1024 * 1024, geometry{cylinders: 65535, heads: 16, sectorsPerTrack: 255}},
+	}
+	for _, tt := range tests {
+		if g := geometryFor(tt.size); g != tt.expected {
+			t.Errorf("size %d: geometry %+v instead of %+v", tt.size, g, tt.expected)
+		}
+	}
+}
+
+func TestFooter(t *testing.T) {
+	f := &footer{
+		features:     footerFeatures,
+		version:      footerVersion,
+		dataOffset:   noDataOffset,
+		timestamp:    timestampFor(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)),
+		creatorApp:   [4]byte{'t', 'e', 's', 't'},
+		originalSize: 1024 * 1024,
+		currentSize:  1024 * 1024,
+		geometry:     geometryFor(1024 * 1024),
+		diskType:     Fixed,
+	}
+	b := f.toBytes()
+	if string(b[0:8]) != footerCookie {
+		t.Errorf("invalid cookie %q", b[0:8])
+	}
+	read, err := footerFromBytes(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *read != *f {
+		t.Errorf("mismatched footer after round trip, got %+v, expected %+v", read, f)
+	}
+	b[100] = 1
+	if _, err := footerFromBytes(b); err == nil {
+		t.Errorf("expected checksum error for modified footer")
+	}
+}
+
+func TestDynamicHeader(t *testing.T) {
+	h := &dynamicHeader{tableOffset: 1536, version: dynamicHeaderVersion, maxTableEntries: 100, blockSize: DefaultBlockSize}
+	read, err := dynamicHeaderFromBytes(h.toBytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *read != *h {
+		t.Errorf("mismatched header after round trip, got %+v, expected %+v", read, h)
+	}
+	if h.bitmapSize() != 512 {
+		t.Errorf("bitmap size %d instead of 512", h.bitmapSize())
+	}
+	if h.batSize() != 512 {
+		t.Errorf("BAT size %d instead of 512", h.batSize())
+	}
+}
@@ -0,0 +1,220 @@
+package vhd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/vhd"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		opts []vhd.CreateOpt
+		err  bool
+	}{
+		{"default", 10 * 1024 * 1024, nil, false},
+		{"fixed", 10 * 1024 * 1024, []vhd.CreateOpt{vhd.WithDiskType(vhd.Fixed)}, false},
+		{"small blocks", 10 * 1024 * 1024, []vhd.CreateOpt{vhd.WithBlockSize(4096)}, false},
+		{"unaligned size", 10*1024*1024 + 1, nil, true},
+		{"invalid type", 10 * 1024 * 1024, []vhd.CreateOpt{vhd.WithDiskType(vhd.Differencing)}, true},
+		{"invalid block size", 10 * 1024 * 1024, []vhd.CreateOpt{vhd.WithBlockSize(1000)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := vhd.CreateFromPath(filepath.Join(t.TempDir(), "test.vhd"), tt.size, tt.opts...)
+			switch {
+			case tt.err && err == nil:
+				img.Close()
+				t.Fatalf("expected error, got none")
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case err != nil:
+				return
+			}
+			defer img.Close()
+			if img.Size() != tt.size {
+				t.Errorf("size %d instead of %d", img.Size(), tt.size)
+			}
+			info, err := img.Stat()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Size() != tt.size {
+				t.Errorf("stat size %d instead of %d", info.Size(), tt.size)
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	size := int64(8 * 1024 * 1024)
+	for _, tt := range []struct {
+		name     string
+		opts     []vhd.CreateOpt
+		diskType vhd.DiskType
+	}{
+		{"fixed", []vhd.CreateOpt{vhd.WithDiskType(vhd.Fixed)}, vhd.Fixed},
+		{"dynamic", nil, vhd.Dynamic},
+		{"dynamic small blocks", []vhd.CreateOpt{vhd.WithBlockSize(64 * 1024)}, vhd.Dynamic},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "test.vhd")
+			img, err := vhd.CreateFromPath(p, size, tt.opts...)
+			if err != nil {
+				t.Fatalf("unexpected error creating image: %v", err)
+			}
+			if img.Type() != tt.diskType {
+				t.Errorf("type %d instead of %d", img.Type(), tt.diskType)
+			}
+			expected := make([]byte, size)
+			for _, w := range []struct {
+				offset int64
+				length int
+			}{
+				{0, 512},
+				{1000, 100},                    // partial sectors
+				{2*1024*1024 - 100, 300},       // crosses a block boundary
+				{3 * 1024 * 1024, 1000000},     // many blocks
+				{3*1024*1024 + 700, 50},        // rewrite
+				{size - 512, 512},              // end of the disk
+				{5*1024*1024 + 1, 64*1024 + 3}, // unaligned start and end
+			} {
+				b := make([]byte, w.length)
+				_, _ = rand.Read(b)
+				n, err := img.WriteAt(b, w.offset)
+				if err != nil {
+					t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+				}
+				if n != w.length {
+					t.Fatalf("wrote %d bytes instead of %d", n, w.length)
+				}
+				copy(expected[w.offset:], b)
+			}
+			if _, err := img.WriteAt([]byte{1}, size); err == nil {
+				t.Errorf("expected error writing beyond end of disk")
+			}
+
+			check := func(img *vhd.Image) {
+				t.Helper()
+				b := make([]byte, size)
+				if _, err := img.ReadAt(b, 0); err != nil {
+					t.Fatalf("error reading image: %v", err)
+				}
+				if !bytes.Equal(b, expected) {
+					t.Errorf("mismatched image contents")
+				}
+				if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+					t.Errorf("expected io.EOF reading past the end, got %v", err)
+				}
+			}
+			check(img)
+			if err := img.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			img, err = vhd.OpenFromPath(p, true)
+			if err != nil {
+				t.Fatalf("error reopening image: %v", err)
+			}
+			defer img.Close()
+			check(img)
+			if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+				t.Errorf("expected error writing to read-only image")
+			}
+
+			info, err := os.Stat(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.diskType == vhd.Fixed && info.Size() != size+512 {
+				t.Errorf("fixed image file is %d bytes instead of %d", info.Size(), size+512)
+			}
+			if info.Size()%512 != 0 {
+				t.Errorf("image file size %d is not a multiple of 512", info.Size())
+			}
+			// the footer is always in the last sector
+			f, err := os.Open(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			cookie := make([]byte, 8)
+			if _, err := f.ReadAt(cookie, info.Size()-512); err != nil {
+				t.Fatal(err)
+			}
+			if string(cookie) != "conectix" {
+				t.Errorf("no footer at end of image, found %q", cookie)
+			}
+		})
+	}
+}
+
+func TestDisk(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.vhd")
+	img, err := vhd.CreateFromPath(p, 20*1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	d, err := diskfs.OpenBackend(img, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 38000, Type: mbr.Fat32LBA},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	content := []byte("hello from inside a VHD image")
+	f, err := fs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("error closing disk: %v", err)
+	}
+
+	img, err = vhd.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	d, err = diskfs.OpenBackend(img)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	defer d.Close()
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err = fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("file contents %q instead of %q", b, content)
+	}
+}
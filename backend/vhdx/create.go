@@ -0,0 +1,150 @@
+package vhdx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// DefaultBlockSize is the default payload block size, as used by Hyper-V
+	DefaultBlockSize = 32 * mb
+	// MaxSize is the maximum virtual disk size of a VHDX image
+	MaxSize = 64 * 1024 * 1024 * mb
+
+	defaultLogicalSectorSize  = 512
+	defaultPhysicalSectorSize = 4096
+
+	creator = "go-diskfs"
+
+	// fixed layout of a new image, after the headers and region tables
+	createLogOffset      = 1 * mb
+	createLogLength      = 1 * mb
+	createMetadataOffset = 2 * mb
+	createMetadataLength = 1 * mb
+	createBATOffset      = 3 * mb
+)
+
+type createOpts struct {
+	blockSize          uint32
+	logicalSectorSize  uint32
+	physicalSectorSize uint32
+}
+
+// CreateOpt func that process Create options
+type CreateOpt func(o *createOpts) error
+
+// WithBlockSize sets the payload block size of the new image, which must be a power of 2
+// between 1MB and 256MB. Default is DefaultBlockSize.
+func WithBlockSize(size uint32) CreateOpt {
+	return func(o *createOpts) error {
+		if size < mb || size > 256*mb || size&(size-1) != 0 {
+			return fmt.Errorf("invalid block size %d, must be a power of 2 between %d and %d", size, mb, 256*mb)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// WithSectorSize sets the logical and physical sector sizes of the new virtual disk, each of
+// which must be 512 or 4096. Default is 512 logical and 4096 physical, as with Hyper-V.
+func WithSectorSize(logical, physical uint32) CreateOpt {
+	return func(o *createOpts) error {
+		for _, size := range []uint32{logical, physical} {
+			if size != 512 && size != 4096 {
+				return fmt.Errorf("invalid sector size %d, must be 512 or 4096", size)
+			}
+		}
+		o.logicalSectorSize = logical
+		o.physicalSectorSize = physical
+		return nil
+	}
+}
+
+// Create writes a new, empty VHDX image with a virtual disk of the given size into the provided
+// backend.Storage, which must be writable, and should be empty. The size must be a multiple of the
+// logical sector size.
+func Create(b backend.Storage, size int64, opts ...CreateOpt) (*Image, error) {
+	opt := &createOpts{
+		blockSize:          DefaultBlockSize,
+		logicalSectorSize:  defaultLogicalSectorSize,
+		physicalSectorSize: defaultPhysicalSectorSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	if size <= 0 || size > MaxSize || size%int64(opt.logicalSectorSize) != 0 {
+		return nil, fmt.Errorf("must pass valid virtual disk size to create, a positive multiple of %d up to %d", opt.logicalSectorSize, int64(MaxSize))
+	}
+	rw, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &parameters{
+		blockSize:          opt.blockSize,
+		virtualDiskSize:    uint64(size),
+		virtualDiskID:      util.NewUUID(),
+		logicalSectorSize:  opt.logicalSectorSize,
+		physicalSectorSize: opt.physicalSectorSize,
+	}
+	batLength := max(alignMB(params.batEntries()*8), mb)
+	h := &header{
+		fileWriteGUID: util.NewUUID(),
+		dataWriteGUID: util.NewUUID(),
+		version:       headerVersion,
+		logVersion:    logVersion,
+		logLength:     createLogLength,
+		logOffset:     createLogOffset,
+	}
+	h2 := *h
+	h2.sequenceNumber = 1
+	regions := regionTableToBytes([]region{
+		{guid: batRegionGUID, offset: createBATOffset, length: uint32(batLength), required: true},
+		{guid: metadataRegionGUID, offset: createMetadataOffset, length: createMetadataLength, required: true},
+	})
+
+	// the log is left empty, as it is only read when the header has a log GUID;
+	// the BAT is written in full, with every block not present
+	for _, w := range []struct {
+		b      []byte
+		offset int64
+	}{
+		{fileIdentifier(creator), fileIdentifierOffset},
+		{h.toBytes(), header1Offset},
+		{h2.toBytes(), header2Offset},
+		{regions, regionTable1Offset},
+		{regions, regionTable2Offset},
+		{params.toMetadata(createMetadataLength), createMetadataOffset},
+		{make([]byte, batLength), createBATOffset},
+	} {
+		if _, err := rw.WriteAt(w.b, w.offset); err != nil {
+			return nil, fmt.Errorf("error writing VHDX metadata: %w", err)
+		}
+	}
+	return New(b, false)
+}
+
+// CreateFromPath creates a new, empty VHDX image file with a virtual disk of the given size.
+// The provided file must not exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not create image %s: %w", pathName, err)
+	}
+	img, err := Create(file.New(f, false), size, opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not create VHDX image %s: %w", pathName, err)
+	}
+	return img, nil
+}
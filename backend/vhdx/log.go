@@ -0,0 +1,176 @@
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	logEntrySignature       = "loge"
+	dataDescriptorSignature = "desc"
+	zeroDescriptorSignature = "zero"
+	dataSectorSignature     = "data"
+
+	logSectorSize      = 4 * kb
+	logEntryHeaderSize = 64
+	logDescriptorSize  = 32
+)
+
+// logEntry is a parsed entry of the log, which records metadata updates so they can be
+// replayed if the image was not closed cleanly
+type logEntry struct {
+	sequenceNumber    uint64
+	tail              uint32
+	length            uint32
+	lastFileOffset    uint64
+	flushedFileOffset uint64
+	writes            []logWrite
+}
+
+// logWrite is a single update recorded in a log entry; zero writes have no data
+type logWrite struct {
+	offset uint64
+	data   []byte
+	zero   uint64
+}
+
+// readLogEntry parses the entry at offset in the log, returning nil if there is no valid entry there
+func readLogEntry(log []byte, offset uint32, logGUID uuid.UUID) *logEntry {
+	size := uint32(len(log))
+	// entries may wrap around the end of the circular log
+	at := func(off, length uint32) []byte {
+		b := make([]byte, length)
+		for i := uint32(0); i < length; {
+			start := (off + i) % size
+			i += uint32(copy(b[i:], log[start:min(size, start+length-i)]))
+		}
+		return b
+	}
+	if offset%logSectorSize != 0 || offset >= size {
+		return nil
+	}
+	h := at(offset, logEntryHeaderSize)
+	if !bytes.Equal(h[0:4], []byte(logEntrySignature)) {
+		return nil
+	}
+	length := binary.LittleEndian.Uint32(h[8:12])
+	if length == 0 || length%logSectorSize != 0 || length > size {
+		return nil
+	}
+	b := at(offset, length)
+	if !validChecksum(b) || guidFromBytes(b[32:48]) != logGUID {
+		return nil
+	}
+	e := &logEntry{
+		length:            length,
+		tail:              binary.LittleEndian.Uint32(b[12:16]),
+		sequenceNumber:    binary.LittleEndian.Uint64(b[16:24]),
+		flushedFileOffset: binary.LittleEndian.Uint64(b[48:56]),
+		lastFileOffset:    binary.LittleEndian.Uint64(b[56:64]),
+	}
+	count := binary.LittleEndian.Uint32(b[24:28])
+	descriptorsEnd := uint64(logEntryHeaderSize) + uint64(count)*logDescriptorSize
+	if descriptorsEnd > uint64(length) {
+		return nil
+	}
+	dataSector := (uint32(descriptorsEnd) + logSectorSize - 1) &^ (logSectorSize - 1)
+	for i := uint32(0); i < count; i++ {
+		d := b[logEntryHeaderSize+i*logDescriptorSize : logEntryHeaderSize+(i+1)*logDescriptorSize]
+		if binary.LittleEndian.Uint64(d[24:32]) != e.sequenceNumber {
+			return nil
+		}
+		switch string(d[0:4]) {
+		case zeroDescriptorSignature:
+			e.writes = append(e.writes, logWrite{
+				zero:   binary.LittleEndian.Uint64(d[8:16]),
+				offset: binary.LittleEndian.Uint64(d[16:24]),
+			})
+		case dataDescriptorSignature:
+			if dataSector+logSectorSize > length {
+				return nil
+			}
+			s := b[dataSector : dataSector+logSectorSize]
+			if !bytes.Equal(s[0:4], []byte(dataSectorSignature)) ||
+				uint64(binary.LittleEndian.Uint32(s[4:8]))<<32|uint64(binary.LittleEndian.Uint32(s[4092:4096])) != e.sequenceNumber {
+				return nil
+			}
+			// the first 8 and last 4 bytes of the sector are in the descriptor, as the data sector uses them
+			// for its signature and sequence number
+			data := make([]byte, logSectorSize)
+			copy(data[0:8], d[8:16])
+			copy(data[8:4092], s[8:4092])
+			copy(data[4092:4096], d[4:8])
+			e.writes = append(e.writes, logWrite{
+				offset: binary.LittleEndian.Uint64(d[16:24]),
+				data:   data,
+			})
+			dataSector += logSectorSize
+		default:
+			return nil
+		}
+	}
+	return e
+}
+
+// activeLogSequence finds the sequence of log entries that must be replayed: the valid sequence with the
+// highest sequence number, ordered from its tail to its head. It returns nil if there is none.
+func activeLogSequence(log []byte, logGUID uuid.UUID) []*logEntry {
+	size := uint32(len(log))
+	var active []*logEntry
+	for start := uint32(0); start < size; start += logSectorSize {
+		first := readLogEntry(log, start, logGUID)
+		if first == nil {
+			continue
+		}
+		sequence := []*logEntry{first}
+		offsets := []uint32{start}
+		offset := start
+		for {
+			offset = (offset + sequence[len(sequence)-1].length) % size
+			if offset == start {
+				break
+			}
+			next := readLogEntry(log, offset, logGUID)
+			if next == nil || next.sequenceNumber != sequence[len(sequence)-1].sequenceNumber+1 {
+				break
+			}
+			sequence = append(sequence, next)
+			offsets = append(offsets, offset)
+		}
+		// the sequence is only complete if the tail of its head entry is within it
+		head := sequence[len(sequence)-1]
+		tailIndex := -1
+		for i, o := range offsets {
+			if o == head.tail {
+				tailIndex = i
+				break
+			}
+		}
+		if tailIndex < 0 {
+			continue
+		}
+		if active == nil || head.sequenceNumber > active[len(active)-1].sequenceNumber {
+			active = sequence[tailIndex:]
+		}
+	}
+	return active
+}
+
+// replayLog applies the active sequence of the log with apply, returning the minimum size of the image file
+func replayLog(log []byte, logGUID uuid.UUID, apply func(w logWrite) error) (uint64, error) {
+	sequence := activeLogSequence(log, logGUID)
+	if sequence == nil {
+		return 0, fmt.Errorf("no valid log sequence found for log %s", logGUID)
+	}
+	for _, e := range sequence {
+		for _, w := range e.writes {
+			if err := apply(w); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return sequence[len(sequence)-1].lastFileOffset, nil
+}
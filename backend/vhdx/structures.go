@@ -0,0 +1,418 @@
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+)
+
+const (
+	kb = 1024
+	mb = 1024 * kb
+
+	fileIdentifierSignature = "vhdxfile"
+	headerSignature         = "head"
+	regionTableSignature    = "regi"
+	metadataTableSignature  = "metadata"
+
+	fileIdentifierOffset = 0
+	header1Offset        = 64 * kb
+	header2Offset        = 128 * kb
+	regionTable1Offset   = 192 * kb
+	regionTable2Offset   = 256 * kb
+
+	headerSize          = 4 * kb
+	regionTableSize     = 64 * kb
+	metadataTableSize   = 64 * kb
+	regionEntrySize     = 32
+	metadataEntrySize   = 32
+	maxRegionEntries    = 2047
+	maxMetadataEntries  = 2047
+	headerVersion       = 1
+	logVersion          = 0
+	checksumFieldOffset = 4
+)
+
+// region and metadata item identifiers
+var (
+	batRegionGUID      = uuid.MustParse("2DC27766-F623-4200-9D64-115E9BFD4A08")
+	metadataRegionGUID = uuid.MustParse("8B7CA206-4790-4B9A-B8FE-575F050F886E")
+
+	fileParametersGUID     = uuid.MustParse("CAA16737-FA36-4D43-B3B6-33F0AA44E76B")
+	virtualDiskSizeGUID    = uuid.MustParse("2FA54224-CD1B-4876-B211-5DBED83BF4B8")
+	virtualDiskIDGUID      = uuid.MustParse("BECA12AB-B2E6-4523-93EF-C309E000C746")
+	logicalSectorSizeGUID  = uuid.MustParse("8141BF1D-A96F-4709-BA47-F233A8FAAB5F")
+	physicalSectorSizeGUID = uuid.MustParse("CDA348C7-445D-4471-9CC9-E9885251C556")
+	parentLocatorGUID      = uuid.MustParse("A8D35F2D-B30B-454D-ABF7-D3D84834AB0C")
+)
+
+// metadata entry flags; bit 0 marks user metadata, which is not used
+const (
+	metadataIsVirtualDisk uint32 = 1 << 1
+	metadataIsRequired    uint32 = 1 << 2
+)
+
+// file parameters flags
+const (
+	fileParameterHasParent uint32 = 1 << 1
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum is the CRC-32C of the structure, with its checksum field taken as zero
+func checksum(b []byte) uint32 {
+	c := crc32.Update(0, crc32c, b[:checksumFieldOffset])
+	c = crc32.Update(c, crc32c, make([]byte, 4))
+	return crc32.Update(c, crc32c, b[checksumFieldOffset+4:])
+}
+
+func validChecksum(b []byte) bool {
+	return binary.LittleEndian.Uint32(b[checksumFieldOffset:checksumFieldOffset+4]) == checksum(b)
+}
+
+func setChecksum(b []byte) {
+	binary.LittleEndian.PutUint32(b[checksumFieldOffset:checksumFieldOffset+4], checksum(b))
+}
+
+// guidFromBytes reads a GUID in the Microsoft mixed-endian layout, with the first three fields little-endian
+func guidFromBytes(b []byte) uuid.UUID {
+	var u uuid.UUID
+	copy(u[:], b[:16])
+	u[0], u[1], u[2], u[3] = b[3], b[2], b[1], b[0]
+	u[4], u[5] = b[5], b[4]
+	u[6], u[7] = b[7], b[6]
+	return u
+}
+
+// guidToBytes writes a GUID in the Microsoft mixed-endian layout
+func guidToBytes(b []byte, u uuid.UUID) {
+	copy(b[:16], u[:])
+	b[0], b[1], b[2], b[3] = u[3], u[2], u[1], u[0]
+	b[4], b[5] = u[5], u[4]
+	b[6], b[7] = u[7], u[6]
+}
+
+// fileIdentifier returns the file type identifier at the start of the image
+func fileIdentifier(creator string) []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:8], fileIdentifierSignature)
+	for i, c := range utf16.Encode([]rune(creator)) {
+		if 8+i*2+2 > 8+512 {
+			break
+		}
+		binary.LittleEndian.PutUint16(b[8+i*2:], c)
+	}
+	return b
+}
+
+// header is one of the two VHDX headers; the valid one with the highest sequence number is current
+type header struct {
+	sequenceNumber uint64
+	fileWriteGUID  uuid.UUID
+	dataWriteGUID  uuid.UUID
+	logGUID        uuid.UUID
+	logVersion     uint16
+	version        uint16
+	logLength      uint32
+	logOffset      uint64
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("header was %d bytes instead of expected %d", len(b), headerSize)
+	}
+	b = b[:headerSize]
+	if !bytes.Equal(b[0:4], []byte(headerSignature)) {
+		return nil, fmt.Errorf("invalid header signature %q", b[0:4])
+	}
+	if !validChecksum(b) {
+		return nil, fmt.Errorf("invalid header checksum")
+	}
+	h := &header{
+		sequenceNumber: binary.LittleEndian.Uint64(b[8:16]),
+		fileWriteGUID:  guidFromBytes(b[16:32]),
+		dataWriteGUID:  guidFromBytes(b[32:48]),
+		logGUID:        guidFromBytes(b[48:64]),
+		logVersion:     binary.LittleEndian.Uint16(b[64:66]),
+		version:        binary.LittleEndian.Uint16(b[66:68]),
+		logLength:      binary.LittleEndian.Uint32(b[68:72]),
+		logOffset:      binary.LittleEndian.Uint64(b[72:80]),
+	}
+	if h.version != headerVersion {
+		return nil, fmt.Errorf("unsupported VHDX version %d", h.version)
+	}
+	if h.logVersion != logVersion {
+		return nil, fmt.Errorf("unsupported VHDX log version %d", h.logVersion)
+	}
+	return h, nil
+}
+
+func (h *header) toBytes() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:4], headerSignature)
+	binary.LittleEndian.PutUint64(b[8:16], h.sequenceNumber)
+	guidToBytes(b[16:32], h.fileWriteGUID)
+	guidToBytes(b[32:48], h.dataWriteGUID)
+	guidToBytes(b[48:64], h.logGUID)
+	binary.LittleEndian.PutUint16(b[64:66], h.logVersion)
+	binary.LittleEndian.PutUint16(b[66:68], h.version)
+	binary.LittleEndian.PutUint32(b[68:72], h.logLength)
+	binary.LittleEndian.PutUint64(b[72:80], h.logOffset)
+	setChecksum(b)
+	return b
+}
+
+// region is an entry in the region table
+type region struct {
+	guid     uuid.UUID
+	offset   uint64
+	length   uint32
+	required bool
+}
+
+func regionTableFromBytes(b []byte) ([]region, error) {
+	if len(b) != regionTableSize {
+		return nil, fmt.Errorf("region table was %d bytes instead of expected %d", len(b), regionTableSize)
+	}
+	if !bytes.Equal(b[0:4], []byte(regionTableSignature)) {
+		return nil, fmt.Errorf("invalid region table signature %q", b[0:4])
+	}
+	if !validChecksum(b) {
+		return nil, fmt.Errorf("invalid region table checksum")
+	}
+	count := binary.LittleEndian.Uint32(b[8:12])
+	if count > maxRegionEntries {
+		return nil, fmt.Errorf("region table has %d entries, more than maximum %d", count, maxRegionEntries)
+	}
+	regions := make([]region, 0, count)
+	for i := uint32(0); i < count; i++ {
+		e := b[16+i*regionEntrySize : 16+(i+1)*regionEntrySize]
+		regions = append(regions, region{
+			guid:     guidFromBytes(e[0:16]),
+			offset:   binary.LittleEndian.Uint64(e[16:24]),
+			length:   binary.LittleEndian.Uint32(e[24:28]),
+			required: binary.LittleEndian.Uint32(e[28:32])&1 != 0,
+		})
+	}
+	return regions, nil
+}
+
+func regionTableToBytes(regions []region) []byte {
+	b := make([]byte, regionTableSize)
+	copy(b[0:4], regionTableSignature)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(regions)))
+	for i, r := range regions {
+		e := b[16+i*regionEntrySize : 16+(i+1)*regionEntrySize]
+		guidToBytes(e[0:16], r.guid)
+		binary.LittleEndian.PutUint64(e[16:24], r.offset)
+		binary.LittleEndian.PutUint32(e[24:28], r.length)
+		if r.required {
+			binary.LittleEndian.PutUint32(e[28:32], 1)
+		}
+	}
+	setChecksum(b)
+	return b
+}
+
+// metadataEntry is an entry in the metadata table, whose offset is relative to the start of the metadata region
+type metadataEntry struct {
+	itemID uuid.UUID
+	offset uint32
+	length uint32
+	flags  uint32
+}
+
+func metadataTableFromBytes(b []byte) ([]metadataEntry, error) {
+	if len(b) < metadataTableSize {
+		return nil, fmt.Errorf("metadata table was %d bytes instead of expected %d", len(b), metadataTableSize)
+	}
+	if !bytes.Equal(b[0:8], []byte(metadataTableSignature)) {
+		return nil, fmt.Errorf("invalid metadata table signature %q", b[0:8])
+	}
+	count := binary.LittleEndian.Uint16(b[10:12])
+	if count > maxMetadataEntries {
+		return nil, fmt.Errorf("metadata table has %d entries, more than maximum %d", count, maxMetadataEntries)
+	}
+	entries := make([]metadataEntry, 0, count)
+	for i := uint16(0); i < count; i++ {
+		e := b[32+int(i)*metadataEntrySize : 32+int(i+1)*metadataEntrySize]
+		entries = append(entries, metadataEntry{
+			itemID: guidFromBytes(e[0:16]),
+			offset: binary.LittleEndian.Uint32(e[16:20]),
+			length: binary.LittleEndian.Uint32(e[20:24]),
+			flags:  binary.LittleEndian.Uint32(e[24:28]),
+		})
+	}
+	return entries, nil
+}
+
+func metadataTableToBytes(entries []metadataEntry) []byte {
+	b := make([]byte, metadataTableSize)
+	copy(b[0:8], metadataTableSignature)
+	binary.LittleEndian.PutUint16(b[10:12], uint16(len(entries)))
+	for i, m := range entries {
+		e := b[32+i*metadataEntrySize : 32+(i+1)*metadataEntrySize]
+		guidToBytes(e[0:16], m.itemID)
+		binary.LittleEndian.PutUint32(e[16:20], m.offset)
+		binary.LittleEndian.PutUint32(e[20:24], m.length)
+		binary.LittleEndian.PutUint32(e[24:28], m.flags)
+	}
+	return b
+}
+
+// parameters are the metadata items that describe the virtual disk
+type parameters struct {
+	blockSize          uint32
+	fileFlags          uint32
+	virtualDiskSize    uint64
+	virtualDiskID      uuid.UUID
+	logicalSectorSize  uint32
+	physicalSectorSize uint32
+}
+
+// the metadata items written for parameters, with their sizes and flags
+var metadataItems = []struct {
+	id     uuid.UUID
+	length uint32
+	flags  uint32
+}{
+	{fileParametersGUID, 8, metadataIsRequired},
+	{virtualDiskSizeGUID, 8, metadataIsVirtualDisk | metadataIsRequired},
+	{virtualDiskIDGUID, 16, metadataIsVirtualDisk | metadataIsRequired},
+	{logicalSectorSizeGUID, 4, metadataIsVirtualDisk | metadataIsRequired},
+	{physicalSectorSizeGUID, 4, metadataIsVirtualDisk | metadataIsRequired},
+}
+
+// parametersFromMetadata reads the parameters from the metadata region
+func parametersFromMetadata(b []byte) (*parameters, error) {
+	entries, err := metadataTableFromBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &parameters{}
+	found := map[uuid.UUID]bool{}
+	for _, e := range entries {
+		var data []byte
+		if e.length > 0 {
+			if e.offset < metadataTableSize || uint64(e.offset)+uint64(e.length) > uint64(len(b)) {
+				return nil, fmt.Errorf("metadata item %s at %d with length %d is outside of the metadata region", e.itemID, e.offset, e.length)
+			}
+			data = b[e.offset : e.offset+e.length]
+		}
+		short := func(size uint32) error {
+			if e.length < size {
+				return fmt.Errorf("metadata item %s has length %d instead of %d", e.itemID, e.length, size)
+			}
+			return nil
+		}
+		switch e.itemID {
+		case fileParametersGUID:
+			if err := short(8); err != nil {
+				return nil, err
+			}
+			p.blockSize = binary.LittleEndian.Uint32(data[0:4])
+			p.fileFlags = binary.LittleEndian.Uint32(data[4:8])
+		case virtualDiskSizeGUID:
+			if err := short(8); err != nil {
+				return nil, err
+			}
+			p.virtualDiskSize = binary.LittleEndian.Uint64(data[0:8])
+		case virtualDiskIDGUID:
+			if err := short(16); err != nil {
+				return nil, err
+			}
+			p.virtualDiskID = guidFromBytes(data[0:16])
+		case logicalSectorSizeGUID:
+			if err := short(4); err != nil {
+				return nil, err
+			}
+			p.logicalSectorSize = binary.LittleEndian.Uint32(data[0:4])
+		case physicalSectorSizeGUID:
+			if err := short(4); err != nil {
+				return nil, err
+			}
+			p.physicalSectorSize = binary.LittleEndian.Uint32(data[0:4])
+		case parentLocatorGUID:
+			return nil, fmt.Errorf("differencing VHDX images are not supported")
+		default:
+			if e.flags&metadataIsRequired != 0 {
+				return nil, fmt.Errorf("unknown required metadata item %s", e.itemID)
+			}
+			continue
+		}
+		found[e.itemID] = true
+	}
+	for _, item := range metadataItems {
+		if !found[item.id] {
+			return nil, fmt.Errorf("missing required metadata item %s", item.id)
+		}
+	}
+	if p.fileFlags&fileParameterHasParent != 0 {
+		return nil, fmt.Errorf("differencing VHDX images are not supported")
+	}
+	if p.blockSize < mb || p.blockSize > 256*mb || p.blockSize&(p.blockSize-1) != 0 {
+		return nil, fmt.Errorf("invalid block size %d", p.blockSize)
+	}
+	if p.logicalSectorSize != 512 && p.logicalSectorSize != 4096 {
+		return nil, fmt.Errorf("invalid logical sector size %d", p.logicalSectorSize)
+	}
+	if p.physicalSectorSize != 512 && p.physicalSectorSize != 4096 {
+		return nil, fmt.Errorf("invalid physical sector size %d", p.physicalSectorSize)
+	}
+	return p, nil
+}
+
+// toMetadata serializes the parameters as a metadata region of the given size
+func (p *parameters) toMetadata(size int) []byte {
+	entries := make([]metadataEntry, 0, len(metadataItems))
+	b := make([]byte, size)
+	offset := uint32(metadataTableSize)
+	for _, item := range metadataItems {
+		data := b[offset : offset+item.length]
+		switch item.id {
+		case fileParametersGUID:
+			binary.LittleEndian.PutUint32(data[0:4], p.blockSize)
+			binary.LittleEndian.PutUint32(data[4:8], p.fileFlags)
+		case virtualDiskSizeGUID:
+			binary.LittleEndian.PutUint64(data[0:8], p.virtualDiskSize)
+		case virtualDiskIDGUID:
+			guidToBytes(data[0:16], p.virtualDiskID)
+		case logicalSectorSizeGUID:
+			binary.LittleEndian.PutUint32(data[0:4], p.logicalSectorSize)
+		case physicalSectorSizeGUID:
+			binary.LittleEndian.PutUint32(data[0:4], p.physicalSectorSize)
+		}
+		entries = append(entries, metadataEntry{itemID: item.id, offset: offset, length: item.length, flags: item.flags})
+		offset += item.length
+	}
+	copy(b, metadataTableToBytes(entries))
+	return b
+}
+
+// chunkRatio is the number of payload blocks described by a single sector bitmap block
+func (p *parameters) chunkRatio() int64 {
+	return (int64(1) << 23) * int64(p.logicalSectorSize) / int64(p.blockSize)
+}
+
+// payloadBlocks is the number of payload blocks in the virtual disk
+func (p *parameters) payloadBlocks() int64 {
+	return (int64(p.virtualDiskSize) + int64(p.blockSize) - 1) / int64(p.blockSize)
+}
+
+// batEntries is the number of entries in the BAT, with a sector bitmap entry after every chunk of payload entries
+func (p *parameters) batEntries() int64 {
+	blocks := p.payloadBlocks()
+	if blocks == 0 {
+		return 0
+	}
+	return blocks + (blocks-1)/p.chunkRatio()
+}
+
+// batIndex the index in the BAT of the entry for a payload block
+func (p *parameters) batIndex(block int64) int64 {
+	return block + block/p.chunkRatio()
+}
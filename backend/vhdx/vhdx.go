@@ -0,0 +1,513 @@
+// Package vhdx provides a backend for disk images in the Microsoft VHDX format, as used by Hyper-V and Azure.
+//
+// When an image is opened, a pending log left by an interrupted writer is replayed: into the image if it is
+// opened for writing, or in memory if it is opened read-only, so the image is left untouched.
+// Payload blocks are allocated at the end of the image on first write. Differencing images are not supported.
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+// BAT entry states, in the low 3 bits of each entry
+const (
+	blockNotPresent       uint64 = 0
+	blockUndefined        uint64 = 1
+	blockZero             uint64 = 2
+	blockUnmapped         uint64 = 3
+	blockFullyPresent     uint64 = 6
+	blockPartiallyPresent uint64 = 7
+
+	batStateMask  uint64 = 0x7
+	batOffsetMask uint64 = ^uint64(mb - 1)
+)
+
+// Image is a VHDX image presented as a backend.Storage of the size of its virtual disk
+type Image struct {
+	storage  backend.Storage
+	rw       backend.WritableFile
+	readOnly bool
+
+	header *header
+	// headerOffset is the location of the current header, either header1Offset or header2Offset
+	headerOffset int64
+	// sessionStarted is set once the header has been updated for writing in this session
+	sessionStarted bool
+	params         *parameters
+	batOffset      int64
+	bat            []uint64
+	// nextFree is the offset of the next payload block to allocate, always at the end of the image
+	nextFree int64
+
+	// replayed holds the replayed log of an image opened read-only, as 4KB sectors keyed by file offset
+	replayed map[int64][]byte
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the VHDX image stored in the provided backend.Storage.
+// If readOnly is false, the storage must be writable.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	img := &Image{
+		storage:  b,
+		readOnly: readOnly,
+	}
+	if !readOnly {
+		rw, err := b.Writable()
+		if err != nil {
+			return nil, err
+		}
+		img.rw = rw
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing VHDX image file
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, readOnly), readOnly)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open VHDX image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init() error {
+	b := make([]byte, len(fileIdentifierSignature))
+	if err := img.readRaw(b, fileIdentifierOffset); err != nil {
+		return fmt.Errorf("error reading file identifier: %w", err)
+	}
+	if !bytes.Equal(b, []byte(fileIdentifierSignature)) {
+		return fmt.Errorf("invalid VHDX file identifier %q", b)
+	}
+
+	// the current header is the valid one with the highest sequence number
+	var headerErrs []error
+	for _, offset := range []int64{header1Offset, header2Offset} {
+		b := make([]byte, headerSize)
+		if err := img.readRaw(b, offset); err != nil {
+			return fmt.Errorf("error reading header at %d: %w", offset, err)
+		}
+		h, err := headerFromBytes(b)
+		if err != nil {
+			headerErrs = append(headerErrs, err)
+			continue
+		}
+		if img.header == nil || h.sequenceNumber > img.header.sequenceNumber {
+			img.header, img.headerOffset = h, offset
+		}
+	}
+	if img.header == nil {
+		return fmt.Errorf("no valid VHDX header: %w", errors.Join(headerErrs...))
+	}
+
+	info, err := img.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat VHDX image: %w", err)
+	}
+	img.nextFree = alignMB(info.Size())
+
+	if img.header.logGUID != uuid.Nil {
+		if err := img.replayLog(); err != nil {
+			return err
+		}
+	}
+
+	var regions []region
+	for _, offset := range []int64{regionTable1Offset, regionTable2Offset} {
+		b := make([]byte, regionTableSize)
+		if err := img.readRaw(b, offset); err != nil {
+			return fmt.Errorf("error reading region table at %d: %w", offset, err)
+		}
+		if regions, err = regionTableFromBytes(b); err == nil {
+			break
+		}
+	}
+	if regions == nil {
+		return fmt.Errorf("no valid VHDX region table: %w", err)
+	}
+	var batRegion, metadataRegion *region
+	for i, r := range regions {
+		switch {
+		case r.guid == batRegionGUID:
+			batRegion = &regions[i]
+		case r.guid == metadataRegionGUID:
+			metadataRegion = &regions[i]
+		case r.required:
+			return fmt.Errorf("unknown required region %s", r.guid)
+		}
+	}
+	if batRegion == nil || metadataRegion == nil {
+		return errors.New("VHDX image is missing the BAT or metadata region")
+	}
+
+	b = make([]byte, metadataRegion.length)
+	if err := img.readRaw(b, int64(metadataRegion.offset)); err != nil {
+		return fmt.Errorf("error reading metadata region: %w", err)
+	}
+	if img.params, err = parametersFromMetadata(b); err != nil {
+		return err
+	}
+
+	entries := img.params.batEntries()
+	if entries*8 > int64(batRegion.length) {
+		return fmt.Errorf("BAT region of %d bytes is too small for %d entries", batRegion.length, entries)
+	}
+	b = make([]byte, entries*8)
+	img.batOffset = int64(batRegion.offset)
+	if err := img.readRaw(b, img.batOffset); err != nil {
+		return fmt.Errorf("error reading BAT: %w", err)
+	}
+	img.bat = make([]uint64, entries)
+	for i := range img.bat {
+		img.bat[i] = binary.LittleEndian.Uint64(b[i*8 : i*8+8])
+	}
+	return nil
+}
+
+// replayLog replays the pending log, into the image if it is writable and otherwise in memory
+func (img *Image) replayLog() error {
+	h := img.header
+	log := make([]byte, h.logLength)
+	if err := img.readRaw(log, int64(h.logOffset)); err != nil {
+		return fmt.Errorf("error reading log: %w", err)
+	}
+	if img.readOnly {
+		img.replayed = map[int64][]byte{}
+	}
+	zero := make([]byte, logSectorSize)
+	apply := func(w logWrite) error {
+		if w.offset%logSectorSize != 0 || w.zero%logSectorSize != 0 {
+			return fmt.Errorf("log write at %d is not aligned to %d bytes", w.offset, logSectorSize)
+		}
+		if img.readOnly {
+			if w.data != nil {
+				img.replayed[int64(w.offset)] = w.data
+			}
+			for off := uint64(0); off < w.zero; off += logSectorSize {
+				img.replayed[int64(w.offset+off)] = zero
+			}
+			return nil
+		}
+		if w.data != nil {
+			return img.writeFull(w.data, int64(w.offset))
+		}
+		for off := uint64(0); off < w.zero; off += logSectorSize {
+			if err := img.writeFull(zero, int64(w.offset+off)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	lastFileOffset, err := replayLog(log, h.logGUID, apply)
+	if err != nil {
+		return err
+	}
+	img.nextFree = max(img.nextFree, alignMB(int64(lastFileOffset)))
+	if img.readOnly {
+		return nil
+	}
+	// the log has been applied, so it no longer needs to be replayed
+	newHeader := *h
+	newHeader.logGUID = uuid.Nil
+	return img.writeHeader(&newHeader)
+}
+
+// writeHeader writes a new header, with the next sequence number, over the non-current header
+func (img *Image) writeHeader(h *header) error {
+	h.sequenceNumber = img.header.sequenceNumber + 1
+	offset := int64(header1Offset)
+	if img.headerOffset == header1Offset {
+		offset = header2Offset
+	}
+	if err := img.writeFull(h.toBytes(), offset); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+	img.header, img.headerOffset = h, offset
+	return nil
+}
+
+// startSession updates the header before the first write, as required so that other readers can tell
+// that the image has changed
+func (img *Image) startSession() error {
+	if img.sessionStarted {
+		return nil
+	}
+	h := *img.header
+	h.fileWriteGUID = util.NewUUID()
+	h.dataWriteGUID = util.NewUUID()
+	if err := img.writeHeader(&h); err != nil {
+		return err
+	}
+	img.sessionStarted = true
+	return nil
+}
+
+func alignMB(off int64) int64 {
+	return (off + mb - 1) &^ (mb - 1)
+}
+
+// readRaw reads len(b) bytes from the image file, treating data past the end of the image as zero,
+// and taking into account a log replayed in memory
+func (img *Image) readRaw(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(b[n:])
+	if img.replayed == nil {
+		return nil
+	}
+	end := offset + int64(len(b))
+	for sector := offset &^ (logSectorSize - 1); sector < end; sector += logSectorSize {
+		data, ok := img.replayed[sector]
+		if !ok {
+			continue
+		}
+		from := max(offset, sector)
+		to := min(end, sector+logSectorSize)
+		copy(b[from-offset:to-offset], data[from-sector:to-sector])
+	}
+	return nil
+}
+
+func (img *Image) writeFull(b []byte, offset int64) error {
+	n, err := img.rw.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+// Size is the size of the virtual disk
+func (img *Image) Size() int64 {
+	return int64(img.params.virtualDiskSize)
+}
+
+// BlockSize is the size of the payload blocks in which the image is allocated
+func (img *Image) BlockSize() int64 {
+	return int64(img.params.blockSize)
+}
+
+// LogicalSectorSize is the logical sector size of the virtual disk, either 512 or 4096
+func (img *Image) LogicalSectorSize() int64 {
+	return int64(img.params.logicalSectorSize)
+}
+
+// PhysicalSectorSize is the physical sector size of the virtual disk, either 512 or 4096
+func (img *Image) PhysicalSectorSize() int64 {
+	return int64(img.params.physicalSectorSize)
+}
+
+// ReadAt reads from the virtual disk
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	return img.readAt(p, off)
+}
+
+func (img *Image) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	blockSize := img.BlockSize()
+	read := 0
+	for read < len(p) {
+		virtual := off + int64(read)
+		chunk := int(min(int64(len(p)-read), blockSize-virtual%blockSize))
+		if err := img.readBlock(p[read:read+chunk], virtual); err != nil {
+			return read, err
+		}
+		read += chunk
+	}
+	return read, eof
+}
+
+// readBlock reads part of a single payload block, which must not cross a block boundary
+func (img *Image) readBlock(b []byte, virtual int64) error {
+	block := virtual / img.BlockSize()
+	entry := img.bat[img.params.batIndex(block)]
+	switch entry & batStateMask {
+	case blockFullyPresent:
+		return img.readRaw(b, int64(entry&batOffsetMask)+virtual%img.BlockSize())
+	case blockNotPresent, blockUndefined, blockZero, blockUnmapped:
+		clear(b)
+		return nil
+	case blockPartiallyPresent:
+		return fmt.Errorf("payload block %d is partially present, which is only valid in differencing images", block)
+	default:
+		return fmt.Errorf("payload block %d has invalid state %d", block, entry&batStateMask)
+	}
+}
+
+// WriteAt writes to the virtual disk, allocating payload blocks as needed
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > img.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the virtual disk of size %d", len(p), off, img.Size())
+	}
+	if err := img.startSession(); err != nil {
+		return 0, err
+	}
+	blockSize := img.BlockSize()
+	written := 0
+	for written < len(p) {
+		virtual := off + int64(written)
+		chunk := int(min(int64(len(p)-written), blockSize-virtual%blockSize))
+		if err := img.writeBlock(p[written:written+chunk], virtual); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// writeBlock writes part of a single payload block, which must not cross a block boundary
+func (img *Image) writeBlock(b []byte, virtual int64) error {
+	blockSize := img.BlockSize()
+	block := virtual / blockSize
+	inBlock := virtual % blockSize
+	index := img.params.batIndex(block)
+	entry := img.bat[index]
+	if entry&batStateMask == blockFullyPresent {
+		return img.writeFull(b, int64(entry&batOffsetMask)+inBlock)
+	}
+
+	// allocate a new block at the end of the image; the rest of it reads as zero,
+	// but is written out so that the file covers the whole block
+	offset := img.nextFree
+	img.nextFree += blockSize
+	if err := img.writeFull(b, offset+inBlock); err != nil {
+		return fmt.Errorf("error writing payload block %d: %w", block, err)
+	}
+	if inBlock+int64(len(b)) < blockSize {
+		if err := img.writeFull([]byte{0}, offset+blockSize-1); err != nil {
+			return fmt.Errorf("error writing payload block %d: %w", block, err)
+		}
+	}
+	entry = uint64(offset) | blockFullyPresent
+	eb := make([]byte, 8)
+	binary.LittleEndian.PutUint64(eb, entry)
+	if err := img.writeFull(eb, img.batOffset+index*8); err != nil {
+		return fmt.Errorf("error updating BAT: %w", err)
+	}
+	img.bat[index] = entry
+	return nil
+}
+
+// Stat returns the file info of the image, but with the size of the virtual disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the virtual disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.readAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the virtual disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the image file
+func (img *Image) Sync() error {
+	return backend.Sync(img.storage)
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for VHDX images, as ioctls on the image file do not apply to the virtual disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the virtual disk instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
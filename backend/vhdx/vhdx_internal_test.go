@@ -0,0 +1,157 @@
+package vhdx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/google/uuid"
+)
+
+func TestGUIDBytes(t *testing.T) {
+	expected := []byte{0x66, 0x77, 0xc2, 0x2d, 0x23, 0xf6, 0x00, 0x42, 0x9d, 0x64, 0x11, 0x5e, 0x9b, 0xfd, 0x4a, 0x08}
+	b := make([]byte, 16)
+	guidToBytes(b, batRegionGUID)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("GUID bytes %x instead of %x", b, expected)
+	}
+	if u := guidFromBytes(expected); u != batRegionGUID {
+		t.Errorf("GUID %s instead of %s", u, batRegionGUID)
+	}
+}
+
+// logEntryBytes builds a log entry as written by a VHDX writer
+func logEntryBytes(seq uint64, tail uint32, logGUID uuid.UUID, writes []logWrite) []byte {
+	var data []logWrite
+	for _, w := range writes {
+		if w.data != nil {
+			data = append(data, w)
+		}
+	}
+	headerLength := (logEntryHeaderSize + len(writes)*logDescriptorSize + logSectorSize - 1) &^ (logSectorSize - 1)
+	b := make([]byte, headerLength+len(data)*logSectorSize)
+	copy(b[0:4], logEntrySignature)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[12:16], tail)
+	binary.LittleEndian.PutUint64(b[16:24], seq)
+	binary.LittleEndian.PutUint32(b[24:28], uint32(len(writes)))
+	guidToBytes(b[32:48], logGUID)
+	sector := headerLength
+	for i, w := range writes {
+		d := b[logEntryHeaderSize+i*logDescriptorSize:]
+		binary.LittleEndian.PutUint64(d[16:24], w.offset)
+		binary.LittleEndian.PutUint64(d[24:32], seq)
+		if w.data == nil {
+			copy(d[0:4], zeroDescriptorSignature)
+			binary.LittleEndian.PutUint64(d[8:16], w.zero)
+			continue
+		}
+		copy(d[0:4], dataDescriptorSignature)
+		copy(d[4:8], w.data[4092:4096])
+		copy(d[8:16], w.data[0:8])
+		s := b[sector : sector+logSectorSize]
+		copy(s[0:4], dataSectorSignature)
+		binary.LittleEndian.PutUint32(s[4:8], uint32(seq>>32))
+		copy(s[8:4092], w.data[8:4092])
+		binary.LittleEndian.PutUint32(s[4092:4096], uint32(seq))
+		sector += logSectorSize
+	}
+	setChecksum(b)
+	return b
+}
+
+func TestLogReplay(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.vhdx")
+	img, err := CreateFromPath(p, 10*1024*1024, WithBlockSize(1024*1024))
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	if _, err := img.WriteAt(bytes.Repeat([]byte{0xaa}, 2*1024*1024), 0); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	blockOffset := int64(img.bat[0] & batOffsetMask)
+
+	// a log that rewrites part of the first payload block, zeroes part of the second, and an older
+	// sequence of entries that must be ignored
+	logGUID := uuid.New()
+	newData := bytes.Repeat([]byte{0x55}, logSectorSize)
+	copy(newData, "replayed")
+	stale := logEntryBytes(5, 0, logGUID, []logWrite{{offset: uint64(blockOffset), data: bytes.Repeat([]byte{0x11}, logSectorSize)}})
+	first := logEntryBytes(10, logSectorSize, logGUID, []logWrite{{offset: uint64(blockOffset + logSectorSize), data: newData}})
+	second := logEntryBytes(11, logSectorSize, logGUID, []logWrite{{offset: uint64(int64(img.bat[1]&batOffsetMask) + 8*logSectorSize), zero: 2 * logSectorSize}})
+	log := make([]byte, img.header.logLength)
+	copy(log, stale)
+	copy(log[logSectorSize:], first)
+	copy(log[logSectorSize+len(first):], second)
+	if err := img.writeFull(log, int64(img.header.logOffset)); err != nil {
+		t.Fatal(err)
+	}
+	h := *img.header
+	h.logGUID = logGUID
+	if err := img.writeHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := bytes.Repeat([]byte{0xaa}, 2*1024*1024)
+	copy(expected[logSectorSize:], newData)
+	copy(expected[1024*1024+8*logSectorSize:], make([]byte, 2*logSectorSize))
+	check := func(img *Image) {
+		t.Helper()
+		b := make([]byte, len(expected))
+		if _, err := img.ReadAt(b, 0); err != nil {
+			t.Fatalf("unexpected error reading: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched contents after log replay")
+		}
+	}
+
+	before, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening image read-only: %v", err)
+	}
+	check(img)
+	img.Close()
+	after, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("image was modified by opening it read-only")
+	}
+
+	img, err = OpenFromPath(p, false)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	check(img)
+	if img.header.logGUID != uuid.Nil {
+		t.Errorf("log GUID was not cleared after replay")
+	}
+	img.Close()
+
+	// the replay is persistent
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = New(file.New(f, true), true)
+	if err != nil {
+		t.Fatalf("error opening image: %v", err)
+	}
+	defer img.Close()
+	if img.replayed != nil {
+		t.Errorf("log was replayed again")
+	}
+	check(img)
+}
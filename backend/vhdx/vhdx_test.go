@@ -0,0 +1,129 @@
+package vhdx_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/vhdx"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		opts []vhdx.CreateOpt
+		err  bool
+	}{
+		{"default", 100 * 1024 * 1024, nil, false},
+		{"small blocks", 100 * 1024 * 1024, []vhdx.CreateOpt{vhdx.WithBlockSize(1024 * 1024)}, false},
+		{"4k sectors", 100 * 1024 * 1024, []vhdx.CreateOpt{vhdx.WithSectorSize(4096, 4096)}, false},
+		{"unaligned size", 100*1024*1024 + 512, []vhdx.CreateOpt{vhdx.WithSectorSize(4096, 4096)}, true},
+		{"too large", vhdx.MaxSize + 512, nil, true},
+		{"invalid block size", 100 * 1024 * 1024, []vhdx.CreateOpt{vhdx.WithBlockSize(3 * 1024 * 1024)}, true},
+		{"invalid sector size", 100 * 1024 * 1024, []vhdx.CreateOpt{vhdx.WithSectorSize(1024, 4096)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := vhdx.CreateFromPath(filepath.Join(t.TempDir(), "test.vhdx"), tt.size, tt.opts...)
+			switch {
+			case tt.err && err == nil:
+				img.Close()
+				t.Fatalf("expected error, got none")
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case err != nil:
+				return
+			}
+			defer img.Close()
+			if img.Size() != tt.size {
+				t.Errorf("size %d instead of %d", img.Size(), tt.size)
+			}
+			info, err := img.Stat()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Size() != tt.size {
+				t.Errorf("stat size %d instead of %d", info.Size(), tt.size)
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.vhdx")
+	size := int64(20 * 1024 * 1024)
+	img, err := vhdx.CreateFromPath(p, size, vhdx.WithBlockSize(1024*1024))
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	if img.BlockSize() != 1024*1024 || img.LogicalSectorSize() != 512 || img.PhysicalSectorSize() != 4096 {
+		t.Errorf("unexpected parameters block size %d, sector sizes %d/%d", img.BlockSize(), img.LogicalSectorSize(), img.PhysicalSectorSize())
+	}
+	expected := make([]byte, size)
+	for _, w := range []struct {
+		offset int64
+		length int
+	}{
+		{0, 512},
+		{1024*1024 - 100, 300},      // crosses a block boundary
+		{3 * 1024 * 1024, 3000000},  // many blocks
+		{3*1024*1024 + 700, 50},     // rewrite
+		{size - 512, 512},           // end of the disk
+		{10*1024*1024 + 1, 1000003}, // unaligned start and end
+	} {
+		b := make([]byte, w.length)
+		_, _ = rand.Read(b)
+		n, err := img.WriteAt(b, w.offset)
+		if err != nil {
+			t.Fatalf("error writing %d bytes at %d: %v", w.length, w.offset, err)
+		}
+		if n != w.length {
+			t.Fatalf("wrote %d bytes instead of %d", n, w.length)
+		}
+		copy(expected[w.offset:], b)
+	}
+	if _, err := img.WriteAt([]byte{1}, size); err == nil {
+		t.Errorf("expected error writing beyond end of disk")
+	}
+
+	check := func(img *vhdx.Image) {
+		t.Helper()
+		b := make([]byte, size)
+		if _, err := img.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading image: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched image contents")
+		}
+		if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+			t.Errorf("expected io.EOF reading past the end, got %v", err)
+		}
+	}
+	check(img)
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err = vhdx.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	defer img.Close()
+	check(img)
+	if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+		t.Errorf("expected error writing to read-only image")
+	}
+
+	// only the written blocks are allocated
+	info, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 4*1024*1024+size {
+		t.Errorf("image file is %d bytes, expected only written blocks to be allocated", info.Size())
+	}
+}
@@ -0,0 +1,179 @@
+package vmdk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	defaultGrainSize   = 128 // sectors, 64KB
+	descriptorSectors  = 20
+	defaultAdapterType = "ide"
+	defaultExtentName  = "disk.vmdk"
+)
+
+type createOpts struct {
+	grainSize   uint64
+	adapterType string
+	extentName  string
+}
+
+// CreateOpt func that process Create and WriteStreamOptimized options
+type CreateOpt func(o *createOpts) error
+
+// WithGrainSize sets the grain size of the new image, which must be a power of 2 of at least 4KB.
+// Default is 64KB, as with VMware.
+func WithGrainSize(size int64) CreateOpt {
+	return func(o *createOpts) error {
+		if size < 8*sectorSize || size&(size-1) != 0 {
+			return fmt.Errorf("invalid grain size %d, must be a power of 2 of at least %d", size, 8*sectorSize)
+		}
+		o.grainSize = uint64(size / sectorSize)
+		return nil
+	}
+}
+
+// WithAdapterType sets the disk adapter type recorded in the descriptor, e.g. "ide", "lsilogic",
+// "buslogic" or "legacyESX". Default is "ide".
+func WithAdapterType(adapterType string) CreateOpt {
+	return func(o *createOpts) error {
+		switch adapterType {
+		case "ide", "lsilogic", "buslogic", "legacyESX":
+		default:
+			return fmt.Errorf("unsupported adapter type %q", adapterType)
+		}
+		o.adapterType = adapterType
+		return nil
+	}
+}
+
+// WithExtentName sets the file name of the image recorded in its descriptor. CreateFromPath
+// defaults to the name of the file, and otherwise the default is "disk.vmdk".
+func WithExtentName(name string) CreateOpt {
+	return func(o *createOpts) error {
+		if name == "" {
+			return errors.New("must pass extent name")
+		}
+		o.extentName = name
+		return nil
+	}
+}
+
+func applyCreateOpts(size int64, opts []CreateOpt) (*createOpts, error) {
+	if size <= 0 || size%sectorSize != 0 {
+		return nil, fmt.Errorf("must pass valid virtual disk size to create, a positive multiple of %d", sectorSize)
+	}
+	opt := &createOpts{
+		grainSize:   defaultGrainSize,
+		adapterType: defaultAdapterType,
+		extentName:  defaultExtentName,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	return opt, nil
+}
+
+// newHeader header of a new image, with the descriptor right after it
+func newHeader(size int64, opt *createOpts) *header {
+	return &header{
+		version:          1,
+		flags:            flagValidNewlineTest,
+		capacity:         uint64(size / sectorSize),
+		grainSize:        opt.grainSize,
+		descriptorOffset: 1,
+		descriptorSize:   descriptorSectors,
+		numGTEsPerGT:     gtesPerGT,
+	}
+}
+
+// newDescriptor returns the descriptor text padded to its sectors
+func newDescriptor(createType string, h *header, opt *createOpts) ([]byte, error) {
+	cid := make([]byte, 4)
+	if _, err := util.Rand.Read(cid); err != nil {
+		return nil, fmt.Errorf("could not generate CID: %w", err)
+	}
+	d := descriptor(createType, opt.extentName, binary.LittleEndian.Uint32(cid), h.capacity, opt.adapterType)
+	b := make([]byte, h.descriptorSize*sectorSize)
+	if len(d) > len(b) {
+		return nil, fmt.Errorf("descriptor is %d bytes, more than the %d available", len(d), len(b))
+	}
+	copy(b, d)
+	return b, nil
+}
+
+// Create writes a new, empty monolithicSparse VMDK image with a virtual disk of the given size into the
+// provided backend.Storage, which must be writable, and should be empty. The size must be a multiple of 512.
+func Create(b backend.Storage, size int64, opts ...CreateOpt) (*Image, error) {
+	opt, err := applyCreateOpts(size, opts)
+	if err != nil {
+		return nil, err
+	}
+	rw, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+
+	// header, descriptor, then the redundant and the primary grain directories, each followed by all
+	// of their grain tables; grains are allocated after that, starting at a grain boundary
+	h := newHeader(size, opt)
+	h.flags |= flagRedundantGT
+	tablesSectors := h.gdSectors() + h.numGTs()*h.gtSectors()
+	h.rgdOffset = h.descriptorOffset + h.descriptorSize
+	h.gdOffset = h.rgdOffset + tablesSectors
+	h.overHead = (h.gdOffset + tablesSectors + h.grainSize - 1) / h.grainSize * h.grainSize
+
+	d, err := newDescriptor(createTypeMonolithicSparse, h, opt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rw.WriteAt(h.toBytes(), 0); err != nil {
+		return nil, fmt.Errorf("error writing VMDK header: %w", err)
+	}
+	if _, err := rw.WriteAt(d, int64(h.descriptorOffset)*sectorSize); err != nil {
+		return nil, fmt.Errorf("error writing VMDK descriptor: %w", err)
+	}
+	for _, gdOffset := range []uint64{h.rgdOffset, h.gdOffset} {
+		tables := make([]byte, tablesSectors*sectorSize)
+		gtSector := gdOffset + h.gdSectors()
+		for i := uint64(0); i < h.numGTs(); i++ {
+			binary.LittleEndian.PutUint32(tables[i*4:], uint32(gtSector+i*h.gtSectors()))
+		}
+		if _, err := rw.WriteAt(tables, int64(gdOffset)*sectorSize); err != nil {
+			return nil, fmt.Errorf("error writing VMDK grain directory: %w", err)
+		}
+	}
+	// extend the file to the end of the overhead, where the first grain is allocated
+	if _, err := rw.WriteAt(make([]byte, sectorSize), int64(h.overHead-1)*sectorSize); err != nil {
+		return nil, fmt.Errorf("error writing VMDK metadata: %w", err)
+	}
+	return New(b, false)
+}
+
+// CreateFromPath creates a new, empty monolithicSparse VMDK image file with a virtual disk of the given size.
+// The provided file must not exist at the time you call CreateFromPath()
+func CreateFromPath(pathName string, size int64, opts ...CreateOpt) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	f, err := os.OpenFile(pathName, os.O_RDWR|os.O_EXCL|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("could not create image %s: %w", pathName, err)
+	}
+	opts = append([]CreateOpt{WithExtentName(filepath.Base(pathName))}, opts...)
+	img, err := Create(file.New(f, false), size, opts...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not create VMDK image %s: %w", pathName, err)
+	}
+	return img, nil
+}
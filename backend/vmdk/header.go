@@ -0,0 +1,165 @@
+package vmdk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	sectorSize = 512
+	headerSize = 512
+
+	sparseMagic = 0x564d444b // "KDMV"
+
+	// flags of the sparse extent header
+	flagValidNewlineTest = 1 << 0
+	flagRedundantGT      = 1 << 1
+	flagZeroedGrainGTE   = 1 << 2
+	flagCompressed       = 1 << 16
+	flagMarkers          = 1 << 17
+
+	compressionDeflate = 1
+
+	// gdAtEnd is the grain directory offset in the header of a streamOptimized image,
+	// whose grain directory is only known once the whole image is written
+	gdAtEnd uint64 = 0xffffffffffffffff
+
+	// number of grain table entries per grain table, fixed in practice
+	gtesPerGT = 512
+	// a grain table entry of 1 is a zeroed grain, if flagZeroedGrainGTE is set
+	gteZeroed = 1
+
+	createTypeMonolithicSparse = "monolithicSparse"
+	createTypeStreamOptimized  = "streamOptimized"
+)
+
+// header is the sparse extent header at the start of a hosted sparse extent, and of the footer
+// of a streamOptimized image
+type header struct {
+	version           uint32
+	flags             uint32
+	capacity          uint64
+	grainSize         uint64
+	descriptorOffset  uint64
+	descriptorSize    uint64
+	numGTEsPerGT      uint32
+	rgdOffset         uint64
+	gdOffset          uint64
+	overHead          uint64
+	uncleanShutdown   bool
+	compressAlgorithm uint16
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if len(b) < headerSize {
+		return nil, fmt.Errorf("header was %d bytes instead of expected %d", len(b), headerSize)
+	}
+	if magic := binary.LittleEndian.Uint32(b[0:4]); magic != sparseMagic {
+		return nil, fmt.Errorf("invalid VMDK sparse extent magic %#x", magic)
+	}
+	h := &header{
+		version:           binary.LittleEndian.Uint32(b[4:8]),
+		flags:             binary.LittleEndian.Uint32(b[8:12]),
+		capacity:          binary.LittleEndian.Uint64(b[12:20]),
+		grainSize:         binary.LittleEndian.Uint64(b[20:28]),
+		descriptorOffset:  binary.LittleEndian.Uint64(b[28:36]),
+		descriptorSize:    binary.LittleEndian.Uint64(b[36:44]),
+		numGTEsPerGT:      binary.LittleEndian.Uint32(b[44:48]),
+		rgdOffset:         binary.LittleEndian.Uint64(b[48:56]),
+		gdOffset:          binary.LittleEndian.Uint64(b[56:64]),
+		overHead:          binary.LittleEndian.Uint64(b[64:72]),
+		uncleanShutdown:   b[72] != 0,
+		compressAlgorithm: binary.LittleEndian.Uint16(b[77:79]),
+	}
+	if h.version < 1 || h.version > 3 {
+		return nil, fmt.Errorf("unsupported VMDK sparse extent version %d", h.version)
+	}
+	// a file transferred in text mode would have its line endings changed
+	if h.flags&flagValidNewlineTest != 0 && (b[73] != '\n' || b[74] != ' ' || b[75] != '\r' || b[76] != '\n') {
+		return nil, fmt.Errorf("VMDK header newline test failed, the file may have been corrupted by a text mode transfer")
+	}
+	if h.grainSize < 8 || h.grainSize&(h.grainSize-1) != 0 {
+		return nil, fmt.Errorf("invalid grain size of %d sectors", h.grainSize)
+	}
+	if h.numGTEsPerGT != gtesPerGT {
+		return nil, fmt.Errorf("unsupported %d grain table entries per grain table", h.numGTEsPerGT)
+	}
+	switch {
+	case h.flags&flagCompressed == 0:
+	case h.compressAlgorithm == compressionDeflate:
+	default:
+		return nil, fmt.Errorf("unsupported VMDK compression algorithm %d", h.compressAlgorithm)
+	}
+	return h, nil
+}
+
+func (h *header) toBytes() []byte {
+	b := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(b[0:4], sparseMagic)
+	binary.LittleEndian.PutUint32(b[4:8], h.version)
+	binary.LittleEndian.PutUint32(b[8:12], h.flags)
+	binary.LittleEndian.PutUint64(b[12:20], h.capacity)
+	binary.LittleEndian.PutUint64(b[20:28], h.grainSize)
+	binary.LittleEndian.PutUint64(b[28:36], h.descriptorOffset)
+	binary.LittleEndian.PutUint64(b[36:44], h.descriptorSize)
+	binary.LittleEndian.PutUint32(b[44:48], h.numGTEsPerGT)
+	binary.LittleEndian.PutUint64(b[48:56], h.rgdOffset)
+	binary.LittleEndian.PutUint64(b[56:64], h.gdOffset)
+	binary.LittleEndian.PutUint64(b[64:72], h.overHead)
+	if h.uncleanShutdown {
+		b[72] = 1
+	}
+	b[73], b[74], b[75], b[76] = '\n', ' ', '\r', '\n'
+	binary.LittleEndian.PutUint16(b[77:79], h.compressAlgorithm)
+	return b
+}
+
+// numGTs number of grain tables needed to map the whole capacity
+func (h *header) numGTs() uint64 {
+	perGT := h.grainSize * uint64(h.numGTEsPerGT)
+	return (h.capacity + perGT - 1) / perGT
+}
+
+// gdSectors size of the grain directory in sectors
+func (h *header) gdSectors() uint64 {
+	return (h.numGTs()*4 + sectorSize - 1) / sectorSize
+}
+
+// gtSectors size of a grain table in sectors
+func (h *header) gtSectors() uint64 {
+	return (uint64(h.numGTEsPerGT)*4 + sectorSize - 1) / sectorSize
+}
+
+// descriptorCreateType returns the createType of an embedded descriptor
+func descriptorCreateType(descriptor string) string {
+	scanner := bufio.NewScanner(strings.NewReader(descriptor))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found && strings.TrimSpace(key) == "createType" {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// descriptor builds the embedded descriptor of a single extent image
+func descriptor(createType, extentName string, cid uint32, capacity uint64, adapterType string) string {
+	cylinders := min(capacity/(16*63), 16383)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Disk DescriptorFile\n")
+	fmt.Fprintf(&sb, "version=1\n")
+	fmt.Fprintf(&sb, "CID=%08x\n", cid)
+	fmt.Fprintf(&sb, "parentCID=ffffffff\n")
+	fmt.Fprintf(&sb, "createType=%q\n", createType)
+	fmt.Fprintf(&sb, "\n# Extent description\n")
+	fmt.Fprintf(&sb, "RW %d SPARSE %q\n", capacity, extentName)
+	fmt.Fprintf(&sb, "\n# The Disk Data Base\n#DDB\n\n")
+	fmt.Fprintf(&sb, "ddb.virtualHWVersion = \"4\"\n")
+	fmt.Fprintf(&sb, "ddb.adapterType = %q\n", adapterType)
+	fmt.Fprintf(&sb, "ddb.geometry.cylinders = \"%d\"\n", cylinders)
+	fmt.Fprintf(&sb, "ddb.geometry.heads = \"16\"\n")
+	fmt.Fprintf(&sb, "ddb.geometry.sectors = \"63\"\n")
+	return sb.String()
+}
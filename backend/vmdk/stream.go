@@ -0,0 +1,175 @@
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// marker types of streamOptimized images; grain markers have no type, but a non-zero size
+const (
+	markerEOS    uint32 = 0
+	markerGT     uint32 = 1
+	markerGD     uint32 = 2
+	markerFooter uint32 = 3
+
+	// a grain marker is the LBA and size of the compressed grain, directly followed by the data
+	grainMarkerSize = 12
+)
+
+// marker precedes every grain and metadata structure in a streamOptimized image
+type marker struct {
+	// value is the LBA of a grain, or the number of sectors of a metadata structure
+	value      uint64
+	size       uint32
+	markerType uint32
+}
+
+func markerFromBytes(b []byte) marker {
+	m := marker{
+		value: binary.LittleEndian.Uint64(b[0:8]),
+		size:  binary.LittleEndian.Uint32(b[8:12]),
+	}
+	if m.size == 0 {
+		m.markerType = binary.LittleEndian.Uint32(b[12:16])
+	}
+	return m
+}
+
+// metadataMarker returns a whole sector with the marker for a metadata structure
+func metadataMarker(markerType uint32, sectors uint64) []byte {
+	b := make([]byte, sectorSize)
+	binary.LittleEndian.PutUint64(b[0:8], sectors)
+	binary.LittleEndian.PutUint32(b[12:16], markerType)
+	return b
+}
+
+// streamWriter tracks the position in sectors of a sequentially written image
+type streamWriter struct {
+	w      io.Writer
+	offset uint64
+}
+
+// write writes b, padded to a whole number of sectors
+func (s *streamWriter) write(b []byte) error {
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	s.offset += uint64(len(b))
+	if pad := s.offset % sectorSize; pad != 0 {
+		if _, err := s.w.Write(make([]byte, sectorSize-pad)); err != nil {
+			return err
+		}
+		s.offset += sectorSize - pad
+	}
+	return nil
+}
+
+func (s *streamWriter) sector() uint64 {
+	return s.offset / sectorSize
+}
+
+func tableBytes(table []uint32, sectors uint64) []byte {
+	b := make([]byte, sectors*sectorSize)
+	for i, e := range table {
+		binary.LittleEndian.PutUint32(b[i*4:], e)
+	}
+	return b
+}
+
+// WriteStreamOptimized writes the virtual disk of the given size read from src to w as a streamOptimized
+// VMDK image, the compressed format used for OVA files and by VMware for exports. The image is written
+// sequentially, so w can be a pipe or an archive; grains that are entirely zero are not stored.
+func WriteStreamOptimized(w io.Writer, src io.ReaderAt, size int64, opts ...CreateOpt) error {
+	opt, err := applyCreateOpts(size, opts)
+	if err != nil {
+		return err
+	}
+	h := newHeader(size, opt)
+	h.version = 3
+	h.flags |= flagCompressed | flagMarkers
+	h.compressAlgorithm = compressionDeflate
+	h.overHead = (h.descriptorOffset + h.descriptorSize + h.grainSize - 1) / h.grainSize * h.grainSize
+	h.gdOffset = gdAtEnd
+	d, err := newDescriptor(createTypeStreamOptimized, h, opt)
+	if err != nil {
+		return err
+	}
+
+	s := &streamWriter{w: w}
+	if err := s.write(h.toBytes()); err != nil {
+		return fmt.Errorf("error writing VMDK header: %w", err)
+	}
+	if err := s.write(d); err != nil {
+		return fmt.Errorf("error writing VMDK descriptor: %w", err)
+	}
+	if err := s.write(make([]byte, (h.overHead-s.sector())*sectorSize)); err != nil {
+		return fmt.Errorf("error writing VMDK header: %w", err)
+	}
+
+	grainSize := int64(h.grainSize) * sectorSize
+	grains := (size + grainSize - 1) / grainSize
+	gtes := make([]uint32, h.numGTs()*uint64(h.numGTEsPerGT))
+	data := make([]byte, grainSize)
+	zero := make([]byte, grainSize)
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	for grain := int64(0); grain < grains; grain++ {
+		n, err := src.ReadAt(data[:min(grainSize, size-grain*grainSize)], grain*grainSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error reading grain %d: %w", grain, err)
+		}
+		if bytes.Equal(data[:n], zero[:n]) {
+			continue
+		}
+		compressed.Reset()
+		zw.Reset(&compressed)
+		if _, err := zw.Write(data[:n]); err != nil {
+			return fmt.Errorf("error compressing grain %d: %w", grain, err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("error compressing grain %d: %w", grain, err)
+		}
+		b := make([]byte, grainMarkerSize+compressed.Len())
+		binary.LittleEndian.PutUint64(b[0:8], uint64(grain)*h.grainSize)
+		binary.LittleEndian.PutUint32(b[8:12], uint32(compressed.Len()))
+		copy(b[grainMarkerSize:], compressed.Bytes())
+		gtes[grain] = uint32(s.sector())
+		if err := s.write(b); err != nil {
+			return fmt.Errorf("error writing grain %d: %w", grain, err)
+		}
+	}
+
+	gd := make([]uint32, h.numGTs())
+	for i := range gd {
+		if err := s.write(metadataMarker(markerGT, h.gtSectors())); err != nil {
+			return fmt.Errorf("error writing grain table marker: %w", err)
+		}
+		gd[i] = uint32(s.sector())
+		gt := gtes[uint64(i)*uint64(h.numGTEsPerGT) : uint64(i+1)*uint64(h.numGTEsPerGT)]
+		if err := s.write(tableBytes(gt, h.gtSectors())); err != nil {
+			return fmt.Errorf("error writing grain table: %w", err)
+		}
+	}
+	if err := s.write(metadataMarker(markerGD, h.gdSectors())); err != nil {
+		return fmt.Errorf("error writing grain directory marker: %w", err)
+	}
+	footer := *h
+	footer.gdOffset = s.sector()
+	if err := s.write(tableBytes(gd, h.gdSectors())); err != nil {
+		return fmt.Errorf("error writing grain directory: %w", err)
+	}
+	if err := s.write(metadataMarker(markerFooter, 1)); err != nil {
+		return fmt.Errorf("error writing footer marker: %w", err)
+	}
+	if err := s.write(footer.toBytes()); err != nil {
+		return fmt.Errorf("error writing footer: %w", err)
+	}
+	if err := s.write(metadataMarker(markerEOS, 0)); err != nil {
+		return fmt.Errorf("error writing end-of-stream marker: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,491 @@
+// Package vmdk provides a backend for disk images in the VMware virtual disk (VMDK) format.
+//
+// Single-file images are supported: monolithicSparse images can be read, written and created, and
+// streamOptimized images, as found in OVA files and VMware exports, can be read and written sequentially
+// with WriteStreamOptimized.
+package vmdk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// maximum number of cached grain tables
+const maxCachedTables = 256
+
+// Image is a VMDK image presented as a backend.Storage of the size of its virtual disk
+type Image struct {
+	storage  backend.Storage
+	rw       backend.WritableFile
+	readOnly bool
+	header   *header
+
+	createType string
+	gd         []uint32
+	// rgd is the redundant grain directory, if any, which is kept in sync with gd
+	rgd     []uint32
+	gtCache map[uint32][]uint32
+	// nextFree is the sector of the next grain or grain table to allocate, always at the end of the image
+	nextFree uint64
+	// the most recently decompressed grain
+	grainSector uint32
+	grainData   []byte
+
+	mu     sync.Mutex
+	offset int64
+}
+
+// backend.Storage interface guard
+var _ backend.Storage = (*Image)(nil)
+
+// New opens the VMDK image stored in the provided backend.Storage.
+// If readOnly is false, the storage must be writable; compressed images such as streamOptimized
+// images can only be opened read-only.
+func New(b backend.Storage, readOnly bool) (*Image, error) {
+	img := &Image{
+		storage:  b,
+		readOnly: readOnly,
+		gtCache:  map[uint32][]uint32{},
+	}
+	if !readOnly {
+		rw, err := b.Writable()
+		if err != nil {
+			return nil, err
+		}
+		img.rw = rw
+	}
+	if err := img.init(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// OpenFromPath opens an existing VMDK image file
+func OpenFromPath(pathName string, readOnly bool) (*Image, error) {
+	if pathName == "" {
+		return nil, errors.New("must pass image file name")
+	}
+	openMode := os.O_RDONLY
+	if !readOnly {
+		openMode = os.O_RDWR
+	}
+	f, err := os.OpenFile(pathName, openMode, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	img, err := New(file.New(f, readOnly), readOnly)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not open VMDK image %s: %w", pathName, err)
+	}
+	return img, nil
+}
+
+func (img *Image) init() error {
+	b := make([]byte, headerSize)
+	if err := img.readFull(b, 0); err != nil {
+		return fmt.Errorf("error reading VMDK header: %w", err)
+	}
+	if strings.HasPrefix(string(b), "# Disk DescriptorFile") {
+		return errors.New("VMDK descriptor files with separate extents are not supported, only single file images")
+	}
+	h, err := headerFromBytes(b)
+	if err != nil {
+		return err
+	}
+	info, err := img.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat VMDK image: %w", err)
+	}
+	fileSize := info.Size()
+
+	if h.gdOffset == gdAtEnd {
+		// the real header is in the footer, before the end-of-stream marker
+		if fileSize < 3*sectorSize {
+			return errors.New("VMDK image is too small for a footer")
+		}
+		m := make([]byte, sectorSize)
+		if err := img.readFull(m, fileSize-3*sectorSize); err != nil {
+			return fmt.Errorf("error reading VMDK footer marker: %w", err)
+		}
+		if marker := markerFromBytes(m); marker.markerType != markerFooter {
+			return fmt.Errorf("no VMDK footer marker found, instead marker of type %d", marker.markerType)
+		}
+		if err := img.readFull(b, fileSize-2*sectorSize); err != nil {
+			return fmt.Errorf("error reading VMDK footer: %w", err)
+		}
+		if h, err = headerFromBytes(b); err != nil {
+			return fmt.Errorf("invalid VMDK footer: %w", err)
+		}
+		if h.gdOffset == gdAtEnd {
+			return errors.New("VMDK footer has no grain directory offset")
+		}
+	}
+	img.header = h
+
+	if h.descriptorOffset != 0 && h.descriptorSize != 0 {
+		d := make([]byte, h.descriptorSize*sectorSize)
+		if err := img.readFull(d, int64(h.descriptorOffset*sectorSize)); err != nil {
+			return fmt.Errorf("error reading VMDK descriptor: %w", err)
+		}
+		img.createType = descriptorCreateType(string(bytes.TrimRight(d, "\x00")))
+		switch img.createType {
+		case createTypeMonolithicSparse, createTypeStreamOptimized:
+		default:
+			return fmt.Errorf("unsupported VMDK type %q", img.createType)
+		}
+	}
+	if !img.readOnly && h.flags&flagCompressed != 0 {
+		return errors.New("compressed VMDK images can only be opened read-only")
+	}
+
+	if img.gd, err = img.readTable(h.gdOffset, h.numGTs()); err != nil {
+		return fmt.Errorf("error reading grain directory: %w", err)
+	}
+	if h.flags&flagRedundantGT != 0 && h.rgdOffset != 0 {
+		if img.rgd, err = img.readTable(h.rgdOffset, h.numGTs()); err != nil {
+			return fmt.Errorf("error reading redundant grain directory: %w", err)
+		}
+	}
+	img.nextFree = (uint64(fileSize) + sectorSize - 1) / sectorSize
+	return nil
+}
+
+// readTable reads a table of little-endian uint32 entries at the sector
+func (img *Image) readTable(sector, entries uint64) ([]uint32, error) {
+	b := make([]byte, entries*4)
+	if err := img.readFull(b, int64(sector*sectorSize)); err != nil {
+		return nil, err
+	}
+	table := make([]uint32, entries)
+	for i := range table {
+		table[i] = binary.LittleEndian.Uint32(b[i*4 : i*4+4])
+	}
+	return table, nil
+}
+
+// grainTable returns the grain table at the sector
+func (img *Image) grainTable(sector uint32) ([]uint32, error) {
+	if gt, ok := img.gtCache[sector]; ok {
+		return gt, nil
+	}
+	gt, err := img.readTable(uint64(sector), uint64(img.header.numGTEsPerGT))
+	if err != nil {
+		return nil, fmt.Errorf("error reading grain table at sector %d: %w", sector, err)
+	}
+	if len(img.gtCache) >= maxCachedTables {
+		clear(img.gtCache)
+	}
+	img.gtCache[sector] = gt
+	return gt, nil
+}
+
+// readFull reads len(b) bytes from the underlying storage, treating data past the end of the image as zero
+func (img *Image) readFull(b []byte, offset int64) error {
+	n, err := img.storage.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	clear(b[n:])
+	return nil
+}
+
+func (img *Image) writeFull(b []byte, offset int64) error {
+	n, err := img.rw.WriteAt(b, offset)
+	if err != nil {
+		return err
+	}
+	if n != len(b) {
+		return fmt.Errorf("wrote %d bytes at %d instead of %d", n, offset, len(b))
+	}
+	return nil
+}
+
+func (img *Image) grainBytes() int64 {
+	return int64(img.header.grainSize) * sectorSize
+}
+
+// Size is the size of the virtual disk
+func (img *Image) Size() int64 {
+	return int64(img.header.capacity) * sectorSize
+}
+
+// GrainSize is the size of the grains in which the image is allocated
+func (img *Image) GrainSize() int64 {
+	return img.grainBytes()
+}
+
+// StreamOptimized whether the image is a compressed, streamOptimized image
+func (img *Image) StreamOptimized() bool {
+	return img.header.flags&flagCompressed != 0
+}
+
+// lookup returns the grain table entry for the grain containing the virtual offset, 0 if not allocated
+func (img *Image) lookup(virtual int64) (uint32, error) {
+	grain := uint64(virtual / img.grainBytes())
+	gdIndex := grain / uint64(img.header.numGTEsPerGT)
+	if gdIndex >= uint64(len(img.gd)) || img.gd[gdIndex] == 0 {
+		return 0, nil
+	}
+	gt, err := img.grainTable(img.gd[gdIndex])
+	if err != nil {
+		return 0, err
+	}
+	return gt[grain%uint64(img.header.numGTEsPerGT)], nil
+}
+
+// readCompressed returns the decompressed contents of the compressed grain at the sector
+func (img *Image) readCompressed(sector uint32) ([]byte, error) {
+	if img.grainData != nil && img.grainSector == sector {
+		return img.grainData, nil
+	}
+	m := make([]byte, grainMarkerSize)
+	if err := img.readFull(m, int64(sector)*sectorSize); err != nil {
+		return nil, fmt.Errorf("error reading grain marker at sector %d: %w", sector, err)
+	}
+	size := binary.LittleEndian.Uint32(m[8:12])
+	if int64(size) > 2*img.grainBytes()+sectorSize {
+		return nil, fmt.Errorf("compressed grain at sector %d has invalid size %d", sector, size)
+	}
+	compressed := make([]byte, size)
+	if err := img.readFull(compressed, int64(sector)*sectorSize+grainMarkerSize); err != nil {
+		return nil, fmt.Errorf("error reading compressed grain at sector %d: %w", sector, err)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing grain at sector %d: %w", sector, err)
+	}
+	defer r.Close()
+	data := make([]byte, img.grainBytes())
+	// the last grain of an image may be short
+	if _, err := io.ReadFull(r, data); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("error decompressing grain at sector %d: %w", sector, err)
+	}
+	img.grainSector, img.grainData = sector, data
+	return data, nil
+}
+
+// readGrain reads part of a single grain into b, which must not cross a grain boundary
+func (img *Image) readGrain(b []byte, virtual int64) error {
+	entry, err := img.lookup(virtual)
+	if err != nil {
+		return err
+	}
+	inGrain := virtual % img.grainBytes()
+	switch {
+	case entry == 0, entry == gteZeroed && img.header.flags&flagZeroedGrainGTE != 0:
+		clear(b)
+	case img.header.flags&flagCompressed != 0:
+		data, err := img.readCompressed(entry)
+		if err != nil {
+			return err
+		}
+		copy(b, data[inGrain:])
+	default:
+		return img.readFull(b, int64(entry)*sectorSize+inGrain)
+	}
+	return nil
+}
+
+// ReadAt reads from the virtual disk
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	return img.readAt(p, off)
+}
+
+func (img *Image) readAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	size := img.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if off+int64(len(p)) > size {
+		p = p[:size-off]
+		eof = io.EOF
+	}
+	grainSize := img.grainBytes()
+	read := 0
+	for read < len(p) {
+		virtual := off + int64(read)
+		chunk := int(min(int64(len(p)-read), grainSize-virtual%grainSize))
+		if err := img.readGrain(p[read:read+chunk], virtual); err != nil {
+			return read, err
+		}
+		read += chunk
+	}
+	return read, eof
+}
+
+// WriteAt writes to the virtual disk, allocating grains as needed
+func (img *Image) WriteAt(p []byte, off int64) (int, error) {
+	if img.readOnly {
+		return 0, backend.ErrIncorrectOpenMode
+	}
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	if off < 0 || off+int64(len(p)) > img.Size() {
+		return 0, fmt.Errorf("write of %d bytes at %d is outside of the virtual disk of size %d", len(p), off, img.Size())
+	}
+	grainSize := img.grainBytes()
+	written := 0
+	for written < len(p) {
+		virtual := off + int64(written)
+		chunk := int(min(int64(len(p)-written), grainSize-virtual%grainSize))
+		if err := img.writeGrain(p[written:written+chunk], virtual); err != nil {
+			return written, err
+		}
+		written += chunk
+	}
+	return written, nil
+}
+
+// writeGrain writes part of a single grain, which must not cross a grain boundary
+func (img *Image) writeGrain(b []byte, virtual int64) error {
+	entry, err := img.lookup(virtual)
+	if err != nil {
+		return err
+	}
+	inGrain := virtual % img.grainBytes()
+	if entry != 0 && (entry != gteZeroed || img.header.flags&flagZeroedGrainGTE == 0) {
+		return img.writeFull(b, int64(entry)*sectorSize+inGrain)
+	}
+
+	// allocate a new grain at the end of the image, and write the whole of it
+	data := make([]byte, img.grainBytes())
+	copy(data[inGrain:], b)
+	sector := img.allocate(img.header.grainSize)
+	if err := img.writeFull(data, int64(sector)*sectorSize); err != nil {
+		return fmt.Errorf("error writing grain at sector %d: %w", sector, err)
+	}
+	grain := uint64(virtual / img.grainBytes())
+	gdIndex := grain / uint64(img.header.numGTEsPerGT)
+	gtIndex := grain % uint64(img.header.numGTEsPerGT)
+	if err := img.setGTE(img.gd, img.header.gdOffset, gdIndex, gtIndex, sector); err != nil {
+		return err
+	}
+	if img.rgd != nil {
+		return img.setGTE(img.rgd, img.header.rgdOffset, gdIndex, gtIndex, sector)
+	}
+	return nil
+}
+
+// allocate allocates the given number of sectors at the end of the image, returning the first sector
+func (img *Image) allocate(sectors uint64) uint32 {
+	sector := img.nextFree
+	img.nextFree += sectors
+	return uint32(sector)
+}
+
+// setGTE sets a grain table entry in the grain tables referenced by a grain directory,
+// allocating the grain table if needed
+func (img *Image) setGTE(gd []uint32, gdSector, gdIndex, gtIndex uint64, value uint32) error {
+	b := make([]byte, 4)
+	if gd[gdIndex] == 0 {
+		gtSector := img.allocate(img.header.gtSectors())
+		if err := img.writeFull(make([]byte, img.header.gtSectors()*sectorSize), int64(gtSector)*sectorSize); err != nil {
+			return fmt.Errorf("error writing grain table at sector %d: %w", gtSector, err)
+		}
+		img.gtCache[gtSector] = make([]uint32, img.header.numGTEsPerGT)
+		binary.LittleEndian.PutUint32(b, gtSector)
+		if err := img.writeFull(b, int64(gdSector*sectorSize+gdIndex*4)); err != nil {
+			return fmt.Errorf("error updating grain directory: %w", err)
+		}
+		gd[gdIndex] = gtSector
+	}
+	gt, err := img.grainTable(gd[gdIndex])
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(b, value)
+	if err := img.writeFull(b, int64(gd[gdIndex])*sectorSize+int64(gtIndex)*4); err != nil {
+		return fmt.Errorf("error updating grain table: %w", err)
+	}
+	gt[gtIndex] = value
+	return nil
+}
+
+// Stat returns the file info of the image, but with the size of the virtual disk
+func (img *Image) Stat() (fs.FileInfo, error) {
+	info, err := img.storage.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &imageInfo{FileInfo: info, size: img.Size()}, nil
+}
+
+// Read reads from the virtual disk at the current offset
+func (img *Image) Read(b []byte) (int, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	n, err := img.readAt(b, img.offset)
+	img.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset for the next Read in the virtual disk
+func (img *Image) Seek(offset int64, whence int) (int64, error) {
+	img.mu.Lock()
+	defer img.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += img.offset
+	case io.SeekEnd:
+		offset += img.Size()
+	default:
+		return img.offset, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return img.offset, fmt.Errorf("cannot seek to negative offset %d", offset)
+	}
+	img.offset = offset
+	return offset, nil
+}
+
+// Sync syncs the image file
+func (img *Image) Sync() error {
+	return backend.Sync(img.storage)
+}
+
+// Close closes the underlying storage
+func (img *Image) Close() error {
+	return img.storage.Close()
+}
+
+// Sys is not suitable for VMDK images, as ioctls on the image file do not apply to the virtual disk
+func (img *Image) Sys() (*os.File, error) {
+	return nil, backend.ErrNotSuitable
+}
+
+// Writable returns the image for read-write operations
+func (img *Image) Writable() (backend.WritableFile, error) {
+	if img.readOnly {
+		return nil, backend.ErrIncorrectOpenMode
+	}
+	return img, nil
+}
+
+// imageInfo reports the size of the virtual disk instead of the image file
+type imageInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i *imageInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,170 @@
+package vmdk_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/vmdk"
+)
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		opts []vmdk.CreateOpt
+		err  bool
+	}{
+		{"default", 10 * 1024 * 1024, nil, false},
+		{"small grains", 10 * 1024 * 1024, []vmdk.CreateOpt{vmdk.WithGrainSize(4096), vmdk.WithAdapterType("lsilogic")}, false},
+		{"unaligned size", 10*1024*1024 + 1, nil, true},
+		{"invalid grain size", 10 * 1024 * 1024, []vmdk.CreateOpt{vmdk.WithGrainSize(1000)}, true},
+		{"invalid adapter", 10 * 1024 * 1024, []vmdk.CreateOpt{vmdk.WithAdapterType("floppy")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "test.vmdk")
+			img, err := vmdk.CreateFromPath(p, tt.size, tt.opts...)
+			switch {
+			case tt.err && err == nil:
+				img.Close()
+				t.Fatalf("expected error, got none")
+			case !tt.err && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case err != nil:
+				return
+			}
+			defer img.Close()
+			if img.Size() != tt.size {
+				t.Errorf("size %d instead of %d", img.Size(), tt.size)
+			}
+			if img.StreamOptimized() {
+				t.Errorf("new image should not be streamOptimized")
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{`createType="monolithicSparse"`, `RW 20480 SPARSE "test.vmdk"`} {
+				if !bytes.Contains(b, []byte(s)) {
+					t.Errorf("descriptor does not contain %s", s)
+				}
+			}
+		})
+	}
+}
+
+func randomDisk(t *testing.T, size int64) []byte {
+	t.Helper()
+	expected := make([]byte, size)
+	for _, w := range []struct {
+		offset int64
+		length int
+	}{
+		{0, 512},
+		{64*1024 - 100, 300},      // crosses a grain boundary
+		{1024 * 1024, 300000},     // many grains
+		{1024*1024 + 700, 50},     // rewrite
+		{size - 512, 512},         // end of the disk
+		{3*1024*1024 + 1, 100003}, // unaligned start and end
+	} {
+		_, _ = rand.Read(expected[w.offset : w.offset+int64(w.length)])
+	}
+	return expected
+}
+
+func TestReadWrite(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "test.vmdk")
+	size := int64(4*1024*1024 + 512)
+	img, err := vmdk.CreateFromPath(p, size)
+	if err != nil {
+		t.Fatalf("unexpected error creating image: %v", err)
+	}
+	expected := randomDisk(t, size)
+	// write in uneven chunks
+	for off := int64(0); off < size; off += 77777 {
+		end := min(size, off+77777)
+		if bytes.Equal(expected[off:end], make([]byte, end-off)) {
+			continue
+		}
+		if _, err := img.WriteAt(expected[off:end], off); err != nil {
+			t.Fatalf("error writing at %d: %v", off, err)
+		}
+	}
+	if _, err := img.WriteAt([]byte{1}, size); err == nil {
+		t.Errorf("expected error writing beyond end of disk")
+	}
+
+	check := func(img *vmdk.Image) {
+		t.Helper()
+		b := make([]byte, size)
+		if _, err := img.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading image: %v", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched image contents")
+		}
+		if _, err := img.ReadAt(make([]byte, 10), size-5); err != io.EOF {
+			t.Errorf("expected io.EOF reading past the end, got %v", err)
+		}
+	}
+	check(img)
+	if err := img.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img, err = vmdk.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error reopening image: %v", err)
+	}
+	defer img.Close()
+	check(img)
+	if _, err := img.WriteAt([]byte{1}, 0); err == nil {
+		t.Errorf("expected error writing to read-only image")
+	}
+}
+
+func TestStreamOptimized(t *testing.T) {
+	size := int64(4*1024*1024 + 512)
+	expected := randomDisk(t, size)
+	var buf bytes.Buffer
+	if err := vmdk.WriteStreamOptimized(&buf, bytes.NewReader(expected), size, vmdk.WithExtentName("export.vmdk")); err != nil {
+		t.Fatalf("error writing streamOptimized image: %v", err)
+	}
+	if !strings.Contains(buf.String(), `createType="streamOptimized"`) {
+		t.Errorf("descriptor does not contain streamOptimized create type")
+	}
+	// the random data does not compress, but the zero grains are skipped
+	if int64(buf.Len()) >= size {
+		t.Errorf("streamOptimized image is %d bytes, expected zero grains to be skipped", buf.Len())
+	}
+
+	p := filepath.Join(t.TempDir(), "export.vmdk")
+	if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vmdk.OpenFromPath(p, false); err == nil {
+		t.Errorf("expected error opening streamOptimized image for writing")
+	}
+	img, err := vmdk.OpenFromPath(p, true)
+	if err != nil {
+		t.Fatalf("error opening streamOptimized image: %v", err)
+	}
+	defer img.Close()
+	if !img.StreamOptimized() {
+		t.Errorf("image should be streamOptimized")
+	}
+	if img.Size() != size {
+		t.Errorf("size %d instead of %d", img.Size(), size)
+	}
+	b, err := io.ReadAll(img)
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("mismatched image contents")
+	}
+}
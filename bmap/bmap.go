@@ -0,0 +1,204 @@
+// Package bmap generates block maps of raw disk images in the format of bmaptool.
+//
+// A block map lists the ranges of blocks of an image that hold data, with their checksums, so that
+// bmaptool copy only writes those ranges when flashing the image, and verifies them as it goes.
+// Blocks that are entirely zero are left out of the map; as bmaptool does not write them, the flashed
+// device keeps its previous contents there, which is correct for the free space of partitions and
+// filesystems, but not where an image relies on zeroed blocks.
+package bmap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// DefaultBlockSize is the block size of generated block maps, as with bmaptool
+	DefaultBlockSize = 4096
+
+	version      = "2.0"
+	checksumType = "sha256"
+)
+
+// Range is a range of consecutive blocks holding data
+type Range struct {
+	// First and Last are the indexes of first and last block of the range, inclusive
+	First, Last uint64
+	// Checksum is the hex-encoded sha256 of the data of the range
+	Checksum string
+}
+
+// BlockMap is the block map of an image
+type BlockMap struct {
+	ImageSize   int64
+	BlockSize   int64
+	BlocksCount uint64
+	Ranges      []Range
+}
+
+type generateOpts struct {
+	blockSize int64
+}
+
+// GenerateOpt func that process Generate options
+type GenerateOpt func(o *generateOpts) error
+
+// WithBlockSize sets the size of the blocks of the map, which must be a positive multiple of 512.
+// Default is DefaultBlockSize.
+func WithBlockSize(size int64) GenerateOpt {
+	return func(o *generateOpts) error {
+		if size <= 0 || size%512 != 0 {
+			return fmt.Errorf("invalid block size %d, must be a positive multiple of 512", size)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// Generate scans the image of the given size read from r, and returns the block map of its blocks
+// that are not entirely zero
+func Generate(r io.ReaderAt, size int64, opts ...GenerateOpt) (*BlockMap, error) {
+	opt := &generateOpts{
+		blockSize: DefaultBlockSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("invalid image size %d", size)
+	}
+	m := &BlockMap{
+		ImageSize:   size,
+		BlockSize:   opt.blockSize,
+		BlocksCount: uint64((size + opt.blockSize - 1) / opt.blockSize),
+	}
+
+	data := make([]byte, opt.blockSize)
+	zero := make([]byte, opt.blockSize)
+	var (
+		current *Range
+		h       hash.Hash
+	)
+	closeRange := func() {
+		if current != nil {
+			current.Checksum = hex.EncodeToString(h.Sum(nil))
+			m.Ranges = append(m.Ranges, *current)
+			current = nil
+		}
+	}
+	for block := uint64(0); block < m.BlocksCount; block++ {
+		offset := int64(block) * opt.blockSize
+		b := data[:min(opt.blockSize, size-offset)]
+		n, err := r.ReadAt(b, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error reading block %d: %w", block, err)
+		}
+		if n != len(b) {
+			return nil, fmt.Errorf("error reading block %d: read %d bytes instead of %d", block, n, len(b))
+		}
+		if bytes.Equal(b, zero[:len(b)]) {
+			closeRange()
+			continue
+		}
+		if current == nil {
+			current = &Range{First: block}
+			h = sha256.New()
+		}
+		current.Last = block
+		h.Write(b)
+	}
+	closeRange()
+	return m, nil
+}
+
+// MappedBlocksCount is the number of blocks in the ranges of the map
+func (m *BlockMap) MappedBlocksCount() uint64 {
+	var count uint64
+	for _, r := range m.Ranges {
+		count += r.Last - r.First + 1
+	}
+	return count
+}
+
+// WriteTo writes the block map as a bmap XML file, which bmaptool accepts with the image
+func (m *BlockMap) WriteTo(w io.Writer) (int64, error) {
+	// the checksum of the file is computed with its own field set to zeroes
+	b := m.bytes(strings.Repeat("0", sha256.Size*2))
+	sum := sha256.Sum256(b)
+	b = m.bytes(hex.EncodeToString(sum[:]))
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (m *BlockMap) bytes(fileChecksum string) []byte {
+	var sb strings.Builder
+	mapped := m.MappedBlocksCount()
+	fmt.Fprintf(&sb, "<?xml version=\"1.0\" ?>\n")
+	fmt.Fprintf(&sb, "<!-- This file contains the block map for an image file, which is basically\n")
+	fmt.Fprintf(&sb, "     a list of useful (mapped) block numbers in the image file. In other words,\n")
+	fmt.Fprintf(&sb, "     it lists only those blocks which contain data (boot sector, partition\n")
+	fmt.Fprintf(&sb, "     table, file-system metadata, files, directories, extents, etc). These\n")
+	fmt.Fprintf(&sb, "     blocks have to be copied to the target device. The other blocks do not\n")
+	fmt.Fprintf(&sb, "     contain any useful data and do not have to be copied to the target\n")
+	fmt.Fprintf(&sb, "     device. Generated by %s -->\n\n", util.AppNameVersion)
+	fmt.Fprintf(&sb, "<bmap version=\"%s\">\n", version)
+	fmt.Fprintf(&sb, "    <!-- Image size in bytes: %s -->\n", humanSize(m.ImageSize))
+	fmt.Fprintf(&sb, "    <ImageSize> %d </ImageSize>\n\n", m.ImageSize)
+	fmt.Fprintf(&sb, "    <!-- Size of a block in bytes -->\n")
+	fmt.Fprintf(&sb, "    <BlockSize> %d </BlockSize>\n\n", m.BlockSize)
+	fmt.Fprintf(&sb, "    <!-- Count of blocks in the image file -->\n")
+	fmt.Fprintf(&sb, "    <BlocksCount> %d </BlocksCount>\n\n", m.BlocksCount)
+	fmt.Fprintf(&sb, "    <!-- Count of mapped blocks: %s or %.1f%% -->\n", humanSize(int64(mapped)*m.BlockSize), percent(mapped, m.BlocksCount))
+	fmt.Fprintf(&sb, "    <MappedBlocksCount> %d </MappedBlocksCount>\n\n", mapped)
+	fmt.Fprintf(&sb, "    <!-- Type of checksum used in this file -->\n")
+	fmt.Fprintf(&sb, "    <ChecksumType> %s </ChecksumType>\n\n", checksumType)
+	fmt.Fprintf(&sb, "    <!-- The checksum of this bmap file. When it is calculated, the value of\n")
+	fmt.Fprintf(&sb, "         the checksum has be zero (all ASCII \"0\" symbols).  -->\n")
+	fmt.Fprintf(&sb, "    <BmapFileChecksum> %s </BmapFileChecksum>\n\n", fileChecksum)
+	fmt.Fprintf(&sb, "    <!-- The block map which consists of elements which may either be a\n")
+	fmt.Fprintf(&sb, "         range of blocks or a single block. The 'chksum' attribute\n")
+	fmt.Fprintf(&sb, "         (if present) is the checksum of this blocks range. -->\n")
+	fmt.Fprintf(&sb, "    <BlockMap>\n")
+	for _, r := range m.Ranges {
+		if r.First == r.Last {
+			fmt.Fprintf(&sb, "        <Range chksum=\"%s\"> %d </Range>\n", r.Checksum, r.First)
+		} else {
+			fmt.Fprintf(&sb, "        <Range chksum=\"%s\"> %d-%d </Range>\n", r.Checksum, r.First, r.Last)
+		}
+	}
+	fmt.Fprintf(&sb, "    </BlockMap>\n")
+	fmt.Fprintf(&sb, "</bmap>\n")
+	return []byte(sb.String())
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// humanSize formats a size in bytes with a binary unit, as in the comments of bmaptool
+func humanSize(size int64) string {
+	units := []string{"bytes", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
@@ -0,0 +1,118 @@
+package bmap_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/bmap"
+)
+
+// bmapFile is the part of a bmap file checked by the tests
+type bmapFile struct {
+	Version           string `xml:"version,attr"`
+	ImageSize         string `xml:"ImageSize"`
+	BlockSize         string `xml:"BlockSize"`
+	BlocksCount       string `xml:"BlocksCount"`
+	MappedBlocksCount string `xml:"MappedBlocksCount"`
+	ChecksumType      string `xml:"ChecksumType"`
+	BmapFileChecksum  string `xml:"BmapFileChecksum"`
+	Ranges            []struct {
+		Checksum string `xml:"chksum,attr"`
+		Blocks   string `xml:",chardata"`
+	} `xml:"BlockMap>Range"`
+}
+
+func TestGenerate(t *testing.T) {
+	blockSize := int64(bmap.DefaultBlockSize)
+	// blocks 0-1 and 3 have data, 4-7 are zero, and the last, partial block 8 has data
+	size := 8*blockSize + 1000
+	image := make([]byte, size)
+	_, _ = rand.Read(image[100 : 2*blockSize-1])
+	image[3*blockSize+5] = 1
+	_, _ = rand.Read(image[8*blockSize:])
+
+	m, err := bmap.Generate(bytes.NewReader(image), size)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sum := func(start, end int64) string {
+		s := sha256.Sum256(image[start:end])
+		return hex.EncodeToString(s[:])
+	}
+	expected := []bmap.Range{
+		{First: 0, Last: 1, Checksum: sum(0, 2*blockSize)},
+		{First: 3, Last: 3, Checksum: sum(3*blockSize, 4*blockSize)},
+		{First: 8, Last: 8, Checksum: sum(8*blockSize, size)},
+	}
+	if len(m.Ranges) != len(expected) {
+		t.Fatalf("ranges %+v instead of %+v", m.Ranges, expected)
+	}
+	for i := range expected {
+		if m.Ranges[i] != expected[i] {
+			t.Errorf("range %d is %+v instead of %+v", i, m.Ranges[i], expected[i])
+		}
+	}
+	if m.BlocksCount != 9 || m.MappedBlocksCount() != 4 {
+		t.Errorf("%d blocks and %d mapped instead of 9 and 4", m.BlocksCount, m.MappedBlocksCount())
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var f bmapFile
+	if err := xml.Unmarshal(buf.Bytes(), &f); err != nil {
+		t.Fatalf("invalid bmap XML: %v", err)
+	}
+	for _, v := range []struct{ name, value, expected string }{
+		{"version", f.Version, "2.0"},
+		{"ImageSize", strings.TrimSpace(f.ImageSize), "33768"},
+		{"BlockSize", strings.TrimSpace(f.BlockSize), "4096"},
+		{"BlocksCount", strings.TrimSpace(f.BlocksCount), "9"},
+		{"MappedBlocksCount", strings.TrimSpace(f.MappedBlocksCount), "4"},
+		{"ChecksumType", strings.TrimSpace(f.ChecksumType), "sha256"},
+	} {
+		if v.value != v.expected {
+			t.Errorf("%s is %q instead of %q", v.name, v.value, v.expected)
+		}
+	}
+	blocks := []string{"0-1", "3", "8"}
+	if len(f.Ranges) != len(blocks) {
+		t.Fatalf("%d ranges in the file instead of %d", len(f.Ranges), len(blocks))
+	}
+	for i, r := range f.Ranges {
+		if strings.TrimSpace(r.Blocks) != blocks[i] || r.Checksum != expected[i].Checksum {
+			t.Errorf("range %d is %q %s instead of %q %s", i, r.Blocks, r.Checksum, blocks[i], expected[i].Checksum)
+		}
+	}
+
+	// the file checksum is that of the file with the checksum replaced by zeroes
+	fileChecksum := strings.TrimSpace(f.BmapFileChecksum)
+	zeroed := strings.Replace(buf.String(), fileChecksum, strings.Repeat("0", 64), 1)
+	if s := sha256.Sum256([]byte(zeroed)); hex.EncodeToString(s[:]) != fileChecksum {
+		t.Errorf("invalid bmap file checksum %s", fileChecksum)
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	image := make([]byte, 64*1024)
+	image[10*1024] = 1
+	m, err := bmap.Generate(bytes.NewReader(image), int64(len(image)), bmap.WithBlockSize(1024))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Ranges) != 1 || m.Ranges[0].First != 10 || m.Ranges[0].Last != 10 {
+		t.Errorf("unexpected ranges %+v", m.Ranges)
+	}
+	if _, err := bmap.Generate(bytes.NewReader(image), int64(len(image)), bmap.WithBlockSize(1000)); err == nil {
+		t.Errorf("expected error for invalid block size")
+	}
+	if _, err := bmap.Generate(bytes.NewReader(image), int64(len(image))+4096); err == nil {
+		t.Errorf("expected error for image shorter than its size")
+	}
+}
@@ -0,0 +1,398 @@
+// Package bootimg reads and writes Android boot images, the boot.img of header versions 0 to 4
+// with the kernel, ramdisk, device tree and command line that bootloaders start, and the
+// vendor_boot.img of versions 3 and 4 that holds the vendor ramdisks and device tree of devices
+// with a generic kernel image:
+//
+//	img, err := bootimg.Read(f, size)
+//	img.Cmdline += " androidboot.selinux=permissive"
+//	err = img.Write(out)
+//
+// Images are unpacked and repacked as mkbootimg does, with each part padded to the page size.
+// Images with an AVB footer are read, but repacking them drops the footer.
+package bootimg
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the id of boot images is a SHA1 digest of their contents
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	bootMagic = "ANDROID!"
+	// header sizes of the versions of boot images, of which versions 0 to 2 are padded to the
+	// page size, and 3 and 4 to pageSizeV3
+	headerSizeV0 = 1632
+	headerSizeV1 = 1648
+	headerSizeV2 = 1660
+	headerSizeV3 = 1580
+	headerSizeV4 = 1584
+	pageSizeV3   = 4096
+	// sizes of the command lines, with their terminating zero
+	cmdlineSize      = 512
+	extraCmdlineSize = 1024
+	cmdlineSizeV3    = 1536
+	nameSize         = 16
+	// DefaultPageSize is the default page size of images of versions 0 to 2
+	DefaultPageSize = 2048
+)
+
+// load addresses of mkbootimg, from the base address
+const (
+	DefaultBase         = 0x10000000
+	defaultKernelOffset = 0x00008000
+	defaultRamdisk      = 0x01000000
+	defaultSecond       = 0x00f00000
+	defaultTags         = 0x00000100
+	defaultDTB          = 0x01f00000
+)
+
+// ErrNoMagic is returned by Read and ReadVendor when there is no boot image of the kind
+var ErrNoMagic = errors.New("no Android boot image magic")
+
+// Image is an Android boot image, boot.img or recovery.img
+type Image struct {
+	// HeaderVersion is the version of the header, from 0 to 4, which decides which of the other
+	// fields are written
+	HeaderVersion uint32
+	// Kernel and Ramdisk are the kernel and the generic ramdisk, usually a compressed cpio archive
+	Kernel  []byte
+	Ramdisk []byte
+	// Second is the second stage bootloader of versions 0 to 2, if any
+	Second []byte
+	// RecoveryDTBO is the device tree overlay of recovery images of versions 1 and 2, if any
+	RecoveryDTBO []byte
+	// DTB is the device tree blob of version 2, if any
+	DTB []byte
+	// Signature is the boot signature of version 4, if any
+	Signature []byte
+	// Cmdline is the kernel command line, of up to 1534 bytes for versions 0 to 2, in the
+	// cmdline and extra_cmdline of the header, and 1535 for versions 3 and 4
+	Cmdline string
+	// Name is the product name of versions 0 to 2
+	Name string
+	// OSVersion and OSPatchLevel are the version of Android, e.g. "14.0.0", and its security
+	// patch level, e.g. "2024-03", or "" if not set
+	OSVersion    string
+	OSPatchLevel string
+	// PageSize is the page size of versions 0 to 2, to which the parts of the image are aligned,
+	// DefaultPageSize if 0. Versions 3 and 4 have pages of 4096 bytes.
+	PageSize uint32
+	// KernelAddr, RamdiskAddr, SecondAddr, TagsAddr and DTBAddr are the physical load addresses
+	// of versions 0 to 2, see SetDefaultAddresses
+	KernelAddr  uint32
+	RamdiskAddr uint32
+	SecondAddr  uint32
+	TagsAddr    uint32
+	DTBAddr     uint64
+}
+
+// SetDefaultAddresses sets the load addresses of the image from a base address, with the offsets
+// of mkbootimg, e.g. DefaultBase
+func (img *Image) SetDefaultAddresses(base uint32) {
+	img.KernelAddr = base + defaultKernelOffset
+	img.RamdiskAddr = base + defaultRamdisk
+	img.SecondAddr = base + defaultSecond
+	img.TagsAddr = base + defaultTags
+	img.DTBAddr = uint64(base) + defaultDTB
+}
+
+// pageSize returns the page size to which the parts of the image are aligned
+func (img *Image) pageSize() uint32 {
+	switch {
+	case img.HeaderVersion >= 3:
+		return pageSizeV3
+	case img.PageSize == 0:
+		return DefaultPageSize
+	}
+	return img.PageSize
+}
+
+// Read reads the boot image of size bytes of r. It returns ErrNoMagic if it is not a boot image.
+func Read(r io.ReaderAt, size int64) (*Image, error) {
+	// images of version 3 and 4 have a smaller header
+	h, err := readPart(r, size, 0, uint32(min(size, headerSizeV2)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading boot image header: %w", err)
+	}
+	if len(h) < headerSizeV4 || string(h[0:8]) != bootMagic {
+		return nil, ErrNoMagic
+	}
+	le := binary.LittleEndian
+	img := &Image{}
+	// the header version is at the same offset in all versions
+	img.HeaderVersion = le.Uint32(h[40:44])
+	if img.HeaderVersion >= 3 {
+		return readV3(r, size, h)
+	}
+	if len(h) < headerSizeV2 {
+		return nil, fmt.Errorf("boot image of %d bytes is shorter than its header", size)
+	}
+	img.KernelAddr = le.Uint32(h[12:16])
+	img.RamdiskAddr = le.Uint32(h[20:24])
+	img.SecondAddr = le.Uint32(h[28:32])
+	img.TagsAddr = le.Uint32(h[32:36])
+	img.PageSize = le.Uint32(h[36:40])
+	if err := checkPageSize(img.PageSize); err != nil {
+		return nil, err
+	}
+	img.OSVersion, img.OSPatchLevel = decodeOSVersion(le.Uint32(h[44:48]))
+	img.Name = cString(h[48:64])
+	img.Cmdline = cString(h[64:576]) + cString(h[608:1632])
+
+	sizes := []uint32{le.Uint32(h[8:12]), le.Uint32(h[16:20]), le.Uint32(h[24:28])}
+	parts := []*[]byte{&img.Kernel, &img.Ramdisk, &img.Second}
+	if img.HeaderVersion >= 1 {
+		sizes = append(sizes, le.Uint32(h[1632:1636]))
+		parts = append(parts, &img.RecoveryDTBO)
+	}
+	if img.HeaderVersion >= 2 {
+		sizes = append(sizes, le.Uint32(h[1648:1652]))
+		parts = append(parts, &img.DTB)
+		img.DTBAddr = le.Uint64(h[1652:1660])
+	}
+	if err := readParts(r, size, int64(img.PageSize), int64(img.PageSize), sizes, parts); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// readV3 reads the rest of a boot image of version 3 or 4 with its header
+func readV3(r io.ReaderAt, size int64, h []byte) (*Image, error) {
+	le := binary.LittleEndian
+	img := &Image{HeaderVersion: le.Uint32(h[40:44])}
+	img.OSVersion, img.OSPatchLevel = decodeOSVersion(le.Uint32(h[16:20]))
+	img.Cmdline = cString(h[44 : 44+cmdlineSizeV3])
+	sizes := []uint32{le.Uint32(h[8:12]), le.Uint32(h[12:16])}
+	parts := []*[]byte{&img.Kernel, &img.Ramdisk}
+	if img.HeaderVersion >= 4 {
+		sizes = append(sizes, le.Uint32(h[1580:1584]))
+		parts = append(parts, &img.Signature)
+	}
+	if err := readParts(r, size, pageSizeV3, pageSizeV3, sizes, parts); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// Write writes the image, with its header of HeaderVersion and its parts padded to the page size
+func (img *Image) Write(w io.Writer) error {
+	if img.HeaderVersion > 4 {
+		return fmt.Errorf("unsupported boot image header version %d", img.HeaderVersion)
+	}
+	osVersion, err := encodeOSVersion(img.OSVersion, img.OSPatchLevel)
+	if err != nil {
+		return err
+	}
+	pageSize := img.pageSize()
+	if err := checkPageSize(pageSize); err != nil {
+		return err
+	}
+	le := binary.LittleEndian
+	var (
+		h     []byte
+		parts [][]byte
+	)
+	if img.HeaderVersion >= 3 {
+		if len(img.Second) > 0 || len(img.RecoveryDTBO) > 0 || len(img.DTB) > 0 {
+			return fmt.Errorf("boot images of version %d have no second stage, recovery DTBO or DTB, which go in vendor_boot", img.HeaderVersion)
+		}
+		if len(img.Cmdline) >= cmdlineSizeV3 {
+			return fmt.Errorf("command line of %d bytes is longer than %d bytes", len(img.Cmdline), cmdlineSizeV3-1)
+		}
+		headerSize := uint32(headerSizeV3)
+		parts = [][]byte{img.Kernel, img.Ramdisk}
+		if img.HeaderVersion == 4 {
+			headerSize = headerSizeV4
+			parts = append(parts, img.Signature)
+		} else if len(img.Signature) > 0 {
+			return fmt.Errorf("boot images of version 3 have no boot signature")
+		}
+		h = make([]byte, headerSize)
+		copy(h[0:8], bootMagic)
+		le.PutUint32(h[8:12], uint32(len(img.Kernel)))
+		le.PutUint32(h[12:16], uint32(len(img.Ramdisk)))
+		le.PutUint32(h[16:20], osVersion)
+		le.PutUint32(h[20:24], headerSize)
+		le.PutUint32(h[40:44], img.HeaderVersion)
+		copy(h[44:44+cmdlineSizeV3], img.Cmdline)
+		if img.HeaderVersion == 4 {
+			le.PutUint32(h[1580:1584], uint32(len(img.Signature)))
+		}
+	} else {
+		if len(img.Signature) > 0 {
+			return fmt.Errorf("boot images of version %d have no boot signature", img.HeaderVersion)
+		}
+		if len(img.Name) >= nameSize {
+			return fmt.Errorf("name %q is longer than %d bytes", img.Name, nameSize-1)
+		}
+		if len(img.Cmdline) >= cmdlineSize+extraCmdlineSize-1 {
+			return fmt.Errorf("command line of %d bytes is longer than %d bytes", len(img.Cmdline), cmdlineSize+extraCmdlineSize-2)
+		}
+		headerSize := []int{headerSizeV0, headerSizeV1, headerSizeV2}[img.HeaderVersion]
+		parts = [][]byte{img.Kernel, img.Ramdisk, img.Second}
+		if img.HeaderVersion >= 1 {
+			parts = append(parts, img.RecoveryDTBO)
+		} else if len(img.RecoveryDTBO) > 0 {
+			return fmt.Errorf("boot images of version 0 have no recovery DTBO")
+		}
+		if img.HeaderVersion >= 2 {
+			parts = append(parts, img.DTB)
+		} else if len(img.DTB) > 0 {
+			return fmt.Errorf("boot images of version %d have no DTB", img.HeaderVersion)
+		}
+		h = make([]byte, headerSize)
+		copy(h[0:8], bootMagic)
+		le.PutUint32(h[8:12], uint32(len(img.Kernel)))
+		le.PutUint32(h[12:16], img.KernelAddr)
+		le.PutUint32(h[16:20], uint32(len(img.Ramdisk)))
+		le.PutUint32(h[20:24], img.RamdiskAddr)
+		le.PutUint32(h[24:28], uint32(len(img.Second)))
+		le.PutUint32(h[28:32], img.SecondAddr)
+		le.PutUint32(h[32:36], img.TagsAddr)
+		le.PutUint32(h[36:40], pageSize)
+		le.PutUint32(h[40:44], img.HeaderVersion)
+		le.PutUint32(h[44:48], osVersion)
+		copy(h[48:64], img.Name)
+		// the command line goes on in extra_cmdline, each with its terminating zero
+		cmdline, extra := img.Cmdline, ""
+		if len(cmdline) >= cmdlineSize {
+			cmdline, extra = cmdline[:cmdlineSize-1], cmdline[cmdlineSize-1:]
+		}
+		copy(h[64:576], cmdline)
+		copy(h[608:1632], extra)
+		copy(h[576:608], img.id(parts))
+		if img.HeaderVersion >= 1 {
+			le.PutUint32(h[1632:1636], uint32(len(img.RecoveryDTBO)))
+			if len(img.RecoveryDTBO) > 0 {
+				// the offset of the recovery DTBO in the image, after the header and three parts
+				offset := uint64(pageSize)
+				for _, p := range parts[:3] {
+					offset += pad(uint64(len(p)), uint64(pageSize))
+				}
+				le.PutUint64(h[1636:1644], offset)
+			}
+			le.PutUint32(h[1644:1648], uint32(headerSize))
+		}
+		if img.HeaderVersion >= 2 {
+			le.PutUint32(h[1648:1652], uint32(len(img.DTB)))
+			le.PutUint64(h[1652:1660], img.DTBAddr)
+		}
+	}
+	return writeParts(w, uint64(pageSize), append([][]byte{h}, parts...))
+}
+
+// id returns the id of the header of versions 0 to 2, the SHA1 digest of each part followed by
+// its size, as mkbootimg computes it
+func (img *Image) id(parts [][]byte) []byte {
+	sum := sha1.New()
+	for _, p := range parts {
+		sum.Write(p)
+		_ = binary.Write(sum, binary.LittleEndian, uint32(len(p)))
+	}
+	return sum.Sum(nil)
+}
+
+// checkPageSize returns an error if the page size is not a power of 2 from 2048 to 16384
+func checkPageSize(size uint32) error {
+	if size < 2048 || size > 16384 || size&(size-1) != 0 {
+		return fmt.Errorf("invalid page size %d, must be a power of 2 from 2048 to 16384", size)
+	}
+	return nil
+}
+
+// pad returns n rounded up to a multiple of the page size
+func pad(n, pageSize uint64) uint64 {
+	return (n + pageSize - 1) / pageSize * pageSize
+}
+
+// readPart reads n bytes at offset of r, checking they are within the size of the image
+func readPart(r io.ReaderAt, size, offset int64, n uint32) ([]byte, error) {
+	if offset+int64(n) > size {
+		return nil, fmt.Errorf("part of %d bytes at %d is beyond the end of the image at %d", n, offset, size)
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readParts reads the parts of the sizes, one after the other from offset, each from the start
+// of a page, leaving those of size 0 nil
+func readParts(r io.ReaderAt, size, offset, pageSize int64, sizes []uint32, parts []*[]byte) error {
+	for i, n := range sizes {
+		if n > 0 {
+			b, err := readPart(r, size, offset, n)
+			if err != nil {
+				return fmt.Errorf("error reading part %d of the boot image: %w", i, err)
+			}
+			*parts[i] = b
+		}
+		offset += int64(pad(uint64(n), uint64(pageSize)))
+	}
+	return nil
+}
+
+// writeParts writes the parts, each padded with zeros to the page size
+func writeParts(w io.Writer, pageSize uint64, parts [][]byte) error {
+	for _, p := range parts {
+		if len(p) == 0 {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			return fmt.Errorf("error writing boot image: %w", err)
+		}
+		if _, err := w.Write(make([]byte, pad(uint64(len(p)), pageSize)-uint64(len(p)))); err != nil {
+			return fmt.Errorf("error writing boot image: %w", err)
+		}
+	}
+	return nil
+}
+
+// cString returns the string of b up to its first zero
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// encodeOSVersion returns the os_version of the header, the version a.b.c in 7 bits each above
+// the patch level year-month in 7 and 4 bits
+func encodeOSVersion(version, patchLevel string) (uint32, error) {
+	var v, p uint32
+	if version != "" {
+		var a, b, c uint32
+		parts := strings.Split(version, ".")
+		for len(parts) < 3 {
+			parts = append(parts, "0")
+		}
+		if _, err := fmt.Sscanf(strings.Join(parts, " "), "%d %d %d", &a, &b, &c); err != nil || len(parts) != 3 || a >= 128 || b >= 128 || c >= 128 {
+			return 0, fmt.Errorf("invalid OS version %q, must be of the form 14.0.0", version)
+		}
+		v = a<<14 | b<<7 | c
+	}
+	if patchLevel != "" {
+		var y, m uint32
+		if n, err := fmt.Sscanf(patchLevel, "%4d-%2d", &y, &m); err != nil || n != 2 || y < 2000 || y >= 2128 || m < 1 || m > 12 {
+			return 0, fmt.Errorf("invalid OS patch level %q, must be of the form 2024-03", patchLevel)
+		}
+		p = (y-2000)<<4 | m
+	}
+	return v<<11 | p, nil
+}
+
+// decodeOSVersion returns the version and patch level of the os_version of the header
+func decodeOSVersion(osVersion uint32) (version, patchLevel string) {
+	if v := osVersion >> 11; v != 0 {
+		version = fmt.Sprintf("%d.%d.%d", v>>14, v>>7&0x7f, v&0x7f)
+	}
+	if p := osVersion & 0x7ff; p != 0 {
+		patchLevel = fmt.Sprintf("%d-%02d", 2000+p>>4, p&0xf)
+	}
+	return version, patchLevel
+}
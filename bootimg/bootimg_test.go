@@ -0,0 +1,208 @@
+package bootimg_test
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the id of boot images is a SHA1 digest of their contents
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/bootimg"
+)
+
+// write writes the image and reads it back, returning its bytes
+func write(t *testing.T, img *bootimg.Image) (*bootimg.Image, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := img.Write(&buf); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	b := buf.Bytes()
+	read, err := bootimg.Read(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	return read, b
+}
+
+func TestImage(t *testing.T) {
+	kernel := bytes.Repeat([]byte("kernel"), 1000)
+	ramdisk := bytes.Repeat([]byte("ramdisk"), 100)
+	tests := []struct {
+		name  string
+		img   bootimg.Image
+		pages []int
+	}{
+		{"v0", bootimg.Image{HeaderVersion: 0, Kernel: kernel, Ramdisk: ramdisk, Second: []byte("second"), Name: "board", PageSize: 4096}, []int{1, 2, 1, 1}},
+		{"v1", bootimg.Image{HeaderVersion: 1, Kernel: kernel, Ramdisk: ramdisk, RecoveryDTBO: []byte("dtbo")}, []int{1, 3, 1, 0, 1}},
+		{"v2", bootimg.Image{HeaderVersion: 2, Kernel: kernel, Ramdisk: ramdisk, DTB: []byte("dtb"), Cmdline: string(bytes.Repeat([]byte("x"), 1000))}, []int{1, 3, 1, 0, 0, 1}},
+		{"v3", bootimg.Image{HeaderVersion: 3, Kernel: kernel, Ramdisk: ramdisk, Cmdline: "console=ttyS0"}, []int{1, 2, 1}},
+		{"v4", bootimg.Image{HeaderVersion: 4, Kernel: kernel, Ramdisk: ramdisk, Signature: []byte("signature")}, []int{1, 2, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := tt.img
+			img.OSVersion, img.OSPatchLevel = "14.0.0", "2024-03"
+			if img.HeaderVersion < 3 {
+				img.SetDefaultAddresses(bootimg.DefaultBase)
+			}
+			if img.HeaderVersion < 2 {
+				// the DTB and its address are of version 2
+				img.DTBAddr = 0
+			}
+			read, b := write(t, &img)
+			if img.PageSize == 0 && img.HeaderVersion < 3 {
+				img.PageSize = bootimg.DefaultPageSize
+			}
+			if !reflect.DeepEqual(read, &img) {
+				t.Errorf("read %+v instead of %+v", read, &img)
+			}
+			pageSize := 4096
+			if img.HeaderVersion < 3 {
+				pageSize = int(img.PageSize)
+			}
+			total := 0
+			for _, n := range tt.pages {
+				total += n
+			}
+			if len(b) != total*pageSize {
+				t.Errorf("image of %d bytes instead of %d pages of %d bytes", len(b), total, pageSize)
+			}
+			// the kernel starts the page after the header, and the ramdisk the page after it
+			if !bytes.Equal(b[pageSize:pageSize+len(kernel)], kernel) || !bytes.Equal(b[(1+tt.pages[1])*pageSize:][:len(ramdisk)], ramdisk) {
+				t.Errorf("kernel or ramdisk not at their pages")
+			}
+			// os_version of 14.0.0 and 2024-03
+			offset := 44
+			if img.HeaderVersion >= 3 {
+				offset = 16
+			}
+			if v := binary.LittleEndian.Uint32(b[offset:]); v != (14<<14)<<11|(24<<4|3) {
+				t.Errorf("os_version %#x", v)
+			}
+		})
+	}
+}
+
+func TestImageID(t *testing.T) {
+	img := &bootimg.Image{HeaderVersion: 2, Kernel: []byte("kernel"), Ramdisk: []byte("ramdisk"), DTB: []byte("dtb")}
+	_, b := write(t, img)
+	sum := sha1.New() //nolint:gosec // the id of boot images is a SHA1 digest of their contents
+	for _, p := range [][]byte{img.Kernel, img.Ramdisk, nil, nil, img.DTB} {
+		sum.Write(p)
+		_ = binary.Write(sum, binary.LittleEndian, uint32(len(p)))
+	}
+	if id := b[576:596]; !bytes.Equal(id, sum.Sum(nil)) {
+		t.Errorf("id %x instead of %x", id, sum.Sum(nil))
+	}
+	if headerSize := binary.LittleEndian.Uint32(b[1644:]); headerSize != 1660 {
+		t.Errorf("header_size %d instead of 1660", headerSize)
+	}
+}
+
+func TestVendorImage(t *testing.T) {
+	tests := []struct {
+		name string
+		img  bootimg.VendorImage
+		size int
+	}{
+		{"v3", bootimg.VendorImage{
+			HeaderVersion: 3,
+			Cmdline:       "androidboot.hardware=board",
+			Name:          "board",
+			Ramdisks:      []bootimg.VendorRamdisk{{Data: []byte("vendor ramdisk")}},
+			DTB:           []byte("dtb"),
+		}, 4 * 2048},
+		{"v4", bootimg.VendorImage{
+			HeaderVersion: 4,
+			PageSize:      4096,
+			Ramdisks: []bootimg.VendorRamdisk{
+				{Name: "", Type: bootimg.RamdiskTypePlatform, Data: []byte("platform")},
+				{Name: "dlkm", Type: bootimg.RamdiskTypeDLKM, BoardID: [16]uint32{1, 2}, Data: []byte("modules")},
+			},
+			DTB:        []byte("dtb"),
+			Bootconfig: []byte("androidboot.serialno = 1234\n"),
+		}, 5 * 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := tt.img
+			img.SetDefaultAddresses(bootimg.DefaultBase)
+			var buf bytes.Buffer
+			if err := img.Write(&buf); err != nil {
+				t.Fatalf("error writing image: %v", err)
+			}
+			b := buf.Bytes()
+			if len(b) != tt.size {
+				t.Errorf("image of %d bytes instead of %d", len(b), tt.size)
+			}
+			read, err := bootimg.ReadVendor(bytes.NewReader(b), int64(len(b)))
+			if err != nil {
+				t.Fatalf("error reading image: %v", err)
+			}
+			if img.PageSize == 0 {
+				img.PageSize = bootimg.DefaultPageSize
+			}
+			if !reflect.DeepEqual(read, &img) {
+				t.Errorf("read %+v instead of %+v", read, &img)
+			}
+			// the ramdisks are concatenated after the header, of 4096 bytes padded to both page sizes
+			if !bytes.HasPrefix(b[4096:], img.Ramdisks[0].Data) {
+				t.Errorf("ramdisk not after the header")
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	b := make([]byte, 8192)
+	if _, err := bootimg.Read(bytes.NewReader(b), int64(len(b))); !errors.Is(err, bootimg.ErrNoMagic) {
+		t.Errorf("read image without magic: %v", err)
+	}
+	if _, err := bootimg.ReadVendor(bytes.NewReader(b), int64(len(b))); !errors.Is(err, bootimg.ErrNoMagic) {
+		t.Errorf("read vendor image without magic: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := (&bootimg.Image{Kernel: make([]byte, 10000)}).Write(&buf); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	// truncated in the kernel
+	if _, err := bootimg.Read(bytes.NewReader(buf.Bytes()), 4096); err == nil {
+		t.Errorf("read truncated image")
+	}
+}
+
+func TestWriteInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		img  bootimg.Image
+	}{
+		{"version", bootimg.Image{HeaderVersion: 5}},
+		{"page size", bootimg.Image{PageSize: 1000}},
+		{"dtb of v3", bootimg.Image{HeaderVersion: 3, DTB: []byte("dtb")}},
+		{"dtb of v1", bootimg.Image{HeaderVersion: 1, DTB: []byte("dtb")}},
+		{"signature of v2", bootimg.Image{HeaderVersion: 2, Signature: []byte("signature")}},
+		{"name", bootimg.Image{Name: "a name of sixteen"}},
+		{"cmdline", bootimg.Image{Cmdline: string(make([]byte, 1535))}},
+		{"os version", bootimg.Image{OSVersion: "128.0.0"}},
+		{"patch level", bootimg.Image{OSPatchLevel: "2024-13"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.img.Write(&bytes.Buffer{}); err == nil {
+				t.Errorf("wrote image")
+			}
+		})
+	}
+	for _, v := range []bootimg.VendorImage{
+		{HeaderVersion: 2},
+		{HeaderVersion: 3, Ramdisks: []bootimg.VendorRamdisk{{}, {}}},
+		{HeaderVersion: 3, Bootconfig: []byte("bootconfig")},
+		{HeaderVersion: 4, Ramdisks: []bootimg.VendorRamdisk{{Name: "a"}, {Name: "a"}}},
+	} {
+		if err := v.Write(&bytes.Buffer{}); err == nil {
+			t.Errorf("wrote vendor image %+v", v)
+		}
+	}
+}
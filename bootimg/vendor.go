@@ -0,0 +1,227 @@
+package bootimg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	vendorMagic        = "VNDRBOOT"
+	vendorHeaderSizeV3 = 2112
+	vendorHeaderSizeV4 = 2128
+	vendorCmdlineSize  = 2048
+	// sizes of the entries of the vendor ramdisk table of version 4
+	ramdiskEntrySize = 108
+	ramdiskNameSize  = 32
+	boardIDSize      = 16
+)
+
+// RamdiskType is the type of a vendor ramdisk
+type RamdiskType uint32
+
+// types of vendor ramdisks
+const (
+	RamdiskTypeNone     RamdiskType = 0
+	RamdiskTypePlatform RamdiskType = 1
+	RamdiskTypeRecovery RamdiskType = 2
+	RamdiskTypeDLKM     RamdiskType = 3
+)
+
+// VendorRamdisk is a ramdisk of a vendor boot image, concatenated to the others by the bootloader
+type VendorRamdisk struct {
+	// Name is the name of the ramdisk in the table of version 4, unique among its ramdisks
+	Name string
+	// Type is the type of the ramdisk in the table of version 4
+	Type RamdiskType
+	// BoardID is the board id of the ramdisk in the table of version 4, for the bootloader to
+	// pick the ramdisks of the board
+	BoardID [boardIDSize]uint32
+	// Data is the ramdisk, usually a compressed cpio archive
+	Data []byte
+}
+
+// VendorImage is an Android vendor boot image, vendor_boot.img, with the parts of boot images of
+// versions 0 to 2 that moved out of those of versions 3 and 4
+type VendorImage struct {
+	// HeaderVersion is the version of the header, 3 or 4, the same as that of the boot image
+	HeaderVersion uint32
+	// PageSize is the page size to which the parts of the image are aligned, DefaultPageSize if 0
+	PageSize uint32
+	// KernelAddr, RamdiskAddr, TagsAddr and DTBAddr are the physical load addresses
+	KernelAddr  uint32
+	RamdiskAddr uint32
+	TagsAddr    uint32
+	DTBAddr     uint64
+	// Cmdline is the vendor kernel command line, of up to 2047 bytes, appended to that of the boot
+	// image
+	Cmdline string
+	// Name is the product name
+	Name string
+	// Ramdisks are the vendor ramdisks, only one unnamed for version 3
+	Ramdisks []VendorRamdisk
+	// DTB is the device tree blob
+	DTB []byte
+	// Bootconfig is the bootconfig of version 4, "key = value" lines appended to the ramdisk
+	Bootconfig []byte
+}
+
+// SetDefaultAddresses sets the load addresses of the image from a base address, with the offsets
+// of mkbootimg, e.g. DefaultBase
+func (v *VendorImage) SetDefaultAddresses(base uint32) {
+	v.KernelAddr = base + defaultKernelOffset
+	v.RamdiskAddr = base + defaultRamdisk
+	v.TagsAddr = base + defaultTags
+	v.DTBAddr = uint64(base) + defaultDTB
+}
+
+// ReadVendor reads the vendor boot image of size bytes of r. It returns ErrNoMagic if it is not
+// a vendor boot image.
+func ReadVendor(r io.ReaderAt, size int64) (*VendorImage, error) {
+	h, err := readPart(r, size, 0, uint32(min(size, vendorHeaderSizeV4)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading vendor boot image header: %w", err)
+	}
+	// images of version 3 have a smaller header
+	if len(h) < vendorHeaderSizeV3 || string(h[0:8]) != vendorMagic {
+		return nil, ErrNoMagic
+	}
+	le := binary.LittleEndian
+	v := &VendorImage{
+		HeaderVersion: le.Uint32(h[8:12]),
+		PageSize:      le.Uint32(h[12:16]),
+		KernelAddr:    le.Uint32(h[16:20]),
+		RamdiskAddr:   le.Uint32(h[20:24]),
+		Cmdline:       cString(h[28:2076]),
+		TagsAddr:      le.Uint32(h[2076:2080]),
+		Name:          cString(h[2080:2096]),
+		DTBAddr:       le.Uint64(h[2104:2112]),
+	}
+	if v.HeaderVersion < 3 || v.HeaderVersion > 4 {
+		return nil, fmt.Errorf("unsupported vendor boot image header version %d", v.HeaderVersion)
+	}
+	if v.HeaderVersion == 4 && len(h) < vendorHeaderSizeV4 {
+		return nil, fmt.Errorf("vendor boot image of %d bytes is shorter than its header", size)
+	}
+	if err := checkPageSize(v.PageSize); err != nil {
+		return nil, err
+	}
+	var ramdisk, table []byte
+	headerSize := uint64(vendorHeaderSizeV3)
+	sizes := []uint32{le.Uint32(h[24:28]), le.Uint32(h[2100:2104])}
+	parts := []*[]byte{&ramdisk, &v.DTB}
+	if v.HeaderVersion == 4 {
+		headerSize = vendorHeaderSizeV4
+		sizes = append(sizes, le.Uint32(h[2112:2116]), le.Uint32(h[2124:2128]))
+		parts = append(parts, &table, &v.Bootconfig)
+	}
+	// the header is padded to the page size like the parts
+	if err := readParts(r, size, int64(pad(headerSize, uint64(v.PageSize))), int64(v.PageSize), sizes, parts); err != nil {
+		return nil, err
+	}
+	if v.HeaderVersion == 3 {
+		if len(ramdisk) > 0 {
+			v.Ramdisks = []VendorRamdisk{{Data: ramdisk}}
+		}
+		return v, nil
+	}
+
+	entries, entrySize := le.Uint32(h[2116:2120]), le.Uint32(h[2120:2124])
+	if entrySize < ramdiskEntrySize || uint64(entries)*uint64(entrySize) > uint64(len(table)) {
+		return nil, fmt.Errorf("invalid vendor ramdisk table of %d entries of %d bytes in %d bytes", entries, entrySize, len(table))
+	}
+	for i := uint32(0); i < entries; i++ {
+		e := table[i*entrySize : (i+1)*entrySize]
+		n, offset := le.Uint32(e[0:4]), le.Uint32(e[4:8])
+		if uint64(offset)+uint64(n) > uint64(len(ramdisk)) {
+			return nil, fmt.Errorf("vendor ramdisk %d of %d bytes at %d is beyond the end of the ramdisks at %d", i, n, offset, len(ramdisk))
+		}
+		rd := VendorRamdisk{
+			Type: RamdiskType(le.Uint32(e[8:12])),
+			Name: cString(e[12:44]),
+			Data: ramdisk[offset : offset+n],
+		}
+		for j := range rd.BoardID {
+			rd.BoardID[j] = le.Uint32(e[44+4*j:])
+		}
+		v.Ramdisks = append(v.Ramdisks, rd)
+	}
+	return v, nil
+}
+
+// Write writes the image, with its header of HeaderVersion and its parts padded to the page size
+func (v *VendorImage) Write(w io.Writer) error {
+	if v.HeaderVersion < 3 || v.HeaderVersion > 4 {
+		return fmt.Errorf("unsupported vendor boot image header version %d", v.HeaderVersion)
+	}
+	pageSize := v.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+	if err := checkPageSize(pageSize); err != nil {
+		return err
+	}
+	if len(v.Cmdline) >= vendorCmdlineSize {
+		return fmt.Errorf("command line of %d bytes is longer than %d bytes", len(v.Cmdline), vendorCmdlineSize-1)
+	}
+	if len(v.Name) >= nameSize {
+		return fmt.Errorf("name %q is longer than %d bytes", v.Name, nameSize-1)
+	}
+	le := binary.LittleEndian
+	var ramdisk, table []byte
+	names := map[string]bool{}
+	for i, rd := range v.Ramdisks {
+		if v.HeaderVersion == 3 {
+			if len(v.Ramdisks) > 1 || rd.Name != "" || rd.Type != RamdiskTypeNone || rd.BoardID != [boardIDSize]uint32{} {
+				return fmt.Errorf("vendor boot images of version 3 have a single ramdisk without name, type or board id")
+			}
+		}
+		if len(rd.Name) >= ramdiskNameSize {
+			return fmt.Errorf("name %q of vendor ramdisk %d is longer than %d bytes", rd.Name, i, ramdiskNameSize-1)
+		}
+		if names[rd.Name] {
+			return fmt.Errorf("name %q of vendor ramdisk %d is not unique", rd.Name, i)
+		}
+		names[rd.Name] = true
+		e := make([]byte, ramdiskEntrySize)
+		le.PutUint32(e[0:4], uint32(len(rd.Data)))
+		le.PutUint32(e[4:8], uint32(len(ramdisk)))
+		le.PutUint32(e[8:12], uint32(rd.Type))
+		copy(e[12:44], rd.Name)
+		for j, id := range rd.BoardID {
+			le.PutUint32(e[44+4*j:], id)
+		}
+		table = append(table, e...)
+		ramdisk = append(ramdisk, rd.Data...)
+	}
+	if v.HeaderVersion == 3 && len(v.Bootconfig) > 0 {
+		return fmt.Errorf("vendor boot images of version 3 have no bootconfig")
+	}
+
+	headerSize := uint32(vendorHeaderSizeV3)
+	if v.HeaderVersion == 4 {
+		headerSize = vendorHeaderSizeV4
+	}
+	h := make([]byte, headerSize)
+	copy(h[0:8], vendorMagic)
+	le.PutUint32(h[8:12], v.HeaderVersion)
+	le.PutUint32(h[12:16], pageSize)
+	le.PutUint32(h[16:20], v.KernelAddr)
+	le.PutUint32(h[20:24], v.RamdiskAddr)
+	le.PutUint32(h[24:28], uint32(len(ramdisk)))
+	copy(h[28:2076], v.Cmdline)
+	le.PutUint32(h[2076:2080], v.TagsAddr)
+	copy(h[2080:2096], v.Name)
+	le.PutUint32(h[2096:2100], headerSize)
+	le.PutUint32(h[2100:2104], uint32(len(v.DTB)))
+	le.PutUint64(h[2104:2112], v.DTBAddr)
+	parts := [][]byte{h, ramdisk, v.DTB}
+	if v.HeaderVersion == 4 {
+		le.PutUint32(h[2112:2116], uint32(len(table)))
+		le.PutUint32(h[2116:2120], uint32(len(v.Ramdisks)))
+		le.PutUint32(h[2120:2124], ramdiskEntrySize)
+		le.PutUint32(h[2124:2128], uint32(len(v.Bootconfig)))
+		parts = append(parts, table, v.Bootconfig)
+	}
+	return writeParts(w, uint64(pageSize), parts)
+}
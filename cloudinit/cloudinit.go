@@ -0,0 +1,172 @@
+// Package cloudinit builds NoCloud seed images, which provide cloud-init with its configuration
+// on virtual machines without a metadata service.
+//
+// cloud-init finds the seed by its volume label, cidata, on an ISO9660 or FAT filesystem, and reads
+// the files user-data and meta-data, and optionally network-config and vendor-data, from its root.
+// Attach the image as a CD-ROM or a disk of the virtual machine.
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// VolumeLabel is the volume label that cloud-init looks for
+const VolumeLabel = "cidata"
+
+// Format is the filesystem of a seed image
+type Format int
+
+const (
+	// FormatISO9660 is an ISO9660 volume with Rock Ridge extensions, as built by
+	// `genisoimage -volid cidata -rock`, usually attached as a CD-ROM
+	FormatISO9660 Format = iota
+	// FormatFAT is a FAT32 filesystem on the whole disk, without a partition table
+	FormatFAT
+)
+
+const (
+	isoBlocksize = 2048
+	fatBlocksize = 512
+	// overhead is the space given to filesystem structures beyond the file contents
+	overhead = 1024 * 1024
+)
+
+// Seed is the configuration provided to cloud-init
+type Seed struct {
+	// UserData is the user-data file, usually a #cloud-config document or a script
+	UserData []byte
+	// MetaData is the meta-data file, which sets e.g. instance-id and local-hostname
+	MetaData []byte
+	// NetworkConfig is the network-config file, omitted if nil
+	NetworkConfig []byte
+	// VendorData is the vendor-data file, omitted if nil
+	VendorData []byte
+}
+
+type seedFile struct {
+	name string
+	data []byte
+}
+
+// files lists the files of the seed; cloud-init requires user-data and meta-data, even if empty
+func (s *Seed) files() []seedFile {
+	files := []seedFile{
+		{"user-data", s.UserData},
+		{"meta-data", s.MetaData},
+	}
+	if s.NetworkConfig != nil {
+		files = append(files, seedFile{"network-config", s.NetworkConfig})
+	}
+	if s.VendorData != nil {
+		files = append(files, seedFile{"vendor-data", s.VendorData})
+	}
+	return files
+}
+
+// Size is the size of a seed image of the given format with ample room for the files
+func (s *Seed) Size(format Format) int64 {
+	blocksize := int64(isoBlocksize)
+	if format == FormatFAT {
+		blocksize = fatBlocksize
+	}
+	size := int64(overhead)
+	for _, f := range s.files() {
+		size += (int64(len(f.data)) + blocksize - 1) / blocksize * blocksize
+	}
+	// round up to whole MB, which FAT needs and does not hurt ISO9660
+	return (size + overhead - 1) / overhead * overhead
+}
+
+// Write writes the seed image to the start of the storage, which must be at least s.Size(format)
+// for FormatFAT
+func (s *Seed) Write(b backend.Storage, format Format) error {
+	switch format {
+	case FormatISO9660:
+		return s.writeISO9660(b)
+	case FormatFAT:
+		return s.writeFAT(b)
+	default:
+		return fmt.Errorf("unknown seed format %d", format)
+	}
+}
+
+func (s *Seed) writeISO9660(b backend.Storage) error {
+	fs, err := iso9660.Create(b, 0, 0, isoBlocksize, "")
+	if err != nil {
+		return fmt.Errorf("could not create ISO9660 filesystem: %w", err)
+	}
+	defer fs.Close()
+	if err := writeFiles(fs, s.files()); err != nil {
+		return err
+	}
+	// the file names are only kept with Rock Ridge, plain ISO9660 only allows 8.3 upper case names
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true, VolumeIdentifier: VolumeLabel}); err != nil {
+		return fmt.Errorf("could not finalize ISO9660 filesystem: %w", err)
+	}
+	return nil
+}
+
+func (s *Seed) writeFAT(b backend.Storage) error {
+	info, err := b.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat storage: %w", err)
+	}
+	if info.Size() < s.Size(FormatFAT) {
+		return fmt.Errorf("storage of %d bytes is smaller than the seed image of %d bytes", info.Size(), s.Size(FormatFAT))
+	}
+	// FAT labels are conventionally upper case, which cloud-init also accepts
+	fs, err := fat32.Create(b, info.Size(), 0, fatBlocksize, "CIDATA")
+	if err != nil {
+		return fmt.Errorf("could not create FAT32 filesystem: %w", err)
+	}
+	defer fs.Close()
+	return writeFiles(fs, s.files())
+}
+
+func writeFiles(fs filesystem.FileSystem, files []seedFile) error {
+	for _, f := range files {
+		rw, err := fs.OpenFile("/"+f.name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			return fmt.Errorf("could not create %s: %w", f.name, err)
+		}
+		if _, err := rw.Write(f.data); err != nil {
+			return fmt.Errorf("could not write %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
+
+// CreateFromPath creates a seed image file of the given format at the path, which must not exist
+func CreateFromPath(pathName string, seed *Seed, format Format) error {
+	if pathName == "" {
+		return errors.New("must pass image file name")
+	}
+	var b backend.Storage
+	if format == FormatISO9660 {
+		// the ISO9660 volume is written out by Finalize, and the file grows to its size
+		f, err := os.OpenFile(pathName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if err != nil {
+			return fmt.Errorf("could not create image file %s: %w", pathName, err)
+		}
+		b = file.New(f, false)
+	} else {
+		var err error
+		if b, err = file.CreateFromPath(pathName, seed.Size(format)); err != nil {
+			return fmt.Errorf("could not create image file %s: %w", pathName, err)
+		}
+	}
+	if err := seed.Write(b, format); err != nil {
+		b.Close()
+		os.Remove(pathName)
+		return err
+	}
+	return b.Close()
+}
@@ -0,0 +1,92 @@
+package cloudinit_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/cloudinit"
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+func TestCreateFromPath(t *testing.T) {
+	seed := &cloudinit.Seed{
+		UserData:      []byte("#cloud-config\npassword: passw0rd\nchpasswd: { expire: False }\n"),
+		MetaData:      []byte("instance-id: test-1\nlocal-hostname: test\n"),
+		NetworkConfig: []byte("version: 2\nethernets:\n  eth0:\n    dhcp4: true\n"),
+	}
+	tests := []struct {
+		name   string
+		format cloudinit.Format
+		fsType filesystem.Type
+	}{
+		{"iso9660", cloudinit.FormatISO9660, filesystem.TypeISO9660},
+		{"fat", cloudinit.FormatFAT, filesystem.TypeFat32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "seed.img")
+			if err := cloudinit.CreateFromPath(p, seed, tt.format); err != nil {
+				t.Fatalf("error creating seed image: %v", err)
+			}
+			if err := cloudinit.CreateFromPath(p, seed, tt.format); err == nil {
+				t.Errorf("no error creating seed image over an existing file")
+			}
+			d, err := diskfs.Open(p, diskfs.WithOpenMode(diskfs.ReadOnly))
+			if err != nil {
+				t.Fatalf("error opening seed image: %v", err)
+			}
+			defer d.Close()
+			if tt.format == cloudinit.FormatISO9660 {
+				d.LogicalBlocksize = 2048
+			}
+			fs, err := d.GetFilesystem(0)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			if fs.Type() != tt.fsType {
+				t.Errorf("filesystem type %v instead of %v", fs.Type(), tt.fsType)
+			}
+			if label := strings.TrimRight(fs.Label(), " \x00"); !strings.EqualFold(label, cloudinit.VolumeLabel) {
+				t.Errorf("label %q instead of %q", label, cloudinit.VolumeLabel)
+			}
+			for name, expected := range map[string][]byte{
+				"user-data":      seed.UserData,
+				"meta-data":      seed.MetaData,
+				"network-config": seed.NetworkConfig,
+			} {
+				f, err := fs.OpenFile("/"+name, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("error opening %s: %v", name, err)
+				}
+				b, err := io.ReadAll(f)
+				if err != nil {
+					t.Fatalf("error reading %s: %v", name, err)
+				}
+				if !bytes.Equal(b, expected) {
+					t.Errorf("%s is %q instead of %q", name, b, expected)
+				}
+			}
+			if _, err := fs.OpenFile("/vendor-data", os.O_RDONLY); err == nil {
+				t.Errorf("vendor-data exists without being provided")
+			}
+		})
+	}
+}
+
+func TestWriteTooSmall(t *testing.T) {
+	seed := &cloudinit.Seed{UserData: make([]byte, 3*1024*1024)}
+	p := filepath.Join(t.TempDir(), "seed.img")
+	b, err := diskfs.Create(p, 1024*1024, diskfs.SectorSizeDefault)
+	if err != nil {
+		t.Fatalf("error creating disk: %v", err)
+	}
+	defer b.Close()
+	if err := seed.Write(b.Backend, cloudinit.FormatFAT); err == nil {
+		t.Errorf("no error writing seed image to storage that is too small")
+	}
+}
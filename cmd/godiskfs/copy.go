@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+func (c *cli) cp(args []string) error {
+	usage := usages["cp"]
+	if len(args) == 0 || (args[0] != "in" && args[0] != "out") {
+		fmt.Fprintf(c.stderr, "usage: godiskfs %s\n", usage)
+		return errUsage
+	}
+	var part int
+	set := c.flags(usage, &part)
+	if err := c.parse(set, args[1:], 3, 3); err != nil {
+		return err
+	}
+	writable := args[0] == "in"
+	img, err := openFilesystem(set.Arg(0), part, writable)
+	if err != nil {
+		return err
+	}
+	if writable {
+		err = copyIn(set.Arg(1), img.fs, filesystem.AbsolutePath(set.Arg(2)))
+	} else {
+		err = copyOut(img.fs, filesystem.AbsolutePath(set.Arg(1)), set.Arg(2))
+	}
+	if closeErr := img.close(writable); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *cli) extract(args []string) error {
+	var part int
+	set := c.flags(usages["extract"], &part)
+	if err := c.parse(set, args, 2, 2); err != nil {
+		return err
+	}
+	img, err := openFilesystem(set.Arg(0), part, false)
+	if err != nil {
+		return err
+	}
+	defer img.close(false)
+	return copyOut(img.fs, "/", set.Arg(1))
+}
+
+// copyIn copies the local file or directory src to dst in f, or into dst if src is a file and dst
+// an existing directory, as cp does
+func copyIn(src string, f filesystem.FileSystem, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := f.Stat(dst); err == nil && dstInfo.IsDir() && !info.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			return f.Mkdir(target)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return f.Symlink(filepath.ToSlash(link), target)
+		case d.Type().IsRegular():
+			return copyFileIn(p, f, target)
+		default:
+			return fmt.Errorf("cannot copy %s, of type %s", p, d.Type())
+		}
+	})
+}
+
+func copyFileIn(src string, f filesystem.FileSystem, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := f.OpenFile(dst, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing %s: %w", dst, err)
+	}
+	return out.Close()
+}
+
+// copyOut copies the file or directory src in f to the local dst like copyIn, with the
+// modification times of the files; devices, named pipes and sockets are skipped
+func copyOut(f filesystem.FileSystem, src, dst string) error {
+	info, err := filesystem.Lstat(f, src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.IsDir() && !info.IsDir() {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+	return filesystem.WalkDir(f, src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := p[len(src):]
+		target := filepath.Join(dst, filepath.FromSlash(rel))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := filesystem.Readlink(f, p)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			return os.Symlink(filepath.FromSlash(link), target)
+		case d.Type().IsRegular():
+			if err := copyFileOut(f, p, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if mtime := info.ModTime(); !mtime.IsZero() {
+				return os.Chtimes(target, mtime, mtime)
+			}
+		}
+		return nil
+	})
+}
+
+func copyFileOut(f filesystem.FileSystem, src, dst string, perm fs.FileMode) error {
+	in, err := f.OpenFile(src, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error reading %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// filesystemTypes are the types of filesystems that mkfs creates, which need no workspace
+var filesystemTypes = map[string]filesystem.Type{
+	"fat32": filesystem.TypeFat32,
+	"ext4":  filesystem.TypeExt4,
+}
+
+func (c *cli) mkfs(args []string) error {
+	var part int
+	set := c.flags(usages["mkfs"], &part)
+	fsType := set.String("t", "", "type of the filesystem, fat32 or ext4")
+	label := set.String("L", "", "label of the filesystem")
+	if err := c.parse(set, args, 1, 1); err != nil {
+		return err
+	}
+	t, ok := filesystemTypes[*fsType]
+	if !ok {
+		return fmt.Errorf("unknown filesystem type %q, must be fat32 or ext4", *fsType)
+	}
+	d, err := openDisk(set.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	f, err := d.CreateFilesystem(disk.FormatSpec{Partition: part, FSType: t, VolumeLabel: *label})
+	if err != nil {
+		return fmt.Errorf("error creating filesystem: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (c *cli) ls(args []string) error {
+	var part int
+	set := c.flags(usages["ls"], &part)
+	long := set.Bool("l", false, "list the mode, size and modification time of the files")
+	if err := c.parse(set, args, 1, 2); err != nil {
+		return err
+	}
+	img, err := openFilesystem(set.Arg(0), part, false)
+	if err != nil {
+		return err
+	}
+	defer img.close(false)
+	p := filesystem.AbsolutePath(set.Arg(1))
+	info, err := filesystem.Lstat(img.fs, p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.list(img.fs, p, info, *long)
+	}
+	entries, err := img.fs.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if err := c.list(img.fs, path.Join(p, e.Name()), info, *long); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list prints the file at p described by info, with its details if long
+func (c *cli) list(f filesystem.FileSystem, p string, info fs.FileInfo, long bool) error {
+	if !long {
+		fmt.Fprintln(c.stdout, info.Name())
+		return nil
+	}
+	name := info.Name()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := filesystem.Readlink(f, p)
+		if err != nil {
+			return err
+		}
+		name += " -> " + target
+	}
+	fmt.Fprintf(c.stdout, "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().UTC().Format("2006-01-02 15:04"), name)
+	return nil
+}
+
+func (c *cli) cat(args []string) error {
+	var part int
+	set := c.flags(usages["cat"], &part)
+	if err := c.parse(set, args, 2, -1); err != nil {
+		return err
+	}
+	img, err := openFilesystem(set.Arg(0), part, false)
+	if err != nil {
+		return err
+	}
+	defer img.close(false)
+	for _, p := range set.Args()[1:] {
+		file, err := img.fs.OpenFile(filesystem.AbsolutePath(p), os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(c.stdout, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (c *cli) tree(args []string) error {
+	var part int
+	set := c.flags(usages["tree"], &part)
+	if err := c.parse(set, args, 1, 2); err != nil {
+		return err
+	}
+	img, err := openFilesystem(set.Arg(0), part, false)
+	if err != nil {
+		return err
+	}
+	defer img.close(false)
+	p := filesystem.AbsolutePath(set.Arg(1))
+	fmt.Fprintln(c.stdout, p)
+	return c.printTree(img.fs, p, "")
+}
+
+// printTree prints the contents of the directory p, with each line after prefix
+func (c *cli) printTree(f filesystem.FileSystem, p, prefix string) error {
+	entries, err := f.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		branch, indent := "├── ", "│   "
+		if i == len(entries)-1 {
+			branch, indent = "└── ", "    "
+		}
+		name := e.Name()
+		child := path.Join(p, name)
+		if e.Type()&fs.ModeSymlink != 0 {
+			if target, err := filesystem.Readlink(f, child); err == nil {
+				name += " -> " + target
+			}
+		}
+		fmt.Fprintf(c.stdout, "%s%s%s\n", prefix, branch, name)
+		if e.IsDir() {
+			if err := c.printTree(f, child, prefix+indent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cli) fsck(args []string) error {
+	var part int
+	set := c.flags(usages["fsck"], &part)
+	skipContents := set.Bool("skip-contents", false, "check only the structures of the filesystem, without reading the contents of the files")
+	if err := c.parse(set, args, 1, 1); err != nil {
+		return err
+	}
+	img, err := openFilesystem(set.Arg(0), part, false)
+	if err != nil {
+		return err
+	}
+	defer img.close(false)
+	findings, err := filesystem.Check(img.fs, filesystem.CheckOptions{SkipContents: *skipContents})
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		fmt.Fprintln(c.stdout, f)
+	}
+	if severity, ok := filesystem.MaxSeverity(findings); ok && severity >= filesystem.SeverityError {
+		return errFindings
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/qcow2"
+	"github.com/diskfs/go-diskfs/backend/vdi"
+	"github.com/diskfs/go-diskfs/backend/vhd"
+	"github.com/diskfs/go-diskfs/backend/vhdx"
+	"github.com/diskfs/go-diskfs/backend/vmdk"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// formats of the images, as detected by imageFormat
+const (
+	formatRaw   = "raw"
+	formatQcow2 = "qcow2"
+	formatVHD   = "vhd"
+	formatVHDX  = "vhdx"
+	formatVMDK  = "vmdk"
+	formatVDI   = "vdi"
+)
+
+// imageFormat detects the format of the image at p from its signatures, raw if it has none of
+// the formats of the backends of the module
+func imageFormat(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	if _, err := io.ReadFull(f, head); err != nil {
+		// too small for any header
+		return formatRaw, nil
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("QFI\xfb")):
+		return formatQcow2, nil
+	case bytes.HasPrefix(head, []byte("vhdxfile")):
+		return formatVHDX, nil
+	case bytes.HasPrefix(head, []byte("KDMV")):
+		return formatVMDK, nil
+	case binary.LittleEndian.Uint32(head[0x40:0x44]) == 0xbeda107f:
+		return formatVDI, nil
+	case bytes.HasPrefix(head, []byte("conectix")):
+		// the copy of the footer at the start of dynamic VHD images
+		return formatVHD, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	// the footer of fixed VHD images
+	foot := make([]byte, 8)
+	if info.Size() >= 1024 {
+		if _, err := f.ReadAt(foot, info.Size()-512); err != nil {
+			return "", err
+		}
+		if bytes.Equal(foot, []byte("conectix")) {
+			return formatVHD, nil
+		}
+	}
+	return formatRaw, nil
+}
+
+// openBackend opens the image at p with the backend of its format
+func openBackend(p string, readOnly bool) (backend.Storage, error) {
+	format, err := imageFormat(p)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case formatQcow2:
+		return qcow2.OpenFromPath(p, readOnly)
+	case formatVHD:
+		return vhd.OpenFromPath(p, readOnly)
+	case formatVHDX:
+		return vhdx.OpenFromPath(p, readOnly)
+	case formatVMDK:
+		return vmdk.OpenFromPath(p, readOnly)
+	case formatVDI:
+		return vdi.OpenFromPath(p, readOnly)
+	default:
+		return file.OpenFromPath(p, readOnly)
+	}
+}
+
+// openDisk opens the disk in the image at p, writable or not
+func openDisk(p string, writable bool) (*disk.Disk, error) {
+	b, err := openBackend(p, !writable)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image %s: %w", p, err)
+	}
+	mode := diskfs.ReadOnly
+	if writable {
+		mode = diskfs.ReadWrite
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(mode))
+	if err != nil {
+		b.Close()
+		return nil, fmt.Errorf("error opening disk in %s: %w", p, err)
+	}
+	return d, nil
+}
+
+// image is a filesystem opened in a partition of a disk image, or the whole of it
+type image struct {
+	disk *disk.Disk
+	fs   filesystem.FileSystem
+}
+
+// openFilesystem opens the filesystem in partition part, starting at 1, of the image at p, or in
+// the whole image for 0
+func openFilesystem(p string, part int, writable bool) (*image, error) {
+	d, err := openDisk(p, writable)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.GetFilesystem(part)
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("error reading filesystem of %s: %w", p, err)
+	}
+	return &image{disk: d, fs: f}, nil
+}
+
+// close closes the filesystem and the image, making the changes durable first if there were any
+func (img *image) close(changed bool) error {
+	if changed {
+		if err := img.fs.Sync(); err != nil {
+			img.disk.Close()
+			return err
+		}
+	}
+	if err := img.fs.Close(); err != nil {
+		img.disk.Close()
+		return err
+	}
+	return img.disk.Close()
+}
@@ -0,0 +1,158 @@
+// Command godiskfs reads and writes the partition tables and filesystems of disk images with the
+// library of the module, in raw, qcow2, VHD, VHDX, VMDK and VDI images, whose format is detected
+// from their signatures.
+//
+//	godiskfs partition ls IMAGE
+//	godiskfs partition add [-table gpt|mbr] [-type TYPE] [-name NAME] [-size SIZE] IMAGE
+//	godiskfs partition rm IMAGE N
+//	godiskfs mkfs [-p N] -t fat32|ext4 [-L LABEL] IMAGE
+//	godiskfs ls [-p N] [-l] IMAGE [PATH]
+//	godiskfs cat [-p N] IMAGE PATH...
+//	godiskfs cp in [-p N] IMAGE LOCAL PATH
+//	godiskfs cp out [-p N] IMAGE PATH LOCAL
+//	godiskfs extract [-p N] IMAGE DIR
+//	godiskfs tree [-p N] IMAGE [PATH]
+//	godiskfs fsck [-p N] [-skip-contents] IMAGE
+//	godiskfs run [-C DIR] SCRIPT IMAGE
+//
+// Filesystems are in the partition N given by -p, starting at 1, or in the whole image by default.
+// fsck prints the findings of filesystem.Check, and exits with 1 if any is an error. run runs the
+// operations of a script of the script package on the image, such as adding partitions, making
+// filesystems and copying files into them.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// usages are the synopses of the commands, by name
+var usages = map[string]string{
+	"partition": "partition ls|add|rm ...",
+	"mkfs":      "mkfs [-p N] -t fat32|ext4 [-L LABEL] IMAGE",
+	"ls":        "ls [-p N] [-l] IMAGE [PATH]",
+	"cat":       "cat [-p N] IMAGE PATH...",
+	"cp":        "cp in|out [-p N] IMAGE SRC DST",
+	"extract":   "extract [-p N] IMAGE DIR",
+	"tree":      "tree [-p N] IMAGE [PATH]",
+	"fsck":      "fsck [-p N] [-skip-contents] IMAGE",
+	"run":       "run [-C DIR] SCRIPT IMAGE",
+}
+
+// errUsage is returned for invalid command lines, after the usage is printed
+var errUsage = errors.New("invalid usage")
+
+// errFindings is returned by fsck when a filesystem has errors
+var errFindings = errors.New("filesystem has errors")
+
+// cli runs the commands with their output
+type cli struct {
+	stdout, stderr io.Writer
+}
+
+func main() {
+	switch err := run(os.Args[1:], os.Stdout, os.Stderr); {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case errors.Is(err, errFindings):
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "godiskfs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command line args, without the name of the program
+func run(args []string, stdout, stderr io.Writer) error {
+	c := &cli{stdout: stdout, stderr: stderr}
+	if len(args) == 0 {
+		c.usage()
+		return errUsage
+	}
+	commands := map[string]func(args []string) error{
+		"partition": c.partition,
+		"mkfs":      c.mkfs,
+		"ls":        c.ls,
+		"cat":       c.cat,
+		"cp":        c.cp,
+		"extract":   c.extract,
+		"tree":      c.tree,
+		"fsck":      c.fsck,
+		"run":       c.runScript,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			c.usage()
+			return nil
+		}
+		fmt.Fprintf(stderr, "godiskfs: unknown command %q\n", args[0])
+		c.usage()
+		return errUsage
+	}
+	return cmd(args[1:])
+}
+
+func (c *cli) usage() {
+	names := make([]string, 0, len(usages))
+	for name := range usages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(c.stderr, "usage:")
+	for _, name := range names {
+		fmt.Fprintf(c.stderr, "  godiskfs %s\n", usages[name])
+	}
+}
+
+// flags returns the flags of a command with its usage, and those with a filesystem have -p
+func (c *cli) flags(usage string, part *int) *flag.FlagSet {
+	set := flag.NewFlagSet(strings.Fields(usage)[0], flag.ContinueOnError)
+	set.SetOutput(c.stderr)
+	set.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: godiskfs %s\n", usage)
+		set.PrintDefaults()
+	}
+	if part != nil {
+		set.IntVar(part, "p", 0, "partition of the filesystem, starting at 1, or 0 for the whole image")
+	}
+	return set
+}
+
+// parse parses the flags of args, and checks that there are between minArgs and maxArgs other
+// arguments, with no maximum if maxArgs is negative
+func (c *cli) parse(set *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := set.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if n := set.NArg(); n < minArgs || (maxArgs >= 0 && n > maxArgs) {
+		set.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// parseSize parses a size in bytes, with an optional suffix K, M, G or T for powers of 1024
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", s[i]&^0x20) + 1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/qcow2"
+	"github.com/diskfs/go-diskfs/backend/vhd"
+)
+
+const imageSize = 64 * 1024 * 1024
+
+// godiskfs runs the command line args, and returns its output
+func godiskfs(t *testing.T, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if err := run(args, &stdout, &stderr); err != nil {
+		t.Fatalf("godiskfs %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestImageFormat(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(raw, make([]byte, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := qcow2.CreateFromPath(filepath.Join(dir, "disk.qcow2"), imageSize)
+	if err != nil {
+		t.Fatalf("error creating qcow2 image: %v", err)
+	}
+	q.Close()
+	v, err := vhd.CreateFromPath(filepath.Join(dir, "disk.vhd"), imageSize)
+	if err != nil {
+		t.Fatalf("error creating VHD image: %v", err)
+	}
+	v.Close()
+	for name, expected := range map[string]string{"disk.img": formatRaw, "disk.qcow2": formatQcow2, "disk.vhd": formatVHD} {
+		if format, err := imageFormat(filepath.Join(dir, name)); err != nil || format != expected {
+			t.Errorf("format %q, %v of %s instead of %q", format, err, name, expected)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, format := range []string{formatRaw, formatQcow2} {
+		t.Run(format, func(t *testing.T) {
+			dir := t.TempDir()
+			img := filepath.Join(dir, "disk.img")
+			if format == formatQcow2 {
+				q, err := qcow2.CreateFromPath(img, imageSize)
+				if err != nil {
+					t.Fatalf("error creating image: %v", err)
+				}
+				q.Close()
+			} else if err := os.WriteFile(img, nil, 0o600); err != nil || os.Truncate(img, imageSize) != nil {
+				t.Fatalf("error creating image: %v", err)
+			}
+
+			if n := godiskfs(t, "partition", "add", "-type", "efi", "-size", "40M", img); n != "1\n" {
+				t.Errorf("added partition %q instead of 1", n)
+			}
+			if n := godiskfs(t, "partition", "add", img); n != "2\n" {
+				t.Errorf("added partition %q instead of 2", n)
+			}
+			list := strings.Split(godiskfs(t, "partition", "ls", img), "\n")
+			if len(list) != 4 || !strings.HasPrefix(list[0], "gpt ") || !strings.HasPrefix(list[1], "1\t2048\t83967\t") {
+				t.Errorf("partitions listed as %q", list)
+			}
+			godiskfs(t, "mkfs", "-p", "1", "-t", "fat32", "-L", "EFI", img)
+
+			// a tree of local files
+			src := filepath.Join(dir, "src")
+			if err := os.MkdirAll(filepath.Join(src, "boot", "grub"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "boot", "grub", "grub.cfg"), []byte("set timeout=5\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			godiskfs(t, "cp", "in", "-p", "1", img, src, "/")
+			godiskfs(t, "cp", "in", "-p", "1", img, filepath.Join(dir, "hello.txt"), "/boot")
+
+			if out := godiskfs(t, "ls", "-p", "1", img, "/boot"); out != "grub\nhello.txt\n" {
+				t.Errorf("listed %q", out)
+			}
+			if out := godiskfs(t, "cat", "-p", "1", img, "/boot/hello.txt", "boot/grub/grub.cfg"); out != "hello\nset timeout=5\n" {
+				t.Errorf("read %q", out)
+			}
+			expected := "/\n└── boot\n    ├── grub\n    │   └── grub.cfg\n    └── hello.txt\n"
+			if out := godiskfs(t, "tree", "-p", "1", img); out != expected {
+				t.Errorf("tree %q instead of %q", out, expected)
+			}
+			if out := godiskfs(t, "fsck", "-p", "1", img); out != "" {
+				t.Errorf("findings %q", out)
+			}
+
+			godiskfs(t, "cp", "out", "-p", "1", img, "/boot/hello.txt", filepath.Join(dir, "out.txt"))
+			if b, err := os.ReadFile(filepath.Join(dir, "out.txt")); err != nil || string(b) != "hello\n" {
+				t.Errorf("copied %q, %v out of the image", b, err)
+			}
+			extracted := filepath.Join(dir, "extracted")
+			godiskfs(t, "extract", "-p", "1", img, extracted)
+			if b, err := os.ReadFile(filepath.Join(extracted, "boot", "grub", "grub.cfg")); err != nil || string(b) != "set timeout=5\n" {
+				t.Errorf("extracted %q, %v", b, err)
+			}
+
+			godiskfs(t, "partition", "rm", img, "2")
+			if list := strings.Split(godiskfs(t, "partition", "ls", img), "\n"); len(list) != 3 {
+				t.Errorf("partitions listed as %q after removing one", list)
+			}
+		})
+	}
+}
+
+func TestMBR(t *testing.T) {
+	img := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(img, nil, 0o600); err != nil || os.Truncate(img, imageSize) != nil {
+		t.Fatalf("error creating image: %v", err)
+	}
+	godiskfs(t, "partition", "add", "-table", "mbr", "-type", "fat32", "-size", "16M", img)
+	godiskfs(t, "partition", "add", "-type", "0x83", img)
+	godiskfs(t, "partition", "rm", img, "1")
+	// the free entry is used first
+	if n := godiskfs(t, "partition", "add", "-size", "8M", img); n != "1\n" {
+		t.Errorf("added partition %q instead of 1", n)
+	}
+	list := strings.Split(godiskfs(t, "partition", "ls", img), "\n")
+	if len(list) != 4 || !strings.HasPrefix(list[2], "2\t34816\t131071\t") || !strings.Contains(list[2], "\t0x83\t") {
+		t.Errorf("partitions listed as %q", list)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(img, nil, 0o600); err != nil || os.Truncate(img, imageSize) != nil {
+		t.Fatalf("error creating image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "grub.cfg"), []byte("set timeout=5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	scriptFile := filepath.Join(dir, "image.json")
+	s := `{"operations": [
+		{"op": "add-partition", "type": "efi", "size": "40M"},
+		{"op": "mkfs", "partition": 1, "fstype": "fat32", "label": "EFI"},
+		{"op": "copy-in", "partition": 1, "source": "grub.cfg", "path": "/boot/grub/grub.cfg"}
+	]}`
+	if err := os.WriteFile(scriptFile, []byte(s), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	godiskfs(t, "run", scriptFile, img)
+	if out := godiskfs(t, "cat", "-p", "1", img, "/boot/grub/grub.cfg"); out != "set timeout=5\n" {
+		t.Errorf("read %q", out)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"cat"}, &stdout, &stderr); !errors.Is(err, errUsage) {
+		t.Errorf("error %v without arguments instead of %v", err, errUsage)
+	}
+	if !strings.Contains(stderr.String(), "usage: godiskfs cat") {
+		t.Errorf("usage %q", stderr.String())
+	}
+	if err := run([]string{"format"}, &stdout, &stderr); !errors.Is(err, errUsage) {
+		t.Errorf("error %v for an unknown command instead of %v", err, errUsage)
+	}
+}
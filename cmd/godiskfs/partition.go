@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// partitionAlignment is where new partitions start, in bytes, as by default for fdisk and sgdisk
+const partitionAlignment = 1024 * 1024
+
+// partitionTypes are the names of partition types accepted by partition add
+var partitionTypes = map[string]struct {
+	gpt gpt.Type
+	mbr mbr.Type
+}{
+	"linux": {gpt.LinuxFilesystem, mbr.Linux},
+	"efi":   {gpt.EFISystemPartition, mbr.EFISystem},
+	"swap":  {gpt.LinuxSwap, mbr.LinuxSwap},
+	"lvm":   {gpt.LinuxLVM, mbr.LinuxLVM},
+	"fat32": {gpt.MicrosoftBasicData, mbr.Fat32LBA},
+}
+
+func (c *cli) partition(args []string) error {
+	usage := usages["partition"]
+	if len(args) == 0 {
+		fmt.Fprintf(c.stderr, "usage: godiskfs %s\n", usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "ls":
+		return c.partitionList(args[1:])
+	case "add":
+		return c.partitionAdd(args[1:])
+	case "rm":
+		return c.partitionRemove(args[1:])
+	default:
+		fmt.Fprintf(c.stderr, "usage: godiskfs %s\n", usage)
+		return errUsage
+	}
+}
+
+func (c *cli) partitionList(args []string) error {
+	set := c.flags("partition ls IMAGE", nil)
+	if err := c.parse(set, args, 1, 1); err != nil {
+		return err
+	}
+	d, err := openDisk(set.Arg(0), false)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	switch t := d.Table.(type) {
+	case *gpt.Table:
+		fmt.Fprintf(c.stdout, "gpt %s\n", t.GUID)
+		for i, p := range t.Partitions {
+			fmt.Fprintf(c.stdout, "%d\t%d\t%d\t%d\t%s\t%s\t%s\n", i+1, p.Start, p.End, p.Size, p.Type, p.GUID, p.Name)
+		}
+	case *mbr.Table:
+		fmt.Fprintf(c.stdout, "mbr %s\n", t.UUID())
+		for i, p := range t.Partitions {
+			if p.Type == mbr.Empty {
+				continue
+			}
+			boot := ""
+			if p.Bootable {
+				boot = "boot"
+			}
+			fmt.Fprintf(c.stdout, "%d\t%d\t%d\t%d\t%#02x\t%s\t%s\n", i+1, p.Start, uint64(p.Start)+uint64(p.Size)-1, int64(p.Size)*int64(t.LogicalSectorSize), uint8(p.Type), p.UUID(), boot)
+		}
+	case nil:
+		return fmt.Errorf("no partition table in %s", set.Arg(0))
+	default:
+		fmt.Fprintf(c.stdout, "%s %s\n", t.Type(), t.UUID())
+	}
+	return nil
+}
+
+func (c *cli) partitionAdd(args []string) error {
+	set := c.flags("partition add [-table gpt|mbr] [-type TYPE] [-name NAME] [-size SIZE] IMAGE", nil)
+	tableType := set.String("table", "gpt", "type of the partition table created if the image has none")
+	typeName := set.String("type", "linux", "type of the partition, linux, efi, swap, lvm or fat32, or a GUID for GPT or a number for MBR")
+	name := set.String("name", "", "name of the partition, for GPT")
+	sizeFlag := set.String("size", "", "size of the partition, e.g. 512M, the rest of the disk by default")
+	if err := c.parse(set, args, 1, 1); err != nil {
+		return err
+	}
+	var size int64
+	if *sizeFlag != "" {
+		var err error
+		if size, err = parseSize(*sizeFlag); err != nil {
+			return err
+		}
+	}
+	d, err := openDisk(set.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	table := d.Table
+	if table == nil {
+		switch *tableType {
+		case "gpt":
+			table = &gpt.Table{LogicalSectorSize: int(d.LogicalBlocksize), PhysicalSectorSize: int(d.PhysicalBlocksize), ProtectiveMBR: true}
+		case "mbr":
+			table = &mbr.Table{LogicalSectorSize: int(d.LogicalBlocksize), PhysicalSectorSize: int(d.PhysicalBlocksize)}
+		default:
+			return fmt.Errorf("unknown partition table type %q", *tableType)
+		}
+	}
+	n, err := addPartition(d, table, *typeName, *name, size)
+	if err != nil {
+		return err
+	}
+	if err := d.Partition(table); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, n)
+	return nil
+}
+
+// addPartition adds a partition of size bytes to the table of d, in the first space free for it,
+// or the rest of the disk after the last partition for 0, and returns its number
+func addPartition(d *disk.Disk, table partition.Table, typeName, name string, size int64) (int, error) {
+	sectorSize := d.LogicalBlocksize
+	if size%sectorSize != 0 {
+		return 0, fmt.Errorf("size %d is not a multiple of the sector size %d", size, sectorSize)
+	}
+	var used [][2]int64
+	for _, p := range table.GetPartitions() {
+		if p.GetSize() > 0 {
+			used = append(used, [2]int64{p.GetStart() / sectorSize, (p.GetStart()+p.GetSize())/sectorSize - 1})
+		}
+	}
+	types, known := partitionTypes[typeName]
+
+	switch t := table.(type) {
+	case *gpt.Table:
+		// the partition array and the secondary header are in the last sectors
+		arraySectors := int64(128*128) / sectorSize
+		start, end, err := freeSectors(used, d.Size/sectorSize-1-arraySectors-1, partitionAlignment/sectorSize, size/sectorSize)
+		if err != nil {
+			return 0, err
+		}
+		partType := types.gpt
+		if !known {
+			partType = gpt.Type(strings.ToUpper(typeName))
+		}
+		t.Partitions = append(t.Partitions, &gpt.Partition{Start: uint64(start), End: uint64(end), Type: partType, Name: name})
+		return len(t.Partitions), nil
+	case *mbr.Table:
+		start, end, err := freeSectors(used, min(d.Size/sectorSize-1, int64(^uint32(0))), partitionAlignment/sectorSize, size/sectorSize)
+		if err != nil {
+			return 0, err
+		}
+		partType := types.mbr
+		if !known {
+			v, err := strconv.ParseUint(typeName, 0, 8)
+			if err != nil {
+				return 0, fmt.Errorf("unknown partition type %q", typeName)
+			}
+			partType = mbr.Type(v)
+		}
+		p := &mbr.Partition{Type: partType, Start: uint32(start), Size: uint32(end - start + 1)}
+		for i, old := range t.Partitions {
+			if old.Type == mbr.Empty {
+				t.Partitions[i] = p
+				return i + 1, nil
+			}
+		}
+		if len(t.Partitions) >= 4 {
+			return 0, fmt.Errorf("no free entry in the MBR for another primary partition")
+		}
+		t.Partitions = append(t.Partitions, p)
+		return len(t.Partitions), nil
+	default:
+		return 0, fmt.Errorf("cannot add partitions to a %s partition table", table.Type())
+	}
+}
+
+// freeSectors returns the first and last sectors of the first range of sectors free between
+// the used ranges, starting at a multiple of align and ending by last, or of the range after
+// them all if sectors is 0
+func freeSectors(used [][2]int64, last, align, sectors int64) (start, end int64, err error) {
+	slices.SortFunc(used, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	start = align
+	for _, u := range used {
+		if sectors > 0 && start+sectors-1 < u[0] {
+			break
+		}
+		start = max(start, (u[1]+align)/align*align)
+	}
+	end = last
+	if sectors > 0 {
+		end = start + sectors - 1
+	}
+	if end > last || end < start {
+		return 0, 0, fmt.Errorf("no room for a partition of %d sectors before sector %d", sectors, last)
+	}
+	return start, end, nil
+}
+
+func (c *cli) partitionRemove(args []string) error {
+	set := c.flags("partition rm IMAGE N", nil)
+	if err := c.parse(set, args, 2, 2); err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(set.Arg(1))
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid partition number %q", set.Arg(1))
+	}
+	d, err := openDisk(set.Arg(0), true)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	switch t := d.Table.(type) {
+	case *gpt.Table:
+		if n > len(t.Partitions) {
+			return fmt.Errorf("no partition %d", n)
+		}
+		// the entries of GPT are read without the unused ones, so those after it are renumbered
+		t.Partitions = append(t.Partitions[:n-1], t.Partitions[n:]...)
+	case *mbr.Table:
+		if n > len(t.Partitions) || t.Partitions[n-1].Type == mbr.Empty {
+			return fmt.Errorf("no partition %d", n)
+		}
+		t.Partitions[n-1] = &mbr.Partition{Type: mbr.Empty}
+	case nil:
+		return fmt.Errorf("no partition table in %s", set.Arg(0))
+	default:
+		return fmt.Errorf("cannot remove partitions from a %s partition table", t.Type())
+	}
+	return d.Partition(d.Table)
+}
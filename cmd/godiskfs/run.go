@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/script"
+)
+
+func (c *cli) runScript(args []string) error {
+	set := c.flags(usages["run"], nil)
+	baseDir := set.String("C", "", "directory of the host paths of the script, that of the script by default")
+	if err := c.parse(set, args, 2, 2); err != nil {
+		return err
+	}
+	s, err := script.ParseFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir := *baseDir
+	if dir == "" {
+		dir = filepath.Dir(set.Arg(0))
+	}
+	d, err := openDisk(set.Arg(1), true)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := s.Run(d, script.WithBaseDir(dir)); err != nil {
+		return err
+	}
+	return d.Sync()
+}
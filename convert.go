@@ -0,0 +1,156 @@
+package diskfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// DefaultConvertChunkSize is the size of the chunks in which Convert copies, and checks for zeroes
+const DefaultConvertChunkSize = 1024 * 1024
+
+type convertOpts struct {
+	chunkSize       int64
+	progress        func(done, total int64)
+	zeroDestination bool
+}
+
+// ConvertOpt func that process Convert options
+type ConvertOpt func(o *convertOpts) error
+
+// WithConvertChunkSize sets the size of the chunks copied by Convert, which must be a positive multiple of 512.
+// Chunks that are entirely zero are not written, so chunks no larger than the clusters or blocks of the
+// destination format keep it as sparse as possible. Default is DefaultConvertChunkSize.
+func WithConvertChunkSize(size int64) ConvertOpt {
+	return func(o *convertOpts) error {
+		if size <= 0 || size%512 != 0 {
+			return fmt.Errorf("invalid chunk size %d, must be a positive multiple of 512", size)
+		}
+		o.chunkSize = size
+		return nil
+	}
+}
+
+// WithConvertProgress sets a func called by Convert after each chunk, with the number of bytes
+// done and the total size of the source
+func WithConvertProgress(progress func(done, total int64)) ConvertOpt {
+	return func(o *convertOpts) error {
+		o.progress = progress
+		return nil
+	}
+}
+
+// WithZeroedDestination tells Convert that the destination already reads as zero, as newly created
+// images do, so that chunks of zeroes are skipped without checking the destination. By default,
+// the destination is read where the source is zero, and only overwritten if it is not zero.
+func WithZeroedDestination() ConvertOpt {
+	return func(o *convertOpts) error {
+		o.zeroDestination = true
+		return nil
+	}
+}
+
+// Convert copies the disk in the src backend to the dst backend, which must be writable and at least as
+// large. Any backends may be used, e.g. to convert a raw image into a qcow2, VHD or VMDK image, as
+// qemu-img convert does; regions of zeroes in the source are not written, so that they are not allocated
+// in sparse destinations.
+func Convert(src, dst backend.Storage, opts ...ConvertOpt) error {
+	return ConvertContext(context.Background(), src, dst, opts...)
+}
+
+// ConvertContext copies the disk in the src backend to the dst backend like Convert, stopping with the
+// error of ctx when it is done, between chunks
+func ConvertContext(ctx context.Context, src, dst backend.Storage, opts ...ConvertOpt) error {
+	opt := &convertOpts{
+		chunkSize: DefaultConvertChunkSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return err
+		}
+	}
+	rw, err := dst.Writable()
+	if err != nil {
+		return fmt.Errorf("destination is not writable: %w", err)
+	}
+	size, err := backendSize(src)
+	if err != nil {
+		return fmt.Errorf("could not get size of source: %w", err)
+	}
+	dstSize, err := backendSize(dst)
+	if err != nil {
+		return fmt.Errorf("could not get size of destination: %w", err)
+	}
+	if dstSize < size {
+		return fmt.Errorf("destination of %d bytes is smaller than the source of %d bytes", dstSize, size)
+	}
+
+	data := make([]byte, opt.chunkSize)
+	existing := make([]byte, opt.chunkSize)
+	zero := make([]byte, opt.chunkSize)
+	for offset := int64(0); offset < size; offset += opt.chunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b := data[:min(opt.chunkSize, size-offset)]
+		if err := readChunk(src, b, offset); err != nil {
+			return fmt.Errorf("error reading source at %d: %w", offset, err)
+		}
+		write := !bytes.Equal(b, zero[:len(b)])
+		if !write && !opt.zeroDestination {
+			e := existing[:len(b)]
+			if err := readChunk(dst, e, offset); err != nil {
+				return fmt.Errorf("error reading destination at %d: %w", offset, err)
+			}
+			write = !bytes.Equal(e, zero[:len(e)])
+		}
+		if write {
+			if _, err := rw.WriteAt(b, offset); err != nil {
+				return fmt.Errorf("error writing destination at %d: %w", offset, err)
+			}
+		}
+		if opt.progress != nil {
+			opt.progress(offset+int64(len(b)), size)
+		}
+	}
+	return nil
+}
+
+// backendSize returns the size of the disk in the backend, which image backends report in Stat,
+// and block devices only as the end of the device
+func backendSize(b backend.Storage) (int64, error) {
+	info, err := b.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if size := info.Size(); size > 0 {
+		return size, nil
+	}
+	current, err := b.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := b.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := b.Seek(current, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func readChunk(b io.ReaderAt, p []byte, offset int64) error {
+	n, err := b.ReadAt(p, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n != len(p) {
+		return fmt.Errorf("read %d bytes instead of %d", n, len(p))
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package diskfs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/backend/qcow2"
+	"github.com/diskfs/go-diskfs/backend/vhd"
+	"github.com/diskfs/go-diskfs/backend/vmdk"
+)
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(16 * 1024 * 1024)
+	expected := make([]byte, size)
+	_, _ = rand.Read(expected[:64*1024])
+	_, _ = rand.Read(expected[5*1024*1024+100 : 5*1024*1024+3000])
+	_, _ = rand.Read(expected[size-512:])
+	rawPath := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(rawPath, expected, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := file.OpenFromPath(rawPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// raw to qcow2 to VHD to VMDK, then back to raw over existing data
+	qcow2Img, err := qcow2.CreateFromPath(filepath.Join(dir, "disk.qcow2"), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qcow2Img.Close()
+	vhdImg, err := vhd.CreateFromPath(filepath.Join(dir, "disk.vhd"), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vhdImg.Close()
+	vmdkImg, err := vmdk.CreateFromPath(filepath.Join(dir, "disk.vmdk"), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vmdkImg.Close()
+	outPath := filepath.Join(dir, "out.img")
+	noise := make([]byte, size)
+	_, _ = rand.Read(noise)
+	if err := os.WriteFile(outPath, noise, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := file.OpenFromPath(outPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	steps := []struct {
+		src, dst backend.Storage
+		opts     []diskfs.ConvertOpt
+	}{
+		{src, qcow2Img, []diskfs.ConvertOpt{diskfs.WithZeroedDestination(), diskfs.WithConvertChunkSize(64 * 1024)}},
+		{qcow2Img, vhdImg, nil},
+		{vhdImg, vmdkImg, nil},
+		{vmdkImg, out, nil},
+	}
+	for i, s := range steps {
+		var done, total int64
+		opts := append(s.opts, diskfs.WithConvertProgress(func(d, t int64) {
+			done, total = d, t
+		}))
+		if err := diskfs.Convert(s.src, s.dst, opts...); err != nil {
+			t.Fatalf("step %d: error converting: %v", i, err)
+		}
+		if done != size || total != size {
+			t.Errorf("step %d: final progress %d of %d instead of %d", i, done, total, size)
+		}
+	}
+
+	b, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("mismatched contents after conversions")
+	}
+	// zeroes are not allocated in the sparse formats
+	info, err := os.Stat(filepath.Join(dir, "disk.qcow2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*1024*1024 {
+		t.Errorf("qcow2 image is %d bytes for less than 128KB of data", info.Size())
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	dir := t.TempDir()
+	large, err := file.CreateFromPath(filepath.Join(dir, "large.img"), 2*1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer large.Close()
+	small, err := file.CreateFromPath(filepath.Join(dir, "small.img"), 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer small.Close()
+	if err := diskfs.Convert(large, small); err == nil {
+		t.Errorf("expected error converting to a smaller destination")
+	}
+	if err := diskfs.Convert(small, large, diskfs.WithConvertChunkSize(1000)); err == nil {
+		t.Errorf("expected error for invalid chunk size")
+	}
+	readOnly, err := file.OpenFromPath(filepath.Join(dir, "large.img"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	if err := diskfs.Convert(small, readOnly); err == nil {
+		t.Errorf("expected error converting to a read-only destination")
+	}
+}
+
+func TestConvertCanceled(t *testing.T) {
+	src := mem.New(make([]byte, 4*1024*1024), true)
+	dst, err := mem.Create(4 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var chunks int
+	progress := func(_, _ int64) {
+		chunks++
+		cancel()
+	}
+	if err := diskfs.ConvertContext(ctx, src, dst, diskfs.WithConvertProgress(progress)); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of %v", err, context.Canceled)
+	}
+	if chunks != 1 {
+		t.Errorf("converted %d chunks after it was canceled", chunks-1)
+	}
+}
@@ -0,0 +1,485 @@
+// Package delta generates and applies block-level deltas between two raw disk images, so that an
+// update to a device that holds the old image ships only what changed, as OTA pipelines do.
+//
+// A delta lists the blocks of the new image, the target, that differ from those at the same offset
+// of the old image, the source: as their data, as zeroes, or as a copy of another block of the source
+// that holds the same data, e.g. where a file moved. Every other block is the same as in the
+// source. The delta carries the sha256 of the source, which Apply checks before writing anything,
+// and of the target, which it checks once written. Deltas are not compressed; they compress well,
+// e.g. through zstd, where changed blocks do.
+//
+// The format is of this package: a header of the magic "DISKFSDT", the version, the block size,
+// the sizes of the source and of the target and the sha256 of the source, followed by the
+// operations in the order of their blocks, and an end with the sha256 of the target. All numbers
+// are little-endian.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks compared, as of most filesystems
+	DefaultBlockSize = 4096
+
+	magic   = "DISKFSDT"
+	version = 1
+
+	headerSize = 8 + 4 + 4 + 8 + 8 + sha256.Size
+)
+
+// the operations of a delta
+const (
+	// opEnd ends the delta, with the sha256 of the target
+	opEnd byte = iota
+	// opData has the data of count blocks from block
+	opData
+	// opZero zeroes count blocks from block
+	opZero
+	// opCopy copies count blocks from block of the source to block of the target
+	opCopy
+)
+
+var (
+	// ErrSourceMismatch is returned by Apply when the source is not the image the delta was made from
+	ErrSourceMismatch = errors.New("source is not the image of the delta")
+	// ErrTargetMismatch is returned by Apply when the image written is not the one the delta was
+	// made to, which a corrupt delta would cause
+	ErrTargetMismatch = errors.New("image written is not the target of the delta")
+)
+
+// Stats counts the blocks of the target in a delta
+type Stats struct {
+	// BlockSize is the size of the blocks, the last of which may be shorter
+	BlockSize int64
+	// Blocks is the count of blocks of the target
+	Blocks uint64
+	// Unchanged, Data, Zero and Copied count the blocks the same as in the source, with their data
+	// in the delta, of zeroes, and copied from elsewhere in the source
+	Unchanged, Data, Zero, Copied uint64
+	// Size is that of the delta, in bytes
+	Size int64
+}
+
+type generateOpts struct {
+	blockSize int64
+	noCopies  bool
+}
+
+// GenerateOpt func that process Generate options
+type GenerateOpt func(o *generateOpts) error
+
+// WithBlockSize sets the size of the blocks compared, which must be a positive multiple of 512 of at
+// most 1 GB. Default is DefaultBlockSize.
+func WithBlockSize(size int64) GenerateOpt {
+	return func(o *generateOpts) error {
+		if size <= 0 || size%512 != 0 || size > 1<<30 {
+			return fmt.Errorf("invalid block size %d, must be a positive multiple of 512 of at most 1 GB", size)
+		}
+		o.blockSize = size
+		return nil
+	}
+}
+
+// WithoutCopies makes Generate write the data of all the changed blocks that are not zero, rather
+// than find those held elsewhere in the source, which needs the sha256 of each block of the source
+// in memory, 40 bytes or so for each
+func WithoutCopies() GenerateOpt {
+	return func(o *generateOpts) error {
+		o.noCopies = true
+		return nil
+	}
+}
+
+// header is the start of a delta
+type header struct {
+	blockSize  int64
+	sourceSize int64
+	targetSize int64
+	sourceSum  [sha256.Size]byte
+}
+
+func (h *header) toBytes() []byte {
+	b := make([]byte, headerSize)
+	copy(b[0:8], magic)
+	binary.LittleEndian.PutUint32(b[8:12], version)
+	binary.LittleEndian.PutUint32(b[12:16], uint32(h.blockSize))
+	binary.LittleEndian.PutUint64(b[16:24], uint64(h.sourceSize))
+	binary.LittleEndian.PutUint64(b[24:32], uint64(h.targetSize))
+	copy(b[32:], h.sourceSum[:])
+	return b
+}
+
+func headerFromBytes(b []byte) (*header, error) {
+	if string(b[0:8]) != magic {
+		return nil, fmt.Errorf("not a delta, magic %q instead of %q", b[0:8], magic)
+	}
+	if v := binary.LittleEndian.Uint32(b[8:12]); v != version {
+		return nil, fmt.Errorf("unsupported delta version %d", v)
+	}
+	h := &header{
+		blockSize:  int64(binary.LittleEndian.Uint32(b[12:16])),
+		sourceSize: int64(binary.LittleEndian.Uint64(b[16:24])),
+		targetSize: int64(binary.LittleEndian.Uint64(b[24:32])),
+	}
+	copy(h.sourceSum[:], b[32:])
+	if h.blockSize <= 0 || h.blockSize%512 != 0 || h.sourceSize < 0 || h.targetSize < 0 {
+		return nil, fmt.Errorf("invalid delta of blocks of %d bytes from %d to %d bytes", h.blockSize, h.sourceSize, h.targetSize)
+	}
+	return h, nil
+}
+
+// blocks returns the count of blocks of size bytes
+func (h *header) blocks(size int64) uint64 {
+	return uint64((size + h.blockSize - 1) / h.blockSize)
+}
+
+// readBlock reads the block of the image of size bytes into b, returning the bytes of it, which are
+// fewer for the last block
+func readBlock(r io.ReaderAt, size, blockSize int64, block uint64, b []byte) ([]byte, error) {
+	offset := int64(block) * blockSize
+	b = b[:min(blockSize, size-offset)]
+	n, err := r.ReadAt(b, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading block %d: %w", block, err)
+	}
+	if n != len(b) {
+		return nil, fmt.Errorf("error reading block %d: read %d bytes instead of %d", block, n, len(b))
+	}
+	return b, nil
+}
+
+// hashImage returns the sha256 of the image of size bytes, calling found with each of its blocks
+func hashImage(r io.ReaderAt, size, blockSize int64, found func(block uint64, b []byte)) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	buf := make([]byte, blockSize)
+	blocks := uint64((size + blockSize - 1) / blockSize)
+	for block := uint64(0); block < blocks; block++ {
+		b, err := readBlock(r, size, blockSize, block, buf)
+		if err != nil {
+			return sum, err
+		}
+		h.Write(b)
+		if found != nil {
+			found(block, b)
+		}
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// opWriter writes the operations of a delta, merging those that continue the last
+type opWriter struct {
+	w                       *bufio.Writer
+	op                      byte
+	block, count, fromBlock uint64
+	data                    bytes.Buffer
+}
+
+func (o *opWriter) add(op byte, block, fromBlock uint64, data []byte) error {
+	if o.count > 0 && (op != o.op || block != o.block+o.count || (op == opCopy && fromBlock != o.fromBlock+o.count) || o.count == 1<<32-1) {
+		if err := o.flush(); err != nil {
+			return err
+		}
+	}
+	if o.count == 0 {
+		o.op, o.block, o.fromBlock = op, block, fromBlock
+	}
+	o.count++
+	if op == opData {
+		// the data is written once its count is known, so at most a few MB are held
+		o.data.Write(data)
+		if o.data.Len() >= 4*1024*1024 {
+			return o.flush()
+		}
+	}
+	return nil
+}
+
+func (o *opWriter) flush() error {
+	if o.count == 0 {
+		return nil
+	}
+	b := make([]byte, 13, 21)
+	b[0] = o.op
+	binary.LittleEndian.PutUint64(b[1:9], o.block)
+	binary.LittleEndian.PutUint32(b[9:13], uint32(o.count))
+	if o.op == opCopy {
+		b = binary.LittleEndian.AppendUint64(b, o.fromBlock)
+	}
+	if _, err := o.w.Write(b); err != nil {
+		return err
+	}
+	if _, err := o.data.WriteTo(o.w); err != nil {
+		return err
+	}
+	o.count = 0
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Generate writes to w the delta from the source image of sourceSize bytes to the target image of
+// targetSize bytes, comparing them a block at a time. The source is read twice: once for its
+// sha256, and the sha256 of its blocks unless WithoutCopies, and once to compare it with the target.
+//
+// returns the counts of the blocks of the delta
+func Generate(source io.ReaderAt, sourceSize int64, target io.ReaderAt, targetSize int64, w io.Writer, opts ...GenerateOpt) (*Stats, error) {
+	opt := &generateOpts{
+		blockSize: DefaultBlockSize,
+	}
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+	if sourceSize < 0 || targetSize < 0 {
+		return nil, fmt.Errorf("invalid image sizes %d and %d", sourceSize, targetSize)
+	}
+	h := &header{blockSize: opt.blockSize, sourceSize: sourceSize, targetSize: targetSize}
+	zero := make([]byte, opt.blockSize)
+
+	// the first block of the source with each content, to copy blocks that moved
+	var sourceBlocks map[[sha256.Size]byte]uint64
+	var found func(block uint64, b []byte)
+	if !opt.noCopies {
+		sourceBlocks = map[[sha256.Size]byte]uint64{}
+		found = func(block uint64, b []byte) {
+			if int64(len(b)) != opt.blockSize || bytes.Equal(b, zero) {
+				return
+			}
+			sum := sha256.Sum256(b)
+			if _, ok := sourceBlocks[sum]; !ok {
+				sourceBlocks[sum] = block
+			}
+		}
+	}
+	var err error
+	h.sourceSum, err = hashImage(source, sourceSize, opt.blockSize, found)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source: %w", err)
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, 1024*1024)
+	if _, err := bw.Write(h.toBytes()); err != nil {
+		return nil, fmt.Errorf("error writing delta: %w", err)
+	}
+	stats := &Stats{BlockSize: opt.blockSize, Blocks: h.blocks(targetSize)}
+	ops := &opWriter{w: bw}
+	targetHash := sha256.New()
+	targetBuf := make([]byte, opt.blockSize)
+	sourceBuf := make([]byte, opt.blockSize)
+	for block := uint64(0); block < stats.Blocks; block++ {
+		t, err := readBlock(target, targetSize, opt.blockSize, block, targetBuf)
+		if err != nil {
+			return nil, fmt.Errorf("error reading target: %w", err)
+		}
+		targetHash.Write(t)
+		// the same block of the source, where it is as long
+		if int64(block)*opt.blockSize+int64(len(t)) <= sourceSize {
+			s, err := readBlock(source, sourceSize, opt.blockSize, block, sourceBuf)
+			if err != nil {
+				return nil, fmt.Errorf("error reading source: %w", err)
+			}
+			if bytes.Equal(s[:len(t)], t) {
+				stats.Unchanged++
+				continue
+			}
+		}
+		if bytes.Equal(t, zero[:len(t)]) {
+			stats.Zero++
+			err = ops.add(opZero, block, 0, nil)
+		} else if from, ok := findCopy(source, h, sourceBlocks, t, sourceBuf); ok {
+			stats.Copied++
+			err = ops.add(opCopy, block, from, nil)
+		} else {
+			stats.Data++
+			err = ops.add(opData, block, 0, t)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error writing delta: %w", err)
+		}
+	}
+	if err := ops.flush(); err != nil {
+		return nil, fmt.Errorf("error writing delta: %w", err)
+	}
+	end := append([]byte{opEnd}, targetHash.Sum(nil)...)
+	if _, err := bw.Write(end); err != nil {
+		return nil, fmt.Errorf("error writing delta: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing delta: %w", err)
+	}
+	stats.Size = cw.n
+	return stats, nil
+}
+
+// findCopy returns the block of the source with the same data as the full block b, if any
+func findCopy(source io.ReaderAt, h *header, sourceBlocks map[[sha256.Size]byte]uint64, b, buf []byte) (uint64, bool) {
+	if sourceBlocks == nil || int64(len(b)) != h.blockSize {
+		return 0, false
+	}
+	block, ok := sourceBlocks[sha256.Sum256(b)]
+	if !ok {
+		return 0, false
+	}
+	// the source is compared rather than trusted to be as it was hashed
+	s, err := readBlock(source, h.sourceSize, h.blockSize, block, buf)
+	if err != nil || !bytes.Equal(s, b) {
+		return 0, false
+	}
+	return block, true
+}
+
+// Apply writes to target the image the delta read from r was made to, from the source image it
+// was made from. The sha256 of the source is checked before anything is written, and the error is
+// ErrSourceMismatch if it differs; that of the image written is checked at the end, and the error is
+// ErrTargetMismatch if it differs. The target must not be the source, as the blocks copied within the
+// source may have been written over already; it is written in full, the blocks unchanged by the
+// delta copied from the source.
+func Apply(source io.ReaderAt, r io.Reader, target io.WriterAt) error {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	hb := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, hb); err != nil {
+		return fmt.Errorf("error reading delta header: %w", err)
+	}
+	h, err := headerFromBytes(hb)
+	if err != nil {
+		return err
+	}
+	sum, err := hashImage(source, h.sourceSize, h.blockSize, nil)
+	if err != nil {
+		return fmt.Errorf("error reading source: %w", err)
+	}
+	if sum != h.sourceSum {
+		return ErrSourceMismatch
+	}
+
+	a := &applier{source: source, target: target, h: h, hash: sha256.New(), buf: make([]byte, h.blockSize), blocks: h.blocks(h.targetSize)}
+	opb := make([]byte, 12)
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("error reading delta: %w", err)
+		}
+		if op == opEnd {
+			break
+		}
+		if _, err := io.ReadFull(br, opb); err != nil {
+			return fmt.Errorf("error reading delta: %w", err)
+		}
+		block := binary.LittleEndian.Uint64(opb[0:8])
+		count := uint64(binary.LittleEndian.Uint32(opb[8:12]))
+		if block < a.next || count == 0 || block+count > a.blocks {
+			return fmt.Errorf("invalid delta operation on %d blocks from block %d after block %d of %d", count, block, a.next, a.blocks)
+		}
+		if err := a.unchanged(block); err != nil {
+			return err
+		}
+		var fromBlock uint64
+		if op == opCopy {
+			if _, err := io.ReadFull(br, opb[:8]); err != nil {
+				return fmt.Errorf("error reading delta: %w", err)
+			}
+			fromBlock = binary.LittleEndian.Uint64(opb[:8])
+			if fromBlock+count > h.blocks(h.sourceSize) {
+				return fmt.Errorf("invalid delta copy of %d blocks from block %d of the source", count, fromBlock)
+			}
+		}
+		for i := uint64(0); i < count; i++ {
+			b := a.buf[:a.blockLength(block+i)]
+			switch op {
+			case opData:
+				_, err = io.ReadFull(br, b)
+			case opZero:
+				clear(b)
+			case opCopy:
+				b, err = readBlock(source, h.sourceSize, h.blockSize, fromBlock+i, b)
+				if err == nil && len(b) != int(a.blockLength(block+i)) {
+					err = fmt.Errorf("invalid delta copy of the short last block of the source")
+				}
+			default:
+				err = fmt.Errorf("unknown delta operation %d", op)
+			}
+			if err != nil {
+				return fmt.Errorf("error applying delta to block %d: %w", block+i, err)
+			}
+			if err := a.write(block+i, b); err != nil {
+				return err
+			}
+		}
+	}
+	if err := a.unchanged(a.blocks); err != nil {
+		return err
+	}
+	end := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(br, end); err != nil {
+		return fmt.Errorf("error reading delta: %w", err)
+	}
+	if !bytes.Equal(a.hash.Sum(nil), end) {
+		return ErrTargetMismatch
+	}
+	return nil
+}
+
+// applier writes the blocks of the target in order
+type applier struct {
+	source io.ReaderAt
+	target io.WriterAt
+	h      *header
+	hash   hash.Hash
+	buf    []byte
+	blocks uint64
+	// next is the block after the last written
+	next uint64
+}
+
+func (a *applier) blockLength(block uint64) int64 {
+	return min(a.h.blockSize, a.h.targetSize-int64(block)*a.h.blockSize)
+}
+
+// unchanged copies the blocks of the source up to the block, which the delta leaves as they are
+func (a *applier) unchanged(block uint64) error {
+	for a.next < block {
+		length := a.blockLength(a.next)
+		if int64(a.next)*a.h.blockSize+length > a.h.sourceSize {
+			return fmt.Errorf("invalid delta without block %d, which is not in the source", a.next)
+		}
+		b, err := readBlock(a.source, a.h.sourceSize, a.h.blockSize, a.next, a.buf)
+		if err != nil {
+			return fmt.Errorf("error reading source: %w", err)
+		}
+		if err := a.write(a.next, b[:length]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *applier) write(block uint64, b []byte) error {
+	if _, err := a.target.WriteAt(b, int64(block)*a.h.blockSize); err != nil {
+		return fmt.Errorf("error writing block %d: %w", block, err)
+	}
+	a.hash.Write(b)
+	a.next = block + 1
+	return nil
+}
@@ -0,0 +1,129 @@
+package delta_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/delta"
+)
+
+// buffer is an io.WriterAt of a target image
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(w.b) {
+		w.b = append(w.b, make([]byte, end-len(w.b))...)
+	}
+	return copy(w.b[off:], p), nil
+}
+
+func TestGenerateApply(t *testing.T) {
+	const blockSize = delta.DefaultBlockSize
+	source := make([]byte, 256*blockSize+100)
+	_, _ = rand.Read(source)
+
+	tests := []struct {
+		name     string
+		target   func() []byte
+		opts     []delta.GenerateOpt
+		expected delta.Stats
+	}{
+		{"same", func() []byte { return bytes.Clone(source) }, nil,
+			delta.Stats{Blocks: 257, Unchanged: 257}},
+		{"changed", func() []byte {
+			b := bytes.Clone(source)
+			// 2 blocks changed, 3 zeroed and 4 moved from the start
+			_, _ = rand.Read(b[10*blockSize+5 : 12*blockSize-5])
+			clear(b[20*blockSize : 23*blockSize])
+			copy(b[100*blockSize:104*blockSize], source[0:4*blockSize])
+			return b
+		}, nil,
+			delta.Stats{Blocks: 257, Unchanged: 248, Data: 2, Zero: 3, Copied: 4}},
+		{"without copies", func() []byte {
+			b := bytes.Clone(source)
+			copy(b[100*blockSize:104*blockSize], source[0:4*blockSize])
+			return b
+		}, []delta.GenerateOpt{delta.WithoutCopies()},
+			delta.Stats{Blocks: 257, Unchanged: 253, Data: 4}},
+		{"grown", func() []byte {
+			b := append(bytes.Clone(source), make([]byte, 10*blockSize)...)
+			_, _ = rand.Read(b[len(b)-10:])
+			return b
+		}, nil,
+			// the last block of the source is now full, and the last of the target has data
+			delta.Stats{Blocks: 267, Unchanged: 256, Data: 2, Zero: 9}},
+		{"shrunk", func() []byte { return bytes.Clone(source[:128*blockSize+1]) }, nil,
+			delta.Stats{Blocks: 129, Unchanged: 129}},
+		{"small blocks", func() []byte {
+			b := bytes.Clone(source)
+			b[0] ^= 0xff
+			return b
+		}, []delta.GenerateOpt{delta.WithBlockSize(512)},
+			delta.Stats{Blocks: 2049, Unchanged: 2048, Data: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target()
+			var d bytes.Buffer
+			stats, err := delta.Generate(bytes.NewReader(source), int64(len(source)), bytes.NewReader(target), int64(len(target)), &d, tt.opts...)
+			if err != nil {
+				t.Fatalf("error generating delta: %v", err)
+			}
+			if stats.Size != int64(d.Len()) {
+				t.Errorf("delta of %d bytes reported as %d", d.Len(), stats.Size)
+			}
+			tt.expected.BlockSize, tt.expected.Size = stats.BlockSize, stats.Size
+			if *stats != tt.expected {
+				t.Errorf("stats %+v instead of %+v", *stats, tt.expected)
+			}
+			if limit := int64(stats.Data)*stats.BlockSize + 1024; stats.Size > limit {
+				t.Errorf("delta of %d bytes for %d blocks of data", stats.Size, stats.Data)
+			}
+			out := &buffer{}
+			if err := delta.Apply(bytes.NewReader(source), bytes.NewReader(d.Bytes()), out); err != nil {
+				t.Fatalf("error applying delta: %v", err)
+			}
+			if !bytes.Equal(out.b, target) {
+				t.Errorf("mismatched image from delta")
+			}
+		})
+	}
+}
+
+func TestApplyMismatch(t *testing.T) {
+	source := make([]byte, 64*1024)
+	_, _ = rand.Read(source)
+	target := bytes.Clone(source)
+	_, _ = rand.Read(target[8192:12288])
+	var d bytes.Buffer
+	if _, err := delta.Generate(bytes.NewReader(source), int64(len(source)), bytes.NewReader(target), int64(len(target)), &d); err != nil {
+		t.Fatalf("error generating delta: %v", err)
+	}
+
+	other := bytes.Clone(source)
+	other[0] ^= 0xff
+	out := &buffer{}
+	if err := delta.Apply(bytes.NewReader(other), bytes.NewReader(d.Bytes()), out); !errors.Is(err, delta.ErrSourceMismatch) {
+		t.Errorf("error %v instead of %v", err, delta.ErrSourceMismatch)
+	}
+	if len(out.b) != 0 {
+		t.Errorf("wrote %d bytes from the wrong source", len(out.b))
+	}
+
+	// corrupt the data of the changed block
+	corrupt := bytes.Clone(d.Bytes())
+	corrupt[len(corrupt)-100] ^= 0xff
+	if err := delta.Apply(bytes.NewReader(source), bytes.NewReader(corrupt), &buffer{}); !errors.Is(err, delta.ErrTargetMismatch) {
+		t.Errorf("error %v instead of %v", err, delta.ErrTargetMismatch)
+	}
+	if err := delta.Apply(bytes.NewReader(source), bytes.NewReader(d.Bytes()[:d.Len()-10]), &buffer{}); err == nil {
+		t.Errorf("no error applying truncated delta")
+	}
+	if err := delta.Apply(bytes.NewReader(source), bytes.NewReader([]byte("not a delta at all, but long enough for a header, and then some")), &buffer{}); err == nil {
+		t.Errorf("no error applying invalid delta")
+	}
+}
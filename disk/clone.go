@@ -0,0 +1,257 @@
+package disk
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// cloneChunkSize is the most copied by CloneTo with each read and write
+const cloneChunkSize = 1024 * 1024
+
+type cloneOpts struct {
+	progress        util.Progress
+	newIdentifiers  bool
+	wholePartitions bool
+}
+
+// CloneOpt func that process CloneTo options
+type CloneOpt func(o *cloneOpts) error
+
+// WithCloneProgress sets the Progress updated by CloneTo in util.PhaseClone, with the bytes copied
+// so far, out of all those to copy
+func WithCloneProgress(progress util.Progress) CloneOpt {
+	return func(o *cloneOpts) error {
+		o.progress = progress
+		return nil
+	}
+}
+
+// WithNewIdentifiers makes CloneTo give the partition table of the copy new random identifiers,
+// see partition.RandomizeIdentifiers, so that the disk and its copy can be attached to the same
+// system. The identifiers of the filesystems are left as they are.
+func WithNewIdentifiers() CloneOpt {
+	return func(o *cloneOpts) error {
+		o.newIdentifiers = true
+		return nil
+	}
+}
+
+// WithWholePartitions makes CloneTo copy all of every partition, like dd, rather than only the
+// blocks their filesystems use
+func WithWholePartitions() CloneOpt {
+	return func(o *cloneOpts) error {
+		o.wholePartitions = true
+		return nil
+	}
+}
+
+// CloneTo copies the disk to the dst backend, which must be at least as large, copying only what
+// is in use, like partclone: the partition table and whatever is outside the partitions, such as a
+// boot loader, in full, and of each partition whose filesystem knows its free blocks, see
+// filesystem.AllocatedFS, only the rest. Other partitions are copied in full. A disk without a
+// partition table is copied as a single filesystem.
+//
+// The regions not copied keep what dst held before, which the filesystems do not read, so a copy
+// to a new image or a zeroed device is as good as a full one, and far quicker.
+//
+// returns the number of bytes copied
+func (d *Disk) CloneTo(dst backend.Storage, opts ...CloneOpt) (int64, error) {
+	return d.CloneToContext(context.Background(), dst, opts...)
+}
+
+// CloneToContext copies the disk to the dst backend like CloneTo, stopping with the error of ctx
+// when it is done, between chunks
+func (d *Disk) CloneToContext(ctx context.Context, dst backend.Storage, opts ...CloneOpt) (int64, error) {
+	o := &cloneOpts{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return 0, err
+		}
+	}
+	if o.newIdentifiers && d.Table == nil {
+		return 0, fmt.Errorf("cannot give new identifiers to a disk without a partition table")
+	}
+	rw, err := dst.Writable()
+	if err != nil {
+		return 0, fmt.Errorf("destination is not writable: %w", err)
+	}
+	dstSize, err := storageSize(dst)
+	if err != nil {
+		return 0, fmt.Errorf("could not get size of destination: %w", err)
+	}
+	if dstSize < d.Size {
+		return 0, fmt.Errorf("destination of %d bytes is smaller than the disk of %d bytes", dstSize, d.Size)
+	}
+
+	ranges, err := d.allocatedRanges(o.wholePartitions)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+
+	buf := util.DefaultBufferPool.Get(cloneChunkSize)
+	defer util.DefaultBufferPool.Put(buf)
+	var copied int64
+	for _, r := range ranges {
+		for offset := r.Offset; offset < r.End(); {
+			if err := ctx.Err(); err != nil {
+				return copied, err
+			}
+			b := buf[:min(int64(len(buf)), r.End()-offset)]
+			n, err := d.Backend.ReadAt(b, offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return copied, fmt.Errorf("error reading disk at %d: %w", offset, err)
+			}
+			if n != len(b) {
+				return copied, fmt.Errorf("read %d bytes instead of %d at %d", n, len(b), offset)
+			}
+			if _, err := rw.WriteAt(b, offset); err != nil {
+				return copied, fmt.Errorf("error writing destination at %d: %w", offset, err)
+			}
+			offset += int64(n)
+			copied += int64(n)
+			if o.progress != nil {
+				o.progress.Update(util.PhaseClone, copied, total, "")
+			}
+		}
+	}
+
+	if o.newIdentifiers {
+		table, err := partition.Read(rw, int(d.LogicalBlocksize), int(d.PhysicalBlocksize))
+		if err != nil {
+			return copied, fmt.Errorf("could not read partition table of the copy: %w", err)
+		}
+		if err := partition.RandomizeIdentifiers(table); err != nil {
+			return copied, err
+		}
+		if err := table.Write(rw, d.Size); err != nil {
+			return copied, fmt.Errorf("could not write partition table of the copy: %w", err)
+		}
+	}
+	return copied, nil
+}
+
+// allocatedRanges returns the ranges of the disk to copy to clone it, in order and merged
+func (d *Disk) allocatedRanges(wholePartitions bool) ([]filesystem.Range, error) {
+	type region struct {
+		part        int
+		start, size int64
+	}
+	var regions []region
+	if d.Table == nil {
+		regions = append(regions, region{start: 0, size: d.Size})
+	} else {
+		for i, p := range d.Table.GetPartitions() {
+			start, size := p.GetStart(), p.GetSize()
+			// partitions past the end of the disk have nothing to copy
+			size = min(size, d.Size-start)
+			if size <= 0 || start < 0 {
+				continue
+			}
+			regions = append(regions, region{part: i + 1, start: start, size: size})
+		}
+	}
+	slices.SortFunc(regions, func(a, b region) int {
+		return cmp.Compare(a.start, b.start)
+	})
+
+	var ranges []filesystem.Range
+	// what is outside the partitions is copied in full
+	var cursor int64
+	for _, r := range regions {
+		if r.start > cursor {
+			ranges = append(ranges, filesystem.Range{Offset: cursor, Length: r.start - cursor})
+		}
+		cursor = max(cursor, r.start+r.size)
+	}
+	if cursor < d.Size {
+		ranges = append(ranges, filesystem.Range{Offset: cursor, Length: d.Size - cursor})
+	}
+
+	for _, r := range regions {
+		used, err := d.regionRanges(r.part, r.start, r.size, wholePartitions)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, used...)
+	}
+
+	slices.SortFunc(ranges, func(a, b filesystem.Range) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	var merged []filesystem.Range
+	for _, r := range ranges {
+		merged = filesystem.AppendRange(merged, r.Offset, r.Length)
+	}
+	return merged, nil
+}
+
+// regionRanges returns the ranges of the disk to copy of the partition, or the whole disk for 0,
+// of size bytes from start
+func (d *Disk) regionRanges(part int, start, size int64, wholePartitions bool) ([]filesystem.Range, error) {
+	whole := []filesystem.Range{{Offset: start, Length: size}}
+	if wholePartitions {
+		return whole, nil
+	}
+	fs, err := d.GetFilesystem(part)
+	if err != nil {
+		// not a filesystem that can be read, so all of it may be in use
+		return whole, nil
+	}
+	afs, ok := fs.(filesystem.AllocatedFS)
+	if !ok {
+		return whole, nil
+	}
+	used, err := afs.AllocatedRanges()
+	if errors.Is(err, filesystem.ErrNotSupported) {
+		return whole, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find the blocks in use of partition %d: %w", part, err)
+	}
+	ranges := make([]filesystem.Range, 0, len(used))
+	for _, r := range used {
+		end := min(r.End(), size)
+		if r.Offset < 0 || r.Offset >= end {
+			continue
+		}
+		ranges = append(ranges, filesystem.Range{Offset: start + r.Offset, Length: end - r.Offset})
+	}
+	return ranges, nil
+}
+
+// storageSize returns the size of the disk in the backend, which image backends report in Stat,
+// and block devices only as the end of the device
+func storageSize(b backend.Storage) (int64, error) {
+	info, err := b.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if size := info.Size(); size > 0 {
+		return size, nil
+	}
+	current, err := b.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := b.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := b.Seek(current, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
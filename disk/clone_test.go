@@ -0,0 +1,153 @@
+package disk_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestCloneTo(t *testing.T) {
+	size := int64(64 * 1024 * 1024)
+	// the free blocks hold garbage, which a clone need not copy
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	d := &disk.Disk{
+		Backend:           mem.New(b, false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	table := &gpt.Table{
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 32767, Type: gpt.EFISystemPartition},
+			{Start: 2048 + 32768, End: 2048 + 32768 + 16383, Type: gpt.LinuxFilesystem},
+		},
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	contents := make([]byte, 100*1024)
+	_, _ = rand.Read(contents)
+	fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/file", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(contents); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("error closing file: %v", err)
+	}
+	// the second partition has no filesystem, so all of it is copied
+	raw := make([]byte, table.Partitions[1].GetSize())
+	_, _ = rand.Read(raw)
+	if _, err := d.WritePartitionContents(2, bytes.NewReader(raw)); err != nil {
+		t.Fatalf("error writing partition 2: %v", err)
+	}
+
+	clone := func(t *testing.T, opts ...disk.CloneOpt) (*disk.Disk, int64) {
+		t.Helper()
+		dst := &disk.Disk{
+			Backend:           mem.New(make([]byte, size), false),
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Size:              size,
+		}
+		n, err := d.CloneTo(dst.Backend, opts...)
+		if err != nil {
+			t.Fatalf("error cloning disk: %v", err)
+		}
+		if _, err := dst.GetPartitionTable(); err != nil {
+			t.Fatalf("error reading partition table of clone: %v", err)
+		}
+		fs, err := dst.GetFilesystem(1)
+		if err != nil {
+			t.Fatalf("error reading filesystem of clone: %v", err)
+		}
+		b, err := fs.ReadFile("file")
+		if err != nil {
+			t.Fatalf("error reading file of clone: %v", err)
+		}
+		if !bytes.Equal(b, contents) {
+			t.Errorf("mismatched contents of file of clone")
+		}
+		var out bytes.Buffer
+		if _, err := dst.ReadPartitionContents(2, &out); err != nil {
+			t.Fatalf("error reading partition 2 of clone: %v", err)
+		}
+		if !bytes.Equal(out.Bytes(), raw) {
+			t.Errorf("mismatched contents of partition 2 of clone")
+		}
+		return dst, n
+	}
+
+	t.Run("allocated", func(t *testing.T) {
+		var done, total int64
+		dst, n := clone(t, disk.WithCloneProgress(util.ProgressFunc(func(phase string, d, tot int64, _ string) {
+			if phase != util.PhaseClone {
+				t.Errorf("progress in phase %q instead of %q", phase, util.PhaseClone)
+			}
+			done, total = d, tot
+		})))
+		// the free clusters of the first partition, of 16 MB, are not copied
+		if n >= size-8*1024*1024 {
+			t.Errorf("copied %d bytes of a disk of %d bytes", n, size)
+		}
+		if done != n || total != n {
+			t.Errorf("progress at %d of %d instead of %d", done, total, n)
+		}
+		if !strings.EqualFold(dst.Table.UUID(), d.Table.UUID()) {
+			t.Errorf("clone has disk GUID %s instead of %s", dst.Table.UUID(), d.Table.UUID())
+		}
+	})
+	t.Run("whole partitions", func(t *testing.T) {
+		_, n := clone(t, disk.WithWholePartitions())
+		if n != size {
+			t.Errorf("copied %d bytes instead of %d", n, size)
+		}
+	})
+	t.Run("new identifiers", func(t *testing.T) {
+		dst, _ := clone(t, disk.WithNewIdentifiers())
+		if strings.EqualFold(dst.Table.UUID(), d.Table.UUID()) {
+			t.Errorf("clone has the same disk GUID %s", d.Table.UUID())
+		}
+		src, copied := d.Table.GetPartitions(), dst.Table.GetPartitions()
+		for i := range src {
+			if strings.EqualFold(src[i].UUID(), copied[i].UUID()) {
+				t.Errorf("partition %d of clone has the same GUID %s", i+1, src[i].UUID())
+			}
+		}
+		if err := dst.Table.Verify(dst.Backend, uint64(size)); err != nil {
+			t.Errorf("invalid partition table of clone: %v", err)
+		}
+	})
+	t.Run("small destination", func(t *testing.T) {
+		if _, err := d.CloneTo(mem.New(make([]byte, size/2), false)); err == nil {
+			t.Errorf("no error cloning to a smaller destination")
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := d.CloneToContext(ctx, mem.New(make([]byte, size), false)); !errors.Is(err, context.Canceled) {
+			t.Errorf("error %v instead of %v", err, context.Canceled)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package disk
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is the compression applied to a disk image written with WriteImage
+type Compression int
+
+const (
+	// CompressionNone writes the raw image
+	CompressionNone Compression = iota
+	// CompressionGzip writes a .gz image
+	CompressionGzip
+	// CompressionXz writes a .xz image
+	CompressionXz
+	// CompressionZstd writes a .zst image
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionXz:
+		return "xz"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(c))
+	}
+}
+
+// Extension is the file name extension for images with the compression, e.g. ".zst"
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionXz:
+		return ".xz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type imageOpts struct {
+	progress util.Progress
+}
+
+// ImageOpt func that process WriteImage options
+type ImageOpt func(o *imageOpts) error
+
+// WithImageProgress sets the Progress updated by WriteImage in util.PhaseImage, with the bytes of
+// the disk read so far, out of its size
+func WithImageProgress(progress util.Progress) ImageOpt {
+	return func(o *imageOpts) error {
+		o.progress = progress
+		return nil
+	}
+}
+
+// progressReader reports the bytes read through it to a Progress
+type progressReader struct {
+	r        io.Reader
+	progress util.Progress
+	done     int64
+	total    int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress.Update(util.PhaseImage, p.done, p.total, "")
+	}
+	return n, err
+}
+
+// WriteImage streams the whole disk image to w with the given compression, without creating an
+// uncompressed copy, e.g. to produce CI artifacts or OTA payloads. The disk is read through its backend,
+// so the image written is always the raw disk, whatever the format of the backend.
+//
+// returns the number of bytes written to w
+func (d *Disk) WriteImage(w io.Writer, compression Compression, opts ...ImageOpt) (int64, error) {
+	return d.WriteImageContext(context.Background(), w, compression, opts...)
+}
+
+// WriteImageContext streams the whole disk image to w like WriteImage, stopping with the error of
+// ctx when it is done
+func (d *Disk) WriteImageContext(ctx context.Context, w io.Writer, compression Compression, opts ...ImageOpt) (int64, error) {
+	o := &imageOpts{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return 0, err
+		}
+	}
+	out := &countingWriter{w: w}
+	var (
+		cw  io.WriteCloser
+		err error
+	)
+	switch compression {
+	case CompressionNone:
+	case CompressionGzip:
+		cw = gzip.NewWriter(out)
+	case CompressionXz:
+		cw, err = xz.NewWriter(out)
+	case CompressionZstd:
+		cw, err = zstd.NewWriter(out)
+	default:
+		return 0, fmt.Errorf("unsupported compression %v", compression)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not create %v compressor: %w", compression, err)
+	}
+
+	var src io.Reader = io.NewSectionReader(d.Backend, 0, d.Size)
+	if o.progress != nil {
+		src = &progressReader{r: src, progress: o.progress, total: d.Size}
+	}
+	src = util.ContextReader(ctx, src)
+	if cw == nil {
+		if _, err := io.Copy(out, src); err != nil {
+			return out.n, fmt.Errorf("error writing image: %w", err)
+		}
+		return out.n, nil
+	}
+	if _, err := io.Copy(cw, src); err != nil {
+		cw.Close()
+		return out.n, fmt.Errorf("error writing %v compressed image: %w", compression, err)
+	}
+	if err := cw.Close(); err != nil {
+		return out.n, fmt.Errorf("error completing %v compressed image: %w", compression, err)
+	}
+	return out.n, nil
+}
@@ -0,0 +1,115 @@
+package disk_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestWriteImage(t *testing.T) {
+	f, err := tmpDisk("")
+	if err != nil {
+		t.Fatalf("error creating new temporary disk: %v", err)
+	}
+	defer f.Close()
+	size := int64(10 * 1024 * 1024)
+	expected := make([]byte, size)
+	_, _ = rand.Read(expected[1024*1024 : 1024*1024+64*1024])
+	if _, err := f.WriteAt(expected[1024*1024:1024*1024+64*1024], 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	d := &disk.Disk{
+		Backend:           file.New(f, true),
+		Size:              size,
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+	}
+
+	tests := []struct {
+		compression disk.Compression
+		decompress  func(r io.Reader) (io.Reader, error)
+	}{
+		{disk.CompressionNone, func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{disk.CompressionGzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{disk.CompressionXz, func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) }},
+		{disk.CompressionZstd, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.compression.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := d.WriteImage(&buf, tt.compression)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("reported %d bytes written instead of %d", n, buf.Len())
+			}
+			if tt.compression != disk.CompressionNone && n >= size/2 {
+				t.Errorf("compressed image is %d bytes for %d bytes of data", n, 64*1024)
+			}
+			r, err := tt.decompress(&buf)
+			if err != nil {
+				t.Fatalf("error decompressing: %v", err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("error decompressing: %v", err)
+			}
+			if !bytes.Equal(b, expected) {
+				t.Errorf("mismatched image contents")
+			}
+		})
+	}
+
+	if _, err := d.WriteImage(io.Discard, disk.Compression(100)); err == nil {
+		t.Errorf("expected error for unknown compression")
+	}
+}
+
+func TestWriteImageCanceled(t *testing.T) {
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, 1024*1024), true),
+		Size:              1024 * 1024,
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.WriteImageContext(ctx, io.Discard, disk.CompressionGzip); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of %v", err, context.Canceled)
+	}
+}
+
+func TestWriteImageProgress(t *testing.T) {
+	const size = 1024 * 1024
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), true),
+		Size:              size,
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+	}
+	var done, total int64
+	progress := func(phase string, d, n int64, _ string) {
+		if phase != util.PhaseImage || d < done {
+			t.Errorf("progress %s %d/%d after %d", phase, d, n, done)
+		}
+		done, total = d, n
+	}
+	if _, err := d.WriteImage(io.Discard, disk.CompressionZstd, disk.WithImageProgress(util.ProgressFunc(progress))); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	if done != size || total != size {
+		t.Errorf("progress reported %d/%d bytes instead of %d", done, total, size)
+	}
+}
@@ -5,9 +5,11 @@
 package disk
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -16,9 +18,21 @@ import (
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
 	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/diskfs/go-diskfs/probe"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrReadOnlyDisk is returned by all operations that would change a disk that was opened read-only
+	ErrReadOnlyDisk = errors.New("read-only disk")
+	// ErrPartitionNotFound is returned when no partition or filesystem matches what is looked for
+	ErrPartitionNotFound = errors.New("partition not found")
+)
+
 // Disk is a reference to a single disk block device or image that has been Create() or Open()
 type Disk struct {
 	Backend           backend.Storage
@@ -60,7 +74,7 @@ func (d *Disk) GetPartitionTable() (partition.Table, error) {
 //
 // Actual writing of the table is delegated to the individual implementation
 func (d *Disk) Partition(table partition.Table) error {
-	rwBackingFile, err := d.Backend.Writable()
+	rwBackingFile, err := d.writable()
 	if err != nil {
 		return err
 	}
@@ -71,6 +85,9 @@ func (d *Disk) Partition(table partition.Table) error {
 		return fmt.Errorf("failed to write partition table: %v", err)
 	}
 	d.Table = table
+	if err := d.Sync(); err != nil {
+		return err
+	}
 
 	return d.ReReadPartitionTable()
 }
@@ -82,7 +99,13 @@ func (d *Disk) Partition(table partition.Table) error {
 // returns an error if there was an error writing to the disk, reading from the reader, the table
 // is invalid, or the partition is invalid
 func (d *Disk) WritePartitionContents(part int, reader io.Reader) (int64, error) {
-	backingRwFile, err := d.Backend.Writable()
+	return d.WritePartitionContentsContext(context.Background(), part, reader)
+}
+
+// WritePartitionContentsContext writes the contents of an io.Reader to a given partition like
+// WritePartitionContents, stopping with the error of ctx when it is done
+func (d *Disk) WritePartitionContentsContext(ctx context.Context, part int, reader io.Reader) (int64, error) {
+	backingRwFile, err := d.writable()
 
 	if err != nil {
 		return -1, err
@@ -98,7 +121,7 @@ func (d *Disk) WritePartitionContents(part int, reader io.Reader) (int64, error)
 	if part > len(partitions) {
 		return -1, fmt.Errorf("cannot write contents of partition %d which is greater than max partition %d", part, len(partitions))
 	}
-	written, err := partitions[part-1].WriteContents(backingRwFile, reader)
+	written, err := partitions[part-1].WriteContents(backingRwFile, util.ContextReader(ctx, reader))
 	return int64(written), err
 }
 
@@ -109,6 +132,12 @@ func (d *Disk) WritePartitionContents(part int, reader io.Reader) (int64, error)
 // returns an error if there was an error reading from the disk, writing to the writer, the table
 // is invalid, or the partition is invalid
 func (d *Disk) ReadPartitionContents(part int, writer io.Writer) (int64, error) {
+	return d.ReadPartitionContentsContext(context.Background(), part, writer)
+}
+
+// ReadPartitionContentsContext reads the contents of a partition to an io.Writer like
+// ReadPartitionContents, stopping with the error of ctx when it is done
+func (d *Disk) ReadPartitionContentsContext(ctx context.Context, part int, writer io.Writer) (int64, error) {
 	if d.Table == nil {
 		return -1, fmt.Errorf("cannot read contents of a partition on a disk without a partition table")
 	}
@@ -120,17 +149,45 @@ func (d *Disk) ReadPartitionContents(part int, writer io.Writer) (int64, error)
 	if part > len(partitions) {
 		return -1, fmt.Errorf("cannot read contents of partition %d which is greater than max partition %d", part, len(partitions))
 	}
-	return partitions[part-1].ReadContents(d.Backend, writer)
+	p := partitions[part-1]
+	n, err := io.Copy(util.ContextWriter(ctx, writer), io.NewSectionReader(d.Backend, p.GetStart(), p.GetSize()))
+	if err != nil {
+		return n, fmt.Errorf("error reading contents of partition %d: %w", part, err)
+	}
+	return n, nil
 }
 
-// FilesystemSpec represents the specification of a filesystem to be created
-type FilesystemSpec struct {
-	Partition   int
-	FSType      filesystem.Type
+// FormatSpec represents the specification of a filesystem to be created by CreateFilesystem. Only
+// Partition and FSType are required; options that are zero are left to the defaults of the
+// filesystem type, and setting one that the type does not have is an error.
+type FormatSpec struct {
+	// Partition is the partition number, or 0 for the entire disk
+	Partition int
+	// FSType is the type of the filesystem
+	FSType filesystem.Type
+	// VolumeLabel is the label of FAT32 and ext4 filesystems; under Linux this shows in
+	// '/dev/disk/by-label/<label>'
 	VolumeLabel string
-	WorkDir     string
+	// WorkDir is the workspace of ISO9660 filesystems, a temporary directory if empty
+	WorkDir string
+	// UUID identifies the filesystem, in the form blkid and Probe report it: a UUID for ext4, or
+	// the volume serial number such as "1234-ABCD" for FAT32
+	UUID string
+	// BlockSize is the size in bytes of the allocation units: the clusters of FAT32 or the blocks
+	// of ext4
+	BlockSize int64
+	// ReservedPercent is the percentage of the blocks of ext4 filesystems reserved for root
+	ReservedPercent uint8
+	// Ext4 has the other options of ext4 filesystems, such as their features; those above take
+	// precedence over its fields
+	Ext4 *ext4.Params
 }
 
+// FilesystemSpec represents the specification of a filesystem to be created
+//
+// Deprecated: use FormatSpec, of which it is an alias.
+type FilesystemSpec = FormatSpec
+
 // CreateFilesystem creates a filesystem on a disk image, the equivalent of mkfs.
 //
 // Required:
@@ -141,12 +198,16 @@ type FilesystemSpec struct {
 // Optional:
 //   - volume label for those filesystems that support it; under Linux this shows
 //     in '/dev/disks/by-label/<label>'
+//   - the UUID, block size and other options of FormatSpec for the filesystem type
 //
 // if successful, returns a filesystem-implementing structure for the given filesystem type
 //
 // returns error if there was an error creating the filesystem, or the partition table is invalid and did not
 // request the entire disk.
-func (d *Disk) CreateFilesystem(spec FilesystemSpec) (filesystem.FileSystem, error) {
+func (d *Disk) CreateFilesystem(spec FormatSpec) (filesystem.FileSystem, error) {
+	if _, err := d.writable(); err != nil {
+		return nil, err
+	}
 	// find out where the partition starts and ends, or if it is the entire disk
 	var (
 		size, start int64
@@ -171,18 +232,97 @@ func (d *Disk) CreateFilesystem(spec FilesystemSpec) (filesystem.FileSystem, err
 
 	switch spec.FSType {
 	case filesystem.TypeFat32:
-		return fat32.Create(d.Backend, size, start, d.LogicalBlocksize, spec.VolumeLabel)
+		p, err := spec.fat32Params()
+		if err != nil {
+			return nil, err
+		}
+		return fat32.CreateWithParams(d.Backend, size, start, d.LogicalBlocksize, p)
 	case filesystem.TypeISO9660:
+		if err := spec.noOptions(); err != nil {
+			return nil, err
+		}
 		return iso9660.Create(d.Backend, size, start, d.LogicalBlocksize, spec.WorkDir)
 	case filesystem.TypeExt4:
-		return ext4.Create(d.Backend, size, start, d.LogicalBlocksize, nil)
+		p, err := spec.ext4Params()
+		if err != nil {
+			return nil, err
+		}
+		return ext4.Create(d.Backend, size, start, d.LogicalBlocksize, p)
 	case filesystem.TypeSquashfs:
+		if err := spec.noOptions(); err != nil {
+			return nil, err
+		}
 		return squashfs.Create(d.Backend, size, start, d.LogicalBlocksize)
 	default:
 		return nil, errors.New("unknown filesystem type requested")
 	}
 }
 
+// noOptions returns an error if the spec has any of the options of FAT32 and ext4 filesystems, for
+// the other types
+func (spec FormatSpec) noOptions() error {
+	if spec.UUID != "" || spec.BlockSize != 0 || spec.ReservedPercent != 0 || spec.Ext4 != nil {
+		return fmt.Errorf("filesystem type %v has no UUID, block size, reserved space or ext4 options", spec.FSType)
+	}
+	return nil
+}
+
+// fat32Params returns the parameters of fat32.CreateWithParams for the spec
+func (spec FormatSpec) fat32Params() (*fat32.Params, error) {
+	if spec.ReservedPercent != 0 || spec.Ext4 != nil {
+		return nil, fmt.Errorf("FAT32 filesystems have no reserved space or ext4 options")
+	}
+	p := &fat32.Params{VolumeLabel: spec.VolumeLabel, ClusterSize: spec.BlockSize}
+	if spec.UUID != "" {
+		var high, low uint16
+		if n, err := fmt.Sscanf(spec.UUID, "%04X-%04X", &high, &low); err != nil || n != 2 || len(spec.UUID) != 9 {
+			return nil, fmt.Errorf("invalid FAT32 volume serial number %q, must be of the form 1234-ABCD", spec.UUID)
+		}
+		volid := uint32(high)<<16 | uint32(low)
+		p.VolumeID = &volid
+	}
+	return p, nil
+}
+
+// ext4Params returns the parameters of ext4.Create for the spec, a copy of its Ext4 with the other
+// options
+func (spec FormatSpec) ext4Params() (*ext4.Params, error) {
+	p := &ext4.Params{}
+	if spec.Ext4 != nil {
+		*p = *spec.Ext4
+	}
+	if spec.VolumeLabel != "" {
+		p.VolumeName = spec.VolumeLabel
+	}
+	if spec.UUID != "" {
+		u, err := uuid.Parse(spec.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ext4 UUID %q: %w", spec.UUID, err)
+		}
+		p.UUID = &u
+	}
+	if spec.BlockSize != 0 {
+		if spec.BlockSize%int64(ext4.SectorSize512) != 0 {
+			return nil, fmt.Errorf("block size for ext4 must be a multiple of %d bytes, not %d", ext4.SectorSize512, spec.BlockSize)
+		}
+		p.SectorsPerBlock = uint8(min(spec.BlockSize/int64(ext4.SectorSize512), 255))
+	}
+	if spec.ReservedPercent != 0 {
+		p.ReservedBlocksPercent = spec.ReservedPercent
+	}
+	return p, nil
+}
+
+// writable returns the backend for writing, or an error that is ErrReadOnlyDisk if the disk was
+// opened read-only
+func (d *Disk) writable() (backend.WritableFile, error) {
+	w, err := d.Backend.Writable()
+	if errors.Is(err, backend.ErrIncorrectOpenMode) {
+		return nil, fmt.Errorf("%w: %w", ErrReadOnlyDisk, err)
+	}
+	return w, err
+}
+
 // GetFilesystem gets the filesystem that already exists on a disk image
 //
 // pass the desired partition number, or 0 to create the filesystem on the entire block device / disk image,
@@ -193,10 +333,7 @@ func (d *Disk) CreateFilesystem(spec FilesystemSpec) (filesystem.FileSystem, err
 // request the entire disk.
 func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 	// find out where the partition starts and ends, or if it is the entire disk
-	var (
-		size, start int64
-		err         error
-	)
+	var size, start int64
 
 	switch {
 	case part == 0:
@@ -214,6 +351,31 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		start = partitions[part-1].GetStart()
 	}
 
+	fs, err := d.readFilesystem(start, size)
+	if err != nil {
+		return nil, fmt.Errorf("unknown filesystem on partition %d", part)
+	}
+	return fs, nil
+}
+
+// GetFilesystemAt gets the filesystem that already exists at the byte offset start of the disk, of
+// size bytes, without using the partition table, for filesystems that are not in a partition, e.g.
+// the FAT region of a firmware image. The type of the filesystem is detected as by GetFilesystem.
+//
+// returns an error if the range is not within the disk, or there is no filesystem there that can be read
+func (d *Disk) GetFilesystemAt(start, size int64) (filesystem.FileSystem, error) {
+	if start < 0 || size <= 0 || start > d.Size-size {
+		return nil, fmt.Errorf("cannot read filesystem of %d bytes at offset %d of disk of %d bytes", size, start, d.Size)
+	}
+	fs, err := d.readFilesystem(start, size)
+	if err != nil {
+		return nil, fmt.Errorf("unknown filesystem at offset %d", start)
+	}
+	return fs, nil
+}
+
+// readFilesystem reads the filesystem of size bytes at start, trying each type
+func (d *Disk) readFilesystem(start, size int64) (filesystem.FileSystem, error) {
 	// just try each type
 	log.Debug("trying fat32")
 	fat32FS, err := fat32.Read(d.Backend, size, start, d.LogicalBlocksize)
@@ -241,7 +403,125 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		return ext4FS, nil
 	}
 	log.Debugf("ext4 failed: %v", err)
-	return nil, fmt.Errorf("unknown filesystem on partition %d", part)
+	return nil, err
+}
+
+// Probe identifies the contents of a partition from their signatures, like blkid, with their type,
+// label and UUID, including those that GetFilesystem cannot read.
+//
+// pass the desired partition number, or 0 for the entire block device / disk image
+//
+// returns an error that is probe.ErrUnknown if no known signature is found
+func (d *Disk) Probe(part int) (*probe.Result, error) {
+	var size, start int64
+	switch {
+	case part == 0:
+		size = d.Size
+		start = 0
+	case d.Table == nil:
+		return nil, fmt.Errorf("cannot probe partition without a partition table")
+	default:
+		partitions := d.Table.GetPartitions()
+		// API indexes from 1, but slice from 0
+		if part > len(partitions) {
+			return nil, fmt.Errorf("cannot probe partition %d greater than maximum partition %d", part, len(partitions))
+		}
+		size = partitions[part-1].GetSize()
+		start = partitions[part-1].GetStart()
+	}
+	r, err := probe.Probe(d.Backend, start, size)
+	if err != nil {
+		return nil, fmt.Errorf("error probing partition %d: %w", part, err)
+	}
+	return r, nil
+}
+
+// GetFilesystemByLabel gets the filesystem with the label, in the first partition whose contents
+// have it, or the entire disk if it has no partition table. The label is that reported by Probe,
+// the same as blkid, e.g. "ESP".
+//
+// returns an error that is ErrPartitionNotFound if no filesystem has the label
+func (d *Disk) GetFilesystemByLabel(label string) (filesystem.FileSystem, error) {
+	return d.getFilesystemBy(func(r *probe.Result) bool {
+		return r.Label == label
+	}, fmt.Sprintf("label %q", label))
+}
+
+// GetFilesystemByUUID gets the filesystem with the UUID, in the first partition whose contents
+// have it, or the entire disk if it has no partition table. The UUID is that reported by Probe,
+// the same as blkid, and is compared without case.
+//
+// returns an error that is ErrPartitionNotFound if no filesystem has the UUID
+func (d *Disk) GetFilesystemByUUID(uuid string) (filesystem.FileSystem, error) {
+	return d.getFilesystemBy(func(r *probe.Result) bool {
+		return r.UUID != "" && strings.EqualFold(r.UUID, uuid)
+	}, fmt.Sprintf("UUID %s", uuid))
+}
+
+// getFilesystemBy gets the filesystem of the first partition whose probed contents match
+func (d *Disk) getFilesystemBy(match func(r *probe.Result) bool, what string) (filesystem.FileSystem, error) {
+	parts := []int{0}
+	if d.Table != nil {
+		parts = parts[:0]
+		for i := range d.Table.GetPartitions() {
+			parts = append(parts, i+1)
+		}
+	}
+	for _, n := range parts {
+		r, err := d.Probe(n)
+		if errors.Is(err, probe.ErrUnknown) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if match(r) {
+			return d.GetFilesystem(n)
+		}
+	}
+	return nil, fmt.Errorf("no filesystem with %s: %w", what, ErrPartitionNotFound)
+}
+
+// GetPartitionByGUID gets the partition with the unique GUID of GPT partition tables, or the UUID
+// of MBR ones, compared without case, and its partition number.
+//
+// returns an error that is ErrPartitionNotFound if no partition has the GUID
+func (d *Disk) GetPartitionByGUID(guid string) (int, part.Partition, error) {
+	if d.Table == nil {
+		return 0, nil, fmt.Errorf("cannot find partition without a partition table")
+	}
+	for i, p := range d.Table.GetPartitions() {
+		if strings.EqualFold(p.UUID(), guid) {
+			return i + 1, p, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("no partition with GUID %s: %w", guid, ErrPartitionNotFound)
+}
+
+// GetPartitionByTypeGUID gets the first partition of the type on a GPT partition table, e.g.
+// gpt.EFISystemPartition, compared without case, and its partition number.
+//
+// returns an error that is ErrPartitionNotFound if no partition has the type
+func (d *Disk) GetPartitionByTypeGUID(typeGUID gpt.Type) (int, *gpt.Partition, error) {
+	table, ok := d.Table.(*gpt.Table)
+	if !ok {
+		return 0, nil, fmt.Errorf("cannot find partition by type without a GPT partition table")
+	}
+	for i, p := range table.Partitions {
+		if strings.EqualFold(string(p.Type), string(typeGUID)) {
+			return i + 1, p, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("no partition of type %s: %w", typeGUID, ErrPartitionNotFound)
+}
+
+// Sync makes the writes to the disk so far durable, committing them from any buffer or cache of
+// its backend. Filesystems on the disk have their own Sync, which syncs the disk as well.
+func (d *Disk) Sync() error {
+	if err := backend.Sync(d.Backend); err != nil {
+		return fmt.Errorf("error syncing disk: %w", err)
+	}
+	return nil
 }
 
 // Close the disk. Once successfully closed, it can no longer be used.
@@ -0,0 +1,35 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/ext4"
+	"github.com/google/uuid"
+)
+
+func TestFormatSpecExt4Params(t *testing.T) {
+	u := uuid.MustParse("5ca3360b-5de6-4fcf-b4ce-419cee433b51")
+	ext4Params := &ext4.Params{VolumeName: "other", InodeCount: 1000, Checksum: true}
+	spec := FormatSpec{
+		FSType:          filesystem.TypeExt4,
+		VolumeLabel:     "root",
+		UUID:            u.String(),
+		BlockSize:       4096,
+		ReservedPercent: 1,
+		Ext4:            ext4Params,
+	}
+	p, err := spec.ext4Params()
+	if err != nil {
+		t.Fatalf("error getting parameters: %v", err)
+	}
+	if p.VolumeName != "root" || p.UUID == nil || *p.UUID != u || p.SectorsPerBlock != 8 || p.ReservedBlocksPercent != 1 {
+		t.Errorf("parameters %+v do not have the options of the spec", p)
+	}
+	if p.InodeCount != 1000 || !p.Checksum {
+		t.Errorf("parameters %+v do not have those of Ext4", p)
+	}
+	if ext4Params.VolumeName != "other" {
+		t.Errorf("Ext4 of the spec changed to %+v", ext4Params)
+	}
+}
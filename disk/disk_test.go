@@ -8,6 +8,7 @@ package disk_test
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -15,7 +16,9 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/diskfs/go-diskfs/backend/fault"
 	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/partition"
@@ -170,9 +173,9 @@ func TestPartition(t *testing.T) {
 		d := &disk.Disk{
 			Backend: file.New(&testhelper.FileImpl{}, true),
 		}
-		expectedErr := fmt.Errorf("disk file or device not open for write")
+		expectedErr := disk.ErrReadOnlyDisk
 		err := d.Partition(&mbr.Table{})
-		if err.Error() != expectedErr.Error() {
+		if !errors.Is(err, expectedErr) {
 			t.Errorf("Mismatched error, actual '%v', expected '%v'", err, expectedErr)
 		}
 	})
@@ -243,9 +246,9 @@ func TestWritePartitionContents(t *testing.T) {
 		d := &disk.Disk{
 			Backend: file.New(&testhelper.FileImpl{}, true),
 		}
-		expectedErr := fmt.Errorf("disk file or device not open for write")
+		expectedErr := disk.ErrReadOnlyDisk
 		_, err := d.WritePartitionContents(0, nil)
-		if err.Error() != expectedErr.Error() {
+		if !errors.Is(err, expectedErr) {
 			t.Errorf("mismatched error, actual '%v' expected '%v'", err, expectedErr)
 		}
 	})
@@ -409,7 +412,7 @@ func TestCreateFilesystem(t *testing.T) {
 			PhysicalBlocksize: 512,
 		}
 		expected := fmt.Errorf("cannot create filesystem on a partition without a partition table")
-		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+		fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
 		if err == nil || err.Error() != expected.Error() {
 			t.Errorf("Mismatched error: actual %v expected %v", err, expected)
 		}
@@ -441,7 +444,7 @@ func TestCreateFilesystem(t *testing.T) {
 			PhysicalBlocksize: 512,
 			Size:              fileInfo.Size(),
 		}
-		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeFat32})
+		fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 0, FSType: filesystem.TypeFat32})
 		if err != nil {
 			t.Errorf("error unexpectedly not nil:  %v", err)
 		}
@@ -482,7 +485,7 @@ func TestCreateFilesystem(t *testing.T) {
 			Size:              fileInfo.Size(),
 			Table:             table,
 		}
-		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+		fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
 		if err != nil {
 			t.Errorf("error unexpectedly not nil:  %v", err)
 		}
@@ -494,9 +497,9 @@ func TestCreateFilesystem(t *testing.T) {
 		d := &disk.Disk{
 			Backend: file.New(&testhelper.FileImpl{}, true),
 		}
-		expectedErr := fmt.Errorf("disk file or device not open for write")
-		_, err := d.CreateFilesystem(disk.FilesystemSpec{})
-		if err.Error() != expectedErr.Error() {
+		expectedErr := disk.ErrReadOnlyDisk
+		_, err := d.CreateFilesystem(disk.FormatSpec{})
+		if !errors.Is(err, expectedErr) {
 			t.Errorf("Mismatched error, actual '%v', expected '%v'", err, expectedErr)
 		}
 	})
@@ -616,3 +619,163 @@ func TestGetFilesystem(t *testing.T) {
 		}
 	})
 }
+
+func TestSync(t *testing.T) {
+	s := fault.New(mem.New(make([]byte, 20*1024*1024), false))
+	d := &disk.Disk{
+		Backend:           s,
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              20 * 1024 * 1024,
+	}
+	table := &mbr.Table{
+		Partitions: []*mbr.Partition{
+			{Start: 2048, Size: 38000, Type: mbr.Fat32LBA},
+		},
+		LogicalSectorSize: 512,
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	if s.Pending() != 0 {
+		t.Errorf("partition table not synced")
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/synced.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("synced")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("error syncing filesystem: %v", err)
+	}
+	if _, err := fs.OpenFile("/lost.txt", os.O_CREATE|os.O_RDWR); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	s.Crash()
+
+	fs, err = d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading filesystem after crash: %v", err)
+	}
+	if _, err := fs.OpenFile("/synced.txt", os.O_RDONLY); err != nil {
+		t.Errorf("synced file lost in crash: %v", err)
+	}
+	if _, err := fs.OpenFile("/lost.txt", os.O_RDONLY); err == nil {
+		t.Errorf("file created after sync is there after crash")
+	}
+	if err := d.Sync(); err != nil {
+		t.Errorf("error syncing disk: %v", err)
+	}
+}
+
+func TestGetByLabelAndGUID(t *testing.T) {
+	size := int64(40 * 1024 * 1024)
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	guid := "5CA3360B-5DE6-4FCF-B4CE-419CEE433B51"
+	table := &gpt.Table{
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 36863, Type: gpt.EFISystemPartition, Name: "EFI"},
+			{Start: 40960, End: 40960 + 36863, Type: gpt.LinuxFilesystem, GUID: guid},
+		},
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	for i, label := range []string{"ESP", "DATA"} {
+		if _, err := d.CreateFilesystem(disk.FormatSpec{Partition: i + 1, FSType: filesystem.TypeFat32, VolumeLabel: label}); err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+	}
+
+	fs, err := d.GetFilesystemByLabel("DATA")
+	if err != nil {
+		t.Fatalf("error getting filesystem by label: %v", err)
+	}
+	if label := strings.TrimSpace(fs.Label()); label != "DATA" {
+		t.Errorf("filesystem with label %q instead of %q", label, "DATA")
+	}
+	if _, err := d.GetFilesystemByLabel("MISSING"); !errors.Is(err, disk.ErrPartitionNotFound) {
+		t.Errorf("error %v instead of %v", err, disk.ErrPartitionNotFound)
+	}
+	r, err := d.Probe(1)
+	if err != nil {
+		t.Fatalf("error probing partition: %v", err)
+	}
+	if fs, err := d.GetFilesystemByUUID(strings.ToLower(r.UUID)); err != nil || strings.TrimSpace(fs.Label()) != "ESP" {
+		t.Errorf("error %v getting filesystem by UUID %s", err, r.UUID)
+	}
+
+	n, p, err := d.GetPartitionByGUID(strings.ToLower(guid))
+	if err != nil || n != 2 || p.GetStart() != 40960*512 {
+		t.Errorf("partition %d, %v, %v with GUID instead of 2", n, p, err)
+	}
+	if _, _, err := d.GetPartitionByGUID("00000000-0000-0000-0000-000000000000"); !errors.Is(err, disk.ErrPartitionNotFound) {
+		t.Errorf("error %v instead of %v", err, disk.ErrPartitionNotFound)
+	}
+	n, esp, err := d.GetPartitionByTypeGUID(gpt.EFISystemPartition)
+	if err != nil || n != 1 || esp.Name != "EFI" {
+		t.Errorf("partition %d, %v, %v of type instead of 1", n, esp, err)
+	}
+}
+
+func TestCreateFilesystemFormatSpec(t *testing.T) {
+	size := int64(40 * 1024 * 1024)
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	table := &gpt.Table{
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 36863, Type: gpt.EFISystemPartition},
+		},
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	spec := disk.FormatSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "BOOT", UUID: "1234-abcd", BlockSize: 4096}
+	fs, err := d.CreateFilesystem(spec)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if b, ok := fs.(filesystem.BlockSizeFS); !ok || b.BlockSize() != 4096 {
+		t.Errorf("filesystem %v without clusters of 4096 bytes", fs)
+	}
+	r, err := d.Probe(1)
+	if err != nil {
+		t.Fatalf("error probing partition: %v", err)
+	}
+	if r.UUID != "1234-ABCD" || r.Label != "BOOT" {
+		t.Errorf("UUID %q and label %q instead of %q and %q", r.UUID, r.Label, "1234-ABCD", "BOOT")
+	}
+
+	for _, spec := range []disk.FormatSpec{
+		{Partition: 1, FSType: filesystem.TypeFat32, UUID: "not-a-serial"},
+		{Partition: 1, FSType: filesystem.TypeFat32, BlockSize: 3000},
+		{Partition: 1, FSType: filesystem.TypeFat32, ReservedPercent: 5},
+		{Partition: 1, FSType: filesystem.TypeExt4, UUID: "not-a-uuid"},
+		{Partition: 1, FSType: filesystem.TypeSquashfs, BlockSize: 4096},
+	} {
+		if _, err := d.CreateFilesystem(spec); err == nil {
+			t.Errorf("no error creating filesystem with %+v", spec)
+		}
+	}
+}
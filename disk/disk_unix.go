@@ -4,14 +4,9 @@
 package disk
 
 import (
-	"fmt"
 	"os"
 
-	"golang.org/x/sys/unix"
-)
-
-const (
-	blkrrpart = 0x125f
+	"github.com/diskfs/go-diskfs/backend/block"
 )
 
 // ReReadPartitionTable forces the kernel to re-read the partition table
@@ -31,11 +26,7 @@ func (d *Disk) ReReadPartitionTable() error {
 		if err != nil {
 			return err
 		}
-		fd := osFile.Fd()
-		_, err = unix.IoctlGetInt(int(fd), blkrrpart)
-		if err != nil {
-			return fmt.Errorf("unable to re-read the partition table. Kernel still uses old partition table: %v", err)
-		}
+		return block.RereadPartitionTable(osFile)
 	}
 
 	return nil
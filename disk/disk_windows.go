@@ -1,10 +1,30 @@
 package disk
 
-// ReReadPartitionTable is used to re-read the partition table
+import (
+	"os"
+
+	"github.com/diskfs/go-diskfs/backend/block"
+)
+
+// ReReadPartitionTable makes Windows re-read the partition table
 // on the disk.
 //
-// In windows machine, force re-read is not done. The method returns nil when
-// invoked
+// It is done via an IOCTL_DISK_UPDATE_PROPERTIES call on physical drives.
 func (d *Disk) ReReadPartitionTable() error {
+	// the partition table needs to be re-read only if
+	// the disk file is an actual physical drive
+	devInfo, err := d.Backend.Stat()
+	if err != nil {
+		return err
+	}
+
+	if devInfo.Mode()&os.ModeDevice != 0 {
+		osFile, err := d.Backend.Sys()
+		if err != nil {
+			return err
+		}
+		return block.RereadPartitionTable(osFile)
+	}
+
 	return nil
 }
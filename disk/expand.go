@@ -0,0 +1,126 @@
+package disk
+
+import (
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// ExpandPartition grows the partition to size bytes, or as far as it can for a size of 0 or less:
+// up to the next partition or the end of the disk. It moves the backup GPT to the end of the disk,
+// so an image written to a larger device, e.g. an SD card, can be grown to fill it, and then grows
+// the filesystem of the partition into the new space, for the filesystems that can, see
+// filesystem.GrowFS, which is FAT32.
+//
+// A partition without a filesystem that can be read is grown on its own. Nothing is changed if
+// the filesystem cannot grow: the error is filesystem.ErrNotSupported.
+func (d *Disk) ExpandPartition(part int, size int64) error {
+	rw, err := d.writable()
+	if err != nil {
+		return err
+	}
+	if d.Table == nil {
+		return fmt.Errorf("cannot expand a partition on a disk without a partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	// API indexes from 1, but slice from 0
+	if part < 1 || part > len(partitions) {
+		return fmt.Errorf("cannot expand partition %d which is not between 1 and max partition %d", part, len(partitions))
+	}
+	current := partitions[part-1].GetSize()
+	if size > 0 && size < current {
+		return fmt.Errorf("cannot shrink partition %d of %d bytes to %d bytes", part, current, size)
+	}
+
+	// the filesystem is read before the partition changes, to know that it can grow
+	var grow filesystem.GrowFS
+	if fs, err := d.GetFilesystem(part); err == nil {
+		g, ok := fs.(filesystem.GrowFS)
+		if !ok {
+			return fmt.Errorf("cannot grow the filesystem of type %v of partition %d: %w", fs.Type(), part, filesystem.ErrNotSupported)
+		}
+		grow = g
+	}
+
+	switch table := d.Table.(type) {
+	case *gpt.Table:
+		err = expandGPTPartition(table, part, size, d.Size)
+	case *mbr.Table:
+		err = expandMBRPartition(table, part, size, d.Size)
+	default:
+		err = fmt.Errorf("cannot expand partitions of a %s partition table", d.Table.Type())
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.Table.Write(rw, d.Size); err != nil {
+		return fmt.Errorf("failed to write partition table: %v", err)
+	}
+
+	if grow != nil {
+		if err := grow.Grow(d.Table.GetPartitions()[part-1].GetSize()); err != nil {
+			return fmt.Errorf("error growing filesystem of partition %d: %w", part, err)
+		}
+	}
+	if err := d.Sync(); err != nil {
+		return err
+	}
+	return d.ReReadPartitionTable()
+}
+
+// expandGPTPartition sets the size of the partition of the table, with its backup at the end of a
+// disk of diskSize bytes
+func expandGPTPartition(table *gpt.Table, part int, size, diskSize int64) error {
+	sectorSize := uint64(table.LogicalSectorSize)
+	table.Resize(uint64(diskSize))
+	p := table.Partitions[part-1]
+	last := table.LastDataSector()
+	for _, other := range table.Partitions {
+		if other != p && other.Type != gpt.Unused && other.Start > p.Start {
+			last = min(last, other.Start-1)
+		}
+	}
+	end := last
+	if size > 0 {
+		if uint64(size)%sectorSize != 0 {
+			return fmt.Errorf("size %d of partition %d is not a multiple of the sector size %d", size, part, sectorSize)
+		}
+		end = p.Start + uint64(size)/sectorSize - 1
+	}
+	if end > last {
+		return fmt.Errorf("partition %d cannot grow to end at sector %d, after sector %d", part, end, last)
+	}
+	p.End = end
+	p.Size = (end - p.Start + 1) * sectorSize
+	return nil
+}
+
+// expandMBRPartition sets the size of the partition of the table, on a disk of diskSize bytes
+func expandMBRPartition(table *mbr.Table, part int, size, diskSize int64) error {
+	sectorSize := int64(table.LogicalSectorSize)
+	if sectorSize <= 0 {
+		sectorSize = 512
+	}
+	p := table.Partitions[part-1]
+	// the sectors of an MBR partition are counted in 32 bits
+	limit := min(diskSize/sectorSize, int64(p.Start)+1<<32-1)
+	for _, other := range table.Partitions {
+		if other != p && other.Size > 0 && other.Start > p.Start {
+			limit = min(limit, int64(other.Start))
+		}
+	}
+	sectors := limit - int64(p.Start)
+	if size > 0 {
+		if size%sectorSize != 0 {
+			return fmt.Errorf("size %d of partition %d is not a multiple of the sector size %d", size, part, sectorSize)
+		}
+		sectors = size / sectorSize
+	}
+	if int64(p.Start)+sectors > limit {
+		return fmt.Errorf("partition %d cannot grow to %d sectors, past sector %d", part, sectors, limit)
+	}
+	p.Size = uint32(sectors)
+	return nil
+}
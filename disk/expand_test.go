@@ -0,0 +1,136 @@
+package disk_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+func TestExpandPartition(t *testing.T) {
+	const (
+		imageSize = 24 * 1024 * 1024
+		cardSize  = 64 * 1024 * 1024
+	)
+	tables := map[string]func() partition.Table{
+		"gpt": func() partition.Table {
+			return &gpt.Table{
+				Partitions: []*gpt.Partition{
+					{Start: 2048, End: 2048 + 32767, Type: gpt.EFISystemPartition},
+				},
+				LogicalSectorSize:  512,
+				PhysicalSectorSize: 512,
+				ProtectiveMBR:      true,
+			}
+		},
+		"mbr": func() partition.Table {
+			return &mbr.Table{
+				Partitions: []*mbr.Partition{
+					{Start: 2048, Size: 32768, Type: mbr.Fat32LBA},
+				},
+				LogicalSectorSize:  512,
+				PhysicalSectorSize: 512,
+			}
+		},
+	}
+	for name, table := range tables {
+		t.Run(name, func(t *testing.T) {
+			// an image written to the start of a larger card
+			b := make([]byte, cardSize)
+			d := &disk.Disk{
+				Backend:           mem.New(b[:imageSize], false),
+				LogicalBlocksize:  512,
+				PhysicalBlocksize: 512,
+				Size:              imageSize,
+			}
+			if err := d.Partition(table()); err != nil {
+				t.Fatalf("error partitioning disk: %v", err)
+			}
+			fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			contents := make([]byte, 1024*1024)
+			_, _ = rand.Read(contents)
+			f, err := fs.OpenFile("/file", os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := f.Write(contents); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+			if err := fs.Close(); err != nil {
+				t.Fatalf("error closing filesystem: %v", err)
+			}
+
+			d = &disk.Disk{
+				Backend:           mem.New(b, false),
+				LogicalBlocksize:  512,
+				PhysicalBlocksize: 512,
+				Size:              cardSize,
+			}
+			if _, err := d.GetPartitionTable(); err != nil {
+				t.Fatalf("error reading partition table: %v", err)
+			}
+			if err := d.ExpandPartition(1, 8*1024*1024); err == nil {
+				t.Errorf("no error shrinking partition")
+			}
+			if err := d.ExpandPartition(1, cardSize); err == nil {
+				t.Errorf("no error expanding partition past the end of the disk")
+			}
+			if err := d.ExpandPartition(1, 0); err != nil {
+				t.Fatalf("error expanding partition: %v", err)
+			}
+
+			if _, err := d.GetPartitionTable(); err != nil {
+				t.Fatalf("error reading expanded partition table: %v", err)
+			}
+			if err := d.Table.Verify(d.Backend, cardSize); err != nil {
+				t.Errorf("invalid expanded partition table: %v", err)
+			}
+			p := d.Table.GetPartitions()[0]
+			if end := p.GetStart() + p.GetSize(); end < cardSize-1024*1024 {
+				t.Errorf("partition ends at %d on a disk of %d bytes", end, cardSize)
+			}
+			fs, err = d.GetFilesystem(1)
+			if err != nil {
+				t.Fatalf("error reading expanded filesystem: %v", err)
+			}
+			total, _, err := fs.(filesystem.SpaceFS).Space()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total < p.GetSize()-1024*1024 {
+				t.Errorf("filesystem of %d bytes in partition of %d bytes", total, p.GetSize())
+			}
+			if b, err := fs.ReadFile("file"); err != nil || !bytes.Equal(b, contents) {
+				t.Errorf("mismatched contents of file in expanded filesystem, %v", err)
+			}
+		})
+	}
+
+	t.Run("no filesystem", func(t *testing.T) {
+		d := &disk.Disk{
+			Backend:           mem.New(make([]byte, cardSize), false),
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Size:              cardSize,
+		}
+		if err := d.Partition(tables["gpt"]()); err != nil {
+			t.Fatalf("error partitioning disk: %v", err)
+		}
+		if err := d.ExpandPartition(1, 32*1024*1024); err != nil {
+			t.Fatalf("error expanding partition: %v", err)
+		}
+		if size := d.Table.GetPartitions()[0].GetSize(); size != 32*1024*1024 {
+			t.Errorf("partition of %d bytes instead of %d", size, 32*1024*1024)
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package disk
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// ManifestVersion is the version of the Manifest written by this package
+const ManifestVersion = 1
+
+// ErrManifestSignature is returned when the signature of a Manifest is missing or invalid
+var ErrManifestSignature = errors.New("invalid manifest signature")
+
+// Manifest records the partition table of a disk and the SHA-256 of the contents of each of its
+// partitions, e.g. once their filesystems are finalized, so that a device flashed with the image
+// can be verified against it, see VerifyManifest. It is encoded with encoding/json, and can be
+// signed with Sign.
+type Manifest struct {
+	Version int `json:"version"`
+	// Size is the size of the disk the manifest was made of; devices may be larger
+	Size int64 `json:"size"`
+	// TableType and TableUUID are those of the partition table, empty for a disk without one
+	TableType string `json:"tableType,omitempty"`
+	TableUUID string `json:"tableUUID,omitempty"`
+	// Partitions are the partitions of the table, or the whole disk as partition 0 without one
+	Partitions []ManifestPartition `json:"partitions"`
+	// Signature is the ed25519 signature of the rest of the manifest, see Sign
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// ManifestPartition is a partition of a Manifest
+type ManifestPartition struct {
+	// Number is that of the partition, from 1, as for GetFilesystem
+	Number int `json:"number"`
+	// Start and Size are in bytes
+	Start int64 `json:"start"`
+	Size  int64 `json:"size"`
+	// Type is the type GUID of a GPT partition, or the type byte of an MBR partition in hexadecimal
+	Type string `json:"type,omitempty"`
+	// UUID is the unique GUID of a GPT partition, or the UUID of an MBR partition
+	UUID string `json:"uuid,omitempty"`
+	// Name is the name of a GPT partition
+	Name string `json:"name,omitempty"`
+	// SHA256 is the hash of the contents of the partition, in hexadecimal
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestVerification is the outcome of VerifyManifest. The disk matches the manifest if all of
+// it is empty.
+type ManifestVerification struct {
+	// Layout describes each way in which the partition table differs from that of the manifest
+	Layout []string `json:"layout"`
+	// Mismatched are the numbers of the partitions whose contents, at the start and of the size of
+	// the manifest, have another hash
+	Mismatched []int `json:"mismatched"`
+}
+
+// OK returns whether the disk matches the manifest
+func (v *ManifestVerification) OK() bool {
+	return len(v.Layout) == 0 && len(v.Mismatched) == 0
+}
+
+type manifestOpts struct {
+	progress util.Progress
+}
+
+// ManifestOpt func that process Manifest and VerifyManifest options
+type ManifestOpt func(o *manifestOpts) error
+
+// WithManifestProgress sets the Progress updated by Manifest and VerifyManifest in util.PhaseHash,
+// with the bytes of the partitions hashed so far, out of the size of all of them
+func WithManifestProgress(progress util.Progress) ManifestOpt {
+	return func(o *manifestOpts) error {
+		o.progress = progress
+		return nil
+	}
+}
+
+// Manifest returns the manifest of the disk, reading all of its partitions to hash them
+func (d *Disk) Manifest(opts ...ManifestOpt) (*Manifest, error) {
+	return d.ManifestContext(context.Background(), opts...)
+}
+
+// ManifestContext returns the manifest of the disk like Manifest, stopping with the error of ctx
+// when it is done
+func (d *Disk) ManifestContext(ctx context.Context, opts ...ManifestOpt) (*Manifest, error) {
+	o := &manifestOpts{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	m := &Manifest{
+		Version:    ManifestVersion,
+		Size:       d.Size,
+		Partitions: d.manifestPartitions(),
+	}
+	if d.Table != nil {
+		m.TableType, m.TableUUID = d.Table.Type(), d.Table.UUID()
+	}
+	h := &manifestHasher{ctx: ctx, backend: d.Backend, progress: o.progress, total: m.partitionsSize()}
+	for i := range m.Partitions {
+		p := &m.Partitions[i]
+		sum, err := h.hash(p.Start, p.Size)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing partition %d: %w", p.Number, err)
+		}
+		p.SHA256 = sum
+	}
+	return m, nil
+}
+
+// VerifyManifest checks the disk against the manifest: that its partition table is the same, and
+// that the contents of each partition of the manifest, where the manifest says it is, have the same
+// hash. It returns an error only if the disk cannot be read, and otherwise what differs, see
+// ManifestVerification. The signature of the manifest is not checked, see VerifySignature.
+func (d *Disk) VerifyManifest(m *Manifest, opts ...ManifestOpt) (*ManifestVerification, error) {
+	return d.VerifyManifestContext(context.Background(), m, opts...)
+}
+
+// VerifyManifestContext checks the disk against the manifest like VerifyManifest, stopping with
+// the error of ctx when it is done
+func (d *Disk) VerifyManifestContext(ctx context.Context, m *Manifest, opts ...ManifestOpt) (*ManifestVerification, error) {
+	o := &manifestOpts{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	v := &ManifestVerification{}
+	tableType, tableUUID := "", ""
+	if d.Table != nil {
+		tableType, tableUUID = d.Table.Type(), d.Table.UUID()
+	}
+	if tableType != m.TableType {
+		v.Layout = append(v.Layout, fmt.Sprintf("partition table of type %q instead of %q", tableType, m.TableType))
+	} else if !strings.EqualFold(tableUUID, m.TableUUID) {
+		v.Layout = append(v.Layout, fmt.Sprintf("partition table UUID %s instead of %s", tableUUID, m.TableUUID))
+	}
+	if d.Size < m.Size {
+		v.Layout = append(v.Layout, fmt.Sprintf("disk of %d bytes, smaller than %d bytes", d.Size, m.Size))
+	}
+	actual := map[int]ManifestPartition{}
+	for _, p := range d.manifestPartitions() {
+		actual[p.Number] = p
+	}
+	for _, expected := range m.Partitions {
+		p, ok := actual[expected.Number]
+		switch {
+		case !ok:
+			v.Layout = append(v.Layout, fmt.Sprintf("partition %d missing", expected.Number))
+		case p.Start != expected.Start || p.Size != expected.Size:
+			v.Layout = append(v.Layout, fmt.Sprintf("partition %d of %d bytes from %d instead of %d bytes from %d", p.Number, p.Size, p.Start, expected.Size, expected.Start))
+		case p.Type != expected.Type || !strings.EqualFold(p.UUID, expected.UUID) || p.Name != expected.Name:
+			v.Layout = append(v.Layout, fmt.Sprintf("partition %d of type %s, UUID %s and name %q instead of %s, %s and %q", p.Number, p.Type, p.UUID, p.Name, expected.Type, expected.UUID, expected.Name))
+		}
+		delete(actual, expected.Number)
+	}
+	unlisted := make([]int, 0, len(actual))
+	for number := range actual {
+		unlisted = append(unlisted, number)
+	}
+	slices.Sort(unlisted)
+	for _, number := range unlisted {
+		v.Layout = append(v.Layout, fmt.Sprintf("partition %d not in the manifest", number))
+	}
+
+	h := &manifestHasher{ctx: ctx, backend: d.Backend, progress: o.progress, total: m.partitionsSize()}
+	for _, p := range m.Partitions {
+		if p.Start < 0 || p.Size < 0 || p.Start+p.Size > d.Size {
+			v.Mismatched = append(v.Mismatched, p.Number)
+			continue
+		}
+		sum, err := h.hash(p.Start, p.Size)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing partition %d: %w", p.Number, err)
+		}
+		if !strings.EqualFold(sum, p.SHA256) {
+			v.Mismatched = append(v.Mismatched, p.Number)
+		}
+	}
+	return v, nil
+}
+
+// manifestPartitions returns the partitions of the disk, of a Manifest without their hashes
+func (d *Disk) manifestPartitions() []ManifestPartition {
+	if d.Table == nil {
+		return []ManifestPartition{{Number: 0, Start: 0, Size: d.Size}}
+	}
+	var partitions []ManifestPartition
+	for i, p := range d.Table.GetPartitions() {
+		if p.GetSize() <= 0 {
+			continue
+		}
+		mp := ManifestPartition{Number: i + 1, Start: p.GetStart(), Size: p.GetSize(), UUID: p.UUID()}
+		switch p := p.(type) {
+		case *gpt.Partition:
+			mp.Type, mp.Name = string(p.Type), p.Name
+		case *mbr.Partition:
+			mp.Type = fmt.Sprintf("%#02x", byte(p.Type))
+		}
+		partitions = append(partitions, mp)
+	}
+	return partitions
+}
+
+// partitionsSize returns the size of all the partitions of the manifest
+func (m *Manifest) partitionsSize() int64 {
+	var total int64
+	for _, p := range m.Partitions {
+		total += p.Size
+	}
+	return total
+}
+
+// signedBytes returns the bytes of the manifest that its signature is of, its JSON encoding
+// without the signature
+func (m *Manifest) signedBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// Sign signs the manifest with the ed25519 key, setting its Signature, so that a device can be
+// verified against a manifest known to come from the build
+func (m *Manifest) Sign(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid ed25519 private key of %d bytes", len(key))
+	}
+	b, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	m.Signature = ed25519.Sign(key, b)
+	return nil
+}
+
+// VerifySignature checks that the manifest was signed with the private key of the ed25519 key,
+// and has not changed since, returning an error that is ErrManifestSignature if not
+func (m *Manifest) VerifySignature(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid ed25519 public key of %d bytes", len(key))
+	}
+	if len(m.Signature) == 0 {
+		return fmt.Errorf("manifest is not signed: %w", ErrManifestSignature)
+	}
+	b, err := m.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, b, m.Signature) {
+		return ErrManifestSignature
+	}
+	return nil
+}
+
+// manifestHasher hashes the partitions of a disk, reporting the progress through all of them
+type manifestHasher struct {
+	ctx      context.Context
+	backend  io.ReaderAt
+	progress util.Progress
+	done     int64
+	total    int64
+}
+
+func (h *manifestHasher) hash(start, size int64) (string, error) {
+	sum := sha256.New()
+	buf := util.DefaultBufferPool.Get(1024 * 1024)
+	defer util.DefaultBufferPool.Put(buf)
+	for offset := int64(0); offset < size; {
+		if err := h.ctx.Err(); err != nil {
+			return "", err
+		}
+		b := buf[:min(int64(len(buf)), size-offset)]
+		n, err := h.backend.ReadAt(b, start+offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		if n != len(b) {
+			return "", fmt.Errorf("read %d bytes instead of %d at %d", n, len(b), start+offset)
+		}
+		sum.Write(b)
+		offset += int64(n)
+		h.done += int64(n)
+		if h.progress != nil {
+			h.progress.Update(util.PhaseHash, h.done, h.total, "")
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
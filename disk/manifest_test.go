@@ -0,0 +1,149 @@
+package disk_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestManifest(t *testing.T) {
+	size := int64(32 * 1024 * 1024)
+	b := make([]byte, size)
+	d := &disk.Disk{
+		Backend:           mem.New(b, false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	table := &gpt.Table{
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 2048 + 16383, Type: gpt.EFISystemPartition, Name: "EFI"},
+			{Start: 2048 + 16384, End: 2048 + 16384 + 16383, Type: gpt.LinuxFilesystem, Name: "data"},
+		},
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/file", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("contents")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	raw := make([]byte, 8*1024*1024)
+	_, _ = rand.Read(raw)
+	if _, err := d.WritePartitionContents(2, bytes.NewReader(raw)); err != nil {
+		t.Fatalf("error writing partition 2: %v", err)
+	}
+
+	var done, total int64
+	m, err := d.Manifest(disk.WithManifestProgress(util.ProgressFunc(func(phase string, d, tot int64, _ string) {
+		done, total = d, tot
+	})))
+	if err != nil {
+		t.Fatalf("error making manifest: %v", err)
+	}
+	if done != 16*1024*1024 || total != done {
+		t.Errorf("progress at %d of %d instead of %d", done, total, 16*1024*1024)
+	}
+	if m.TableType != "gpt" || len(m.Partitions) != 2 || m.Partitions[1].Name != "data" || m.Partitions[1].Start != (2048+16384)*512 {
+		t.Errorf("mismatched manifest %+v", m)
+	}
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifySignature(pub); !errors.Is(err, disk.ErrManifestSignature) {
+		t.Errorf("error %v for unsigned manifest instead of %v", err, disk.ErrManifestSignature)
+	}
+	if err := m.Sign(key); err != nil {
+		t.Fatalf("error signing manifest: %v", err)
+	}
+	// a manifest is shipped as JSON
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read disk.Manifest
+	if err := json.Unmarshal(encoded, &read); err != nil {
+		t.Fatalf("error decoding manifest: %v", err)
+	}
+	if err := read.VerifySignature(pub); err != nil {
+		t.Errorf("error verifying signature: %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := read.VerifySignature(other); !errors.Is(err, disk.ErrManifestSignature) {
+		t.Errorf("error %v for other key instead of %v", err, disk.ErrManifestSignature)
+	}
+	tampered := read
+	tampered.Partitions = slices.Clone(read.Partitions)
+	tampered.Partitions[0].SHA256 = tampered.Partitions[1].SHA256
+	if err := tampered.VerifySignature(pub); !errors.Is(err, disk.ErrManifestSignature) {
+		t.Errorf("error %v for changed manifest instead of %v", err, disk.ErrManifestSignature)
+	}
+
+	// a device larger than the image, with the same table and contents
+	device := make([]byte, 2*size)
+	copy(device, b)
+	dev := &disk.Disk{
+		Backend:           mem.New(device, false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              2 * size,
+	}
+	if _, err := dev.GetPartitionTable(); err != nil {
+		t.Fatalf("error reading partition table of device: %v", err)
+	}
+	v, err := dev.VerifyManifest(&read)
+	if err != nil {
+		t.Fatalf("error verifying device: %v", err)
+	}
+	if !v.OK() {
+		t.Errorf("device does not match manifest: %+v", v)
+	}
+
+	device[(2048+16384)*512+100] ^= 0xff
+	v, err = dev.VerifyManifest(&read)
+	if err != nil {
+		t.Fatalf("error verifying device: %v", err)
+	}
+	if len(v.Layout) != 0 || !slices.Equal(v.Mismatched, []int{2}) {
+		t.Errorf("verification %+v instead of partition 2 mismatched", v)
+	}
+
+	dev.Table = nil
+	v, err = dev.VerifyManifest(&read)
+	if err != nil {
+		t.Fatalf("error verifying device: %v", err)
+	}
+	if len(v.Layout) != 4 {
+		t.Errorf("layout differences %q for a device without a partition table", v.Layout)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.ManifestContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of %v", err, context.Canceled)
+	}
+}
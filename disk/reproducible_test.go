@@ -0,0 +1,71 @@
+package disk_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestReproducible(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	build := func(seed string) []byte {
+		t.Helper()
+		util.SetReproducible([]byte(seed), epoch)
+		defer util.ClearReproducible()
+		size := int64(40 * 1024 * 1024)
+		b := make([]byte, size)
+		d := &disk.Disk{
+			Backend:           mem.New(b, false),
+			LogicalBlocksize:  512,
+			PhysicalBlocksize: 512,
+			Size:              size,
+		}
+		table := &gpt.Table{
+			Partitions: []*gpt.Partition{
+				{Start: 2048, End: 2048 + 65535, Type: gpt.EFISystemPartition, Name: "EFI"},
+			},
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			ProtectiveMBR:      true,
+		}
+		if err := d.Partition(table); err != nil {
+			t.Fatalf("error partitioning disk: %v", err)
+		}
+		fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 1, FSType: filesystem.TypeFat32, VolumeLabel: "EFI"})
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fs.Mkdir("/EFI/BOOT"); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		f, err := fs.OpenFile("/EFI/BOOT/BOOTX64.EFI", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write(bytes.Repeat([]byte("boot"), 4096)); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("error closing file: %v", err)
+		}
+		return b
+	}
+
+	first, second := build("seed"), build("seed")
+	if !bytes.Equal(first, second) {
+		t.Errorf("images built with the same seed and epoch differ")
+	}
+	if bytes.Equal(first, build("other seed")) {
+		t.Errorf("images built with different seeds are the same")
+	}
+	if _, ok := util.Reproducible(); ok {
+		t.Errorf("reproducible mode still on after ClearReproducible")
+	}
+}
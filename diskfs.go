@@ -13,13 +13,16 @@ package diskfs
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/block"
 	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
 )
 
 // when we use a disk image with a GPT, we cannot get the logical sector size from the disk via the kernel
@@ -88,7 +91,23 @@ func writableMode(mode OpenModeOption) bool {
 }
 
 func initDisk(b backend.Storage, sectorSize SectorSize) (*disk.Disk, error) {
-	log.Debug("initDisk(): start")
+	newDisk, err := newDisk(b, sectorSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// try to initialize the partition table.
+	//nolint:errcheck // we ignore errors, because it is perfectly fine to open a disk and use it before it has a
+	// partition table. This is solely a convenience.
+	newDisk.GetPartitionTable()
+
+	return newDisk, nil
+}
+
+// newDisk returns the disk of the backend, with its size and sector sizes, without reading its
+// partition table
+func newDisk(b backend.Storage, sectorSize SectorSize) (*disk.Disk, error) {
+	log.Debug("newDisk(): start")
 
 	var (
 		lblksize = int64(defaultBlocksize)
@@ -117,27 +136,27 @@ func initDisk(b backend.Storage, sectorSize SectorSize) (*disk.Disk, error) {
 	mode := devInfo.Mode()
 	switch {
 	case mode.IsRegular():
-		log.Debug("initDisk(): regular file")
+		log.Debug("newDisk(): regular file")
 		if newDisk.Size <= 0 {
 			return nil, fmt.Errorf("could not get file size for device %s", devInfo.Name())
 		}
 	case mode&os.ModeDevice != 0:
-		log.Debug("initDisk(): block device")
+		log.Debug("newDisk(): block device")
 		osFile, err := newDisk.Backend.Sys()
 		if err != nil {
 			return nil, backend.ErrNotSuitable
 		}
 
-		if size, err := getBlockDeviceSize(osFile); err != nil {
+		if size, err := block.DeviceSize(osFile); err != nil {
 			return nil, fmt.Errorf("error getting block device %s size: %s", devInfo.Name(), err)
 		} else {
 			newDisk.Size = size
 		}
 
-		if lblksize, pblksize, err = getSectorSizes(osFile); err != nil {
+		if lblksize, pblksize, err = block.SectorSizes(osFile); err != nil {
 			return nil, fmt.Errorf("unable to get block sizes for device %s: %v", devInfo.Name(), err)
 		} else {
-			log.Debugf("initDisk(): logical block size %d, physical block size %d", lblksize, pblksize)
+			log.Debugf("newDisk(): logical block size %d, physical block size %d", lblksize, pblksize)
 
 			newDisk.LogicalBlocksize = lblksize
 			newDisk.PhysicalBlocksize = pblksize
@@ -152,11 +171,6 @@ func initDisk(b backend.Storage, sectorSize SectorSize) (*disk.Disk, error) {
 	//    var goodBlocks, orphanedBlocks int
 	//    goodBlocks = size / lblksize
 
-	// try to initialize the partition table.
-	//nolint:errcheck // we ignore errors, because it is perfectly fine to open a disk and use it before it has a
-	// partition table. This is solely a convenience.
-	newDisk.GetPartitionTable()
-
 	return newDisk, nil
 }
 
@@ -174,6 +188,7 @@ func checkDevice(device string) error {
 type openOpts struct {
 	mode       OpenModeOption
 	sectorSize SectorSize
+	logger     *slog.Logger
 }
 
 func openOptsDefaults() *openOpts {
@@ -195,6 +210,16 @@ func WithOpenMode(mode OpenModeOption) OpenOpt {
 	}
 }
 
+// WithLogger logs the I/O of the disk, and of the filesystems read from it, to logger, with
+// backend.WithLogger, so that slow operations can be debugged. The counts of the I/O are logged
+// when the disk is closed, and can be read with backend.Stats of the Backend of the disk.
+func WithLogger(logger *slog.Logger) OpenOpt {
+	return func(o *openOpts) error {
+		o.logger = logger
+		return nil
+	}
+}
+
 // WithSectorSize opens the disk file or block device with the provided sector size.
 // Defaults to the physical block size.
 func WithSectorSize(sectorSize SectorSize) OpenOpt {
@@ -207,6 +232,9 @@ func WithSectorSize(sectorSize SectorSize) OpenOpt {
 // Might be deprecated in future: use <backend>.New + diskfs.OpenBackend
 // Open a Disk from a path to a device in read-write exclusive mode
 // Should pass a path to a block device e.g. /dev/sda or a path to a file /tmp/foo.img
+// On Windows, physical drives and volumes are opened by their paths, e.g. \\.\PhysicalDrive1 or
+// \\.\E:, and in ReadWriteExclusive mode their volumes are locked and dismounted until the disk is
+// closed, which must be done as administrator.
 // The provided device must exist at the time you call Open().
 // Use OpenOpt to control options, such as sector size or open mode.
 func Open(device string, opts ...OpenOpt) (*disk.Disk, error) {
@@ -232,8 +260,25 @@ func Open(device string, opts ...OpenOpt) (*disk.Disk, error) {
 		return nil, fmt.Errorf("could not open device %s with mode %v: %w", device, m, err)
 	}
 
+	// block devices get a backend that knows their size and supports discarding ranges, and whose
+	// volumes are locked on Windows for exclusive access
+	if block.IsDevice(f) {
+		b, err := block.New(f, !writableMode(opt.mode))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if opt.mode == ReadWriteExclusive {
+			if err := b.Lock(); err != nil {
+				b.Close()
+				return nil, fmt.Errorf("could not lock device %s: %w", device, err)
+			}
+		}
+		return initDisk(opt.backend(b), opt.sectorSize)
+	}
+
 	// return our disk
-	return initDisk(file.New(f, !writableMode(opt.mode)), opt.sectorSize)
+	return initDisk(opt.backend(file.New(f, !writableMode(opt.mode))), opt.sectorSize)
 }
 
 // Open a Disk using provided fs.File to a device in read-only mode
@@ -250,7 +295,43 @@ func OpenBackend(b backend.Storage, opts ...OpenOpt) (*disk.Disk, error) {
 		}
 	}
 
-	return initDisk(b, opt.sectorSize)
+	return initDisk(opt.backend(b), opt.sectorSize)
+}
+
+// OpenFilesystemAt opens the filesystem at the byte offset of the backend, of size bytes, or up to
+// the end of the backend if size is 0, without reading any partition table: for images that are a
+// bare filesystem, or that hold one at an offset of their own, such as the FAT region of a firmware
+// image, or the offsets that mtools takes as image@@offset. The type of the filesystem is detected
+// as by Disk.GetFilesystemAt. It is writable if the backend is, and its type can be changed.
+// Use OpenOpt to control options, such as sector size; the open mode is that of the backend.
+func OpenFilesystemAt(b backend.Storage, offset, size int64, opts ...OpenOpt) (filesystem.FileSystem, error) {
+	opt := &openOpts{
+		mode:       ReadOnly,
+		sectorSize: SectorSizeDefault,
+	}
+
+	for _, o := range opts {
+		if err := o(opt); err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := newDisk(opt.backend(b), opt.sectorSize)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		size = d.Size - offset
+	}
+	return d.GetFilesystemAt(offset, size)
+}
+
+// backend wraps the backend of the disk as the options require
+func (o *openOpts) backend(b backend.Storage) backend.Storage {
+	if o.logger != nil {
+		return backend.WithLogger(b, o.logger)
+	}
+	return b
 }
 
 // Might be deprecated in future: use <backend>.CreateFromPath + diskfs.OpenBackend
@@ -10,7 +10,10 @@ import (
 	"testing"
 
 	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
 	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
 )
 
 const oneMB = 10 * 1024 * 1024
@@ -229,6 +232,46 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestOpenFilesystemAt(t *testing.T) {
+	// a FAT32 filesystem at an offset that is not that of a partition, after a blob of firmware
+	size, offset, fsSize := int64(48*1024*1024), int64(1024*1024+512), int64(40*1024*1024)
+	b := make([]byte, size)
+	_, _ = rand.Read(b[:offset])
+	fs, err := fat32.Create(mem.New(b, false), fsSize, offset, 512, "FIRMWARE")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/config.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("arm_64bit=1\n")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sz := range []int64{fsSize, 0} {
+		fs, err := diskfs.OpenFilesystemAt(mem.New(b, true), offset, sz)
+		if err != nil {
+			t.Fatalf("error opening filesystem of %d bytes: %v", sz, err)
+		}
+		if fs.Type() != filesystem.TypeFat32 {
+			t.Errorf("filesystem of type %v instead of FAT32", fs.Type())
+		}
+		if contents, err := fs.ReadFile("config.txt"); err != nil || string(contents) != "arm_64bit=1\n" {
+			t.Errorf("read %q, %v", contents, err)
+		}
+	}
+	if _, err := diskfs.OpenFilesystemAt(mem.New(b, true), 0, 0); err == nil {
+		t.Errorf("no error opening filesystem at the start of the firmware")
+	}
+	if _, err := diskfs.OpenFilesystemAt(mem.New(b, true), offset, size); err == nil {
+		t.Errorf("no error opening filesystem past the end of the backend")
+	}
+}
+
 func testTmpFilename(t *testing.T, prefix, suffix string) string {
 	t.Helper()
 	randBytes := make([]byte, 16)
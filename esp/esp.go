@@ -0,0 +1,222 @@
+// Package esp creates EFI system partitions, formatted FAT32 with their files, in one call:
+//
+//	spec := &esp.Spec{
+//		Size:        256 * 1024 * 1024,
+//		BootLoaders: map[gpt.Architecture][]byte{gpt.ArchX86_64: shim, gpt.ArchARM64: shimAA64},
+//		Files:       map[string][]byte{"/EFI/BOOT/grubx64.efi": grub},
+//	}
+//	n, fs, err := spec.Create(d)
+//
+// The boot loaders are written to the default paths of removable media, e.g.
+// /EFI/BOOT/BOOTX64.EFI, which UEFI firmware boots from without any boot entry.
+package esp
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+const (
+	// DefaultSize is the default size of the partition, ample for boot loaders and a few kernels
+	DefaultSize = 512 * 1024 * 1024
+	// DefaultLabel is the default volume label of the filesystem
+	DefaultLabel = "ESP"
+	// DefaultName is the default name of the partition, that of gdisk and systemd-repart
+	DefaultName = "EFI System Partition"
+	// alignment is that of the partition, as by most partitioning tools
+	alignment = 1024 * 1024
+)
+
+// bootFiles are the names of the default boot loaders of the architectures, in /EFI/BOOT
+var bootFiles = map[gpt.Architecture]string{
+	gpt.ArchX86:         "BOOTIA32.EFI",
+	gpt.ArchX86_64:      "BOOTX64.EFI",
+	gpt.ArchIA64:        "BOOTIA64.EFI",
+	gpt.ArchARM:         "BOOTARM.EFI",
+	gpt.ArchARM64:       "BOOTAA64.EFI",
+	gpt.ArchRISCV32:     "BOOTRISCV32.EFI",
+	gpt.ArchRISCV64:     "BOOTRISCV64.EFI",
+	gpt.ArchLoongArch64: "BOOTLOONGARCH64.EFI",
+}
+
+// BootLoaderPath returns the path in the partition of the default boot loader of the
+// architecture, e.g. /EFI/BOOT/BOOTX64.EFI
+func BootLoaderPath(arch gpt.Architecture) (string, error) {
+	name, ok := bootFiles[arch]
+	if !ok {
+		return "", fmt.Errorf("UEFI has no default boot loader for architecture %q", arch)
+	}
+	return "/EFI/BOOT/" + name, nil
+}
+
+// Spec describes an EFI system partition and its files
+type Spec struct {
+	// Size is the size of the partition in bytes, a multiple of the sector size, DefaultSize if 0
+	Size uint64
+	// Label is the volume label of the filesystem, DefaultLabel if not set
+	Label string
+	// SerialNumber is the volume serial number, e.g. "1234-ABCD", random if not set
+	SerialNumber string
+	// Name is the name of the GPT partition, DefaultName if not set
+	Name string
+	// GUID is the unique GUID of the GPT partition, random if not set
+	GUID string
+	// Attributes are the attributes of the GPT partition, e.g. gpt.AttributeRequired
+	Attributes uint64
+	// BootLoaders are the default boot loaders of each architecture, see BootLoaderPath
+	BootLoaders map[gpt.Architecture][]byte
+	// Files are other files by their absolute path, e.g. /loader/loader.conf, written after the
+	// boot loaders, with their directories
+	Files map[string][]byte
+}
+
+// Create adds the partition to the GPT partition table of the disk, after its last partition and
+// aligned to 1MiB, or creates a table with only the partition if the disk has none, then formats
+// and populates it with Format. It returns the number of the partition and its filesystem.
+func (s *Spec) Create(d *disk.Disk) (int, filesystem.FileSystem, error) {
+	table, ok := d.Table.(*gpt.Table)
+	switch {
+	case d.Table == nil:
+		table = &gpt.Table{
+			LogicalSectorSize:  int(d.LogicalBlocksize),
+			PhysicalSectorSize: int(d.PhysicalBlocksize),
+			ProtectiveMBR:      true,
+		}
+	case !ok:
+		return 0, nil, fmt.Errorf("cannot add an EFI system partition to a %s partition table", d.Table.Type())
+	}
+	size := s.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+	sector := uint64(d.LogicalBlocksize)
+	if size%sector != 0 {
+		return 0, nil, fmt.Errorf("size %d of the partition is not a multiple of %d bytes", size, sector)
+	}
+	align := alignment / sector
+	start := align
+	for _, p := range table.Partitions {
+		if p.Type != gpt.Unused {
+			start = max(start, (p.End+align)/align*align)
+		}
+	}
+	end := start + size/sector - 1
+	// the last sector before the backup partition entry array and header
+	if last := uint64(d.Size)/sector - 2 - 128*128/sector; end > last {
+		return 0, nil, fmt.Errorf("partition of %d bytes at sector %d does not fit on the disk, whose last usable sector is %d", size, start, last)
+	}
+	name := s.Name
+	if name == "" {
+		name = DefaultName
+	}
+	table.Partitions = append(table.Partitions, &gpt.Partition{
+		Start:      start,
+		End:        end,
+		Type:       gpt.EFISystemPartition,
+		Name:       name,
+		GUID:       s.GUID,
+		Attributes: s.Attributes,
+	})
+	if err := d.Partition(table); err != nil {
+		return 0, nil, err
+	}
+	n := len(table.Partitions)
+	fs, err := s.Format(d, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, fs, nil
+}
+
+// Format formats the partition of the disk, numbered from 1 as in disk.GetFilesystem, FAT32 with
+// the label, and writes the boot loaders and files to it. The partition must be of the type of
+// EFI system partitions, gpt.EFISystemPartition or mbr.EFISystem.
+func (s *Spec) Format(d *disk.Disk, partition int) (filesystem.FileSystem, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot use partition %d of %d partitions", partition, len(partitions))
+	}
+	switch p := partitions[partition-1].(type) {
+	case *gpt.Partition:
+		if p.Type != gpt.EFISystemPartition {
+			return nil, fmt.Errorf("partition %d is of type %s, not an EFI system partition", partition, p.Type)
+		}
+	case *mbr.Partition:
+		if p.Type != mbr.EFISystem {
+			return nil, fmt.Errorf("partition %d is of type %#x, not an EFI system partition", partition, p.Type)
+		}
+	}
+	label := s.Label
+	if label == "" {
+		label = DefaultLabel
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{
+		Partition:   partition,
+		FSType:      filesystem.TypeFat32,
+		VolumeLabel: label,
+		UUID:        s.SerialNumber,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error formatting partition %d: %w", partition, err)
+	}
+	if err := s.populate(fs); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// populate writes the boot loaders and files, in a fixed order
+func (s *Spec) populate(fs filesystem.FileSystem) error {
+	files := map[string][]byte{}
+	for arch, b := range s.BootLoaders {
+		p, err := BootLoaderPath(arch)
+		if err != nil {
+			return err
+		}
+		files[p] = b
+	}
+	names := make([]string, 0, len(files)+len(s.Files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var others []string
+	for name, b := range s.Files {
+		if !path.IsAbs(name) {
+			return fmt.Errorf("path %s of file is not absolute", name)
+		}
+		name = path.Clean(name)
+		if _, ok := files[name]; !ok {
+			others = append(others, name)
+		}
+		files[name] = b
+	}
+	slices.Sort(others)
+	for _, name := range append(names, others...) {
+		if err := fs.Mkdir(path.Dir(name)); err != nil {
+			return fmt.Errorf("error creating directory %s: %w", path.Dir(name), err)
+		}
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", name, err)
+		}
+		if _, err := f.Write(files[name]); err != nil {
+			f.Close()
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package esp_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/esp"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/probe"
+)
+
+const (
+	size    = 128 * 1024 * 1024
+	espSize = 40 * 1024 * 1024
+)
+
+func newDisk(t *testing.T) *disk.Disk {
+	t.Helper()
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	return d
+}
+
+func readFile(t *testing.T, fs filesystem.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", name, err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func TestCreate(t *testing.T) {
+	d := newDisk(t)
+	spec := &esp.Spec{
+		Size:         espSize,
+		SerialNumber: "1234-ABCD",
+		BootLoaders: map[gpt.Architecture][]byte{
+			gpt.ArchX86_64: []byte("x86-64 loader"),
+			gpt.ArchARM64:  []byte("arm64 loader"),
+		},
+		Files: map[string][]byte{
+			"/loader/loader.conf": []byte("timeout 3\n"),
+			"/startup.nsh":        []byte("BOOTX64.EFI\n"),
+		},
+	}
+	n, _, err := spec.Create(d)
+	if err != nil {
+		t.Fatalf("error creating partition: %v", err)
+	}
+	table := d.Table.(*gpt.Table)
+	p := table.Partitions[n-1]
+	if n != 1 || p.Type != gpt.EFISystemPartition || p.Name != esp.DefaultName || p.Start != 2048 || p.GetSize() != espSize {
+		t.Errorf("created partition %d %+v", n, p)
+	}
+	r, err := d.Probe(n)
+	if err != nil || r.Type != probe.TypeVFAT || r.Version != "FAT32" || r.Label != esp.DefaultLabel || r.UUID != "1234-ABCD" {
+		t.Errorf("probed %+v, %v", r, err)
+	}
+
+	fs, err := d.GetFilesystem(n)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for name, expected := range map[string]string{
+		"/EFI/BOOT/BOOTX64.EFI":  "x86-64 loader",
+		"/EFI/BOOT/BOOTAA64.EFI": "arm64 loader",
+		"/loader/loader.conf":    "timeout 3\n",
+		"/startup.nsh":           "BOOTX64.EFI\n",
+	} {
+		if content := readFile(t, fs, name); content != expected {
+			t.Errorf("%s contains %q instead of %q", name, content, expected)
+		}
+	}
+}
+
+func TestCreateAfter(t *testing.T) {
+	d := newDisk(t)
+	table := &gpt.Table{
+		ProtectiveMBR: true,
+		Partitions:    []*gpt.Partition{{Start: 2048, End: 4096, Type: gpt.BIOSBoot, Name: "bios"}},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	n, _, err := (&esp.Spec{Size: espSize, Label: "EFI", Attributes: gpt.AttributeRequired}).Create(d)
+	if err != nil {
+		t.Fatalf("error creating partition: %v", err)
+	}
+	p := d.Table.(*gpt.Table).Partitions[n-1]
+	if n != 2 || p.Start != 6144 || p.Attributes != gpt.AttributeRequired {
+		t.Errorf("created partition %d %+v", n, p)
+	}
+	if r, err := d.Probe(n); err != nil || r.Label != "EFI" {
+		t.Errorf("probed %+v, %v", r, err)
+	}
+	if _, err := (&esp.Spec{}).Format(d, 1); err == nil {
+		t.Errorf("formatted a partition of type %s", gpt.BIOSBoot)
+	}
+	if _, _, err := (&esp.Spec{Size: size}).Create(d); err == nil {
+		t.Errorf("created a partition larger than the disk")
+	}
+}
+
+func TestBootLoaderPath(t *testing.T) {
+	if p, err := esp.BootLoaderPath(gpt.ArchRISCV64); err != nil || p != "/EFI/BOOT/BOOTRISCV64.EFI" {
+		t.Errorf("path %s, %v", p, err)
+	}
+	if _, err := esp.BootLoaderPath(gpt.ArchS390X); err == nil {
+		t.Errorf("found a boot loader path for %s", gpt.ArchS390X)
+	}
+}
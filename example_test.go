@@ -30,7 +30,7 @@ func ExampleCreate_fat32() {
 	defer os.Remove(diskImg)
 	theDisk, _ := diskfs.Create(diskImg, size, diskfs.SectorSizeDefault)
 
-	fs, err := theDisk.CreateFilesystem(disk.FilesystemSpec{
+	fs, err := theDisk.CreateFilesystem(disk.FormatSpec{
 		Partition: 0,
 		FSType:    filesystem.TypeFat32,
 	})
@@ -62,7 +62,7 @@ func ExampleCreate_mbr() {
 
 	check(theDisk.Partition(table))
 
-	fs, err := theDisk.CreateFilesystem(disk.FilesystemSpec{
+	fs, err := theDisk.CreateFilesystem(disk.FormatSpec{
 		Partition: 1,
 		FSType:    filesystem.TypeFat32,
 	})
@@ -124,7 +124,7 @@ func ExampleCreate_fat32WithDirsAndFiles() {
 
 	check(theDisk.Partition(table))
 
-	fs, err := theDisk.CreateFilesystem(disk.FilesystemSpec{
+	fs, err := theDisk.CreateFilesystem(disk.FormatSpec{
 		Partition: 1,
 		FSType:    filesystem.TypeFat32,
 	})
@@ -22,7 +22,7 @@ func CreateBootableIso(diskImg string) {
 	// the following line is required for an ISO, which may have logical block sizes
 	// only of 2048, 4096, 8192
 	mydisk.LogicalBlocksize = 2048
-	fspec := disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeISO9660, VolumeLabel: "label"}
+	fspec := disk.FormatSpec{Partition: 0, FSType: filesystem.TypeISO9660, VolumeLabel: "label"}
 	fs, err := mydisk.CreateFilesystem(fspec)
 	check(err)
 	// write contents to the disk
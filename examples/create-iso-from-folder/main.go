@@ -49,7 +49,7 @@ func CreateIsoFromFolder(srcFolder string, outputFileName string) {
 	check(err)
 
 	// Create the ISO filesystem on the disk image
-	fspec := disk.FilesystemSpec{
+	fspec := disk.FormatSpec{
 		Partition:   0,
 		FSType:      filesystem.TypeISO9660,
 		VolumeLabel: "label",
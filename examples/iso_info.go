@@ -48,8 +48,12 @@ func fileInfoFor(path string, fs filesystem.FileSystem) error {
 			continue
 		}
 
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
 		myFile := isoFile.(*iso9660.File)
-		fmt.Printf("%s\n Size: %d\n Location: %d\n\n", fullPath, file.Size(), myFile.Location())
+		fmt.Printf("%s\n Size: %d\n Location: %d\n\n", fullPath, info.Size(), myFile.Location())
 	}
 	return nil
 }
@@ -19,7 +19,7 @@ func CreateSquashfs(diskImg string) {
 	mydisk, err := diskfs.Create(diskImg, diskSize, diskfs.SectorSize4k)
 	check(err)
 
-	fspec := disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeSquashfs, VolumeLabel: "label"}
+	fspec := disk.FormatSpec{Partition: 0, FSType: filesystem.TypeSquashfs, VolumeLabel: "label"}
 	fs, err := mydisk.CreateFilesystem(fspec)
 	check(err)
 	defer func() {
@@ -0,0 +1,35 @@
+package filesystem
+
+// Range is a range of bytes of a filesystem or a disk
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// End returns the offset just after the range
+func (r Range) End() int64 {
+	return r.Offset + r.Length
+}
+
+// AllocatedFS is implemented by the filesystems that know which of their blocks are in use, so
+// that a copy of the filesystem needs only those, like partclone, see disk.CloneTo
+type AllocatedFS interface {
+	// AllocatedRanges returns the ranges of the filesystem, in bytes from its start, that hold
+	// its metadata and the contents of its files, in order and neither adjacent nor overlapping.
+	// The contents of the rest do not matter to the filesystem.
+	AllocatedRanges() ([]Range, error)
+}
+
+// AppendRange appends the range of length bytes from offset to the ranges, which are in order,
+// merging it with the last of them where they are adjacent or overlap
+func AppendRange(ranges []Range, offset, length int64) []Range {
+	if length <= 0 {
+		return ranges
+	}
+	if n := len(ranges); n > 0 && offset <= ranges[n-1].End() {
+		last := &ranges[n-1]
+		last.Length = max(last.End(), offset+length) - last.Offset
+		return ranges
+	}
+	return append(ranges, Range{Offset: offset, Length: length})
+}
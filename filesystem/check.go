@@ -0,0 +1,206 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// Severity is how serious a Finding of Check is
+type Severity int
+
+// severities of findings, from the least serious
+const (
+	// SeverityInfo is an inconsistency that does no harm, such as a stale count of free space
+	// that readers recompute
+	SeverityInfo Severity = iota
+	// SeverityWarning is an inconsistency that loses space or may confuse other implementations,
+	// but leaves every file readable
+	SeverityWarning
+	// SeverityError is a corruption that leaves files unreadable or wrong, or that writing to the
+	// filesystem would spread
+	SeverityError
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+// String returns the name of the severity, as in the findings encoded as JSON
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes the severity by its name
+func (s Severity) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(severityNames) {
+		return nil, fmt.Errorf("invalid severity %d", int(s))
+	}
+	return []byte(severityNames[s]), nil
+}
+
+// UnmarshalText decodes the severity from its name
+func (s *Severity) UnmarshalText(b []byte) error {
+	for i, name := range severityNames {
+		if name == string(b) {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid severity %q", b)
+}
+
+// codes of the findings of GenericCheck, which every Checker reports too
+const (
+	// CodeDirectoryUnreadable is a directory whose entries cannot be read
+	CodeDirectoryUnreadable = "directory-unreadable"
+	// CodeFileUnreadable is a file whose contents cannot be read
+	CodeFileUnreadable = "file-unreadable"
+	// CodeFileSize is a file whose contents are not of the size of its entry
+	CodeFileSize = "file-size"
+	// CodeSymlinkUnreadable is a symbolic link whose target cannot be read
+	CodeSymlinkUnreadable = "symlink-unreadable"
+)
+
+// Finding is an inconsistency found by Check. Its fields are meant to be read by programs, e.g.
+// encoded as JSON for a CI gate, as well as by people.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	// Code identifies the kind of inconsistency, the same for every image, e.g. "file-size" or
+	// "fat-cross-linked", see the Code constants of each filesystem
+	Code string `json:"code"`
+	// Path is the absolute path of the file concerned, or "" if the finding is about the
+	// filesystem as a whole
+	Path string `json:"path,omitempty"`
+	// Message describes the inconsistency in detail
+	Message string `json:"message"`
+}
+
+// String formats the finding on one line, like the messages of fsck
+func (f Finding) String() string {
+	if f.Path == "" {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", f.Severity, f.Code, f.Path, f.Message)
+}
+
+// CheckOptions are the options of Check
+type CheckOptions struct {
+	// SkipContents does not read the contents of every file, only the structures that describe
+	// them, which is much faster for large images
+	SkipContents bool
+	// Progress, if set, is updated in util.PhaseCheck as the contents of files are read, with the
+	// path of each file. The total is not known beforehand and is always 0.
+	Progress util.Progress
+}
+
+// Checker is a filesystem that can check its own structures, like fsck without repairing
+// anything. Check returns the inconsistencies found, which include those of GenericCheck, and an
+// error only where the filesystem could not be checked at all.
+type Checker interface {
+	Check(opts CheckOptions) ([]Finding, error)
+}
+
+// Check checks the consistency of the filesystem with its Check if it is a Checker, or else
+// GenericCheck, so that images of any type can be validated the same way. An image is sound if
+// there is no finding of SeverityError, see MaxSeverity.
+func Check(f FileSystem, opts CheckOptions) ([]Finding, error) {
+	if c, ok := f.(Checker); ok {
+		return c.Check(opts)
+	}
+	return GenericCheck(f, opts)
+}
+
+// MaxSeverity returns the highest severity of the findings, and false if there are none
+func MaxSeverity(findings []Finding) (Severity, bool) {
+	if len(findings) == 0 {
+		return SeverityInfo, false
+	}
+	highest := SeverityInfo
+	for _, f := range findings {
+		if f.Severity > highest {
+			highest = f.Severity
+		}
+	}
+	return highest, true
+}
+
+// GenericCheck checks what can be found through the FileSystem alone: that every directory can
+// be read, that every regular file can be read to the end with the size of its entry, unless
+// opts.SkipContents, and that the targets of symbolic links can be read.
+func GenericCheck(f FileSystem, opts CheckOptions) ([]Finding, error) {
+	var (
+		findings []Finding
+		done     int64
+		buf      = make([]byte, copyBufferSize)
+	)
+	err := WalkDir(f, "/", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d == nil {
+				// not even the root can be found
+				return err
+			}
+			findings = append(findings, Finding{Severity: SeverityError, Code: CodeDirectoryUnreadable, Path: p, Message: err.Error()})
+			return nil
+		}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			if _, err := Readlink(f, p); err != nil && !errors.Is(err, ErrNotSupported) {
+				findings = append(findings, Finding{Severity: SeverityError, Code: CodeSymlinkUnreadable, Path: p, Message: err.Error()})
+			}
+		case d.Type().IsRegular() && !opts.SkipContents:
+			finding := checkContents(f, p, d, buf, func(n int64) {
+				done += n
+				if opts.Progress != nil {
+					opts.Progress.Update(util.PhaseCheck, done, 0, p)
+				}
+			})
+			if finding != nil {
+				findings = append(findings, *finding)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error checking filesystem: %w", err)
+	}
+	return findings, nil
+}
+
+// checkContents reads the whole file at p, reporting the bytes read to progress, and returns the
+// finding if it cannot be read or is not of the size of its entry
+func checkContents(f FileSystem, p string, d fs.DirEntry, buf []byte, progress func(n int64)) *Finding {
+	info, err := d.Info()
+	if err != nil {
+		return &Finding{Severity: SeverityError, Code: CodeFileUnreadable, Path: p, Message: err.Error()}
+	}
+	file, err := f.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return &Finding{Severity: SeverityError, Code: CodeFileUnreadable, Path: p, Message: err.Error()}
+	}
+	defer file.Close()
+	var n int64
+	for {
+		read, err := file.Read(buf)
+		n += int64(read)
+		progress(int64(read))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &Finding{Severity: SeverityError, Code: CodeFileUnreadable, Path: p, Message: fmt.Sprintf("error after %d bytes: %v", n, err)}
+		}
+		if read == 0 || n > info.Size() {
+			break
+		}
+	}
+	if n != info.Size() {
+		return &Finding{Severity: SeverityError, Code: CodeFileSize, Path: p, Message: fmt.Sprintf("read %d bytes of contents instead of %d", n, info.Size())}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package filesystem_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+func TestCheck(t *testing.T) {
+	t.Run("fat32", func(t *testing.T) {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := fat32.Create(b, size, 0, 512, "check")
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fstest.Write(f, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		findings, err := filesystem.Check(f, filesystem.CheckOptions{})
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		if len(findings) != 0 {
+			t.Fatalf("findings %v in a sound filesystem", findings)
+		}
+
+		// allocate the last cluster in both tables, to no file
+		raw := b.Bytes()
+		reserved := int(binary.LittleEndian.Uint16(raw[14:16]))
+		sectorsPerFat := int(binary.LittleEndian.Uint32(raw[36:40]))
+		clusters := (size/512 - reserved - 2*sectorsPerFat) / int(raw[13])
+		for _, fat := range []int{reserved, reserved + sectorsPerFat} {
+			binary.LittleEndian.PutUint32(raw[fat*512+4*(clusters+1):], 0x0fffffff)
+		}
+		f, err = fat32.Read(b, size, 0, 512)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		findings, err = filesystem.Check(f, filesystem.CheckOptions{SkipContents: true})
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		if len(findings) != 1 || findings[0].Code != fat32.CodeLostClusters || findings[0].Severity != filesystem.SeverityWarning {
+			t.Fatalf("findings %v instead of a lost cluster", findings)
+		}
+		out, err := json.Marshal(findings[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"severity":"warning","code":"fat-lost-clusters","message":"1 clusters are allocated but belong to no file"}`
+		if string(out) != expected {
+			t.Errorf("encoded %s instead of %s", out, expected)
+		}
+		if severity, ok := filesystem.MaxSeverity(findings); !ok || severity != filesystem.SeverityWarning {
+			t.Errorf("highest severity %v, %v instead of %v", severity, ok, filesystem.SeverityWarning)
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := squashfs.Create(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fstest.Write(f, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		f, err = squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		findings, err := filesystem.Check(f, filesystem.CheckOptions{})
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		if len(findings) != 0 {
+			t.Errorf("findings %v in a sound filesystem", findings)
+		}
+	})
+}
@@ -0,0 +1,463 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// AbsolutePath converts a name in the form of io/fs, e.g. "a/b" or "." for the root, to the
+// absolute path used by the methods of FileSystem, e.g. "/a/b". Absolute paths are returned as is.
+func AbsolutePath(name string) string {
+	if name == "" || name == "." {
+		return "/"
+	}
+	if name[0] != '/' {
+		return "/" + name
+	}
+	return name
+}
+
+// SortedDirEntries returns the entries of a directory for ReadDir, sorted by name, without the
+// entries for the directory itself and its parent
+func SortedDirEntries(infos []fs.FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		if name := info.Name(); name == "." || name == ".." || name == "" {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries
+}
+
+// osErrors are the errors of the operating system matching those of FileSystem, besides those
+// that already match the errors of io/fs
+var osErrors = []struct{ errno, err error }{
+	{syscall.ENOSPC, ErrNoSpace},
+	{syscall.ENOTDIR, ErrNotDir},
+	{syscall.EISDIR, ErrIsDir},
+	{syscall.ENOTEMPTY, ErrNotEmpty},
+	{syscall.EROFS, ErrReadOnlyFilesystem},
+	{syscall.EINVAL, fs.ErrInvalid},
+}
+
+// osError is an error of the operating system that also matches an error of FileSystem
+type osError struct {
+	err, match error
+}
+
+func (e *osError) Error() string   { return e.err.Error() }
+func (e *osError) Unwrap() []error { return []error{e.err, e.match} }
+
+// OSError returns err, from the operating system, such that errors.Is matches the errors of this
+// package as well, e.g. ErrNotEmpty for ENOTEMPTY, and fs.ErrInvalid for EINVAL. It is used by
+// the filesystems built in a workspace on the local filesystem.
+func OSError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, e := range osErrors {
+		if errors.Is(err, e.errno) {
+			return &osError{err: err, match: e.err}
+		}
+	}
+	return err
+}
+
+// rootInfo describes the root directory, which has no entry of its own in most filesystems
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "." }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o755 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() any           { return nil }
+
+// maxSymlinks is the number of symbolic links followed to find a file, like the limit of Linux
+const maxSymlinks = 40
+
+// readlinker is implemented by the Sys of the FileInfo of symbolic links, returning the target
+type readlinker interface {
+	Readlink() (string, error)
+}
+
+// linkInfo describes the file a symbolic link points to, by the name of the link
+type linkInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (l linkInfo) Name() string { return l.name }
+
+// Lstat describes the named file like Stat, but describes symbolic links themselves rather than
+// the files they point to, with the Lstat of the FileSystem if it is an LstatFS, or else
+// GenericLstat. The name is in the form of io/fs, or an absolute path.
+func Lstat(f FileSystem, name string) (fs.FileInfo, error) {
+	if l, ok := f.(LstatFS); ok {
+		return l.Lstat(name)
+	}
+	return GenericLstat(f, name)
+}
+
+// GenericLstat implements Lstat of LstatFS by finding the entry in its parent directory, without
+// following symbolic links. The name is in the form of io/fs, or an absolute path.
+func GenericLstat(f FileSystem, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(strings.TrimPrefix(name, "/")) && name != "/" {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := lstat(f, AbsolutePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// Readlink returns the target of the named symbolic link, with the Readlink of the FileSystem if
+// it is a ReadlinkFS, or else from the FileInfo of the link where the filesystem reports it. It
+// fails with fs.ErrInvalid if the file is not a symbolic link, and ErrNotSupported if its target
+// cannot be read. The name is in the form of io/fs, or an absolute path.
+func Readlink(f FileSystem, name string) (string, error) {
+	if r, ok := f.(ReadlinkFS); ok {
+		return r.Readlink(name)
+	}
+	info, err := Lstat(f, name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.Unwrap(err)}
+	}
+	target, err := readlink(f, name, info)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// readlink returns the target of the symbolic link of the path described by info
+func readlink(f FileSystem, p string, info fs.FileInfo) (string, error) {
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", fs.ErrInvalid
+	}
+	if r, ok := f.(ReadlinkFS); ok {
+		return r.Readlink(p)
+	}
+	if link, ok := info.Sys().(readlinker); ok {
+		return link.Readlink()
+	}
+	return "", ErrNotSupported
+}
+
+// lstat finds the entry of the absolute path in its parent directory
+func lstat(f FileSystem, p string) (fs.FileInfo, error) {
+	// some filesystems take backslashes as separators of the elements of their paths as well,
+	// which io/fs forbids, so names with backslashes are never found
+	if strings.Contains(p, `\`) {
+		return nil, fs.ErrNotExist
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return rootInfo{}, nil
+	}
+	entries, err := f.ReadDir(path.Dir(p))
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	name := path.Base(p)
+	i, found := slices.BinarySearchFunc(entries, name, func(e fs.DirEntry, name string) int {
+		return strings.Compare(e.Name(), name)
+	})
+	if !found {
+		return nil, fs.ErrNotExist
+	}
+	return entries[i].Info()
+}
+
+// lstatEntry describes the absolute path with the Lstat of the FileSystem if it is an LstatFS, or
+// else lstat, returning the errors of lstat rather than a fs.PathError
+func lstatEntry(f FileSystem, p string) (fs.FileInfo, error) {
+	l, ok := f.(LstatFS)
+	if !ok {
+		return lstat(f, p)
+	}
+	info, err := l.Lstat(p)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return nil, pathErr.Err
+	}
+	return info, err
+}
+
+// stat finds the entry of the absolute path like lstat, following symbolic links whose target
+// can be read. It returns the path of the file found as well.
+func stat(f FileSystem, p string) (fs.FileInfo, string, error) {
+	name := path.Base(p)
+	for i := 0; i <= maxSymlinks; i++ {
+		info, err := lstatEntry(f, p)
+		if err != nil {
+			return nil, "", err
+		}
+		target, err := readlink(f, p, info)
+		if errors.Is(err, fs.ErrInvalid) || errors.Is(err, ErrNotSupported) {
+			if i > 0 {
+				info = linkInfo{FileInfo: info, name: name}
+			}
+			return info, p, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return nil, "", errors.New("too many levels of symbolic links")
+}
+
+// GenericStat implements Stat of fs.StatFS by finding the entry in its parent directory. The name
+// is in the form of io/fs, or an absolute path.
+func GenericStat(f FileSystem, name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(strings.TrimPrefix(name, "/")) && name != "/" {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	info, _, err := stat(f, AbsolutePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// GenericOpen implements Open of fs.FS, opening the file read-only with OpenFile, or the directory
+// for reading its entries. The name is in the form of io/fs.
+func GenericOpen(f FileSystem, name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, p, err := stat(f, AbsolutePath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		return &dirFile{fs: f, path: p, info: info}, nil
+	}
+	file, err := f.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &readOnlyFile{file: file, info: info}, nil
+}
+
+// RemoveAll removes the named file, or the directory with everything it contains, like
+// os.RemoveAll, with Remove. A name that does not exist is not an error. The name is in the form
+// of io/fs, or an absolute path.
+func RemoveAll(f FileSystem, name string) error {
+	p := AbsolutePath(name)
+	if p == "/" {
+		return &fs.PathError{Op: "removeall", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := Lstat(f, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := f.ReadDir(p)
+		if err != nil {
+			return &fs.PathError{Op: "removeall", Path: name, Err: err}
+		}
+		for _, e := range entries {
+			if err := RemoveAll(f, path.Join(p, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := f.Remove(p); err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	return nil
+}
+
+// GenericReadFile implements ReadFile of fs.ReadFileFS with GenericOpen
+func GenericReadFile(f FileSystem, name string) ([]byte, error) {
+	file, err := GenericOpen(f, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer file.Close()
+	if info, _ := file.Stat(); info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: ErrIsDir}
+	}
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+// readOnlyFile is a file opened by GenericOpen
+type readOnlyFile struct {
+	file File
+	info fs.FileInfo
+	// mu guards closed
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *readOnlyFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *readOnlyFile) Read(b []byte) (int, error) {
+	return f.file.Read(b)
+}
+
+func (f *readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+func (f *readOnlyFile) ReadAt(b []byte, off int64) (int, error) {
+	return f.file.ReadAt(b, off)
+}
+
+func (f *readOnlyFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return f.file.Close()
+}
+
+// dirFile is a directory opened by GenericOpen
+type dirFile struct {
+	fs   FileSystem
+	path string
+	info fs.FileInfo
+	// entries are read on the first ReadDir, and returned from the position
+	entries []fs.DirEntry
+	read    bool
+	pos     int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: ErrIsDir}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all remaining entries if n <= 0
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.path)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.path, Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+	rest := d.entries[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.pos += n
+	return rest[:n], nil
+}
+
+// WalkDirFS is a FileSystem that walks its trees faster than GenericWalkDir, usually reading the
+// directories from their entries rather than looking up each of their paths
+type WalkDirFS interface {
+	FileSystem
+	WalkDir(root string, fn fs.WalkDirFunc) error
+}
+
+// WalkDir walks the file tree rooted at root like fs.WalkDir, with the WalkDir of the FileSystem
+// if it is a WalkDirFS, or else GenericWalkDir. The root is absolute or in the form of io/fs, and
+// the paths passed to fn are joined to it.
+func WalkDir(f FileSystem, root string, fn fs.WalkDirFunc) error {
+	if w, ok := f.(WalkDirFS); ok {
+		return w.WalkDir(root, fn)
+	}
+	return GenericWalkDir(f, root, fn)
+}
+
+// GenericWalkDir walks the file tree rooted at root like fs.WalkDir, reading each directory by
+// its path with ReadDir of the FileSystem. The entries passed to fn are those returned by
+// ReadDir, so fn needs no Stat of its own.
+func GenericWalkDir(f FileSystem, root string, fn fs.WalkDirFunc) error {
+	info, err := f.Stat(root)
+	if err != nil {
+		return WalkEntries(root, nil, err, nil, fn)
+	}
+	return WalkEntries(root, fs.FileInfoToDirEntry(info), nil, func(name string, _ fs.DirEntry) ([]fs.DirEntry, error) {
+		return f.ReadDir(name)
+	}, fn)
+}
+
+// WalkEntries walks the file tree of the entry d found at root, calling fn for each file and
+// directory with the rules of fs.WalkDir, including fs.SkipDir and fs.SkipAll. The entries of each
+// directory are read with readDir, given its path and entry, and must be sorted by name. If the
+// root could not be found, d is nil and err is the error, which is passed to fn.
+//
+// It lets a FileSystem implement WalkDir reading the directories from their entries.
+func WalkEntries(root string, d fs.DirEntry, err error, readDir func(name string, d fs.DirEntry) ([]fs.DirEntry, error), fn fs.WalkDirFunc) error {
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkEntries(root, d, readDir, fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkEntries(name string, d fs.DirEntry, readDir func(name string, d fs.DirEntry) ([]fs.DirEntry, error), fn fs.WalkDirFunc) error {
+	if err := fn(name, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	entries, err := readDir(name, d)
+	if err != nil {
+		// second call, to report the error reading the directory
+		if err = fn(name, d, err); err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := walkEntries(path.Join(name, e.Name()), e, readDir, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/ext4"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// readAll reads every file of the filesystem with small reads through OpenFile, looking up
+// each file by path too
+func readAll(f filesystem.FileSystem) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	err := filesystem.WalkDir(f, "/", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if _, err := f.Stat(p); err != nil {
+			return err
+		}
+		file, err := f.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		var buf bytes.Buffer
+		chunk := make([]byte, 1000)
+		for {
+			n, err := file.Read(chunk)
+			buf.Write(chunk[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		contents[p] = buf.Bytes()
+		return nil
+	})
+	return contents, err
+}
+
+// readFilesystems are filesystems of every type with files to read
+var readFilesystems = []struct {
+	name string
+	fs   func(t *testing.T) filesystem.FileSystem
+}{
+	{"fat32", func(t *testing.T) filesystem.FileSystem {
+		f := createFat32(t)
+		if err := fstest.Write(f, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}},
+	{"squashfs", func(t *testing.T) filesystem.FileSystem {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := squashfs.Create(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fstest.Write(f, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		f, err = squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		// blocks evicted from the cache while others read them
+		f.SetCacheSize(1)
+		return f
+	}},
+	{"iso9660", func(t *testing.T) filesystem.FileSystem {
+		b, err := file.OpenFromPath("iso9660/testdata/9660.iso", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = b.Close() })
+		f, err := iso9660.Read(b, 0, 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		return f
+	}},
+	{"ext4", func(t *testing.T) filesystem.FileSystem {
+		b, err := file.OpenFromPath("ext4/testdata/dist/ext4.img", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = b.Close() })
+		f, err := ext4.Read(b, 100*1024*1024, 0, 512)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		return f
+	}},
+}
+
+func TestConcurrentReads(t *testing.T) {
+	for _, tt := range readFilesystems {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			expected, err := readAll(f)
+			if err != nil {
+				t.Fatalf("error reading files: %v", err)
+			}
+			if len(expected) == 0 {
+				t.Fatal("no files read")
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := 0; i < cap(errs); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					actual, err := readAll(f)
+					if err != nil {
+						errs <- err
+						return
+					}
+					for p, content := range expected {
+						if !bytes.Equal(actual[p], content) {
+							t.Errorf("%s: read %d different bytes instead of %d", p, len(actual[p]), len(content))
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Errorf("error reading files concurrently: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// CopyOptions are the options of CopyTree
+type CopyOptions struct {
+	// Filter, if set, is called for every file and directory, with its name relative to the root
+	// of the source in the form of io/fs, "." for the root itself. Those for which it returns false
+	// are not copied, nor is anything inside skipped directories.
+	Filter func(name string, d fs.DirEntry) bool
+	// Progress, if set, is updated in util.PhaseCopy as the contents of files are copied, and after
+	// each file, directory or symbolic link, with its name like Filter. The total is the size of
+	// all the files to copy, found by walking the tree, and calling Filter, once more beforehand.
+	Progress util.Progress
+	// NoMetadata does not copy the mode, owner, modification time and extended attributes of the
+	// files. Otherwise they are copied where both the source reports them and the destination
+	// supports them, see XattrFS, those of directories once their contents are copied.
+	NoMetadata bool
+}
+
+// owner is implemented by the Sys of the FileInfo of filesystems that keep the owner of files
+type owner interface {
+	UID() uint32
+	GID() uint32
+}
+
+// copyBufferSize is the size of the chunks of content copied, between checks of the context
+const copyBufferSize = 1024 * 1024
+
+// CopyTree copies the file tree rooted at srcRoot in src to dstRoot in dst, creating the
+// directories, regular files, symbolic links, named pipes and devices, and copying their metadata
+// where supported, see CopyOptions. The roots are absolute or in the form of io/fs. If srcRoot is a
+// directory, its contents are copied into the directory dstRoot, which is created if needed, and
+// files already in dst are replaced; if it is a file, it is copied to dstRoot. Regular files that
+// are hard links to the same one in src are hard links in dst as well, where both are a LinkFS, and
+// separate copies otherwise. Devices are created where src reports their numbers, see
+// DeviceNumbers, and dst is an MknodFS.
+//
+// dst must be writable, or the workspace of a filesystem not yet finalized. The copy stops at the
+// first error, or when ctx is done.
+func CopyTree(ctx context.Context, src FileSystem, srcRoot string, dst FileSystem, dstRoot string, opts CopyOptions) error {
+	srcRoot, dstRoot = path.Clean(AbsolutePath(srcRoot)), path.Clean(AbsolutePath(dstRoot))
+	c := &copier{ctx: ctx, progress: opts.Progress, buf: make([]byte, copyBufferSize)}
+	if _, ok := src.(LinkFS); ok {
+		if _, ok := dst.(LinkFS); ok {
+			c.links = map[uint64]string{}
+		}
+	}
+	if c.progress != nil {
+		total, err := copySize(src, srcRoot, opts.Filter)
+		if err != nil {
+			return err
+		}
+		c.total = total
+	}
+	err := WalkDir(src, srcRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := relativeName(strings.TrimPrefix(p, srcRoot))
+		if opts.Filter != nil && !opts.Filter(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		target := path.Join(dstRoot, name)
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		switch {
+		case d.IsDir():
+			if target != "/" {
+				if err := dst.Mkdir(target); err != nil {
+					return fmt.Errorf("error creating directory %s: %w", target, err)
+				}
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			linkTarget, err := Readlink(src, p)
+			if err != nil {
+				return fmt.Errorf("error reading symbolic link %s: %w", p, err)
+			}
+			if err := dst.Symlink(linkTarget, target); err != nil {
+				return fmt.Errorf("error creating symbolic link %s: %w", target, err)
+			}
+			if !opts.NoMetadata {
+				if err := copyXattrs(src, p, dst, target); err != nil {
+					return err
+				}
+			}
+			// the rest of the metadata of a link would change its target
+			c.update(name)
+			return nil
+		case info.Mode().IsRegular():
+			linked, err := c.link(src, p, dst, target)
+			if err != nil {
+				return err
+			}
+			if linked {
+				// the metadata is that of the file already copied
+				c.done += info.Size()
+				c.update(name)
+				return nil
+			}
+			if err := c.copyFile(src, p, dst, target, name); err != nil {
+				return err
+			}
+		case info.Mode()&(fs.ModeDevice|fs.ModeNamedPipe) != 0:
+			if err := copyNode(dst, target, info); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unable to copy %s of type %s: %w", p, info.Mode().Type(), ErrNotSupported)
+		}
+		if !opts.NoMetadata {
+			if err := copyXattrs(src, p, dst, target); err != nil {
+				return err
+			}
+		}
+		switch {
+		case opts.NoMetadata || target == "/":
+		case d.IsDir():
+			// creating the contents would change the times, and a mode might prevent it
+			c.dirs = append(c.dirs, copiedDir{target: target, info: info})
+		default:
+			if err := copyMetadata(dst, target, info); err != nil {
+				return err
+			}
+		}
+		c.update(name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the innermost directories first, so that the times of their parents are not changed again
+	for i := len(c.dirs) - 1; i >= 0; i-- {
+		if err := copyMetadata(dst, c.dirs[i].target, c.dirs[i].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySize is the size of the regular files of the tree that CopyTree copies
+func copySize(src FileSystem, root string, filter func(name string, d fs.DirEntry) bool) (int64, error) {
+	var total int64
+	err := WalkDir(src, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(relativeName(strings.TrimPrefix(p, root)), d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
+
+// copier is the state of a CopyTree
+type copier struct {
+	ctx      context.Context
+	progress util.Progress
+	buf      []byte
+	// done and total bytes of the contents of files, for progress
+	done, total int64
+	// dirs copied, whose metadata is copied last
+	dirs []copiedDir
+	// links are the targets of the regular files copied by the inode of their source in a
+	// LinkFS, for the hard links to them, or nil where src or dst is not a LinkFS
+	links map[uint64]string
+}
+
+// copiedDir is a directory created by CopyTree, with the FileInfo of its source
+type copiedDir struct {
+	target string
+	info   fs.FileInfo
+}
+
+func (c *copier) update(name string) {
+	if c.progress != nil {
+		c.progress.Update(util.PhaseCopy, c.done, c.total, name)
+	}
+}
+
+// link creates the target as a hard link to the copy of the file already copied that the source
+// is a hard link to, replacing the target, and returns whether it did
+func (c *copier) link(src FileSystem, p string, dst FileSystem, target string) (bool, error) {
+	if c.links == nil {
+		return false, nil
+	}
+	ino, err := src.(LinkFS).Inode(p)
+	if err != nil {
+		if unsupported(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error reading inode of %s: %w", p, err)
+	}
+	first, ok := c.links[ino]
+	if !ok {
+		c.links[ino] = target
+		return false, nil
+	}
+	err = dst.Link(first, target)
+	if errors.Is(err, fs.ErrExist) {
+		if err := dst.Remove(target); err != nil {
+			return false, fmt.Errorf("error replacing %s: %w", target, err)
+		}
+		err = dst.Link(first, target)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error creating hard link %s to %s: %w", target, first, err)
+	}
+	return true, nil
+}
+
+// copyNode creates the target as the device or named pipe described by info with Mknod, replacing
+// the target
+func copyNode(dst FileSystem, target string, info fs.FileInfo) error {
+	var dev int
+	if info.Mode()&fs.ModeDevice != 0 {
+		major, minor, ok := DeviceNumbers(info)
+		if !ok {
+			return fmt.Errorf("unable to copy device %s of unknown numbers: %w", target, ErrNotSupported)
+		}
+		dev = Mkdev(major, minor)
+	}
+	mode := UnixMode(info.Mode())
+	err := dst.Mknod(target, mode, dev)
+	if errors.Is(err, fs.ErrExist) {
+		if err := dst.Remove(target); err != nil {
+			return fmt.Errorf("error replacing %s: %w", target, err)
+		}
+		err = dst.Mknod(target, mode, dev)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", target, err)
+	}
+	return nil
+}
+
+// copyFile copies the content of the regular file, replacing the target
+func (c *copier) copyFile(src FileSystem, p string, dst FileSystem, target, name string) error {
+	in, err := src.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", p, err)
+	}
+	defer in.Close()
+	out, err := dst.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", target, err)
+	}
+	for {
+		if err := c.ctx.Err(); err != nil {
+			out.Close()
+			return err
+		}
+		n, err := io.ReadFull(in, c.buf)
+		if n > 0 {
+			if _, err := out.Write(c.buf[:n]); err != nil {
+				out.Close()
+				return fmt.Errorf("error writing %s: %w", target, err)
+			}
+			c.done += int64(n)
+			if n == len(c.buf) {
+				c.update(name)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error closing %s: %w", target, err)
+	}
+	return nil
+}
+
+// copyMetadata sets the mode, owner and modification time of the source on the target, if the
+// destination supports them
+func copyMetadata(dst FileSystem, target string, info fs.FileInfo) error {
+	if err := dst.Chmod(target, info.Mode()); err != nil && !unsupported(err) {
+		return fmt.Errorf("error changing mode of %s: %w", target, err)
+	}
+	if o, ok := info.Sys().(owner); ok {
+		if err := dst.Chown(target, int(o.UID()), int(o.GID())); err != nil && !unsupported(err) {
+			return fmt.Errorf("error changing owner of %s: %w", target, err)
+		}
+	}
+	if c, ok := dst.(ChtimesFS); ok {
+		if err := c.Chtimes(target, time.Time{}, info.ModTime()); err != nil && !unsupported(err) {
+			return fmt.Errorf("error changing times of %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+// copyXattrs sets the extended attributes of the source on the target, if the destination
+// supports them
+func copyXattrs(src FileSystem, p string, dst FileSystem, target string) error {
+	xattrs, err := Xattrs(src, p)
+	if err != nil {
+		return fmt.Errorf("error reading extended attributes of %s: %w", p, err)
+	}
+	if err := SetXattrs(dst, target, xattrs); err != nil && !unsupported(err) {
+		return fmt.Errorf("error setting extended attributes of %s: %w", target, err)
+	}
+	return nil
+}
+
+func unsupported(err error) bool {
+	return errors.Is(err, ErrNotSupported) || errors.Is(err, ErrNotImplemented)
+}
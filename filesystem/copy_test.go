@@ -0,0 +1,149 @@
+package filesystem_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const size = 20 * 1024 * 1024
+
+func createFat32(t *testing.T) filesystem.FileSystem {
+	t.Helper()
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fat32.Create(b, size, 0, 512, "copy")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	return f
+}
+
+func TestCopyTree(t *testing.T) {
+	src := createFat32(t)
+	if err := fstest.Write(src, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("whole tree", func(t *testing.T) {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := squashfs.Create(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		var (
+			copied      []string
+			done, total int64
+		)
+		progress := func(phase string, d, n int64, name string) {
+			if phase != util.PhaseCopy || d < done {
+				t.Errorf("progress %s %d/%d after %d", phase, d, n, done)
+			}
+			copied = append(copied, name)
+			done, total = d, n
+		}
+		if err := filesystem.CopyTree(context.Background(), src, "/", dst, "/", filesystem.CopyOptions{Progress: util.ProgressFunc(progress)}); err != nil {
+			t.Fatalf("error copying: %v", err)
+		}
+		if err := dst.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		if err := fstest.TestFS(read, fstest.Tree.Names()...); err != nil {
+			t.Fatal(err)
+		}
+		var expected int64
+		for _, content := range fstest.Tree {
+			expected += int64(len(content))
+		}
+		if done != expected || total != expected {
+			t.Errorf("progress reported %d/%d bytes instead of %d", done, total, expected)
+		}
+		want := []string{".", "DIR1", "DIR1/FILE1.TXT", "DIR1/SUB", "DIR1/SUB/DEEP.BIN", "DIR1/SUB/SUBSUB", "DIR1/SUB/SUBSUB/LEAF.X",
+			"DIR2", "DIR3", "DIR3/LARGE.BIN", "EMPTY.TXT", "README.TXT"}
+		if !slices.Equal(copied, want) {
+			t.Errorf("progress reported %v instead of %v", copied, want)
+		}
+	})
+
+	t.Run("filtered subtree", func(t *testing.T) {
+		dst := createFat32(t)
+		filter := func(name string, _ fs.DirEntry) bool {
+			return name != "SUB/SUBSUB"
+		}
+		if err := filesystem.CopyTree(context.Background(), src, "DIR1", dst, "/COPY/DIR", filesystem.CopyOptions{Filter: filter}); err != nil {
+			t.Fatalf("error copying: %v", err)
+		}
+		expected := fstest.Files{
+			"COPY/DIR/FILE1.TXT":    fstest.Tree["DIR1/FILE1.TXT"],
+			"COPY/DIR/SUB/DEEP.BIN": fstest.Tree["DIR1/SUB/DEEP.BIN"],
+		}
+		if err := fstest.TestFS(dst, expected.Names()...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Stat("COPY/DIR/SUB/SUBSUB"); err == nil {
+			t.Errorf("filtered directory copied")
+		}
+		for name, content := range expected {
+			if b, err := dst.ReadFile(name); err != nil || string(b) != content {
+				t.Errorf("%s: read %d bytes, %v instead of %d", name, len(b), err, len(content))
+			}
+		}
+	})
+
+	t.Run("single file", func(t *testing.T) {
+		dst := createFat32(t)
+		if err := fstest.Write(dst, fstest.Files{"README.TXT": "to be replaced"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := filesystem.CopyTree(context.Background(), src, "DIR3/LARGE.BIN", dst, "README.TXT", filesystem.CopyOptions{}); err != nil {
+			t.Fatalf("error copying: %v", err)
+		}
+		if b, err := dst.ReadFile("README.TXT"); err != nil || string(b) != fstest.Tree["DIR3/LARGE.BIN"] {
+			t.Errorf("read %d bytes, %v instead of %d", len(b), err, len(fstest.Tree["DIR3/LARGE.BIN"]))
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		dst := createFat32(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		progress := func(_ string, _, _ int64, name string) {
+			if strings.HasPrefix(name, "DIR1") {
+				cancel()
+			}
+		}
+		err := filesystem.CopyTree(ctx, src, "/", dst, "/", filesystem.CopyOptions{Progress: util.ProgressFunc(progress)})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error %v instead of %v", err, context.Canceled)
+		}
+		if _, err := dst.Stat("DIR2"); err == nil {
+			t.Errorf("copy continued after it was canceled")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		dst := createFat32(t)
+		err := filesystem.CopyTree(context.Background(), src, "MISSING", dst, "/", filesystem.CopyOptions{})
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("error %v instead of %v", err, fs.ErrNotExist)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package filesystem
+
+import "io/fs"
+
+// Device is implemented by the Sys of the FileInfo of the device files of the filesystems that
+// keep their numbers, see DeviceNumbers
+type Device interface {
+	Major() uint32
+	Minor() uint32
+}
+
+// the type bits of the mode of files on Linux, as passed to Mknod
+const (
+	modeFifo    = 0o010000
+	modeChar    = 0o020000
+	modeDir     = 0o040000
+	modeBlock   = 0o060000
+	modeRegular = 0o100000
+	modeSymlink = 0o120000
+	modeSocket  = 0o140000
+)
+
+// DeviceNumbers returns the major and minor numbers of the character or block device described by
+// info, from its Sys, which is a Device for the filesystems of this module, or the stat structure of
+// the files of the local filesystem on Unix, such as those of the workspace of squashfs. ok is
+// false for other files, and devices whose numbers are unknown.
+func DeviceNumbers(info fs.FileInfo) (major, minor uint32, ok bool) {
+	if info.Mode()&fs.ModeDevice == 0 {
+		return 0, 0, false
+	}
+	if d, ok := info.Sys().(Device); ok {
+		return d.Major(), d.Minor(), true
+	}
+	return osDeviceNumbers(info)
+}
+
+// Mkdev encodes the major and minor numbers of a device like Linux does for dev_t, as passed to
+// Mknod
+func Mkdev(major, minor uint32) int {
+	return int(uint64(minor&0xff) | uint64(major&0xfff)<<8 | uint64(minor&^0xff)<<12 | uint64(major&^0xfff)<<32)
+}
+
+// SplitDev returns the major and minor numbers of a device encoded by Mkdev
+func SplitDev(dev int) (major, minor uint32) {
+	d := uint64(dev)
+	major = uint32((d>>8)&0xfff | (d>>32)&^0xfff)
+	minor = uint32(d&0xff | (d>>12)&^0xff)
+	return major, minor
+}
+
+// UnixMode returns the mode of Linux of a file of mode, with its type bits, permissions, and
+// setuid, setgid and sticky bits, as passed to Mknod
+func UnixMode(mode fs.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		m |= modeChar
+	case mode&fs.ModeDevice != 0:
+		m |= modeBlock
+	case mode&fs.ModeNamedPipe != 0:
+		m |= modeFifo
+	case mode&fs.ModeSocket != 0:
+		m |= modeSocket
+	case mode&fs.ModeSymlink != 0:
+		m |= modeSymlink
+	case mode.IsDir():
+		m |= modeDir
+	default:
+		m |= modeRegular
+	}
+	if mode&fs.ModeSetuid != 0 {
+		m |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= 0o1000
+	}
+	return m
+}
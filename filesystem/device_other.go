@@ -0,0 +1,11 @@
+//go:build !unix
+
+package filesystem
+
+import "io/fs"
+
+// osDeviceNumbers returns the numbers of the device file of the local filesystem described by info,
+// which are not known on this platform
+func osDeviceNumbers(_ fs.FileInfo) (major, minor uint32, ok bool) {
+	return 0, 0, false
+}
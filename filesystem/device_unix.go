@@ -0,0 +1,20 @@
+//go:build unix
+
+package filesystem
+
+import (
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// osDeviceNumbers returns the numbers of the device file of the local filesystem described by info
+func osDeviceNumbers(info fs.FileInfo) (major, minor uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	//nolint:unconvert,nolintlint // Rdev is not an uint64 on every Unix
+	return unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev)), true
+}
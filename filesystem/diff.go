@@ -0,0 +1,195 @@
+package filesystem
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Change is what differs between two versions of a file, as found by Diff
+type Change string
+
+// changes of the files modified between two filesystems
+const (
+	// ChangeType is a file replaced by one of a different type, e.g. a directory by a regular file.
+	// Nothing else is compared.
+	ChangeType Change = "type"
+	// ChangeSize is a regular file or symbolic link of a different size
+	ChangeSize Change = "size"
+	// ChangeContent is a regular file whose contents have a different SHA-256 hash
+	ChangeContent Change = "content"
+	// ChangeTarget is a symbolic link to a different target
+	ChangeTarget Change = "target"
+	// ChangeMode is a file with different permissions, or setuid, setgid and sticky bits
+	ChangeMode Change = "mode"
+	// ChangeOwner is a file of a different owner or group, where both filesystems keep them
+	ChangeOwner Change = "owner"
+	// ChangeModTime is a file with a different modification time, where both filesystems keep
+	// them
+	ChangeModTime Change = "mtime"
+)
+
+// Modification is a file found in both filesystems compared by Diff, that differs between them
+type Modification struct {
+	// Path is the absolute path of the file
+	Path string `json:"path"`
+	// Changes are what differs, in the order of the Change constants
+	Changes []Change `json:"changes"`
+}
+
+// String formats the modification on one line, e.g. "/a/b: content, mtime"
+func (m Modification) String() string {
+	changes := make([]string, len(m.Changes))
+	for i, c := range m.Changes {
+		changes[i] = string(c)
+	}
+	return fmt.Sprintf("%s: %s", m.Path, strings.Join(changes, ", "))
+}
+
+// Differences are the differences between two filesystems found by Diff, with the absolute paths
+// of the files sorted. The contents of directories added or removed are listed as well.
+type Differences struct {
+	// Added are the files only in the second filesystem
+	Added []string `json:"added"`
+	// Removed are the files only in the first filesystem
+	Removed []string `json:"removed"`
+	// Modified are the files in both filesystems that differ
+	Modified []Modification `json:"modified"`
+}
+
+// Empty returns whether no difference was found
+func (d *Differences) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Diff compares the trees of files of a and b, e.g. two versions of an image to verify an
+// update, or two builds that should be reproducible. Files are matched by path, and compared by
+// type, metadata and, for regular files of the same size, the SHA-256 hash of their contents. The
+// sizes of directories, which depend on the layout of each filesystem, are not compared.
+//
+// Metadata that only one of the filesystems keeps is not compared, but that of filesystems of
+// different types may still differ in ways that do not matter to the caller, e.g. modification
+// times rounded to 2 seconds by FAT32, which can be ignored by the Changes of each Modification.
+func Diff(a, b FileSystem) (*Differences, error) {
+	infosA, err := diffTree(a)
+	if err != nil {
+		return nil, fmt.Errorf("error reading first filesystem: %w", err)
+	}
+	infosB, err := diffTree(b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading second filesystem: %w", err)
+	}
+	d := &Differences{}
+	for _, p := range sortedPaths(infosA) {
+		if _, ok := infosB[p]; !ok {
+			d.Removed = append(d.Removed, p)
+		}
+	}
+	for _, p := range sortedPaths(infosB) {
+		infoA, ok := infosA[p]
+		if !ok {
+			d.Added = append(d.Added, p)
+			continue
+		}
+		changes, err := diffFile(a, b, p, infoA, infosB[p])
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			d.Modified = append(d.Modified, Modification{Path: p, Changes: changes})
+		}
+	}
+	return d, nil
+}
+
+// diffTree describes every file of the filesystem by its absolute path
+func diffTree(f FileSystem) (map[string]fs.FileInfo, error) {
+	infos := map[string]fs.FileInfo{}
+	err := WalkDir(f, "/", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		infos[p] = info
+		return nil
+	})
+	return infos, err
+}
+
+func sortedPaths(infos map[string]fs.FileInfo) []string {
+	paths := make([]string, 0, len(infos))
+	for p := range infos {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// diffFile returns what differs between the file at p in a, described by infoA, and in b
+func diffFile(a, b FileSystem, p string, infoA, infoB fs.FileInfo) ([]Change, error) {
+	if infoA.Mode().Type() != infoB.Mode().Type() {
+		return []Change{ChangeType}, nil
+	}
+	var changes []Change
+	if !infoA.IsDir() && infoA.Size() != infoB.Size() {
+		changes = append(changes, ChangeSize)
+	}
+	switch {
+	case infoA.Mode().IsRegular():
+		same := false
+		if infoA.Size() == infoB.Size() {
+			hashA, err := hashFile(a, p)
+			if err != nil {
+				return nil, err
+			}
+			hashB, err := hashFile(b, p)
+			if err != nil {
+				return nil, err
+			}
+			same = bytes.Equal(hashA, hashB)
+		}
+		if !same {
+			changes = append(changes, ChangeContent)
+		}
+	case infoA.Mode()&fs.ModeSymlink != 0:
+		targetA, errA := Readlink(a, p)
+		targetB, errB := Readlink(b, p)
+		if errA == nil && errB == nil && targetA != targetB {
+			changes = append(changes, ChangeTarget)
+		}
+	}
+	if infoA.Mode()&^fs.ModeType != infoB.Mode()&^fs.ModeType {
+		changes = append(changes, ChangeMode)
+	}
+	ownerA, okA := infoA.Sys().(owner)
+	ownerB, okB := infoB.Sys().(owner)
+	if okA && okB && (ownerA.UID() != ownerB.UID() || ownerA.GID() != ownerB.GID()) {
+		changes = append(changes, ChangeOwner)
+	}
+	if mtimeA, mtimeB := infoA.ModTime(), infoB.ModTime(); !mtimeA.IsZero() && !mtimeB.IsZero() && !mtimeA.Equal(mtimeB) {
+		changes = append(changes, ChangeModTime)
+	}
+	return changes, nil
+}
+
+// hashFile returns the SHA-256 hash of the contents of the regular file at p
+func hashFile(f FileSystem, p string) ([]byte, error) {
+	file, err := f.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", p, err)
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,77 @@
+package filesystem_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+)
+
+func TestDiff(t *testing.T) {
+	a := createFat32(t)
+	if err := fstest.Write(a, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	b := createFat32(t)
+	if err := filesystem.CopyTree(context.Background(), a, "/", b, "/", filesystem.CopyOptions{}); err != nil {
+		t.Fatalf("error copying tree: %v", err)
+	}
+	d, err := filesystem.Diff(a, b)
+	if err != nil {
+		t.Fatalf("error comparing filesystems: %v", err)
+	}
+	if !d.Empty() {
+		t.Errorf("differences %+v in a copy", d)
+	}
+
+	// same size, other contents
+	if err := fstest.Write(b, fstest.Files{"README.TXT": "DISKFS TEST TREE\n", "EMPTY.TXT": "full", "DIR4/NEW.TXT": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove("/DIR2"); err != nil {
+		t.Fatal(err)
+	}
+	d, err = filesystem.Diff(a, b)
+	if err != nil {
+		t.Fatalf("error comparing filesystems: %v", err)
+	}
+	if expected := []string{"/DIR4", "/DIR4/NEW.TXT"}; !slices.Equal(d.Added, expected) {
+		t.Errorf("added %v instead of %v", d.Added, expected)
+	}
+	if expected := []string{"/DIR2"}; !slices.Equal(d.Removed, expected) {
+		t.Errorf("removed %v instead of %v", d.Removed, expected)
+	}
+	expected := []filesystem.Modification{
+		{Path: "/EMPTY.TXT", Changes: []filesystem.Change{filesystem.ChangeSize, filesystem.ChangeContent}},
+		{Path: "/README.TXT", Changes: []filesystem.Change{filesystem.ChangeContent}},
+	}
+	// the files written again may have another modification time as well
+	modified := slices.Clone(d.Modified)
+	for i := range modified {
+		modified[i].Changes = slices.DeleteFunc(slices.Clone(modified[i].Changes), func(c filesystem.Change) bool { return c == filesystem.ChangeModTime })
+	}
+	if !slices.EqualFunc(modified, expected, func(a, b filesystem.Modification) bool {
+		return a.Path == b.Path && slices.Equal(a.Changes, b.Changes)
+	}) {
+		t.Errorf("modified %v instead of %v", d.Modified, expected)
+	}
+
+	t.Run("type", func(t *testing.T) {
+		c := createFat32(t)
+		if err := fstest.Write(c, fstest.Files{"DIR1": "not a directory"}); err != nil {
+			t.Fatal(err)
+		}
+		d, err := filesystem.Diff(a, c)
+		if err != nil {
+			t.Fatalf("error comparing filesystems: %v", err)
+		}
+		if i := slices.IndexFunc(d.Modified, func(m filesystem.Modification) bool { return m.Path == "/DIR1" }); i < 0 || !slices.Equal(d.Modified[i].Changes, []filesystem.Change{filesystem.ChangeType}) {
+			t.Errorf("modified %v instead of the type of /DIR1", d.Modified)
+		}
+		if !slices.Contains(d.Removed, "/DIR1/SUB/DEEP.BIN") {
+			t.Errorf("removed %v without the contents of /DIR1", d.Removed)
+		}
+	})
+}
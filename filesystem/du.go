@@ -0,0 +1,113 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// BlockSizeFS is implemented by the filesystems that allocate the space of files in blocks, or
+// clusters, of a fixed size, see Du
+type BlockSizeFS interface {
+	// BlockSize returns the size in bytes of the blocks allocated to files
+	BlockSize() int64
+}
+
+// DiskUsage is the disk usage of a tree of files, as found by Du
+type DiskUsage struct {
+	// Path is the name of the directory at the root of the tree, in the form of the root of Du
+	Path string
+	// Size is the apparent size of the tree, the sum of the sizes of its files, directories and
+	// symbolic links
+	Size int64
+	// Allocated estimates the space allocated to the tree, with the size of each file and
+	// directory rounded up to the blocks of the filesystem. It is the same as Size where the
+	// filesystem is not a BlockSizeFS, e.g. squashfs, which packs and compresses files.
+	Allocated int64
+	// Files, Dirs, Symlinks and Others count the entries of the tree by type, including its root
+	// in Dirs. Others are devices, pipes and sockets.
+	Files, Dirs, Symlinks, Others int64
+	// Subdirs are the usages of the directories of the tree, sorted by name
+	Subdirs []*DiskUsage
+}
+
+// add adds the usage of a subtree
+func (u *DiskUsage) add(sub *DiskUsage) {
+	u.Size += sub.Size
+	u.Allocated += sub.Allocated
+	u.Files += sub.Files
+	u.Dirs += sub.Dirs
+	u.Symlinks += sub.Symlinks
+	u.Others += sub.Others
+}
+
+// Du returns the disk usage of the tree of files at root, like du, with that of every directory
+// in it, e.g. to check that a tree will fit in a partition before copying it. Files with several
+// hard links are counted for each of them. The root is in the form of io/fs, or an absolute path.
+func Du(f FileSystem, root string) (*DiskUsage, error) {
+	info, err := Lstat(f, root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", root, err)
+	}
+	var blockSize int64
+	if b, ok := f.(BlockSizeFS); ok {
+		blockSize = b.BlockSize()
+	}
+	u := &DiskUsage{Path: root}
+	if !info.IsDir() {
+		u.count(info, blockSize)
+		return u, nil
+	}
+	if err := du(f, root, info, blockSize, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// du adds to u the usage of the directory at name, described by info
+func du(f FileSystem, name string, info fs.FileInfo, blockSize int64, u *DiskUsage) error {
+	u.count(info, blockSize)
+	entries, err := f.ReadDir(AbsolutePath(name))
+	if err != nil {
+		return fmt.Errorf("error reading directory %s: %w", name, err)
+	}
+	for _, e := range entries {
+		p := path.Join(name, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		if !e.IsDir() {
+			u.count(info, blockSize)
+			continue
+		}
+		sub := &DiskUsage{Path: p}
+		if err := du(f, p, info, blockSize, sub); err != nil {
+			return err
+		}
+		u.add(sub)
+		u.Subdirs = append(u.Subdirs, sub)
+	}
+	return nil
+}
+
+// count adds the file described by info, without its contents if it is a directory
+func (u *DiskUsage) count(info fs.FileInfo, blockSize int64) {
+	size := info.Size()
+	u.Size += size
+	switch {
+	case info.IsDir():
+		u.Dirs++
+	case info.Mode().IsRegular():
+		u.Files++
+	case info.Mode()&fs.ModeSymlink != 0:
+		u.Symlinks++
+	default:
+		u.Others++
+	}
+	// the targets of links are usually kept with their entries or inodes, without blocks
+	if blockSize > 0 && (info.IsDir() || info.Mode().IsRegular()) {
+		size = (size + blockSize - 1) / blockSize * blockSize
+	}
+	u.Allocated += size
+}
@@ -0,0 +1,48 @@
+package filesystem_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+)
+
+func TestDu(t *testing.T) {
+	f := createFat32(t)
+	if err := fstest.Write(f, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	blockSize := f.(filesystem.BlockSizeFS).BlockSize()
+	var size, allocated, dir1 int64
+	for name, content := range fstest.Tree {
+		n := int64(len(content))
+		size += n
+		allocated += (n + blockSize - 1) / blockSize * blockSize
+		if strings.HasPrefix(name, "DIR1/") {
+			dir1 += n
+		}
+	}
+
+	u, err := filesystem.Du(f, "/")
+	if err != nil {
+		t.Fatalf("error finding usage: %v", err)
+	}
+	if u.Size != size || u.Allocated != allocated || u.Files != 6 || u.Dirs != 6 || u.Symlinks != 0 {
+		t.Errorf("usage %+v instead of %d bytes, %d allocated, 6 files and 6 directories", u, size, allocated)
+	}
+	if len(u.Subdirs) != 3 || u.Subdirs[0].Path != "/DIR1" || u.Subdirs[0].Size != dir1 || u.Subdirs[0].Dirs != 3 {
+		t.Fatalf("usage of directories %+v", u.Subdirs)
+	}
+	if sub := u.Subdirs[0].Subdirs; len(sub) != 1 || sub[0].Path != "/DIR1/SUB" || len(sub[0].Subdirs) != 1 {
+		t.Errorf("usage of DIR1 %+v", sub)
+	}
+
+	u, err = filesystem.Du(f, "DIR3/LARGE.BIN")
+	if err != nil {
+		t.Fatalf("error finding usage: %v", err)
+	}
+	if n := int64(len(fstest.Tree["DIR3/LARGE.BIN"])); u.Size != n || u.Files != 1 || len(u.Subdirs) != 0 {
+		t.Errorf("usage %+v of file instead of %d bytes", u, n)
+	}
+}
@@ -0,0 +1,92 @@
+package filesystem_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+type errorTest struct {
+	op       string
+	call     func() error
+	expected error
+}
+
+func TestErrors(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sq, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(sq, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := sq.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	workspace, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(workspace, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	fat := createFat32(t)
+	if err := fstest.Write(fat, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, f := range map[string]filesystem.FileSystem{"fat32": fat, "squashfs": read, "workspace": workspace} {
+		t.Run(name, func(t *testing.T) {
+			tests := []errorTest{
+				{"open missing", func() error { _, err := f.OpenFile("/MISSING.TXT", os.O_RDONLY); return err }, fs.ErrNotExist},
+				{"open in missing directory", func() error { _, err := f.OpenFile("/MISSING/FILE.TXT", os.O_RDONLY); return err }, fs.ErrNotExist},
+				{"open directory", func() error { _, err := f.OpenFile("/DIR1/SUB", os.O_RDONLY); return err }, filesystem.ErrIsDir},
+				{"read missing directory", func() error { _, err := f.ReadDir("/MISSING"); return err }, fs.ErrNotExist},
+				{"stat missing", func() error { _, err := f.Stat("MISSING"); return err }, fs.ErrNotExist},
+				{"read directory", func() error { _, err := f.ReadFile("DIR1"); return err }, filesystem.ErrIsDir},
+			}
+			if f != read {
+				tests = append(tests, []errorTest{
+					{"remove non-empty directory", func() error { return f.Remove("/DIR1") }, filesystem.ErrNotEmpty},
+					{"remove missing", func() error { return f.Remove("/MISSING.TXT") }, fs.ErrNotExist},
+					{"create existing", func() error {
+						_, err := f.OpenFile("/README.TXT", os.O_CREATE|os.O_EXCL|os.O_RDWR)
+						return err
+					}, fs.ErrExist},
+					{"mkdir under file", func() error { return f.Mkdir("/README.TXT/SUB") }, filesystem.ErrNotDir},
+				}...)
+			} else {
+				tests = append(tests, errorTest{"mkdir", func() error { return f.Mkdir("/NEW") }, fs.ErrPermission})
+			}
+			for _, tt := range tests {
+				if err := tt.call(); !errors.Is(err, tt.expected) {
+					t.Errorf("%s: error %v instead of %v", tt.op, err, tt.expected)
+				}
+			}
+		})
+	}
+
+	t.Run("no space", func(t *testing.T) {
+		f, err := createFat32(t).OpenFile("/LARGE.BIN", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write(make([]byte, size)); !errors.Is(err, filesystem.ErrNoSpace) {
+			t.Errorf("error %v instead of %v", err, filesystem.ErrNoSpace)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package ext4
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// DefaultCacheSize is the size of the cache of metadata blocks of a filesystem, see SetCacheSize
+const DefaultCacheSize = 8 * 1024 * 1024
+
+// blockCache is a least recently used cache of metadata blocks: inode tables, extent tree nodes,
+// directories, symbolic link targets and extended attributes. File data is not cached. The group
+// descriptors are read once, with the superblock, and kept.
+type blockCache struct {
+	// mu guards the cache, but not reads of the backend
+	mu        sync.Mutex
+	maxBlocks int
+	lru       *list.List
+	blocks    map[uint64]*list.Element
+	// generation changes with every write, so that blocks read while a write happens are not cached
+	generation uint64
+}
+
+type cachedBlock struct {
+	number uint64
+	data   []byte
+}
+
+// newBlockCache returns a cache of up to maxBlocks blocks, none if 0
+func newBlockCache(maxBlocks int) *blockCache {
+	return &blockCache{
+		maxBlocks: maxBlocks,
+		lru:       list.New(),
+		blocks:    map[uint64]*list.Element{},
+	}
+}
+
+// get returns the cached block, moving it to the front, and the generation to add it with if not
+func (c *blockCache) get(number uint64) (data []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.blocks[number]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedBlock).data, c.generation
+	}
+	return nil, c.generation
+}
+
+// add caches a block read in the generation, unless it was written since
+func (c *blockCache) add(number uint64, data []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation || c.maxBlocks == 0 {
+		return
+	}
+	if e, ok := c.blocks[number]; ok {
+		e.Value.(*cachedBlock).data = data
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.blocks[number] = c.lru.PushFront(&cachedBlock{number: number, data: data})
+	c.trim()
+}
+
+// trim drops the least recently used blocks beyond maxBlocks
+func (c *blockCache) trim() {
+	for c.lru.Len() > c.maxBlocks {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.blocks, e.Value.(*cachedBlock).number)
+	}
+}
+
+// setMaxBlocks sets the number of blocks of the cache, dropping those beyond it
+func (c *blockCache) setMaxBlocks(maxBlocks int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBlocks = maxBlocks
+	c.trim()
+}
+
+// invalidate drops the cached blocks of blockSize bytes overlapping a write
+func (c *blockCache) invalidate(off, length, blockSize int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if length <= 0 || off < 0 {
+		return
+	}
+	for i := off / blockSize; i <= (off+length-1)/blockSize; i++ {
+		if e, ok := c.blocks[uint64(i)]; ok {
+			c.lru.Remove(e)
+			delete(c.blocks, uint64(i))
+		}
+	}
+}
+
+// SetCacheSize sets the maximum memory used by the cache of metadata blocks to cacheSize bytes,
+// DefaultCacheSize by default. The cache saves reading the same inode tables, extent tree nodes
+// and directories again and again, e.g. when walking the tree or extracting the files of a large
+// filesystem on a slow backend. If this is <= 0 then the cache will be disabled.
+func (fs *FileSystem) SetCacheSize(cacheSize int) {
+	blocks := 0
+	if cacheSize > 0 {
+		blocks = cacheSize / int(fs.superblock.blockSize)
+	}
+	fs.cache.setMaxBlocks(blocks)
+}
+
+// GetCacheSize get the maximum memory used by the cache of metadata blocks in bytes
+func (fs *FileSystem) GetCacheSize() int {
+	fs.cache.mu.Lock()
+	defer fs.cache.mu.Unlock()
+	return fs.cache.maxBlocks * int(fs.superblock.blockSize)
+}
+
+// readBlocks reads count blocks from blockNumber through the cache, all of them from the backend
+// in one read unless they are all cached
+func (fs *FileSystem) readBlocks(blockNumber, count uint64) ([]byte, error) {
+	blockSize := uint64(fs.superblock.blockSize)
+	b := make([]byte, 0, count*blockSize)
+	for i := uint64(0); i < count; i++ {
+		data, _ := fs.cache.get(blockNumber + i)
+		if data == nil {
+			break
+		}
+		b = append(b, data...)
+	}
+	if uint64(len(b)) == count*blockSize {
+		return b, nil
+	}
+
+	_, generation := fs.cache.get(blockNumber)
+	b = b[:count*blockSize]
+	read, err := fs.backend.ReadAt(b, int64(blockNumber*blockSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %d blocks from block %d: %w", count, blockNumber, err)
+	}
+	if read != len(b) {
+		return nil, fmt.Errorf("read %d bytes for %d blocks from block %d instead of %d", read, count, blockNumber, len(b))
+	}
+	for i := uint64(0); i < count; i++ {
+		// each block its own copy, so that callers may change what they get
+		fs.cache.add(blockNumber+i, append([]byte{}, b[i*blockSize:(i+1)*blockSize]...), generation)
+	}
+	return b, nil
+}
+
+// cachedWritable writes to the backend, dropping the blocks it writes from the cache
+type cachedWritable struct {
+	backend.WritableFile
+	cache     *blockCache
+	blockSize int64
+}
+
+func (w *cachedWritable) WriteAt(p []byte, off int64) (int, error) {
+	defer w.cache.invalidate(off, int64(len(p)), w.blockSize)
+	return w.WritableFile.WriteAt(p, off)
+}
+
+// writableBackend returns the backend of the filesystem to write to, keeping the cache up to date
+func (fs *FileSystem) writableBackend() (backend.WritableFile, error) {
+	w, err := writableBackend(fs.backend)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedWritable{WritableFile: w, cache: fs.cache, blockSize: int64(fs.superblock.blockSize)}, nil
+}
@@ -0,0 +1,72 @@
+package ext4
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+func TestCache(t *testing.T) {
+	outfile := testCreateImgCopy(t)
+	f, err := os.OpenFile(outfile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	b := backend.WithLogger(file.New(f, false), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fs, err := Read(b, 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	if fs.GetCacheSize() != DefaultCacheSize {
+		t.Errorf("cache of %d bytes instead of %d", fs.GetCacheSize(), DefaultCacheSize)
+	}
+	names := func() []string {
+		entries, err := fs.ReadDir("/")
+		if err != nil {
+			t.Fatalf("Error reading directory: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+	reads := func() int64 {
+		stats, _ := backend.Stats(b)
+		return stats.Reads
+	}
+
+	// the second time, the inodes and directories come from the cache
+	names()
+	before := reads()
+	names()
+	if n := reads() - before; n != 0 {
+		t.Errorf("%d reads of cached directory", n)
+	}
+
+	// writes drop what they change from the cache
+	if err := fs.Mkdir("/cached"); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if !slices.Contains(names(), "cached") {
+		t.Errorf("new directory not in %v", names())
+	}
+
+	fs.SetCacheSize(0)
+	if fs.GetCacheSize() != 0 {
+		t.Errorf("cache of %d bytes after disabling it", fs.GetCacheSize())
+	}
+	names()
+	before = reads()
+	names()
+	if reads() == before {
+		t.Errorf("no reads of directory without cache")
+	}
+}
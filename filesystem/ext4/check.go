@@ -0,0 +1,214 @@
+package ext4
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// codes of the findings of Check, beyond those of filesystem.GenericCheck
+const (
+	// CodeInodeUnallocated is a directory entry for an inode that is free in the inode bitmap
+	CodeInodeUnallocated = "ext4-inode-unallocated"
+	// CodeLinkCount is an inode whose count of hard links is not the number of entries for it
+	CodeLinkCount = "ext4-link-count"
+	// CodeParentInvalid is a ".." entry that is not the parent of its directory
+	CodeParentInvalid = "ext4-parent-invalid"
+	// CodeFreeBlocks is a count of free blocks of a block group that is not that of its bitmap
+	CodeFreeBlocks = "ext4-free-blocks"
+	// CodeFreeInodes is a count of free inodes of a block group that is not that of its bitmap
+	CodeFreeInodes = "ext4-free-inodes"
+	// CodeFreeCount is a count of free blocks or inodes in the superblock that is not the sum of
+	// those of the block groups, which the kernel recomputes when mounting
+	CodeFreeCount = "ext4-free-count"
+)
+
+// checker holds the state of Check while it walks the directories
+type checker struct {
+	fs       *FileSystem
+	findings []filesystem.Finding
+	// inodeBitmaps are the inode bitmaps read so far, by block group
+	inodeBitmaps map[int]*util.Bitmap
+	// links are the number of entries found for each inode, and subdirs the number of
+	// subdirectories of each directory
+	links, subdirs map[uint32]int
+	// paths are the first path found for each inode
+	paths map[uint32]string
+}
+
+// Check checks that every inode in a directory is allocated and has as many hard links as
+// entries, and that the counts of free blocks and inodes agree with the bitmaps, after the checks
+// of filesystem.GenericCheck, for filesystem.Checker. Block groups with uninitialized bitmaps
+// are not checked against them.
+func (fs *FileSystem) Check(opts filesystem.CheckOptions) ([]filesystem.Finding, error) {
+	findings, err := filesystem.GenericCheck(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		fs:           fs,
+		findings:     findings,
+		inodeBitmaps: map[int]*util.Bitmap{},
+		links:        map[uint32]int{},
+		subdirs:      map[uint32]int{},
+		paths:        map[uint32]string{rootInode: "/"},
+	}
+	// the root is its own parent
+	c.links[rootInode] = 1
+	c.directory("/", rootInode, rootInode)
+	for number, count := range c.links {
+		in, err := fs.readInode(number)
+		if err != nil {
+			// already found by GenericCheck
+			continue
+		}
+		expected := count
+		if in.fileType == fileTypeDirectory {
+			// "." and the ".." of each subdirectory, with 1 for directories with too many to count
+			expected = count + 1 + c.subdirs[number]
+			if in.hardLinks == 1 && expected > 1 {
+				continue
+			}
+		}
+		if int(in.hardLinks) != expected {
+			c.add(filesystem.SeverityWarning, CodeLinkCount, c.paths[number], "inode %d has %d links instead of %d", number, in.hardLinks, expected)
+		}
+	}
+	if err := c.groups(); err != nil {
+		return nil, err
+	}
+	return c.findings, nil
+}
+
+// add adds a finding
+func (c *checker) add(severity filesystem.Severity, code, p, format string, args ...any) {
+	c.findings = append(c.findings, filesystem.Finding{Severity: severity, Code: code, Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// allocated returns whether the inode is set in the inode bitmap of its block group
+func (c *checker) allocated(number uint32) (bool, error) {
+	inodesPerGroup := c.fs.superblock.inodesPerGroup
+	group := blockGroupForInode(int(number), inodesPerGroup)
+	if group >= len(c.fs.groupDescriptors.descriptors) {
+		return false, nil
+	}
+	if c.fs.groupDescriptors.descriptors[group].flags.inodesUninitialized {
+		return false, nil
+	}
+	bm, ok := c.inodeBitmaps[group]
+	if !ok {
+		var err error
+		if bm, err = c.fs.readInodeBitmap(group); err != nil {
+			return false, err
+		}
+		c.inodeBitmaps[group] = bm
+	}
+	return bm.IsSet(int((number - 1) % inodesPerGroup))
+}
+
+// directory checks the entries of the directory at p, with the inode number, and those of its
+// subdirectories
+func (c *checker) directory(p string, number, parent uint32) {
+	entries, err := c.fs.readDirectory(number)
+	if err != nil {
+		// already found by GenericCheck
+		return
+	}
+	for _, e := range entries {
+		switch {
+		case e.inode == 0 || e.filename == ".":
+			continue
+		case e.filename == "..":
+			if e.inode != parent {
+				c.add(filesystem.SeverityWarning, CodeParentInvalid, p, "parent is inode %d instead of %d", e.inode, parent)
+			}
+			continue
+		}
+		entryPath := path.Join(p, e.filename)
+		ok, err := c.allocated(e.inode)
+		if err != nil {
+			c.add(filesystem.SeverityError, CodeInodeUnallocated, entryPath, "cannot read inode bitmap for inode %d: %v", e.inode, err)
+			continue
+		}
+		if !ok {
+			c.add(filesystem.SeverityError, CodeInodeUnallocated, entryPath, "inode %d is free in the inode bitmap", e.inode)
+			continue
+		}
+		c.links[e.inode]++
+		if _, found := c.paths[e.inode]; found {
+			// a hard link, or a directory already walked that must not be walked again
+			continue
+		}
+		c.paths[e.inode] = entryPath
+		if e.fileType == dirFileTypeDirectory {
+			c.subdirs[number]++
+			c.directory(entryPath, e.inode, number)
+		}
+	}
+}
+
+// groups checks the counts of free blocks and inodes of every block group against their bitmaps,
+// and those of the superblock against their sums
+func (c *checker) groups() error {
+	sb := c.fs.superblock
+	var freeBlocks, freeInodes uint64
+	for i, gd := range c.fs.groupDescriptors.descriptors {
+		freeBlocks += uint64(gd.freeBlocks)
+		freeInodes += uint64(gd.freeInodes)
+		// the last block group may be shorter
+		blocks := uint64(sb.blocksPerGroup)
+		if rest := sb.blockCount - uint64(sb.firstDataBlock) - uint64(i)*uint64(sb.blocksPerGroup); rest < blocks {
+			blocks = rest
+		}
+		if !gd.flags.blockBitmapUninitialized {
+			bm, err := c.fs.readBlockBitmap(i)
+			if err != nil {
+				return fmt.Errorf("could not read block bitmap of block group %d: %w", i, err)
+			}
+			free, err := countFree(bm, int(blocks))
+			if err != nil {
+				return fmt.Errorf("could not read block bitmap of block group %d: %w", i, err)
+			}
+			if free != int(gd.freeBlocks) {
+				c.add(filesystem.SeverityWarning, CodeFreeBlocks, "", "block group %d has %d free blocks instead of %d", i, gd.freeBlocks, free)
+			}
+		}
+		if !gd.flags.inodesUninitialized {
+			bm, err := c.fs.readInodeBitmap(i)
+			if err != nil {
+				return fmt.Errorf("could not read inode bitmap of block group %d: %w", i, err)
+			}
+			free, err := countFree(bm, int(sb.inodesPerGroup))
+			if err != nil {
+				return fmt.Errorf("could not read inode bitmap of block group %d: %w", i, err)
+			}
+			if free != int(gd.freeInodes) {
+				c.add(filesystem.SeverityWarning, CodeFreeInodes, "", "block group %d has %d free inodes instead of %d", i, gd.freeInodes, free)
+			}
+		}
+	}
+	if sb.freeBlocks != freeBlocks {
+		c.add(filesystem.SeverityInfo, CodeFreeCount, "", "superblock has %d free blocks instead of %d", sb.freeBlocks, freeBlocks)
+	}
+	if uint64(sb.freeInodes) != freeInodes {
+		c.add(filesystem.SeverityInfo, CodeFreeCount, "", "superblock has %d free inodes instead of %d", sb.freeInodes, freeInodes)
+	}
+	return nil
+}
+
+// countFree counts the bits not set among the first n of the bitmap
+func countFree(bm *util.Bitmap, n int) (int, error) {
+	var free int
+	for i := 0; i < n; i++ {
+		set, err := bm.IsSet(i)
+		if err != nil {
+			return 0, err
+		}
+		if !set {
+			free++
+		}
+	}
+	return free, nil
+}
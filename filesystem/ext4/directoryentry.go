@@ -3,6 +3,7 @@ package ext4
 import (
 	"encoding/binary"
 	"fmt"
+	"io/fs"
 )
 
 // directoryFileType uses different constants than the file type property in the inode
@@ -23,6 +24,28 @@ const (
 	dirFileTypeSymlink   directoryFileType = 0x7
 )
 
+// fileMode returns the type bits of fs.FileMode for the directory file type
+func (t directoryFileType) fileMode() fs.FileMode {
+	switch t {
+	case dirFileTypeRegular:
+		return 0
+	case dirFileTypeDirectory:
+		return fs.ModeDir
+	case dirFileTypeCharacter:
+		return fs.ModeDevice | fs.ModeCharDevice
+	case dirFileTypeBlock:
+		return fs.ModeDevice
+	case dirFileTypeFifo:
+		return fs.ModeNamedPipe
+	case dirFileTypeSocket:
+		return fs.ModeSocket
+	case dirFileTypeSymlink:
+		return fs.ModeSymlink
+	default:
+		return fs.ModeIrregular
+	}
+}
+
 // directoryEntry is a single directory entry
 type directoryEntry struct {
 	inode    uint32
@@ -134,7 +157,7 @@ func parseDirEntriesLinear(b []byte, withChecksums bool, blocksize, inodeNumber,
 		length := binary.LittleEndian.Uint16(b[i+0x4 : i+0x6])
 		de, err := directoryEntryFromBytes(b[i : i+int(length)])
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse directory entry %d: %v", count, err)
+			return nil, fmt.Errorf("failed to parse directory entry %d: %w", count, err)
 		}
 		entries = append(entries, de)
 		i += int(length)
@@ -174,3 +197,38 @@ func parseDirEntriesHashed(b []byte, depth uint8, node dxNode, blocksize uint32,
 	}
 	return dirEntries, nil
 }
+
+// lazyDirEntry is a fs.DirEntry for WalkDir, made from the directory entry, which reads the inode
+// only when Info is called
+type lazyDirEntry struct {
+	fs    *FileSystem
+	entry *directoryEntry
+	info  fs.FileInfo
+}
+
+// Name returns the name of the file
+func (e *lazyDirEntry) Name() string {
+	return e.entry.filename
+}
+
+// IsDir reports whether the entry describes a directory
+func (e *lazyDirEntry) IsDir() bool {
+	return e.entry.fileType == dirFileTypeDirectory
+}
+
+// Type returns the type bits of the mode, from the file type of the directory entry
+func (e *lazyDirEntry) Type() fs.FileMode {
+	return e.entry.fileType.fileMode()
+}
+
+// Info reads the inode of the entry
+func (e *lazyDirEntry) Info() (fs.FileInfo, error) {
+	if e.info == nil {
+		info, err := e.fs.entryInfo(e.entry)
+		if err != nil {
+			return nil, err
+		}
+		e.info = info
+	}
+	return e.info, nil
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"os"
 	"path"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -48,6 +49,11 @@ const (
 	billion                      int        = 1000 * million
 	firstNonReservedInode        uint32     = 11 // traditional
 
+	// maxSymlinks is the number of symbolic links followed by Stat, like the limit of Linux
+	maxSymlinks = 40
+	// maxHardLinks is the most links an inode has, like the limit of Linux
+	maxHardLinks uint16 = 65000
+
 	minBlockLogSize int = 10 /* 1024 */
 	maxBlockLogSize int = 16 /* 65536 */
 	minBlockSize    int = (1 << minBlockLogSize)
@@ -99,6 +105,7 @@ type FileSystem struct {
 	size             int64
 	start            int64
 	backend          backend.Storage
+	cache            *blockCache
 }
 
 // Equal compare if two filesystems are equal
@@ -159,7 +166,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 	// uuid
 	fsuuid := p.UUID
 	if fsuuid == nil {
-		fsuuid2, _ := uuid.NewRandom()
+		fsuuid2, _ := uuid.NewRandomFromReader(util.Rand)
 		fsuuid = &fsuuid2
 	}
 
@@ -374,7 +381,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 	mflags := defaultMiscFlags
 
 	// generate hash seed
-	hashSeed, _ := uuid.NewRandom()
+	hashSeed, _ := uuid.NewRandomFromReader(util.Rand)
 	hashSeedBytes := hashSeed[:]
 	htreeSeed := make([]uint32, 0, 4)
 	htreeSeed = append(htreeSeed,
@@ -385,7 +392,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 	)
 
 	// create a UUID for the journal
-	journalSuperblockUUID, _ := uuid.NewRandom()
+	journalSuperblockUUID, _ := uuid.NewRandomFromReader(util.Rand)
 
 	// group descriptor size could be 32 or 64, depending on option
 	var gdSize uint16
@@ -455,7 +462,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 	}
 
 	// create the superblock - MUST ADD IN OPTIONS
-	now, epoch := time.Now(), time.Unix(0, 0)
+	now, epoch := util.Now(), time.Unix(0, 0)
 	sb := superblock{
 		inodeCount:                   inodeCount,
 		blockCount:                   uint64(numblocks),
@@ -542,7 +549,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 
 	superblockBytes, err := sb.toBytes()
 	if err != nil {
-		return nil, fmt.Errorf("error converting Superblock to bytes: %v", err)
+		return nil, fmt.Errorf("error converting Superblock to bytes: %w", err)
 	}
 
 	g := gdt.toBytes(gdtChecksumType, sb.checksumSeed)
@@ -562,7 +569,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 			incr = int64(SectorSize512) * 2
 		}
 
-		writable, err := b.Writable()
+		writable, err := writableBackend(b)
 		if err != nil {
 			return nil, err
 		}
@@ -570,7 +577,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 		// write the superblock
 		count, err := writable.WriteAt(superblockBytes, incr+blockStart+start)
 		if err != nil {
-			return nil, fmt.Errorf("error writing Superblock for block %d to disk: %v", block, err)
+			return nil, fmt.Errorf("error writing Superblock for block %d to disk: %w", block, err)
 		}
 		if count != int(SuperblockSize) {
 			return nil, fmt.Errorf("wrote %d bytes of Superblock for block %d to disk instead of expected %d", count, block, SuperblockSize)
@@ -579,7 +586,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 		// write the GDT
 		count, err = writable.WriteAt(g, incr+blockStart+int64(SuperblockSize)+start)
 		if err != nil {
-			return nil, fmt.Errorf("error writing GDT for block %d to disk: %v", block, err)
+			return nil, fmt.Errorf("error writing GDT for block %d to disk: %w", block, err)
 		}
 		if count != int(gdtSize) {
 			return nil, fmt.Errorf("wrote %d bytes of GDT for block %d to disk instead of expected %d", count, block, gdtSize)
@@ -596,6 +603,7 @@ func Create(b backend.Storage, size, start, sectorsize int64, p *Params) (*FileS
 		size:             size,
 		start:            start,
 		backend:          b,
+		cache:            newBlockCache(DefaultCacheSize / int(sb.blockSize)),
 	}, nil
 }
 
@@ -630,7 +638,7 @@ func Read(b backend.Storage, size, start, sectorsize int64) (*FileSystem, error)
 	bs := make([]byte, BootSectorSize)
 	n, err := b.ReadAt(bs, start)
 	if err != nil {
-		return nil, fmt.Errorf("could not read boot sector bytes from file: %v", err)
+		return nil, fmt.Errorf("could not read boot sector bytes from file: %w", err)
 	}
 	if uint16(n) < uint16(BootSectorSize) {
 		return nil, fmt.Errorf("only could read %d boot sector bytes from file", n)
@@ -641,7 +649,7 @@ func Read(b backend.Storage, size, start, sectorsize int64) (*FileSystem, error)
 	superblockBytes := make([]byte, SuperblockSize)
 	n, err = b.ReadAt(superblockBytes, start+int64(BootSectorSize))
 	if err != nil {
-		return nil, fmt.Errorf("could not read superblock bytes from file: %v", err)
+		return nil, fmt.Errorf("could not read superblock bytes from file: %w", err)
 	}
 	if uint16(n) < uint16(SuperblockSize) {
 		return nil, fmt.Errorf("only could read %d superblock bytes from file", n)
@@ -650,7 +658,7 @@ func Read(b backend.Storage, size, start, sectorsize int64) (*FileSystem, error)
 	// convert the bytes into a superblock structure
 	sb, err := superblockFromBytes(superblockBytes)
 	if err != nil {
-		return nil, fmt.Errorf("could not interpret superblock data: %v", err)
+		return nil, fmt.Errorf("could not interpret superblock data: %w", err)
 	}
 
 	// now read the GDT
@@ -669,14 +677,14 @@ func Read(b backend.Storage, size, start, sectorsize int64) (*FileSystem, error)
 	}
 	n, err = b.ReadAt(gdtBytes, start+int64(gdtBlock)*int64(sb.blockSize))
 	if err != nil {
-		return nil, fmt.Errorf("could not read Group Descriptor Table bytes from file: %v", err)
+		return nil, fmt.Errorf("could not read Group Descriptor Table bytes from file: %w", err)
 	}
 	if uint64(n) < gdtSize {
 		return nil, fmt.Errorf("only could read %d Group Descriptor Table bytes from file instead of %d", n, gdtSize)
 	}
 	gdt, err := groupDescriptorsFromBytes(gdtBytes, sb.groupDescriptorSize, sb.checksumSeed, sb.gdtChecksumType())
 	if err != nil {
-		return nil, fmt.Errorf("could not interpret Group Descriptor Table data: %v", err)
+		return nil, fmt.Errorf("could not interpret Group Descriptor Table data: %w", err)
 	}
 
 	return &FileSystem{
@@ -687,6 +695,7 @@ func Read(b backend.Storage, size, start, sectorsize int64) (*FileSystem, error)
 		size:             size,
 		start:            start,
 		backend:          b,
+		cache:            newBlockCache(DefaultCacheSize / int(sb.blockSize)),
 	}, nil
 }
 
@@ -698,6 +707,15 @@ func (fs *FileSystem) Close() error {
 	return nil
 }
 
+// Sync makes the changes so far durable. All metadata is written with every change, so this syncs
+// the backend.
+func (fs *FileSystem) Sync() error {
+	if err := backend.Sync(fs.backend); err != nil {
+		return fmt.Errorf("error syncing filesystem: %w", err)
+	}
+	return nil
+}
+
 // Type returns the type code for the filesystem. Always returns filesystem.TypeExt4
 func (fs *FileSystem) Type() filesystem.Type {
 	return filesystem.TypeExt4
@@ -708,24 +726,142 @@ func (fs *FileSystem) Type() filesystem.Type {
 // * It will make the entire tree path if it does not exist
 // * It will not return an error if the path already exists
 func (fs *FileSystem) Mkdir(p string) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
 	_, err := fs.readDirWithMkdir(p, true)
 	// we are not interesting in returning the entries
 	return err
 }
 
-// creates a filesystem node (file, device special file, or named pipe) named pathname,
-// with attributes specified by mode and dev
-//
-//nolint:revive // parameters will be used eventually
+// creates a filesystem node (device special file, named pipe or socket) named pathname,
+// with attributes specified by mode and dev, for filesystem.MknodFS. Only the permissions of mode
+// are kept with its type, and the owner is that of the parent directory.
 func (fs *FileSystem) Mknod(pathname string, mode uint32, dev int) error {
-	return filesystem.ErrNotImplemented
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	pathname = filesystem.AbsolutePath(pathname)
+	var deFileType directoryFileType
+	fileType := parseFileType(uint16(mode))
+	//nolint:exhaustive // other files are not created by Mknod
+	switch fileType {
+	case fileTypeCharacterDevice:
+		deFileType = dirFileTypeCharacter
+	case fileTypeBlockDevice:
+		deFileType = dirFileTypeBlock
+	case fileTypeFifo:
+		deFileType = dirFileTypeFifo
+	case fileTypeSocket:
+		deFileType = dirFileTypeSocket
+	default:
+		return fmt.Errorf("cannot create node %s of mode %o: %w", pathname, mode, filesystem.ErrNotSupported)
+	}
+	parentDir, existing, err := fs.getEntryAndParent(pathname)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("cannot create node %s: %w", pathname, iofs.ErrExist)
+	}
+	parentInode, err := fs.readInode(parentDir.inode)
+	if err != nil {
+		return fmt.Errorf("could not read inode %d of parent directory: %w", parentDir.inode, err)
+	}
+	inodeNumber, err := fs.allocateInode(parentDir.inode)
+	if err != nil {
+		return fmt.Errorf("could not allocate inode for node %s: %w", pathname, err)
+	}
+	// devices have no blocks, their numbers are held in place of the extents
+	major, minor := filesystem.SplitDev(dev)
+	perm := uint16(mode)
+	now := util.Now()
+	in := &inode{
+		number:           inodeNumber,
+		permissionsOwner: parseOwnerPermissions(perm),
+		permissionsGroup: parseGroupPermissions(perm),
+		permissionsOther: parseOtherPermissions(perm),
+		fileType:         fileType,
+		owner:            parentInode.owner,
+		group:            parentInode.group,
+		hardLinks:        1,
+		flags:            &inodeFlags{},
+		inodeSize:        parentInode.inodeSize,
+		accessTime:       now,
+		changeTime:       now,
+		createTime:       now,
+		modifyTime:       now,
+		deviceMajor:      major,
+		deviceMinor:      minor,
+	}
+	if err := fs.writeInode(in); err != nil {
+		return fmt.Errorf("could not write inode for node %s: %w", pathname, err)
+	}
+	parentDir.entries = append(parentDir.entries, &directoryEntry{
+		inode:    inodeNumber,
+		filename: path.Base(pathname),
+		fileType: deFileType,
+	})
+	if err := fs.writeDirectory(parentDir); err != nil {
+		return fmt.Errorf("could not write directory %s: %w", path.Dir(pathname), err)
+	}
+	return nil
 }
 
-// creates a new link (also known as a hard link) to an existing file.
-//
-//nolint:revive // parameters will be used eventually
+// creates a new link (also known as a hard link) to an existing file, for filesystem.LinkFS.
 func (fs *FileSystem) Link(oldpath, newpath string) error {
-	return filesystem.ErrNotImplemented
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	oldpath, newpath = filesystem.AbsolutePath(oldpath), filesystem.AbsolutePath(newpath)
+	_, entry, err := fs.getEntryAndParent(oldpath)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("file does not exist: %s: %w", oldpath, iofs.ErrNotExist)
+	}
+	if entry.fileType == dirFileTypeDirectory {
+		return fmt.Errorf("cannot link directory %s: %w", oldpath, filesystem.ErrIsDir)
+	}
+	in, err := fs.readInode(entry.inode)
+	if err != nil {
+		return fmt.Errorf("could not read inode %d of %s: %w", entry.inode, oldpath, err)
+	}
+	if in.hardLinks >= maxHardLinks {
+		return fmt.Errorf("%s already has %d links: %w", oldpath, in.hardLinks, filesystem.ErrNoSpace)
+	}
+	parentDir, existing, err := fs.getEntryAndParent(newpath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("cannot link %s: %w", newpath, iofs.ErrExist)
+	}
+	parentDir.entries = append(parentDir.entries, &directoryEntry{
+		inode:    entry.inode,
+		filename: path.Base(newpath),
+		fileType: entry.fileType,
+	})
+	if err := fs.writeDirectory(parentDir); err != nil {
+		return fmt.Errorf("could not write directory %s: %w", path.Dir(newpath), err)
+	}
+	in.hardLinks++
+	in.changeTime = util.Now()
+	return fs.writeInode(in)
+}
+
+// Inode returns the number of the inode of the named file, shared by its hard links, for
+// filesystem.LinkFS
+func (fs *FileSystem) Inode(p string) (uint64, error) {
+	_, entry, err := fs.getEntryAndParent(filesystem.AbsolutePath(p))
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, fmt.Errorf("file does not exist: %s: %w", p, iofs.ErrNotExist)
+	}
+	return uint64(entry.inode), nil
 }
 
 // creates a symbolic link named linkpath which contains the string target.
@@ -735,50 +871,152 @@ func (fs *FileSystem) Symlink(oldpath, newpath string) error {
 	return filesystem.ErrNotImplemented
 }
 
-// Chmod changes the mode of the named file to mode. If the file is a symbolic link,
-// it changes the mode of the link's target.
-//
-//nolint:revive // parameters will be used eventually
+// Chmod changes the mode of the named file to mode. Only the permissions of mode are kept, not
+// its setuid, setgid and sticky bits. Symbolic links are not followed yet, so the mode of the
+// link itself is changed.
 func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
-	return filesystem.ErrNotImplemented
+	perm := uint16(mode.Perm())
+	return fs.updateInode(name, func(in *inode) {
+		in.permissionsOwner = parseOwnerPermissions(perm)
+		in.permissionsGroup = parseGroupPermissions(perm)
+		in.permissionsOther = parseOtherPermissions(perm)
+	})
 }
 
-// Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
-// it changes the uid and gid of the link's target. A uid or gid of -1 means to not change that value
-//
-//nolint:revive // parameters will be used eventually
+// Chown changes the numeric uid and gid of the named file. A uid or gid of -1 means to not change
+// that value. Symbolic links are not followed yet, so the owner of the link itself is changed.
 func (fs *FileSystem) Chown(name string, uid, gid int) error {
-	return filesystem.ErrNotImplemented
+	return fs.updateInode(name, func(in *inode) {
+		if uid != -1 {
+			in.owner = uint32(uid)
+		}
+		if gid != -1 {
+			in.group = uint32(gid)
+		}
+	})
+}
+
+// Chtimes changes the access and modification times of the named file, like os.Chtimes. A zero
+// time leaves that time unchanged.
+func (fs *FileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.updateInode(name, func(in *inode) {
+		if !atime.IsZero() {
+			in.accessTime = atime
+		}
+		if !mtime.IsZero() {
+			in.modifyTime = mtime
+		}
+	})
+}
+
+// updateInode changes the inode of the file with update, along with its change time, and writes it
+func (fs *FileSystem) updateInode(p string, update func(in *inode)) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	_, entry, err := fs.getEntryAndParent(filesystem.AbsolutePath(p))
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("file does not exist: %s: %w", p, iofs.ErrNotExist)
+	}
+	in, err := fs.readInode(entry.inode)
+	if err != nil {
+		return fmt.Errorf("could not read inode %d of %s: %w", entry.inode, p, err)
+	}
+	update(in)
+	in.changeTime = util.Now()
+	return fs.writeInode(in)
 }
 
 // ReadDir return the contents of a given directory in a given filesystem.
 //
-// Returns a slice of os.FileInfo with all of the entries in the directory.
+// Returns a slice of fs.DirEntry with all of the entries in the directory, sorted by name.
 //
 // Will return an error if the directory does not exist or is a regular file and not a directory
-func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
-	dir, err := fs.readDirWithMkdir(p, false)
+func (fs *FileSystem) ReadDir(p string) ([]iofs.DirEntry, error) {
+	dir, err := fs.readDirWithMkdir(filesystem.AbsolutePath(p), false)
 	if err != nil {
-		return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
 	}
 	// once we have made it here, looping is done. We have found the final entry
 	// we need to return all of the file info
-	count := len(dir.entries)
-	ret := make([]os.FileInfo, count)
+	ret := make([]iofs.FileInfo, 0, len(dir.entries))
 	for i, e := range dir.entries {
-		in, err := fs.readInode(e.inode)
+		// unused entries have no inode
+		if e.inode == 0 {
+			continue
+		}
+		info, err := fs.entryInfo(e)
 		if err != nil {
-			return nil, fmt.Errorf("could not read inode %d at position %d in directory: %v", e.inode, i, err)
+			return nil, fmt.Errorf("error reading entry %d of directory %s: %w", i, p, err)
 		}
-		ret[i] = &FileInfo{
-			modTime: in.modifyTime,
-			name:    e.filename,
-			size:    int64(in.size),
-			isDir:   e.fileType == dirFileTypeDirectory,
+		ret = append(ret, info)
+	}
+
+	return filesystem.SortedDirEntries(ret), nil
+}
+
+// entryInfo reads the inode of the directory entry for its FileInfo
+func (fs *FileSystem) entryInfo(e *directoryEntry) (*FileInfo, error) {
+	in, err := fs.readInode(e.inode)
+	if err != nil {
+		return nil, fmt.Errorf("could not read inode %d in directory: %w", e.inode, err)
+	}
+	perm := in.permissionsOwner.toOwnerInt() | in.permissionsGroup.toGroupInt() | in.permissionsOther.toOtherInt()
+	return &FileInfo{
+		modTime: in.modifyTime,
+		mode:    iofs.FileMode(perm) | e.fileType.fileMode(),
+		name:    e.filename,
+		size:    int64(in.size),
+		isDir:   e.fileType == dirFileTypeDirectory,
+		major:   in.deviceMajor,
+		minor:   in.deviceMinor,
+	}, nil
+}
+
+// WalkDir walks the file tree rooted at root like fs.WalkDir, reading the directories by the inodes
+// of their entries. The inode of a file is read only when fn asks for the Info of its entry.
+func (fs *FileSystem) WalkDir(root string, fn iofs.WalkDirFunc) error {
+	_, entry, err := fs.getEntryAndParent(filesystem.AbsolutePath(root))
+	if err == nil && entry == nil {
+		err = &iofs.PathError{Op: "stat", Path: root, Err: iofs.ErrNotExist}
+	}
+	if err != nil {
+		return filesystem.WalkEntries(root, nil, err, nil, fn)
+	}
+	d := &lazyDirEntry{fs: fs, entry: entry}
+	return filesystem.WalkEntries(root, d, nil, fs.walkReadDir, fn)
+}
+
+// walkReadDir reads the entries of the directory of a lazyDirEntry for WalkDir, sorted by name
+func (fs *FileSystem) walkReadDir(p string, d iofs.DirEntry) ([]iofs.DirEntry, error) {
+	dirEntries, err := fs.readDirectory(d.(*lazyDirEntry).entry.inode)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	entries := make([]iofs.DirEntry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		if e.inode == 0 || e.filename == "." || e.filename == ".." {
+			continue
 		}
+		entries = append(entries, &lazyDirEntry{fs: fs, entry: e})
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Open opens the file or directory read-only, for fs.FS
+func (fs *FileSystem) Open(name string) (iofs.File, error) {
+	return filesystem.GenericOpen(fs, name)
+}
 
-	return ret, nil
+// ReadFile reads the whole file, for fs.ReadFileFS
+func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
+	return filesystem.GenericReadFile(fs, name)
 }
 
 // OpenFile returns an io.ReadWriter from which you can read the contents of a file
@@ -788,6 +1026,11 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 //
 // returns an error if the file does not exist
 func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		if _, err := writableBackend(fs.backend); err != nil {
+			return nil, err
+		}
+	}
 	filename := path.Base(p)
 	dir := path.Dir(p)
 	parentDir, entry, err := fs.getEntryAndParent(p)
@@ -795,26 +1038,26 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 		return nil, err
 	}
 	if entry != nil && entry.fileType == dirFileTypeDirectory {
-		return nil, fmt.Errorf("cannot open directory %s as file", p)
+		return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 	}
 
 	// see if the file exists
 	// if the file does not exist, and is not opened for os.O_CREATE, return an error
 	if entry == nil {
 		if flag&os.O_CREATE == 0 {
-			return nil, fmt.Errorf("target file %s does not exist and was not asked to create", p)
+			return nil, fmt.Errorf("target file %s does not exist and was not asked to create: %w", p, iofs.ErrNotExist)
 		}
 		// else create it
 		entry, err = fs.mkFile(parentDir, filename)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create file %s: %v", p, err)
+			return nil, fmt.Errorf("failed to create file %s: %w", p, err)
 		}
 	}
 	// get the inode
 	inodeNumber := entry.inode
 	inode, err := fs.readInode(inodeNumber)
 	if err != nil {
-		return nil, fmt.Errorf("could not read inode number %d: %v", inodeNumber, err)
+		return nil, fmt.Errorf("could not read inode number %d: %w", inodeNumber, err)
 	}
 
 	// if a symlink, read the target, rather than the inode itself, which does not point to anything
@@ -836,15 +1079,19 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	if flag&os.O_APPEND == os.O_APPEND {
 		offset = int64(inode.size)
 	}
+	// devices, named pipes and sockets have no contents to read or write
+	if inode.extents == nil {
+		return nil, fmt.Errorf("cannot open %s of type %s as file: %w", p, entry.fileType.fileMode(), filesystem.ErrNotSupported)
+	}
 	// when we open a file, we load the inode but also all of the extents
 	extents, err := inode.extents.blocks(fs)
 	if err != nil {
-		return nil, fmt.Errorf("could not read extent tree for inode %d: %v", inodeNumber, err)
+		return nil, fmt.Errorf("could not read extent tree for inode %d: %w", inodeNumber, err)
 	}
 	return &File{
 		directoryEntry: entry,
 		inode:          inode,
-		isReadWrite:    flag&os.O_RDWR != 0,
+		isReadWrite:    flag&(os.O_RDWR|os.O_WRONLY) != 0,
 		isAppend:       flag&os.O_APPEND != 0,
 		offset:         offset,
 		filesystem:     fs,
@@ -852,6 +1099,66 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	}, nil
 }
 
+// BlockSize returns the size of the blocks allocated to files, for filesystem.BlockSizeFS
+func (fs *FileSystem) BlockSize() int64 {
+	return int64(fs.superblock.blockSize)
+}
+
+// Space returns the size of the blocks of the filesystem, and of those that are free, including
+// those reserved for root, from the counts of the superblock, for filesystem.SpaceFS
+func (fs *FileSystem) Space() (total, free int64, err error) {
+	blockSize := int64(fs.superblock.blockSize)
+	return int64(fs.superblock.blockCount) * blockSize, int64(fs.superblock.freeBlocks) * blockSize, nil
+}
+
+// AllocatedRanges returns the blocks in use in the block bitmaps, for filesystem.AllocatedFS. The
+// blocks of groups whose bitmap is not initialized are all in use, as some of them hold metadata.
+// With bigalloc, each bit of the block bitmaps is a cluster of blocks.
+func (fs *FileSystem) AllocatedRanges() ([]filesystem.Range, error) {
+	sb := fs.superblock
+	blockSize := int64(sb.blockSize)
+	blocksPerCluster, clustersPerGroup := uint64(1), uint64(sb.blocksPerGroup)
+	if sb.features.bigalloc {
+		// the cluster size is kept as in the superblock, a power of 2 of KB
+		blocksPerCluster = max(sb.clusterSize*1024/uint64(sb.blockSize), 1)
+		clustersPerGroup = uint64(sb.clustersPerGroup)
+	}
+	if clustersPerGroup > 8*uint64(sb.blockSize) || clustersPerGroup*blocksPerCluster != uint64(sb.blocksPerGroup) {
+		return nil, fmt.Errorf("block bitmaps of %d clusters of %d blocks for block groups of %d blocks: %w", clustersPerGroup, blocksPerCluster, sb.blocksPerGroup, filesystem.ErrNotSupported)
+	}
+	clusterSize := int64(blocksPerCluster) * blockSize
+	// the blocks before the first group, such as the boot block where blocks are of 1 KB
+	ranges := filesystem.AppendRange(nil, 0, int64(sb.firstDataBlock)*blockSize)
+	for i, gd := range fs.groupDescriptors.descriptors {
+		first := uint64(sb.firstDataBlock) + uint64(i)*uint64(sb.blocksPerGroup)
+		// the last block group may be shorter
+		blocks := min(uint64(sb.blocksPerGroup), sb.blockCount-first)
+		if gd.flags.blockBitmapUninitialized {
+			ranges = filesystem.AppendRange(ranges, int64(first)*blockSize, int64(blocks)*blockSize)
+			continue
+		}
+		bm, err := fs.readBlockBitmap(i)
+		if err != nil {
+			return nil, fmt.Errorf("could not read block bitmap of block group %d: %w", i, err)
+		}
+		b := bm.ToBytes()
+		clusters := (blocks + blocksPerCluster - 1) / blocksPerCluster
+		for j := uint64(0); j < clusters; j++ {
+			// skip the free clusters a byte at a time
+			if j%8 == 0 && j+8 <= clusters && b[j/8] == 0 {
+				j += 7
+				continue
+			}
+			if b[j/8]&(1<<(j%8)) != 0 {
+				start := int64(first+j*blocksPerCluster) * blockSize
+				end := int64(first+blocks) * blockSize
+				ranges = filesystem.AppendRange(ranges, start, min(clusterSize, end-start))
+			}
+		}
+	}
+	return ranges, nil
+}
+
 // Label read the volume label
 func (fs *FileSystem) Label() string {
 	if fs.superblock == nil {
@@ -860,11 +1167,122 @@ func (fs *FileSystem) Label() string {
 	return fs.superblock.volumeLabel
 }
 
-// Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
-//
-//nolint:revive // parameters will be used eventually
+// Rename renames (moves) oldpath to newpath, within its directory or to another one. If newpath
+// already exists and is not a directory, Rename replaces it.
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
-	return filesystem.ErrNotImplemented
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	oldpath, newpath = filesystem.AbsolutePath(oldpath), filesystem.AbsolutePath(newpath)
+	if oldpath == "/" || newpath == "/" {
+		return fmt.Errorf("cannot rename root directory: %w", iofs.ErrInvalid)
+	}
+	if strings.HasPrefix(newpath, oldpath+"/") {
+		return fmt.Errorf("cannot move %s into itself as %s: %w", oldpath, newpath, iofs.ErrInvalid)
+	}
+	_, entry, err := fs.getEntryAndParent(oldpath)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("file does not exist: %s: %w", oldpath, iofs.ErrNotExist)
+	}
+	_, existing, err := fs.getEntryAndParent(newpath)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		switch {
+		case existing.inode == entry.inode:
+			return nil
+		case existing.fileType == dirFileTypeDirectory:
+			return fmt.Errorf("cannot replace directory %s: %w", newpath, filesystem.ErrIsDir)
+		case entry.fileType == dirFileTypeDirectory:
+			return fmt.Errorf("cannot replace file %s with directory %s: %w", newpath, oldpath, filesystem.ErrNotDir)
+		}
+		if err := fs.Remove(newpath); err != nil {
+			return fmt.Errorf("could not replace %s: %w", newpath, err)
+		}
+	}
+
+	// read the parents again, as removing the file replaced may have changed them
+	parentDir, entry, err := fs.getEntryAndParent(oldpath)
+	if err != nil {
+		return err
+	}
+	newParentDir, _, err := fs.getEntryAndParent(newpath)
+	if err != nil {
+		return err
+	}
+	newname := path.Base(newpath)
+	if newParentDir.inode == parentDir.inode {
+		entry.filename = newname
+		if err := fs.writeDirectory(parentDir); err != nil {
+			return fmt.Errorf("could not write directory %s: %w", path.Dir(oldpath), err)
+		}
+		return nil
+	}
+
+	parentDir.entries = slices.DeleteFunc(parentDir.entries, func(e *directoryEntry) bool {
+		return e == entry
+	})
+	if err := fs.writeDirectory(parentDir); err != nil {
+		return fmt.Errorf("could not write directory %s: %w", path.Dir(oldpath), err)
+	}
+	entry.filename = newname
+	newParentDir.entries = append(newParentDir.entries, entry)
+	if err := fs.writeDirectory(newParentDir); err != nil {
+		return fmt.Errorf("could not write directory %s: %w", path.Dir(newpath), err)
+	}
+	// a directory moved elsewhere points to its new parent
+	if entry.fileType == dirFileTypeDirectory {
+		entries, err := fs.readDirectory(entry.inode)
+		if err != nil {
+			return fmt.Errorf("could not read directory %s: %w", newpath, err)
+		}
+		for _, e := range entries {
+			if e.filename == ".." {
+				e.inode = newParentDir.inode
+			}
+		}
+		if err := fs.writeDirectory(&Directory{directoryEntry: *entry, entries: entries}); err != nil {
+			return fmt.Errorf("could not write directory %s: %w", newpath, err)
+		}
+	}
+	return nil
+}
+
+// writeDirectory writes the entries of the directory to its blocks, like mkDirEntry does
+func (fs *FileSystem) writeDirectory(dir *Directory) error {
+	dirInode, err := fs.readInode(dir.inode)
+	if err != nil {
+		return fmt.Errorf("could not read inode %d of directory: %w", dir.inode, err)
+	}
+	extents, err := dirInode.extents.blocks(fs)
+	if err != nil {
+		return fmt.Errorf("could not read extents for directory: %w", err)
+	}
+	dirFile := &File{
+		inode: dirInode,
+		directoryEntry: &directoryEntry{
+			inode:    dir.inode,
+			filename: dir.filename,
+			fileType: dirFileTypeDirectory,
+		},
+		filesystem:  fs,
+		isReadWrite: true,
+		offset:      0,
+		extents:     extents,
+	}
+	dirBytes := dir.toBytes(fs.superblock.blockSize, directoryChecksumAppender(fs.superblock.checksumSeed, dir.inode, 0))
+	wrote, err := dirFile.Write(dirBytes)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("unable to write directory: %w", err)
+	}
+	if wrote != len(dirBytes) {
+		return fmt.Errorf("wrote only %d bytes instead of expected %d for directory", wrote, len(dirBytes))
+	}
+	return nil
 }
 
 // Deprecated: use filesystem.Remove(p string) instead
@@ -878,18 +1296,21 @@ func (fs *FileSystem) Rm(p string) error {
 // Will not remove any parents.
 // Error if the file does not exist or is not an empty directory
 func (fs *FileSystem) Remove(p string) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
 	parentDir, entry, err := fs.getEntryAndParent(p)
 	if err != nil {
 		return err
 	}
 	if parentDir.root && entry == &parentDir.directoryEntry {
-		return fmt.Errorf("cannot remove root directory")
+		return fmt.Errorf("cannot remove root directory: %w", iofs.ErrInvalid)
 	}
 	if entry == nil {
-		return fmt.Errorf("file does not exist: %s", p)
+		return fmt.Errorf("file does not exist: %s: %w", p, iofs.ErrNotExist)
 	}
 
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 
 	if err != nil {
 		return err
@@ -899,10 +1320,10 @@ func (fs *FileSystem) Remove(p string) error {
 		// read the directory
 		entries, err := fs.readDirectory(entry.inode)
 		if err != nil {
-			return fmt.Errorf("could not read directory %s: %v", p, err)
+			return fmt.Errorf("could not read directory %s: %w", p, err)
 		}
 		if len(entries) > 2 {
-			return fmt.Errorf("directory not empty: %s", p)
+			return fmt.Errorf("%w: %s", filesystem.ErrNotEmpty, p)
 		}
 	}
 	// at this point, it is either a file or an empty directory, so remove it
@@ -911,17 +1332,33 @@ func (fs *FileSystem) Remove(p string) error {
 	// read the inode to find the blocks
 	removedInode, err := fs.readInode(entry.inode)
 	if err != nil {
-		return fmt.Errorf("could not read inode %d for %s: %v", entry.inode, p, err)
+		return fmt.Errorf("could not read inode %d for %s: %w", entry.inode, p, err)
 	}
-	extents, err := removedInode.extents.blocks(fs)
-	if err != nil {
-		return fmt.Errorf("could not read extents for inode %d for %s: %v", entry.inode, p, err)
+	// a file with other hard links keeps its inode and blocks for them
+	if entry.fileType != dirFileTypeDirectory && removedInode.hardLinks > 1 {
+		parentDir.entries = slices.DeleteFunc(parentDir.entries, func(e *directoryEntry) bool {
+			return e == entry
+		})
+		if err := fs.writeDirectory(parentDir); err != nil {
+			return fmt.Errorf("could not write directory %s: %w", path.Dir(p), err)
+		}
+		removedInode.hardLinks--
+		removedInode.changeTime = util.Now()
+		return fs.writeInode(removedInode)
+	}
+	// devices, named pipes, sockets and fast symbolic links have no blocks
+	var extents extents
+	if removedInode.extents != nil {
+		extents, err = removedInode.extents.blocks(fs)
+		if err != nil {
+			return fmt.Errorf("could not read extents for inode %d for %s: %w", entry.inode, p, err)
+		}
 	}
 	// clear the inode from the inode bitmap
 	inodeBG := blockGroupForInode(int(entry.inode), fs.superblock.inodesPerGroup)
 	inodeBitmap, err := fs.readInodeBitmap(inodeBG)
 	if err != nil {
-		return fmt.Errorf("could not read inode bitmap: %v", err)
+		return fmt.Errorf("could not read inode bitmap: %w", err)
 	}
 	// clear up the blocks from the block bitmap. We are not clearing the block content, just the bitmap.
 	// keep a cache of bitmaps, so we do not have to read them again and again
@@ -934,27 +1371,27 @@ func (fs *FileSystem) Remove(p string) error {
 			if !ok {
 				dataBlockBitmap, err = fs.readBlockBitmap(bg)
 				if err != nil {
-					return fmt.Errorf("could not read block bitmap: %v", err)
+					return fmt.Errorf("could not read block bitmap: %w", err)
 				}
 				blockBitmaps[bg] = dataBlockBitmap
 			}
 			// the extent lists the absolute block number, but the bitmap is relative to the block group
 			blockInBG := int(i) - int(fs.superblock.blocksPerGroup)*bg
 			if err := dataBlockBitmap.Clear(blockInBG); err != nil {
-				return fmt.Errorf("could not clear block bitmap for block %d: %v", i, err)
+				return fmt.Errorf("could not clear block bitmap for block %d: %w", i, err)
 			}
 		}
 	}
 	for bg, dataBlockBitmap := range blockBitmaps {
 		if err := fs.writeBlockBitmap(dataBlockBitmap, bg); err != nil {
-			return fmt.Errorf("could not write block bitmap back to disk: %v", err)
+			return fmt.Errorf("could not write block bitmap back to disk: %w", err)
 		}
 	}
 
 	// remove the directory entry from the parent
 	newEntries := make([]*directoryEntry, 0, len(parentDir.entries)-1)
 	for _, e := range parentDir.entries {
-		if e.inode == entry.inode {
+		if e == entry {
 			continue
 		}
 		newEntries = append(newEntries, e)
@@ -964,46 +1401,41 @@ func (fs *FileSystem) Remove(p string) error {
 	dirBytes := parentDir.toBytes(fs.superblock.blockSize, directoryChecksumAppender(fs.superblock.checksumSeed, parentDir.inode, 0))
 	parentInode, err := fs.readInode(parentDir.inode)
 	if err != nil {
-		return fmt.Errorf("could not read inode %d for %s: %v", entry.inode, path.Base(p), err)
+		return fmt.Errorf("could not read inode %d for %s: %w", entry.inode, path.Base(p), err)
 	}
 	extents, err = parentInode.extents.blocks(fs)
 	if err != nil {
-		return fmt.Errorf("could not read extents for inode %d for %s: %v", entry.inode, path.Base(p), err)
+		return fmt.Errorf("could not read extents for inode %d for %s: %w", entry.inode, path.Base(p), err)
 	}
 	for _, e := range extents {
 		for i := 0; i < int(e.count); i++ {
 			b := dirBytes[i:fs.superblock.blockSize]
 			if _, err := writableFile.WriteAt(b, (int64(i)+int64(e.startingBlock))*int64(fs.superblock.blockSize)); err != nil {
-				return fmt.Errorf("could not write inode bitmap back to disk: %v", err)
+				return fmt.Errorf("could not write inode bitmap back to disk: %w", err)
 			}
 		}
 	}
 
 	// remove the inode from the bitmap and write the inode bitmap back
-	// inode is absolute, but bitmap is relative to block group
-	inodeInBG := int(entry.inode) - int(fs.superblock.inodesPerGroup)*inodeBG
+	// inode is absolute and numbered from 1, but bitmap is relative to block group
+	inodeInBG := int(entry.inode) - 1 - int(fs.superblock.inodesPerGroup)*inodeBG
 	if err := inodeBitmap.Clear(inodeInBG); err != nil {
-		return fmt.Errorf("could not clear inode bitmap for inode %d: %v", entry.inode, err)
+		return fmt.Errorf("could not clear inode bitmap for inode %d: %w", entry.inode, err)
 	}
 
 	// write the inode bitmap back
 	if err := fs.writeInodeBitmap(inodeBitmap, inodeBG); err != nil {
-		return fmt.Errorf("could not write inode bitmap back to disk: %v", err)
+		return fmt.Errorf("could not write inode bitmap back to disk: %w", err)
 	}
 	// update the group descriptor
-	gd := fs.groupDescriptors.descriptors[inodeBG]
+	gd := &fs.groupDescriptors.descriptors[inodeBG]
 
 	// update the group descriptor inodes and blocks
 	gd.freeInodes++
 	gd.freeBlocks += uint32(removedInode.blocks)
 	// write the group descriptor back
-	gdBytes := gd.toBytes(fs.superblock.gdtChecksumType(), fs.superblock.uuid.ID())
-	gdtBlock := 1
-	if fs.superblock.blockSize == 1024 {
-		gdtBlock = 2
-	}
-	if _, err := writableFile.WriteAt(gdBytes, fs.start+int64(gdtBlock)*int64(fs.superblock.blockSize)+int64(gd.number)*int64(fs.superblock.groupDescriptorSize)); err != nil {
-		return fmt.Errorf("could not write Group Descriptor bytes to file: %v", err)
+	if err := fs.writeGroupDescriptor(gd); err != nil {
+		return err
 	}
 
 	// we could remove the inode from the inode table in the group descriptor,
@@ -1015,20 +1447,23 @@ func (fs *FileSystem) Remove(p string) error {
 }
 
 func (fs *FileSystem) Truncate(p string, size int64) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
 	_, entry, err := fs.getEntryAndParent(p)
 	if err != nil {
 		return err
 	}
 	if entry == nil {
-		return fmt.Errorf("file does not exist: %s", p)
+		return fmt.Errorf("file does not exist: %s: %w", p, iofs.ErrNotExist)
 	}
 	if entry.fileType == dirFileTypeDirectory {
-		return fmt.Errorf("cannot truncate directory %s", p)
+		return fmt.Errorf("cannot truncate directory %s: %w", p, filesystem.ErrIsDir)
 	}
 	// it is not a directory, and it exists, so truncate it
 	inode, err := fs.readInode(entry.inode)
 	if err != nil {
-		return fmt.Errorf("could not read inode %d in directory: %v", entry.inode, err)
+		return fmt.Errorf("could not read inode %d in directory: %w", entry.inode, err)
 	}
 	// change the file size
 	inode.size = uint64(size)
@@ -1049,7 +1484,7 @@ func (fs *FileSystem) getEntryAndParent(p string) (parent *Directory, entry *dir
 	// get the directory entries
 	parentDir, err := fs.readDirWithMkdir(dir, false)
 	if err != nil {
-		return nil, nil, fmt.Errorf("could not read directory entries for %s", dir)
+		return nil, nil, fmt.Errorf("could not read directory entries for %s: %w", dir, err)
 	}
 	// we now know that the directory exists, see if the file exists
 	var targetEntry *directoryEntry
@@ -1069,34 +1504,239 @@ func (fs *FileSystem) getEntryAndParent(p string) (parent *Directory, entry *dir
 	return parentDir, targetEntry, nil
 }
 
-// Stat return fs.FileInfo about a specific file path.
+// Stat return fs.FileInfo about a specific file path, absolute or in the form of io/fs, for fs.StatFS.
+// Symbolic links are followed, and the FileInfo of their target has the name of the link.
 func (fs *FileSystem) Stat(p string) (iofs.FileInfo, error) {
+	current := filesystem.AbsolutePath(p)
+	for i := 0; i <= maxSymlinks; i++ {
+		info, err := fs.lstat(current)
+		if err != nil {
+			return nil, &iofs.PathError{Op: "stat", Path: p, Err: err}
+		}
+		if info.mode&iofs.ModeSymlink == 0 {
+			info.name = path.Base(filesystem.AbsolutePath(p))
+			return info, nil
+		}
+		target, err := fs.readlink(current)
+		if err != nil {
+			return nil, &iofs.PathError{Op: "stat", Path: p, Err: err}
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(current), target)
+		}
+		current = target
+	}
+	return nil, &iofs.PathError{Op: "stat", Path: p, Err: errors.New("too many levels of symbolic links")}
+}
+
+// Lstat return fs.FileInfo about a specific file path like Stat, but describes symbolic links
+// themselves, for filesystem.LstatFS
+func (fs *FileSystem) Lstat(p string) (iofs.FileInfo, error) {
+	info, err := fs.lstat(filesystem.AbsolutePath(p))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "lstat", Path: p, Err: err}
+	}
+	return info, nil
+}
+
+// Readlink returns the target of the symbolic link, for filesystem.ReadlinkFS
+func (fs *FileSystem) Readlink(p string) (string, error) {
+	target, err := fs.readlink(filesystem.AbsolutePath(p))
+	if err != nil {
+		return "", &iofs.PathError{Op: "readlink", Path: p, Err: err}
+	}
+	return target, nil
+}
+
+func (fs *FileSystem) lstat(p string) (*FileInfo, error) {
 	_, entry, err := fs.getEntryAndParent(p)
 	if err != nil {
 		return nil, err
 	}
 	if entry == nil {
-		return nil, fmt.Errorf("file does not exist: %s", p)
+		return nil, iofs.ErrNotExist
+	}
+	return fs.entryInfo(entry)
+}
+
+func (fs *FileSystem) readlink(p string) (string, error) {
+	_, entry, err := fs.getEntryAndParent(p)
+	if err != nil {
+		return "", err
+	}
+	if entry == nil {
+		return "", iofs.ErrNotExist
 	}
 	in, err := fs.readInode(entry.inode)
 	if err != nil {
-		return nil, fmt.Errorf("could not read inode %d in directory: %v", entry.inode, err)
+		return "", fmt.Errorf("could not read inode %d of %s: %w", entry.inode, p, err)
 	}
-	return &FileInfo{
-		modTime: in.modifyTime,
-		name:    entry.filename,
-		size:    int64(in.size),
-		isDir:   entry.fileType == dirFileTypeDirectory,
-	}, nil
+	if in.fileType != fileTypeSymbolicLink {
+		return "", iofs.ErrInvalid
+	}
+	return in.linkTarget, nil
+}
+
+// Listxattr returns the names of the extended attributes of the file, for filesystem.XattrFS
+func (fs *FileSystem) Listxattr(p string) ([]string, error) {
+	in, err := fs.lstatInode(filesystem.AbsolutePath(p))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+	inInode, inBlock, err := fs.readXattrs(in)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "listxattr", Path: p, Err: err}
+	}
+	attrs := make([]string, 0, len(inInode)+len(inBlock))
+	for _, e := range slices.Concat(inInode, inBlock) {
+		attrs = append(attrs, e.fullName())
+	}
+	slices.Sort(attrs)
+	return attrs, nil
+}
+
+// Getxattr returns the value of the extended attribute of the file, for filesystem.XattrFS.
+// ACLs are returned in the format of the xattr system calls.
+func (fs *FileSystem) Getxattr(p, attr string) ([]byte, error) {
+	in, err := fs.lstatInode(filesystem.AbsolutePath(p))
+	if err != nil {
+		return nil, &iofs.PathError{Op: "getxattr", Path: p, Err: err}
+	}
+	inInode, inBlock, err := fs.readXattrs(in)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "getxattr", Path: p, Err: err}
+	}
+	for _, e := range slices.Concat(inInode, inBlock) {
+		if e.fullName() == attr {
+			return e.xattrValue()
+		}
+	}
+	return nil, &iofs.PathError{Op: "getxattr", Path: p, Err: fmt.Errorf("%s: %w", attr, filesystem.ErrNoXattr)}
+}
+
+// Setxattr creates or replaces the extended attribute of the file, for filesystem.XattrFS.
+// Attributes are written in the space of the inode after its fields, and it returns
+// filesystem.ErrNoSpace if they do not fit there, and filesystem.ErrNotImplemented to change
+// those already in a separate block.
+func (fs *FileSystem) Setxattr(p, attr string, value []byte) error {
+	e, err := newXattrEntry(attr, value)
+	if err != nil {
+		return &iofs.PathError{Op: "setxattr", Path: p, Err: fmt.Errorf("%w: %w", iofs.ErrInvalid, err)}
+	}
+	return fs.updateXattrs(p, "setxattr", attr, func(entries []*xattrEntry, i int) ([]*xattrEntry, error) {
+		if i < 0 {
+			return append(entries, e), nil
+		}
+		entries[i] = e
+		return entries, nil
+	})
+}
+
+// Removexattr removes the extended attribute of the file, for filesystem.XattrFS. It returns
+// filesystem.ErrNotImplemented for those in a separate block.
+func (fs *FileSystem) Removexattr(p, attr string) error {
+	return fs.updateXattrs(p, "removexattr", attr, func(entries []*xattrEntry, i int) ([]*xattrEntry, error) {
+		if i < 0 {
+			return nil, fmt.Errorf("%s: %w", attr, filesystem.ErrNoXattr)
+		}
+		return slices.Delete(entries, i, i+1), nil
+	})
+}
+
+// updateXattrs changes the extended attributes in the inode of the file with update, given them
+// and the index of attr in them, or -1, and writes them back
+func (fs *FileSystem) updateXattrs(p, op, attr string, update func(entries []*xattrEntry, i int) ([]*xattrEntry, error)) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	in, err := fs.lstatInode(filesystem.AbsolutePath(p))
+	if err != nil {
+		return &iofs.PathError{Op: op, Path: p, Err: err}
+	}
+	inInode, inBlock, err := fs.readXattrs(in)
+	if err != nil {
+		return &iofs.PathError{Op: op, Path: p, Err: err}
+	}
+	if slices.ContainsFunc(inBlock, func(e *xattrEntry) bool { return e.fullName() == attr }) {
+		return &iofs.PathError{Op: op, Path: p, Err: fmt.Errorf("%s in extended attribute block: %w", attr, filesystem.ErrNotImplemented)}
+	}
+	i := slices.IndexFunc(inInode, func(e *xattrEntry) bool { return e.fullName() == attr })
+	entries, err := update(inInode, i)
+	if err != nil {
+		return &iofs.PathError{Op: op, Path: p, Err: err}
+	}
+	b, err := inodeXattrsToBytes(entries, len(in.xattrs))
+	if err != nil {
+		return &iofs.PathError{Op: op, Path: p, Err: fmt.Errorf("%w: %w", filesystem.ErrNoSpace, err)}
+	}
+	in.xattrs = b
+	if err := fs.writeInode(in); err != nil {
+		return &iofs.PathError{Op: op, Path: p, Err: err}
+	}
+	if !fs.superblock.features.extendedAttributes {
+		fs.superblock.features.extendedAttributes = true
+		return fs.writeSuperblock()
+	}
+	return nil
+}
+
+// lstatInode reads the inode of the file at the absolute path, without following symbolic links
+func (fs *FileSystem) lstatInode(p string) (*inode, error) {
+	_, entry, err := fs.getEntryAndParent(p)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, iofs.ErrNotExist
+	}
+	in, err := fs.readInode(entry.inode)
+	if err != nil {
+		return nil, fmt.Errorf("could not read inode %d of %s: %w", entry.inode, p, err)
+	}
+	return in, nil
+}
+
+// readXattrs reads the extended attributes of the inode, those in its own space and those in its
+// extended attribute block
+func (fs *FileSystem) readXattrs(in *inode) (inInode, inBlock []*xattrEntry, err error) {
+	inInode, err = parseInodeXattrs(in.xattrs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid extended attributes in inode %d: %w", in.number, err)
+	}
+	if in.extendedAttributeBlock == 0 {
+		return inInode, nil, nil
+	}
+	b, err := fs.readBlock(in.extendedAttributeBlock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read extended attribute block of inode %d: %w", in.number, err)
+	}
+	inBlock, err = parseXattrBlock(b)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid extended attribute block %d of inode %d: %w", in.extendedAttributeBlock, in.number, err)
+	}
+	return inInode, inBlock, nil
 }
 
 // SetLabel changes the label on the writable filesystem. Different file system may hav different
 // length constraints.
 func (fs *FileSystem) SetLabel(label string) error {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
 	fs.superblock.volumeLabel = label
 	return fs.writeSuperblock()
 }
 
+// writableBackend returns the backend for writing, or an error that is
+// filesystem.ErrReadOnlyFilesystem if the backend is read-only
+func writableBackend(b backend.Storage) (backend.WritableFile, error) {
+	w, err := b.Writable()
+	if errors.Is(err, backend.ErrIncorrectOpenMode) {
+		return nil, fmt.Errorf("%w: %w", filesystem.ErrReadOnlyFilesystem, err)
+	}
+	return w, err
+}
+
 // readInode read a single inode from disk
 func (fs *FileSystem) readInode(inodeNumber uint32) (*inode, error) {
 	if inodeNumber == 0 {
@@ -1110,34 +1750,31 @@ func (fs *FileSystem) readInode(inodeNumber uint32) (*inode, error) {
 	// read the group descriptor to find out the location of the inode table
 	gd := fs.groupDescriptors.descriptors[bg]
 	inodeTableBlock := gd.inodeTableLocation
-	inodeBytes := make([]byte, inodeSize)
-	// bytesStart is beginning byte for the inodeTableBlock
-	byteStart := inodeTableBlock * uint64(sb.blockSize)
 	// offsetInode is how many inodes in our inode is
 	offsetInode := (inodeNumber - 1) % inodesPerGroup
 	// offset is how many bytes in our inode is
-	offset := offsetInode * uint32(inodeSize)
-	read, err := fs.backend.ReadAt(inodeBytes, int64(byteStart)+int64(offset))
+	offset := uint64(offsetInode) * uint64(inodeSize)
+	// read the whole block of the inode table, along with the inodes next to it
+	blockSize := uint64(sb.blockSize)
+	b, err := fs.readBlock(inodeTableBlock + offset/blockSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read inode %d from offset %d of block %d from block group %d: %v", inodeNumber, offset, inodeTableBlock, bg, err)
-	}
-	if read != int(inodeSize) {
-		return nil, fmt.Errorf("read %d bytes for inode %d instead of inode size of %d", read, inodeNumber, inodeSize)
+		return nil, fmt.Errorf("failed to read inode %d from offset %d of block %d from block group %d: %w", inodeNumber, offset, inodeTableBlock, bg, err)
 	}
+	inodeBytes := b[offset%blockSize : offset%blockSize+uint64(inodeSize)]
 	inode, err := inodeFromBytes(inodeBytes, sb, inodeNumber)
 	if err != nil {
-		return nil, fmt.Errorf("could not interpret inode data: %v", err)
+		return nil, fmt.Errorf("could not interpret inode data: %w", err)
 	}
 	// fill in symlink target if needed
 	if inode.fileType == fileTypeSymbolicLink && inode.linkTarget == "" {
 		// read the symlink target
 		extents, err := inode.extents.blocks(fs)
 		if err != nil {
-			return nil, fmt.Errorf("could not read extent tree for symlink inode %d: %v", inodeNumber, err)
+			return nil, fmt.Errorf("could not read extent tree for symlink inode %d: %w", inodeNumber, err)
 		}
 		b, err := fs.readFileBytes(extents, inode.size)
 		if err != nil {
-			return nil, fmt.Errorf("could not read symlink target for inode %d: %v", inodeNumber, err)
+			return nil, fmt.Errorf("could not read symlink target for inode %d: %w", inodeNumber, err)
 		}
 		inode.linkTarget = string(b)
 	}
@@ -1146,7 +1783,7 @@ func (fs *FileSystem) readInode(inodeNumber uint32) (*inode, error) {
 
 // writeInode write a single inode to disk
 func (fs *FileSystem) writeInode(i *inode) error {
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 
 	if err != nil {
 		return err
@@ -1172,7 +1809,7 @@ func (fs *FileSystem) writeInode(i *inode) error {
 	inodeBytes := i.toBytes(sb)
 	wrote, err := writableFile.WriteAt(inodeBytes, int64(byteStart)+offset)
 	if err != nil {
-		return fmt.Errorf("failed to write inode %d at offset %d of block %d from block group %d: %v", i.number, offset, inodeTableBlock, bg, err)
+		return fmt.Errorf("failed to write inode %d at offset %d of block %d from block group %d: %w", i.number, offset, inodeTableBlock, bg, err)
 	}
 	if wrote != int(inodeSize) {
 		return fmt.Errorf("wrote %d bytes for inode %d instead of inode size of %d", wrote, i.number, inodeSize)
@@ -1185,7 +1822,7 @@ func (fs *FileSystem) readDirectory(inodeNumber uint32) ([]*directoryEntry, erro
 	// read the inode for the directory
 	in, err := fs.readInode(inodeNumber)
 	if err != nil {
-		return nil, fmt.Errorf("could not read inode %d for directory: %v", inodeNumber, err)
+		return nil, fmt.Errorf("could not read inode %d for directory: %w", inodeNumber, err)
 	}
 	// convert the extent tree into a sorted list of extents
 	extents, err := in.extents.blocks(fs)
@@ -1195,7 +1832,7 @@ func (fs *FileSystem) readDirectory(inodeNumber uint32) ([]*directoryEntry, erro
 	// read the contents of the file across all blocks
 	b, err := fs.readFileBytes(extents, in.size)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file bytes for inode %d: %v", inodeNumber, err)
+		return nil, fmt.Errorf("error reading file bytes for inode %d: %w", inodeNumber, err)
 	}
 
 	var dirEntries []*directoryEntry
@@ -1203,11 +1840,11 @@ func (fs *FileSystem) readDirectory(inodeNumber uint32) ([]*directoryEntry, erro
 	if in.flags.hashedDirectoryIndexes {
 		treeRoot, err := parseDirectoryTreeRoot(b[:fs.superblock.blockSize], fs.superblock.features.largeDirectory)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse directory tree root: %v", err)
+			return nil, fmt.Errorf("failed to parse directory tree root: %w", err)
 		}
 		subDirEntries, err := parseDirEntriesHashed(b, treeRoot.depth, treeRoot, fs.superblock.blockSize, fs.superblock.features.metadataChecksums, in.number, in.nfsFileVersion, fs.superblock.checksumSeed)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse hashed directory entries: %v", err)
+			return nil, fmt.Errorf("failed to parse hashed directory entries: %w", err)
 		}
 		// include the dot and dotdot entries from treeRoot; they do not show up in the hashed entries
 		dirEntries = []*directoryEntry{treeRoot.dotEntry, treeRoot.dotDotEntry}
@@ -1226,19 +1863,15 @@ func (fs *FileSystem) readFileBytes(extents extents, filesize uint64) ([]byte, e
 	// walk through each one, gobbling up the bytes
 	b := make([]byte, 0, fs.superblock.blockSize)
 	for i, e := range extents {
-		start := e.startingBlock * uint64(fs.superblock.blockSize)
 		count := uint64(e.count) * uint64(fs.superblock.blockSize)
 		if uint64(len(b))+count > filesize {
 			count = filesize - uint64(len(b))
 		}
-		b2 := make([]byte, count)
-		read, err := fs.backend.ReadAt(b2, int64(start))
+		b2, err := fs.readBlocks(e.startingBlock, (count+uint64(fs.superblock.blockSize)-1)/uint64(fs.superblock.blockSize))
 		if err != nil {
-			return nil, fmt.Errorf("failed to read bytes for extent %d: %v", i, err)
-		}
-		if read != int(count) {
-			return nil, fmt.Errorf("read %d bytes instead of %d for extent %d", read, count, i)
+			return nil, fmt.Errorf("failed to read bytes for extent %d: %w", i, err)
 		}
+		b2 = b2[:count]
 		b = append(b, b2...)
 		if uint64(len(b)) >= filesize {
 			break
@@ -1283,7 +1916,7 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, error
 				continue
 			}
 			if e.fileType != dirFileTypeDirectory {
-				return nil, fmt.Errorf("cannot create directory at %s since it is a file", "/"+strings.Join(paths[0:i+1], "/"))
+				return nil, fmt.Errorf("cannot create directory at %s since it is a file: %w", "/"+strings.Join(paths[0:i+1], "/"), filesystem.ErrNotDir)
 			}
 			// the filename matches, and it is a subdirectory, so we can break after saving the directory entry, which contains the inode
 			found = true
@@ -1300,14 +1933,14 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, error
 				var subdirEntry *directoryEntry
 				subdirEntry, err = fs.mkSubdir(currentDir, subp)
 				if err != nil {
-					return nil, fmt.Errorf("failed to create subdirectory %s", "/"+strings.Join(paths[0:i+1], "/"))
+					return nil, fmt.Errorf("failed to create subdirectory %s: %w", "/"+strings.Join(paths[0:i+1], "/"), err)
 				}
 				// save where we are to search next
 				currentDir = &Directory{
 					directoryEntry: *subdirEntry,
 				}
 			} else {
-				return nil, fmt.Errorf("path %s not found", "/"+strings.Join(paths[0:i+1], "/"))
+				return nil, fmt.Errorf("path %s not found: %w", "/"+strings.Join(paths[0:i+1], "/"), iofs.ErrNotExist)
 			}
 		}
 		// get all of the entries in this directory
@@ -1322,20 +1955,9 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, error
 	return currentDir, nil
 }
 
-// readBlock read a single block from disk
+// readBlock read a single block from disk, or the cache
 func (fs *FileSystem) readBlock(blockNumber uint64) ([]byte, error) {
-	sb := fs.superblock
-	// bytesStart is beginning byte for the inodeTableBlock
-	byteStart := blockNumber * uint64(sb.blockSize)
-	blockBytes := make([]byte, sb.blockSize)
-	read, err := fs.backend.ReadAt(blockBytes, int64(byteStart))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read block %d: %v", blockNumber, err)
-	}
-	if read != int(sb.blockSize) {
-		return nil, fmt.Errorf("read %d bytes for block %d instead of size of %d", read, blockNumber, sb.blockSize)
-	}
-	return blockBytes, nil
+	return fs.readBlocks(blockNumber, 1)
 }
 
 // recalculate blocksize based on the existing number of blocks
@@ -1408,10 +2030,13 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 	deFileType := dirFileTypeRegular
 	fileType := fileTypeRegularFile
 	var contentSize uint64
+	// a file has the link of its entry, a directory that of its "." too
+	hardLinks := uint16(1)
 	if isDir {
 		deFileType = dirFileTypeDirectory
 		fileType = fileTypeDirectory
 		contentSize = uint64(fs.superblock.blockSize)
+		hardLinks = 2
 	}
 	de := directoryEntry{
 		inode:    inodeNumber,
@@ -1443,7 +2068,6 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 		},
 		filesystem:  fs,
 		isReadWrite: true,
-		isAppend:    true,
 		offset:      0,
 		extents:     parentExtents,
 	}
@@ -1456,7 +2080,7 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 	}
 
 	// write the inode for the new entry out
-	now := time.Now()
+	now := util.Now()
 	in := inode{
 		number:                 inodeNumber,
 		permissionsGroup:       parentInode.permissionsGroup,
@@ -1466,7 +2090,7 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 		owner:                  parentInode.owner,
 		group:                  parentInode.group,
 		size:                   contentSize,
-		hardLinks:              2,
+		hardLinks:              hardLinks,
 		blocks:                 newExtents.blockCount(),
 		flags:                  &inodeFlags{},
 		nfsFileVersion:         0,
@@ -1515,7 +2139,6 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 			},
 			filesystem:  fs,
 			isReadWrite: true,
-			isAppend:    true,
 			offset:      0,
 			extents:     *newExtents,
 		}
@@ -1539,28 +2162,24 @@ func (fs *FileSystem) mkDirEntry(parent *Directory, name string, isDir bool) (*d
 //   - parent is  2 : child of root, will try to spread out
 //   - else         : try to collocate with parent, if possible
 func (fs *FileSystem) allocateInode(parent uint32) (uint32, error) {
-	var (
-		inodeNumber = -1
-	)
+	// the root inode is marked in the bitmap by Create
 	if parent == 0 {
-		inodeNumber = 2
+		return 2, nil
 	}
 	// load the inode bitmap
 	var (
-		bg int
-		gd groupDescriptor
+		inodeNumber = -1
+		bg          int
 	)
-
-	writableFile, err := fs.backend.Writable()
-	if err != nil {
+	if _, err := writableBackend(fs.backend); err != nil {
 		return 0, err
 	}
 
-	for _, gd = range fs.groupDescriptors.descriptors {
+	for i := range fs.groupDescriptors.descriptors {
 		if inodeNumber != -1 {
 			break
 		}
-		bg := int(gd.number)
+		bg = i
 		bm, err := fs.readInodeBitmap(bg)
 		if err != nil {
 			return 0, fmt.Errorf("could not read inode bitmap: %w", err)
@@ -1581,29 +2200,26 @@ func (fs *FileSystem) allocateInode(parent uint32) (uint32, error) {
 		}
 	}
 	if inodeNumber == -1 {
-		return 0, errors.New("no free inodes available")
+		return 0, fmt.Errorf("no free inodes available: %w", filesystem.ErrNoSpace)
 	}
 
-	// reduce number of free inodes in that descriptor in the group descriptor table
+	// reduce number of free inodes in that descriptor in the group descriptor table, and in the superblock
+	gd := &fs.groupDescriptors.descriptors[bg]
 	gd.freeInodes--
-
-	// get the group descriptor as bytes
-	gdBytes := gd.toBytes(fs.superblock.gdtChecksumType(), fs.superblock.uuid.ID())
-
-	// write the group descriptor bytes
-	// gdt starts in block 1 of any redundant copies, specifically in BG 0
-	gdtBlock := 1
-	blockByteLocation := gdtBlock * int(fs.superblock.blockSize)
-	gdOffset := fs.start + int64(blockByteLocation) + int64(bg)*int64(fs.superblock.groupDescriptorSize)
-	wrote, err := writableFile.WriteAt(gdBytes, gdOffset)
-	if err != nil {
-		return 0, fmt.Errorf("unable to write group descriptor bytes for blockgroup %d: %v", bg, err)
+	// the inodes after the last one used are not checked, so that one must not be after them
+	if unused := fs.superblock.inodesPerGroup - uint32(inodeNumber) - 1; gd.unusedInodes > unused {
+		gd.unusedInodes = unused
 	}
-	if wrote != len(gdBytes) {
-		return 0, fmt.Errorf("wrote only %d bytes instead of expected %d for group descriptor of block group %d", wrote, len(gdBytes), bg)
+	if err := fs.writeGroupDescriptor(gd); err != nil {
+		return 0, err
+	}
+	fs.superblock.freeInodes--
+	if err := fs.writeSuperblock(); err != nil {
+		return 0, fmt.Errorf("could not write superblock: %w", err)
 	}
 
-	return uint32(inodeNumber), nil
+	// the bitmap is relative to the block group, inodes are numbered from 1
+	return uint32(bg)*fs.superblock.inodesPerGroup + uint32(inodeNumber) + 1, nil
 }
 
 // allocateExtents allocate the data blocks in extents that are
@@ -1632,7 +2248,7 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 
 	// if there are not enough blocks left on the filesystem, return an error
 	if fs.superblock.freeBlocks < extraBlockCount {
-		return nil, fmt.Errorf("only %d blocks free, requires additional %d", fs.superblock.freeBlocks, extraBlockCount)
+		return nil, fmt.Errorf("only %d blocks free, requires additional %d: %w", fs.superblock.freeBlocks, extraBlockCount, filesystem.ErrNoSpace)
 	}
 
 	// now we need to look for as many contiguous blocks as possible
@@ -1646,6 +2262,7 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 	var (
 		newExtents       []extent
 		datablockBitmaps = map[int]*util.Bitmap{}
+		allocatedByGroup = map[int]uint64{}
 		blocksPerGroup   = fs.superblock.blocksPerGroup
 	)
 
@@ -1658,7 +2275,7 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 		// 3- find the maximum contiguous space available
 		bs, err := fs.readBlockBitmap(int(i))
 		if err != nil {
-			return nil, fmt.Errorf("could not read block bitmap for block group %d: %v", i, err)
+			return nil, fmt.Errorf("could not read block bitmap for block group %d: %w", i, err)
 		}
 		// now find our unused blocks and how many there are in a row as potential extents
 		if extraBlockCount > maxUint16 {
@@ -1696,6 +2313,7 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 			}
 			newExtents = append(newExtents, extentToAdd)
 			allocatedBlocks += uint64(extentToAdd.count)
+			allocatedByGroup[int(i)] += uint64(extentToAdd.count)
 			extraBlockCount -= uint64(extentToAdd.count)
 			// set the marked blocks in the bitmap, and save the bitmap
 			for block := extentToAdd.startingBlock; block < extentToAdd.startingBlock+uint64(extentToAdd.count); block++ {
@@ -1703,7 +2321,7 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 				// the extent lists the absolute block number, but the bitmap is relative to the block group
 				blockInGroup := block - uint64(i)*uint64(blocksPerGroup)
 				if err := bs.Set(int(blockInGroup)); err != nil {
-					return nil, fmt.Errorf("could not clear block bitmap for block %d: %v", i, err)
+					return nil, fmt.Errorf("could not clear block bitmap for block %d: %w", i, err)
 				}
 			}
 
@@ -1713,18 +2331,25 @@ func (fs *FileSystem) allocateExtents(size uint64, previous *extents) (*extents,
 		}
 	}
 	if extraBlockCount > 0 {
-		return nil, fmt.Errorf("could not allocate %d blocks", extraBlockCount)
+		return nil, fmt.Errorf("could not allocate %d blocks: %w", extraBlockCount, filesystem.ErrNoSpace)
 	}
 
 	// write the block bitmaps back to disk
 	for bg, bs := range datablockBitmaps {
 		if err := fs.writeBlockBitmap(bs, bg); err != nil {
-			return nil, fmt.Errorf("could not write block bitmap for block group %d: %v", bg, err)
+			return nil, fmt.Errorf("could not write block bitmap for block group %d: %w", bg, err)
 		}
 	}
 
-	// need to update the total blocks used/free in superblock
-	fs.superblock.freeBlocks -= allocated
+	// need to update the blocks used/free in the GDT and the total in superblock
+	for bg, count := range allocatedByGroup {
+		gd := &fs.groupDescriptors.descriptors[bg]
+		gd.freeBlocks -= uint32(count)
+		if err := fs.writeGroupDescriptor(gd); err != nil {
+			return nil, err
+		}
+		fs.superblock.freeBlocks -= count
+	}
 	// update the blockBitmapChecksum for any updated block groups in GDT
 	// write updated superblock and GDT to disk
 	if err := fs.writeSuperblock(); err != nil {
@@ -1767,7 +2392,7 @@ func (fs *FileSystem) writeInodeBitmap(bm *util.Bitmap, group int) error {
 	if group >= len(fs.groupDescriptors.descriptors) {
 		return fmt.Errorf("block group %d does not exist", group)
 	}
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 	if err != nil {
 		return err
 	}
@@ -1783,8 +2408,12 @@ func (fs *FileSystem) writeInodeBitmap(bm *util.Bitmap, group int) error {
 	if wrote != int(bitmapByteCount) {
 		return fmt.Errorf("wrote %d bytes instead of expected %d for inode bitmap of block group %d", wrote, bitmapByteCount, gd.number)
 	}
-
-	return nil
+	if !fs.superblock.features.metadataChecksums {
+		return nil
+	}
+	// the checksum of the bitmap is in the group descriptor
+	fs.groupDescriptors.descriptors[group].inodeBitmapChecksum = crc.CRC32c(fs.superblock.checksumSeed, b[:bitmapByteCount])
+	return fs.writeGroupDescriptor(&fs.groupDescriptors.descriptors[group])
 }
 
 func (fs *FileSystem) readBlockBitmap(group int) (*util.Bitmap, error) {
@@ -1813,7 +2442,7 @@ func (fs *FileSystem) writeBlockBitmap(bm *util.Bitmap, group int) error {
 	if group >= len(fs.groupDescriptors.descriptors) {
 		return fmt.Errorf("block group %d does not exist", group)
 	}
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 	if err != nil {
 		return err
 	}
@@ -1828,18 +2457,41 @@ func (fs *FileSystem) writeBlockBitmap(bm *util.Bitmap, group int) error {
 	if wrote != int(fs.superblock.blockSize) {
 		return fmt.Errorf("wrote %d bytes instead of expected %d for block bitmap of block group %d", wrote, fs.superblock.blockSize, gd.number)
 	}
+	if !fs.superblock.features.metadataChecksums {
+		return nil
+	}
+	// the checksum of the bitmap is in the group descriptor
+	fs.groupDescriptors.descriptors[group].blockBitmapChecksum = crc.CRC32c(fs.superblock.checksumSeed, b[:fs.superblock.blocksPerGroup/8])
+	return fs.writeGroupDescriptor(&fs.groupDescriptors.descriptors[group])
+}
 
+// writeGroupDescriptor writes the descriptor of a block group to the table in block group 0
+func (fs *FileSystem) writeGroupDescriptor(gd *groupDescriptor) error {
+	writableFile, err := fs.writableBackend()
+	if err != nil {
+		return err
+	}
+	gdBytes := gd.toBytes(fs.superblock.gdtChecksumType(), fs.superblock.checksumSeed)
+	// the table follows the superblock, which is in block 1 when blocks are of 1024 bytes
+	gdtBlock := 1
+	if fs.superblock.blockSize == 1024 {
+		gdtBlock = 2
+	}
+	offset := fs.start + int64(gdtBlock)*int64(fs.superblock.blockSize) + int64(gd.number)*int64(fs.superblock.groupDescriptorSize)
+	if _, err := writableFile.WriteAt(gdBytes, offset); err != nil {
+		return fmt.Errorf("unable to write group descriptor bytes for blockgroup %d: %w", gd.number, err)
+	}
 	return nil
 }
 
 func (fs *FileSystem) writeSuperblock() error {
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 	if err != nil {
 		return err
 	}
 	superblockBytes, err := fs.superblock.toBytes()
 	if err != nil {
-		return fmt.Errorf("could not convert superblock to bytes: %v", err)
+		return fmt.Errorf("could not convert superblock to bytes: %w", err)
 	}
 	_, err = writableFile.WriteAt(superblockBytes, fs.start+int64(BootSectorSize))
 	return err
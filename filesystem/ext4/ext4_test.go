@@ -3,17 +3,23 @@ package ext4
 import (
 	"bytes"
 	"cmp"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/go-test/deep"
 )
 
@@ -410,22 +416,539 @@ func TestMkdir(t *testing.T) {
 				if err != nil {
 					t.Fatalf("Error reading directory: %v", err)
 				}
-				if len(entries) < 2 {
-					t.Fatalf("expected at least 2 entries in directory, for . and .. , got %d", len(entries))
-				}
-				if entries[0].Name() != "." {
-					t.Errorf("expected . entry in directory")
-				}
-				if entries[1].Name() != ".." {
-					t.Errorf("expected .. entry in directory")
+				for _, e := range entries {
+					if e.Name() == "." || e.Name() == ".." {
+						t.Errorf("unexpected %s entry in directory", e.Name())
+					}
 				}
-				if !entries[0].IsDir() {
-					t.Errorf("expected . entry to be a directory")
+				info, err := fs.Stat(tt.path)
+				if err != nil {
+					t.Fatalf("Error getting info of directory: %v", err)
 				}
-				if !entries[1].IsDir() {
-					t.Errorf("expected .. entry to be a directory")
+				if !info.IsDir() {
+					t.Errorf("expected %s to be a directory", tt.path)
 				}
 			}
 		})
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+	fs, err := Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	if _, err := fs.OpenFile("/shortfile.txt", os.O_RDONLY); err != nil {
+		t.Errorf("Error opening file for reading: %v", err)
+	}
+	ops := map[string]func() error{
+		"Mkdir": func() error { return fs.Mkdir("/other") },
+		"OpenFile create": func() error {
+			_, err := fs.OpenFile("/new.txt", os.O_CREATE|os.O_RDWR)
+			return err
+		},
+		"OpenFile write": func() error {
+			_, err := fs.OpenFile("/shortfile.txt", os.O_WRONLY)
+			return err
+		},
+		"Remove":   func() error { return fs.Remove("/shortfile.txt") },
+		"Rename":   func() error { return fs.Rename("/shortfile.txt", "/other.txt") },
+		"Truncate": func() error { return fs.Truncate("/shortfile.txt", 0) },
+		"SetLabel": func() error { return fs.SetLabel("other") },
+		"Chmod":    func() error { return fs.Chmod("/shortfile.txt", 0o600) },
+		"Chown":    func() error { return fs.Chown("/shortfile.txt", 1000, 1000) },
+		"Chtimes":  func() error { return fs.Chtimes("/shortfile.txt", time.Time{}, time.Now()) },
+	}
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+			t.Errorf("%s: error %v instead of read-only filesystem", name, err)
+		}
+	}
+}
+
+func TestChmodChownChtimes(t *testing.T) {
+	outfile := testCreateImgCopy(t)
+	f, err := os.OpenFile(outfile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, false), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	atime := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	mtime := time.Date(2020, time.February, 3, 4, 5, 6, 0, time.UTC)
+	if err := fs.Chmod("/shortfile.txt", 0o600); err != nil {
+		t.Fatalf("Error changing mode: %v", err)
+	}
+	if err := fs.Chown("/shortfile.txt", 1000, -1); err != nil {
+		t.Fatalf("Error changing owner: %v", err)
+	}
+	if err := fs.Chtimes("/shortfile.txt", atime, mtime); err != nil {
+		t.Fatalf("Error changing times: %v", err)
+	}
+	if err := fs.Chmod("/nonexistent.txt", 0o600); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("error %v instead of %v", err, iofs.ErrNotExist)
+	}
+
+	// read it all back from the image
+	fs, err = Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	info, err := fs.Stat("shortfile.txt")
+	if err != nil {
+		t.Fatalf("Error getting info: %v", err)
+	}
+	if info.Mode() != 0o600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("mode %v, time %v instead of %v, %v", info.Mode(), info.ModTime(), iofs.FileMode(0o600), mtime)
+	}
+	_, entry, err := fs.getEntryAndParent("/shortfile.txt")
+	if err != nil {
+		t.Fatalf("Error finding file: %v", err)
+	}
+	in, err := fs.readInode(entry.inode)
+	if err != nil {
+		t.Fatalf("Error reading inode: %v", err)
+	}
+	if in.owner != 1000 || in.group != 0 || !in.accessTime.Equal(atime) {
+		t.Errorf("owner %d:%d, access time %v instead of 1000:0, %v", in.owner, in.group, in.accessTime, atime)
+	}
+}
+
+func TestRename(t *testing.T) {
+	outfile := testCreateImgCopy(t)
+	f, err := os.OpenFile(outfile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, false), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	for _, dir := range []string{"/a", "/b"} {
+		if err := fs.Mkdir(dir); err != nil {
+			t.Fatalf("Error creating directory %s: %v", dir, err)
+		}
+	}
+	renames := [][2]string{
+		{"/shortfile.txt", "/renamed.txt"},
+		{"/renamed.txt", "/a/moved.txt"},
+		{"/a", "/b/a"},
+	}
+	for _, r := range renames {
+		if err := fs.Rename(r[0], r[1]); err != nil {
+			t.Fatalf("Error renaming %s to %s: %v", r[0], r[1], err)
+		}
+	}
+	if err := fs.Rename("/b", "/b/a/b"); !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("error %v moving directory into itself instead of %v", err, iofs.ErrInvalid)
+	}
+	if err := fs.Rename("/random.dat", "/b"); !errors.Is(err, filesystem.ErrIsDir) {
+		t.Errorf("error %v replacing directory instead of %v", err, filesystem.ErrIsDir)
+	}
+	if err := fs.Rename("/missing", "/b/missing"); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("error %v renaming missing file instead of %v", err, iofs.ErrNotExist)
+	}
+
+	// read it all back from the image
+	fs, err = Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	if b, err := fs.ReadFile("b/a/moved.txt"); err != nil || string(b) != "This is a short file\n" {
+		t.Errorf("read %q, %v from moved file", b, err)
+	}
+	for _, name := range []string{"shortfile.txt", "renamed.txt", "a"} {
+		if _, err := fs.Stat(name); !errors.Is(err, iofs.ErrNotExist) {
+			t.Errorf("%s: error %v instead of %v", name, err, iofs.ErrNotExist)
+		}
+	}
+	_, parent, err := fs.getEntryAndParent("/b")
+	if err != nil {
+		t.Fatalf("Error reading directory: %v", err)
+	}
+	dir, err := fs.readDirWithMkdir("/b/a", false)
+	if err != nil {
+		t.Fatalf("Error reading moved directory: %v", err)
+	}
+	for _, e := range dir.entries {
+		if e.filename == ".." && e.inode != parent.inode {
+			t.Errorf("moved directory has parent inode %d instead of %d", e.inode, parent.inode)
+		}
+	}
+}
+
+func TestLstatReadlink(t *testing.T) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	for name, target := range map[string]string{"/symlink.dat": "random.dat", "absolutesymlink": "/random.dat"} {
+		info, err := fs.Lstat(name)
+		if err != nil || info.Mode() != iofs.ModeSymlink|0o777 {
+			t.Errorf("%s: described as %v, %v", name, info, err)
+		}
+		if actual, err := fs.Readlink(name); err != nil || actual != target {
+			t.Errorf("%s: symbolic link to %q, %v instead of %q", name, actual, err, target)
+		}
+		info, err = fs.Stat(name)
+		if err != nil || info.Mode() != 0o644 || info.Name() != path.Base(name) {
+			t.Errorf("%s: target described as %v, %v", name, info, err)
+		}
+	}
+	if _, err := fs.Readlink("random.dat"); !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("error %v reading regular file instead of %v", err, iofs.ErrInvalid)
+	}
+	if _, err := fs.Stat("deadlink"); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("error %v describing dead link instead of %v", err, iofs.ErrNotExist)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	type walked struct {
+		path  string
+		isDir bool
+		size  int64
+	}
+	walk := func(walkDir func(root string, fn iofs.WalkDirFunc) error, root string) []walked {
+		var list []walked
+		err := walkDir(root, func(p string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			list = append(list, walked{p, d.IsDir(), info.Size()})
+			if p == "/foo" {
+				return iofs.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error walking %s: %v", root, err)
+		}
+		return list
+	}
+	for _, root := range []string{"/", "/foo", "/random.dat"} {
+		fast := walk(fs.WalkDir, root)
+		generic := walk(func(root string, fn iofs.WalkDirFunc) error {
+			return filesystem.GenericWalkDir(fs, root, fn)
+		}, root)
+		if diff := deep.Equal(fast, generic); diff != nil {
+			t.Errorf("walking %s: WalkDir and GenericWalkDir mismatched: %v", root, diff)
+		}
+		if len(fast) == 0 || fast[0].path != root {
+			t.Errorf("walking %s: did not start at the root", root)
+		}
+	}
+	if list := walk(fs.WalkDir, "/foo"); len(list) != 1 {
+		t.Errorf("walked %d entries in /foo after SkipDir instead of 1", len(list))
+	}
+	err = fs.WalkDir("/missing", func(_ string, _ iofs.DirEntry, err error) error {
+		return err
+	})
+	if !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("error %v walking missing directory instead of %v", err, iofs.ErrNotExist)
+	}
+}
+
+func TestReadDirMode(t *testing.T) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("Error reading directory: %v", err)
+	}
+	expected := map[string]iofs.FileMode{
+		"foo":         iofs.ModeDir | 0o755,
+		"lost+found":  iofs.ModeDir | 0o700,
+		"random.dat":  0o644,
+		"symlink.dat": iofs.ModeSymlink | 0o777,
+	}
+	for _, e := range entries {
+		mode, ok := expected[e.Name()]
+		if !ok {
+			continue
+		}
+		delete(expected, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			t.Fatalf("Error getting info of %s: %v", e.Name(), err)
+		}
+		if info.Mode() != mode || e.Type() != mode.Type() {
+			t.Errorf("%s: mode %v and type %v instead of %v", e.Name(), info.Mode(), e.Type(), mode)
+		}
+	}
+	for name := range expected {
+		t.Errorf("%s not found", name)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	f, err := os.Open(imgFile)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	findings, err := fs.Check(filesystem.CheckOptions{})
+	if err != nil {
+		t.Fatalf("Error checking filesystem: %v", err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("findings %v in a sound filesystem", findings)
+	}
+}
+
+func TestAllocatedRanges(t *testing.T) {
+	b, err := os.ReadFile(imgFile)
+	if err != nil {
+		t.Fatalf("Error reading test image: %v", err)
+	}
+	fs, err := Read(mem.New(b, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	ranges, err := fs.AllocatedRanges()
+	if err != nil {
+		t.Fatalf("Error getting allocated ranges: %v", err)
+	}
+	// copy only the ranges, which must be enough to read the files
+	copied := make([]byte, len(b))
+	var total, end int64
+	for i, r := range ranges {
+		if r.Length <= 0 || (i > 0 && r.Offset <= end) || r.End() > int64(len(b)) {
+			t.Fatalf("range %d %+v out of order or of the image", i, r)
+		}
+		copy(copied[r.Offset:r.End()], b[r.Offset:r.End()])
+		total += r.Length
+		end = r.End()
+	}
+	used := int64(fs.superblock.blockCount-fs.superblock.freeBlocks) * int64(fs.superblock.blockSize)
+	if total < used || total >= int64(len(b)) {
+		t.Errorf("%d bytes allocated for %d bytes used of %d", total, used, len(b))
+	}
+	clone, err := Read(mem.New(copied, true), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading allocated ranges as filesystem: %v", err)
+	}
+	expected, err := os.ReadFile(randomDataFile)
+	if err != nil {
+		t.Fatalf("Error reading %s: %v", randomDataFile, err)
+	}
+	actual, err := clone.ReadFile("random.dat")
+	if err != nil {
+		t.Fatalf("Error reading file of allocated ranges: %v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("mismatched contents of file of allocated ranges")
+	}
+}
+
+func TestAllocatedRangesBigalloc(t *testing.T) {
+	// a filesystem whose block bitmaps are of clusters of 16 blocks, with a file spanning many
+	dir := t.TempDir()
+	data := make([]byte, 300*1024+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "root"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "root", "data.bin"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	img := filepath.Join(dir, "bigalloc.img")
+	out, err := exec.Command("mke2fs", "-q", "-t", "ext4", "-O", "bigalloc", "-C", "16384", "-d", filepath.Join(dir, "root"), "-F", img, "64M").CombinedOutput()
+	if err != nil {
+		t.Skipf("could not create bigalloc filesystem: %v: %s", err, out)
+	}
+	b, err := os.ReadFile(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := Read(mem.New(b, true), int64(len(b)), 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	ranges, err := fs.AllocatedRanges()
+	if err != nil {
+		t.Fatalf("Error getting allocated ranges: %v", err)
+	}
+	copied := make([]byte, len(b))
+	var total, end int64
+	for i, r := range ranges {
+		if r.Length <= 0 || (i > 0 && r.Offset <= end) || r.End() > int64(len(b)) {
+			t.Fatalf("range %d %+v out of order or of the image", i, r)
+		}
+		copy(copied[r.Offset:r.End()], b[r.Offset:r.End()])
+		total += r.Length
+		end = r.End()
+	}
+	used := int64(fs.superblock.blockCount-fs.superblock.freeBlocks) * int64(fs.superblock.blockSize)
+	if total < used || total >= int64(len(b)) {
+		t.Errorf("%d bytes allocated for %d bytes used of %d", total, used, len(b))
+	}
+	clone, err := Read(mem.New(copied, true), int64(len(copied)), 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading allocated ranges as filesystem: %v", err)
+	}
+	actual, err := clone.ReadFile("data.bin")
+	if err != nil {
+		t.Fatalf("Error reading file of allocated ranges: %v", err)
+	}
+	if !bytes.Equal(actual, data) {
+		t.Errorf("mismatched contents of file of allocated ranges")
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	outfile := testCreateImgCopy(t)
+	f, err := os.OpenFile(outfile, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Error opening test image: %v", err)
+	}
+	defer f.Close()
+
+	fs, err := Read(file.New(f, false), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	if attrs, err := fs.Listxattr("/shortfile.txt"); err != nil || len(attrs) != 0 {
+		t.Fatalf("extended attributes %v, %v of a file without them", attrs, err)
+	}
+	// an ACL granting read to user 1000, as passed to setxattr
+	acl := []byte{
+		2, 0, 0, 0,
+		0x01, 0, 6, 0, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0, 4, 0, 0xe8, 0x03, 0, 0,
+		0x04, 0, 4, 0, 0xff, 0xff, 0xff, 0xff,
+		0x10, 0, 4, 0, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0, 4, 0, 0xff, 0xff, 0xff, 0xff,
+	}
+	xattrs := map[string][]byte{
+		"user.comment":            []byte("hi"),
+		"system.posix_acl_access": acl,
+	}
+	for attr, value := range xattrs {
+		if err := fs.Setxattr("/shortfile.txt", attr, value); err != nil {
+			t.Fatalf("Error setting %s: %v", attr, err)
+		}
+	}
+	// replaced
+	if err := fs.Setxattr("/shortfile.txt", "user.comment", []byte("hello")); err != nil {
+		t.Fatalf("Error setting user.comment: %v", err)
+	}
+	xattrs["user.comment"] = []byte("hello")
+	if err := fs.Setxattr("/shortfile.txt", "user.large", make([]byte, 1000)); !errors.Is(err, filesystem.ErrNoSpace) {
+		t.Errorf("error %v setting a value larger than the inode instead of %v", err, filesystem.ErrNoSpace)
+	}
+	if err := fs.Setxattr("/shortfile.txt", "unknown.name", nil); !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("error %v setting an attribute out of the namespaces instead of %v", err, iofs.ErrInvalid)
+	}
+
+	// read it all back from the image
+	fs, err = Read(file.New(f, false), 100*MB, 0, 512)
+	if err != nil {
+		t.Fatalf("Error reading filesystem: %v", err)
+	}
+	attrs, err := fs.Listxattr("/shortfile.txt")
+	if expected := []string{"system.posix_acl_access", "user.comment"}; err != nil || !slices.Equal(attrs, expected) {
+		t.Errorf("extended attributes %v, %v instead of %v", attrs, err, expected)
+	}
+	for attr, value := range xattrs {
+		if b, err := fs.Getxattr("/shortfile.txt", attr); err != nil || !bytes.Equal(b, value) {
+			t.Errorf("%s is %v, %v instead of %v", attr, b, err, value)
+		}
+	}
+	if b, err := fs.ReadFile("shortfile.txt"); err != nil || string(b) != "This is a short file\n" {
+		t.Errorf("read %q, %v after setting extended attributes", b, err)
+	}
+	// the ACL is held in the shorter format of ext4
+	in, err := fs.lstatInode("/shortfile.txt")
+	if err != nil {
+		t.Fatalf("Error reading inode: %v", err)
+	}
+	entries, err := parseInodeXattrs(in.xattrs)
+	if err != nil {
+		t.Fatalf("Error parsing extended attributes: %v", err)
+	}
+	i := slices.IndexFunc(entries, func(e *xattrEntry) bool { return e.index == xattrIndexPosixACLAccess })
+	if i < 0 || len(entries[i].value) != 4+4+8+4+4+4 || entries[i].name != "" {
+		t.Errorf("ACL held as %+v", entries)
+	}
+
+	if err := fs.Removexattr("/shortfile.txt", "user.comment"); err != nil {
+		t.Fatalf("Error removing user.comment: %v", err)
+	}
+	if _, err := fs.Getxattr("/shortfile.txt", "user.comment"); !errors.Is(err, filesystem.ErrNoXattr) {
+		t.Errorf("error %v getting a removed attribute instead of %v", err, filesystem.ErrNoXattr)
+	}
+	if err := fs.Removexattr("/shortfile.txt", "user.comment"); !errors.Is(err, filesystem.ErrNoXattr) {
+		t.Errorf("error %v removing a removed attribute instead of %v", err, filesystem.ErrNoXattr)
+	}
+	if _, err := fs.Listxattr("/nonexistent.txt"); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("error %v instead of %v", err, iofs.ErrNotExist)
+	}
+}
+
+func TestParseXattrBlock(t *testing.T) {
+	b := make([]byte, 1024)
+	copy(b, []byte{0x00, 0x00, 0x02, 0xea, 1, 0, 0, 0, 1, 0, 0, 0})
+	// security.selinux, with its value at the end of the block
+	value := "system_u:object_r:bin_t:s0\x00"
+	copy(b[xattrBlockHeaderSize:], []byte{7, xattrIndexSecurity, 0, 0, 0, 0, 0, 0, byte(len(value)), 0, 0, 0})
+	binary.LittleEndian.PutUint16(b[xattrBlockHeaderSize+2:], uint16(1024-32))
+	copy(b[xattrBlockHeaderSize+xattrEntryHeaderSize:], "selinux")
+	copy(b[1024-32:], value)
+	entries, err := parseXattrBlock(b)
+	if err != nil {
+		t.Fatalf("Error parsing block: %v", err)
+	}
+	if len(entries) != 1 || entries[0].fullName() != "security.selinux" || string(entries[0].value) != value {
+		t.Errorf("entries %+v", entries)
+	}
+	if _, err := parseXattrBlock(make([]byte, 1024)); err == nil {
+		t.Error("no error parsing a block without magic")
+	}
+}
@@ -680,7 +680,7 @@ func writeNodeToDisk(node extentBlockFinder, fs *FileSystem, parent *extentInter
 		return fmt.Errorf("block number not found for node")
 	}
 
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := fs.writableBackend()
 	if err != nil {
 		return err
 	}
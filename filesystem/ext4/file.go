@@ -3,6 +3,10 @@ package ext4
 import (
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"os"
+
+	"github.com/diskfs/go-diskfs/filesystem"
 )
 
 // File represents a single file in an ext4 filesystem
@@ -22,18 +26,35 @@ type File struct {
 // reads from the last known offset in the file from last read or write
 // use Seek() to set at a particular point
 func (fl *File) Read(b []byte) (int, error) {
+	n, err := fl.readAt(b, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.ReaderAt. It returns io.EOF with fewer bytes when the file
+// ends, and is safe for concurrent use.
+func (fl *File) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("cannot read at offset %d before start of file", off)
+	}
+	return fl.readAt(b, off)
+}
+
+// readAt reads up to len(b) bytes from offset, returning io.EOF if it reaches the end of the file
+func (fl *File) readAt(b []byte, offset int64) (int, error) {
 	var (
 		fileSize  = int64(fl.size)
 		blocksize = uint64(fl.filesystem.superblock.blockSize)
 	)
-	if fl.offset >= fileSize {
+	if offset >= fileSize {
 		return 0, io.EOF
 	}
 
 	// Calculate the number of bytes to read
 	bytesToRead := int64(len(b))
-	if fl.offset+bytesToRead > fileSize {
-		bytesToRead = fileSize - fl.offset
+	if offset+bytesToRead > fileSize {
+		bytesToRead = fileSize - offset
 	}
 
 	// Create a buffer to hold the bytes to be read
@@ -42,16 +63,16 @@ func (fl *File) Read(b []byte) (int, error) {
 
 	// the offset given for reading is relative to the file, so we need to calculate
 	// where these are in the extents relative to the file
-	readStartBlock := uint64(fl.offset) / blocksize
+	readStartBlock := uint64(offset) / blocksize
 	for _, e := range fl.extents {
-		// if the last block of the extent is before the first block we want to read, skip it
-		if uint64(e.fileBlock)+uint64(e.count) < readStartBlock {
+		// if the extent ends before the first block we want to read, skip it
+		if uint64(e.fileBlock)+uint64(e.count) <= readStartBlock {
 			continue
 		}
 		// extentSize is the number of bytes on the disk for the extent
 		extentSize := int64(e.count) * int64(blocksize)
 		// where do we start and end in the extent?
-		startPositionInExtent := fl.offset - int64(e.fileBlock)*int64(blocksize)
+		startPositionInExtent := offset - int64(e.fileBlock)*int64(blocksize)
 		leftInExtent := extentSize - startPositionInExtent
 		// how many bytes are left to read
 		toReadInOffset := bytesToRead - readBytes
@@ -63,18 +84,18 @@ func (fl *File) Read(b []byte) (int, error) {
 		b2 := make([]byte, toReadInOffset)
 		read, err := fl.filesystem.backend.ReadAt(b2, int64(startPosOnDisk))
 		if err != nil {
-			return int(readBytes), fmt.Errorf("failed to read bytes: %v", err)
+			return int(readBytes), fmt.Errorf("failed to read bytes: %w", err)
 		}
 		copy(b[readBytes:], b2[:read])
 		readBytes += int64(read)
-		fl.offset += int64(read)
+		offset += int64(read)
 
 		if readBytes >= bytesToRead {
 			break
 		}
 	}
 	var err error
-	if fl.offset >= fileSize {
+	if offset >= fileSize {
 		err = io.EOF
 	}
 
@@ -85,8 +106,69 @@ func (fl *File) Read(b []byte) (int, error) {
 // It returns the number of bytes written and an error, if any.
 // returns a non-nil error when n != len(b)
 // writes to the last known offset in the file from last read or write
-// use Seek() to set at a particular point
+// use Seek() to set at a particular point, except with O_APPEND where it writes at the end
 func (fl *File) Write(b []byte) (int, error) {
+	if fl.isAppend {
+		// as for an os.File, every write goes to the end, wherever Seek left the offset
+		fl.offset = int64(fl.size)
+	}
+	n, err := fl.writeAt(b, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes len(b) bytes to the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.WriterAt, extending the file if needed. Like the other changes
+// to the filesystem, it is not safe for concurrent use. As for an os.File, it returns an error if
+// the file was opened with O_APPEND.
+func (fl *File) WriteAt(b []byte, off int64) (int, error) {
+	if fl.isAppend {
+		return 0, fmt.Errorf("cannot write at an offset to a file opened with O_APPEND: %w", iofs.ErrInvalid)
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("cannot write at offset %d before start of file", off)
+	}
+	return fl.writeAt(b, off)
+}
+
+// zeroes is the size of the chunks of zeroes written to grow files by Truncate
+const zeroes = 1024 * 1024
+
+// Truncate changes the size of the file, without changing the offset of Read and Write, like
+// os.File.Truncate. Growing it allocates blocks filled with zeroes; shrinking it keeps its blocks
+// allocated, as FileSystem.Truncate does.
+func (fl *File) Truncate(size int64) error {
+	if !fl.isReadWrite {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	if size < 0 {
+		return fmt.Errorf("cannot truncate to %d bytes: %w", size, iofs.ErrInvalid)
+	}
+	oldSize := int64(fl.size)
+	if size > oldSize {
+		// the blocks kept by shrinking, and new ones, may have other contents
+		b := make([]byte, min(size-oldSize, zeroes))
+		for offset := oldSize; offset < size; {
+			n, err := fl.writeAt(b[:min(size-offset, int64(len(b)))], offset)
+			if err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		return nil
+	}
+	if size == oldSize {
+		return nil
+	}
+	fl.size = uint64(size)
+	if err := fl.filesystem.writeInode(fl.inode); err != nil {
+		return fmt.Errorf("could not write inode: %w", err)
+	}
+	return nil
+}
+
+// writeAt writes b at offset, allocating the blocks needed and updating the size of the file
+func (fl *File) writeAt(b []byte, offset int64) (int, error) {
 	var (
 		fileSize           = int64(fl.size)
 		originalFileSize   = int64(fl.size)
@@ -101,16 +183,16 @@ func (fl *File) Write(b []byte) (int, error) {
 	// if adding these bytes goes past the filesize, update the inode filesize to the new size and write the inode
 	// if adding these bytes goes past the total number of blocks, add more blocks, update the inode block count and write the inode
 	// if the offset is greater than the filesize, update the inode filesize to the offset
-	if fl.offset >= fileSize {
-		fl.size = uint64(fl.offset)
+	if offset >= fileSize {
+		fl.size = uint64(offset)
 	}
 
 	// Calculate the number of bytes to write
 	bytesToWrite := int64(len(b))
 
-	offsetAfterWrite := fl.offset + bytesToWrite
+	offsetAfterWrite := offset + bytesToWrite
 	if offsetAfterWrite > int64(fl.size) {
-		fl.size = uint64(fl.offset + bytesToWrite)
+		fl.size = uint64(offset + bytesToWrite)
 	}
 
 	// calculate the number of blocks in the file post-write
@@ -144,22 +226,22 @@ func (fl *File) Write(b []byte) (int, error) {
 
 	// the offset given for reading is relative to the file, so we need to calculate
 	// where these are in the extents relative to the file
-	writeStartBlock := uint64(fl.offset) / blocksize
+	writeStartBlock := uint64(offset) / blocksize
 
-	writableFile, err := fl.filesystem.backend.Writable()
+	writableFile, err := fl.filesystem.writableBackend()
 	if err != nil {
 		return -1, err
 	}
 
 	for _, e := range fl.extents {
-		// if the last block of the extent is before the first block we want to write, skip it
-		if uint64(e.fileBlock)+uint64(e.count) < writeStartBlock {
+		// if the extent ends before the first block we want to write, skip it
+		if uint64(e.fileBlock)+uint64(e.count) <= writeStartBlock {
 			continue
 		}
 		// extentSize is the number of bytes on the disk for the extent
 		extentSize := int64(e.count) * int64(blocksize)
 		// where do we start and end in the extent?
-		startPositionInExtent := fl.offset - int64(e.fileBlock)*int64(blocksize)
+		startPositionInExtent := offset - int64(e.fileBlock)*int64(blocksize)
 		leftInExtent := extentSize - startPositionInExtent
 		// how many bytes are left in the extent?
 		toWriteInOffset := bytesToWrite - writtenBytes
@@ -172,25 +254,24 @@ func (fl *File) Write(b []byte) (int, error) {
 		copy(b2, b[writtenBytes:])
 		written, err := writableFile.WriteAt(b2, int64(startPosOnDisk))
 		if err != nil {
-			return int(writtenBytes), fmt.Errorf("failed to read bytes: %v", err)
+			return int(writtenBytes), fmt.Errorf("failed to read bytes: %w", err)
 		}
 		writtenBytes += int64(written)
-		fl.offset += int64(written)
+		offset += int64(written)
 
-		if written >= len(b) {
+		if writtenBytes >= bytesToWrite {
 			break
 		}
 	}
 
-	if fl.offset >= fileSize {
-		err = io.EOF
-	}
-
-	return int(writtenBytes), err
+	return int(writtenBytes), nil
 }
 
 // Seek set the offset to a particular point in the file
 func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
 	newOffset := int64(0)
 	switch whence {
 	case io.SeekStart:
@@ -199,6 +280,8 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 		newOffset = int64(fl.size) + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
+	default:
+		return fl.offset, fmt.Errorf("invalid whence %d: %w", whence, iofs.ErrInvalid)
 	}
 	if newOffset < 0 {
 		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
@@ -207,6 +290,21 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	return fl.offset, nil
 }
 
+// DataOffset returns the offset of the first block of the file in the filesystem, for
+// filesystem.LocatedFile, or -1 if it has no extents, e.g. its data is inline in its inode
+func (fl *File) DataOffset() int64 {
+	var first *extent
+	for i, e := range fl.extents {
+		if first == nil || e.fileBlock < first.fileBlock {
+			first = &fl.extents[i]
+		}
+	}
+	if first == nil || fl.filesystem == nil {
+		return -1
+	}
+	return int64(first.startingBlock) * fl.filesystem.BlockSize()
+}
+
 // Close close a file that is being read
 func (fl *File) Close() error {
 	*fl = File{}
@@ -13,6 +13,9 @@ type FileInfo struct {
 	name    string
 	size    int64
 	isDir   bool
+	// major and minor are the numbers of character and block devices
+	major uint32
+	minor uint32
 }
 
 // IsDir abbreviation for Mode().IsDir()
@@ -42,7 +45,17 @@ func (fi *FileInfo) Size() int64 {
 	return fi.size
 }
 
-// Sys underlying data source - not supported yet and so will return nil
+// Sys underlying data source, the FileInfo itself, which is a filesystem.Device
 func (fi *FileInfo) Sys() interface{} {
-	return nil
+	return fi
+}
+
+// Major the major number of a device file, or 0 for other files
+func (fi *FileInfo) Major() uint32 {
+	return fi.major
+}
+
+// Minor the minor number of a device file, or 0 for other files
+func (fi *FileInfo) Minor() uint32 {
+	return fi.minor
 }
@@ -3,6 +3,8 @@ package ext4
 import (
 	"encoding/binary"
 	"fmt"
+	"reflect"
+	"slices"
 	"time"
 
 	"github.com/diskfs/go-diskfs/filesystem/ext4/crc"
@@ -132,6 +134,11 @@ type inode struct {
 	project                uint32
 	extents                extentBlockFinder
 	linkTarget             string
+	// deviceMajor and deviceMinor are the numbers of character and block devices
+	deviceMajor uint32
+	deviceMinor uint32
+	// xattrs is the space of the inode after its fields, which holds extended attributes
+	xattrs []byte
 }
 
 //nolint:unused // will be used in the future, not yet
@@ -142,7 +149,13 @@ func (i *inode) equal(a *inode) bool {
 	if i == nil && a == nil {
 		return true
 	}
-	return *i == *a
+	return reflect.DeepEqual(i, a)
+}
+
+// fieldsSize is the size of the fields of the inode, those of ext2 and the extra ones, after which
+// come its extended attributes. inodeSize counts the extra fields from minInodeSize instead.
+func (i *inode) fieldsSize() int {
+	return int(ext2InodeSize) + int(i.inodeSize-minInodeSize)
 }
 
 // inodeFromBytes create an inode struct from bytes
@@ -185,7 +198,7 @@ func inodeFromBytes(b []byte, sb *superblock, number uint32) (*inode, error) {
 	copy(fileSize[4:8], b[0x6c:0x70])
 	copy(version[0:4], b[0x24:0x28])
 	copy(version[4:8], b[0x98:0x9c])
-	copy(extendedAttributeBlock[0:4], b[0x88:0x8c])
+	copy(extendedAttributeBlock[0:4], b[0x68:0x6c])
 	copy(extendedAttributeBlock[4:6], b[0x76:0x78])
 
 	// get the the times
@@ -246,19 +259,25 @@ func inodeFromBytes(b []byte, sb *superblock, number uint32) (*inode, error) {
 	copy(extentInfo, b[0x28:0x64])
 	// symlinks might store link target in extentInfo, or might store them elsewhere
 	var (
-		linkTarget string
-		allExtents extentBlockFinder
-		err        error
+		linkTarget               string
+		allExtents               extentBlockFinder
+		deviceMajor, deviceMinor uint32
+		err                      error
 	)
-	if fileType == fileTypeSymbolicLink && fileSizeNum < 60 {
+	switch {
+	case fileType == fileTypeSymbolicLink && fileSizeNum < 60:
 		linkTarget = string(extentInfo[:fileSizeNum])
-	} else {
+	case fileType == fileTypeCharacterDevice || fileType == fileTypeBlockDevice:
+		deviceMajor, deviceMinor = parseDevice(extentInfo)
+	case fileType == fileTypeFifo || fileType == fileTypeSocket:
+		// no data, nor anything held in place of the extents
+	default:
 		// parse the extent information in the inode to get the root of the extents tree
 		// we do not walk the entire tree, to get a slice of blocks for the file.
 		// If we want to do that, we call the extentBlockFinder.blocks() method
 		allExtents, err = parseExtents(extentInfo, sb.blockSize, 0, uint32(blocks))
 		if err != nil {
-			return nil, fmt.Errorf("error parsing extent tree: %v", err)
+			return nil, fmt.Errorf("error parsing extent tree: %w", err)
 		}
 	}
 
@@ -287,6 +306,11 @@ func inodeFromBytes(b []byte, sb *superblock, number uint32) (*inode, error) {
 		project:                binary.LittleEndian.Uint32(b[0x9c:0x100]),
 		extents:                allExtents,
 		linkTarget:             linkTarget,
+		deviceMajor:            deviceMajor,
+		deviceMinor:            deviceMinor,
+	}
+	if fieldsEnd := i.fieldsSize(); fieldsEnd < len(b) {
+		i.xattrs = slices.Clone(b[fieldsEnd:])
 	}
 	checksum := binary.LittleEndian.Uint32(checksumBytes)
 	actualChecksum := inodeChecksum(b, sb.checksumSeed, number, i.nfsFileVersion)
@@ -352,7 +376,14 @@ func (i *inode) toBytes(sb *superblock) []byte {
 	copy(b[0x1c:0x20], blocks[0:4])
 	binary.LittleEndian.PutUint32(b[0x20:0x24], i.flags.toInt())
 	copy(b[0x24:0x28], version[0:4])
-	copy(b[0x28:0x64], i.extents.toBytes())
+	switch {
+	case i.fileType == fileTypeCharacterDevice || i.fileType == fileTypeBlockDevice:
+		deviceToBytes(b[0x28:0x64], i.deviceMajor, i.deviceMinor)
+	case i.extents != nil:
+		copy(b[0x28:0x64], i.extents.toBytes())
+	case i.fileType == fileTypeSymbolicLink:
+		copy(b[0x28:0x64], i.linkTarget)
+	}
 	binary.LittleEndian.PutUint32(b[0x64:0x68], i.nfsFileVersion)
 	copy(b[0x68:0x6c], extendedAttributeBlock[0:4])
 	copy(b[0x6c:0x70], fileSize[4:8])
@@ -370,6 +401,9 @@ func (i *inode) toBytes(sb *superblock) []byte {
 	copy(b[0x8c:0x90], accessTime[4:8])
 	copy(b[0x90:0x94], createTime[0:4])
 	copy(b[0x94:0x98], createTime[4:8])
+	if fieldsEnd := i.fieldsSize(); fieldsEnd < len(b) {
+		copy(b[fieldsEnd:], i.xattrs)
+	}
 
 	actualChecksum := inodeChecksum(b, sb.checksumSeed, i.number, i.nfsFileVersion)
 	checksum := make([]byte, 4)
@@ -380,6 +414,26 @@ func (i *inode) toBytes(sb *superblock) []byte {
 	return b
 }
 
+// parseDevice returns the numbers of the device held in place of the extents of its inode. Linux
+// keeps the old encoding of 16 bits in the first word where both numbers are less than 256, and
+// the new one of 32 bits in the second word otherwise.
+func parseDevice(b []byte) (major, minor uint32) {
+	if dev := binary.LittleEndian.Uint32(b[0:4]); dev != 0 {
+		return (dev >> 8) & 0xff, dev & 0xff
+	}
+	dev := binary.LittleEndian.Uint32(b[4:8])
+	return (dev & 0xfff00) >> 8, (dev & 0xff) | ((dev >> 12) & 0xfff00)
+}
+
+// deviceToBytes writes the numbers of the device in place of the extents of its inode, like Linux
+func deviceToBytes(b []byte, major, minor uint32) {
+	if major < 256 && minor < 256 {
+		binary.LittleEndian.PutUint32(b[0:4], major<<8|minor)
+		return
+	}
+	binary.LittleEndian.PutUint32(b[4:8], (minor&0xff)|(major<<8)|((minor&^0xff)<<12))
+}
+
 func parseOwnerPermissions(mode uint16) filePermissions {
 	return filePermissions{
 		execute: mode&filePermissionsOwnerExecute == filePermissionsOwnerExecute,
@@ -316,7 +316,7 @@ func superblockFromBytes(b []byte) (*superblock, error) {
 
 	voluuid, err := uuid.FromBytes(b[0x68:0x78])
 	if err != nil {
-		return nil, fmt.Errorf("unable to read volume UUID: %v", err)
+		return nil, fmt.Errorf("unable to read volume UUID: %w", err)
 	}
 	sb.uuid = &voluuid
 	sb.volumeLabel = minString(b[0x78:0x88])
@@ -329,7 +329,7 @@ func superblockFromBytes(b []byte) (*superblock, error) {
 
 	journaluuid, err := uuid.FromBytes(b[0xd0:0xe0])
 	if err != nil {
-		return nil, fmt.Errorf("unable to read journal UUID: %v", err)
+		return nil, fmt.Errorf("unable to read journal UUID: %w", err)
 	}
 	sb.journalSuperblockUUID = &journaluuid
 	sb.journalInode = binary.LittleEndian.Uint32(b[0xe0:0xe4])
@@ -551,12 +551,12 @@ func (sb *superblock) toBytes() ([]byte, error) {
 
 	ab, err := stringToASCIIBytes(sb.volumeLabel, 16)
 	if err != nil {
-		return nil, fmt.Errorf("error converting volume label to bytes: %v", err)
+		return nil, fmt.Errorf("error converting volume label to bytes: %w", err)
 	}
 	copy(b[0x78:0x88], ab[0:16])
 	ab, err = stringToASCIIBytes(sb.lastMountedDirectory, 64)
 	if err != nil {
-		return nil, fmt.Errorf("error last mounted directory to bytes: %v", err)
+		return nil, fmt.Errorf("error last mounted directory to bytes: %w", err)
 	}
 	copy(b[0x88:0xc8], ab[0:64])
 
@@ -634,7 +634,7 @@ func (sb *superblock) toBytes() ([]byte, error) {
 	binary.LittleEndian.PutUint64(b[0x1a0:0x1a8], sb.errorFirstBlock)
 	errorFirstFunctionBytes, err := stringToASCIIBytes(sb.errorFirstFunction, 32)
 	if err != nil {
-		return nil, fmt.Errorf("error converting errorFirstFunction to bytes: %v", err)
+		return nil, fmt.Errorf("error converting errorFirstFunction to bytes: %w", err)
 	}
 	copy(b[0x1a8:0x1c8], errorFirstFunctionBytes)
 	binary.LittleEndian.PutUint32(b[0x1c8:0x1cc], sb.errorFirstLine)
@@ -643,13 +643,13 @@ func (sb *superblock) toBytes() ([]byte, error) {
 	binary.LittleEndian.PutUint64(b[0x1d8:0x1e0], sb.errorLastBlock)
 	errorLastFunctionBytes, err := stringToASCIIBytes(sb.errorLastFunction, 32)
 	if err != nil {
-		return nil, fmt.Errorf("error converting errorLastFunction to bytes: %v", err)
+		return nil, fmt.Errorf("error converting errorLastFunction to bytes: %w", err)
 	}
 	copy(b[0x1e0:0x200], errorLastFunctionBytes)
 
 	mountOptionsBytes, err := stringToASCIIBytes(sb.mountOptions, 64)
 	if err != nil {
-		return nil, fmt.Errorf("error converting mountOptions to bytes: %v", err)
+		return nil, fmt.Errorf("error converting mountOptions to bytes: %w", err)
 	}
 	copy(b[0x200:0x240], mountOptionsBytes)
 	binary.LittleEndian.PutUint32(b[0x240:0x244], sb.userQuotaInode)
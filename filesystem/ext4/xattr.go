@@ -0,0 +1,234 @@
+package ext4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+const (
+	// xattrMagic starts the extended attributes in an inode, and the header of their blocks
+	xattrMagic uint32 = 0xea020000
+	// xattrEntryHeaderSize is the size of an entry before its name
+	xattrEntryHeaderSize = 16
+	// xattrBlockHeaderSize is the size of the header of an extended attribute block
+	xattrBlockHeaderSize = 32
+
+	// versions of the ACLs, as held by ext4 and as passed to the xattr system calls
+	aclVersionExt4  uint32 = 1
+	aclVersionXattr uint32 = 2
+	// aclUndefinedID is the id of the entries of ACLs that apply to no user or group in particular
+	aclUndefinedID uint32 = 0xffffffff
+	// tags of the entries of ACLs for a given user or group, the only ones with an id
+	aclTagUser  uint16 = 0x02
+	aclTagGroup uint16 = 0x08
+)
+
+// indexes of the prefixes of the names of extended attributes, which entries hold without them
+const (
+	xattrIndexUser             uint8 = 1
+	xattrIndexPosixACLAccess   uint8 = 2
+	xattrIndexPosixACLDefault  uint8 = 3
+	xattrIndexTrusted          uint8 = 4
+	xattrIndexSecurity         uint8 = 6
+	xattrIndexSystem           uint8 = 7
+	xattrIndexSystemRichACL    uint8 = 8
+	xattrPrefixPosixACLAccess        = "system.posix_acl_access"
+	xattrPrefixPosixACLDefault       = "system.posix_acl_default"
+)
+
+// xattrPrefixes are the prefixes of the names of extended attributes by their index, the longest
+// first, so that the first that matches a name is its own
+var xattrPrefixes = []struct {
+	index  uint8
+	prefix string
+}{
+	{xattrIndexPosixACLDefault, xattrPrefixPosixACLDefault},
+	{xattrIndexPosixACLAccess, xattrPrefixPosixACLAccess},
+	{xattrIndexSystemRichACL, "system.richacl"},
+	{xattrIndexSecurity, "security."},
+	{xattrIndexTrusted, "trusted."},
+	{xattrIndexSystem, "system."},
+	{xattrIndexUser, "user."},
+}
+
+// xattrEntry is an extended attribute of an inode
+type xattrEntry struct {
+	index uint8
+	// name is the name without the prefix of its index
+	name  string
+	value []byte
+	// valueInode is the inode holding the value instead of the entry, for large values
+	valueInode uint32
+}
+
+// fullName is the name of the attribute with its prefix
+func (e *xattrEntry) fullName() string {
+	for _, p := range xattrPrefixes {
+		if p.index == e.index {
+			return p.prefix + e.name
+		}
+	}
+	return e.name
+}
+
+// size is the size of the entry, without its value
+func (e *xattrEntry) size() int {
+	return (xattrEntryHeaderSize + len(e.name) + 3) &^ 3
+}
+
+// newXattrEntry returns the entry of the extended attribute with its full name and value as
+// passed to the xattr system calls
+func newXattrEntry(attr string, value []byte) (*xattrEntry, error) {
+	for _, p := range xattrPrefixes {
+		name, ok := strings.CutPrefix(attr, p.prefix)
+		if !ok {
+			continue
+		}
+		if len(name) > 255 {
+			return nil, fmt.Errorf("name of extended attribute %s longer than 255 bytes", attr)
+		}
+		e := &xattrEntry{index: p.index, name: name, value: value}
+		if p.index == xattrIndexPosixACLAccess || p.index == xattrIndexPosixACLDefault {
+			acl, err := aclToExt4(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", attr, err)
+			}
+			e.value = acl
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("extended attribute %s not in a namespace of ext4", attr)
+}
+
+// xattrValue is the value of the entry as passed to the xattr system calls
+func (e *xattrEntry) xattrValue() ([]byte, error) {
+	if e.valueInode != 0 {
+		return nil, fmt.Errorf("value of %s in inode %d: %w", e.fullName(), e.valueInode, filesystem.ErrNotImplemented)
+	}
+	if e.index == xattrIndexPosixACLAccess || e.index == xattrIndexPosixACLDefault {
+		return aclFromExt4(e.value)
+	}
+	return e.value, nil
+}
+
+// parseXattrEntries parses the entries starting at start in b, until the 4 zero bytes after the
+// last one, with their values at their offset from base in b
+func parseXattrEntries(b []byte, start, base int) ([]*xattrEntry, error) {
+	var entries []*xattrEntry
+	for i := start; i+4 <= len(b) && binary.LittleEndian.Uint32(b[i:i+4]) != 0; {
+		if i+xattrEntryHeaderSize > len(b) {
+			return nil, fmt.Errorf("extended attribute entry at %d beyond the end", i)
+		}
+		nameLen := int(b[i])
+		e := &xattrEntry{index: b[i+1], valueInode: binary.LittleEndian.Uint32(b[i+4 : i+8])}
+		valueOffset := base + int(binary.LittleEndian.Uint16(b[i+2:i+4]))
+		valueSize := int(binary.LittleEndian.Uint32(b[i+8 : i+12]))
+		if i+xattrEntryHeaderSize+nameLen > len(b) {
+			return nil, fmt.Errorf("name of extended attribute entry at %d beyond the end", i)
+		}
+		e.name = string(b[i+xattrEntryHeaderSize : i+xattrEntryHeaderSize+nameLen])
+		if e.valueInode == 0 {
+			if valueOffset+valueSize > len(b) {
+				return nil, fmt.Errorf("value of extended attribute %s beyond the end", e.fullName())
+			}
+			e.value = slices.Clone(b[valueOffset : valueOffset+valueSize])
+		}
+		entries = append(entries, e)
+		i += e.size()
+	}
+	return entries, nil
+}
+
+// parseInodeXattrs parses the extended attributes in the space of an inode after its fields
+func parseInodeXattrs(b []byte) ([]*xattrEntry, error) {
+	if len(b) < 4 || binary.LittleEndian.Uint32(b[0:4]) != xattrMagic {
+		return nil, nil
+	}
+	return parseXattrEntries(b, 4, 4)
+}
+
+// parseXattrBlock parses the extended attributes of a block
+func parseXattrBlock(b []byte) ([]*xattrEntry, error) {
+	if len(b) < xattrBlockHeaderSize || binary.LittleEndian.Uint32(b[0:4]) != xattrMagic {
+		return nil, fmt.Errorf("invalid extended attribute block")
+	}
+	return parseXattrEntries(b, xattrBlockHeaderSize, 0)
+}
+
+// inodeXattrsToBytes returns the space of an inode of size bytes after its fields, with the
+// extended attributes, or an error if they do not fit
+func inodeXattrsToBytes(entries []*xattrEntry, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if len(entries) == 0 {
+		return b, nil
+	}
+	// the magic, the entries and the 4 zero bytes after them, with the values at the end
+	used := 4 + 4
+	for _, e := range entries {
+		used += e.size() + (len(e.value)+3)&^3
+	}
+	if used > size {
+		return nil, fmt.Errorf("%d bytes of extended attributes in an inode with room for %d", used, size)
+	}
+	binary.LittleEndian.PutUint32(b[0:4], xattrMagic)
+	entry, value := 4, size
+	for _, e := range entries {
+		value -= (len(e.value) + 3) &^ 3
+		copy(b[value:], e.value)
+		b[entry] = uint8(len(e.name))
+		b[entry+1] = e.index
+		// the offsets of values are from the first entry, and there is no hash in inodes
+		binary.LittleEndian.PutUint16(b[entry+2:entry+4], uint16(value-4))
+		binary.LittleEndian.PutUint32(b[entry+8:entry+12], uint32(len(e.value)))
+		copy(b[entry+xattrEntryHeaderSize:], e.name)
+		entry += e.size()
+	}
+	return b, nil
+}
+
+// aclFromExt4 converts an ACL held by ext4 to the format of the xattr system calls, where every
+// entry has an id
+func aclFromExt4(b []byte) ([]byte, error) {
+	if len(b) < 4 || binary.LittleEndian.Uint32(b[0:4]) != aclVersionExt4 {
+		return nil, fmt.Errorf("invalid ACL")
+	}
+	acl := binary.LittleEndian.AppendUint32(nil, aclVersionXattr)
+	for i := 4; i < len(b); {
+		if i+4 > len(b) {
+			return nil, fmt.Errorf("invalid ACL entry at %d", i)
+		}
+		tag := binary.LittleEndian.Uint16(b[i : i+2])
+		id := aclUndefinedID
+		acl = append(acl, b[i:i+4]...)
+		i += 4
+		if tag == aclTagUser || tag == aclTagGroup {
+			if i+4 > len(b) {
+				return nil, fmt.Errorf("invalid ACL entry at %d", i-4)
+			}
+			id = binary.LittleEndian.Uint32(b[i : i+4])
+			i += 4
+		}
+		acl = binary.LittleEndian.AppendUint32(acl, id)
+	}
+	return acl, nil
+}
+
+// aclToExt4 converts an ACL in the format of the xattr system calls to that held by ext4, where
+// only the entries of given users and groups have an id
+func aclToExt4(b []byte) ([]byte, error) {
+	if len(b) < 4 || (len(b)-4)%8 != 0 || binary.LittleEndian.Uint32(b[0:4]) != aclVersionXattr {
+		return nil, fmt.Errorf("invalid ACL")
+	}
+	acl := binary.LittleEndian.AppendUint32(nil, aclVersionExt4)
+	for i := 4; i < len(b); i += 8 {
+		acl = append(acl, b[i:i+4]...)
+		if tag := binary.LittleEndian.Uint16(b[i : i+2]); tag == aclTagUser || tag == aclTagGroup {
+			acl = append(acl, b[i+4:i+8]...)
+		}
+	}
+	return acl, nil
+}
@@ -0,0 +1,164 @@
+package fat32
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// codes of the findings of Check, beyond those of filesystem.GenericCheck
+const (
+	// CodeChainInvalid is a chain of clusters that leaves the table, reaches a free cluster or
+	// loops
+	CodeChainInvalid = "fat-chain-invalid"
+	// CodeCrossLinked is a cluster in the chains of two files
+	CodeCrossLinked = "fat-cross-linked"
+	// CodeChainLength is a chain of clusters too short or too long for the size of its file
+	CodeChainLength = "fat-chain-length"
+	// CodeParentInvalid is a ".." entry that is not the parent of its directory
+	CodeParentInvalid = "fat-parent-invalid"
+	// CodeLostClusters are allocated clusters that belong to no file
+	CodeLostClusters = "fat-lost-clusters"
+	// CodeFreeCount is a count of free clusters in the FS Information Sector that is not that of
+	// the table
+	CodeFreeCount = "fat-free-count"
+)
+
+// checker holds the state of Check while it walks the directories
+type checker struct {
+	fs       *FileSystem
+	findings []filesystem.Finding
+	// owners are the paths of the files that each cluster is in the chain of
+	owners map[uint32]string
+}
+
+// Check checks the chains of clusters of every file and directory in the table, and that every
+// allocated cluster belongs to one of them, after the checks of filesystem.GenericCheck, for
+// filesystem.Checker. The tables must already agree, or the filesystem cannot be read at all.
+func (fs *FileSystem) Check(opts filesystem.CheckOptions) ([]filesystem.Finding, error) {
+	findings, err := filesystem.GenericCheck(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{fs: fs, findings: findings, owners: map[uint32]string{}}
+	root := fs.table.rootDirCluster
+	if c.chain("/", root, 0, true) {
+		c.directory("/", root, 0)
+	}
+
+	// clusters beyond the end of the data region are never allocated
+	dataClusters := fs.dataClusters()
+	var free, lost uint32
+	for cluster := uint32(2); cluster < dataClusters; cluster++ {
+		switch {
+		case fs.table.clusters[cluster] == fs.table.unusedMarker:
+			free++
+		case c.owners[cluster] == "":
+			lost++
+		}
+	}
+	if lost > 0 {
+		c.add(filesystem.SeverityWarning, CodeLostClusters, "", "%d clusters are allocated but belong to no file", lost)
+	}
+	if count := fs.fsis.freeDataClustersCount; count != 0xffffffff && count != free {
+		c.add(filesystem.SeverityInfo, CodeFreeCount, "", "FS Information Sector has %d free clusters instead of %d", count, free)
+	}
+	return c.findings, nil
+}
+
+// add adds a finding
+func (c *checker) add(severity filesystem.Severity, code, p, format string, args ...any) {
+	c.findings = append(c.findings, filesystem.Finding{Severity: severity, Code: code, Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// chain follows the chain of clusters of the file at p from first, checking it against the size
+// of the file unless it is a directory, and records p as the owner of its clusters. It returns
+// whether the chain is valid and can be read.
+func (c *checker) chain(p string, first, size uint32, dir bool) bool {
+	clusters := c.fs.table.clusters
+	if first == 0 {
+		if size > 0 || dir {
+			c.add(filesystem.SeverityError, CodeChainInvalid, p, "no clusters for %d bytes", size)
+			return false
+		}
+		return true
+	}
+	var count uint32
+	seen := map[uint32]bool{}
+	for cluster := first; ; {
+		if cluster < 2 || cluster >= uint32(len(clusters)) {
+			c.add(filesystem.SeverityError, CodeChainInvalid, p, "cluster %d after %d clusters is outside of the table", cluster, count)
+			return false
+		}
+		if seen[cluster] {
+			c.add(filesystem.SeverityError, CodeChainInvalid, p, "chain loops back to cluster %d after %d clusters", cluster, count)
+			return false
+		}
+		next := clusters[cluster] & 0x0fffffff
+		if next == c.fs.table.unusedMarker {
+			c.add(filesystem.SeverityError, CodeChainInvalid, p, "chain reaches free cluster %d after %d clusters", cluster, count)
+			return false
+		}
+		seen[cluster] = true
+		count++
+		if owner := c.owners[cluster]; owner != "" {
+			c.add(filesystem.SeverityError, CodeCrossLinked, p, "cluster %d is also in the chain of %s", cluster, owner)
+		} else {
+			c.owners[cluster] = p
+		}
+		if c.fs.table.isEoc(next) {
+			break
+		}
+		cluster = next
+	}
+	if dir {
+		return true
+	}
+	// an empty file may keep the cluster it was given
+	expected := (size + uint32(c.fs.bytesPerCluster) - 1) / uint32(c.fs.bytesPerCluster)
+	if count != expected && (size > 0 || count > 1) {
+		c.add(filesystem.SeverityWarning, CodeChainLength, p, "%d clusters for %d bytes instead of %d", count, size, expected)
+	}
+	return true
+}
+
+// directory checks the entries of the directory at p, whose chain of clusters is valid and starts
+// at cluster, and those of its subdirectories. The parent is the cluster of its parent, with 0 for
+// the root as in ".." entries.
+func (c *checker) directory(p string, cluster, parent uint32) {
+	entries, err := c.fs.readDirectory(&Directory{directoryEntry: directoryEntry{clusterLocation: cluster}})
+	if err != nil {
+		// already found by GenericCheck
+		return
+	}
+	// the root is 0 in ".." entries
+	self := cluster
+	if cluster == c.fs.table.rootDirCluster {
+		self = 0
+	}
+	for _, e := range entries {
+		switch {
+		case e.isVolumeLabel || e.filenameShort == ".":
+			continue
+		case e.filenameShort == "..":
+			if e.clusterLocation != parent {
+				c.add(filesystem.SeverityWarning, CodeParentInvalid, p, "parent at cluster %d instead of %d", e.clusterLocation, parent)
+			}
+			continue
+		}
+		name := e.filenameLong
+		if name == "" {
+			name = e.filenameShort
+			if e.fileExtension != "" {
+				name += "." + e.fileExtension
+			}
+		}
+		entryPath := path.Join(p, name)
+		// a directory already walked is cross-linked, and walking it again could loop forever
+		walked := c.owners[e.clusterLocation] != ""
+		if c.chain(entryPath, e.clusterLocation, e.fileSize, e.isSubdirectory) && e.isSubdirectory && !walked {
+			c.directory(entryPath, e.clusterLocation, self)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package fat32
 
 import (
 	"fmt"
-	"time"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/util"
 )
 
 // Directory represents a single directory in a FAT32 filesystem
@@ -59,9 +62,9 @@ func (d *Directory) createEntry(name string, cluster uint32, dir bool) (*directo
 		fileSize:          uint32(0),
 		clusterLocation:   cluster,
 		filesystem:        d.filesystem,
-		createTime:        time.Now(),
-		modifyTime:        time.Now(),
-		accessTime:        time.Now(),
+		createTime:        util.Now(),
+		modifyTime:        util.Now(),
+		accessTime:        util.Now(),
 		isSubdirectory:    dir,
 		isNew:             true,
 	}
@@ -71,57 +74,57 @@ func (d *Directory) createEntry(name string, cluster uint32, dir bool) (*directo
 	return &entry, nil
 }
 
-// removeEntry removes an entry in the given directory
-func (d *Directory) removeEntry(name string) error {
-	// TODO implement check for long/short filename after increment of sfn is correctly implemented
-
-	removeEntryIndex := -1
-	for i, entry := range d.entries {
-		if entry.filenameLong == name { // || entry.filenameShort == shortName  do not compare SFN, since it is not incremented correctly
-			removeEntryIndex = i
+// findEntry returns the entry of the given directory with the name, long or short with its
+// extension, compared without case like readDirWithMkdir does, or nil if there is none
+func (d *Directory) findEntry(name string) *directoryEntry {
+	for _, e := range d.entries {
+		if e.isVolumeLabel {
+			continue
+		}
+		shortName := e.filenameShort
+		if e.fileExtension != "" {
+			shortName += "." + e.fileExtension
+		}
+		if strings.EqualFold(e.filenameLong, name) || strings.EqualFold(shortName, name) {
+			return e
 		}
 	}
+	return nil
+}
 
-	if removeEntryIndex == -1 {
+// removeEntry removes an entry in the given directory
+func (d *Directory) removeEntry(name string) error {
+	entry := d.findEntry(name)
+	if entry == nil {
 		return fmt.Errorf("cannot find entry for name %s", name)
 	}
-
-	// remove the entry from the list
-	d.entries = append(d.entries[:removeEntryIndex], d.entries[removeEntryIndex+1:]...)
-
+	d.entries = slices.DeleteFunc(d.entries, func(e *directoryEntry) bool {
+		return e == entry
+	})
 	return nil
 }
 
-// renameEntry renames an entry in the given directory, and returns the handle to it
+// renameEntry renames an entry in the given directory, replacing any other entry with the new name
 func (d *Directory) renameEntry(oldFileName, newFileName string) error {
-	// TODO implement check for long/short filename after increment of sfn is correctly implemented
-
-	newEntries := make([]*directoryEntry, 0, len(d.entries))
-	var isReplaced = false
-	for _, entry := range d.entries {
-		if entry.filenameLong == newFileName {
-			continue // skip adding already existing file, will be overwritten
-		}
-		if entry.filenameLong == oldFileName { //  || entry.filenameShort == shortName  do not compare SFN, since it is not incremented correctly
-			var lfn string
-			shortName, extension, isLFN, _ := convertLfnSfn(newFileName)
-			if isLFN {
-				lfn = newFileName
-			}
-			entry.filenameLong = lfn
-			entry.filenameShort = shortName
-			entry.fileExtension = extension
-			entry.modifyTime = time.Now()
-			isReplaced = true
-		}
-		newEntries = append(newEntries, entry)
-	}
-	if !isReplaced {
+	entry := d.findEntry(oldFileName)
+	if entry == nil {
 		return fmt.Errorf("cannot find file entry for %s", oldFileName)
 	}
-
-	d.entries = newEntries
-
+	if existing := d.findEntry(newFileName); existing != nil && existing != entry {
+		d.entries = slices.DeleteFunc(d.entries, func(e *directoryEntry) bool {
+			return e == existing
+		})
+	}
+	var lfn string
+	shortName, extension, isLFN, _ := convertLfnSfn(newFileName)
+	if isLFN {
+		lfn = newFileName
+	}
+	entry.filenameLong = lfn
+	entry.filenameShort = shortName
+	entry.fileExtension = extension
+	entry.longFilenameSlots = calculateSlots(lfn)
+	entry.modifyTime = util.Now()
 	return nil
 }
 
@@ -136,9 +139,9 @@ func (d *Directory) createVolumeLabel(name string) (*directoryEntry, error) {
 		fileSize:          uint32(0),
 		clusterLocation:   0,
 		filesystem:        d.filesystem,
-		createTime:        time.Now(),
-		modifyTime:        time.Now(),
-		accessTime:        time.Now(),
+		createTime:        util.Now(),
+		modifyTime:        util.Now(),
+		accessTime:        util.Now(),
 		isSubdirectory:    false,
 		isNew:             true,
 		isVolumeLabel:     true,
@@ -56,7 +56,7 @@ func (de *directoryEntry) toBytes() ([]byte, error) {
 	if de.filenameLong != "" {
 		lfnBytes, err := longFilenameBytes(de.filenameLong, de.filenameShort, de.fileExtension)
 		if err != nil {
-			return nil, fmt.Errorf("could not convert long filename to directory entries: %v", err)
+			return nil, fmt.Errorf("could not convert long filename to directory entries: %w", err)
 		}
 		b = append(b, lfnBytes...)
 	}
@@ -74,12 +74,12 @@ func (de *directoryEntry) toBytes() ([]byte, error) {
 	// convert the short filename and extension to ascii bytes
 	shortName, err := stringToASCIIBytes(fmt.Sprintf("% -8s", de.filenameShort))
 	if err != nil {
-		return nil, fmt.Errorf("error converting short filename to bytes: %v", err)
+		return nil, fmt.Errorf("error converting short filename to bytes: %w", err)
 	}
 	// convert the short filename and extension to ascii bytes
 	extension, err := stringToASCIIBytes(fmt.Sprintf("% -3s", de.fileExtension))
 	if err != nil {
-		return nil, fmt.Errorf("error converting file extension to bytes: %v", err)
+		return nil, fmt.Errorf("error converting file extension to bytes: %w", err)
 	}
 	copy(dosBytes[0:8], shortName)
 	copy(dosBytes[8:11], extension)
@@ -92,6 +92,15 @@ func (de *directoryEntry) toBytes() ([]byte, error) {
 	dosBytes[21] = clusterLocation[3]
 
 	// set the flags
+	if de.isReadOnly {
+		dosBytes[11] |= 0x01
+	}
+	if de.isHidden {
+		dosBytes[11] |= 0x02
+	}
+	if de.isSystem {
+		dosBytes[11] |= 0x04
+	}
 	if de.isVolumeLabel {
 		dosBytes[11] |= 0x08
 	}
@@ -143,7 +152,7 @@ byteLoop:
 			tmpLfn, err := longFilenameEntryFromBytes(b[i : i+32])
 			// an error is impossible since we pass exactly 32, but we leave the handler here anyways
 			if err != nil {
-				return nil, fmt.Errorf("error parsing long filename at position %d: %v", i, err)
+				return nil, fmt.Errorf("error parsing long filename at position %d: %w", i, err)
 			}
 			lfn = tmpLfn + lfn
 			continue
@@ -157,6 +166,9 @@ byteLoop:
 		re := regexp.MustCompile(" +$")
 		sfn := re.ReplaceAllString(string(b[i:i+8]), "")
 		extension := re.ReplaceAllString(string(b[i+8:i+11]), "")
+		isReadOnly := b[i+11]&0x01 == 0x01
+		isHidden := b[i+11]&0x02 == 0x02
+		isSystem := b[i+11]&0x04 == 0x04
 		isSubdirectory := b[i+11]&0x10 == 0x10
 		isArchiveDirty := b[i+11]&0x20 == 0x20
 		isVolumeLabel := b[i+11]&0x08 == 0x08
@@ -173,6 +185,9 @@ byteLoop:
 			createTime:         dateTimeToTime(createDate, createTime),
 			modifyTime:         dateTimeToTime(modifyDate, modifyTime),
 			accessTime:         dateTimeToTime(accessDate, 0),
+			isReadOnly:         isReadOnly,
+			isHidden:           isHidden,
+			isSystem:           isSystem,
 			isSubdirectory:     isSubdirectory,
 			isArchiveDirty:     isArchiveDirty,
 			isVolumeLabel:      isVolumeLabel,
@@ -210,7 +225,7 @@ func longFilenameBytes(s, shortName, extension string) ([]byte, error) {
 	// we need the checksum of the short name
 	checksum, err := lfnChecksum(shortName, extension)
 	if err != nil {
-		return nil, fmt.Errorf("could not calculate checksum for 8.3 filename: %v", err)
+		return nil, fmt.Errorf("could not calculate checksum for 8.3 filename: %w", err)
 	}
 	// should be multiple of exactly 32 bytes
 	slots := calculateSlots(s)
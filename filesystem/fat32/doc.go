@@ -1,6 +1,8 @@
 // Package fat32 provides utilities to interact with, manipulate and create a FAT32 filesystem on a block device or
 // a disk image.
 //
+// Its reads are safe for concurrent use, see the concurrency of package filesystem.
+//
 // references:
 //
 //	https://en.wikipedia.org/wiki/Design_of_the_FAT_file_system
@@ -37,7 +37,7 @@ func dos331BPBFromBytes(b []byte) (*dos331BPB, error) {
 	bpb := dos331BPB{}
 	dos20bpb, err := dos20BPBFromBytes(b[0:13])
 	if err != nil {
-		return nil, fmt.Errorf("error reading embedded DOS 2.0 BPB: %v", err)
+		return nil, fmt.Errorf("error reading embedded DOS 2.0 BPB: %w", err)
 	}
 	bpb.dos20BPB = dos20bpb
 	bpb.sectorsPerTrack = binary.LittleEndian.Uint16(b[13:15])
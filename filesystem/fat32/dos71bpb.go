@@ -88,7 +88,7 @@ func dos71EBPBFromBytes(b []byte) (*dos71EBPB, int, error) {
 	// extract the embedded DOS 3.31 BPB
 	dos331bpb, err := dos331BPBFromBytes(b[0:25])
 	if err != nil {
-		return nil, 0, fmt.Errorf("could not read embedded DOS 3.31 BPB: %v", err)
+		return nil, 0, fmt.Errorf("could not read embedded DOS 3.31 BPB: %w", err)
 	}
 	bpb.dos331BPB = dos331bpb
 
@@ -109,7 +109,7 @@ func dos71EBPBFromBytes(b []byte) (*dos71EBPB, int, error) {
 	extendedSignature := b[55]
 	bpb.extendedBootSignature = extendedSignature
 	// is this a longer or shorter one
-	bpb.volumeSerialNumber = binary.BigEndian.Uint32(b[56:60])
+	bpb.volumeSerialNumber = binary.LittleEndian.Uint32(b[56:60])
 
 	switch extendedSignature {
 	case shortDos71EBPB:
@@ -175,7 +175,7 @@ func (bpb *dos71EBPB) toBytes() ([]byte, error) {
 	b[53] = bpb.driveNumber
 	b[54] = bpb.reservedFlags
 	b[55] = bpb.extendedBootSignature
-	binary.BigEndian.PutUint32(b[56:60], bpb.volumeSerialNumber)
+	binary.LittleEndian.PutUint32(b[56:60], bpb.volumeSerialNumber)
 
 	return b, nil
 }
@@ -270,3 +270,24 @@ func TestDos71EBPBToBytes(t *testing.T) {
 		}
 	})
 }
+
+func TestDos71EBPBVolumeSerialNumber(t *testing.T) {
+	// the serial number is little-endian, shown by blkid as 1234-ABCD
+	serial := []byte{0xcd, 0xab, 0x34, 0x12}
+	bpb := getValidDos71EBPB()
+	bpb.volumeSerialNumber = 0x1234abcd
+	b, err := bpb.toBytes()
+	if err != nil {
+		t.Fatalf("error converting to bytes: %v", err)
+	}
+	if !bytes.Equal(b[56:60], serial) {
+		t.Errorf("serial number written as % x instead of % x", b[56:60], serial)
+	}
+	read, _, err := dos71EBPBFromBytes(b)
+	if err != nil {
+		t.Fatalf("error reading bytes: %v", err)
+	}
+	if read.volumeSerialNumber != 0x1234abcd {
+		t.Errorf("serial number read as %#x instead of 0x1234abcd", read.volumeSerialNumber)
+	}
+}
@@ -3,13 +3,16 @@ package fat32
 import (
 	"errors"
 	"fmt"
+	iofs "io/fs"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 // MsdosMediaType is the (mostly unused) media type. However, we provide and export the known constants for it.
@@ -60,6 +63,9 @@ type FileSystem struct {
 	size            int64
 	start           int64
 	backend         backend.Storage
+	index           *dirIndex
+	pending         *pendingWrites
+	writeBack       bool
 }
 
 // Equal compare if two filesystems are equal
@@ -94,6 +100,26 @@ func (fs *FileSystem) Equal(a *FileSystem) bool {
 // If the provided blocksize is 0, it will use the default of 512 bytes. If it is any number other than 0
 // or 512, it will return an error.
 func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string) (*FileSystem, error) {
+	return CreateWithParams(b, size, start, blocksize, &Params{VolumeLabel: volumeLabel})
+}
+
+// Params are the options of CreateWithParams, the equivalent of those of mkfs.vfat
+type Params struct {
+	// VolumeLabel is the label of the volume, "NO NAME" if empty
+	VolumeLabel string
+	// VolumeID is the serial number of the volume, the UUID of blkid, or one from the time of
+	// creation if nil
+	VolumeID *uint32
+	// ClusterSize is the size of the clusters in bytes, a power of 2 from 512 to 65536, or one
+	// from the size of the filesystem, as Microsoft's format does, if 0
+	ClusterSize int64
+}
+
+// CreateWithParams creates a FAT32 filesystem like Create, with the options of p, which may be nil
+func CreateWithParams(b backend.Storage, size, start, blocksize int64, p *Params) (*FileSystem, error) {
+	if p == nil {
+		p = &Params{}
+	}
 	// blocksize must be <=0 or exactly SectorSize512 or error
 	if blocksize != int64(SectorSize512) && blocksize > 0 {
 		return nil, fmt.Errorf("blocksize for FAT32 must be either 512 bytes or 0, not %d", blocksize)
@@ -105,14 +131,17 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 		return nil, fmt.Errorf("requested size is smaller than minimum allowed FAT32, requested %d minimum %d", size, blocksize*4)
 	}
 	// FAT filesystems use time-of-day of creation as a volume ID
-	now := time.Now()
+	now := util.Now()
 	// because we like the fudges other people did for uniqueness
 	volid := uint32(now.Unix()<<20 | (now.UnixNano() / 1000000))
+	if p.VolumeID != nil {
+		volid = *p.VolumeID
+	}
 
 	fsisPrimarySector := uint16(1)
 	backupBootSector := uint16(6)
 
-	writableFile, err := b.Writable()
+	writableFile, err := writableBackend(b)
 	if err != nil {
 		return nil, err
 	}
@@ -157,6 +186,13 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 	case size <= Fat32MaxSize:
 		sectorsPerCluster = 128
 	}
+	if p.ClusterSize != 0 {
+		sectors := p.ClusterSize / int64(SectorSize512)
+		if p.ClusterSize%int64(SectorSize512) != 0 || sectors < 1 || sectors > 128 || sectors&(sectors-1) != 0 {
+			return nil, fmt.Errorf("cluster size for FAT32 must be a power of 2 from 512 to 65536 bytes, not %d", p.ClusterSize)
+		}
+		sectorsPerCluster = uint8(sectors)
+	}
 
 	// stick with uint32 and round down
 	totalSectors := uint32(size / int64(SectorSize512))
@@ -258,6 +294,7 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 		start:           start,
 		size:            size,
 		backend:         b,
+		index:           newDirIndex(),
 	}
 
 	// write the boot sector
@@ -305,9 +342,14 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 	}
 
 	// set the volume label
-	err = fs.SetLabel(volumeLabel)
+	err = fs.SetLabel(p.VolumeLabel)
 	if err != nil {
-		return nil, fmt.Errorf("failed to set volume label to '%s': %w", volumeLabel, err)
+		return nil, fmt.Errorf("failed to set volume label to '%s': %w", p.VolumeLabel, err)
+	}
+
+	// a new filesystem is complete on disk, whether or not anything is written to it
+	if err := fs.flush(); err != nil {
+		return nil, fmt.Errorf("failed to write the new filesystem: %w", err)
 	}
 
 	return fs, nil
@@ -396,6 +438,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		start:           start,
 		size:            size,
 		backend:         b,
+		index:           newDirIndex(),
 	}, nil
 }
 
@@ -404,10 +447,10 @@ func (fs *FileSystem) writeBootSector() error {
 	/*
 		err := bs.write(f)
 		if err != nil {
-			return nil, fmt.Errorf("error writing MS-DOS Boot Sector: %v", err)
+			return nil, fmt.Errorf("error writing MS-DOS Boot Sector: %w", err)
 		}
 	*/
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := writableBackend(fs.backend)
 	if err != nil {
 		return err
 	}
@@ -446,7 +489,7 @@ func (fs *FileSystem) writeFsis() error {
 	fsisPrimary := int64(fsInformationSector * uint16(SectorSize512))
 
 	fsisBytes := fs.fsis.toBytes()
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := writableBackend(fs.backend)
 	if err != nil {
 		return err
 	}
@@ -470,7 +513,7 @@ func (fs *FileSystem) writeFat() error {
 	fatSecondaryStart := fatPrimaryStart + uint64(fs.table.size)
 
 	fatBytes := fs.table.bytes()
-	writableFile, err := fs.backend.Writable()
+	writableFile, err := writableBackend(fs.backend)
 	if err != nil {
 		return err
 	}
@@ -489,8 +532,24 @@ func (fs *FileSystem) writeFat() error {
 // interface guard
 var _ filesystem.FileSystem = (*FileSystem)(nil)
 
-// Do cleaning job for fat32. Note that fat32 does not have side-effects so we do not do anything.
+// Close writes the changes to the FAT, the FS Information Sector and the directories still held
+// in memory, see SetWriteBack. It does not close the backend.
 func (fs *FileSystem) Close() error {
+	if err := fs.flush(); err != nil {
+		return fmt.Errorf("error writing filesystem changes: %w", err)
+	}
+	return nil
+}
+
+// Sync makes the changes so far durable. It writes the changes to the FAT, the FS Information
+// Sector and the directories still held in memory, see SetWriteBack, and then syncs the backend.
+func (fs *FileSystem) Sync() error {
+	if err := fs.flush(); err != nil {
+		return fmt.Errorf("error writing filesystem changes: %w", err)
+	}
+	if err := backend.Sync(fs.backend); err != nil {
+		return fmt.Errorf("error syncing filesystem: %w", err)
+	}
 	return nil
 }
 
@@ -503,8 +562,12 @@ func (fs *FileSystem) Type() filesystem.Type {
 //
 // * It will make the entire tree path if it does not exist
 // * It will not return an error if the path already exists
-func (fs *FileSystem) Mkdir(p string) error {
-	_, _, err := fs.readDirWithMkdir(p, true)
+func (fs *FileSystem) Mkdir(p string) (err error) {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	defer fs.commit(&err)
+	_, _, err = fs.readDirWithMkdir(p, true)
 	// we are not interesting in returning the entries
 	return err
 }
@@ -527,8 +590,13 @@ func (fs *FileSystem) Symlink(_, _ string) error {
 
 // Chmod changes the mode of the named file to mode. If the file is a symbolic link,
 // it changes the mode of the link's target.
-func (fs *FileSystem) Chmod(_ string, _ os.FileMode) error {
-	return filesystem.ErrNotSupported
+//
+// FAT has no permissions, only a read-only attribute, which is set when mode has no write
+// permission for anyone, and cleared otherwise. The root directory has no attributes.
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	return fs.updateEntry(name, func(e *directoryEntry) {
+		e.isReadOnly = mode&0o222 == 0
+	})
 }
 
 // Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
@@ -537,20 +605,71 @@ func (fs *FileSystem) Chown(_ string, _, _ int) error {
 	return filesystem.ErrNotSupported
 }
 
+// Chtimes changes the access and modification times of the named file, like os.Chtimes. A zero
+// time leaves that time unchanged. FAT keeps modification times in steps of 2 seconds, and
+// only the date of the last access.
+func (fs *FileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.updateEntry(name, func(e *directoryEntry) {
+		if !atime.IsZero() {
+			e.accessTime = atime
+		}
+		if !mtime.IsZero() {
+			e.modifyTime = mtime
+		}
+	})
+}
+
+// updateEntry changes the directory entry of the file with update, and writes its directory
+func (fs *FileSystem) updateEntry(p string, update func(e *directoryEntry)) (err error) {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	defer fs.commit(&err)
+	p = filesystem.AbsolutePath(p)
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return fmt.Errorf("root directory has no attributes: %w", filesystem.ErrNotSupported)
+	}
+	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
+	if err != nil {
+		return fmt.Errorf("could not read directory entries for %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		if e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." {
+			continue
+		}
+		shortName := e.filenameShort
+		if e.fileExtension != "" {
+			shortName += "." + e.fileExtension
+		}
+		if !strings.EqualFold(e.filenameLong, filename) && !strings.EqualFold(shortName, filename) {
+			continue
+		}
+		update(e)
+		if err := fs.writeDirectoryEntries(parentDir); err != nil {
+			return fmt.Errorf("error writing directory file %s to disk: %w", p, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("target file %s does not exist: %w", p, iofs.ErrNotExist)
+}
+
 // ReadDir return the contents of a given directory in a given filesystem.
 //
-// Returns a slice of os.FileInfo with all of the entries in the directory.
+// Returns a slice of fs.DirEntry with all of the entries in the directory, sorted by name.
 //
 // Will return an error if the directory does not exist or is a regular file and not a directory
-func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
-	_, entries, err := fs.readDirWithMkdir(p, false)
+func (fs *FileSystem) ReadDir(p string) ([]iofs.DirEntry, error) {
+	_, entries, err := fs.readDirWithMkdir(filesystem.AbsolutePath(p), false)
 	if err != nil {
 		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
 	}
 	// once we have made it here, looping is done. We have found the final entry
 	// we need to return all of the file info
 	//nolint:prealloc // because the following loop may omit some entry
-	var ret []os.FileInfo
+	var ret []iofs.FileInfo
 	for _, e := range entries {
 		if e.isVolumeLabel {
 			continue
@@ -566,15 +685,39 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 		if fileExtension != "" {
 			shortName = fmt.Sprintf("%s.%s", shortName, fileExtension)
 		}
+		// FAT has no permissions, only a read-only attribute
+		mode := iofs.FileMode(0o644)
+		if e.isSubdirectory {
+			mode = iofs.ModeDir | 0o755
+		}
+		if e.isReadOnly {
+			mode &^= 0o222
+		}
 		ret = append(ret, FileInfo{
 			modTime:   e.modifyTime,
+			mode:      mode,
 			name:      e.filenameLong,
 			shortName: shortName,
 			size:      int64(e.fileSize),
 			isDir:     e.isSubdirectory,
 		})
 	}
-	return ret, nil
+	return filesystem.SortedDirEntries(ret), nil
+}
+
+// Open opens the file or directory read-only, for fs.FS
+func (fs *FileSystem) Open(name string) (iofs.File, error) {
+	return filesystem.GenericOpen(fs, name)
+}
+
+// Stat describes the file or directory, for fs.StatFS
+func (fs *FileSystem) Stat(name string) (iofs.FileInfo, error) {
+	return filesystem.GenericStat(fs, name)
+}
+
+// ReadFile reads the whole file, for fs.ReadFileFS
+func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
+	return filesystem.GenericReadFile(fs, name)
 }
 
 // OpenFile returns an io.ReadWriter from which you can read the contents of a file
@@ -583,13 +726,19 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 // accepts normal os.OpenFile flags
 //
 // returns an error if the file does not exist
-func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+func (fs *FileSystem) OpenFile(p string, flag int) (_ filesystem.File, err error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		if _, err := writableBackend(fs.backend); err != nil {
+			return nil, err
+		}
+		defer fs.commit(&err)
+	}
 	// get the path
 	dir := path.Dir(p)
 	filename := path.Base(p)
 	// if the dir == filename, then it is just /
 	if dir == filename {
-		return nil, fmt.Errorf("cannot open directory %s as file", p)
+		return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 	}
 	// get the directory entries
 	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
@@ -608,17 +757,20 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 		}
 		// cannot do anything with directories
 		if e.isSubdirectory {
-			return nil, fmt.Errorf("cannot open directory %s as file", p)
+			return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 		}
 		// if we got this far, we have found the file
 		targetEntry = e
 	}
 
+	if targetEntry != nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0 {
+		return nil, fmt.Errorf("target file %s already exists: %w", p, iofs.ErrExist)
+	}
 	// see if the file exists
 	// if the file does not exist, and is not opened for os.O_CREATE, return an error
 	if targetEntry == nil {
 		if flag&os.O_CREATE == 0 {
-			return nil, fmt.Errorf("target file %s does not exist and was not asked to create", p)
+			return nil, fmt.Errorf("target file %s does not exist and was not asked to create: %w", p, iofs.ErrNotExist)
 		}
 		// else create it
 		targetEntry, err = fs.mkFile(parentDir, filename)
@@ -650,7 +802,7 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	}
 	return &File{
 		directoryEntry: targetEntry,
-		isReadWrite:    flag&os.O_RDWR != 0,
+		isReadWrite:    flag&(os.O_RDWR|os.O_WRONLY) != 0,
 		isAppend:       flag&os.O_APPEND != 0,
 		offset:         offset,
 		filesystem:     fs,
@@ -659,129 +811,234 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 }
 
 // removes the named file or (empty) directory.
-func (fs *FileSystem) Remove(pathname string) error {
+func (fs *FileSystem) Remove(pathname string) (err error) {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	defer fs.commit(&err)
 	// get the path
 	dir := path.Dir(pathname)
 	filename := path.Base(pathname)
 	// if the dir == filename, then it is just /
 	if dir == filename {
-		return fmt.Errorf("cannot remove directory %s as file", pathname)
+		return fmt.Errorf("cannot remove root directory %s: %w", pathname, iofs.ErrInvalid)
 	}
+	// a directory removed is no longer in the index, nor any changed in memory but not on disk
+	var targetEntry *directoryEntry
+	changed := false
+	defer func() {
+		if targetEntry != nil && targetEntry.isSubdirectory {
+			fs.index.drop(targetEntry.clusterLocation)
+		}
+		if err != nil && changed {
+			fs.index.reset()
+		}
+	}()
 	// get the directory entries
-	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
+	parentDir, _, err := fs.readDirWithMkdir(dir, false)
 	if err != nil {
-		return fmt.Errorf("could not read directory entries for %s", dir)
+		return fmt.Errorf("could not read directory entries for %s: %w", dir, err)
 	}
 	// we now know that the directory exists, see if the file exists
-	var targetEntry *directoryEntry
-	for _, e := range entries {
-		shortName := e.filenameShort
-		if e.fileExtension != "" {
-			shortName += "." + e.fileExtension
-		}
-		if e.filenameLong != filename && shortName != filename {
-			continue
+	targetEntry = parentDir.findEntry(filename)
+	if targetEntry == nil {
+		return fmt.Errorf("target file %s does not exist: %w", pathname, iofs.ErrNotExist)
+	}
+	// directories must be empty
+	if targetEntry.isSubdirectory {
+		content, err := fs.ReadDir(pathname)
+		if err != nil {
+			return fmt.Errorf("error while checking if file to delete is empty: %w", err)
 		}
-		// cannot do anything with directories
-		if e.isSubdirectory {
-			content, err := fs.ReadDir(pathname)
-			if err != nil {
-				return fmt.Errorf("error while checking if file to delete is empty: %+v", err)
-			}
-			// '.' & '..' are always present in directory
-			if len(content) > 2 {
-				return fmt.Errorf("cannot remove non-empty directory %s", pathname)
-			}
+		// ReadDir returns the entries without '.' & '..'
+		if len(content) > 0 {
+			return fmt.Errorf("cannot remove directory %s: %w", pathname, filesystem.ErrNotEmpty)
 		}
-		// if we got this far, we have found the file
-		targetEntry = e
-	}
-
-	// see if the file exists
-	// if the file does not exist, and is not opened for os.O_CREATE, return an error
-	if targetEntry == nil {
-		return fmt.Errorf("target file %s does not exist", pathname)
 	}
+	changed = true
 	err = parentDir.removeEntry(filename)
 	if err != nil {
-		return fmt.Errorf("failed to remove file %s: %v", pathname, err)
+		return fmt.Errorf("failed to remove file %s: %w", pathname, err)
 	}
 
 	// we need to make sure that clusters are removed which may not be used anymore
 	_, err = fs.allocateSpace(uint64(parentDir.fileSize), parentDir.clusterLocation)
 	if err != nil {
-		return fmt.Errorf("failed to allocate clusters: %v", err)
+		return fmt.Errorf("failed to allocate clusters: %w", err)
 	}
 
 	// write the directory entries to disk
 	err = fs.writeDirectoryEntries(parentDir)
 	if err != nil {
-		return fmt.Errorf("error writing directory file %s to disk: %v", pathname, err)
+		return fmt.Errorf("error writing directory file %s to disk: %w", pathname, err)
 	}
 
 	return nil
 }
 
-// Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
-func (fs *FileSystem) Rename(oldpath, newpath string) error {
+// Rename renames (moves) oldpath to newpath, within its directory or to another one. If newpath
+// already exists and is not a directory, Rename replaces it.
+func (fs *FileSystem) Rename(oldpath, newpath string) (err error) {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	defer fs.commit(&err)
+	oldpath = filesystem.AbsolutePath(oldpath)
+	newpath = filesystem.AbsolutePath(newpath)
 	// get the path
 	dir := path.Dir(oldpath)
 	filename := path.Base(oldpath)
 
 	newDir := path.Dir(newpath)
 	newname := path.Base(newpath)
-	if dir != newDir {
-		return errors.New("can only rename files within the same directory")
-	}
 
 	// if the dir == filename, then it is just /
-	if dir == filename {
-		return fmt.Errorf("cannot rename directory %s as file", oldpath)
+	if dir == filename || newDir == newname {
+		return fmt.Errorf("cannot rename root directory %s: %w", oldpath, iofs.ErrInvalid)
 	}
+	if strings.HasPrefix(strings.ToUpper(newpath), strings.ToUpper(oldpath)+"/") {
+		return fmt.Errorf("cannot move %s into itself as %s: %w", oldpath, newpath, iofs.ErrInvalid)
+	}
+	// directories are indexed by their path, which changes for those moved, and none changed in
+	// memory but not on disk may stay in the index
+	var targetEntry *directoryEntry
+	changed := false
+	defer func() {
+		if targetEntry != nil && targetEntry.isSubdirectory {
+			fs.index.drop(targetEntry.clusterLocation)
+		}
+		if err != nil && changed {
+			fs.index.reset()
+		}
+	}()
 	// get the directory entries
-	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
+	parentDir, _, err := fs.readDirWithMkdir(dir, false)
 	if err != nil {
-		return fmt.Errorf("could not read directory entries for %s", dir)
+		return fmt.Errorf("could not read directory entries for %s: %w", dir, err)
 	}
 	// we now know that the directory exists, see if the file exists
-	var targetEntry *directoryEntry
-	for _, e := range entries {
-		shortName := e.filenameShort
-		if e.fileExtension != "" {
-			shortName += "." + e.fileExtension
+	targetEntry = parentDir.findEntry(filename)
+	if targetEntry == nil {
+		return fmt.Errorf("target file %s does not exist: %w", oldpath, iofs.ErrNotExist)
+	}
+
+	newParentDir := parentDir
+	if !strings.EqualFold(dir, newDir) {
+		newParentDir, _, err = fs.readDirWithMkdir(newDir, false)
+		if err != nil {
+			return fmt.Errorf("could not read directory entries for %s: %w", newDir, err)
 		}
-		if e.filenameLong != filename && shortName != filename {
-			continue
+	}
+	if existing := newParentDir.findEntry(newname); existing != nil && existing != targetEntry {
+		switch {
+		case existing.isSubdirectory:
+			return fmt.Errorf("cannot replace directory %s: %w", newpath, filesystem.ErrIsDir)
+		case targetEntry.isSubdirectory:
+			return fmt.Errorf("cannot replace file %s with directory %s: %w", newpath, oldpath, filesystem.ErrNotDir)
 		}
-		// if we got this far, we have found the file
-		targetEntry = e
 	}
 
-	// see if the file exists
-	// if the file does not exist, and is not opened for os.O_CREATE, return an error
-	if targetEntry == nil {
-		return fmt.Errorf("target file %s does not exist", oldpath)
-	}
-	err = parentDir.renameEntry(filename, newname)
-	if err != nil {
-		return fmt.Errorf("failed to rename file %s: %v", oldpath, err)
+	changed = true
+	if newParentDir == parentDir {
+		err = parentDir.renameEntry(filename, newname)
+		if err != nil {
+			return fmt.Errorf("failed to rename file %s: %w", oldpath, err)
+		}
+	} else {
+		if err := parentDir.removeEntry(filename); err != nil {
+			return fmt.Errorf("failed to move file %s: %w", oldpath, err)
+		}
+		newParentDir.entries = append(newParentDir.entries, targetEntry)
+		if err := newParentDir.renameEntry(filename, newname); err != nil {
+			return fmt.Errorf("failed to move file %s: %w", oldpath, err)
+		}
+		// a directory moved elsewhere points to its new parent
+		if targetEntry.isSubdirectory {
+			if err := fs.reparentDirectory(targetEntry, newParentDir); err != nil {
+				return fmt.Errorf("failed to move directory %s: %w", oldpath, err)
+			}
+		}
+		if err := fs.writeDirectoryEntries(newParentDir); err != nil {
+			return fmt.Errorf("error writing directory file %s to disk: %w", newpath, err)
+		}
 	}
 
 	// we need to make sure that clusters are removed which may not be used anymore
 	_, err = fs.allocateSpace(uint64(parentDir.fileSize), parentDir.clusterLocation)
 	if err != nil {
-		return fmt.Errorf("failed to allocate clusters: %v", err)
+		return fmt.Errorf("failed to allocate clusters: %w", err)
 	}
 
 	// write the directory entries to disk
 	err = fs.writeDirectoryEntries(parentDir)
 	if err != nil {
-		return fmt.Errorf("error writing directory file %s to disk: %v", oldpath, err)
+		return fmt.Errorf("error writing directory file %s to disk: %w", oldpath, err)
 	}
 
 	return nil
 }
 
+// reparentDirectory changes the .. entry of the directory to the new parent
+func (fs *FileSystem) reparentDirectory(entry *directoryEntry, parent *Directory) error {
+	dir := &Directory{
+		directoryEntry: *entry,
+	}
+	entries, err := fs.readDirectory(dir)
+	if err != nil {
+		return fmt.Errorf("could not read directory: %w", err)
+	}
+	parentCluster := parent.clusterLocation
+	if parentCluster == fs.table.rootDirCluster {
+		// references to the root directory must be stored as 0
+		parentCluster = 0
+	}
+	for _, e := range entries {
+		if e.filenameShort == ".." {
+			e.clusterLocation = parentCluster
+		}
+	}
+	return fs.writeDirectoryEntries(dir)
+}
+
+// BlockSize returns the size of the clusters allocated to files, for filesystem.BlockSizeFS
+func (fs *FileSystem) BlockSize() int64 {
+	return int64(fs.bytesPerCluster)
+}
+
+// Space returns the size of the clusters of the data region, and of those that are free, for
+// filesystem.SpaceFS
+func (fs *FileSystem) Space() (total, free int64, err error) {
+	last := fs.dataClusters()
+	var count int64
+	for cluster := uint32(2); cluster < last; cluster++ {
+		if fs.table.clusters[cluster] == fs.table.unusedMarker {
+			count++
+		}
+	}
+	return int64(last-2) * int64(fs.bytesPerCluster), count * int64(fs.bytesPerCluster), nil
+}
+
+// AllocatedRanges returns the boot sectors, the FATs and the clusters in use, for
+// filesystem.AllocatedFS
+func (fs *FileSystem) AllocatedRanges() ([]filesystem.Range, error) {
+	ranges := filesystem.AppendRange(nil, 0, int64(fs.dataStart))
+	clusterSize := int64(fs.bytesPerCluster)
+	last := fs.dataClusters()
+	for cluster := uint32(2); cluster < last; cluster++ {
+		if fs.table.clusters[cluster] != fs.table.unusedMarker {
+			ranges = filesystem.AppendRange(ranges, int64(fs.dataStart)+int64(cluster-2)*clusterSize, clusterSize)
+		}
+	}
+	return ranges, nil
+}
+
+// dataClusters returns the number after the last cluster that can be allocated: that of the FAT,
+// or of the end of the data region where the FAT has entries for clusters beyond the filesystem
+func (fs *FileSystem) dataClusters() uint32 {
+	clusters := uint32((fs.size-int64(fs.dataStart))/int64(fs.bytesPerCluster)) + 2
+	return min(clusters, fs.table.maxCluster)
+}
+
 // Label get the label of the filesystem from the secial file in the root directory.
 // The label stored in the boot sector is ignored to mimic Windows behavior which
 // only stores and reads the label from the special file in the root directory.
@@ -810,7 +1067,11 @@ func (fs *FileSystem) Label() string {
 }
 
 // SetLabel changes the filesystem label
-func (fs *FileSystem) SetLabel(volumeLabel string) error {
+func (fs *FileSystem) SetLabel(volumeLabel string) (err error) {
+	if _, err := writableBackend(fs.backend); err != nil {
+		return err
+	}
+	defer fs.commit(&err)
 	if volumeLabel == "" {
 		volumeLabel = "NO NAME"
 	}
@@ -864,6 +1125,16 @@ func (fs *FileSystem) SetLabel(volumeLabel string) error {
 	return nil
 }
 
+// writableBackend returns the backend for writing, or an error that is filesystem.ErrReadOnlyFilesystem
+// if the backend is read-only
+func writableBackend(b backend.Storage) (backend.WritableFile, error) {
+	w, err := b.Writable()
+	if errors.Is(err, backend.ErrIncorrectOpenMode) {
+		return nil, fmt.Errorf("%w: %w", filesystem.ErrReadOnlyFilesystem, err)
+	}
+	return w, err
+}
+
 // read directory entries for a given cluster
 func (fs *FileSystem) getClusterList(firstCluster uint32) ([]uint32, error) {
 	// first, get the chain of clusters
@@ -901,17 +1172,28 @@ func (fs *FileSystem) readDirectory(dir *Directory) ([]*directoryEntry, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not read cluster list: %w", err)
 	}
-	// read the data from all of the cluster entries in the list
-	byteCount := len(clusterList) * fs.bytesPerCluster
-	b := make([]byte, 0, byteCount)
-	for _, cluster := range clusterList {
+	// read the data from all of the cluster entries in the list, straight into b, each run of
+	// contiguous clusters in one read
+	b := make([]byte, len(clusterList)*fs.bytesPerCluster)
+	for i := 0; i < len(clusterList); {
+		cluster := clusterList[i]
+		// changed in memory but not written yet
+		if pending, ok := fs.readDirCluster(cluster); ok {
+			copy(b[i*fs.bytesPerCluster:], pending)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(clusterList) && clusterList[j] == clusterList[j-1]+1 {
+			if _, ok := fs.readDirCluster(clusterList[j]); ok {
+				break
+			}
+			j++
+		}
 		// bytes where the cluster starts
 		clusterStart := fs.start + int64(fs.dataStart) + int64(cluster-2)*int64(fs.bytesPerCluster)
-		// length of cluster in bytes
-		tmpb := make([]byte, fs.bytesPerCluster)
-		// read the entire cluster
-		_, _ = fs.backend.ReadAt(tmpb, clusterStart)
-		b = append(b, tmpb...)
+		_, _ = fs.backend.ReadAt(b[i*fs.bytesPerCluster:j*fs.bytesPerCluster], clusterStart)
+		i = j
 	}
 	// get the directory
 	if err := dir.entriesFromBytes(b); err != nil {
@@ -931,15 +1213,21 @@ func (fs *FileSystem) mkSubdir(parent *Directory, name string) (*directoryEntry,
 	return parent.createEntry(name, clusters[0], true)
 }
 
-func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
+// writeDirectoryEntries writes the entries of the directory. If it fails, the directories in the
+// index, which may have been changed already, are dropped.
+func (fs *FileSystem) writeDirectoryEntries(dir *Directory) (err error) {
+	defer func() {
+		if err != nil {
+			fs.index.reset()
+		}
+	}()
 	// we need to save the entries of the parent
 	b, err := dir.entriesToBytes(fs.bytesPerCluster)
 	if err != nil {
 		return fmt.Errorf("could not create a valid byte stream for a FAT32 Entries: %w", err)
 	}
 
-	writableFile, err := fs.backend.Writable()
-	if err != nil {
+	if _, err := writableBackend(fs.backend); err != nil {
 		return err
 	}
 	// now have to expand with zeros to the a multiple of cluster lengths
@@ -956,18 +1244,11 @@ func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
 		}
 		clusterList = clusters
 	}
-	// now write everything out to the cluster list
-	// read the data from all of the cluster entries in the list
+	// now write everything out to the cluster list, in memory until the next flush
 	for i, cluster := range clusterList {
-		// bytes where the cluster starts
-		clusterStart := fs.start + int64(fs.dataStart) + int64(cluster-2)*int64(fs.bytesPerCluster)
 		bStart := i * fs.bytesPerCluster
-		written, err := writableFile.WriteAt(b[bStart:bStart+fs.bytesPerCluster], clusterStart)
-		if err != nil {
-			return fmt.Errorf("error writing directory entries: %w", err)
-		}
-		if written != fs.bytesPerCluster {
-			return fmt.Errorf("wrote %d bytes to cluster %d instead of expected %d", written, cluster, fs.bytesPerCluster)
+		if err := fs.writeDirCluster(cluster, b[bStart:bStart+fs.bytesPerCluster]); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -999,20 +1280,31 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 		return nil, nil, err
 	}
 	// walk down the directory tree until all paths have been walked or we cannot find something
-	// start with the root directory
-	var entries []*directoryEntry
-	currentDir := &Directory{
-		directoryEntry: directoryEntry{
-			clusterLocation: fs.table.rootDirCluster,
-			isSubdirectory:  true,
-			filesystem:      fs,
-		},
-	}
-	entries, err = fs.readDirectory(currentDir)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read directory %s: %w", "/", err)
+	// start with the deepest directory of the path in the index, or the root directory
+	start := len(paths)
+	currentDir := fs.index.get(paths)
+	for currentDir == nil && start > 0 {
+		start--
+		currentDir = fs.index.get(paths[:start])
+	}
+	if currentDir == nil {
+		currentDir = &Directory{
+			directoryEntry: directoryEntry{
+				clusterLocation: fs.table.rootDirCluster,
+				isSubdirectory:  true,
+				filesystem:      fs,
+			},
+		}
+		if _, err = fs.readDirectory(currentDir); err != nil {
+			return nil, nil, fmt.Errorf("failed to read directory %s: %w", "/", err)
+		}
+		fs.index.add(nil, currentDir)
 	}
-	for i, subp := range paths {
+	entries := currentDir.entries
+	// the path as indexed, with the names the directories are indexed with rather than those given
+	indexed := slices.Clone(paths)
+	for i := start; i < len(paths); i++ {
+		subp := paths[i]
 		// do we have an entry whose name is the same as this name?
 		found := false
 		for _, e := range entries {
@@ -1023,20 +1315,32 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 			// if the filename does not match, continue
 			// match is determined by any one of:
 			// - long filename == provided name
-			// - uppercase(short filename) == uppercase(provided name)
-			if !strings.EqualFold(e.filenameLong, subp) && !strings.EqualFold(e.filenameShort, subp) {
+			// - uppercase(short filename with its extension) == uppercase(provided name)
+			shortName := e.filenameShort
+			if e.fileExtension != "" {
+				shortName += "." + e.fileExtension
+			}
+			if !strings.EqualFold(e.filenameLong, subp) && !strings.EqualFold(shortName, subp) {
 				continue
 			}
 			if !e.isSubdirectory {
-				return nil, nil, fmt.Errorf("cannot create directory at %s since it is a file", "/"+strings.Join(paths[0:i+1], "/"))
+				return nil, nil, fmt.Errorf("cannot create directory at %s since it is a file: %w", "/"+strings.Join(paths[0:i+1], "/"), filesystem.ErrNotDir)
 			}
 			// the filename matches, and it is a subdirectory, so we can break after saving the cluster
 			found = true
+			indexed[i] = indexName(e)
 			currentDir = &Directory{
 				directoryEntry: *e,
 			}
 			break
 		}
+		// the directory may be in the index already, walked with its other name
+		if found {
+			if dir := fs.index.get(indexed[:i+1]); dir != nil {
+				currentDir, entries = dir, dir.entries
+				continue
+			}
+		}
 
 		// if not, either make it, retrieve its cluster and entries, and loop;
 		//  or error out
@@ -1086,11 +1390,12 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 					return nil, nil, fmt.Errorf("error writing directory entries to disk: %w", err)
 				}
 				// save where we are to search next
+				indexed[i] = indexName(subdirEntry)
 				currentDir = &Directory{
 					directoryEntry: *subdirEntry,
 				}
 			} else {
-				return nil, nil, fmt.Errorf("path %s not found", "/"+strings.Join(paths[0:i+1], "/"))
+				return nil, nil, fmt.Errorf("path %s not found: %w", "/"+strings.Join(paths[0:i+1], "/"), iofs.ErrNotExist)
 			}
 		}
 		// get all of the entries in this directory
@@ -1098,6 +1403,7 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to read directory %s: %w", "/"+strings.Join(paths[0:i+1], "/"), err)
 		}
+		fs.index.add(indexed[:i+1], currentDir)
 	}
 	// once we have made it here, looping is done; we have found the final entry
 	return currentDir, entries, nil
@@ -1150,7 +1456,7 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 	}
 
 	// get a list of allocated clusters, so we can know which ones are unallocated and therefore allocatable
-	maxCluster := fs.table.maxCluster
+	maxCluster := fs.dataClusters()
 
 	if extraClusterCount > 0 {
 		for i := uint32(2); i < maxCluster && len(allocated) < extraClusterCount; i++ {
@@ -1162,7 +1468,7 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 
 		// did we allocate them all?
 		if len(allocated) < extraClusterCount {
-			return nil, errors.New("no space left on device")
+			return nil, filesystem.ErrNoSpace
 		}
 
 		// mark last allocated one as EOC
@@ -1170,12 +1476,18 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 
 		// extend the chain and fill them in
 		if previous > 0 {
-			fs.table.clusters[previous] = allocated[0]
+			if err := fs.setCluster(previous, allocated[0]); err != nil {
+				return nil, err
+			}
 		}
 		for i := 0; i < lastAlloc; i++ {
-			fs.table.clusters[allocated[i]] = allocated[i+1]
+			if err := fs.setCluster(allocated[i], allocated[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.setCluster(allocated[lastAlloc], fs.table.eocMarker); err != nil {
+			return nil, err
 		}
-		fs.table.clusters[allocated[lastAlloc]] = fs.table.eocMarker
 
 		// update the FSIS
 		lastAllocatedCluster = allocated[len(allocated)-1]
@@ -1196,7 +1508,9 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 		}
 
 		// mark last allocated one as EOC
-		fs.table.clusters[clusters[lastAlloc]] = fs.table.eocMarker
+		if err := fs.setCluster(clusters[lastAlloc], fs.table.eocMarker); err != nil {
+			return nil, err
+		}
 
 		// unmark all of the unused ones
 		lastAllocatedCluster = fs.fsis.lastAllocatedCluster
@@ -1205,23 +1519,19 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 				return nil, fmt.Errorf("invalid cluster chain at %d", cl)
 			}
 
-			fs.table.clusters[cl] = fs.table.unusedMarker
+			if err := fs.setCluster(cl, fs.table.unusedMarker); err != nil {
+				return nil, err
+			}
+			fs.dropDirCluster(cl)
 			if cl == lastAllocatedCluster {
 				lastAllocatedCluster--
 			}
 		}
 	}
 
-	// update the FSIS
+	// update the FSIS; it and the FAT tables are written on the next flush
 	fs.fsis.lastAllocatedCluster = lastAllocatedCluster
-	if err := fs.writeFsis(); err != nil {
-		return nil, fmt.Errorf("failed to write the file system information sector: %w", err)
-	}
-
-	// write the FAT tables
-	if err := fs.writeFat(); err != nil {
-		return nil, fmt.Errorf("failed to write the file allocation table: %w", err)
-	}
+	fs.pendingWrites().fsisDirty = true
 
 	// return all of the clusters
 	return append(clusters, allocated...), nil
@@ -8,8 +8,10 @@ package fat32_test
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	mathrandv2 "math/rand/v2"
 	"os"
 	"path"
@@ -20,6 +22,7 @@ import (
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/fat32"
@@ -326,8 +329,9 @@ func TestFat32ReadDir(t *testing.T) {
 			isDir bool
 			err   error
 		}{
-			{"/", len(rootEntries), "foo", true, nil},
-			{"/foo", len(fooEntries), ".", true, nil},
+			// entries are sorted by name, without . and ..
+			{"/", len(rootEntries), "CORTO1.TXT", false, nil},
+			{"/foo", len(fooEntries) - 2, "bar", true, nil},
 			// 0 entries because the directory does not exist
 			{"/a/b/c", 0, "", false, fmt.Errorf("error reading directory /a/b/c")},
 		}
@@ -465,9 +469,9 @@ func TestFat32OpenFile(t *testing.T) {
 				{"/CORTO1.TXT", os.O_RDWR, false, "This is a very long replacement string", "Tenemos un archivo corto\nThis is a very long replacement string", nil},
 				{"/CORTO1.TXT", os.O_RDWR, false, "Two", "Tenemos un archivo corto\nTwo", nil},
 				//  - open for append file that does exist (write contents, check that appended)
-				{"/CORTO1.TXT", os.O_APPEND, false, "More", "", filesystem.ErrReadonlyFilesystem},
+				{"/CORTO1.TXT", os.O_APPEND, false, "More", "", filesystem.ErrReadOnlyFilesystem},
 				{"/CORTO1.TXT", os.O_APPEND | os.O_RDWR, false, "More", "Tenemos un archivo corto\nMore", nil},
-				{"/CORTO1.TXT", os.O_APPEND, true, "More", "", filesystem.ErrReadonlyFilesystem},
+				{"/CORTO1.TXT", os.O_APPEND, true, "More", "", filesystem.ErrReadOnlyFilesystem},
 				{"/CORTO1.TXT", os.O_APPEND | os.O_RDWR, true, "More", "Moremos un archivo corto\n", nil},
 			}
 			for _, t2 := range tests {
@@ -1026,16 +1030,14 @@ func Test83Lowercase(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expected := []string{"lower.low", "lower.UPP", "UPPER.low"}
-	i := 0
-	for _, file := range files {
-		if file.Name() == "." || file.Name() == ".." {
-			continue
-		}
+	expected := []string{"UPPER.low", "lower.UPP", "lower.low"}
+	if len(files) != len(expected) {
+		t.Fatalf("got %d entries, expected %d", len(files), len(expected))
+	}
+	for i, file := range files {
 		if file.Name() != expected[i] {
 			t.Errorf("got %q, expected %q", file.Name(), expected[i])
 		}
-		i++
 	}
 }
 
@@ -1107,7 +1109,7 @@ func TestCreateFileTree(t *testing.T) {
 		t.Fatalf("error creating disk: %v", err)
 	}
 
-	spec := disk.FilesystemSpec{
+	spec := disk.FormatSpec{
 		Partition: 0,
 		FSType:    filesystem.TypeFat32,
 	}
@@ -1519,3 +1521,343 @@ func Test_Remove(t *testing.T) {
 		})
 	}
 }
+
+func TestReadOnly(t *testing.T) {
+	m, err := mem.Create(10 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(m, m.Size(), 0, 512, "RO")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if _, err := fs.OpenFile("/dir/file.txt", os.O_CREATE|os.O_RDWR); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+
+	fs, err = fat32.Read(mem.New(m.Bytes(), true), m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if _, err := fs.OpenFile("/dir/file.txt", os.O_RDONLY); err != nil {
+		t.Errorf("error opening file for reading: %v", err)
+	}
+	ops := map[string]func() error{
+		"Mkdir": func() error { return fs.Mkdir("/other") },
+		"OpenFile create": func() error {
+			_, err := fs.OpenFile("/new.txt", os.O_CREATE|os.O_RDWR)
+			return err
+		},
+		"OpenFile write": func() error {
+			_, err := fs.OpenFile("/dir/file.txt", os.O_WRONLY)
+			return err
+		},
+		"Remove":   func() error { return fs.Remove("/dir/file.txt") },
+		"Rename":   func() error { return fs.Rename("/dir/file.txt", "/dir/other.txt") },
+		"SetLabel": func() error { return fs.SetLabel("OTHER") },
+	}
+	for name, op := range ops {
+		if err := op(); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+			t.Errorf("%s: error %v instead of read-only filesystem", name, err)
+		}
+	}
+}
+
+func TestDirectoryIndex(t *testing.T) {
+	m, err := mem.Create(10 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.WithLogger(m, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fs, err := fat32.Create(b, m.Size(), 0, 512, "INDEX")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/a/b/c"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if _, err := fs.OpenFile("/a/b/c/file.txt", os.O_CREATE|os.O_RDWR); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	reads := func() int64 {
+		stats, _ := backend.Stats(b)
+		return stats.Reads
+	}
+
+	// the directories walked are in the index, whatever the case of their names
+	before := reads()
+	for _, p := range []string{"/a/b/c/file.txt", "/A/B/C/FILE.TXT"} {
+		if _, err := fs.OpenFile(p, os.O_RDONLY); err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+	}
+	if _, err := fs.Stat("/a/b/c/file.txt"); err != nil {
+		t.Fatalf("error getting info of file: %v", err)
+	}
+	if n := reads() - before; n != 0 {
+		t.Errorf("%d reads for files in indexed directories", n)
+	}
+
+	// moved and removed directories are no longer at their paths
+	if err := fs.Rename("/a/b", "/a/x"); err != nil {
+		t.Fatalf("error renaming directory: %v", err)
+	}
+	if _, err := fs.OpenFile("/a/b/c/file.txt", os.O_RDONLY); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opened file of renamed directory: %v", err)
+	}
+	if _, err := fs.OpenFile("/a/x/c/file.txt", os.O_RDONLY); err != nil {
+		t.Errorf("error opening file of renamed directory: %v", err)
+	}
+	if err := fs.Remove("/a/x/c/file.txt"); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+	if err := fs.Remove("/a/x/c"); err != nil {
+		t.Fatalf("error removing directory: %v", err)
+	}
+	if _, err := fs.ReadDir("/a/x/c"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("read removed directory: %v", err)
+	}
+
+	// what is read back from disk is what the index had
+	fs, err = fat32.Read(mem.New(m.Bytes(), true), m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if entries, err := fs.ReadDir("/a/x"); err != nil || len(entries) != 0 {
+		t.Errorf("read %v, %v", entries, err)
+	}
+}
+
+func TestDirectoryIndexShortNames(t *testing.T) {
+	m, err := mem.Create(10 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(m, m.Size(), 0, 512, "INDEX")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	create := func(p string) {
+		t.Helper()
+		f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := f.Write([]byte(p)); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	if err := fs.Mkdir("/LongDirectoryName/Sub"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	// the same directory walked with its short and its long name
+	create("/LongDirectoryName/one.txt")
+	create("/LONGDI~1/two.txt")
+	create("/LongDirectoryName/three.txt")
+	create("/longdi~1/Sub/four.txt")
+
+	fs, err = fat32.Read(mem.New(m.Bytes(), true), m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for _, p := range []string{"/LongDirectoryName/one.txt", "/LONGDI~1/two.txt", "/LongDirectoryName/three.txt", "/longdi~1/Sub/four.txt"} {
+		if b, err := fs.ReadFile(p[1:]); err != nil || string(b) != p {
+			t.Errorf("read %q from %s, %v", b, p, err)
+		}
+	}
+
+	// a directory renamed by its short name is no longer at its long name, nor are those below it
+	fs, err = fat32.Read(m, m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if _, err := fs.ReadFile("LongDirectoryName/Sub/four.txt"); err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if err := fs.Rename("/LONGDI~1", "/Renamed"); err != nil {
+		t.Fatalf("error renaming directory: %v", err)
+	}
+	if _, err := fs.ReadFile("LongDirectoryName/Sub/four.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("read file of renamed directory: %v", err)
+	}
+	if b, err := fs.ReadFile("Renamed/Sub/four.txt"); err != nil || string(b) != "/longdi~1/Sub/four.txt" {
+		t.Errorf("read %q from renamed directory, %v", b, err)
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	m, err := mem.Create(10 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := backend.WithLogger(m, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	fs, err := fat32.Create(b, m.Size(), 0, 512, "WRITEBACK")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	writes := func() int64 {
+		stats, _ := backend.Stats(b)
+		return stats.Writes
+	}
+
+	// only the contents of the files are written until Sync
+	fs.SetWriteBack(true)
+	const count = 100
+	before := writes()
+	for i := 0; i < count; i++ {
+		f, err := fs.OpenFile(fmt.Sprintf("/dir/file%d.txt", i), os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write([]byte(fmt.Sprintf("file %d", i))); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	if n := writes() - before; n != count {
+		t.Errorf("%d writes for %d files before Sync", n, count)
+	}
+
+	// and all of the metadata on Sync, contiguous directory clusters and pieces of the FAT together
+	before = writes()
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("error syncing filesystem: %v", err)
+	}
+	if n := writes() - before; n > count/4 {
+		t.Errorf("%d writes on Sync for %d files", n, count)
+	}
+	fs, err = fat32.Read(mem.New(m.Bytes(), true), m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	entries, err := fs.ReadDir("/dir")
+	if err != nil || len(entries) != count {
+		t.Fatalf("read %d entries, %v", len(entries), err)
+	}
+	if b, err := fs.ReadFile("dir/file42.txt"); err != nil || string(b) != "file 42" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
+
+func TestGrow(t *testing.T) {
+	m, err := mem.Create(64 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(16 * 1024 * 1024)
+	fs, err := fat32.Create(m, size, 0, 512, "GROW")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	contents := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		b := make([]byte, 100*1024+i)
+		_, _ = rand.Read(b)
+		p := fmt.Sprintf("dir/file%d", i)
+		f, err := fs.OpenFile("/"+p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write(b); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		contents[p] = b
+	}
+	before, _, err := fs.Space()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Grow(size - 512); err == nil {
+		t.Errorf("no error shrinking filesystem")
+	}
+	// larger than the FATs can address, so that they grow and the data moves
+	if err := fs.Grow(m.Size()); err != nil {
+		t.Fatalf("error growing filesystem: %v", err)
+	}
+
+	fs, err = fat32.Read(m, m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading grown filesystem: %v", err)
+	}
+	total, free, err := fs.Space()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total < 3*before || free >= total {
+		t.Errorf("space %d, %d free, after growing from %d", total, free, before)
+	}
+	for p, expected := range contents {
+		b, err := fs.ReadFile(p)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("mismatched contents of %s", p)
+		}
+	}
+	findings, err := fs.Check(filesystem.CheckOptions{})
+	if err != nil || len(findings) != 0 {
+		t.Errorf("findings %v, %v in grown filesystem", findings, err)
+	}
+	// the new space can be used
+	f, err := fs.OpenFile("/large", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	large := make([]byte, 32*1024*1024)
+	_, _ = rand.Read(large)
+	if _, err := f.Write(large); err != nil {
+		t.Fatalf("error writing file to new space: %v", err)
+	}
+	if b, err := fs.ReadFile("large"); err != nil || !bytes.Equal(b, large) {
+		t.Errorf("mismatched contents of file in new space, %v", err)
+	}
+}
+
+func TestGrowFill(t *testing.T) {
+	// the reproduction of writes to the grown part of the FAT being lost: those after Grow, with the
+	// filesystem still open, of clusters whose entries are past the end of the old FAT
+	m, err := mem.Create(200 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(m, 40*1024*1024, 0, 512, "GROW")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Grow(m.Size()); err != nil {
+		t.Fatalf("error growing filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/large", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	large := make([]byte, 150*1024*1024)
+	_, _ = rand.Read(large)
+	if _, err := f.Write(large); err != nil {
+		t.Fatalf("error writing file to new space: %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+
+	fs, err = fat32.Read(m, m.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading grown filesystem: %v", err)
+	}
+	if b, err := fs.ReadFile("large"); err != nil || !bytes.Equal(b, large) {
+		t.Errorf("mismatched contents of file in new space, %v", err)
+	}
+	findings, err := fs.Check(filesystem.CheckOptions{})
+	if err != nil || len(findings) != 0 {
+		t.Errorf("findings %v, %v in grown filesystem", findings, err)
+	}
+}
@@ -3,6 +3,8 @@ package fat32
 import (
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"math"
 	"os"
 
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -31,12 +33,22 @@ func (fl *File) GetClusterChain() ([]uint32, error) {
 	fs := fl.filesystem
 	clusters, err := fs.getClusterList(fl.clusterLocation)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get list of clusters for file: %v", err)
+		return nil, fmt.Errorf("unable to get list of clusters for file: %w", err)
 	}
 
 	return clusters, nil
 }
 
+// DataOffset returns the offset of the first cluster of the file in the filesystem, for
+// filesystem.LocatedFile, or -1 if no cluster is allocated to it
+func (fl *File) DataOffset() int64 {
+	if fl.filesystem == nil || fl.clusterLocation < 2 {
+		return -1
+	}
+	fs := fl.filesystem
+	return int64(fs.dataStart) + int64(fl.clusterLocation-2)*int64(fs.bytesPerCluster)
+}
+
 type DiskRange struct {
 	Offset uint64
 	Length uint64
@@ -82,11 +94,31 @@ func (fl *File) GetDiskRanges() ([]DiskRange, error) {
 // At end of file, Read returns 0, io.EOF
 // reads from the last known offset in the file from last read or write
 // and increments the offset by the number of bytes read.
-// Use Seek() to set at a particular point
+// Use Seek() to set at a particular point, except with O_APPEND where it writes at the end
 func (fl *File) Read(b []byte) (int, error) {
 	if fl == nil || fl.filesystem == nil {
 		return 0, os.ErrClosed
 	}
+	n, err := fl.readAt(b, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.ReaderAt. It returns io.EOF with fewer bytes when the file
+// ends, and is safe for concurrent use.
+func (fl *File) ReadAt(b []byte, off int64) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("cannot read at offset %d before start of file", off)
+	}
+	return fl.readAt(b, off)
+}
+
+// readAt reads up to len(b) bytes from offset, returning io.EOF if it reaches the end of the file
+func (fl *File) readAt(b []byte, offset int64) (int, error) {
 	// we have the DirectoryEntry, so we can get the starting cluster location
 	// we then get a list of the clusters, and read the data from all of those clusters
 	// write the content for the file
@@ -94,19 +126,19 @@ func (fl *File) Read(b []byte) (int, error) {
 	fs := fl.filesystem
 	bytesPerCluster := fs.bytesPerCluster
 	start := int(fs.dataStart)
-	size := int(fl.fileSize) - int(fl.offset)
+	size := int(fl.fileSize) - int(offset)
 	maxRead := size
 	file := fs.backend
-	clusters, err := fs.getClusterList(fl.clusterLocation)
-	if err != nil {
-		return totalRead, fmt.Errorf("unable to get list of clusters for file: %v", err)
-	}
-	clusterIndex := 0
 
 	// if there is nothing left to read, just return EOF
 	if size <= 0 {
 		return totalRead, io.EOF
 	}
+	clusters, err := fs.getClusterList(fl.clusterLocation)
+	if err != nil {
+		return totalRead, fmt.Errorf("unable to get list of clusters for file: %w", err)
+	}
+	clusterIndex := 0
 
 	// we stop when we hit the lesser of
 	//   1- len(b)
@@ -116,16 +148,16 @@ func (fl *File) Read(b []byte) (int, error) {
 	}
 
 	// figure out which cluster we start with
-	if fl.offset > 0 {
-		clusterIndex = int(fl.offset / int64(bytesPerCluster))
+	if offset > 0 {
+		clusterIndex = int(offset / int64(bytesPerCluster))
 		lastCluster := clusters[clusterIndex]
 		// read any partials, if needed
-		remainder := fl.offset % int64(bytesPerCluster)
+		remainder := offset % int64(bytesPerCluster)
 		if remainder != 0 {
 			offset := int64(start) + int64(lastCluster-2)*int64(bytesPerCluster) + remainder
 			toRead := int64(bytesPerCluster) - remainder
-			if toRead > int64(len(b)) {
-				toRead = int64(len(b))
+			if toRead > int64(maxRead) {
+				toRead = int64(maxRead)
 			}
 			_, _ = file.ReadAt(b[0:toRead], offset+fs.start)
 			totalRead += int(toRead)
@@ -147,9 +179,8 @@ func (fl *File) Read(b []byte) (int, error) {
 		}
 	}
 
-	fl.offset += int64(totalRead)
 	var retErr error
-	if fl.offset >= int64(fl.fileSize) {
+	if offset+int64(totalRead) >= int64(fl.fileSize) {
 		retErr = io.EOF
 	}
 	return totalRead, retErr
@@ -165,9 +196,80 @@ func (fl *File) Write(p []byte) (int, error) {
 	if fl == nil || fl.filesystem == nil {
 		return 0, os.ErrClosed
 	}
+	if fl.isAppend {
+		// as for an os.File, every write goes to the end, wherever Seek left the offset
+		fl.offset = int64(fl.fileSize)
+	}
+	n, err := fl.writeAt(p, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
 
+// WriteAt writes len(p) bytes to the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.WriterAt, extending the file if needed. Like the other changes
+// to the filesystem, it is not safe for concurrent use. As for an os.File, it returns an error if
+// the file was opened with O_APPEND.
+func (fl *File) WriteAt(p []byte, off int64) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	if fl.isAppend {
+		return 0, fmt.Errorf("cannot write at an offset to a file opened with O_APPEND: %w", iofs.ErrInvalid)
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("cannot write at offset %d before start of file", off)
+	}
+	return fl.writeAt(p, off)
+}
+
+// zeroes is the size of the chunks of zeroes written to grow files by Truncate
+const zeroes = 1024 * 1024
+
+// Truncate changes the size of the file, without changing the offset of Read and Write, like
+// os.File.Truncate. Shrinking it frees the clusters beyond the new size, and growing it
+// allocates clusters filled with zeroes, e.g. to preallocate a file before writing it.
+func (fl *File) Truncate(size int64) (err error) {
+	if fl == nil || fl.filesystem == nil {
+		return os.ErrClosed
+	}
+	if !fl.isReadWrite {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	defer fl.filesystem.commit(&err)
+	if size < 0 || size > math.MaxUint32 {
+		return fmt.Errorf("cannot truncate to %d bytes, FAT32 files have at most 4GiB: %w", size, iofs.ErrInvalid)
+	}
+	oldSize := int64(fl.fileSize)
+	if size > oldSize {
+		// the new clusters may have the contents of removed files
+		b := make([]byte, min(size-oldSize, zeroes))
+		for offset := oldSize; offset < size; {
+			n, err := fl.writeAt(b[:min(size-offset, int64(len(b)))], offset)
+			if err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		return nil
+	}
+	if size == oldSize {
+		return nil
+	}
+	fs := fl.filesystem
+	if _, err := fs.allocateSpace(uint64(size), fl.clusterLocation); err != nil {
+		return fmt.Errorf("unable to resize cluster list: %w", err)
+	}
+	fl.fileSize = uint32(size)
+	if err := fs.writeDirectoryEntries(fl.parent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %w", err)
+	}
+	return nil
+}
+
+// writeAt writes p at offset, allocating the clusters needed and updating the size of the file
+func (fl *File) writeAt(p []byte, offset int64) (_ int, err error) {
 	totalWritten := 0
-	writableFile, err := fl.filesystem.backend.Writable()
+	writableFile, err := writableBackend(fl.filesystem.backend)
 	if err != nil {
 		return totalWritten, err
 	}
@@ -175,19 +277,20 @@ func (fl *File) Write(p []byte) (int, error) {
 	fs := fl.filesystem
 	// if the file was not opened RDWR, nothing we can do
 	if !fl.isReadWrite {
-		return totalWritten, filesystem.ErrReadonlyFilesystem
+		return totalWritten, filesystem.ErrReadOnlyFilesystem
 	}
+	defer fs.commit(&err)
 	// what is the new file size?
 	writeSize := len(p)
 	oldSize := int64(fl.fileSize)
-	newSize := fl.offset + int64(writeSize)
+	newSize := offset + int64(writeSize)
 	if newSize < oldSize {
 		newSize = oldSize
 	}
 	// 1- ensure we have space and clusters
 	clusters, err := fs.allocateSpace(uint64(newSize), fl.clusterLocation)
 	if err != nil {
-		return 0x00, fmt.Errorf("unable to allocate clusters for file: %v", err)
+		return 0x00, fmt.Errorf("unable to allocate clusters for file: %w", err)
 	}
 
 	// update the directory entry size for the file
@@ -200,11 +303,11 @@ func (fl *File) Write(p []byte) (int, error) {
 	clusterIndex := 0
 
 	// figure out which cluster we start with
-	if fl.offset > 0 {
-		clusterIndex = int(fl.offset) / bytesPerCluster
+	if offset > 0 {
+		clusterIndex = int(offset) / bytesPerCluster
 		lastCluster := clusters[clusterIndex]
 		// write any partials, if needed
-		remainder := fl.offset % int64(bytesPerCluster)
+		remainder := offset % int64(bytesPerCluster)
 		if remainder != 0 {
 			offset := int64(start) + int64(lastCluster-2)*int64(bytesPerCluster) + remainder
 			toWrite := int64(bytesPerCluster) - remainder
@@ -214,7 +317,7 @@ func (fl *File) Write(p []byte) (int, error) {
 			}
 			_, err := writableFile.WriteAt(p[0:toWrite], offset+fs.start)
 			if err != nil {
-				return totalWritten, fmt.Errorf("unable to write to file: %v", err)
+				return totalWritten, fmt.Errorf("unable to write to file: %w", err)
 			}
 			totalWritten += int(toWrite)
 			clusterIndex++
@@ -230,17 +333,15 @@ func (fl *File) Write(p []byte) (int, error) {
 		offset := int64(start) + int64(clusters[i]-2)*int64(bytesPerCluster)
 		_, err := writableFile.WriteAt(p[totalWritten:totalWritten+toWrite], offset+fs.start)
 		if err != nil {
-			return totalWritten, fmt.Errorf("unable to write to file: %v", err)
+			return totalWritten, fmt.Errorf("unable to write to file: %w", err)
 		}
 		totalWritten += toWrite
 	}
 
-	fl.offset += int64(totalWritten)
-
 	// update the parent that we have changed the file size
 	err = fs.writeDirectoryEntries(fl.parent)
 	if err != nil {
-		return 0, fmt.Errorf("error writing directory entries to disk: %v", err)
+		return 0, fmt.Errorf("error writing directory entries to disk: %w", err)
 	}
 
 	return totalWritten, nil
@@ -259,6 +360,8 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 		newOffset = int64(fl.fileSize) + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
+	default:
+		return fl.offset, fmt.Errorf("invalid whence %d: %w", whence, iofs.ErrInvalid)
 	}
 	if newOffset < 0 {
 		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
@@ -0,0 +1,121 @@
+package fat32
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// growChunkSize is the most moved by Grow with each read and write
+const growChunkSize = 1024 * 1024
+
+// Grow grows the filesystem to size bytes, for filesystem.GrowFS, e.g. once its partition has been
+// expanded, with the clusters of the new space free. Where the FATs have no entries for them, the
+// FATs are made larger, and the data region, with the contents of all files, is moved after them;
+// the clusters of files do not change.
+func (fs *FileSystem) Grow(size int64) error {
+	bpb := fs.bootSector.biosParameterBlock
+	current := int64(bpb.dos331BPB.totalSectors) * int64(SectorSize512)
+	switch {
+	case size < current:
+		return fmt.Errorf("cannot shrink FAT32 filesystem of %d bytes to %d bytes", current, size)
+	case size > Fat32MaxSize:
+		return fmt.Errorf("requested size is larger than maximum allowed FAT32, requested %d, maximum %d", size, Fat32MaxSize)
+	case size/int64(SectorSize512) == current/int64(SectorSize512):
+		return nil
+	}
+	// the directories held in memory are written to the clusters before they move
+	if err := fs.flush(); err != nil {
+		return fmt.Errorf("error writing filesystem changes: %w", err)
+	}
+	writableFile, err := writableBackend(fs.backend)
+	if err != nil {
+		return err
+	}
+
+	totalSectors := uint32(size / int64(SectorSize512))
+	reservedSectors := uint32(bpb.dos331BPB.dos20BPB.reservedSectors)
+	sectorsPerCluster := uint32(bpb.dos331BPB.dos20BPB.sectorsPerCluster)
+	// the FATs need an entry for each cluster of the data region after them, and the 2 reserved
+	sectorsPerFat := bpb.sectorsPerFat
+	for {
+		dataSectors := totalSectors - reservedSectors - 2*sectorsPerFat
+		entries := dataSectors/sectorsPerCluster + 2
+		needed := (entries*4 + uint32(SectorSize512) - 1) / uint32(SectorSize512)
+		if needed <= sectorsPerFat {
+			break
+		}
+		sectorsPerFat = needed
+	}
+
+	if sectorsPerFat > bpb.sectorsPerFat {
+		fatSize := sectorsPerFat * uint32(SectorSize512)
+		dataStart := reservedSectors*uint32(SectorSize512) + 2*fatSize
+		// only the clusters up to the last in use need to move
+		last := uint32(1)
+		for cluster := fs.dataClusters() - 1; cluster >= 2; cluster-- {
+			if fs.table.clusters[cluster] != fs.table.unusedMarker {
+				last = cluster
+				break
+			}
+		}
+		length := int64(last-1) * int64(fs.bytesPerCluster)
+		if err := moveData(writableFile, fs.start+int64(fs.dataStart), fs.start+int64(dataStart), length); err != nil {
+			return fmt.Errorf("error moving data region after larger FATs: %w", err)
+		}
+		maxCluster := fatSize / 4
+		fs.table.clusters = slices.Grow(fs.table.clusters, int(maxCluster)+1-len(fs.table.clusters))
+		fs.table.clusters = fs.table.clusters[:maxCluster+1]
+		fs.table.size = fatSize
+		fs.table.maxCluster = maxCluster
+		// the changes to the FAT past its old end are written on flush like the others
+		fs.pendingWrites().resizeFat(fatSize)
+		fs.dataStart = dataStart
+		bpb.sectorsPerFat = sectorsPerFat
+	}
+	bpb.dos331BPB.totalSectors = totalSectors
+	fs.size = size
+
+	if fs.fsis.freeDataClustersCount != unknownFreeDataClusterCount {
+		_, free, err := fs.Space()
+		if err != nil {
+			return err
+		}
+		fs.fsis.freeDataClustersCount = uint32(free / int64(fs.bytesPerCluster))
+	}
+	if err := fs.writeFat(); err != nil {
+		return fmt.Errorf("failed to write the file allocation table: %w", err)
+	}
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	if err := fs.writeFsis(); err != nil {
+		return fmt.Errorf("failed to write the file system information sector: %w", err)
+	}
+	return nil
+}
+
+// moveData moves length bytes at from to the later offset to, from the end, so that the ranges may
+// overlap
+func moveData(w backend.WritableFile, from, to, length int64) error {
+	b := make([]byte, min(growChunkSize, length))
+	for end := length; end > 0; {
+		n := min(int64(len(b)), end)
+		offset := end - n
+		read, err := w.ReadAt(b[:n], from+offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if int64(read) != n {
+			return fmt.Errorf("read %d bytes instead of %d at %d", read, n, from+offset)
+		}
+		if _, err := w.WriteAt(b[:n], to+offset); err != nil {
+			return err
+		}
+		end = offset
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package fat32
+
+import (
+	"strings"
+	"sync"
+)
+
+// dirIndex is an index of the directories read so far by their path, with their entries, so that
+// opening many files in the same directories, e.g. when copying a tree in, does not read every
+// directory from the root again each time. It is built as directories are walked, and kept up to
+// date because the filesystem changes the directories of the index in place; those renamed or
+// removed are dropped. Paths are indexed with the long names of the directories, or the short
+// names of those without, whichever name they were walked with, so that each directory is held
+// once. A nil index indexes nothing.
+type dirIndex struct {
+	mu   sync.Mutex
+	dirs map[string]*Directory
+}
+
+func newDirIndex() *dirIndex {
+	return &dirIndex{dirs: map[string]*Directory{}}
+}
+
+// indexKey returns the key of the directory with the path elements, compared without case as
+// names are in FAT
+func indexKey(paths []string) string {
+	return strings.ToUpper("/" + strings.Join(paths, "/"))
+}
+
+// indexName returns the name the directory of the entry is indexed with in the path of those below
+func indexName(e *directoryEntry) string {
+	if e.filenameLong != "" {
+		return e.filenameLong
+	}
+	if e.fileExtension != "" {
+		return e.filenameShort + "." + e.fileExtension
+	}
+	return e.filenameShort
+}
+
+// get returns the directory of the path elements, or nil if it is not in the index
+func (x *dirIndex) get(paths []string) *Directory {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.dirs[indexKey(paths)]
+}
+
+// add adds the directory of the path elements, named as by indexName, with its entries read
+func (x *dirIndex) add(paths []string, dir *Directory) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.dirs[indexKey(paths)] = dir
+}
+
+// drop removes the directory starting at the cluster, and all of those below it
+func (x *dirIndex) drop(cluster uint32) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for key, dir := range x.dirs {
+		if dir.clusterLocation != cluster {
+			continue
+		}
+		for k := range x.dirs {
+			if k == key || strings.HasPrefix(k, key+"/") {
+				delete(x.dirs, k)
+			}
+		}
+		return
+	}
+}
+
+// reset empties the index, when directories in memory may no longer be those on disk
+func (x *dirIndex) reset() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	clear(x.dirs)
+}
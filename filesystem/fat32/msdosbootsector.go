@@ -43,7 +43,7 @@ func msDosBootSectorFromBytes(b []byte) (*msDosBootSector, error) {
 	// extract the EBPB and its size
 	bpb, bpbSize, err := dos71EBPBFromBytes(b[11:90])
 	if err != nil {
-		return nil, fmt.Errorf("could not read FAT32 BIOS Parameter Block from boot sector: %v", err)
+		return nil, fmt.Errorf("could not read FAT32 BIOS Parameter Block from boot sector: %w", err)
 	}
 	bs.biosParameterBlock = bpb
 
@@ -83,7 +83,7 @@ func (m *msDosBootSector) toBytes() ([]byte, error) {
 	// bytes for the EBPB
 	bpbBytes, err := m.biosParameterBlock.toBytes()
 	if err != nil {
-		return nil, fmt.Errorf("error getting FAT32 EBPB: %v", err)
+		return nil, fmt.Errorf("error getting FAT32 EBPB: %w", err)
 	}
 	copy(b[11:], bpbBytes)
 	bpbLen := len(bpbBytes)
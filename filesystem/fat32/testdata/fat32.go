@@ -26,7 +26,7 @@ func mkfs(name string) filesystem.FileSystem {
 		os.Exit(1)
 	}
 
-	spec := disk.FilesystemSpec{
+	spec := disk.FormatSpec{
 		Partition: 0,
 		FSType:    filesystem.TypeFat32,
 	}
@@ -87,7 +87,7 @@ dosfsck -v -l /data/fat32.img > /data/fsck.txt
 
 fsstat -f fat32 /data/fat32.img > /data/fsstat.txt
 
-# get the serial number
-dd if=/data/fat32.img bs=1 skip=67 count=4 2>/dev/null| hexdump -e '4/1 "%02x"' > /data/serial.txt
+# get the serial number, the value of its 4 little-endian bytes
+dd if=/data/fat32.img bs=1 skip=67 count=4 2>/dev/null| hexdump -e '4/1 "%02x " "\n"' | awk '{print $4 $3 $2 $1}' > /data/serial.txt
 
 EOF
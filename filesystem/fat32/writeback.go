@@ -0,0 +1,205 @@
+package fat32
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// fatChunkSize is the size of the aligned pieces of the FAT that are written when changed
+	fatChunkSize = 64 * 1024
+	// maxPendingDirBytes is how much of the directories may be changed in memory before the
+	// changes are flushed without waiting for Sync or Close
+	maxPendingDirBytes = 8 * 1024 * 1024
+)
+
+// pendingWrites holds the metadata changed in memory but not yet written: the pieces of the FAT,
+// the FS Information Sector and the clusters of directories. Populating a filesystem with many
+// small files allocates clusters and rewrites the same directories over and over; keeping the
+// changes until flush writes each piece once, with contiguous ones in a single write.
+type pendingWrites struct {
+	// fatChunks are the changed fatChunkSize pieces of the FAT
+	fatChunks []bool
+	fatDirty  bool
+	fsisDirty bool
+	// dirs are the contents of the changed directory clusters, by cluster
+	dirs     map[uint32][]byte
+	dirBytes int
+}
+
+// pendingWrites returns the changes not yet written, none when first called
+func (fs *FileSystem) pendingWrites() *pendingWrites {
+	if fs.pending == nil {
+		fs.pending = &pendingWrites{
+			fatChunks: make([]bool, (int(fs.table.size)+fatChunkSize-1)/fatChunkSize),
+			dirs:      map[uint32][]byte{},
+		}
+	}
+	return fs.pending
+}
+
+// SetWriteBack sets whether the changes to the FAT, the FS Information Sector and the directories
+// are held in memory until Sync or Close, false by default. Either way they are coalesced, each
+// changed piece of the FAT and each directory written once in as few writes as possible, but by
+// default at the end of every operation, so that the filesystem on disk is always complete. With
+// write back, populating a filesystem with many small files writes them only once, on Sync or
+// Close, or once enough of the directories is waiting; until then the filesystem on disk is not
+// complete, and must not be read otherwise.
+func (fs *FileSystem) SetWriteBack(enabled bool) {
+	fs.writeBack = enabled
+}
+
+// commit writes the changes of an operation as it ends, unless held until Sync or Close, setting
+// err if it was nil and the changes cannot be written
+func (fs *FileSystem) commit(err *error) {
+	if fs.writeBack {
+		return
+	}
+	if flushErr := fs.flush(); flushErr != nil && *err == nil {
+		*err = flushErr
+	}
+}
+
+// setCluster sets the FAT entry of the cluster, to be written on flush
+func (fs *FileSystem) setCluster(cluster, value uint32) error {
+	p := fs.pendingWrites()
+	chunk := int(cluster) * 4 / fatChunkSize
+	if cluster >= fs.table.maxCluster || chunk >= len(p.fatChunks) {
+		return fmt.Errorf("cluster %d beyond the FAT of %d entries", cluster, fs.table.maxCluster)
+	}
+	fs.table.clusters[cluster] = value
+	p.fatChunks[chunk] = true
+	p.fatDirty = true
+	return nil
+}
+
+// resizeFat resizes the pending changes to the FAT, once the table has a new size
+func (p *pendingWrites) resizeFat(size uint32) {
+	chunks := (int(size) + fatChunkSize - 1) / fatChunkSize
+	p.fatChunks = slices.Grow(p.fatChunks, chunks-len(p.fatChunks))[:chunks]
+}
+
+// writeDirCluster keeps the contents of a directory cluster to be written on flush, flushing
+// everything once enough of the directories is waiting
+func (fs *FileSystem) writeDirCluster(cluster uint32, b []byte) error {
+	p := fs.pendingWrites()
+	if old, ok := p.dirs[cluster]; ok {
+		p.dirBytes -= len(old)
+	}
+	p.dirs[cluster] = slices.Clone(b)
+	p.dirBytes += len(b)
+	if p.dirBytes >= maxPendingDirBytes {
+		return fs.flush()
+	}
+	return nil
+}
+
+// readDirCluster returns the contents of a directory cluster not yet written, if any
+func (fs *FileSystem) readDirCluster(cluster uint32) ([]byte, bool) {
+	b, ok := fs.pendingWrites().dirs[cluster]
+	return b, ok
+}
+
+// dropDirCluster forgets the contents of a cluster no longer part of a directory, so that they
+// are not written over what the cluster is used for next
+func (fs *FileSystem) dropDirCluster(cluster uint32) {
+	p := fs.pendingWrites()
+	if old, ok := p.dirs[cluster]; ok {
+		p.dirBytes -= len(old)
+		delete(p.dirs, cluster)
+	}
+}
+
+// empty reports whether there is nothing to write
+func (p *pendingWrites) empty() bool {
+	return len(p.dirs) == 0 && !p.fatDirty && !p.fsisDirty
+}
+
+// flush writes the changes held in memory: the directory clusters, the changed pieces of both
+// copies of the FAT and the FS Information Sector, each run of contiguous pieces in one write
+func (fs *FileSystem) flush() error {
+	p := fs.pendingWrites()
+	if p.empty() {
+		return nil
+	}
+	writableFile, err := writableBackend(fs.backend)
+	if err != nil {
+		return err
+	}
+
+	// directory clusters first, so that the FAT never points at clusters not written yet
+	clusters := make([]uint32, 0, len(p.dirs))
+	for cluster := range p.dirs {
+		clusters = append(clusters, cluster)
+	}
+	slices.Sort(clusters)
+	for i := 0; i < len(clusters); {
+		j := i + 1
+		for j < len(clusters) && clusters[j] == clusters[j-1]+1 {
+			j++
+		}
+		b := make([]byte, 0, (j-i)*fs.bytesPerCluster)
+		for _, cluster := range clusters[i:j] {
+			b = append(b, p.dirs[cluster]...)
+		}
+		clusterStart := fs.start + int64(fs.dataStart) + int64(clusters[i]-2)*int64(fs.bytesPerCluster)
+		written, err := writableFile.WriteAt(b, clusterStart)
+		if err != nil {
+			return fmt.Errorf("error writing directory entries: %w", err)
+		}
+		if written != len(b) {
+			return fmt.Errorf("wrote %d bytes to clusters %d-%d instead of expected %d", written, clusters[i], clusters[j-1], len(b))
+		}
+		i = j
+	}
+	clear(p.dirs)
+	p.dirBytes = 0
+
+	if p.fatDirty {
+		if err := fs.writeFatChunks(p.fatChunks); err != nil {
+			return err
+		}
+		clear(p.fatChunks)
+		p.fatDirty = false
+	}
+
+	if p.fsisDirty {
+		if err := fs.writeFsis(); err != nil {
+			return fmt.Errorf("failed to write the file system information sector: %w", err)
+		}
+		p.fsisDirty = false
+	}
+	return nil
+}
+
+// writeFatChunks writes the changed pieces of the FAT to both copies
+func (fs *FileSystem) writeFatChunks(chunks []bool) error {
+	reservedSectors := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
+	fatPrimaryStart := int64(reservedSectors) * int64(SectorSize512)
+	fatSecondaryStart := fatPrimaryStart + int64(fs.table.size)
+
+	writableFile, err := writableBackend(fs.backend)
+	if err != nil {
+		return err
+	}
+	fatBytes := fs.table.bytes()
+	for i := 0; i < len(chunks); {
+		if !chunks[i] {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(chunks) && chunks[j] {
+			j++
+		}
+		start, end := i*fatChunkSize, min(j*fatChunkSize, len(fatBytes))
+		if _, err := writableFile.WriteAt(fatBytes[start:end], fatPrimaryStart+int64(start)+fs.start); err != nil {
+			return fmt.Errorf("unable to write primary FAT table: %w", err)
+		}
+		if _, err := writableFile.WriteAt(fatBytes[start:end], fatSecondaryStart+int64(start)+fs.start); err != nil {
+			return fmt.Errorf("unable to write backup FAT table: %w", err)
+		}
+		i = j
+	}
+	return nil
+}
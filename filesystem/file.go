@@ -3,9 +3,28 @@ package filesystem
 import "io"
 
 // File a reference to a single file on disk
+//
+// ReadAt and WriteAt read and write at the offsets given, without the offset shared by Read,
+// Write and Seek, so that several goroutines can read ranges of the same File in parallel, e.g.
+// to copy it in chunks or serve ranges of it. WriteAt returns ErrReadOnlyFilesystem where the
+// filesystem cannot be changed, and writes are not safe for concurrent use, see the concurrency of
+// the package.
 type File interface {
 	io.ReadWriteSeeker
 	io.Closer
-	// io.ReaderAt
-	// io.WriterAt
+	io.ReaderAt
+	io.WriterAt
+	// Truncate changes the size of the file like os.File.Truncate, without changing its offset:
+	// growing it fills it with zeroes. It fails if the file is not open for writing, and returns
+	// ErrReadOnlyFilesystem where the filesystem cannot be changed.
+	Truncate(size int64) error
+}
+
+// LocatedFile is implemented by the files of the filesystems that can tell where their contents
+// are stored, so that many files can be read in the order of the disk rather than of their names,
+// see Manifest
+type LocatedFile interface {
+	// DataOffset returns the offset in bytes, from the start of the filesystem, of the first
+	// block of the contents of the file, or -1 if none is stored, e.g. for an empty file
+	DataOffset() int64
 }
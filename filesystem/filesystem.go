@@ -1,16 +1,48 @@
 // Package filesystem provides interfaces and constants required for filesystem implementations.
 // All interesting implementations are in subpackages, e.g. github.com/diskfs/go-diskfs/filesystem/fat32
+//
+// # Concurrency
+//
+// The reads of every FileSystem of this module, fat32, ext4, squashfs and iso9660, are safe for
+// concurrent use by several goroutines: Stat, Lstat, Readlink, ReadDir, WalkDir, ReadFile, Open,
+// and OpenFile of files to read them. Each File keeps its own offset, so that goroutines reading
+// the same file in parallel each open it; a File itself is not safe for concurrent use, as its
+// Read and Seek share that offset. The filesystems only read their backend with ReadAt, which the
+// backend must allow concurrently, see package backend.
+//
+// Changes to a FileSystem, and the writes to a File, are not safe for concurrent use with each
+// other nor with reads, and must be serialized by the caller.
 package filesystem
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"time"
 )
 
+// The errors of all filesystems wrap those of io/fs, such as fs.ErrNotExist for missing files and
+// fs.ErrExist for files that must not exist yet, or the errors below, so that they can be told
+// apart with errors.Is.
 var (
-	ErrNotSupported       = errors.New("method not supported by this filesystem")
-	ErrNotImplemented     = errors.New("method not implemented (patches are welcome)")
-	ErrReadonlyFilesystem = errors.New("read-only filesystem")
+	ErrNotSupported   = errors.New("method not supported by this filesystem")
+	ErrNotImplemented = errors.New("method not implemented (patches are welcome)")
+	// ErrReadOnlyFilesystem is returned by all operations that would change a filesystem which is
+	// read-only, either by format or because its backend is not writable. It wraps fs.ErrPermission.
+	ErrReadOnlyFilesystem = fmt.Errorf("read-only filesystem: %w", fs.ErrPermission)
+	// Deprecated: use ErrReadOnlyFilesystem, which is the same error
+	ErrReadonlyFilesystem = ErrReadOnlyFilesystem
+	// ErrNoSpace is returned when a filesystem has no room left for what is written to it
+	ErrNoSpace = errors.New("no space left on device")
+	// ErrNotDir is returned when an element of a path that must be a directory is not one
+	ErrNotDir = errors.New("not a directory")
+	// ErrIsDir is returned when a directory is used where a file is expected
+	ErrIsDir = errors.New("is a directory")
+	// ErrNotEmpty is returned when removing a directory that still has entries
+	ErrNotEmpty = errors.New("directory not empty")
+	// ErrNoXattr is returned when reading or removing an extended attribute a file does not have
+	ErrNoXattr = errors.New("no such extended attribute")
 )
 
 // FileSystem is a reference to a single filesystem on a disk
@@ -32,10 +64,19 @@ type FileSystem interface {
 	// Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
 	// it changes the uid and gid of the link's target. A uid or gid of -1 means to not change that value
 	Chown(name string, uid, gid int) error
-	// ReadDir read the contents of a directory
-	ReadDir(pathname string) ([]os.FileInfo, error)
+	// ReadDir reads the contents of a directory, and returns its entries sorted by name, without
+	// those for the directory itself and its parent. The path is absolute, e.g. "/a/b", or in the
+	// form of io/fs, e.g. "a/b", or "." for the root, so that the filesystem is an fs.ReadDirFS.
+	ReadDir(pathname string) ([]fs.DirEntry, error)
 	// OpenFile open a handle to read or write to a file
 	OpenFile(pathname string, flag int) (File, error)
+	// Open opens the file or directory read-only, with the name in the form of io/fs, e.g. "a/b",
+	// so that the filesystem is an fs.FS
+	Open(name string) (fs.File, error)
+	// Stat describes the file or directory, with the name in the form of io/fs, or absolute
+	Stat(name string) (fs.FileInfo, error)
+	// ReadFile reads the whole file, with the name in the form of io/fs
+	ReadFile(name string) ([]byte, error)
 	// Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
 	Rename(oldpath, newpath string) error
 	// removes the named file or (empty) directory.
@@ -46,10 +87,104 @@ type FileSystem interface {
 	// SetLabel changes the label on the writable filesystem. Different file system may hav different
 	// length constraints.
 	SetLabel(label string) error
+	// Sync makes the changes written to the filesystem so far durable on its backend, without
+	// closing it. Filesystems built in a workspace, such as ISO9660, are only written by Finalize.
+	Sync() error
 	// Close will cleanup the temporary files created by the filesystem generation steps
 	Close() error
 }
 
+// LstatFS is implemented by the filesystems with symbolic links, see Lstat
+type LstatFS interface {
+	// Lstat describes the named file like Stat, but describes symbolic links themselves rather
+	// than the files they point to
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// ReadlinkFS is implemented by the filesystems that can read the targets of their symbolic links,
+// see Readlink
+type ReadlinkFS interface {
+	// Readlink returns the target of the named symbolic link
+	Readlink(name string) (string, error)
+}
+
+// ChmodFS is a filesystem that can change the mode of its files. Every FileSystem has Chmod, which
+// returns ErrNotSupported or ErrNotImplemented where the filesystem cannot keep modes.
+type ChmodFS interface {
+	Chmod(name string, mode os.FileMode) error
+}
+
+// ChownFS is a filesystem that can change the owner of its files. Every FileSystem has Chown,
+// which returns ErrNotSupported or ErrNotImplemented where the filesystem cannot keep owners.
+type ChownFS interface {
+	Chown(name string, uid, gid int) error
+}
+
+// RemoveFS is a filesystem that can remove its files. Every FileSystem has Remove, which returns
+// ErrReadOnlyFilesystem where the filesystem cannot be changed, such as a finalized squashfs or ISO9660.
+type RemoveFS interface {
+	Remove(name string) error
+}
+
+// RenameFS is a filesystem that can rename and move its files. Every FileSystem has Rename, which
+// returns ErrReadOnlyFilesystem where the filesystem cannot be changed, such as a finalized squashfs
+// or ISO9660.
+type RenameFS interface {
+	Rename(oldpath, newpath string) error
+}
+
+// MknodFS is a filesystem that can create device files and named pipes. Every FileSystem has
+// Mknod, which returns ErrNotSupported or ErrNotImplemented where the filesystem cannot keep them.
+// The mode has the type bits and permissions of Linux, see UnixMode, and dev the numbers of devices
+// encoded by Mkdev. The Sys of the FileInfo of the device files of those that can is a Device.
+type MknodFS interface {
+	Mknod(pathname string, mode uint32, dev int) error
+}
+
+// ChtimesFS is implemented by the filesystems that can change the times of their files, so that
+// generic tools can keep them when the filesystem supports it
+type ChtimesFS interface {
+	// Chtimes changes the access and modification times of the named file, like os.Chtimes. A
+	// zero time leaves that time unchanged.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// LinkFS is implemented by the filesystems whose Link creates hard links, and that tell which files
+// are hard links to the same one, so that CopyTree and ToTar keep them.
+type LinkFS interface {
+	// Link creates newpath as a hard link to the existing file at oldpath, which is not a
+	// directory, or returns an error wrapping fs.ErrExist where newpath already exists
+	Link(oldpath, newpath string) error
+	// Inode returns the number of the inode of the named file, the same for all the hard links to
+	// it and different for every other file in the filesystem. Symbolic links are not followed.
+	Inode(name string) (uint64, error)
+}
+
+// XattrFS is implemented by the filesystems that keep extended attributes of files, such as
+// security labels and file capabilities, see Xattrs. Symbolic links are not followed: the
+// attributes are those of the named file itself, like lgetxattr. Attributes are named with their
+// namespace, e.g. "security.selinux" or "user.comment".
+type XattrFS interface {
+	// Listxattr returns the names of the extended attributes of the named file, sorted
+	Listxattr(name string) ([]string, error)
+	// Getxattr returns the value of the extended attribute of the named file, or an error
+	// wrapping ErrNoXattr if it has none by that name
+	Getxattr(name, attr string) ([]byte, error)
+	// Setxattr creates or replaces the extended attribute of the named file. It returns
+	// ErrReadOnlyFilesystem where the filesystem cannot be changed.
+	Setxattr(name, attr string, value []byte) error
+	// Removexattr removes the extended attribute of the named file, or returns an error wrapping
+	// ErrNoXattr if it has none by that name
+	Removexattr(name, attr string) error
+}
+
+// every FileSystem can be used with the functions of io/fs
+var _ interface {
+	fs.ReadDirFS
+	fs.StatFS
+	fs.ReadFileFS
+} = FileSystem(nil)
+
 // Type represents the type of disk this is
 type Type int
 
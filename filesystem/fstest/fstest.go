@@ -0,0 +1,227 @@
+// Package fstest checks that a filesystem.FileSystem behaves as an io/fs file system, with
+// testing/fstest.TestFS, and that its own methods agree with its io/fs methods on every path.
+//
+// It is meant for the tests of each filesystem implementation, so that all of them resolve and
+// report paths the same way:
+//
+//	fs, err := fat32.Create(b, size, 0, 512, "test")
+//	...
+//	if err := fstest.Write(fs, fstest.Tree); err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := fstest.TestFS(fs, fstest.Tree.Names()...); err != nil {
+//		t.Fatal(err)
+//	}
+package fstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing/fstest"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// Files is a tree of files, with the contents of each file by its name in the form of io/fs.
+// Names ending with "/" are directories. The parent directories of every name are part of the
+// tree as well.
+type Files map[string]string
+
+// Tree is a small tree of files with names valid on every filesystem implementation, including
+// ISO9660 without extensions
+var Tree = Files{
+	"README.TXT":             "diskfs test tree\n",
+	"EMPTY.TXT":              "",
+	"DIR1/FILE1.TXT":         "file one\n",
+	"DIR1/SUB/DEEP.BIN":      strings.Repeat("0123456789abcdef", 1000),
+	"DIR1/SUB/SUBSUB/LEAF.X": "leaf\n",
+	"DIR2/":                  "",
+	"DIR3/LARGE.BIN":         strings.Repeat("diskfs", 50000),
+}
+
+// Names returns the names of the files and directories of the tree, sorted, for TestFS
+func (f Files) Names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, strings.TrimSuffix(name, "/"))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Write creates the tree of files in a writable FileSystem, or in the workspace of one not yet
+// finalized
+func Write(f filesystem.FileSystem, files Files) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if dir, ok := strings.CutSuffix(name, "/"); ok {
+			if err := f.Mkdir("/" + dir); err != nil {
+				return fmt.Errorf("error creating directory %s: %w", dir, err)
+			}
+			continue
+		}
+		if dir := path.Dir(name); dir != "." {
+			if err := f.Mkdir("/" + dir); err != nil {
+				return fmt.Errorf("error creating directory %s: %w", dir, err)
+			}
+		}
+		file, err := f.OpenFile("/"+name, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+		if err != nil {
+			return fmt.Errorf("error creating file %s: %w", name, err)
+		}
+		if _, err := file.Write([]byte(files[name])); err != nil {
+			return fmt.Errorf("error writing file %s: %w", name, err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("error closing file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// TestFS tests the FileSystem with fstest.TestFS, which checks that the expected files are found,
+// and that everything found behaves consistently through Open, ReadDir, Stat and ReadFile. It then
+// checks that the methods of the FileSystem taking absolute paths, ReadDir, Stat and OpenFile,
+// agree with the io/fs methods for every file found, as do filesystem.FS and filesystem.WalkDir.
+// The expected names are in the form of io/fs.
+func TestFS(f filesystem.FileSystem, expected ...string) error {
+	if err := fstest.TestFS(f, expected...); err != nil {
+		return err
+	}
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	// every file found by fs.WalkDir, with its entry
+	found := map[string]fs.DirEntry{}
+	err := fs.WalkDir(f, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		found[name] = d
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking the filesystem: %w", err)
+	}
+
+	walked := map[string]bool{}
+	err = filesystem.WalkDir(f, "/", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(p, "/")
+		if name == "" {
+			name = "."
+		}
+		walked[name] = true
+		if e, ok := found[name]; !ok {
+			fail("%s: found by filesystem.WalkDir but not fs.WalkDir", p)
+		} else if e.IsDir() != d.IsDir() || e.Type() != d.Type() {
+			fail("%s: filesystem.WalkDir entry of type %v, fs.WalkDir %v", p, d.Type(), e.Type())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking the filesystem with filesystem.WalkDir: %w", err)
+	}
+
+	compat := filesystem.FS(f)
+	for name, d := range found {
+		if !walked[name] {
+			fail("%s: found by fs.WalkDir but not filesystem.WalkDir", name)
+		}
+		p := filesystem.AbsolutePath(name)
+		info, err := fs.Stat(f, name)
+		if err != nil {
+			fail("%s: Stat: %v", name, err)
+			continue
+		}
+		absInfo, err := f.Stat(p)
+		if err != nil {
+			fail("%s: Stat(%s): %v", name, p, err)
+			continue
+		}
+		if !sameInfo(info, absInfo) {
+			fail("%s: Stat(%s) = %s, Stat(%s) = %s", name, p, formatInfo(absInfo), name, formatInfo(info))
+		}
+		if d.IsDir() {
+			entries, err := fs.ReadDir(f, name)
+			if err != nil {
+				fail("%s: ReadDir: %v", name, err)
+				continue
+			}
+			absEntries, err := f.ReadDir(p)
+			if err != nil {
+				fail("%s: ReadDir(%s): %v", name, p, err)
+				continue
+			}
+			if !sameEntries(entries, absEntries) {
+				fail("%s: ReadDir(%s) = %v, ReadDir(%s) = %v", name, p, entryNames(absEntries), name, entryNames(entries))
+			}
+			compatEntries, err := compat.ReadDir(p)
+			if err != nil || !sameEntries(entries, compatEntries) {
+				fail("%s: filesystem.FS ReadDir(%s) = %v, %v, ReadDir(%s) = %v", name, p, entryNames(compatEntries), err, name, entryNames(entries))
+			}
+			continue
+		}
+		// OpenFile need not follow symbolic links
+		if !d.Type().IsRegular() {
+			continue
+		}
+		content, err := fs.ReadFile(f, name)
+		if err != nil {
+			fail("%s: ReadFile: %v", name, err)
+			continue
+		}
+		if absContent, err := readFile(f, p); err != nil || !bytes.Equal(content, absContent) {
+			fail("%s: OpenFile(%s) read %d bytes, %v, ReadFile(%s) %d bytes", name, p, len(absContent), err, name, len(content))
+		}
+		if compatContent, err := fs.ReadFile(compat, p); err != nil || !bytes.Equal(content, compatContent) {
+			fail("%s: filesystem.FS ReadFile(%s) read %d bytes, %v, ReadFile(%s) %d bytes", name, p, len(compatContent), err, name, len(content))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func readFile(f filesystem.FileSystem, p string) ([]byte, error) {
+	file, err := f.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func sameInfo(a, b fs.FileInfo) bool {
+	return a.Name() == b.Name() && a.Size() == b.Size() && a.Mode() == b.Mode() && a.ModTime().Equal(b.ModTime()) && a.IsDir() == b.IsDir()
+}
+
+func formatInfo(info fs.FileInfo) string {
+	return fmt.Sprintf("%s IsDir=%v Mode=%v Size=%d ModTime=%v", info.Name(), info.IsDir(), info.Mode(), info.Size(), info.ModTime())
+}
+
+func sameEntries(a, b []fs.DirEntry) bool {
+	return slices.EqualFunc(a, b, func(x, y fs.DirEntry) bool {
+		return x.Name() == y.Name() && x.Type() == y.Type()
+	})
+}
+
+func entryNames(entries []fs.DirEntry) []string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
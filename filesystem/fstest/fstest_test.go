@@ -0,0 +1,110 @@
+package fstest_test
+
+import (
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+const size = 20 * 1024 * 1024
+
+func TestFat32(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(b, size, 0, 512, "fstest")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(fs, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fs, fstest.Tree.Names()...); err != nil {
+		t.Error(err)
+	}
+
+	// and again after reading it back
+	fs, err = fat32.Read(b, size, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if err := fstest.TestFS(fs, fstest.Tree.Names()...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestISO9660(t *testing.T) {
+	for _, rockRidge := range []bool{false, true} {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs, err := iso9660.Create(b, size, 0, 2048, t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fstest.Write(fs, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		// the workspace before finalizing
+		if err := fstest.TestFS(fs, fstest.Tree.Names()...); err != nil {
+			t.Errorf("workspace: %v", err)
+		}
+		if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: rockRidge}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := iso9660.Read(b, size, 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		if err := fstest.TestFS(read, fstest.Tree.Names()...); err != nil {
+			t.Errorf("rock ridge %t: %v", rockRidge, err)
+		}
+	}
+}
+
+func TestSquashfs(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(fs, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fs, fstest.Tree.Names()...); err != nil {
+		t.Errorf("workspace: %v", err)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if err := fstest.TestFS(read, fstest.Tree.Names()...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(b, size, 0, 512, "fstest")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.TestFS(fs, "MISSING.TXT"); err == nil {
+		t.Errorf("no error testing for a missing file")
+	}
+}
@@ -0,0 +1,85 @@
+package filesystem
+
+import (
+	"path"
+	"slices"
+	"strings"
+)
+
+// Glob returns the names of all files matching the pattern, like fs.Glob, with the syntax of
+// path.Match for each element of the pattern, or "**" for any number of directories, including
+// none, e.g. "**/*.conf" or "etc/**/hosts". A final "**" matches every file under the directory.
+// Symbolic links to directories are not followed by "**".
+// The names are in the form of io/fs, or absolute if the pattern is. As with fs.Glob, the only
+// error is path.ErrBadPattern, and errors reading directories are ignored.
+func Glob(f FileSystem, pattern string) ([]string, error) {
+	elements := strings.Split(strings.Trim(pattern, "/"), "/")
+	for _, e := range elements {
+		if _, err := path.Match(e, ""); err != nil {
+			return nil, err
+		}
+	}
+	if pattern == "" || pattern == "." {
+		elements = nil
+	}
+	found := map[string]bool{}
+	glob(f, "/", elements, found)
+
+	names := make([]string, 0, len(found))
+	for p := range found {
+		switch {
+		case strings.HasPrefix(pattern, "/"):
+			names = append(names, p)
+		case p == "/":
+			names = append(names, ".")
+		default:
+			names = append(names, strings.TrimPrefix(p, "/"))
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// glob adds to found the paths under the directory p matching the elements of a pattern
+func glob(f FileSystem, p string, elements []string, found map[string]bool) {
+	if len(elements) == 0 {
+		found[p] = true
+		return
+	}
+	element, rest := elements[0], elements[1:]
+	// a name without metacharacters needs no directory read
+	if !hasMeta(element) {
+		child := path.Join(p, element)
+		if _, err := Lstat(f, child); err == nil {
+			glob(f, child, rest, found)
+		}
+		return
+	}
+	if element == "**" {
+		glob(f, p, rest, found)
+	}
+	entries, err := f.ReadDir(p)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		child := path.Join(p, e.Name())
+		if element == "**" {
+			if e.IsDir() {
+				glob(f, child, elements, found)
+			} else if len(rest) == 0 {
+				// a final ** matches the files as well
+				found[child] = true
+			}
+			continue
+		}
+		if matched, _ := path.Match(element, e.Name()); matched {
+			glob(f, child, rest, found)
+		}
+	}
+}
+
+// hasMeta reports whether the element of a pattern has any of the metacharacters of path.Match
+func hasMeta(element string) bool {
+	return strings.ContainsAny(element, `*?[\`)
+}
@@ -0,0 +1,45 @@
+package filesystem_test
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+)
+
+func TestGlob(t *testing.T) {
+	f := createFat32(t)
+	if err := fstest.Write(f, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.TXT", []string{"EMPTY.TXT", "README.TXT"}},
+		{"DIR?/*", []string{"DIR1/FILE1.TXT", "DIR1/SUB", "DIR3/LARGE.BIN"}},
+		{"**/*.BIN", []string{"DIR1/SUB/DEEP.BIN", "DIR3/LARGE.BIN"}},
+		{"DIR1/**", []string{"DIR1", "DIR1/FILE1.TXT", "DIR1/SUB", "DIR1/SUB/DEEP.BIN", "DIR1/SUB/SUBSUB", "DIR1/SUB/SUBSUB/LEAF.X"}},
+		{"DIR1/**/LEAF.X", []string{"DIR1/SUB/SUBSUB/LEAF.X"}},
+		{"**/SUB/**/*.X", []string{"DIR1/SUB/SUBSUB/LEAF.X"}},
+		{"/DIR[12]", []string{"/DIR1", "/DIR2"}},
+		{"README.TXT", []string{"README.TXT"}},
+		{"MISSING/**", []string{}},
+	}
+	for _, tt := range tests {
+		names, err := filesystem.Glob(f, tt.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tt.pattern, err)
+			continue
+		}
+		if !slices.Equal(names, tt.expected) {
+			t.Errorf("%s: matched %v instead of %v", tt.pattern, names, tt.expected)
+		}
+	}
+	if _, err := filesystem.Glob(f, "DIR1/[/"); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("error %v instead of %v", err, path.ErrBadPattern)
+	}
+}
@@ -0,0 +1,72 @@
+package iso9660
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// codes of the findings of Check, beyond those of filesystem.GenericCheck
+const (
+	// CodeTruncated is a volume larger than the image
+	CodeTruncated = "iso9660-truncated"
+	// CodeExtentInvalid is the extent of a file or directory that goes beyond the volume
+	CodeExtentInvalid = "iso9660-extent-invalid"
+	// CodePathTable is a directory whose location in the path table is not that of its entry
+	CodePathTable = "iso9660-path-table"
+)
+
+// Check checks that the volume fits in the image and that the extents of every file and
+// directory are within the volume, and agree with the path table, after the checks of
+// filesystem.GenericCheck, for filesystem.Checker. A workspace not yet finalized has only the
+// checks of GenericCheck.
+func (fsm *FileSystem) Check(opts filesystem.CheckOptions) ([]filesystem.Finding, error) {
+	findings, err := filesystem.GenericCheck(fsm, opts)
+	if err != nil {
+		return nil, err
+	}
+	if fsm.workspace != "" || fsm.volumes.primary == nil {
+		return findings, nil
+	}
+	add := func(severity filesystem.Severity, code, p, format string, args ...any) {
+		findings = append(findings, filesystem.Finding{Severity: severity, Code: code, Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+	blocks := int64(fsm.volumes.primary.volumeSize)
+	if fsm.size > 0 && blocks*fsm.blocksize > fsm.size {
+		add(filesystem.SeverityError, CodeTruncated, "", "volume of %d blocks in an image of %d bytes", blocks, fsm.size)
+	}
+	// the path table is only used when the extensions allow, see readDirectory
+	usePathtable := fsm.pathTable != nil
+	for _, e := range fsm.suspExtensions {
+		usePathtable = usePathtable && e.UsePathtable()
+	}
+
+	err = filesystem.WalkDir(fsm, "/", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// already found by GenericCheck
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		de, ok := info.(*directoryEntry)
+		if !ok {
+			return nil
+		}
+		if end := int64(de.location) + (int64(de.size)+fsm.blocksize-1)/fsm.blocksize; de.size > 0 && end > blocks {
+			add(filesystem.SeverityError, CodeExtentInvalid, p, "extent of %d bytes at block %d beyond the volume of %d blocks", de.size, de.location, blocks)
+		}
+		if de.isSubdirectory && usePathtable && p != "/" {
+			if location := fsm.pathTable.getLocation(p); location != 0 && location != de.location {
+				add(filesystem.SeverityWarning, CodePathTable, p, "path table has the directory at block %d instead of %d", location, de.location)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error checking filesystem: %w", err)
+	}
+	return findings, nil
+}
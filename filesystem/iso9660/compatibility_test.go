@@ -1,11 +1,15 @@
 package iso9660
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"testing"
+	testfs "testing/fstest"
 
 	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
 )
 
 func TestISO9660FSCompatibility(t *testing.T) {
@@ -41,3 +45,113 @@ func TestISO9660FSCompatibility(t *testing.T) {
 		t.Fatalf("size bad: %d", stat.Size())
 	}
 }
+
+func TestISO9660IOFS(t *testing.T) {
+	f, err := os.Open(ISO9660File)
+	if err != nil {
+		t.Fatalf("Failed to read iso9660 testfile: %v", err)
+	}
+	defer f.Close()
+
+	isofs, err := Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("iso read: %s", err)
+	}
+	var fsys fs.FS = isofs
+	content, err := fs.ReadFile(fsys, "README.MD")
+	if err != nil {
+		t.Fatalf("read file: %s", err)
+	}
+	if len(content) != 7 {
+		t.Errorf("read %d bytes instead of 7", len(content))
+	}
+	info, err := fs.Stat(fsys, "FOO")
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	if !info.IsDir() {
+		t.Errorf("FOO is not a directory")
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatalf("read dir: %s", err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("%d entries in root instead of 5", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].Name() >= entries[i].Name() {
+			t.Errorf("entries not sorted: %s before %s", entries[i-1].Name(), entries[i].Name())
+		}
+	}
+	if _, err := fsys.Open("/README.MD"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("error %v opening absolute name instead of %v", err, fs.ErrInvalid)
+	}
+	if _, err := fsys.Open("MISSING"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error %v opening missing file instead of %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestISO9660TestFS(t *testing.T) {
+	f, err := os.Open(ISO9660File)
+	if err != nil {
+		t.Fatalf("Failed to read iso9660 testfile: %v", err)
+	}
+	defer f.Close()
+
+	isofs, err := Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("iso read: %s", err)
+	}
+	if err := fstest.TestFS(isofs, "README.MD"); err != nil {
+		t.Errorf("%s: %v", ISO9660File, err)
+	}
+
+	// the root of the Rock Ridge image holds a dangling symbolic link, which cannot be opened,
+	// so only its directories are tested
+	rr, err := os.Open(RockRidgeFile)
+	if err != nil {
+		t.Fatalf("Failed to read iso9660 testfile: %v", err)
+	}
+	defer rr.Close()
+
+	isofs, err = Read(file.New(rr, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("iso read: %s", err)
+	}
+	for dir, expected := range map[string]string{"abc": "largefile", "foo": "filename_75"} {
+		sub, err := fs.Sub(isofs, dir)
+		if err != nil {
+			t.Fatalf("sub %s: %v", dir, err)
+		}
+		if err := testfs.TestFS(sub, expected); err != nil {
+			t.Errorf("%s: %s: %v", RockRidgeFile, dir, err)
+		}
+	}
+}
+
+func TestISO9660Symlink(t *testing.T) {
+	f, err := os.Open(RockRidgeFile)
+	if err != nil {
+		t.Fatalf("Failed to read iso9660 testfile: %v", err)
+	}
+	defer f.Close()
+
+	isofs, err := Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("iso read: %s", err)
+	}
+	info, err := isofs.Lstat("link")
+	if err != nil || info.Mode().Type() != fs.ModeSymlink {
+		t.Fatalf("lstat link: %v, %v instead of a symbolic link", info, err)
+	}
+	if target, err := filesystem.Readlink(isofs, "link"); err != nil || target != "/a/b/c/d/ef/g/h" {
+		t.Errorf("symbolic link to %q, %v instead of %q", target, err, "/a/b/c/d/ef/g/h")
+	}
+	if _, err := isofs.Stat("link"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error %v following dangling link instead of %v", err, fs.ErrNotExist)
+	}
+	if _, err := filesystem.Readlink(isofs, "README.md"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("error %v reading regular file as link instead of %v", err, fs.ErrInvalid)
+	}
+}
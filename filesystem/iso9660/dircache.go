@@ -0,0 +1,113 @@
+package iso9660
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// dirCache holds the directories of an image read so far, parsed, by the block they start at, so
+// that resolving many paths in the same directories neither reads nor parses them again. Their
+// entries are found by name with a binary search, rather than by comparing each of them, which
+// matters for directories of thousands of files. An image does not change once read, so nothing is
+// ever dropped. A nil cache caches nothing.
+type dirCache struct {
+	mu   sync.Mutex
+	dirs map[uint32]*cachedDirectory
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{dirs: map[uint32]*cachedDirectory{}}
+}
+
+// get returns the directory at the block, or nil if it is not in the cache
+func (c *dirCache) get(location uint32) *cachedDirectory {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirs[location]
+}
+
+// add adds the directory at the block, returning the one to use, which is the one already cached if
+// it was read at the same time
+func (c *dirCache) add(location uint32, dir *cachedDirectory) *cachedDirectory {
+	if c == nil {
+		return dir
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.dirs[location]; ok {
+		return cached
+	}
+	c.dirs[location] = dir
+	return dir
+}
+
+// cachedDirectory is a directory read from an image, with its entries in the order of their records
+// and, built when first looked up, indexes of them by name
+type cachedDirectory struct {
+	entries []*directoryEntry
+	// byName indexes the entries by Name(), as files are opened
+	byName func() nameIndex
+	// byIdentifier indexes the entries by identifier, as directories are walked
+	byIdentifier func() (nameIndex, error)
+}
+
+func newCachedDirectory(entries []*directoryEntry) *cachedDirectory {
+	return &cachedDirectory{
+		entries: entries,
+		byName: sync.OnceValue(func() nameIndex {
+			idx, _ := newNameIndex(entries, func(de *directoryEntry) (string, error) {
+				return de.Name(), nil
+			})
+			return idx
+		}),
+		byIdentifier: sync.OnceValues(func() (nameIndex, error) {
+			return newNameIndex(entries, (*directoryEntry).identifier)
+		}),
+	}
+}
+
+// nameIndex holds the entries of a directory sorted by a name, to find them with a binary search
+type nameIndex struct {
+	names   []string
+	entries []*directoryEntry
+}
+
+func newNameIndex(entries []*directoryEntry, name func(*directoryEntry) (string, error)) (nameIndex, error) {
+	idx := nameIndex{
+		names:   make([]string, len(entries)),
+		entries: slices.Clone(entries),
+	}
+	for i, de := range entries {
+		n, err := name(de)
+		if err != nil {
+			return nameIndex{}, err
+		}
+		idx.names[i] = n
+	}
+	// the records are sorted by their identifiers already, and so by name unless the names come
+	// from an extension like Rock Ridge
+	if !slices.IsSorted(idx.names) {
+		sort.Stable(idx)
+	}
+	return idx, nil
+}
+
+func (x nameIndex) Len() int           { return len(x.names) }
+func (x nameIndex) Less(i, j int) bool { return x.names[i] < x.names[j] }
+func (x nameIndex) Swap(i, j int) {
+	x.names[i], x.names[j] = x.names[j], x.names[i]
+	x.entries[i], x.entries[j] = x.entries[j], x.entries[i]
+}
+
+// lookup returns the first entry with the name, or nil if there is none
+func (x nameIndex) lookup(name string) *directoryEntry {
+	i, ok := slices.BinarySearch(x.names, name)
+	if !ok {
+		return nil
+	}
+	return x.entries[i]
+}
@@ -0,0 +1,46 @@
+package iso9660
+
+import (
+	"testing"
+)
+
+func TestNameIndex(t *testing.T) {
+	fs := &FileSystem{}
+	var entries []*directoryEntry
+	// out of order, as the names of an extension may be, with a name twice
+	for i, name := range []string{"\x00", "\x01", "zeta", "ALPHA", "beta", "ALPHA", "Gamma"} {
+		entries = append(entries, &directoryEntry{filename: name, location: uint32(i), filesystem: fs, isSelf: i == 0, isParent: i == 1})
+	}
+	dir := newCachedDirectory(entries)
+	byIdentifier, err := dir.byIdentifier()
+	if err != nil {
+		t.Fatalf("error indexing directory: %v", err)
+	}
+	tests := []struct {
+		name     string
+		location uint32
+		found    bool
+	}{
+		{"zeta", 2, true},
+		{"ALPHA", 3, true},
+		{"beta", 4, true},
+		{"Gamma", 6, true},
+		{"gamma", 0, false},
+		{"delta", 0, false},
+	}
+	for _, tt := range tests {
+		entry := byIdentifier.lookup(tt.name)
+		switch {
+		case !tt.found && entry != nil:
+			t.Errorf("found %s at %d, which does not exist", tt.name, entry.location)
+		case tt.found && entry == nil:
+			t.Errorf("did not find %s", tt.name)
+		case tt.found && entry.location != tt.location:
+			t.Errorf("found %s at %d instead of %d", tt.name, entry.location, tt.location)
+		}
+	}
+	// the entries themselves are in the order of their records still
+	if dir.entries[2].filename != "zeta" {
+		t.Errorf("entries of the directory were reordered")
+	}
+}
@@ -125,11 +125,11 @@ func (de *directoryEntry) toBytes(skipExt bool, ceBlocks []uint32) ([][]byte, er
 			if de.isSubdirectory {
 				nametype = "directory"
 			}
-			return nil, fmt.Errorf("invalid %s %s: %v", nametype, de.filename, err)
+			return nil, fmt.Errorf("invalid %s %s: %w", nametype, de.filename, err)
 		}
 		filenameBytes, err = stringToASCIIBytes(de.filename)
 		if err != nil {
-			return nil, fmt.Errorf("error converting filename to bytes: %v", err)
+			return nil, fmt.Errorf("error converting filename to bytes: %w", err)
 		}
 	}
 
@@ -141,7 +141,7 @@ func (de *directoryEntry) toBytes(skipExt bool, ceBlocks []uint32) ([][]byte, er
 	if !skipExt {
 		extBytes, err = dirEntryExtensionsToBytes(de.extensions, directoryEntryMaxSize-len(b), de.filesystem.blocksize, ceBlocks)
 		if err != nil {
-			return nil, fmt.Errorf("enable to convert directory entry SUSP extensions to bytes: %v", err)
+			return nil, fmt.Errorf("enable to convert directory entry SUSP extensions to bytes: %w", err)
 		}
 		b = append(b, extBytes[0]...)
 	}
@@ -253,7 +253,7 @@ func dirEntryFromBytes(b []byte, ext []suspExtension) (*directoryEntry, error) {
 		var err error
 		suspFields, err = parseDirectoryEntryExtensions(b[33+nameLenWithPadding:], ext)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse directory entry extensions: %v", err)
+			return nil, fmt.Errorf("unable to parse directory entry extensions: %w", err)
 		}
 	}
 
@@ -290,7 +290,7 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 	// get the bytes
 	de, err := dirEntryFromBytes(b[:entryLen], f.suspExtensions)
 	if err != nil {
-		return nil, fmt.Errorf("invalid directory entry : %v", err)
+		return nil, fmt.Errorf("invalid directory entry : %w", err)
 	}
 	de.filesystem = f
 
@@ -307,7 +307,7 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 				continuationBytes := make([]byte, size)
 				read, err := f.backend.ReadAt(continuationBytes, location*f.blocksize+offset)
 				if err != nil {
-					return nil, fmt.Errorf("error reading continuation entry data at %d: %v", location, err)
+					return nil, fmt.Errorf("error reading continuation entry data at %d: %w", location, err)
 				}
 				if read != size {
 					return nil, fmt.Errorf("read continuation entry data %d bytes instead of expected %d", read, size)
@@ -315,7 +315,7 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 				// parse and append
 				entries, err := parseDirectoryEntryExtensions(continuationBytes, f.suspExtensions)
 				if err != nil {
-					return nil, fmt.Errorf("error parsing continuation entry data at %d: %v", location, err)
+					return nil, fmt.Errorf("error parsing continuation entry data at %d: %w", location, err)
 				}
 				// remove the CE one from the extensions array and append our new ones
 				de.extensions = append(de.extensions[:len(de.extensions)-1], entries...)
@@ -342,7 +342,7 @@ func parseDirEntries(b []byte, f *FileSystem) ([]*directoryEntry, error) {
 		}
 		de, err := parseDirEntry(b[i+0:i+entryLen], f)
 		if err != nil {
-			return nil, fmt.Errorf("invalid directory entry %d at byte %d: %v", count, i, err)
+			return nil, fmt.Errorf("invalid directory entry %d at byte %d: %w", count, i, err)
 		}
 		// some extensions to directory relocation, so check if we should ignore it
 		if f.suspEnabled {
@@ -367,87 +367,80 @@ func (de *directoryEntry) getLocation(p string) (location, size uint32, err erro
 	// break path down into parts and levels
 	parts := splitPath(p)
 	if len(parts) == 0 {
-		location = de.location
-		size = de.size
-	} else {
-		current := parts[0]
-		// read the directory bytes
-		dirb := make([]byte, de.size)
-		n, err := de.filesystem.backend.ReadAt(dirb, int64(de.location)*de.filesystem.blocksize)
-		if err != nil {
-			return 0, 0, fmt.Errorf("could not read directory: %v", err)
-		}
-		if n != len(dirb) {
-			return 0, 0, fmt.Errorf("read %d bytes instead of expected %d", n, len(dirb))
-		}
-		// parse those entries
-		dirEntries, err := parseDirEntries(dirb, de.filesystem)
-		if err != nil {
-			return 0, 0, fmt.Errorf("could not parse directory: %v", err)
-		}
-		// find the entry among the children that has the desired name
-		for _, entry := range dirEntries {
-			// do we have an alternate name?
-			// only care if not self or parent entry
-			checkFilename := entry.filename
-			if de.filesystem.suspEnabled && !entry.isSelf && !entry.isParent {
-				for _, e := range de.filesystem.suspExtensions {
-					filename, err2 := e.GetFilename(entry)
-					switch {
-					case err2 != nil && err2 == ErrSuspFilenameUnsupported:
-						continue
-					case err2 != nil:
-						return 0, 0, fmt.Errorf("extension %s count not find a filename property: %v", e.ID(), err2)
-					default:
-						checkFilename = filename
-						//nolint:gosimple // redundant break, but we want this explicit
-						break
-					}
+		return de.location, de.size, nil
+	}
+	current := parts[0]
+	// read the directory entries, and find the one among the children that has the desired name
+	dir, err := de.filesystem.readDirectoryAt(de.location, de.size)
+	if err != nil {
+		return 0, 0, err
+	}
+	byIdentifier, err := dir.byIdentifier()
+	if err != nil {
+		return 0, 0, err
+	}
+	entry := byIdentifier.lookup(current)
+	if entry == nil {
+		return 0, 0, nil
+	}
+	if len(parts) == 1 {
+		// this is the final one, we found it, keep it
+		return entry.location, entry.size, nil
+	}
+	// just dig down further - what if it looks like a file, but is a relocated directory?
+	if !entry.isSubdirectory && de.filesystem.suspEnabled && !entry.isSelf && !entry.isParent {
+		for _, e := range de.filesystem.suspExtensions {
+			location2 := e.GetDirectoryLocation(entry)
+			if location2 != 0 {
+				// need to get the directory entry for the child
+				dirb := make([]byte, de.filesystem.blocksize)
+				n, err2 := de.filesystem.backend.ReadAt(dirb, int64(location2)*de.filesystem.blocksize)
+				if err2 != nil {
+					return 0, 0, fmt.Errorf("could not read bytes of relocated directory %s from block %d: %w", current, location2, err2)
 				}
-			}
-			if checkFilename == current {
-				if len(parts) > 1 {
-					// just dig down further - what if it looks like a file, but is a relocated directory?
-					if !entry.isSubdirectory && de.filesystem.suspEnabled && !entry.isSelf && !entry.isParent {
-						for _, e := range de.filesystem.suspExtensions {
-							location2 := e.GetDirectoryLocation(entry)
-							if location2 != 0 {
-								// need to get the directory entry for the child
-								dirb := make([]byte, de.filesystem.blocksize)
-								n, err2 := de.filesystem.backend.ReadAt(dirb, int64(location2)*de.filesystem.blocksize)
-								if err2 != nil {
-									return 0, 0, fmt.Errorf("could not read bytes of relocated directory %s from block %d: %v", checkFilename, location2, err2)
-								}
-								if n != len(dirb) {
-									return 0, 0, fmt.Errorf("read %d bytes instead of expected %d for relocated directory %s from block %d: %v", n, len(dirb), checkFilename, location2, err)
-								}
-								// get the size of the actual directory entry
-								size2 := dirb[0]
-								entry, err2 = parseDirEntry(dirb[:size2], de.filesystem)
-								if err2 != nil {
-									return 0, 0, fmt.Errorf("error converting bytes to a directory entry for relocated directory %s from block %d: %v", checkFilename, location2, err2)
-								}
-								break
-							}
-						}
-					}
-					location, size, err = entry.getLocation(path.Join(parts[1:]...))
-					if err != nil {
-						return 0, 0, fmt.Errorf("could not get location: %v", err)
-					}
-				} else {
-					// this is the final one, we found it, keep it
-					location = entry.location
-					size = entry.size
+				if n != len(dirb) {
+					return 0, 0, fmt.Errorf("read %d bytes instead of expected %d for relocated directory %s from block %d", n, len(dirb), current, location2)
+				}
+				// get the size of the actual directory entry
+				size2 := dirb[0]
+				entry, err2 = parseDirEntry(dirb[:size2], de.filesystem)
+				if err2 != nil {
+					return 0, 0, fmt.Errorf("error converting bytes to a directory entry for relocated directory %s from block %d: %w", current, location2, err2)
 				}
 				break
 			}
 		}
 	}
-
+	location, size, err = entry.getLocation(path.Join(parts[1:]...))
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not get location: %w", err)
+	}
 	return location, size, nil
 }
 
+// identifier returns the name of the entry that paths are resolved with, the one of an extension
+// if it has one, or else the file identifier as it is in the record
+func (de *directoryEntry) identifier() (string, error) {
+	// do we have an alternate name?
+	// only care if not self or parent entry
+	name := de.filename
+	if !de.filesystem.suspEnabled || de.isSelf || de.isParent {
+		return name, nil
+	}
+	for _, e := range de.filesystem.suspExtensions {
+		filename, err := e.GetFilename(de)
+		switch {
+		case err != nil && err == ErrSuspFilenameUnsupported:
+			continue
+		case err != nil:
+			return "", fmt.Errorf("extension %s count not find a filename property: %w", e.ID(), err)
+		default:
+			name = filename
+		}
+	}
+	return name, nil
+}
+
 // Name() string       // base name of the file
 func (de *directoryEntry) Name() string {
 	name := de.filename
@@ -476,6 +469,10 @@ func (de *directoryEntry) Name() string {
 
 // Size() int64        // length in bytes for regular files; system-dependent for others
 func (de *directoryEntry) Size() int64 {
+	// that of the file uncompressed, rather than of its extent, for files compressed with zisofs
+	if zf, ok := de.zisofs(); ok {
+		return int64(zf.size)
+	}
 	return int64(de.size)
 }
 
@@ -486,6 +483,9 @@ func (de *directoryEntry) Mode() os.FileMode {
 			return 0o755 | os.ModeSymlink
 		}
 	}
+	if de.isSubdirectory {
+		return 0o755 | os.ModeDir
+	}
 	return 0o755
 }
 
@@ -524,14 +524,14 @@ func parseDirectoryEntryExtensions(b []byte, handlers []suspExtension) ([]direct
 		if parser, ok := suspExtensionParser[signature]; ok {
 			entry, err = parser(suspBytes)
 			if err != nil {
-				return nil, fmt.Errorf("error parsing %s extension at byte position %d: %v", signature, i, err)
+				return nil, fmt.Errorf("error parsing %s extension at byte position %d: %w", signature, i, err)
 			}
 		} else {
 			// go through each extension we have and see if it can process
 			for _, ext := range handlers {
 				entry, err = ext.Process(signature, suspBytes)
 				if err != nil && err != ErrSuspNoHandler {
-					return nil, fmt.Errorf("SUSP Extension handler %s error processing extension %s: %v", ext.ID(), signature, err)
+					return nil, fmt.Errorf("SUSP Extension handler %s error processing extension %s: %w", ext.ID(), signature, err)
 				}
 				if err == nil {
 					break
@@ -1,6 +1,8 @@
 // Package iso9660 provides utilities to interact with, manipulate and create an iso9660 filesystem on a block device or
 // a disk image.
 //
+// Its reads are safe for concurrent use, see the concurrency of package filesystem.
+//
 // Reference documentation
 //
 //	ISO9660 https://wiki.osdev.org/ISO_9660
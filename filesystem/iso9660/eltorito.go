@@ -157,7 +157,7 @@ func (e *ElToritoEntry) generateBootTable(pvdSector uint32, p string) ([]byte, e
 	// Checksum - simply add up all 32-bit words beginning at byte position 64
 	f, err := os.Open(p)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open boot file for checksum reading %s: %v", p, err)
+		return nil, fmt.Errorf("failed to open boot file for checksum reading %s: %w", p, err)
 	}
 	defer f.Close()
 
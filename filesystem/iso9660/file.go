@@ -3,6 +3,7 @@ package iso9660
 import (
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"os"
 
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -17,6 +18,7 @@ type File struct {
 	isAppend    bool
 	offset      int64
 	closed      bool
+	zisofsCache zisofsCache
 }
 
 // Read reads up to len(b) bytes from the File.
@@ -28,12 +30,35 @@ func (fl *File) Read(b []byte) (int, error) {
 	if fl == nil || fl.closed {
 		return 0, os.ErrClosed
 	}
+	n, err := fl.readAt(b, fl.offset)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.ReaderAt. It returns io.EOF with fewer bytes when the file
+// ends, and is safe for concurrent use.
+func (fl *File) ReadAt(b []byte, off int64) (int, error) {
+	if fl == nil || fl.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("cannot read at offset %d before start of file", off)
+	}
+	return fl.readAt(b, off)
+}
+
+// readAt reads up to len(b) bytes from offset, returning io.EOF if it reaches the end of the file
+func (fl *File) readAt(b []byte, offset int64) (int, error) {
+	if zf, ok := fl.zisofs(); ok {
+		return fl.readZisofs(zf, b, offset)
+	}
 	// we have the DirectoryEntry, so we can get the starting location and size
 	// since iso9660 files are contiguous, we only need the starting location and size
 	//   to get the entire file
 	fs := fl.filesystem
-	size := int(fl.size) - int(fl.offset)
-	location := int(fl.location)
+	size := int64(fl.size) - offset
+	location := int64(fl.location)
 	maxRead := size
 	file := fs.backend
 
@@ -45,29 +70,42 @@ func (fl *File) Read(b []byte) (int, error) {
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
-	if len(b) < maxRead {
-		maxRead = len(b)
+	if int64(len(b)) < maxRead {
+		maxRead = int64(len(b))
 	}
 
-	// just read the requested number of bytes and change our offset
-	_, err := file.ReadAt(b[0:maxRead], int64(location)*fs.blocksize+fl.offset)
+	// just read the requested number of bytes
+	_, err := file.ReadAt(b[0:maxRead], location*fs.blocksize+offset)
 	if err != nil && err != io.EOF {
 		return 0, err
 	}
 
-	fl.offset += int64(maxRead)
 	var retErr error
-	if fl.offset >= int64(fl.size) {
+	if offset+maxRead >= int64(fl.size) {
 		retErr = io.EOF
 	}
-	return maxRead, retErr
+	return int(maxRead), retErr
 }
 
 // Write writes len(b) bytes to the File.
 //
 //	you cannot write to an iso, so this returns an error
 func (fl *File) Write(_ []byte) (int, error) {
-	return 0, filesystem.ErrReadonlyFilesystem
+	return 0, filesystem.ErrReadOnlyFilesystem
+}
+
+// WriteAt writes len(b) bytes to the File at offset off.
+//
+//	you cannot write to an iso, so this returns an error
+func (fl *File) WriteAt(_ []byte, _ int64) (int, error) {
+	return 0, filesystem.ErrReadOnlyFilesystem
+}
+
+// Truncate changes the size of the file.
+//
+//	you cannot write to an iso, so this returns an error
+func (fl *File) Truncate(_ int64) error {
+	return filesystem.ErrReadOnlyFilesystem
 }
 
 // Seek set the offset to a particular point in the file
@@ -80,9 +118,11 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekStart:
 		newOffset = offset
 	case io.SeekEnd:
-		newOffset = int64(fl.size) + offset
+		newOffset = fl.Size() + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
+	default:
+		return fl.offset, fmt.Errorf("invalid whence %d: %w", whence, iofs.ErrInvalid)
 	}
 	if newOffset < 0 {
 		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
@@ -95,6 +135,18 @@ func (fl *File) Location() uint32 {
 	return fl.location
 }
 
+// DataOffset returns the offset of the extent of the file in the filesystem, for
+// filesystem.LocatedFile, or -1 if it is empty or compressed with zisofs
+func (fl *File) DataOffset() int64 {
+	if fl.closed || fl.size == 0 {
+		return -1
+	}
+	if _, ok := fl.zisofs(); ok {
+		return -1
+	}
+	return int64(fl.location) * fl.filesystem.blocksize
+}
+
 // Close close the file
 func (fl *File) Close() error {
 	fl.closed = true
@@ -1,9 +1,12 @@
 package iso9660
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,6 +16,7 @@ import (
 	"time"
 
 	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/util"
 	"github.com/djherbis/times"
 )
@@ -33,6 +37,20 @@ type FinalizeOptions struct {
 	ElTorito *ElTorito
 	// VolumeIdentifier custom volume name, defaults to "ISOIMAGE"
 	VolumeIdentifier string
+	// Progress, if set, is updated in util.PhaseData after each file is written, with the bytes of
+	// all the files written so far, out of the size of all of them
+	Progress util.Progress
+	// Logger, if set, gets the time taken by each step of the finalization, see util.PhaseTimer
+	Logger *slog.Logger
+	// Zisofs compresses the regular files with zisofs, as mkisofs -z does with the files of
+	// mkzftree, marking them with ZF entries, so that Linux and others that read them decompress
+	// them as they read. Requires RockRidge. The files that would not be smaller by at least a
+	// block, and the boot images of ElTorito, are left as they are.
+	Zisofs bool
+	// Processors number of goroutines compressing blocks of files with Zisofs at the same time.
+	// Defaults to 0, i.e. the number of CPUs; 1 compresses them one after the other. The image is
+	// the same whatever the number.
+	Processors int
 }
 
 // finalizeFileInfo is a file info useful for finalization
@@ -86,6 +104,10 @@ type finalizeFileInfo struct {
 	// then this content is used, rather than anything on disk.
 	content []byte
 	serial  uint64
+	// zisofs is the ZF entry of a file compressed, whose data is at zisofsOffset of the compressed
+	// files rather than in the workspace
+	zisofs       *rockRidgeZisofs
+	zisofsOffset int64
 }
 
 func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFileInfo, error) {
@@ -97,7 +119,12 @@ func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFile
 		name = string([]byte{0x00})
 		shortname = name
 	}
-	t, err := times.Lstat(fullPath)
+	// the times of the source of a file added with AddFile, which the workspace links to
+	timesOf := times.Stat
+	if fi.Mode()&os.ModeSymlink != 0 {
+		timesOf = times.Lstat
+	}
+	t, err := timesOf(fullPath)
 	if err != nil {
 		return nil, fmt.Errorf("could not get times information for %s: %w", fullPath, err)
 	}
@@ -116,9 +143,9 @@ func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFile
 		name:       name,
 		isDir:      fi.IsDir(),
 		isRoot:     isRoot,
-		modTime:    fi.ModTime(),
-		accessTime: t.AccessTime(),
-		changeTime: t.ChangeTime(),
+		modTime:    util.ClampTime(fi.ModTime()),
+		accessTime: util.ClampTime(t.AccessTime()),
+		changeTime: util.ClampTime(t.ChangeTime()),
 		mode:       mode,
 		size:       fi.Size(),
 		shortname:  shortname,
@@ -213,11 +240,11 @@ func (fi *finalizeFileInfo) toDirectoryEntry(fsm *FileSystem, isSelf, isParent b
 			)
 			ext, err = e.GetFileExtensions(fi, isSelf, isParent)
 			if err != nil {
-				return nil, fmt.Errorf("error getting extensions for %s at path %s: %v", e.ID(), fi.path, err)
+				return nil, fmt.Errorf("error getting extensions for %s at path %s: %w", e.ID(), fi.path, err)
 			}
 			ext2, err := e.GetFinalizeExtensions(fi)
 			if err != nil {
-				return nil, fmt.Errorf("error getting finalize extensions for %s at path %s: %v", e.ID(), fi.path, err)
+				return nil, fmt.Errorf("error getting finalize extensions for %s at path %s: %w", e.ID(), fi.path, err)
 			}
 			ext = append(ext, ext2...)
 			de.extensions = append(de.extensions, ext...)
@@ -242,7 +269,7 @@ func (fi *finalizeFileInfo) toDirectory(fsm *FileSystem) (*Directory, error) {
 	}
 	self, err = fi.toDirectoryEntry(fsm, true, false)
 	if err != nil {
-		return nil, fmt.Errorf("could not convert self entry %s to dirEntry: %v", fi.path, err)
+		return nil, fmt.Errorf("could not convert self entry %s to dirEntry: %w", fi.path, err)
 	}
 
 	// if we have no parent, we are the root entry
@@ -253,14 +280,14 @@ func (fi *finalizeFileInfo) toDirectory(fsm *FileSystem) (*Directory, error) {
 	}
 	parent, err = parentEntry.toDirectoryEntry(fsm, false, true)
 	if err != nil {
-		return nil, fmt.Errorf("could not convert parent entry %s to dirEntry: %v", fi.parent.path, err)
+		return nil, fmt.Errorf("could not convert parent entry %s to dirEntry: %w", fi.parent.path, err)
 	}
 
 	entries := []*directoryEntry{self, parent}
 	for _, child := range fi.children {
 		dirEntry, err = child.toDirectoryEntry(fsm, false, false)
 		if err != nil {
-			return nil, fmt.Errorf("could not convert child entry %s to dirEntry: %v", child.path, err)
+			return nil, fmt.Errorf("could not convert child entry %s to dirEntry: %w", child.path, err)
 		}
 		entries = append(entries, dirEntry)
 	}
@@ -277,11 +304,11 @@ func (fi *finalizeFileInfo) calculateRecordSize(fsm *FileSystem, isSelf, isParen
 	extTmpBlocks := make([]uint32, 100)
 	dirEntry, err := fi.toDirectoryEntry(fsm, isSelf, isParent)
 	if err != nil {
-		return 0, 0, fmt.Errorf("could not convert to dirEntry: %v", err)
+		return 0, 0, fmt.Errorf("could not convert to dirEntry: %w", err)
 	}
 	dirBytes, err := dirEntry.toBytes(false, extTmpBlocks)
 	if err != nil {
-		return 0, 0, fmt.Errorf("could not convert dirEntry to bytes: %v", err)
+		return 0, 0, fmt.Errorf("could not convert dirEntry to bytes: %w", err)
 	}
 	// first entry is the bytes to store in the directory
 	// rest are continuation blocks
@@ -298,14 +325,14 @@ func (fi *finalizeFileInfo) calculateDirectorySize(fsm *FileSystem) (dirEntrySiz
 	}
 	recSize, recCE, err = fi.calculateRecordSize(fsm, true, false)
 	if err != nil {
-		return 0, 0, fmt.Errorf("could not calculate self entry size %s: %v", fi.path, err)
+		return 0, 0, fmt.Errorf("could not calculate self entry size %s: %w", fi.path, err)
 	}
 	dirEntrySize += recSize
 	continuationBlocksSize += recCE
 
 	recSize, recCE, err = fi.calculateRecordSize(fsm, false, true)
 	if err != nil {
-		return 0, 0, fmt.Errorf("could not calculate parent entry size %s: %v", fi.path, err)
+		return 0, 0, fmt.Errorf("could not calculate parent entry size %s: %w", fi.path, err)
 	}
 	dirEntrySize += recSize
 	continuationBlocksSize += recCE
@@ -314,7 +341,7 @@ func (fi *finalizeFileInfo) calculateDirectorySize(fsm *FileSystem) (dirEntrySiz
 		// get size of data and CE blocks
 		recSize, recCE, err = e.calculateRecordSize(fsm, false, false)
 		if err != nil {
-			return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %v", e.path, fi.path, err)
+			return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %w", e.path, fi.path, err)
 		}
 		// do not go over a block boundary; pad if necessary
 		newSize := dirEntrySize + recSize
@@ -393,7 +420,7 @@ func (fi *finalizeFileInfo) findEntry(p string) (*finalizeFileInfo, error) {
 				if len(parts) > 1 {
 					target, err = e.findEntry(path.Join(parts[1:]...))
 					if err != nil {
-						return nil, fmt.Errorf("could not get entry: %v", err)
+						return nil, fmt.Errorf("could not get entry: %w", err)
 					}
 				} else {
 					// this is the final one, we found it, keep it
@@ -422,14 +449,34 @@ func (fi *finalizeFileInfo) addChild(entry *finalizeFileInfo) {
 	fi.children = append(fi.children, entry)
 }
 
-// Finalize finalize a read-only filesystem by writing it out to a read-only format
-//
-//nolint:gocyclo // this finalize function is complex and needs to be. We might be better off refactoring it to multiple functions, but it does not buy all that much.
+// Finalize finalize a read-only filesystem by writing it out to a read-only format. It fails with
+// an error that is filesystem.ErrNoSpace, before writing the files, if they do not fit in the size
+// given to Create.
 func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
+	return fsm.FinalizeContext(context.Background(), options)
+}
+
+// FinalizeContext finalizes the filesystem like Finalize, stopping with the error of ctx when it
+// is done, which leaves the image incomplete.
+func (fsm *FileSystem) FinalizeContext(ctx context.Context, options FinalizeOptions) error {
+	timer := util.NewPhaseTimer(options.Logger, "iso9660 finalize")
+	err := fsm.finalize(ctx, options, timer)
+	timer.Done(err)
+	return err
+}
+
+//nolint:gocyclo // this finalize function is complex and needs to be. We might be better off refactoring it to multiple functions, but it does not buy all that much.
+func (fsm *FileSystem) finalize(ctx context.Context, options FinalizeOptions, timer *util.PhaseTimer) error {
 	if fsm.workspace == "" {
-		return fmt.Errorf("cannot finalize an already finalized filesystem")
+		return fmt.Errorf("cannot finalize an already finalized filesystem: %w", filesystem.ErrReadOnlyFilesystem)
+	}
+	if _, err := fsm.backend.Writable(); errors.Is(err, backend.ErrIncorrectOpenMode) {
+		return fmt.Errorf("%w: %w", filesystem.ErrReadOnlyFilesystem, err)
 	}
 
+	if options.Zisofs && !options.RockRidge {
+		return fmt.Errorf("zisofs needs Rock Ridge extensions: %w", filesystem.ErrNotSupported)
+	}
 	// did we ask for susp?
 	if options.RockRidge {
 		fsm.suspEnabled = true
@@ -464,10 +511,11 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		 10- write volume descriptor set terminator
 	*/
 
-	f, err := fsm.backend.Writable()
+	w, err := fsm.backend.Writable()
 	if err != nil {
 		return err
 	}
+	f := filesystem.Section(w, fsm.start, fsm.size)
 
 	blocksize := int(fsm.blocksize)
 
@@ -475,16 +523,17 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	b := make([]byte, dataStartSector*fsm.blocksize)
 	n, err := f.WriteAt(b, 0)
 	if err != nil {
-		return fmt.Errorf("could not write blank system area: %v", err)
+		return fmt.Errorf("could not write blank system area: %w", err)
 	}
 	if n != len(b) {
 		return fmt.Errorf("only wrote %d bytes instead of expected %d to system area", n, len(b))
 	}
 
 	// 3- build out file tree
-	fileList, dirList, err := walkTree(fsm.Workspace())
+	timer.Phase("walk")
+	fileList, dirList, err := fsm.walkTree()
 	if err != nil {
-		return fmt.Errorf("error walking tree: %v", err)
+		return fmt.Errorf("error walking tree: %w", err)
 	}
 
 	// starting point
@@ -505,7 +554,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			var relocateFiles []*finalizeFileInfo
 			relocateFiles, dirList, err = handler.Relocate(dirList)
 			if err != nil {
-				return fmt.Errorf("unable to use extension %s to relocate directories from depth > 8: %v", handler.ID(), err)
+				return fmt.Errorf("unable to use extension %s to relocate directories from depth > 8: %w", handler.ID(), err)
 			}
 			fileList = append(fileList, relocateFiles...)
 		}
@@ -518,6 +567,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	// convert sizes to required blocks for files
+	timer.Phase("layout")
 	for _, e := range fileList {
 		e.blocks = calculateBlocks(e.size, fsm.blocksize)
 	}
@@ -555,7 +605,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		shortname, extension := calculateShortnameExtension(path.Base(catname))
 		// break down the catalog basename from the parent dir
 		catSize := int64(len(bootcat))
-		now := time.Now()
+		now := util.Now()
 		catEntry = &finalizeFileInfo{
 			content:    bootcat,
 			size:       catSize,
@@ -576,7 +626,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			var parent *finalizeFileInfo
 			parent, err = root.findEntry(path.Dir(catname))
 			if err != nil {
-				return fmt.Errorf("error finding parent for boot catalog %s: %v", catname, err)
+				return fmt.Errorf("error finding parent for boot catalog %s: %w", catname, err)
 			}
 			parent.addChild(catEntry)
 		}
@@ -584,7 +634,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			var parent, child *finalizeFileInfo
 			parent, err = root.findEntry(path.Dir(e.BootFile))
 			if err != nil {
-				return fmt.Errorf("error finding parent for boot image file %s: %v", e.BootFile, err)
+				return fmt.Errorf("error finding parent for boot image file %s: %w", e.BootFile, err)
 			}
 			// did we ask to hide any image files?
 			if e.HideBootFile {
@@ -592,11 +642,11 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			} else {
 				child, err = parent.findEntry(path.Base(e.BootFile))
 				if err != nil {
-					return fmt.Errorf("unable to find image child %s: %v", e.BootFile, err)
+					return fmt.Errorf("unable to find image child %s: %w", e.BootFile, err)
 				}
 			}
 			if child == nil {
-				return fmt.Errorf("unable to find image child %s: %v", e.BootFile, err)
+				return fmt.Errorf("unable to find image child %s: %w", e.BootFile, err)
 			}
 			// save the child so we can add location late
 			e.size = uint32(child.size)
@@ -604,12 +654,30 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		}
 	}
 
+	// compress the files before the sizes of the directories, whose entries have their ZF
+	var compressed *os.File
+	if options.Zisofs {
+		timer.Phase("compress")
+		compressed, err = os.CreateTemp(fsm.workspace, ".zisofs")
+		if err != nil {
+			return fmt.Errorf("could not create file for compressed data: %w", err)
+		}
+		defer func() {
+			compressed.Close()
+			os.Remove(compressed.Name())
+		}()
+		if err := fsm.compressFiles(ctx, files, options.Processors, compressed); err != nil {
+			return fmt.Errorf("error compressing files: %w", err)
+		}
+		timer.Phase("layout")
+	}
+
 	var size, ceBlocks int
 	for _, dir := range dirs {
 		dir.location = location
 		size, ceBlocks, err = dir.calculateDirectorySize(fsm)
 		if err != nil {
-			return fmt.Errorf("unable to calculate size of directory for %s: %v", dir.path, err)
+			return fmt.Errorf("unable to calculate size of directory for %s: %w", dir.path, err)
 		}
 		dir.size = int64(size)
 		dir.blocks = calculateBlocks(int64(size), int64(blocksize))
@@ -651,6 +719,11 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		}
 	}
 
+	// with all of the locations, the size is known before writing anything more
+	if fsm.size > 0 && int64(location)*int64(blocksize) > fsm.size {
+		return fmt.Errorf("filesystem needs %d blocks of %d bytes, more than its size of %d bytes: %w", location, blocksize, fsm.size, filesystem.ErrNoSpace)
+	}
+
 	// now that we have all of the files with their locations, we can rebuild the boot catalog using the correct data
 	if catEntry != nil {
 		bootcat = options.ElTorito.generateCatalog()
@@ -658,12 +731,13 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	// now we can write each one out - dirs first then files
+	timer.Phase("directories")
 	for _, e := range dirs {
 		writeAt := int64(e.location) * int64(blocksize)
 		var d *Directory
 		d, err = e.toDirectory(fsm)
 		if err != nil {
-			return fmt.Errorf("unable to convert entry to directory: %v", err)
+			return fmt.Errorf("unable to convert entry to directory: %w", err)
 		}
 		// Directory.toBytes() always returns whole blocks
 		// get the continuation entry locations
@@ -675,7 +749,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		var p [][]byte
 		p, err = d.entriesToBytes(ceLocations)
 		if err != nil {
-			return fmt.Errorf("could not convert directory to bytes: %v", err)
+			return fmt.Errorf("could not convert directory to bytes: %w", err)
 		}
 		for i, e := range p {
 			_, _ = f.WriteAt(e, writeAt+int64(i*blocksize))
@@ -694,6 +768,11 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			f.Close()
 		}
 	}()
+	timer.Phase("data")
+	var done, total int64
+	for _, e := range files {
+		total += e.Size()
+	}
 	for _, e := range files {
 		var (
 			from             *os.File
@@ -701,11 +780,20 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			bootTableMinSize int
 		)
 		writeAt := int64(e.location) * int64(blocksize)
-		if e.content == nil {
+		switch {
+		case e.zisofs != nil:
+			copied, err = copyFileData(ctx, compressed, f, e.zisofsOffset, writeAt, int(e.size))
+			if err != nil {
+				return fmt.Errorf("failed to copy compressed file to disk %s: %w", e.path, err)
+			}
+			if copied != int(e.size) {
+				return fmt.Errorf("error copying compressed file %s to disk, copied %d bytes, expected %d", e.path, copied, e.size)
+			}
+		case e.content == nil:
 			// for file, just copy the data across
 			from, err = os.Open(path.Join(fsm.workspace, e.path))
 			if err != nil {
-				return fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
+				return fmt.Errorf("failed to open file for reading %s: %w", e.path, err)
 			}
 			closeFiles = append(closeFiles, from)
 			if e.elToritoEntry != nil && e.elToritoEntry.BootTable {
@@ -713,33 +801,33 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 				var count int
 
 				// first 8 bytes
-				count, err = copyFileData(from, f, 0, writeAt, elToritoBootTableOffset)
+				count, err = copyFileData(ctx, from, f, 0, writeAt, elToritoBootTableOffset)
 				if err != nil {
-					return fmt.Errorf("failed to copy first bytes 0-8 of boot file to disk %s: %v", e.path, err)
+					return fmt.Errorf("failed to copy first bytes 0-8 of boot file to disk %s: %w", e.path, err)
 				}
 				copied += count
 				// insert El Torito Boot Information Table
 				bootTable, err := e.elToritoEntry.generateBootTable(dataStartSector, path.Join(fsm.workspace, e.path))
 				if err != nil {
-					return fmt.Errorf("failed to generate boot table for %s: %v", e.path, err)
+					return fmt.Errorf("failed to generate boot table for %s: %w", e.path, err)
 				}
 				count, err = f.WriteAt(bootTable, writeAt+elToritoBootTableOffset)
 				if err != nil {
-					return fmt.Errorf("failed to write 56 byte boot table to disk %s: %v", e.path, err)
+					return fmt.Errorf("failed to write 56 byte boot table to disk %s: %w", e.path, err)
 				}
 				copied += count
 				// file with boot table file must be a minimum of boot table size and the offset
 				bootTableMinSize = count
 				// remainder of file
-				count, err = copyFileData(from, f, 64, writeAt+64, 0)
+				count, err = copyFileData(ctx, from, f, 64, writeAt+64, 0)
 				if err != nil {
-					return fmt.Errorf("failed to copy bytes 64 to end of boot file to disk %s: %v", e.path, err)
+					return fmt.Errorf("failed to copy bytes 64 to end of boot file to disk %s: %w", e.path, err)
 				}
 				copied += count
 			} else {
-				copied, err = copyFileData(from, f, 0, writeAt, 0)
+				copied, err = copyFileData(ctx, from, f, 0, writeAt, 0)
 				if err != nil {
-					return fmt.Errorf("failed to copy file to disk %s: %v", e.path, err)
+					return fmt.Errorf("failed to copy file to disk %s: %w", e.path, err)
 				}
 			}
 			targetSize := e.Size()
@@ -749,10 +837,10 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			if copied != int(targetSize) {
 				return fmt.Errorf("error copying file %s to disk, copied %d bytes, expected %d", e.path, copied, targetSize)
 			}
-		} else {
+		default:
 			copied = len(e.content)
 			if _, err = f.WriteAt(e.content, writeAt); err != nil {
-				return fmt.Errorf("failed to write content of %s to disk: %v", e.path, err)
+				return fmt.Errorf("failed to write content of %s to disk: %w", e.path, err)
 			}
 		}
 		// fill in
@@ -761,15 +849,20 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			b2 := make([]byte, left)
 			_, _ = f.WriteAt(b2, writeAt+int64(copied))
 		}
+		if options.Progress != nil {
+			done += e.Size()
+			options.Progress.Update(util.PhaseData, done, total, e.path)
+		}
 	}
 
+	timer.Phase("descriptors")
 	totalSize := location
 	location = dataStartSector
 	// create and write the primary volume descriptor, supplementary and boot, and volume descriptor set terminator
-	now := time.Now()
+	now := util.Now()
 	rootDE, err := root.toDirectoryEntry(fsm, true, false)
 	if err != nil {
-		return fmt.Errorf("could not convert root entry for primary volume descriptor to dirEntry: %v", err)
+		return fmt.Errorf("could not convert root entry for primary volume descriptor to dirEntry: %w", err)
 	}
 
 	pvd := &primaryVolumeDescriptor{
@@ -812,6 +905,10 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	b = terminator.toBytes()
 	_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
 
+	if err := backend.Sync(fsm.backend); err != nil {
+		return fmt.Errorf("error syncing image: %w", err)
+	}
+
 	_ = os.RemoveAll(fsm.workspace)
 
 	// finish by setting as finalized
@@ -819,12 +916,18 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	return nil
 }
 
+// copyBufferSize is the size of the chunks of file data copied into the image
+const copyBufferSize = 1024 * 1024
+
 // copyFileData copy data from file `from` at offset `fromOffset` to file `to` at offset `toOffset`.
 // Copies `size` bytes. If `size` is 0, copies as many bytes as it can.
-func copyFileData(from backend.File, to backend.WritableFile, fromOffset, toOffset int64, size int) (int, error) {
-	buf := make([]byte, 2048)
+func copyFileData(ctx context.Context, from backend.File, to backend.WritableFile, fromOffset, toOffset int64, size int) (int, error) {
+	buf := make([]byte, copyBufferSize)
 	copied := 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
 		n, err := from.ReadAt(buf, fromOffset+int64(copied))
 		if err != nil && err != io.EOF {
 			return copied, err
@@ -912,7 +1015,10 @@ func createPathTable(fi []*finalizeFileInfo) *pathTable {
 	}
 }
 
-func walkTree(workspace string) ([]*finalizeFileInfo, map[string]*finalizeFileInfo, error) {
+// walkTree lists the files and directories of the workspace, with the sources of the files added
+// with AddFile
+func (fsm *FileSystem) walkTree() ([]*finalizeFileInfo, map[string]*finalizeFileInfo, error) {
+	workspace := fsm.workspace
 	var (
 		dirList  = make(map[string]*finalizeFileInfo)
 		fileList = make([]*finalizeFileInfo, 0)
@@ -921,7 +1027,7 @@ func walkTree(workspace string) ([]*finalizeFileInfo, map[string]*finalizeFileIn
 	)
 	err := filepath.WalkDir(workspace, func(actualPath string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("error walking path %s: %v", actualPath, err)
+			return fmt.Errorf("error walking path %s: %w", actualPath, err)
 		}
 		fp := strings.TrimPrefix(actualPath, workspace)
 		fp = strings.TrimPrefix(fp, string(filepath.Separator))
@@ -932,8 +1038,11 @@ func walkTree(workspace string) ([]*finalizeFileInfo, map[string]*finalizeFileIn
 		_, extension := calculateShortnameExtension(name)
 
 		fi, err := d.Info()
+		if err == nil {
+			fi, err = fsm.stagedInfo(fsm.workspacePath(actualPath), actualPath, fi)
+		}
 		if err != nil {
-			return fmt.Errorf("could not get file info for %s: %v", fp, err)
+			return fmt.Errorf("could not get file info for %s: %w", fp, err)
 		}
 		entry, err = finalizeFileInfoFromFile(fp, actualPath, fi)
 		if err != nil {
@@ -2,6 +2,7 @@ package iso9660
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -38,7 +39,7 @@ func TestCopyFileData(t *testing.T) {
 	}
 	defer os.Remove(from.Name()) // clean up
 
-	copied, err := copyFileData(from, to, 0, 0, 0)
+	copied, err := copyFileData(context.Background(), from, to, 0, 0, 0)
 	if err != nil {
 		t.Fatal("error copying data from/to", err)
 	}
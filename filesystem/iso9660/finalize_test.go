@@ -2,19 +2,25 @@ package iso9660_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/testhelper"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 var (
@@ -565,3 +571,277 @@ func validateElTorito(t *testing.T, f *os.File) {
 	}
 	// what sector should it be in?
 }
+
+func TestFinalizeReadOnly(t *testing.T) {
+	fs, err := iso9660.Create(mem.New(make([]byte, 5*1024*1024), true), 0, 0, 2048, t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{}); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+		t.Errorf("error %v instead of read-only filesystem", err)
+	}
+}
+
+func TestFinalizeCanceled(t *testing.T) {
+	fs, err := iso9660.Create(mem.New(make([]byte, 5*1024*1024), false), 0, 0, 2048, t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/LARGE", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 1024*1024)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fs.FinalizeContext(ctx, iso9660.FinalizeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of %v", err, context.Canceled)
+	}
+}
+
+func TestFinalizeProgress(t *testing.T) {
+	fs, err := iso9660.Create(mem.New(make([]byte, 5*1024*1024), false), 0, 0, 2048, t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, name := range []string{"/LARGE1", "/LARGE2"} {
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write(make([]byte, 100000)); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	var updates []int64
+	progress := func(phase string, done, total int64, _ string) {
+		if phase != util.PhaseData || total != 200000 {
+			t.Errorf("progress %s %d/%d", phase, done, total)
+		}
+		updates = append(updates, done)
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{Progress: util.ProgressFunc(progress)}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	if len(updates) != 2 || updates[0] != 100000 || updates[1] != 200000 {
+		t.Errorf("progress reported %v", updates)
+	}
+}
+
+func TestFinalizeAddFile(t *testing.T) {
+	dir := t.TempDir()
+	sources := map[string][]byte{}
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		data := make([]byte, 10000)
+		_, _ = rand.Read(data)
+		sources[name] = data
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := mem.New(make([]byte, 5*1024*1024), false)
+	fs, err := iso9660.Create(m, 0, 0, 2048, t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/sub"); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	for name, p := range map[string]string{"a.bin": "/A.BIN", "b.bin": "/sub/B.BIN", "c.bin": "/C.BIN"} {
+		if err := fs.AddFile(p, filepath.Join(dir, name)); err != nil {
+			t.Fatalf("error adding %s: %v", name, err)
+		}
+	}
+	// added files are regular files of their size in the workspace
+	info, err := fs.Stat("sub/B.BIN")
+	if err != nil || !info.Mode().IsRegular() || info.Size() != 10000 {
+		t.Fatalf("stat %v, %v", info, err)
+	}
+	if err := fs.Rename("/sub", "/moved"); err != nil {
+		t.Fatalf("error renaming directory: %v", err)
+	}
+	// writing to an added file leaves its source as it is
+	f, err := fs.OpenFile("/C.BIN", os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("changed"), 0); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	f.Close()
+	if b, _ := os.ReadFile(filepath.Join(dir, "c.bin")); !bytes.Equal(b, sources["c.bin"]) {
+		t.Errorf("source of written file changed")
+	}
+	expected := map[string][]byte{
+		"A.BIN":       sources["a.bin"],
+		"MOVED/B.BIN": sources["b.bin"],
+		"C.BIN":       append([]byte("changed"), sources["c.bin"][7:]...),
+	}
+
+	if err := fs.Finalize(iso9660.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	fs, err = iso9660.Read(m, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for name, data := range expected {
+		if b, err := fs.ReadFile(name); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("read %s: %d bytes, %v", name, len(b), err)
+		}
+	}
+}
+
+func TestFinalizeLargeDirectory(t *testing.T) {
+	const count = 2000
+	for _, rockRidge := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rockridge %v", rockRidge), func(t *testing.T) {
+			m := mem.New(make([]byte, 20*1024*1024), false)
+			fs, err := iso9660.Create(m, 0, 0, 2048, t.TempDir())
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			if err := fs.Mkdir("/DIR/SUB"); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+			// with Rock Ridge, names whose order differs from that of their identifiers
+			name := func(i int) string {
+				if rockRidge && i%2 == 1 {
+					return fmt.Sprintf("/DIR/SUB/f%d.txt", count-i)
+				}
+				return fmt.Sprintf("/DIR/SUB/F%d.TXT", count-i)
+			}
+			for i := 0; i < count; i++ {
+				f, err := fs.OpenFile(name(i), os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("error creating file: %v", err)
+				}
+				if _, err := f.Write([]byte(name(i))); err != nil {
+					t.Fatalf("error writing file: %v", err)
+				}
+				f.Close()
+			}
+			if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: rockRidge}); err != nil {
+				t.Fatalf("error finalizing filesystem: %v", err)
+			}
+			fs, err = iso9660.Read(m, 0, 0, 2048)
+			if err != nil {
+				t.Fatalf("error reading filesystem: %v", err)
+			}
+			for i := 0; i < count; i++ {
+				if b, err := fs.ReadFile(name(i)[1:]); err != nil || string(b) != name(i) {
+					t.Fatalf("read %s: %q, %v", name(i), b, err)
+				}
+			}
+			if _, err := fs.OpenFile("/DIR/SUB/F0.TXT", os.O_RDONLY); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("opening a file that does not exist returned %v instead of not exist", err)
+			}
+			if _, err := fs.OpenFile("/DIR/SUB", os.O_RDONLY); !errors.Is(err, filesystem.ErrIsDir) {
+				t.Errorf("opening a directory returned %v instead of is a directory", err)
+			}
+			entries, err := fs.ReadDir("/DIR/SUB")
+			if err != nil || len(entries) != count {
+				t.Errorf("read %d entries of directory, %v", len(entries), err)
+			}
+		})
+	}
+}
+
+func TestFinalizeZisofs(t *testing.T) {
+	random := make([]byte, 100*1024)
+	_, _ = rand.Read(random)
+	files := map[string][]byte{
+		"/TEXT.TXT":       []byte(strings.Repeat("some text compressed with zisofs\n", 10000)),
+		"/ZEROS.BIN":      make([]byte, 200*1024+5),
+		"/RANDOM.BIN":     random,
+		"/SMALL.TXT":      []byte("small"),
+		"/EMPTY":          {},
+		"/SUB/MIXED.BIN":  append(append(bytes.Repeat([]byte("mixed"), 20000), make([]byte, 100*1024)...), random[:5000]...),
+		"/SUB/MORE/A.TXT": bytes.Repeat([]byte("a"), 40*1024),
+	}
+	build := func(options iso9660.FinalizeOptions) []byte {
+		t.Helper()
+		m := mem.New(make([]byte, 5*1024*1024), false)
+		fs, err := iso9660.Create(m, 0, 0, 2048, t.TempDir())
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fs.Mkdir("/SUB/MORE"); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		for p, data := range files {
+			f, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating %s: %v", p, err)
+			}
+			if _, err := f.Write(data); err != nil {
+				t.Fatalf("error writing %s: %v", p, err)
+			}
+			f.Close()
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		fs, err = iso9660.Read(m, 0, 0, 2048)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		total, _, _ := fs.Space()
+		return m.Bytes()[:total]
+	}
+
+	plain := build(iso9660.FinalizeOptions{RockRidge: true})
+	image := build(iso9660.FinalizeOptions{RockRidge: true, Zisofs: true, Processors: 4})
+	if len(image) >= len(plain) {
+		t.Errorf("image of %d bytes with zisofs, %d without", len(image), len(plain))
+	}
+
+	// the files read are those written, whether compressed or not
+	fs, err := iso9660.Read(mem.New(image, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for p, data := range files {
+		if b, err := fs.ReadFile(p[1:]); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("read %d bytes of %s instead of %d, %v", len(b), p, len(data), err)
+		}
+		if info, err := fs.Stat(p[1:]); err != nil || info.Size() != int64(len(data)) {
+			t.Errorf("stat %s %v, %v", p, info, err)
+		}
+	}
+	f, err := fs.OpenFile("/SUB/MIXED.BIN", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	b := make([]byte, 10)
+	if _, err := f.(io.ReaderAt).ReadAt(b, 99995); err != nil || string(b) != "mixed\x00\x00\x00\x00\x00" {
+		t.Errorf("read %q across the blocks of a compressed file, %v", b, err)
+	}
+	if offset, err := f.Seek(0, io.SeekEnd); err != nil || offset != int64(len(files["/SUB/MIXED.BIN"])) {
+		t.Errorf("end of compressed file at %d, %v", offset, err)
+	}
+
+	if err := (&iso9660.FileSystem{}).Finalize(iso9660.FinalizeOptions{Zisofs: true}); err == nil {
+		t.Errorf("no error compressing with zisofs without Rock Ridge")
+	}
+
+	// others decompress the files as they read them
+	if _, err := exec.LookPath("bsdtar"); err != nil {
+		t.Skipf("no bsdtar to extract the image: %v", err)
+	}
+	dir := t.TempDir()
+	isoFile := filepath.Join(dir, "zisofs.iso")
+	if err := os.WriteFile(isoFile, image, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("bsdtar", "-x", "-f", isoFile, "-C", dir).CombinedOutput(); err != nil {
+		t.Fatalf("error extracting image: %v: %s", err, out)
+	}
+	for p, data := range files {
+		if b, err := os.ReadFile(filepath.Join(dir, p)); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("extracted %d bytes of %s instead of %d, %v", len(b), p, len(data), err)
+		}
+	}
+}
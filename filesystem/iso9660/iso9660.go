@@ -2,10 +2,13 @@ package iso9660
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -32,6 +35,10 @@ type FileSystem struct {
 	suspEnabled    bool  // is the SUSP in use?
 	suspSkip       uint8 // how many bytes to skip in each directory record
 	suspExtensions []suspExtension
+	// staged are the paths of the files added with AddFile, linked to their source in the workspace
+	staged map[string]bool
+	// dirs are the directories of the image read so far
+	dirs *dirCache
 }
 
 // Equal compare if two filesystems are equal
@@ -82,7 +89,7 @@ func Create(b backend.Storage, size, start, blocksize int64, workspace string) (
 	if workspace != "" {
 		info, err := os.Stat(workspace)
 		if err != nil {
-			return nil, fmt.Errorf("could not stat working directory: %v", err)
+			return nil, fmt.Errorf("could not stat working directory: %w", err)
 		}
 		if !info.IsDir() {
 			return nil, fmt.Errorf("provided workspace is not a directory: %s", workspace)
@@ -94,7 +101,7 @@ func Create(b backend.Storage, size, start, blocksize int64, workspace string) (
 		var err error
 		workdir, err = os.MkdirTemp("", "diskfs_iso")
 		if err != nil {
-			return nil, fmt.Errorf("could not create working directory: %v", err)
+			return nil, fmt.Errorf("could not create working directory: %w", err)
 		}
 	}
 
@@ -147,12 +154,15 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		return nil, fmt.Errorf("requested size is too small to allow for system area (%d), one volume descriptor (%d), one volume descriptor set terminator (%d), and one block (%d)", systemAreaSize, volumeDescriptorSize, volumeDescriptorSize, blocksize)
 	}
 
-	// load the information from the disk
+	// load the information from the disk, whose locations are from the start of the filesystem
+	if start != 0 {
+		b = filesystem.SectionStorage(b, start, size)
+	}
 	// read system area
 	systemArea := make([]byte, systemAreaSize)
-	n, err := b.ReadAt(systemArea, start)
+	n, err := b.ReadAt(systemArea, 0)
 	if err != nil {
-		return nil, fmt.Errorf("could not read bytes from file: %v", err)
+		return nil, fmt.Errorf("could not read bytes from file: %w", err)
 	}
 	if uint16(n) < uint16(systemAreaSize) {
 		return nil, fmt.Errorf("only could read %d bytes from file", n)
@@ -169,9 +179,9 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	for i := 0; !terminated; i++ {
 		vdBytes := make([]byte, volumeDescriptorSize)
 		// read vdBytes
-		read, err = b.ReadAt(vdBytes, start+systemAreaSize+int64(i)*volumeDescriptorSize)
+		read, err = b.ReadAt(vdBytes, systemAreaSize+int64(i)*volumeDescriptorSize)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read bytes for volume descriptor %d: %v", i, err)
+			return nil, fmt.Errorf("unable to read bytes for volume descriptor %d: %w", i, err)
 		}
 		if int64(read) != volumeDescriptorSize {
 			return nil, fmt.Errorf("read %d bytes instead of expected %d for volume descriptor %d", read, volumeDescriptorSize, i)
@@ -179,7 +189,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		// convert to a vd structure
 		vd, err = volumeDescriptorFromBytes(vdBytes)
 		if err != nil {
-			return nil, fmt.Errorf("error reading Volume Descriptor: %v", err)
+			return nil, fmt.Errorf("error reading Volume Descriptor: %w", err)
 		}
 		// is this a terminator?
 		//nolint:exhaustive // we only are looking for the terminators; all of the rest are covered by default
@@ -205,7 +215,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		pathTableLocation := pvd.pathTableLLocation * uint32(pvd.blocksize)
 		read, err = b.ReadAt(pathTableBytes, int64(pathTableLocation))
 		if err != nil {
-			return nil, fmt.Errorf("unable to read path table of size %d at location %d: %v", pvd.pathTableSize, pathTableLocation, err)
+			return nil, fmt.Errorf("unable to read path table of size %d at location %d: %w", pvd.pathTableSize, pathTableLocation, err)
 		}
 		if read != len(pathTableBytes) {
 			return nil, fmt.Errorf("read %d bytes of path table instead of expected %d at location %d", read, pvd.pathTableSize, pathTableLocation)
@@ -219,7 +229,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	dirEntBytes := make([]byte, 1)
 	read, err = b.ReadAt(dirEntBytes, location)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read root directory size at location %d: %v", location, err)
+		return nil, fmt.Errorf("unable to read root directory size at location %d: %w", location, err)
 	}
 	if read != len(dirEntBytes) {
 		return nil, fmt.Errorf("root directory entry size, read %d bytes instead of expected %d", read, len(dirEntBytes))
@@ -231,7 +241,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	dirEntBytes = make([]byte, dirEntBytes[0])
 	read, err = b.ReadAt(dirEntBytes, location)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read root directory entry at location %d: %v", location, err)
+		return nil, fmt.Errorf("unable to read root directory entry at location %d: %w", location, err)
 	}
 	if read != len(dirEntBytes) {
 		return nil, fmt.Errorf("root directory entry, read %d bytes instead of expected %d", read, len(dirEntBytes))
@@ -243,7 +253,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		blocksize:   blocksize,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error parsing root entry from bytes: %v", err)
+		return nil, fmt.Errorf("error parsing root entry from bytes: %w", err)
 	}
 	// is the SUSP in use?
 	var (
@@ -281,6 +291,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		suspEnabled:    suspEnabled,
 		suspSkip:       skipBytes,
 		suspExtensions: suspHandlers,
+		dirs:           newDirCache(),
 	}
 	rootDirEntry.filesystem = fs
 	return fs, nil
@@ -297,6 +308,18 @@ func (fsm *FileSystem) Close() error {
 	return nil
 }
 
+// Sync syncs the backend. The filesystem is only written to the backend by Finalize, which syncs
+// it, so there is nothing to sync while it is being built in the workspace.
+func (fsm *FileSystem) Sync() error {
+	if fsm.workspace != "" {
+		return nil
+	}
+	if err := backend.Sync(fsm.backend); err != nil {
+		return fmt.Errorf("error syncing filesystem: %w", err)
+	}
+	return nil
+}
+
 // Type returns the type code for the filesystem. Always returns filesystem.TypeFat32
 func (fsm *FileSystem) Type() filesystem.Type {
 	return filesystem.TypeISO9660
@@ -310,11 +333,11 @@ func (fsm *FileSystem) Type() filesystem.Type {
 // if readonly and not in workspace, will return an error
 func (fsm *FileSystem) Mkdir(p string) error {
 	if fsm.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
 	}
 	err := os.MkdirAll(path.Join(fsm.workspace, p), 0o755)
 	if err != nil {
-		return fmt.Errorf("could not create directory %s: %v", p, err)
+		return fmt.Errorf("could not create directory %s: %w", p, filesystem.OSError(err))
 	}
 	// we are not interesting in returning the entries
 	return err
@@ -347,29 +370,51 @@ func (fsm *FileSystem) Symlink(oldpath, newpath string) error {
 // Chmod changes the mode of the named file to mode. If the file is a symbolic link,
 // it changes the mode of the link's target.
 //
-//nolint:revive // parameters will be used eventually
+// The mode is changed in the workspace, and kept by Finalize with Rock Ridge.
 func (fsm *FileSystem) Chmod(name string, mode os.FileMode) error {
-	// Rock Ridge has UNIX-style file modes support
-	// https://en.wikipedia.org/wiki/ISO_9660#Rock_Ridge
-	return filesystem.ErrNotImplemented
+	if fsm.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	if err := fsm.unstage(name); err != nil {
+		return err
+	}
+	return filesystem.OSError(os.Chmod(path.Join(fsm.workspace, name), mode))
 }
 
 // Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
 // it changes the uid and gid of the link's target. A uid or gid of -1 means to not change that value
 //
-//nolint:revive // parameters will be used eventually
+// The owner is changed in the workspace, which needs the privileges to do so, and kept by Finalize with Rock Ridge.
 func (fsm *FileSystem) Chown(name string, uid, gid int) error {
-	// Rock Ridge has user ids and group ids support
-	// https://en.wikipedia.org/wiki/ISO_9660#Rock_Ridge
-	return filesystem.ErrNotImplemented
+	if fsm.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	if err := fsm.unstage(name); err != nil {
+		return err
+	}
+	return filesystem.OSError(os.Chown(path.Join(fsm.workspace, name), uid, gid))
+}
+
+// Chtimes changes the access and modification times of the named file, like os.Chtimes. A zero
+// time leaves that time unchanged. The times are changed in the workspace, and the modification
+// time is kept by Finalize with Rock Ridge.
+func (fsm *FileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	if fsm.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	if err := fsm.unstage(name); err != nil {
+		return err
+	}
+	return filesystem.OSError(os.Chtimes(path.Join(fsm.workspace, name), atime, mtime))
 }
 
 // ReadDir return the contents of a given directory in a given filesystem.
 //
-// Returns a slice of os.FileInfo with all of the entries in the directory.
+// Returns a slice of fs.DirEntry with all of the entries in the directory, sorted by name.
 //
 // Will return an error if the directory does not exist or is a regular file and not a directory
-func (fsm *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+func (fsm *FileSystem) ReadDir(p string) ([]fs.DirEntry, error) {
+	p = filesystem.AbsolutePath(p)
 	var fi []os.FileInfo
 	// non-workspace: read from iso9660
 	// workspace: read from regular filesystem
@@ -378,19 +423,22 @@ func (fsm *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 		// read the entries
 		dirEntries, err := os.ReadDir(fullPath)
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+			return nil, fmt.Errorf("could not read directory %s: %w", p, filesystem.OSError(err))
 		}
 		for _, e := range dirEntries {
 			info, err := e.Info()
+			if err == nil {
+				info, err = fsm.stagedInfo(path.Join(p, e.Name()), path.Join(fullPath, e.Name()), info)
+			}
 			if err != nil {
-				return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+				return nil, fmt.Errorf("could not read directory %s: %w", p, filesystem.OSError(err))
 			}
 			fi = append(fi, info)
 		}
 	} else {
 		dirEntries, err := fsm.readDirectory(p)
 		if err != nil {
-			return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+			return nil, fmt.Errorf("error reading directory %s: %w", p, err)
 		}
 		fi = make([]os.FileInfo, 0, len(dirEntries))
 		for _, entry := range dirEntries {
@@ -401,7 +449,53 @@ func (fsm *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 			fi = append(fi, entry)
 		}
 	}
-	return fi, nil
+	return filesystem.SortedDirEntries(fi), nil
+}
+
+// Open opens the file or directory read-only, for fs.FS
+func (fsm *FileSystem) Open(name string) (fs.File, error) {
+	return filesystem.GenericOpen(fsm, name)
+}
+
+// Stat describes the file or directory, for fs.StatFS
+func (fsm *FileSystem) Stat(name string) (fs.FileInfo, error) {
+	return filesystem.GenericStat(fsm, name)
+}
+
+// Lstat describes the file or directory like Stat, but describes symbolic links themselves, for
+// filesystem.LstatFS
+func (fsm *FileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return filesystem.GenericLstat(fsm, name)
+}
+
+// Readlink returns the target of the symbolic link, from its Rock Ridge extension, for
+// filesystem.ReadlinkFS
+func (fsm *FileSystem) Readlink(name string) (string, error) {
+	if fsm.workspace != "" {
+		if fsm.isStaged(name) {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+		}
+		target, err := os.Readlink(path.Join(fsm.workspace, filesystem.AbsolutePath(name)))
+		if err != nil {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: filesystem.OSError(errors.Unwrap(err))}
+		}
+		return target, nil
+	}
+	info, err := filesystem.GenericLstat(fsm, name)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.Unwrap(err)}
+	}
+	if entry, ok := info.(*directoryEntry); ok {
+		if target, ok := entry.ReadLink(); ok {
+			return target, nil
+		}
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+// ReadFile reads the whole file, for fs.ReadFileFS
+func (fsm *FileSystem) ReadFile(name string) ([]byte, error) {
+	return filesystem.GenericReadFile(fsm, name)
 }
 
 // OpenFile returns an io.ReadWriter from which you can read the contents of a file
@@ -420,41 +514,33 @@ func (fsm *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 
 	// if the dir == filename, then it is just /
 	if dir == filename {
-		return nil, fmt.Errorf("cannot open directory %s as file", p)
+		return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 	}
 
 	// cannot open to write or append or create if we do not have a workspace
 	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
 	if fsm.workspace == "" {
 		if writeMode {
-			return nil, filesystem.ErrReadonlyFilesystem
+			return nil, filesystem.ErrReadOnlyFilesystem
 		}
 
 		// get the directory entries
-		var entries []*directoryEntry
-		entries, err = fsm.readDirectory(dir)
+		var parent *cachedDirectory
+		parent, err = fsm.getDirectory(dir)
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory entries for %s", dir)
+			return nil, fmt.Errorf("could not read directory entries for %s: %w", dir, err)
 		}
 		// we now know that the directory exists, see if the file exists
-		var targetEntry *directoryEntry
-		for _, e := range entries {
-			eName := e.Name()
-			// cannot do anything with directories
-			if eName == filename && e.IsDir() {
-				return nil, fmt.Errorf("cannot open directory %s as file", p)
-			}
-			if eName == filename {
-				// if we got this far, we have found the file
-				targetEntry = e
-				break
-			}
+		targetEntry := parent.byName().lookup(filename)
+		// cannot do anything with directories
+		if targetEntry != nil && targetEntry.IsDir() {
+			return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 		}
 
 		// see if the file exists
 		// if the file does not exist, and is not opened for os.O_CREATE, return an error
 		if targetEntry == nil {
-			return nil, fmt.Errorf("target file %s does not exist", p)
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, fs.ErrNotExist)
 		}
 		// now open the file
 		f = &File{
@@ -464,10 +550,28 @@ func (fsm *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 			offset:         0,
 		}
 	} else {
-		f, err = os.OpenFile(path.Join(fsm.workspace, p), flag, 0o644)
+		if writeMode && fsm.isStaged(p) {
+			// written in the workspace rather than to the source
+			if flag&os.O_TRUNC != 0 {
+				err = os.Remove(path.Join(fsm.workspace, p))
+				delete(fsm.staged, path.Clean(filesystem.AbsolutePath(p)))
+			} else {
+				err = fsm.unstage(p)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not open %s for writing: %w", p, filesystem.OSError(err))
+			}
+		}
+		var osf *os.File
+		osf, err = os.OpenFile(path.Join(fsm.workspace, p), flag, 0o644)
 		if err != nil {
-			return nil, fmt.Errorf("target file %s does not exist: %v", p, err)
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, filesystem.OSError(err))
+		}
+		if info, err := osf.Stat(); err == nil && info.IsDir() {
+			osf.Close()
+			return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 		}
+		f = osf
 	}
 
 	return f, nil
@@ -476,20 +580,45 @@ func (fsm *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 // Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
 func (fsm *FileSystem) Rename(oldpath, newpath string) error {
 	if fsm.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	oldpath, newpath = filesystem.AbsolutePath(oldpath), filesystem.AbsolutePath(newpath)
+	if oldpath == "/" || newpath == "/" {
+		return fmt.Errorf("cannot rename root directory: %w", fs.ErrInvalid)
 	}
-	return os.Rename(path.Join(fsm.workspace, oldpath), path.Join(fsm.workspace, newpath))
+	if err := os.Rename(path.Join(fsm.workspace, oldpath), path.Join(fsm.workspace, newpath)); err != nil {
+		return filesystem.OSError(err)
+	}
+	fsm.renameStaged(oldpath, newpath)
+	return nil
 }
 
+// Remove removes the named file or empty directory from the workspace
 func (fsm *FileSystem) Remove(p string) error {
 	if fsm.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
 	}
-	return os.Remove(path.Join(fsm.workspace, p))
+	if p = filesystem.AbsolutePath(p); p == "/" {
+		return fmt.Errorf("cannot remove root directory: %w", fs.ErrInvalid)
+	}
+	if err := os.Remove(path.Join(fsm.workspace, p)); err != nil {
+		return filesystem.OSError(err)
+	}
+	delete(fsm.staged, path.Clean(p))
+	return nil
 }
 
 // readDirectory - read directory entry on iso only (not workspace)
 func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
+	dir, err := fsm.getDirectory(p)
+	if err != nil {
+		return nil, err
+	}
+	return dir.entries, nil
+}
+
+// getDirectory returns the directory of the path on the iso, read unless it is cached
+func (fsm *FileSystem) getDirectory(p string) (*cachedDirectory, error) {
 	var (
 		location, size uint32
 		err            error
@@ -509,13 +638,17 @@ func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 		location = fsm.pathTable.getLocation(p)
 	}
 
+	// if we found it and have read it already, there is nothing to read
+	if dir := fsm.dirs.get(location); location != 0 && dir != nil {
+		return dir, nil
+	}
 	// if we found it, read the first directory entry to get the size
 	if location != 0 {
 		// we need 4 bytes to read the size of the directory; it is at offset 10 from beginning
 		dirb := make([]byte, 4)
 		n, err = fsm.backend.ReadAt(dirb, int64(location)*fsm.blocksize+10)
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+			return nil, fmt.Errorf("could not read directory %s: %w", p, filesystem.OSError(err))
 		}
 		if n != len(dirb) {
 			return nil, fmt.Errorf("read %d bytes instead of expected %d", n, len(dirb))
@@ -527,30 +660,42 @@ func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 		//   it is slow, but this is how Unix does it, since many iso creators *do* create illegitimate disks
 		location, size, err = fsm.rootDir.getLocation(p)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read directory tree for %s: %v", p, err)
+			return nil, fmt.Errorf("unable to read directory tree for %s: %w", p, err)
 		}
 	}
 
 	// did we still not find it?
 	if location == 0 {
-		return nil, fmt.Errorf("could not find directory %s", p)
+		return nil, fmt.Errorf("could not find directory %s: %w", p, fs.ErrNotExist)
 	}
 
-	// we have a location, let's read the directories from it
+	dir, err := fsm.readDirectoryAt(location, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory entries for %s: %w", p, err)
+	}
+	return dir, nil
+}
+
+// readDirectoryAt returns the directory of size bytes at the block, read and parsed unless it is
+// cached
+func (fsm *FileSystem) readDirectoryAt(location, size uint32) (*cachedDirectory, error) {
+	if dir := fsm.dirs.get(location); dir != nil {
+		return dir, nil
+	}
 	b := make([]byte, size)
-	n, err = fsm.backend.ReadAt(b, int64(location)*fsm.blocksize)
+	n, err := fsm.backend.ReadAt(b, int64(location)*fsm.blocksize)
 	if err != nil {
-		return nil, fmt.Errorf("could not read directory entries for %s: %v", p, err)
+		return nil, fmt.Errorf("could not read directory at block %d: %w", location, err)
 	}
 	if n != int(size) {
-		return nil, fmt.Errorf("reading directory %s returned %d bytes read instead of expected %d", p, n, size)
+		return nil, fmt.Errorf("reading directory at block %d returned %d bytes read instead of expected %d", location, n, size)
 	}
 	// parse the entries
 	entries, err := parseDirEntries(b, fsm)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse directory entries for %s: %v", p, err)
+		return nil, fmt.Errorf("could not parse directory at block %d: %w", location, err)
 	}
-	return entries, nil
+	return fsm.dirs.add(location, newCachedDirectory(entries)), nil
 }
 
 func validateBlocksize(blocksize int64) error {
@@ -562,6 +707,51 @@ func validateBlocksize(blocksize int64) error {
 	}
 }
 
+// BlockSize returns the size of the logical blocks allocated to files, for filesystem.BlockSizeFS
+func (fsm *FileSystem) BlockSize() int64 {
+	return fsm.blocksize
+}
+
+// Space returns the size given to Create and an estimate of what is free in it, for
+// filesystem.SpaceFS, while the filesystem is a workspace: the files and directories in it take
+// whole blocks once finalized, after the system area and volume descriptors. The tables
+// Finalize adds, such as those of Rock Ridge, take more. A finalized filesystem has no space free.
+func (fsm *FileSystem) Space() (total, free int64, err error) {
+	if fsm.workspace == "" {
+		if fsm.volumes.primary == nil {
+			return fsm.size, 0, nil
+		}
+		return int64(fsm.volumes.primary.volumeSize) * fsm.blocksize, 0, nil
+	}
+	if fsm.size == 0 {
+		return 0, 0, fmt.Errorf("workspace created without a size: %w", filesystem.ErrNotSupported)
+	}
+	// the system area, the primary volume descriptor and the terminator
+	used := systemAreaSize + 2*volumeDescriptorSize
+	err = filepath.WalkDir(fsm.workspace, func(actualPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info, err = fsm.stagedInfo(fsm.workspacePath(actualPath), actualPath, info); err != nil {
+			return err
+		}
+		blocks := int64(1)
+		if info.Mode().IsRegular() {
+			blocks = (info.Size() + fsm.blocksize - 1) / fsm.blocksize
+		}
+		used += blocks * fsm.blocksize
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("error walking workspace: %w", err)
+	}
+	return fsm.size, max(fsm.size-used, 0), nil
+}
+
 func (fsm *FileSystem) Label() string {
 	if fsm.volumes.primary == nil {
 		return ""
@@ -570,5 +760,5 @@ func (fsm *FileSystem) Label() string {
 }
 
 func (fsm *FileSystem) SetLabel(string) error {
-	return fmt.Errorf("ISO9660 filesystem is read-only")
+	return fmt.Errorf("ISO9660 filesystem label cannot be changed: %w", filesystem.ErrReadOnlyFilesystem)
 }
@@ -307,7 +307,7 @@ func TestIso9660ReadDir(t *testing.T) {
 		runTests(t, []testList{
 			{fs, "/abcdef", 0, "", "", fmt.Errorf("directory does not exist")}, // does not exist
 			// root should have 4 entries (since we do not pass back . and ..):
-			{fs, "/", 6, "abc", "README.md", nil},                                   // exists
+			{fs, "/", 6, "README.md", "link", nil},                                  // exists, sorted by name
 			{fs, "/ABC", 0, "", "LARGEFIL", fmt.Errorf("directory does not exist")}, // should not find 8.3 name
 			{fs, "/abc", 1, "", "largefile", nil},                                   // should find rock ridge name
 			{fs, "/deep/a/b/c/d/e/f/g/h/i/j/k", 1, "file", "file", nil},             // should find a deep directory
@@ -563,7 +563,7 @@ func TestIso9660Finalize(t *testing.T) {
 		}
 
 		// Create the ISO filesystem on the disk image
-		fspec := disk.FilesystemSpec{
+		fspec := disk.FormatSpec{
 			Partition:   0,
 			FSType:      filesystem.TypeISO9660,
 			VolumeLabel: "label",
@@ -653,3 +653,28 @@ func TestIso9660Finalize(t *testing.T) {
 		})
 	}
 }
+
+func TestISO9660Check(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   func() (*iso9660.FileSystem, error)
+	}{
+		{"iso9660", getValidIso9660FSReadOnly},
+		{"rock ridge", getValidRockRidgeFSReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := tt.fs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			findings, err := fs.Check(filesystem.CheckOptions{})
+			if err != nil {
+				t.Fatalf("error checking filesystem: %v", err)
+			}
+			if len(findings) != 0 {
+				t.Errorf("findings %v in a sound filesystem", findings)
+			}
+		})
+	}
+}
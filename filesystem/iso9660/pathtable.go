@@ -1,12 +1,17 @@
 package iso9660
 
 import (
+	"cmp"
 	"encoding/binary"
+	"slices"
 )
 
 // pathTable represents an on-iso path table
 type pathTable struct {
 	records []*pathTableEntry
+	// sorted is whether the records are in the order of the standard, by parent directory and then
+	// name, so that the children of a directory are found with a binary search
+	sorted bool
 }
 
 type pathTableEntry struct {
@@ -94,32 +99,45 @@ func (pt *pathTable) toMBytes() []byte {
 func (pt *pathTable) getLocation(p string) uint32 {
 	// break path down into parts and levels
 	parts := splitPath(p)
-	// level represents the level of the parent
-	var level uint16 = 1
-	var location uint32
 	if len(parts) == 0 {
-		location = pt.records[0].location
-	} else {
-		current := parts[0]
-		// loop through the path table until we find our entry
-		// we always can go forward because of the known depth ordering of path table
-		for i, entry := range pt.records {
-			// did we find a match for our current level?
-			if entry.parentIndex == level && entry.dirname == current {
-				level = uint16(i)
-				if len(parts) > 1 {
-					parts = parts[1:]
-				} else {
-					// this is the final one, we found it, keep it
-					location = entry.location
-					break
-				}
-			}
+		return pt.records[0].location
+	}
+	// directories are numbered from 1 by their place in the table, the root first
+	var parent uint16 = 1
+	var location uint32
+	for _, current := range parts {
+		i, ok := pt.child(parent, current)
+		if !ok {
+			return 0
 		}
+		parent = uint16(i + 1)
+		location = pt.records[i].location
 	}
 	return location
 }
 
+// child returns the index of the record of the directory with the name in the directory numbered
+// parent
+func (pt *pathTable) child(parent uint16, name string) (int, bool) {
+	if pt.sorted {
+		return slices.BinarySearchFunc(pt.records, &pathTableEntry{parentIndex: parent, dirname: name}, comparePathTableEntries)
+	}
+	for i, entry := range pt.records {
+		if entry.parentIndex == parent && entry.dirname == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// comparePathTableEntries orders the records of a path table by parent directory and then name
+func comparePathTableEntries(a, b *pathTableEntry) int {
+	if c := cmp.Compare(a.parentIndex, b.parentIndex); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.dirname, b.dirname)
+}
+
 // parsePathTable load pathtable bytes into structures
 func parsePathTable(b []byte) *pathTable {
 	totalSize := len(b)
@@ -151,5 +169,6 @@ func parsePathTable(b []byte) *pathTable {
 	}
 	return &pathTable{
 		records: entries,
+		sorted:  slices.IsSortedFunc(entries, comparePathTableEntries),
 	}
 }
@@ -98,13 +98,20 @@ func TestPathTableGetLocation(t *testing.T) {
 	}{
 		{"/", 0x12, nil},
 		{"/FOO", 0x21, nil},
+		{"/DEEP/A/B", 0x17, nil},
+		{"/DEEP/A/B/C/D/E/F/G/H/I/J/K", 0x20, nil},
+		{"/DEEP/B", 0x00, nil},
 		{"/nothereatall", 0x00, nil},
 	}
 
-	for _, tt := range tests {
-		location := table.getLocation(tt.path)
-		if location != tt.location {
-			t.Errorf("Mismatched location, actual: %d vs expected: %d", location, tt.location)
+	// with a binary search of the sorted table, and a scan of it
+	for _, sorted := range []bool{true, false} {
+		table.sorted = sorted
+		for _, tt := range tests {
+			location := table.getLocation(tt.path)
+			if location != tt.location {
+				t.Errorf("Mismatched location for %s with sorted %v, actual: %d vs expected: %d", tt.path, sorted, location, tt.location)
+			}
 		}
 	}
 }
@@ -117,4 +124,7 @@ func TestParsePathTable(t *testing.T) {
 		t.Logf("%#v", table.records)
 		t.Logf("%#v", validTable.records)
 	}
+	if !table.sorted {
+		t.Errorf("records of the path table are in order, but not found sorted")
+	}
 }
@@ -18,6 +18,7 @@ const (
 	rockRidgeSignatureRelocatedDirectory = "RE"
 	rockRidgeSignatureTimestamps         = "TF"
 	rockRidgeSignatureSparseFile         = "SF"
+	rockRidgeSignatureZisofs             = "ZF"
 	rockRidge110                         = "RRIP_1991A"
 	rockRidge112                         = "IEEE_P1282"
 )
@@ -69,11 +70,13 @@ func (r *rockRidgeExtension) Process(signature string, b []byte) (directoryEntry
 		entry, err = r.parseTimestamps(b)
 	case rockRidgeSignatureSparseFile:
 		entry, err = r.parseSparseFile(b)
+	case rockRidgeSignatureZisofs:
+		entry, err = r.parseZisofs(b)
 	default:
 		return nil, ErrSuspNoHandler
 	}
 	if err != nil {
-		return nil, fmt.Errorf("error parsing %s extension by Rock Ridge : %v", signature, err)
+		return nil, fmt.Errorf("error parsing %s extension by Rock Ridge : %w", signature, err)
 	}
 	return entry, nil
 }
@@ -131,7 +134,7 @@ func (r *rockRidgeExtension) GetFileExtensions(ffi *finalizeFileInfo, isSelf, is
 }
 
 func (r *rockRidgeExtension) GetFinalizeExtensions(fi *finalizeFileInfo) ([]directoryEntrySystemUseExtension, error) {
-	// we look for CL, PL, RE entries
+	// we look for CL, PL, RE entries, and ZF for files compressed with zisofs
 	ret := []directoryEntrySystemUseExtension{}
 	if fi.trueParent != nil {
 		ret = append(ret, rockRidgeRelocatedDirectory{}, rockRidgeParentDirectory{location: fi.trueParent.location})
@@ -139,6 +142,9 @@ func (r *rockRidgeExtension) GetFinalizeExtensions(fi *finalizeFileInfo) ([]dire
 	if fi.trueChild != nil {
 		ret = append(ret, rockRidgeChildDirectory{location: fi.trueChild.location})
 	}
+	if fi.zisofs != nil {
+		ret = append(ret, *fi.zisofs)
+	}
 	return ret, nil
 }
 
@@ -990,6 +996,72 @@ func (r *rockRidgeExtension) parseSparseFile(b []byte) (directoryEntrySystemUseE
 	return sf, nil
 }
 
+// rockRidgeZisofs is the ZF entry of a file compressed with zisofs, which is not part of Rock Ridge
+// itself but read with it by Linux, libarchive and others
+type rockRidgeZisofs struct {
+	// headerSize is the size of the header of the compressed data, in 4 byte units
+	headerSize uint8
+	// blockSizeLog is the log2 of the size of the blocks compressed
+	blockSizeLog uint8
+	// size is the size of the file uncompressed
+	size uint32
+}
+
+func (d rockRidgeZisofs) Equal(o directoryEntrySystemUseExtension) bool {
+	t, ok := o.(rockRidgeZisofs)
+	return ok && t == d
+}
+func (d rockRidgeZisofs) Signature() string {
+	return rockRidgeSignatureZisofs
+}
+func (d rockRidgeZisofs) Length() int {
+	return 16
+}
+func (d rockRidgeZisofs) Version() uint8 {
+	return 1
+}
+func (d rockRidgeZisofs) Data() []byte {
+	return []byte{}
+}
+func (d rockRidgeZisofs) Bytes() []byte {
+	b := make([]byte, d.Length())
+	copy(b[0:2], rockRidgeSignatureZisofs)
+	b[2] = uint8(d.Length())
+	b[3] = d.Version()
+	copy(b[4:6], zisofsAlgorithm)
+	b[6] = d.headerSize
+	b[7] = d.blockSizeLog
+	binary.LittleEndian.PutUint32(b[8:12], d.size)
+	binary.BigEndian.PutUint32(b[12:16], d.size)
+	return b
+}
+func (d rockRidgeZisofs) Continuable() bool {
+	return false
+}
+func (d rockRidgeZisofs) Merge([]directoryEntrySystemUseExtension) directoryEntrySystemUseExtension {
+	return nil
+}
+
+func (r *rockRidgeExtension) parseZisofs(b []byte) (directoryEntrySystemUseExtension, error) {
+	if len(b) != 16 {
+		return nil, fmt.Errorf("ZF extension must be 16 bytes, but received %d", len(b))
+	}
+	if b[3] != 1 {
+		return nil, fmt.Errorf("ZF extension must be version 1, was %d", b[3])
+	}
+	if string(b[4:6]) != zisofsAlgorithm {
+		return nil, fmt.Errorf("ZF extension of unsupported algorithm %q", b[4:6])
+	}
+	if b[7] < zisofsMinBlockSizeLog || b[7] > zisofsMaxBlockSizeLog {
+		return nil, fmt.Errorf("ZF extension with invalid block size of 2^%d bytes", b[7])
+	}
+	return rockRidgeZisofs{
+		headerSize:   b[6],
+		blockSizeLog: b[7],
+		size:         binary.LittleEndian.Uint32(b[8:12]),
+	}, nil
+}
+
 // rockRidgeChildDirectory
 type rockRidgeChildDirectory struct {
 	location uint32
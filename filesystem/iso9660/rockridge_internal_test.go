@@ -1,6 +1,7 @@
 package iso9660
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/user"
@@ -102,6 +103,25 @@ func TestRockRidgeNameMerge(t *testing.T) {
 	}
 }
 
+func TestRockRidgeZisofs(t *testing.T) {
+	// the ZF entry of mkisofs -z for a file of 0x12345 bytes in blocks of 32 KB
+	b := []byte{'Z', 'F', 16, 1, 'p', 'z', 4, 15, 0x45, 0x23, 0x01, 0x00, 0x00, 0x01, 0x23, 0x45}
+	zf := rockRidgeZisofs{headerSize: 4, blockSizeLog: 15, size: 0x12345}
+	if actual := zf.Bytes(); !bytes.Equal(actual, b) {
+		t.Errorf("ZF entry % x instead of % x", actual, b)
+	}
+	rr := getRockRidgeExtension(rockRidge112)
+	entry, err := rr.Process(rockRidgeSignatureZisofs, b)
+	if err != nil || !zf.Equal(entry) {
+		t.Errorf("parsed %#v, %v instead of %#v", entry, err, zf)
+	}
+	invalid := bytes.Clone(b)
+	copy(invalid[4:6], "bz")
+	if _, err := rr.Process(rockRidgeSignatureZisofs, invalid); err == nil {
+		t.Errorf("no error parsing ZF entry of another algorithm")
+	}
+}
+
 func TestRockRidgeSortTimestamp(t *testing.T) {
 	// these are ust sorted randomly
 	tests := []rockRidgeTimestamp{
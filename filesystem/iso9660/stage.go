@@ -0,0 +1,145 @@
+package iso9660
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// AddFile stages the regular file at source, on the host, as the file p of the filesystem, without
+// copying it into the workspace: Finalize reads it from source, so that its contents are written
+// only once, into the image. Staging the files of a large tree this way rather than writing them
+// with OpenFile halves the data written by Finalize, and needs no space for the workspace copy.
+//
+// The source must not change until Finalize, which reads it as it is then. The file is copied
+// into the workspace, leaving source as it is, if it is opened for writing, or its mode, owner or
+// times are changed, before Finalize.
+func (fsm *FileSystem) AddFile(p, source string) error {
+	if fsm.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	p = path.Clean(filesystem.AbsolutePath(p))
+	if p == "/" {
+		return fmt.Errorf("cannot add a file as the root directory: %w", fs.ErrInvalid)
+	}
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return fmt.Errorf("could not find the absolute path of %s: %w", source, err)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %w", source, filesystem.OSError(err))
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("cannot add %s, which is not a regular file: %w", source, fs.ErrInvalid)
+	}
+	fullPath := path.Join(fsm.workspace, p)
+	if existing, err := os.Lstat(fullPath); err == nil {
+		if existing.IsDir() {
+			return fmt.Errorf("cannot add file %s over a directory: %w", p, filesystem.ErrIsDir)
+		}
+		if err := os.Remove(fullPath); err != nil {
+			return fmt.Errorf("could not replace %s: %w", p, filesystem.OSError(err))
+		}
+	}
+	// the workspace has a link to the source, which only the filesystem knows is not a symbolic link
+	if err := os.Symlink(source, fullPath); err != nil {
+		return fmt.Errorf("could not add file %s: %w", p, filesystem.OSError(err))
+	}
+	if fsm.staged == nil {
+		fsm.staged = map[string]bool{}
+	}
+	fsm.staged[p] = true
+	return nil
+}
+
+// isStaged reports whether the path of the workspace is a file added with AddFile
+func (fsm *FileSystem) isStaged(p string) bool {
+	return fsm.staged[path.Clean(filesystem.AbsolutePath(p))]
+}
+
+// unstage copies the file added with AddFile at the path into the workspace, if it is one, so that
+// it can be changed without changing its source
+func (fsm *FileSystem) unstage(p string) error {
+	p = path.Clean(filesystem.AbsolutePath(p))
+	if !fsm.staged[p] {
+		return nil
+	}
+	fullPath := path.Join(fsm.workspace, p)
+	from, err := os.Open(fullPath)
+	if err != nil {
+		return fmt.Errorf("could not open source of %s: %w", p, filesystem.OSError(err))
+	}
+	defer from.Close()
+	info, err := from.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat source of %s: %w", p, filesystem.OSError(err))
+	}
+	// copy next to the link, and then over it
+	tmp := fullPath + ".staged"
+	to, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("could not copy source of %s: %w", p, filesystem.OSError(err))
+	}
+	_, err = io.Copy(to, from)
+	err = errors.Join(err, to.Close())
+	if err == nil {
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, fullPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not copy source of %s: %w", p, filesystem.OSError(err))
+	}
+	delete(fsm.staged, p)
+	return nil
+}
+
+// renameStaged moves the files added with AddFile at or below oldpath to newpath
+func (fsm *FileSystem) renameStaged(oldpath, newpath string) {
+	if len(fsm.staged) == 0 {
+		return
+	}
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	staged := make(map[string]bool, len(fsm.staged))
+	for p := range fsm.staged {
+		switch {
+		case p == oldpath:
+			staged[newpath] = true
+		case strings.HasPrefix(p, oldpath+"/"):
+			staged[newpath+strings.TrimPrefix(p, oldpath)] = true
+		case p == newpath || strings.HasPrefix(p, newpath+"/"):
+			// replaced by what is renamed
+		default:
+			staged[p] = true
+		}
+	}
+	fsm.staged = staged
+}
+
+// stagedInfo describes the file of the workspace at the path, which is its source for a file
+// added with AddFile
+func (fsm *FileSystem) stagedInfo(p, fullPath string, info fs.FileInfo) (fs.FileInfo, error) {
+	if info.Mode()&os.ModeSymlink == 0 || !fsm.isStaged(p) {
+		return info, nil
+	}
+	return os.Stat(fullPath)
+}
+
+// workspacePath returns the path in the filesystem of a path of the host in the workspace
+func (fsm *FileSystem) workspacePath(actualPath string) string {
+	fp, err := filepath.Rel(fsm.workspace, actualPath)
+	if err != nil {
+		return ""
+	}
+	return path.Clean("/" + filepath.ToSlash(fp))
+}
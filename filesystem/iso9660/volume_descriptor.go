@@ -196,12 +196,12 @@ func volumeDescriptorFromBytes(b []byte) (volumeDescriptor, error) {
 	case volumeDescriptorPrimary:
 		vd, err = parsePrimaryVolumeDescriptor(b)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %v", err)
+			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %w", err)
 		}
 	case volumeDescriptorBoot:
 		vd, err = parseBootVolumeDescriptor(b)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %v", err)
+			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %w", err)
 		}
 	case volumeDescriptorTerminator:
 		vd = &terminatorVolumeDescriptor{}
@@ -212,7 +212,7 @@ func volumeDescriptorFromBytes(b []byte) (volumeDescriptor, error) {
 	case volumeDescriptorSupplementary:
 		vd, err = parseSupplementaryVolumeDescriptor(b)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %v", err)
+			return nil, fmt.Errorf("unable to parse primary volume descriptor bytes: %w", err)
 		}
 	default:
 		return nil, fmt.Errorf("unknown volume descriptor type %d", vdType)
@@ -225,11 +225,11 @@ func parsePrimaryVolumeDescriptor(b []byte) (*primaryVolumeDescriptor, error) {
 
 	creation, err := decBytesToTime(b[813 : 813+17])
 	if err != nil {
-		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %v", err)
+		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %w", err)
 	}
 	modification, err := decBytesToTime(b[830 : 830+17])
 	if err != nil {
-		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %v", err)
+		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %w", err)
 	}
 	// expiration can be never
 	nullBytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
@@ -239,19 +239,19 @@ func parsePrimaryVolumeDescriptor(b []byte) (*primaryVolumeDescriptor, error) {
 	if !bytes.Equal(expirationBytes, nullBytes) {
 		expiration, err = decBytesToTime(expirationBytes)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
+			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %w", err)
 		}
 	}
 	if !bytes.Equal(effectiveBytes, nullBytes) {
 		effective, err = decBytesToTime(effectiveBytes)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
+			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %w", err)
 		}
 	}
 
 	rootDirEntry, err := dirEntryFromBytes(b[156:156+34], nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read root directory entry: %v", err)
+		return nil, fmt.Errorf("unable to read root directory entry: %w", err)
 	}
 
 	return &primaryVolumeDescriptor{
@@ -326,11 +326,11 @@ func parseSupplementaryVolumeDescriptor(b []byte) (*supplementaryVolumeDescripto
 
 	creation, err := decBytesToTime(b[813 : 813+17])
 	if err != nil {
-		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %v", err)
+		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %w", err)
 	}
 	modification, err := decBytesToTime(b[830 : 830+17])
 	if err != nil {
-		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %v", err)
+		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %w", err)
 	}
 	// expiration can be never
 	nullBytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
@@ -340,20 +340,20 @@ func parseSupplementaryVolumeDescriptor(b []byte) (*supplementaryVolumeDescripto
 	if !bytes.Equal(expirationBytes, nullBytes) {
 		expiration, err = decBytesToTime(expirationBytes)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
+			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %w", err)
 		}
 	}
 	if !bytes.Equal(effectiveBytes, nullBytes) {
 		effective, err = decBytesToTime(effectiveBytes)
 		if err != nil {
-			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
+			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %w", err)
 		}
 	}
 
 	// no susp extensions for the dir entry in the volume descriptor
 	rootDirEntry, err := dirEntryFromBytes(b[156:156+34], nil)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read root directory entry: %v", err)
+		return nil, fmt.Errorf("unable to read root directory entry: %w", err)
 	}
 
 	return &supplementaryVolumeDescriptor{
@@ -0,0 +1,259 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// zisofs is the format of files compressed in blocks with zlib, as mkzftree and mkisofs -z write
+// them, marked with a ZF entry. The data of each file starts with a header, followed by the offset
+// of each block in the data and that of its end, and then the blocks, each compressed on its own,
+// or left out when all zeros.
+const (
+	zisofsAlgorithm       = "pz"
+	zisofsHeaderSize      = 16
+	zisofsBlockSizeLog    = 15
+	zisofsMinBlockSizeLog = 15
+	zisofsMaxBlockSizeLog = 17
+)
+
+var zisofsMagic = []byte{0x37, 0xe4, 0x53, 0x96, 0xc9, 0xdb, 0xd6, 0x07}
+
+// zisofsBlock is a block of a file to compress
+type zisofsBlock struct {
+	file  *finalizeFileInfo
+	size  int64
+	index int
+	count int
+	data  []byte
+}
+
+// zisofsResult is a file that compressed smaller, where its data is in the compressed files and
+// how large it is
+type zisofsResult struct {
+	file   *finalizeFileInfo
+	zisofs *rockRidgeZisofs
+	offset int64
+	size   int64
+}
+
+// compressFiles compresses the regular files in zisofs blocks to tmp, with processors goroutines,
+// one file after the other. The files that end up smaller by at least a block are given their
+// ZF entry, their size compressed, and the offset of their data in tmp; the others are left as
+// they are.
+func (fsm *FileSystem) compressFiles(ctx context.Context, files []*finalizeFileInfo, processors int, tmp *os.File) error {
+	blockSize := 1 << zisofsBlockSizeLog
+	// the reader keeps what it needs of the file it is in, as the files are only changed once all
+	// of them are compressed
+	var (
+		next         int
+		current      *os.File
+		e            *finalizeFileInfo
+		size         int64
+		index, count int
+	)
+	defer func() {
+		if current != nil {
+			current.Close()
+		}
+	}()
+	// read the blocks of the files in turn
+	read := func() (zisofsBlock, error) {
+		for {
+			if current != nil {
+				if index < count {
+					b := make([]byte, min(int64(blockSize), size-int64(index)*int64(blockSize)))
+					if _, err := current.ReadAt(b, int64(index)*int64(blockSize)); err != nil {
+						return zisofsBlock{}, fmt.Errorf("failed to read %s: %w", e.path, err)
+					}
+					index++
+					return zisofsBlock{file: e, size: size, index: index - 1, count: count, data: b}, nil
+				}
+				current.Close()
+				current = nil
+			}
+			if next == len(files) {
+				return zisofsBlock{}, io.EOF
+			}
+			e = files[next]
+			next++
+			if e.content != nil || e.elToritoEntry != nil || !e.mode.IsRegular() || e.size <= fsm.blocksize {
+				continue
+			}
+			f, err := os.Open(path.Join(fsm.workspace, e.path))
+			if err != nil {
+				return zisofsBlock{}, fmt.Errorf("failed to open file for reading %s: %w", e.path, err)
+			}
+			current, size, index = f, e.size, 0
+			count = int((size + int64(blockSize) - 1) / int64(blockSize))
+		}
+	}
+	// compress each, leaving those all zeros empty
+	process := func(block zisofsBlock) ([]byte, error) {
+		if allZeros(block.data) {
+			return nil, nil
+		}
+		var buf bytes.Buffer
+		w, err := zlib.NewWriterLevel(&buf, zlib.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(block.data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	// and write the blocks of each file after its header and offsets
+	var (
+		offset, start, written int64
+		pointers               []byte
+		results                []zisofsResult
+	)
+	write := func(block zisofsBlock, compressed []byte) error {
+		if block.index == 0 {
+			start = offset
+			pointers = make([]byte, 4*(block.count+1))
+			written = int64(zisofsHeaderSize + len(pointers))
+			binary.LittleEndian.PutUint32(pointers[0:4], uint32(written))
+		}
+		if _, err := tmp.WriteAt(compressed, start+written); err != nil {
+			return fmt.Errorf("failed to write compressed %s: %w", block.file.path, err)
+		}
+		written += int64(len(compressed))
+		binary.LittleEndian.PutUint32(pointers[4*(block.index+1):], uint32(written))
+		if block.index < block.count-1 {
+			return nil
+		}
+		// the whole file is compressed, and kept if it takes fewer blocks
+		if calculateBlocks(written, fsm.blocksize) >= calculateBlocks(block.size, fsm.blocksize) {
+			return nil
+		}
+		header := make([]byte, zisofsHeaderSize, zisofsHeaderSize+len(pointers))
+		copy(header, zisofsMagic)
+		binary.LittleEndian.PutUint32(header[8:12], uint32(block.size))
+		header[12] = zisofsHeaderSize / 4
+		header[13] = zisofsBlockSizeLog
+		if _, err := tmp.WriteAt(append(header, pointers...), start); err != nil {
+			return fmt.Errorf("failed to write compressed %s: %w", block.file.path, err)
+		}
+		results = append(results, zisofsResult{
+			file:   block.file,
+			zisofs: &rockRidgeZisofs{headerSize: zisofsHeaderSize / 4, blockSizeLog: zisofsBlockSizeLog, size: uint32(block.size)},
+			offset: start,
+			size:   written,
+		})
+		offset += written
+		return nil
+	}
+	if err := util.Pipeline(ctx, util.Processors(processors), read, process, write); err != nil {
+		return err
+	}
+	for _, r := range results {
+		r.file.zisofs = r.zisofs
+		r.file.zisofsOffset = r.offset
+		r.file.size = r.size
+		r.file.blocks = calculateBlocks(r.size, fsm.blocksize)
+	}
+	return nil
+}
+
+func allZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// zisofsCache is the last block of a compressed file read, so that reads of the file smaller
+// than a block do not decompress it again each time
+type zisofsCache struct {
+	mu    sync.Mutex
+	index int64
+	data  []byte
+}
+
+// zisofs returns the ZF entry of the file, if it is compressed
+func (de *directoryEntry) zisofs() (rockRidgeZisofs, bool) {
+	for _, e := range de.extensions {
+		if zf, ok := e.(rockRidgeZisofs); ok {
+			return zf, true
+		}
+	}
+	return rockRidgeZisofs{}, false
+}
+
+// readZisofs reads up to len(b) bytes from offset of the file compressed with zisofs, returning
+// io.EOF if it reaches the end of the file
+func (fl *File) readZisofs(zf rockRidgeZisofs, b []byte, offset int64) (int, error) {
+	size := int64(zf.size)
+	if offset >= size {
+		return 0, io.EOF
+	}
+	blockSize := int64(1) << zf.blockSizeLog
+	n := 0
+	for n < len(b) && offset < size {
+		index := offset / blockSize
+		block, err := fl.zisofsBlock(zf, index, blockSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(b[n:], block[offset-index*blockSize:])
+		n += copied
+		offset += int64(copied)
+	}
+	if offset >= size {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// zisofsBlock returns the block of the file decompressed
+func (fl *File) zisofsBlock(zf rockRidgeZisofs, index, blockSize int64) ([]byte, error) {
+	fl.zisofsCache.mu.Lock()
+	defer fl.zisofsCache.mu.Unlock()
+	if fl.zisofsCache.data != nil && fl.zisofsCache.index == index {
+		return fl.zisofsCache.data, nil
+	}
+	fs := fl.filesystem
+	start := int64(fl.location) * fs.blocksize
+	// the offsets of the block and of the next one
+	pointers := make([]byte, 8)
+	if _, err := fs.backend.ReadAt(pointers, start+int64(zf.headerSize)*4+index*4); err != nil && err != io.EOF {
+		return nil, err
+	}
+	from, to := int64(binary.LittleEndian.Uint32(pointers[0:4])), int64(binary.LittleEndian.Uint32(pointers[4:8]))
+	if from > to || to > int64(fl.size) {
+		return nil, fmt.Errorf("invalid offsets %d-%d of zisofs block %d in %d bytes of data", from, to, index, fl.size)
+	}
+	data := make([]byte, min(blockSize, int64(zf.size)-index*blockSize))
+	// the blocks of zeros are left out
+	if to > from {
+		compressed := make([]byte, to-from)
+		if _, err := fs.backend.ReadAt(compressed, start+from); err != nil && err != io.EOF {
+			return nil, err
+		}
+		r, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zisofs block %d: %w", index, err)
+		}
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("invalid zisofs block %d: %w", index, err)
+		}
+	}
+	fl.zisofsCache.index, fl.zisofsCache.data = index, data
+	return data, nil
+}
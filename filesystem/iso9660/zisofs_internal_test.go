@@ -0,0 +1,81 @@
+package iso9660
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressFiles(t *testing.T) {
+	random := make([]byte, 100*1024)
+	_, _ = rand.Read(random)
+	workspace := t.TempDir()
+	contents := map[string][]byte{
+		"TEXT.TXT":   []byte(strings.Repeat("some text compressed with zisofs\n", 10000)),
+		"ZEROS.BIN":  make([]byte, 200*1024+5),
+		"RANDOM.BIN": random,
+		"SMALL.TXT":  []byte("small"),
+	}
+	for name, data := range contents {
+		if err := os.WriteFile(filepath.Join(workspace, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// compress returns the compressed data and the entries of the files after compressing them
+	compress := func(processors int) ([]byte, map[string]finalizeFileInfo) {
+		t.Helper()
+		fsm := &FileSystem{workspace: workspace, blocksize: 2048}
+		var files []*finalizeFileInfo
+		for _, name := range []string{"RANDOM.BIN", "SMALL.TXT", "TEXT.TXT", "ZEROS.BIN"} {
+			size := int64(len(contents[name]))
+			files = append(files, &finalizeFileInfo{path: name, size: size, blocks: calculateBlocks(size, fsm.blocksize)})
+		}
+		tmp, err := os.CreateTemp(t.TempDir(), ".zisofs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tmp.Close()
+		if err := fsm.compressFiles(context.Background(), files, processors, tmp); err != nil {
+			t.Fatalf("error compressing files: %v", err)
+		}
+		b, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := map[string]finalizeFileInfo{}
+		for _, e := range files {
+			entries[e.path] = *e
+		}
+		return b, entries
+	}
+
+	data, entries := compress(4)
+	for name, compressed := range map[string]bool{"TEXT.TXT": true, "ZEROS.BIN": true, "RANDOM.BIN": false, "SMALL.TXT": false} {
+		e := entries[name]
+		if (e.zisofs != nil) != compressed {
+			t.Errorf("%s compressed %v instead of %v", name, e.zisofs != nil, compressed)
+			continue
+		}
+		size := int64(len(contents[name]))
+		if !compressed {
+			if e.size != size {
+				t.Errorf("%s left with %d bytes instead of %d", name, e.size, size)
+			}
+			continue
+		}
+		if e.zisofs.size != uint32(size) || e.size >= size || e.blocks != calculateBlocks(e.size, 2048) {
+			t.Errorf("%s compressed to %d bytes in %d blocks, ZF %+v", name, e.size, e.blocks, *e.zisofs)
+		}
+		if !bytes.Equal(data[e.zisofsOffset:e.zisofsOffset+8], zisofsMagic) {
+			t.Errorf("%s compressed without the zisofs header", name)
+		}
+	}
+	// the data is the same whatever the number of processors
+	if single, _ := compress(1); !bytes.Equal(data, single) {
+		t.Errorf("data compressed with 4 and 1 processors differs")
+	}
+}
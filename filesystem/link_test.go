@@ -0,0 +1,140 @@
+package filesystem_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// sameInode fails unless the named files are hard links to the same file
+func sameInode(t *testing.T, f filesystem.LinkFS, a, b string) {
+	t.Helper()
+	inoA, errA := f.Inode(a)
+	inoB, errB := f.Inode(b)
+	if errA != nil || errB != nil || inoA != inoB {
+		t.Errorf("inodes %d, %v of %s and %d, %v of %s are not the same", inoA, errA, a, inoB, errB, b)
+	}
+}
+
+func TestLink(t *testing.T) {
+	t.Run("ext4", func(t *testing.T) {
+		f := createExt4(t)
+		// made by ln in the image
+		sameInode(t, f, "/random.dat", "/hardlink.dat")
+		content, err := f.ReadFile("shortfile.txt")
+		if err != nil {
+			t.Fatalf("error reading file: %v", err)
+		}
+		if err := f.Link("/shortfile.txt", "/LINK.TXT"); err != nil {
+			t.Fatalf("error creating hard link: %v", err)
+		}
+		sameInode(t, f, "/shortfile.txt", "/LINK.TXT")
+		if err := f.Link("/shortfile.txt", "/LINK.TXT"); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("error %v linking over an existing file instead of %v", err, fs.ErrExist)
+		}
+		if err := f.Link("/lost+found", "/LOST"); !errors.Is(err, filesystem.ErrIsDir) {
+			t.Errorf("error %v linking a directory instead of %v", err, filesystem.ErrIsDir)
+		}
+		if findings, err := f.Check(filesystem.CheckOptions{}); err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v after linking", findings, err)
+		}
+
+		// the contents stay with the other link
+		if err := f.Remove("/shortfile.txt"); err != nil {
+			t.Fatalf("error removing file: %v", err)
+		}
+		if b, err := f.ReadFile("LINK.TXT"); err != nil || !bytes.Equal(b, content) {
+			t.Errorf("read %q, %v through the remaining link instead of %q", b, err, content)
+		}
+		if findings, err := f.Check(filesystem.CheckOptions{}); err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v after removing a link", findings, err)
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		src, b := createSquashfs(t)
+		file, err := src.OpenFile("/ORIGINAL.TXT", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := file.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		_ = file.Close()
+		if err := src.Mkdir("/DIR"); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		if err := src.Link("/ORIGINAL.TXT", "/DIR/LINK.TXT"); err != nil {
+			t.Fatalf("error creating hard link: %v", err)
+		}
+		sameInode(t, src, "/ORIGINAL.TXT", "/DIR/LINK.TXT")
+		if err := src.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		sameInode(t, read, "/ORIGINAL.TXT", "/DIR/LINK.TXT")
+		if b, err := read.ReadFile("DIR/LINK.TXT"); err != nil || string(b) != "hello" {
+			t.Errorf("read %q, %v through the link instead of %q", b, err, "hello")
+		}
+		if err := read.Link("/ORIGINAL.TXT", "/OTHER.TXT"); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+			t.Errorf("error %v linking in an image instead of %v", err, filesystem.ErrReadOnlyFilesystem)
+		}
+
+		t.Run("copy", func(t *testing.T) {
+			dst, _ := createSquashfs(t)
+			if err := filesystem.CopyTree(context.Background(), read, "/", dst, "/COPY", filesystem.CopyOptions{}); err != nil {
+				t.Fatalf("error copying: %v", err)
+			}
+			sameInode(t, dst, "/COPY/ORIGINAL.TXT", "/COPY/DIR/LINK.TXT")
+			// copies where the destination has no hard links
+			fat := createFat32(t)
+			if err := filesystem.CopyTree(context.Background(), read, "/", fat, "/", filesystem.CopyOptions{}); err != nil {
+				t.Fatalf("error copying to FAT32: %v", err)
+			}
+			if b, err := fat.ReadFile("DIR/LINK.TXT"); err != nil || string(b) != "hello" {
+				t.Errorf("read %q, %v from the copy instead of %q", b, err, "hello")
+			}
+		})
+
+		t.Run("tar", func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := filesystem.ToTar(read, "/", &archive, filesystem.ToTarOptions{}); err != nil {
+				t.Fatalf("error writing archive: %v", err)
+			}
+			links := map[string]string{}
+			tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("error reading archive: %v", err)
+				}
+				if hdr.Typeflag == tar.TypeLink {
+					links[hdr.Name] = hdr.Linkname
+				}
+			}
+			// DIR/LINK.TXT comes first in the order of the walk
+			if len(links) != 1 || links["ORIGINAL.TXT"] != "DIR/LINK.TXT" {
+				t.Errorf("hard links %v in the archive", links)
+			}
+			dst, _ := createSquashfs(t)
+			if err := filesystem.FromTar(dst, bytes.NewReader(archive.Bytes()), filesystem.FromTarOptions{}); err != nil {
+				t.Fatalf("error importing archive: %v", err)
+			}
+			sameInode(t, dst, "/ORIGINAL.TXT", "/DIR/LINK.TXT")
+		})
+	})
+}
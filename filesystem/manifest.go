@@ -0,0 +1,236 @@
+package filesystem
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// ManifestOptions are the options of WriteManifest and VerifyManifest
+type ManifestOptions struct {
+	// Filter, if set, is called for every file and directory, with its name relative to the root
+	// in the form of io/fs, "." for the root itself. Files for which it returns false are not
+	// hashed, nor is anything inside skipped directories.
+	Filter func(name string, d fs.DirEntry) bool
+	// Progress, if set, is updated in util.PhaseHash as the contents of files are read, with the
+	// absolute path of each file. The total is the size of all the files to hash.
+	Progress util.Progress
+}
+
+// ManifestVerification is the outcome of VerifyManifest, with the names of the files sorted, in
+// the form of the manifest. The tree matches the manifest if all of them are empty.
+type ManifestVerification struct {
+	// Mismatched are the files listed whose contents have another hash
+	Mismatched []string `json:"mismatched"`
+	// Missing are the files listed that are not regular files of the tree
+	Missing []string `json:"missing"`
+	// Unlisted are the regular files of the tree that are not in the manifest
+	Unlisted []string `json:"unlisted"`
+}
+
+// OK returns whether the tree matches the manifest
+func (v *ManifestVerification) OK() bool {
+	return len(v.Mismatched) == 0 && len(v.Missing) == 0 && len(v.Unlisted) == 0
+}
+
+// WriteManifest writes the SHA-256 hashes of the contents of the regular files of the tree at
+// root to w, in the format of sha256sum and SHA256SUMS files: one line per file with the hash in
+// hexadecimal, two spaces and the name of the file relative to root, in the form of io/fs, sorted
+// by name. Images can then be signed and verified by their contents without extracting them. The
+// root is absolute or in the form of io/fs.
+//
+// Where the files of the filesystem are a LocatedFile, they are read in the order of their data
+// on the disk, which is much faster on rotating disks and with images of many small files.
+func WriteManifest(f FileSystem, root string, w io.Writer, opts ManifestOptions) error {
+	sums, err := hashTree(f, root, opts)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		if _, err := fmt.Fprintf(bw, "%s  %s\n", sums[name], name); err != nil {
+			return fmt.Errorf("error writing manifest: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
+
+// VerifyManifest checks the regular files of the tree at root against a manifest in the format
+// of WriteManifest, as written by sha256sum as well, including the "*" of binary mode before
+// names. It returns an error only if the manifest cannot be parsed or the tree cannot be read,
+// and otherwise what differs, see ManifestVerification. Files skipped by opts.Filter are not
+// reported as unlisted.
+func VerifyManifest(f FileSystem, root string, r io.Reader, opts ManifestOptions) (*ManifestVerification, error) {
+	expected := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(text, " ")
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		if b, err := hex.DecodeString(sum); !ok || err != nil || len(b) != sha256.Size || name == "" {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", line, text)
+		}
+		expected[path.Clean(strings.TrimPrefix(name, "./"))] = strings.ToLower(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	sums, err := hashTree(f, root, opts)
+	if err != nil {
+		return nil, err
+	}
+	v := &ManifestVerification{}
+	for name, sum := range expected {
+		actual, ok := sums[name]
+		switch {
+		case !ok:
+			v.Missing = append(v.Missing, name)
+		case actual != sum:
+			v.Mismatched = append(v.Mismatched, name)
+		}
+	}
+	for name := range sums {
+		if _, ok := expected[name]; !ok {
+			v.Unlisted = append(v.Unlisted, name)
+		}
+	}
+	slices.Sort(v.Mismatched)
+	slices.Sort(v.Missing)
+	slices.Sort(v.Unlisted)
+	return v, nil
+}
+
+// hashedFile is a regular file to hash, by its absolute path and its name in the manifest
+type hashedFile struct {
+	path, name string
+	size       int64
+	offset     int64
+}
+
+// hashTree returns the hashes in hexadecimal of the regular files of the tree at root, by their
+// names relative to root, reading them in the order of their data where it is known
+func hashTree(f FileSystem, root string, opts ManifestOptions) (map[string]string, error) {
+	root = path.Clean(AbsolutePath(root))
+	var (
+		files []hashedFile
+		total int64
+	)
+	err := WalkDir(f, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := relativeName(strings.TrimPrefix(p, root))
+		if opts.Filter != nil && !opts.Filter(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		if name == "." {
+			// the root is a file itself
+			name = path.Base(p)
+		}
+		files = append(files, hashedFile{path: p, name: name, size: info.Size(), offset: -1})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking %s: %w", root, err)
+	}
+	// the files whose data is not located are read last, in the order of their names
+	for i := range files {
+		file, err := f.OpenFile(files[i].path, os.O_RDONLY)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %w", files[i].path, err)
+		}
+		located, ok := file.(LocatedFile)
+		if ok {
+			files[i].offset = located.DataOffset()
+		}
+		_ = file.Close()
+		if !ok {
+			break
+		}
+	}
+	slices.SortStableFunc(files, func(a, b hashedFile) int {
+		switch {
+		case a.offset == b.offset:
+			return 0
+		case a.offset < 0:
+			return 1
+		case b.offset < 0:
+			return -1
+		}
+		return cmp.Compare(a.offset, b.offset)
+	})
+	sums := make(map[string]string, len(files))
+	buf := make([]byte, copyBufferSize)
+	var done int64
+	for _, hf := range files {
+		sum, err := hashContents(f, hf.path, buf, func(n int64) {
+			done += n
+			if opts.Progress != nil {
+				opts.Progress.Update(util.PhaseHash, done, total, hf.path)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		sums[hf.name] = sum
+	}
+	return sums, nil
+}
+
+// hashContents returns the SHA-256 hash in hexadecimal of the contents of the file at p, reading
+// it with buf and reporting the bytes read to progress
+func hashContents(f FileSystem, p string, buf []byte, progress func(n int64)) (string, error) {
+	file, err := f.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", p, err)
+	}
+	defer file.Close()
+	h := sha256.New()
+	for {
+		n, err := file.Read(buf)
+		h.Write(buf[:n])
+		progress(int64(n))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading %s: %w", p, err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,108 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestManifest(t *testing.T) {
+	for _, tt := range readFilesystems {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			// the files are hashed in the order of their data
+			var (
+				order   []string
+				offsets []int64
+			)
+			progress := func(phase string, _, _ int64, p string) {
+				if phase != util.PhaseHash {
+					t.Errorf("progress in phase %s", phase)
+				}
+				if len(order) > 0 && order[len(order)-1] == p {
+					return
+				}
+				order = append(order, p)
+				file, err := f.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("error opening %s: %v", p, err)
+				}
+				defer file.Close()
+				if located, ok := file.(filesystem.LocatedFile); ok {
+					offsets = append(offsets, located.DataOffset())
+				}
+			}
+			var b bytes.Buffer
+			if err := filesystem.WriteManifest(f, "/", &b, filesystem.ManifestOptions{Progress: util.ProgressFunc(progress)}); err != nil {
+				t.Fatalf("error writing manifest: %v", err)
+			}
+			contents, err := readAll(f)
+			if err != nil {
+				t.Fatalf("error reading files: %v", err)
+			}
+			paths := make([]string, 0, len(contents))
+			for p := range contents {
+				paths = append(paths, p)
+			}
+			slices.Sort(paths)
+			var expected strings.Builder
+			for _, p := range paths {
+				sum := sha256.Sum256(contents[p])
+				expected.WriteString(hex.EncodeToString(sum[:]) + "  " + strings.TrimPrefix(p, "/") + "\n")
+			}
+			if b.String() != expected.String() {
+				t.Errorf("manifest\n%s instead of\n%s", b.String(), expected.String())
+			}
+			if len(offsets) > 0 && !slices.IsSorted(slices.DeleteFunc(offsets, func(o int64) bool { return o < 0 })) {
+				t.Errorf("files %v read at offsets %v", order, offsets)
+			}
+
+			v, err := filesystem.VerifyManifest(f, "/", strings.NewReader(b.String()), filesystem.ManifestOptions{})
+			if err != nil || !v.OK() {
+				t.Errorf("verification %+v, %v of its own manifest", v, err)
+			}
+		})
+	}
+
+	t.Run("verify", func(t *testing.T) {
+		f := readFilesystems[0].fs(t)
+		var b bytes.Buffer
+		if err := filesystem.WriteManifest(f, "DIR1", &b, filesystem.ManifestOptions{}); err != nil {
+			t.Fatalf("error writing manifest: %v", err)
+		}
+		if !strings.Contains(b.String(), "  SUB/DEEP.BIN\n") {
+			t.Errorf("manifest without names relative to its root:\n%s", b.String())
+		}
+		if err := fstest.Write(f, fstest.Files{"DIR1/FILE1.TXT": "changed", "DIR1/NEW.TXT": "new"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Remove("/DIR1/SUB/SUBSUB/LEAF.X"); err != nil {
+			t.Fatal(err)
+		}
+		// as written by sha256sum in binary mode
+		manifest := strings.ReplaceAll(b.String(), "  ", " *")
+		v, err := filesystem.VerifyManifest(f, "DIR1", strings.NewReader(manifest), filesystem.ManifestOptions{})
+		if err != nil {
+			t.Fatalf("error verifying manifest: %v", err)
+		}
+		expected := filesystem.ManifestVerification{
+			Mismatched: []string{"FILE1.TXT"},
+			Missing:    []string{"SUB/SUBSUB/LEAF.X"},
+			Unlisted:   []string{"NEW.TXT"},
+		}
+		if !slices.Equal(v.Mismatched, expected.Mismatched) || !slices.Equal(v.Missing, expected.Missing) || !slices.Equal(v.Unlisted, expected.Unlisted) {
+			t.Errorf("verification %+v instead of %+v", v, expected)
+		}
+		if _, err := filesystem.VerifyManifest(f, "/", strings.NewReader("not a manifest\n"), filesystem.ManifestOptions{}); err == nil {
+			t.Error("no error verifying an invalid manifest")
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package filesystem_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+func TestMetadata(t *testing.T) {
+	src := createFat32(t)
+	if err := fstest.Write(src, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	c, ok := src.(filesystem.ChtimesFS)
+	if !ok {
+		t.Fatalf("FAT32 is not a ChtimesFS")
+	}
+	mtime := time.Date(2020, time.February, 3, 4, 5, 6, 0, time.UTC)
+	for _, name := range []string{"/DIR1/SUB", "/README.TXT"} {
+		if err := c.Chtimes(name, time.Time{}, mtime); err != nil {
+			t.Fatalf("error changing times of %s: %v", name, err)
+		}
+	}
+	if err := src.Chmod("/README.TXT", 0o444); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+	if err := src.Chown("/README.TXT", 0, 0); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("error %v changing owner on FAT32 instead of %v", err, filesystem.ErrNotSupported)
+	}
+	info, err := src.Stat("README.TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) || info.Mode() != 0o444 {
+		t.Errorf("time %v, mode %v instead of %v, %v", info.ModTime(), info.Mode(), mtime, fs.FileMode(0o444))
+	}
+
+	// kept by CopyTree
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := filesystem.CopyTree(context.Background(), src, "/", dst, "/", filesystem.CopyOptions{}); err != nil {
+		t.Fatalf("error copying: %v", err)
+	}
+	if err := dst.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	for name, mode := range map[string]fs.FileMode{"DIR1/SUB": fs.ModeDir | 0o755, "README.TXT": 0o444} {
+		info, err := read.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(mtime) || info.Mode() != mode {
+			t.Errorf("%s: time %v, mode %v instead of %v, %v", name, info.ModTime(), info.Mode(), mtime, mode)
+		}
+	}
+}
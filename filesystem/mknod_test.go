@@ -0,0 +1,168 @@
+package filesystem_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+var (
+	charMode = filesystem.UnixMode(fs.ModeDevice | fs.ModeCharDevice | 0o666)
+	fifoMode = filesystem.UnixMode(fs.ModeNamedPipe | 0o644)
+)
+
+// isDevice fails unless the named file is a device of mode type and numbers
+func isDevice(t *testing.T, f filesystem.FileSystem, name string, mode fs.FileMode, major, minor uint32) {
+	t.Helper()
+	info, err := filesystem.Lstat(f, name)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", name, err)
+	}
+	if info.Mode().Type() != mode {
+		t.Errorf("%s of type %s instead of %s", name, info.Mode().Type(), mode)
+	}
+	if maj, min, ok := filesystem.DeviceNumbers(info); !ok || maj != major || min != minor {
+		t.Errorf("%s has numbers %d:%d, %v instead of %d:%d", name, maj, min, ok, major, minor)
+	}
+}
+
+func TestMkdev(t *testing.T) {
+	for _, n := range [][2]uint32{{1, 3}, {8, 0}, {259, 300}, {4095, 1 << 19}} {
+		if major, minor := filesystem.SplitDev(filesystem.Mkdev(n[0], n[1])); major != n[0] || minor != n[1] {
+			t.Errorf("%d:%d encoded and decoded as %d:%d", n[0], n[1], major, minor)
+		}
+	}
+	if dev := filesystem.Mkdev(8, 1); dev != 0x801 {
+		t.Errorf("8:1 encoded as %#x instead of %#x", dev, 0x801)
+	}
+}
+
+func TestMknod(t *testing.T) {
+	t.Run("ext4", func(t *testing.T) {
+		f := createExt4(t)
+		if err := f.Mknod("/null", charMode, filesystem.Mkdev(1, 3)); err != nil {
+			t.Fatalf("error creating device: %v", err)
+		}
+		// beyond the old encoding of numbers
+		block := filesystem.UnixMode(fs.ModeDevice | 0o660)
+		if err := f.Mknod("/nvme0n1p300", block, filesystem.Mkdev(259, 300)); err != nil {
+			t.Fatalf("error creating device: %v", err)
+		}
+		if err := f.Mknod("/fifo", fifoMode, 0); err != nil {
+			t.Fatalf("error creating named pipe: %v", err)
+		}
+		isDevice(t, f, "/null", fs.ModeDevice|fs.ModeCharDevice, 1, 3)
+		isDevice(t, f, "/nvme0n1p300", fs.ModeDevice, 259, 300)
+		if info, err := f.Stat("fifo"); err != nil || info.Mode() != fs.ModeNamedPipe|0o644 {
+			t.Errorf("named pipe of mode %v, %v", info.Mode(), err)
+		}
+		if err := f.Mknod("/null", charMode, filesystem.Mkdev(1, 3)); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("error %v creating an existing device instead of %v", err, fs.ErrExist)
+		}
+		if findings, err := f.Check(filesystem.CheckOptions{}); err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v after creating devices", findings, err)
+		}
+		if err := f.Remove("/fifo"); err != nil {
+			t.Fatalf("error removing named pipe: %v", err)
+		}
+		if findings, err := f.Check(filesystem.CheckOptions{}); err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v after removing a named pipe", findings, err)
+		}
+
+		var archive bytes.Buffer
+		filter := func(name string, _ fs.DirEntry) bool { return name == "null" }
+		if err := filesystem.ToTar(f, "/", &archive, filesystem.ToTarOptions{Filter: filter}); err != nil {
+			t.Fatalf("error writing archive: %v", err)
+		}
+		hdr, err := tar.NewReader(&archive).Next()
+		if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeChar || hdr.Devmajor != 1 || hdr.Devminor != 3 || hdr.Mode != 0o666 {
+			t.Errorf("device in the archive of type %q, numbers %d:%d and mode %o", hdr.Typeflag, hdr.Devmajor, hdr.Devminor, hdr.Mode)
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		src, b := createSquashfs(t)
+		if err := src.Mknod("/fifo", fifoMode, 0); errors.Is(err, filesystem.ErrNotSupported) {
+			t.Skipf("no nodes in the workspace: %v", err)
+		} else if err != nil {
+			t.Fatalf("error creating named pipe: %v", err)
+		}
+		devices := true
+		if err := src.Mknod("/null", charMode, filesystem.Mkdev(1, 3)); errors.Is(err, fs.ErrPermission) {
+			// devices need privileges in the workspace
+			devices = false
+		} else if err != nil {
+			t.Fatalf("error creating device: %v", err)
+		}
+		if err := src.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		if info, err := read.Stat("fifo"); err != nil || info.Mode().Type() != fs.ModeNamedPipe {
+			t.Errorf("named pipe of type %v, %v", info.Mode().Type(), err)
+		}
+		if devices {
+			isDevice(t, read, "/null", fs.ModeDevice|fs.ModeCharDevice, 1, 3)
+		}
+		if err := read.Mknod("/zero", charMode, filesystem.Mkdev(1, 5)); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+			t.Errorf("error %v creating a device in an image instead of %v", err, filesystem.ErrReadOnlyFilesystem)
+		}
+
+		t.Run("copy", func(t *testing.T) {
+			dst := createExt4(t)
+			if err := filesystem.CopyTree(context.Background(), read, "/", dst, "/", filesystem.CopyOptions{}); err != nil {
+				t.Fatalf("error copying: %v", err)
+			}
+			if info, err := dst.Stat("fifo"); err != nil || info.Mode() != fs.ModeNamedPipe|0o644 {
+				t.Errorf("named pipe copied with mode %v, %v", info.Mode(), err)
+			}
+			if devices {
+				isDevice(t, dst, "/null", fs.ModeDevice|fs.ModeCharDevice, 1, 3)
+			}
+			// FAT32 has none
+			if err := filesystem.CopyTree(context.Background(), read, "/", createFat32(t), "/", filesystem.CopyOptions{}); !errors.Is(err, filesystem.ErrNotSupported) {
+				t.Errorf("error %v copying to FAT32 instead of %v", err, filesystem.ErrNotSupported)
+			}
+		})
+
+		t.Run("tar", func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := filesystem.ToTar(read, "/", &archive, filesystem.ToTarOptions{}); err != nil {
+				t.Fatalf("error writing archive: %v", err)
+			}
+			dst := createExt4(t)
+			if err := filesystem.FromTar(dst, bytes.NewReader(archive.Bytes()), filesystem.FromTarOptions{}); err != nil {
+				t.Fatalf("error importing archive: %v", err)
+			}
+			if devices {
+				isDevice(t, dst, "/null", fs.ModeDevice|fs.ModeCharDevice, 1, 3)
+			}
+			tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("error reading archive: %v", err)
+				}
+				if hdr.Name == "fifo" && hdr.Typeflag != tar.TypeFifo {
+					t.Errorf("named pipe in the archive of type %q", hdr.Typeflag)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,102 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+func TestReadAt(t *testing.T) {
+	const chunk = 700
+	for _, tt := range readFilesystems {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			expected, err := readAll(f)
+			if err != nil {
+				t.Fatalf("error reading files: %v", err)
+			}
+			for p, content := range expected {
+				file, err := f.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("%s: error opening file: %v", p, err)
+				}
+				// chunks read in parallel through the same file
+				actual := make([]byte, len(content))
+				var wg sync.WaitGroup
+				for off := 0; off < len(content); off += chunk {
+					wg.Add(1)
+					go func(off int) {
+						defer wg.Done()
+						b := actual[off:min(off+chunk, len(content))]
+						if n, err := file.ReadAt(b, int64(off)); n != len(b) || (err != nil && err != io.EOF) {
+							t.Errorf("%s: read %d bytes, %v at %d instead of %d", p, n, err, off, len(b))
+						}
+					}(off)
+				}
+				wg.Wait()
+				if !bytes.Equal(actual, content) {
+					t.Errorf("%s: read different contents in chunks", p)
+				}
+				if n, err := file.ReadAt(make([]byte, chunk), int64(len(content))); n != 0 || err != io.EOF {
+					t.Errorf("%s: read %d bytes, %v at the end instead of %v", p, n, err, io.EOF)
+				}
+				if len(content) > 10 {
+					if n, err := file.ReadAt(make([]byte, 20), int64(len(content)-10)); n != 10 || err != io.EOF {
+						t.Errorf("%s: read %d bytes, %v across the end instead of 10, %v", p, n, err, io.EOF)
+					}
+				}
+				// the offset of Read is left alone
+				b := make([]byte, min(len(content), chunk))
+				if n, err := io.ReadFull(file, b); n != len(b) || !bytes.Equal(b, content[:len(b)]) {
+					t.Errorf("%s: read %d bytes, %v from the start after ReadAt", p, n, err)
+				}
+				_ = file.Close()
+			}
+		})
+	}
+}
+
+func TestWriteAt(t *testing.T) {
+	f := createFat32(t)
+	file, err := f.OpenFile("/HELLO.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	for _, w := range []struct {
+		s   string
+		off int64
+	}{{"world", 6}, {"hello ", 0}, {"!", 11}} {
+		if n, err := file.WriteAt([]byte(w.s), w.off); n != len(w.s) || err != nil {
+			t.Fatalf("wrote %d bytes, %v instead of %d", n, err, len(w.s))
+		}
+	}
+	b, err := io.ReadAll(file)
+	if err != nil || string(b) != "hello world!" {
+		t.Errorf("read %q, %v instead of %q from the start", b, err, "hello world!")
+	}
+	_ = file.Close()
+
+	file, err = f.OpenFile("/HELLO.TXT", os.O_APPEND|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("x"), 0); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("error %v writing at an offset with O_APPEND instead of %v", err, fs.ErrInvalid)
+	}
+	_ = file.Close()
+
+	ro := readFilesystems[1].fs(t)
+	file, err = ro.OpenFile("/README.TXT", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	if _, err := file.WriteAt([]byte("x"), 0); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+		t.Errorf("error %v writing to squashfs instead of %v", err, filesystem.ErrReadOnlyFilesystem)
+	}
+}
@@ -0,0 +1,79 @@
+package filesystem_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+func TestRemoveRename(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+
+	for name, f := range map[string]filesystem.FileSystem{"fat32": createFat32(t), "workspace": workspace} {
+		t.Run(name, func(t *testing.T) {
+			if err := fstest.Write(f, fstest.Tree); err != nil {
+				t.Fatal(err)
+			}
+			var (
+				_ filesystem.RemoveFS = f
+				_ filesystem.RenameFS = f
+			)
+			renames := [][2]string{
+				{"/README.TXT", "/DIR2/README.TXT"},
+				{"/DIR1/SUB", "/DIR2/SUB"},
+				{"/DIR2/SUB/DEEP.BIN", "/DIR2/SUB/MOVED.BIN"},
+				// replaces the file
+				{"/DIR2/README.TXT", "/EMPTY.TXT"},
+			}
+			for _, r := range renames {
+				if err := f.Rename(r[0], r[1]); err != nil {
+					t.Fatalf("error renaming %s to %s: %v", r[0], r[1], err)
+				}
+			}
+			if err := f.Remove("/DIR1/FILE1.TXT"); err != nil {
+				t.Fatalf("error removing file: %v", err)
+			}
+			if err := filesystem.RemoveAll(f, "DIR3"); err != nil {
+				t.Fatalf("error removing tree: %v", err)
+			}
+			if err := filesystem.RemoveAll(f, "MISSING"); err != nil {
+				t.Errorf("error %v removing missing tree", err)
+			}
+			if err := f.Rename("/DIR2", "/DIR2/SUB/DIR2"); err == nil {
+				t.Errorf("directory moved into itself")
+			}
+
+			expected := fstest.Files{
+				"EMPTY.TXT":              fstest.Tree["README.TXT"],
+				"DIR1/":                  "",
+				"DIR2/SUB/MOVED.BIN":     fstest.Tree["DIR1/SUB/DEEP.BIN"],
+				"DIR2/SUB/SUBSUB/LEAF.X": fstest.Tree["DIR1/SUB/SUBSUB/LEAF.X"],
+			}
+			if err := fstest.TestFS(f, expected.Names()...); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range expected {
+				if b, err := f.ReadFile(name); content != "" && (err != nil || string(b) != content) {
+					t.Errorf("%s: read %d bytes, %v instead of %d", name, len(b), err, len(content))
+				}
+			}
+			for _, name := range []string{"README.TXT", "DIR1/SUB", "DIR1/FILE1.TXT", "DIR2/README.TXT", "DIR3"} {
+				if _, err := f.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s: error %v instead of %v", name, err, fs.ErrNotExist)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// section is the writable backend of a filesystem within a larger one, see Section
+type section struct {
+	backend.WritableFile
+	start, size int64
+}
+
+// Section returns the part of size bytes at start of w, for the filesystems that write all of an
+// image at once, such as squashfs and ISO9660 when they are finalized: offsets are relative to
+// start, and writes beyond size fail with an error that is ErrNoSpace instead of overrunning
+// what follows, e.g. the next partition. A size of 0 has no end. Its other methods, such as Seek,
+// are those of w.
+func Section(w backend.WritableFile, start, size int64) backend.WritableFile {
+	return &section{WritableFile: w, start: start, size: size}
+}
+
+// ReadAt reads at the offset in the section
+func (s *section) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("cannot read at offset %d before the start of the filesystem", off)
+	}
+	return s.WritableFile.ReadAt(b, s.start+off)
+}
+
+// WriteAt writes at the offset in the section, with nothing written if it goes beyond its end
+func (s *section) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("cannot write at offset %d before the start of the filesystem", off)
+	}
+	if s.size > 0 && off+int64(len(b)) > s.size {
+		return 0, fmt.Errorf("cannot write %d bytes at %d, beyond the end of the filesystem of %d bytes: %w", len(b), off, s.size, ErrNoSpace)
+	}
+	return s.WritableFile.WriteAt(b, s.start+off)
+}
+
+// SectionStorage returns the part of size bytes at start of b as a read-only backend, for the
+// filesystems whose locations are from their start, such as squashfs and ISO9660. A size of 0
+// goes to the end of b.
+func SectionStorage(b backend.Storage, start, size int64) backend.Storage {
+	if size <= 0 {
+		size = math.MaxInt64 - start
+	}
+	return backend.FromReaderAt(io.NewSectionReader(b, start, size), size)
+}
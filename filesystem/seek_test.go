@@ -0,0 +1,124 @@
+package filesystem_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/ext4"
+)
+
+func TestSeek(t *testing.T) {
+	for _, tt := range readFilesystems {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			expected, err := readAll(f)
+			if err != nil {
+				t.Fatalf("error reading files: %v", err)
+			}
+			for p, content := range expected {
+				file, err := f.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("%s: error opening file: %v", p, err)
+				}
+				if offset, err := file.Seek(0, io.SeekEnd); offset != int64(len(content)) || err != nil {
+					t.Errorf("%s: end at %d, %v instead of %d", p, offset, err, len(content))
+				}
+				if n, err := file.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+					t.Errorf("%s: read %d bytes, %v at the end instead of %v", p, n, err, io.EOF)
+				}
+				tail := min(len(content), 5)
+				if _, err := file.Seek(-int64(tail), io.SeekEnd); err != nil {
+					t.Errorf("%s: error seeking from the end: %v", p, err)
+				}
+				if b, err := io.ReadAll(file); err != nil || string(b) != string(content[len(content)-tail:]) {
+					t.Errorf("%s: read %q, %v instead of %q from the end", p, b, err, content[len(content)-tail:])
+				}
+				if _, err := file.Seek(-int64(len(content))-1, io.SeekEnd); err == nil {
+					t.Errorf("%s: no error seeking before the start", p)
+				}
+				if _, err := file.Seek(0, 42); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("%s: error %v seeking with an invalid whence instead of %v", p, err, fs.ErrInvalid)
+				}
+				_ = file.Close()
+			}
+		})
+	}
+}
+
+// createExt4 returns a writable copy of the ext4 test image
+func createExt4(t *testing.T) *ext4.FileSystem {
+	t.Helper()
+	img := filepath.Join(t.TempDir(), "ext4.img")
+	content, err := os.ReadFile("ext4/testdata/dist/ext4.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(img, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := file.OpenFromPath(img, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	f, err := ext4.Read(b, 100*1024*1024, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	return f
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   func(t *testing.T) filesystem.FileSystem
+	}{
+		{"fat32", createFat32},
+		{"ext4", func(t *testing.T) filesystem.FileSystem { return createExt4(t) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			file, err := f.OpenFile("/LOG.TXT", os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := file.Write([]byte("hello")); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+			_ = file.Close()
+
+			file, err = f.OpenFile("/LOG.TXT", os.O_WRONLY|os.O_APPEND)
+			if err != nil {
+				t.Fatalf("error opening file: %v", err)
+			}
+			// writes go to the end wherever the offset is
+			for _, s := range []string{" world", "!"} {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					t.Fatalf("error seeking: %v", err)
+				}
+				if n, err := file.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("wrote %d bytes, %v instead of %d", n, err, len(s))
+				}
+			}
+			if offset, err := file.Seek(0, io.SeekCurrent); offset != 12 || err != nil {
+				t.Errorf("offset %d, %v after appending instead of 12", offset, err)
+			}
+			_ = file.Close()
+
+			file, err = f.OpenFile("/LOG.TXT", os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("error opening file: %v", err)
+			}
+			if b, err := io.ReadAll(file); err != nil || string(b) != "hello world!" {
+				t.Errorf("read %q, %v instead of %q", b, err, "hello world!")
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package filesystem
+
+// SpaceFS is implemented by the writable filesystems that know their capacity, like statfs
+type SpaceFS interface {
+	// Space returns the capacity of the filesystem for the contents of files and directories, and
+	// how much of it is still free, in bytes. Writes that need more than the free space fail
+	// with an error that is ErrNoSpace.
+	Space() (total, free int64, err error)
+}
+
+// GrowFS is implemented by the filesystems that can grow into the space after their end, e.g. once
+// their partition is expanded, see disk.ExpandPartition
+type GrowFS interface {
+	// Grow grows the filesystem to size bytes from its start, which must be no less than its size
+	Grow(size int64) error
+}
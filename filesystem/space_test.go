@@ -0,0 +1,159 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// partitioned returns a backend of twice the size, for a filesystem of size bytes at start, and a
+// function returning whether what comes after the filesystem is still zero
+func partitioned(t *testing.T, start int64) (b *mem.Storage, untouched func() bool) {
+	t.Helper()
+	b, err := mem.Create(start + 2*size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, func() bool {
+		after := make([]byte, size)
+		if _, err := b.ReadAt(after, start+size); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		before := make([]byte, start)
+		if _, err := b.ReadAt(before, 0); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Count(after, []byte{0}) == len(after) && bytes.Count(before, []byte{0}) == len(before)
+	}
+}
+
+func TestSpace(t *testing.T) {
+	t.Run("fat32", func(t *testing.T) {
+		const start = 1024 * 1024
+		b, untouched := partitioned(t, start)
+		f, err := fat32.Create(b, size, start, 512, "space")
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		total, free, err := f.Space()
+		if err != nil || total <= 0 || total >= size || free <= 0 || free > total {
+			t.Fatalf("space of %d bytes, %d free, %v", total, free, err)
+		}
+		// filled until nothing is left of the data region
+		file, err := f.OpenFile("/FULL.BIN", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := file.Write(make([]byte, free)); err != nil {
+			t.Fatalf("error writing the free space: %v", err)
+		}
+		if _, free, err := f.Space(); free != 0 || err != nil {
+			t.Errorf("%d bytes, %v free after filling the filesystem", free, err)
+		}
+		if _, err := file.Write([]byte{1}); !errors.Is(err, filesystem.ErrNoSpace) {
+			t.Errorf("error %v writing to a full filesystem instead of %v", err, filesystem.ErrNoSpace)
+		}
+		if !untouched() {
+			t.Error("filesystem wrote beyond its size")
+		}
+		findings, err := filesystem.Check(f, filesystem.CheckOptions{})
+		if err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v of a full filesystem", findings, err)
+		}
+	})
+
+	t.Run("iso9660", func(t *testing.T) {
+		b, untouched := partitioned(t, 0)
+		f, err := iso9660.Create(b, size, 0, 2048, "")
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if total, free, err := f.Space(); err != nil || total != size || free <= 0 || free >= size {
+			t.Fatalf("space of %d bytes, %d free, %v", total, free, err)
+		}
+		file, err := f.OpenFile("/LARGE.BIN", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := file.Write(make([]byte, size)); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		_ = file.Close()
+		if _, free, err := f.Space(); free != 0 || err != nil {
+			t.Errorf("%d bytes, %v free after writing a file larger than the filesystem", free, err)
+		}
+		if err := f.Finalize(iso9660.FinalizeOptions{}); !errors.Is(err, filesystem.ErrNoSpace) {
+			t.Errorf("error %v finalizing instead of %v", err, filesystem.ErrNoSpace)
+		}
+		if !untouched() {
+			t.Error("filesystem wrote beyond its size")
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		const start = 1024 * 1024
+		b, untouched := partitioned(t, start)
+		f, err := squashfs.Create(b, size, start, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		file, err := f.OpenFile("/RANDOM.BIN", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		// random contents do not compress
+		if _, err := io.CopyN(file, rand.Reader, size); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		_ = file.Close()
+		if err := f.Finalize(squashfs.FinalizeOptions{}); !errors.Is(err, filesystem.ErrNoSpace) {
+			t.Errorf("error %v finalizing instead of %v", err, filesystem.ErrNoSpace)
+		}
+		if !untouched() {
+			t.Error("filesystem wrote beyond its size")
+		}
+	})
+
+	t.Run("squashfs in a partition", func(t *testing.T) {
+		const start = 1024 * 1024
+		b, untouched := partitioned(t, start)
+		f, err := squashfs.Create(b, size, start, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		file, err := f.OpenFile("/HELLO.TXT", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := file.Write([]byte("hello")); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		_ = file.Close()
+		if err := f.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		if !untouched() {
+			t.Error("filesystem wrote outside of its partition")
+		}
+		f, err = squashfs.Read(b, size, start, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		file, err = f.OpenFile("/HELLO.TXT", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening file: %v", err)
+		}
+		if b, err := io.ReadAll(file); err != nil || string(b) != "hello" {
+			t.Errorf("read %q, %v instead of %q", b, err, "hello")
+		}
+	})
+}
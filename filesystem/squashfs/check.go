@@ -0,0 +1,69 @@
+package squashfs
+
+import (
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// codes of the findings of Check, beyond those of filesystem.GenericCheck
+const (
+	// CodeTruncated is a filesystem whose bytes used go beyond the end of the image
+	CodeTruncated = "squashfs-truncated"
+	// CodeTableInvalid is a table of the superblock that starts outside of the bytes used
+	CodeTableInvalid = "squashfs-table-invalid"
+	// CodeFragmentInvalid is a fragment block that goes beyond the bytes used
+	CodeFragmentInvalid = "squashfs-fragment-invalid"
+)
+
+// noTable is the start of the tables missing from the superblock
+const noTable = 0xffff_ffff_ffff_ffff
+
+// Check checks that the superblock, its tables and the fragment blocks are within the image,
+// after the checks of filesystem.GenericCheck, for filesystem.Checker. A workspace not yet
+// finalized has only the checks of GenericCheck.
+func (fs *FileSystem) Check(opts filesystem.CheckOptions) ([]filesystem.Finding, error) {
+	findings, err := filesystem.GenericCheck(fs, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := fs.superblock
+	if s == nil {
+		return findings, nil
+	}
+	add := func(code, format string, args ...any) {
+		findings = append(findings, filesystem.Finding{Severity: filesystem.SeverityError, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+	if fs.size > 0 && s.size > uint64(fs.size) {
+		add(CodeTruncated, "%d bytes used in an image of %d bytes", s.size, fs.size)
+	}
+	tables := []struct {
+		name  string
+		start uint64
+	}{
+		{"inode", s.inodeTableStart},
+		{"directory", s.directoryTableStart},
+		{"fragment", s.fragmentTableStart},
+		{"export", s.exportTableStart},
+		{"id", s.idTableStart},
+		{"xattr", s.xattrTableStart},
+	}
+	for _, t := range tables {
+		if t.start != noTable && (t.start < superblockSize || t.start >= s.size) {
+			add(CodeTableInvalid, "%s table at %d, outside of the %d bytes used", t.name, t.start, s.size)
+		}
+	}
+	if s.inodeTableStart >= s.directoryTableStart {
+		add(CodeTableInvalid, "inode table at %d is not before the directory table at %d", s.inodeTableStart, s.directoryTableStart)
+	}
+	fragments, err := fs.fragmentTable()
+	if err != nil {
+		add(CodeTableInvalid, "%v", err)
+	}
+	for i, f := range fragments {
+		if f.start+uint64(f.size) > s.size {
+			add(CodeFragmentInvalid, "fragment block %d of %d bytes at %d, beyond the %d bytes used", i, f.size, f.start, s.size)
+		}
+	}
+	return findings, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/klauspost/compress/zstd"
 	lz4 "github.com/pierrec/lz4/v4"
@@ -30,7 +31,7 @@ func (c *CompressorLzma) compress(in []byte) ([]byte, error) {
 	var b bytes.Buffer
 	lz, err := lzma.NewWriter(&b)
 	if err != nil {
-		return nil, fmt.Errorf("error creating lzma compressor: %v", err)
+		return nil, fmt.Errorf("error creating lzma compressor: %w", err)
 	}
 	if _, err := lz.Write(in); err != nil {
 		return nil, err
@@ -41,14 +42,17 @@ func (c *CompressorLzma) compress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 func (c *CompressorLzma) decompress(in []byte) ([]byte, error) {
+	return c.decompressTo(nil, in)
+}
+func (c *CompressorLzma) decompressTo(dst, in []byte) ([]byte, error) {
 	b := bytes.NewReader(in)
 	lz, err := lzma.NewReader(b)
 	if err != nil {
-		return nil, fmt.Errorf("error creating lzma decompressor: %v", err)
+		return nil, fmt.Errorf("error creating lzma decompressor: %w", err)
 	}
-	p, err := io.ReadAll(lz)
+	p, err := readAllTo(lz, dst)
 	if err != nil {
-		return nil, fmt.Errorf("error decompressing: %v", err)
+		return nil, fmt.Errorf("error decompressing: %w", err)
 	}
 	return p, nil
 }
@@ -87,7 +91,7 @@ func (c *CompressorGzip) compress(in []byte) ([]byte, error) {
 	var b bytes.Buffer
 	gz, err := zlib.NewWriterLevel(&b, int(c.CompressionLevel))
 	if err != nil {
-		return nil, fmt.Errorf("error creating gzip compressor: %v", err)
+		return nil, fmt.Errorf("error creating gzip compressor: %w", err)
 	}
 	if _, err := gz.Write(in); err != nil {
 		return nil, err
@@ -101,18 +105,35 @@ func (c *CompressorGzip) compress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 func (c *CompressorGzip) decompress(in []byte) ([]byte, error) {
+	return c.decompressTo(nil, in)
+}
+
+// zlibReaders are the zlib decompressors reused by the reads of all filesystems
+var zlibReaders sync.Pool
+
+func (c *CompressorGzip) decompressTo(dst, in []byte) ([]byte, error) {
 	b := bytes.NewReader(in)
-	gz, err := zlib.NewReader(b)
+	var (
+		gz  io.ReadCloser
+		err error
+	)
+	if r, ok := zlibReaders.Get().(io.ReadCloser); ok {
+		gz = r
+		err = r.(zlib.Resetter).Reset(b, nil)
+	} else {
+		gz, err = zlib.NewReader(b)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error creating gzip decompressor: %v", err)
+		return nil, fmt.Errorf("error creating gzip decompressor: %w", err)
 	}
-	p, err := io.ReadAll(gz)
+	p, err := readAllTo(gz, dst)
 	if err != nil {
-		return nil, fmt.Errorf("error decompressing: %v", err)
+		return nil, fmt.Errorf("error decompressing: %w", err)
 	}
 	if err := gz.Close(); err != nil {
 		return nil, err
 	}
+	zlibReaders.Put(gz)
 	return p, nil
 }
 
@@ -176,7 +197,7 @@ func (c *CompressorXz) compress(in []byte) ([]byte, error) {
 	}
 	xzWriter, err := config.NewWriter(&b)
 	if err != nil {
-		return nil, fmt.Errorf("error creating xz compressor: %v", err)
+		return nil, fmt.Errorf("error creating xz compressor: %w", err)
 	}
 	if _, err := xzWriter.Write(in); err != nil {
 		return nil, err
@@ -187,14 +208,17 @@ func (c *CompressorXz) compress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 func (c *CompressorXz) decompress(in []byte) ([]byte, error) {
+	return c.decompressTo(nil, in)
+}
+func (c *CompressorXz) decompressTo(dst, in []byte) ([]byte, error) {
 	b := bytes.NewReader(in)
 	xzReader, err := xz.NewReader(b)
 	if err != nil {
-		return nil, fmt.Errorf("error creating xz decompressor: %v", err)
+		return nil, fmt.Errorf("error creating xz decompressor: %w", err)
 	}
-	p, err := io.ReadAll(xzReader)
+	p, err := readAllTo(xzReader, dst)
 	if err != nil {
-		return nil, fmt.Errorf("error decompressing: %v", err)
+		return nil, fmt.Errorf("error decompressing: %w", err)
 	}
 	return p, nil
 }
@@ -258,11 +282,14 @@ func (c *CompressorLz4) compress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 func (c *CompressorLz4) decompress(in []byte) ([]byte, error) {
+	return c.decompressTo(nil, in)
+}
+func (c *CompressorLz4) decompressTo(dst, in []byte) ([]byte, error) {
 	b := bytes.NewReader(in)
 	lz := lz4.NewReader(b)
-	p, err := io.ReadAll(lz)
+	p, err := readAllTo(lz, dst)
 	if err != nil {
-		return nil, fmt.Errorf("error decompressing: %v", err)
+		return nil, fmt.Errorf("error decompressing: %w", err)
 	}
 	return p, nil
 }
@@ -347,18 +374,69 @@ func (c *CompressorZstd) compress(in []byte) ([]byte, error) {
 	return b.Bytes(), nil
 }
 func (c *CompressorZstd) decompress(in []byte) ([]byte, error) {
-	z, err := zstd.NewReader(nil)
+	return c.decompressTo(nil, in)
+}
+
+// zstdDecoder is the zstd decompressor shared by the reads of all filesystems, as DecodeAll may be
+// called concurrently
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+})
+
+func (c *CompressorZstd) decompressTo(dst, in []byte) ([]byte, error) {
+	z, err := zstdDecoder()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create zstd decompressor: %w", err)
 	}
-	defer z.Close()
-	p, err := z.DecodeAll(in, nil)
+	p, err := z.DecodeAll(in, dst[:0])
 	if err != nil {
 		return nil, fmt.Errorf("error decompressing zstd: %w", err)
 	}
 	return p, nil
 }
 
+// decompressorTo is implemented by the compressors that can decompress into a buffer
+type decompressorTo interface {
+	// decompressTo decompresses in into dst, or a larger buffer if it does not fit
+	decompressTo(dst, in []byte) ([]byte, error)
+}
+
+// decompressTo decompresses in with c into dst if c can, so that dst may come from a pool
+func decompressTo(c Compressor, dst, in []byte) ([]byte, error) {
+	if d, ok := c.(decompressorTo); ok {
+		return d.decompressTo(dst, in)
+	}
+	return c.decompress(in)
+}
+
+// readAllTo reads r to its end into dst, like io.ReadAll, growing it only if what is read does not
+// fit
+func readAllTo(r io.Reader, dst []byte) ([]byte, error) {
+	b := dst[:0]
+	for {
+		if len(b) == cap(b) {
+			// full: check if there is more before growing it
+			var probe [1]byte
+			n, err := io.ReadFull(r, probe[:])
+			if n == 0 {
+				if err == io.EOF {
+					return b, nil
+				}
+				return b, err
+			}
+			b = append(b, probe[0])
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			return b, err
+		}
+	}
+}
+
 func newCompressor(flavour compression) (Compressor, error) {
 	var c Compressor
 	switch flavour {
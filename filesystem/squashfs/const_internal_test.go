@@ -228,7 +228,7 @@ func testGetFilesystemRoot() []*directoryEntry {
 		{isSubdirectory: false, name: "goodlink", size: 0, modTime: modTime, mode: 0o777},
 		{isSubdirectory: false, name: "hardlink", size: 7, modTime: modTime, mode: 0o644, uid: 1, gid: 2},
 		{isSubdirectory: false, name: "README.md", size: 7, modTime: modTime, mode: 0o644, uid: 1, gid: 2},
-		{isSubdirectory: false, name: "attrfile", size: 5, modTime: modTime, mode: 0o644, xattrs: map[string]string{"abc": "def", "myattr": "hello"}},
+		{isSubdirectory: false, name: "attrfile", size: 5, modTime: modTime, mode: 0o644, xattrs: map[string]string{"user.abc": "def", "user.myattr": "hello"}},
 	}
 }
 
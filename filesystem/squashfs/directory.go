@@ -3,6 +3,7 @@ package squashfs
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 const (
@@ -17,8 +18,7 @@ const (
 // directory may be composed of one or more of these "directory", depending
 // on how many headers it requires
 type directory struct {
-	inodeIndex uint32
-	entries    []*directoryEntryRaw
+	entries []*directoryEntryRaw
 }
 
 type directoryEntryRaw struct {
@@ -58,7 +58,7 @@ func parseDirectory(b []byte) (*directory, error) {
 	for pos := 0; pos+dirHeaderSize <= len(b); {
 		directoryHeader, err := parseDirectoryHeader(b[pos:])
 		if err != nil {
-			return nil, fmt.Errorf("could not parse directory header: %v", err)
+			return nil, fmt.Errorf("could not parse directory header: %w", err)
 		}
 		if directoryHeader.count == 0 {
 			return nil, fmt.Errorf("corrupted directory, must have at least one entry")
@@ -70,7 +70,7 @@ func parseDirectory(b []byte) (*directory, error) {
 		for count := uint32(0); count < directoryHeader.count; count++ {
 			entry, size, err := parseDirectoryEntry(b[pos:], directoryHeader.inode)
 			if err != nil {
-				return nil, fmt.Errorf("unable to parse entry at position %d: %v", pos, err)
+				return nil, fmt.Errorf("unable to parse entry at position %d: %w", pos, err)
 			}
 			entry.startBlock = directoryHeader.startBlock
 			entries = append(entries, entry)
@@ -84,36 +84,56 @@ func parseDirectory(b []byte) (*directory, error) {
 	}, nil
 }
 
-func (d *directory) toBytes(in uint32) []byte {
-	// need to group these into chunks that would share a header
+func (d *directory) toBytes() []byte {
+	var b []byte
+	for _, group := range d.groups(0) {
+		b = append(b, group.toBytes()...)
+	}
+	return b
+}
+
+// groups splits the entries into chunks that share a header, as they are laid out in the directory
+// table from the offset into a metadata block
+func (d *directory) groups(offset int) []*directoryEntryGroup {
 	var (
-		b      []byte
 		groups []*directoryEntryGroup
 		group  *directoryEntryGroup
+		// the metadata block of the header of the group, counted from that of the offset
+		groupBlock int
 	)
+	pos := offset
 	for _, e := range d.entries {
 		// we need a new header if one of the following:
 		// - we don't have one yet
+		// - it has the maximum number of entries
 		// - inode block changes
-		// - inode offset > +/- 32k from the inode in the header,
-		if group == nil || group.header.startBlock != e.startBlock {
+		// - inode number is not within +/- 32k of the one in the header
+		// - the entry starts in the next metadata block, so that the directory can be indexed by
+		//   the header at the start of each of its blocks
+		if group == nil || group.header.count == maxDirEntries || group.header.startBlock != e.startBlock ||
+			int64(e.inodeNumber)-int64(group.header.inode) < math.MinInt16 || int64(e.inodeNumber)-int64(group.header.inode) > math.MaxInt16 ||
+			pos/int(metadataBlockSize) != groupBlock {
 			group = &directoryEntryGroup{
 				header: &directoryHeader{
 					startBlock: e.startBlock,
-					inode:      in,
+					inode:      e.inodeNumber,
 				},
 			}
 			groups = append(groups, group)
+			groupBlock = pos / int(metadataBlockSize)
+			pos += dirHeaderSize
 		}
 		group.header.count++
 		group.entries = append(group.entries, e)
+		pos += dirEntryMinSize + len(e.name)
 	}
-	// now convert all of the chunks to bytes
-	for _, group := range groups {
-		b = append(b, group.header.toBytes()...)
-		for _, e := range group.entries {
-			b = append(b, e.toBytes(in)...)
-		}
+	return groups
+}
+
+func (g *directoryEntryGroup) toBytes() []byte {
+	b := g.header.toBytes()
+	for _, e := range g.entries {
+		b = append(b, e.toBytes(g.header.inode)...)
 	}
 	return b
 }
@@ -165,7 +185,8 @@ func parseDirectoryEntry(b []byte, in uint32) (*directoryEntryRaw, int, error) {
 	}
 
 	offset := binary.LittleEndian.Uint16(b[0:2])
-	inode := uint32(binary.LittleEndian.Uint16(b[2:4])) + in
+	// the inode number is a signed offset from the one of the header
+	inode := in + uint32(int16(binary.LittleEndian.Uint16(b[2:4])))
 	entryType := binary.LittleEndian.Uint16(b[4:6])
 	nameSize := binary.LittleEndian.Uint16(b[6:8])
 	realNameSize := nameSize + 1
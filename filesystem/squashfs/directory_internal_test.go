@@ -137,7 +137,7 @@ func TestParseDirectory(t *testing.T) {
 }
 
 func TestDirectoryToBytes(t *testing.T) {
-	b := testDirectory.toBytes(1)
+	b := testDirectory.toBytes()
 	if !bytes.Equal(b, testDirectoryTable) {
 		t.Errorf("mismatched bytes, actual then expected")
 		t.Logf("% x", b)
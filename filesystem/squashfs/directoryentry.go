@@ -84,25 +84,7 @@ func (d *directoryEntry) Mode() os.FileMode {
 	if d.inode == nil {
 		return mode
 	}
-	switch d.inode.inodeType() {
-	case inodeBasicDirectory, inodeExtendedDirectory:
-		mode |= os.ModeDir // d: is a directory
-	case inodeBasicFile, inodeExtendedFile:
-		// zero mode
-	case inodeBasicSymlink, inodeExtendedSymlink:
-		mode |= os.ModeSymlink // L: symbolic link
-	case inodeBasicBlock, inodeExtendedBlock:
-		mode |= os.ModeDevice // D: device file
-	case inodeBasicChar, inodeExtendedChar:
-		mode |= os.ModeDevice     // D: device file
-		mode |= os.ModeCharDevice // c: Unix character device, when ModeDevice is set
-	case inodeBasicFifo, inodeExtendedFifo:
-		mode |= os.ModeNamedPipe // p: named pipe (FIFO)
-	case inodeBasicSocket, inodeExtendedSocket:
-		mode |= os.ModeSocket // S: Unix domain socket
-	default:
-		mode |= os.ModeIrregular // ?: non-regular file; nothing else is known about this file
-	}
+	mode |= d.inode.inodeType().fileMode()
 
 	// Not currently translated
 	// mode |= os.ModeAppend          // a: append-only
@@ -130,6 +112,28 @@ func (d *directoryEntry) GID() uint32 {
 	return d.gid
 }
 
+// Major get the major number of a device file, or 0 for other files, for filesystem.Device
+func (d *directoryEntry) Major() uint32 {
+	major, _ := d.deviceNumbers()
+	return major
+}
+
+// Minor get the minor number of a device file, or 0 for other files, for filesystem.Device
+func (d *directoryEntry) Minor() uint32 {
+	_, minor := d.deviceNumbers()
+	return minor
+}
+
+func (d *directoryEntry) deviceNumbers() (major, minor uint32) {
+	if d.inode == nil {
+		return 0, 0
+	}
+	if dev, ok := d.inode.getBody().(interface{ numbers() (uint32, uint32) }); ok {
+		return dev.numbers()
+	}
+	return 0, 0
+}
+
 // Xattrs get extended attributes of file
 func (d *directoryEntry) Xattrs() map[string]string {
 	return d.xattrs
@@ -197,3 +201,57 @@ func (d *directoryEntry) Open() (filesystem.File, error) {
 		filesystem:   d.fs,
 	}, nil
 }
+
+// lazyDirEntry is a fs.DirEntry for WalkDir, made from the entry in the directory table, which
+// reads the inode only when it is needed, for Info or the entries of a subdirectory
+type lazyDirEntry struct {
+	fs   *FileSystem
+	raw  *directoryEntryRaw
+	name string
+	typ  fs.FileMode
+	// info and in are read from raw when first needed, and set already for the root of a walk
+	info fs.FileInfo
+	in   inode
+}
+
+func newLazyDirEntry(fs *FileSystem, raw *directoryEntryRaw) *lazyDirEntry {
+	return &lazyDirEntry{fs: fs, raw: raw, name: raw.name, typ: raw.inodeType.fileMode()}
+}
+
+// Name returns the name of the file
+func (e *lazyDirEntry) Name() string {
+	return e.name
+}
+
+// IsDir reports whether the entry describes a directory
+func (e *lazyDirEntry) IsDir() bool {
+	return e.typ.IsDir()
+}
+
+// Type returns the type bits of the mode, known without reading the inode
+func (e *lazyDirEntry) Type() fs.FileMode {
+	return e.typ
+}
+
+// Info reads the inode of the entry
+func (e *lazyDirEntry) Info() (fs.FileInfo, error) {
+	if e.info == nil {
+		entry, err := e.fs.hydrateDirectoryEntry(e.raw)
+		if err != nil {
+			return nil, err
+		}
+		e.info, e.in = entry, entry.inode
+	}
+	return e.info, nil
+}
+
+func (e *lazyDirEntry) inode() (inode, error) {
+	if e.in == nil {
+		in, err := e.fs.getInode(e.raw.startBlock, e.raw.offset, e.raw.inodeType)
+		if err != nil {
+			return nil, fmt.Errorf("error finding inode for %s: %w", e.name, err)
+		}
+		e.in = in
+	}
+	return e.in, nil
+}
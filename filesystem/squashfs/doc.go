@@ -1,4 +1,8 @@
-// Package squashfs provides support for reading and creating squashfs filesystems
+// Package squashfs provides support for reading and creating squashfs filesystems.
+//
+// Its reads are safe for concurrent use, see the concurrency of package filesystem, including
+// those sharing the cache of decompressed blocks.
+//
 // references:
 //
 //	https://www.kernel.org/doc/Documentation/filesystems/squashfs.txt
@@ -3,6 +3,7 @@ package squashfs
 import (
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"os"
 
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -32,6 +33,36 @@ func (fl *File) Read(b []byte) (int, error) {
 	if fl == nil || fl.filesystem == nil {
 		return 0, os.ErrClosed
 	}
+	n, err := fl.readAt(b, fl.offset, true)
+	fl.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the File starting at byte offset off, without changing the
+// offset of Read and Seek, for io.ReaderAt. It returns io.EOF with fewer bytes when the file
+// ends. Unlike Read, it does not keep the last block decompressed, so that it is safe for
+// concurrent use.
+func (fl *File) ReadAt(b []byte, off int64) (int, error) {
+	if fl == nil || fl.filesystem == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("cannot read at offset %d before start of file", off)
+	}
+	var read int
+	for read < len(b) {
+		n, err := fl.readAt(b[read:], off+int64(read), false)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// readAt reads up to len(b) bytes from offset, returning io.EOF if it reaches the end of the
+// file. With cacheLast, the last block decompressed is kept in the File for the next read.
+func (fl *File) readAt(b []byte, offset int64, cacheLast bool) (int, error) {
 	// squashfs files are *mostly* contiguous, we only need the starting location and size for whole blocks
 	// if there are fragments, we need the location of those as well
 
@@ -46,7 +77,7 @@ func (fl *File) Read(b []byte) (int, error) {
 	//      e.g. if starting block is at position 10245, then we want blocks 27,28,29 from the disk
 	// 5- read in and uncompress the necessary blocks
 	fs := fl.filesystem
-	size := fl.size() - fl.offset
+	size := fl.size() - offset
 	location := int64(fl.blocksStart)
 	maxRead := len(b)
 
@@ -54,6 +85,9 @@ func (fl *File) Read(b []byte) (int, error) {
 	if size <= 0 {
 		return 0, io.EOF
 	}
+	if maxRead == 0 {
+		return 0, nil
+	}
 
 	// we stop when we hit the lesser of
 	//   1- len(b)
@@ -64,8 +98,8 @@ func (fl *File) Read(b []byte) (int, error) {
 
 	// just read the requested number of bytes and change our offset
 	// figure out which block number has the bytes we are looking for
-	startBlock := int(fl.offset / fs.blocksize)
-	endBlock := int((fl.offset + int64(maxRead) - 1) / fs.blocksize)
+	startBlock := int(offset / fs.blocksize)
+	endBlock := int((offset + int64(maxRead) - 1) / fs.blocksize)
 
 	// do we end in fragment territory?
 	fragments := false
@@ -75,13 +109,13 @@ func (fl *File) Read(b []byte) (int, error) {
 	}
 
 	read := 0
-	offsetEnd := fl.offset + int64(maxRead)
+	offsetEnd := offset + int64(maxRead)
 	pos := int64(0)
 
 	// send input to b, clipping as appropriate
 	outputBlock := func(input []byte) {
 		inputSize := int64(len(input))
-		start := fl.offset - pos
+		start := offset - pos
 		end := offsetEnd - pos
 		if start >= 0 && start < inputSize {
 			if end > inputSize {
@@ -89,7 +123,7 @@ func (fl *File) Read(b []byte) (int, error) {
 			}
 			n := copy(b[read:], input[start:end])
 			read += n
-			fl.offset += int64(n)
+			offset += int64(n)
 		}
 	}
 
@@ -103,21 +137,46 @@ func (fl *File) Read(b []byte) (int, error) {
 			if int64(block.size) > fs.blocksize {
 				return read, fmt.Errorf("unexpected block.size=%d > fs.blocksize=%d", block.size, fs.blocksize)
 			}
-			var input []byte
-			if fl.blockLocation == location && fl.block != nil {
+			switch {
+			case !block.compressed && block.size != 0:
+				// uncompressed blocks are read straight into b
+				start := offset - pos
+				n := min(int64(block.size)-start, int64(maxRead-read))
+				if n <= 0 {
+					return read, fmt.Errorf("data block %d of %d bytes ends before offset %d in it", i, block.size, start)
+				}
+				m, err := fs.backend.ReadAt(b[read:read+int(n)], location+start)
+				if err != nil && err != io.EOF {
+					return read, fmt.Errorf("error reading data block %d from squashfs: %w", i, err)
+				}
+				if int64(m) != n {
+					return read, fmt.Errorf("read %d bytes of data block %d instead of expected %d", m, i, n)
+				}
+				read += m
+				offset += n
+			case cacheLast && fl.blockLocation == location && fl.block != nil:
 				// Read last block from cache
-				input = fl.block
-			} else {
-				var err error
-				input, err = fs.readBlock(location, block.compressed, block.size)
+				outputBlock(fl.block)
+			case cacheLast:
+				input, err := fs.readBlock(location, block.compressed, block.size, nil)
 				if err != nil {
-					return read, fmt.Errorf("error reading data block %d from squashfs: %v", i, err)
+					return read, fmt.Errorf("error reading data block %d from squashfs: %w", i, err)
 				}
 				// Cache the last block
 				fl.blockLocation = location
 				fl.block = input
+				outputBlock(input)
+			default:
+				// decompressed into a buffer only needed until it is copied into b
+				dst := fs.buffers.Get(int(fs.blocksize))
+				input, err := fs.readBlock(location, block.compressed, block.size, dst[:0])
+				if err != nil {
+					fs.buffers.Put(dst)
+					return read, fmt.Errorf("error reading data block %d from squashfs: %w", i, err)
+				}
+				outputBlock(input)
+				fs.buffers.Put(dst)
 			}
-			outputBlock(input)
 		}
 		location += int64(block.size)
 		pos += fs.blocksize
@@ -130,13 +189,13 @@ func (fl *File) Read(b []byte) (int, error) {
 		}
 		input, err := fs.readFragment(fl.fragmentBlockIndex, fl.fragmentOffset, fl.size()%fs.blocksize)
 		if err != nil {
-			return read, fmt.Errorf("error reading fragment block %d from squashfs: %v", fl.fragmentBlockIndex, err)
+			return read, fmt.Errorf("error reading fragment block %d from squashfs: %w", fl.fragmentBlockIndex, err)
 		}
 		pos = int64(len(fl.blockSizes)) * fs.blocksize
 		outputBlock(input)
 	}
 	var retErr error
-	if fl.offset >= fl.size() {
+	if offset >= fl.size() {
 		retErr = io.EOF
 	} else if read == 0 {
 		retErr = fmt.Errorf("internal error: read no bytes")
@@ -150,7 +209,21 @@ func (fl *File) Read(b []byte) (int, error) {
 //
 //nolint:unused,revive // but it is important to implement the interface
 func (fl *File) Write(p []byte) (int, error) {
-	return 0, filesystem.ErrReadonlyFilesystem
+	return 0, filesystem.ErrReadOnlyFilesystem
+}
+
+// WriteAt writes len(b) bytes to the File at offset off.
+//
+//	you cannot write to a finished squashfs, so this returns an error
+func (fl *File) WriteAt(_ []byte, _ int64) (int, error) {
+	return 0, filesystem.ErrReadOnlyFilesystem
+}
+
+// Truncate changes the size of the file.
+//
+//	you cannot write to a finished squashfs, so this returns an error
+func (fl *File) Truncate(_ int64) error {
+	return filesystem.ErrReadOnlyFilesystem
 }
 
 // Seek set the offset to a particular point in the file
@@ -163,9 +236,11 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekStart:
 		newOffset = offset
 	case io.SeekEnd:
-		newOffset = fl.size() - offset
+		newOffset = fl.size() + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
+	default:
+		return fl.offset, fmt.Errorf("invalid whence %d: %w", whence, iofs.ErrInvalid)
 	}
 	if newOffset < 0 {
 		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
@@ -174,6 +249,23 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	return fl.offset, nil
 }
 
+// DataOffset returns the offset of the first block of the file in the filesystem, or of the
+// fragment holding its contents if it has no whole block, for filesystem.LocatedFile, or -1 if it
+// is empty
+func (fl *File) DataOffset() int64 {
+	switch {
+	case fl.filesystem == nil || fl.fileSize == 0:
+		return -1
+	case len(fl.blockSizes) > 0:
+		return int64(fl.blocksStart)
+	}
+	fragments, err := fl.filesystem.fragmentTable()
+	if err == nil && int(fl.fragmentBlockIndex) < len(fragments) {
+		return int64(fragments[fl.fragmentBlockIndex].start)
+	}
+	return -1
+}
+
 // Close close the file
 func (fl *File) Close() error {
 	fl.filesystem = nil
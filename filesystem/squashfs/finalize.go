@@ -1,17 +1,22 @@
 package squashfs
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	iofs "io/fs"
+	"log/slog"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
 	"github.com/pkg/xattr"
 )
 
@@ -53,12 +58,39 @@ type FinalizeOptions struct {
 	FileUID *uint32
 	// FileGID set all files to be owned by the GID provided, default is to leave as in filesystem
 	FileGID *uint32
+	// Progress, if set, is updated in util.PhaseData after the data of each file is written, with
+	// the bytes of all the files written so far, out of the size of all of them
+	Progress util.Progress
+	// Logger, if set, gets the time taken by each step of the finalization, see util.PhaseTimer
+	Logger *slog.Logger
+	// Processors number of goroutines compressing data blocks at the same time. Defaults to 0, i.e.
+	// the number of CPUs, as mksquashfs does; 1 compresses them one after the other. The image is
+	// the same whatever the number.
+	Processors int
 }
 
-// Finalize finalize a read-only filesystem by writing it out to a read-only format
+// Finalize finalize a read-only filesystem by writing it out to a read-only format. As its size is
+// only known once compressed, it fails with an error that is filesystem.ErrNoSpace where it would
+// write beyond the size given to Create, leaving the image incomplete.
 func (fs *FileSystem) Finalize(options FinalizeOptions) error {
+	return fs.FinalizeContext(context.Background(), options)
+}
+
+// FinalizeContext finalizes the filesystem like Finalize, stopping with the error of ctx when it
+// is done, which leaves the image incomplete
+func (fs *FileSystem) FinalizeContext(ctx context.Context, options FinalizeOptions) error {
+	timer := util.NewPhaseTimer(options.Logger, "squashfs finalize")
+	err := fs.finalize(ctx, options, timer)
+	timer.Done(err)
+	return err
+}
+
+func (fs *FileSystem) finalize(ctx context.Context, options FinalizeOptions, timer *util.PhaseTimer) error {
 	if fs.workspace == "" {
-		return fmt.Errorf("cannot finalize an already finalized filesystem")
+		return fmt.Errorf("cannot finalize an already finalized filesystem: %w", filesystem.ErrReadOnlyFilesystem)
+	}
+	if _, err := fs.backend.Writable(); errors.Is(err, backend.ErrIncorrectOpenMode) {
+		return fmt.Errorf("%w: %w", filesystem.ErrReadOnlyFilesystem, err)
 	}
 
 	/*
@@ -71,8 +103,8 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		to keep it simple, we will follow what mksquashfs on linux does, in the following order:
 		- superblock at byte 0
 		- compression options, if any, at byte 96
-		- file data immediately following compression options (or superblock, if no compression options)
-		- fragments immediately following file data
+		- file data immediately following compression options (or superblock, if no compression options),
+		  with each block of fragments where it fills, among the data of the files after it
 		- inode table
 		- directory table
 		- fragment table
@@ -83,13 +115,22 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		Note that until we actually copy and compress each section, we do not know the position of each subsequent
 		section. So we have to write one, keep track of it, then the next, etc.
 
+		The stages of writing the data overlap, like those of mksquashfs: the tree is walked in one goroutine,
+		the data of each regular file is read as soon as it is found, and the blocks of data and fragments are
+		compressed by several goroutines while those compressed before are written, holding only a few blocks
+		for each goroutine in memory. The metadata is only emitted once all the data is written, as the inodes
+		point to where it is.
+
 
 	*/
 
-	f, err := fs.backend.Writable()
+	w, err := fs.backend.Writable()
 	if err != nil {
 		return err
 	}
+	// the compressed size is only known once written, so it stops where the filesystem would
+	// overrun its size
+	f := filesystem.Section(w, fs.start, fs.size)
 
 	blocksize := int(fs.blocksize)
 	comp := compressionNone
@@ -97,14 +138,6 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		comp = options.Compression.flavour()
 	}
 
-	// build out file and directory tree
-	// this returns a slice of *finalizeFileInfo, each of which represents a directory
-	// or file
-	fileList, err := walkTree(fs.Workspace())
-	if err != nil {
-		return fmt.Errorf("error walking tree: %v", err)
-	}
-
 	// location holds where we are writing in our file
 	var (
 		location int64
@@ -114,7 +147,9 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if options.Compression != nil {
 		b = options.Compression.optionsBytes()
 		if len(b) > 0 {
-			_, _ = f.WriteAt(b, location)
+			if _, err := f.WriteAt(b, location); err != nil {
+				return fmt.Errorf("failed to write compression options: %w", err)
+			}
 			location += int64(len(b))
 		}
 	}
@@ -125,23 +160,43 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		compressor = nil
 	}
 
-	// write file data blocks
-	//
-	dataWritten, err := writeDataBlocks(fileList, f, fs.workspace, blocksize, compressor, location)
+	// build out file and directory tree, writing the data of the files as they are found
+	// this returns a slice of *finalizeFileInfo, each of which represents a directory
+	// or file
+	timer.Phase("data")
+	walkCtx, cancelWalk := context.WithCancel(ctx)
+	defer cancelWalk()
+	var (
+		files <-chan *finalizeFileInfo
+		wait  func() ([]*finalizeFileInfo, error)
+		total int64
+	)
+	if options.Progress != nil {
+		// the progress is out of the size of all the files, known only once they are all found
+		fileList, err := walkTree(fs.Workspace(), options.Xattrs, nil)
+		if err != nil {
+			return fmt.Errorf("error walking tree: %w", err)
+		}
+		files, wait = listFiles(fileList)
+		for _, e := range fileList {
+			if e.fileType == fileRegular {
+				total += e.Size()
+			}
+		}
+	} else {
+		files, wait = walkFiles(walkCtx, fs.Workspace(), options.Xattrs)
+	}
+	dataWritten, fragmentBlocks, err := writeDataBlocks(ctx, files, f, fs.workspace, blocksize, compressor, location, options, total)
 	if err != nil {
-		return fmt.Errorf("error writing file data blocks: %v", err)
+		cancelWalk()
+		_, _ = wait()
+		return fmt.Errorf("error writing file data blocks: %w", err)
 	}
-	location += int64(dataWritten)
-
-	//
-	// write file fragments
-	//
-	fragmentBlockStart := location
-	fragmentBlocks, _, err := writeFragmentBlocks(fileList, f, fs.workspace, blocksize, options, fragmentBlockStart)
+	fileList, err := wait()
 	if err != nil {
-		return fmt.Errorf("error writing file fragment blocks: %v", err)
+		return fmt.Errorf("error walking tree: %w", err)
 	}
-	location += int64(len(fragmentBlocks) * blocksize)
+	location += int64(dataWritten)
 
 	// extract extended attributes, and save them for later; these are written at the very end
 	// this must be done *before* creating inodes, as inodes reference these
@@ -150,111 +205,61 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	// Now we need to write the inode table and directory table. But
 	// we have a chicken and an egg problem.
 	//
-	// * On the one hand, inodes are written to the disk before the directories, so we need to know
-	// the size of the inode data.
-	// * On the other hand, inodes for directories point to directories, specifically, the block and offset
-	// where the pointed-at directory resides in the directory table.
-	//
-	// So we need inode table to create directory table, and directory table to create inode table.
-	//
-	// Further complicating matters is that the data in the
-	// directory inodes relies on having the directory data ready. Specifically,
-	// it includes:
-	// - index of the block in the directory table where the dir info starts. Note
-	//   that this is not just the directory *table* index, but the *block* index.
-	// - offset within the block in the directory table where the dir info starts.
-	//   Same notes as previous entry.
-	// - size of the directory table entries for this directory, all of it. Thus,
-	//   you have to have converted it all to bytes to get the information.
-	//
-	// The only possible way to do this is to run one, then the other, then
-	// modify them. Until you generate both, you just don't know.
-	//
-	// Something that eases it a bit is that the block index in directory inodes
-	// is from the start of the directory table, rather than start of archive.
+	// * On the one hand, the entries of directories point to inodes, specifically, the position of
+	// the compressed metadata block of the inode table that holds each, and its offset in it.
+	// * On the other hand, inodes for directories point to directories, specifically, the block and
+	// offset where the pointed-at directory resides in the directory table, its size, and for large
+	// directories an index of the blocks it spans.
 	//
-	// Order of execution:
+	// Neither position is known until the blocks before it are compressed. Like mksquashfs, we get
+	// out of it by building both tables at once, block by block, with the inode of each directory
+	// after everything in it:
 	// 1. Write the file (not directory) data and fragments to disk.
-	// 2. Create inodes for the files. We cannot write them yet because we need to
-	//    add the directory entries before compression.
-	// 3. Convert the directories to a directory table. And no, we cannot just
-	//    calculate it based on the directory size, since some directories have
-	//    one header, some have multiple, so the size of each directory, even
-	//    given the number of files, can change.
-	// 4. Create inodes for the directories and write them to disk
-	// 5. Update the directory entries based on the inodes.
-	// 6. Write directory table to disk
+	// 2. Create inodes for everything.
+	// 3. Add the inodes of everything but the directories to the inode table, so that any directory
+	//    entry can point to them, even a hard link to a file elsewhere.
+	// 4. Then for each directory, after those within it: add its entries to the directory table,
+	//    which now know where their inodes are, and then its inode, which now knows where they are.
+	// 5. Write the inode table, and then the directory table, to disk. The positions of their blocks
+	//    are from the start of each table, so they do not depend on where the tables are written.
 	//
-	// if storing the inodes and directory table entirely in memory becomes
-	// burdensome, use temporary scratch disk space to cache data in flight
+	// The inodes and directory table are held in memory until written;
+	// if that becomes burdensome, use temporary scratch disk space to cache data in flight
 
 	//
 	// Build inodes for files. They are saved onto the fileList items themselves.
 	//
 	// build up a table of uids/gids we can store later
+	timer.Phase("inodes")
 	idtable := map[uint32]uint16{}
 	// get the inodes in order as a slice
-	if err := createInodes(fileList, idtable, options); err != nil {
-		return fmt.Errorf("error creating file inodes: %v", err)
+	if err := createInodes(fileList, fs.workspace, idtable, options); err != nil {
+		return fmt.Errorf("error creating file inodes: %w", err)
 	}
 
-	// convert the inodes to data, while keeping track of where each
-	// one is, so we can update the directory entries
-	updateInodeLocations(fileList)
-
-	// create the directory table. We already have every inode and its position,
-	// so we do not need to dip back into the inodes. The only changes will be
-	// the block/offset references into the directory table, but those sizes do
-	// not change. However, we will have to break out the headers, so this is not
-	// completely finalized yet.
-	directories := createDirectories(fileList[0])
-
-	// create the final version of the directory table by creating the headers
-	// and entries.
-	populateDirectoryLocations(directories)
-
-	if err := updateInodesFromDirectories(directories); err != nil {
-		return fmt.Errorf("error updating inodes with final directory data: %v", err)
+	// lay out the inode and directory tables, each directory after everything in it, so that its
+	// entries and its own inode are complete where they are written
+	inodeTable, dirTable, err := buildMetadataTables(fileList, compressor)
+	if err != nil {
+		return fmt.Errorf("error building inode and directory tables: %w", err)
 	}
 
 	// write the inodes to the file
-	inodesWritten, inodeTableLocation, err := writeInodes(fileList, f, compressor, location)
-	if err != nil {
-		return fmt.Errorf("error writing inode data blocks: %v", err)
+	inodeTableLocation := uint64(location)
+	if _, err := f.WriteAt(inodeTable, location); err != nil {
+		return fmt.Errorf("error writing inode data blocks: %w", err)
 	}
-	location += int64(inodesWritten)
+	location += int64(len(inodeTable))
 
 	// write directory data
-	dirsWritten, dirTableLocation, err := writeDirectories(directories, f, compressor, location)
-	if err != nil {
-		return fmt.Errorf("error writing directory data blocks: %v", err)
+	dirTableLocation := uint64(location)
+	if _, err := f.WriteAt(dirTable, location); err != nil {
+		return fmt.Errorf("error writing directory data blocks: %w", err)
 	}
-	location += int64(dirsWritten)
+	location += int64(len(dirTable))
 
 	// write fragment table
-
-	/*
-		The indexCount is used for indexed lookups.
-
-		The index is stored at the end of the inode (after the filename) for extended directory
-		There is one entry for each block after the 0th, so if there is just one block, then there is no index
-		The filenames in the directory are sorted alphabetically. Each entry gives the first filename found in
-		the respective block, so if the name found is larger than yours, it is in the previous block
-
-		b[0:4] uint32 index - number of bytes where this entry is from the beginning of this directory
-		b[4:8] uint32 startBlock - number of bytes in the filesystem from the start of the directory table that this block is
-		b[8:12] uint32 size - size of the name (-1)
-		b[12:12+size] string name
-
-		Here is an example of 1 entry:
-
-		f11f 0000 0000 0000 0b00 0000 6669 6c65 6e61 6d65 5f34 3638
-
-		b[0:4] index 0x1ff1
-		b[4:8] startBlock 0x00
-		b[8:12] size 0x0b (+1 for a total of 0x0c = 12)
-		b[12:24] name filename_468
-	*/
+	timer.Phase("tables")
 
 	// TODO:
 	/*
@@ -271,9 +276,9 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	*/
 
 	// write the fragment table and its index
-	fragmentTableWritten, fragmentTableLocation, err := writeFragmentTable(fragmentBlocks, fragmentBlockStart, f, compressor, location)
+	fragmentTableWritten, fragmentTableLocation, err := writeFragmentTable(fragmentBlocks, f, compressor, location)
 	if err != nil {
-		return fmt.Errorf("error writing fragment table: %v", err)
+		return fmt.Errorf("error writing fragment table: %w", err)
 	}
 	location += int64(fragmentTableWritten)
 
@@ -285,7 +290,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if !options.NonExportable {
 		exportTableWritten, exportTableLocation, err = writeExportTable(fileList, f, compressor, location)
 		if err != nil {
-			return fmt.Errorf("error writing export table: %v", err)
+			return fmt.Errorf("error writing export table: %w", err)
 		}
 		location += int64(exportTableWritten)
 	}
@@ -293,7 +298,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	// write the uidgid table
 	idTableWritten, idTableLocation, err := writeIDTable(idtable, f, compressor, location)
 	if err != nil {
-		return fmt.Errorf("error writing uidgid table: %v", err)
+		return fmt.Errorf("error writing uidgid table: %w", err)
 	}
 	location += int64(idTableWritten)
 
@@ -305,7 +310,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		var xAttrsWritten int
 		xAttrsWritten, xAttrsLocation, err = writeXattrs(xattrs, f, compressor, location)
 		if err != nil {
-			return fmt.Errorf("error writing xattrs table: %v", err)
+			return fmt.Errorf("error writing xattrs table: %w", err)
 		}
 		location += int64(xAttrsWritten)
 	}
@@ -327,7 +332,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		inodes:              uint32(len(fileList)),
 		xattrTableStart:     xAttrsLocation,
 		fragmentCount:       uint32(len(fragmentBlocks)),
-		modTime:             time.Now(),
+		modTime:             util.Now(),
 		size:                uint64(location),
 		versionMajor:        4,
 		versionMinor:        0,
@@ -350,9 +355,13 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	// write the superblock
+	timer.Phase("superblock")
 	sbBytes := sb.toBytes()
 	if _, err := f.WriteAt(sbBytes, 0); err != nil {
-		return fmt.Errorf("failed to write superblock: %v", err)
+		return fmt.Errorf("failed to write superblock: %w", err)
+	}
+	if err := backend.Sync(fs.backend); err != nil {
+		return fmt.Errorf("error syncing image: %w", err)
 	}
 
 	// finish by setting as finalized
@@ -360,68 +369,35 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	return nil
 }
 
-func copyFileData(from backend.File, to backend.WritableFile, fromOffset, toOffset, blocksize int64, c Compressor) (raw, compressed int, blocks []*blockData, err error) {
-	buf := make([]byte, blocksize)
-	blocks = make([]*blockData, 0)
-	for {
-		n, err := from.ReadAt(buf, fromOffset+int64(raw))
-		if err != nil && err != io.EOF {
-			return raw, compressed, nil, err
-		}
-		if n != len(buf) {
-			break
-		}
-		raw += len(buf)
-
-		// compress the block if needed
-		isCompressed := false
-		if c != nil {
-			out, err := c.compress(buf)
-			if err != nil {
-				return 0, 0, nil, fmt.Errorf("error compressing block: %v", err)
-			}
-			if len(out) < len(buf) {
-				isCompressed = true
-				buf = out
-			}
-		}
-		blocks = append(blocks, &blockData{size: uint32(len(buf)), compressed: isCompressed})
-		if _, err := to.WriteAt(buf[:n], toOffset+int64(compressed)); err != nil {
-			return raw, compressed, blocks, err
-		}
-		compressed += len(buf)
+// compressBlock compresses a block of data if there is a compressor, and returns it and whether it
+// is compressed, or the block itself where compressing it does not make it smaller
+func compressBlock(buf []byte, c Compressor) ([]byte, bool, error) {
+	if c == nil {
+		return buf, false, nil
 	}
-	return raw, compressed, blocks, nil
-}
-
-// finalizeFragment write fragment data out to the archive, compressing if relevant.
-// Returns the total amount written, whether compressed, and any error.
-func finalizeFragment(buf []byte, to backend.WritableFile, toOffset int64, c Compressor) (raw int, compressed bool, err error) {
-	// compress the block if needed
-	if c != nil {
-		out, err := c.compress(buf)
-		if err != nil {
-			return 0, compressed, fmt.Errorf("error compressing fragment block: %v", err)
-		}
-		if len(out) < len(buf) {
-			buf = out
-			compressed = true
-		}
+	out, err := c.compress(buf)
+	if err != nil {
+		return nil, false, err
 	}
-	if _, err := to.WriteAt(buf, toOffset); err != nil {
-		return 0, compressed, err
+	if len(out) < len(buf) {
+		return out, true, nil
 	}
-	return len(buf), compressed, nil
+	return buf, false, nil
 }
 
 // walkTree walks the tree and returns a slice of files and directories.
 // We do files and directories differently, since they need to be processed
 // differently on disk (file data and fragments vs directory table), and
 // because the inode data is different.
-// The first entry in the return always will be the root
-func walkTree(workspace string) ([]*finalizeFileInfo, error) {
+// The first entry in the return always will be the root. The hard links to a file already walked
+// are only among the children of their directory, sharing its inode. The extended attributes of
+// the files are read only with xattrs. If found is not nil, it is called with each entry as it is
+// added, stopping the walk with its error.
+func walkTree(workspace string, xattrs bool, found func(*finalizeFileInfo) error) ([]*finalizeFileInfo, error) {
 	dirMap := make(map[string]*finalizeFileInfo)
 	fileList := make([]*finalizeFileInfo, 0)
+	// the files with hard links, by the number of their inode in the workspace
+	linked := make(map[uint64]*finalizeFileInfo)
 	var entry *finalizeFileInfo
 	err := filepath.WalkDir(workspace, func(actualPath string, d iofs.DirEntry, err error) error {
 		if err != nil {
@@ -436,7 +412,7 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 		name := d.Name()
 		fi, err := d.Info()
 		if err != nil {
-			return fmt.Errorf("could not get file info for %s: %v", fp, err)
+			return fmt.Errorf("could not get file info for %s: %w", fp, err)
 		}
 		m := fi.Mode()
 		var fType fileType
@@ -456,17 +432,9 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 		default:
 			fType = fileRegular
 		}
-		xattrNames, err := xattr.List(actualPath)
+		xattrValues, err := readXattrs(actualPath, xattrs)
 		if err != nil {
-			return fmt.Errorf("unable to list xattrs for %s: %v", fp, err)
-		}
-		xattrs := map[string]string{}
-		for _, name := range xattrNames {
-			val, err := xattr.Get(fp, name)
-			if err != nil {
-				return fmt.Errorf("unable to get xattr %s for %s: %v", name, fp, err)
-			}
-			xattrs[name] = string(val)
+			return fmt.Errorf("unable to get xattrs for %s: %w", fp, err)
 		}
 		nlink, uid, gid := getFileProperties(fi)
 
@@ -475,11 +443,11 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 			name:     name,
 			isDir:    fi.IsDir(),
 			isRoot:   isRoot,
-			modTime:  fi.ModTime(),
+			modTime:  util.ClampTime(fi.ModTime()),
 			mode:     m,
 			fileType: fType,
 			size:     fi.Size(),
-			xattrs:   xattrs,
+			xattrs:   xattrValues,
 			uid:      uid,
 			gid:      gid,
 			links:    nlink,
@@ -500,7 +468,17 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 			parentDirInfo.children = append(parentDirInfo.children, entry)
 			dirMap[parentDir] = parentDirInfo
 		}
+		if ino, ok := getInodeNumber(fi); ok && nlink > 1 && !fi.IsDir() {
+			if original, found := linked[ino]; found {
+				entry.linkOf = original
+				return nil
+			}
+			linked[ino] = entry
+		}
 		fileList = append(fileList, entry)
+		if found != nil {
+			return found(entry)
+		}
 		return nil
 	})
 	if err != nil {
@@ -510,6 +488,76 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 	return fileList, nil
 }
 
+// readXattrs reads the extended attributes of the file, without following symbolic links, or
+// returns none without read
+func readXattrs(p string, read bool) (map[string]string, error) {
+	xattrs := map[string]string{}
+	if !read {
+		return xattrs, nil
+	}
+	names, err := xattr.LList(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		val, err := xattr.LGet(p, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		xattrs[name] = string(val)
+	}
+	return xattrs, nil
+}
+
+// walkAhead is how many regular files the walk of the tree finds before their data is written
+const walkAhead = 64
+
+// walkFiles walks the tree of the workspace with walkTree in another goroutine, sending each regular
+// file on the channel as it is found, so that its data is written while the rest of the tree is
+// walked. The channel is closed when the walk ends, after which wait returns what walkTree did; the
+// walk stops early once ctx is done.
+func walkFiles(ctx context.Context, workspace string, xattrs bool) (files <-chan *finalizeFileInfo, wait func() ([]*finalizeFileInfo, error)) {
+	found := make(chan *finalizeFileInfo, walkAhead)
+	var (
+		fileList []*finalizeFileInfo
+		err      error
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(found)
+		fileList, err = walkTree(workspace, xattrs, func(e *finalizeFileInfo) error {
+			if e.fileType != fileRegular {
+				return nil
+			}
+			select {
+			case found <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return found, func() ([]*finalizeFileInfo, error) {
+		<-done
+		return fileList, err
+	}
+}
+
+// listFiles is walkFiles for a tree already walked
+func listFiles(fileList []*finalizeFileInfo) (files <-chan *finalizeFileInfo, wait func() ([]*finalizeFileInfo, error)) {
+	found := make(chan *finalizeFileInfo, len(fileList))
+	for _, e := range fileList {
+		if e.fileType == fileRegular {
+			found <- e
+		}
+	}
+	close(found)
+	return found, func() ([]*finalizeFileInfo, error) {
+		return fileList, nil
+	}
+}
+
 func getTableIdx(m map[uint32]uint16, index uint32) uint16 {
 	for k, v := range m {
 		if k == index {
@@ -521,37 +569,26 @@ func getTableIdx(m map[uint32]uint16, index uint32) uint16 {
 	return m[index]
 }
 
-func writeFileDataBlocks(e *finalizeFileInfo, to backend.WritableFile, ws string, startBlock uint64, blocksize int, compressor Compressor, location int64) (blockCount, compressed int, err error) {
-	from, err := os.Open(path.Join(ws, e.path))
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
-	}
-	defer from.Close()
-	raw, compressed, blocks, err := copyFileData(from, to, 0, location, int64(blocksize), compressor)
+func writeMetadataBlock(buf []byte, to backend.WritableFile, c Compressor, location int64) (int, error) {
+	b, err := metadataBlockBytes(buf, c)
 	if err != nil {
-		return 0, 0, fmt.Errorf("error copying file %s: %v", e.Name(), err)
+		return 0, err
 	}
-	if raw%blocksize != 0 {
-		return 0, 0, fmt.Errorf("copying file %s copied %d which is not a multiple of blocksize %d", e.Name(), raw, blocksize)
+	if _, err := to.WriteAt(b, location); err != nil {
+		return 0, err
 	}
-	// save the information we need for usage later in inodes to find the file data
-	e.dataLocation = location
-	e.blocks = blocks
-	e.startBlock = startBlock
-
-	// how many blocks did we write?
-	blockCount = raw / blocksize
-
-	return blockCount, compressed, nil
+	return len(b), nil
 }
 
-func writeMetadataBlock(buf []byte, to backend.WritableFile, c Compressor, location int64) (int, error) {
+// metadataBlockBytes returns the metadata block of the contents as written, compressed if there is
+// a compressor and that makes it smaller
+func metadataBlockBytes(buf []byte, c Compressor) ([]byte, error) {
 	// compress the block if needed
 	isCompressed := false
 	if c != nil {
 		out, err := c.compress(buf)
 		if err != nil {
-			return 0, fmt.Errorf("error compressing block: %v", err)
+			return nil, fmt.Errorf("error compressing block: %w", err)
 		}
 		if len(out) < len(buf) {
 			isCompressed = true
@@ -564,205 +601,211 @@ func writeMetadataBlock(buf []byte, to backend.WritableFile, c Compressor, locat
 	if !isCompressed {
 		size |= 1 << 15
 	}
-	header := make([]byte, 2)
+	header := make([]byte, 2, 2+len(buf))
 	binary.LittleEndian.PutUint16(header, size)
-	buf = append(header, buf...)
-	if _, err := to.WriteAt(buf, location); err != nil {
-		return 0, err
-	}
-	return len(buf), nil
+	return append(header, buf...), nil
 }
 
-func writeDataBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, compressor Compressor, location int64) (int, error) {
-	allBlocks := 0
-	allWritten := 0
-	for _, e := range fileList {
-		// only copy data for normal files
-		if e.fileType != fileRegular {
-			continue
-		}
+// dataBlock is a full block of the data of a file, or the end of the data of the file if nil, or a
+// block of fragments if there is no file
+type dataBlock struct {
+	file *finalizeFileInfo
+	data []byte
+}
 
-		blocks, written, err := writeFileDataBlocks(e, f, ws, uint64(allBlocks), blocksize, compressor, location)
-		if err != nil {
-			return allWritten, fmt.Errorf("error writing data for %s to file: %v", e.path, err)
-		}
-		allBlocks += blocks
-		allWritten += written
-		location += int64(written)
-	}
-	return allWritten, nil
+// compressedBlock is a block of data as written
+type compressedBlock struct {
+	data       []byte
+	compressed bool
 }
 
-// writeFragmentBlocks writes all of the fragment blocks to the archive. Returns slice of blocks written, the total bytes written, any error
-func writeFragmentBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, options FinalizeOptions, location int64) ([]fragmentBlock, int64, error) {
-	compressor := options.Compression
+// writeDataBlocks writes the blocks of data of the regular files received from files, compressed by
+// options.Processors goroutines, one file after the other. The last partial block of each file
+// goes in a fragment, unless options.NoFragments, and each block of fragments is written among the
+// data once full, as mksquashfs does, so that each file is read once. Returns the total bytes
+// written and the blocks of fragments. The progress of options is updated out of total.
+func writeDataBlocks(ctx context.Context, files <-chan *finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, compressor Compressor, location int64, options FinalizeOptions, total int64) (int, []fragmentBlock, error) {
+	fragmentCompressor := options.Compression
 	if options.NoCompressFragments {
-		compressor = nil
+		fragmentCompressor = nil
 	}
-	fragmentData := make([]byte, 0)
+	pool := util.DefaultBufferPool
+	// the read goroutine stops waiting for files once a block cannot be compressed or written
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// read the blocks of each file in turn, and pack the ends of the files into blocks of
+	// fragments; queued holds what is read but not yet returned
 	var (
-		allWritten         int64
-		fragmentBlockIndex uint32
-		fragmentBlocks     []fragmentBlock
+		e              *finalizeFileInfo
+		from           *os.File
+		offset         int64
+		fragments      []byte
+		fragmentBlockN uint32
+		queued         []dataBlock
+		walked         bool
 	)
-	fileCloseList := make([]*os.File, 0)
 	defer func() {
-		for _, f := range fileCloseList {
-			f.Close()
+		if from != nil {
+			from.Close()
 		}
-	}()
-	for _, e := range fileList {
-		// only copy data for regular files
-		if e.fileType != fileRegular {
-			continue
-		}
-		var (
-			written int64
-			err     error
-		)
-
-		// how much is there to put in a fragment?
-		remainder := e.Size() % int64(blocksize)
-		if remainder == 0 {
-			continue
+		for _, b := range queued {
+			pool.Put(b.data)
 		}
-
-		// would adding this data cause us to write?
-		if len(fragmentData)+int(remainder) > blocksize {
-			written, compressed, err := finalizeFragment(fragmentData, f, location, compressor)
-			if err != nil {
-				return fragmentBlocks, 0, fmt.Errorf("error writing fragment block %d: %v", fragmentBlockIndex, err)
+		pool.Put(fragments)
+	}()
+	queueFragments := func() {
+		queued = append(queued, dataBlock{data: fragments})
+		fragments = nil
+		fragmentBlockN++
+	}
+	next := func() error {
+		if e == nil {
+			var ok bool
+			select {
+			case e, ok = <-files:
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			fragmentBlocks = append(fragmentBlocks, fragmentBlock{
-				size:       uint32(written),
-				compressed: compressed,
-				location:   location,
-			})
-			location += int64(blocksize)
-			// increment as all writes will be to next block block
-			fragmentBlockIndex++
-			fragmentData = make([]byte, 0)
-		}
-
-		e.fragment = &fragmentRef{
-			block:  fragmentBlockIndex,
-			offset: uint32(len(fragmentData)),
-		}
-		// save the fragment data from the file
-
-		from, err := os.Open(path.Join(ws, e.path))
-		if err != nil {
-			return fragmentBlocks, 0, fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
-		}
-		fileCloseList = append(fileCloseList, from)
-		buf := make([]byte, remainder)
-		n, err := from.ReadAt(buf, e.Size()-remainder)
-		if err != nil && err != io.EOF {
-			return fragmentBlocks, 0, fmt.Errorf("error reading final %d bytes from file %s: %v", remainder, e.Name(), err)
+			if !ok {
+				walked = true
+				if len(fragments) > 0 {
+					queueFragments()
+				}
+				return nil
+			}
+			var err error
+			if from, err = os.Open(path.Join(ws, e.path)); err != nil {
+				return fmt.Errorf("failed to open file for reading %s: %w", e.path, err)
+			}
+			offset = 0
 		}
-		if n != len(buf) {
-			return fragmentBlocks, 0, fmt.Errorf("failed reading final %d bytes from file %s, only read %d", remainder, e.Name(), n)
+		if offset+int64(blocksize) <= e.Size() {
+			buf := pool.Get(blocksize)
+			if _, err := from.ReadAt(buf, offset); err != nil {
+				pool.Put(buf)
+				return fmt.Errorf("error reading data for %s: %w", e.path, err)
+			}
+			offset += int64(blocksize)
+			queued = append(queued, dataBlock{file: e, data: buf})
+			return nil
+		}
+		if remainder := int(e.Size() - offset); remainder > 0 {
+			var buf []byte
+			if options.NoFragments {
+				buf = pool.Get(remainder)
+				queued = append(queued, dataBlock{file: e, data: buf})
+			} else {
+				// would adding this data overfill the block?
+				if len(fragments)+remainder > blocksize {
+					queueFragments()
+				}
+				if fragments == nil {
+					fragments = pool.Get(blocksize)[:0]
+				}
+				e.fragment = &fragmentRef{
+					block:  fragmentBlockN,
+					offset: uint32(len(fragments)),
+				}
+				fragments = fragments[:len(fragments)+remainder]
+				buf = fragments[len(fragments)-remainder:]
+			}
+			n, err := from.ReadAt(buf, offset)
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("error reading final %d bytes from file %s: %w", remainder, e.path, err)
+			}
+			if n != len(buf) {
+				return fmt.Errorf("failed reading final %d bytes from file %s, only read %d", remainder, e.path, n)
+			}
 		}
 		from.Close()
-		fragmentData = append(fragmentData, buf...)
-
-		allWritten += written
-		if written > 0 {
-			fragmentBlockIndex++
-		}
-	}
-
-	// write remaining fragment data
-	if len(fragmentData) > 0 {
-		written, compressed, err := finalizeFragment(fragmentData, f, location, compressor)
-		if err != nil {
-			return fragmentBlocks, 0, fmt.Errorf("error writing fragment block %d: %v", fragmentBlockIndex, err)
-		}
-		fragmentBlocks = append(fragmentBlocks, fragmentBlock{
-			size:       uint32(written),
-			compressed: compressed,
-			location:   location,
-		})
-		// increment as all writes will be to next block block
-		allWritten += int64(written)
+		from = nil
+		queued = append(queued, dataBlock{file: e})
+		e = nil
+		return nil
 	}
-	return fragmentBlocks, allWritten, nil
-}
-
-func writeInodes(files []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, location int64) (inodesWritten int, finalLocation uint64, err error) {
-	var (
-		buf             []byte
-		maxSize         = int(metadataBlockSize)
-		initialLocation = location
-	)
-	for _, e := range files {
-		// keep writing until we run out, or we hit 8KB
-		buf = append(buf, e.inode.toBytes()...)
-		if len(buf) > maxSize {
-			written, err := writeMetadataBlock(buf[:maxSize], f, compressor, location)
-			if err != nil {
-				return inodesWritten, 0, err
+	read := func() (dataBlock, error) {
+		for len(queued) == 0 {
+			if walked {
+				return dataBlock{}, io.EOF
+			}
+			if err := next(); err != nil {
+				return dataBlock{}, err
 			}
-			// count all we have written
-			inodesWritten += written
-			// increment for next write
-			location += int64(written)
-			// truncate all except what we wrote
-			buf = buf[maxSize:]
 		}
+		b := queued[0]
+		queued = queued[1:]
+		return b, nil
 	}
-	// was there anything left?
-	if len(buf) > 0 {
-		written, err := writeMetadataBlock(buf, f, compressor, location)
+	process := func(b dataBlock) (compressedBlock, error) {
+		if b.data == nil {
+			return compressedBlock{}, nil
+		}
+		c, what := fragmentCompressor, "fragment block"
+		if b.file != nil {
+			c, what = compressor, "block of "+b.file.path
+		}
+		out, compressed, err := compressBlock(b.data, c)
 		if err != nil {
-			return inodesWritten, 0, err
+			cancel()
+			return compressedBlock{}, fmt.Errorf("error compressing %s: %w", what, err)
 		}
-		inodesWritten += written
+		return compressedBlock{data: out, compressed: compressed}, nil
 	}
-	return inodesWritten, uint64(initialLocation), nil
-}
 
-// writeDirectories write all directories out to disk. Assumes it already has been optimized.
-func writeDirectories(dirs []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, location int64) (directoriesWritten int, finalLocation uint64, err error) {
 	var (
-		buf             []byte
-		maxSize         = int(metadataBlockSize)
-		initialLocation = location
+		done           int64
+		allBlocks      int
+		allWritten     int
+		current        *finalizeFileInfo
+		fragmentBlocks []fragmentBlock
 	)
-	for i, d := range dirs {
-		if d.directory == nil {
-			return 0, 0, fmt.Errorf("empty directory info for position %d", i)
+	write := func(b dataBlock, c compressedBlock) error {
+		defer pool.Put(b.data)
+		e := b.file
+		// save the information we need for usage later in inodes to find the file data
+		if e != nil && e != current {
+			current = e
+			e.dataLocation = location
+			e.blocks = make([]*blockData, 0)
+			e.startBlock = uint64(allBlocks)
+		}
+		if b.data == nil {
+			if options.Progress != nil {
+				done += e.Size()
+				options.Progress.Update(util.PhaseData, done, total, e.path)
+			}
+			return nil
 		}
-		// keep writing until we run out, or we hit metadata maxSize of 8KB
-		buf = append(buf, d.directory.toBytes(d.directory.inodeIndex)...)
-		if len(buf) > maxSize {
-			written, err := writeMetadataBlock(buf[:maxSize], f, compressor, location)
-			if err != nil {
-				return directoriesWritten, 0, err
+		if _, err := f.WriteAt(c.data, location); err != nil {
+			cancel()
+			if e == nil {
+				return fmt.Errorf("error writing fragment block %d to file: %w", len(fragmentBlocks), err)
 			}
-			// count all we have written
-			directoriesWritten += written
-			// increment for next write
-			location += int64(written)
-			// truncate all except what we wrote
-			buf = buf[maxSize:]
+			return fmt.Errorf("error writing data for %s to file: %w", e.path, err)
 		}
-	}
-	// was there anything left?
-	if len(buf) > 0 {
-		written, err := writeMetadataBlock(buf, f, compressor, location)
-		if err != nil {
-			return directoriesWritten, 0, err
+		if e == nil {
+			fragmentBlocks = append(fragmentBlocks, fragmentBlock{
+				size:       uint32(len(c.data)),
+				compressed: c.compressed,
+				location:   location,
+			})
+		} else {
+			e.blocks = append(e.blocks, &blockData{size: uint32(len(c.data)), compressed: c.compressed})
+			allBlocks++
 		}
-		directoriesWritten += written
+		allWritten += len(c.data)
+		location += int64(len(c.data))
+		return nil
 	}
-	return directoriesWritten, uint64(initialLocation), nil
+	if err := util.Pipeline(ctx, util.Processors(options.Processors), read, process, write); err != nil {
+		return allWritten, fragmentBlocks, err
+	}
+	return allWritten, fragmentBlocks, nil
 }
 
 // writeFragmentTable write the fragment table
-//
-//nolint:unparam,unused,revive // this does not use fragmentBlocksStart yet, but only because we have not yet added support
-func writeFragmentTable(fragmentBlocks []fragmentBlock, fragmentBlocksStart int64, f backend.WritableFile, compressor Compressor, location int64) (fragmentsWritten int, finalLocation uint64, err error) {
+func writeFragmentTable(fragmentBlocks []fragmentBlock, f backend.WritableFile, compressor Compressor, location int64) (fragmentsWritten int, finalLocation uint64, err error) {
 	// now write the actual fragment table entries
 	var (
 		indexEntries []uint64
@@ -817,7 +860,7 @@ func writeFragmentTable(fragmentBlocks []fragmentBlock, fragmentBlocksStart int6
 	// just write it out
 	written, err := f.WriteAt(buf, location)
 	if err != nil {
-		return fragmentsWritten, 0, fmt.Errorf("error writing fragment table lookup index: %v", err)
+		return fragmentsWritten, 0, fmt.Errorf("error writing fragment table lookup index: %w", err)
 	}
 	fragmentsWritten += written
 	return fragmentsWritten, uint64(location), nil
@@ -877,7 +920,7 @@ func writeExportTable(files []*finalizeFileInfo, f backend.WritableFile, compres
 	// just write it out
 	written, err := f.WriteAt(buf, location)
 	if err != nil {
-		return entriesWritten, 0, fmt.Errorf("error writing export table lookup index: %v", err)
+		return entriesWritten, 0, fmt.Errorf("error writing export table lookup index: %w", err)
 	}
 	entriesWritten += written
 	return entriesWritten, uint64(location), nil
@@ -937,7 +980,7 @@ func writeIDTable(idtable map[uint32]uint16, f backend.WritableFile, compressor
 	// just write it out
 	written, err := f.WriteAt(buf, location)
 	if err != nil {
-		return entriesWritten, 0, fmt.Errorf("error writing uidgid table lookup index: %v", err)
+		return entriesWritten, 0, fmt.Errorf("error writing uidgid table lookup index: %w", err)
 	}
 	entriesWritten += written
 	return entriesWritten, uint64(location), nil
@@ -950,6 +993,7 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 		offset      int
 		lookupTable []byte
 		buf         []byte
+		dataStart   = location
 	)
 
 	// each entry in the xattrs slice is a unique key-value map. It may be referenced by one or more inodes.
@@ -966,7 +1010,7 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 			}
 			b := make([]byte, 4)
 			binary.LittleEndian.PutUint16(b[0:2], prefix)
-			binary.LittleEndian.PutUint16(b[2:4], uint16(len(k)))
+			binary.LittleEndian.PutUint16(b[2:4], uint16(len(name)))
 			b = append(b, []byte(name)...)
 			single = append(single, b...)
 
@@ -977,10 +1021,10 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 		}
 		// add the index
 		b := make([]byte, 16)
+		// bits 0:16 (uint16) hold the offset in the uncompressed block
+		binary.LittleEndian.PutUint16(b[0:2], uint16(offset))
 		// bits 16:48 (uint32) hold the block position
 		binary.LittleEndian.PutUint32(b[2:6], uint32(xattrsWritten))
-		// bits 48:64 (uint16) hold the offset in the uncompressed block
-		binary.LittleEndian.PutUint16(b[6:8], uint16(offset))
 		// bytes 8:12 (uint32) hold the number of pairs
 		binary.LittleEndian.PutUint32(b[8:12], uint32(len(m)))
 		// bytes 12:16 (uint32) hold the size of the entire map for this inode
@@ -1019,21 +1063,8 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 	var indexEntries []uint64
 
 	// write the lookupTable - this too is stored as metadata blocks
-	var i int
-	for i = 0; i < len(lookupTable); i += maxSize {
-		written, err := writeMetadataBlock(lookupTable[i*maxSize:i*maxSize+maxSize], f, compressor, location)
-		if err != nil {
-			return xattrsWritten, 0, err
-		}
-		indexEntries = append(indexEntries, uint64(location))
-		// count all we have written
-		xattrsWritten += written
-		location += int64(written)
-	}
-	// was there any left?
-	remainder := len(lookupTable) % maxSize
-	if remainder > 0 {
-		written, err := writeMetadataBlock(lookupTable[remainder:], f, compressor, location)
+	for i := 0; i < len(lookupTable); i += maxSize {
+		written, err := writeMetadataBlock(lookupTable[i:min(i+maxSize, len(lookupTable))], f, compressor, location)
 		if err != nil {
 			return xattrsWritten, 0, err
 		}
@@ -1042,10 +1073,10 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 		xattrsWritten += written
 		location += int64(written)
 	}
-	// finally, we need the ID table
-	b := make([]byte, 16+8*len(indexEntries))
-	binary.LittleEndian.PutUint64(b[0:8], uint64(location))
-	binary.LittleEndian.PutUint32(b[8:12], uint32(len(lookupTable)))
+	// finally, we need the ID table, with where the key-value pairs start and how many ids there are
+	b := make([]byte, 16, 16+8*len(indexEntries))
+	binary.LittleEndian.PutUint64(b[0:8], uint64(dataStart))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(xattrs)))
 	for _, e := range indexEntries {
 		b2 := make([]byte, 8)
 		binary.LittleEndian.PutUint64(b2, e)
@@ -1055,7 +1086,7 @@ func writeXattrs(xattrs []map[string]string, f backend.WritableFile, compressor
 	// just write it out
 	written, err := f.WriteAt(b, location)
 	if err != nil {
-		return xattrsWritten, 0, fmt.Errorf("error writing xattrs id index: %v", err)
+		return xattrsWritten, 0, fmt.Errorf("error writing xattrs id index: %w", err)
 	}
 	xattrsWritten += written
 
@@ -1082,7 +1113,7 @@ func xAttrKeyConvert(key string) (prefixID uint16, prefix string, err error) {
 }
 
 // createInodes create an inode of appropriate type for each file, and attach it to the finalizeFileInfo
-func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, options FinalizeOptions) error {
+func createInodes(fileList []*finalizeFileInfo, ws string, idtable map[uint32]uint16, options FinalizeOptions) error {
 	// get the inodes
 	var inodeIndex uint32 = 1
 
@@ -1103,7 +1134,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				- it has extended attributes
 				- it has hard links
 			*/
-			if e.startBlock|uint32max != uint32max || e.Size()|int64(uint32max) != int64(uint32max) || len(e.xattrs) > 0 || e.links > 0 {
+			if e.startBlock|uint32max != uint32max || e.Size()|int64(uint32max) != int64(uint32max) || len(e.xattrs) > 0 || e.links > 1 {
 				// use extendedFile inode
 				ef := &extendedFile{
 					blocksStart: uint64(e.dataLocation),
@@ -1115,6 +1146,8 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				if e.fragment != nil {
 					ef.fragmentBlockIndex = e.fragment.block
 					ef.fragmentOffset = e.fragment.offset
+				} else {
+					ef.fragmentBlockIndex = 0xffffffff
 				}
 				in = ef
 				inodeT = inodeExtendedFile
@@ -1143,9 +1176,9 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				- it has extended attributes
 				- it has hard links
 			*/
-			target, err := os.Readlink(e.path)
+			target, err := os.Readlink(filepath.Join(ws, e.path))
 			if err != nil {
-				return fmt.Errorf("unable to read target for symlink at %s: %v", e.path, err)
+				return fmt.Errorf("unable to read target for symlink at %s: %w", e.path, err)
 			}
 			if len(e.xattrs) > 0 {
 				in = &extendedSymlink{
@@ -1168,7 +1201,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				- the size of the directory does not fit in a single metadata block, i.e. >8K uncompressed
 				- it has more than 256 entries
 			*/
-			if e.startBlock|uint32max != uint32max || e.Size()|int64(uint32max) != int64(uint32max) || len(e.xattrs) > 0 || e.links > 0 {
+			if e.startBlock|uint32max != uint32max || e.Size()|int64(uint32max) != int64(uint32max) || len(e.xattrs) > 0 {
 				// use extendedDirectory inode
 				in = &extendedDirectory{
 					startBlock: uint32(e.startBlock),
@@ -1187,9 +1220,9 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicDirectory
 			}
 		case fileBlock:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := getDeviceNumbers(filepath.Join(ws, e.path))
 			if err != nil {
-				return fmt.Errorf("unable to read major/minor device numbers for block device at %s: %v", e.path, err)
+				return fmt.Errorf("unable to read major/minor device numbers for block device at %s: %w", e.path, err)
 			}
 			if len(e.xattrs) > 0 {
 				in = &extendedBlock{
@@ -1212,9 +1245,9 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicBlock
 			}
 		case fileChar:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := getDeviceNumbers(filepath.Join(ws, e.path))
 			if err != nil {
-				return fmt.Errorf("unable to read major/minor device numbers for char device at %s: %v", e.path, err)
+				return fmt.Errorf("unable to read major/minor device numbers for char device at %s: %w", e.path, err)
 			}
 			if len(e.xattrs) > 0 {
 				in = &extendedChar{
@@ -1359,18 +1392,72 @@ type blockPosition struct {
 	size   int
 }
 
-// createDirectories append directory structure to every finalizeFileInfo that is a directory.
-// Returns a slice pointing to all finalizeFileInfo that are directories and have been populated.
-func createDirectories(e *finalizeFileInfo) []*finalizeFileInfo {
-	var (
-		dirs    = make([]*finalizeFileInfo, 0)
-		entries = make([]*directoryEntryRaw, 0)
-	)
-	// go through each entry, and create a directory structure for it
-	// we will cycle through each directory, creating an entry for it
-	// and its children. A second pass will split into headers
+// buildMetadataTables lays out the inodes and directories of the files, the root first, in an inode
+// table and a directory table, and returns them as written. The inodes of everything but the
+// directories come first, and each directory after everything in it, so that the entries of a
+// directory know where the inodes are that they point to, and its inode where they are.
+func buildMetadataTables(fileList []*finalizeFileInfo, compressor Compressor) (inodeTable, dirTable []byte, err error) {
+	inodes := &metadataWriter{compressor: compressor}
+	dirs := &metadataWriter{compressor: compressor}
+	for _, e := range fileList {
+		if e.IsDir() {
+			continue
+		}
+		e.inodeLocation = inodes.position()
+		if err := inodes.write(e.inode.toBytes()); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := writeDirectoryTree(fileList[0], inodes, dirs); err != nil {
+		return nil, nil, err
+	}
+	if inodeTable, err = inodes.bytes(); err != nil {
+		return nil, nil, err
+	}
+	if dirTable, err = dirs.bytes(); err != nil {
+		return nil, nil, err
+	}
+	return inodeTable, dirTable, nil
+}
+
+// writeDirectoryTree adds the directory e and those within it to the tables, each after those within
+// it: its entries to the directory table, and then its inode to the inode table
+func writeDirectoryTree(e *finalizeFileInfo, inodes, dirs *metadataWriter) error {
 	for _, child := range e.children {
-		blockPos := child.inodeLocation
+		if child.IsDir() {
+			if err := writeDirectoryTree(child, inodes, dirs); err != nil {
+				return err
+			}
+		}
+	}
+	e.directory = createDirectory(e)
+	start, size, indexes, err := writeDirectory(e.directory, dirs)
+	if err != nil {
+		return fmt.Errorf("error writing directory %s: %w", e.path, err)
+	}
+	e.directoryLocation = blockPosition{
+		block:  start.block,
+		offset: start.offset,
+		size:   size + 3,
+	}
+	if err := updateDirectoryInode(e, indexes); err != nil {
+		return err
+	}
+	e.inodeLocation = inodes.position()
+	return inodes.write(e.inode.toBytes())
+}
+
+// createDirectory returns the entries of the directory e, whose children have their inodes laid out
+func createDirectory(e *finalizeFileInfo) *directory {
+	entries := make([]*directoryEntryRaw, 0, len(e.children))
+	for _, child := range e.children {
+		// a hard link is an entry for the inode of the file it links to
+		in := child
+		if child.linkOf != nil {
+			in = child.linkOf
+		}
+		blockPos := in.inodeLocation
+		// set the inode type. It doesn't use extended, just the basic ones.
 		var iType inodeType
 		switch child.fileType {
 		case fileRegular:
@@ -1388,100 +1475,83 @@ func createDirectories(e *finalizeFileInfo) []*finalizeFileInfo {
 		case fileSocket:
 			iType = inodeBasicSocket
 		}
-		entry := &directoryEntryRaw{
+		entries = append(entries, &directoryEntryRaw{
 			name:           child.Name(),
 			isSubdirectory: child.IsDir(),
 			startBlock:     blockPos.block,
 			offset:         blockPos.offset,
 			inodeType:      iType,
-			inodeNumber:    child.inode.index(),
-			// we do not yet know the inodeNumber, which is an offset from the one in the header
-			// it will be filled in later
-		}
-		// set the inode type. It doesn't use extended, just the basic ones.
-		entries = append(entries, entry)
+			inodeNumber:    in.inode.index(),
+		})
 	}
-	e.directory = &directory{
+	return &directory{
 		entries: entries,
 	}
-	dirs = append(dirs, e)
-	// do children in a separate loop, so that we get all of the children lined up
-	for _, child := range e.children {
-		if child.IsDir() {
-			dirs = append(dirs, createDirectories(child)...)
-		}
-	}
-	return dirs
-}
-
-// updateInodeLocations update each inode with where it will be on disk
-// i.e. the inode block, and the offset into the block
-func updateInodeLocations(files []*finalizeFileInfo) {
-	var pos int64
-
-	// get block position for each inode
-	for _, f := range files {
-		b := f.inode.toBytes()
-		block, offset := uint32(pos/metadataBlockSize), uint16(pos%metadataBlockSize)
-		blockPos := block * (standardMetadataBlocksize + 2)
-		f.inodeLocation = blockPosition{
-			block:  blockPos,
-			offset: offset,
-			size:   len(b),
-		}
-		pos += int64(len(b))
-	}
 }
 
-// populateDirectoryLocations get a map of each directory index and where it will be
-// on disk i.e. the directory block, and the offset into the block
-func populateDirectoryLocations(directories []*finalizeFileInfo) {
-	// keeps our reference
-	pos := 0
-
-	// get block position for each inode
-	for _, d := range directories {
-		// we start without knowing the inode block/number
-		// in any case, this func is just here to give us sizes and therefore
-		// locations inside the directory metadata blocks, not actual writable
-		// bytes
-		if d.directory == nil {
-			continue
+// writeDirectory adds the entries of the directory to the directory table, and returns where they
+// start, their size, and the index of the directory if they span more than one metadata block.
+//
+// The index is stored at the end of the extended inode of the directory. There is one entry for
+// each block after the first, pointing to the first header in it. The filenames in the directory
+// are sorted alphabetically, so a lookup reads the entries from the last index entry whose name
+// is not larger than the one looked up.
+//
+//	b[0:4] uint32 index - number of bytes where the header is from the beginning of this directory
+//	b[4:8] uint32 startBlock - number of bytes from the start of the directory table to the block
+//	b[8:12] uint32 size - size of the name (-1)
+//	b[12:12+size] string name - of the first entry after the header
+func writeDirectory(d *directory, dirs *metadataWriter) (start blockPosition, size int, indexes []*directoryIndex, err error) {
+	start = dirs.position()
+	for i, group := range d.groups(int(start.offset)) {
+		pos := dirs.position()
+		if i > 0 && pos.block != start.block && (len(indexes) == 0 || pos.block != indexes[len(indexes)-1].block) {
+			indexes = append(indexes, &directoryIndex{
+				index: uint32(size),
+				block: pos.block,
+				name:  group.entries[0].name,
+			})
 		}
-		b := d.directory.toBytes(0)
-		d.directoryLocation = blockPosition{
-			block:  uint32(pos / int(metadataBlockSize)),
-			offset: uint16(pos % int(metadataBlockSize)),
-			size:   len(b) + 3,
+		b := group.toBytes()
+		if err := dirs.write(b); err != nil {
+			return start, 0, nil, err
 		}
-		pos += len(b)
+		size += len(b)
 	}
+	return start, size, indexes, nil
 }
 
-// updateInodesFromDirectories update the blockPosition for each directory
-// inode.
-func updateInodesFromDirectories(files []*finalizeFileInfo) error {
-	// go through each directory, find its inode, and update it with the
-	// correct block and offset
-	for i, d := range files {
-		if d.directory == nil {
-			return fmt.Errorf("file at index %d missing directory information", i)
-		}
-		index := d.directory.inodeIndex
-		in := d.inode
-		inBody := in.getBody()
-		switch dir := inBody.(type) {
-		case *basicDirectory:
-			dir.startBlock = d.directoryLocation.block
-			dir.offset = d.directoryLocation.offset
-			dir.fileSize = uint16(d.directoryLocation.size)
-		case *extendedDirectory:
-			dir.startBlock = d.directoryLocation.block
-			dir.offset = d.directoryLocation.offset
-			dir.fileSize = uint32(d.directoryLocation.size)
-		default:
-			return fmt.Errorf("inode at index %d from directory at index %d was unexpected type", index, i)
-		}
-	}
+// updateDirectoryInode points the inode of the directory e to where its entries are, making it an
+// extended directory inode if they need its index, or a larger size
+func updateDirectoryInode(e *finalizeFileInfo, indexes []*directoryIndex) error {
+	loc := e.directoryLocation
+	in, ok := e.inode.(*inodeImpl)
+	if !ok {
+		return fmt.Errorf("inode of directory %s was unexpected type", e.path)
+	}
+	switch dir := in.body.(type) {
+	case *basicDirectory:
+		if len(indexes) == 0 && loc.size <= math.MaxUint16 {
+			dir.startBlock = loc.block
+			dir.offset = loc.offset
+			dir.fileSize = uint16(loc.size)
+			return nil
+		}
+		in.header.inodeType = inodeExtendedDirectory
+		in.body = &extendedDirectory{
+			links:            dir.links,
+			parentInodeIndex: dir.parentInodeIndex,
+			xAttrIndex:       noXattrInodeFlag,
+		}
+	case *extendedDirectory:
+	default:
+		return fmt.Errorf("inode of directory %s was unexpected type", e.path)
+	}
+	dir, _ := in.body.(*extendedDirectory)
+	dir.startBlock = loc.block
+	dir.offset = loc.offset
+	dir.fileSize = uint32(loc.size)
+	dir.indexCount = uint16(len(indexes))
+	dir.indexes = indexes
 	return nil
 }
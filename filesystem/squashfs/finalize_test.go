@@ -2,16 +2,23 @@ package squashfs_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/mem"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
 	"github.com/diskfs/go-diskfs/testhelper"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 var (
@@ -189,3 +196,251 @@ func validateSquashfs(t *testing.T, f *os.File) {
 		t.Log(outString)
 	}
 }
+
+func TestFinalizeReadOnly(t *testing.T) {
+	fs, err := squashfs.Create(mem.New(make([]byte, 5*1024*1024), true), 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+		t.Errorf("error %v instead of read-only filesystem", err)
+	}
+}
+
+func TestFinalizeCanceled(t *testing.T) {
+	fs, err := squashfs.Create(mem.New(make([]byte, 5*1024*1024), false), 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/large", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write(make([]byte, 1024*1024)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fs.FinalizeContext(ctx, squashfs.FinalizeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of %v", err, context.Canceled)
+	}
+}
+
+func TestFinalizeProgress(t *testing.T) {
+	fs, err := squashfs.Create(mem.New(make([]byte, 5*1024*1024), false), 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	for _, name := range []string{"/large1", "/large2"} {
+		f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := f.Write(make([]byte, 100000)); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	var updates []int64
+	progress := func(phase string, done, total int64, _ string) {
+		if phase != util.PhaseData || total != 200000 {
+			t.Errorf("progress %s %d/%d", phase, done, total)
+		}
+		updates = append(updates, done)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{Progress: util.ProgressFunc(progress)}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	if len(updates) != 2 || updates[0] != 100000 || updates[1] != 200000 {
+		t.Errorf("progress reported %v", updates)
+	}
+}
+
+func TestFinalizeProcessors(t *testing.T) {
+	// files of several blocks, compressible or not, so that blocks compressed at the same time
+	// have different sizes
+	random := make([]byte, 5*4096+100)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"/zeros":  make([]byte, 7*4096),
+		"/random": random,
+		"/text":   bytes.Repeat([]byte("squashfs "), 4000),
+	}
+	for _, processors := range []int{1, 4} {
+		b := mem.New(make([]byte, 5*1024*1024), false)
+		fs, err := squashfs.Create(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		for name, data := range files {
+			f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := f.Write(data); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+		}
+		if err := fs.Finalize(squashfs.FinalizeOptions{Processors: processors}); err != nil {
+			t.Fatalf("%d processors: error finalizing filesystem: %v", processors, err)
+		}
+		fs, err = squashfs.Read(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("%d processors: error reading filesystem: %v", processors, err)
+		}
+		for name, data := range files {
+			f, err := fs.OpenFile(name, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("%d processors: error opening %s: %v", processors, name, err)
+			}
+			read, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("%d processors: error reading %s: %v", processors, name, err)
+			}
+			if !bytes.Equal(read, data) {
+				t.Errorf("%d processors: read %d bytes of %s that differ from the %d written", processors, len(read), name, len(data))
+			}
+		}
+	}
+}
+
+func TestFinalizeLogger(t *testing.T) {
+	fs, err := squashfs.Create(mem.New(make([]byte, 1024*1024), false), 0, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	if err := fs.Finalize(squashfs.FinalizeOptions{Logger: logger}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	// the tree is walked and its fragments written at the same time as its data
+	for _, phase := range []string{"data", "inodes", "tables", "superblock"} {
+		if !strings.Contains(logs.String(), "phase="+phase+" duration=") {
+			t.Errorf("no time of phase %s in %q", phase, logs.String())
+		}
+	}
+	if !strings.Contains(logs.String(), `msg=done op="squashfs finalize"`) {
+		t.Errorf("no total time in %q", logs.String())
+	}
+}
+
+func TestFinalizeLargeDirectory(t *testing.T) {
+	// enough files for the entries of the directory to take several metadata blocks, so that it
+	// has an index, and several headers in each
+	count := 3000
+	for _, options := range []squashfs.FinalizeOptions{{}, {NoCompressInodes: true}} {
+		b := mem.New(make([]byte, 10*1024*1024), false)
+		fs, err := squashfs.Create(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		if err := fs.Mkdir("/dir/sub"); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		for i := 0; i < count; i++ {
+			f, err := fs.OpenFile(fmt.Sprintf("/dir/sub/file_%05d", i), os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := fmt.Fprintf(f, "contents %d\n", i); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+		}
+		if err := fs.Mkdir("/dir/sub/zz/last"); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		fs, err = squashfs.Read(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		entries, err := fs.ReadDir("/dir/sub")
+		if err != nil {
+			t.Fatalf("error reading directory: %v", err)
+		}
+		if len(entries) != count+1 {
+			t.Errorf("read %d entries instead of %d", len(entries), count+1)
+		}
+		for _, i := range []int{0, 1, 255, 256, 257, 1000, 1500, 2047, count - 1} {
+			name := fmt.Sprintf("dir/sub/file_%05d", i)
+			data, err := fs.ReadFile(name)
+			if err != nil {
+				t.Errorf("error reading %s: %v", name, err)
+				continue
+			}
+			if expected := fmt.Sprintf("contents %d\n", i); string(data) != expected {
+				t.Errorf("read %q from %s instead of %q", data, name, expected)
+			}
+		}
+		info, err := fs.Lstat("/dir/sub/zz/last")
+		if err != nil || !info.IsDir() {
+			t.Errorf("lstat of directory below the large one: %v, %v", info, err)
+		}
+		for _, name := range []string{"/dir/sub/a", "/dir/sub/file_01000x", "/dir/sub/file_99999", "/dir/sub/zzz"} {
+			if _, err := fs.Lstat(name); !errors.Is(err, iofs.ErrNotExist) {
+				t.Errorf("lstat of %s: error %v instead of %v", name, err, iofs.ErrNotExist)
+			}
+		}
+	}
+}
+
+func TestFinalizeFragments(t *testing.T) {
+	// files of all sizes, whose ends fill many blocks of fragments, written among their data
+	files := map[string][]byte{}
+	for i := 0; i < 200; i++ {
+		data := make([]byte, i*73%9000)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		files[fmt.Sprintf("/dir%d/file%d", i%3, i)] = data
+	}
+	images := map[squashfs.FinalizeOptions][]byte{}
+	for _, options := range []squashfs.FinalizeOptions{{Processors: 1}, {Processors: 4}, {Processors: 4, NoFragments: true}} {
+		img := make([]byte, 5*1024*1024)
+		b := mem.New(img, false)
+		fs, err := squashfs.Create(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		for _, dir := range []string{"/dir0", "/dir1", "/dir2"} {
+			if err := fs.Mkdir(dir); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+		}
+		for name, data := range files {
+			f, err := fs.OpenFile(name, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			if _, err := f.Write(data); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("%+v: error finalizing filesystem: %v", options, err)
+		}
+		images[options] = img
+		fs, err = squashfs.Read(b, 0, 0, 4096)
+		if err != nil {
+			t.Fatalf("%+v: error reading filesystem: %v", options, err)
+		}
+		for name, data := range files {
+			read, err := fs.ReadFile(strings.TrimPrefix(name, "/"))
+			if err != nil {
+				t.Fatalf("%+v: error reading %s: %v", options, name, err)
+			}
+			if !bytes.Equal(read, data) {
+				t.Errorf("%+v: read %d bytes of %s that differ from the %d written", options, len(read), name, len(data))
+			}
+		}
+	}
+	// the data is the same whatever the number of processors, unlike the times of the inodes after it
+	one, four := images[squashfs.FinalizeOptions{Processors: 1}], images[squashfs.FinalizeOptions{Processors: 4}]
+	if !bytes.Equal(one[96:512*1024], four[96:512*1024]) {
+		t.Errorf("data written by 4 processors differs from that written by 1")
+	}
+}
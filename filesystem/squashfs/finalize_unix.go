@@ -6,6 +6,7 @@ package squashfs
 
 import (
 	"os"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
@@ -16,12 +17,12 @@ func getDeviceNumbers(path string) (major, minor uint32, err error) {
 	if err != nil {
 		return 0, 0, err
 	}
-	return uint32(stat.Rdev / 256), uint32(stat.Rdev % 256), nil
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), nil
 }
 
 func getFileProperties(fi os.FileInfo) (links, uid, gid uint32) {
 	if sys := fi.Sys(); sys != nil {
-		if stat, ok := sys.(*unix.Stat_t); ok {
+		if stat, ok := sys.(*syscall.Stat_t); ok {
 			links = uint32(stat.Nlink)
 			uid = stat.Uid
 			gid = stat.Gid
@@ -30,6 +31,15 @@ func getFileProperties(fi os.FileInfo) (links, uid, gid uint32) {
 	return links, uid, gid
 }
 
+// getInodeNumber returns the number of the inode of the file in the workspace, shared by its hard
+// links
+func getInodeNumber(fi os.FileInfo) (ino uint64, ok bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino), true
+	}
+	return 0, false
+}
+
 //nolint:deadcode // this is here solely so that linter does not complain on darwin about unconvert
 func unused() uint32 {
 	var f uint32 = 25
@@ -12,3 +12,7 @@ func getDeviceNumbers(path string) (uint32, uint32, error) {
 func getFileProperties(fi os.FileInfo) (uint32, uint32, uint32) {
 	return 0, 0, 0
 }
+
+func getInodeNumber(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -39,6 +39,7 @@ type finalizeFileInfo struct {
 	xattrs            map[string]string
 	xAttrIndex        uint32
 	links             uint32
+	linkOf            *finalizeFileInfo // the file this is a hard link to, whose inode it shares
 	blocks            []*blockData
 	startBlock        uint64
 	fragment          *fragmentRef
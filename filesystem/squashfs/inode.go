@@ -26,9 +26,32 @@ const (
 	inodeExtendedSocket    inodeType = 14
 )
 
+// fileMode returns the type bits of os.FileMode for the inode type
+func (i inodeType) fileMode() os.FileMode {
+	switch i {
+	case inodeBasicDirectory, inodeExtendedDirectory:
+		return os.ModeDir // d: is a directory
+	case inodeBasicFile, inodeExtendedFile:
+		return 0 // zero mode
+	case inodeBasicSymlink, inodeExtendedSymlink:
+		return os.ModeSymlink // L: symbolic link
+	case inodeBasicBlock, inodeExtendedBlock:
+		return os.ModeDevice // D: device file
+	case inodeBasicChar, inodeExtendedChar:
+		return os.ModeDevice | os.ModeCharDevice // c: Unix character device, when ModeDevice is set
+	case inodeBasicFifo, inodeExtendedFifo:
+		return os.ModeNamedPipe // p: named pipe (FIFO)
+	case inodeBasicSocket, inodeExtendedSocket:
+		return os.ModeSocket // S: Unix domain socket
+	default:
+		return os.ModeIrregular // ?: non-regular file; nothing else is known about this file
+	}
+}
+
 const (
-	inodeHeaderSize              = 16
-	inodeDirectoryIndexEntrySize = 3*4 + 1
+	inodeHeaderSize = 16
+	// inodeDirectoryIndexEntrySize is the size of an entry of the index of a directory, before its name
+	inodeDirectoryIndexEntrySize = 3 * 4
 )
 
 type inodeHeader struct {
@@ -250,12 +273,26 @@ func parseBasicDirectory(b []byte) (*basicDirectory, error) {
 	return d, nil
 }
 
-// directoryIndex
+// directoryIndex is an entry of the index of a large directory, at the end of its extended inode,
+// which points to one of the headers of its entries, so that a lookup reads only the entries from
+// the last header with a name before the one looked up. There is one for each metadata block of the
+// directory after the first.
 type directoryIndex struct {
+	// index is the position of the header in the entries of the directory
 	index uint32
+	// block is the position of the metadata block with the header, from the start of the directory
+	// table
 	block uint32
-	size  uint32
-	name  byte
+	// name is that of the first entry after the header
+	name string
+}
+
+func (d *directoryIndex) toBytes() []byte {
+	b := make([]byte, inodeDirectoryIndexEntrySize, inodeDirectoryIndexEntrySize+len(d.name))
+	binary.LittleEndian.PutUint32(b[0:4], d.index)
+	binary.LittleEndian.PutUint32(b[4:8], d.block)
+	binary.LittleEndian.PutUint32(b[8:12], uint32(len(d.name)-1))
+	return append(b, d.name...)
 }
 
 // extendedDirectory
@@ -279,6 +316,9 @@ func (i extendedDirectory) toBytes() []byte {
 	binary.LittleEndian.PutUint16(b[16:18], i.indexCount)
 	binary.LittleEndian.PutUint16(b[18:20], i.offset)
 	binary.LittleEndian.PutUint32(b[20:24], i.xAttrIndex)
+	for _, index := range i.indexes {
+		b = append(b, index.toBytes()...)
+	}
 	return b
 }
 func (i extendedDirectory) size() int64 {
@@ -306,10 +346,7 @@ func (i extendedDirectory) equal(o inodeBody) bool {
 }
 
 func parseExtendedDirectory(b []byte) (*extendedDirectory, int, error) {
-	var (
-		target = 24
-		extra  int
-	)
+	target := 24
 	if len(b) < target {
 		return nil, 0, fmt.Errorf("received %d bytes, fewer than minimum %d", len(b), target)
 	}
@@ -322,48 +359,42 @@ func parseExtendedDirectory(b []byte) (*extendedDirectory, int, error) {
 		offset:           binary.LittleEndian.Uint16(b[18:20]),
 		xAttrIndex:       binary.LittleEndian.Uint32(b[20:24]),
 	}
-	// see how many other bytes we need to read for directory indexes
-	//
-	// each entry in indexes is a struct squashfs_dir_index, which is:
+	// the indexes follow, each a struct squashfs_dir_index, which is:
 	// struct squashfs_dir_index {
 	//      unsigned int            index;
 	//      unsigned int            start_block;
 	//      unsigned int            size;
 	//      unsigned char           name[0];
 	// };
-	// so each is 4 int + 1 char
-	extra = int(d.indexCount) * inodeDirectoryIndexEntrySize
-	// do we have enough data left to read those?
-	if len(b[target:]) >= extra {
-		indexes, err := parseDirectoryIndexes(b[target:target+extra], int(d.indexCount))
-		if err != nil {
-			return d, 0, err
-		}
+	// with a name of size+1 bytes, so that the bytes needed are known only as they are read
+	indexes, extra := parseDirectoryIndexes(b[target:], int(d.indexCount))
+	if extra == 0 {
 		d.indexes = indexes
-		extra = 0
 	}
-
 	return d, extra, nil
 }
 
-// parseDirectoryIndexes parse count directoryIndex from the given byte data
-func parseDirectoryIndexes(b []byte, count int) ([]*directoryIndex, error) {
-	var (
-		indexes      []*directoryIndex
-		expectedSize = count * inodeDirectoryIndexEntrySize
-	)
-	if len(b) < expectedSize {
-		return nil, fmt.Errorf("expected at least %d bytes, received only %d", expectedSize, len(b))
-	}
-	for i := 0; i < len(b); i += inodeDirectoryIndexEntrySize {
+// parseDirectoryIndexes parses count directoryIndex from the given byte data, or returns how many
+// bytes at least they need if there are not enough
+func parseDirectoryIndexes(b []byte, count int) (indexes []*directoryIndex, extra int) {
+	pos := 0
+	for i := 0; i < count; i++ {
+		if len(b) < pos+inodeDirectoryIndexEntrySize {
+			return nil, pos + (count-i)*(inodeDirectoryIndexEntrySize+1)
+		}
+		nameSize := int(binary.LittleEndian.Uint32(b[pos+8:pos+12])) + 1
+		end := pos + inodeDirectoryIndexEntrySize + nameSize
+		if len(b) < end {
+			return nil, end + (count-i-1)*(inodeDirectoryIndexEntrySize+1)
+		}
 		indexes = append(indexes, &directoryIndex{
-			index: binary.LittleEndian.Uint32(b[0:4]),
-			block: binary.LittleEndian.Uint32(b[4:8]),
-			size:  binary.LittleEndian.Uint32(b[8:12]),
-			name:  b[12],
+			index: binary.LittleEndian.Uint32(b[pos : pos+4]),
+			block: binary.LittleEndian.Uint32(b[pos+4 : pos+8]),
+			name:  string(b[pos+inodeDirectoryIndexEntrySize : end]),
 		})
+		pos = end
 	}
-	return indexes, nil
+	return indexes, 0
 }
 
 // basicFile
@@ -681,6 +712,11 @@ func (i basicDevice) equal(o inodeBody) bool {
 	}
 	return true
 }
+
+// numbers returns the major and minor numbers of the device
+func (i basicDevice) numbers() (major, minor uint32) {
+	return i.major, i.minor
+}
 func parseBasicDevice(b []byte) (*basicDevice, error) {
 	target := 8
 	if len(b) < target {
@@ -741,6 +777,10 @@ func (i extendedDevice) equal(o inodeBody) bool {
 	return true
 }
 
+// numbers returns the major and minor numbers of the device
+func (i extendedDevice) numbers() (major, minor uint32) {
+	return i.major, i.minor
+}
 func parseExtendedDevice(b []byte) (*extendedDevice, error) {
 	target := 12
 	if len(b) < target {
@@ -748,7 +788,7 @@ func parseExtendedDevice(b []byte) (*extendedDevice, error) {
 	}
 	basic, err := parseBasicDevice(b[:8])
 	if err != nil {
-		return nil, fmt.Errorf("error parsing block device: %v", err)
+		return nil, fmt.Errorf("error parsing block device: %w", err)
 	}
 	return &extendedDevice{
 		links:      basic.links,
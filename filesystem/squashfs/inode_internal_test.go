@@ -193,13 +193,51 @@ func TestBasicDirectory(t *testing.T) {
 	})
 }
 
-//nolint:unused,revive // keep for future when we implement it and will need t
 func TestExtendedDirectory(t *testing.T) {
-	// do some day when we have good raw data
-
-	// func (i extendedDirectory) toBytes() []byte {
-	// func (i extendedDirectory) size() int64 {
-	// func parseExtendedDirectory(b []byte) (*extendedDirectory, error) {
+	dir := extendedDirectory{
+		links:            2,
+		fileSize:         20000,
+		startBlock:       100,
+		parentInodeIndex: 1,
+		indexCount:       2,
+		offset:           4000,
+		xAttrIndex:       noXattrInodeFlag,
+		indexes: []*directoryIndex{
+			{index: 4190, block: 2010, name: "file_00200"},
+			{index: 12382, block: 4030, name: "g"},
+		},
+	}
+	b := dir.toBytes()
+	if len(b) != 24+2*inodeDirectoryIndexEntrySize+len("file_00200")+len("g") {
+		t.Fatalf("%d bytes of extended directory", len(b))
+	}
+	t.Run("parse", func(t *testing.T) {
+		d, extra, err := parseExtendedDirectory(b)
+		switch {
+		case err != nil:
+			t.Errorf("unexpected error: %v", err)
+		case extra != 0:
+			t.Errorf("%d extra bytes needed", extra)
+		case !dir.equal(*d):
+			t.Errorf("mismatched results, actual then expected")
+			t.Logf("%#v", *d)
+			t.Logf("%#v", dir)
+		}
+	})
+	t.Run("short", func(t *testing.T) {
+		// the bytes needed are known as far as the names read so far
+		for _, size := range []int{24, 24 + inodeDirectoryIndexEntrySize, 24 + inodeDirectoryIndexEntrySize + 10, len(b) - 1} {
+			d, extra, err := parseExtendedDirectory(b[:size])
+			switch {
+			case err != nil:
+				t.Errorf("%d bytes: unexpected error: %v", size, err)
+			case d.indexes != nil:
+				t.Errorf("%d bytes: parsed indexes %v", size, d.indexes)
+			case extra <= size-24 || 24+extra > len(b):
+				t.Errorf("%d bytes: %d extra bytes needed, of %d", size, extra, len(b)-24)
+			}
+		}
+	})
 }
 
 func TestBasicFile(t *testing.T) {
@@ -33,7 +33,7 @@ func parseMetadata(b []byte, c Compressor) (block *metadatablock, err error) {
 	}
 	size, compressed, err := getMetadataSize(b[:2])
 	if err != nil {
-		return nil, fmt.Errorf("error reading metadata header: %v", err)
+		return nil, fmt.Errorf("error reading metadata header: %w", err)
 	}
 	if len(b) < int(2+size) {
 		return nil, fmt.Errorf("metadata header said size should be %d but was only %d", size, len(b)-2)
@@ -42,7 +42,7 @@ func parseMetadata(b []byte, c Compressor) (block *metadatablock, err error) {
 	if compressed {
 		data, err = c.decompress(data)
 		if err != nil {
-			return nil, fmt.Errorf("decompress error: %v", err)
+			return nil, fmt.Errorf("decompress error: %w", err)
 		}
 	}
 	return &metadatablock{
@@ -63,7 +63,7 @@ func (m *metadatablock) toBytes(c Compressor) ([]byte, error) {
 	} else {
 		data, err = c.compress(m.data)
 		if err != nil {
-			return nil, fmt.Errorf("compression error: %v", err)
+			return nil, fmt.Errorf("compression error: %w", err)
 		}
 	}
 	header |= uint16(len(data))
@@ -79,12 +79,18 @@ func (fs *FileSystem) readMetaBlock(r io.ReaderAt, c Compressor, location int64)
 		_, _ = r.ReadAt(b, location)
 		size, compressed, err := getMetadataSize(b)
 		if err != nil {
-			return nil, 0, fmt.Errorf("error getting size and compression for metadata block at %d: %v", location, err)
+			return nil, 0, fmt.Errorf("error getting size and compression for metadata block at %d: %w", location, err)
+		}
+		if compressed {
+			// only needed until it is decompressed
+			b = fs.buffers.Get(int(size))
+			defer fs.buffers.Put(b)
+		} else {
+			b = make([]byte, size)
 		}
-		b = make([]byte, size)
 		read, err := r.ReadAt(b, location+2)
 		if err != nil && err != io.EOF {
-			return nil, 0, fmt.Errorf("unable to read metadata block of size %d at location %d: %v", size, location, err)
+			return nil, 0, fmt.Errorf("unable to read metadata block of size %d at location %d: %w", size, location, err)
 		}
 		if read != len(b) {
 			return nil, 0, fmt.Errorf("read %d instead of expected %d bytes for metadata block at location %d", read, size, location)
@@ -94,9 +100,9 @@ func (fs *FileSystem) readMetaBlock(r io.ReaderAt, c Compressor, location int64)
 			if c == nil {
 				return nil, 0, fmt.Errorf("metadata block at %d compressed, but no compressor provided", location)
 			}
-			data, err = c.decompress(b)
+			data, err = decompressTo(c, make([]byte, 0, metadataBlockSize), b)
 			if err != nil {
-				return nil, 0, fmt.Errorf("decompress error: %v", err)
+				return nil, 0, fmt.Errorf("decompress error: %w", err)
 			}
 		}
 		return data, size + 2, nil
@@ -130,3 +136,47 @@ func (fs *FileSystem) readMetadata(r io.ReaderAt, c Compressor, firstBlock int64
 	}
 	return b, nil
 }
+
+// metadataWriter builds a table of metadata blocks in memory, compressing each block as it fills,
+// so that where anything added to the table is found is known as it is added: the position of its
+// block from the start of the table, and its offset into the uncompressed block
+type metadataWriter struct {
+	compressor Compressor
+	blocks     []byte
+	pending    []byte
+}
+
+// position returns where what is added next is found
+func (m *metadataWriter) position() blockPosition {
+	return blockPosition{
+		block:  uint32(len(m.blocks)),
+		offset: uint16(len(m.pending)),
+	}
+}
+
+// write adds to the table
+func (m *metadataWriter) write(b []byte) error {
+	m.pending = append(m.pending, b...)
+	for len(m.pending) >= int(metadataBlockSize) {
+		block, err := metadataBlockBytes(m.pending[:metadataBlockSize], m.compressor)
+		if err != nil {
+			return err
+		}
+		m.blocks = append(m.blocks, block...)
+		m.pending = append(m.pending[:0], m.pending[metadataBlockSize:]...)
+	}
+	return nil
+}
+
+// bytes returns the table, ending with the last block however full it is
+func (m *metadataWriter) bytes() ([]byte, error) {
+	if len(m.pending) > 0 {
+		block, err := metadataBlockBytes(m.pending, m.compressor)
+		if err != nil {
+			return nil, err
+		}
+		m.blocks = append(m.blocks, block...)
+		m.pending = nil
+	}
+	return m.blocks, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package squashfs
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+func mknod(_ string, _ uint32, _ int) error {
+	return fmt.Errorf("nodes in the workspace on %s: %w", runtime.GOOS, filesystem.ErrNotSupported)
+}
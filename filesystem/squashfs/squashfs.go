@@ -2,14 +2,24 @@ package squashfs
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	iofs "io/fs"
 	"math"
 	"os"
 	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/pkg/xattr"
 )
 
 const (
@@ -32,8 +42,12 @@ type FileSystem struct {
 	fragments  []*fragmentEntry
 	uidsGids   []uint32
 	xattrs     *xAttrTable
-	rootDir    inode
-	cache      *lru
+	// tablesMu guards fragments, uidsGids and xattrs, which are read when first needed
+	tablesMu sync.Mutex
+	rootDir  inode
+	cache    *lru
+	// buffers are the buffers of compressed blocks read, and of blocks decompressed for ReadAt
+	buffers *util.BufferPool
 }
 
 // Equal compare if two filesystems are equal
@@ -49,7 +63,7 @@ func (fs *FileSystem) Label() string {
 }
 
 func (fs *FileSystem) SetLabel(string) error {
-	return filesystem.ErrReadonlyFilesystem
+	return filesystem.ErrReadOnlyFilesystem
 }
 
 // Workspace get the workspace path
@@ -75,7 +89,7 @@ func CreateFromPath(dir string) (*FileSystem, error) {
 	// Create a temporary working area
 	tmpdir, err := os.MkdirTemp("", "diskfs_squashfs")
 	if err != nil {
-		return nil, fmt.Errorf("could not create working directory: %v", err)
+		return nil, fmt.Errorf("could not create working directory: %w", err)
 	}
 
 	// Copy the contents of the source directory to the workspace
@@ -86,8 +100,8 @@ func CreateFromPath(dir string) (*FileSystem, error) {
 	}
 
 	return &FileSystem{
-		workspace:  tmpdir,
-		blocksize:  defaultBlockSize,
+		workspace: tmpdir,
+		blocksize: defaultBlockSize,
 	}, nil
 }
 
@@ -103,20 +117,20 @@ func (fs *FileSystem) WriteToFile(filePath string, options *FinalizeOptions) err
 	}
 
 	// Create the output file
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
-	defer file.Close()
+	defer f.Close()
 
 	// Get file info to determine size
-	fileInfo, err := file.Stat()
+	fileInfo, err := f.Stat()
 	if err != nil {
 		return fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Create a backend storage from the file
-	backendStorage := backend.New(file, false) // false means not read-only
+	backendStorage := file.New(f, false) // false means not read-only
 
 	// Set the size and start position
 	fs.size = fileInfo.Size()
@@ -125,7 +139,7 @@ func (fs *FileSystem) WriteToFile(filePath string, options *FinalizeOptions) err
 
 	// Use default options if none provided
 	finalOptions := FinalizeOptions{
-		Compression: newGzipCompressor(),
+		Compression: &CompressorGzip{},
 	}
 	if options != nil {
 		finalOptions = *options
@@ -243,7 +257,7 @@ func Create(b backend.Storage, size, start, blocksize int64) (*FileSystem, error
 	//  It is only on `Finalize()` that we write it out to the actual disk file
 	tmpdir, err := os.MkdirTemp("", "diskfs_squashfs")
 	if err != nil {
-		return nil, fmt.Errorf("could not create working directory: %v", err)
+		return nil, fmt.Errorf("could not create working directory: %w", err)
 	}
 
 	// create root directory
@@ -273,6 +287,10 @@ func Create(b backend.Storage, size, start, blocksize int64) (*FileSystem, error
 //
 // If the provided blocksize is 0, it will use the default of 2K bytes.
 //
+// Read reads only the superblock and the root inode. The inodes and directories are read as
+// they are walked, and the fragment, uid/gid and xattr tables when first needed, so that opening
+// a large image to read a few files reads little more than those files.
+//
 // This will use a cache for the decompressed blocks of 128 MB by
 // default. (You can set this with the SetCacheSize method and read
 // its size with the GetCacheSize method). A block cache is essential
@@ -317,13 +335,16 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		return nil, err
 	}
 
-	// load the information from the disk
+	// load the information from the disk, whose locations are from the start of the filesystem
+	if start != 0 {
+		b = filesystem.SectionStorage(b, start, size)
+	}
 
 	// read the superblock
 	superblockBytes := make([]byte, superblockSize)
-	read, err = b.ReadAt(superblockBytes, start)
+	read, err = b.ReadAt(superblockBytes, 0)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read bytes for superblock: %v", err)
+		return nil, fmt.Errorf("unable to read bytes for superblock: %w", err)
 	}
 	if int64(read) != superblockSize {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d for superblock", read, superblockSize)
@@ -332,37 +353,13 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	// parse superblock
 	s, err := parseSuperblock(superblockBytes)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing superblock: %v", err)
+		return nil, fmt.Errorf("error parsing superblock: %w", err)
 	}
 
 	// create the compressor function we will use
 	compress, err := newCompressor(s.compression)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create compressor: %v", err)
-	}
-
-	// load fragments
-	fragments, err := readFragmentTable(s, b, compress)
-	if err != nil {
-		return nil, fmt.Errorf("error reading fragments: %v", err)
-	}
-
-	// read xattrs
-	var (
-		xattrs *xAttrTable
-	)
-	if !s.noXattrs && s.xattrTableStart != 0xffff_ffff_ffff_ffff {
-		// xattr is right to the end of the disk
-		xattrs, err = readXattrsTable(s, b, compress)
-		if err != nil {
-			return nil, fmt.Errorf("error reading xattr table: %v", err)
-		}
-	}
-
-	// read uidsgids
-	uidsgids, err := readUidsGids(s, b, compress)
-	if err != nil {
-		return nil, fmt.Errorf("error reading uids/gids: %v", err)
+		return nil, fmt.Errorf("unable to create compressor: %w", err)
 	}
 
 	fs := &FileSystem{
@@ -372,11 +369,9 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		backend:    b,
 		superblock: s,
 		blocksize:  int64(s.blocksize), // use the blocksize in the superblock
-		xattrs:     xattrs,
 		compressor: compress,
-		fragments:  fragments,
-		uidsGids:   uidsgids,
 		cache:      newLRU(int(defaultCacheSize) / int(s.blocksize)),
+		buffers:    util.DefaultBufferPool,
 	}
 	// for efficiency, read in the root inode right now
 	rootInode, err := fs.getInode(s.rootInode.block, s.rootInode.offset, inodeBasicDirectory)
@@ -398,6 +393,27 @@ func (fs *FileSystem) Close() error {
 	return nil
 }
 
+// Sync syncs the backend. The filesystem is only written to the backend by Finalize, which syncs
+// it, so there is nothing to sync while it is being built in the workspace.
+func (fs *FileSystem) Sync() error {
+	if fs.workspace != "" {
+		return nil
+	}
+	if err := backend.Sync(fs.backend); err != nil {
+		return fmt.Errorf("error syncing filesystem: %w", err)
+	}
+	return nil
+}
+
+// AllocatedRanges returns the bytes of the image used for its data and tables, which are all before
+// its size, for filesystem.AllocatedFS
+func (fs *FileSystem) AllocatedRanges() ([]filesystem.Range, error) {
+	if fs.workspace != "" {
+		return nil, fmt.Errorf("a workspace has no image until finalized: %w", filesystem.ErrNotSupported)
+	}
+	return filesystem.AppendRange(nil, 0, int64(fs.superblock.size)), nil
+}
+
 // Type returns the type code for the filesystem. Always returns filesystem.TypeFat32
 func (fs *FileSystem) Type() filesystem.Type {
 	return filesystem.TypeSquashfs
@@ -427,6 +443,14 @@ func (fs *FileSystem) GetCacheSize() int {
 	return fs.cache.maxBlocks * int(fs.blocksize)
 }
 
+// SetBufferPool sets the pool of the buffers of the blocks read, util.DefaultBufferPool by
+// default, which is shared with the other filesystems. Blocks are read and decompressed into
+// buffers from the pool, which are reused once their contents are copied out, rather than
+// allocated for every block. If this is nil then every buffer will be allocated.
+func (fs *FileSystem) SetBufferPool(pool *util.BufferPool) {
+	fs.buffers = pool
+}
+
 // Unsquashfs extracts the contents of the SquashFS filesystem to the specified destination path.
 // It preserves the directory structure, file permissions, and ownership where possible.
 // Returns an error if the extraction fails.
@@ -449,7 +473,11 @@ func (fs *FileSystem) extractDirectory(sourcePath, destPath string) error {
 	}
 
 	// Process each entry
-	for _, entry := range entries {
+	for _, e := range entries {
+		entry, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", sourcePath, err)
+		}
 		srcEntryPath := path.Join(sourcePath, entry.Name())
 		destEntryPath := path.Join(destPath, entry.Name())
 
@@ -471,7 +499,20 @@ func (fs *FileSystem) extractDirectory(sourcePath, destPath string) error {
 			if err := fs.extractDirectory(srcEntryPath, destEntryPath); err != nil {
 				return err
 			}
-		} else {
+		} else if entry.Mode()&os.ModeSymlink != 0 {
+			// Recreate the symlink; its target does not need to exist
+			dirEntry, ok := entry.(*directoryEntry)
+			if !ok {
+				return fmt.Errorf("unexpected entry type for symlink %s", srcEntryPath)
+			}
+			target, err := dirEntry.Readlink()
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", srcEntryPath, err)
+			}
+			if err := os.Symlink(target, destEntryPath); err != nil {
+				return fmt.Errorf("failed to create symlink %s: %w", destEntryPath, err)
+			}
+		} else if entry.Mode().IsRegular() {
 			// Extract the file
 			if err := fs.extractFile(srcEntryPath, destEntryPath, entry); err != nil {
 				return err
@@ -527,66 +568,225 @@ func (fs *FileSystem) extractFile(sourcePath, destPath string, info os.FileInfo)
 // if readonly and not in workspace, will return an error
 func (fs *FileSystem) Mkdir(p string) error {
 	if fs.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
 	}
 	err := os.MkdirAll(path.Join(fs.workspace, p), 0o755)
 	if err != nil {
-		return fmt.Errorf("could not create directory %s: %v", p, err)
+		return fmt.Errorf("could not create directory %s: %w", p, filesystem.OSError(err))
 	}
 	// we are not interesting in returning the entries
 	return err
 }
 
 // creates a filesystem node (file, device special file, or named pipe) named pathname,
-// with attributes specified by mode and dev
+// with attributes specified by mode and dev, for filesystem.MknodFS.
 //
-//nolint:revive // parameters will be used eventually
+// The node is created in the workspace, so it is only possible before the filesystem is finalized,
+// and devices need the privileges to create them there. Finalize writes the numbers of devices
+// encoded as by Linux, see filesystem.Mkdev, so nodes are only supported on Linux.
 func (fs *FileSystem) Mknod(pathname string, mode uint32, dev int) error {
-	// https://dr-emann.github.io/squashfs/squashfs.html#_device_special_files
-	// https://dr-emann.github.io/squashfs/squashfs.html#_ipc_inodes_fifo_or_socket
-	return filesystem.ErrNotImplemented
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	pathname = filesystem.AbsolutePath(pathname)
+	if err := mknod(path.Join(fs.workspace, pathname), mode, dev); err != nil {
+		return fmt.Errorf("could not create node %s: %w", pathname, filesystem.OSError(err))
+	}
+	return nil
 }
 
-// creates a new link (also known as a hard link) to an existing file.
+// creates a new link (also known as a hard link) to an existing file, for filesystem.LinkFS.
 //
-//nolint:revive // parameters will be used eventually
+// The link is created in the workspace, so it is only possible before the filesystem is finalized,
+// which writes one inode for the file and all its links.
 func (fs *FileSystem) Link(oldpath, newpath string) error {
-	// https://dr-emann.github.io/squashfs/squashfs.html#_symbolic_links
-	return filesystem.ErrNotImplemented
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	oldpath, newpath = filesystem.AbsolutePath(oldpath), filesystem.AbsolutePath(newpath)
+	if err := os.Link(path.Join(fs.workspace, oldpath), path.Join(fs.workspace, newpath)); err != nil {
+		return fmt.Errorf("could not create hard link %s: %w", newpath, filesystem.OSError(err))
+	}
+	return nil
+}
+
+// Inode returns the number of the inode of the named file, shared by its hard links, for
+// filesystem.LinkFS. That of the workspace is the number of the inode in the filesystem holding
+// it, where the operating system reports it.
+func (fs *FileSystem) Inode(name string) (uint64, error) {
+	if fs.workspace != "" {
+		info, err := os.Lstat(path.Join(fs.workspace, filesystem.AbsolutePath(name)))
+		if err != nil {
+			return 0, filesystem.OSError(err)
+		}
+		ino, ok := getInodeNumber(info)
+		if !ok {
+			return 0, fmt.Errorf("inode of %s: %w", name, filesystem.ErrNotSupported)
+		}
+		return ino, nil
+	}
+	info, err := filesystem.GenericLstat(fs, name)
+	if err != nil {
+		return 0, err
+	}
+	if entry, ok := info.Sys().(*directoryEntry); ok && entry.inode != nil {
+		return uint64(entry.inode.index()), nil
+	}
+	// the root directory has no entry, only its inode
+	return uint64(fs.rootDir.index()), nil
 }
 
 // creates a symbolic link named linkpath which contains the string target.
 //
-//nolint:revive // parameters will be used eventually
+// The link is created in the workspace, so it is only possible before the filesystem is finalized.
 func (fs *FileSystem) Symlink(oldpath, newpath string) error {
-	// https://dr-emann.github.io/squashfs/squashfs.html#_symbolic_links
-	return filesystem.ErrNotImplemented
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	if err := os.Symlink(oldpath, path.Join(fs.workspace, newpath)); err != nil {
+		return fmt.Errorf("could not create symbolic link %s: %w", newpath, filesystem.OSError(err))
+	}
+	return nil
 }
 
 // Chmod changes the mode of the named file to mode. If the file is a symbolic link,
 // it changes the mode of the link's target.
 //
-//nolint:revive // parameters will be used eventually
+// The mode is changed in the workspace, and kept by Finalize.
 func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
-	// https://dr-emann.github.io/squashfs/squashfs.html#_common_inode_header
-	return filesystem.ErrNotImplemented
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	return filesystem.OSError(os.Chmod(path.Join(fs.workspace, name), mode))
 }
 
 // Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
 // it changes the uid and gid of the link's target. A uid or gid of -1 means to not change that value
 //
-//nolint:revive // parameters will be used eventually
+// The owner is changed in the workspace, which needs the privileges to do so, and kept by Finalize.
 func (fs *FileSystem) Chown(name string, uid, gid int) error {
-	// https://dr-emann.github.io/squashfs/squashfs.html#_id_table
-	return filesystem.ErrNotImplemented
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	return filesystem.OSError(os.Chown(path.Join(fs.workspace, name), uid, gid))
+}
+
+// Chtimes changes the access and modification times of the named file, like os.Chtimes. A zero
+// time leaves that time unchanged. The times are changed in the workspace, and the modification
+// time is kept by Finalize.
+func (fs *FileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	return filesystem.OSError(os.Chtimes(path.Join(fs.workspace, name), atime, mtime))
+}
+
+// Listxattr returns the names of the extended attributes of the named file, sorted, for
+// filesystem.XattrFS
+func (fs *FileSystem) Listxattr(name string) ([]string, error) {
+	if fs.workspace != "" {
+		attrs, err := xattr.LList(path.Join(fs.workspace, filesystem.AbsolutePath(name)))
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		slices.Sort(attrs)
+		return attrs, nil
+	}
+	xattrs, err := fs.entryXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([]string, 0, len(xattrs))
+	for attr := range xattrs {
+		attrs = append(attrs, attr)
+	}
+	slices.Sort(attrs)
+	return attrs, nil
+}
+
+// Getxattr returns the value of the extended attribute of the named file, for filesystem.XattrFS
+func (fs *FileSystem) Getxattr(name, attr string) ([]byte, error) {
+	if fs.workspace != "" {
+		value, err := xattr.LGet(path.Join(fs.workspace, filesystem.AbsolutePath(name)), attr)
+		if err != nil {
+			return nil, xattrError(err)
+		}
+		return value, nil
+	}
+	xattrs, err := fs.entryXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := xattrs[attr]
+	if !ok {
+		return nil, fmt.Errorf("%s of %s: %w", attr, name, filesystem.ErrNoXattr)
+	}
+	return []byte(value), nil
+}
+
+// Setxattr sets the extended attribute of the named file, for filesystem.XattrFS. The attribute is
+// set in the workspace, which needs the privileges to set those of the trusted and security
+// namespaces, and kept by Finalize with FinalizeOptions.Xattrs. Squashfs keeps the attributes of
+// the user, trusted and security namespaces only.
+func (fs *FileSystem) Setxattr(name, attr string, value []byte) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	return xattrError(xattr.LSet(path.Join(fs.workspace, filesystem.AbsolutePath(name)), attr, value))
+}
+
+// Removexattr removes the extended attribute of the named file in the workspace, for
+// filesystem.XattrFS
+func (fs *FileSystem) Removexattr(name, attr string) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	return xattrError(xattr.LRemove(path.Join(fs.workspace, filesystem.AbsolutePath(name)), attr))
+}
+
+// entryXattrs returns the extended attributes of the named file, without following symbolic links
+func (fs *FileSystem) entryXattrs(name string) (map[string]string, error) {
+	info, err := filesystem.GenericLstat(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := info.Sys().(*directoryEntry); ok {
+		return entry.xattrs, nil
+	}
+	// the root directory has no entry, only its inode
+	index, ok := fs.rootDir.getBody().xattrIndex()
+	if !ok {
+		return nil, nil
+	}
+	table, err := fs.xattrTable()
+	if err != nil {
+		return nil, err
+	}
+	return table.find(int(index))
+}
+
+// xattrError returns the error of the operating system for the extended attributes of the
+// workspace, matching filesystem.ErrNoXattr where there is no such attribute, and
+// filesystem.ErrNotSupported where the workspace cannot hold it
+func xattrError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, xattr.ENOATTR):
+		return fmt.Errorf("%w: %w", filesystem.ErrNoXattr, err)
+	case errors.Is(err, syscall.ENOTSUP), errors.Is(err, syscall.EPERM):
+		return fmt.Errorf("%w: %w", filesystem.ErrNotSupported, err)
+	}
+	return filesystem.OSError(err)
 }
 
 // ReadDir return the contents of a given directory in a given filesystem.
 //
-// Returns a slice of os.FileInfo with all of the entries in the directory.
+// Returns a slice of fs.DirEntry with all of the entries in the directory, sorted by name.
 //
 // Will return an error if the directory does not exist or is a regular file and not a directory
-func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+func (fs *FileSystem) ReadDir(p string) ([]iofs.DirEntry, error) {
+	p = filesystem.AbsolutePath(p)
 	var fi []os.FileInfo
 	// non-workspace: read from squashfs
 	// workspace: read from regular filesystem
@@ -595,12 +795,12 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 		// read the entries
 		dirEntries, err := os.ReadDir(fullPath)
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+			return nil, fmt.Errorf("could not read directory %s: %w", p, filesystem.OSError(err))
 		}
 		for _, e := range dirEntries {
 			info, err := e.Info()
 			if err != nil {
-				return nil, fmt.Errorf("could not read directory %s: %v", p, err)
+				return nil, fmt.Errorf("could not read directory %s: %w", p, filesystem.OSError(err))
 			}
 
 			fi = append(fi, info)
@@ -608,14 +808,103 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 	} else {
 		dirEntries, err := fs.readDirectory(p)
 		if err != nil {
-			return nil, fmt.Errorf("error reading directory %s: %v", p, err)
+			return nil, fmt.Errorf("error reading directory %s: %w", p, err)
 		}
 		fi = make([]os.FileInfo, 0, len(dirEntries))
 		for _, entry := range dirEntries {
 			fi = append(fi, entry)
 		}
 	}
-	return fi, nil
+	return filesystem.SortedDirEntries(fi), nil
+}
+
+// WalkDir walks the file tree rooted at root like fs.WalkDir, reading the directories from the
+// directory table by their entries. The inode of a file is read only when fn asks for the Info of
+// its entry, and that of a directory when its entries are read.
+func (fs *FileSystem) WalkDir(root string, fn iofs.WalkDirFunc) error {
+	if fs.workspace != "" {
+		return filesystem.GenericWalkDir(fs, root, fn)
+	}
+	info, err := fs.Stat(root)
+	if err != nil {
+		return filesystem.WalkEntries(root, nil, err, nil, fn)
+	}
+	d := &lazyDirEntry{fs: fs, name: info.Name(), typ: info.Mode().Type(), info: info, in: fs.rootDir}
+	if entry, ok := info.Sys().(*directoryEntry); ok {
+		d.in = entry.inode
+	}
+	return filesystem.WalkEntries(root, d, nil, fs.walkReadDir, fn)
+}
+
+// walkReadDir reads the entries of the directory of a lazyDirEntry for WalkDir, sorted by name
+func (fs *FileSystem) walkReadDir(p string, d iofs.DirEntry) ([]iofs.DirEntry, error) {
+	in, err := d.(*lazyDirEntry).inode()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := fs.getRawDirectoryEntries(in)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	entries := make([]iofs.DirEntry, 0, len(raw))
+	for _, e := range raw {
+		entries = append(entries, newLazyDirEntry(fs, e))
+	}
+	slices.SortFunc(entries, func(a, b iofs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// Open opens the file or directory read-only, for fs.FS
+func (fs *FileSystem) Open(name string) (iofs.File, error) {
+	return filesystem.GenericOpen(fs, name)
+}
+
+// Stat describes the file or directory, for fs.StatFS
+func (fs *FileSystem) Stat(name string) (iofs.FileInfo, error) {
+	return filesystem.GenericStat(fs, name)
+}
+
+// Lstat describes the file or directory like Stat, but describes symbolic links themselves, for
+// filesystem.LstatFS
+func (fs *FileSystem) Lstat(name string) (iofs.FileInfo, error) {
+	// the entry is found without reading the whole of its directory, unless it is in the workspace,
+	// or the path is one that GenericLstat rejects
+	p := filesystem.AbsolutePath(name)
+	if fs.workspace != "" || path.Clean(p) == "/" || strings.Contains(p, `\`) || !iofs.ValidPath(strings.TrimPrefix(name, "/")) {
+		return filesystem.GenericLstat(fs, name)
+	}
+	entry, err := fs.findEntry(p)
+	if err != nil {
+		return nil, &iofs.PathError{Op: "lstat", Path: name, Err: iofs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// Readlink returns the target of the symbolic link, for filesystem.ReadlinkFS
+func (fs *FileSystem) Readlink(name string) (string, error) {
+	if fs.workspace != "" {
+		target, err := os.Readlink(path.Join(fs.workspace, filesystem.AbsolutePath(name)))
+		if err != nil {
+			return "", &iofs.PathError{Op: "readlink", Path: name, Err: filesystem.OSError(errors.Unwrap(err))}
+		}
+		return target, nil
+	}
+	info, err := filesystem.GenericLstat(fs, name)
+	if err != nil {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: errors.Unwrap(err)}
+	}
+	entry, ok := info.Sys().(FileStat)
+	if !ok || info.Mode()&iofs.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+	return entry.Readlink()
+}
+
+// ReadFile reads the whole file, for fs.ReadFileFS
+func (fs *FileSystem) ReadFile(name string) ([]byte, error) {
+	return filesystem.GenericReadFile(fs, name)
 }
 
 // OpenFile returns an io.ReadWriter from which you can read the contents of a file
@@ -634,51 +923,40 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 
 	// if the dir == filename, then it is just /
 	if dir == filename {
-		return nil, fmt.Errorf("cannot open directory %s as file", p)
+		return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 	}
 
 	// cannot open to write or append or create if we do not have a workspace
 	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
 	if fs.workspace == "" {
 		if writeMode {
-			return nil, filesystem.ErrReadonlyFilesystem
+			return nil, filesystem.ErrReadOnlyFilesystem
 		}
 
-		// get the directory entries
-		var entries []*directoryEntry
-		entries, err = fs.readDirectory(dir)
+		// find the entry, reading only what leads to it of the directories above it
+		targetEntry, err := fs.findEntry(p)
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory entries for %s", dir)
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, err)
 		}
-		// we now know that the directory exists, see if the file exists
-		var targetEntry *directoryEntry
-		for _, e := range entries {
-			eName := e.Name()
-			// cannot do anything with directories
-			if eName == filename && e.IsDir() {
-				return nil, fmt.Errorf("cannot open directory %s as file", p)
-			}
-			if eName == filename {
-				// if we got this far, we have found the file
-				targetEntry = e
-				break
-			}
-		}
-
-		// see if the file exists
-		// if the file does not exist, and is not opened for os.O_CREATE, return an error
-		if targetEntry == nil {
-			return nil, fmt.Errorf("target file %s does not exist", p)
+		// cannot do anything with directories
+		if targetEntry.IsDir() {
+			return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 		}
 		f, err = targetEntry.Open()
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		f, err = os.OpenFile(path.Join(fs.workspace, p), flag, 0o644)
+		var osf *os.File
+		osf, err = os.OpenFile(path.Join(fs.workspace, p), flag, 0o644)
 		if err != nil {
-			return nil, fmt.Errorf("target file %s does not exist: %v", p, err)
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, filesystem.OSError(err))
+		}
+		if info, err := osf.Stat(); err == nil && info.IsDir() {
+			osf.Close()
+			return nil, fmt.Errorf("cannot open directory %s as file: %w", p, filesystem.ErrIsDir)
 		}
+		f = osf
 	}
 
 	return f, nil
@@ -687,16 +965,24 @@ func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 // Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
 	if fs.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
+	}
+	oldpath, newpath = filesystem.AbsolutePath(oldpath), filesystem.AbsolutePath(newpath)
+	if oldpath == "/" || newpath == "/" {
+		return fmt.Errorf("cannot rename root directory: %w", iofs.ErrInvalid)
 	}
-	return os.Rename(path.Join(fs.workspace, oldpath), path.Join(fs.workspace, newpath))
+	return filesystem.OSError(os.Rename(path.Join(fs.workspace, oldpath), path.Join(fs.workspace, newpath)))
 }
 
+// Remove removes the named file or empty directory from the workspace
 func (fs *FileSystem) Remove(p string) error {
 	if fs.workspace == "" {
-		return filesystem.ErrReadonlyFilesystem
+		return filesystem.ErrReadOnlyFilesystem
 	}
-	return os.Remove(path.Join(fs.workspace, p))
+	if p = filesystem.AbsolutePath(p); p == "/" {
+		return fmt.Errorf("cannot remove root directory: %w", iofs.ErrInvalid)
+	}
+	return filesystem.OSError(os.Remove(path.Join(fs.workspace, p)))
 }
 
 // readDirectory - read directory entry on squashfs only (not workspace)
@@ -704,21 +990,19 @@ func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 	// use the root inode to find the location of the root direectory in the table
 	entries, err := fs.getDirectoryEntries(p, fs.rootDir)
 	if err != nil {
-		return nil, fmt.Errorf("could not read directory at path %s: %v", p, err)
+		return nil, fmt.Errorf("could not read directory at path %s: %w", p, err)
 	}
 	return entries, nil
 }
 
-func (fs *FileSystem) getDirectoryEntries(p string, in inode) ([]*directoryEntry, error) {
+// getRawDirectoryEntries reads the entries of the directory of the inode, without their inodes
+func (fs *FileSystem) getRawDirectoryEntries(in inode) ([]*directoryEntryRaw, error) {
 	var (
 		block  uint32
 		offset uint16
 		size   int
 	)
 
-	// break path down into parts and levels
-	parts := splitPath(p)
-
 	iType := in.inodeType()
 	body := in.getBody()
 	//nolint:exhaustive // we only are looking for directory types here
@@ -734,84 +1018,168 @@ func (fs *FileSystem) getDirectoryEntries(p string, in inode) ([]*directoryEntry
 		offset = dir.offset
 		size = int(dir.fileSize)
 	default:
-		return nil, fmt.Errorf("inode is of type %d, neither basic nor extended directory", iType)
+		return nil, fmt.Errorf("inode is of type %d, neither basic nor extended directory: %w", iType, filesystem.ErrNotDir)
 	}
 	// read the directory data from the directory table
 	dir, err := fs.getDirectory(block, offset, size)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read directory from table: %v", err)
+		return nil, fmt.Errorf("unable to read directory from table: %w", err)
 	}
-	entriesRaw := dir.entries
-	var entries []*directoryEntry
+	return dir.entries, nil
+}
+
+func (fs *FileSystem) getDirectoryEntries(p string, in inode) ([]*directoryEntry, error) {
+	// break path down into parts and levels
+	parts := splitPath(p)
+
 	// if this is the directory we are looking for, return the entries
 	if len(parts) == 0 {
-		entries, err = fs.hydrateDirectoryEntries(entriesRaw)
+		entriesRaw, err := fs.getRawDirectoryEntries(in)
 		if err != nil {
-			return nil, fmt.Errorf("could not populate directory entries for %s with properties: %v", p, err)
+			return nil, err
+		}
+		entries, err := fs.hydrateDirectoryEntries(entriesRaw)
+		if err != nil {
+			return nil, fmt.Errorf("could not populate directory entries for %s with properties: %w", p, err)
 		}
 		return entries, nil
 	}
 
 	// it is not, so dig down one level
 	// find the entry among the children that has the desired name
-	for _, entry := range entriesRaw {
-		// only care if not self or parent entry
-		checkFilename := entry.name
-		if checkFilename == parts[0] {
-			// read the inode for this entry
-			inode, err := fs.getInode(entry.startBlock, entry.offset, entry.inodeType)
-			if err != nil {
-				return nil, fmt.Errorf("error finding inode for %s: %v", p, err)
-			}
+	entry, err := fs.findRawEntry(in, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		// we were not looking for this directory, but did not find it among our children
+		return nil, fmt.Errorf("could not find path %s: %w", p, iofs.ErrNotExist)
+	}
+	// read the inode for this entry
+	inode, err := fs.getInode(entry.startBlock, entry.offset, entry.inodeType)
+	if err != nil {
+		return nil, fmt.Errorf("error finding inode for %s: %w", p, err)
+	}
+	entries, err := fs.getDirectoryEntries(path.Join(parts[1:]...), inode)
+	if err != nil {
+		return nil, fmt.Errorf("could not get entries: %w", err)
+	}
+	return entries, nil
+}
 
-			childPath := ""
-			if len(parts) > 1 {
-				childPath = path.Join(parts[1:]...)
-			}
-			entries, err = fs.getDirectoryEntries(childPath, inode)
-			if err != nil {
-				return nil, fmt.Errorf("could not get entries: %v", err)
+// findEntry returns the entry of the path, which must not be the root, reading only the entries of
+// the directories above it that lead to it
+func (fs *FileSystem) findEntry(p string) (*directoryEntry, error) {
+	parts := splitPath(p)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("the root directory has no entry: %w", iofs.ErrInvalid)
+	}
+	in := fs.rootDir
+	for i, part := range parts {
+		entry, err := fs.findRawEntry(in, part)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("could not find path %s: %w", p, iofs.ErrNotExist)
+		}
+		if i == len(parts)-1 {
+			return fs.hydrateDirectoryEntry(entry)
+		}
+		if in, err = fs.getInode(entry.startBlock, entry.offset, entry.inodeType); err != nil {
+			return nil, fmt.Errorf("error finding inode for %s: %w", p, err)
+		}
+	}
+	return nil, nil
+}
+
+// findRawEntry returns the entry with the name in the directory of the inode, or nil if there is
+// none. Where the directory has an index, only the entries from the header of the last index
+// entry not after the name, up to the next, are read, rather than the whole directory.
+func (fs *FileSystem) findRawEntry(in inode, name string) (*directoryEntryRaw, error) {
+	var entries []*directoryEntryRaw
+	if dir, ok := in.getBody().(*extendedDirectory); ok && len(dir.indexes) > 0 {
+		// the size counts the 3 bytes of the . and .. entries, which are not written
+		block, offset, start, end := dir.startBlock, dir.offset, 0, int(dir.fileSize)-3
+		for _, index := range dir.indexes {
+			if index.name > name {
+				end = int(index.index)
+				break
 			}
-			return entries, nil
+			block, start = index.block, int(index.index)
+			offset = uint16((int(dir.offset) + start) % int(metadataBlockSize))
+		}
+		d, err := fs.getDirectory(block, offset, end-start)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read directory from table: %w", err)
+		}
+		entries = d.entries
+	} else {
+		var err error
+		if entries, err = fs.getRawDirectoryEntries(in); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range entries {
+		if entry.name == name {
+			return entry, nil
 		}
 	}
-	// if we made it here, we were not looking for this directory, but did not find it among our children
-	return nil, fmt.Errorf("could not find path %s", p)
+	return nil, nil
 }
 
 func (fs *FileSystem) hydrateDirectoryEntries(entries []*directoryEntryRaw) ([]*directoryEntry, error) {
 	fullEntries := make([]*directoryEntry, 0)
 	for _, e := range entries {
-		// read the inode for this entry
-		in, err := fs.getInode(e.startBlock, e.offset, e.inodeType)
+		entry, err := fs.hydrateDirectoryEntry(e)
 		if err != nil {
-			return nil, fmt.Errorf("error finding inode for %s: %v", e.name, err)
-		}
-		body, header := in.getBody(), in.getHeader()
-		xattrIndex, has := body.xattrIndex()
-		xattrs := map[string]string{}
-		if has {
-			xattrs, err = fs.xattrs.find(int(xattrIndex))
-			if err != nil {
-				return nil, fmt.Errorf("error reading xattrs for %s: %v", e.name, err)
-			}
+			return nil, err
 		}
-		fullEntries = append(fullEntries, &directoryEntry{
-			fs:             fs,
-			isSubdirectory: e.isSubdirectory,
-			name:           e.name,
-			size:           body.size(),
-			modTime:        header.modTime,
-			mode:           header.mode,
-			inode:          in,
-			uid:            fs.uidsGids[header.uidIdx],
-			gid:            fs.uidsGids[header.gidIdx],
-			xattrs:         xattrs,
-		})
+		fullEntries = append(fullEntries, entry)
 	}
 	return fullEntries, nil
 }
 
+func (fs *FileSystem) hydrateDirectoryEntry(e *directoryEntryRaw) (*directoryEntry, error) {
+	// read the inode for this entry
+	in, err := fs.getInode(e.startBlock, e.offset, e.inodeType)
+	if err != nil {
+		return nil, fmt.Errorf("error finding inode for %s: %w", e.name, err)
+	}
+	body, header := in.getBody(), in.getHeader()
+	xattrIndex, has := body.xattrIndex()
+	xattrs := map[string]string{}
+	if has {
+		table, err := fs.xattrTable()
+		if err != nil {
+			return nil, fmt.Errorf("error reading xattrs for %s: %w", e.name, err)
+		}
+		xattrs, err = table.find(int(xattrIndex))
+		if err != nil {
+			return nil, fmt.Errorf("error reading xattrs for %s: %w", e.name, err)
+		}
+	}
+	ids, err := fs.idTable()
+	if err != nil {
+		return nil, fmt.Errorf("error reading owner of %s: %w", e.name, err)
+	}
+	if int(header.uidIdx) >= len(ids) || int(header.gidIdx) >= len(ids) {
+		return nil, fmt.Errorf("owner of %s has uid index %d and gid index %d of %d ids", e.name, header.uidIdx, header.gidIdx, len(ids))
+	}
+	return &directoryEntry{
+		fs:             fs,
+		isSubdirectory: e.isSubdirectory,
+		name:           e.name,
+		size:           body.size(),
+		modTime:        header.modTime,
+		mode:           header.mode,
+		inode:          in,
+		uid:            ids[header.uidIdx],
+		gid:            ids[header.gidIdx],
+		xattrs:         xattrs,
+	}, nil
+}
+
 // getInode read a single inode, given the block offset, and the offset in the
 // block when uncompressed. This may require two reads, one to get the header and discover the type,
 // and then another to read the rest. Some inodes even have a variable length, which complicates it
@@ -822,12 +1190,12 @@ func (fs *FileSystem) getInode(blockOffset uint32, byteOffset uint16, iType inod
 	size := inodeTypeToSize(iType)
 	uncompressed, err := fs.readMetadata(fs.backend, fs.compressor, int64(fs.superblock.inodeTableStart), blockOffset, byteOffset, size)
 	if err != nil {
-		return nil, fmt.Errorf("error reading block at position %d: %v", blockOffset, err)
+		return nil, fmt.Errorf("error reading block at position %d: %w", blockOffset, err)
 	}
 	// parse the header to see the type matches
 	header, err := parseInodeHeader(uncompressed)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing inode header: %v", err)
+		return nil, fmt.Errorf("error parsing inode header: %w", err)
 	}
 	if header.inodeType != iType {
 		iType = header.inodeType
@@ -836,26 +1204,30 @@ func (fs *FileSystem) getInode(blockOffset uint32, byteOffset uint16, iType inod
 		if size > len(uncompressed) {
 			uncompressed, err = fs.readMetadata(fs.backend, fs.compressor, int64(fs.superblock.inodeTableStart), blockOffset, byteOffset, size)
 			if err != nil {
-				return nil, fmt.Errorf("error reading block at position %d: %v", blockOffset, err)
+				return nil, fmt.Errorf("error reading block at position %d: %w", blockOffset, err)
 			}
 		}
 	}
 	// now read the body, which may have a variable size
 	body, extra, err := parseInodeBody(uncompressed[inodeHeaderSize:], int(fs.blocksize), iType)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing inode body: %v", err)
+		return nil, fmt.Errorf("error parsing inode body: %w", err)
 	}
-	// if it returns extra > 0, then it needs that many more bytes to be read, and to be reparsed
-	if extra > 0 {
-		size += extra
+	// if it returns extra > 0, then it needs that many more bytes to be read, and to be reparsed;
+	// the indexes of a directory may need more still once their names are read
+	for minSize := size; extra > 0; {
+		if minSize+extra <= size {
+			return nil, fmt.Errorf("inode at position %d needs %d bytes after reading %d", blockOffset, minSize+extra, size)
+		}
+		size = minSize + extra
 		uncompressed, err = fs.readMetadata(fs.backend, fs.compressor, int64(fs.superblock.inodeTableStart), blockOffset, byteOffset, size)
 		if err != nil {
-			return nil, fmt.Errorf("error reading block at position %d: %v", blockOffset, err)
+			return nil, fmt.Errorf("error reading block at position %d: %w", blockOffset, err)
 		}
-		// no need to revalidate the body type, or check for extra
-		body, _, err = parseInodeBody(uncompressed[inodeHeaderSize:], int(fs.blocksize), iType)
+		// no need to revalidate the body type
+		body, extra, err = parseInodeBody(uncompressed[inodeHeaderSize:], int(fs.blocksize), iType)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing inode body: %v", err)
+			return nil, fmt.Errorf("error parsing inode body: %w", err)
 		}
 	}
 	return &inodeImpl{
@@ -870,7 +1242,7 @@ func (fs *FileSystem) getDirectory(blockOffset uint32, byteOffset uint16, size i
 	// get the block
 	uncompressed, err := fs.readMetadata(fs.backend, fs.compressor, int64(fs.superblock.directoryTableStart), blockOffset, byteOffset, size)
 	if err != nil {
-		return nil, fmt.Errorf("error reading block at position %d: %v", blockOffset, err)
+		return nil, fmt.Errorf("error reading block at position %d: %w", blockOffset, err)
 	}
 	// for parseDirectory, we only want to use precisely the right number of bytes
 	if len(uncompressed) > size {
@@ -880,23 +1252,35 @@ func (fs *FileSystem) getDirectory(blockOffset uint32, byteOffset uint16, size i
 	return parseDirectory(uncompressed)
 }
 
-func (fs *FileSystem) readBlock(location int64, compressed bool, size uint32) ([]byte, error) {
+// readBlock reads the data block of size bytes at location, decompressing it into dst if it is
+// compressed, or into a new buffer if dst is nil. Uncompressed blocks are read into a new buffer.
+func (fs *FileSystem) readBlock(location int64, compressed bool, size uint32, dst []byte) ([]byte, error) {
 	// Zero size is a sparse block of blocksize
 	if size == 0 {
 		return make([]byte, fs.superblock.blocksize), nil
 	}
-	b := make([]byte, size)
+	var b []byte
+	if compressed {
+		// only needed until it is decompressed
+		b = fs.buffers.Get(int(size))
+		defer fs.buffers.Put(b)
+	} else {
+		b = make([]byte, size)
+	}
 	read, err := fs.backend.ReadAt(b, location)
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("error reading block %d: %v", location, err)
+		return nil, fmt.Errorf("error reading block %d: %w", location, err)
 	}
 	if read != int(size) {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d", read, size)
 	}
 	if compressed {
-		b, err = fs.compressor.decompress(b)
+		if dst == nil {
+			dst = make([]byte, 0, fs.blocksize)
+		}
+		b, err = decompressTo(fs.compressor, dst, b)
 		if err != nil {
-			return nil, fmt.Errorf("decompress error: %v", err)
+			return nil, fmt.Errorf("decompress error: %w", err)
 		}
 	}
 	return b, nil
@@ -907,17 +1291,26 @@ func (fs *FileSystem) readFragment(index, offset uint32, fragmentSize int64) ([]
 	// figure out which block of the fragment table we need
 
 	// first find where the compressed fragment table entry for the given index is
-	if len(fs.fragments)-1 < int(index) {
+	fragments, err := fs.fragmentTable()
+	if err != nil {
+		return nil, err
+	}
+	if len(fragments)-1 < int(index) {
 		return nil, fmt.Errorf("cannot find fragment block with index %d", index)
 	}
-	fragmentInfo := fs.fragments[index]
+	fragmentInfo := fragments[index]
 	pos := int64(fragmentInfo.start)
 	data, _, err := fs.cache.get(pos, func() (data []byte, size uint16, err error) {
 		// figure out the size of the compressed block and if it is compressed
 		b := make([]byte, fragmentInfo.size)
+		if fragmentInfo.compressed {
+			// only needed until it is decompressed
+			b = fs.buffers.Get(int(fragmentInfo.size))
+			defer fs.buffers.Put(b)
+		}
 		read, err := fs.backend.ReadAt(b, pos)
 		if err != nil && err != io.EOF {
-			return nil, 0, fmt.Errorf("unable to read fragment block %d: %v", index, err)
+			return nil, 0, fmt.Errorf("unable to read fragment block %d: %w", index, err)
 		}
 		if read != len(b) {
 			return nil, 0, fmt.Errorf("read %d instead of expected %d bytes for fragment block %d", read, len(b), index)
@@ -928,9 +1321,9 @@ func (fs *FileSystem) readFragment(index, offset uint32, fragmentSize int64) ([]
 			if fs.compressor == nil {
 				return nil, 0, fmt.Errorf("fragment compressed but do not have valid compressor")
 			}
-			data, err = fs.compressor.decompress(b)
+			data, err = decompressTo(fs.compressor, make([]byte, 0, fs.blocksize), b)
 			if err != nil {
-				return nil, 0, fmt.Errorf("decompress error: %v", err)
+				return nil, 0, fmt.Errorf("decompress error: %w", err)
 			}
 		}
 		return data, 0, nil
@@ -956,6 +1349,21 @@ func validateBlocksize(blocksize int64) error {
 	return nil
 }
 
+// fragmentTable returns the fragment table, read when first needed rather than by Read, so that
+// opening a filesystem to read a few files does not read all of its tables
+func (fs *FileSystem) fragmentTable() ([]*fragmentEntry, error) {
+	fs.tablesMu.Lock()
+	defer fs.tablesMu.Unlock()
+	if fs.fragments == nil && fs.superblock != nil && fs.superblock.fragmentCount > 0 {
+		fragments, err := readFragmentTable(fs.superblock, fs.backend, fs.compressor)
+		if err != nil {
+			return nil, fmt.Errorf("error reading fragments: %w", err)
+		}
+		fs.fragments = fragments
+	}
+	return fs.fragments, nil
+}
+
 func readFragmentTable(s *superblock, file backend.File, c Compressor) ([]*fragmentEntry, error) {
 	// get the first level index, which is just the pointers to the fragment table metadata blocks
 	blockCount := s.fragmentCount / 512
@@ -966,7 +1374,7 @@ func readFragmentTable(s *superblock, file backend.File, c Compressor) ([]*fragm
 	b := make([]byte, 8*blockCount)
 	read, err := file.ReadAt(b, int64(s.fragmentTableStart))
 	if err != nil {
-		return nil, fmt.Errorf("error reading fragment table index: %v", err)
+		return nil, fmt.Errorf("error reading fragment table index: %w", err)
 	}
 	if read != len(b) {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d bytes of fragment table index", read, len(b))
@@ -983,13 +1391,13 @@ func readFragmentTable(s *superblock, file backend.File, c Compressor) ([]*fragm
 	for i, offset := range offsets {
 		uncompressed, _, err := fs.readMetaBlock(file, c, offset)
 		if err != nil {
-			return nil, fmt.Errorf("error reading meta block %d at position %d: %v", i, offset, err)
+			return nil, fmt.Errorf("error reading meta block %d at position %d: %w", i, offset, err)
 		}
 		// uncompressed should be a multiple of 16 bytes
 		for j := 0; j < len(uncompressed); j += 16 {
 			entry, err := parseFragmentEntry(uncompressed[j:])
 			if err != nil {
-				return nil, fmt.Errorf("error parsing fragment table entry in block %d position %d: %v", i, j, err)
+				return nil, fmt.Errorf("error parsing fragment table entry in block %d position %d: %w", i, j, err)
 			}
 			fragmentTable = append(fragmentTable, entry)
 		}
@@ -997,6 +1405,23 @@ func readFragmentTable(s *superblock, file backend.File, c Compressor) ([]*fragm
 	return fragmentTable, nil
 }
 
+// xattrTable returns the xattr table, read when first needed, or an error if the filesystem has none
+func (fs *FileSystem) xattrTable() (*xAttrTable, error) {
+	fs.tablesMu.Lock()
+	defer fs.tablesMu.Unlock()
+	if fs.xattrs == nil && fs.superblock != nil && !fs.superblock.noXattrs && fs.superblock.xattrTableStart != 0xffff_ffff_ffff_ffff {
+		xattrs, err := readXattrsTable(fs.superblock, fs.backend, fs.compressor)
+		if err != nil {
+			return nil, fmt.Errorf("error reading xattr table: %w", err)
+		}
+		fs.xattrs = xattrs
+	}
+	if fs.xattrs == nil {
+		return nil, errors.New("filesystem has no xattr table")
+	}
+	return fs.xattrs, nil
+}
+
 /*
 How the xattr table is laid out
 It has three components in the following order
@@ -1018,7 +1443,7 @@ func readXattrsTable(s *superblock, file backend.File, c Compressor) (*xAttrTabl
 	b := make([]byte, xAttrHeaderSize)
 	read, err := file.ReadAt(b, int64(s.xattrTableStart))
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("unable to read bytes for xattrs metadata ID header: %v", err)
+		return nil, fmt.Errorf("unable to read bytes for xattrs metadata ID header: %w", err)
 	}
 	if read != len(b) {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d for xattrs metadata ID header", read, len(b))
@@ -1041,7 +1466,7 @@ func readXattrsTable(s *superblock, file backend.File, c Compressor) (*xAttrTabl
 	b = make([]byte, idBlocks*8)
 	read, err = file.ReadAt(b, int64(s.xattrTableStart)+int64(xAttrHeaderSize))
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("unable to read bytes for xattrs metadata ID table: %v", err)
+		return nil, fmt.Errorf("unable to read bytes for xattrs metadata ID table: %w", err)
 	}
 	if read != len(b) {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d for xattrs metadata ID table", read, len(b))
@@ -1059,7 +1484,7 @@ func readXattrsTable(s *superblock, file backend.File, c Compressor) (*xAttrTabl
 		locn := binary.LittleEndian.Uint64(b[i : i+8])
 		uncompressed, _, err = fs.readMetaBlock(file, c, int64(locn))
 		if err != nil {
-			return nil, fmt.Errorf("error reading xattr index meta block %d at position %d: %v", i, locn, err)
+			return nil, fmt.Errorf("error reading xattr index meta block %d at position %d: %w", i, locn, err)
 		}
 		bIndex = append(bIndex, uncompressed...)
 	}
@@ -1071,7 +1496,7 @@ func readXattrsTable(s *superblock, file backend.File, c Compressor) (*xAttrTabl
 	for i := xAttrStart; i < xAttrEnd; {
 		uncompressed, size, err = fs.readMetaBlock(file, c, int64(i))
 		if err != nil {
-			return nil, fmt.Errorf("error reading xattr data meta block at position %d: %v", i, err)
+			return nil, fmt.Errorf("error reading xattr data meta block at position %d: %w", i, err)
 		}
 		xAttrData = append(xAttrData, uncompressed...)
 		i += uint64(size)
@@ -1094,7 +1519,7 @@ func parseXattrsTable(bUIDXattr, bIndex []byte, offsetMap map[uint32]uint32, c C
 	for i := 0; i+entrySize <= len(bIndex); i += entrySize {
 		entry, err := parseXAttrIndex(bIndex[i:], offsetMap)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing xAttr ID table entry in position %d: %v", i, err)
+			return nil, fmt.Errorf("error parsing xAttr ID table entry in position %d: %w", i, err)
 		}
 		xAttrIDList = append(xAttrIDList, entry)
 	}
@@ -1105,6 +1530,20 @@ func parseXattrsTable(bUIDXattr, bIndex []byte, offsetMap map[uint32]uint32, c C
 	}, nil
 }
 
+// idTable returns the table of uids and gids, read when first needed
+func (fs *FileSystem) idTable() ([]uint32, error) {
+	fs.tablesMu.Lock()
+	defer fs.tablesMu.Unlock()
+	if fs.uidsGids == nil && fs.superblock != nil && fs.superblock.idCount > 0 {
+		uidsGids, err := readUidsGids(fs.superblock, fs.backend, fs.compressor)
+		if err != nil {
+			return nil, fmt.Errorf("error reading uids/gids: %w", err)
+		}
+		fs.uidsGids = uidsGids
+	}
+	return fs.uidsGids, nil
+}
+
 /*
 How the uids/gids table is laid out
 It has two components in the following order
@@ -1136,7 +1575,7 @@ func readUidsGids(s *superblock, file backend.File, c Compressor) ([]uint32, err
 	b := make([]byte, idBlocks*8)
 	read, err := file.ReadAt(b, int64(idStart))
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("unable to read index bytes for uidgid ID table: %v", err)
+		return nil, fmt.Errorf("unable to read index bytes for uidgid ID table: %w", err)
 	}
 	if read != len(b) {
 		return nil, fmt.Errorf("read %d bytes instead of expected %d for uidgid ID table", read, len(b))
@@ -1153,7 +1592,7 @@ func readUidsGids(s *superblock, file backend.File, c Compressor) ([]uint32, err
 		locn := binary.LittleEndian.Uint64(b[i : i+8])
 		uncompressed, _, err = fs.readMetaBlock(file, c, int64(locn))
 		if err != nil {
-			return nil, fmt.Errorf("error reading uidgid index meta block %d at position %d: %v", i, locn, err)
+			return nil, fmt.Errorf("error reading uidgid index meta block %d at position %d: %w", i, locn, err)
 		}
 		data = append(data, uncompressed...)
 	}
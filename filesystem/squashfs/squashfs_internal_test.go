@@ -1,11 +1,17 @@
 package squashfs
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5" //nolint:gosec // MD5 is still fine for detecting file corruptions
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -277,7 +283,7 @@ func TestReadBlock(t *testing.T) {
 			backend:    file.New(testFile, true),
 			compressor: tt.compressor,
 		}
-		b, err := fs.readBlock(tt.location, tt.compressed, size)
+		b, err := fs.readBlock(tt.location, tt.compressed, size, nil)
 		switch {
 		case (err != nil && tt.err == nil) || (err == nil && tt.err != nil):
 			t.Errorf("%d: mismatched error, actual then expected", i)
@@ -395,3 +401,322 @@ func TestReadUidsGids(t *testing.T) {
 		t.Logf("%#v", expected)
 	}
 }
+
+func TestUnsquashfs(t *testing.T) {
+	// Skip if the test file doesn't exist
+	if _, err := os.Stat(Squashfsfile); os.IsNotExist(err) {
+		t.Skip("Test squashfs file not found, skipping test")
+	}
+
+	// Open the squash file
+	f, err := os.Open(Squashfsfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := file.New(f, true)
+	// Create the filesystem
+	fs, err := Read(b, fi.Size(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a temporary directory for extraction
+	tempDir := t.TempDir()
+
+	// Extract the filesystem
+	err = fs.Unsquashfs(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to extract squashfs: %v", err)
+	}
+
+	// Verify some expected files exist
+	expectedFiles := []string{
+		"README.md",
+		"foo/filename_10",
+		"foo/filename_75",
+	}
+
+	for _, expectedFile := range expectedFiles {
+		extractedPath := filepath.Join(tempDir, expectedFile)
+		if _, err := os.Stat(extractedPath); os.IsNotExist(err) {
+			t.Errorf("Expected file %s not found at %s", expectedFile, extractedPath)
+		}
+	}
+
+	// Verify content of a specific file
+	readmeContent, err := os.ReadFile(filepath.Join(tempDir, "README.md"))
+	if err != nil {
+		t.Errorf("Failed to read extracted README.md: %v", err)
+	} else if string(readmeContent) != "README\n" {
+		t.Errorf("README.md content mismatch, got %q, want %q", string(readmeContent), "README\n")
+	}
+
+	// Verify permissions and ownership are preserved
+	// This is a basic check - full verification would be more complex
+	readmeInfo, err := os.Stat(filepath.Join(tempDir, "README.md"))
+	if err != nil {
+		t.Errorf("Failed to stat README.md: %v", err)
+	} else if readmeInfo.Mode().Perm()&0444 == 0 {
+		t.Errorf("README.md should be readable, got mode %v", readmeInfo.Mode())
+	}
+}
+
+func TestUnsquashfsWithLargeFiles(t *testing.T) {
+	// Skip if the test file doesn't exist
+	if _, err := os.Stat(SquashfsReadTestFile); os.IsNotExist(err) {
+		t.Skip("Test squashfs read test file not found, skipping test")
+	}
+
+	// Open the squash file
+	f, err := os.Open(SquashfsReadTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := file.New(f, true)
+	// Create the filesystem
+	fs, err := Read(b, fi.Size(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a temporary directory for extraction
+	tempDir := t.TempDir()
+
+	// Extract the filesystem
+	err = fs.Unsquashfs(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to extract squashfs: %v", err)
+	}
+
+	// Read the check files in creating the tests
+	var tests []struct {
+		p      string
+		size   int
+		md5sum string
+	}
+	flist, err := os.Open(SquashfsReatTestMd5sums)
+	if err != nil {
+		t.Skip("MD5 sums file not found, skipping verification")
+	} else {
+		defer flist.Close()
+		scanner := bufio.NewScanner(flist)
+		for scanner.Scan() {
+			line := scanner.Text()
+			field := strings.Fields(line)
+			size, err := strconv.Atoi(field[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := strings.TrimPrefix(field[1], ".")
+			tests = append(tests, struct {
+				p      string
+				size   int
+				md5sum string
+			}{
+				p:      name,
+				size:   size,
+				md5sum: field[0],
+			})
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Verify MD5 sums of extracted files
+	for _, test := range tests {
+		extractedPath := filepath.Join(tempDir, test.p)
+		if _, err := os.Stat(extractedPath); os.IsNotExist(err) {
+			t.Errorf("Expected file %s not found at %s", test.p, extractedPath)
+			continue
+		}
+
+		data, err := os.ReadFile(extractedPath)
+		if err != nil {
+			t.Errorf("Failed to read file %s: %v", test.p, err)
+			continue
+		}
+
+		if len(data) != test.size {
+			t.Errorf("File %s size mismatch: got %d, want %d", test.p, len(data), test.size)
+		}
+
+		//nolint:gosec // MD5 is still fine for detecting file corruptions
+		md5sumBytes := md5.Sum(data)
+		md5sum := hex.EncodeToString(md5sumBytes[:])
+		if md5sum != test.md5sum {
+			t.Errorf("File %s MD5 mismatch: got %s, want %s", test.p, md5sum, test.md5sum)
+		}
+	}
+}
+
+func TestCreateFromPath(t *testing.T) {
+	// Create a temporary directory with some test files
+	tempDir := t.TempDir()
+
+	// Create a few files and directories
+	testDirs := []string{
+		"dir1",
+		"dir2",
+		"dir2/subdir",
+	}
+
+	testFiles := map[string]string{
+		"file1.txt":             "This is file 1",
+		"dir1/file2.txt":        "This is file 2 in dir1",
+		"dir2/file3.txt":        "This is file 3 in dir2",
+		"dir2/subdir/file4.txt": "This is file 4 in subdir",
+	}
+
+	// Create the directories
+	for _, dir := range testDirs {
+		err := os.MkdirAll(filepath.Join(tempDir, dir), 0755)
+		if err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+
+	// Create the files
+	for path, content := range testFiles {
+		err := os.WriteFile(filepath.Join(tempDir, path), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	// Create a squashfs from the directory
+	fs, err := CreateFromPath(tempDir)
+	if err != nil {
+		t.Fatalf("CreateFromPath failed: %v", err)
+	}
+	defer fs.Close()
+
+	// Verify the workspace was created and contains the files
+	if fs.workspace == "" {
+		t.Fatal("Expected workspace to be set")
+	}
+
+	// Check that the files were copied to the workspace
+	for path, content := range testFiles {
+		workspacePath := filepath.Join(fs.workspace, path)
+		data, err := os.ReadFile(workspacePath)
+		if err != nil {
+			t.Errorf("Failed to read file from workspace: %v", err)
+			continue
+		}
+
+		if string(data) != content {
+			t.Errorf("File content mismatch for %s: got %q, want %q", path, string(data), content)
+		}
+	}
+
+	// Create a temporary file to hold the squashfs image
+	imgFile, err := os.CreateTemp("", "squashfs-test-*.img")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(imgFile.Name())
+	defer imgFile.Close()
+
+	// Make the file large enough
+	if err := imgFile.Truncate(10 * 1024 * 1024); err != nil {
+		t.Fatalf("Failed to truncate file: %v", err)
+	}
+
+	// Create a backend for the file
+	b := file.New(imgFile, false)
+
+	// Set the filesystem backend and other required fields
+	fs.backend = b
+	fs.size = 10 * 1024 * 1024
+	fs.start = 0
+
+	// Finalize the filesystem
+	err = fs.Finalize(FinalizeOptions{
+		NoCompressInodes:    true,
+		NoCompressData:      true,
+		NoCompressFragments: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to finalize filesystem: %v", err)
+	}
+
+	// Reopen the file for reading
+	imgFile.Close()
+	readFile, err := os.Open(imgFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open image file: %v", err)
+	}
+	defer readFile.Close()
+
+	// Create a backend for reading
+	readBackend := file.New(readFile, true)
+
+	// Read the filesystem
+	readFs, err := Read(readBackend, 10*1024*1024, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to read filesystem: %v", err)
+	}
+
+	// Verify we can read the files
+	for path, expectedContent := range testFiles {
+		file, err := readFs.OpenFile("/"+path, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("Failed to open file %s: %v", path, err)
+			continue
+		}
+
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Errorf("Failed to read file %s: %v", path, err)
+			continue
+		}
+
+		if string(content) != expectedContent {
+			t.Errorf("Content mismatch for %s: got %q, want %q", path, string(content), expectedContent)
+		}
+	}
+}
+
+func TestReadLazyTables(t *testing.T) {
+	f, err := os.Open(Squashfsfile)
+	if err != nil {
+		t.Fatalf("Failed to read squashfs testfile %s: %v", Squashfsfile, err)
+	}
+	defer f.Close()
+	fs, err := Read(file.New(f, true), 0, 0, 0)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	// Read reads only the superblock and the root inode
+	if fs.fragments != nil || fs.uidsGids != nil || fs.xattrs != nil {
+		t.Errorf("tables read by Read")
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	// with the owners of the entries, and the xattrs of /attrfile
+	if len(entries) == 0 || fs.uidsGids == nil || fs.xattrs == nil {
+		t.Errorf("read %d entries with ids %v and xattrs %v", len(entries), fs.uidsGids, fs.xattrs)
+	}
+	if _, err := fs.ReadFile("README.md"); err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if fs.fragments == nil {
+		t.Errorf("fragments not read with the file in a fragment")
+	}
+}
@@ -2,6 +2,7 @@ package squashfs_test
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5" //nolint:gosec // MD5 is still fine for detecting file corruptions
 	"encoding/hex"
 	"errors"
@@ -21,6 +22,7 @@ import (
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
 	"github.com/diskfs/go-diskfs/testhelper"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 func getOpenMode(mode int) string {
@@ -101,6 +103,52 @@ func TestSquashfsSetCacheSize(t *testing.T) {
 	assertCacheSize(0)
 }
 
+func TestSquashfsSetBufferPool(t *testing.T) {
+	f, err := os.Open(squashfs.SquashfsReadTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fs, err := squashfs.Read(file.New(f, true), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.SetCacheSize(0)
+
+	// read both ways in pieces which straddle the blocks, with the default pool and with none
+	readAt := func(p string) []byte {
+		fh, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fh.Close()
+		var out []byte
+		buf := make([]byte, 100000)
+		for off := int64(0); ; off += int64(len(buf)) {
+			n, err := fh.(io.ReaderAt).ReadAt(buf, off)
+			out = append(out, buf[:n]...)
+			if err == io.EOF {
+				return out
+			}
+			if err != nil {
+				t.Fatalf("error reading %s at %d: %v", p, off, err)
+			}
+		}
+	}
+	for p, size := range map[string]int{"/compressible-large": 970761, "/zeros-plus": 1048577} {
+		pooled := readAt(p)
+		fs.SetBufferPool(nil)
+		unpooled := readAt(p)
+		fs.SetBufferPool(util.DefaultBufferPool)
+		if !bytes.Equal(pooled, unpooled) {
+			t.Errorf("%s: contents read with and without a buffer pool differ", p)
+		}
+		if len(pooled) != size {
+			t.Errorf("%s: read %d bytes instead of %d", p, len(pooled), size)
+		}
+	}
+}
+
 func TestSquashfsMkdir(t *testing.T) {
 	t.Run("read-only", func(t *testing.T) {
 		fs, err := getValidSquashfsFSReadOnly()
@@ -316,8 +364,12 @@ func TestSquashfsOpen(t *testing.T) {
 		t.Errorf("Failed to list squashfs filesystem: %v", err)
 	}
 	var dir = make(map[string]os.FileInfo, len(fis))
-	for _, fi := range fis {
-		dir[fi.Name()] = fi
+	for _, de := range fis {
+		fi, err := de.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir[de.Name()] = fi
 	}
 
 	// Check a file
@@ -356,6 +408,108 @@ func TestSquashfsOpen(t *testing.T) {
 	}
 }
 
+// Test WalkDir from the directory table matches the generic walk with ReadDir
+func TestSquashfsWalkDir(t *testing.T) {
+	fs, err := getValidSquashfsFSReadOnly()
+	if err != nil {
+		t.Fatalf("Failed to get read-only squashfs filesystem: %v", err)
+	}
+	type walked struct {
+		path string
+		typ  stdfs.FileMode
+		mode stdfs.FileMode
+		size int64
+	}
+	walk := func(walkDir func(root string, fn stdfs.WalkDirFunc) error, root string) []walked {
+		var list []walked
+		err := walkDir(root, func(p string, d stdfs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			list = append(list, walked{p, d.Type(), info.Mode(), info.Size()})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to walk %s: %v", root, err)
+		}
+		return list
+	}
+	for _, root := range []string{"/", "/README.md"} {
+		fast := walk(fs.WalkDir, root)
+		generic := walk(func(root string, fn stdfs.WalkDirFunc) error {
+			return filesystem.GenericWalkDir(fs, root, fn)
+		}, root)
+		if len(fast) == 0 || fast[0].path != root {
+			t.Errorf("Walk of %s did not start at the root", root)
+		}
+		if len(fast) != len(generic) {
+			t.Fatalf("Walk of %s found %d entries, generic walk %d", root, len(fast), len(generic))
+		}
+		for i := range fast {
+			if fast[i] != generic[i] {
+				t.Errorf("Walk of %s found %+v, generic walk %+v", root, fast[i], generic[i])
+			}
+		}
+	}
+	err = fs.WalkDir("/", func(p string, d stdfs.DirEntry, err error) error {
+		if p != "/" && d.IsDir() {
+			t.Errorf("Walk entered %s after SkipAll", p)
+		}
+		if p == "/README.md" {
+			return stdfs.SkipAll
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("Walk with SkipAll returned %v", err)
+	}
+}
+
+// Test the filesystem through the functions of io/fs
+func TestSquashfsIOFS(t *testing.T) {
+	fs, err := getValidSquashfsFSReadOnly()
+	if err != nil {
+		t.Fatalf("Failed to get read-only squashfs filesystem: %v", err)
+	}
+	content, err := stdfs.ReadFile(fs, "README.md")
+	if err != nil {
+		t.Fatalf("Failed to read README.md: %v", err)
+	}
+	if string(content) != "README\n" {
+		t.Errorf("README.md contains %q instead of %q", content, "README\n")
+	}
+	entries, err := stdfs.ReadDir(fs, ".")
+	if err != nil {
+		t.Fatalf("Failed to read root directory: %v", err)
+	}
+	if len(entries) != 9 || entries[0].Name() != "README.md" || entries[len(entries)-1].Name() != "zero" {
+		t.Errorf("Root directory has %d entries from %s", len(entries), entries[0].Name())
+	}
+	var files int
+	err = stdfs.WalkDir(fs, ".", func(p string, d stdfs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk filesystem: %v", err)
+	}
+	if files == 0 {
+		t.Errorf("No files found walking filesystem")
+	}
+	if _, err := stdfs.Stat(fs, "missing"); !errors.Is(err, stdfs.ErrNotExist) {
+		t.Errorf("Stat of missing file returned %v instead of %v", err, stdfs.ErrNotExist)
+	}
+}
+
 func TestSquashfsRead(t *testing.T) {
 	tests := []struct {
 		blocksize  int64
@@ -440,7 +594,11 @@ func TestSquashfsCheckListing(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		for _, fi := range fis {
+		for _, de := range fis {
+			fi, err := de.Info()
+			if err != nil {
+				t.Fatal(err)
+			}
 			p := path.Join(dir, fi.Name())
 			if _, found := listing[p]; found {
 				delete(listing, p)
@@ -701,7 +859,7 @@ func TestSquashfsReadDirXattr(t *testing.T) {
 		f      string
 		xattrs map[string]string
 	}{
-		{"/", "attrfile", map[string]string{"abc": "def", "myattr": "hello"}},
+		{"/", "attrfile", map[string]string{"user.abc": "def", "user.myattr": "hello"}},
 		{"/", "README.md", map[string]string{}},
 	}
 
@@ -719,8 +877,8 @@ func TestSquashfsReadDirXattr(t *testing.T) {
 		)
 		for _, f := range list {
 			if f.Name() == tt.f {
-				fi = f
-				found = true
+				fi, err = f.Info()
+				found = err == nil
 			}
 		}
 		if !found {
@@ -784,290 +942,8 @@ func TestFinalize(t *testing.T) {
 
 }
 
-func TestUnsquashfs(t *testing.T) {
-	// Skip if the test file doesn't exist
-	if _, err := os.Stat(Squashfsfile); os.IsNotExist(err) {
-		t.Skip("Test squashfs file not found, skipping test")
-	}
-
-	// Open the squash file
-	f, err := os.Open(Squashfsfile)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer f.Close()
-	fi, err := f.Stat()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	b := file.New(f, true)
-	// Create the filesystem
-	fs, err := Read(b, fi.Size(), 0, 0)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Create a temporary directory for extraction
-	tempDir := t.TempDir()
-
-	// Extract the filesystem
-	err = fs.Unsquashfs(tempDir)
-	if err != nil {
-		t.Fatalf("Failed to extract squashfs: %v", err)
-	}
-
-	// Verify some expected files exist
-	expectedFiles := []string{
-		"README.md",
-		"foo/filename_10",
-		"foo/filename_75",
-	}
-
-	for _, expectedFile := range expectedFiles {
-		extractedPath := filepath.Join(tempDir, expectedFile)
-		if _, err := os.Stat(extractedPath); os.IsNotExist(err) {
-			t.Errorf("Expected file %s not found at %s", expectedFile, extractedPath)
-		}
-	}
-
-	// Verify content of a specific file
-	readmeContent, err := os.ReadFile(filepath.Join(tempDir, "README.md"))
-	if err != nil {
-		t.Errorf("Failed to read extracted README.md: %v", err)
-	} else if string(readmeContent) != "README\n" {
-		t.Errorf("README.md content mismatch, got %q, want %q", string(readmeContent), "README\n")
-	}
-
-	// Verify permissions and ownership are preserved
-	// This is a basic check - full verification would be more complex
-	readmeInfo, err := os.Stat(filepath.Join(tempDir, "README.md"))
-	if err != nil {
-		t.Errorf("Failed to stat README.md: %v", err)
-	} else if readmeInfo.Mode().Perm()&0444 == 0 {
-		t.Errorf("README.md should be readable, got mode %v", readmeInfo.Mode())
-	}
-}
-
-func TestUnsquashfsWithLargeFiles(t *testing.T) {
-	// Skip if the test file doesn't exist
-	if _, err := os.Stat(SquashfsReadTestFile); os.IsNotExist(err) {
-		t.Skip("Test squashfs read test file not found, skipping test")
-	}
-
-	// Open the squash file
-	f, err := os.Open(SquashfsReadTestFile)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer f.Close()
-	fi, err := f.Stat()
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	b := file.New(f, true)
-	// Create the filesystem
-	fs, err := Read(b, fi.Size(), 0, 0)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Create a temporary directory for extraction
-	tempDir := t.TempDir()
-
-	// Extract the filesystem
-	err = fs.Unsquashfs(tempDir)
-	if err != nil {
-		t.Fatalf("Failed to extract squashfs: %v", err)
-	}
-
-	// Read the check files in creating the tests
-	var tests []readTest
-	flist, err := os.Open(SquashfsReatTestMd5sums)
-	if err != nil {
-		t.Skip("MD5 sums file not found, skipping verification")
-	} else {
-		defer flist.Close()
-		scanner := bufio.NewScanner(flist)
-		for scanner.Scan() {
-			line := scanner.Text()
-			field := strings.Fields(line)
-			size, err := strconv.Atoi(field[2])
-			if err != nil {
-				t.Fatal(err)
-			}
-			name := strings.TrimPrefix(field[1], ".")
-			tests = append(tests, readTest{
-				name:   strings.TrimPrefix(name, "/"),
-				p:      name,
-				size:   size,
-				md5sum: field[0],
-			})
-		}
-		if err := scanner.Err(); err != nil {
-			t.Fatal(err)
-		}
-	}
-
-	// Verify MD5 sums of extracted files
-	for _, test := range tests {
-		extractedPath := filepath.Join(tempDir, test.p)
-		if _, err := os.Stat(extractedPath); os.IsNotExist(err) {
-			t.Errorf("Expected file %s not found at %s", test.p, extractedPath)
-			continue
-		}
-
-		data, err := os.ReadFile(extractedPath)
-		if err != nil {
-			t.Errorf("Failed to read file %s: %v", test.p, err)
-			continue
-		}
-
-		if len(data) != test.size {
-			t.Errorf("File %s size mismatch: got %d, want %d", test.p, len(data), test.size)
-		}
-
-		//nolint:gosec // MD5 is still fine for detecting file corruptions
-		md5sumBytes := md5.Sum(data)
-		md5sum := hex.EncodeToString(md5sumBytes[:])
-		if md5sum != test.md5sum {
-			t.Errorf("File %s MD5 mismatch: got %s, want %s", test.p, md5sum, test.md5sum)
-		}
-	}
-}
-
-func TestCreateFromPath(t *testing.T) {
-	// Create a temporary directory with some test files
-	tempDir := t.TempDir()
-	
-	// Create a few files and directories
-	testDirs := []string{
-		"dir1",
-		"dir2",
-		"dir2/subdir",
-	}
-	
-	testFiles := map[string]string{
-		"file1.txt":          "This is file 1",
-		"dir1/file2.txt":     "This is file 2 in dir1",
-		"dir2/file3.txt":     "This is file 3 in dir2",
-		"dir2/subdir/file4.txt": "This is file 4 in subdir",
-	}
-	
-	// Create the directories
-	for _, dir := range testDirs {
-		err := os.MkdirAll(filepath.Join(tempDir, dir), 0755)
-		if err != nil {
-			t.Fatalf("Failed to create test directory: %v", err)
-		}
-	}
-	
-	// Create the files
-	for path, content := range testFiles {
-		err := os.WriteFile(filepath.Join(tempDir, path), []byte(content), 0644)
-		if err != nil {
-			t.Fatalf("Failed to create test file: %v", err)
-		}
-	}
-	
-	// Create a squashfs from the directory
-	fs, err := CreateFromPath(tempDir)
-	if err != nil {
-		t.Fatalf("CreateFromPath failed: %v", err)
-	}
-	defer fs.Close()
-	
-	// Verify the workspace was created and contains the files
-	if fs.workspace == "" {
-		t.Fatal("Expected workspace to be set")
-	}
-	
-	// Check that the files were copied to the workspace
-	for path, content := range testFiles {
-		workspacePath := filepath.Join(fs.workspace, path)
-		data, err := os.ReadFile(workspacePath)
-		if err != nil {
-			t.Errorf("Failed to read file from workspace: %v", err)
-			continue
-		}
-		
-		if string(data) != content {
-			t.Errorf("File content mismatch for %s: got %q, want %q", path, string(data), content)
-		}
-	}
-	
-	// Create a temporary file to hold the squashfs image
-	imgFile, err := os.CreateTemp("", "squashfs-test-*.img")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	defer os.Remove(imgFile.Name())
-	defer imgFile.Close()
-	
-	// Make the file large enough
-	if err := imgFile.Truncate(10 * 1024 * 1024); err != nil {
-		t.Fatalf("Failed to truncate file: %v", err)
-	}
-	
-	// Create a backend for the file
-	b := file.New(imgFile, false)
-	
-	// Set the filesystem backend and other required fields
-	fs.backend = b
-	fs.size = 10 * 1024 * 1024
-	fs.start = 0
-	
-	// Finalize the filesystem
-	err = fs.Finalize(FinalizeOptions{
-		NoCompressInodes:    true,
-		NoCompressData:      true,
-		NoCompressFragments: true,
-	})
-	if err != nil {
-		t.Fatalf("Failed to finalize filesystem: %v", err)
-	}
-	
-	// Reopen the file for reading
-	imgFile.Close()
-	readFile, err := os.Open(imgFile.Name())
-	if err != nil {
-		t.Fatalf("Failed to open image file: %v", err)
-	}
-	defer readFile.Close()
-	
-	// Create a backend for reading
-	readBackend := file.New(readFile, true)
-	
-	// Read the filesystem
-	readFs, err := Read(readBackend, 10*1024*1024, 0, 0)
-	if err != nil {
-		t.Fatalf("Failed to read filesystem: %v", err)
-	}
-	
-	// Verify we can read the files
-	for path, expectedContent := range testFiles {
-		file, err := readFs.OpenFile(path, os.O_RDONLY)
-		if err != nil {
-			t.Errorf("Failed to open file %s: %v", path, err)
-			continue
-		}
-		
-		content, err := io.ReadAll(file)
-		file.Close()
-		if err != nil {
-			t.Errorf("Failed to read file %s: %v", path, err)
-			continue
-		}
-		
-		if string(content) != expectedContent {
-			t.Errorf("Content mismatch for %s: got %q, want %q", path, string(content), expectedContent)
-		}
-	}
-}
-
 func TestCreateAndReadFile(t *testing.T) {
-	CreateFilesystem := func(d *disk.Disk, spec disk.FilesystemSpec) (filesystem.FileSystem, error) {
+	CreateFilesystem := func(d *disk.Disk, spec disk.FormatSpec) (filesystem.FileSystem, error) {
 		// find out where the partition starts and ends, or if it is the entire disk
 		var (
 			size, start int64
@@ -1110,7 +986,7 @@ func TestCreateAndReadFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	fspec := disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeSquashfs, VolumeLabel: "label"}
+	fspec := disk.FormatSpec{Partition: 0, FSType: filesystem.TypeSquashfs, VolumeLabel: "label"}
 	fs, err := CreateFilesystem(mydisk, fspec)
 	if err != nil {
 		t.Fatal(err)
@@ -1163,3 +1039,17 @@ func TestCreateAndReadFile(t *testing.T) {
 		t.Errorf("groupdescriptor.toBytes() mismatched, actual then expected\n%s", diffString)
 	}
 }
+
+func TestSquashfsCheck(t *testing.T) {
+	fs, err := getValidSquashfsFSReadOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := fs.Check(filesystem.CheckOptions{})
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if len(findings) != 0 {
+		t.Errorf("findings %v in a sound filesystem", findings)
+	}
+}
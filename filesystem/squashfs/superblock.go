@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math"
 	"time"
+
+	"github.com/diskfs/go-diskfs/util"
 )
 
 const (
@@ -91,7 +93,7 @@ func (s *superblock) equal(a *superblock) bool {
 	*a1 = *a
 	s1.rootInode = nil
 	a1.rootInode = nil
-	modTime := time.Now()
+	modTime := util.Now()
 	s1.modTime = modTime
 	a1.modTime = modTime
 	sblockEql := *s1 == *a1
@@ -204,7 +206,7 @@ func parseSuperblock(b []byte) (*superblock, error) {
 	}
 	flags, err := parseFlags(b[24:26])
 	if err != nil {
-		return nil, fmt.Errorf("error parsing flags bytes: %v", err)
+		return nil, fmt.Errorf("error parsing flags bytes: %w", err)
 	}
 	s := &superblock{
 		inodes:              binary.LittleEndian.Uint32(b[4:8]),
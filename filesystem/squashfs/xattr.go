@@ -37,6 +37,13 @@ func parseXAttrIndex(b []byte, offsetMap map[uint32]uint32) (*xAttrIndex, error)
 	return toReturn, nil
 }
 
+// xAttrPrefixes are the prefixes of the names of xattrs by their type
+var xAttrPrefixes = map[uint16]string{
+	0: "user.",
+	1: "trusted.",
+	2: "security.",
+}
+
 type xAttrTable struct {
 	list []*xAttrIndex
 	data []byte
@@ -59,8 +66,8 @@ func (x *xAttrTable) find(pos int) (map[string]string, error) {
 		if len(b[ptr:]) < 4 {
 			return nil, fmt.Errorf("insufficient bytes %d to read the xattr at position %d", len(b[ptr:]), ptr)
 		}
-		// get the type and size
-		//   xType := binary.LittleEndian.Uint16(b[ptr : ptr+2])
+		// get the type, whose low byte is the prefix of the name, and size
+		xType := binary.LittleEndian.Uint16(b[ptr:ptr+2]) & 0xff
 		xSize := int(binary.LittleEndian.Uint16(b[ptr+2 : ptr+4]))
 		nameStart := ptr + 4
 		valHeaderStart := nameStart + xSize
@@ -72,7 +79,11 @@ func (x *xAttrTable) find(pos int) (map[string]string, error) {
 		if xSize < 1 {
 			return nil, fmt.Errorf("no name given for xattr at position %d", ptr)
 		}
-		key := string(b[nameStart : nameStart+xSize])
+		prefix, ok := xAttrPrefixes[xType]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %d of xattr at position %d", xType, ptr)
+		}
+		key := prefix + string(b[nameStart:nameStart+xSize])
 		// read the size of the value
 		if len(b[valHeaderStart:]) < 4 {
 			return nil, fmt.Errorf("insufficient bytes %d to read the xattr value at position %d", len(b[valHeaderStart:]), ptr)
@@ -85,7 +96,7 @@ func (x *xAttrTable) find(pos int) (map[string]string, error) {
 		xattrs[key] = val
 
 		// increment the position pointer
-		ptr += valStart + valSize
+		ptr = valStart + valSize
 	}
 	return xattrs, nil
 }
@@ -88,8 +88,8 @@ func TestXAttrTableFind(t *testing.T) {
 		err    error
 	}{
 		{5, nil, fmt.Errorf("position %d is greater than list size %d", 5, len(x.list))},
-		{0, map[string]string{"ABC": "DEFGHI", "KLM": "NOPQ"}, nil},
-		{1, map[string]string{"FGHI": "KL"}, nil},
+		{0, map[string]string{"user.ABC": "DEFGHI", "user.KLM": "NOPQ"}, nil},
+		{1, map[string]string{"user.FGHI": "KL"}, nil},
 	}
 	for i, tt := range tests {
 		xattrs, err := x.find(tt.pos)
@@ -0,0 +1,80 @@
+package filesystem_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+func TestLstatReadlink(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(workspace, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.Symlink("DIR1/FILE1.TXT", "/LINK"); err != nil {
+		t.Fatalf("error creating symbolic link: %v", err)
+	}
+	check := func(t *testing.T, f filesystem.FileSystem) {
+		t.Helper()
+		if _, ok := f.(filesystem.LstatFS); !ok {
+			t.Errorf("not an LstatFS")
+		}
+		if _, ok := f.(filesystem.ReadlinkFS); !ok {
+			t.Errorf("not a ReadlinkFS")
+		}
+		info, err := filesystem.Lstat(f, "LINK")
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			t.Errorf("link described as %v, %v", info, err)
+		}
+		if target, err := filesystem.Readlink(f, "/LINK"); err != nil || target != "DIR1/FILE1.TXT" {
+			t.Errorf("symbolic link to %q, %v instead of %q", target, err, "DIR1/FILE1.TXT")
+		}
+		info, err = f.Stat("LINK")
+		if err != nil || !info.Mode().IsRegular() || info.Name() != "LINK" || info.Size() != int64(len(fstest.Tree["DIR1/FILE1.TXT"])) {
+			t.Errorf("target described as %v, %v", info, err)
+		}
+		if _, err := filesystem.Readlink(f, "README.TXT"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("error %v reading regular file instead of %v", err, fs.ErrInvalid)
+		}
+		if _, err := filesystem.Readlink(f, "MISSING"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("error %v reading missing file instead of %v", err, fs.ErrNotExist)
+		}
+	}
+	t.Run("workspace", func(t *testing.T) {
+		check(t, workspace)
+	})
+	t.Run("squashfs", func(t *testing.T) {
+		if err := workspace.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		check(t, read)
+	})
+	t.Run("fat32", func(t *testing.T) {
+		f := createFat32(t)
+		if err := fstest.Write(f, fstest.Tree); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := filesystem.Lstat(f, "README.TXT"); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("file described as %v, %v", info, err)
+		}
+		if _, err := filesystem.Readlink(f, "README.TXT"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("error %v reading regular file instead of %v", err, fs.ErrInvalid)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// FromTarOptions are the options of FromTar
+type FromTarOptions struct {
+	// Filter, if set, is called for every entry of the archive. Those for which it returns false
+	// are not created.
+	Filter func(hdr *tar.Header) bool
+	// IgnoreUnsupported skips the entries and the extended attributes the destination cannot
+	// hold, such as symbolic links and devices on FAT32, or extended attributes on a filesystem
+	// that is not an XattrFS, instead of failing. Hard links are created as copies of their target
+	// where the destination has no hard links, either way.
+	IgnoreUnsupported bool
+	// NoMetadata does not set the mode, owner, times and extended attributes of the files.
+	// Otherwise they are set where the destination supports them, the times of directories once the
+	// whole archive is read.
+	NoMetadata bool
+}
+
+// paxXattr is the prefix of the records of PAX headers holding the extended attributes of files
+const paxXattr = "SCHILY.xattr."
+
+// FromTar creates the files of the tar archive read from r in the writable filesystem dst, or in
+// the workspace of a filesystem not yet finalized, e.g. to turn the layer of an OCI image into a
+// squashfs or ext4 image. Directories, regular files, symbolic links, hard links and devices are
+// created with the names of their entries relative to the root of dst, along with the parent
+// directories missing from the archive. Files already in dst are replaced.
+//
+// GNU and PAX headers, including long names and sparse files, are read as archive/tar does.
+// Extended attributes in PAX headers are set with SetXattrs, see FromTarOptions.
+func FromTar(dst FileSystem, r io.Reader, opts FromTarOptions) error {
+	tr := tar.NewReader(r)
+	// the directories, whose times are changed by creating their contents
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar archive: %w", err)
+		}
+		if opts.Filter != nil && !opts.Filter(hdr) {
+			continue
+		}
+		name := path.Join("/", hdr.Name)
+		if name == "/" && hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if err := fromTarEntry(dst, tr, hdr, name, opts); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+	if c, ok := dst.(ChtimesFS); ok && !opts.NoMetadata {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			name := path.Join("/", dirs[i].Name)
+			if err := c.Chtimes(name, dirs[i].AccessTime, dirs[i].ModTime); err != nil && !unsupported(err) {
+				return fmt.Errorf("error changing times of %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// fromTarEntry creates the file of the entry of the archive, the content of regular files read from tr
+func fromTarEntry(dst FileSystem, tr io.Reader, hdr *tar.Header, name string, opts FromTarOptions) error {
+	if dir := path.Dir(name); dir != "/" {
+		if err := dst.Mkdir(dir); err != nil {
+			return fmt.Errorf("error creating directory %s: %w", dir, err)
+		}
+	}
+	// regular files are truncated by writing them, other files already there are replaced rather
+	// than written through, as symbolic links would be
+	if hdr.Typeflag != tar.TypeDir {
+		if info, err := Lstat(dst, name); err == nil && !info.IsDir() && (hdr.Typeflag != tar.TypeReg || !info.Mode().IsRegular()) {
+			if err := dst.Remove(name); err != nil {
+				return fmt.Errorf("error replacing %s: %w", name, err)
+			}
+		}
+	}
+	var err error
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		err = dst.Mkdir(name)
+	case tar.TypeReg:
+		// archive/tar reports the regular files of old archives, and sparse files, as TypeReg
+		err = writeTarFile(dst, name, tr)
+	case tar.TypeSymlink:
+		err = dst.Symlink(hdr.Linkname, name)
+	case tar.TypeLink:
+		target := path.Join("/", hdr.Linkname)
+		if err = dst.Link(target, name); unsupported(err) {
+			err = copyTarLink(dst, target, name)
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		err = dst.Mknod(name, UnixMode(hdr.FileInfo().Mode()), Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		err = fmt.Errorf("tar entry of type %q: %w", hdr.Typeflag, ErrNotSupported)
+	}
+	if err != nil {
+		if opts.IgnoreUnsupported && unsupported(err) {
+			return nil
+		}
+		return fmt.Errorf("error creating %s: %w", name, err)
+	}
+	if opts.NoMetadata || hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+	if err := SetXattrs(dst, name, tarXattrs(hdr)); err != nil && (!opts.IgnoreUnsupported || !unsupported(err)) {
+		return fmt.Errorf("error setting extended attributes of %s: %w", name, err)
+	}
+	// the metadata of a link would change its target
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	if err := dst.Chmod(name, hdr.FileInfo().Mode()); err != nil && !unsupported(err) {
+		return fmt.Errorf("error changing mode of %s: %w", name, err)
+	}
+	if err := dst.Chown(name, hdr.Uid, hdr.Gid); err != nil && !unsupported(err) {
+		return fmt.Errorf("error changing owner of %s: %w", name, err)
+	}
+	if c, ok := dst.(ChtimesFS); ok && hdr.Typeflag != tar.TypeDir {
+		if err := c.Chtimes(name, hdr.AccessTime, hdr.ModTime); err != nil && !unsupported(err) {
+			return fmt.Errorf("error changing times of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func writeTarFile(dst FileSystem, name string, r io.Reader) error {
+	f, err := dst.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyTarLink creates the hard link to the file already created as a copy of it
+func copyTarLink(dst FileSystem, target, name string) error {
+	f, err := dst.OpenFile(target, os.O_RDONLY)
+	if err != nil {
+		return fmt.Errorf("error opening target %s of hard link: %w", target, err)
+	}
+	defer f.Close()
+	return writeTarFile(dst, name, f)
+}
+
+// tarXattrs are the extended attributes in the PAX records of the entry
+func tarXattrs(hdr *tar.Header) map[string][]byte {
+	var values map[string][]byte
+	for k, v := range hdr.PAXRecords {
+		if attr, ok := strings.CutPrefix(k, paxXattr); ok {
+			if values == nil {
+				values = map[string][]byte{}
+			}
+			values[attr] = []byte(v)
+		}
+	}
+	return values
+}
+
+// ToTarOptions are the options of ToTar
+type ToTarOptions struct {
+	// Filter, if set, is called for every file and directory, with its name relative to the root
+	// in the form of io/fs. Those for which it returns false are not written, nor is anything
+	// inside skipped directories.
+	Filter func(name string, d fs.DirEntry) bool
+	// ModTime, if set, is the modification time of every entry, as SOURCE_DATE_EPOCH is for
+	// reproducible builds. Otherwise that of each file is used, in whole seconds.
+	ModTime time.Time
+	// IgnoreUnsupported skips the files that cannot be written to the archive, such as devices
+	// whose numbers the filesystem does not report, instead of failing
+	IgnoreUnsupported bool
+}
+
+// ToTar writes the file tree rooted at root in src to w as a tar archive, e.g. to turn an image
+// into the layer of an OCI image. The root is absolute or in the form of io/fs, and the names of
+// the entries are relative to it; the root itself has no entry.
+//
+// The archive is reproducible: the entries are sorted by name, owners are numeric only, times
+// are in whole seconds without access and change times, and the extended attributes of the
+// filesystems that are an XattrFS are written in PAX headers, sorted by archive/tar. Directories,
+// regular files, symbolic links, named pipes and the devices whose numbers are known, see
+// DeviceNumbers, are written, and hard links to the regular files written before them where src is
+// a LinkFS.
+func ToTar(src FileSystem, root string, w io.Writer, opts ToTarOptions) error {
+	root = path.Clean(AbsolutePath(root))
+	tw := tar.NewWriter(w)
+	// the names of the regular files written by the inode of their source, for the hard links to them
+	linkFS, _ := src.(LinkFS)
+	links := map[uint64]string{}
+	err := WalkDir(src, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := relativeName(strings.TrimPrefix(p, root))
+		if name == "." {
+			return nil
+		}
+		if opts.Filter != nil && !opts.Filter(name, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", p, err)
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = Readlink(src, p); err != nil {
+				if opts.IgnoreUnsupported && unsupported(err) {
+					return nil
+				}
+				return fmt.Errorf("unable to read symbolic link %s: %w", p, err)
+			}
+		}
+		xattrs, err := Xattrs(src, p)
+		if err != nil {
+			return fmt.Errorf("error reading extended attributes of %s: %w", p, err)
+		}
+		hdr, err := tarHeader(info, name, link, xattrs, opts)
+		if err != nil {
+			if opts.IgnoreUnsupported && unsupported(err) {
+				return nil
+			}
+			return fmt.Errorf("unable to write %s to tar archive: %w", p, err)
+		}
+		if hdr.Typeflag == tar.TypeReg && linkFS != nil {
+			ino, err := linkFS.Inode(p)
+			switch {
+			case err != nil && !unsupported(err):
+				return fmt.Errorf("error reading inode of %s: %w", p, err)
+			case err != nil:
+			case links[ino] != "":
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, links[ino], 0
+			default:
+				links[ino] = name
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing tar header of %s: %w", p, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := src.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", p, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("error writing %s to tar archive: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// tarHeader is the header of the entry of the file in the archive, with the target of symbolic links
+// and the extended attributes of the file
+func tarHeader(info fs.FileInfo, name, link string, xattrs map[string][]byte, opts ToTarOptions) (*tar.Header, error) {
+	mode := info.Mode()
+	modTime := opts.ModTime
+	if modTime.IsZero() {
+		modTime = info.ModTime()
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(mode.Perm()),
+		ModTime: modTime.Truncate(time.Second),
+	}
+	switch {
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case mode.IsRegular():
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+	case mode&fs.ModeSymlink != 0:
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, link
+	case mode&fs.ModeDevice != 0:
+		major, minor, ok := DeviceNumbers(info)
+		if !ok {
+			return nil, fmt.Errorf("numbers of device unknown: %w", ErrNotSupported)
+		}
+		hdr.Typeflag, hdr.Devmajor, hdr.Devminor = tar.TypeBlock, int64(major), int64(minor)
+		if mode&fs.ModeCharDevice != 0 {
+			hdr.Typeflag = tar.TypeChar
+		}
+	case mode&fs.ModeNamedPipe != 0:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		return nil, fmt.Errorf("file of type %s: %w", mode.Type(), ErrNotSupported)
+	}
+	if mode&fs.ModeSetuid != 0 {
+		hdr.Mode |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		hdr.Mode |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		hdr.Mode |= 0o1000
+	}
+	if o, ok := info.Sys().(owner); ok {
+		hdr.Uid, hdr.Gid = int(o.UID()), int(o.GID())
+	}
+	if len(xattrs) > 0 {
+		hdr.Format = tar.FormatPAX
+		hdr.PAXRecords = map[string]string{}
+		for k, v := range xattrs {
+			hdr.PAXRecords[paxXattr+k] = string(v)
+		}
+	}
+	return hdr, nil
+}
@@ -0,0 +1,222 @@
+package filesystem_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// capNetRaw is the file capability granting CAP_NET_RAW, as set by setcap cap_net_raw+ep
+const capNetRaw = "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+// layer is a tar archive in the form of the layer of an OCI image
+func layer(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr     tar.Header
+		content string
+	}{
+		{tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0o755}, ""},
+		{tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0o755}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hostname", Mode: 0o644}, "diskfs\n"},
+		// no entry for its directory
+		{tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/tool", Mode: 0o755}, "#!/bin/sh\n"},
+		{tar.Header{Typeflag: tar.TypeLink, Name: "usr/bin/tool2", Linkname: "usr/bin/tool"}, ""},
+		{tar.Header{Typeflag: tar.TypeSymlink, Name: "bin", Linkname: "usr/bin", Mode: 0o777}, ""},
+		{tar.Header{Typeflag: tar.TypeChar, Name: "dev/null", Mode: 0o666, Devmajor: 1, Devminor: 3}, ""},
+		{tar.Header{Typeflag: tar.TypeReg, Name: "usr/bin/ping", Mode: 0o755, Format: tar.FormatPAX,
+			PAXRecords: map[string]string{"SCHILY.xattr.security.capability": capNetRaw}}, "ping"},
+		// replaces the file written before
+		{tar.Header{Typeflag: tar.TypeReg, Name: "etc/hostname", Mode: 0o644}, "replaced\n"},
+	}
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.content))
+		if err := w.WriteHeader(&e.hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFromTar(t *testing.T) {
+	archive := layer(t)
+	expected := map[string]string{
+		"etc/hostname":  "replaced\n",
+		"usr/bin/tool":  "#!/bin/sh\n",
+		"usr/bin/tool2": "#!/bin/sh\n",
+		"usr/bin/ping":  "ping",
+	}
+
+	t.Run("fat32", func(t *testing.T) {
+		dst := createFat32(t)
+		err := filesystem.FromTar(dst, bytes.NewReader(archive), filesystem.FromTarOptions{})
+		if !errors.Is(err, filesystem.ErrNotSupported) {
+			t.Errorf("error %v with symbolic link on FAT32 instead of %v", err, filesystem.ErrNotSupported)
+		}
+
+		dst = createFat32(t)
+		if err := filesystem.FromTar(dst, bytes.NewReader(archive), filesystem.FromTarOptions{IgnoreUnsupported: true}); err != nil {
+			t.Fatalf("error importing archive: %v", err)
+		}
+		for name, content := range expected {
+			if b, err := dst.ReadFile(name); err != nil || string(b) != content {
+				t.Errorf("%s: read %q, %v instead of %q", name, b, err, content)
+			}
+		}
+		for _, name := range []string{"bin", "dev/null"} {
+			if _, err := dst.Stat(name); err == nil {
+				t.Errorf("%s created on FAT32", name)
+			}
+		}
+	})
+
+	t.Run("squashfs", func(t *testing.T) {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := squashfs.Create(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		// devices need privileges in the workspace
+		filter := func(hdr *tar.Header) bool {
+			return hdr.Typeflag != tar.TypeChar
+		}
+		if err := filesystem.FromTar(dst, bytes.NewReader(archive), filesystem.FromTarOptions{Filter: filter, IgnoreUnsupported: true}); err != nil {
+			t.Fatalf("error importing archive: %v", err)
+		}
+		if err := dst.Finalize(squashfs.FinalizeOptions{}); err != nil {
+			t.Fatalf("error finalizing filesystem: %v", err)
+		}
+		read, err := squashfs.Read(b, size, 0, 4096)
+		if err != nil {
+			t.Fatalf("error reading filesystem: %v", err)
+		}
+		for name, content := range expected {
+			if b, err := read.ReadFile(name); err != nil || string(b) != content {
+				t.Errorf("%s: read %q, %v instead of %q", name, b, err, content)
+			}
+		}
+		entries, err := read.ReadDir("/")
+		if err != nil {
+			t.Fatalf("error reading directory: %v", err)
+		}
+		if len(entries) != 3 || entries[0].Name() != "bin" || entries[0].Type() != fs.ModeSymlink {
+			t.Fatalf("root entries %v, bin not a symbolic link", entries)
+		}
+		info, err := entries[0].Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target, err := info.Sys().(squashfs.FileStat).Readlink(); err != nil || target != "usr/bin" {
+			t.Errorf("symbolic link to %q, %v instead of %q", target, err, "usr/bin")
+		}
+	})
+}
+
+func TestToTar(t *testing.T) {
+	src := createFat32(t)
+	if err := fstest.Write(src, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	epoch := time.Unix(1700000000, 0)
+	var archive bytes.Buffer
+	if err := filesystem.ToTar(src, "/", &archive, filesystem.ToTarOptions{ModTime: epoch}); err != nil {
+		t.Fatalf("error writing archive: %v", err)
+	}
+
+	// sorted entries, with the contents and times of the files
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if !hdr.ModTime.Equal(epoch) || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("%s: time %v, owner %q:%q", hdr.Name, hdr.ModTime, hdr.Uname, hdr.Gname)
+		}
+		b, err := io.ReadAll(tr)
+		if content := fstest.Tree[hdr.Name]; err != nil || string(b) != content {
+			t.Errorf("%s: read %d bytes, %v instead of %d", hdr.Name, len(b), err, len(content))
+		}
+	}
+	expected := []string{"DIR1/", "DIR1/FILE1.TXT", "DIR1/SUB/", "DIR1/SUB/DEEP.BIN", "DIR1/SUB/SUBSUB/", "DIR1/SUB/SUBSUB/LEAF.X",
+		"DIR2/", "DIR3/", "DIR3/LARGE.BIN", "EMPTY.TXT", "README.TXT"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("entries %v instead of %v", names, expected)
+	}
+
+	// the same archive every time
+	var again bytes.Buffer
+	if err := filesystem.ToTar(src, "/", &again, filesystem.ToTarOptions{ModTime: epoch}); err != nil {
+		t.Fatalf("error writing archive: %v", err)
+	}
+	if !bytes.Equal(archive.Bytes(), again.Bytes()) {
+		t.Errorf("archives of the same tree differ")
+	}
+
+	// the archive of a subtree imported elsewhere
+	var sub bytes.Buffer
+	if err := filesystem.ToTar(src, "DIR1/SUB", &sub, filesystem.ToTarOptions{}); err != nil {
+		t.Fatalf("error writing archive: %v", err)
+	}
+	dst := createFat32(t)
+	if err := filesystem.FromTar(dst, &sub, filesystem.FromTarOptions{}); err != nil {
+		t.Fatalf("error importing archive: %v", err)
+	}
+	if err := fstest.TestFS(dst, "DEEP.BIN", "SUBSUB/LEAF.X"); err != nil {
+		t.Fatal(err)
+	}
+
+	// symbolic links
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sq, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := sq.Symlink("usr/bin", "/bin"); err != nil {
+		t.Fatalf("error creating symbolic link: %v", err)
+	}
+	if err := sq.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	var links bytes.Buffer
+	if err := filesystem.ToTar(read, "/", &links, filesystem.ToTarOptions{}); err != nil {
+		t.Fatalf("error writing archive: %v", err)
+	}
+	hdr, err := tar.NewReader(&links).Next()
+	if err != nil || hdr.Name != "bin" || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "usr/bin" {
+		t.Errorf("entry %+v, %v instead of symbolic link to usr/bin", hdr, err)
+	}
+}
@@ -0,0 +1,102 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+func TestTruncate(t *testing.T) {
+	workspace := func(t *testing.T, create func(b *mem.Storage) (filesystem.FileSystem, error)) filesystem.FileSystem {
+		b, err := mem.Create(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := create(b)
+		if err != nil {
+			t.Fatalf("error creating filesystem: %v", err)
+		}
+		return f
+	}
+	tests := []struct {
+		name string
+		fs   func(t *testing.T) filesystem.FileSystem
+	}{
+		{"fat32", createFat32},
+		{"squashfs workspace", func(t *testing.T) filesystem.FileSystem {
+			return workspace(t, func(b *mem.Storage) (filesystem.FileSystem, error) { return squashfs.Create(b, size, 0, 4096) })
+		}},
+		{"iso9660 workspace", func(t *testing.T) filesystem.FileSystem {
+			return workspace(t, func(b *mem.Storage) (filesystem.FileSystem, error) { return iso9660.Create(b, size, 0, 2048, "") })
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.fs(t)
+			file, err := f.OpenFile("/FILE.BIN", os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("error creating file: %v", err)
+			}
+			defer file.Close()
+			if _, err := file.Write(bytes.Repeat([]byte{'a'}, 10000)); err != nil {
+				t.Fatalf("error writing file: %v", err)
+			}
+			if err := file.Truncate(100); err != nil {
+				t.Fatalf("error shrinking file: %v", err)
+			}
+			// grown again over the contents cut off
+			if err := file.Truncate(5000); err != nil {
+				t.Fatalf("error growing file: %v", err)
+			}
+			expected := append(bytes.Repeat([]byte{'a'}, 100), make([]byte, 4900)...)
+			b := make([]byte, 6000)
+			n, err := file.ReadAt(b, 0)
+			if n != len(expected) || (err != nil && err != io.EOF) || !bytes.Equal(b[:n], expected) {
+				t.Errorf("read %d bytes, %v instead of %d bytes ending with zeroes", n, err, len(expected))
+			}
+			if info, err := f.Stat("/FILE.BIN"); err != nil || info.Size() != int64(len(expected)) {
+				t.Errorf("described as %v, %v instead of %d bytes", info, err, len(expected))
+			}
+			// the offset is where the write left it
+			if offset, err := file.Seek(0, io.SeekCurrent); err != nil || offset != 10000 {
+				t.Errorf("offset %d, %v instead of 10000", offset, err)
+			}
+		})
+	}
+
+	t.Run("fat32 frees clusters", func(t *testing.T) {
+		f := createFat32(t)
+		file, err := f.OpenFile("/FILE.BIN", os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating file: %v", err)
+		}
+		if _, err := file.Write(make([]byte, 100000)); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+		if err := file.Truncate(10); err != nil {
+			t.Fatalf("error shrinking file: %v", err)
+		}
+		findings, err := filesystem.Check(f, filesystem.CheckOptions{})
+		if err != nil || len(findings) != 0 {
+			t.Errorf("findings %v, %v after shrinking a file", findings, err)
+		}
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		f := readFilesystems[1].fs(t)
+		file, err := f.OpenFile("/README.TXT", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening file: %v", err)
+		}
+		if err := file.Truncate(0); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+			t.Errorf("error %v truncating squashfs file instead of %v", err, filesystem.ErrReadOnlyFilesystem)
+		}
+	})
+}
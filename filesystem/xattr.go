@@ -0,0 +1,55 @@
+package filesystem
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Xattrs returns the extended attributes of the named file, by name, with the XattrFS of the
+// filesystem, or nil if the filesystem has none. Symbolic links are not followed.
+func Xattrs(f FileSystem, name string) (map[string][]byte, error) {
+	x, ok := f.(XattrFS)
+	if !ok {
+		return nil, nil
+	}
+	attrs, err := x.Listxattr(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	values := make(map[string][]byte, len(attrs))
+	for _, attr := range attrs {
+		value, err := x.Getxattr(name, attr)
+		if err != nil {
+			return nil, err
+		}
+		values[attr] = value
+	}
+	return values, nil
+}
+
+// SetXattrs sets the extended attributes of the named file, in the order of their names, with the
+// XattrFS of the filesystem. Those the file already has but not in values are kept. It returns
+// ErrNotSupported if there are attributes to set and the filesystem is not an XattrFS.
+func SetXattrs(f FileSystem, name string, values map[string][]byte) error {
+	if len(values) == 0 {
+		return nil
+	}
+	x, ok := f.(XattrFS)
+	if !ok {
+		return fmt.Errorf("extended attributes of %s: %w", name, ErrNotSupported)
+	}
+	attrs := make([]string, 0, len(values))
+	for attr := range values {
+		attrs = append(attrs, attr)
+	}
+	slices.Sort(attrs)
+	for _, attr := range attrs {
+		if err := x.Setxattr(name, attr, values[attr]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
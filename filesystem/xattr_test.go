@@ -0,0 +1,103 @@
+package filesystem_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// createSquashfs returns the workspace of a squashfs filesystem and its backend
+func createSquashfs(t *testing.T) (*squashfs.FileSystem, *mem.Storage) {
+	t.Helper()
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	return f, b
+}
+
+func TestXattrs(t *testing.T) {
+	src, b := createSquashfs(t)
+	file, err := src.OpenFile("/HELLO.TXT", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := file.Write([]byte("hello")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	_ = file.Close()
+	xattrs := map[string][]byte{"user.comment": []byte("greeting"), "user.lang": []byte("en")}
+	if err := filesystem.SetXattrs(src, "/HELLO.TXT", xattrs); errors.Is(err, filesystem.ErrNotSupported) {
+		t.Skipf("no extended attributes in the workspace: %v", err)
+	} else if err != nil {
+		t.Fatalf("error setting extended attributes: %v", err)
+	}
+	if err := src.Removexattr("/HELLO.TXT", "user.none"); !errors.Is(err, filesystem.ErrNoXattr) {
+		t.Errorf("error %v removing a missing attribute instead of %v", err, filesystem.ErrNoXattr)
+	}
+	if err := src.Finalize(squashfs.FinalizeOptions{Xattrs: true}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if attrs, err := read.Listxattr("/HELLO.TXT"); err != nil || !slices.Equal(attrs, []string{"user.comment", "user.lang"}) {
+		t.Errorf("extended attributes %v, %v", attrs, err)
+	}
+	if _, err := read.Getxattr("/HELLO.TXT", "user.none"); !errors.Is(err, filesystem.ErrNoXattr) {
+		t.Errorf("error %v reading a missing attribute instead of %v", err, filesystem.ErrNoXattr)
+	}
+	if err := read.Setxattr("/HELLO.TXT", "user.lang", nil); !errors.Is(err, filesystem.ErrReadOnlyFilesystem) {
+		t.Errorf("error %v setting an attribute of an image instead of %v", err, filesystem.ErrReadOnlyFilesystem)
+	}
+
+	t.Run("copy", func(t *testing.T) {
+		dst, _ := createSquashfs(t)
+		if err := filesystem.CopyTree(context.Background(), read, "/", dst, "/", filesystem.CopyOptions{}); err != nil {
+			t.Fatalf("error copying: %v", err)
+		}
+		for attr, value := range xattrs {
+			if b, err := dst.Getxattr("/HELLO.TXT", attr); err != nil || !bytes.Equal(b, value) {
+				t.Errorf("%s is %q, %v instead of %q", attr, b, err, value)
+			}
+		}
+		// FAT32 has none, so they are skipped
+		if err := filesystem.CopyTree(context.Background(), read, "/", createFat32(t), "/", filesystem.CopyOptions{}); err != nil {
+			t.Errorf("error copying to FAT32: %v", err)
+		}
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		var archive bytes.Buffer
+		if err := filesystem.ToTar(read, "/", &archive, filesystem.ToTarOptions{}); err != nil {
+			t.Fatalf("error writing archive: %v", err)
+		}
+		hdr, err := tar.NewReader(bytes.NewReader(archive.Bytes())).Next()
+		if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		if v := hdr.PAXRecords["SCHILY.xattr.user.comment"]; v != "greeting" {
+			t.Errorf("user.comment is %q in the archive", v)
+		}
+		dst, _ := createSquashfs(t)
+		if err := filesystem.FromTar(dst, bytes.NewReader(archive.Bytes()), filesystem.FromTarOptions{}); err != nil {
+			t.Fatalf("error importing archive: %v", err)
+		}
+		if got, err := filesystem.Xattrs(dst, "HELLO.TXT"); err != nil || len(got) != len(xattrs) {
+			t.Errorf("extended attributes %v, %v imported instead of %v", got, err, xattrs)
+		}
+	})
+}
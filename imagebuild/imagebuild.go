@@ -0,0 +1,523 @@
+// Package imagebuild builds complete disk images from a declarative spec in one call: the
+// partition table, with partitions of fixed sizes and one that fills the rest of the disk, a
+// filesystem in each partition populated from host trees and tar archives, or a raw image written
+// to it, and boot loader blobs written at fixed offsets of the disk, like genimage:
+//
+//	spec := &imagebuild.Spec{
+//		Partitions: []imagebuild.Partition{
+//			{Name: "EFI", Type: gpt.EFISystemPartition, Size: 256 * 1024 * 1024, FSType: "fat32", Label: "EFI", Trees: []string{"boot"}},
+//			{Name: "root", Fill: true, FSType: "ext4", Label: "root", Tars: []string{"rootfs.tar.gz"}},
+//		},
+//		Blobs: []imagebuild.Blob{{Path: "mbr.bin", Offset: 0}},
+//	}
+//	d, err := diskfs.Create("disk.img", 2*1024*1024*1024, diskfs.SectorSizeDefault)
+//	err = spec.Build(d)
+//
+// The spec is checked before anything is written, and the filesystems are made and populated
+// with the script package. Specs can be read from JSON, with the json names of their fields.
+package imagebuild
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/script"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// The types of partition tables of Spec.Table
+const (
+	TableGPT = "gpt"
+	TableMBR = "mbr"
+)
+
+// alignment is where partitions start, in bytes, as by default for fdisk and sgdisk
+const alignment = 1024 * 1024
+
+// gptEntriesSize is the size of the partition entry arrays of the GPT tables written, after the
+// primary header and before the secondary one
+const gptEntriesSize = 128 * 128
+
+// mbrBootCodeSize is the size of the boot code at the start of the MBR, which blobs may hold,
+// before the disk signature and partition entries
+const mbrBootCodeSize = 440
+
+// Spec describes a disk image
+type Spec struct {
+	// Size is the size of the image in bytes, which the disk must have at least; the layout is
+	// that of the whole disk if 0
+	Size int64 `json:"size,omitempty"`
+	// Table is the type of partition table, TableGPT by default or TableMBR
+	Table string `json:"table,omitempty"`
+	// Partitions are the partitions of the disk, in the order of their numbers and on the disk,
+	// each starting on a 1MiB boundary
+	Partitions []Partition `json:"partitions"`
+	// Blobs are written at offsets of the disk outside the partitions, before the partition table
+	Blobs []Blob `json:"blobs,omitempty"`
+}
+
+// Partition describes a partition of the disk and its contents
+type Partition struct {
+	// Name is the name of a GPT partition
+	Name string `json:"name,omitempty"`
+	// Type is the type of a GPT partition, gpt.LinuxFilesystem by default
+	Type gpt.Type `json:"type,omitempty"`
+	// MBRType is the type of an MBR partition, mbr.Linux by default
+	MBRType mbr.Type `json:"mbrType,omitempty"`
+	// GUID is the unique GUID of a GPT partition, random if not set
+	GUID string `json:"guid,omitempty"`
+	// Attributes are the attributes of a GPT partition, e.g. gpt.AttributeRequired
+	Attributes uint64 `json:"attributes,omitempty"`
+	// Bootable marks an MBR partition active
+	Bootable bool `json:"bootable,omitempty"`
+	// Size is the size of the partition in bytes, a multiple of the sector size, a number in JSON
+	// or a string such as "512M"
+	Size script.Size `json:"size,omitempty"`
+	// Fill makes the partition take the space left by the others, instead of Size; at most one
+	// partition fills
+	Fill bool `json:"fill,omitempty"`
+	// FSType is the filesystem made in the partition, fat32, ext4, squashfs or iso9660, or none if
+	// empty
+	FSType string `json:"fstype,omitempty"`
+	// Label is the volume label of the filesystem
+	Label string `json:"label,omitempty"`
+	// Tars are host tar archives, compressed with gzip or not, extracted into the root of the
+	// filesystem, in order, before the Trees
+	Tars []string `json:"tars,omitempty"`
+	// Trees are host directories whose contents are copied into the root of the filesystem, in
+	// order, over those of the Tars
+	Trees []string `json:"trees,omitempty"`
+	// Image is a host file written as the contents of a partition with no filesystem, e.g. a
+	// filesystem image made by another tool
+	Image string `json:"image,omitempty"`
+}
+
+// Blob is a host file written at an offset of the disk, such as the boot code of the MBR or a boot
+// loader that firmware loads from a fixed sector
+type Blob struct {
+	// Path is the host file
+	Path string `json:"path"`
+	// Offset is where it is written, in bytes from the start of the disk
+	Offset int64 `json:"offset"`
+}
+
+type buildOpts struct {
+	baseDir  string
+	progress util.Progress
+	check    bool
+}
+
+// BuildOpt is an option of Build
+type BuildOpt func(o *buildOpts) error
+
+// WithBaseDir sets the directory that the host paths of the spec are relative to, the current
+// directory by default
+func WithBaseDir(dir string) BuildOpt {
+	return func(o *buildOpts) error {
+		if dir == "" {
+			return errors.New("base directory must not be empty")
+		}
+		o.baseDir = dir
+		return nil
+	}
+}
+
+// WithProgress reports the progress of the build to p, in util.PhaseBuild as the blobs and
+// partition images are written, out of the size of all of them, and as the script package does as
+// the filesystems are populated and finalized
+func WithProgress(p util.Progress) BuildOpt {
+	return func(o *buildOpts) error {
+		o.progress = p
+		return nil
+	}
+}
+
+// WithCheck checks the filesystems once built with filesystem.Check, failing if any has a finding
+// of SeverityError
+func WithCheck() BuildOpt {
+	return func(o *buildOpts) error {
+		o.check = true
+		return nil
+	}
+}
+
+// Build builds the image on the disk, with BuildContext
+func (s *Spec) Build(d *disk.Disk, opts ...BuildOpt) error {
+	return s.BuildContext(context.Background(), d, opts...)
+}
+
+// BuildContext builds the image on the disk: it checks the spec, the layout of its partitions on
+// the disk and its host files, writes the blobs, the partition table and the partition images,
+// then makes and populates the filesystems, and syncs the disk. Nothing is written if the spec is
+// invalid. It stops with the error of ctx when it is done.
+func (s *Spec) BuildContext(ctx context.Context, d *disk.Disk, opts ...BuildOpt) error {
+	o := &buildOpts{baseDir: "."}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return err
+		}
+	}
+	b := &builder{ctx: ctx, spec: s, disk: d, opts: o}
+	table, err := b.layout()
+	if err != nil {
+		return err
+	}
+	populate, err := b.script()
+	if err != nil {
+		return err
+	}
+	if err := b.checkFiles(); err != nil {
+		return err
+	}
+
+	for _, blob := range s.Blobs {
+		if err := b.writeBlob(blob); err != nil {
+			return err
+		}
+	}
+	if err := d.Partition(table); err != nil {
+		return err
+	}
+	for i, p := range s.Partitions {
+		if p.Image != "" {
+			if err := b.writeImage(i+1, p.Image); err != nil {
+				return err
+			}
+		}
+	}
+	scriptOpts := []script.RunOpt{script.WithBaseDir(o.baseDir)}
+	if o.progress != nil {
+		scriptOpts = append(scriptOpts, script.WithProgress(o.progress))
+	}
+	if err := populate.RunContext(ctx, d, scriptOpts...); err != nil {
+		return fmt.Errorf("could not populate filesystems: %w", err)
+	}
+	if o.check {
+		if err := b.check(); err != nil {
+			return err
+		}
+	}
+	return d.Sync()
+}
+
+// builder holds the state of a build
+type builder struct {
+	ctx  context.Context
+	spec *Spec
+	disk *disk.Disk
+	opts *buildOpts
+	// done and total are the bytes of blobs and images written so far and to write
+	done, total int64
+}
+
+// extent is a range of sectors of the disk
+type extent struct {
+	start, end int64
+}
+
+// layout returns the partition table of the spec on the disk, or an error if it is invalid or
+// does not fit
+func (b *builder) layout() (partition.Table, error) {
+	s, d := b.spec, b.disk
+	sector := d.LogicalBlocksize
+	size := d.Size
+	if s.Size != 0 {
+		if s.Size > d.Size {
+			return nil, fmt.Errorf("image of %d bytes does not fit on disk of %d bytes", s.Size, d.Size)
+		}
+		size = s.Size
+	}
+	align := int64(alignment) / sector
+	var last int64
+	switch s.Table {
+	case "", TableGPT:
+		last = size/sector - 2 - gptEntriesSize/sector
+	case TableMBR:
+		if len(s.Partitions) > 4 {
+			return nil, fmt.Errorf("an MBR holds 4 primary partitions, not %d", len(s.Partitions))
+		}
+		last = min(size/sector-1, int64(^uint32(0)))
+	default:
+		return nil, fmt.Errorf("unknown partition table type %q", s.Table)
+	}
+
+	fill := -1
+	for i, p := range s.Partitions {
+		switch {
+		case p.Fill && fill >= 0:
+			return nil, fmt.Errorf("partitions %d and %d both fill the disk", fill+1, i+1)
+		case p.Fill && p.Size != 0:
+			return nil, fmt.Errorf("partition %d has a size and fills the disk", i+1)
+		case p.Fill:
+			fill = i
+		case p.Size <= 0:
+			return nil, fmt.Errorf("partition %d has no size", i+1)
+		case int64(p.Size)%sector != 0:
+			return nil, fmt.Errorf("size %d of partition %d is not a multiple of the sector size %d", p.Size, i+1, sector)
+		}
+	}
+	// the partitions before the one that fills are laid out from the start of the disk, and
+	// those after it from the end
+	extents := make([]extent, len(s.Partitions))
+	next := align
+	for i := 0; i < len(s.Partitions) && i != fill; i++ {
+		extents[i] = extent{next, next + int64(s.Partitions[i].Size)/sector - 1}
+		next = (extents[i].end + align) / align * align
+	}
+	if fill >= 0 {
+		end := last
+		for i := len(s.Partitions) - 1; i > fill; i-- {
+			start := (end - int64(s.Partitions[i].Size)/sector + 1) / align * align
+			extents[i] = extent{start, start + int64(s.Partitions[i].Size)/sector - 1}
+			end = start - 1
+		}
+		extents[fill] = extent{next, end}
+	}
+	for i, e := range extents {
+		if e.start < align || e.end < e.start || e.end > last || (i > 0 && e.start <= extents[i-1].end) {
+			return nil, fmt.Errorf("partitions do not fit in the %d bytes of the disk", size)
+		}
+	}
+
+	reserved := []extent{{mbrBootCodeSize, sector - 1}}
+	if s.Table != TableMBR {
+		reserved[0].end = (2+gptEntriesSize/sector)*sector - 1
+		reserved = append(reserved, extent{(last + 1) * sector, size - 1})
+	}
+	for _, e := range extents {
+		reserved = append(reserved, extent{e.start * sector, (e.end+1)*sector - 1})
+	}
+	if err := b.checkBlobs(size, reserved); err != nil {
+		return nil, err
+	}
+
+	if s.Table == TableMBR {
+		t := &mbr.Table{LogicalSectorSize: int(sector), PhysicalSectorSize: int(d.PhysicalBlocksize)}
+		for i, p := range s.Partitions {
+			if p.Name != "" || p.Type != "" || p.GUID != "" || p.Attributes != 0 {
+				return nil, fmt.Errorf("partition %d has a name, type, GUID or attributes of GPT", i+1)
+			}
+			t.Partitions = append(t.Partitions, &mbr.Partition{
+				Type:     cmp.Or(p.MBRType, mbr.Linux),
+				Start:    uint32(extents[i].start),
+				Size:     uint32(extents[i].end - extents[i].start + 1),
+				Bootable: p.Bootable,
+			})
+		}
+		return t, nil
+	}
+	t := &gpt.Table{LogicalSectorSize: int(sector), PhysicalSectorSize: int(d.PhysicalBlocksize), ProtectiveMBR: true}
+	for i, p := range s.Partitions {
+		if p.MBRType != mbr.Empty || p.Bootable {
+			return nil, fmt.Errorf("partition %d has a type or bootable flag of MBR", i+1)
+		}
+		t.Partitions = append(t.Partitions, &gpt.Partition{
+			Start:      uint64(extents[i].start),
+			End:        uint64(extents[i].end),
+			Size:       uint64(extents[i].end-extents[i].start+1) * uint64(sector),
+			Type:       cmp.Or(p.Type, gpt.LinuxFilesystem),
+			Name:       p.Name,
+			GUID:       p.GUID,
+			Attributes: p.Attributes,
+		})
+	}
+	return t, nil
+}
+
+// checkBlobs checks that the blobs are within the disk, and do not overlap each other, the
+// partition table or the partitions, the reserved ranges of bytes
+func (b *builder) checkBlobs(size int64, reserved []extent) error {
+	var blobs []extent
+	for i, blob := range b.spec.Blobs {
+		info, err := os.Stat(b.hostPath(blob.Path))
+		if err != nil {
+			return fmt.Errorf("blob %d: %w", i+1, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("blob %d: %s is not a regular file", i+1, blob.Path)
+		}
+		e := extent{blob.Offset, blob.Offset + info.Size() - 1}
+		if blob.Offset < 0 || e.end >= size {
+			return fmt.Errorf("blob %d of %d bytes at offset %d is not within the disk", i+1, info.Size(), blob.Offset)
+		}
+		for _, r := range append(reserved, blobs...) {
+			if e.start <= r.end && r.start <= e.end {
+				return fmt.Errorf("blob %d at bytes %d to %d overlaps the partition table, a partition or another blob at bytes %d to %d", i+1, e.start, e.end, r.start, r.end)
+			}
+		}
+		blobs = append(blobs, e)
+		b.total += info.Size()
+	}
+	return nil
+}
+
+// script returns the script making and populating the filesystems, validated
+func (b *builder) script() (*script.Script, error) {
+	s := &script.Script{}
+	for i, p := range b.spec.Partitions {
+		n := i + 1
+		if p.Image != "" {
+			if p.FSType != "" || p.Label != "" || len(p.Tars) > 0 || len(p.Trees) > 0 {
+				return nil, fmt.Errorf("partition %d has an image and a filesystem", n)
+			}
+			continue
+		}
+		if p.FSType == "" {
+			if p.Label != "" || len(p.Tars) > 0 || len(p.Trees) > 0 {
+				return nil, fmt.Errorf("partition %d has a label or files but no filesystem", n)
+			}
+			continue
+		}
+		s.Operations = append(s.Operations, script.Operation{Op: script.OpMkfs, Partition: n, FSType: p.FSType, Label: p.Label})
+		for _, tar := range p.Tars {
+			s.Operations = append(s.Operations, script.Operation{Op: script.OpTarIn, Partition: n, Source: tar})
+		}
+		for _, tree := range p.Trees {
+			s.Operations = append(s.Operations, script.Operation{Op: script.OpCopyIn, Partition: n, Source: tree, Path: "/"})
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkFiles checks that the host trees, archives and images exist, and that the images fit in
+// their partitions
+func (b *builder) checkFiles() error {
+	for i, p := range b.spec.Partitions {
+		for _, tree := range p.Trees {
+			if info, err := os.Stat(b.hostPath(tree)); err != nil || !info.IsDir() {
+				return fmt.Errorf("tree %s of partition %d is not a directory", tree, i+1)
+			}
+		}
+		for _, tar := range p.Tars {
+			if info, err := os.Stat(b.hostPath(tar)); err != nil || !info.Mode().IsRegular() {
+				return fmt.Errorf("archive %s of partition %d is not a file", tar, i+1)
+			}
+		}
+		if p.Image == "" {
+			continue
+		}
+		info, err := os.Stat(b.hostPath(p.Image))
+		if err != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("image %s of partition %d is not a file", p.Image, i+1)
+		}
+		if p.Fill {
+			// the size of the partition that fills is only known from the table
+			b.total += info.Size()
+			continue
+		}
+		if info.Size() > int64(p.Size) {
+			return fmt.Errorf("image %s of %d bytes does not fit in partition %d of %d bytes", p.Image, info.Size(), i+1, p.Size)
+		}
+		b.total += info.Size()
+	}
+	return nil
+}
+
+// hostPath returns the host path p relative to the base directory
+func (b *builder) hostPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(b.opts.baseDir, p)
+}
+
+// reader returns a reader of the host file f at p, reading it until the context of the build is done, and
+// reporting the bytes read to the progress
+func (b *builder) reader(f io.Reader, p string) io.Reader {
+	r := util.ContextReader(b.ctx, f)
+	if b.opts.progress == nil {
+		return r
+	}
+	return &progressReader{r: r, builder: b, path: p}
+}
+
+// progressReader reports the bytes of blobs and images read to the progress of the build
+type progressReader struct {
+	r       io.Reader
+	builder *builder
+	path    string
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.builder.done += int64(n)
+		p.builder.opts.progress.Update(util.PhaseBuild, p.builder.done, p.builder.total, p.path)
+	}
+	return n, err
+}
+
+// writeBlob writes the blob at its offset of the disk
+func (b *builder) writeBlob(blob Blob) error {
+	if err := b.writeAt(blob.Path, blob.Offset); err != nil {
+		return fmt.Errorf("could not write blob %s at offset %d: %w", blob.Path, blob.Offset, err)
+	}
+	return nil
+}
+
+// writeImage writes the host image file at the start of the partition, leaving the rest of the
+// partition as it is if the image is smaller
+func (b *builder) writeImage(part int, p string) error {
+	info, err := os.Stat(b.hostPath(p))
+	if err != nil {
+		return err
+	}
+	partition := b.disk.Table.GetPartitions()[part-1]
+	if size := partition.GetSize(); info.Size() > size {
+		return fmt.Errorf("image %s of %d bytes does not fit in partition %d of %d bytes", p, info.Size(), part, size)
+	}
+	if err := b.writeAt(p, partition.GetStart()); err != nil {
+		return fmt.Errorf("could not write image %s to partition %d: %w", p, part, err)
+	}
+	return nil
+}
+
+// writeAt copies the host file at p to the disk at offset
+func (b *builder) writeAt(p string, offset int64) error {
+	f, err := os.Open(b.hostPath(p))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := b.disk.Backend.Writable()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.NewOffsetWriter(w, offset), b.reader(f, p))
+	return err
+}
+
+// check checks the filesystems built
+func (b *builder) check() error {
+	for i, p := range b.spec.Partitions {
+		if p.FSType == "" {
+			continue
+		}
+		f, err := b.disk.GetFilesystem(i + 1)
+		if err != nil {
+			return fmt.Errorf("could not read filesystem of partition %d: %w", i+1, err)
+		}
+		findings, err := filesystem.Check(f, filesystem.CheckOptions{})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("could not check filesystem of partition %d: %w", i+1, err)
+		}
+		if severity, _ := filesystem.MaxSeverity(findings); severity >= filesystem.SeverityError {
+			return fmt.Errorf("filesystem of partition %d is inconsistent: %v", i+1, findings)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package imagebuild_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/imagebuild"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func newDisk(b []byte) *disk.Disk {
+	size := int64(len(b))
+	return &disk.Disk{
+		Backend:           mem.New(b, false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "boot", "EFI", "BOOT"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"boot/EFI/BOOT/BOOTX64.EFI": []byte("loader"),
+		"bootcode.bin":              bytes.Repeat([]byte{0xeb}, 440),
+		"data.img":                  bytes.Repeat([]byte("data"), 1024),
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var spec imagebuild.Spec
+	if err := json.Unmarshal([]byte(`{
+		"partitions": [
+			{"name": "EFI", "type": "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", "size": "16M", "fstype": "fat32", "label": "EFI", "trees": ["boot"]},
+			{"name": "data", "fill": true, "image": "data.img"},
+			{"name": "spare", "size": "4M"}
+		],
+		"blobs": [{"path": "bootcode.bin", "offset": 0}]
+	}`), &spec); err != nil {
+		t.Fatalf("error decoding spec: %v", err)
+	}
+	size := int64(64 * 1024 * 1024)
+	b := make([]byte, size)
+	d := newDisk(b)
+	var done, total int64
+	progress := util.ProgressFunc(func(phase string, d, t int64, _ string) {
+		if phase == util.PhaseBuild {
+			done, total = d, t
+		}
+	})
+	if err := spec.Build(d, imagebuild.WithBaseDir(dir), imagebuild.WithProgress(progress), imagebuild.WithCheck()); err != nil {
+		t.Fatalf("error building image: %v", err)
+	}
+	if expected := int64(440 + 4096); done != expected || total != expected {
+		t.Errorf("progress of %d out of %d bytes instead of %d", done, total, expected)
+	}
+
+	table, ok := d.Table.(*gpt.Table)
+	if !ok || len(table.Partitions) != 3 {
+		t.Fatalf("partition table %v instead of GPT with 3 partitions", d.Table)
+	}
+	// the last partition is at the end of the disk, before the secondary GPT, and the one that
+	// fills is between the others
+	spare := table.Partitions[2]
+	if spare.Start%2048 != 0 || spare.Size != 4*1024*1024 || spare.End+1+33 > uint64(size/512) {
+		t.Errorf("last partition %+v", spare)
+	}
+	if p := table.Partitions[1]; p.Start != 2048+32768 || p.End+1 != spare.Start {
+		t.Errorf("partition that fills %+v", p)
+	}
+	if p := table.Partitions[0]; p.Type != gpt.EFISystemPartition || p.Name != "EFI" || p.Start != 2048 {
+		t.Errorf("first partition %+v", p)
+	}
+
+	if !bytes.Equal(b[:440], files["bootcode.bin"]) {
+		t.Error("boot code of the MBR not written")
+	}
+	if start := int(table.Partitions[1].Start) * 512; !bytes.Equal(b[start:start+4096], files["data.img"]) {
+		t.Error("image of the second partition not written")
+	}
+	esp, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading FAT32 filesystem: %v", err)
+	}
+	if b, err := esp.ReadFile("EFI/BOOT/BOOTX64.EFI"); err != nil || string(b) != "loader" {
+		t.Errorf("contents %q, %v of the boot loader", b, err)
+	}
+}
+
+func TestBuildMBR(t *testing.T) {
+	spec := &imagebuild.Spec{
+		Table: imagebuild.TableMBR,
+		Partitions: []imagebuild.Partition{
+			{MBRType: mbr.Fat32LBA, Size: 8 * 1024 * 1024, Bootable: true, FSType: "fat32", Label: "BOOT"},
+			{Fill: true},
+		},
+	}
+	d := newDisk(make([]byte, 32*1024*1024))
+	if err := spec.Build(d); err != nil {
+		t.Fatalf("error building image: %v", err)
+	}
+	table, ok := d.Table.(*mbr.Table)
+	if !ok || len(table.Partitions) != 2 {
+		t.Fatalf("partition table %v instead of MBR with 2 partitions", d.Table)
+	}
+	if p := table.Partitions[0]; p.Type != mbr.Fat32LBA || !p.Bootable || p.Start != 2048 || p.Size != 16384 {
+		t.Errorf("first partition %+v", p)
+	}
+	if p := table.Partitions[1]; p.Type != mbr.Linux || p.Start != 2048+16384 || p.Start+p.Size != 65536 {
+		t.Errorf("second partition %+v", p)
+	}
+	if _, err := d.GetFilesystem(1); err != nil {
+		t.Errorf("error reading FAT32 filesystem: %v", err)
+	}
+}
+
+func TestBuildInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "blob"), make([]byte, 1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		spec imagebuild.Spec
+		err  string
+	}{
+		{"two fill", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true}, {Fill: true}}}, "partitions 1 and 2 both fill the disk"},
+		{"no size", imagebuild.Spec{Partitions: []imagebuild.Partition{{Name: "a"}}}, "partition 1 has no size"},
+		{"too large", imagebuild.Spec{Partitions: []imagebuild.Partition{{Size: 64 * 1024 * 1024}}}, "do not fit"},
+		{"table", imagebuild.Spec{Table: "apm"}, `unknown partition table type "apm"`},
+		{"mbr partitions", imagebuild.Spec{Table: imagebuild.TableMBR, Partitions: make([]imagebuild.Partition, 5)}, "4 primary partitions"},
+		{"gpt type on mbr", imagebuild.Spec{Table: imagebuild.TableMBR, Partitions: []imagebuild.Partition{{Fill: true, Type: gpt.LinuxFilesystem}}}, "GPT"},
+		{"filesystem type", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true, FSType: "ntfs"}}}, `unknown filesystem type "ntfs"`},
+		{"image and filesystem", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true, FSType: "fat32", Image: "blob"}}}, "has an image and a filesystem"},
+		{"files without filesystem", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true, Trees: []string{"."}}}}, "no filesystem"},
+		{"missing tree", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true, FSType: "fat32", Trees: []string{"missing"}}}}, "tree missing of partition 1 is not a directory"},
+		{"image too large", imagebuild.Spec{Partitions: []imagebuild.Partition{{Size: 512, Image: "blob"}}}, "does not fit in partition 1"},
+		{"blob over table", imagebuild.Spec{Blobs: []imagebuild.Blob{{Path: "blob", Offset: 0}}}, "overlaps"},
+		{"blob over partition", imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true}}, Blobs: []imagebuild.Blob{{Path: "blob", Offset: 1024*1024 - 512}}}, "overlaps"},
+		{"blob outside disk", imagebuild.Spec{Blobs: []imagebuild.Blob{{Path: "blob", Offset: 16 * 1024 * 1024}}}, "not within the disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 16*1024*1024)
+			err := tt.spec.Build(newDisk(b), imagebuild.WithBaseDir(dir))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error %v instead of %q", err, tt.err)
+			}
+			// nothing is written for an invalid spec
+			if !bytes.Equal(b, make([]byte, len(b))) {
+				t.Error("disk written despite the invalid spec")
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	spec := &imagebuild.Spec{Partitions: []imagebuild.Partition{{Fill: true, FSType: "fat32"}}}
+	if err := spec.BuildContext(ctx, newDisk(make([]byte, 16*1024*1024))); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of canceled", err)
+	}
+}
@@ -0,0 +1,487 @@
+// Package integrity creates and verifies standalone dm-integrity devices, whose sectors are
+// interleaved with a checksum or MAC of each, in the format of integritysetup, so that images with
+// authenticated storage can be built and checked without it:
+//
+//	dev, err := integrity.Create(device, size, data, integrity.WithAlgorithm("hmac(sha256)"), integrity.WithKey(key))
+//	fmt.Println(dev.Table("/dev/sda2"))
+//
+// The device starts with its superblock and journal, followed by areas of InterleaveSectors of
+// data, each after the tags of its sectors. The journal is written empty, so that the kernel
+// initializes it when the device is first opened, e.g. with
+//
+//	integritysetup open --integrity crc32c device.img name
+//
+// Verify and Open read the data in place: the journal of a device that was not cleanly closed is
+// not replayed. Devices with a separate metadata device, a journal MAC or a bitmap are not
+// supported.
+package integrity
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // integritysetup supports SHA1 tags
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/bits"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+const (
+	// DefaultAlgorithm is the default algorithm of the tags, that of integritysetup
+	DefaultAlgorithm = "crc32c"
+	// DefaultSectorSize is the default size of the sectors with a tag each, that of integritysetup
+	DefaultSectorSize = 512
+	// DefaultInterleaveSectors is the default number of sectors of data of each area, that of the
+	// kernel
+	DefaultInterleaveSectors = 32768
+	// sectorSize is the unit of the sizes in the superblock
+	sectorSize = 512
+	// layout of the journal and metadata, as by the kernel
+	journalBlockSectors    = 8
+	journalSectorData      = sectorSize - 8
+	journalMACPerSector    = 8
+	journalEntryRoundup    = 8
+	metadataPaddingSectors = 8
+	maxJournalSectors      = 131072
+	journalSizeFactor      = 7
+)
+
+var (
+	// ErrNoSuperblock is returned by ReadSuperblock where the device has no dm-integrity superblock
+	ErrNoSuperblock = errors.New("no dm-integrity superblock")
+	// ErrCorrupted is returned by Verify, and reading the data of Open, where a tag does not match
+	ErrCorrupted = errors.New("integrity tag mismatch")
+)
+
+// algorithm is a hash algorithm of the tags, by its name in the kernel crypto API
+type algorithm struct {
+	size  int
+	keyed bool
+	new   func(key []byte) hash.Hash
+}
+
+var algorithms = map[string]algorithm{
+	"crc32c":       {4, false, func([]byte) hash.Hash { return crc32cHash{crc32.New(crc32.MakeTable(crc32.Castagnoli))} }},
+	"sha1":         {sha1.Size, false, func([]byte) hash.Hash { return sha1.New() }},
+	"sha256":       {sha256.Size, false, func([]byte) hash.Hash { return sha256.New() }},
+	"hmac(sha256)": {sha256.Size, true, func(key []byte) hash.Hash { return hmac.New(sha256.New, key) }},
+}
+
+// crc32cHash is crc32c with its sum little endian, as by the kernel
+type crc32cHash struct {
+	hash.Hash32
+}
+
+func (h crc32cHash) Sum(b []byte) []byte {
+	return binary.LittleEndian.AppendUint32(b, h.Sum32())
+}
+
+// Device is a standalone dm-integrity device, as described by its superblock
+type Device struct {
+	// Algorithm is the algorithm of the tags, e.g. "crc32c" or "hmac(sha256)", not recorded in the
+	// superblock
+	Algorithm string
+	// TagSize is the size in bytes of the tag of each sector
+	TagSize int
+	// SectorSize is the size in bytes of the sectors with a tag each
+	SectorSize int64
+	// InterleaveSectors is the number of 512-byte sectors of data of each area
+	InterleaveSectors int64
+	// JournalSections is the number of sections of the journal
+	JournalSections uint32
+	// DataSectors is the number of 512-byte sectors of data provided by the device
+	DataSectors int64
+	// Version and Flags are those of the superblock
+	Version int
+	Flags   uint32
+	// Salt is mixed into the tags with fixed HMAC, of keyed algorithms
+	Salt []byte
+	key  []byte
+}
+
+type opts struct {
+	algorithm   string
+	key         []byte
+	sectorSize  int64
+	interleave  int64
+	journalSize int64
+}
+
+// Opt func that process Create options
+type Opt func(o *opts) error
+
+// WithAlgorithm sets the algorithm of the tags, "crc32c", "sha1", "sha256" or "hmac(sha256)",
+// DefaultAlgorithm if not set
+func WithAlgorithm(name string) Opt {
+	return func(o *opts) error {
+		if _, ok := algorithms[name]; !ok {
+			return fmt.Errorf("unsupported integrity algorithm %s", name)
+		}
+		o.algorithm = name
+		return nil
+	}
+}
+
+// WithKey sets the key of keyed algorithms, e.g. "hmac(sha256)"
+func WithKey(key []byte) Opt {
+	return func(o *opts) error {
+		o.key = append([]byte{}, key...)
+		return nil
+	}
+}
+
+// WithSectorSize sets the size of the sectors with a tag each, a power of 2 from 512 to 4096,
+// DefaultSectorSize if not set
+func WithSectorSize(size int64) Opt {
+	return func(o *opts) error {
+		if size < sectorSize || size > 4096 || size&(size-1) != 0 {
+			return fmt.Errorf("invalid sector size %d, must be a power of 2 from 512 to 4096", size)
+		}
+		o.sectorSize = size
+		return nil
+	}
+}
+
+// WithInterleaveSectors sets the number of 512-byte sectors of data of each area, rounded down to
+// a power of 2 from 8 to 2^31, DefaultInterleaveSectors if not set
+func WithInterleaveSectors(n int64) Opt {
+	return func(o *opts) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid number of interleave sectors %d", n)
+		}
+		o.interleave = n
+		return nil
+	}
+}
+
+// WithJournalSize sets the size in bytes of the journal, rounded down to whole sections, by
+// default 1/128 of the device up to 64MiB as by the kernel
+func WithJournalSize(size int64) Opt {
+	return func(o *opts) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid journal size %d", size)
+		}
+		o.journalSize = size
+		return nil
+	}
+}
+
+// newOpts returns the options with their defaults
+func newOpts(options ...Opt) (*opts, error) {
+	o := &opts{algorithm: DefaultAlgorithm, sectorSize: DefaultSectorSize, interleave: DefaultInterleaveSectors}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// New returns the layout of a device of size bytes with the options, without writing it, e.g. to
+// know the size of the data it provides
+func New(size int64, options ...Opt) (*Device, error) {
+	o, err := newOpts(options...)
+	if err != nil {
+		return nil, err
+	}
+	log2Interleave := bits.Len64(uint64(o.interleave)) - 1
+	log2Interleave = min(max(log2Interleave, minLog2Interleave), maxLog2Interleave)
+	d := &Device{
+		Algorithm:         o.algorithm,
+		TagSize:           algorithms[o.algorithm].size,
+		SectorSize:        o.sectorSize,
+		InterleaveSectors: 1 << log2Interleave,
+		Version:           versionFixedPadding,
+		Flags:             flagFixedPadding,
+		key:               o.key,
+	}
+	if algorithms[d.Algorithm].keyed {
+		d.Version = versionFixedHMAC
+		d.Flags |= flagFixedHMAC
+		d.Salt = make([]byte, saltSize)
+		if _, err := util.Rand.Read(d.Salt); err != nil {
+			return nil, fmt.Errorf("could not generate salt: %w", err)
+		}
+	}
+	if err := d.checkAlgorithm(); err != nil {
+		return nil, err
+	}
+	deviceSectors := size / sectorSize
+	journalSectors := min(int64(maxJournalSectors), deviceSectors>>journalSizeFactor)
+	if o.journalSize > 0 {
+		journalSectors = o.journalSize / sectorSize
+	}
+	d.JournalSections = uint32(max(journalSectors/d.journalSectionSectors(), 1))
+
+	// the most data whose last sector fits on the device, as by the kernel
+	fits := func(n int64) bool {
+		return d.initialSectors()+metadataPaddingSectors < deviceSectors && d.dataSector(n-1) < deviceSectors
+	}
+	for bit := bits.Len64(uint64(deviceSectors)) - 1; bit >= 3; bit-- {
+		if n := d.DataSectors | 1<<bit; fits(n) {
+			d.DataSectors = n
+		}
+	}
+	if d.DataSectors == 0 {
+		return nil, fmt.Errorf("device of %d bytes is too small for a journal of %d sections", size, d.JournalSections)
+	}
+	return d, nil
+}
+
+// Create writes a device of size bytes with the options to device: its superblock, an empty
+// journal and the data read from data, up to the DataSize of the device and padded with zeros,
+// with their tags. A nil data writes zeros, as integritysetup format does.
+func Create(device io.WriterAt, size int64, data io.Reader, options ...Opt) (*Device, error) {
+	d, err := New(size, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Write(device, data); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Write writes the device to device, with the data read from data
+func (d *Device) Write(device io.WriterAt, data io.Reader) error {
+	if err := d.checkAlgorithm(); err != nil {
+		return err
+	}
+	if _, err := device.WriteAt(d.superblock(), 0); err != nil {
+		return fmt.Errorf("error writing superblock: %w", err)
+	}
+	zeros := make([]byte, 1024*1024)
+	for offset, end := int64(superblockSectors*sectorSize), d.initialSectors()*sectorSize; offset < end; offset += int64(len(zeros)) {
+		if _, err := device.WriteAt(zeros[:min(int64(len(zeros)), end-offset)], offset); err != nil {
+			return fmt.Errorf("error writing journal: %w", err)
+		}
+	}
+	if data == nil {
+		data = bytes.NewReader(nil)
+	}
+	h := d.newHash()
+	run := d.metadataRun()
+	eof := false
+	for sector := int64(0); sector < d.DataSectors; sector += d.InterleaveSectors {
+		buf := make([]byte, min(d.InterleaveSectors, d.DataSectors-sector)*sectorSize)
+		if !eof {
+			_, err := io.ReadFull(data, buf)
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				eof = true
+			case err != nil:
+				return fmt.Errorf("error reading data: %w", err)
+			}
+		}
+		tags := make([]byte, run*sectorSize)
+		for i := int64(0); i*d.SectorSize < int64(len(buf)); i++ {
+			copy(tags[i*int64(d.TagSize):], d.tag(h, sector+i*d.SectorSize/sectorSize, buf[i*d.SectorSize:(i+1)*d.SectorSize]))
+		}
+		start := d.areaStart(sector / d.InterleaveSectors)
+		if _, err := device.WriteAt(tags, start*sectorSize); err != nil {
+			return fmt.Errorf("error writing tags: %w", err)
+		}
+		if _, err := device.WriteAt(buf, (start+run)*sectorSize); err != nil {
+			return fmt.Errorf("error writing data: %w", err)
+		}
+	}
+	if !eof {
+		if n, _ := data.Read(make([]byte, 1)); n > 0 {
+			return fmt.Errorf("data is larger than the %d bytes of the device", d.DataSize())
+		}
+	}
+	return nil
+}
+
+// Verify checks the tags of all the sectors of the data of device, and returns an error that is
+// ErrCorrupted with the first sector that does not match
+func (d *Device) Verify(device io.ReaderAt) error {
+	if err := d.checkAlgorithm(); err != nil {
+		return err
+	}
+	h := d.newHash()
+	run := d.metadataRun()
+	for sector := int64(0); sector < d.DataSectors; sector += d.InterleaveSectors {
+		start := d.areaStart(sector / d.InterleaveSectors)
+		tags := make([]byte, run*sectorSize)
+		if _, err := device.ReadAt(tags, start*sectorSize); err != nil {
+			return fmt.Errorf("error reading tags: %w", err)
+		}
+		buf := make([]byte, min(d.InterleaveSectors, d.DataSectors-sector)*sectorSize)
+		if _, err := device.ReadAt(buf, (start+run)*sectorSize); err != nil {
+			return fmt.Errorf("error reading data: %w", err)
+		}
+		for i := int64(0); i*d.SectorSize < int64(len(buf)); i++ {
+			s := sector + i*d.SectorSize/sectorSize
+			if !bytes.Equal(tags[i*int64(d.TagSize):][:d.TagSize], d.tag(h, s, buf[i*d.SectorSize:(i+1)*d.SectorSize])) {
+				return fmt.Errorf("%w: sector %d", ErrCorrupted, s)
+			}
+		}
+	}
+	return nil
+}
+
+// Open returns a read-only backend of the data of device, whose reads fail with ErrCorrupted
+// where a tag does not match, as from the kernel device
+func (d *Device) Open(device io.ReaderAt) (backend.Storage, error) {
+	if err := d.checkAlgorithm(); err != nil {
+		return nil, err
+	}
+	return backend.FromReaderAt(&dataReader{d: d, r: device}, d.DataSize()), nil
+}
+
+// DataSize is the size in bytes of the data provided by the device
+func (d *Device) DataSize() int64 {
+	return d.DataSectors * sectorSize
+}
+
+// JournalOffset is the offset in bytes of the journal, after the superblock
+func (d *Device) JournalOffset() int64 {
+	return superblockSectors * sectorSize
+}
+
+// JournalSize is the size in bytes of the journal, of JournalSections sections
+func (d *Device) JournalSize() int64 {
+	return int64(d.JournalSections) * d.journalSectionSectors() * sectorSize
+}
+
+// Table returns the device mapper table of the device, e.g. for dmsetup create, with the key of
+// keyed algorithms in hex
+func (d *Device) Table(device string) string {
+	hashArg := "internal_hash:" + d.Algorithm
+	if algorithms[d.Algorithm].keyed {
+		hashArg += ":" + hex.EncodeToString(d.key)
+	}
+	args := []string{hashArg}
+	if d.SectorSize != sectorSize {
+		args = append(args, fmt.Sprintf("block_size:%d", d.SectorSize))
+	}
+	if d.Flags&flagFixedPadding != 0 {
+		args = append(args, "fix_padding")
+	}
+	if d.Flags&flagFixedHMAC != 0 {
+		args = append(args, "fix_hmac")
+	}
+	table := fmt.Sprintf("0 %d integrity %s 0 %d J %d", d.DataSectors, device, d.TagSize, len(args))
+	for _, arg := range args {
+		table += " " + arg
+	}
+	return table
+}
+
+// checkAlgorithm returns an error if the algorithm is not supported, or does not match the tag
+// size or key
+func (d *Device) checkAlgorithm() error {
+	a, ok := algorithms[d.Algorithm]
+	switch {
+	case !ok:
+		return fmt.Errorf("unsupported integrity algorithm %s", d.Algorithm)
+	case a.size != d.TagSize:
+		return fmt.Errorf("tags of %d bytes are not of algorithm %s, of %d bytes", d.TagSize, d.Algorithm, a.size)
+	case a.keyed && len(d.key) == 0:
+		return fmt.Errorf("integrity algorithm %s needs a key", d.Algorithm)
+	case !a.keyed && len(d.key) > 0:
+		return fmt.Errorf("integrity algorithm %s takes no key", d.Algorithm)
+	}
+	return nil
+}
+
+// newHash returns a hash of the algorithm of the device
+func (d *Device) newHash() hash.Hash {
+	return algorithms[d.Algorithm].new(d.key)
+}
+
+// tag returns the tag of the data of the sector at sector, a hash of the salt with fixed HMAC, the
+// sector number and the data
+func (d *Device) tag(h hash.Hash, sector int64, data []byte) []byte {
+	h.Reset()
+	if d.Flags&flagFixedHMAC != 0 {
+		h.Write(d.Salt)
+	}
+	_ = binary.Write(h, binary.LittleEndian, uint64(sector))
+	h.Write(data)
+	return h.Sum(nil)[:d.TagSize]
+}
+
+// journalSectionSectors is the number of 512-byte sectors of each section of the journal, of
+// entries of the tag and last bytes of each sector, and the sectors of their data
+func (d *Device) journalSectionSectors() int64 {
+	sectorsPerBlock := d.SectorSize / sectorSize
+	entrySize := (8 + 8*sectorsPerBlock + int64(d.TagSize) + journalEntryRoundup - 1) / journalEntryRoundup * journalEntryRoundup
+	space := int64(journalSectorData)
+	if d.Flags&flagJournalMAC != 0 {
+		space -= journalMACPerSector
+	}
+	entries := space / entrySize * journalBlockSectors
+	return entries*sectorsPerBlock + journalBlockSectors
+}
+
+// initialSectors is the number of 512-byte sectors of the superblock and journal
+func (d *Device) initialSectors() int64 {
+	return superblockSectors + int64(d.JournalSections)*d.journalSectionSectors()
+}
+
+// metadataRun is the number of 512-byte sectors of the tags of each area, padded to 4KiB with
+// fixed padding, and 128KiB by older kernels
+func (d *Device) metadataRun() int64 {
+	padding := int64(sectorSize << metadataPaddingSectors)
+	if d.Flags&flagFixedPadding != 0 {
+		padding = metadataPaddingSectors * sectorSize
+	}
+	size := int64(d.TagSize) * (d.InterleaveSectors * sectorSize / d.SectorSize)
+	return (size + padding - 1) / padding * padding / sectorSize
+}
+
+// areaStart is the 512-byte sector of the device where the tags of the area start, followed by
+// its data
+func (d *Device) areaStart(area int64) int64 {
+	return d.initialSectors() + area*(d.InterleaveSectors+d.metadataRun())
+}
+
+// dataSector is the 512-byte sector of the device of the sector of data
+func (d *Device) dataSector(sector int64) int64 {
+	return d.areaStart(sector/d.InterleaveSectors) + d.metadataRun() + sector%d.InterleaveSectors
+}
+
+// dataReader reads the data of a device, checking the tag of each sector read
+type dataReader struct {
+	d *Device
+	r io.ReaderAt
+}
+
+func (dr *dataReader) ReadAt(p []byte, off int64) (int, error) {
+	d := dr.d
+	h := d.newHash()
+	n := 0
+	buf := make([]byte, d.SectorSize)
+	stored := make([]byte, d.TagSize)
+	for n < len(p) && off < d.DataSize() {
+		block := off / d.SectorSize
+		sector := block * d.SectorSize / sectorSize
+		if _, err := dr.r.ReadAt(buf, d.dataSector(sector)*sectorSize); err != nil {
+			return n, fmt.Errorf("error reading sector %d: %w", sector, err)
+		}
+		tagOffset := d.areaStart(sector/d.InterleaveSectors)*sectorSize + sector%d.InterleaveSectors*sectorSize/d.SectorSize*int64(d.TagSize)
+		if _, err := dr.r.ReadAt(stored, tagOffset); err != nil {
+			return n, fmt.Errorf("error reading tag of sector %d: %w", sector, err)
+		}
+		if !bytes.Equal(stored, d.tag(h, sector, buf)) {
+			return n, fmt.Errorf("%w: sector %d", ErrCorrupted, sector)
+		}
+		copied := copy(p[n:], buf[off-block*d.SectorSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,173 @@
+package integrity_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/integrity"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+const size = 64 * 1024 * 1024
+
+func TestLayout(t *testing.T) {
+	// the layout of the kernel for a device of 131072 sectors: a journal of 5 sections of 176
+	// sectors after the superblock, areas of 256 sectors of tags and 32768 of data, and 30856
+	// sectors of data in the last area
+	d, err := integrity.New(size)
+	if err != nil {
+		t.Fatalf("error computing layout: %v", err)
+	}
+	if d.JournalSections != 5 || d.JournalSize() != 5*176*512 || d.DataSectors != 3*32768+30856 || d.TagSize != 4 || d.Version != 4 {
+		t.Errorf("layout %+v", d)
+	}
+	if table := d.Table("/dev/sda2"); table != "0 129160 integrity /dev/sda2 0 4 J 2 internal_hash:crc32c fix_padding" {
+		t.Errorf("table %s", table)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []integrity.Opt
+	}{
+		{"crc32c", nil},
+		{"sha256 of 4096-byte sectors", []integrity.Opt{integrity.WithAlgorithm("sha256"), integrity.WithSectorSize(4096)}},
+		{"hmac", []integrity.Opt{integrity.WithAlgorithm("hmac(sha256)"), integrity.WithKey([]byte("key")), integrity.WithInterleaveSectors(4096)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, 40*1024*1024)
+			rand.New(rand.NewSource(1)).Read(data)
+			b, err := mem.Create(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, err := b.Writable()
+			if err != nil {
+				t.Fatal(err)
+			}
+			created, err := integrity.Create(w, size, bytes.NewReader(data), tt.options...)
+			if err != nil {
+				t.Fatalf("error creating device: %v", err)
+			}
+			d, err := integrity.ReadSuperblock(b, tt.options...)
+			if err != nil {
+				t.Fatalf("error reading superblock: %v", err)
+			}
+			if d.DataSectors != created.DataSectors || d.JournalSections != created.JournalSections || d.SectorSize != created.SectorSize || !bytes.Equal(d.Salt, created.Salt) {
+				t.Errorf("read %+v instead of %+v", d, created)
+			}
+			if err := d.Verify(b); err != nil {
+				t.Errorf("error verifying device: %v", err)
+			}
+			s, err := d.Open(b)
+			if err != nil {
+				t.Fatalf("error opening device: %v", err)
+			}
+			read := make([]byte, len(data))
+			if _, err := s.ReadAt(read, 0); err != nil || !bytes.Equal(read, data) {
+				t.Errorf("read different data: %v", err)
+			}
+			// the rest is zeros
+			rest, err := io.ReadAll(io.NewSectionReader(s, int64(len(data)), d.DataSize()))
+			if err != nil || int64(len(rest)) != d.DataSize()-int64(len(data)) || !bytes.Equal(rest, make([]byte, len(rest))) {
+				t.Errorf("read %d bytes after the data, %v", len(rest), err)
+			}
+
+			// the data is not interleaved where it was written
+			if bytes.Contains(data[:1024*1024], make([]byte, 8)) {
+				t.Fatal("random data with zeros")
+			}
+			raw := make([]byte, size)
+			if _, err := b.ReadAt(raw, 0); err != nil {
+				t.Fatal(err)
+			}
+			i := bytes.Index(raw, data[5*1024*1024:][:4096])
+			if i < 0 {
+				t.Fatalf("data not found on the device")
+			}
+			raw[i+100] ^= 1
+			if _, err := b.WriteAt(raw[i:i+4096], int64(i)); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Verify(b); !errors.Is(err, integrity.ErrCorrupted) {
+				t.Errorf("verified corrupted device: %v", err)
+			}
+			if _, err := s.ReadAt(read, 0); !errors.Is(err, integrity.ErrCorrupted) {
+				t.Errorf("read corrupted data: %v", err)
+			}
+		})
+	}
+}
+
+func TestPartition(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{Partitions: []*gpt.Partition{{Start: 2048, Size: 16 * 1024 * 1024, Type: gpt.LinuxFilesystem}}}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	if _, err := integrity.CreatePartition(d, 1, bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatalf("error creating device: %v", err)
+	}
+	if _, err := integrity.VerifyPartition(d, 1); err != nil {
+		t.Errorf("error verifying device: %v", err)
+	}
+	// the algorithm is not recorded, only the size of the tags
+	if _, err := integrity.VerifyPartition(d, 1, integrity.WithAlgorithm("sha256")); err == nil {
+		t.Errorf("verified tags of crc32c as sha256")
+	}
+	s, err := integrity.OpenPartition(d, 1)
+	if err != nil {
+		t.Fatalf("error opening device: %v", err)
+	}
+	read := make([]byte, 4)
+	if _, err := s.ReadAt(read, 0); err != nil || string(read) != "data" {
+		t.Errorf("read %q, %v", read, err)
+	}
+	if _, err := integrity.OpenPartition(d, 2); err == nil {
+		t.Errorf("opened partition 2")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := integrity.New(size, integrity.WithAlgorithm("hmac(sha256)")); err == nil {
+		t.Errorf("created keyed device without key")
+	}
+	if _, err := integrity.New(size, integrity.WithKey([]byte("key"))); err == nil {
+		t.Errorf("created crc32c device with key")
+	}
+	if _, err := integrity.New(64 * 1024); err == nil {
+		t.Errorf("created device of 64KiB")
+	}
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := integrity.ReadSuperblock(b); !errors.Is(err, integrity.ErrNoSuperblock) {
+		t.Errorf("read superblock of empty device: %v", err)
+	}
+	w, err := b.Writable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := integrity.New(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write(w, bytes.NewReader(make([]byte, d.DataSize()+1))); err == nil {
+		t.Errorf("wrote data larger than the device")
+	}
+}
@@ -0,0 +1,74 @@
+package integrity
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// CreatePartition writes a device over all of the partition of the disk, numbered from 1 as in
+// disk.GetFilesystem, with the data read from data
+func CreatePartition(d *disk.Disk, partition int, data io.Reader, options ...Opt) (*Device, error) {
+	p, err := getPartition(d, partition)
+	if err != nil {
+		return nil, err
+	}
+	w, err := d.Backend.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return Create(io.NewOffsetWriter(w, p.GetStart()), p.GetSize(), data, options...)
+}
+
+// VerifyPartition reads the superblock of the device on the partition of the disk and checks the
+// tags of all of its sectors with the algorithm and key of the options, and returns the device
+func VerifyPartition(d *disk.Disk, partition int, options ...Opt) (*Device, error) {
+	r, dev, err := readPartition(d, partition, options...)
+	if err != nil {
+		return nil, err
+	}
+	return dev, dev.Verify(r)
+}
+
+// OpenPartition returns a read-only backend of the data of the device on the partition of the
+// disk, with the algorithm and key of the options, as with Device.Open
+func OpenPartition(d *disk.Disk, partition int, options ...Opt) (backend.Storage, error) {
+	r, dev, err := readPartition(d, partition, options...)
+	if err != nil {
+		return nil, err
+	}
+	return dev.Open(r)
+}
+
+// readPartition returns a reader of the partition of the disk, and the device its superblock
+// describes
+func readPartition(d *disk.Disk, partition int, options ...Opt) (io.ReaderAt, *Device, error) {
+	p, err := getPartition(d, partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := io.NewSectionReader(d.Backend, p.GetStart(), p.GetSize())
+	dev, err := ReadSuperblock(r, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if end := (dev.dataSector(dev.DataSectors-1) + 1) * sectorSize; end > p.GetSize() {
+		return nil, nil, fmt.Errorf("device of %d data sectors is larger than partition %d", dev.DataSectors, partition)
+	}
+	return r, dev, nil
+}
+
+// getPartition returns the partition of the disk
+func getPartition(d *disk.Disk, partition int) (part.Partition, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot use partition %d of %d partitions", partition, len(partitions))
+	}
+	return partitions[partition-1], nil
+}
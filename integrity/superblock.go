@@ -0,0 +1,94 @@
+package integrity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	superblockMagic = "integrt\x00"
+	// superblockSectors is the number of sectors of the superblock, before the journal
+	superblockSectors = 8
+	saltSize          = 16
+	// flags of the superblock
+	flagJournalMAC    = 1 << 0
+	flagRecalculating = 1 << 1
+	flagDirtyBitmap   = 1 << 2
+	flagFixedPadding  = 1 << 3
+	flagFixedHMAC     = 1 << 4
+	// versions of the superblock, by the flags it has
+	versionFixedPadding = 4
+	versionFixedHMAC    = 5
+	// bounds of the log2 of the interleave sectors
+	minLog2Interleave = 3
+	maxLog2Interleave = 31
+)
+
+// superblock returns the superblock of the device, in the sectors before the journal, little endian
+func (d *Device) superblock() []byte {
+	b := make([]byte, superblockSectors*sectorSize)
+	copy(b[0:8], superblockMagic)
+	b[8] = byte(d.Version)
+	b[9] = byte(bits.TrailingZeros64(uint64(d.InterleaveSectors)))
+	binary.LittleEndian.PutUint16(b[10:12], uint16(d.TagSize))
+	binary.LittleEndian.PutUint32(b[12:16], d.JournalSections)
+	binary.LittleEndian.PutUint64(b[16:24], uint64(d.DataSectors))
+	binary.LittleEndian.PutUint32(b[24:28], d.Flags)
+	b[28] = byte(bits.TrailingZeros64(uint64(d.SectorSize / sectorSize)))
+	copy(b[48:64], d.Salt)
+	return b
+}
+
+// ReadSuperblock reads the superblock at the start of the device and returns the device it
+// describes. The algorithm of the tags is not recorded, only their size, so the Algorithm and
+// key of the device are those of the options, or DefaultAlgorithm.
+func ReadSuperblock(r io.ReaderAt, options ...Opt) (*Device, error) {
+	o, err := newOpts(options...)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 64)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("error reading superblock: %w", err)
+	}
+	if string(b[0:8]) != superblockMagic {
+		return nil, ErrNoSuperblock
+	}
+	d := &Device{
+		Algorithm:       o.algorithm,
+		Version:         int(b[8]),
+		TagSize:         int(binary.LittleEndian.Uint16(b[10:12])),
+		JournalSections: binary.LittleEndian.Uint32(b[12:16]),
+		DataSectors:     int64(binary.LittleEndian.Uint64(b[16:24])),
+		Flags:           binary.LittleEndian.Uint32(b[24:28]),
+		key:             o.key,
+	}
+	if d.Version < 1 || d.Version > versionFixedHMAC {
+		return nil, fmt.Errorf("unsupported superblock version %d", d.Version)
+	}
+	log2Interleave, log2SectorsPerBlock := b[9], b[28]
+	// devices with a separate metadata device have no interleaving
+	if log2Interleave < minLog2Interleave || log2Interleave > maxLog2Interleave {
+		return nil, fmt.Errorf("unsupported interleave of 2^%d sectors, only standalone devices are supported", log2Interleave)
+	}
+	if log2SectorsPerBlock > 3 || log2SectorsPerBlock > log2Interleave {
+		return nil, fmt.Errorf("invalid sector size of 2^%d sectors", log2SectorsPerBlock)
+	}
+	d.InterleaveSectors = 1 << log2Interleave
+	d.SectorSize = sectorSize << log2SectorsPerBlock
+	if d.Flags&(flagJournalMAC|flagRecalculating|flagDirtyBitmap) != 0 {
+		return nil, fmt.Errorf("unsupported superblock flags %#x", d.Flags)
+	}
+	if d.Flags&flagFixedHMAC != 0 {
+		d.Salt = append([]byte{}, b[48:64]...)
+	}
+	if d.JournalSections == 0 || d.DataSectors <= 0 || d.DataSectors%(d.SectorSize/sectorSize) != 0 {
+		return nil, fmt.Errorf("invalid superblock of %d journal sections and %d data sectors", d.JournalSections, d.DataSectors)
+	}
+	if err := d.checkAlgorithm(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
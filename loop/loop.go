@@ -0,0 +1,104 @@
+// Package loop attaches image files to loop devices on Linux, so that an image built with
+// go-diskfs can be handed to the kernel, e.g. to mount its partitions or to boot a virtual machine
+// from the device, without running losetup:
+//
+//	dev, err := loop.Attach("disk.img")
+//	...
+//	defer dev.Detach()
+//	err = dev.Disk.Partition(table)
+//	...
+//	fmt.Println(dev.Partition(1)) // /dev/loop0p1
+//
+// A free device is found with LOOP_CTL_GET_FREE of /dev/loop-control, and the image attached to it
+// with LOOP_CONFIGURE, or LOOP_SET_FD and LOOP_SET_STATUS64 on kernels before 5.8, with partition
+// scanning, so that the kernel creates the devices of the partitions of the image, and again when
+// the partition table is written through the Disk. Attaching and detaching need CAP_SYS_ADMIN.
+package loop
+
+import (
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+type opts struct {
+	readOnly   bool
+	offset     int64
+	size       int64
+	sectorSize int64
+	noPartScan bool
+}
+
+// Opt func that process Attach options
+type Opt func(o *opts) error
+
+// WithReadOnly attaches the image read-only, and opens the Disk read-only
+func WithReadOnly() Opt {
+	return func(o *opts) error {
+		o.readOnly = true
+		return nil
+	}
+}
+
+// WithOffset attaches the size bytes of the image at offset, or those up to its end if size is 0,
+// e.g. to attach a partition of the image by itself
+func WithOffset(offset, size int64) Opt {
+	return func(o *opts) error {
+		if offset < 0 || size < 0 {
+			return fmt.Errorf("invalid offset %d and size %d", offset, size)
+		}
+		o.offset, o.size = offset, size
+		return nil
+	}
+}
+
+// WithSectorSize sets the logical sector size of the loop device, a power of 2 from 512 to 4096,
+// 512 by default, e.g. for images of disks with 4096 byte sectors
+func WithSectorSize(size int64) Opt {
+	return func(o *opts) error {
+		if size < 512 || size > 4096 || size&(size-1) != 0 {
+			return fmt.Errorf("invalid sector size %d, must be a power of 2 from 512 to 4096", size)
+		}
+		o.sectorSize = size
+		return nil
+	}
+}
+
+// WithoutPartitionScan attaches the image without partition scanning, so that the kernel creates
+// no devices for its partitions
+func WithoutPartitionScan() Opt {
+	return func(o *opts) error {
+		o.noPartScan = true
+		return nil
+	}
+}
+
+// Device is an image file attached to a loop device
+type Device struct {
+	// Disk is the loop device opened as a disk, read-write unless attached WithReadOnly. It is not
+	// opened for exclusive access, so that the kernel can mount its partitions. It is closed by
+	// Detach.
+	Disk   *disk.Disk
+	number int
+}
+
+// Number returns the number of the loop device, N of /dev/loopN
+func (d *Device) Number() int {
+	return d.number
+}
+
+// Path returns the path of the loop device, e.g. /dev/loop0
+func (d *Device) Path() string {
+	return devicePath(d.number)
+}
+
+// Partition returns the path of the device of the partition of the loop device, numbered from 1,
+// e.g. /dev/loop0p1, which the kernel creates with partition scanning
+func (d *Device) Partition(n int) string {
+	return fmt.Sprintf("%sp%d", d.Path(), n)
+}
+
+// devicePath returns the path of the loop device numbered n
+func devicePath(n int) string {
+	return fmt.Sprintf("/dev/loop%d", n)
+}
@@ -0,0 +1,168 @@
+package loop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/block"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+const loopControl = "/dev/loop-control"
+
+// errDetached is returned by Detach of a device already detached
+var errDetached = errors.New("loop device already detached")
+
+// attempts is the number of free devices tried, as others may take the device returned by
+// LOOP_CTL_GET_FREE before it is configured
+const attempts = 10
+
+// Attach attaches the image file to a free loop device, with partition scanning unless
+// WithoutPartitionScan, and opens the device as a Disk
+func Attach(image string, options ...Opt) (*Device, error) {
+	o := &opts{}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	flag := os.O_RDWR
+	if o.readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(image, flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image %s: %w", image, err)
+	}
+	// the loop device keeps its own reference to the file once attached
+	defer f.Close()
+	ctl, err := os.OpenFile(loopControl, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", loopControl, err)
+	}
+	defer ctl.Close()
+
+	for i := 0; i < attempts; i++ {
+		n, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get a free loop device: %w", err)
+		}
+		dev, err := os.OpenFile(devicePath(n), flag, 0)
+		if err != nil {
+			return nil, fmt.Errorf("could not open loop device %s: %w", devicePath(n), err)
+		}
+		err = configure(dev, f, image, o)
+		if errors.Is(err, unix.EBUSY) {
+			dev.Close()
+			continue
+		}
+		if err != nil {
+			dev.Close()
+			return nil, fmt.Errorf("unable to attach %s to %s: %w", image, devicePath(n), err)
+		}
+		d, err := open(dev, o)
+		if err != nil {
+			_ = clearFD(dev)
+			dev.Close()
+			return nil, err
+		}
+		return &Device{Disk: d, number: n}, nil
+	}
+	return nil, fmt.Errorf("unable to attach %s: the free loop devices were taken by others", image)
+}
+
+// configure attaches the image file f to the loop device dev, or returns unix.EBUSY if the device
+// was taken by another
+func configure(dev, f *os.File, image string, o *opts) error {
+	info := unix.LoopInfo64{
+		Offset:    uint64(o.offset),
+		Sizelimit: uint64(o.size),
+	}
+	if !o.noPartScan {
+		info.Flags |= unix.LO_FLAGS_PARTSCAN
+	}
+	if o.readOnly {
+		info.Flags |= unix.LO_FLAGS_READ_ONLY
+	}
+	// the name is truncated as losetup does, it is only shown by losetup --list
+	copy(info.File_name[:len(info.File_name)-1], image)
+	fd := int(dev.Fd())
+	config := unix.LoopConfig{Fd: uint32(f.Fd()), Size: uint32(o.sectorSize), Info: info}
+	err := unix.IoctlLoopConfigure(fd, &config)
+	if !errors.Is(err, unix.EINVAL) && !errors.Is(err, unix.ENOTTY) {
+		return err
+	}
+
+	// kernels before 5.8 have no LOOP_CONFIGURE
+	if err := unix.IoctlSetInt(fd, unix.LOOP_SET_FD, int(f.Fd())); err != nil {
+		return err
+	}
+	err = unix.IoctlLoopSetStatus64(fd, &info)
+	if err == nil && o.sectorSize != 0 {
+		err = unix.IoctlSetInt(fd, unix.LOOP_SET_BLOCK_SIZE, int(o.sectorSize))
+	}
+	if err != nil {
+		_ = clearFD(dev)
+		return err
+	}
+	return nil
+}
+
+// open opens the loop device as a disk
+func open(dev *os.File, o *opts) (*disk.Disk, error) {
+	b, err := block.New(dev, o.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	mode := diskfs.ReadWrite
+	if o.readOnly {
+		mode = diskfs.ReadOnly
+	}
+	return diskfs.OpenBackend(b, diskfs.WithOpenMode(mode))
+}
+
+// clearFD detaches the image file of the loop device dev, with LOOP_CLR_FD. The kernel detaches it
+// once the device is closed by all.
+func clearFD(dev *os.File) error {
+	return unix.IoctlSetInt(int(dev.Fd()), unix.LOOP_CLR_FD, 0)
+}
+
+// Detach detaches the image from the loop device, once the writes to the Disk are synced, and
+// closes the Disk. The kernel detaches it once the partitions of the device are unmounted, and the
+// device closed by others.
+func (d *Device) Detach() error {
+	if d.Disk == nil {
+		return errDetached
+	}
+	if err := d.Disk.Sync(); err != nil {
+		return err
+	}
+	f, err := d.Disk.Backend.Sys()
+	if err != nil {
+		return err
+	}
+	if err := clearFD(f); err != nil {
+		return fmt.Errorf("unable to detach %s: %w", d.Path(), err)
+	}
+	err = d.Disk.Close()
+	d.Disk = nil
+	return err
+}
+
+// Detach detaches the image from the loop device at the path, e.g. /dev/loop0, such as one attached
+// by another process or by losetup
+func Detach(path string) error {
+	dev, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open loop device %s: %w", path, err)
+	}
+	defer dev.Close()
+	if err := clearFD(dev); err != nil {
+		return fmt.Errorf("unable to detach %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package loop_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/loop"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+// attach attaches the image, skipping the test if that is not possible, e.g. when not running as
+// root
+func attach(t *testing.T, image string, opts ...loop.Opt) *loop.Device {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("loop devices need root")
+	}
+	if _, err := os.Stat("/dev/loop-control"); err != nil {
+		t.Skipf("no loop devices: %v", err)
+	}
+	dev, err := loop.Attach(image, opts...)
+	if err != nil {
+		t.Fatalf("error attaching %s: %v", image, err)
+	}
+	t.Cleanup(func() {
+		if dev.Disk != nil {
+			_ = dev.Detach()
+		}
+	})
+	return dev
+}
+
+// loopAttr returns the attribute of the loop device, e.g. its backing_file, as the kernel reports
+// it, or "" if it is not attached
+func loopAttr(n int, attr string) string {
+	b, err := os.ReadFile(fmt.Sprintf("/sys/block/loop%d/loop/%s", n, attr))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+func TestAttach(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "disk.img")
+	size := int64(20 * 1024 * 1024)
+	if err := os.WriteFile(image, make([]byte, size), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dev := attach(t, image)
+	if dev.Path() != fmt.Sprintf("/dev/loop%d", dev.Number()) || dev.Partition(1) != dev.Path()+"p1" {
+		t.Errorf("paths %s and %s of loop device %d", dev.Path(), dev.Partition(1), dev.Number())
+	}
+	if backing := loopAttr(dev.Number(), "backing_file"); backing != image {
+		t.Errorf("backing file %q instead of %s", backing, image)
+	}
+	if dev.Disk.Size != size || dev.Disk.LogicalBlocksize != 512 {
+		t.Errorf("disk of %d bytes and sectors of %d bytes", dev.Disk.Size, dev.Disk.LogicalBlocksize)
+	}
+	// the kernel scans the partitions where it has support for their tables
+	if partscan := loopAttr(dev.Number(), "partscan"); partscan != "1" {
+		t.Errorf("partition scanning %q instead of 1", partscan)
+	}
+
+	table := &gpt.Table{
+		LogicalSectorSize:  512,
+		PhysicalSectorSize: 512,
+		ProtectiveMBR:      true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 4095, Type: gpt.LinuxFilesystem, Name: "data"},
+		},
+	}
+	if err := dev.Disk.Partition(table); err != nil {
+		t.Fatalf("error partitioning: %v", err)
+	}
+
+	n := dev.Number()
+	if err := dev.Detach(); err != nil {
+		t.Fatalf("error detaching: %v", err)
+	}
+	if backing := loopAttr(n, "backing_file"); backing != "" {
+		t.Errorf("backing file %s after detaching", backing)
+	}
+	if err := dev.Detach(); err == nil {
+		t.Error("no error detaching twice")
+	}
+
+	// the partition table was written to the image
+	b, err := os.ReadFile(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b[512:520], []byte("EFI PART")) {
+		t.Error("GPT header not written to the image")
+	}
+}
+
+func TestAttachOptions(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(image, make([]byte, 8*1024*1024), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dev := attach(t, image, loop.WithReadOnly(), loop.WithOffset(1024*1024, 4*1024*1024), loop.WithSectorSize(4096), loop.WithoutPartitionScan())
+	if partscan := loopAttr(dev.Number(), "partscan"); partscan != "0" {
+		t.Errorf("partition scanning %q instead of 0", partscan)
+	}
+	if offset := loopAttr(dev.Number(), "offset"); offset != "1048576" {
+		t.Errorf("offset %s instead of 1048576", offset)
+	}
+	if dev.Disk.Size != 4*1024*1024 || dev.Disk.LogicalBlocksize != 4096 {
+		t.Errorf("disk of %d bytes and sectors of %d bytes", dev.Disk.Size, dev.Disk.LogicalBlocksize)
+	}
+	if _, err := dev.Disk.Backend.Writable(); err == nil {
+		t.Error("read-only disk writable")
+	}
+
+	if err := loop.Detach(dev.Path()); err != nil {
+		t.Errorf("error detaching %s: %v", dev.Path(), err)
+	}
+	_ = dev.Disk.Close()
+	dev.Disk = nil
+	if _, err := loop.Attach(image, loop.WithSectorSize(1000)); err == nil {
+		t.Error("no error attaching with sectors of 1000 bytes")
+	}
+}
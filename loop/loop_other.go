@@ -0,0 +1,22 @@
+//go:build !linux
+
+package loop
+
+import "errors"
+
+var errUnsupported = errors.New("loop devices not supported on this platform")
+
+// Attach attaches the image file to a loop device, which is not supported on this platform
+func Attach(_ string, _ ...Opt) (*Device, error) {
+	return nil, errUnsupported
+}
+
+// Detach detaches the image from the loop device
+func (d *Device) Detach() error {
+	return errUnsupported
+}
+
+// Detach detaches the image from the loop device at the path
+func Detach(_ string) error {
+	return errUnsupported
+}
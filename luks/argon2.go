@@ -0,0 +1,323 @@
+package luks
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sync"
+)
+
+// argon2 types, as in RFC 9106
+const (
+	argon2d  = 0
+	argon2i  = 1
+	argon2id = 2
+
+	argon2Version = 0x13
+	// argon2SyncPoints are the slices of each pass, after which the lanes are synchronized
+	argon2SyncPoints = 4
+	// argon2BlockWords are the 64 bit words of the blocks of 1KiB
+	argon2BlockWords = 128
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives a key of keyLen bytes from the password with Argon2 of the type, time passes
+// over memory KiB in the number of lanes. LUKS2 has neither secret nor associated data.
+func argon2Key(typ int, password, salt, secret, data []byte, time, memory, lanes uint32, keyLen int) []byte {
+	h0 := blake2bSum(64,
+		le32(lanes), le32(uint32(keyLen)), le32(memory), le32(time), le32(argon2Version), le32(uint32(typ)),
+		le32(uint32(len(password))), password, le32(uint32(len(salt))), salt,
+		le32(uint32(len(secret))), secret, le32(uint32(len(data))), data)
+
+	// the memory is a whole number of segments in each lane
+	memory = max(memory, 2*argon2SyncPoints*lanes)
+	segment := memory / (lanes * argon2SyncPoints)
+	laneLength := segment * argon2SyncPoints
+	blocks := make([]argon2Block, laneLength*lanes)
+
+	var b [1024]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			blake2bLong(b[:], h0, le32(i), le32(lane))
+			blocks[lane*laneLength+i].fromBytes(b[:])
+		}
+	}
+
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			var wg sync.WaitGroup
+			for lane := uint32(0); lane < lanes; lane++ {
+				wg.Add(1)
+				go func(lane uint32) {
+					defer wg.Done()
+					argon2Segment(blocks, typ, pass, slice, lane, lanes, segment, time)
+				}(lane)
+			}
+			wg.Wait()
+		}
+	}
+
+	var final argon2Block
+	for lane := uint32(0); lane < lanes; lane++ {
+		last := &blocks[lane*laneLength+laneLength-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	key := make([]byte, keyLen)
+	blake2bLong(key, final.bytes())
+	return key
+}
+
+// argon2Segment fills the blocks of a segment of a lane
+func argon2Segment(blocks []argon2Block, typ int, pass, slice, lane, lanes, segment, time uint32) {
+	laneLength := segment * argon2SyncPoints
+	memory := laneLength * lanes
+	dataIndependent := typ == argon2i || (typ == argon2id && pass == 0 && slice < argon2SyncPoints/2)
+
+	var zero, input, addresses argon2Block
+	nextAddresses := func() {
+		input[6]++
+		argon2Fill(&addresses, &zero, &input, false)
+		argon2Fill(&addresses, &zero, &addresses, false)
+	}
+	if dataIndependent {
+		input[0], input[1], input[2] = uint64(pass), uint64(lane), uint64(slice)
+		input[3], input[4], input[5] = uint64(memory), uint64(time), uint64(typ)
+	}
+
+	start := uint32(0)
+	if pass == 0 && slice == 0 {
+		// the first two blocks are already filled
+		start = 2
+		if dataIndependent {
+			nextAddresses()
+		}
+	}
+	offset := lane*laneLength + slice*segment + start
+	prev := offset - 1
+	if offset%laneLength == 0 {
+		prev = offset + laneLength - 1
+	}
+	for i := start; i < segment; i, offset, prev = i+1, offset+1, prev+1 {
+		if offset%laneLength == 1 {
+			prev = offset - 1
+		}
+		var random uint64
+		if dataIndependent {
+			if i%argon2BlockWords == 0 {
+				nextAddresses()
+			}
+			random = addresses[i%argon2BlockWords]
+		} else {
+			random = blocks[prev][0]
+		}
+		refLane := uint32(random>>32) % lanes
+		if pass == 0 && slice == 0 {
+			refLane = lane
+		}
+		ref := argon2Index(pass, slice, i, segment, uint32(random), refLane == lane)
+		argon2Fill(&blocks[offset], &blocks[prev], &blocks[refLane*laneLength+ref], pass > 0)
+	}
+}
+
+// argon2Index maps the pseudo-random number to the index of the reference block in its lane
+func argon2Index(pass, slice, index, segment, random uint32, sameLane bool) uint32 {
+	laneLength := segment * argon2SyncPoints
+	var area uint32
+	switch {
+	case pass == 0 && slice == 0:
+		area = index - 1
+	case pass == 0 && sameLane:
+		area = slice*segment + index - 1
+	case pass == 0:
+		area = slice * segment
+		if index == 0 {
+			area--
+		}
+	case sameLane:
+		area = laneLength - segment + index - 1
+	default:
+		area = laneLength - segment
+		if index == 0 {
+			area--
+		}
+	}
+	x := uint64(random) * uint64(random) >> 32
+	relative := uint64(area) - 1 - (uint64(area) * x >> 32)
+	startPosition := uint64(0)
+	if pass != 0 && slice != argon2SyncPoints-1 {
+		startPosition = uint64(slice+1) * uint64(segment)
+	}
+	return uint32((startPosition + relative) % uint64(laneLength))
+}
+
+// argon2Fill sets out to the compression of prev and ref, XORed with out if xor
+func argon2Fill(out, prev, ref *argon2Block, xor bool) {
+	var r, tmp argon2Block
+	for i := range r {
+		r[i] = prev[i] ^ ref[i]
+	}
+	tmp = r
+	if xor {
+		for i := range tmp {
+			tmp[i] ^= out[i]
+		}
+	}
+	// the permutation of BLAKE2b on the rows, then the columns, of 16 byte registers
+	for i := 0; i < 8; i++ {
+		v := r[16*i : 16*i+16]
+		blamkaRound(&v[0], &v[1], &v[2], &v[3], &v[4], &v[5], &v[6], &v[7],
+			&v[8], &v[9], &v[10], &v[11], &v[12], &v[13], &v[14], &v[15])
+	}
+	for i := 0; i < 8; i++ {
+		c := 2 * i
+		blamkaRound(&r[c], &r[c+1], &r[c+16], &r[c+17], &r[c+32], &r[c+33], &r[c+48], &r[c+49],
+			&r[c+64], &r[c+65], &r[c+80], &r[c+81], &r[c+96], &r[c+97], &r[c+112], &r[c+113])
+	}
+	for i := range out {
+		out[i] = tmp[i] ^ r[i]
+	}
+}
+
+func blamkaRound(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	blamka(v0, v4, v8, v12)
+	blamka(v1, v5, v9, v13)
+	blamka(v2, v6, v10, v14)
+	blamka(v3, v7, v11, v15)
+	blamka(v0, v5, v10, v15)
+	blamka(v1, v6, v11, v12)
+	blamka(v2, v7, v8, v13)
+	blamka(v3, v4, v9, v14)
+}
+
+// blamka is the G function of BLAKE2b with multiplications of the low 32 bits
+func blamka(a, b, c, d *uint64) {
+	mul := func(x, y uint64) uint64 { return 2 * uint64(uint32(x)) * uint64(uint32(y)) }
+	*a += *b + mul(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -32)
+	*c += *d + mul(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -24)
+	*a += *b + mul(*a, *b)
+	*d = bits.RotateLeft64(*d^*a, -16)
+	*c += *d + mul(*c, *d)
+	*b = bits.RotateLeft64(*b^*c, -63)
+}
+
+func (b *argon2Block) fromBytes(p []byte) {
+	for i := range b {
+		b[i] = binary.LittleEndian.Uint64(p[8*i:])
+	}
+}
+
+func (b *argon2Block) bytes() []byte {
+	p := make([]byte, 0, 8*argon2BlockWords)
+	for _, w := range b {
+		p = binary.LittleEndian.AppendUint64(p, w)
+	}
+	return p
+}
+
+func le32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// blake2bLong is the hash H' of Argon2, of any length, from BLAKE2b hashes of 64 bytes
+func blake2bLong(out []byte, in ...[]byte) {
+	prefixed := append([][]byte{le32(uint32(len(out)))}, in...)
+	if len(out) <= 64 {
+		copy(out, blake2bSum(len(out), prefixed...))
+		return
+	}
+	v := blake2bSum(64, prefixed...)
+	for ; len(out) > 64; out = out[32:] {
+		copy(out, v[:32])
+		v = blake2bSum(min(len(out)-32, 64), v)
+	}
+	copy(out, v)
+}
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2bSum returns the unkeyed BLAKE2b hash of size bytes, from 1 to 64, of the concatenation
+// of the inputs, as in RFC 7693
+func blake2bSum(size int, in ...[]byte) []byte {
+	var data []byte
+	for _, b := range in {
+		data = append(data, b...)
+	}
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(size)
+	var block [128]byte
+	counter := uint64(0)
+	for {
+		n := copy(block[:], data)
+		clear(block[n:])
+		data = data[n:]
+		counter += uint64(n)
+		last := len(data) == 0
+		blake2bCompress(&h, &block, counter, last)
+		if last {
+			break
+		}
+	}
+	out := make([]byte, 0, 64)
+	for _, w := range h {
+		out = binary.LittleEndian.AppendUint64(out, w)
+	}
+	return out[:size]
+}
+
+func blake2bCompress(h *[8]uint64, block *[128]byte, counter uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if last {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for round := 0; round < 12; round++ {
+		s := &blake2bSigma[round%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
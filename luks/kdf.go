@@ -0,0 +1,84 @@
+package luks
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // LUKS1 headers use SHA1 by default
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// hashes are the hashes of the keyslots and digests, by their names in the headers
+var hashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+func hashFunc(name string) (func() hash.Hash, error) {
+	h, ok := hashes[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %s", name)
+	}
+	return h, nil
+}
+
+// pbkdf2 derives a key of keyLen bytes from the password, as in RFC 8018
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	key := make([]byte, 0, (keyLen+size-1)/size*size)
+	u := make([]byte, size)
+	t := make([]byte, size)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// afMerge recovers the key of keySize bytes from the stripes of the anti-forensic splitter of
+// LUKS, each of which but the last is XORed in and diffused with the hash
+func afMerge(material []byte, keySize, stripes int, h func() hash.Hash) []byte {
+	d := make([]byte, keySize)
+	for i := 0; i < stripes-1; i++ {
+		for j, b := range material[i*keySize : (i+1)*keySize] {
+			d[j] ^= b
+		}
+		diffuse(d, h)
+	}
+	last := material[(stripes-1)*keySize : stripes*keySize]
+	for j, b := range last {
+		d[j] ^= b
+	}
+	return d
+}
+
+// diffuse replaces each block of the size of the hash with its hash, prefixed with its number
+func diffuse(d []byte, h func() hash.Hash) {
+	hh := h()
+	size := hh.Size()
+	for i := 0; i*size < len(d); i++ {
+		block := d[i*size : min((i+1)*size, len(d))]
+		hh.Reset()
+		hh.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+		hh.Write(block)
+		copy(block, hh.Sum(nil))
+	}
+}
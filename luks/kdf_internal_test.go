@@ -0,0 +1,69 @@
+package luks
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // the test vectors of RFC 6070 are for SHA1
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlake2b(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		input []byte
+		sum   string
+	}{
+		{"empty", 64, nil, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{"abc", 32, []byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{"several blocks", 64, bytes.Repeat(func() []byte {
+			b := make([]byte, 256)
+			for i := range b {
+				b[i] = byte(i)
+			}
+			return b
+		}(), 3), "323e97a7a859ee63c9013debb0ca995811e73117a2f574723416e596ebc184e37a59b66d2f597df4a7c1b0d1d41a1a7f28774f46a6864d56c57b9d6c5f7302fb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sum := hex.EncodeToString(blake2bSum(tt.size, tt.input)); sum != tt.sum {
+				t.Errorf("hash %s instead of %s", sum, tt.sum)
+			}
+		})
+	}
+}
+
+// TestArgon2 checks the test vectors of RFC 9106
+func TestArgon2(t *testing.T) {
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	secret := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	tests := []struct {
+		name string
+		typ  int
+		tag  string
+	}{
+		{"argon2d", argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{"argon2i", argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{"argon2id", argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag := hex.EncodeToString(argon2Key(tt.typ, password, salt, secret, data, 3, 32, 4, 32))
+			if tag != tt.tag {
+				t.Errorf("tag %s instead of %s", tag, tt.tag)
+			}
+		})
+	}
+}
+
+func TestPBKDF2(t *testing.T) {
+	if key := hex.EncodeToString(pbkdf2(sha1.New, []byte("password"), []byte("salt"), 4096, 20)); key != "4b007901b765489abead49d926f721d065a429c1" {
+		t.Errorf("PBKDF2-HMAC-SHA1 key %s", key)
+	}
+	if key := hex.EncodeToString(pbkdf2(sha256.New, []byte("password"), []byte("salt"), 4096, 40)); key != "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134af7ad98c1b458ce3f" {
+		t.Errorf("PBKDF2-HMAC-SHA256 key %s", key)
+	}
+}
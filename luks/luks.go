@@ -0,0 +1,208 @@
+// Package luks unlocks disks and partitions encrypted with LUKS1 or LUKS2, as cryptsetup creates
+// them, with a passphrase of one of their keyslots, and gives access to the decrypted data as a
+// backend, read-write if the encrypted storage is writable:
+//
+//	b, err := luks.OpenPartition(d, 2, []byte("passphrase"))
+//	root, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+//	fs, err := root.GetFilesystem(0)
+//
+// Keyslots derive their keys with PBKDF2, Argon2i or Argon2id. The data must be encrypted with
+// aes-xts-plain64, the default of cryptsetup, and is decrypted with the crypt package. Headers
+// are only read, so keyslots cannot be added or changed.
+package luks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/backend/crypt"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+// magic starts the headers of LUKS1 and LUKS2
+const magic = "LUKS\xba\xbe"
+
+// supportedCipher is the only encryption of the data and keyslots supported
+const supportedCipher = "aes-xts-plain64"
+
+var (
+	// ErrNoHeader is returned where there is no LUKS header
+	ErrNoHeader = errors.New("no LUKS header")
+	// ErrWrongPassphrase is returned where the passphrase unlocks no keyslot
+	ErrWrongPassphrase = errors.New("passphrase unlocks no keyslot")
+)
+
+// Header is the header of a LUKS1 or LUKS2 encrypted disk
+type Header struct {
+	// Version is 1 for LUKS1, 2 for LUKS2
+	Version int
+	UUID    string
+	// Label is the label of LUKS2, empty for LUKS1
+	Label string
+	// Cipher is the encryption of the data, e.g. "aes-xts-plain64"
+	Cipher string
+	// KeySize is the size in bytes of the volume key
+	KeySize int
+	// PayloadOffset is the offset in bytes of the encrypted data
+	PayloadOffset int64
+	// SectorSize is the size of the units encrypted with their own sector number
+	SectorSize int64
+	// IVOffset is added to the sector numbers of the data, in units of 512 bytes
+	IVOffset uint64
+	// Keyslots are the numbers of the keyslots in use
+	Keyslots []int
+	// keyslots unlock the volume key with the passphrase
+	keyslots []keyslot
+}
+
+// keyslot is a copy of the volume key, encrypted with a key derived from a passphrase
+type keyslot struct {
+	number int
+	// derive derives the key of the keyslot from the passphrase
+	derive func(passphrase []byte) ([]byte, error)
+	// the key material, split into stripes with the hash, encrypted with the cipher
+	offset, size int64
+	cipher       string
+	keySize      int
+	stripes      int
+	hash         string
+	// verify checks the volume key against the digest
+	verify func(key []byte) (bool, error)
+}
+
+// ReadHeader reads the header of LUKS1 or LUKS2 at the start of the encrypted disk. It returns an
+// error that is ErrNoHeader if there is none.
+func ReadHeader(r io.ReaderAt) (*Header, error) {
+	b := make([]byte, 8)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+	if string(b[:6]) != magic {
+		// the primary header of LUKS2 may be damaged, its secondary copy intact
+		if h, err := readLUKS2(r); err == nil {
+			return h, nil
+		}
+		return nil, ErrNoHeader
+	}
+	switch version := int(b[6])<<8 | int(b[7]); version {
+	case 1:
+		return readLUKS1(r)
+	case 2:
+		return readLUKS2(r)
+	default:
+		return nil, fmt.Errorf("unsupported LUKS version %d", version)
+	}
+}
+
+// VolumeKey returns the key of the encrypted data, unlocked by the passphrase from one of the
+// keyslots. It returns an error that is ErrWrongPassphrase if none of them is unlocked.
+func (h *Header) VolumeKey(r io.ReaderAt, passphrase []byte) ([]byte, error) {
+	var errs []error
+	for _, slot := range h.keyslots {
+		key, err := slot.unlock(r, passphrase)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("keyslot %d: %w", slot.number, err))
+			continue
+		}
+		if key != nil {
+			return key, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrWrongPassphrase, errors.Join(errs...))
+	}
+	return nil, ErrWrongPassphrase
+}
+
+// unlock returns the volume key of the keyslot, or nil if the passphrase is not that of the
+// keyslot
+func (slot *keyslot) unlock(r io.ReaderAt, passphrase []byte) ([]byte, error) {
+	if slot.cipher != supportedCipher {
+		return nil, fmt.Errorf("unsupported encryption %s", slot.cipher)
+	}
+	h, err := hashFunc(slot.hash)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(slot.keySize) * int64(slot.stripes)
+	if size > slot.size {
+		return nil, fmt.Errorf("key material of %d bytes larger than the area of %d bytes", size, slot.size)
+	}
+	derived, err := slot.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// the key material is encrypted in sectors of 512 bytes, numbered from the start of the area
+	material := make([]byte, (size+crypt.DefaultSectorSize-1)/crypt.DefaultSectorSize*crypt.DefaultSectorSize)
+	if _, err := r.ReadAt(material, slot.offset); err != nil {
+		return nil, fmt.Errorf("error reading key material: %w", err)
+	}
+	c, err := crypt.New(backend.FromReaderAt(bytes.NewReader(material), int64(len(material))), derived)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.ReadAt(material, 0); err != nil {
+		return nil, fmt.Errorf("error decrypting key material: %w", err)
+	}
+	key := afMerge(material, slot.keySize, slot.stripes, h)
+	ok, err := slot.verify(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Open unlocks the encrypted disk with the passphrase and returns the storage of its decrypted
+// data, after the header and keyslots
+func Open(b backend.Storage, passphrase []byte) (*crypt.Storage, error) {
+	h, err := ReadHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	key, err := h.VolumeKey(b, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return h.open(b, key)
+}
+
+// OpenPartition unlocks the encrypted partition of the disk, numbered from 1 as in
+// disk.GetFilesystem, with the passphrase and returns the storage of its decrypted data. Closing
+// it leaves the disk open.
+func OpenPartition(d *disk.Disk, partition int, passphrase []byte) (*crypt.Storage, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot open partition %d of %d partitions", partition, len(partitions))
+	}
+	p := partitions[partition-1]
+	return Open(backend.Section(d.Backend, p.GetStart(), p.GetSize()), passphrase)
+}
+
+// open returns the storage of the data decrypted with the volume key
+func (h *Header) open(b backend.Storage, key []byte) (*crypt.Storage, error) {
+	if h.Cipher != supportedCipher {
+		return nil, fmt.Errorf("unsupported encryption %s of the data", h.Cipher)
+	}
+	options := []crypt.Opt{crypt.WithOffset(h.PayloadOffset), crypt.WithSectorSize(h.SectorSize)}
+	ivOffset := h.IVOffset
+	// LUKS2 counts larger sectors in their size
+	if h.SectorSize > crypt.DefaultSectorSize {
+		options = append(options, crypt.WithLargeSectorIV())
+		ivOffset /= uint64(h.SectorSize / crypt.DefaultSectorSize)
+	}
+	options = append(options, crypt.WithIVOffset(ivOffset))
+	return crypt.New(b, key, options...)
+}
+
+// cString returns the string of the field padded with NUL
+func cString(b []byte) string {
+	s, _, _ := strings.Cut(string(b), "\x00")
+	return s
+}
@@ -0,0 +1,68 @@
+package luks
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	luks1HeaderSize = 592
+	luks1Keyslots   = 8
+	luks1DigestSize = 20
+	// luks1KeyEnabled marks the keyslots in use
+	luks1KeyEnabled = 0x00ac71f3
+)
+
+// readLUKS1 reads the header of LUKS1, whose fields are big endian
+func readLUKS1(r io.ReaderAt) (*Header, error) {
+	b := make([]byte, luks1HeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("error reading LUKS1 header: %w", err)
+	}
+	hashSpec := cString(b[72:104])
+	h := &Header{
+		Version:       1,
+		UUID:          cString(b[168:208]),
+		Cipher:        cString(b[8:40]) + "-" + cString(b[40:72]),
+		KeySize:       int(binary.BigEndian.Uint32(b[108:112])),
+		PayloadOffset: int64(binary.BigEndian.Uint32(b[104:108])) * 512,
+		SectorSize:    512,
+	}
+	hash, err := hashFunc(hashSpec)
+	if err != nil {
+		return nil, err
+	}
+	digest := b[112 : 112+luks1DigestSize]
+	digestSalt := b[132:164]
+	digestIterations := int(binary.BigEndian.Uint32(b[164:168]))
+	verify := func(key []byte) (bool, error) {
+		return hmac.Equal(pbkdf2(hash, key, digestSalt, digestIterations, luks1DigestSize), digest), nil
+	}
+
+	for i := 0; i < luks1Keyslots; i++ {
+		s := b[208+48*i : 208+48*(i+1)]
+		if binary.BigEndian.Uint32(s[0:4]) != luks1KeyEnabled {
+			continue
+		}
+		iterations := int(binary.BigEndian.Uint32(s[4:8]))
+		salt := s[8:40]
+		stripes := int(binary.BigEndian.Uint32(s[44:48]))
+		h.Keyslots = append(h.Keyslots, i)
+		h.keyslots = append(h.keyslots, keyslot{
+			number: i,
+			derive: func(passphrase []byte) ([]byte, error) {
+				return pbkdf2(hash, passphrase, salt, iterations, h.KeySize), nil
+			},
+			offset:  int64(binary.BigEndian.Uint32(s[40:44])) * 512,
+			size:    int64(h.KeySize) * int64(stripes),
+			cipher:  h.Cipher,
+			keySize: h.KeySize,
+			stripes: stripes,
+			hash:    hashSpec,
+			verify:  verify,
+		})
+	}
+	return h, nil
+}
@@ -0,0 +1,242 @@
+package luks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+const (
+	luks2BinarySize = 4096
+	// luks2SecondaryMagic starts the secondary copy of the header
+	luks2SecondaryMagic = "SKUL\xba\xbe"
+)
+
+// luks2Offsets are where the headers of LUKS2 can be, the primary one at the start, the
+// secondary one after it, at an offset that depends on the size of the metadata
+var luks2Offsets = []int64{0, 0x4000, 0x8000, 0x10000, 0x20000, 0x40000, 0x80000, 0x100000, 0x200000, 0x400000}
+
+// luks2Metadata is the JSON metadata of LUKS2, after the binary header
+type luks2Metadata struct {
+	Keyslots map[string]luks2Keyslot `json:"keyslots"`
+	Segments map[string]luks2Segment `json:"segments"`
+	Digests  map[string]luks2Digest  `json:"digests"`
+}
+
+type luks2Keyslot struct {
+	Type    string `json:"type"`
+	KeySize int    `json:"key_size"`
+	AF      struct {
+		Type    string `json:"type"`
+		Stripes int    `json:"stripes"`
+		Hash    string `json:"hash"`
+	} `json:"af"`
+	Area struct {
+		Type       string `json:"type"`
+		Offset     int64  `json:"offset,string"`
+		Size       int64  `json:"size,string"`
+		Encryption string `json:"encryption"`
+		KeySize    int    `json:"key_size"`
+	} `json:"area"`
+	KDF struct {
+		Type       string `json:"type"`
+		Hash       string `json:"hash"`
+		Iterations int    `json:"iterations"`
+		Time       uint32 `json:"time"`
+		Memory     uint32 `json:"memory"`
+		CPUs       uint32 `json:"cpus"`
+		Salt       []byte `json:"salt"`
+	} `json:"kdf"`
+}
+
+type luks2Segment struct {
+	Type       string `json:"type"`
+	Offset     int64  `json:"offset,string"`
+	IVTweak    uint64 `json:"iv_tweak,string"`
+	Encryption string `json:"encryption"`
+	SectorSize int64  `json:"sector_size"`
+}
+
+type luks2Digest struct {
+	Type       string   `json:"type"`
+	Keyslots   []string `json:"keyslots"`
+	Hash       string   `json:"hash"`
+	Iterations int      `json:"iterations"`
+	Salt       []byte   `json:"salt"`
+	Digest     []byte   `json:"digest"`
+}
+
+// readLUKS2 reads the most recent of the valid copies of the header of LUKS2
+func readLUKS2(r io.ReaderAt) (*Header, error) {
+	var (
+		best     []byte
+		bestSeq  uint64
+		firstErr error
+	)
+	for _, offset := range luks2Offsets {
+		b, err := readLUKS2Copy(r, offset)
+		if err != nil {
+			if offset == 0 {
+				firstErr = err
+			}
+			continue
+		}
+		if seq := binary.BigEndian.Uint64(b[16:24]); best == nil || seq > bestSeq {
+			best, bestSeq = b, seq
+		}
+	}
+	if best == nil {
+		if firstErr == nil {
+			firstErr = ErrNoHeader
+		}
+		return nil, firstErr
+	}
+
+	var m luks2Metadata
+	text, _, _ := bytes.Cut(best[luks2BinarySize:], []byte{0})
+	if err := json.Unmarshal(text, &m); err != nil {
+		return nil, fmt.Errorf("invalid LUKS2 metadata: %w", err)
+	}
+	h := &Header{Version: 2, UUID: cString(best[168:208]), Label: cString(best[24:72])}
+
+	// the data is the first segment
+	segment, ok := m.Segments[lowestKey(m.Segments)]
+	if !ok || segment.Type != "crypt" {
+		return nil, fmt.Errorf("no crypt segment in LUKS2 metadata")
+	}
+	h.Cipher = segment.Encryption
+	h.PayloadOffset = segment.Offset
+	h.SectorSize = segment.SectorSize
+	h.IVOffset = segment.IVTweak
+
+	for _, id := range sortedKeys(m.Keyslots) {
+		k := m.Keyslots[id]
+		number, err := strconv.Atoi(id)
+		if err != nil || k.Type != "luks2" {
+			continue
+		}
+		digest, ok := keyslotDigest(m.Digests, id)
+		if !ok {
+			continue
+		}
+		h.KeySize = k.KeySize
+		h.Keyslots = append(h.Keyslots, number)
+		h.keyslots = append(h.keyslots, keyslot{
+			number:  number,
+			derive:  luks2Derive(number, k),
+			offset:  k.Area.Offset,
+			size:    k.Area.Size,
+			cipher:  k.Area.Encryption,
+			keySize: k.KeySize,
+			stripes: k.AF.Stripes,
+			hash:    k.AF.Hash,
+			verify: func(key []byte) (bool, error) {
+				if digest.Type != "pbkdf2" {
+					return false, fmt.Errorf("unsupported digest %s", digest.Type)
+				}
+				hash, err := hashFunc(digest.Hash)
+				if err != nil {
+					return false, err
+				}
+				return hmac.Equal(pbkdf2(hash, key, digest.Salt, digest.Iterations, len(digest.Digest)), digest.Digest), nil
+			},
+		})
+	}
+	return h, nil
+}
+
+// readLUKS2Copy reads the copy of the header of LUKS2 at the offset, with its metadata, checking
+// its checksum
+func readLUKS2Copy(r io.ReaderAt, offset int64) ([]byte, error) {
+	b := make([]byte, luks2BinarySize)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("error reading LUKS2 header at %d: %w", offset, err)
+	}
+	if (offset == 0 && string(b[:6]) != magic) || (offset != 0 && string(b[:6]) != luks2SecondaryMagic) {
+		return nil, ErrNoHeader
+	}
+	if version := binary.BigEndian.Uint16(b[6:8]); version != 2 {
+		return nil, fmt.Errorf("unsupported LUKS version %d", version)
+	}
+	size := binary.BigEndian.Uint64(b[8:16])
+	if size < luks2BinarySize || size > 4*1024*1024 {
+		return nil, fmt.Errorf("invalid LUKS2 header size %d", size)
+	}
+	if hdrOffset := binary.BigEndian.Uint64(b[256:264]); hdrOffset != uint64(offset) {
+		return nil, fmt.Errorf("LUKS2 header at %d records the offset %d", offset, hdrOffset)
+	}
+	b = slices.Grow(b, int(size)-len(b))[:size]
+	if _, err := r.ReadAt(b[luks2BinarySize:], offset+luks2BinarySize); err != nil {
+		return nil, fmt.Errorf("error reading LUKS2 metadata at %d: %w", offset, err)
+	}
+	hash, err := hashFunc(cString(b[72:104]))
+	if err != nil {
+		return nil, err
+	}
+	// the checksum covers the header and metadata, with the checksum itself zeroed
+	checksum := slices.Clone(b[448:512])
+	clear(b[448:512])
+	hh := hash()
+	hh.Write(b)
+	if sum := hh.Sum(nil); !bytes.Equal(sum, checksum[:len(sum)]) {
+		return nil, fmt.Errorf("invalid checksum of the LUKS2 header at %d", offset)
+	}
+	return b, nil
+}
+
+// luks2Derive returns the function deriving the key of the keyslot from the passphrase
+func luks2Derive(number int, k luks2Keyslot) func(passphrase []byte) ([]byte, error) {
+	return func(passphrase []byte) ([]byte, error) {
+		switch k.KDF.Type {
+		case "pbkdf2":
+			hash, err := hashFunc(k.KDF.Hash)
+			if err != nil {
+				return nil, err
+			}
+			return pbkdf2(hash, passphrase, k.KDF.Salt, k.KDF.Iterations, k.Area.KeySize), nil
+		case "argon2i":
+			return argon2Key(argon2i, passphrase, k.KDF.Salt, nil, nil, k.KDF.Time, k.KDF.Memory, k.KDF.CPUs, k.Area.KeySize), nil
+		case "argon2id":
+			return argon2Key(argon2id, passphrase, k.KDF.Salt, nil, nil, k.KDF.Time, k.KDF.Memory, k.KDF.CPUs, k.Area.KeySize), nil
+		default:
+			return nil, fmt.Errorf("unsupported key derivation %s of keyslot %d", k.KDF.Type, number)
+		}
+	}
+}
+
+// keyslotDigest returns the digest of the volume key of the keyslot
+func keyslotDigest(digests map[string]luks2Digest, keyslot string) (luks2Digest, bool) {
+	for _, id := range sortedKeys(digests) {
+		if slices.Contains(digests[id].Keyslots, keyslot) {
+			return digests[id], true
+		}
+	}
+	return luks2Digest{}, false
+}
+
+// sortedKeys returns the keys of the objects of the metadata, which are numbers, in order
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	return keys
+}
+
+func lowestKey[T any](m map[string]T) string {
+	keys := sortedKeys(m)
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
@@ -0,0 +1,306 @@
+package luks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/crypt"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+const (
+	testKeySize = 64
+	testStripes = 4000
+)
+
+// writeKeyslot splits the volume key into stripes, as cryptsetup does, and writes them at the
+// offset encrypted with the key derived from the passphrase
+func writeKeyslot(t *testing.T, b []byte, offset int64, derived, key []byte, h func() hash.Hash) {
+	t.Helper()
+	material := make([]byte, testKeySize*testStripes)
+	if _, err := rand.Read(material); err != nil {
+		t.Fatalf("error generating stripes: %v", err)
+	}
+	d := make([]byte, testKeySize)
+	for i := 0; i < testStripes-1; i++ {
+		for j, c := range material[i*testKeySize : (i+1)*testKeySize] {
+			d[j] ^= c
+		}
+		diffuse(d, h)
+	}
+	last := material[(testStripes-1)*testKeySize:]
+	for j := range last {
+		last[j] = d[j] ^ key[j]
+	}
+	area := make([]byte, (len(material)+511)/512*512)
+	copy(area, material)
+	c, err := crypt.New(mem.New(area, false), derived)
+	if err != nil {
+		t.Fatalf("error creating keyslot encryption: %v", err)
+	}
+	if _, err := c.WriteAt(append([]byte(nil), area...), 0); err != nil {
+		t.Fatalf("error encrypting keyslot: %v", err)
+	}
+	copy(b[offset:], area)
+}
+
+// formatLUKS1 writes a LUKS1 header with a keyslot for the passphrase, and the data after 2MiB
+func formatLUKS1(t *testing.T, b, passphrase, key []byte) {
+	t.Helper()
+	h := b[:luks1HeaderSize]
+	copy(h[0:6], magic)
+	binary.BigEndian.PutUint16(h[6:8], 1)
+	copy(h[8:], "aes")
+	copy(h[40:], "xts-plain64")
+	copy(h[72:], "sha256")
+	binary.BigEndian.PutUint32(h[104:108], 4096)
+	binary.BigEndian.PutUint32(h[108:112], testKeySize)
+	digestSalt := bytes.Repeat([]byte{1}, 32)
+	copy(h[112:132], pbkdf2(sha256.New, key, digestSalt, 1000, luks1DigestSize))
+	copy(h[132:164], digestSalt)
+	binary.BigEndian.PutUint32(h[164:168], 1000)
+	copy(h[168:], "5d3a6f0e-8b3c-4a53-9d0c-2b0f3e6a7c11")
+	salt := bytes.Repeat([]byte{2}, 32)
+	for i := 0; i < luks1Keyslots; i++ {
+		s := h[208+48*i : 208+48*(i+1)]
+		binary.BigEndian.PutUint32(s[0:4], 0x0000dead)
+		binary.BigEndian.PutUint32(s[40:44], uint32(8+i*504))
+		binary.BigEndian.PutUint32(s[44:48], testStripes)
+	}
+	s := h[208+48 : 208+96]
+	binary.BigEndian.PutUint32(s[0:4], luks1KeyEnabled)
+	binary.BigEndian.PutUint32(s[4:8], 2000)
+	copy(s[8:40], salt)
+	writeKeyslot(t, b, int64(8+504)*512, pbkdf2(sha256.New, passphrase, salt, 2000, testKeySize), key, sha256.New)
+}
+
+// luks2Slot is a keyslot for formatLUKS2
+type luks2Slot struct {
+	passphrase []byte
+	kdf        string
+}
+
+// formatLUKS2 writes both copies of a LUKS2 header with the keyslots, and the data after 1MiB
+// in sectors of the size
+func formatLUKS2(t *testing.T, b, key []byte, sectorSize int, slots ...luks2Slot) {
+	t.Helper()
+	const (
+		hdrSize      = 0x4000
+		areaOffset   = 0x8000
+		areaSize     = 258048
+		digestSalt   = "digest salt of 32 bytes........."
+		keyslotSalt  = "keyslot salt of 32 bytes........"
+		digestRounds = 1000
+	)
+	var keyslots, ids string
+	for i, slot := range slots {
+		offset := areaOffset + i*areaSize
+		var derived []byte
+		kdf := fmt.Sprintf(`{"type":%q,"time":2,"memory":64,"cpus":2,"salt":%q}`, slot.kdf, base64.StdEncoding.EncodeToString([]byte(keyslotSalt)))
+		switch slot.kdf {
+		case "pbkdf2":
+			kdf = fmt.Sprintf(`{"type":"pbkdf2","hash":"sha256","iterations":1000,"salt":%q}`, base64.StdEncoding.EncodeToString([]byte(keyslotSalt)))
+			derived = pbkdf2(sha256.New, slot.passphrase, []byte(keyslotSalt), 1000, testKeySize)
+		case "argon2i":
+			derived = argon2Key(argon2i, slot.passphrase, []byte(keyslotSalt), nil, nil, 2, 64, 2, testKeySize)
+		case "argon2id":
+			derived = argon2Key(argon2id, slot.passphrase, []byte(keyslotSalt), nil, nil, 2, 64, 2, testKeySize)
+		}
+		writeKeyslot(t, b, int64(offset), derived, key, sha256.New)
+		if i > 0 {
+			keyslots += ","
+			ids += ","
+		}
+		keyslots += fmt.Sprintf(`"%d":{"type":"luks2","key_size":%d,"af":{"type":"luks1","stripes":%d,"hash":"sha256"},`+
+			`"area":{"type":"raw","offset":"%d","size":"%d","encryption":"aes-xts-plain64","key_size":%d},"kdf":%s}`,
+			i, testKeySize, testStripes, offset, areaSize, testKeySize, kdf)
+		ids += fmt.Sprintf(`"%d"`, i)
+	}
+	metadata := fmt.Sprintf(`{"keyslots":{%s},"tokens":{},`+
+		`"segments":{"0":{"type":"crypt","offset":"1048576","size":"dynamic","iv_tweak":"0","encryption":"aes-xts-plain64","sector_size":%d}},`+
+		`"digests":{"0":{"type":"pbkdf2","keyslots":[%s],"segments":["0"],"hash":"sha256","iterations":%d,"salt":%q,"digest":%q}},`+
+		`"config":{"json_size":"12288","keyslots_size":"1015808"}}`,
+		keyslots, sectorSize, ids, digestRounds, base64.StdEncoding.EncodeToString([]byte(digestSalt)),
+		base64.StdEncoding.EncodeToString(pbkdf2(sha256.New, key, []byte(digestSalt), digestRounds, 32)))
+
+	for i, offset := range []int64{0, hdrSize} {
+		h := b[offset : offset+hdrSize]
+		clear(h)
+		if i == 0 {
+			copy(h[0:6], magic)
+		} else {
+			copy(h[0:6], luks2SecondaryMagic)
+		}
+		binary.BigEndian.PutUint16(h[6:8], 2)
+		binary.BigEndian.PutUint64(h[8:16], hdrSize)
+		binary.BigEndian.PutUint64(h[16:24], 7)
+		copy(h[24:], "data")
+		copy(h[72:], "sha256")
+		copy(h[168:], "0b9f0e1c-7c53-4d3e-a0a4-5bd1e8c2f6a9")
+		binary.BigEndian.PutUint64(h[256:264], uint64(offset))
+		copy(h[luks2BinarySize:], metadata)
+		sum := sha256.Sum256(h)
+		copy(h[448:], sum[:])
+	}
+}
+
+// checkReadWrite writes through the storage unlocked with the passphrase, and reads it back
+// unlocked again
+func checkReadWrite(t *testing.T, b, passphrase []byte, payloadOffset int64) {
+	t.Helper()
+	s, err := Open(mem.New(b, false), passphrase)
+	if err != nil {
+		t.Fatalf("error opening: %v", err)
+	}
+	content := bytes.Repeat([]byte("secret data "), 1000)
+	if _, err := s.WriteAt(content, 12345); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if bytes.Contains(b, []byte("secret data")) {
+		t.Errorf("data written unencrypted")
+	}
+	if s.Size() != int64(len(b))-payloadOffset {
+		t.Errorf("size %d instead of %d", s.Size(), int64(len(b))-payloadOffset)
+	}
+
+	s, err = Open(mem.New(b, true), passphrase)
+	if err != nil {
+		t.Fatalf("error opening again: %v", err)
+	}
+	read := make([]byte, len(content))
+	if _, err := s.ReadAt(read, 12345); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("read data different from written data")
+	}
+	if _, err := Open(mem.New(b, true), []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("error %v with a wrong passphrase instead of ErrWrongPassphrase", err)
+	}
+}
+
+func testKey() []byte {
+	return bytes.Repeat([]byte("0123456789abcdef"), testKeySize/16)
+}
+
+func TestLUKS1(t *testing.T) {
+	b := make([]byte, 3*1024*1024)
+	formatLUKS1(t, b, []byte("passphrase"), testKey())
+	h, err := ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("error reading header: %v", err)
+	}
+	if h.Version != 1 || h.Cipher != "aes-xts-plain64" || h.KeySize != testKeySize || h.PayloadOffset != 2*1024*1024 || len(h.Keyslots) != 1 || h.Keyslots[0] != 1 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	checkReadWrite(t, b, []byte("passphrase"), 2*1024*1024)
+}
+
+func TestLUKS2(t *testing.T) {
+	tests := []struct {
+		name       string
+		sectorSize int
+		kdf        string
+	}{
+		{"pbkdf2", 512, "pbkdf2"},
+		{"argon2i", 512, "argon2i"},
+		{"argon2id 4096 byte sectors", 4096, "argon2id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, 2*1024*1024)
+			formatLUKS2(t, b, testKey(), tt.sectorSize, luks2Slot{[]byte("other"), "pbkdf2"}, luks2Slot{[]byte("passphrase"), tt.kdf})
+			h, err := ReadHeader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("error reading header: %v", err)
+			}
+			if h.Version != 2 || h.Label != "data" || h.PayloadOffset != 1024*1024 || h.SectorSize != int64(tt.sectorSize) || len(h.Keyslots) != 2 {
+				t.Errorf("unexpected header %+v", h)
+			}
+			checkReadWrite(t, b, []byte("passphrase"), 1024*1024)
+		})
+	}
+}
+
+func TestLUKS2DamagedPrimary(t *testing.T) {
+	b := make([]byte, 2*1024*1024)
+	formatLUKS2(t, b, testKey(), 512, luks2Slot{[]byte("passphrase"), "pbkdf2"})
+	// a changed byte of the metadata fails the checksum of the primary header
+	b[luks2BinarySize+1] ^= 0xff
+	if _, err := Open(mem.New(b, true), []byte("passphrase")); err != nil {
+		t.Errorf("error opening with the secondary header: %v", err)
+	}
+	clear(b[:0x8000])
+	if _, err := Open(mem.New(b, true), []byte("passphrase")); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("error %v without headers instead of ErrNoHeader", err)
+	}
+}
+
+func TestOpenPartition(t *testing.T) {
+	image := make([]byte, 64*1024*1024)
+	d, err := diskfs.OpenBackend(mem.New(image, false), diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{
+		ProtectiveMBR: true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 4095, Type: gpt.EFISystemPartition, Name: "EFI"},
+			{Start: 4096, End: 129023, Type: gpt.LinuxFilesystem, Name: "root"},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	formatLUKS2(t, image[4096*512:], testKey(), 512, luks2Slot{[]byte("passphrase"), "argon2id"})
+
+	b, err := OpenPartition(d, 2, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("error opening partition: %v", err)
+	}
+	root, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening decrypted partition: %v", err)
+	}
+	fs, err := root.CreateFilesystem(disk.FormatSpec{FSType: filesystem.TypeFat32})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/hostname", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("server\n")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	if b, err = OpenPartition(d, 2, []byte("passphrase")); err != nil {
+		t.Fatalf("error opening partition again: %v", err)
+	}
+	if root, err = diskfs.OpenBackend(b); err != nil {
+		t.Fatalf("error opening decrypted partition: %v", err)
+	}
+	if fs, err = root.GetFilesystem(0); err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if f, err = fs.OpenFile("/hostname", os.O_RDONLY); err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil || string(content) != "server\n" {
+		t.Errorf("file content %q, %v instead of server", content, err)
+	}
+}
@@ -0,0 +1,151 @@
+package lvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	sectorSize = 512
+	// labelSectors are the first sectors of a physical volume, one of which holds its label
+	labelSectors = 4
+	labelID      = "LABELONE"
+	labelType    = "LVM2 001"
+	// mdaMagic starts the header of a metadata area
+	mdaMagic      = " LVM2 x[5A%r0N*>"
+	mdaHeaderSize = 512
+	// crcInitial is the initial value of the checksums of LVM
+	crcInitial uint32 = 0xf597a6cf
+	// rawLocnIgnored marks metadata that is not to be read
+	rawLocnIgnored uint32 = 0x00000001
+)
+
+// ErrNoLabel is returned by ReadPhysicalVolume where there is no LVM2 label
+var ErrNoLabel = errors.New("no LVM2 physical volume label")
+
+// area is a range of a physical volume, in bytes from its start
+type area struct {
+	offset, size uint64
+}
+
+// checksum is the CRC of LVM, which is CRC-32 without its inversions, from crcInitial
+func checksum(b []byte) uint32 {
+	return ^crc32.Update(^crcInitial, crc32.IEEETable, b)
+}
+
+// formatUUID formats a UUID of 32 characters in the groups of 6-4-4-4-4-4-6 that LVM writes
+func formatUUID(id string) string {
+	if len(id) != 32 {
+		return id
+	}
+	groups := []string{id[0:6], id[6:10], id[10:14], id[14:18], id[18:22], id[22:26], id[26:32]}
+	return strings.Join(groups, "-")
+}
+
+// pvHeader is the header of a physical volume after its label
+type pvHeader struct {
+	uuid          string
+	deviceSize    uint64
+	dataAreas     []area
+	metadataAreas []area
+}
+
+// readLabel finds the label in the first sectors of the physical volume, and returns its header
+func readLabel(r io.ReaderAt) (*pvHeader, error) {
+	b := make([]byte, sectorSize)
+	for sector := int64(0); sector < labelSectors; sector++ {
+		if _, err := r.ReadAt(b, sector*sectorSize); err != nil {
+			return nil, fmt.Errorf("error reading sector %d: %w", sector, err)
+		}
+		if string(b[0:8]) != labelID || string(b[24:32]) != labelType {
+			continue
+		}
+		if binary.LittleEndian.Uint64(b[8:16]) != uint64(sector) {
+			continue
+		}
+		if crc := binary.LittleEndian.Uint32(b[16:20]); crc != checksum(b[20:]) {
+			return nil, fmt.Errorf("invalid checksum %#x of the label in sector %d", crc, sector)
+		}
+		offset := int(binary.LittleEndian.Uint32(b[20:24]))
+		if offset < 32 || offset+40 > sectorSize {
+			return nil, fmt.Errorf("invalid offset %d of the physical volume header", offset)
+		}
+		return parsePVHeader(b[offset:])
+	}
+	return nil, ErrNoLabel
+}
+
+// parsePVHeader parses the header of a physical volume with its lists of areas, each ending
+// with an area of zeroes
+func parsePVHeader(b []byte) (*pvHeader, error) {
+	h := &pvHeader{uuid: string(b[0:32]), deviceSize: binary.LittleEndian.Uint64(b[32:40])}
+	i := 40
+	readAreas := func() ([]area, error) {
+		var areas []area
+		for {
+			if i+16 > len(b) {
+				return nil, fmt.Errorf("list of areas beyond the end of the label sector")
+			}
+			a := area{offset: binary.LittleEndian.Uint64(b[i : i+8]), size: binary.LittleEndian.Uint64(b[i+8 : i+16])}
+			i += 16
+			if a.offset == 0 {
+				return areas, nil
+			}
+			areas = append(areas, a)
+		}
+	}
+	var err error
+	if h.dataAreas, err = readAreas(); err != nil {
+		return nil, err
+	}
+	if h.metadataAreas, err = readAreas(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readMetadata returns the text of the metadata of the volume group in the metadata area, or ""
+// if there is none
+func readMetadata(r io.ReaderAt, mda area) (string, error) {
+	b := make([]byte, mdaHeaderSize)
+	if _, err := r.ReadAt(b, int64(mda.offset)); err != nil {
+		return "", fmt.Errorf("error reading metadata area header: %w", err)
+	}
+	if crc := binary.LittleEndian.Uint32(b[0:4]); crc != checksum(b[4:]) {
+		return "", fmt.Errorf("invalid checksum %#x of the metadata area header", crc)
+	}
+	if string(b[4:20]) != mdaMagic {
+		return "", fmt.Errorf("invalid magic %q of the metadata area header", b[4:20])
+	}
+	size := binary.LittleEndian.Uint64(b[32:40])
+	// the first location is the current metadata, the others are unused
+	offset := binary.LittleEndian.Uint64(b[40:48])
+	length := binary.LittleEndian.Uint64(b[48:56])
+	crc := binary.LittleEndian.Uint32(b[56:60])
+	flags := binary.LittleEndian.Uint32(b[60:64])
+	if offset == 0 || flags&rawLocnIgnored != 0 {
+		return "", nil
+	}
+	if offset < mdaHeaderSize || offset >= size || length > size-mdaHeaderSize {
+		return "", fmt.Errorf("metadata of %d bytes at %d beyond the metadata area of %d bytes", length, offset, size)
+	}
+	text := make([]byte, length)
+	// the area is a circular buffer after its header, so the metadata may wrap around its end
+	first := min(length, size-offset)
+	if _, err := r.ReadAt(text[:first], int64(mda.offset+offset)); err != nil {
+		return "", fmt.Errorf("error reading metadata: %w", err)
+	}
+	if first < length {
+		if _, err := r.ReadAt(text[first:], int64(mda.offset+mdaHeaderSize)); err != nil {
+			return "", fmt.Errorf("error reading metadata: %w", err)
+		}
+	}
+	if c := checksum(text); c != crc {
+		return "", fmt.Errorf("invalid checksum %#x of the metadata instead of %#x", c, crc)
+	}
+	return strings.TrimRight(string(text), "\x00"), nil
+}
@@ -0,0 +1,341 @@
+// Package lvm reads the volume groups of LVM2, from the labels and metadata of their physical
+// volumes, and gives access to their logical volumes, linear or striped, as disks whose
+// filesystems can be opened like any other:
+//
+//	vgs, err := lvm.Scan(d)
+//	lv, ok := vgs[0].LogicalVolume("root")
+//	b, err := lv.Open()
+//	root, err := diskfs.OpenBackend(b)
+//	fs, err := root.GetFilesystem(0)
+//
+// The volumes are written through to their physical volumes if these are writable, but the
+// metadata is never changed, so logical volumes cannot be created or resized. Mirrored, RAID,
+// thin and snapshot volumes are listed, but cannot be opened.
+package lvm
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+// PhysicalVolume is a disk or partition initialized for LVM, found by its label
+type PhysicalVolume struct {
+	// UUID is the UUID of the physical volume, in the groups in which LVM formats it
+	UUID string
+	// Size is the size in bytes of the device, as recorded in the label
+	Size int64
+	// storage and start are where the physical volume is
+	storage backend.Storage
+	start   int64
+	// metadata is the text of the metadata of its volume group, if it has any
+	metadata string
+}
+
+// VolumeGroup is a volume group, assembled from the metadata of its physical volumes
+type VolumeGroup struct {
+	Name string
+	UUID string
+	// SeqNo is the sequence number of the metadata, incremented by LVM with each change
+	SeqNo int64
+	// ExtentSize is the size in bytes of the extents in which volumes are allocated
+	ExtentSize int64
+	// PhysicalVolumes are the physical volumes of the group found
+	PhysicalVolumes []*PhysicalVolume
+	// Missing are the UUIDs of the physical volumes of the group that were not found
+	Missing []string
+	// LogicalVolumes are the logical volumes of the group, sorted by name
+	LogicalVolumes []*LogicalVolume
+	// pvs are the physical volumes by their names in the metadata, e.g. "pv0"
+	pvs map[string]*pvEntry
+}
+
+// pvEntry is a physical volume of the metadata of a volume group
+type pvEntry struct {
+	uuid string
+	// pv is the physical volume, nil if missing
+	pv *PhysicalVolume
+	// peStart is the offset of the first extent in bytes
+	peStart int64
+}
+
+// LogicalVolume is a logical volume of a volume group
+type LogicalVolume struct {
+	Name string
+	UUID string
+	// Size is the size of the volume in bytes
+	Size int64
+	// Status are the flags of the volume, e.g. "READ", "WRITE" and "VISIBLE"
+	Status []string
+	// Segments map the extents of the volume to those of physical volumes, in order
+	Segments []Segment
+	vg       *VolumeGroup
+}
+
+// Segment is a range of the extents of a logical volume
+type Segment struct {
+	// StartExtent is the first extent of the volume in the segment, ExtentCount its number of extents
+	StartExtent int64
+	ExtentCount int64
+	// Type is the type of the segment, "striped" for linear and striped volumes, the only type
+	// that can be opened
+	Type string
+	// StripeSize is the size in bytes of the chunks spread across the stripes, if there are
+	// several
+	StripeSize int64
+	// Stripes are where the extents of the segment are, one for linear volumes
+	Stripes []Stripe
+}
+
+// Stripe is where some extents of a segment are on a physical volume
+type Stripe struct {
+	// PhysicalVolume is the UUID of the physical volume
+	PhysicalVolume string
+	// StartExtent is the first extent of the stripe on the physical volume
+	StartExtent int64
+}
+
+// Visible returns whether the volume is one that LVM lists, and not part of another, such as the
+// metadata of a thin pool
+func (lv *LogicalVolume) Visible() bool {
+	return slices.Contains(lv.Status, "VISIBLE")
+}
+
+// LogicalVolume returns the logical volume of the group with the name
+func (vg *VolumeGroup) LogicalVolume(name string) (*LogicalVolume, bool) {
+	for _, lv := range vg.LogicalVolumes {
+		if lv.Name == name {
+			return lv, true
+		}
+	}
+	return nil, false
+}
+
+// ReadPhysicalVolume reads the label and metadata of the physical volume of size bytes at start
+// in the storage, e.g. a partition of a disk.Disk. It returns an error that is ErrNoLabel if there
+// is none.
+func ReadPhysicalVolume(b backend.Storage, start, size int64) (*PhysicalVolume, error) {
+	r := io.NewSectionReader(b, start, size)
+	h, err := readLabel(r)
+	if err != nil {
+		return nil, err
+	}
+	pv := &PhysicalVolume{UUID: formatUUID(h.uuid), Size: int64(h.deviceSize), storage: b, start: start}
+	// the copies of the metadata are the same, the second one at the end of the device
+	var errs []error
+	for _, mda := range h.metadataAreas {
+		text, err := readMetadata(r, mda)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pv.metadata = text
+		return pv, nil
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("error reading metadata of physical volume %s: %w", pv.UUID, errors.Join(errs...))
+	}
+	return pv, nil
+}
+
+// Scan finds the physical volumes on the disks, on each of their partitions or on the whole of
+// those without a partition table, and assembles their volume groups
+func Scan(disks ...*disk.Disk) ([]*VolumeGroup, error) {
+	var pvs []*PhysicalVolume
+	for _, d := range disks {
+		ranges := [][2]int64{{0, d.Size}}
+		if d.Table != nil {
+			ranges = nil
+			for _, p := range d.Table.GetPartitions() {
+				if p.GetSize() > 0 {
+					ranges = append(ranges, [2]int64{p.GetStart(), p.GetSize()})
+				}
+			}
+		}
+		for _, r := range ranges {
+			pv, err := ReadPhysicalVolume(d.Backend, r[0], r[1])
+			if errors.Is(err, ErrNoLabel) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			pvs = append(pvs, pv)
+		}
+	}
+	return Assemble(pvs)
+}
+
+// Assemble assembles the volume groups of the physical volumes, with the most recent metadata
+// of each, sorted by name. Physical volumes without metadata belong to no group, unless another
+// physical volume of its group has metadata.
+func Assemble(pvs []*PhysicalVolume) ([]*VolumeGroup, error) {
+	byUUID := map[string]*VolumeGroup{}
+	for _, pv := range pvs {
+		if pv.metadata == "" {
+			continue
+		}
+		vg, err := parseVolumeGroup(pv.metadata)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing metadata of physical volume %s: %w", pv.UUID, err)
+		}
+		if old, ok := byUUID[vg.UUID]; !ok || vg.SeqNo > old.SeqNo {
+			byUUID[vg.UUID] = vg
+		}
+	}
+	vgs := make([]*VolumeGroup, 0, len(byUUID))
+	for _, vg := range byUUID {
+		for _, entry := range vg.pvs {
+			for _, pv := range pvs {
+				if pv.UUID == entry.uuid {
+					entry.pv = pv
+				}
+			}
+			if entry.pv == nil {
+				vg.Missing = append(vg.Missing, entry.uuid)
+			} else {
+				vg.PhysicalVolumes = append(vg.PhysicalVolumes, entry.pv)
+			}
+		}
+		slices.Sort(vg.Missing)
+		slices.SortFunc(vg.PhysicalVolumes, func(a, b *PhysicalVolume) int { return strings.Compare(a.UUID, b.UUID) })
+		vgs = append(vgs, vg)
+	}
+	slices.SortFunc(vgs, func(a, b *VolumeGroup) int { return strings.Compare(a.Name, b.Name) })
+	return vgs, nil
+}
+
+// parseVolumeGroup parses the metadata of a volume group
+func parseVolumeGroup(text string) (*VolumeGroup, error) {
+	top, err := parseMetadata(text)
+	if err != nil {
+		return nil, err
+	}
+	// the group is the only section at the top, with settings of the text format
+	var (
+		name string
+		s    section
+	)
+	for n, v := range top {
+		if sub, ok := v.(section); ok {
+			if s != nil {
+				return nil, fmt.Errorf("several volume groups %s and %s", name, n)
+			}
+			name, s = n, sub
+		}
+	}
+	if s == nil {
+		return nil, fmt.Errorf("no volume group")
+	}
+	vg := &VolumeGroup{Name: name, pvs: map[string]*pvEntry{}}
+	if vg.UUID, err = s.string("id"); err != nil {
+		return nil, fmt.Errorf("volume group %s: %w", name, err)
+	}
+	if vg.SeqNo, err = s.int("seqno"); err != nil {
+		return nil, fmt.Errorf("volume group %s: %w", name, err)
+	}
+	extentSize, err := s.int("extent_size")
+	if err != nil || extentSize <= 0 {
+		return nil, fmt.Errorf("volume group %s has no valid extent_size", name)
+	}
+	vg.ExtentSize = extentSize * sectorSize
+
+	pvSections, _ := s.section("physical_volumes")
+	for pvName, v := range pvSections {
+		pvSection, ok := v.(section)
+		if !ok {
+			continue
+		}
+		id, err := pvSection.string("id")
+		if err != nil {
+			return nil, fmt.Errorf("physical volume %s: %w", pvName, err)
+		}
+		peStart, err := pvSection.int("pe_start")
+		if err != nil {
+			return nil, fmt.Errorf("physical volume %s: %w", pvName, err)
+		}
+		vg.pvs[pvName] = &pvEntry{uuid: id, peStart: peStart * sectorSize}
+	}
+
+	lvSections, _ := s.section("logical_volumes")
+	for lvName, v := range lvSections {
+		lvSection, ok := v.(section)
+		if !ok {
+			continue
+		}
+		lv, err := vg.parseLogicalVolume(lvName, lvSection)
+		if err != nil {
+			return nil, fmt.Errorf("logical volume %s: %w", lvName, err)
+		}
+		vg.LogicalVolumes = append(vg.LogicalVolumes, lv)
+	}
+	slices.SortFunc(vg.LogicalVolumes, func(a, b *LogicalVolume) int { return strings.Compare(a.Name, b.Name) })
+	return vg, nil
+}
+
+func (vg *VolumeGroup) parseLogicalVolume(name string, s section) (*LogicalVolume, error) {
+	lv := &LogicalVolume{Name: name, Status: s.strings("status"), vg: vg}
+	var err error
+	if lv.UUID, err = s.string("id"); err != nil {
+		return nil, err
+	}
+	for segName, v := range s {
+		segSection, ok := v.(section)
+		if !ok || !strings.HasPrefix(segName, "segment") {
+			continue
+		}
+		seg, err := vg.parseSegment(segSection)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", segName, err)
+		}
+		lv.Segments = append(lv.Segments, seg)
+		lv.Size += seg.ExtentCount * vg.ExtentSize
+	}
+	slices.SortFunc(lv.Segments, func(a, b Segment) int { return cmp.Compare(a.StartExtent, b.StartExtent) })
+	return lv, nil
+}
+
+func (vg *VolumeGroup) parseSegment(s section) (Segment, error) {
+	var (
+		seg Segment
+		err error
+	)
+	if seg.StartExtent, err = s.int("start_extent"); err != nil {
+		return seg, err
+	}
+	if seg.ExtentCount, err = s.int("extent_count"); err != nil {
+		return seg, err
+	}
+	if seg.Type, err = s.string("type"); err != nil {
+		return seg, err
+	}
+	if seg.Type != "striped" {
+		return seg, nil
+	}
+	if stripeSize, err := s.int("stripe_size"); err == nil {
+		seg.StripeSize = stripeSize * sectorSize
+	}
+	stripes, _ := s["stripes"].([]any)
+	for i := 0; i+1 < len(stripes); i += 2 {
+		pvName, ok1 := stripes[i].(string)
+		start, ok2 := stripes[i+1].(int64)
+		entry, ok3 := vg.pvs[pvName]
+		if !ok1 || !ok2 || !ok3 {
+			return seg, fmt.Errorf("invalid stripe %v, %v", stripes[i], stripes[i+1])
+		}
+		seg.Stripes = append(seg.Stripes, Stripe{PhysicalVolume: entry.uuid, StartExtent: start})
+	}
+	if len(seg.Stripes) == 0 {
+		return seg, fmt.Errorf("no stripes")
+	}
+	if len(seg.Stripes) > 1 && seg.StripeSize <= 0 {
+		return seg, fmt.Errorf("no stripe_size for %d stripes", len(seg.Stripes))
+	}
+	return seg, nil
+}
@@ -0,0 +1,303 @@
+package lvm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/lvm"
+)
+
+const (
+	pvSize     = 40 * 1024 * 1024
+	extentSize = 4 * 1024 * 1024
+	peStart    = 1024 * 1024
+	mdaStart   = 4096
+	pv0UUID    = "aaaaaabbbbccccddddeeeeffffgggggg"
+	pv1UUID    = "hhhhhhiiiijjjjkkkkllllmmmmnnnnnn"
+)
+
+// metadata is the metadata of a group with a volume root in two linear segments on both
+// physical volumes, and a volume data striped on both in chunks of 64KiB
+const metadata = `vg0 {
+	id = "VGVGVG-0000-1111-2222-3333-4444-555555"
+	seqno = %d
+	format = "lvm2" # informational
+	status = ["RESIZEABLE", "READ", "WRITE"]
+	extent_size = 8192
+	max_lv = 0
+
+	physical_volumes {
+
+		pv0 {
+			id = "aaaaaa-bbbb-cccc-dddd-eeee-ffff-gggggg"
+			device = "/dev/vda"
+			status = ["ALLOCATABLE"]
+			dev_size = 81920
+			pe_start = 2048
+			pe_count = 9
+		}
+
+		pv1 {
+			id = "hhhhhh-iiii-jjjj-kkkk-llll-mmmm-nnnnnn"
+			device = "/dev/vdb"
+			status = ["ALLOCATABLE"]
+			dev_size = 81920
+			pe_start = 2048
+			pe_count = 9
+		}
+	}
+
+	logical_volumes {
+
+		root {
+			id = "LVLVLV-0000-1111-2222-3333-4444-555555"
+			status = ["READ", "WRITE", "VISIBLE"]
+			creation_host = "test"
+			segment_count = 2
+
+			segment1 {
+				start_extent = 0
+				extent_count = 6
+				type = "striped"
+				stripe_count = 1	# linear
+				stripes = [
+					"pv0", 0
+				]
+			}
+			segment2 {
+				start_extent = 6
+				extent_count = 3
+				type = "striped"
+				stripe_count = 1	# linear
+				stripes = [
+					"pv1", 0
+				]
+			}
+		}
+%s	}
+}
+# Generated by LVM2 version 2.03.16(2) (2022-05-18): Mon Oct 12 10:00:00 2026
+
+contents = "Text Format Volume Group"
+version = 1
+
+description = "Created *after* executing 'lvcreate -i 2 -I 64k -n data -l 4 vg0'"
+
+creation_host = "test"	# Linux test 6.1.0 #1 SMP x86_64
+creation_time = 1791799200	# Mon Oct 12 10:00:00 2026
+`
+
+const dataVolume = `
+		data {
+			id = "LVLVLV-6666-7777-8888-9999-aaaa-bbbbbb"
+			status = ["READ", "WRITE", "VISIBLE"]
+			segment_count = 1
+
+			segment1 {
+				start_extent = 0
+				extent_count = 4
+				type = "striped"
+				stripe_count = 2
+				stripe_size = 128
+				stripes = [
+					"pv0", 6,
+					"pv1", 3
+				]
+			}
+		}
+`
+
+func checksum(b []byte) uint32 {
+	return ^crc32.Update(^uint32(0xf597a6cf), crc32.IEEETable, b)
+}
+
+// physicalVolume returns a physical volume with the label in its second sector, as pvcreate
+// writes it, and the metadata in its metadata area after the first 4KiB
+func physicalVolume(uuid, text string) []byte {
+	b := make([]byte, pvSize)
+	label := b[512:1024]
+	copy(label[0:8], "LABELONE")
+	binary.LittleEndian.PutUint64(label[8:16], 1)
+	binary.LittleEndian.PutUint32(label[20:24], 32)
+	copy(label[24:32], "LVM2 001")
+	h := label[32:]
+	copy(h[0:32], uuid)
+	binary.LittleEndian.PutUint64(h[32:40], pvSize)
+	// the data area, then the metadata area, each list ending with zeroes
+	binary.LittleEndian.PutUint64(h[40:48], peStart)
+	binary.LittleEndian.PutUint64(h[72:80], mdaStart)
+	binary.LittleEndian.PutUint64(h[80:88], peStart-mdaStart)
+	binary.LittleEndian.PutUint32(label[16:20], checksum(label[20:]))
+
+	mda := b[mdaStart : mdaStart+512]
+	copy(mda[4:20], " LVM2 x[5A%r0N*>")
+	binary.LittleEndian.PutUint32(mda[20:24], 1)
+	binary.LittleEndian.PutUint64(mda[24:32], mdaStart)
+	binary.LittleEndian.PutUint64(mda[32:40], peStart-mdaStart)
+	binary.LittleEndian.PutUint64(mda[40:48], 512)
+	binary.LittleEndian.PutUint64(mda[48:56], uint64(len(text)))
+	binary.LittleEndian.PutUint32(mda[56:60], checksum([]byte(text)))
+	binary.LittleEndian.PutUint32(mda[0:4], checksum(mda[4:]))
+	copy(b[mdaStart+512:], text)
+	return b
+}
+
+func scan(t *testing.T, images ...[]byte) []*lvm.VolumeGroup {
+	t.Helper()
+	var disks []*disk.Disk
+	for _, image := range images {
+		d, err := diskfs.OpenBackend(mem.New(image, false), diskfs.WithOpenMode(diskfs.ReadWrite))
+		if err != nil {
+			t.Fatalf("error opening disk: %v", err)
+		}
+		disks = append(disks, d)
+	}
+	vgs, err := lvm.Scan(disks...)
+	if err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	return vgs
+}
+
+func TestScan(t *testing.T) {
+	// the second physical volume has older metadata, from before data was created
+	pv0 := physicalVolume(pv0UUID, fmt.Sprintf(metadata, 3, dataVolume))
+	pv1 := physicalVolume(pv1UUID, fmt.Sprintf(metadata, 2, ""))
+	vgs := scan(t, pv0, pv1, make([]byte, 1024*1024))
+	if len(vgs) != 1 {
+		t.Fatalf("%d volume groups instead of 1", len(vgs))
+	}
+	vg := vgs[0]
+	if vg.Name != "vg0" || vg.SeqNo != 3 || vg.ExtentSize != extentSize || len(vg.PhysicalVolumes) != 2 || len(vg.Missing) != 0 {
+		t.Fatalf("unexpected volume group %+v", vg)
+	}
+	if vg.PhysicalVolumes[0].UUID != "aaaaaa-bbbb-cccc-dddd-eeee-ffff-gggggg" || vg.PhysicalVolumes[0].Size != pvSize {
+		t.Errorf("unexpected physical volume %+v", vg.PhysicalVolumes[0])
+	}
+	if len(vg.LogicalVolumes) != 2 || vg.LogicalVolumes[0].Name != "data" || vg.LogicalVolumes[1].Name != "root" {
+		t.Fatalf("unexpected logical volumes %+v", vg.LogicalVolumes)
+	}
+
+	t.Run("linear", func(t *testing.T) {
+		root, _ := vg.LogicalVolume("root")
+		if root.Size != 9*extentSize || !root.Visible() || len(root.Segments) != 2 {
+			t.Fatalf("unexpected logical volume %+v", root)
+		}
+		b, err := root.Open()
+		if err != nil {
+			t.Fatalf("error opening volume: %v", err)
+		}
+		w, err := b.Writable()
+		if err != nil {
+			t.Fatalf("error getting writable volume: %v", err)
+		}
+		// across the end of the first segment
+		if _, err := w.WriteAt([]byte("abcdefgh"), 6*extentSize-4); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		if got := pv0[peStart+6*extentSize-4 : peStart+6*extentSize]; string(got) != "abcd" {
+			t.Errorf("%q at the end of the first segment instead of abcd", got)
+		}
+		if got := pv1[peStart : peStart+4]; string(got) != "efgh" {
+			t.Errorf("%q at the start of the second segment instead of efgh", got)
+		}
+		if _, err := b.ReadAt(make([]byte, 1), root.Size); err != io.EOF {
+			t.Errorf("error %v reading beyond the end instead of EOF", err)
+		}
+	})
+
+	t.Run("striped", func(t *testing.T) {
+		data, _ := vg.LogicalVolume("data")
+		b, err := data.Open()
+		if err != nil {
+			t.Fatalf("error opening volume: %v", err)
+		}
+		w, err := b.Writable()
+		if err != nil {
+			t.Fatalf("error getting writable volume: %v", err)
+		}
+		// the first chunks are on pv0, pv1 and pv0 again
+		const chunk = 64 * 1024
+		content := bytes.Repeat([]byte("0123456789abcdef"), 3*chunk/16)
+		if _, err := w.WriteAt(content, 0); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		on0 := pv0[peStart+6*extentSize:]
+		on1 := pv1[peStart+3*extentSize:]
+		if !bytes.Equal(on0[:chunk], content[:chunk]) || !bytes.Equal(on1[:chunk], content[chunk:2*chunk]) || !bytes.Equal(on0[chunk:2*chunk], content[2*chunk:]) {
+			t.Error("chunks not striped on the physical volumes")
+		}
+		read := make([]byte, len(content))
+		if _, err := b.ReadAt(read, 0); err != nil {
+			t.Fatalf("error reading: %v", err)
+		}
+		if !bytes.Equal(read, content) {
+			t.Error("read content different from written content")
+		}
+	})
+}
+
+func TestFilesystem(t *testing.T) {
+	pv0 := physicalVolume(pv0UUID, fmt.Sprintf(metadata, 1, dataVolume))
+	pv1 := physicalVolume(pv1UUID, fmt.Sprintf(metadata, 1, dataVolume))
+	root, _ := scan(t, pv0, pv1)[0].LogicalVolume("root")
+	b, err := root.Open()
+	if err != nil {
+		t.Fatalf("error opening volume: %v", err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening volume as disk: %v", err)
+	}
+	fs, err := d.CreateFilesystem(disk.FormatSpec{FSType: filesystem.TypeFat32, VolumeLabel: "ROOT"})
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/hostname", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("server\n")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+
+	// the filesystem as found on the physical volumes
+	root, _ = scan(t, pv0, pv1)[0].LogicalVolume("root")
+	if b, err = root.Open(); err != nil {
+		t.Fatalf("error opening volume: %v", err)
+	}
+	if d, err = diskfs.OpenBackend(b); err != nil {
+		t.Fatalf("error opening volume as disk: %v", err)
+	}
+	if fs, err = d.GetFilesystem(0); err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	if f, err = fs.OpenFile("/hostname", os.O_RDONLY); err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	content, err := io.ReadAll(f)
+	if err != nil || string(content) != "server\n" {
+		t.Errorf("file content %q, %v instead of server", content, err)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	pv0 := physicalVolume(pv0UUID, fmt.Sprintf(metadata, 1, dataVolume))
+	vg := scan(t, pv0)[0]
+	if len(vg.Missing) != 1 || vg.Missing[0] != "hhhhhh-iiii-jjjj-kkkk-llll-mmmm-nnnnnn" {
+		t.Errorf("missing physical volumes %v instead of pv1", vg.Missing)
+	}
+	root, _ := vg.LogicalVolume("root")
+	if _, err := root.Open(); err == nil {
+		t.Error("no error opening a volume on a missing physical volume")
+	}
+}
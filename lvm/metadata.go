@@ -0,0 +1,216 @@
+package lvm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// section is a section of the metadata of a volume group, with its values and subsections by name.
+// Values are an int64, a string or a []any of them.
+type section map[string]any
+
+// parseMetadata parses the text of the metadata of a volume group, in the configuration format
+// of LVM:
+//
+//	vg0 {
+//		id = "..."
+//		extent_size = 8192
+//		status = ["READ", "WRITE"]
+//		...
+//	}
+func parseMetadata(text string) (section, error) {
+	p := &parser{text: text}
+	s, err := p.section()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != "" {
+		return nil, p.errorf("unexpected %q", p.token)
+	}
+	return s, nil
+}
+
+// parser parses the metadata token by token
+type parser struct {
+	text  string
+	pos   int
+	token string
+	// peeked is whether token is a token read ahead, and not yet consumed
+	peeked bool
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	line := strings.Count(p.text[:p.pos], "\n") + 1
+	return fmt.Errorf("invalid metadata at line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// next returns the next token: a punctuation character, a quoted string with its quotes, a name
+// or a number, or "" at the end
+func (p *parser) next() string {
+	if p.peeked {
+		p.peeked = false
+		return p.token
+	}
+	for p.pos < len(p.text) {
+		c := p.text[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.text) && p.text[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.IndexByte("{}[]=,", c) >= 0:
+			p.pos++
+			p.token = string(c)
+			return p.token
+		case c == '"':
+			start := p.pos
+			for p.pos++; p.pos < len(p.text) && p.text[p.pos] != '"'; p.pos++ {
+				if p.text[p.pos] == '\\' {
+					p.pos++
+				}
+			}
+			p.pos++
+			p.token = p.text[start:min(p.pos, len(p.text))]
+			return p.token
+		default:
+			start := p.pos
+			for p.pos < len(p.text) && strings.IndexByte(" \t\r\n#{}[]=,\"", p.text[p.pos]) < 0 {
+				p.pos++
+			}
+			p.token = p.text[start:p.pos]
+			return p.token
+		}
+	}
+	p.token = ""
+	return ""
+}
+
+func (p *parser) peek() string {
+	t := p.next()
+	p.peeked = true
+	return t
+}
+
+// section parses the values and subsections until a closing brace or the end
+func (p *parser) section() (section, error) {
+	s := section{}
+	for {
+		name := p.peek()
+		if name == "" || name == "}" {
+			return s, nil
+		}
+		p.next()
+		switch op := p.next(); op {
+		case "{":
+			sub, err := p.section()
+			if err != nil {
+				return nil, err
+			}
+			if p.next() != "}" {
+				return nil, p.errorf("section %s not closed", name)
+			}
+			s[name] = sub
+		case "=":
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			s[name] = v
+		default:
+			return nil, p.errorf("unexpected %q after %s", op, name)
+		}
+	}
+}
+
+// value parses a number, a string or an array of them
+func (p *parser) value() (any, error) {
+	t := p.next()
+	switch {
+	case t == "[":
+		values := []any{}
+		for {
+			if p.peek() == "]" {
+				p.next()
+				return values, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			switch sep := p.next(); sep {
+			case ",":
+			case "]":
+				return values, nil
+			default:
+				return nil, p.errorf("unexpected %q in array", sep)
+			}
+		}
+	case strings.HasPrefix(t, `"`):
+		if len(t) < 2 || !strings.HasSuffix(t, `"`) {
+			return nil, p.errorf("string not terminated")
+		}
+		return unquote(t[1 : len(t)-1]), nil
+	default:
+		n, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			// floats are only in settings of no interest here
+			if _, err := strconv.ParseFloat(t, 64); err == nil {
+				return t, nil
+			}
+			return nil, p.errorf("invalid value %q", t)
+		}
+		return n, nil
+	}
+}
+
+// unquote removes the backslashes escaping characters of a string
+func unquote(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (s section) section(name string) (section, bool) {
+	sub, ok := s[name].(section)
+	return sub, ok
+}
+
+func (s section) int(name string) (int64, error) {
+	n, ok := s[name].(int64)
+	if !ok {
+		return 0, fmt.Errorf("no number %s", name)
+	}
+	return n, nil
+}
+
+func (s section) string(name string) (string, error) {
+	v, ok := s[name].(string)
+	if !ok {
+		return "", fmt.Errorf("no string %s", name)
+	}
+	return v, nil
+}
+
+// strings returns the strings of an array, such as the flags of status
+func (s section) strings(name string) []string {
+	values, _ := s[name].([]any)
+	var strs []string
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,112 @@
+package lvm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// Open returns the storage of the logical volume, which can be opened with diskfs.OpenBackend.
+// It is writable if all of its physical volumes are. It returns an error if some of its physical
+// volumes are missing, or if it is not linear or striped.
+func (lv *LogicalVolume) Open() (backend.Storage, error) {
+	v := &volume{lv: lv, files: map[*PhysicalVolume]io.WriterAt{}}
+	writable := true
+	for _, seg := range lv.Segments {
+		if seg.Type != "striped" {
+			return nil, fmt.Errorf("logical volume %s has a segment of type %s, which is not supported", lv.Name, seg.Type)
+		}
+		for _, stripe := range seg.Stripes {
+			entry := lv.vg.entry(stripe.PhysicalVolume)
+			if entry == nil || entry.pv == nil {
+				return nil, fmt.Errorf("logical volume %s is on the missing physical volume %s", lv.Name, stripe.PhysicalVolume)
+			}
+			if _, ok := v.files[entry.pv]; ok || !writable {
+				continue
+			}
+			w, err := entry.pv.storage.Writable()
+			if err != nil {
+				writable = false
+				continue
+			}
+			v.files[entry.pv] = w
+		}
+	}
+	if !writable {
+		return backend.FromReaderAt(v, lv.Size), nil
+	}
+	return backend.FromReadWriterAt(v, lv.Size), nil
+}
+
+// entry returns the physical volume of the group with the UUID
+func (vg *VolumeGroup) entry(uuid string) *pvEntry {
+	for _, entry := range vg.pvs {
+		if entry.uuid == uuid {
+			return entry
+		}
+	}
+	return nil
+}
+
+// volume maps the reads and writes of a logical volume to its physical volumes
+type volume struct {
+	lv *LogicalVolume
+	// files are the writable files of the physical volumes
+	files map[*PhysicalVolume]io.WriterAt
+}
+
+func (v *volume) ReadAt(p []byte, off int64) (int, error) {
+	return v.do(p, off, false)
+}
+
+func (v *volume) WriteAt(p []byte, off int64) (int, error) {
+	return v.do(p, off, true)
+}
+
+// do reads or writes p at off, chunk by chunk on the physical volumes
+func (v *volume) do(p []byte, off int64, write bool) (int, error) {
+	n := 0
+	for n < len(p) {
+		entry, pvOffset, length, err := v.locate(off + int64(n))
+		if err != nil {
+			return n, err
+		}
+		chunk := p[n : n+int(min(length, int64(len(p)-n)))]
+		var m int
+		if write {
+			m, err = v.files[entry.pv].WriteAt(chunk, entry.pv.start+pvOffset)
+		} else {
+			m, err = entry.pv.storage.ReadAt(chunk, entry.pv.start+pvOffset)
+		}
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// locate returns the physical volume and offset on it of the offset of the volume, and the
+// number of bytes that follow it there
+func (v *volume) locate(off int64) (entry *pvEntry, pvOffset, length int64, err error) {
+	extentSize := v.lv.vg.ExtentSize
+	for _, seg := range v.lv.Segments {
+		segStart, segEnd := seg.StartExtent*extentSize, (seg.StartExtent+seg.ExtentCount)*extentSize
+		if off < segStart || off >= segEnd {
+			continue
+		}
+		segOffset := off - segStart
+		stripe, stripeOffset, length := seg.Stripes[0], segOffset, segEnd-off
+		if n := int64(len(seg.Stripes)); n > 1 {
+			// chunks of StripeSize go to each stripe in turn
+			chunk := segOffset / seg.StripeSize
+			stripe = seg.Stripes[chunk%n]
+			stripeOffset = chunk/n*seg.StripeSize + segOffset%seg.StripeSize
+			length = seg.StripeSize - segOffset%seg.StripeSize
+		}
+		entry = v.lv.vg.entry(stripe.PhysicalVolume)
+		return entry, entry.peStart + stripe.StartExtent*extentSize + stripeOffset, length, nil
+	}
+	return nil, 0, 0, fmt.Errorf("offset %d of logical volume %s is in no segment", off, v.lv.Name)
+}
@@ -0,0 +1,178 @@
+// Package md detects the members of Linux software RAID arrays, from their md superblocks of
+// version 0.90 or 1.x, and gives access to the data of RAID1 arrays, as made by many NAS, as disks
+// whose filesystems can be opened like any other:
+//
+//	arrays, err := md.Scan(d0, d1)
+//	b, err := arrays[0].Open()
+//	array, err := diskfs.OpenBackend(b)
+//	fs, err := array.GetFilesystem(0)
+//
+// The arrays are only read, since writing to their members without updating their superblocks
+// would leave them out of sync. Other levels are listed, but cannot be opened.
+package md
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+// roles of members that are not in a slot of their array
+const (
+	RoleSpare  = -1
+	RoleFaulty = -2
+)
+
+// Member is a disk or partition that is a member of an array, found by its superblock
+type Member struct {
+	// Version is the version of the superblock, "0.90", "1.0", "1.1" or "1.2"
+	Version string
+	// ArrayUUID is the UUID of the array, in the form mdadm reports it
+	ArrayUUID string
+	// Name is the name of the array, often prefixed with the name of its host, or "" for 0.90
+	Name string
+	// Level is the RAID level of the array, e.g. 1 for RAID1, or -1 for linear arrays
+	Level int
+	// RaidDisks is the number of slots of the array
+	RaidDisks int
+	// Role is the slot of the member in the array from 0, or RoleSpare or RoleFaulty
+	Role int
+	// DeviceUUID is the UUID of the member, or "" for 0.90
+	DeviceUUID string
+	// Events counts the updates of the superblock, those of members that missed some are lower
+	Events uint64
+	// DataOffset is the offset in bytes of the data of the array on the member, and Size its size
+	DataOffset int64
+	Size       int64
+	// Recovering is whether the member is being rebuilt, so only some of its data is in sync
+	Recovering bool
+	// storage and start are where the member is
+	storage backend.Storage
+	start   int64
+}
+
+// Array is a RAID array, assembled from the superblocks of its members
+type Array struct {
+	UUID      string
+	Name      string
+	Level     int
+	RaidDisks int
+	// Events is the most recent count of updates of the superblocks of the members
+	Events uint64
+	// Members are the members of the array found, by role, with the spares and faulty ones last
+	Members []*Member
+	// Missing are the slots of the array without an up to date member
+	Missing []int
+}
+
+// ReadMember reads the superblock of the member of size bytes at start in the storage, e.g. a
+// partition of a disk.Disk. It returns an error that is ErrNoSuperblock if there is none.
+func ReadMember(b backend.Storage, start, size int64) (*Member, error) {
+	m, err := readSuperblock(io.NewSectionReader(b, start, size), size)
+	if err != nil {
+		return nil, err
+	}
+	if m.DataOffset+m.Size > size {
+		return nil, fmt.Errorf("data of %d bytes at %d of member is beyond its end at %d", m.Size, m.DataOffset, size)
+	}
+	m.storage, m.start = b, start
+	return m, nil
+}
+
+// Open returns the storage of the data of the array on the member, which is read-only. For
+// RAID1 arrays, it is that of the array.
+func (m *Member) Open() backend.Storage {
+	return backend.FromReaderAt(io.NewSectionReader(m.storage, m.start+m.DataOffset, m.Size), m.Size)
+}
+
+// inSync returns whether the member has all of the data of its slot in the array
+func (m *Member) inSync(events uint64) bool {
+	return m.Role >= 0 && !m.Recovering && m.Events == events
+}
+
+// Scan finds the members of arrays on the disks, on each of their partitions or on the whole of
+// those without a partition table, and assembles their arrays
+func Scan(disks ...*disk.Disk) ([]*Array, error) {
+	var members []*Member
+	for _, d := range disks {
+		ranges := [][2]int64{{0, d.Size}}
+		if d.Table != nil {
+			ranges = nil
+			for _, p := range d.Table.GetPartitions() {
+				if p.GetSize() > 0 {
+					ranges = append(ranges, [2]int64{p.GetStart(), p.GetSize()})
+				}
+			}
+		}
+		for _, r := range ranges {
+			m, err := ReadMember(d.Backend, r[0], r[1])
+			if errors.Is(err, ErrNoSuperblock) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, m)
+		}
+	}
+	return Assemble(members), nil
+}
+
+// Assemble assembles the arrays of the members, described by the most recent superblock of each,
+// sorted by UUID
+func Assemble(members []*Member) []*Array {
+	byUUID := map[string]*Array{}
+	for _, m := range members {
+		a, ok := byUUID[m.ArrayUUID]
+		if !ok {
+			a = &Array{UUID: m.ArrayUUID}
+			byUUID[m.ArrayUUID] = a
+		}
+		if !ok || m.Events > a.Events {
+			a.Name, a.Level, a.RaidDisks, a.Events = m.Name, m.Level, m.RaidDisks, m.Events
+		}
+		a.Members = append(a.Members, m)
+	}
+	arrays := make([]*Array, 0, len(byUUID))
+	for _, a := range byUUID {
+		slices.SortStableFunc(a.Members, func(x, y *Member) int { return cmp.Compare(order(x.Role), order(y.Role)) })
+		for slot := 0; slot < a.RaidDisks; slot++ {
+			if !slices.ContainsFunc(a.Members, func(m *Member) bool { return m.Role == slot && m.inSync(a.Events) }) {
+				a.Missing = append(a.Missing, slot)
+			}
+		}
+		arrays = append(arrays, a)
+	}
+	slices.SortFunc(arrays, func(x, y *Array) int { return strings.Compare(x.UUID, y.UUID) })
+	return arrays
+}
+
+// order returns the order of a role, the slots first, then the spares and the faulty members
+func order(role int) int {
+	if role < 0 {
+		return math.MaxInt + role
+	}
+	return role
+}
+
+// Open returns the read-only storage of the data of a RAID1 array, which can be opened with
+// diskfs.OpenBackend, from one of its up to date members. It returns an error if the array is of
+// another level, or if none of its members is up to date.
+func (a *Array) Open() (backend.Storage, error) {
+	if a.Level != 1 {
+		return nil, fmt.Errorf("array %s is of level %d, only RAID1 is supported", a.UUID, a.Level)
+	}
+	for _, m := range a.Members {
+		if m.inSync(a.Events) {
+			return m.Open(), nil
+		}
+	}
+	return nil, fmt.Errorf("array %s has no up to date member of %d", a.UUID, len(a.Members))
+}
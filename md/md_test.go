@@ -0,0 +1,256 @@
+package md_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/md"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+const (
+	memberSize = 24 * 1024 * 1024
+	dataSize   = 20 * 1024 * 1024
+	dataOffset = 1024 * 1024
+	arrayUUID  = "01234567:89abcdef:01234567:89abcdef"
+	content    = "hello from the NAS"
+)
+
+var rawUUID = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+// data returns a FAT32 filesystem of dataSize bytes with a file
+func data(t *testing.T) []byte {
+	t.Helper()
+	b, err := mem.Create(dataSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fat32.Create(b, dataSize, 0, 512, "NAS")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	return b.Bytes()
+}
+
+// checksum returns the sum of the little endian 32 bit words of b, and of a last 16 bit one
+func checksum(b []byte) uint32 {
+	var sum uint64
+	for i := 0; i+4 <= len(b); i += 4 {
+		sum += uint64(binary.LittleEndian.Uint32(b[i:]))
+	}
+	if len(b)%4 == 2 {
+		sum += uint64(binary.LittleEndian.Uint16(b[len(b)-2:]))
+	}
+	return uint32(sum&0xffffffff + sum>>32)
+}
+
+// member returns a member of a RAID1 array of 2 slots with the data, in the role, 0xffff for a
+// spare, with a superblock of the version
+func member(version string, number int, role uint16, events uint64, d []byte) []byte {
+	b := make([]byte, memberSize)
+	if version == "0.90" {
+		copy(b, d)
+		sb := b[memberSize-64*1024:][:4096]
+		word := func(i int, v uint32) { binary.LittleEndian.PutUint32(sb[4*i:], v) }
+		word(0, 0xa92b4efc)
+		word(2, 90)
+		word(5, 0x01234567)
+		word(7, 1)
+		word(8, dataSize/1024)
+		word(9, 2)
+		word(10, 2)
+		word(13, 0x89abcdef)
+		word(14, 0x01234567)
+		word(15, 0x89abcdef)
+		word(39, uint32(events))
+		word(40, uint32(events>>32))
+		word(992, uint32(number))
+		if role != 0xffff {
+			word(992+3, uint32(role))
+			word(992+4, 1<<1|1<<2)
+		}
+		word(38, checksum(sb))
+		return b
+	}
+
+	offset, start := int64(4096), int64(dataOffset)
+	switch version {
+	case "1.1":
+		offset = 0
+	case "1.0":
+		offset, start = memberSize-16*512, 0
+	}
+	copy(b[start:], d)
+	sb := b[offset : offset+4096]
+	le := binary.LittleEndian
+	le.PutUint32(sb[0:], 0xa92b4efc)
+	le.PutUint32(sb[4:], 1)
+	copy(sb[16:], rawUUID)
+	copy(sb[32:], "nas:0")
+	le.PutUint32(sb[72:], 1)
+	le.PutUint64(sb[80:], dataSize/512)
+	le.PutUint32(sb[92:], 2)
+	le.PutUint64(sb[128:], uint64(start/512))
+	le.PutUint64(sb[136:], uint64((memberSize-start-64*1024)/512))
+	le.PutUint64(sb[144:], uint64(offset/512))
+	le.PutUint32(sb[160:], uint32(number))
+	sb[168] = byte(number)
+	le.PutUint64(sb[200:], events)
+	le.PutUint32(sb[220:], 3)
+	for i := 0; i < 3; i++ {
+		le.PutUint16(sb[256+2*i:], 0xffff)
+	}
+	le.PutUint16(sb[256+2*number:], role)
+	le.PutUint32(sb[216:], checksum(sb[:256+2*3]))
+	return b
+}
+
+// readFile reads the file of the filesystem on the storage of the array
+func readFile(t *testing.T, a *md.Array) {
+	t.Helper()
+	b, err := a.Open()
+	if err != nil {
+		t.Fatalf("error opening array: %v", err)
+	}
+	d, err := diskfs.OpenBackend(b)
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	fs, err := d.GetFilesystem(0)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	f, err := fs.OpenFile("/hello.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	read, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if string(read) != content {
+		t.Errorf("read %q instead of %q", read, content)
+	}
+}
+
+func TestRAID1(t *testing.T) {
+	d := data(t)
+	for _, version := range []string{"0.90", "1.0", "1.1", "1.2"} {
+		t.Run(version, func(t *testing.T) {
+			var members []*md.Member
+			for i, role := range []uint16{1, 0, 0xffff} {
+				m, err := md.ReadMember(mem.New(member(version, i, role, 42, d), true), 0, memberSize)
+				if err != nil {
+					t.Fatalf("error reading member %d: %v", i, err)
+				}
+				if m.Version != version || m.ArrayUUID != arrayUUID || m.Level != 1 || m.Events != 42 || m.Size != dataSize {
+					t.Errorf("member %d is %+v", i, m)
+				}
+				members = append(members, m)
+			}
+			arrays := md.Assemble(members)
+			if len(arrays) != 1 {
+				t.Fatalf("assembled %d arrays instead of 1", len(arrays))
+			}
+			a := arrays[0]
+			var roles []int
+			for _, m := range a.Members {
+				roles = append(roles, m.Role)
+			}
+			if !slices.Equal(roles, []int{0, 1, md.RoleSpare}) || len(a.Missing) != 0 || a.RaidDisks != 2 {
+				t.Errorf("array has roles %v and missing slots %v", roles, a.Missing)
+			}
+			readFile(t, a)
+		})
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	d := data(t)
+	var members []*md.Member
+	// the member of slot 0 missed the last updates, while the other is faulty
+	for i, role := range []uint16{0, 1, 0xfffe} {
+		events := uint64(42)
+		if i == 0 {
+			events = 40
+		}
+		m, err := md.ReadMember(mem.New(member("1.2", i, role, events, d), true), 0, memberSize)
+		if err != nil {
+			t.Fatalf("error reading member %d: %v", i, err)
+		}
+		members = append(members, m)
+	}
+	a := md.Assemble(members)[0]
+	if !slices.Equal(a.Missing, []int{0}) || a.Events != 42 || a.Members[2].Role != md.RoleFaulty {
+		t.Errorf("array with missing slots %v, %d events and last role %d", a.Missing, a.Events, a.Members[2].Role)
+	}
+	readFile(t, a)
+
+	a = md.Assemble(members[:1])[0]
+	a.Level = 5
+	if _, err := a.Open(); err == nil {
+		t.Errorf("opened an array of level 5")
+	}
+}
+
+func TestScan(t *testing.T) {
+	d := data(t)
+	var disks []*disk.Disk
+	for i := 0; i < 2; i++ {
+		b, err := mem.Create(memberSize + 2*1024*1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dsk, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+		if err != nil {
+			t.Fatalf("error opening disk: %v", err)
+		}
+		table := &gpt.Table{
+			ProtectiveMBR: true,
+			Partitions: []*gpt.Partition{
+				{Start: 2048, End: 2048 + memberSize/512 - 1, Type: gpt.LinuxRAID, Name: "raid"},
+			},
+		}
+		if err := dsk.Partition(table); err != nil {
+			t.Fatalf("error partitioning disk: %v", err)
+		}
+		if _, err := b.WriteAt(member("1.2", i, uint16(i), 7, d), 2048*512); err != nil {
+			t.Fatalf("error writing member: %v", err)
+		}
+		disks = append(disks, dsk)
+	}
+	arrays, err := md.Scan(disks...)
+	if err != nil {
+		t.Fatalf("error scanning: %v", err)
+	}
+	if len(arrays) != 1 || len(arrays[0].Members) != 2 || arrays[0].Name != "nas:0" {
+		t.Fatalf("found arrays %+v", arrays)
+	}
+	readFile(t, arrays[0])
+}
+
+func TestNoSuperblock(t *testing.T) {
+	if _, err := md.ReadMember(mem.New(make([]byte, memberSize), true), 0, memberSize); !errors.Is(err, md.ErrNoSuperblock) {
+		t.Errorf("error %v instead of %v", err, md.ErrNoSuperblock)
+	}
+	b := member("1.2", 0, 0, 1, nil)
+	b[4096+200]++
+	if _, err := md.ReadMember(mem.New(b, true), 0, memberSize); err == nil || errors.Is(err, md.ErrNoSuperblock) {
+		t.Errorf("error %v instead of an invalid checksum", err)
+	}
+}
@@ -0,0 +1,193 @@
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	magic = 0xa92b4efc
+	// sb0Reserved is the space at the end of a device in which the 0.90 superblock is, aligned
+	sb0Reserved = 64 * 1024
+	sb0Size     = 4096
+	// sb1Size is the size of the 1.x superblock read, which has the roles of up to 1920 devices
+	sb1Size = 4096
+	// featureRecoveryOffset is set in the 1.x superblock of a device being rebuilt
+	featureRecoveryOffset = 0x2
+	// roles of 1.x devices that are not in a slot of the array
+	roleSpare  = 0xffff
+	roleFaulty = 0xfffe
+	// bits of the state of a 0.90 device
+	diskFaulty = 1 << 0
+	diskSync   = 1 << 2
+)
+
+// ErrNoSuperblock is returned by ReadMember where there is no md superblock
+var ErrNoSuperblock = errors.New("no md superblock")
+
+// readSuperblock reads the superblock of any version on the device of size bytes, trying the
+// versions at its start before those at its end, where one may be left over
+func readSuperblock(r io.ReaderAt, size int64) (*Member, error) {
+	for _, version := range []string{"1.2", "1.1", "1.0"} {
+		offset := int64(0)
+		switch version {
+		case "1.2":
+			offset = 4096
+		case "1.0":
+			offset = (size/512 - 16) &^ 7 * 512
+		}
+		if offset < 0 || offset+sb1Size > size {
+			continue
+		}
+		b := make([]byte, sb1Size)
+		if _, err := r.ReadAt(b, offset); err != nil {
+			return nil, fmt.Errorf("error reading superblock: %w", err)
+		}
+		if binary.LittleEndian.Uint32(b[0:4]) != magic {
+			continue
+		}
+		m, err := parseSuperblock1(b, offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s superblock: %w", version, err)
+		}
+		m.Version = version
+		return m, nil
+	}
+
+	offset := size&^(sb0Reserved-1) - sb0Reserved
+	if offset < 0 {
+		return nil, ErrNoSuperblock
+	}
+	b := make([]byte, sb0Size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("error reading superblock: %w", err)
+	}
+	if binary.LittleEndian.Uint32(b[0:4]) != magic {
+		return nil, ErrNoSuperblock
+	}
+	m, err := parseSuperblock0(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 0.90 superblock: %w", err)
+	}
+	return m, nil
+}
+
+// parseSuperblock1 parses a 1.x superblock, read at offset of the device
+func parseSuperblock1(b []byte, offset int64) (*Member, error) {
+	le := binary.LittleEndian
+	if major := le.Uint32(b[4:8]); major != 1 {
+		return nil, fmt.Errorf("major version %d", major)
+	}
+	maxDev := int(le.Uint32(b[220:224]))
+	if 256+2*maxDev > len(b) {
+		return nil, fmt.Errorf("too many devices %d", maxDev)
+	}
+	if sum, csum := checksum1(b[:256+2*maxDev]), le.Uint32(b[216:220]); sum != csum {
+		return nil, fmt.Errorf("checksum %08x instead of %08x", csum, sum)
+	}
+	if superOffset := int64(le.Uint64(b[144:152])) * 512; superOffset != offset {
+		return nil, fmt.Errorf("superblock at %d records its offset as %d", offset, superOffset)
+	}
+	m := &Member{
+		ArrayUUID:  formatUUID(b[16:32]),
+		Name:       name(b[32:64]),
+		Level:      int(int32(le.Uint32(b[72:76]))),
+		RaidDisks:  int(le.Uint32(b[92:96])),
+		DeviceUUID: formatUUID(b[168:184]),
+		Events:     le.Uint64(b[200:208]),
+		DataOffset: int64(le.Uint64(b[128:136])) * 512,
+		Size:       int64(le.Uint64(b[80:88])) * 512,
+		Recovering: le.Uint32(b[8:12])&featureRecoveryOffset != 0,
+		Role:       RoleSpare,
+	}
+	// some levels, e.g. linear, have no size of their own
+	if m.Size == 0 {
+		m.Size = int64(le.Uint64(b[136:144])) * 512
+	}
+	if number := int(le.Uint32(b[160:164])); number < maxDev {
+		switch role := le.Uint16(b[256+2*number:]); role {
+		case roleSpare:
+		case roleFaulty:
+			m.Role = RoleFaulty
+		default:
+			m.Role = int(role)
+		}
+	}
+	return m, nil
+}
+
+// parseSuperblock0 parses a 0.90 superblock, in 32 bit words, which are those of the machine
+// that wrote it, little endian for all but a few
+func parseSuperblock0(b []byte) (*Member, error) {
+	le := binary.LittleEndian
+	word := func(i int) uint32 { return le.Uint32(b[4*i:]) }
+	if major, minor := word(1), word(2); major != 0 || minor != 90 {
+		return nil, fmt.Errorf("version %d.%d", major, minor)
+	}
+	if sum, csum := checksum0(b), word(38); sum != csum {
+		return nil, fmt.Errorf("checksum %08x instead of %08x", csum, sum)
+	}
+	m := &Member{
+		Version:   "0.90",
+		ArrayUUID: fmt.Sprintf("%08x:%08x:%08x:%08x", word(5), word(13), word(14), word(15)),
+		Level:     int(int32(word(7))),
+		RaidDisks: int(word(10)),
+		Events:    uint64(word(40))<<32 | uint64(word(39)),
+		Size:      int64(word(8)) * 1024,
+		Role:      RoleSpare,
+	}
+	// the descriptor of this device, number, major, minor, raid_disk and state
+	const thisDisk = 992
+	state := word(thisDisk + 4)
+	switch {
+	case state&diskFaulty != 0:
+		m.Role = RoleFaulty
+	case state&diskSync != 0:
+		m.Role = int(word(thisDisk + 3))
+	}
+	return m, nil
+}
+
+// checksum1 returns the checksum of a 1.x superblock with its roles, the sum of its little
+// endian 32 bit words, and of a last 16 bit word, except for the checksum itself
+func checksum1(b []byte) uint32 {
+	var sum uint64
+	for i := 0; i+4 <= len(b); i += 4 {
+		if i != 216 {
+			sum += uint64(binary.LittleEndian.Uint32(b[i:]))
+		}
+	}
+	if len(b)%4 == 2 {
+		sum += uint64(binary.LittleEndian.Uint16(b[len(b)-2:]))
+	}
+	return uint32(sum&0xffffffff + sum>>32)
+}
+
+// checksum0 returns the checksum of a 0.90 superblock, the sum of its 32 bit words except for the
+// checksum itself
+func checksum0(b []byte) uint32 {
+	var sum uint64
+	for i := 0; i < sb0Size; i += 4 {
+		if i != 4*38 {
+			sum += uint64(binary.LittleEndian.Uint32(b[i:]))
+		}
+	}
+	return uint32(sum&0xffffffff + sum>>32)
+}
+
+// formatUUID formats the 16 bytes of a UUID the way mdadm does, in 4 groups of 8 hex digits
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x:%x:%x:%x", b[0:4], b[4:8], b[8:12], b[12:16])
+}
+
+// name returns the name of an array, padded with zeros
+func name(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
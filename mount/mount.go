@@ -0,0 +1,59 @@
+// Package mount serves a filesystem.FileSystem with FUSE on Linux and macOS, so that the files of
+// an image can be browsed and changed with the usual tools, without a loop device or root.
+//
+//	fs, err := d.GetFilesystem(1)
+//	...
+//	srv, err := mount.Mount(fs, "/mnt/image")
+//	...
+//	defer srv.Unmount()
+//
+// Filesystems which cannot be changed, such as ISO9660 and squashfs images read from a disk, are
+// mounted read-only, and the others read-write unless WithReadOnly is given. Requests are served
+// one at a time, with the methods of the FileSystem.
+//
+// On Linux the filesystem is mounted with the mount system call, which needs CAP_SYS_ADMIN, or
+// else with the fusermount3 or fusermount command of libfuse. On macOS it is mounted with the
+// mount helper of macFUSE.
+package mount
+
+import (
+	"errors"
+	"strings"
+)
+
+type opts struct {
+	readOnly   bool
+	allowOther bool
+	fsName     string
+}
+
+// Opt func that process Mount options
+type Opt func(o *opts) error
+
+// WithReadOnly mounts the filesystem read-only, even if it can be changed
+func WithReadOnly() Opt {
+	return func(o *opts) error {
+		o.readOnly = true
+		return nil
+	}
+}
+
+// WithAllowOther lets other users than the one mounting the filesystem access it. With fusermount,
+// it must be allowed by user_allow_other in /etc/fuse.conf.
+func WithAllowOther() Opt {
+	return func(o *opts) error {
+		o.allowOther = true
+		return nil
+	}
+}
+
+// WithFSName sets the name of the source of the mount, shown in the mount table, "diskfs" if not set
+func WithFSName(name string) Opt {
+	return func(o *opts) error {
+		if name == "" || strings.ContainsAny(name, ", \x00") {
+			return errors.New("name of the source must not be empty, or contain commas or spaces")
+		}
+		o.fsName = name
+		return nil
+	}
+}
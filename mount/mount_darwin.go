@@ -0,0 +1,98 @@
+package mount
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// mount helpers of macFUSE, and of the older osxfuse
+var mountHelpers = []string{
+	"/Library/Filesystems/macfuse.fs/Contents/Resources/mount_macfuse",
+	"/Library/Filesystems/osxfuse.fs/Contents/Resources/mount_osxfuse",
+}
+
+// appendAttr appends the attributes of a file in the layout of macFUSE, with the creation time
+func appendAttr(b []byte, a *attr) []byte {
+	sec, nsec := timestamp(a.mtime)
+	b = ne.AppendUint64(b, a.ino)
+	b = ne.AppendUint64(b, a.size)
+	b = ne.AppendUint64(b, a.blocks)
+	for i := 0; i < 4; i++ { // atime, mtime, ctime and crtime
+		b = ne.AppendUint64(b, sec)
+	}
+	for i := 0; i < 4; i++ {
+		b = ne.AppendUint32(b, nsec)
+	}
+	b = ne.AppendUint32(b, a.mode)
+	b = ne.AppendUint32(b, a.nlink)
+	b = ne.AppendUint32(b, a.uid)
+	b = ne.AppendUint32(b, a.gid)
+	b = ne.AppendUint32(b, 0)    // rdev
+	return ne.AppendUint32(b, 0) // flags
+}
+
+// mount mounts the directory with the mount helper of macFUSE, which sends the device it opened,
+// and exits once the filesystem answered init, reporting on the channel returned
+func mount(dir string, o *opts) (int, <-chan error, error) {
+	var bin string
+	for _, helper := range mountHelpers {
+		if _, err := os.Stat(helper); err == nil {
+			bin = helper
+			break
+		}
+	}
+	if bin == "" {
+		return -1, nil, errors.New("mounting needs macFUSE, which is not installed")
+	}
+	options := []string{"fsname=" + o.fsName, "default_permissions", fmt.Sprintf("iosize=%d", maxWrite)}
+	if o.readOnly {
+		options = append(options, "ro")
+	}
+	if o.allowOther {
+		options = append(options, "allow_other")
+	}
+	local, remote, err := commSocket()
+	if err != nil {
+		return -1, nil, err
+	}
+	defer unix.Close(local)
+	defer remote.Close()
+	cmd := exec.Command(bin, "-o", strings.Join(options, ","), dir)
+	cmd.Env = append(os.Environ(), "_FUSE_CALL_BY_LIB=", "_FUSE_COMMFD=3", "_FUSE_COMMVERS=2", "_FUSE_DAEMON_PATH="+os.Args[0])
+	cmd.ExtraFiles = []*os.File{remote}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return -1, nil, fmt.Errorf("unable to run %s: %w", bin, err)
+	}
+	dev, err := receiveDevice(local)
+	if err != nil {
+		_ = cmd.Wait()
+		return -1, nil, err
+	}
+	ready := make(chan error, 1)
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			ready <- fmt.Errorf("unable to mount %s with %s: %w: %s", dir, bin, err, strings.TrimSpace(stderr.String()))
+			return
+		}
+		ready <- nil
+	}()
+	return dev, ready, nil
+}
+
+// pollHack does nothing, as the runtime does not poll the regular files of macOS
+func pollHack(_ string) error {
+	return nil
+}
+
+// unmount unmounts the directory with the unmount system call
+func unmount(dir string) error {
+	return unix.Unmount(dir, 0)
+}
@@ -0,0 +1,138 @@
+package mount
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// appendAttr appends the attributes of a file in the layout of Linux
+func appendAttr(b []byte, a *attr) []byte {
+	sec, nsec := timestamp(a.mtime)
+	b = ne.AppendUint64(b, a.ino)
+	b = ne.AppendUint64(b, a.size)
+	b = ne.AppendUint64(b, a.blocks)
+	for i := 0; i < 3; i++ { // atime, mtime and ctime
+		b = ne.AppendUint64(b, sec)
+	}
+	for i := 0; i < 3; i++ {
+		b = ne.AppendUint32(b, nsec)
+	}
+	b = ne.AppendUint32(b, a.mode)
+	b = ne.AppendUint32(b, a.nlink)
+	b = ne.AppendUint32(b, a.uid)
+	b = ne.AppendUint32(b, a.gid)
+	b = ne.AppendUint32(b, 0)    // rdev
+	b = ne.AppendUint32(b, 4096) // blksize
+	return ne.AppendUint32(b, 0) // flags
+}
+
+// mount mounts /dev/fuse on the directory with the mount system call, or with fusermount without
+// the privileges for it, and returns the device to serve
+func mount(dir string, o *opts) (int, <-chan error, error) {
+	ready := make(chan error, 1)
+	ready <- nil
+	dev, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, fmt.Errorf("unable to open /dev/fuse: %w", err)
+	}
+	flags := uintptr(unix.MS_NOSUID | unix.MS_NODEV)
+	if o.readOnly {
+		flags |= unix.MS_RDONLY
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=%o,user_id=%d,group_id=%d,default_permissions", dev, unix.S_IFDIR, os.Getuid(), os.Getgid())
+	if o.allowOther {
+		data += ",allow_other"
+	}
+	err = unix.Mount(o.fsName, dir, "fuse.diskfs", flags, data)
+	if err == nil {
+		return dev, ready, nil
+	}
+	unix.Close(dev)
+	if err != unix.EPERM {
+		return -1, nil, fmt.Errorf("unable to mount %s: %w", dir, err)
+	}
+	dev, err = fusermount(dir, o)
+	if err != nil {
+		return -1, nil, err
+	}
+	return dev, ready, nil
+}
+
+// pollHack opens and polls a file of the mount, to which the server replies that it does not poll.
+// The kernel then never asks again, as it would when the runtime adds a file opened with os.Open
+// to its poller: it waits for the reply without releasing its processor, so with GOMAXPROCS=1 the
+// server would never reply.
+func pollHack(dir string) error {
+	fd, err := unix.Open(filepath.Join(dir, pollHackPath), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open %s of the mount: %w", pollHackPath, err)
+	}
+	defer unix.Close(fd)
+	_, _ = unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, 0)
+	return nil
+}
+
+// fusermountBinary finds fusermount3 of libfuse 3, or fusermount of libfuse 2
+func fusermountBinary() (string, error) {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if bin, err := exec.LookPath(name); err == nil {
+			return bin, nil
+		}
+	}
+	return "", fmt.Errorf("mounting without CAP_SYS_ADMIN needs fusermount3 or fusermount: %w", unix.EPERM)
+}
+
+// fusermount mounts the directory with fusermount, which sends the device it opened
+func fusermount(dir string, o *opts) (int, error) {
+	bin, err := fusermountBinary()
+	if err != nil {
+		return -1, err
+	}
+	options := []string{"fsname=" + o.fsName, "subtype=diskfs", "default_permissions", "nosuid", "nodev"}
+	if o.readOnly {
+		options = append(options, "ro")
+	}
+	if o.allowOther {
+		options = append(options, "allow_other")
+	}
+	local, remote, err := commSocket()
+	if err != nil {
+		return -1, err
+	}
+	defer unix.Close(local)
+	cmd := exec.Command(bin, "-o", strings.Join(options, ","), "--", dir)
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.ExtraFiles = []*os.File{remote}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	remote.Close()
+	if err != nil {
+		return -1, fmt.Errorf("unable to mount %s with %s: %w: %s", dir, bin, err, strings.TrimSpace(stderr.String()))
+	}
+	return receiveDevice(local)
+}
+
+// unmount unmounts the directory with the umount system call, or with fusermount without the
+// privileges for it
+func unmount(dir string) error {
+	err := unix.Unmount(dir, 0)
+	if err == nil || !errors.Is(err, unix.EPERM) {
+		return err
+	}
+	bin, err := fusermountBinary()
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command(bin, "-u", "--", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", bin, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package mount_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/fstest"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/mount"
+	"golang.org/x/sys/unix"
+)
+
+const size = 20 * 1024 * 1024
+
+// mountFS mounts the filesystem on a temporary directory, skipping the test without FUSE
+func mountFS(t *testing.T, f filesystem.FileSystem, opts ...mount.Opt) *mount.Server {
+	t.Helper()
+	dir := t.TempDir()
+	srv, err := mount.Mount(f, dir, opts...)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		t.Skipf("unable to mount with FUSE: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("error mounting: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = srv.Unmount()
+	})
+	return srv
+}
+
+func TestReadWrite(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fat32.Create(b, size, 0, 512, "mount")
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(f, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	srv := mountFS(t, f)
+	dir := srv.Dir()
+
+	// every file of the tree is read back through the mount
+	for name, content := range fstest.Tree {
+		p := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			if info, err := os.Stat(p); err != nil || !info.IsDir() {
+				t.Errorf("%s: not a directory: %v", name, err)
+			}
+			continue
+		}
+		read, err := os.ReadFile(p)
+		if err != nil {
+			t.Errorf("error reading %s: %v", name, err)
+			continue
+		}
+		if string(read) != content {
+			t.Errorf("%s: read %d bytes instead of %d", name, len(read), len(content))
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "DIR1"))
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !slices.Equal(names, []string{"FILE1.TXT", "SUB"}) || entries[0].IsDir() || !entries[1].IsDir() {
+		t.Errorf("directory entries %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "MISSING.TXT")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error %v for missing file instead of %v", err, fs.ErrNotExist)
+	}
+
+	// changes through the mount
+	content := bytes.Repeat([]byte("written through FUSE\n"), 20000)
+	if err := os.Mkdir(filepath.Join(dir, "new directory"), 0o755); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new directory", "FILE.TXT"), content, 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.TXT"), []byte("replaced"), 0o644); err != nil {
+		t.Fatalf("error replacing file: %v", err)
+	}
+	if err := os.Rename(filepath.Join(dir, "new directory"), filepath.Join(dir, "renamed directory")); err != nil {
+		t.Fatalf("error renaming directory: %v", err)
+	}
+	if read, err := os.ReadFile(filepath.Join(dir, "renamed directory", "FILE.TXT")); err != nil || !bytes.Equal(read, content) {
+		t.Errorf("read %d bytes, %v from renamed directory instead of %d", len(read), err, len(content))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "removed file.txt"), content, 0o644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "removed file.txt")); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "removed directory"), 0o755); err != nil {
+		t.Fatalf("error creating directory: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "removed directory")); err != nil {
+		t.Fatalf("error removing directory: %v", err)
+	}
+	if err := srv.Unmount(); err != nil {
+		t.Fatalf("error unmounting: %v", err)
+	}
+
+	// the changes are in the filesystem
+	read, err := f.ReadFile("renamed directory/FILE.TXT")
+	if err != nil || !bytes.Equal(read, content) {
+		t.Errorf("read %d bytes, %v of file written through the mount instead of %d", len(read), err, len(content))
+	}
+	if read, err := f.ReadFile("README.TXT"); err != nil || string(read) != "replaced" {
+		t.Errorf("read %q, %v of file replaced through the mount", read, err)
+	}
+	for _, name := range []string{"new directory", "removed file.txt", "removed directory"} {
+		if _, err := f.Stat(name); err == nil {
+			t.Errorf("%s found after it was renamed or removed", name)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := squashfs.Create(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error creating filesystem: %v", err)
+	}
+	if err := fstest.Write(f, fstest.Tree); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("error finalizing filesystem: %v", err)
+	}
+	read, err := squashfs.Read(b, size, 0, 4096)
+	if err != nil {
+		t.Fatalf("error reading filesystem: %v", err)
+	}
+	dir := mountFS(t, read).Dir()
+
+	content, err := os.ReadFile(filepath.Join(dir, "DIR3", "LARGE.BIN"))
+	if err != nil || string(content) != fstest.Tree["DIR3/LARGE.BIN"] {
+		t.Errorf("read %d bytes, %v instead of %d", len(content), err, len(fstest.Tree["DIR3/LARGE.BIN"]))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "NEW.TXT"), []byte("new"), 0o644); !errors.Is(err, unix.EROFS) {
+		t.Errorf("error %v writing read-only mount instead of %v", err, unix.EROFS)
+	}
+	if err := os.Remove(filepath.Join(dir, "README.TXT")); !errors.Is(err, unix.EROFS) {
+		t.Errorf("error %v removing from read-only mount instead of %v", err, unix.EROFS)
+	}
+}
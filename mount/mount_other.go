@@ -0,0 +1,34 @@
+//go:build !linux && !darwin
+
+package mount
+
+import (
+	"errors"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+var errUnsupported = errors.New("FUSE mounts not supported on this platform")
+
+// Server serves a FileSystem mounted with FUSE, which is not supported on this platform
+type Server struct{}
+
+// Mount mounts the FileSystem with FUSE, which is not supported on this platform
+func Mount(_ filesystem.FileSystem, _ string, _ ...Opt) (*Server, error) {
+	return nil, errUnsupported
+}
+
+// Dir returns the directory the filesystem is mounted on
+func (s *Server) Dir() string {
+	return ""
+}
+
+// Wait waits until the filesystem is unmounted
+func (s *Server) Wait() error {
+	return errUnsupported
+}
+
+// Unmount unmounts the filesystem
+func (s *Server) Unmount() error {
+	return errUnsupported
+}
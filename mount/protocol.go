@@ -0,0 +1,189 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// constants and messages of the FUSE protocol, see
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/fuse.h
+
+const (
+	kernelVersion = 7
+	// kernelMinorVersion is the version of the messages sent, the kernel adapts to older ones
+	kernelMinorVersion = 31
+	// minorVersionInitOut is the first version with the init_out of 64 bytes, before it was 24
+	minorVersionInitOut = 23
+
+	rootID uint64 = 1
+
+	opLookup      uint32 = 1
+	opForget      uint32 = 2
+	opGetattr     uint32 = 3
+	opSetattr     uint32 = 4
+	opReadlink    uint32 = 5
+	opSymlink     uint32 = 6
+	opMknod       uint32 = 8
+	opMkdir       uint32 = 9
+	opUnlink      uint32 = 10
+	opRmdir       uint32 = 11
+	opRename      uint32 = 12
+	opLink        uint32 = 13
+	opOpen        uint32 = 14
+	opRead        uint32 = 15
+	opWrite       uint32 = 16
+	opStatfs      uint32 = 17
+	opRelease     uint32 = 18
+	opFsync       uint32 = 20
+	opFlush       uint32 = 25
+	opInit        uint32 = 26
+	opOpendir     uint32 = 27
+	opReaddir     uint32 = 28
+	opReleasedir  uint32 = 29
+	opFsyncdir    uint32 = 30
+	opAccess      uint32 = 34
+	opCreate      uint32 = 35
+	opInterrupt   uint32 = 36
+	opDestroy     uint32 = 38
+	opBatchForget uint32 = 42
+	opRename2     uint32 = 45
+
+	// flags of init
+	initAtomicOTrunc uint32 = 1 << 3
+	initBigWrites    uint32 = 1 << 5
+
+	// valid fields of setattr
+	setattrMode     uint32 = 1 << 0
+	setattrUID      uint32 = 1 << 1
+	setattrGID      uint32 = 1 << 2
+	setattrSize     uint32 = 1 << 3
+	setattrAtime    uint32 = 1 << 4
+	setattrMtime    uint32 = 1 << 5
+	setattrFh       uint32 = 1 << 6
+	setattrAtimeNow uint32 = 1 << 7
+	setattrMtimeNow uint32 = 1 << 8
+
+	// flags of rename2
+	renameNoReplace uint32 = 1 << 0
+	renameExchange  uint32 = 1 << 1
+
+	inHeaderSize = 40
+	// maxWrite is the most data written by one request, the kernel splits larger writes
+	maxWrite = 128 * 1024
+	// bufferSize holds the largest request, a write with its headers
+	bufferSize = maxWrite + 4096
+
+	// entryTimeout is how long the kernel caches names and attributes
+	entryTimeout = time.Second
+)
+
+var ne = binary.NativeEndian
+
+// inHeader is the header of every request from the kernel
+type inHeader struct {
+	length uint32
+	opcode uint32
+	unique uint64
+	nodeID uint64
+	uid    uint32
+	gid    uint32
+	pid    uint32
+}
+
+func parseInHeader(b []byte) inHeader {
+	return inHeader{
+		length: ne.Uint32(b[0:]),
+		opcode: ne.Uint32(b[4:]),
+		unique: ne.Uint64(b[8:]),
+		nodeID: ne.Uint64(b[16:]),
+		uid:    ne.Uint32(b[24:]),
+		gid:    ne.Uint32(b[28:]),
+		pid:    ne.Uint32(b[32:]),
+	}
+}
+
+// appendOutHeader appends the header of a reply to the request, negative errno for errors
+func appendOutHeader(b []byte, length int, errno int32, unique uint64) []byte {
+	b = ne.AppendUint32(b, uint32(16+length))
+	b = ne.AppendUint32(b, uint32(errno))
+	return ne.AppendUint64(b, unique)
+}
+
+// attr are the attributes of a file, encoded by appendAttr for the platform
+type attr struct {
+	ino    uint64
+	size   uint64
+	blocks uint64
+	mtime  time.Time
+	mode   uint32
+	nlink  uint32
+	uid    uint32
+	gid    uint32
+}
+
+// timestamp splits the time in seconds and nanoseconds for the kernel
+func timestamp(t time.Time) (sec uint64, nsec uint32) {
+	if t.IsZero() || t.Unix() < 0 {
+		return 0, 0
+	}
+	return uint64(t.Unix()), uint32(t.Nanosecond())
+}
+
+func splitTimeout(d time.Duration) (sec uint64, nsec uint32) {
+	return uint64(d / time.Second), uint32(d % time.Second)
+}
+
+func appendEntryOut(b []byte, nodeID uint64, a *attr) []byte {
+	sec, nsec := splitTimeout(entryTimeout)
+	b = ne.AppendUint64(b, nodeID)
+	b = ne.AppendUint64(b, 0) // generation
+	b = ne.AppendUint64(b, sec)
+	b = ne.AppendUint64(b, sec)
+	b = ne.AppendUint32(b, nsec)
+	b = ne.AppendUint32(b, nsec)
+	return appendAttr(b, a)
+}
+
+func appendAttrOut(b []byte, a *attr) []byte {
+	sec, nsec := splitTimeout(entryTimeout)
+	b = ne.AppendUint64(b, sec)
+	b = ne.AppendUint32(b, nsec)
+	b = ne.AppendUint32(b, 0)
+	return appendAttr(b, a)
+}
+
+func appendOpenOut(b []byte, fh uint64) []byte {
+	b = ne.AppendUint64(b, fh)
+	b = ne.AppendUint32(b, 0) // open_flags
+	return ne.AppendUint32(b, 0)
+}
+
+// appendDirent appends the entry of a directory for readdir, padded to 8 bytes
+func appendDirent(b []byte, ino, off uint64, name string, typ uint32) []byte {
+	b = ne.AppendUint64(b, ino)
+	b = ne.AppendUint64(b, off)
+	b = ne.AppendUint32(b, uint32(len(name)))
+	b = ne.AppendUint32(b, typ)
+	b = append(b, name...)
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// direntSize is the size of the entry of a directory appended by appendDirent
+func direntSize(name string) int {
+	return (24 + len(name) + 7) &^ 7
+}
+
+// cstring splits the string terminated by NUL at the start of b from the rest
+func cstring(b []byte) (string, []byte) {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i]), b[i+1:]
+		}
+	}
+	return string(b), nil
+}
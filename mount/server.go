@@ -0,0 +1,885 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+	"golang.org/x/sys/unix"
+)
+
+// Server serves a FileSystem mounted with FUSE, until it is unmounted
+type Server struct {
+	fs       filesystem.FileSystem
+	dir      string
+	dev      int
+	readOnly bool
+	uid, gid uint32
+
+	// nodes are the files known to the kernel by their ID, and paths their IDs by path
+	nodes  map[uint64]*node
+	paths  map[string]uint64
+	nextID uint64
+	// handles are the open files and directories
+	handles    map[uint64]*handle
+	nextHandle uint64
+	// dirCache is the last directory read, for the lookups of its entries, until anything changes
+	dirCache *cachedDir
+	// pollHack is set while the file of pollHackPath can be opened
+	pollHack atomic.Bool
+
+	// done is closed when the filesystem is unmounted, with err the error stopping the server
+	done chan struct{}
+	err  error
+}
+
+// node is a file known to the kernel, with the path it was found at, empty once removed
+type node struct {
+	path    string
+	lookups uint64
+}
+
+// handle is an open file, or the entries of an open directory
+type handle struct {
+	path    string
+	file    filesystem.File
+	entries []dirent
+}
+
+type dirent struct {
+	name string
+	ino  uint64
+	typ  uint32
+}
+
+type cachedDir struct {
+	path    string
+	entries []fs.DirEntry
+}
+
+// pollHackPath is the file opened while mounting, so that the kernel asks once to poll a file,
+// while the server can reply that it does not, see pollHack
+const pollHackPath = "/.diskfs-poll-hack"
+
+// errNoReply is returned by the requests the kernel expects no reply for
+var errNoReply = errors.New("no reply")
+
+// Mount mounts the FileSystem on the directory dir, and serves it until it is unmounted, with
+// Unmount or umount. The FileSystem is not closed when it is unmounted.
+func Mount(f filesystem.FileSystem, dir string, options ...Opt) (*Server, error) {
+	o := &opts{fsName: "diskfs"}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if w, ok := f.(interface{ Workspace() string }); ok && w.Workspace() == "" {
+		// read from an image, changes are only written to a workspace
+		o.readOnly = true
+	}
+	dir, err := absDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		fs:       f,
+		dir:      dir,
+		readOnly: o.readOnly,
+		uid:      uint32(os.Getuid()),
+		gid:      uint32(os.Getgid()),
+		nodes:    map[uint64]*node{rootID: {path: "/", lookups: 1}},
+		paths:    map[string]uint64{"/": rootID},
+		nextID:   rootID + 1,
+		handles:  map[uint64]*handle{},
+		done:     make(chan struct{}),
+	}
+	// the helper mounting it on macOS waits for init, so requests are served while mounting
+	dev, ready, err := mount(dir, o)
+	if err != nil {
+		return nil, err
+	}
+	s.dev = dev
+	go s.serve()
+	if err := <-ready; err != nil {
+		_ = unmount(dir)
+		<-s.done
+		return nil, err
+	}
+	s.pollHack.Store(true)
+	err = pollHack(dir)
+	s.pollHack.Store(false)
+	if err != nil {
+		_ = s.Unmount()
+		return nil, err
+	}
+	return s, nil
+}
+
+func absDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("unable to mount on %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("unable to mount on %s: not a directory", dir)
+	}
+	return abs, nil
+}
+
+// Dir returns the absolute path of the directory the filesystem is mounted on
+func (s *Server) Dir() string {
+	return s.dir
+}
+
+// Wait waits until the filesystem is unmounted, and returns the error that stopped serving it,
+// if any
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Unmount unmounts the filesystem, which fails while its files are in use, and waits until the
+// server stops
+func (s *Server) Unmount() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	if err := unmount(s.dir); err != nil {
+		return fmt.Errorf("unable to unmount %s: %w", s.dir, err)
+	}
+	return s.Wait()
+}
+
+// serve reads the requests of the kernel and replies to each in turn, until the filesystem is
+// unmounted
+func (s *Server) serve() {
+	defer close(s.done)
+	defer unix.Close(s.dev)
+	defer func() {
+		for _, h := range s.handles {
+			if h.file != nil {
+				_ = h.file.Close()
+			}
+		}
+	}()
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := unix.Read(s.dev, buf)
+		switch {
+		case err == unix.EINTR || err == unix.EAGAIN || err == unix.ENOENT:
+			// ENOENT when the request was interrupted before it was read
+			continue
+		case err == unix.ENODEV:
+			// unmounted
+			return
+		case err != nil:
+			s.err = fmt.Errorf("error reading FUSE request: %w", err)
+			return
+		case n < inHeaderSize:
+			s.err = fmt.Errorf("short FUSE request of %d bytes", n)
+			return
+		}
+		h := parseInHeader(buf)
+		out, err := s.dispatch(h, buf[inHeaderSize:n])
+		if err == errNoReply {
+			continue
+		}
+		var errno unix.Errno
+		if err != nil {
+			errno, out = toErrno(err), nil
+		}
+		reply := appendOutHeader(make([]byte, 0, 16+len(out)), len(out), -int32(errno), h.unique)
+		if _, err := unix.Write(s.dev, append(reply, out...)); err != nil && err != unix.ENOENT {
+			// ENOENT when the request was interrupted
+			s.err = fmt.Errorf("error writing FUSE reply: %w", err)
+			return
+		}
+	}
+}
+
+// toErrno converts the error of a FileSystem to the error number for the kernel
+func toErrno(err error) unix.Errno {
+	var errno unix.Errno
+	switch {
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, filesystem.ErrReadOnlyFilesystem):
+		return unix.EROFS
+	case errors.Is(err, filesystem.ErrNoSpace):
+		return unix.ENOSPC
+	case errors.Is(err, filesystem.ErrNotDir):
+		return unix.ENOTDIR
+	case errors.Is(err, filesystem.ErrIsDir):
+		return unix.EISDIR
+	case errors.Is(err, filesystem.ErrNotEmpty):
+		return unix.ENOTEMPTY
+	case errors.Is(err, fs.ErrNotExist):
+		return unix.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return unix.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return unix.EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return unix.EINVAL
+	case errors.Is(err, filesystem.ErrNotSupported), errors.Is(err, filesystem.ErrNotImplemented):
+		return unix.ENOTSUP
+	default:
+		return unix.EIO
+	}
+}
+
+func (s *Server) dispatch(h inHeader, body []byte) ([]byte, error) {
+	switch h.opcode {
+	case opInit:
+		return s.init(body)
+	case opForget:
+		s.forget(h.nodeID, ne.Uint64(body))
+		return nil, errNoReply
+	case opBatchForget:
+		count := ne.Uint32(body)
+		for i := 0; i < int(count); i++ {
+			one := body[8+16*i:]
+			s.forget(ne.Uint64(one), ne.Uint64(one[8:]))
+		}
+		return nil, errNoReply
+	case opInterrupt:
+		// requests are served one at a time, so the interrupted one has been replied to already
+		return nil, errNoReply
+	case opDestroy, opAccess, opFlush, opFsyncdir:
+		// permissions are checked by the kernel, with default_permissions
+		return nil, nil
+	case opStatfs:
+		return statfsOut(), nil
+	}
+
+	p, err := s.path(h.nodeID)
+	if err != nil {
+		return nil, err
+	}
+	switch h.opcode {
+	case opLookup:
+		name, _ := cstring(body)
+		return s.lookup(path.Join(p, name))
+	case opGetattr:
+		a, err := s.attr(p)
+		if err != nil {
+			return nil, err
+		}
+		return appendAttrOut(nil, a), nil
+	case opReadlink:
+		return s.readlink(p)
+	case opOpendir:
+		return s.opendir(p)
+	case opReaddir:
+		return s.readdir(body)
+	case opReleasedir, opRelease:
+		s.release(ne.Uint64(body))
+		return nil, nil
+	case opOpen:
+		return s.open(p, ne.Uint32(body))
+	case opRead:
+		return s.read(body)
+	}
+
+	switch h.opcode {
+	case opWrite, opFsync, opSetattr, opCreate, opMkdir, opMknod, opSymlink, opLink, opUnlink, opRmdir, opRename, opRename2:
+	default:
+		return nil, unix.ENOSYS
+	}
+	// requests changing the filesystem
+	if s.readOnly {
+		return nil, unix.EROFS
+	}
+	s.dirCache = nil
+	switch h.opcode {
+	case opWrite:
+		return s.write(body)
+	case opFsync:
+		return nil, s.fs.Sync()
+	case opSetattr:
+		return s.setattr(p, body)
+	case opCreate:
+		flags := ne.Uint32(body)
+		name, _ := cstring(body[16:])
+		return s.create(path.Join(p, name), flags)
+	case opMkdir:
+		name, _ := cstring(body[8:])
+		return s.change(path.Join(p, name), s.fs.Mkdir)
+	case opMknod:
+		mode, dev := ne.Uint32(body), ne.Uint32(body[4:])
+		name, _ := cstring(body[16:])
+		return s.change(path.Join(p, name), func(p string) error {
+			return s.fs.Mknod(p, mode, int(dev))
+		})
+	case opSymlink:
+		name, rest := cstring(body)
+		target, _ := cstring(rest)
+		return s.change(path.Join(p, name), func(p string) error {
+			return s.fs.Symlink(target, p)
+		})
+	case opLink:
+		old, err := s.path(ne.Uint64(body))
+		if err != nil {
+			return nil, err
+		}
+		name, _ := cstring(body[8:])
+		return s.change(path.Join(p, name), func(p string) error {
+			return s.fs.Link(old, p)
+		})
+	case opUnlink, opRmdir:
+		name, _ := cstring(body)
+		return nil, s.remove(path.Join(p, name))
+	case opRename:
+		return nil, s.rename(p, ne.Uint64(body), 0, body[8:])
+	case opRename2:
+		return nil, s.rename(p, ne.Uint64(body), ne.Uint32(body[8:]), body[16:])
+	}
+	return nil, nil
+}
+
+func (s *Server) init(body []byte) ([]byte, error) {
+	major, minor := ne.Uint32(body), ne.Uint32(body[4:])
+	maxReadahead, flags := ne.Uint32(body[8:]), ne.Uint32(body[12:])
+	if major != kernelVersion {
+		return nil, unix.EPROTO
+	}
+	b := ne.AppendUint32(nil, kernelVersion)
+	b = ne.AppendUint32(b, kernelMinorVersion)
+	b = ne.AppendUint32(b, maxReadahead)
+	b = ne.AppendUint32(b, flags&(initAtomicOTrunc|initBigWrites))
+	b = ne.AppendUint16(b, 16) // max_background
+	b = ne.AppendUint16(b, 12) // congestion_threshold
+	b = ne.AppendUint32(b, maxWrite)
+	if minor < minorVersionInitOut {
+		return b, nil
+	}
+	b = ne.AppendUint32(b, 1) // time_gran
+	return append(b, make([]byte, 64-len(b))...), nil
+}
+
+// path returns the path of the node
+func (s *Server) path(id uint64) (string, error) {
+	n, ok := s.nodes[id]
+	if !ok || n.path == "" {
+		return "", unix.ESTALE
+	}
+	return n.path, nil
+}
+
+// nodeID returns the ID of the node of the path, which the kernel looked up once more
+func (s *Server) nodeID(p string) uint64 {
+	id, ok := s.paths[p]
+	if !ok {
+		id = s.nextID
+		s.nextID++
+		s.nodes[id] = &node{path: p}
+		s.paths[p] = id
+	}
+	s.nodes[id].lookups++
+	return id
+}
+
+func (s *Server) forget(id, lookups uint64) {
+	n, ok := s.nodes[id]
+	if !ok || id == rootID {
+		return
+	}
+	n.lookups -= min(lookups, n.lookups)
+	if n.lookups > 0 {
+		return
+	}
+	delete(s.nodes, id)
+	if n.path != "" && s.paths[n.path] == id {
+		delete(s.paths, n.path)
+	}
+}
+
+// lstat finds the entry of the path in its parent directory, without following symbolic links
+func (s *Server) lstat(p string) (fs.FileInfo, error) {
+	if p == "/" {
+		return s.fs.Stat("/")
+	}
+	dir := path.Dir(p)
+	if s.dirCache == nil || s.dirCache.path != dir {
+		entries, err := s.fs.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		s.dirCache = &cachedDir{path: dir, entries: entries}
+	}
+	entries := s.dirCache.entries
+	i, found := slices.BinarySearchFunc(entries, path.Base(p), func(e fs.DirEntry, name string) int {
+		return strings.Compare(e.Name(), name)
+	})
+	if !found {
+		return nil, unix.ENOENT
+	}
+	return entries[i].Info()
+}
+
+func (s *Server) attr(p string) (*attr, error) {
+	if p == pollHackPath && s.pollHack.Load() {
+		return &attr{ino: inode(p), mode: unix.S_IFREG | 0o444, nlink: 1, uid: s.uid, gid: s.gid}, nil
+	}
+	info, err := s.lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	mode := unixMode(info.Mode())
+	if p == "/" {
+		mode = unix.S_IFDIR | mode&0o7777
+	}
+	a := &attr{
+		ino:   inode(p),
+		size:  uint64(info.Size()),
+		mtime: info.ModTime(),
+		mode:  mode,
+		nlink: 1,
+		uid:   s.uid,
+		gid:   s.gid,
+	}
+	if mode&unix.S_IFMT == unix.S_IFDIR {
+		a.nlink, a.size = 2, 0
+	}
+	a.blocks = (a.size + 511) / 512
+	return a, nil
+}
+
+// inode numbers the file by its path, the root being 1
+func inode(p string) uint64 {
+	if p == "/" {
+		return rootID
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(p))
+	return h.Sum64() | 1<<63
+}
+
+// unixMode converts the mode of a file to the type and permissions of Unix
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= unix.S_ISUID
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= unix.S_ISGID
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= unix.S_ISVTX
+	}
+	switch {
+	case m.IsDir():
+		mode |= unix.S_IFDIR
+	case m&fs.ModeSymlink != 0:
+		mode |= unix.S_IFLNK
+	case m&fs.ModeCharDevice != 0:
+		mode |= unix.S_IFCHR
+	case m&fs.ModeDevice != 0:
+		mode |= unix.S_IFBLK
+	case m&fs.ModeNamedPipe != 0:
+		mode |= unix.S_IFIFO
+	case m&fs.ModeSocket != 0:
+		mode |= unix.S_IFSOCK
+	default:
+		mode |= unix.S_IFREG
+	}
+	return mode
+}
+
+// fileMode converts the permissions of Unix to those of a file
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	if mode&unix.S_ISUID != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&unix.S_ISGID != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&unix.S_ISVTX != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// lookup replies with the node and attributes of the path
+func (s *Server) lookup(p string) ([]byte, error) {
+	a, err := s.attr(p)
+	if err != nil {
+		return nil, err
+	}
+	return appendEntryOut(nil, s.nodeID(p), a), nil
+}
+
+// change makes a new file at the path with the function, and replies with its node
+func (s *Server) change(p string, fn func(p string) error) ([]byte, error) {
+	if _, err := s.lstat(p); err == nil {
+		return nil, unix.EEXIST
+	}
+	if err := fn(p); err != nil {
+		return nil, err
+	}
+	s.dirCache = nil
+	return s.lookup(p)
+}
+
+func (s *Server) readlink(p string) ([]byte, error) {
+	target, err := filesystem.Readlink(s.fs, p)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(target), nil
+}
+
+func (s *Server) newHandle(h *handle) uint64 {
+	s.nextHandle++
+	s.handles[s.nextHandle] = h
+	return s.nextHandle
+}
+
+func (s *Server) handle(fh uint64) (*handle, error) {
+	h, ok := s.handles[fh]
+	if !ok {
+		return nil, unix.EBADF
+	}
+	return h, nil
+}
+
+func (s *Server) release(fh uint64) {
+	if h, ok := s.handles[fh]; ok {
+		if h.file != nil {
+			_ = h.file.Close()
+		}
+		delete(s.handles, fh)
+	}
+}
+
+func (s *Server) opendir(p string) ([]byte, error) {
+	entries, err := s.fs.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	s.dirCache = &cachedDir{path: p, entries: entries}
+	dirents := []dirent{
+		{name: ".", ino: inode(p), typ: unix.S_IFDIR >> 12},
+		{name: "..", ino: inode(path.Dir(p)), typ: unix.S_IFDIR >> 12},
+	}
+	for _, e := range entries {
+		child := path.Join(p, e.Name())
+		dirents = append(dirents, dirent{name: e.Name(), ino: inode(child), typ: unixMode(e.Type()) >> 12})
+	}
+	return appendOpenOut(nil, s.newHandle(&handle{path: p, entries: dirents})), nil
+}
+
+func (s *Server) readdir(body []byte) ([]byte, error) {
+	h, err := s.handle(ne.Uint64(body))
+	if err != nil {
+		return nil, err
+	}
+	offset, size := ne.Uint64(body[8:]), int(ne.Uint32(body[16:]))
+	var b []byte
+	for i := int(min(offset, uint64(len(h.entries)))); i < len(h.entries); i++ {
+		e := h.entries[i]
+		if len(b)+direntSize(e.name) > size {
+			break
+		}
+		b = appendDirent(b, e.ino, uint64(i+1), e.name, e.typ)
+	}
+	return b, nil
+}
+
+// openFlags converts the flags of the kernel to those of OpenFile, which writes only files opened
+// for reading and writing. Appending is done by the kernel, which writes at the end.
+func openFlags(flags uint32) int {
+	flag := os.O_RDONLY
+	if flags&unix.O_ACCMODE != unix.O_RDONLY {
+		flag = os.O_RDWR
+	}
+	if flags&unix.O_TRUNC != 0 {
+		flag |= os.O_TRUNC
+	}
+	return flag
+}
+
+func (s *Server) open(p string, flags uint32) ([]byte, error) {
+	if p == pollHackPath && s.pollHack.Load() {
+		return appendOpenOut(nil, s.newHandle(&handle{path: p})), nil
+	}
+	flag := openFlags(flags)
+	if flag != os.O_RDONLY {
+		if s.readOnly {
+			return nil, unix.EROFS
+		}
+		s.dirCache = nil
+	}
+	f, err := s.fs.OpenFile(p, flag)
+	if err != nil {
+		return nil, err
+	}
+	return appendOpenOut(nil, s.newHandle(&handle{path: p, file: f})), nil
+}
+
+func (s *Server) create(p string, flags uint32) ([]byte, error) {
+	if _, err := s.lstat(p); err == nil {
+		if flags&unix.O_EXCL != 0 {
+			return nil, unix.EEXIST
+		}
+	}
+	s.dirCache = nil
+	f, err := s.fs.OpenFile(p, os.O_CREATE|openFlags(flags|unix.O_RDWR))
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.lookup(p)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return appendOpenOut(entry, s.newHandle(&handle{path: p, file: f})), nil
+}
+
+func (s *Server) read(body []byte) ([]byte, error) {
+	h, err := s.handle(ne.Uint64(body))
+	if err != nil {
+		return nil, err
+	}
+	if h.file == nil {
+		return nil, unix.EISDIR
+	}
+	offset, size := ne.Uint64(body[8:]), ne.Uint32(body[16:])
+	b := make([]byte, size)
+	n, err := h.file.ReadAt(b, int64(offset))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+func (s *Server) write(body []byte) ([]byte, error) {
+	h, err := s.handle(ne.Uint64(body))
+	if err != nil {
+		return nil, err
+	}
+	if h.file == nil {
+		return nil, unix.EISDIR
+	}
+	offset, size := ne.Uint64(body[8:]), ne.Uint32(body[16:])
+	data := body[40:]
+	if int(size) < len(data) {
+		data = data[:size]
+	}
+	if _, err := h.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	n, err := h.file.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	out := ne.AppendUint32(nil, uint32(n))
+	return ne.AppendUint32(out, 0), nil
+}
+
+func (s *Server) setattr(p string, body []byte) ([]byte, error) {
+	valid, fh, size := ne.Uint32(body), ne.Uint64(body[8:]), ne.Uint64(body[16:])
+	mode, uid, gid := ne.Uint32(body[68:]), ne.Uint32(body[76:]), ne.Uint32(body[80:])
+	if valid&setattrMode != 0 {
+		if err := s.fs.Chmod(p, fileMode(mode)); err != nil {
+			return nil, err
+		}
+	}
+	if valid&(setattrUID|setattrGID) != 0 {
+		owner, group := -1, -1
+		if valid&setattrUID != 0 {
+			owner = int(uid)
+		}
+		if valid&setattrGID != 0 {
+			group = int(gid)
+		}
+		if err := s.fs.Chown(p, owner, group); err != nil {
+			return nil, err
+		}
+	}
+	if valid&setattrSize != 0 {
+		if err := s.truncate(p, size, valid&setattrFh != 0, fh); err != nil {
+			return nil, err
+		}
+	}
+	if valid&(setattrAtime|setattrMtime) != 0 {
+		if err := s.chtimes(p, valid, body); err != nil {
+			return nil, err
+		}
+	}
+	s.dirCache = nil
+	a, err := s.attr(p)
+	if err != nil {
+		return nil, err
+	}
+	return appendAttrOut(nil, a), nil
+}
+
+// chtimes sets the times of setattr, where the filesystem can change them. Elsewhere setting
+// them, as touch does, does nothing rather than fail.
+func (s *Server) chtimes(p string, valid uint32, body []byte) error {
+	c, ok := s.fs.(filesystem.ChtimesFS)
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	var atime, mtime time.Time
+	switch {
+	case valid&setattrAtimeNow != 0:
+		atime = now
+	case valid&setattrAtime != 0:
+		atime = time.Unix(int64(ne.Uint64(body[32:])), int64(ne.Uint32(body[56:])))
+	}
+	switch {
+	case valid&setattrMtimeNow != 0:
+		mtime = now
+	case valid&setattrMtime != 0:
+		mtime = time.Unix(int64(ne.Uint64(body[40:])), int64(ne.Uint32(body[60:])))
+	}
+	err := c.Chtimes(p, atime, mtime)
+	if errors.Is(err, filesystem.ErrNotSupported) || errors.Is(err, filesystem.ErrNotImplemented) {
+		return nil
+	}
+	return err
+}
+
+// truncate changes the size of the file, through the file of the handle given if any
+func (s *Server) truncate(p string, size uint64, withHandle bool, fh uint64) error {
+	info, err := s.lstat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return unix.EISDIR
+	}
+	if uint64(info.Size()) == size {
+		return nil
+	}
+	if h, ok := s.handles[fh]; withHandle && ok && h.file != nil {
+		return h.file.Truncate(int64(size))
+	}
+	f, err := s.fs.OpenFile(p, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(int64(size)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Server) remove(p string) error {
+	if err := s.fs.Remove(p); err != nil {
+		return err
+	}
+	if id, ok := s.paths[p]; ok {
+		s.nodes[id].path = ""
+		delete(s.paths, p)
+	}
+	return nil
+}
+
+func (s *Server) rename(dir string, newDir uint64, flags uint32, names []byte) error {
+	oldName, rest := cstring(names)
+	newName, _ := cstring(rest)
+	newParent, err := s.path(newDir)
+	if err != nil {
+		return err
+	}
+	oldPath, newPath := path.Join(dir, oldName), path.Join(newParent, newName)
+	switch {
+	case flags&renameExchange != 0:
+		return unix.ENOTSUP
+	case flags&renameNoReplace != 0:
+		if _, err := s.lstat(newPath); err == nil {
+			return unix.EEXIST
+		}
+		s.dirCache = nil
+	}
+	if err := s.fs.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	// the file replaced is gone, and the nodes of the file and all files below it move
+	if id, ok := s.paths[newPath]; ok {
+		s.nodes[id].path = ""
+		delete(s.paths, newPath)
+	}
+	var moved []string
+	for p := range s.paths {
+		if p == oldPath || strings.HasPrefix(p, oldPath+"/") {
+			moved = append(moved, p)
+		}
+	}
+	ids := make([]uint64, len(moved))
+	for i, p := range moved {
+		ids[i] = s.paths[p]
+		delete(s.paths, p)
+	}
+	for i, p := range moved {
+		p = newPath + strings.TrimPrefix(p, oldPath)
+		s.paths[p] = ids[i]
+		s.nodes[ids[i]].path = p
+	}
+	return nil
+}
+
+// statfsOut replies to statfs, without any counts of blocks and files, which a FileSystem does
+// not report
+func statfsOut() []byte {
+	b := make([]byte, 40)
+	b = ne.AppendUint32(b, 4096) // bsize
+	b = ne.AppendUint32(b, 255)  // namelen
+	b = ne.AppendUint32(b, 4096) // frsize
+	return append(b, make([]byte, 28)...)
+}
+
+// commSocket returns the sockets for a mount helper to send the FUSE device on, the one of the
+// helper as a file for its ExtraFiles
+func commSocket() (int, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return -1, nil, fmt.Errorf("unable to create socket for the mount helper: %w", err)
+	}
+	unix.CloseOnExec(fds[0])
+	return fds[0], os.NewFile(uintptr(fds[1]), "fuse-commfd"), nil
+}
+
+// receiveDevice receives the FUSE device the mount helper opened for the mount
+func receiveDevice(sock int) (int, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(sock, buf, oob, 0)
+	if err != nil {
+		return -1, fmt.Errorf("unable to receive the FUSE device from the mount helper: %w", err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, errors.New("mount helper sent no FUSE device")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return -1, errors.New("mount helper sent no FUSE device")
+	}
+	unix.CloseOnExec(fds[0])
+	return fds[0], nil
+}
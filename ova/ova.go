@@ -0,0 +1,213 @@
+// Package ova wraps finished disk images into OVA appliances, for import into VMware, VirtualBox and
+// other virtualization platforms.
+//
+// An OVA is a tar archive holding an OVF descriptor, which describes the virtual machine, then its disk
+// images, then a manifest with the SHA256 checksums of all of them. VMware and VirtualBox expect
+// streamOptimized VMDK disks, as written by vmdk.WriteStreamOptimized.
+package ova
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/backend/vhd"
+	"github.com/diskfs/go-diskfs/backend/vmdk"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// OVF disk format URIs
+const (
+	FormatVMDKStreamOptimized = "http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"
+	FormatVMDKSparse          = "http://www.vmware.com/interfaces/specifications/vmdk.html#sparse"
+	FormatVHD                 = "http://technet.microsoft.com/en-us/virtualserver/bb676673.aspx"
+)
+
+// Disk is a disk image of an appliance
+type Disk struct {
+	// File is the name of the disk image in the OVA, e.g. disk1.vmdk
+	File string
+	// Path is the path of the disk image file, which is read if Source is nil
+	Path string
+	// Source is the contents of the disk image file
+	Source io.Reader
+	// Size is the size of the disk image file
+	Size int64
+	// Capacity is the size of the virtual disk
+	Capacity int64
+	// Format is the OVF format URI of the disk image, e.g. FormatVMDKStreamOptimized
+	Format string
+}
+
+// Appliance describes the virtual machine of an OVA
+type Appliance struct {
+	// Name is the name of the virtual machine, and of the OVF descriptor and manifest in the OVA
+	Name string
+	// CPUs is the number of virtual CPUs, 1 if not set
+	CPUs int
+	// MemoryMB is the memory of the virtual machine in MB, 1024 if not set
+	MemoryMB int
+	// OSType is the CIM operating system ID, 1 (Other) if not set; e.g. 101 is Linux 64-bit
+	OSType int
+	// Disks are the disks, attached in order to a SCSI controller
+	Disks []Disk
+	// Template is the text/template of the OVF descriptor, executed with the Appliance.
+	// DefaultTemplate is used if not set.
+	Template string
+}
+
+// DiskFromPath describes the VMDK or VHD image file at the path, for inclusion in an appliance
+func DiskFromPath(pathName string) (Disk, error) {
+	if pathName == "" {
+		return Disk{}, errors.New("must pass image file name")
+	}
+	f, err := os.Open(pathName)
+	if err != nil {
+		return Disk{}, fmt.Errorf("could not open image %s: %w", pathName, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Disk{}, fmt.Errorf("could not stat image %s: %w", pathName, err)
+	}
+	d := Disk{
+		File: filepath.Base(pathName),
+		Path: pathName,
+		Size: info.Size(),
+	}
+	b := file.New(f, true)
+	switch strings.ToLower(filepath.Ext(pathName)) {
+	case ".vmdk":
+		img, err := vmdk.New(b, true)
+		if err != nil {
+			return Disk{}, fmt.Errorf("could not open VMDK image %s: %w", pathName, err)
+		}
+		d.Capacity = img.Size()
+		d.Format = FormatVMDKSparse
+		if img.StreamOptimized() {
+			d.Format = FormatVMDKStreamOptimized
+		}
+	case ".vhd":
+		img, err := vhd.New(b, true)
+		if err != nil {
+			return Disk{}, fmt.Errorf("could not open VHD image %s: %w", pathName, err)
+		}
+		d.Capacity = img.Size()
+		d.Format = FormatVHD
+	default:
+		return Disk{}, fmt.Errorf("unsupported disk image %s, must be .vmdk or .vhd", pathName)
+	}
+	return d, nil
+}
+
+// Descriptor returns the OVF descriptor of the appliance
+func (a *Appliance) Descriptor() ([]byte, error) {
+	text := a.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	tmpl, err := template.New("ovf").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OVF template: %w", err)
+	}
+	values := *a
+	values.CPUs = max(a.CPUs, 1)
+	if values.MemoryMB <= 0 {
+		values.MemoryMB = 1024
+	}
+	if values.OSType <= 0 {
+		values.OSType = 1
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &values); err != nil {
+		return nil, fmt.Errorf("error executing OVF template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Write writes the appliance as an OVA to w: the OVF descriptor, the disk images and the manifest
+func Write(w io.Writer, a *Appliance) error {
+	if a.Name == "" || strings.ContainsAny(a.Name, `/\`) {
+		return fmt.Errorf("invalid appliance name %q", a.Name)
+	}
+	if len(a.Disks) == 0 {
+		return errors.New("must pass at least one disk")
+	}
+	for i, d := range a.Disks {
+		if d.File == "" || strings.ContainsAny(d.File, `/\`) {
+			return fmt.Errorf("invalid file name %q of disk %d", d.File, i)
+		}
+		if d.Source == nil && d.Path == "" {
+			return fmt.Errorf("disk %s has neither a source nor a path", d.File)
+		}
+	}
+	descriptor, err := a.Descriptor()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	modTime := util.Now().Truncate(time.Second)
+	var manifest strings.Builder
+	add := func(name string, size int64, r io.Reader) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    size,
+			ModTime: modTime,
+			Format:  tar.FormatUSTAR,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error writing header of %s: %w", name, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(io.MultiWriter(tw, h), r)
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", name, err)
+		}
+		if n != size {
+			return fmt.Errorf("%s is %d bytes instead of %d", name, n, size)
+		}
+		fmt.Fprintf(&manifest, "SHA256(%s)= %s\n", name, hex.EncodeToString(h.Sum(nil)))
+		return nil
+	}
+
+	if err := add(a.Name+".ovf", int64(len(descriptor)), bytes.NewReader(descriptor)); err != nil {
+		return err
+	}
+	for _, d := range a.Disks {
+		if err := addDisk(d, add); err != nil {
+			return err
+		}
+	}
+	m := manifest.String()
+	if err := add(a.Name+".mf", int64(len(m)), strings.NewReader(m)); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error completing OVA: %w", err)
+	}
+	return nil
+}
+
+func addDisk(d Disk, add func(name string, size int64, r io.Reader) error) error {
+	if d.Source != nil {
+		return add(d.File, d.Size, d.Source)
+	}
+	f, err := os.Open(d.Path)
+	if err != nil {
+		return fmt.Errorf("could not open disk image %s: %w", d.Path, err)
+	}
+	defer f.Close()
+	return add(d.File, d.Size, f)
+}
@@ -0,0 +1,167 @@
+package ova_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/vhd"
+	"github.com/diskfs/go-diskfs/backend/vmdk"
+	"github.com/diskfs/go-diskfs/ova"
+)
+
+// envelope is the part of an OVF descriptor checked by the tests
+type envelope struct {
+	Files []struct {
+		Href string `xml:"href,attr"`
+		Size int64  `xml:"size,attr"`
+	} `xml:"References>File"`
+	Disks []struct {
+		Capacity int64  `xml:"capacity,attr"`
+		Format   string `xml:"format,attr"`
+	} `xml:"DiskSection>Disk"`
+	Name string `xml:"VirtualSystem>Name"`
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(4 * 1024 * 1024)
+	raw := make([]byte, size)
+	_, _ = rand.Read(raw[:100*1024])
+
+	vmdkPath := filepath.Join(dir, "disk1.vmdk")
+	f, err := os.Create(vmdkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vmdk.WriteStreamOptimized(f, bytes.NewReader(raw), size); err != nil {
+		t.Fatalf("error writing VMDK: %v", err)
+	}
+	f.Close()
+	vhdPath := filepath.Join(dir, "disk2.vhd")
+	img, err := vhd.CreateFromPath(vhdPath, 2*size)
+	if err != nil {
+		t.Fatalf("error creating VHD: %v", err)
+	}
+	img.Close()
+
+	var disks []ova.Disk
+	for _, p := range []string{vmdkPath, vhdPath} {
+		d, err := ova.DiskFromPath(p)
+		if err != nil {
+			t.Fatalf("error reading disk %s: %v", p, err)
+		}
+		disks = append(disks, d)
+	}
+	if disks[0].Capacity != size || disks[0].Format != ova.FormatVMDKStreamOptimized {
+		t.Errorf("VMDK disk %+v has wrong capacity or format", disks[0])
+	}
+	if disks[1].Capacity != 2*size || disks[1].Format != ova.FormatVHD {
+		t.Errorf("VHD disk %+v has wrong capacity or format", disks[1])
+	}
+
+	a := &ova.Appliance{Name: "test & appliance", CPUs: 2, MemoryMB: 2048, OSType: 101, Disks: disks}
+	var buf bytes.Buffer
+	if err := ova.Write(&buf, a); err != nil {
+		t.Fatalf("error writing OVA: %v", err)
+	}
+
+	// the descriptor comes first and the manifest last, with the checksums of all other files
+	tr := tar.NewReader(&buf)
+	contents := map[string][]byte{}
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid OVA: %v", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = b
+	}
+	expectedNames := []string{"test & appliance.ovf", "disk1.vmdk", "disk2.vhd", "test & appliance.mf"}
+	if strings.Join(names, ",") != strings.Join(expectedNames, ",") {
+		t.Fatalf("OVA files %v instead of %v", names, expectedNames)
+	}
+	var manifest strings.Builder
+	for _, name := range expectedNames[:3] {
+		sum := sha256.Sum256(contents[name])
+		fmt.Fprintf(&manifest, "SHA256(%s)= %s\n", name, hex.EncodeToString(sum[:]))
+	}
+	if string(contents[expectedNames[3]]) != manifest.String() {
+		t.Errorf("manifest\n%s\ninstead of\n%s", contents[expectedNames[3]], manifest.String())
+	}
+
+	var e envelope
+	if err := xml.Unmarshal(contents[expectedNames[0]], &e); err != nil {
+		t.Fatalf("invalid OVF descriptor: %v", err)
+	}
+	if e.Name != a.Name {
+		t.Errorf("name %q instead of %q", e.Name, a.Name)
+	}
+	if len(e.Files) != 2 || len(e.Disks) != 2 {
+		t.Fatalf("%d files and %d disks in the descriptor instead of 2", len(e.Files), len(e.Disks))
+	}
+	for i, d := range disks {
+		if e.Files[i].Href != d.File || e.Files[i].Size != d.Size {
+			t.Errorf("file %d is %+v instead of %s of %d bytes", i, e.Files[i], d.File, d.Size)
+		}
+		if e.Disks[i].Capacity != d.Capacity || e.Disks[i].Format != d.Format {
+			t.Errorf("disk %d is %+v instead of %d bytes in %s", i, e.Disks[i], d.Capacity, d.Format)
+		}
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	a := &ova.Appliance{
+		Name:     "custom",
+		Disks:    []ova.Disk{{File: "disk.vmdk", Source: strings.NewReader("data"), Size: 4}},
+		Template: `<Envelope><Name>{{ .Name }}</Name><Memory>{{ .MemoryMB }}</Memory></Envelope>`,
+	}
+	b, err := a.Descriptor()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "<Envelope><Name>custom</Name><Memory>1024</Memory></Envelope>"; string(b) != expected {
+		t.Errorf("descriptor %q instead of %q", b, expected)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	disk := ova.Disk{File: "disk.vmdk", Source: strings.NewReader("data"), Size: 4}
+	tests := []struct {
+		name string
+		a    *ova.Appliance
+	}{
+		{"no name", &ova.Appliance{Disks: []ova.Disk{disk}}},
+		{"no disks", &ova.Appliance{Name: "test"}},
+		{"invalid disk name", &ova.Appliance{Name: "test", Disks: []ova.Disk{{File: "../disk.vmdk", Source: strings.NewReader("data"), Size: 4}}}},
+		{"short source", &ova.Appliance{Name: "test", Disks: []ova.Disk{{File: "disk.vmdk", Source: strings.NewReader("data"), Size: 10}}}},
+		{"invalid template", &ova.Appliance{Name: "test", Disks: []ova.Disk{disk}, Template: "{{ .Missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ova.Write(io.Discard, tt.a); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+	if _, err := ova.DiskFromPath(filepath.Join(t.TempDir(), "disk.img")); err == nil {
+		t.Errorf("expected error for missing disk")
+	}
+}
@@ -0,0 +1,87 @@
+package ova
+
+import (
+	"bytes"
+	"encoding/xml"
+	"text/template"
+)
+
+var templateFuncs = template.FuncMap{
+	// xml escapes a value for use in XML text and attributes
+	"xml": func(s string) string {
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
+}
+
+// DefaultTemplate is the template of OVF descriptors, describing a virtual machine with its CPUs, memory,
+// and disks attached to an LSI Logic SCSI controller
+const DefaultTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <References>
+{{- range $i, $d := .Disks }}
+    <File ovf:href="{{ xml $d.File }}" ovf:id="file{{ add $i 1 }}" ovf:size="{{ $d.Size }}"/>
+{{- end }}
+  </References>
+  <DiskSection>
+    <Info>Virtual disk information</Info>
+{{- range $i, $d := .Disks }}
+    <Disk ovf:capacity="{{ $d.Capacity }}" ovf:capacityAllocationUnits="byte" ovf:diskId="vmdisk{{ add $i 1 }}" ovf:fileRef="file{{ add $i 1 }}" ovf:format="{{ xml $d.Format }}"/>
+{{- end }}
+  </DiskSection>
+  <VirtualSystem ovf:id="{{ xml .Name }}">
+    <Info>A virtual machine</Info>
+    <Name>{{ xml .Name }}</Name>
+    <OperatingSystemSection ovf:id="{{ .OSType }}">
+      <Info>The kind of installed guest operating system</Info>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements</Info>
+      <System>
+        <vssd:ElementName>Virtual Hardware Family</vssd:ElementName>
+        <vssd:InstanceID>0</vssd:InstanceID>
+        <vssd:VirtualSystemIdentifier>{{ xml .Name }}</vssd:VirtualSystemIdentifier>
+        <vssd:VirtualSystemType>vmx-10</vssd:VirtualSystemType>
+      </System>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:Description>Number of Virtual CPUs</rasd:Description>
+        <rasd:ElementName>{{ .CPUs }} virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{ .CPUs }}</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:Description>Memory Size</rasd:Description>
+        <rasd:ElementName>{{ .MemoryMB }}MB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{ .MemoryMB }}</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:Address>0</rasd:Address>
+        <rasd:Description>SCSI Controller</rasd:Description>
+        <rasd:ElementName>SCSI Controller 0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceSubType>lsilogic</rasd:ResourceSubType>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+{{- range $i, $d := .Disks }}
+      <Item>
+        <rasd:AddressOnParent>{{ $i }}</rasd:AddressOnParent>
+        <rasd:ElementName>Hard Disk {{ add $i 1 }}</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk{{ add $i 1 }}</rasd:HostResource>
+        <rasd:InstanceID>{{ add $i 4 }}</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+{{- end }}
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
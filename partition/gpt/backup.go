@@ -0,0 +1,132 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// backupSectorSize is the unit in which `sgdisk --backup` lays out its backup file,
+// independent of the logical sector size of the disk it was taken from
+const backupSectorSize = 512
+
+// the layout of a backup file created by `sgdisk --backup`, in units of backupSectorSize:
+//
+//	0  MBR (protective or hybrid)
+//	1  primary GPT header
+//	2  secondary GPT header
+//	3- partition entry array
+const (
+	backupMBRSector             = 0
+	backupPrimaryHeaderSector   = 1
+	backupSecondaryHeaderSector = 2
+	backupPartitionArraySector  = 3
+)
+
+// the largest partition entries and the most of them a backup may have, so that a damaged or
+// hostile header does not size what is read from it
+const (
+	maxBackupPartitionEntrySize  = 4096
+	maxBackupPartitionEntryCount = 16384
+)
+
+// WriteBackup writes the table of the disk f in the binary format produced by `sgdisk --backup`,
+// so it can be restored with `sgdisk --load-backup` or ReadBackup. The MBR is the one in the first
+// sector of f as it is, so that restoring the backup keeps a hybrid or legacy MBR.
+//
+// The table must either have been read from a disk, or have been written to one, so that
+// the header locations are known.
+func (t *Table) WriteBackup(f backend.File, w io.Writer) error {
+	if !t.initialized {
+		return fmt.Errorf("table is not initialized, read it from or write it to a disk first")
+	}
+
+	mbr := make([]byte, backupSectorSize)
+	read, err := f.ReadAt(mbr, 0)
+	if err != nil && (!errors.Is(err, io.EOF) || read < len(mbr)) {
+		return fmt.Errorf("error reading MBR from disk: %w", err)
+	}
+
+	primaryHeader, err := t.toGPTBytes(true)
+	if err != nil {
+		return fmt.Errorf("error converting primary GPT header to byte array: %v", err)
+	}
+	secondaryHeader, err := t.toGPTBytes(false)
+	if err != nil {
+		return fmt.Errorf("error converting secondary GPT header to byte array: %v", err)
+	}
+	partitionArray, err := t.toPartitionArrayBytes()
+	if err != nil {
+		return fmt.Errorf("error converting partitions to byte array: %v", err)
+	}
+
+	// the headers are always stored in a backupSectorSize slot, whatever the logical sector size
+	for _, b := range [][]byte{mbr, primaryHeader[:backupSectorSize], secondaryHeader[:backupSectorSize], partitionArray} {
+		written, err := w.Write(b)
+		if err != nil {
+			return fmt.Errorf("error writing GPT backup: %v", err)
+		}
+		if written != len(b) {
+			return fmt.Errorf("wrote %d bytes of GPT backup instead of %d", written, len(b))
+		}
+	}
+	return nil
+}
+
+// ReadBackup reads a table from the binary format produced by `sgdisk --backup`.
+// The logical and physical block sizes are those of the disk the table will be applied to;
+// the LBAs in the headers are interpreted in units of the logical block size.
+//
+// The resulting table can be applied to a disk using Write, optionally after calling Repair
+// or Resize if the target disk is not the same size as the original.
+func ReadBackup(r io.Reader, logicalBlockSize, physicalBlockSize int) (*Table, error) {
+	headers := make([]byte, backupPartitionArraySector*backupSectorSize)
+	if _, err := io.ReadFull(r, headers); err != nil {
+		return nil, fmt.Errorf("error reading GPT backup headers: %w", err)
+	}
+	mbr := headers[backupMBRSector*backupSectorSize : (backupMBRSector+1)*backupSectorSize]
+	primary := headers[backupPrimaryHeaderSector*backupSectorSize : (backupPrimaryHeaderSector+1)*backupSectorSize]
+	secondary := headers[backupSecondaryHeaderSector*backupSectorSize : (backupSecondaryHeaderSector+1)*backupSectorSize]
+
+	table, err := readGPTHeader(primary)
+	if err != nil {
+		return nil, fmt.Errorf("error reading primary GPT header from backup: %w", err)
+	}
+	secondaryTable, err := readGPTHeader(secondary)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secondary GPT header from backup: %w", err)
+	}
+	if secondaryTable.GUID != table.GUID || secondaryTable.partitionEntryChecksum != table.partitionEntryChecksum {
+		return nil, fmt.Errorf("primary and secondary GPT headers in backup do not match")
+	}
+
+	entrySize := int(table.partitionEntrySize)
+	if entrySize < 128 || entrySize%128 != 0 || entrySize > maxBackupPartitionEntrySize {
+		return nil, fmt.Errorf("invalid partition entry size %d in GPT backup", entrySize)
+	}
+	if table.partitionArraySize > maxBackupPartitionEntryCount {
+		return nil, fmt.Errorf("%d partition entries in GPT backup is more than the maximum %d", table.partitionArraySize, maxBackupPartitionEntryCount)
+	}
+	b := make([]byte, table.partitionArraySize*entrySize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("error reading partitions from GPT backup: %w", err)
+	}
+	checksum := crc32.ChecksumIEEE(b)
+	if table.partitionEntryChecksum != checksum {
+		return nil, fmt.Errorf("invalid EFI Partition Entry Checksum, expected %v, got %v", checksum, table.partitionEntryChecksum)
+	}
+	parts, err := readPartitionArrayBytes(b, entrySize, logicalBlockSize, physicalBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing partition data: %w", err)
+	}
+
+	table.Partitions = parts
+	table.ProtectiveMBR = readProtectiveMBR(mbr, uint32(table.secondaryHeader))
+	table.LogicalSectorSize = logicalBlockSize
+	table.PhysicalSectorSize = physicalBlockSize
+	table.initialized = true
+	return table, nil
+}
@@ -0,0 +1,109 @@
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+func TestBackup(t *testing.T) {
+	f, err := os.Open(gptFile)
+	if err != nil {
+		t.Fatalf("error opening file %s: %v", gptFile, err)
+	}
+	defer f.Close()
+	table, err := gpt.Read(f, 512, 512)
+	if err != nil {
+		t.Fatalf("error reading table: %v", err)
+	}
+
+	t.Run("write", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := table.WriteBackup(f, &buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b := buf.Bytes()
+		if len(b) != 3*512+gptSize {
+			t.Fatalf("backup was %d bytes instead of expected %d", len(b), 3*512+gptSize)
+		}
+		// MBR, primary header and partition array must match what is on disk
+		disk := make([]byte, 2*512+gptSize)
+		if _, err := f.ReadAt(disk, 0); err != nil {
+			t.Fatalf("error reading disk: %v", err)
+		}
+		if !bytes.Equal(b[:512], disk[:512]) {
+			t.Errorf("mismatched MBR")
+		}
+		if !bytes.Equal(b[512:1024], disk[512:1024]) {
+			t.Errorf("mismatched primary header")
+		}
+		if !bytes.Equal(b[3*512:], disk[1024:]) {
+			t.Errorf("mismatched partition array")
+		}
+	})
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := table.WriteBackup(f, &buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		restored, err := gpt.ReadBackup(&buf, 512, 512)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !restored.Equal(table) {
+			t.Errorf("restored table does not match original")
+		}
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := table.WriteBackup(f, &buf); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b := buf.Bytes()
+		// damage a partition entry
+		b[3*512+40] ^= 0xff
+		if _, err := gpt.ReadBackup(bytes.NewReader(b), 512, 512); err == nil {
+			t.Errorf("expected checksum error for corrupt backup")
+		}
+	})
+	t.Run("invalid partition array", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			count     uint32
+			entrySize uint32
+		}{
+			{"entry size not a multiple of 128", 128, 136},
+			{"entry size too large", 128, 1 << 20},
+			{"too many entries", 1 << 30, 128},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var buf bytes.Buffer
+				if err := table.WriteBackup(f, &buf); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b := buf.Bytes()
+				// set the array in both headers, with their checksums fixed up
+				for _, header := range [][]byte{b[512:1024], b[1024:1536]} {
+					binary.LittleEndian.PutUint32(header[80:84], tt.count)
+					binary.LittleEndian.PutUint32(header[84:88], tt.entrySize)
+					binary.LittleEndian.PutUint32(header[16:20], 0)
+					binary.LittleEndian.PutUint32(header[16:20], crc32.ChecksumIEEE(header[:92]))
+				}
+				if _, err := gpt.ReadBackup(bytes.NewReader(b), 512, 512); err == nil {
+					t.Errorf("expected error for invalid partition array")
+				}
+			})
+		}
+	})
+	t.Run("uninitialized", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := (&gpt.Table{}).WriteBackup(f, &buf); err == nil {
+			t.Errorf("expected error for uninitialized table")
+		}
+	})
+}
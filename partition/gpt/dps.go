@@ -0,0 +1,302 @@
+package gpt
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Role is the role of a partition in the Discoverable Partitions Specification of systemd, see
+// https://uapi-group.org/specifications/specs/discoverable_partitions_specification/, named as
+// the partition types of systemd-repart
+type Role string
+
+// Roles of partitions, those from RoleRoot to RoleUsrVeritySig with a type for each architecture
+const (
+	RoleRoot          Role = "root"
+	RoleUsr           Role = "usr"
+	RoleRootVerity    Role = "root-verity"
+	RoleUsrVerity     Role = "usr-verity"
+	RoleRootVeritySig Role = "root-verity-sig"
+	RoleUsrVeritySig  Role = "usr-verity-sig"
+	RoleESP           Role = "esp"
+	RoleXBOOTLDR      Role = "xbootldr"
+	RoleSwap          Role = "swap"
+	RoleHome          Role = "home"
+	RoleSrv           Role = "srv"
+	RoleVar           Role = "var"
+	RoleTmp           Role = "tmp"
+	RoleUserHome      Role = "user-home"
+	RoleLinuxGeneric  Role = "linux-generic"
+)
+
+// Architecture is an architecture of the Discoverable Partitions Specification, named as by systemd
+type Architecture string
+
+// Architectures of the root and /usr partitions
+const (
+	ArchAlpha       Architecture = "alpha"
+	ArchARC         Architecture = "arc"
+	ArchARM         Architecture = "arm"
+	ArchARM64       Architecture = "arm64"
+	ArchIA64        Architecture = "ia64"
+	ArchLoongArch64 Architecture = "loongarch64"
+	ArchMIPSLE      Architecture = "mips-le"
+	ArchMIPS64LE    Architecture = "mips64-le"
+	ArchPPC         Architecture = "ppc"
+	ArchPPC64       Architecture = "ppc64"
+	ArchPPC64LE     Architecture = "ppc64-le"
+	ArchRISCV32     Architecture = "riscv32"
+	ArchRISCV64     Architecture = "riscv64"
+	ArchS390        Architecture = "s390"
+	ArchS390X       Architecture = "s390x"
+	ArchTILEGx      Architecture = "tilegx"
+	ArchX86         Architecture = "x86"
+	ArchX86_64      Architecture = "x86-64"
+)
+
+// Attributes of partitions, those of UEFI and those of the Discoverable Partitions Specification
+const (
+	// AttributeRequired marks a partition required by the platform, not to be deleted
+	AttributeRequired uint64 = 1 << 0
+	// AttributeNoBlockIO hides a partition from the firmware
+	AttributeNoBlockIO uint64 = 1 << 1
+	// AttributeLegacyBIOSBootable marks the partition to boot from with a BIOS
+	AttributeLegacyBIOSBootable uint64 = 1 << 2
+	// AttributeGrowFS lets systemd grow the filesystem of the partition to its size
+	AttributeGrowFS uint64 = 1 << 59
+	// AttributeReadOnly lets systemd mount the partition only read-only
+	AttributeReadOnly uint64 = 1 << 60
+	// AttributeNoAuto keeps systemd from mounting the partition by its role
+	AttributeNoAuto uint64 = 1 << 63
+)
+
+// archTypes are the types of the roles with one for each architecture
+var archTypes = map[Role]map[Architecture]Type{
+	RoleRoot: {
+		ArchAlpha:       "6523F8AE-3EB1-4E2A-A05A-18B695AE656F",
+		ArchARC:         "D27F46ED-2919-4CB8-BD25-9531F3C16534",
+		ArchARM:         LinuxRootArm,
+		ArchARM64:       LinuxRootArm64,
+		ArchIA64:        LinuxRootIA64,
+		ArchLoongArch64: "77055800-792C-4F94-B39A-98C91B762BB6",
+		ArchMIPSLE:      "37C58C8A-D913-4156-A25F-48B1B64E07F0",
+		ArchMIPS64LE:    "700BDA43-7A34-4507-B179-EEB93D7A7CA3",
+		ArchPPC:         "1DE3F1EF-FA98-47B5-8DCD-4A860A654D78",
+		ArchPPC64:       "912ADE1D-A839-4913-8964-A10EEE08FBD2",
+		ArchPPC64LE:     "C31C45E6-3F39-412E-80FB-4809C4980599",
+		ArchRISCV32:     "60D5A7FE-8E7D-435C-B714-3DD8162144E1",
+		ArchRISCV64:     "72EC70A6-CF74-40E6-BD49-4BDA08E8F224",
+		ArchS390:        "08A7ACEA-624C-4A20-91E8-6E0FA67D23F9",
+		ArchS390X:       "5EEAD9A9-FE09-4A1E-A1D7-520D00531306",
+		ArchTILEGx:      "C50CDD70-3862-4CC3-90E1-809A8C93EE2C",
+		ArchX86:         LinuxRootX86,
+		ArchX86_64:      LinuxRootX86_64,
+	},
+	RoleUsr: {
+		ArchAlpha:       "E18CF08C-33EC-4C0D-8246-C6C6FB3DA024",
+		ArchARC:         "7978A683-6316-4922-BBEE-38BFF5A2FECC",
+		ArchARM:         "7D0359A3-02B3-4F0A-865C-654403E70625",
+		ArchARM64:       "B0E01050-EE5F-4390-949A-9101B17104E9",
+		ArchIA64:        "4301D2A6-4E3B-4B2A-BB94-9E0B2C4225EA",
+		ArchLoongArch64: "E611C702-575C-4CBE-9A46-434FA0BF7E3F",
+		ArchMIPSLE:      "0F4868E9-9952-4706-979F-3ED3A473E947",
+		ArchMIPS64LE:    "C97C1F32-BA06-40B4-9F22-236061B08AA8",
+		ArchPPC:         "7D14FEC5-CC71-415D-9D6C-06BF0B3C3EAF",
+		ArchPPC64:       "2C9739E2-F068-46B3-9FD0-01C5A9AFBCCA",
+		ArchPPC64LE:     "15BB03AF-77E7-4D4A-B12B-C0D084F7491C",
+		ArchRISCV32:     "B933FB22-5C3F-4F91-AF90-E2BB0FA50702",
+		ArchRISCV64:     "BEAEC34B-8442-439B-A40B-984381ED097D",
+		ArchS390:        "CD0F869B-D0FB-4CA0-B141-9EA87CC78D66",
+		ArchS390X:       "8A4F5770-50AA-4ED3-874A-99B710DB6FEA",
+		ArchTILEGx:      "55497029-C7C1-44CC-AA39-815ED1558630",
+		ArchX86:         "75250D76-8CC6-458E-BD66-BD47CC81A812",
+		ArchX86_64:      "8484680C-9521-48C6-9C11-B0720656F69E",
+	},
+	RoleRootVerity: {
+		ArchAlpha:       "FC56D9E9-E6E5-4C06-BE32-E74407CE09A5",
+		ArchARC:         "24B2D975-0F97-4521-AFA1-CD531E421B8D",
+		ArchARM:         "7386CDF2-203C-47A9-A498-F2ECCE45A2D6",
+		ArchARM64:       "DF3300CE-D69F-4C92-978C-9BFB0F38D820",
+		ArchIA64:        "86ED10D5-B607-45BB-8957-D350F23D0571",
+		ArchLoongArch64: "F3393B22-E9AF-4613-A948-9D3BFBD0C535",
+		ArchMIPSLE:      "D7D150D2-2A04-4A33-8F12-16651205FF7B",
+		ArchMIPS64LE:    "16B417F8-3E06-4F57-8DD2-9B5232F41AA6",
+		ArchPPC:         "98CFE649-1588-46DC-B2F0-ADD147424925",
+		ArchPPC64:       "9225A9A3-3C19-4D89-B4F6-EEFF88F17631",
+		ArchPPC64LE:     "906BD944-4589-4AAE-A4E4-DD983917446A",
+		ArchRISCV32:     "AE0253BE-1167-4007-AC68-43926C14C5DE",
+		ArchRISCV64:     "B6ED5582-440B-4209-B8DA-5FF7C419EA3D",
+		ArchS390:        "7AC63B47-B25C-463B-8DF8-B4A94E6C90E1",
+		ArchS390X:       "B325BFBE-C7BE-4AB8-8357-139E652D2F6B",
+		ArchTILEGx:      "966061EC-28E4-4B2E-B4A5-1F0A825A1D84",
+		ArchX86:         "D13C5D3B-B5D1-422A-B29F-9454FDC89D76",
+		ArchX86_64:      "2C7357ED-EBD2-46D9-AEC1-23D437EC2BF5",
+	},
+	RoleUsrVerity: {
+		ArchAlpha:       "8CCE0D25-C0D0-4A44-BD87-46331BF1DF67",
+		ArchARC:         "FCA0598C-D880-4591-8C16-4EDA05C7347C",
+		ArchARM:         "C215D751-7BCD-4649-BE90-6627490A4C05",
+		ArchARM64:       "6E11A4E7-FBCA-4DED-B9E9-E1A512BB664E",
+		ArchIA64:        "6A491E03-3BE7-4545-8E38-83320E0EA880",
+		ArchLoongArch64: "F46B2C26-59AE-48F0-9106-C50ED47F673D",
+		ArchMIPSLE:      "46B98D8D-B55C-4E8F-AAB3-37FCA7F80752",
+		ArchMIPS64LE:    "3C3D61FE-B5F3-414D-BB71-8739A694A4EF",
+		ArchPPC:         "DF765D00-270E-49E5-BC75-F47BB2118B09",
+		ArchPPC64:       "BDB528A5-A259-475F-A87D-DA53FA736A07",
+		ArchPPC64LE:     "EE2B9983-21E8-4153-86D9-B6901A54D1CE",
+		ArchRISCV32:     "CB1EE4E3-8CD0-4136-A0A4-AA61A32E8730",
+		ArchRISCV64:     "8F1056BE-9B05-47C4-81D6-BE53128E5B54",
+		ArchS390:        "B663C618-E7BC-4D6D-90AA-11B756BB1797",
+		ArchS390X:       "31741CC4-1A2A-4111-A581-E00B447D2D06",
+		ArchTILEGx:      "2FB4BF56-07FA-42DA-8132-6B139F2026AE",
+		ArchX86:         "8F461B0D-14EE-4E81-9AA9-049B6FB97ABD",
+		ArchX86_64:      "77FF5F63-E7B6-4633-ACF4-1565B864C0E6",
+	},
+	RoleRootVeritySig: {
+		ArchAlpha:       "D46495B7-A053-414F-80F7-700C99921EF8",
+		ArchARC:         "143A70BA-CBD3-4F06-919F-6C05683A78BC",
+		ArchARM:         "42B0455F-EB11-491D-98D3-56145BA9D037",
+		ArchARM64:       "6DB69DE6-29F4-4758-A7A5-962190F00CE3",
+		ArchIA64:        "E98B36EE-32BA-4882-9B12-0CE14655F46A",
+		ArchLoongArch64: "5AFB67EB-ECC8-4F85-AE8E-AC1E7C50E7D0",
+		ArchMIPSLE:      "C919CC1F-4456-4EFF-918C-F75E94525CA5",
+		ArchMIPS64LE:    "904E58EF-5C65-4A31-9C57-6AF5FC7C5DE7",
+		ArchPPC:         "1B31B5AA-ADD9-463A-B2ED-BD467FC857E7",
+		ArchPPC64:       "F5E2C20C-45B2-4FFA-BCE9-2A60737E1AAF",
+		ArchPPC64LE:     "D4A236E7-E873-4C07-BF1D-BF6CF7F1C3C6",
+		ArchRISCV32:     "3A112A75-8729-4380-B4CF-764D79934448",
+		ArchRISCV64:     "EFE0F087-EA8D-4469-821A-4C2A96A8386A",
+		ArchS390:        "3482388E-4254-435A-A241-766A065F9960",
+		ArchS390X:       "C80187A5-73A3-491A-901A-017C3FA953E9",
+		ArchTILEGx:      "B3671439-97B0-4A53-90F7-2D5A8F3AD47B",
+		ArchX86:         "5996FC05-109C-48DE-808B-23FA0830B676",
+		ArchX86_64:      "41092B05-9FC8-4523-994F-2DEF0408B176",
+	},
+	RoleUsrVeritySig: {
+		ArchAlpha:       "5C6E1C76-076A-457A-A0FE-F3B4CD21CE6E",
+		ArchARC:         "94F9A9A1-9971-427A-A400-50CB297F0F35",
+		ArchARM:         "D7FF812F-37D1-4902-A810-D76BA57B975A",
+		ArchARM64:       "C23CE4FF-44BD-4B00-B2D4-B41B3419E02A",
+		ArchIA64:        "8DE58BC2-2A43-460D-B14E-A76E4A17B47F",
+		ArchLoongArch64: "B024F315-D330-444C-8461-44BBDE524E99",
+		ArchMIPSLE:      "3E23CA0B-A4BC-4B4E-8087-5AB6A26AA8A9",
+		ArchMIPS64LE:    "F2C2C7EE-ADCC-4351-B5C6-EE9816B66E16",
+		ArchPPC:         "7007891D-D371-4A80-86A4-5CB875B9302E",
+		ArchPPC64:       "0B888863-D7F8-4D9E-9766-239FCE4D58AF",
+		ArchPPC64LE:     "C8BFBD1E-268E-4521-8BBA-BF314C399557",
+		ArchRISCV32:     "C3836A13-3137-45BA-B583-B16C50FE5EB4",
+		ArchRISCV64:     "D2F9000A-7A18-453F-B5CD-4D32F77A7B32",
+		ArchS390:        "17440E4F-A8D0-467F-A46E-3912AE6EF2C5",
+		ArchS390X:       "3F324816-667B-46AE-86EE-9B0C0C6C11B4",
+		ArchTILEGx:      "4EDE75E2-6CCC-4CC8-B9C7-70334B087510",
+		ArchX86:         "974A71C0-DE41-43C3-BE5D-5C5CCD1AD2C0",
+		ArchX86_64:      "E7BB33FB-06CF-4E81-8273-E543B413E2E2",
+	},
+}
+
+// commonTypes are the types of the roles of all architectures
+var commonTypes = map[Role]Type{
+	RoleESP:          EFISystemPartition,
+	RoleXBOOTLDR:     LinuxExtendedBoot,
+	RoleSwap:         LinuxSwap,
+	RoleHome:         LinuxHome,
+	RoleSrv:          LinuxServerData,
+	RoleVar:          "4D21B016-B534-45C2-A9FB-5C16E091FD2D",
+	RoleTmp:          "7EC6F557-3BC5-4ACA-B293-16EF5DF639D1",
+	RoleUserHome:     "773F91EF-66D4-49B5-BD83-D683BF40AD16",
+	RoleLinuxGeneric: LinuxFilesystem,
+}
+
+// goArchitectures are the architectures of the values of GOARCH
+var goArchitectures = map[string]Architecture{
+	"386":      ArchX86,
+	"amd64":    ArchX86_64,
+	"arm":      ArchARM,
+	"arm64":    ArchARM64,
+	"loong64":  ArchLoongArch64,
+	"mipsle":   ArchMIPSLE,
+	"mips64le": ArchMIPS64LE,
+	"ppc64":    ArchPPC64,
+	"ppc64le":  ArchPPC64LE,
+	"riscv64":  ArchRISCV64,
+	"s390x":    ArchS390X,
+}
+
+// NativeArchitecture returns the architecture the program runs on, or "" if it has no partition
+// types
+func NativeArchitecture() Architecture {
+	return goArchitectures[runtime.GOARCH]
+}
+
+// DiscoverableType returns the partition type of the role, for the architecture if the role has a
+// type for each, such as RoleRoot
+func DiscoverableType(role Role, arch Architecture) (Type, error) {
+	if t, ok := commonTypes[role]; ok {
+		return t, nil
+	}
+	types, ok := archTypes[role]
+	if !ok {
+		return "", fmt.Errorf("unknown role %s", role)
+	}
+	t, ok := types[arch]
+	if !ok {
+		return "", fmt.Errorf("no %s partition type for architecture %q", role, arch)
+	}
+	return t, nil
+}
+
+// DiscoverablePartition returns a partition of the role and architecture of size bytes, named
+// like those of systemd-repart, e.g. "root-x86-64", to be placed after the previous partition of
+// the table unless Start is set
+func DiscoverablePartition(role Role, arch Architecture, size uint64) (*Partition, error) {
+	t, err := DiscoverableType(role, arch)
+	if err != nil {
+		return nil, err
+	}
+	name := string(role)
+	if _, ok := archTypes[role]; ok {
+		// the architecture goes before the suffix, as in root-x86-64-verity
+		base, suffix, _ := strings.Cut(name, "-")
+		name = base + "-" + string(arch)
+		if suffix != "" {
+			name += "-" + suffix
+		}
+	}
+	return &Partition{Type: t, Name: name, Size: size}, nil
+}
+
+// Role returns the role of the partition in the Discoverable Partitions Specification, and its
+// architecture for roles with a type for each, or false if its type has no role
+func (p *Partition) Role() (Role, Architecture, bool) {
+	t := Type(strings.ToUpper(string(p.Type)))
+	for role, common := range commonTypes {
+		if t == common {
+			return role, "", true
+		}
+	}
+	for role, types := range archTypes {
+		for arch, archType := range types {
+			if t == archType {
+				return role, arch, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// Discoverable returns the partition of the role and architecture, and its number from 1, the
+// first of the table that systemd would mount, without AttributeNoAuto, or false if there is none.
+// The architecture is ignored for roles of all architectures.
+func (t *Table) Discoverable(role Role, arch Architecture) (int, *Partition, bool) {
+	for i, p := range t.Partitions {
+		if p.Attributes&AttributeNoAuto != 0 {
+			continue
+		}
+		r, a, ok := p.Role()
+		if ok && r == role && (a == arch || a == "") {
+			return i + 1, p, true
+		}
+	}
+	return 0, nil, false
+}
@@ -0,0 +1,108 @@
+package gpt_test
+
+import (
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+func TestDiscoverableType(t *testing.T) {
+	tests := []struct {
+		role     gpt.Role
+		arch     gpt.Architecture
+		expected gpt.Type
+	}{
+		{gpt.RoleRoot, gpt.ArchX86_64, gpt.LinuxRootX86_64},
+		{gpt.RoleRoot, gpt.ArchARM64, gpt.LinuxRootArm64},
+		{gpt.RoleUsr, gpt.ArchX86_64, "8484680C-9521-48C6-9C11-B0720656F69E"},
+		{gpt.RoleRootVerity, gpt.ArchX86_64, "2C7357ED-EBD2-46D9-AEC1-23D437EC2BF5"},
+		{gpt.RoleUsrVeritySig, gpt.ArchRISCV64, "D2F9000A-7A18-453F-B5CD-4D32F77A7B32"},
+		{gpt.RoleESP, "", gpt.EFISystemPartition},
+		{gpt.RoleXBOOTLDR, gpt.ArchARM, gpt.LinuxExtendedBoot},
+		{gpt.RoleVar, "", "4D21B016-B534-45C2-A9FB-5C16E091FD2D"},
+	}
+	for _, tt := range tests {
+		typ, err := gpt.DiscoverableType(tt.role, tt.arch)
+		if err != nil || typ != tt.expected {
+			t.Errorf("type of %s for %q is %s, %v instead of %s", tt.role, tt.arch, typ, err, tt.expected)
+		}
+		role, arch, ok := (&gpt.Partition{Type: typ}).Role()
+		if !ok || role != tt.role || (arch != tt.arch && arch != "") {
+			t.Errorf("role of %s is %s, %q, %v instead of %s, %q", typ, role, arch, ok, tt.role, tt.arch)
+		}
+	}
+	for _, invalid := range []struct {
+		role gpt.Role
+		arch gpt.Architecture
+	}{{"boot", gpt.ArchX86_64}, {gpt.RoleRoot, ""}, {gpt.RoleUsr, "vax"}} {
+		if _, err := gpt.DiscoverableType(invalid.role, invalid.arch); err == nil {
+			t.Errorf("found a type of %s for %q", invalid.role, invalid.arch)
+		}
+	}
+	if _, _, ok := (&gpt.Partition{Type: gpt.MicrosoftBasicData}).Role(); ok {
+		t.Errorf("found a role for %s", gpt.MicrosoftBasicData)
+	}
+}
+
+func TestDiscoverableLayout(t *testing.T) {
+	const size = 64 * 1024 * 1024
+	var partitions []*gpt.Partition
+	for _, p := range []struct {
+		role gpt.Role
+		size uint64
+		name string
+	}{
+		{gpt.RoleESP, 8 * 1024 * 1024, "esp"},
+		{gpt.RoleRoot, 16 * 1024 * 1024, "root-arm64"},
+		{gpt.RoleRootVerity, 1024 * 1024, "root-arm64-verity"},
+		{gpt.RoleRootVeritySig, 16 * 1024, "root-arm64-verity-sig"},
+		{gpt.RoleRoot, 16 * 1024 * 1024, "root-arm64"},
+	} {
+		partition, err := gpt.DiscoverablePartition(p.role, gpt.ArchARM64, p.size)
+		if err != nil {
+			t.Fatalf("error creating %s partition: %v", p.role, err)
+		}
+		if partition.Name != p.name {
+			t.Errorf("partition named %s instead of %s", partition.Name, p.name)
+		}
+		partitions = append(partitions, partition)
+	}
+	// the first root is the inactive one of an A/B update
+	partitions[1].Attributes = gpt.AttributeNoAuto | gpt.AttributeReadOnly
+	partitions[1].Start = 2048 + 8*1024*2
+
+	b, err := mem.Create(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := b.Writable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&gpt.Table{ProtectiveMBR: true, Partitions: partitions}).Write(w, size); err != nil {
+		t.Fatalf("error writing table: %v", err)
+	}
+	table, err := gpt.Read(b, 512, 512)
+	if err != nil {
+		t.Fatalf("error reading table: %v", err)
+	}
+
+	for _, tt := range []struct {
+		role     gpt.Role
+		arch     gpt.Architecture
+		expected int
+	}{
+		{gpt.RoleESP, "", 1},
+		{gpt.RoleESP, gpt.ArchARM64, 1},
+		{gpt.RoleRoot, gpt.ArchARM64, 5},
+		{gpt.RoleRootVerity, gpt.ArchARM64, 3},
+		{gpt.RoleRoot, gpt.ArchX86_64, 0},
+		{gpt.RoleXBOOTLDR, "", 0},
+	} {
+		n, p, ok := table.Discoverable(tt.role, tt.arch)
+		if n != tt.expected || ok != (tt.expected != 0) || (ok && p != table.Partitions[n-1]) {
+			t.Errorf("found %s partition %d, %v instead of %d", tt.role, n, ok, tt.expected)
+		}
+	}
+}
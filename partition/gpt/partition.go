@@ -10,6 +10,7 @@ import (
 	"unicode/utf16"
 
 	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/util"
 	uuid "github.com/google/uuid"
 )
 
@@ -173,7 +174,7 @@ func (p *Partition) WriteContents(f backend.WritableFile, contents io.Reader) (u
 	for {
 		read, err := contents.Read(b)
 		if err != nil && err != io.EOF {
-			return total, fmt.Errorf("could not read contents to pass to partition: %v", err)
+			return total, fmt.Errorf("could not read contents to pass to partition: %w", err)
 		}
 		tmpTotal := uint64(read) + total
 		if tmpTotal > p.Size {
@@ -239,7 +240,7 @@ func (p *Partition) initEntry(blocksize, starting uint64) error {
 	var guid uuid.UUID
 
 	if part.GUID == "" {
-		guid, _ = uuid.NewRandom()
+		guid, _ = uuid.NewRandomFromReader(util.Rand)
 	} else {
 		var err error
 		guid, err = uuid.Parse(part.GUID)
@@ -0,0 +1,189 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+// usedEntriesReadSize is how many bytes of partition entries are read at a time with
+// WithUsedEntries, 32 entries of the usual size
+const usedEntriesReadSize = 4096
+
+// ReadOpt is an option of ReadWithOptions
+type ReadOpt func(o *readOpts)
+
+type readOpts struct {
+	usedEntries  bool
+	verifyBackup bool
+}
+
+// WithUsedEntries reads the partition entries only as far as they are used, usedEntriesReadSize
+// bytes of them at a time, stopping after the first of these reads with no used entry, rather
+// than the whole array of usually 128 entries. This is for scanning many disks, where reading
+// the table is bound by I/O. The checksum of the entries can only be verified, and is, when they
+// are all read. Partitions after 32 unused entries in a row are not found, which partitioning
+// tools do not create, as they use the first unused entry for a new partition.
+func WithUsedEntries() ReadOpt {
+	return func(o *readOpts) {
+		o.usedEntries = true
+	}
+}
+
+// WithBackupVerification verifies the backup header and partition entries at the end of the
+// disk as the table is read, with VerifyBackup, which reading leaves for later otherwise.
+func WithBackupVerification() ReadOpt {
+	return func(o *readOpts) {
+		o.verifyBackup = true
+	}
+}
+
+// ReadWithOptions reads a partition table from a disk like Read, as the options ask. The
+// protective MBR, the header and the partition entries that usually follow it are read at once.
+func ReadWithOptions(f backend.File, logicalBlockSize, physicalBlockSize int, opts ...ReadOpt) (*Table, error) {
+	o := readOpts{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// read the data off of the disk - first block is the compatibility MBR, second is the GPT
+	// header, usually followed by the partition entries
+	ahead := 128 * 128
+	if o.usedEntries {
+		ahead = usedEntriesReadSize
+	}
+	b := make([]byte, logicalBlockSize*2+ahead)
+	read, err := f.ReadAt(b, 0)
+	if err != nil && (!errors.Is(err, io.EOF) || read < logicalBlockSize*2) {
+		return nil, fmt.Errorf("error reading GPT from file: %w", err)
+	}
+	if read < logicalBlockSize*2 {
+		return nil, fmt.Errorf("read only %d bytes of GPT from file instead of expected %d", read, logicalBlockSize*2)
+	}
+	b = b[:read]
+	// get the gpt table
+	gptTable, err := tableFromBytes(b[:logicalBlockSize*2], logicalBlockSize, physicalBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GPT table: %w", err)
+	}
+	entrySize := int(gptTable.partitionEntrySize)
+	if entrySize < 128 || entrySize%8 != 0 {
+		return nil, fmt.Errorf("error reading GPT table: invalid partition entry size %d", entrySize)
+	}
+	start, size := gptTable.calculatePartitionArrayLocations()
+	// readEntries returns the bytes of the entries from offset in the array, from what was read
+	// with the header where it has them
+	readEntries := func(offset, size int) ([]byte, error) {
+		from := start + offset
+		if from+size <= len(b) {
+			return b[from : from+size], nil
+		}
+		buf := make([]byte, size)
+		read, err := f.ReadAt(buf, int64(from))
+		if read != len(buf) {
+			return nil, fmt.Errorf("read only %d bytes of GPT from file instead of expected %d", read, len(buf))
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("error reading partitions from file: %w", err)
+		}
+		return buf, nil
+	}
+
+	var entries []byte
+	if o.usedEntries {
+		step := max(usedEntriesReadSize/entrySize, 1) * entrySize
+		for offset := 0; offset < size; offset += step {
+			c, err := readEntries(offset, min(step, size-offset))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, c...)
+			if !anyEntryUsed(c, entrySize) {
+				break
+			}
+		}
+	} else {
+		if entries, err = readEntries(0, size); err != nil {
+			return nil, err
+		}
+	}
+	// we need a CRC/zlib of the partition entries, so we do those first, then append the bytes
+	if len(entries) == size {
+		checksum := crc32.ChecksumIEEE(entries)
+		if gptTable.partitionEntryChecksum != checksum {
+			return nil, fmt.Errorf("invalid EFI Partition Entry Checksum, expected %v, got %v", checksum, gptTable.partitionEntryChecksum)
+		}
+	}
+
+	parts, err := readPartitionArrayBytes(entries, entrySize, logicalBlockSize, physicalBlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing partition data: %w", err)
+	}
+	gptTable.Partitions = parts
+	if o.verifyBackup {
+		if err := gptTable.VerifyBackup(f); err != nil {
+			return nil, err
+		}
+	}
+	return gptTable, nil
+}
+
+// anyEntryUsed reports whether any of the partition entries has a type
+func anyEntryUsed(b []byte, entrySize int) bool {
+	for ; len(b) >= entrySize; b = b[entrySize:] {
+		if !zeroMatch(b[:16]) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyBackup verifies the backup header, where the primary header of the table read says it
+// is, and the backup partition entries it points to: that their checksums are right, and that
+// they describe the same table as the primary ones. Read does not, as they are at the other end
+// of the disk.
+func (t *Table) VerifyBackup(f backend.File) error {
+	if !t.initialized {
+		return fmt.Errorf("table is not initialized, read it from a disk first")
+	}
+	b := make([]byte, t.LogicalSectorSize)
+	location := int64(t.secondaryHeader) * int64(t.LogicalSectorSize)
+	read, err := f.ReadAt(b, location)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading backup GPT header at %d: %w", location, err)
+	}
+	if read != len(b) {
+		return fmt.Errorf("read only %d bytes of backup GPT header at %d instead of expected %d", read, location, len(b))
+	}
+	backup, err := readGPTHeader(b)
+	if err != nil {
+		return fmt.Errorf("invalid backup GPT header at %d: %w", location, err)
+	}
+	switch {
+	case backup.primaryHeader != t.secondaryHeader || backup.secondaryHeader != t.primaryHeader:
+		return fmt.Errorf("backup GPT header at LBA %d gives itself at LBA %d and the primary one at %d", t.secondaryHeader, backup.primaryHeader, backup.secondaryHeader)
+	case backup.GUID != t.GUID:
+		return fmt.Errorf("backup GPT header has disk GUID %s instead of %s", backup.GUID, t.GUID)
+	case backup.firstDataSector != t.firstDataSector || backup.lastDataSector != t.lastDataSector:
+		return fmt.Errorf("backup GPT header has data sectors %d to %d instead of %d to %d", backup.firstDataSector, backup.lastDataSector, t.firstDataSector, t.lastDataSector)
+	case backup.partitionArraySize != t.partitionArraySize || backup.partitionEntrySize != t.partitionEntrySize:
+		return fmt.Errorf("backup GPT header has %d partition entries of %d bytes instead of %d of %d", backup.partitionArraySize, backup.partitionEntrySize, t.partitionArraySize, t.partitionEntrySize)
+	case backup.partitionEntryChecksum != t.partitionEntryChecksum:
+		return fmt.Errorf("backup GPT header has partition entry checksum %v instead of %v", backup.partitionEntryChecksum, t.partitionEntryChecksum)
+	}
+	entries := make([]byte, backup.partitionArraySize*int(backup.partitionEntrySize))
+	location = int64(backup.partitionFirstLBA) * int64(t.LogicalSectorSize)
+	read, err = f.ReadAt(entries, location)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading backup partition entries at %d: %w", location, err)
+	}
+	if read != len(entries) {
+		return fmt.Errorf("read only %d bytes of backup partition entries at %d instead of expected %d", read, location, len(entries))
+	}
+	if checksum := crc32.ChecksumIEEE(entries); checksum != backup.partitionEntryChecksum {
+		return fmt.Errorf("invalid backup EFI Partition Entry Checksum, expected %v, got %v", checksum, backup.partitionEntryChecksum)
+	}
+	return nil
+}
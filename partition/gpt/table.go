@@ -9,6 +9,7 @@ import (
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/diskfs/go-diskfs/util"
 	uuid "github.com/google/uuid"
 )
 
@@ -84,7 +85,7 @@ func (t *Table) initTable(size int64) {
 		t.primaryHeader = 1
 	}
 	if t.GUID == "" {
-		guid, _ := uuid.NewRandom()
+		guid, _ := uuid.NewRandomFromReader(util.Rand)
 		t.GUID = guid.String()
 	}
 	if t.partitionArraySize == 0 {
@@ -284,7 +285,7 @@ func (t *Table) toGPTBytes(primary bool) ([]byte, error) {
 	// 16 bytes disk GUID
 	var guid uuid.UUID
 	if t.GUID == "" {
-		guid, _ = uuid.NewRandom()
+		guid, _ = uuid.NewRandomFromReader(util.Rand)
 	} else {
 		var err error
 		guid, err = uuid.Parse(t.GUID)
@@ -541,42 +542,7 @@ func (t *Table) Write(f backend.WritableFile, size int64) error {
 // if successful, returns a gpt.Table struct
 // returns errors if fails at any stage reading the disk or processing the bytes on disk as a GPT
 func Read(f backend.File, logicalBlockSize, physicalBlockSize int) (*Table, error) {
-	// read the data off of the disk - first block is the compatibility MBR, ssecond is the GPT table
-	b := make([]byte, logicalBlockSize*2)
-	read, err := f.ReadAt(b, 0)
-	if err != nil {
-		return nil, fmt.Errorf("error reading GPT from file: %w", err)
-	}
-	if read != len(b) {
-		return nil, fmt.Errorf("read only %d bytes of GPT from file instead of expected %d", read, len(b))
-	}
-	// get the gpt table
-	gptTable, err := tableFromBytes(b, logicalBlockSize, physicalBlockSize)
-	if err != nil {
-		return nil, fmt.Errorf("error reading GPT table: %w", err)
-	}
-	start, size := gptTable.calculatePartitionArrayLocations()
-	b = make([]byte, size)
-	read, err = f.ReadAt(b, int64(start))
-	if read != len(b) {
-		return nil, fmt.Errorf("read only %d bytes of GPT from file instead of expected %d", read, len(b))
-	}
-	if err != nil {
-		return nil, fmt.Errorf("error reading partitions from file: %w", err)
-	}
-	// we need a CRC/zlib of the partition entries, so we do those first, then append the bytes
-	checksum := crc32.ChecksumIEEE(b)
-	if gptTable.partitionEntryChecksum != checksum {
-		return nil, fmt.Errorf("invalid EFI Partition Entry Checksum, expected %v, got %v", checksum, gptTable.partitionEntryChecksum)
-	}
-
-	parts, err := readPartitionArrayBytes(b, int(gptTable.partitionEntrySize), logicalBlockSize, physicalBlockSize)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing partition data: %w", err)
-	}
-	gptTable.Partitions = parts
-	// get the partition table
-	return gptTable, nil
+	return ReadWithOptions(f, logicalBlockSize, physicalBlockSize)
 }
 
 // GetPartitions get the partitions
@@ -594,6 +560,42 @@ func (t *Table) UUID() string {
 	return t.GUID
 }
 
+// SetGUID sets the disk GUID, validating that it is a well-formed GUID.
+// The new GUID is written to disk on the next call to Write.
+func (t *Table) SetGUID(guid string) error {
+	u, err := uuid.Parse(guid)
+	if err != nil {
+		return fmt.Errorf("invalid disk GUID %s: %v", guid, err)
+	}
+	t.GUID = strings.ToUpper(u.String())
+	return nil
+}
+
+// RandomizeIdentifiers replaces the disk GUID and the unique GUIDs of all used partitions
+// with newly generated random GUIDs. Partition type GUIDs are left unchanged.
+//
+// Use this when cloning a disk, as disks or partitions with duplicate GUIDs attached to one
+// system confuse firmware, Windows and several boot managers.
+// The new GUIDs are written to disk on the next call to Write.
+func (t *Table) RandomizeIdentifiers() error {
+	guid, err := uuid.NewRandomFromReader(util.Rand)
+	if err != nil {
+		return fmt.Errorf("unable to generate disk GUID: %v", err)
+	}
+	t.GUID = strings.ToUpper(guid.String())
+	for i, p := range t.Partitions {
+		if p == nil || p.Type == Unused {
+			continue
+		}
+		guid, err := uuid.NewRandomFromReader(util.Rand)
+		if err != nil {
+			return fmt.Errorf("unable to generate GUID for partition %d: %v", i, err)
+		}
+		p.GUID = strings.ToUpper(guid.String())
+	}
+	return nil
+}
+
 // Verify will attempt to evaluate the headers
 func (t *Table) Verify(f backend.File, diskSize uint64) error {
 	if t.LogicalSectorSize == 0 {
@@ -544,3 +544,159 @@ func TestExpandPartition(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSetGUID(t *testing.T) {
+	table := gpt.GetValidTable()
+	if err := table.SetGUID("not-a-guid"); err == nil {
+		t.Errorf("expected error for invalid GUID")
+	}
+	guid := "d9ec1c4a-3d1e-4cc4-8f0e-0c1f3f5a3b2e"
+	if err := table.SetGUID(guid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if table.UUID() != strings.ToUpper(guid) {
+		t.Errorf("mismatched GUID, actual %s, expected %s", table.UUID(), strings.ToUpper(guid))
+	}
+}
+
+func TestRandomizeIdentifiers(t *testing.T) {
+	f, err := tmpDisk(gptFile, 0)
+	if err != nil {
+		t.Fatalf("error creating new temporary disk: %v", err)
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	table, err := gpt.Read(f, 512, 512)
+	if err != nil {
+		t.Fatalf("error reading table: %v", err)
+	}
+	oldGUID, oldPartGUID, oldType := table.GUID, table.Partitions[0].GUID, table.Partitions[0].Type
+	if err := table.RandomizeIdentifiers(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if table.GUID == oldGUID {
+		t.Errorf("disk GUID was not changed")
+	}
+	if table.Partitions[0].GUID == oldPartGUID {
+		t.Errorf("partition GUID was not changed")
+	}
+	if table.Partitions[0].Type != oldType {
+		t.Errorf("partition type GUID was changed from %s to %s", oldType, table.Partitions[0].Type)
+	}
+	if err := table.Write(f, tenMB); err != nil {
+		t.Fatalf("error writing table: %v", err)
+	}
+	reread, err := gpt.Read(f, 512, 512)
+	if err != nil {
+		t.Fatalf("error re-reading table: %v", err)
+	}
+	if reread.GUID != table.GUID || reread.Partitions[0].GUID != table.Partitions[0].GUID {
+		t.Errorf("randomized GUIDs were not persisted")
+	}
+}
+
+func TestReadWithOptions(t *testing.T) {
+	// a table with an unused entry between the partitions, and a corrupted entry far after them
+	newDisk := func(t *testing.T) *os.File {
+		f, err := tmpDisk("", tenMB)
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		t.Cleanup(func() {
+			f.Close()
+			os.Remove(f.Name())
+		})
+		table := &gpt.Table{
+			LogicalSectorSize:  512,
+			PhysicalSectorSize: 512,
+			ProtectiveMBR:      true,
+			Partitions: []*gpt.Partition{
+				{Start: 2048, End: 4095, Type: gpt.LinuxFilesystem},
+				{Type: gpt.Unused},
+				{Start: 4096, End: 8191, Type: gpt.LinuxFilesystem},
+			},
+		}
+		if err := table.Write(f, tenMB); err != nil {
+			t.Fatalf("error writing table: %v", err)
+		}
+		return f
+	}
+	counting := func(f *os.File, reads *int, bytesRead *int) *testhelper.FileImpl {
+		return &testhelper.FileImpl{
+			Reader: func(b []byte, offset int64) (int, error) {
+				*reads++
+				*bytesRead += len(b)
+				return f.ReadAt(b, offset)
+			},
+		}
+	}
+
+	t.Run("used entries", func(t *testing.T) {
+		f := newDisk(t)
+		full, err := gpt.Read(f, 512, 512)
+		if err != nil {
+			t.Fatalf("error reading table: %v", err)
+		}
+		var reads, bytesRead int
+		table, err := gpt.ReadWithOptions(counting(f, &reads, &bytesRead), 512, 512, gpt.WithUsedEntries())
+		if err != nil {
+			t.Fatalf("error reading used entries: %v", err)
+		}
+		if !table.Equal(full) {
+			t.Errorf("read %v instead of %v", table, full)
+		}
+		// the header with the first 32 entries, and then the next 32, none of which are used
+		if reads != 2 || bytesRead != 2*512+2*4096 {
+			t.Errorf("%d reads of %d bytes for the used entries", reads, bytesRead)
+		}
+		// an entry after the used ones is not read, nor is the checksum of all of them verified
+		if _, err := f.WriteAt([]byte{1}, 2*512+100*128); err != nil {
+			t.Fatalf("error corrupting entry: %v", err)
+		}
+		if _, err := gpt.Read(f, 512, 512); err == nil || !strings.Contains(err.Error(), "invalid EFI Partition Entry Checksum") {
+			t.Errorf("read corrupted table with error %v", err)
+		}
+		if _, err := gpt.ReadWithOptions(f, 512, 512, gpt.WithUsedEntries()); err != nil {
+			t.Errorf("error reading used entries of corrupted table: %v", err)
+		}
+	})
+	t.Run("all entries", func(t *testing.T) {
+		f := newDisk(t)
+		var reads, bytesRead int
+		if _, err := gpt.Read(counting(f, &reads, &bytesRead), 512, 512); err != nil {
+			t.Fatalf("error reading table: %v", err)
+		}
+		// the entries are read with the header
+		if reads != 1 || bytesRead != 2*512+gptSize {
+			t.Errorf("%d reads of %d bytes for the table", reads, bytesRead)
+		}
+	})
+	t.Run("backup", func(t *testing.T) {
+		f := newDisk(t)
+		table, err := gpt.ReadWithOptions(f, 512, 512, gpt.WithBackupVerification())
+		if err != nil {
+			t.Fatalf("error reading table with its backup: %v", err)
+		}
+		if err := table.VerifyBackup(f); err != nil {
+			t.Errorf("error verifying backup: %v", err)
+		}
+		// corrupt the backup entries, and then the backup header
+		if _, err := f.WriteAt([]byte{1}, tenMB-512-gptSize+200); err != nil {
+			t.Fatalf("error corrupting backup entries: %v", err)
+		}
+		if err := table.VerifyBackup(f); err == nil || !strings.Contains(err.Error(), "invalid backup EFI Partition Entry Checksum") {
+			t.Errorf("verified corrupted backup entries with error %v", err)
+		}
+		if _, err := f.WriteAt([]byte{1}, tenMB-512+60); err != nil {
+			t.Fatalf("error corrupting backup header: %v", err)
+		}
+		if _, err := gpt.ReadWithOptions(f, 512, 512, gpt.WithBackupVerification()); err == nil || !strings.Contains(err.Error(), "invalid backup GPT header") {
+			t.Errorf("read table with corrupted backup header with error %v", err)
+		}
+		// which reading without verifying it does not notice
+		if _, err := gpt.Read(f, 512, 512); err != nil {
+			t.Errorf("error reading table without its backup: %v", err)
+		}
+	})
+}
@@ -137,7 +137,7 @@ func (p *Partition) WriteContents(f backend.WritableFile, contents io.Reader) (u
 	for {
 		read, err := contents.Read(b)
 		if err != nil && err != io.EOF {
-			return total, fmt.Errorf("could not read contents to pass to partition: %v", err)
+			return total, fmt.Errorf("could not read contents to pass to partition: %w", err)
 		}
 		tmpTotal := uint64(read) + total
 		if tmpTotal > uint64(size) {
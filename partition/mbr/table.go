@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strconv"
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/partition/part"
+	"github.com/diskfs/go-diskfs/util"
 )
 
 // Table represents an MBR partition table to be applied to a disk or read from a disk
@@ -15,6 +17,9 @@ type Table struct {
 	LogicalSectorSize  int // logical size of a sector
 	PhysicalSectorSize int // physical size of the sector
 	partitionTableUUID string
+	// signatureChanged is set when the disk signature was changed via SetDiskSignature or
+	// RandomizeIdentifiers, and thus needs to be written out along with the partition entries
+	signatureChanged bool
 }
 
 const (
@@ -110,7 +115,11 @@ func tableFromBytes(b []byte) (*Table, error) {
 
 func readPartitionTableUUID(b []byte) string {
 	ptUUID := b[partitionTableUUIDStart:partitionTableUUIDEnd]
-	return fmt.Sprintf("%x", binary.LittleEndian.Uint32(ptUUID))
+	return formatDiskSignature(binary.LittleEndian.Uint32(ptUUID))
+}
+
+func formatDiskSignature(sig uint32) string {
+	return fmt.Sprintf("%x", sig)
 }
 
 // UUID returns the partition table UUID used to identify disks
@@ -118,6 +127,49 @@ func (t *Table) UUID() string {
 	return t.partitionTableUUID
 }
 
+// DiskSignature returns the 4-byte disk signature stored at offset 440 of the MBR.
+// This is the value that Windows and several boot managers use to identify a disk,
+// and from which the partition table UUID is derived.
+func (t *Table) DiskSignature() uint32 {
+	sig, err := strconv.ParseUint(t.partitionTableUUID, 16, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(sig)
+}
+
+// SetDiskSignature sets the 4-byte disk signature. The UUIDs of the partitions,
+// which are derived from the signature, are updated to match.
+//
+// The signature is written to disk on the next call to Write. Tables whose signature
+// was never set leave the signature bytes on disk untouched.
+func (t *Table) SetDiskSignature(sig uint32) {
+	t.partitionTableUUID = formatDiskSignature(sig)
+	for i, p := range t.Partitions {
+		if p == nil {
+			continue
+		}
+		p.partitionUUID = formatPartitionUUID(t.partitionTableUUID, i+1)
+	}
+	t.signatureChanged = true
+}
+
+// RandomizeIdentifiers replaces the disk signature with a new random, non-zero value.
+// Use this when cloning a disk, as two disks with the same signature attached to one
+// system confuse Windows and several boot managers.
+func (t *Table) RandomizeIdentifiers() error {
+	b := make([]byte, 4)
+	for {
+		if _, err := util.Rand.Read(b); err != nil {
+			return fmt.Errorf("unable to generate random disk signature: %v", err)
+		}
+		if sig := binary.LittleEndian.Uint32(b); sig != 0 {
+			t.SetDiskSignature(sig)
+			return nil
+		}
+	}
+}
+
 // formatPartitionUUID creates the partition UUID which is created by using the
 // partition table UUID and the partition index.
 // Format string taken from libblkid:
@@ -181,6 +233,18 @@ func (t *Table) Write(f backend.WritableFile, size int64) error {
 	if written != len(b) {
 		return fmt.Errorf("partition table wrote %d bytes to disk instead of the expected %d", written, len(b))
 	}
+
+	if t.signatureChanged {
+		sig := make([]byte, partitionTableUUIDEnd-partitionTableUUIDStart)
+		binary.LittleEndian.PutUint32(sig, t.DiskSignature())
+		written, err = f.WriteAt(sig, partitionTableUUIDStart)
+		if err != nil {
+			return fmt.Errorf("error writing disk signature to disk: %v", err)
+		}
+		if written != len(sig) {
+			return fmt.Errorf("disk signature wrote %d bytes to disk instead of the expected %d", written, len(sig))
+		}
+	}
 	return nil
 }
 
@@ -422,3 +422,57 @@ func TestWritePartitionContents(t *testing.T) {
 		t.Log(b2)
 	}
 }
+
+func TestTableDiskSignature(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		table := mbr.GetValidTable()
+		// matches `blkid ./testdata/mbr.img`
+		if sig := table.DiskSignature(); sig != 0x10e9203d {
+			t.Errorf("mismatched disk signature, actual %x, expected %x", sig, 0x10e9203d)
+		}
+	})
+	t.Run("set and write", func(t *testing.T) {
+		f, err := tmpDisk(mbrFile, 0)
+		if err != nil {
+			t.Fatalf("error creating new temporary disk: %v", err)
+		}
+		defer f.Close()
+		defer os.Remove(f.Name())
+
+		table, err := mbr.Read(f, 512, 512)
+		if err != nil {
+			t.Fatalf("error reading table: %v", err)
+		}
+		var sig uint32 = 0xdeadbeef
+		table.SetDiskSignature(sig)
+		if table.UUID() != "deadbeef" {
+			t.Errorf("mismatched table UUID, actual %s, expected %s", table.UUID(), "deadbeef")
+		}
+		if uuid := table.Partitions[0].UUID(); uuid != "deadbeef-01" {
+			t.Errorf("mismatched partition UUID, actual %s, expected %s", uuid, "deadbeef-01")
+		}
+		if err := table.Write(f, tenMB); err != nil {
+			t.Fatalf("error writing table: %v", err)
+		}
+		reread, err := mbr.Read(f, 512, 512)
+		if err != nil {
+			t.Fatalf("error re-reading table: %v", err)
+		}
+		if reread.DiskSignature() != sig {
+			t.Errorf("mismatched disk signature after write, actual %x, expected %x", reread.DiskSignature(), sig)
+		}
+		if !reread.Equal(table) {
+			t.Errorf("partitions changed after writing disk signature")
+		}
+	})
+	t.Run("randomize", func(t *testing.T) {
+		table := mbr.GetValidTable()
+		old := table.DiskSignature()
+		if err := table.RandomizeIdentifiers(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if table.DiskSignature() == 0 || table.DiskSignature() == old {
+			t.Errorf("disk signature was not randomized, is %x", table.DiskSignature())
+		}
+	})
+}
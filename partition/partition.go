@@ -24,3 +24,22 @@ func Read(f backend.File, logicalBlocksize, physicalBlocksize int) (Table, error
 	// we are out
 	return nil, fmt.Errorf("unknown disk partition type")
 }
+
+// identifierRandomizer is implemented by partition tables that can regenerate their unique identifiers
+type identifierRandomizer interface {
+	RandomizeIdentifiers() error
+}
+
+// RandomizeIdentifiers replaces the unique identifiers of a partition table with new random values:
+// the disk signature for MBR, and the disk GUID plus every partition GUID for GPT.
+//
+// This is intended for cloning workflows, where duplicate identifiers on disks attached to the same
+// system break Windows and boot managers. The changes are not persisted until the table is written,
+// e.g. via disk.Partition().
+func RandomizeIdentifiers(t Table) error {
+	r, ok := t.(identifierRandomizer)
+	if !ok {
+		return fmt.Errorf("partition table type %s does not support randomizing identifiers", t.Type())
+	}
+	return r.RandomizeIdentifiers()
+}
@@ -65,3 +65,38 @@ func TestRead(t *testing.T) {
 		})
 	}
 }
+
+func TestRandomizeIdentifiers(t *testing.T) {
+	tests := []struct {
+		path      string
+		tableType string
+	}{
+		{"./mbr/testdata/mbr.img", "mbr"},
+		{"./gpt/testdata/gpt.img", "gpt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tableType, func(t *testing.T) {
+			f, err := os.Open(tt.path)
+			if err != nil {
+				t.Fatalf("Failed to open file %s :%v", tt.path, err)
+			}
+			defer f.Close()
+
+			table, err := partition.Read(f, 512, 512)
+			if err != nil {
+				t.Fatalf("unexpected error reading table: %v", err)
+			}
+			oldUUID := table.UUID()
+			oldPartUUID := table.GetPartitions()[0].UUID()
+			if err := partition.RandomizeIdentifiers(table); err != nil {
+				t.Fatalf("unexpected error randomizing identifiers: %v", err)
+			}
+			if table.UUID() == oldUUID {
+				t.Errorf("table UUID was not changed, still %s", oldUUID)
+			}
+			if newPartUUID := table.GetPartitions()[0].UUID(); newPartUUID == oldPartUUID {
+				t.Errorf("partition UUID was not changed, still %s", oldPartUUID)
+			}
+		})
+	}
+}
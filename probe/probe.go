@@ -0,0 +1,151 @@
+// Package probe identifies the contents of disks and partitions from their signatures, the way
+// blkid does, reporting the type, label and UUID of filesystems and other contents even where
+// go-diskfs has no reader for them.
+//
+//	r, err := probe.Probe(f, 0, size)
+//	if errors.Is(err, probe.ErrUnknown) {
+//		...
+//	}
+//	fmt.Printf("TYPE=%q LABEL=%q UUID=%q\n", r.Type, r.Label, r.UUID)
+package probe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// Type is the type of the contents, named the same as the TYPE of blkid
+type Type string
+
+// types of the contents found
+const (
+	TypeExt2     Type = "ext2"
+	TypeExt3     Type = "ext3"
+	TypeExt4     Type = "ext4"
+	TypeXFS      Type = "xfs"
+	TypeBtrfs    Type = "btrfs"
+	TypeNTFS     Type = "ntfs"
+	TypeVFAT     Type = "vfat"
+	TypeISO9660  Type = "iso9660"
+	TypeSquashfs Type = "squashfs"
+	TypeSwap     Type = "swap"
+	TypeLVM2     Type = "LVM2_member"
+	TypeLUKS     Type = "crypto_LUKS"
+	TypeMD       Type = "linux_raid_member"
+)
+
+// Usage is what the contents are used for, named the same as the USAGE of blkid
+type Usage string
+
+// usages of the contents found
+const (
+	UsageFilesystem Usage = "filesystem"
+	UsageRaid       Usage = "raid"
+	UsageCrypto     Usage = "crypto"
+	UsageOther      Usage = "other"
+)
+
+// ErrUnknown is returned by Probe when no known signature is found
+var ErrUnknown = errors.New("no known signature")
+
+// Result describes the contents found
+type Result struct {
+	Type  Type
+	Usage Usage
+	// Version is the version or variant of the format, if it has one, e.g. FAT32 or 2 for LUKS2
+	Version string
+	// Label is the name given to the contents, or "" if none
+	Label string
+	// UUID identifies the contents, in the form blkid reports for the type, or is "" if none
+	UUID string
+}
+
+// FilesystemType returns the type of filesystem of the contents for the readers of go-diskfs, and
+// whether there is a reader for them
+func (r *Result) FilesystemType() (filesystem.Type, bool) {
+	switch {
+	case r.Type == TypeVFAT && r.Version == "FAT32":
+		return filesystem.TypeFat32, true
+	case r.Type == TypeISO9660:
+		return filesystem.TypeISO9660, true
+	case r.Type == TypeSquashfs:
+		return filesystem.TypeSquashfs, true
+	case r.Type == TypeExt4:
+		return filesystem.TypeExt4, true
+	}
+	return 0, false
+}
+
+// device is the region of a disk with the contents probed
+type device struct {
+	r           io.ReaderAt
+	start, size int64
+}
+
+// errShort is returned by read beyond the end of the contents, where no signature can be
+var errShort = errors.New("beyond the end of the contents")
+
+// read returns n bytes at offset off of the contents
+func (d device) read(off int64, n int) ([]byte, error) {
+	if d.size > 0 && off+int64(n) > d.size {
+		return nil, errShort
+	}
+	b := make([]byte, n)
+	read, err := d.r.ReadAt(b, d.start+off)
+	if read == n {
+		return b, nil
+	}
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil, errShort
+	}
+	return nil, err
+}
+
+// prober finds the contents of its type, returning nil if their signature is not there
+type prober func(d device) (*Result, error)
+
+// probers in the order they are tried, from those whose signatures are the least likely to be
+// left over from previous contents
+var probers = []prober{
+	probeLUKS,
+	probeLVM2,
+	probeMD,
+	probeSwap,
+	probeXFS,
+	probeSquashfs,
+	probeBtrfs,
+	probeExt,
+	probeISO9660,
+	probeNTFS,
+	probeVFAT,
+}
+
+// Probe identifies the contents of the region of size bytes at start of r, a whole disk or one
+// of its partitions. A size of 0 means all of r after start. It returns ErrUnknown if no known
+// signature is found.
+func Probe(r io.ReaderAt, start, size int64) (*Result, error) {
+	d := device{r: r, start: start, size: size}
+	for _, p := range probers {
+		result, err := p(d)
+		if err != nil && !errors.Is(err, errShort) {
+			return nil, fmt.Errorf("error probing contents: %w", err)
+		}
+		if result != nil {
+			return result, nil
+		}
+	}
+	return nil, ErrUnknown
+}
+
+// label converts the raw bytes of a label, padded with zeros or spaces, to a string
+func label(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimRight(string(b), " ")
+}
@@ -0,0 +1,186 @@
+package probe_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/probe"
+)
+
+const size = 20 * 1024 * 1024
+
+var rawUUID = []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}
+
+const formattedUUID = "12345678-9abc-def0-1234-56789abcdef0"
+
+// ntfs is the start of an NTFS volume, with its label in the $Volume record of the MFT
+func ntfs() []byte {
+	b := make([]byte, 64*1024)
+	copy(b[3:], "NTFS    ")
+	binary.LittleEndian.PutUint16(b[0x0b:], 512)
+	b[0x0d] = 8
+	binary.LittleEndian.PutUint64(b[0x30:], 4)
+	// records of 1KiB
+	b[0x40] = 0xf6
+	binary.LittleEndian.PutUint64(b[0x48:], 0x0123456789abcdef)
+
+	rec := b[4*4096+3*1024 : 4*4096+4*1024]
+	copy(rec, "FILE")
+	// update sequence array for 2 sectors
+	binary.LittleEndian.PutUint16(rec[4:], 0x30)
+	binary.LittleEndian.PutUint16(rec[6:], 3)
+	binary.LittleEndian.PutUint16(rec[0x14:], 0x38)
+	attr := rec[0x38:]
+	binary.LittleEndian.PutUint32(attr[0:], 0x60)
+	binary.LittleEndian.PutUint32(attr[4:], 0x28)
+	name := utf16.Encode([]rune("Données"))
+	binary.LittleEndian.PutUint32(attr[0x10:], uint32(2*len(name)))
+	binary.LittleEndian.PutUint16(attr[0x14:], 0x18)
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(attr[0x18+2*i:], c)
+	}
+	binary.LittleEndian.PutUint32(rec[0x60:], 0xffffffff)
+	return b
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    func() []byte
+		expected probe.Result
+	}{
+		{"ext4", func() []byte {
+			b := make([]byte, 4096)
+			binary.LittleEndian.PutUint16(b[1024+0x38:], 0xef53)
+			binary.LittleEndian.PutUint32(b[1024+0x5c:], 0x4)
+			binary.LittleEndian.PutUint32(b[1024+0x60:], 0x2|0x40)
+			copy(b[1024+0x68:], rawUUID)
+			copy(b[1024+0x78:], "root")
+			return b
+		}, probe.Result{Type: probe.TypeExt4, Usage: probe.UsageFilesystem, Label: "root", UUID: formattedUUID}},
+		{"ext3", func() []byte {
+			b := make([]byte, 4096)
+			binary.LittleEndian.PutUint16(b[1024+0x38:], 0xef53)
+			binary.LittleEndian.PutUint32(b[1024+0x5c:], 0x4)
+			binary.LittleEndian.PutUint32(b[1024+0x60:], 0x2)
+			return b
+		}, probe.Result{Type: probe.TypeExt3, Usage: probe.UsageFilesystem}},
+		{"xfs", func() []byte {
+			b := make([]byte, 4096)
+			copy(b, "XFSB")
+			copy(b[32:], rawUUID)
+			copy(b[108:], "data")
+			return b
+		}, probe.Result{Type: probe.TypeXFS, Usage: probe.UsageFilesystem, Label: "data", UUID: formattedUUID}},
+		{"btrfs", func() []byte {
+			b := make([]byte, 128*1024)
+			copy(b[0x10000+0x20:], rawUUID)
+			copy(b[0x10000+0x40:], "_BHRfS_M")
+			copy(b[0x10000+0x12b:], "pool")
+			return b
+		}, probe.Result{Type: probe.TypeBtrfs, Usage: probe.UsageFilesystem, Label: "pool", UUID: formattedUUID}},
+		{"ntfs", ntfs, probe.Result{Type: probe.TypeNTFS, Usage: probe.UsageFilesystem, Label: "Données", UUID: "0123456789ABCDEF"}},
+		{"swap", func() []byte {
+			b := make([]byte, 4096)
+			copy(b[4096-10:], "SWAPSPACE2")
+			copy(b[1024+12:], rawUUID)
+			copy(b[1024+28:], "swap0")
+			return b
+		}, probe.Result{Type: probe.TypeSwap, Usage: probe.UsageOther, Version: "1", Label: "swap0", UUID: formattedUUID}},
+		{"lvm2", func() []byte {
+			b := make([]byte, 4096)
+			copy(b[512:], "LABELONE")
+			binary.LittleEndian.PutUint32(b[512+20:], 32)
+			copy(b[512+24:], "LVM2 001")
+			copy(b[512+32:], "abcdefGHIJklmnOPQRstuvWXYZ012345")
+			return b
+		}, probe.Result{Type: probe.TypeLVM2, Usage: probe.UsageRaid, Version: "LVM2 001", UUID: "abcdef-GHIJ-klmn-OPQR-stuv-WXYZ-012345"}},
+		{"md", func() []byte {
+			b := make([]byte, 8192)
+			binary.LittleEndian.PutUint32(b[4096:], 0xa92b4efc)
+			binary.LittleEndian.PutUint32(b[4096+4:], 1)
+			copy(b[4096+16:], rawUUID)
+			copy(b[4096+32:], "nas:0")
+			return b
+		}, probe.Result{Type: probe.TypeMD, Usage: probe.UsageRaid, Version: "1.2", Label: "nas:0", UUID: formattedUUID}},
+		{"luks2", func() []byte {
+			b := make([]byte, 4096)
+			copy(b, "LUKS\xba\xbe\x00\x02")
+			copy(b[24:], "secret")
+			copy(b[168:], formattedUUID)
+			return b
+		}, probe.Result{Type: probe.TypeLUKS, Usage: probe.UsageCrypto, Version: "2", Label: "secret", UUID: formattedUUID}},
+		{"iso9660", func() []byte {
+			b, err := os.ReadFile("../filesystem/iso9660/testdata/9660.iso")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}, probe.Result{Type: probe.TypeISO9660, Usage: probe.UsageFilesystem, Label: "ISOIMAGE"}},
+		{"fat32", func() []byte {
+			b, err := mem.Create(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fat32.Create(b, size, 0, 512, "BOOT"); err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			return b.Bytes()
+		}, probe.Result{Type: probe.TypeVFAT, Usage: probe.UsageFilesystem, Version: "FAT32", Label: "BOOT"}},
+		{"squashfs", func() []byte {
+			b, err := mem.Create(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs, err := squashfs.Create(b, size, 0, 4096)
+			if err != nil {
+				t.Fatalf("error creating filesystem: %v", err)
+			}
+			if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+				t.Fatalf("error finalizing filesystem: %v", err)
+			}
+			return b.Bytes()
+		}, probe.Result{Type: probe.TypeSquashfs, Usage: probe.UsageFilesystem, Version: "4.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := probe.Probe(bytes.NewReader(tt.image()), 0, 0)
+			if err != nil {
+				t.Fatalf("error probing: %v", err)
+			}
+			// the UUIDs of ISO9660 and FAT32 depend on the time they are created
+			if tt.expected.UUID == "" && (r.Type == probe.TypeISO9660 || r.Type == probe.TypeVFAT) {
+				r.UUID = ""
+			}
+			if *r != tt.expected {
+				t.Errorf("found %+v instead of %+v", *r, tt.expected)
+			}
+		})
+	}
+
+	t.Run("partition", func(t *testing.T) {
+		// the contents at an offset, within their size
+		image := append(make([]byte, 1024*1024), tests[0].image()...)
+		r, err := probe.Probe(bytes.NewReader(image), 1024*1024, 4096)
+		if err != nil || r.Type != probe.TypeExt4 {
+			t.Errorf("found %+v, %v instead of %s", r, err, probe.TypeExt4)
+		}
+		if fsType, ok := r.FilesystemType(); !ok || fsType != filesystem.TypeExt4 {
+			t.Errorf("filesystem type %v, %v instead of %v", fsType, ok, filesystem.TypeExt4)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := probe.Probe(bytes.NewReader(make([]byte, 4096)), 0, 0); !errors.Is(err, probe.ErrUnknown) {
+			t.Errorf("error %v instead of %v", err, probe.ErrUnknown)
+		}
+	})
+}
@@ -0,0 +1,392 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/google/uuid"
+)
+
+// formatUUID formats the 16 bytes of a UUID in its usual form
+func formatUUID(b []byte) string {
+	u, err := uuid.FromBytes(b)
+	if err != nil || u == uuid.Nil {
+		return ""
+	}
+	return u.String()
+}
+
+// probeLUKS finds the header of LUKS1 or LUKS2 encrypted contents
+func probeLUKS(d device) (*Result, error) {
+	b, err := d.read(0, 208)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b[0:6], []byte("LUKS\xba\xbe")) {
+		return nil, nil
+	}
+	version := binary.BigEndian.Uint16(b[6:8])
+	r := &Result{
+		Type:    TypeLUKS,
+		Usage:   UsageCrypto,
+		Version: fmt.Sprintf("%d", version),
+		UUID:    label(b[168:208]),
+	}
+	// only LUKS2 has a label, where LUKS1 has the cipher
+	if version == 2 {
+		r.Label = label(b[24:72])
+	}
+	return r, nil
+}
+
+// probeLVM2 finds the label of an LVM2 physical volume, in one of its first 4 sectors
+func probeLVM2(d device) (*Result, error) {
+	for sector := int64(0); sector < 4; sector++ {
+		b, err := d.read(sector*512, 512)
+		if err != nil {
+			return nil, err
+		}
+		if string(b[0:8]) != "LABELONE" || string(b[24:32]) != "LVM2 001" {
+			continue
+		}
+		// the header of the physical volume, from the start of the sector of the label
+		offset := int64(binary.LittleEndian.Uint32(b[20:24]))
+		pv, err := d.read(sector*512+offset, 32)
+		if err != nil {
+			return nil, err
+		}
+		// LVM formats its 32 character UUIDs in groups of 6-4-4-4-4-4-6
+		id := string(pv)
+		groups := []string{id[0:6], id[6:10], id[10:14], id[14:18], id[18:22], id[22:26], id[26:32]}
+		return &Result{
+			Type:    TypeLVM2,
+			Usage:   UsageRaid,
+			Version: "LVM2 001",
+			UUID:    strings.Join(groups, "-"),
+		}, nil
+	}
+	return nil, nil
+}
+
+// mdMagic is the magic number of the superblocks of md RAID members
+const mdMagic = 0xa92b4efc
+
+// probeMD finds the superblock of a member of a Linux software RAID array, of version 1.1 or 1.2
+// at the start of the member, or 1.0 or 0.90 at its end if its size is known
+func probeMD(d device) (*Result, error) {
+	offsets := map[string]int64{"1.1": 0, "1.2": 4096}
+	if d.size > 0 {
+		offsets["1.0"] = (d.size/512 - 16) &^ 7 * 512
+	}
+	for _, version := range []string{"1.2", "1.1", "1.0"} {
+		offset, ok := offsets[version]
+		if !ok || offset < 0 {
+			continue
+		}
+		b, err := d.read(offset, 256)
+		if err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(b[0:4]) != mdMagic || binary.LittleEndian.Uint32(b[4:8]) != 1 {
+			continue
+		}
+		return &Result{
+			Type:    TypeMD,
+			Usage:   UsageRaid,
+			Version: version,
+			Label:   label(b[32:64]),
+			UUID:    formatUUID(b[16:32]),
+		}, nil
+	}
+	if d.size <= 0 {
+		return nil, nil
+	}
+	// the 0.90 superblock is in the last 64KiB of the member, aligned to them
+	offset := d.size&^(64*1024-1) - 64*1024
+	if offset < 0 {
+		return nil, nil
+	}
+	b, err := d.read(offset, 64)
+	if err != nil {
+		return nil, err
+	}
+	word := func(i int) uint32 { return binary.LittleEndian.Uint32(b[4*i:]) }
+	if word(0) != mdMagic || word(1) != 0 {
+		return nil, nil
+	}
+	// the 4 words of the UUID, the first apart from the others
+	id := make([]byte, 16)
+	for i, w := range []uint32{word(5), word(13), word(14), word(15)} {
+		binary.BigEndian.PutUint32(id[4*i:], w)
+	}
+	return &Result{
+		Type:    TypeMD,
+		Usage:   UsageRaid,
+		Version: fmt.Sprintf("%d.%d.%d", word(1), word(2), word(3)),
+		UUID:    formatUUID(id),
+	}, nil
+}
+
+// swapPageSizes are the sizes of pages swap areas are made for, with their signature at the end
+// of the first page
+var swapPageSizes = []int64{4096, 8192, 16384, 32768, 65536}
+
+// probeSwap finds the signature of a Linux swap area
+func probeSwap(d device) (*Result, error) {
+	for _, pageSize := range swapPageSizes {
+		b, err := d.read(pageSize-10, 10)
+		if err != nil {
+			return nil, err
+		}
+		switch string(b) {
+		case "SWAP-SPACE":
+			return &Result{Type: TypeSwap, Usage: UsageOther, Version: "0"}, nil
+		case "SWAPSPACE2":
+			// the header after the space for boot blocks
+			header, err := d.read(1024, 44)
+			if err != nil {
+				return nil, err
+			}
+			return &Result{
+				Type:    TypeSwap,
+				Usage:   UsageOther,
+				Version: "1",
+				UUID:    formatUUID(header[12:28]),
+				Label:   label(header[28:44]),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// probeXFS finds the superblock of XFS
+func probeXFS(d device) (*Result, error) {
+	b, err := d.read(0, 120)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[0:4]) != "XFSB" {
+		return nil, nil
+	}
+	return &Result{
+		Type:  TypeXFS,
+		Usage: UsageFilesystem,
+		UUID:  formatUUID(b[32:48]),
+		Label: label(b[108:120]),
+	}, nil
+}
+
+// probeSquashfs finds the superblock of squashfs
+func probeSquashfs(d device) (*Result, error) {
+	b, err := d.read(0, 32)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[0:4]) != "hsqs" {
+		return nil, nil
+	}
+	return &Result{
+		Type:    TypeSquashfs,
+		Usage:   UsageFilesystem,
+		Version: fmt.Sprintf("%d.%d", binary.LittleEndian.Uint16(b[28:30]), binary.LittleEndian.Uint16(b[30:32])),
+	}, nil
+}
+
+// probeBtrfs finds the primary superblock of btrfs, at 64KiB
+func probeBtrfs(d device) (*Result, error) {
+	b, err := d.read(0x10000, 0x22b)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[0x40:0x48]) != "_BHRfS_M" {
+		return nil, nil
+	}
+	return &Result{
+		Type:  TypeBtrfs,
+		Usage: UsageFilesystem,
+		UUID:  formatUUID(b[0x20:0x30]),
+		Label: label(b[0x12b:0x22b]),
+	}, nil
+}
+
+// features of ext2 filesystems, beyond which they are ext3 or ext4
+const (
+	extCompatHasJournal = 0x4
+	// filetype, recover, journal device and meta_bg
+	extIncompatExt2 = 0x2 | 0x4 | 0x8 | 0x10
+	// sparse_super, large_file and btree_dir
+	extROCompatExt2 = 0x1 | 0x2 | 0x4
+)
+
+// probeExt finds the superblock of ext2, ext3 or ext4, told apart by their features like blkid does
+func probeExt(d device) (*Result, error) {
+	b, err := d.read(1024, 0x88)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint16(b[0x38:0x3a]) != 0xef53 {
+		return nil, nil
+	}
+	compat := binary.LittleEndian.Uint32(b[0x5c:0x60])
+	incompat := binary.LittleEndian.Uint32(b[0x60:0x64])
+	roCompat := binary.LittleEndian.Uint32(b[0x64:0x68])
+	t := TypeExt2
+	switch {
+	case incompat&^extIncompatExt2 != 0 || roCompat&^extROCompatExt2 != 0:
+		t = TypeExt4
+	case compat&extCompatHasJournal != 0:
+		t = TypeExt3
+	}
+	return &Result{
+		Type:  t,
+		Usage: UsageFilesystem,
+		UUID:  formatUUID(b[0x68:0x78]),
+		Label: label(b[0x78:0x88]),
+	}, nil
+}
+
+// probeISO9660 finds the primary volume descriptor of ISO9660, whose UUID is its creation time
+func probeISO9660(d device) (*Result, error) {
+	b, err := d.read(0x8000, 0x33e)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != 1 || string(b[1:6]) != "CD001" {
+		return nil, nil
+	}
+	r := &Result{
+		Type:  TypeISO9660,
+		Usage: UsageFilesystem,
+		Label: label(b[40:72]),
+	}
+	// the creation time as YYYYMMDDHHMMSScc, formatted as YYYY-MM-DD-HH-MM-SS-cc
+	created := string(b[0x32d:0x33d])
+	if strings.Trim(created, "0") != "" && strings.Trim(created, "0123456789") == "" {
+		r.UUID = strings.Join([]string{created[0:4], created[4:6], created[6:8], created[8:10], created[10:12], created[12:14], created[14:16]}, "-")
+	}
+	return r, nil
+}
+
+// NTFS attributes and records used for the label
+const (
+	ntfsVolumeRecord    = 3
+	ntfsAttrVolumeName  = 0x60
+	ntfsAttrEnd         = 0xffffffff
+	ntfsMaxRecordLength = 64 * 1024
+)
+
+// probeNTFS finds the boot sector of NTFS, and its label in the $Volume file of the MFT
+func probeNTFS(d device) (*Result, error) {
+	b, err := d.read(0, 512)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[3:11]) != "NTFS    " {
+		return nil, nil
+	}
+	r := &Result{
+		Type:  TypeNTFS,
+		Usage: UsageFilesystem,
+		UUID:  fmt.Sprintf("%016X", binary.LittleEndian.Uint64(b[0x48:0x50])),
+	}
+	r.Label = ntfsLabel(d, b)
+	return r, nil
+}
+
+// ntfsLabel reads the label of NTFS from the volume name of $Volume, or "" if it has none
+func ntfsLabel(d device, boot []byte) string {
+	sectorSize := int64(binary.LittleEndian.Uint16(boot[0x0b:0x0d]))
+	clusterSize := sectorSize * int64(boot[0x0d])
+	if boot[0x0d] > 0x80 {
+		// sectors per cluster given as a negative power of 2
+		clusterSize = sectorSize << (256 - int(boot[0x0d]))
+	}
+	recordLength := clusterSize * int64(int8(boot[0x40]))
+	if int8(boot[0x40]) < 0 {
+		recordLength = 1 << -int(int8(boot[0x40]))
+	}
+	if sectorSize < 256 || clusterSize <= 0 || recordLength <= 0 || recordLength > ntfsMaxRecordLength {
+		return ""
+	}
+	mft := int64(binary.LittleEndian.Uint64(boot[0x30:0x38])) * clusterSize
+	rec, err := d.read(mft+ntfsVolumeRecord*recordLength, int(recordLength))
+	if err != nil || string(rec[0:4]) != "FILE" {
+		// an unreadable MFT leaves the volume without label rather than unknown
+		return ""
+	}
+	// the last 2 bytes of each sector are in the update sequence array
+	usa := int(binary.LittleEndian.Uint16(rec[4:6]))
+	count := int(binary.LittleEndian.Uint16(rec[6:8]))
+	for i := 1; i < count; i++ {
+		pos := i*int(sectorSize) - 2
+		if usa+2*i+2 > len(rec) || pos+2 > len(rec) {
+			break
+		}
+		copy(rec[pos:pos+2], rec[usa+2*i:usa+2*i+2])
+	}
+	for offset := int(binary.LittleEndian.Uint16(rec[0x14:0x16])); offset+24 <= len(rec); {
+		attrType := binary.LittleEndian.Uint32(rec[offset : offset+4])
+		length := int(binary.LittleEndian.Uint32(rec[offset+4 : offset+8]))
+		if attrType == ntfsAttrEnd || length == 0 {
+			break
+		}
+		// the volume name is always resident, in the record
+		if attrType == ntfsAttrVolumeName && rec[offset+8] == 0 {
+			valueLength := int(binary.LittleEndian.Uint32(rec[offset+0x10 : offset+0x14]))
+			start := offset + int(binary.LittleEndian.Uint16(rec[offset+0x14:offset+0x16]))
+			if start+valueLength > len(rec) {
+				break
+			}
+			name := make([]uint16, valueLength/2)
+			for i := range name {
+				name[i] = binary.LittleEndian.Uint16(rec[start+2*i : start+2*i+2])
+			}
+			return string(utf16.Decode(name))
+		}
+		offset += length
+	}
+	return ""
+}
+
+// probeVFAT finds the boot sector of FAT12, FAT16 or FAT32
+func probeVFAT(d device) (*Result, error) {
+	b, err := d.read(0, 512)
+	if err != nil {
+		return nil, err
+	}
+	if b[510] != 0x55 || b[511] != 0xaa {
+		return nil, nil
+	}
+	switch sectorSize := binary.LittleEndian.Uint16(b[0x0b:0x0d]); sectorSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, nil
+	}
+	// the extended boot record is after the parameters of FAT32, or those of FAT12 and FAT16
+	var ebr []byte
+	switch {
+	case string(b[0x52:0x5a]) == "FAT32   ":
+		ebr = b[0x40:0x5a]
+	case string(b[0x36:0x39]) == "FAT":
+		ebr = b[0x24:0x3e]
+	default:
+		return nil, nil
+	}
+	r := &Result{
+		Type:    TypeVFAT,
+		Usage:   UsageFilesystem,
+		Version: strings.TrimRight(string(ebr[0x12:0x1a]), " "),
+	}
+	// with the boot signature, the serial number and label are there
+	if ebr[2] == 0x29 {
+		serial := binary.LittleEndian.Uint32(ebr[3:7])
+		r.UUID = fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff)
+		if name := label(ebr[7:0x12]); name != "NO NAME" {
+			r.Label = name
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,581 @@
+package script
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/partition"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// partitionAlignment is where new partitions start, in bytes, as by default for fdisk and sgdisk
+const partitionAlignment = 1024 * 1024
+
+// fsTypes are the names of the filesystem types of mkfs
+var fsTypes = map[string]filesystem.Type{
+	"fat32":    filesystem.TypeFat32,
+	"ext4":     filesystem.TypeExt4,
+	"squashfs": filesystem.TypeSquashfs,
+	"iso9660":  filesystem.TypeISO9660,
+}
+
+// partitionTypes are the names of the partition types of add-partition
+var partitionTypes = map[string]struct {
+	gpt gpt.Type
+	mbr mbr.Type
+}{
+	"linux": {gpt.LinuxFilesystem, mbr.Linux},
+	"efi":   {gpt.EFISystemPartition, mbr.EFISystem},
+	"swap":  {gpt.LinuxSwap, mbr.LinuxSwap},
+	"lvm":   {gpt.LinuxLVM, mbr.LinuxLVM},
+	"fat32": {gpt.MicrosoftBasicData, mbr.Fat32LBA},
+}
+
+type runOpts struct {
+	baseDir  string
+	progress util.Progress
+}
+
+// RunOpt is an option of Run
+type RunOpt func(o *runOpts) error
+
+// WithBaseDir sets the directory that the host paths of the script are relative to, the current
+// directory by default, e.g. that of the script file
+func WithBaseDir(dir string) RunOpt {
+	return func(o *runOpts) error {
+		if dir == "" {
+			return errors.New("base directory must not be empty")
+		}
+		o.baseDir = dir
+		return nil
+	}
+}
+
+// WithProgress reports the progress of the script to p, in util.PhaseCopy as the host files of
+// copy-in and the archives of tar-in are read, out of the size of all of them, with the host path
+// being read, and in util.PhaseData as squashfs and ISO9660 filesystems are finalized
+func WithProgress(p util.Progress) RunOpt {
+	return func(o *runOpts) error {
+		o.progress = p
+		return nil
+	}
+}
+
+// Run runs the operations of the script on the disk in order, with RunContext
+func (s *Script) Run(d *disk.Disk, opts ...RunOpt) error {
+	return s.RunContext(context.Background(), d, opts...)
+}
+
+// RunContext runs the operations of the script on the disk in order, stopping at the first that
+// fails, whose number, from 1, is in the error, or when ctx is done. The script is validated first.
+//
+// Once all have run, the squashfs and ISO9660 filesystems made by the script are finalized, ISO9660
+// with Rock Ridge and the label of its mkfs as volume identifier, and the others synced. The
+// filesystems are closed, but not the disk. If the script fails, the workspaces of squashfs and
+// ISO9660 filesystems are removed without being finalized.
+func (s *Script) RunContext(ctx context.Context, d *disk.Disk, opts ...RunOpt) error {
+	o := &runOpts{baseDir: "."}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return err
+		}
+	}
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	r := &runner{ctx: ctx, disk: d, opts: o, filesystems: map[int]*openFilesystem{}}
+	if o.progress != nil {
+		total, err := r.sourcesSize(s)
+		if err != nil {
+			return err
+		}
+		r.total = total
+	}
+	for i := range s.Operations {
+		op := &s.Operations[i]
+		err := ctx.Err()
+		if err == nil {
+			err = r.run(op)
+		}
+		if err != nil {
+			r.close()
+			return fmt.Errorf("operation %d (%s): %w", i+1, op.Op, err)
+		}
+	}
+	return r.finish()
+}
+
+// runner holds the state of a script being run
+type runner struct {
+	ctx  context.Context
+	disk *disk.Disk
+	opts *runOpts
+	// filesystems are those used so far, by partition
+	filesystems map[int]*openFilesystem
+	// done and total are the bytes of host files read so far and to read, for the progress
+	done, total int64
+}
+
+// openFilesystem is a filesystem used by a script
+type openFilesystem struct {
+	fs filesystem.FileSystem
+	// label is that of the mkfs of ISO9660 filesystems, their volume identifier when finalized
+	label string
+}
+
+// run runs one operation
+func (r *runner) run(op *Operation) error {
+	switch op.Op {
+	case OpCreateTable:
+		return r.createTable(op)
+	case OpAddPartition:
+		return r.addPartition(op)
+	case OpMkfs:
+		return r.mkfs(op)
+	}
+	f, err := r.filesystem(op.Partition)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case OpMkdir:
+		return f.Mkdir(op.Path)
+	case OpWrite:
+		return writeFile(f, op.Path, strings.NewReader(op.Content))
+	case OpCopyIn:
+		return r.copyIn(f, r.hostPath(op.Source), cmp.Or(op.Path, "/"))
+	case OpTarIn:
+		return r.tarIn(f, r.hostPath(op.Source))
+	case OpSymlink:
+		return f.Symlink(op.Target, op.Path)
+	case OpSetAttrs:
+		return setAttrs(f, op)
+	case OpRemove:
+		return filesystem.RemoveAll(f, op.Path)
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+}
+
+// hostPath returns the host path p relative to the base directory
+func (r *runner) hostPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(r.opts.baseDir, p)
+}
+
+// filesystem returns the filesystem in the partition, reading it the first time
+func (r *runner) filesystem(part int) (filesystem.FileSystem, error) {
+	if of, ok := r.filesystems[part]; ok {
+		return of.fs, nil
+	}
+	f, err := r.disk.GetFilesystem(part)
+	if err != nil {
+		return nil, fmt.Errorf("could not read filesystem of partition %d: %w", part, err)
+	}
+	r.filesystems[part] = &openFilesystem{fs: f}
+	return f, nil
+}
+
+func (r *runner) createTable(op *Operation) error {
+	if len(r.filesystems) > 0 {
+		return errors.New("cannot replace the partition table once filesystems are used")
+	}
+	var table partition.Table
+	switch op.Table {
+	case "", "gpt":
+		table = &gpt.Table{LogicalSectorSize: int(r.disk.LogicalBlocksize), PhysicalSectorSize: int(r.disk.PhysicalBlocksize), ProtectiveMBR: true}
+	case "mbr":
+		table = &mbr.Table{LogicalSectorSize: int(r.disk.LogicalBlocksize), PhysicalSectorSize: int(r.disk.PhysicalBlocksize)}
+	default:
+		return fmt.Errorf("unknown partition table type %q", op.Table)
+	}
+	return r.disk.Partition(table)
+}
+
+// addPartition adds a partition to the table of the disk, in the first space free for it, or the
+// rest of the disk after the last partition if it has no size, creating a GPT table if there is
+// none
+func (r *runner) addPartition(op *Operation) error {
+	d := r.disk
+	table := d.Table
+	if table == nil {
+		table = &gpt.Table{LogicalSectorSize: int(d.LogicalBlocksize), PhysicalSectorSize: int(d.PhysicalBlocksize), ProtectiveMBR: true}
+	}
+	sectorSize, size := d.LogicalBlocksize, int64(op.Size)
+	if size%sectorSize != 0 {
+		return fmt.Errorf("size %d is not a multiple of the sector size %d", size, sectorSize)
+	}
+	var used [][2]int64
+	for _, p := range table.GetPartitions() {
+		if p.GetSize() > 0 {
+			used = append(used, [2]int64{p.GetStart() / sectorSize, (p.GetStart()+p.GetSize())/sectorSize - 1})
+		}
+	}
+	typeName := cmp.Or(op.Type, "linux")
+	types, known := partitionTypes[typeName]
+
+	switch t := table.(type) {
+	case *gpt.Table:
+		if op.Bootable {
+			return errors.New("bootable is only for MBR partitions")
+		}
+		// the partition array and the secondary header are in the last sectors
+		arraySectors := int64(128*128) / sectorSize
+		start, end, err := freeSectors(used, d.Size/sectorSize-1-arraySectors-1, partitionAlignment/sectorSize, size/sectorSize)
+		if err != nil {
+			return err
+		}
+		partType := types.gpt
+		if !known {
+			partType = gpt.Type(strings.ToUpper(typeName))
+		}
+		t.Partitions = append(t.Partitions, &gpt.Partition{Start: uint64(start), End: uint64(end), Type: partType, Name: op.Name})
+	case *mbr.Table:
+		if op.Name != "" {
+			return errors.New("MBR partitions have no name")
+		}
+		start, end, err := freeSectors(used, min(d.Size/sectorSize-1, int64(^uint32(0))), partitionAlignment/sectorSize, size/sectorSize)
+		if err != nil {
+			return err
+		}
+		partType := types.mbr
+		if !known {
+			v, err := strconv.ParseUint(typeName, 0, 8)
+			if err != nil {
+				return fmt.Errorf("unknown partition type %q", typeName)
+			}
+			partType = mbr.Type(v)
+		}
+		p := &mbr.Partition{Type: partType, Start: uint32(start), Size: uint32(end - start + 1), Bootable: op.Bootable}
+		if i := slices.IndexFunc(t.Partitions, func(old *mbr.Partition) bool { return old.Type == mbr.Empty }); i >= 0 {
+			t.Partitions[i] = p
+		} else if len(t.Partitions) < 4 {
+			t.Partitions = append(t.Partitions, p)
+		} else {
+			return errors.New("no free entry in the MBR for another primary partition")
+		}
+	default:
+		return fmt.Errorf("cannot add partitions to a %s partition table", table.Type())
+	}
+	return d.Partition(table)
+}
+
+// freeSectors returns the first and last sectors of the first range of sectors free between
+// the used ranges, starting at a multiple of align and ending by last, or of the range after
+// them all if sectors is 0
+func freeSectors(used [][2]int64, last, align, sectors int64) (start, end int64, err error) {
+	slices.SortFunc(used, func(a, b [2]int64) int { return cmp.Compare(a[0], b[0]) })
+	start = align
+	for _, u := range used {
+		if sectors > 0 && start+sectors-1 < u[0] {
+			break
+		}
+		start = max(start, (u[1]+align)/align*align)
+	}
+	end = last
+	if sectors > 0 {
+		end = start + sectors - 1
+	}
+	if end > last || end < start {
+		return 0, 0, fmt.Errorf("no room for a partition of %d sectors before sector %d", sectors, last)
+	}
+	return start, end, nil
+}
+
+func (r *runner) mkfs(op *Operation) error {
+	fsType := fsTypes[op.FSType]
+	spec := disk.FormatSpec{Partition: op.Partition, FSType: fsType}
+	switch fsType {
+	case filesystem.TypeFat32, filesystem.TypeExt4:
+		spec.VolumeLabel = op.Label
+	case filesystem.TypeSquashfs:
+		if op.Label != "" {
+			return errors.New("squashfs filesystems have no label")
+		}
+	}
+	// a filesystem made again replaces the one used so far
+	if of, ok := r.filesystems[op.Partition]; ok {
+		delete(r.filesystems, op.Partition)
+		if err := of.fs.Close(); err != nil {
+			return err
+		}
+	}
+	f, err := r.disk.CreateFilesystem(spec)
+	if err != nil {
+		return err
+	}
+	r.filesystems[op.Partition] = &openFilesystem{fs: f, label: op.Label}
+	return nil
+}
+
+// writeFile writes the contents of the reader to the file p, with its directories
+func writeFile(f filesystem.FileSystem, p string, r io.Reader) error {
+	if err := f.Mkdir(path.Dir(p)); err != nil {
+		return fmt.Errorf("could not create directory %s: %w", path.Dir(p), err)
+	}
+	out, err := f.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("could not create %s: %w", p, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("could not write %s: %w", p, err)
+	}
+	return out.Close()
+}
+
+// copyIn copies the host file or directory src to dst in f, or into dst if src is a file and dst
+// an existing directory, keeping the permissions where f has them
+func (r *runner) copyIn(f filesystem.FileSystem, src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := f.Stat(dst); err == nil && dstInfo.IsDir() && !info.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+	// the modes of directories are set once their contents are copied, which they may not allow
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := path.Join(dst, filepath.ToSlash(rel))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if err := f.Mkdir(target); err != nil {
+				return fmt.Errorf("could not create directory %s: %w", target, err)
+			}
+			if rel != "." {
+				dirs = append(dirs, dirMode{target, info.Mode()})
+			}
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return f.Symlink(filepath.ToSlash(link), target)
+		case d.Type().IsRegular():
+			in, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			if err := writeFile(f, target, r.reader(in, p)); err != nil {
+				return err
+			}
+			return chmod(f, target, info.Mode())
+		default:
+			return fmt.Errorf("cannot copy %s, of type %s", p, d.Type())
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := chmod(f, dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chmod sets the permissions of the file where the filesystem keeps them
+func chmod(f filesystem.FileSystem, p string, mode fs.FileMode) error {
+	err := f.Chmod(p, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	if errors.Is(err, filesystem.ErrNotSupported) || errors.Is(err, filesystem.ErrNotImplemented) {
+		return nil
+	}
+	return err
+}
+
+// tarIn extracts the host tar archive, compressed with gzip or not, into f
+func (r *runner) tarIn(f filesystem.FileSystem, src string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	br := bufio.NewReader(r.reader(file, src))
+	var in io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("could not read gzip archive %s: %w", src, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+	if err := filesystem.FromTar(f, in, filesystem.FromTarOptions{}); err != nil {
+		return fmt.Errorf("could not extract %s: %w", src, err)
+	}
+	return nil
+}
+
+// sourcesSize returns the size of the host files read by the copy-in and tar-in of the script
+func (r *runner) sourcesSize(s *Script) (int64, error) {
+	var total int64
+	for i := range s.Operations {
+		op := &s.Operations[i]
+		if op.Op != OpCopyIn && op.Op != OpTarIn {
+			continue
+		}
+		err := filepath.WalkDir(r.hostPath(op.Source), func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("operation %d (%s): %w", i+1, op.Op, err)
+		}
+	}
+	return total, nil
+}
+
+// reader reads the host file at p, stopping when the context of the script is done, and reporting
+// the bytes read to the progress
+func (r *runner) reader(f io.Reader, p string) io.Reader {
+	rd := util.ContextReader(r.ctx, f)
+	if r.opts.progress == nil {
+		return rd
+	}
+	return &progressReader{r: rd, runner: r, path: p}
+}
+
+// progressReader reports the bytes read from a host file to the progress of the script
+type progressReader struct {
+	r      io.Reader
+	runner *runner
+	path   string
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.runner.done += int64(n)
+		p.runner.opts.progress.Update(util.PhaseCopy, p.runner.done, p.runner.total, p.path)
+	}
+	return n, err
+}
+
+// setAttrs changes the attributes of the file of set-attrs that it sets
+func setAttrs(f filesystem.FileSystem, op *Operation) error {
+	if op.Mode != "" {
+		mode, err := op.mode()
+		if err != nil {
+			return err
+		}
+		if err := f.Chmod(op.Path, mode); err != nil {
+			return fmt.Errorf("could not change mode of %s: %w", op.Path, err)
+		}
+	}
+	if op.UID != nil || op.GID != nil {
+		uid, gid := -1, -1
+		if op.UID != nil {
+			uid = *op.UID
+		}
+		if op.GID != nil {
+			gid = *op.GID
+		}
+		if err := f.Chown(op.Path, uid, gid); err != nil {
+			return fmt.Errorf("could not change owner of %s: %w", op.Path, err)
+		}
+	}
+	if op.MTime != nil {
+		c, ok := f.(filesystem.ChtimesFS)
+		if !ok {
+			return fmt.Errorf("could not change modification time of %s: %w", op.Path, filesystem.ErrNotSupported)
+		}
+		if err := c.Chtimes(op.Path, time.Time{}, *op.MTime); err != nil {
+			return fmt.Errorf("could not change modification time of %s: %w", op.Path, err)
+		}
+	}
+	return nil
+}
+
+// finish finalizes the squashfs and ISO9660 filesystems made by the script and syncs the others,
+// in the order of their partitions, then closes them all
+func (r *runner) finish() error {
+	parts := make([]int, 0, len(r.filesystems))
+	for part := range r.filesystems {
+		parts = append(parts, part)
+	}
+	slices.Sort(parts)
+	for _, part := range parts {
+		of := r.filesystems[part]
+		var err error
+		switch f := of.fs.(type) {
+		case *squashfs.FileSystem:
+			if f.Workspace() != "" {
+				err = f.FinalizeContext(r.ctx, squashfs.FinalizeOptions{Progress: r.opts.progress})
+			}
+		case *iso9660.FileSystem:
+			if f.Workspace() != "" {
+				err = f.FinalizeContext(r.ctx, iso9660.FinalizeOptions{RockRidge: true, VolumeIdentifier: of.label, Progress: r.opts.progress})
+			}
+		default:
+			err = f.Sync()
+		}
+		if err != nil {
+			r.close()
+			return fmt.Errorf("could not write filesystem of partition %d: %w", part, err)
+		}
+	}
+	return r.close()
+}
+
+// close closes the filesystems used, removing the workspaces of those not finalized
+func (r *runner) close() error {
+	var errs []error
+	for part, of := range r.filesystems {
+		if err := of.fs.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("could not close filesystem of partition %d: %w", part, err))
+		}
+	}
+	r.filesystems = map[int]*openFilesystem{}
+	return errors.Join(errs...)
+}
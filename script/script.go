@@ -0,0 +1,294 @@
+// Package script runs scripts of operations on disk images, like guestfish: creating a partition
+// table, adding partitions, making filesystems and copying files into them, so that CI jobs can
+// build images from a file describing them rather than with Go code for each variation:
+//
+//	{"operations": [
+//		{"op": "create-table", "table": "gpt"},
+//		{"op": "add-partition", "type": "efi", "name": "EFI", "size": "256M"},
+//		{"op": "add-partition", "type": "linux", "name": "root"},
+//		{"op": "mkfs", "partition": 1, "fstype": "fat32", "label": "EFI"},
+//		{"op": "copy-in", "partition": 1, "source": "boot", "path": "/"},
+//		{"op": "mkfs", "partition": 2, "fstype": "squashfs"},
+//		{"op": "tar-in", "partition": 2, "source": "rootfs.tar.gz"},
+//		{"op": "set-attrs", "partition": 2, "path": "/etc/shadow", "mode": "0600", "uid": 0, "gid": 42}
+//	]}
+//
+// Scripts are JSON. Those written in YAML can be converted to JSON, e.g. by sigs.k8s.io/yaml,
+// which decodes YAML with the json names of the fields of Operation.
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The operations of scripts, the values of Operation.Op
+const (
+	// OpCreateTable writes a new, empty partition table of type Table, gpt by default
+	OpCreateTable = "create-table"
+	// OpAddPartition adds a partition of Type, Name and Size to the partition table, in the first
+	// space free for it, aligned to 1MiB, or in the rest of the disk if Size is 0, creating a GPT
+	// table if the disk has none. Partitions are numbered from 1 in the order they are added to a
+	// new table.
+	OpAddPartition = "add-partition"
+	// OpMkfs makes a filesystem of FSType, with Label, in Partition
+	OpMkfs = "mkfs"
+	// OpMkdir creates the directory Path, with its parents
+	OpMkdir = "mkdir"
+	// OpWrite writes Content to the file Path, with its directories, replacing it if it exists
+	OpWrite = "write"
+	// OpCopyIn copies the host file or directory Source to Path, as filesystem.CopyTree does: the
+	// contents of a directory are copied into Path, and a file is copied to Path, or into it if
+	// Path is a directory. The permissions of the files are kept where the filesystem has them.
+	OpCopyIn = "copy-in"
+	// OpTarIn extracts the host tar archive Source, which may be compressed with gzip, into the
+	// root of the filesystem, with filesystem.FromTar
+	OpTarIn = "tar-in"
+	// OpSymlink creates Path as a symbolic link to Target
+	OpSymlink = "symlink"
+	// OpSetAttrs changes the Mode, owner UID and GID, and modification time MTime of Path, those
+	// that are set
+	OpSetAttrs = "set-attrs"
+	// OpRemove removes Path, and everything in it if it is a directory
+	OpRemove = "remove"
+)
+
+// Script is a sequence of operations on a disk, run in order by Run
+type Script struct {
+	Operations []Operation `json:"operations"`
+}
+
+// Operation is one operation of a script, Op, with the fields it uses; see the Op constants. Those
+// on files are on the filesystem in Partition, numbered from 1, or on the whole disk for 0, the
+// one made by an earlier mkfs or else the one already there. Paths in filesystems are absolute,
+// and host paths are relative to the directory of WithBaseDir.
+type Operation struct {
+	// Op is the operation, e.g. OpMkfs
+	Op string `json:"op"`
+	// Table is the type of partition table of create-table, gpt or mbr
+	Table string `json:"table,omitempty"`
+	// Type is the type of partition of add-partition: linux, the default, efi, swap, lvm or fat32,
+	// or a GUID for GPT or a number for MBR
+	Type string `json:"type,omitempty"`
+	// Name is the name of a GPT partition of add-partition
+	Name string `json:"name,omitempty"`
+	// Size is the size of the partition of add-partition, the rest of the disk if 0
+	Size Size `json:"size,omitempty"`
+	// Bootable marks the MBR partition of add-partition active
+	Bootable bool `json:"bootable,omitempty"`
+	// Partition is the partition of the filesystem, starting at 1, or 0 for the whole disk
+	Partition int `json:"partition,omitempty"`
+	// FSType is the type of filesystem of mkfs: fat32, ext4, squashfs or iso9660
+	FSType string `json:"fstype,omitempty"`
+	// Label is the volume label of mkfs
+	Label string `json:"label,omitempty"`
+	// Path is the file or directory in the filesystem
+	Path string `json:"path,omitempty"`
+	// Source is the host file or directory of copy-in, or the tar archive of tar-in
+	Source string `json:"source,omitempty"`
+	// Content is the contents of the file of write
+	Content string `json:"content,omitempty"`
+	// Target is the target of the symbolic link of symlink
+	Target string `json:"target,omitempty"`
+	// Mode is the permissions of set-attrs, in octal, e.g. "0755"
+	Mode string `json:"mode,omitempty"`
+	// UID and GID are the owner of set-attrs
+	UID *int `json:"uid,omitempty"`
+	GID *int `json:"gid,omitempty"`
+	// MTime is the modification time of set-attrs, in RFC 3339 format
+	MTime *time.Time `json:"mtime,omitempty"`
+}
+
+// Size is a size in bytes, a number in JSON or a string with a suffix K, M, G or T for powers of
+// 1024, e.g. "512M"
+type Size int64
+
+// UnmarshalJSON sets the size from a number or a string
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		if n < 0 {
+			return fmt.Errorf("invalid size %d", n)
+		}
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid size %s, must be a number or a string such as \"512M\"", b)
+	}
+	size, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = Size(size)
+	return nil
+}
+
+// ParseSize parses a size in bytes, with an optional suffix K, M, G or T for powers of 1024
+func ParseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	num := s
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", s[i]&^0x20) + 1))
+		num = s[:i]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// Parse reads a script in JSON from r, and validates it. Unknown fields are errors, so that
+// misspelled options are not ignored.
+func Parse(r io.Reader) (*Script, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var s Script
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("could not decode script: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not decode script: data after the script")
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ParseFile reads and validates the script in the file at p
+func ParseFile(p string) (*Script, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not read script %s: %w", p, err)
+	}
+	return Parse(bytes.NewReader(b))
+}
+
+// Validate checks that the operations are known and have the fields they need and no others, so
+// that a script fails before anything is written rather than part way through
+func (s *Script) Validate() error {
+	for i := range s.Operations {
+		op := &s.Operations[i]
+		if err := op.validate(); err != nil {
+			return fmt.Errorf("operation %d (%s): %w", i+1, op.Op, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the fields of the operation
+func (op *Operation) validate() error {
+	// the fields set, which the operation must use
+	fields := map[string]bool{
+		"table":     op.Table != "",
+		"type":      op.Type != "",
+		"name":      op.Name != "",
+		"size":      op.Size != 0,
+		"bootable":  op.Bootable,
+		"partition": op.Partition != 0,
+		"fstype":    op.FSType != "",
+		"label":     op.Label != "",
+		"path":      op.Path != "",
+		"source":    op.Source != "",
+		"content":   op.Content != "",
+		"target":    op.Target != "",
+		"mode":      op.Mode != "",
+		"uid":       op.UID != nil,
+		"gid":       op.GID != nil,
+		"mtime":     op.MTime != nil,
+	}
+	var allowed, required []string
+	switch op.Op {
+	case OpCreateTable:
+		allowed = []string{"table"}
+		if op.Table != "" && op.Table != "gpt" && op.Table != "mbr" {
+			return fmt.Errorf("unknown partition table type %q", op.Table)
+		}
+	case OpAddPartition:
+		allowed = []string{"type", "name", "size", "bootable"}
+	case OpMkfs:
+		allowed, required = []string{"partition", "fstype", "label"}, []string{"fstype"}
+		if _, ok := fsTypes[op.FSType]; !ok && op.FSType != "" {
+			return fmt.Errorf("unknown filesystem type %q", op.FSType)
+		}
+	case OpMkdir, OpRemove:
+		allowed, required = []string{"partition", "path"}, []string{"path"}
+	case OpWrite:
+		allowed, required = []string{"partition", "path", "content"}, []string{"path"}
+	case OpCopyIn:
+		allowed, required = []string{"partition", "path", "source"}, []string{"source"}
+	case OpTarIn:
+		allowed, required = []string{"partition", "source"}, []string{"source"}
+	case OpSymlink:
+		allowed, required = []string{"partition", "path", "target"}, []string{"path", "target"}
+	case OpSetAttrs:
+		allowed, required = []string{"partition", "path", "mode", "uid", "gid", "mtime"}, []string{"path"}
+		if !fields["mode"] && !fields["uid"] && !fields["gid"] && !fields["mtime"] {
+			return errors.New("no mode, uid, gid or mtime to set")
+		}
+		if _, err := op.mode(); err != nil {
+			return err
+		}
+	case "":
+		return errors.New("missing op")
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	for _, f := range required {
+		if !fields[f] {
+			return fmt.Errorf("missing %s", f)
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for f, set := range fields {
+		if set && !slices.Contains(allowed, f) {
+			names = append(names, f)
+		}
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		return fmt.Errorf("%s is not an option of %s", strings.Join(names, ", "), op.Op)
+	}
+	if op.Partition < 0 {
+		return fmt.Errorf("invalid partition %d", op.Partition)
+	}
+	if op.Path != "" && !path.IsAbs(op.Path) {
+		return fmt.Errorf("path %s is not absolute", op.Path)
+	}
+	return nil
+}
+
+// mode returns the permissions of set-attrs, or 0 if there are none
+func (op *Operation) mode() (os.FileMode, error) {
+	if op.Mode == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseUint(op.Mode, 8, 32)
+	if err != nil || m > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q, must be octal permissions such as \"0755\"", op.Mode)
+	}
+	// setuid, setgid and sticky bits are those of os.FileMode rather than of Unix
+	mode := os.FileMode(m & 0o777)
+	if m&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
@@ -0,0 +1,220 @@
+package script_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/script"
+	"github.com/diskfs/go-diskfs/util"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "boot", "BOOT"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "boot", "BOOT", "BOOTX64.EFI"), []byte("loader"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := script.Parse(strings.NewReader(`{"operations": [
+		{"op": "create-table", "table": "gpt"},
+		{"op": "add-partition", "type": "efi", "name": "EFI", "size": "16M"},
+		{"op": "add-partition", "name": "root"},
+		{"op": "mkfs", "partition": 1, "fstype": "fat32", "label": "EFI"},
+		{"op": "copy-in", "partition": 1, "source": "boot", "path": "/EFI"},
+		{"op": "write", "partition": 1, "path": "/loader/loader.conf", "content": "timeout 3\n"},
+		{"op": "write", "partition": 1, "path": "/old", "content": "old"},
+		{"op": "remove", "partition": 1, "path": "/old"}
+	]}`))
+	if err != nil {
+		t.Fatalf("error parsing script: %v", err)
+	}
+	size := int64(64 * 1024 * 1024)
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	var done, total int64
+	progress := util.ProgressFunc(func(phase string, d, t int64, _ string) {
+		if phase == util.PhaseCopy {
+			done, total = d, t
+		}
+	})
+	if err := s.Run(d, script.WithBaseDir(dir), script.WithProgress(progress)); err != nil {
+		t.Fatalf("error running script: %v", err)
+	}
+	if done != 6 || total != 6 {
+		t.Errorf("progress of %d out of %d bytes instead of 6", done, total)
+	}
+
+	table, ok := d.Table.(*gpt.Table)
+	if !ok || len(table.Partitions) != 2 {
+		t.Fatalf("partition table %v instead of GPT with 2 partitions", d.Table)
+	}
+	if p := table.Partitions[0]; p.Type != gpt.EFISystemPartition || p.Name != "EFI" || p.Start != 2048 || p.Size != 16*1024*1024 {
+		t.Errorf("first partition %+v", p)
+	}
+	if p := table.Partitions[1]; p.Type != gpt.LinuxFilesystem || p.Name != "root" || p.Start != 2048+32768 {
+		t.Errorf("second partition %+v", p)
+	}
+
+	esp, err := d.GetFilesystem(1)
+	if err != nil {
+		t.Fatalf("error reading FAT32 filesystem: %v", err)
+	}
+	for name, expected := range map[string]string{"EFI/BOOT/BOOTX64.EFI": "loader", "loader/loader.conf": "timeout 3\n"} {
+		if b, err := esp.ReadFile(name); err != nil || string(b) != expected {
+			t.Errorf("contents %q, %v of %s instead of %q", b, err, name, expected)
+		}
+	}
+	if _, err := esp.Stat("old"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("/old not removed: %v", err)
+	}
+	if label := strings.TrimSpace(esp.Label()); label != "EFI" {
+		t.Errorf("label %q instead of EFI", label)
+	}
+}
+
+func TestRunSquashfs(t *testing.T) {
+	dir := t.TempDir()
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/shadow", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+		{Name: "tmp/", Typeflag: tar.TypeDir, Mode: 0o1777},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			_, _ = tw.Write([]byte("root\n"))
+		}
+	}
+	if err := errors.Join(tw.Close(), gz.Close()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rootfs.tar.gz"), archive.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := script.Parse(strings.NewReader(`{"operations": [
+		{"op": "mkfs", "fstype": "squashfs"},
+		{"op": "tar-in", "source": "rootfs.tar.gz"},
+		{"op": "mkdir", "path": "/var/empty"},
+		{"op": "symlink", "path": "/bin", "target": "usr/bin"},
+		{"op": "set-attrs", "path": "/etc/shadow", "mode": "0600", "mtime": "2024-01-02T03:04:05Z"},
+		{"op": "remove", "path": "/tmp"}
+	]}`))
+	if err != nil {
+		t.Fatalf("error parsing script: %v", err)
+	}
+	// squashfs filesystems are made with blocks of at least 4096 bytes
+	size := int64(16 * 1024 * 1024)
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), false),
+		LogicalBlocksize:  4096,
+		PhysicalBlocksize: 4096,
+		Size:              size,
+	}
+	if err := s.Run(d, script.WithBaseDir(dir)); err != nil {
+		t.Fatalf("error running script: %v", err)
+	}
+
+	sq, err := d.GetFilesystem(0)
+	if err != nil {
+		t.Fatalf("error reading squashfs filesystem: %v", err)
+	}
+	info, err := sq.Stat("etc/shadow")
+	if err != nil {
+		t.Fatalf("error reading /etc/shadow: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 || !info.ModTime().Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("mode %v and time %v of /etc/shadow", info.Mode(), info.ModTime())
+	}
+	if b, err := sq.ReadFile("etc/shadow"); err != nil || string(b) != "root\n" {
+		t.Errorf("contents %q, %v of /etc/shadow", b, err)
+	}
+	if target, err := filesystem.Readlink(sq, "/bin"); err != nil || target != "usr/bin" {
+		t.Errorf("link /bin to %q, %v instead of usr/bin", target, err)
+	}
+	if info, err := sq.Stat("var/empty"); err != nil || !info.IsDir() {
+		t.Errorf("/var/empty is not a directory: %v", err)
+	}
+	if _, err := sq.Stat("tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("/tmp not removed: %v", err)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	size := int64(16 * 1024 * 1024)
+	d := &disk.Disk{
+		Backend:           mem.New(make([]byte, size), false),
+		LogicalBlocksize:  512,
+		PhysicalBlocksize: 512,
+		Size:              size,
+	}
+	s := &script.Script{Operations: []script.Operation{
+		{Op: script.OpAddPartition, Type: "efi"},
+		{Op: script.OpMkfs, Partition: 1, FSType: "fat32"},
+		{Op: script.OpCopyIn, Partition: 1, Source: "missing"},
+	}}
+	err := s.Run(d, script.WithBaseDir(t.TempDir()))
+	if err == nil || !strings.Contains(err.Error(), "operation 3 (copy-in)") || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v instead of the missing source of operation 3", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.RunContext(ctx, d); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v instead of canceled", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{"unknown op", `{"operations": [{"op": "format"}]}`, `operation 1 (format): unknown op "format"`},
+		{"missing op", `{"operations": [{"path": "/a"}]}`, "operation 1 (): missing op"},
+		{"unknown field", `{"operations": [{"op": "mkdir", "paht": "/a"}]}`, `unknown field "paht"`},
+		{"missing field", `{"operations": [{"op": "mkfs", "partition": 1}]}`, "operation 1 (mkfs): missing fstype"},
+		{"unused field", `{"operations": [{"op": "mkdir", "path": "/a", "content": "x", "mode": "0755"}]}`, "operation 1 (mkdir): content, mode is not an option of mkdir"},
+		{"relative path", `{"operations": [{"op": "mkdir", "path": "a"}]}`, "operation 1 (mkdir): path a is not absolute"},
+		{"filesystem type", `{"operations": [{"op": "mkfs", "fstype": "ntfs"}]}`, `operation 1 (mkfs): unknown filesystem type "ntfs"`},
+		{"table type", `{"operations": [{"op": "create-table", "table": "apm"}]}`, `operation 1 (create-table): unknown partition table type "apm"`},
+		{"mode", `{"operations": [{"op": "set-attrs", "path": "/a", "mode": "rwx"}]}`, `operation 1 (set-attrs): invalid mode "rwx"`},
+		{"no attributes", `{"operations": [{"op": "set-attrs", "path": "/a"}]}`, "operation 1 (set-attrs): no mode, uid, gid or mtime to set"},
+		{"size", `{"operations": [{"op": "add-partition", "size": "12X"}]}`, `invalid size "12X"`},
+		{"trailing data", `{"operations": []} {}`, "data after the script"},
+		{"valid", `{"operations": [{"op": "add-partition", "size": 1048576}, {"op": "set-attrs", "path": "/a", "uid": 0}]}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := script.Parse(strings.NewReader(tt.script))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error %v instead of %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+// Package swap creates and reads the headers of Linux swap areas, as mkswap does, so that the
+// swap partitions of disk images can be made without it:
+//
+//	area, err := swap.CreatePartition(d, 3, swap.WithLabel("swap"))
+//	fmt.Printf("UUID=%s none swap defaults 0 0\n", area.UUID)
+//
+// The areas are of version 1, the only one of current kernels, for pages of the size of those of
+// the machine they are used on, DefaultPageSize if not set.
+package swap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultPageSize is the default size of the pages of the area, that of most machines
+	DefaultPageSize = 4096
+	// MinPages is the smallest number of pages of an area, as for mkswap
+	MinPages = 10
+	// signature is at the end of the first page of the area
+	signature = "SWAPSPACE2"
+	// headerOffset is the offset of the header, after space for boot blocks
+	headerOffset = 1024
+	headerSize   = 44
+	version      = 1
+	maxLabel     = 16
+)
+
+// ErrNoSignature is returned by Read where there is no swap signature
+var ErrNoSignature = errors.New("no swap signature")
+
+// pageSizes are the sizes of pages for which Read looks for the signature
+var pageSizes = []int64{4096, 8192, 16384, 32768, 65536}
+
+// Area is the header of a swap area
+type Area struct {
+	// PageSize is the size of the pages the area is made for, with its header in the first one
+	PageSize int64
+	// Pages is the number of pages of the area, with the first
+	Pages int64
+	// UUID is the UUID of the area, often used to find it in /etc/fstab
+	UUID string
+	// Label is the label of the area, of up to 16 bytes, or "" if none
+	Label string
+}
+
+// Size returns the size in bytes of the area used for swapping, after its header
+func (a *Area) Size() int64 {
+	return (a.Pages - 1) * a.PageSize
+}
+
+type opts struct {
+	pageSize int64
+	uuid     string
+	label    string
+}
+
+// Opt func that process Create options
+type Opt func(o *opts) error
+
+// WithPageSize sets the size of the pages of the area, a power of 2 from 4096 to 65536, which
+// must be that of the machine the area is used on, DefaultPageSize if not set
+func WithPageSize(size int64) Opt {
+	return func(o *opts) error {
+		if size < pageSizes[0] || size > pageSizes[len(pageSizes)-1] || size&(size-1) != 0 {
+			return fmt.Errorf("invalid page size %d, must be a power of 2 from 4096 to 65536", size)
+		}
+		o.pageSize = size
+		return nil
+	}
+}
+
+// WithUUID sets the UUID of the area, random if not set
+func WithUUID(id string) Opt {
+	return func(o *opts) error {
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid UUID %s: %w", id, err)
+		}
+		o.uuid = id
+		return nil
+	}
+}
+
+// WithLabel sets the label of the area, of up to 16 bytes, none if not set
+func WithLabel(label string) Opt {
+	return func(o *opts) error {
+		if len(label) > maxLabel {
+			return fmt.Errorf("label %q is longer than %d bytes", label, maxLabel)
+		}
+		o.label = label
+		return nil
+	}
+}
+
+// Create writes the header of a swap area of size bytes at start in the storage, e.g. a
+// partition of a disk.Disk, erasing the first page, and returns it. The size is rounded down to
+// a number of pages, of at least MinPages.
+func Create(b backend.Storage, start, size int64, options ...Opt) (*Area, error) {
+	o := &opts{pageSize: DefaultPageSize}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.uuid == "" {
+		o.uuid = util.NewUUID().String()
+	}
+	a := &Area{PageSize: o.pageSize, Pages: size / o.pageSize, UUID: o.uuid, Label: o.label}
+	if a.Pages < MinPages {
+		return nil, fmt.Errorf("swap area of %d bytes is smaller than %d pages of %d bytes", size, MinPages, a.PageSize)
+	}
+	// the number of the last page is recorded on 32 bits
+	if a.Pages-1 > int64(^uint32(0)) {
+		return nil, fmt.Errorf("swap area of %d pages is larger than %d pages", a.Pages, int64(^uint32(0))+1)
+	}
+	w, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.WriteAt(a.header(), start); err != nil {
+		return nil, fmt.Errorf("error writing swap header: %w", err)
+	}
+	return a, nil
+}
+
+// header returns the first page of the area, with its header and signature, little endian
+func (a *Area) header() []byte {
+	b := make([]byte, a.PageSize)
+	h := b[headerOffset : headerOffset+headerSize]
+	binary.LittleEndian.PutUint32(h[0:4], version)
+	binary.LittleEndian.PutUint32(h[4:8], uint32(a.Pages-1))
+	// no bad pages in h[8:12]
+	id, _ := uuid.Parse(a.UUID)
+	copy(h[12:28], id[:])
+	copy(h[28:44], a.Label)
+	copy(b[a.PageSize-int64(len(signature)):], signature)
+	return b
+}
+
+// Read reads the header of the swap area of size bytes at start of r, and returns it. It returns
+// an error that is ErrNoSignature if there is no swap area of version 1.
+func Read(r io.ReaderAt, start, size int64) (*Area, error) {
+	s := io.NewSectionReader(r, start, size)
+	for _, pageSize := range pageSizes {
+		if pageSize > size {
+			break
+		}
+		b := make([]byte, len(signature))
+		if _, err := s.ReadAt(b, pageSize-int64(len(b))); err != nil {
+			return nil, fmt.Errorf("error reading swap signature: %w", err)
+		}
+		if string(b) != signature {
+			continue
+		}
+		h := make([]byte, headerSize)
+		if _, err := s.ReadAt(h, headerOffset); err != nil {
+			return nil, fmt.Errorf("error reading swap header: %w", err)
+		}
+		if v := binary.LittleEndian.Uint32(h[0:4]); v != version {
+			return nil, fmt.Errorf("unsupported swap version %d", v)
+		}
+		label, _, _ := strings.Cut(string(h[28:44]), "\x00")
+		a := &Area{
+			PageSize: pageSize,
+			Pages:    int64(binary.LittleEndian.Uint32(h[4:8])) + 1,
+			Label:    label,
+		}
+		if id, err := uuid.FromBytes(h[12:28]); err == nil && id != uuid.Nil {
+			a.UUID = id.String()
+		}
+		return a, nil
+	}
+	return nil, ErrNoSignature
+}
+
+// CreatePartition writes the header of a swap area over all of the partition of the disk,
+// numbered from 1 as in disk.GetFilesystem, e.g. one of type gpt.LinuxSwap or mbr.LinuxSwap
+func CreatePartition(d *disk.Disk, partition int, options ...Opt) (*Area, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot use partition %d of %d partitions", partition, len(partitions))
+	}
+	p := partitions[partition-1]
+	return Create(d.Backend, p.GetStart(), p.GetSize(), options...)
+}
@@ -0,0 +1,134 @@
+package swap_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/probe"
+	"github.com/diskfs/go-diskfs/swap"
+)
+
+const id = "12345678-9abc-def0-1234-56789abcdef0"
+
+func TestCreate(t *testing.T) {
+	const size = 1024 * 1024
+	b, err := mem.Create(size + 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// left over contents, erased with the first page
+	if _, err := b.WriteAt(bytes.Repeat([]byte{0xff}, 2*4096), 4096); err != nil {
+		t.Fatal(err)
+	}
+	a, err := swap.Create(b, 4096, size, swap.WithUUID(id), swap.WithLabel("swap0"))
+	if err != nil {
+		t.Fatalf("error creating swap area: %v", err)
+	}
+	if a.Pages != 256 || a.Size() != size-4096 {
+		t.Errorf("created %d pages of %d bytes", a.Pages, a.Size())
+	}
+
+	// the first page written by mkswap -U 12345678-9abc-def0-1234-56789abcdef0 -L swap0
+	expected := make([]byte, 4096)
+	copy(expected[1024:], []byte{
+		0x01, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0,
+		's', 'w', 'a', 'p', '0',
+	})
+	copy(expected[4096-10:], "SWAPSPACE2")
+	if page := b.Bytes()[4096 : 2*4096]; !bytes.Equal(page, expected) {
+		t.Errorf("first page differs from that of mkswap")
+	}
+
+	read, err := swap.Read(b, 4096, size)
+	if err != nil {
+		t.Fatalf("error reading swap area: %v", err)
+	}
+	if *read != *a {
+		t.Errorf("read %+v instead of %+v", *read, *a)
+	}
+	r, err := probe.Probe(b, 4096, size)
+	if err != nil || r.Type != probe.TypeSwap || r.UUID != id || r.Label != "swap0" {
+		t.Errorf("probed %+v, %v", r, err)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	b, err := mem.Create(1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := swap.Create(b, 0, 1024*1024, swap.WithPageSize(65536)); err != nil {
+		t.Fatalf("error creating swap area: %v", err)
+	}
+	a, err := swap.Read(b, 0, 1024*1024)
+	if err != nil {
+		t.Fatalf("error reading swap area: %v", err)
+	}
+	if a.PageSize != 65536 || a.Pages != 16 || a.Label != "" || a.UUID == "" {
+		t.Errorf("read %+v", *a)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	b, err := mem.Create(10 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{
+		ProtectiveMBR: true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 4095, Type: gpt.LinuxFilesystem, Name: "root"},
+			{Start: 4096, End: 8191, Type: gpt.LinuxSwap, Name: "swap"},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	a, err := swap.CreatePartition(d, 2, swap.WithLabel("swap"))
+	if err != nil {
+		t.Fatalf("error creating swap area: %v", err)
+	}
+	if a.Pages != 512 {
+		t.Errorf("created %d pages instead of 512", a.Pages)
+	}
+	r, err := d.Probe(2)
+	if err != nil || r.Type != probe.TypeSwap || r.UUID != a.UUID || r.Label != "swap" {
+		t.Errorf("probed %+v, %v", r, err)
+	}
+	if _, err := swap.Read(b, 2048*512, 2048*512); !errors.Is(err, swap.ErrNoSignature) {
+		t.Errorf("error %v instead of %v", err, swap.ErrNoSignature)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	b, err := mem.Create(1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		size    int64
+		options []swap.Opt
+	}{
+		{"too small", 9 * 4096, nil},
+		{"page size", 1024 * 1024, []swap.Opt{swap.WithPageSize(6000)}},
+		{"label", 1024 * 1024, []swap.Opt{swap.WithLabel("a label of 17 chr")}},
+		{"UUID", 1024 * 1024, []swap.Opt{swap.WithUUID("swap")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := swap.Create(b, 0, tt.size, tt.options...); err == nil {
+				t.Errorf("created swap area")
+			}
+		})
+	}
+}
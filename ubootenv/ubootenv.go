@@ -0,0 +1,313 @@
+// Package ubootenv reads and writes U-Boot environments, the variables that U-Boot reads at boot
+// from a partition or raw offset of its boot medium, as fw_printenv and fw_setenv do:
+//
+//	env, err := ubootenv.ReadPartition(d, 1, 0x20000, true)
+//	env.Vars["bootcmd"] = "run mmcboot"
+//	err = env.Write(d.Backend)
+//
+// Each copy of the environment is a CRC32 of its data followed by the variables, key=value
+// strings each ended by a zero. A redundant environment has two copies, each with a flags byte
+// after the CRC32, so that one copy stays valid while the other is written.
+package ubootenv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/disk"
+)
+
+const (
+	// flags of the copies of a redundant environment, written by U-Boot on flash where bits can
+	// only be cleared; elsewhere the flags of each new copy are those of the other plus one
+	flagObsolete = 0
+	flagActive   = 1
+	// crcSize is the size of the CRC32 at the start of each copy
+	crcSize = 4
+)
+
+// ErrNoEnv is returned by Read and ReadPartition when no copy of the environment has a valid CRC32
+var ErrNoEnv = errors.New("no U-Boot environment with a valid CRC32")
+
+// Env is a U-Boot environment
+type Env struct {
+	// Vars are the variables of the environment
+	Vars map[string]string
+	// Size is the size in bytes of each copy, with its header, CONFIG_ENV_SIZE of U-Boot
+	Size int64
+	// Offsets are the offsets in the storage of the copies, CONFIG_ENV_OFFSET and, for a redundant
+	// environment, CONFIG_ENV_OFFSET_REDUND
+	Offsets []int64
+	// current is the index of the copy in use, and flags its flags
+	current int
+	flags   byte
+}
+
+// Redundant returns whether the environment has two copies
+func (e *Env) Redundant() bool {
+	return len(e.Offsets) == 2
+}
+
+// header returns the size of the header of each copy
+func (e *Env) header() int64 {
+	if e.Redundant() {
+		return crcSize + 1
+	}
+	return crcSize
+}
+
+// check returns an error if the size or offsets of the environment are invalid
+func (e *Env) check() error {
+	if len(e.Offsets) != 1 && len(e.Offsets) != 2 {
+		return fmt.Errorf("environment must have 1 or 2 copies, not %d", len(e.Offsets))
+	}
+	if e.Size <= e.header() {
+		return fmt.Errorf("invalid environment size %d", e.Size)
+	}
+	if e.Redundant() && e.Offsets[0] < e.Offsets[1]+e.Size && e.Offsets[1] < e.Offsets[0]+e.Size {
+		return fmt.Errorf("copies of %d bytes at %d and %d overlap", e.Size, e.Offsets[0], e.Offsets[1])
+	}
+	return nil
+}
+
+// Create writes a new environment with the variables to all of its copies, of size bytes each at
+// the offsets in the storage: one, or two for a redundant environment
+func Create(b backend.Storage, size int64, vars map[string]string, offsets ...int64) (*Env, error) {
+	e := &Env{Vars: vars, Size: size, Offsets: offsets}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	data, err := e.data()
+	if err != nil {
+		return nil, err
+	}
+	w, err := b.Writable()
+	if err != nil {
+		return nil, err
+	}
+	// the first copy is the one in use, whether the flags are read as a boolean or a counter
+	for i, flags := range []byte{flagActive, flagObsolete}[:len(offsets)] {
+		if _, err := w.WriteAt(e.copyBytes(data, flags), offsets[i]); err != nil {
+			return nil, fmt.Errorf("error writing copy %d of environment: %w", i, err)
+		}
+	}
+	e.flags = flagActive
+	return e, nil
+}
+
+// Read reads the environment of size bytes at the offsets of r: one, or two for a redundant
+// environment, in which case the variables are those of the valid copy written last, as U-Boot
+// reads them. It returns ErrNoEnv if no copy is valid.
+func Read(r io.ReaderAt, size int64, offsets ...int64) (*Env, error) {
+	e := &Env{Size: size, Offsets: offsets}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	var (
+		copies [][]byte
+		valid  []bool
+	)
+	for i, offset := range offsets {
+		b := make([]byte, size)
+		if _, err := r.ReadAt(b, offset); err != nil {
+			return nil, fmt.Errorf("error reading copy %d of environment at %d: %w", i, offset, err)
+		}
+		copies = append(copies, b)
+		valid = append(valid, binary.LittleEndian.Uint32(b) == crc32.ChecksumIEEE(b[e.header():]))
+	}
+	switch {
+	case !slices.Contains(valid, true):
+		return nil, ErrNoEnv
+	case len(copies) == 1 || !valid[1]:
+		e.current = 0
+	case !valid[0]:
+		e.current = 1
+	default:
+		e.current = newer(copies[0][crcSize], copies[1][crcSize])
+	}
+	b := copies[e.current]
+	if e.Redundant() {
+		e.flags = b[crcSize]
+	}
+	vars, err := parse(b[e.header():])
+	if err != nil {
+		return nil, err
+	}
+	e.Vars = vars
+	return e, nil
+}
+
+// newer returns the index of the copy written last of two valid copies with the flags, as by
+// env_check_redund of U-Boot
+func newer(flags0, flags1 byte) int {
+	switch {
+	case flags0 == flagActive && flags1 == flagObsolete:
+		return 0
+	case flags0 == flagObsolete && flags1 == flagActive:
+		return 1
+	// counters wrap around
+	case flags0 == 0xff && flags1 == 0:
+		return 1
+	case flags0 == 0 && flags1 == 0xff:
+		return 0
+	case flags1 > flags0:
+		return 1
+	}
+	return 0
+}
+
+// Write writes the variables of the environment. A redundant environment is written to the copy
+// not in use, with flags above those of the other, which it then replaces; the copy in use is left
+// as it was, for U-Boot to fall back to if the write is interrupted.
+func (e *Env) Write(b backend.Storage) error {
+	if err := e.check(); err != nil {
+		return err
+	}
+	data, err := e.data()
+	if err != nil {
+		return err
+	}
+	w, err := b.Writable()
+	if err != nil {
+		return err
+	}
+	next, flags := 0, byte(0)
+	if e.Redundant() {
+		next, flags = 1-e.current, e.flags+1
+	}
+	if _, err := w.WriteAt(e.copyBytes(data, flags), e.Offsets[next]); err != nil {
+		return fmt.Errorf("error writing copy %d of environment: %w", next, err)
+	}
+	e.current, e.flags = next, flags
+	return nil
+}
+
+// data returns the variables sorted by name, as U-Boot exports them, each key=value ended by a
+// zero, padded with zeros to the size of the data of each copy
+func (e *Env) data() ([]byte, error) {
+	keys := make([]string, 0, len(e.Vars))
+	for k := range e.Vars {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		v := e.Vars[k]
+		if k == "" || strings.ContainsAny(k, "=\x00") {
+			return nil, fmt.Errorf("invalid variable name %q", k)
+		}
+		if strings.ContainsRune(v, 0) {
+			return nil, fmt.Errorf("value of variable %s contains a zero byte", k)
+		}
+		buf.WriteString(k + "=" + v + "\x00")
+	}
+	size := int(e.Size - e.header())
+	// the variables end with an empty string
+	if buf.Len()+1 > size {
+		return nil, fmt.Errorf("variables of %d bytes do not fit in an environment of %d bytes", buf.Len()+1, size)
+	}
+	data := make([]byte, size)
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// copyBytes returns a copy of the environment with the data, and the flags if redundant
+func (e *Env) copyBytes(data []byte, flags byte) []byte {
+	b := make([]byte, e.header(), e.Size)
+	binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(data))
+	if e.Redundant() {
+		b[crcSize] = flags
+	}
+	return append(b, data...)
+}
+
+// parse returns the variables of the data of a copy, up to the first empty string
+func parse(data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	for len(data) > 0 && data[0] != 0 {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, fmt.Errorf("environment variables are not terminated")
+		}
+		k, v, ok := strings.Cut(string(data[:end]), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid environment variable %q", data[:end])
+		}
+		vars[k] = v
+		data = data[end+1:]
+	}
+	return vars, nil
+}
+
+// ParseText returns the variables of a text file of key=value lines, as read by mkenvimage and
+// fw_setenv --script, skipping empty lines and comments starting with #
+func ParseText(r io.Reader) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(text, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("line %d is not of the form key=value: %q", line, text)
+		}
+		vars[k] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading variables: %w", err)
+	}
+	return vars, nil
+}
+
+// partitionOffsets returns the offsets of the copies in the partition of the disk, numbered from 1
+// as in disk.GetFilesystem, the second after the first if redundant
+func partitionOffsets(d *disk.Disk, partition int, size int64, redundant bool) ([]int64, error) {
+	if d.Table == nil {
+		return nil, fmt.Errorf("disk has no partition table")
+	}
+	partitions := d.Table.GetPartitions()
+	if partition < 1 || partition > len(partitions) {
+		return nil, fmt.Errorf("cannot use partition %d of %d partitions", partition, len(partitions))
+	}
+	p := partitions[partition-1]
+	offsets := []int64{p.GetStart()}
+	if redundant {
+		offsets = append(offsets, p.GetStart()+size)
+	}
+	if n := int64(len(offsets)) * size; n > p.GetSize() {
+		return nil, fmt.Errorf("environment of %d bytes does not fit in partition %d of %d bytes", n, partition, p.GetSize())
+	}
+	return offsets, nil
+}
+
+// CreatePartition writes a new environment with the variables to the start of the partition of the
+// disk, numbered from 1 as in disk.GetFilesystem, with a second copy right after the first if
+// redundant
+func CreatePartition(d *disk.Disk, partition int, size int64, redundant bool, vars map[string]string) (*Env, error) {
+	offsets, err := partitionOffsets(d, partition, size, redundant)
+	if err != nil {
+		return nil, err
+	}
+	return Create(d.Backend, size, vars, offsets...)
+}
+
+// ReadPartition reads the environment at the start of the partition of the disk, numbered from 1
+// as in disk.GetFilesystem, with a second copy right after the first if redundant. Write it back
+// to d.Backend.
+func ReadPartition(d *disk.Disk, partition int, size int64, redundant bool) (*Env, error) {
+	offsets, err := partitionOffsets(d, partition, size, redundant)
+	if err != nil {
+		return nil, err
+	}
+	return Read(d.Backend, size, offsets...)
+}
@@ -0,0 +1,214 @@
+package ubootenv_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"maps"
+	"strings"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/ubootenv"
+)
+
+var vars = map[string]string{"bootcmd": "run mmcboot", "baudrate": "115200"}
+
+func TestCreate(t *testing.T) {
+	b, err := mem.Create(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ubootenv.Create(b, 64, vars, 128); err != nil {
+		t.Fatalf("error creating environment: %v", err)
+	}
+	buf := make([]byte, 64)
+	if _, err := b.ReadAt(buf, 128); err != nil {
+		t.Fatal(err)
+	}
+	// the CRC32 and the variables sorted by name, as U-Boot exports them
+	expected := append([]byte{0xd4, 0xf1, 0xc6, 0xda}, "baudrate=115200\x00bootcmd=run mmcboot\x00"...)
+	expected = append(expected, make([]byte, 64-len(expected))...)
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("environment is\n%q instead of\n%q", buf, expected)
+	}
+	env, err := ubootenv.Read(b, 64, 128)
+	if err != nil {
+		t.Fatalf("error reading environment: %v", err)
+	}
+	if !maps.Equal(env.Vars, vars) || env.Redundant() {
+		t.Errorf("read %v", env.Vars)
+	}
+
+	// a changed byte breaks the CRC32
+	if _, err := b.WriteAt([]byte("B"), 132); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ubootenv.Read(b, 64, 128); !errors.Is(err, ubootenv.ErrNoEnv) {
+		t.Errorf("read corrupted environment: %v", err)
+	}
+}
+
+func TestRedundant(t *testing.T) {
+	b, err := mem.Create(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ubootenv.Create(b, 64, vars, 0, 512); err != nil {
+		t.Fatalf("error creating environment: %v", err)
+	}
+	flags := func(offset int64) byte {
+		f := make([]byte, 5)
+		if _, err := b.ReadAt(f, offset); err != nil {
+			t.Fatal(err)
+		}
+		return f[4]
+	}
+	if flags(0) != 1 || flags(512) != 0 {
+		t.Errorf("copies of flags %d and %d instead of 1 and 0", flags(0), flags(512))
+	}
+	env, err := ubootenv.Read(b, 64, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading environment: %v", err)
+	}
+	// each write goes to the other copy, with flags one more than those of the copy in use
+	for i, expected := range []struct {
+		offset int64
+		flags  byte
+	}{{512, 2}, {0, 3}} {
+		env.Vars["bootcount"] = string(rune('1' + i))
+		if err := env.Write(b); err != nil {
+			t.Fatalf("error writing environment: %v", err)
+		}
+		if f := flags(expected.offset); f != expected.flags {
+			t.Errorf("write %d: copy at %d of flags %d instead of %d", i, expected.offset, f, expected.flags)
+		}
+		read, err := ubootenv.Read(b, 64, 0, 512)
+		if err != nil {
+			t.Fatalf("error reading environment: %v", err)
+		}
+		if read.Vars["bootcount"] != env.Vars["bootcount"] {
+			t.Errorf("write %d: read bootcount %q instead of %q", i, read.Vars["bootcount"], env.Vars["bootcount"])
+		}
+	}
+
+	// an interrupted write falls back to the other copy
+	if _, err := b.WriteAt([]byte{0}, 10); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ubootenv.Read(b, 64, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading environment: %v", err)
+	}
+	if read.Vars["bootcount"] != "1" {
+		t.Errorf("read bootcount %q of the copy with the bad CRC32", read.Vars["bootcount"])
+	}
+}
+
+// redundantCopy returns a copy of a redundant environment of 64 bytes with the flags
+func redundantCopy(data string, flags byte) []byte {
+	b := append(make([]byte, 4), flags)
+	b = append(b, data...)
+	b = append(b, make([]byte, 64-len(b))...)
+	binary.LittleEndian.PutUint32(b, crc32.ChecksumIEEE(b[5:]))
+	return b
+}
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		flags0, flags1 byte
+		expected       string
+	}{
+		{1, 0, "0"},
+		{0, 1, "1"},
+		{3, 4, "1"},
+		{5, 4, "0"},
+		// counters wrap around
+		{0xff, 0, "1"},
+		{0, 0xff, "0"},
+		{2, 2, "0"},
+	}
+	for _, tt := range tests {
+		b := mem.New(append(redundantCopy("copy=0\x00", tt.flags0), redundantCopy("copy=1\x00", tt.flags1)...), true)
+		env, err := ubootenv.Read(b, 64, 0, 64)
+		if err != nil {
+			t.Fatalf("error reading environment: %v", err)
+		}
+		if env.Vars["copy"] != tt.expected {
+			t.Errorf("flags %d and %d: read copy %s instead of %s", tt.flags0, tt.flags1, env.Vars["copy"], tt.expected)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	b, err := mem.Create(4 * 1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := diskfs.OpenBackend(b, diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{Partitions: []*gpt.Partition{{Start: 2048, Size: 256 * 1024, Type: gpt.LinuxFilesystem, Name: "uboot-env"}}}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	if _, err := ubootenv.CreatePartition(d, 1, 0x20000, true, vars); err != nil {
+		t.Fatalf("error creating environment: %v", err)
+	}
+	env, err := ubootenv.Read(b, 0x20000, 2048*512, 2048*512+0x20000)
+	if err != nil {
+		t.Fatalf("error reading environment: %v", err)
+	}
+	if !maps.Equal(env.Vars, vars) {
+		t.Errorf("read %v", env.Vars)
+	}
+	if env, err = ubootenv.ReadPartition(d, 1, 0x20000, true); err != nil || !maps.Equal(env.Vars, vars) {
+		t.Errorf("read %v, %v", env, err)
+	}
+	if _, err := ubootenv.ReadPartition(d, 1, 0x40000, true); err == nil {
+		t.Errorf("read environment larger than the partition")
+	}
+	if _, err := ubootenv.ReadPartition(d, 2, 0x20000, false); err == nil {
+		t.Errorf("read environment of partition 2")
+	}
+}
+
+func TestParseText(t *testing.T) {
+	parsed, err := ubootenv.ParseText(strings.NewReader("# boot\nbootcmd=run mmcboot\n\nbaudrate=115200\n"))
+	if err != nil || !maps.Equal(parsed, vars) {
+		t.Errorf("parsed %v, %v", parsed, err)
+	}
+	if _, err := ubootenv.ParseText(strings.NewReader("bootcmd\n")); err == nil {
+		t.Errorf("parsed line without value")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	b, err := mem.Create(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		size    int64
+		vars    map[string]string
+		offsets []int64
+	}{
+		{"too small", 32, vars, []int64{0}},
+		{"no copies", 64, vars, nil},
+		{"overlap", 64, vars, []int64{0, 32}},
+		{"name", 64, map[string]string{"a=b": "c"}, []int64{0}},
+		{"value", 64, map[string]string{"a": "b\x00c"}, []int64{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ubootenv.Create(b, tt.size, tt.vars, tt.offsets...); err == nil {
+				t.Errorf("created environment")
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// minPooledBuffer and maxPooledBuffer are the sizes of the smallest and largest buffers pooled
+const (
+	minPooledBuffer = 512
+	maxPooledBuffer = 16 * 1024 * 1024
+)
+
+// BufferPool reuses the byte buffers of reads, e.g. of the compressed blocks of squashfs before
+// they are decompressed, so that extracting a large image does not allocate a buffer for every
+// block, and spend its time collecting them. It keeps a sync.Pool for each power of two size of
+// buffer. It is safe for concurrent use; a nil BufferPool allocates every buffer.
+type BufferPool struct {
+	pools [bits.UintSize]sync.Pool
+}
+
+// DefaultBufferPool is the pool of buffers shared by the filesystems unless they are given another
+var DefaultBufferPool = &BufferPool{}
+
+// class returns the index of the pool of buffers of at least size bytes, or -1 if not pooled
+func class(size int) int {
+	if size <= 0 || size > maxPooledBuffer {
+		return -1
+	}
+	return bits.Len(uint(max(size, minPooledBuffer) - 1))
+}
+
+// Get returns a buffer of size bytes, with whatever it held when put back
+func (p *BufferPool) Get(size int) []byte {
+	c := class(size)
+	if p == nil || c < 0 {
+		return make([]byte, size)
+	}
+	if b, ok := p.pools[c].Get().(*[]byte); ok {
+		return (*b)[:size]
+	}
+	return make([]byte, size, 1<<c)
+}
+
+// Put gives back a buffer from Get, which must no longer be used. Buffers not from Get are not
+// kept.
+func (p *BufferPool) Put(b []byte) {
+	c := class(cap(b))
+	if p == nil || c < 0 || cap(b) != 1<<c {
+		return
+	}
+	b = b[:cap(b)]
+	p.pools[c].Put(&b)
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Processors returns the number of goroutines to process with for an option of n, the number of
+// CPUs if n is 0
+func Processors(n int) int {
+	if n <= 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// Pipeline reads items in turn, processes up to processors of them at the same time, e.g. to
+// compress blocks of data, and writes the results in the order the items were read, so that they
+// can be written one after the other the same whatever the number of processors:
+//
+//   - read is called from a single goroutine until it returns io.EOF
+//   - process is called from processors goroutines
+//   - write is called from the goroutine calling Pipeline, with each item and its result
+//
+// The first error returned by any of them, or the context, stops the pipeline and is returned.
+// With processors of 1 or less, each item is read, processed and written in turn without other
+// goroutines.
+func Pipeline[T, U any](ctx context.Context, processors int, read func() (T, error), process func(T) (U, error), write func(T, U) error) error {
+	if processors <= 1 {
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item, err := read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			result, err := process(item)
+			if err != nil {
+				return err
+			}
+			if err := write(item, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	type job struct {
+		item   T
+		result U
+		err    error
+		done   chan struct{}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	// jobs go to the processors, and in order to the writer, which holds at most twice as many
+	// items as there are processors
+	jobs := make(chan *job)
+	pending := make(chan *job, 2*processors)
+	readErr := make(chan error, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(jobs)
+		for {
+			item, err := read()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErr <- err
+				}
+				return
+			}
+			j := &job{item: item, done: make(chan struct{})}
+			select {
+			case pending <- j:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; i < processors; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.result, j.err = process(j.item)
+				close(j.done)
+			}
+		}()
+	}
+
+	for j := range pending {
+		select {
+		case <-j.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if j.err != nil {
+			return j.err
+		}
+		if err := write(j.item, j.result); err != nil {
+			return err
+		}
+	}
+	select {
+	case err := <-readErr:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,37 @@
+package util
+
+// phases reported to Progress by the operations of go-diskfs
+const (
+	// PhaseCopy is the copy of files between filesystems, by filesystem.CopyTree
+	PhaseCopy = "copy"
+	// PhaseData is the writing of the contents of files when a filesystem is finalized
+	PhaseData = "data"
+	// PhaseImage is the writing of a whole disk image, by Disk.WriteImage
+	PhaseImage = "image"
+	// PhaseCheck is the reading of the contents of files, by filesystem.Check
+	PhaseCheck = "check"
+	// PhaseHash is the reading of the contents of files to hash them, by filesystem.WriteManifest
+	// and VerifyManifest, and of partitions, by Disk.Manifest and VerifyManifest
+	PhaseHash = "hash"
+	// PhaseClone is the copy of the allocated regions of a disk, by Disk.CloneTo
+	PhaseClone = "clone"
+	// PhaseBuild is the writing of the blobs and partition images of an image, by imagebuild.Build
+	PhaseBuild = "build"
+)
+
+// Progress receives the progress of long-running operations, the same way for all of them, so
+// that a user interface need not know each one
+type Progress interface {
+	// Update is called as the operation advances through its phase, one of the Phase constants,
+	// with the bytes done so far and the total bytes of the phase, or 0 if it is not known. The
+	// path is that of the file being worked on, if any.
+	Update(phase string, done, total int64, path string)
+}
+
+// ProgressFunc is a function used as a Progress
+type ProgressFunc func(phase string, done, total int64, path string)
+
+// Update calls f
+func (f ProgressFunc) Update(phase string, done, total int64, path string) {
+	f(phase, done, total, path)
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	mathrand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// reproducible is the state of the reproducible mode, see SetReproducible
+var reproducible struct {
+	mu      sync.Mutex
+	enabled bool
+	epoch   time.Time
+	stream  *mathrand.ChaCha8
+	// pending holds the bytes of the last value of the stream not read yet
+	pending []byte
+}
+
+// SetReproducible makes every writer of go-diskfs deterministic, so that building the same disk
+// image the same way gives the same bytes: the GUIDs of GPT tables and partitions, the MBR disk
+// signatures, the UUIDs and hash seeds of ext4, the serial numbers of FAT32, the identifiers of
+// image formats such as VHD, VHDX, VDI and VMDK, and the salts of dm-verity and dm-integrity come
+// from a stream seeded with seed, and the times of filesystems, their creation and the files
+// written to them, are epoch. The times of the files from the host put into squashfs and ISO9660
+// images are clamped to epoch, as SOURCE_DATE_EPOCH is. The random values are those of the order in
+// which they are taken, so images must be built in the same order, one at a time.
+//
+// The mode is for builds, not for disks in use: identifiers repeat in all the images built from the
+// same seed. ClearReproducible ends it.
+func SetReproducible(seed []byte, epoch time.Time) {
+	reproducible.mu.Lock()
+	defer reproducible.mu.Unlock()
+	reproducible.enabled = true
+	reproducible.epoch = epoch
+	reproducible.stream = mathrand.NewChaCha8(sha256.Sum256(seed))
+	reproducible.pending = nil
+}
+
+// ClearReproducible ends the reproducible mode of SetReproducible, so that identifiers are random
+// and times are the current time again
+func ClearReproducible() {
+	reproducible.mu.Lock()
+	defer reproducible.mu.Unlock()
+	reproducible.enabled = false
+	reproducible.stream = nil
+	reproducible.pending = nil
+}
+
+// Reproducible returns whether the reproducible mode of SetReproducible is on, and its epoch
+func Reproducible() (time.Time, bool) {
+	reproducible.mu.Lock()
+	defer reproducible.mu.Unlock()
+	return reproducible.epoch, reproducible.enabled
+}
+
+// Now returns the current time, or the epoch of SetReproducible
+func Now() time.Time {
+	if epoch, ok := Reproducible(); ok {
+		return epoch
+	}
+	return time.Now()
+}
+
+// ClampTime returns t, or the epoch of SetReproducible if t is later
+func ClampTime(t time.Time) time.Time {
+	if epoch, ok := Reproducible(); ok && t.After(epoch) {
+		return epoch
+	}
+	return t
+}
+
+// Rand is the source of the random identifiers of the writers of go-diskfs: crypto/rand, or the
+// stream of the seed of SetReproducible. Its Read always fills the buffer.
+var Rand io.Reader = randReader{}
+
+type randReader struct{}
+
+func (randReader) Read(b []byte) (int, error) {
+	reproducible.mu.Lock()
+	defer reproducible.mu.Unlock()
+	if !reproducible.enabled {
+		return rand.Read(b)
+	}
+	for n := 0; n < len(b); {
+		if len(reproducible.pending) == 0 {
+			reproducible.pending = binary.LittleEndian.AppendUint64(make([]byte, 0, 8), reproducible.stream.Uint64())
+		}
+		c := copy(b[n:], reproducible.pending)
+		reproducible.pending = reproducible.pending[c:]
+		n += c
+	}
+	return len(b), nil
+}
+
+// NewUUID returns a new random (version 4) UUID from Rand
+func NewUUID() uuid.UUID {
+	u, err := uuid.NewRandomFromReader(Rand)
+	if err != nil {
+		// the reader of crypto/rand does not fail, as uuid.New assumes
+		panic(err)
+	}
+	return u
+}
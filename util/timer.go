@@ -0,0 +1,52 @@
+package util
+
+import (
+	"log/slog"
+	"time"
+)
+
+// PhaseTimer logs the time taken by the steps of an operation, such as those of the Finalize of
+// filesystems. A nil PhaseTimer, as returned by NewPhaseTimer for a nil logger, logs nothing.
+type PhaseTimer struct {
+	logger     *slog.Logger
+	op         string
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+}
+
+// NewPhaseTimer returns a timer of the operation op, which logs to logger, or nil if logger is nil
+func NewPhaseTimer(logger *slog.Logger, op string) *PhaseTimer {
+	if logger == nil {
+		return nil
+	}
+	now := time.Now()
+	return &PhaseTimer{logger: logger, op: op, start: now, phaseStart: now}
+}
+
+// Phase ends the current step, logging its duration at slog.LevelDebug, and starts the step named
+// phase
+func (t *PhaseTimer) Phase(phase string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	if t.phase != "" {
+		t.logger.Debug("phase done", "op", t.op, "phase", t.phase, "duration", now.Sub(t.phaseStart))
+	}
+	t.phase, t.phaseStart = phase, now
+}
+
+// Done ends the current step and the operation, logging the duration of the whole of it at
+// slog.LevelInfo, with the error that ended it if any
+func (t *PhaseTimer) Done(err error) {
+	if t == nil {
+		return
+	}
+	t.Phase("")
+	if err != nil {
+		t.logger.Info("failed", "op", t.op, "duration", time.Since(t.start), "error", err)
+		return
+	}
+	t.logger.Info("done", "op", t.op, "duration", time.Since(t.start))
+}
@@ -0,0 +1,68 @@
+package verity
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/partition/part"
+)
+
+// CreatePartition computes the hash tree of the data partition of the disk, numbered from 1 as in
+// disk.GetFilesystem, and writes it with its superblock to the start of the hash partition, e.g.
+// the root and root-verity partitions of the Discoverable Partitions Specification
+func CreatePartition(d *disk.Disk, data, hash int, options ...Opt) (*Tree, error) {
+	dataPartition, hashPartition, err := partitions(d, data, hash)
+	if err != nil {
+		return nil, err
+	}
+	w, err := d.Backend.Writable()
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTree(dataPartition.GetSize(), options...)
+	if err != nil {
+		return nil, err
+	}
+	if t.HashSize() > hashPartition.GetSize() {
+		return nil, fmt.Errorf("hash tree of %d bytes does not fit in partition %d of %d bytes", t.HashSize(), hash, hashPartition.GetSize())
+	}
+	r := io.NewSectionReader(d.Backend, dataPartition.GetStart(), dataPartition.GetSize())
+	if err := t.write(r, io.NewOffsetWriter(w, hashPartition.GetStart())); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// VerifyPartition checks the data partition of the disk against the hash tree at the start of
+// the hash partition and the root hash, and returns the tree
+func VerifyPartition(d *disk.Disk, data, hash int, rootHash []byte) (*Tree, error) {
+	dataPartition, hashPartition, err := partitions(d, data, hash)
+	if err != nil {
+		return nil, err
+	}
+	hashReader := io.NewSectionReader(d.Backend, hashPartition.GetStart(), hashPartition.GetSize())
+	t, err := ReadSuperblock(hashReader, 0)
+	if err != nil {
+		return nil, err
+	}
+	if t.DataBlocks*t.DataBlockSize > dataPartition.GetSize() {
+		return nil, fmt.Errorf("hash tree of %d data blocks is larger than partition %d", t.DataBlocks, data)
+	}
+	t.RootHash = rootHash
+	return t, Verify(io.NewSectionReader(d.Backend, dataPartition.GetStart(), dataPartition.GetSize()), hashReader, t)
+}
+
+// partitions returns the data and hash partitions of the disk
+func partitions(d *disk.Disk, data, hash int) (dataPartition, hashPartition part.Partition, err error) {
+	if d.Table == nil {
+		return nil, nil, fmt.Errorf("disk has no partition table")
+	}
+	all := d.Table.GetPartitions()
+	for _, n := range []int{data, hash} {
+		if n < 1 || n > len(all) {
+			return nil, nil, fmt.Errorf("cannot use partition %d of %d partitions", n, len(all))
+		}
+	}
+	return all[data-1], all[hash-1], nil
+}
@@ -0,0 +1,80 @@
+package verity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	superblockSignature = "verity\x00\x00"
+	superblockVersion   = 1
+	// hashTypeNormal hashes the salt before each block, as opposed to the hash type 0 of Chrome OS
+	hashTypeNormal = 1
+)
+
+// superblock returns the superblock of veritysetup describing the tree, little endian
+func (t *Tree) superblock() []byte {
+	b := make([]byte, superblockSize)
+	copy(b[0:8], superblockSignature)
+	binary.LittleEndian.PutUint32(b[8:12], superblockVersion)
+	binary.LittleEndian.PutUint32(b[12:16], hashTypeNormal)
+	id, _ := uuid.Parse(t.UUID)
+	copy(b[16:32], id[:])
+	copy(b[32:64], t.Algorithm)
+	binary.LittleEndian.PutUint32(b[64:68], uint32(t.DataBlockSize))
+	binary.LittleEndian.PutUint32(b[68:72], uint32(t.HashBlockSize))
+	binary.LittleEndian.PutUint64(b[72:80], uint64(t.DataBlocks))
+	binary.LittleEndian.PutUint16(b[80:82], uint16(len(t.Salt)))
+	copy(b[88:344], t.Salt)
+	return b
+}
+
+// ReadSuperblock reads the superblock at the offset of the hash device, and returns the tree it
+// describes, without its root hash, which is not recorded and must be set before Verify
+func ReadSuperblock(r io.ReaderAt, offset int64) (*Tree, error) {
+	b := make([]byte, superblockSize)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, fmt.Errorf("error reading superblock: %w", err)
+	}
+	if string(b[0:8]) != superblockSignature {
+		return nil, fmt.Errorf("no verity superblock at %d", offset)
+	}
+	if version := binary.LittleEndian.Uint32(b[8:12]); version != superblockVersion {
+		return nil, fmt.Errorf("unsupported superblock version %d", version)
+	}
+	if hashType := binary.LittleEndian.Uint32(b[12:16]); hashType != hashTypeNormal {
+		return nil, fmt.Errorf("unsupported hash type %d", hashType)
+	}
+	id, err := uuid.FromBytes(b[16:32])
+	if err != nil {
+		return nil, fmt.Errorf("invalid UUID: %w", err)
+	}
+	algorithm, _, _ := strings.Cut(string(b[32:64]), "\x00")
+	if _, ok := algorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %s", algorithm)
+	}
+	saltSize := int(binary.LittleEndian.Uint16(b[80:82]))
+	if saltSize > maxSaltSize {
+		return nil, fmt.Errorf("invalid salt size %d", saltSize)
+	}
+	t := &Tree{
+		Algorithm:     algorithm,
+		DataBlockSize: int64(binary.LittleEndian.Uint32(b[64:68])),
+		HashBlockSize: int64(binary.LittleEndian.Uint32(b[68:72])),
+		DataBlocks:    int64(binary.LittleEndian.Uint64(b[72:80])),
+		Salt:          append([]byte{}, b[88:88+saltSize]...),
+		UUID:          id.String(),
+		HashOffset:    offset,
+		Superblock:    true,
+	}
+	for _, size := range []int64{t.DataBlockSize, t.HashBlockSize} {
+		if size < 512 || size > 65536 || size&(size-1) != 0 {
+			return nil, fmt.Errorf("invalid block size %d", size)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,356 @@
+// Package verity computes the dm-verity hash trees of images and partitions, and verifies them,
+// in the format of veritysetup, so that verified boot images can be built without it:
+//
+//	tree, err := verity.Create(data, size, hash)
+//	fmt.Printf("roothash=%x\n", tree.RootHash)
+//
+// The hash tree is of version 1, with a superblock before it unless WithoutSuperblock, so that
+// it can be opened with
+//
+//	veritysetup open data.img name hash.img <root hash>
+//
+// or with the table of Tree.Table. Forward error correction is not supported.
+package verity
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // veritysetup supports SHA1 hash trees
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+
+	"github.com/diskfs/go-diskfs/util"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultBlockSize is the default size of the data and hash blocks
+	DefaultBlockSize = 4096
+	// DefaultAlgorithm is the default hash algorithm
+	DefaultAlgorithm = "sha256"
+	// superblockSize is the size of the superblock before the hash tree
+	superblockSize = 512
+	// maxSaltSize is the largest salt the superblock holds
+	maxSaltSize = 256
+)
+
+// ErrCorrupted is returned by Verify where the data or the hash tree do not match
+var ErrCorrupted = errors.New("verity hash mismatch")
+
+var algorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Tree is a dm-verity hash tree, with the parameters needed to open it
+type Tree struct {
+	// Algorithm is the hash algorithm, e.g. "sha256"
+	Algorithm string
+	// DataBlockSize and HashBlockSize are the sizes in bytes of the blocks of the data and the
+	// hash tree
+	DataBlockSize int64
+	HashBlockSize int64
+	// DataBlocks is the number of blocks of data
+	DataBlocks int64
+	// Salt is hashed before each block
+	Salt []byte
+	// UUID is the UUID recorded in the superblock
+	UUID string
+	// HashOffset is the offset in bytes in the hash device of the superblock, or of the hash tree
+	// without superblock
+	HashOffset int64
+	// Superblock is whether there is a superblock before the hash tree
+	Superblock bool
+	// RootHash is the hash of the top block of the tree, which is to be trusted, e.g. by signing
+	// it or passing it on the kernel command line
+	RootHash []byte
+}
+
+type opts struct {
+	algorithm     string
+	dataBlockSize int64
+	hashBlockSize int64
+	salt          []byte
+	uuid          string
+	hashOffset    int64
+	noSuperblock  bool
+}
+
+// Opt func that process Create options
+type Opt func(o *opts) error
+
+// WithAlgorithm sets the hash algorithm, "sha1", "sha256" or "sha512", DefaultAlgorithm if not set
+func WithAlgorithm(algorithm string) Opt {
+	return func(o *opts) error {
+		if _, ok := algorithms[algorithm]; !ok {
+			return fmt.Errorf("unsupported hash algorithm %s", algorithm)
+		}
+		o.algorithm = algorithm
+		return nil
+	}
+}
+
+// WithBlockSizes sets the sizes of the data and hash blocks, powers of 2 from 512 to 65536,
+// DefaultBlockSize if not set
+func WithBlockSizes(data, hash int64) Opt {
+	return func(o *opts) error {
+		for _, size := range []int64{data, hash} {
+			if size < 512 || size > 65536 || size&(size-1) != 0 {
+				return fmt.Errorf("invalid block size %d, must be a power of 2 from 512 to 65536", size)
+			}
+		}
+		o.dataBlockSize, o.hashBlockSize = data, hash
+		return nil
+	}
+}
+
+// WithSalt sets the salt, of up to 256 bytes, random bytes of the size of the hash if not set.
+// An empty salt is no salt, as "-" for veritysetup.
+func WithSalt(salt []byte) Opt {
+	return func(o *opts) error {
+		if len(salt) > maxSaltSize {
+			return fmt.Errorf("salt of %d bytes is longer than %d bytes", len(salt), maxSaltSize)
+		}
+		o.salt = append([]byte{}, salt...)
+		return nil
+	}
+}
+
+// WithUUID sets the UUID of the superblock, random if not set
+func WithUUID(id string) Opt {
+	return func(o *opts) error {
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid UUID %s: %w", id, err)
+		}
+		o.uuid = id
+		return nil
+	}
+}
+
+// WithHashOffset writes the superblock and hash tree at the offset in the hash device, e.g.
+// after the data on the same device, as --hash-offset of veritysetup. Without superblock, the
+// offset must be a multiple of the hash block size.
+func WithHashOffset(offset int64) Opt {
+	return func(o *opts) error {
+		if offset < 0 || offset%512 != 0 {
+			return fmt.Errorf("invalid hash offset %d, must be a multiple of 512", offset)
+		}
+		o.hashOffset = offset
+		return nil
+	}
+}
+
+// WithoutSuperblock writes the hash tree alone, as --no-superblock of veritysetup
+func WithoutSuperblock() Opt {
+	return func(o *opts) error {
+		o.noSuperblock = true
+		return nil
+	}
+}
+
+// Create computes the hash tree of size bytes of data, a whole number of data blocks, and writes
+// it to hash, with its superblock. The hash may be the same device as the data, with
+// WithHashOffset after the data.
+func Create(data io.ReaderAt, size int64, hash io.WriterAt, options ...Opt) (*Tree, error) {
+	t, err := newTree(size, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.write(data, hash); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// newTree returns the tree of size bytes of data with the options, before it is computed
+func newTree(size int64, options ...Opt) (*Tree, error) {
+	o := &opts{algorithm: DefaultAlgorithm, dataBlockSize: DefaultBlockSize, hashBlockSize: DefaultBlockSize}
+	for _, opt := range options {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if size <= 0 || size%o.dataBlockSize != 0 {
+		return nil, fmt.Errorf("data size %d is not a positive multiple of the data block size %d", size, o.dataBlockSize)
+	}
+	t := &Tree{
+		Algorithm:     o.algorithm,
+		DataBlockSize: o.dataBlockSize,
+		HashBlockSize: o.hashBlockSize,
+		DataBlocks:    size / o.dataBlockSize,
+		Salt:          o.salt,
+		UUID:          o.uuid,
+		HashOffset:    o.hashOffset,
+		Superblock:    !o.noSuperblock,
+	}
+	newHash := algorithms[t.Algorithm]
+	if t.Salt == nil {
+		t.Salt = make([]byte, newHash().Size())
+		if _, err := util.Rand.Read(t.Salt); err != nil {
+			return nil, fmt.Errorf("could not generate salt: %w", err)
+		}
+	}
+	if t.UUID == "" {
+		t.UUID = util.NewUUID().String()
+	}
+	if !t.Superblock && t.HashOffset%t.HashBlockSize != 0 {
+		return nil, fmt.Errorf("hash offset %d without superblock is not a multiple of the hash block size %d", t.HashOffset, t.HashBlockSize)
+	}
+	return t, nil
+}
+
+// write computes the tree of the data and writes it to hash, with its superblock
+func (t *Tree) write(data io.ReaderAt, hash io.WriterAt) error {
+	levels, err := t.compute(data)
+	if err != nil {
+		return err
+	}
+	if t.Superblock {
+		if _, err := hash.WriteAt(t.superblock(), t.HashOffset); err != nil {
+			return fmt.Errorf("error writing superblock: %w", err)
+		}
+	}
+	// the top level first
+	offset := t.TreeOffset()
+	for i := len(levels) - 1; i >= 0; i-- {
+		if _, err := hash.WriteAt(levels[i], offset); err != nil {
+			return fmt.Errorf("error writing level %d of the hash tree: %w", i, err)
+		}
+		offset += int64(len(levels[i]))
+	}
+	return nil
+}
+
+// Verify checks the data against the hash tree in hash and the root hash of the tree, and
+// returns an error that is ErrCorrupted with the first block that does not match
+func Verify(data, hash io.ReaderAt, t *Tree) error {
+	if _, ok := algorithms[t.Algorithm]; !ok {
+		return fmt.Errorf("unsupported hash algorithm %s", t.Algorithm)
+	}
+	expected := t.RootHash
+	computed := &Tree{}
+	*computed = *t
+	levels, err := computed.compute(data)
+	if err != nil {
+		return err
+	}
+	// the blocks of the lowest levels first, so that corrupted data is reported as such
+	offset := t.HashSize()
+	for i, level := range levels {
+		offset -= int64(len(level))
+		stored := make([]byte, len(level))
+		if _, err := hash.ReadAt(stored, offset); err != nil {
+			return fmt.Errorf("error reading level %d of the hash tree: %w", i, err)
+		}
+		for block := int64(0); block*t.HashBlockSize < int64(len(level)); block++ {
+			start, end := block*t.HashBlockSize, (block+1)*t.HashBlockSize
+			if bytes.Equal(stored[start:end], level[start:end]) {
+				continue
+			}
+			if i == 0 {
+				return fmt.Errorf("%w: data blocks %d to %d", ErrCorrupted, block*t.hashesPerBlock(), min((block+1)*t.hashesPerBlock(), t.DataBlocks)-1)
+			}
+			return fmt.Errorf("%w: block %d of level %d of the hash tree", ErrCorrupted, block, i)
+		}
+	}
+	if !bytes.Equal(computed.RootHash, expected) {
+		return fmt.Errorf("%w: root hash %x instead of %x", ErrCorrupted, computed.RootHash, expected)
+	}
+	return nil
+}
+
+// TreeOffset is the offset in bytes of the hash tree in the hash device, after the superblock
+func (t *Tree) TreeOffset() int64 {
+	if !t.Superblock {
+		return t.HashOffset
+	}
+	return (t.HashOffset + superblockSize + t.HashBlockSize - 1) / t.HashBlockSize * t.HashBlockSize
+}
+
+// HashSize is the size in bytes of the hash device needed for the tree, from the start of the
+// device to the end of the tree, so that a partition can be sized for it
+func (t *Tree) HashSize() int64 {
+	blocks := int64(0)
+	for _, n := range t.levelBlocks() {
+		blocks += n
+	}
+	return t.TreeOffset() + blocks*t.HashBlockSize
+}
+
+// Table returns the device mapper table of the tree with the data and hash devices, e.g. for
+// dmsetup create or the dm-mod.create parameter of the kernel
+func (t *Tree) Table(dataDevice, hashDevice string) string {
+	salt := "-"
+	if len(t.Salt) > 0 {
+		salt = hex.EncodeToString(t.Salt)
+	}
+	return fmt.Sprintf("0 %d verity 1 %s %s %d %d %d %d %s %x %s",
+		t.DataBlocks*t.DataBlockSize/512, dataDevice, hashDevice, t.DataBlockSize, t.HashBlockSize,
+		t.DataBlocks, t.TreeOffset()/t.HashBlockSize, t.Algorithm, t.RootHash, salt)
+}
+
+// hashesPerBlock is the number of hashes in a hash block, the largest power of 2 that fits
+func (t *Tree) hashesPerBlock() int64 {
+	n := t.HashBlockSize / int64(algorithms[t.Algorithm]().Size())
+	return 1 << (bits.Len64(uint64(n)) - 1)
+}
+
+// levelBlocks returns the number of blocks of each level of the tree, from the lowest, which
+// hashes the data, to the top, of a single block. A single block of data has no tree.
+func (t *Tree) levelBlocks() []int64 {
+	var levels []int64
+	for n := t.DataBlocks; n > 1; {
+		n = (n + t.hashesPerBlock() - 1) / t.hashesPerBlock()
+		levels = append(levels, n)
+	}
+	return levels
+}
+
+// compute computes the levels of the tree from the lowest, and sets the root hash
+func (t *Tree) compute(data io.ReaderAt) ([][]byte, error) {
+	h := algorithms[t.Algorithm]()
+	perBlock := t.hashesPerBlock()
+	stride := t.HashBlockSize / perBlock
+	digest := func(b []byte) []byte {
+		h.Reset()
+		h.Write(t.Salt)
+		h.Write(b)
+		return h.Sum(nil)
+	}
+
+	// the hashes of the data, read in chunks of many blocks
+	hashes := make([][]byte, 0, t.DataBlocks)
+	chunk := make([]byte, t.DataBlockSize*256)
+	for block := int64(0); block < t.DataBlocks; {
+		n := min(256, t.DataBlocks-block)
+		b := chunk[:n*t.DataBlockSize]
+		if _, err := data.ReadAt(b, block*t.DataBlockSize); err != nil {
+			return nil, fmt.Errorf("error reading data block %d: %w", block, err)
+		}
+		for ; len(b) > 0; b = b[t.DataBlockSize:] {
+			hashes = append(hashes, digest(b[:t.DataBlockSize]))
+		}
+		block += n
+	}
+
+	var levels [][]byte
+	for _, blocks := range t.levelBlocks() {
+		level := make([]byte, blocks*t.HashBlockSize)
+		for i, d := range hashes {
+			copy(level[int64(i)/perBlock*t.HashBlockSize+int64(i)%perBlock*stride:], d)
+		}
+		levels = append(levels, level)
+		hashes = hashes[:0]
+		for b := level; len(b) > 0; b = b[t.HashBlockSize:] {
+			hashes = append(hashes, digest(b[:t.HashBlockSize]))
+		}
+	}
+	t.RootHash = hashes[0]
+	return levels, nil
+}
@@ -0,0 +1,159 @@
+package verity_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/backend/mem"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/verity"
+)
+
+// buffer is a hash device growing as it is written
+type buffer struct {
+	b []byte
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.b) {
+		b.b = append(b.b, make([]byte, end-len(b.b))...)
+	}
+	return copy(b.b[off:], p), nil
+}
+
+func (b *buffer) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(b.b).ReadAt(p, off)
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("error generating data: %v", err)
+	}
+	return b
+}
+
+func TestCreateVerify(t *testing.T) {
+	data := randomData(t, 300*4096)
+	hash := &buffer{}
+	tree, err := verity.Create(bytes.NewReader(data), int64(len(data)), hash)
+	if err != nil {
+		t.Fatalf("error creating hash tree: %v", err)
+	}
+	// a superblock, then a top block hashing the 3 blocks hashing the data
+	if tree.DataBlocks != 300 || len(tree.Salt) != 32 || len(tree.RootHash) != 32 || tree.HashSize() != 5*4096 || len(hash.b) != 5*4096 {
+		t.Fatalf("unexpected tree %+v of %d bytes", tree, len(hash.b))
+	}
+	if err := verity.Verify(bytes.NewReader(data), hash, tree); err != nil {
+		t.Fatalf("error verifying: %v", err)
+	}
+
+	t.Run("corrupted data", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[200*4096+17] ^= 1
+		err := verity.Verify(bytes.NewReader(corrupted), hash, tree)
+		if !errors.Is(err, verity.ErrCorrupted) || !strings.Contains(err.Error(), "data blocks 128 to 255") {
+			t.Errorf("error %v instead of corrupted data blocks 128 to 255", err)
+		}
+	})
+	t.Run("corrupted tree", func(t *testing.T) {
+		corrupted := &buffer{b: bytes.Clone(hash.b)}
+		corrupted.b[4096+5] ^= 1
+		err := verity.Verify(bytes.NewReader(data), corrupted, tree)
+		if !errors.Is(err, verity.ErrCorrupted) || !strings.Contains(err.Error(), "level 1") {
+			t.Errorf("error %v instead of corrupted level 1", err)
+		}
+	})
+	t.Run("wrong root hash", func(t *testing.T) {
+		wrong := *tree
+		wrong.RootHash = make([]byte, 32)
+		if err := verity.Verify(bytes.NewReader(data), hash, &wrong); !errors.Is(err, verity.ErrCorrupted) {
+			t.Errorf("error %v instead of a mismatch of the root hash", err)
+		}
+	})
+}
+
+func TestSingleBlock(t *testing.T) {
+	data := randomData(t, 4096)
+	salt := []byte("salt")
+	hash := &buffer{}
+	tree, err := verity.Create(bytes.NewReader(data), int64(len(data)), hash, verity.WithSalt(salt), verity.WithoutSuperblock())
+	if err != nil {
+		t.Fatalf("error creating hash tree: %v", err)
+	}
+	// the root hash is that of the block itself, without a tree
+	expected := sha256.Sum256(append(bytes.Clone(salt), data...))
+	if !bytes.Equal(tree.RootHash, expected[:]) || len(hash.b) != 0 || tree.HashSize() != 0 {
+		t.Errorf("root hash %x instead of %x, with %d bytes of tree", tree.RootHash, expected, len(hash.b))
+	}
+}
+
+func TestSuperblock(t *testing.T) {
+	// the hash tree after the data on the same device
+	const size = 1000 * 1024
+	device := &buffer{b: randomData(t, size)}
+	tree, err := verity.Create(bytes.NewReader(device.b[:size]), size, device,
+		verity.WithAlgorithm("sha512"), verity.WithBlockSizes(1024, 4096), verity.WithHashOffset(size),
+		verity.WithUUID("5f2b3bd6-3c7f-4aa0-8f3e-2f7d8b1c9e44"), verity.WithSalt([]byte{1, 2, 3}))
+	if err != nil {
+		t.Fatalf("error creating hash tree: %v", err)
+	}
+	if tree.TreeOffset() != 251*4096 || int64(len(device.b)) != tree.HashSize() {
+		t.Errorf("tree at %d ending at %d instead of %d", tree.TreeOffset(), tree.HashSize(), len(device.b))
+	}
+	read, err := verity.ReadSuperblock(device, size)
+	if err != nil {
+		t.Fatalf("error reading superblock: %v", err)
+	}
+	read.RootHash = tree.RootHash
+	if fmt.Sprintf("%+v", read) != fmt.Sprintf("%+v", tree) {
+		t.Errorf("superblock %+v instead of %+v", read, tree)
+	}
+	if err := verity.Verify(bytes.NewReader(device.b[:size]), device, read); err != nil {
+		t.Errorf("error verifying: %v", err)
+	}
+	table := fmt.Sprintf("0 2000 verity 1 /dev/sda1 /dev/sda1 1024 4096 1000 251 sha512 %x 010203", tree.RootHash)
+	if got := tree.Table("/dev/sda1", "/dev/sda1"); got != table {
+		t.Errorf("table %q instead of %q", got, table)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	image := make([]byte, 10*1024*1024)
+	copy(image[2048*512:], randomData(t, 4*1024*1024))
+	d, err := diskfs.OpenBackend(mem.New(image, false), diskfs.WithOpenMode(diskfs.ReadWrite))
+	if err != nil {
+		t.Fatalf("error opening disk: %v", err)
+	}
+	table := &gpt.Table{
+		ProtectiveMBR: true,
+		Partitions: []*gpt.Partition{
+			{Start: 2048, End: 10239, Type: gpt.LinuxFilesystem, Name: "root"},
+			{Start: 10240, End: 10327, Type: gpt.LinuxFilesystem, Name: "root-verity"},
+		},
+	}
+	if err := d.Partition(table); err != nil {
+		t.Fatalf("error partitioning disk: %v", err)
+	}
+	tree, err := verity.CreatePartition(d, 1, 2)
+	if err != nil {
+		t.Fatalf("error creating hash tree: %v", err)
+	}
+	if _, err := verity.VerifyPartition(d, 1, 2, tree.RootHash); err != nil {
+		t.Errorf("error verifying: %v", err)
+	}
+	image[3000*512] ^= 1
+	if _, err := verity.VerifyPartition(d, 1, 2, tree.RootHash); !errors.Is(err, verity.ErrCorrupted) {
+		t.Errorf("error %v instead of corrupted data", err)
+	}
+	if _, err := verity.CreatePartition(d, 1, 2, verity.WithHashOffset(8192)); err == nil {
+		t.Error("no error creating a hash tree in a partition too small")
+	}
+}